@@ -139,6 +139,16 @@ func (w *AgreementWorker) NewEvent(incoming events.Message) {
 		msg, _ := incoming.(*events.EdgeRegisteredExchangeMessage)
 		w.Commands <- NewDeviceRegisteredCommand(msg)
 
+	case *events.NodeTokenMessage:
+		msg, _ := incoming.(*events.NodeTokenMessage)
+		w.EC = worker.NewExchangeContext(fmt.Sprintf("%v/%v", msg.Org(), msg.DeviceId()), msg.Token(), w.Config.Edge.ExchangeURL, w.Config.GetCSSURL(), w.Config.Collaborators.HTTPClientFactory)
+
+	case *events.NodePatternMessage:
+		msg, _ := incoming.(*events.NodePatternMessage)
+		if msg.Event().Id == events.NODE_PATTERN_UPDATED {
+			w.Commands <- NewNodePatternUpdatedCommand(msg)
+		}
+
 	case *events.PolicyCreatedMessage:
 		msg, _ := incoming.(*events.PolicyCreatedMessage)
 
@@ -149,6 +159,28 @@ func (w *AgreementWorker) NewEvent(incoming events.Message) {
 			glog.Errorf("AgreementWorker received Unsupported event: %v", incoming.Event().Id)
 		}
 
+	case *events.PoliciesCreatedMessage:
+		msg, _ := incoming.(*events.PoliciesCreatedMessage)
+
+		switch msg.Event().Id {
+		case events.NEW_POLICIES:
+			w.Commands <- NewAdvertisePoliciesCommand(msg.PolicyFiles())
+		default:
+			glog.Errorf("AgreementWorker received Unsupported event: %v", incoming.Event().Id)
+		}
+
+	case *events.PolicyChangedMessage:
+		msg, _ := incoming.(*events.PolicyChangedMessage)
+
+		// The policy of a service that was updated to another version. The agbot policies also change, they are not
+		// under the policy path of the node.
+		switch msg.Event().Id {
+		case events.CHANGED_POLICY:
+			if strings.HasPrefix(msg.PolicyFile(), w.Config.Edge.PolicyPath) {
+				w.Commands <- NewAdvertisePolicyCommand(msg.PolicyFile())
+			}
+		}
+
 	case *events.BlockchainClientInitializedMessage:
 		msg, _ := incoming.(*events.BlockchainClientInitializedMessage)
 		switch msg.Event().Id {
@@ -225,6 +257,10 @@ func (w *AgreementWorker) NewEvent(incoming events.Message) {
 			w.Commands <- NewNodePolicyChangeCommand()
 		}
 
+	case *events.NodeSyncMessage:
+		msg, _ := incoming.(*events.NodeSyncMessage)
+		w.Commands <- NewNodeSyncCommand(msg)
+
 	case *events.NodeHeartbeatStateChangeMessage:
 		msg, _ := incoming.(*events.NodeHeartbeatStateChangeMessage)
 		switch msg.Event().Id {
@@ -355,47 +391,19 @@ func (w *AgreementWorker) CommandHandler(command worker.Command) bool {
 		cmd, _ := command.(*DeviceRegisteredCommand)
 		w.handleDeviceRegistered(cmd)
 
+	case *NodePatternUpdatedCommand:
+		cmd, _ := command.(*NodePatternUpdatedCommand)
+		// The node was re-registered with the pattern locally, it is not a change of the pattern in the exchange.
+		glog.V(3).Infof(logString(fmt.Sprintf("node pattern updated from %v to %v", w.devicePattern, cmd.Msg.Pattern)))
+		w.devicePattern = cmd.Msg.Pattern
+
 	case *AdvertisePolicyCommand:
 		cmd, _ := command.(*AdvertisePolicyCommand)
+		w.advertisePolicyFiles([]string{cmd.PolicyFile})
 
-		a_tmp := strings.Split(cmd.PolicyFile, "/")
-		svcName := a_tmp[len(a_tmp)-1]
-
-		if newPolicy, err := policy.ReadPolicyFile(cmd.PolicyFile, w.Config.ArchSynonyms); err != nil {
-			eventlog.LogAgreementEvent2(w.db, persistence.SEVERITY_ERROR,
-				persistence.NewMessageMeta(EL_AG_UNABLE_READ_POL_FILE, cmd.PolicyFile, svcName, err.Error()),
-				persistence.EC_ERROR_POLICY_ADVERTISING,
-				"", persistence.WorkloadInfo{}, []persistence.ServiceSpec{}, "", "")
-
-			glog.Errorf(logString(fmt.Sprintf("unable to read policy file %v into memory, error: %v", cmd.PolicyFile, err)))
-		} else {
-			w.pm.UpdatePolicy(exchange.GetOrg(w.GetExchangeId()), newPolicy)
-
-			a_protocols := []string{}
-			for _, p := range newPolicy.AgreementProtocols {
-				a_protocols = append(a_protocols, p.Name)
-			}
-			protocols := strings.Join(a_protocols, ",")
-
-			eventlog.LogAgreementEvent2(w.db, persistence.SEVERITY_INFO,
-				persistence.NewMessageMeta(EL_AG_START_ADVERTISE_POL, newPolicy.APISpecs[0].Org, newPolicy.APISpecs[0].SpecRef),
-				persistence.EC_START_POLICY_ADVERTISING,
-				"", persistence.WorkloadInfo{}, producer.ConvertToServiceSpecs(newPolicy.APISpecs), "", protocols)
-			// Publish what we have for the world to see
-			if err := w.advertiseAllPolicies(); err != nil {
-				eventlog.LogAgreementEvent2(w.db, persistence.SEVERITY_ERROR,
-					persistence.NewMessageMeta(EL_AG_UNABLE_ADVERTISE_POL, newPolicy.APISpecs[0].Org, newPolicy.APISpecs[0].SpecRef, err.Error()),
-					persistence.EC_ERROR_POLICY_ADVERTISING,
-					"", persistence.WorkloadInfo{}, producer.ConvertToServiceSpecs(newPolicy.APISpecs), "", protocols)
-
-				glog.Warningf(logString(fmt.Sprintf("unable to advertise policies with exchange, error: %v", err)))
-			} else {
-				eventlog.LogAgreementEvent2(w.db, persistence.SEVERITY_INFO,
-					persistence.NewMessageMeta(EL_AG_COMPLETE_ADVERTISE_POL, newPolicy.APISpecs[0].Org, newPolicy.APISpecs[0].SpecRef),
-					persistence.EC_COMPLETE_POLICY_ADVERTISING,
-					"", persistence.WorkloadInfo{}, producer.ConvertToServiceSpecs(newPolicy.APISpecs), "", protocols)
-			}
-		}
+	case *AdvertisePoliciesCommand:
+		cmd, _ := command.(*AdvertisePoliciesCommand)
+		w.advertisePolicyFiles(cmd.PolicyFiles)
 
 	case *producer.ExchangeMessageCommand:
 		cmd, _ := command.(*producer.ExchangeMessageCommand)
@@ -436,7 +444,7 @@ func (w *AgreementWorker) CommandHandler(command worker.Command) bool {
 				// send a message to let the changes worker know that we have received a proposal message
 				w.Messages() <- events.NewProposalAcceptedMessage(events.PROPOSAL_ACCEPTED)
 			}
-		} else if pDevice != nil && pDevice.Config.State == persistence.CONFIGSTATE_CONFIGURING {
+		} else if pDevice != nil && (pDevice.Config.State == persistence.CONFIGSTATE_CONFIGURING || pDevice.Config.State == persistence.CONFIGSTATE_CONFIGURED_PENDING) {
 			w.AddDeferredCommand(cmd)
 			return true
 		} else {
@@ -498,6 +506,11 @@ func (w *AgreementWorker) CommandHandler(command worker.Command) bool {
 	case *NodePolicyChangeCommand:
 		w.checkNodePolicyChanges()
 
+	case *NodeSyncCommand:
+		cmd, _ := command.(*NodeSyncCommand)
+		cmd.Msg.Results <- w.checkNodeChanges()
+		cmd.Msg.Results <- w.checkNodePolicyChanges()
+
 	default:
 		// Unexpected commands are not handled.
 		return false
@@ -858,6 +871,69 @@ func (w *AgreementWorker) patchNodeKey() error {
 	}
 }
 
+// Read the given policy files into the policy manager and advertise the policies of the node with the exchange. The
+// policies are advertised once for all the files, so that creating many services together, e.g. by the autoconfig of
+// the node's pattern, makes one exchange update rather than one per service.
+func (w *AgreementWorker) advertisePolicyFiles(fileNames []string) {
+
+	type advertisedPolicy struct {
+		pol       *policy.Policy
+		protocols string
+	}
+
+	advertised := make([]advertisedPolicy, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		a_tmp := strings.Split(fileName, "/")
+		svcName := a_tmp[len(a_tmp)-1]
+
+		if newPolicy, err := policy.ReadPolicyFile(fileName, w.Config.ArchSynonyms); err != nil {
+			eventlog.LogAgreementEvent2(w.db, persistence.SEVERITY_ERROR,
+				persistence.NewMessageMeta(EL_AG_UNABLE_READ_POL_FILE, fileName, svcName, err.Error()),
+				persistence.EC_ERROR_POLICY_ADVERTISING,
+				"", persistence.WorkloadInfo{}, []persistence.ServiceSpec{}, "", "")
+
+			glog.Errorf(logString(fmt.Sprintf("unable to read policy file %v into memory, error: %v", fileName, err)))
+		} else {
+			w.pm.UpdatePolicy(exchange.GetOrg(w.GetExchangeId()), newPolicy)
+
+			a_protocols := []string{}
+			for _, p := range newPolicy.AgreementProtocols {
+				a_protocols = append(a_protocols, p.Name)
+			}
+			protocols := strings.Join(a_protocols, ",")
+
+			eventlog.LogAgreementEvent2(w.db, persistence.SEVERITY_INFO,
+				persistence.NewMessageMeta(EL_AG_START_ADVERTISE_POL, newPolicy.APISpecs[0].Org, newPolicy.APISpecs[0].SpecRef),
+				persistence.EC_START_POLICY_ADVERTISING,
+				"", persistence.WorkloadInfo{}, producer.ConvertToServiceSpecs(newPolicy.APISpecs), "", protocols)
+			advertised = append(advertised, advertisedPolicy{pol: newPolicy, protocols: protocols})
+		}
+	}
+
+	if len(advertised) == 0 {
+		return
+	}
+
+	// Publish what we have for the world to see
+	if err := w.advertiseAllPolicies(); err != nil {
+		for _, a := range advertised {
+			eventlog.LogAgreementEvent2(w.db, persistence.SEVERITY_ERROR,
+				persistence.NewMessageMeta(EL_AG_UNABLE_ADVERTISE_POL, a.pol.APISpecs[0].Org, a.pol.APISpecs[0].SpecRef, err.Error()),
+				persistence.EC_ERROR_POLICY_ADVERTISING,
+				"", persistence.WorkloadInfo{}, producer.ConvertToServiceSpecs(a.pol.APISpecs), "", a.protocols)
+		}
+
+		glog.Warningf(logString(fmt.Sprintf("unable to advertise policies with exchange, error: %v", err)))
+	} else {
+		for _, a := range advertised {
+			eventlog.LogAgreementEvent2(w.db, persistence.SEVERITY_INFO,
+				persistence.NewMessageMeta(EL_AG_COMPLETE_ADVERTISE_POL, a.pol.APISpecs[0].Org, a.pol.APISpecs[0].SpecRef),
+				persistence.EC_COMPLETE_POLICY_ADVERTISING,
+				"", persistence.WorkloadInfo{}, producer.ConvertToServiceSpecs(a.pol.APISpecs), "", a.protocols)
+		}
+	}
+}
+
 func (w *AgreementWorker) advertiseAllPolicies() error {
 
 	var pType, pValue, pCompare string
@@ -22,6 +22,21 @@ func NewDeviceRegisteredCommand(msg *events.EdgeRegisteredExchangeMessage) *Devi
 	}
 }
 
+// ==============================================================================================================
+type NodePatternUpdatedCommand struct {
+	Msg *events.NodePatternMessage
+}
+
+func (n NodePatternUpdatedCommand) ShortString() string {
+	return fmt.Sprintf("%v", n)
+}
+
+func NewNodePatternUpdatedCommand(msg *events.NodePatternMessage) *NodePatternUpdatedCommand {
+	return &NodePatternUpdatedCommand{
+		Msg: msg,
+	}
+}
+
 // ==============================================================================================================
 type AdvertisePolicyCommand struct {
 	PolicyFile string
@@ -37,6 +52,21 @@ func NewAdvertisePolicyCommand(fileName string) *AdvertisePolicyCommand {
 	}
 }
 
+// ==============================================================================================================
+type AdvertisePoliciesCommand struct {
+	PolicyFiles []string
+}
+
+func (a AdvertisePoliciesCommand) ShortString() string {
+	return fmt.Sprintf("%v", a)
+}
+
+func NewAdvertisePoliciesCommand(fileNames []string) *AdvertisePoliciesCommand {
+	return &AdvertisePoliciesCommand{
+		PolicyFiles: fileNames,
+	}
+}
+
 // ==============================================================================================================
 type EdgeConfigCompleteCommand struct {
 	Msg *events.EdgeConfigCompleteMessage
@@ -90,3 +120,16 @@ func (c NodePolicyChangeCommand) ShortString() string {
 func NewNodePolicyChangeCommand() *NodePolicyChangeCommand {
 	return &NodePolicyChangeCommand{}
 }
+
+// ==============================================================================================================
+type NodeSyncCommand struct {
+	Msg *events.NodeSyncMessage
+}
+
+func (c NodeSyncCommand) ShortString() string {
+	return fmt.Sprintf("NodeSyncCommand Msg: %v", c.Msg)
+}
+
+func NewNodeSyncCommand(msg *events.NodeSyncMessage) *NodeSyncCommand {
+	return &NodeSyncCommand{Msg: msg}
+}
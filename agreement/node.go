@@ -43,9 +43,10 @@ func (w *AgreementWorker) NodePolicyDeleted() {
 	w.pm.DeletePolicyByName(exchange.GetOrg(w.GetExchangeId()), policy.MakeExternalPolicyHeaderName(w.GetExchangeId()))
 }
 
-// Check node changes on the exchange and save it on local node
-func (w *AgreementWorker) checkNodeChanges() {
+// Check node changes on the exchange and save it on local node. Returns what was found, for a node sync.
+func (w *AgreementWorker) checkNodeChanges() events.SyncResult {
 	glog.V(3).Infof(logString(fmt.Sprintf("checking the exchange node changes.")))
+	result := events.SyncResult{Name: events.SYNC_NODE}
 
 	// get the node
 	pDevice, err := persistence.FindExchangeDevice(w.db)
@@ -54,15 +55,18 @@ func (w *AgreementWorker) checkNodeChanges() {
 		eventlog.LogDatabaseEvent(w.db, persistence.SEVERITY_ERROR,
 			persistence.NewMessageMeta(EL_AG_UNABLE_READ_NODE_FROM_DB, err.Error()),
 			persistence.EC_DATABASE_ERROR)
-		return
+		result.Error = fmt.Sprintf("Unable to read node object from the local database. %v", err)
+		return result
 	} else if pDevice == nil {
 		glog.Errorf(logString(fmt.Sprintf("No device is found from the local database.")))
-		return
+		result.Error = "the node is not registered"
+		return result
 	}
 
 	// save a local copy of the exchange node
 	exchNode, err := exchangesync.SyncNodeWithExchange(w.db, pDevice, exchange.GetHTTPDeviceHandler(w.limitedRetryEC))
 	if err != nil {
+		result.Error = fmt.Sprintf("Unable to sync the node with the exchange copy. Error: %v", err)
 		if !w.hznOffline {
 			glog.Errorf(logString(fmt.Sprintf("Unable to sync the node with the exchange copy. Error: %v", err)))
 			eventlog.LogNodeEvent(w.db, persistence.SEVERITY_ERROR,
@@ -72,7 +76,7 @@ func (w *AgreementWorker) checkNodeChanges() {
 				exchange.GetId(w.GetExchangeId()),
 				w.devicePattern, "")
 			w.isOffline()
-			return
+			return result
 		}
 	} else {
 		w.hznOffline = false
@@ -81,24 +85,41 @@ func (w *AgreementWorker) checkNodeChanges() {
 	glog.V(3).Infof(logString(fmt.Sprintf("Done checking exchange node changes.")))
 
 	// now check the user input changes.
-	w.checkNodeUserInputChanges(pDevice)
+	changes := []string{}
+	if changed := w.checkNodeUserInputChanges(pDevice); len(changed) != 0 {
+		services := make([]string, 0, len(changed))
+		for _, spec := range changed {
+			services = append(services, fmt.Sprintf("%v/%v", spec.Org, spec.Url))
+		}
+		changes = append(changes, fmt.Sprintf("user input changed for %v", strings.Join(services, ", ")))
+	}
 
 	// check the pattern changes
-	w.checkNodePatternChanges(exchNode)
+	if pattern := w.checkNodePatternChanges(exchNode); pattern != "" {
+		changes = append(changes, fmt.Sprintf("pattern changed to %v", pattern))
+	}
+
+	result.Changed = len(changes) != 0
 
-	// check the service configstate changes
-	w.checkServiceConfigStateChanges(exchNode)
+	// check the service configstate changes, the suspended services are handled on every check
+	if suspended := w.checkServiceConfigStateChanges(exchNode); len(suspended) != 0 {
+		changes = append(changes, fmt.Sprintf("suspended services %v", suspended))
+	}
 
-	return
+	if len(changes) != 0 {
+		result.Summary = strings.Join(changes, ", ")
+	} else {
+		result.Summary = "no changes"
+	}
+	return result
 }
 
-// Check the node user input changes on the exchange and sync up with
-// the local copy. THe exchange is the master
-func (w *AgreementWorker) checkNodePatternChanges(exchDevice *exchange.Device) {
+// Check the node pattern changes on the exchange. Returns the new pattern when the node is re-registered for it.
+func (w *AgreementWorker) checkNodePatternChanges(exchDevice *exchange.Device) string {
 	glog.V(5).Infof(logString(fmt.Sprintf("checking the node pattern changes.")))
 
 	if exchDevice == nil {
-		return
+		return ""
 	}
 
 	glog.V(5).Infof(logString(fmt.Sprintf("checking the node pattern devp=%v, exchp=%v", w.devicePattern, exchDevice.Pattern)))
@@ -110,11 +131,11 @@ func (w *AgreementWorker) checkNodePatternChanges(exchDevice *exchange.Device) {
 			eventlog.LogDatabaseEvent(w.db, persistence.SEVERITY_ERROR,
 				persistence.NewMessageMeta(EL_AG_UNABLE_READ_NODE_EXCH_PATTERN_FROM_DB, err.Error()),
 				persistence.EC_DATABASE_ERROR)
-			return
+			return ""
 		} else if saved_pattern != "" {
 			// will not handle it because the pattern may be changed by the shutdown process.
 			glog.Infof(logString(fmt.Sprintf("Node pattern changed to %v on the exchange, but will not handle it because there is already a saved exchange pattern %v in local database that needs to be handled.", exchDevice.Pattern, saved_pattern)))
-			return
+			return ""
 		}
 
 		// save the node change pattern into the local db
@@ -123,22 +144,25 @@ func (w *AgreementWorker) checkNodePatternChanges(exchDevice *exchange.Device) {
 			eventlog.LogDatabaseEvent(w.db, persistence.SEVERITY_ERROR,
 				persistence.NewMessageMeta(EL_AG_UNABLE_WRITE_NODE_EXCH_PATTERN_TO_DB, exchDevice.Pattern, err.Error()),
 				persistence.EC_DATABASE_ERROR)
-			return
+			return ""
 		} else {
 			glog.Infof(logString(fmt.Sprintf("Node pattern changed on the exchange from %v to %v. Will re-register the node.", w.devicePattern, exchDevice.Pattern)))
 			w.Messages() <- events.NewNodePatternMessage(events.NODE_PATTERN_CHANGE_SHUTDOWN, exchDevice.Pattern)
+			return exchDevice.Pattern
 		}
 	}
 
 	glog.V(5).Infof(logString(fmt.Sprintf("Done checking the node pattern changes.")))
+	return ""
 }
 
 // Check the node user input changes on the exchange and sync up with
-// the local copy. The exchange is the master
-func (w *AgreementWorker) checkNodeUserInputChanges(pDevice *persistence.ExchangeDevice) {
+// the local copy. The exchange is the master. Returns the services whose user input changed.
+func (w *AgreementWorker) checkNodeUserInputChanges(pDevice *persistence.ExchangeDevice) persistence.ServiceSpecs {
 	glog.V(5).Infof(logString(fmt.Sprintf("checking the node user input changes.")))
 
 	// exchange is the master
+	var changed persistence.ServiceSpecs
 	updated, changedSvcSpecs, err := exchangesync.SyncLocalUserInputWithExchange(w.db, pDevice, nil)
 	if err != nil {
 		glog.Errorf(logString(fmt.Sprintf("Unable to sync the local node user input with the exchange copy. Error: %v", err)))
@@ -153,6 +177,7 @@ func (w *AgreementWorker) checkNodeUserInputChanges(pDevice *persistence.Exchang
 		}
 	} else if updated {
 		w.hznOffline = false
+		changed = changedSvcSpecs
 		glog.V(3).Infof(logString(fmt.Sprintf("Node user input updated with the exchange copy. The changed user inputs are: %v", changedSvcSpecs)))
 		eventlog.LogNodeEvent(w.db, persistence.SEVERITY_INFO,
 			persistence.NewMessageMeta(EL_AG_NODE_UI_SYNCED_WITH_EXCH, changedSvcSpecs),
@@ -170,15 +195,17 @@ func (w *AgreementWorker) checkNodeUserInputChanges(pDevice *persistence.Exchang
 	}
 
 	glog.V(5).Infof(logString(fmt.Sprintf("Done checking the user input changes.")))
+	return changed
 }
 
 // get the service configuration state from the exchange, check if any of them are suspended.
 // if a service is suspended, cancel the agreements and remove the containers associated with it.
-func (w *AgreementWorker) checkServiceConfigStateChanges(exchDevice *exchange.Device) {
+// Returns the suspended services.
+func (w *AgreementWorker) checkServiceConfigStateChanges(exchDevice *exchange.Device) []events.ServiceConfigState {
 	glog.V(4).Infof(logString(fmt.Sprintf("Check the service configuration state")))
 
 	if exchDevice == nil {
-		return
+		return nil
 	}
 
 	// get the service configuration states from the node
@@ -218,12 +245,14 @@ func (w *AgreementWorker) checkServiceConfigStateChanges(exchDevice *exchange.De
 		// we only handle the suspended services for the configstate change now
 		w.Messages() <- events.NewServiceConfigStateChangeMessage(events.SERVICE_SUSPENDED, suspended_services)
 	}
+	return suspended_services
 }
 
 // Check the node policy changes on the exchange and sync up with
-// the local copy. The exchange is the master.
-func (w *AgreementWorker) checkNodePolicyChanges() {
+// the local copy. The exchange is the master. Returns what was found, for a node sync.
+func (w *AgreementWorker) checkNodePolicyChanges() events.SyncResult {
 	glog.V(3).Infof(logString(fmt.Sprintf("checking the node policy changes.")))
+	result := events.SyncResult{Name: events.SYNC_NODE_POLICY, Summary: "no changes"}
 
 	// get the node
 	pDevice, err := persistence.FindExchangeDevice(w.db)
@@ -232,16 +261,19 @@ func (w *AgreementWorker) checkNodePolicyChanges() {
 		eventlog.LogDatabaseEvent(w.db, persistence.SEVERITY_ERROR,
 			persistence.NewMessageMeta(EL_AG_UNABLE_READ_NODE_FROM_DB, err.Error()),
 			persistence.EC_DATABASE_ERROR)
-		return
+		result.Error = fmt.Sprintf("Unable to read node object from the local database. %v", err)
+		return result
 	} else if pDevice == nil {
 		glog.Errorf(logString(fmt.Sprintf("No device is found from the local database.")))
-		return
+		result.Error = "the node is not registered"
+		return result
 	}
 
 	// exchange is the master
 	updated, newNodePolicy, err := exchangesync.SyncNodePolicyWithExchange(w.db, pDevice, exchange.GetHTTPNodePolicyHandler(w.limitedRetryEC), exchange.GetHTTPPutNodePolicyHandler(w.limitedRetryEC))
 	if err != nil {
 		glog.Errorf(logString(fmt.Sprintf("Unable to sync the local node policy with the exchange copy. Error: %v", err)))
+		result.Error = fmt.Sprintf("Unable to sync the local node policy with the exchange copy. Error: %v", err)
 		if !w.hznOffline {
 			eventlog.LogNodeEvent(w.db, persistence.SEVERITY_ERROR,
 				persistence.NewMessageMeta(EL_AG_UNABLE_SYNC_NODE_POL_WITH_EXCH, err.Error()),
@@ -253,6 +285,8 @@ func (w *AgreementWorker) checkNodePolicyChanges() {
 		}
 	} else if updated {
 		w.hznOffline = false
+		result.Changed = true
+		result.Summary = "node policy updated from the exchange"
 		glog.V(3).Infof(logString(fmt.Sprintf("Node policy updated with the exchange copy: %v", newNodePolicy)))
 		eventlog.LogNodeEvent(w.db, persistence.SEVERITY_INFO,
 			persistence.NewMessageMeta(EL_AG_NODE_POL_SYNCED_WITH_EXCH, newNodePolicy),
@@ -268,7 +302,7 @@ func (w *AgreementWorker) checkNodePolicyChanges() {
 		w.hznOffline = false
 	}
 	glog.V(3).Infof(logString(fmt.Sprint("Done checking the node policy changes.")))
-	return
+	return result
 }
 
 func (w *AgreementWorker) isOffline() {
@@ -847,11 +847,14 @@ func (a *API) GetHorizonAgbotConfig() (*HorizonAgbotConfig, error) {
 	cfg, err := config.Read(a.configFile)
 	if err != nil {
 		glog.Error(APIlogString(fmt.Sprintf("error finding File System Config File %v, error: %v", a.configFile, err)))
+		return nil, err
 	}
+
+	// The secrets that were read from a reference are shown as the reference.
 	return &HorizonAgbotConfig{
-		InMemoryConfig:   a.Config.AgreementBot,
-		FileSystemConfig: cfg.AgreementBot,
-	}, err
+		InMemoryConfig:   a.Config.WithSecretReferences().AgreementBot,
+		FileSystemConfig: cfg.WithSecretReferences().AgreementBot,
+	}, nil
 }
 
 func getAgbotInfo(config *config.HorizonConfig) {
@@ -3,8 +3,10 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/golang/glog"
@@ -14,19 +16,32 @@ import (
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/policy"
+	"github.com/open-horizon/anax/tpm"
 	"github.com/open-horizon/anax/worker"
 )
 
 type API struct {
-	worker.Manager // embedded field
-	name           string
-	db             *bolt.DB
-	pm             *policy.PolicyManager
-	em             *events.EventStateManager
-	bcState        map[string]map[string]apicommon.BlockchainState
-	bcStateLock    sync.Mutex
-	shutdownError  string
-	EC             *worker.BaseExchangeContext
+	worker.Manager  // embedded field
+	name            string
+	db              *bolt.DB
+	pm              *policy.PolicyManager
+	em              *events.EventStateManager
+	bcState         map[string]map[string]apicommon.BlockchainState
+	bcStateLock     sync.Mutex
+	shutdownError   string
+	EC              *worker.BaseExchangeContext
+	listeners       []apicommon.APIListener // the active listeners of the API
+	nodeSyncLock    sync.Mutex
+	lastNodeSync    time.Time          // when the last node sync was requested
+	pendingTimer    *time.Timer        // configures a configured_pending node at its effective time
+	limiter         configLimiter      // limits the rate of the changes of the node configuration
+	operations      operationTracker   // the requests that change the node and are running, for the shutdown
+	progress        *progressPublisher // publishes the progress of the autoconfig on the message bus
+	shutdownLock    sync.Mutex
+	shutdownResult  *ShutdownResult // set once the API is shut down
+	configstateHeld chan struct{}   // closed once the shutdown holds configstateLock
+	servers         []*http.Server  // the servers of the listeners
+	exitProcess     func() error    // makes anax exit after POST /node/shutdown
 }
 
 type BlockchainState struct {
@@ -37,7 +52,9 @@ type BlockchainState struct {
 }
 
 func NewAPIListener(name string, cfg *config.HorizonConfig, db *bolt.DB, pm *policy.PolicyManager) *API {
-	messages := make(chan events.Message)
+	// The messages are queued so that a request does not wait for the message bus to take them, a shutdown waits for
+	// them to be sent.
+	messages := make(chan events.Message, 100)
 
 	listener := &API{
 		Manager: worker.Manager{
@@ -52,6 +69,7 @@ func NewAPIListener(name string, cfg *config.HorizonConfig, db *bolt.DB, pm *pol
 		bcState:     make(map[string]map[string]apicommon.BlockchainState),
 		bcStateLock: sync.Mutex{},
 		EC:          nil,
+		exitProcess: signalExit,
 	}
 
 	// setup the exchange context if the device is set
@@ -60,9 +78,27 @@ func NewAPIListener(name string, cfg *config.HorizonConfig, db *bolt.DB, pm *pol
 		glog.Errorf(apiLogString(fmt.Sprintf("Unable to read node object, error %v", err)))
 	} else if pDevice != nil {
 		listener.EC = worker.NewExchangeContext(fmt.Sprintf("%v/%v", pDevice.Org, pDevice.Id), pDevice.Token, cfg.Edge.ExchangeURL, cfg.GetCSSURL(), cfg.Collaborators.HTTPClientFactory)
+
+		// a node that was waiting for its effective time when the agent stopped is configured when it is reached
+		listener.schedulePendingConfigstate(ConvertFromPersistentHorizonDevice(pDevice).Config)
 	}
 
+	// publish the progress of the autoconfig of the node on the message bus
+	listener.progress = newProgressPublisher(messages, 100)
+	SetConfigstateProgressPublisher(listener.progress.publish)
+
+	// the agreements of a node that is unconfigured are cancelled before its state changes
+	SetConfigstateTeardownPublisher(func(msg events.Message) { messages <- msg })
+
+	// a SIGTERM shuts the API down before anax exits
 	listener.listen(cfg)
+	setShutdownAPI(listener)
+
+	// register and configure the node from the provisioning file on its first boot, the secrets cannot be saved in
+	// recovery mode
+	if tpm.GetStatus().State != tpm.STATE_RECOVERY {
+		listener.provisionOnFirstBoot()
+	}
 	return listener
 }
 
@@ -76,25 +112,68 @@ func (a *API) router(includeStaticRedirects bool) *mux.Router {
 	// For working with existing or archived agreements
 	router.HandleFunc("/agreement", a.agreement).Methods("GET", "OPTIONS")
 	router.HandleFunc("/agreement/{id}", a.agreement).Methods("GET", "DELETE", "OPTIONS")
+	router.HandleFunc("/agreement/{id}/object", a.agreementobject).Methods("GET", "OPTIONS")
+	router.HandleFunc("/agreement/{id}/object/{name}", a.agreementobject).Methods("GET", "OPTIONS")
 
 	// For obtaining microservice info or configuring a microservice (sensor) userInput variables
 	router.HandleFunc("/service", a.service).Methods("GET", "OPTIONS")
-	router.HandleFunc("/service/config", a.serviceconfig).Methods("GET", "POST", "OPTIONS")
+	router.HandleFunc("/service/config", a.limitConfigChanges(a.serviceconfig, nil)).Methods("GET", "POST", "OPTIONS")
 	router.HandleFunc("/service/configstate", a.service_configstate).Methods("GET", "POST", "OPTIONS")
 	router.HandleFunc("/service/policy", a.servicepolicy).Methods("GET", "OPTIONS")
+	router.HandleFunc("/service/logs", a.servicelogs).Methods("GET", "OPTIONS")
+	router.HandleFunc("/service/health", a.servicehealth).Methods("GET", "OPTIONS")
+	router.HandleFunc("/service/instance", a.serviceinstance).Methods("GET", "OPTIONS")
+	router.HandleFunc("/service/instance/{key}", a.serviceinstance).Methods("GET", "OPTIONS")
 
 	// Connectivity and blockchain status info
 	router.HandleFunc("/status", a.status).Methods("GET", "OPTIONS")
 	router.HandleFunc("/status/workers", a.workerstatus).Methods("GET", "OPTIONS")
 
+	// The metrics of the agent, in the Prometheus text format
+	if a.Config.Edge.EnableMetrics {
+		router.HandleFunc("/metrics", a.agentmetrics).Methods("GET", "OPTIONS")
+	}
+
+	// List the networks and volumes left behind by agreements and services that no longer exist
+	router.HandleFunc("/cleanup/resources", a.cleanupresources).Methods("GET", "OPTIONS")
+
+	// List or remove the service images that have been superseded by newer versions
+	router.HandleFunc("/cleanup/images", a.cleanupimages).Methods("GET", "POST", "OPTIONS")
+
+	// Get the config that anax is running with, reload the settings of the config file that can be changed while
+	// anax is running, and get or change the features enabled on this node
+	router.HandleFunc("/config", a.config).Methods("GET", "OPTIONS")
+	router.HandleFunc("/config/reload", a.configreload).Methods("POST", "OPTIONS")
+	router.HandleFunc("/config/features", a.configfeatures).Methods("GET", "PATCH", "OPTIONS")
+	router.HandleFunc("/config/download", a.configdownload).Methods("GET", "PATCH", "OPTIONS")
+
 	// Used by the Registration UI to obtain a random token string
 	router.HandleFunc("/token/random", tokenRandom).Methods("GET", "OPTIONS")
 
 	// Used to configure a node to participate in the Horizon platform
 	router.HandleFunc("/node", a.node).Methods("GET", "HEAD", "POST", "PATCH", "DELETE", "OPTIONS")
-	router.HandleFunc("/node/configstate", a.nodeconfigstate).Methods("GET", "HEAD", "PUT", "OPTIONS")
+	router.HandleFunc("/node/configstate", a.limitConfigChanges(a.nodeconfigstate, a.configstateNoOp)).Methods("GET", "HEAD", "PUT", "OPTIONS")
+	router.HandleFunc("/node/configstate/progress", a.nodeconfigstateprogress).Methods("GET", "OPTIONS")
+	router.HandleFunc("/node/configstate/resolution", a.nodeconfigstateresolution).Methods("GET", "OPTIONS")
+	router.HandleFunc("/node/connectivity", a.nodeconnectivity).Methods("GET", "OPTIONS")
+	router.HandleFunc("/node/pattern/services", a.nodepatternservices).Methods("GET", "OPTIONS")
 	router.HandleFunc("/node/policy", a.nodepolicy).Methods("GET", "HEAD", "PUT", "POST", "PATCH", "DELETE", "OPTIONS")
-	router.HandleFunc("/node/userinput", a.nodeuserinput).Methods("GET", "HEAD", "PUT", "POST", "PATCH", "DELETE", "OPTIONS")
+	router.HandleFunc("/node/hostaccess", a.nodehostaccess).Methods("GET", "PUT", "DELETE", "OPTIONS")
+	router.HandleFunc("/node/portpolicy", a.nodeportpolicy).Methods("GET", "PUT", "DELETE", "OPTIONS")
+	router.HandleFunc("/node/canary", a.nodecanary).Methods("GET", "DELETE", "OPTIONS")
+	router.HandleFunc("/node/reconcile", a.nodereconcile).Methods("GET", "OPTIONS")
+	router.HandleFunc("/node/audit", a.nodeaudit).Methods("GET", "OPTIONS")
+	router.HandleFunc("/node/db", a.nodedb).Methods("GET", "OPTIONS")
+	router.HandleFunc("/node/db/compaction", a.nodedbcompaction).Methods("POST", "OPTIONS")
+	router.HandleFunc("/node/export", a.nodeexport).Methods("GET", "OPTIONS")
+	router.HandleFunc("/node/import", a.limitConfigChanges(a.nodeimport, nil)).Methods("POST", "OPTIONS")
+	router.HandleFunc("/node/maintenance", a.nodemaintenance).Methods("GET", "PUT", "DELETE", "OPTIONS")
+	router.HandleFunc("/node/maintenance/override", a.nodemaintenanceoverride).Methods("POST", "DELETE", "OPTIONS")
+	router.HandleFunc("/node/sync", a.nodesync).Methods("POST", "OPTIONS")
+	router.HandleFunc("/node/shutdown", a.nodeshutdown).Methods("POST", "OPTIONS")
+	router.HandleFunc("/node/tpm", a.nodetpm).Methods("GET", "OPTIONS")
+	router.HandleFunc("/node/tpm/quote", a.nodetpmquote).Methods("GET", "OPTIONS")
+	router.HandleFunc("/node/userinput", a.limitConfigChanges(a.nodeuserinput, nil)).Methods("GET", "HEAD", "PUT", "POST", "PATCH", "DELETE", "OPTIONS")
 
 	// Used to get the event logs on this node.
 	// get the eventlogs for current registration.
@@ -128,19 +207,43 @@ func (a *API) listen(cfg *config.HorizonConfig) {
 			w.Header().Add("Cache-Control", "no-cache, no-store, must-revalidate")
 			w.Header().Add("Pragma", "no-cache, no-store")
 			w.Header().Add("Access-Control-Allow-Origin", "*")
-			w.Header().Add("Access-Control-Allow-Headers", "X-Requested-With, content-type, Authorization")
+			w.Header().Add("Access-Control-Allow-Headers", "X-Requested-With, X-Request-Id, content-type, Authorization")
+			w.Header().Add("Access-Control-Expose-Headers", REQUEST_ID_HEADER)
 			w.Header().Add("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, PATCH, OPTIONS")
 			h.ServeHTTP(w, r)
 		})
 	}
 
-	// This routine does not need to be a subworker because there is no way to terminate it. It will terminate when
-	// the main anax process goes away.
-	go func() {
-		if err := http.ListenAndServe(cfg.Edge.APIListen, nocache(a.router(true))); err != nil {
-			glog.Fatalf(apiLogString(fmt.Sprintf("Failed to start listener on %v, error %v", cfg.Edge.APIListen, err)))
+	// All the listeners share the same routes. Anax does not start when one of them cannot be bound.
+	router := a.router(true)
+	handler := a.audit(nocache(a.timezone(a.trackOperations(router))))
+	if cfg.Edge.EnableMetrics {
+		handler = recordRequestMetrics(router, handler)
+	}
+	handler = NegotiateErrorFormat(a.requestID(handler))
+	for _, lc := range apiListeners(cfg) {
+		l, err := bindListener(cfg, lc)
+		if err != nil {
+			glog.Fatalf(apiLogString(fmt.Sprintf("Failed to start listener on %v, error %v", lc.Address, err)))
 		}
-	}()
+		a.listeners = append(a.listeners, listenerStatus(lc))
+		glog.Info(apiLogString(fmt.Sprintf("Listening on %v", lc.String())))
+
+		h := handler
+		if lc.RequiresClientCert() {
+			h = requireClientCert(handler)
+		}
+
+		// This routine does not need to be a subworker, it terminates when the API is shut down, see Shutdown, or when
+		// the main anax process goes away.
+		server := &http.Server{Addr: lc.Address, Handler: h}
+		a.servers = append(a.servers, server)
+		go func(l net.Listener, server *http.Server) {
+			if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+				glog.Fatalf(apiLogString(fmt.Sprintf("Listener on %v failed, error %v", server.Addr, err)))
+			}
+		}(l, server)
+	}
 
 }
 
@@ -207,12 +310,17 @@ func (a *API) GetExchangeId() string {
 	}
 }
 
+// The token is read from the database rather than from the exchange context, so that the exchange calls of the
+// configstate and service handlers use the token that PATCH /node rotated right away.
 func (a *API) GetExchangeToken() string {
-	if a.EC != nil {
-		return a.EC.Token
-	} else {
+	if a.EC == nil {
 		return ""
+	} else if pDevice, err := persistence.FindExchangeDevice(a.db); err != nil {
+		glog.Errorf(apiLogString(fmt.Sprintf("Unable to read node object, using the token of the exchange context, error %v", err)))
+	} else if pDevice != nil && pDevice.Token != "" {
+		return pDevice.Token
 	}
+	return a.EC.Token
 }
 
 func (a *API) GetExchangeURL() string {
@@ -244,6 +352,9 @@ func serializeResponse(w http.ResponseWriter, payload interface{}) ([]byte, bool
 	glog.V(6).Infof(apiLogString(fmt.Sprintf("response payload before serialization (%T): %v", payload, payload)))
 
 	serial, err := json.Marshal(payload)
+	if err == nil {
+		serial, err = addTimestampFields(serial, responseLocation(w))
+	}
 	if err != nil {
 		glog.Error(apiLogString(err))
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
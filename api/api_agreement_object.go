@@ -0,0 +1,87 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	"github.com/open-horizon/anax/objectsync"
+	"github.com/open-horizon/anax/resource"
+)
+
+// The objects that the agent downloaded for the services of an agreement, and their content.
+func (a *API) agreementobject(w http.ResponseWriter, r *http.Request) {
+
+	resource := "agreement object"
+	errorhandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		pathVars := mux.Vars(r)
+		id := pathVars["id"]
+		name := pathVars["name"]
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v %v/%v", r.Method, resource, id, name)))
+
+		if err := a.authorizeAgreementObject(r, id); err != nil {
+			errorhandler(err)
+			return
+		}
+
+		cacheDir := a.Config.Edge.ObjectSync.GetCacheDir()
+
+		if name == "" {
+			if objects, err := objectsync.ListObjects(cacheDir, id); err != nil {
+				errorhandler(NewSystemError(fmt.Sprintf("Error getting the objects of agreement %v for output, error %v", id, err)))
+			} else if objects == nil {
+				errorhandler(NewNotFoundError(fmt.Sprintf("agreement %v has no objects", id), "id"))
+			} else {
+				writeResponse(w, objects, http.StatusOK)
+			}
+			return
+		}
+
+		obj, file, err := objectsync.FindObject(cacheDir, id, name)
+		if err != nil {
+			errorhandler(NewSystemError(fmt.Sprintf("Error getting object %v of agreement %v, error %v", name, id, err)))
+			return
+		} else if obj == nil {
+			errorhandler(NewNotFoundError(fmt.Sprintf("agreement %v has no object %v", id, name), "name"))
+			return
+		}
+
+		// The file is replaced, not changed, when a new version arrives, so the open file is the version that is described.
+		content, err := os.Open(file)
+		if err != nil {
+			errorhandler(NewSystemError(fmt.Sprintf("Error opening object %v of agreement %v, error %v", name, id, err)))
+			return
+		}
+		defer content.Close()
+
+		w.Header().Set("ETag", fmt.Sprintf("\"%v\"", obj.Digest))
+		w.Header().Set("X-Horizon-Object-Version", obj.Version)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, obj.Name, time.Unix(obj.Updated, 0), content)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Only the services of the agreement can read its objects, with the credentials that are mounted in their containers.
+func (a *API) authorizeAgreementObject(r *http.Request, id string) error {
+	user, pw, ok := r.BasicAuth()
+	if !ok {
+		return NewUnauthorizedError(fmt.Sprintf("the credentials of agreement %v are required to read its objects", id))
+	} else if valid, err := resource.NewAuthenticationManager(a.Config.GetFileSyncServiceAuthPath()).AuthenticateKey(id, user, pw); err != nil {
+		return NewSystemError(fmt.Sprintf("Error checking the credentials of agreement %v, error %v", id, err))
+	} else if !valid {
+		return NewUnauthorizedError(fmt.Sprintf("the credentials are not the ones of agreement %v", id))
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
 	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/imagefetch"
 	"github.com/open-horizon/anax/persistence"
 )
 
@@ -35,6 +36,25 @@ func (a *API) attribute(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// A change with an If-Match header is checked against the ETag of the attribute, or of all the attributes for a new
+	// one, that the client read. It is checked under the lock of the config changes, no other conditional change or
+	// config state change can update the attributes between the check and the change.
+	if ifMatch := r.Header.Get(IF_MATCH_HEADER); ifMatch != "" && r.Method != "GET" && r.Method != "HEAD" && r.Method != "OPTIONS" {
+		lockConfigstate()
+		defer unlockConfigstate()
+		resource := "The attributes of the node"
+		if decodedID != "" {
+			resource = fmt.Sprintf("Attribute %v", decodedID)
+		}
+		if etag, err := FindAttributesETag(a.db, decodedID); err != nil {
+			errorhandler(err)
+			return
+		} else if err := checkIfMatch(ifMatch, etag, resource); err != nil {
+			errorhandler(err)
+			return
+		}
+	}
+
 	// shared logic between payload-handling update functions
 	handlePayload := func(permitPartial bool, doModifications func(permitPartial bool, attr persistence.Attribute, msgQueue chan events.Message), msgQueue chan events.Message) {
 		defer r.Body.Close()
@@ -48,7 +68,7 @@ func (a *API) attribute(w http.ResponseWriter, r *http.Request) {
 			if len(attrs) != 1 {
 				// only one attr may be specified to add at a time
 				w.WriteHeader(http.StatusBadRequest)
-			} else {
+			} else if !a.verifyRegistryAuthAttribute(errorhandler, attrs[0]) {
 				doModifications(permitPartial, attrs[0], msgQueue)
 			}
 		}
@@ -66,8 +86,11 @@ func (a *API) attribute(w http.ResponseWriter, r *http.Request) {
 					w.WriteHeader(http.StatusInternalServerError)
 				}
 			} else if added != nil {
+				setETag(w, attributeETag(*added))
 				writeResponse(w, toOutModel(*added), http.StatusOK)
-				msgQueue <- events.NewUpdatePolicyMessage(events.UPDATE_POLICY)
+				if !isRegistryAuthAttribute(*added) {
+					msgQueue <- events.NewUpdatePolicyMessage(events.UPDATE_POLICY)
+				}
 			} else {
 				glog.Error(apiLogString(fmt.Sprintf("Attribute was not successfully persisted but no error was returned from persistence module")))
 				w.WriteHeader(http.StatusInternalServerError)
@@ -88,6 +111,7 @@ func (a *API) attribute(w http.ResponseWriter, r *http.Request) {
 		out := wrapAttributesForOutput([]persistence.Attribute{*returned}, decodedID)
 
 		if serial, errWritten := serializeResponse(w, out); !errWritten {
+			setETag(w, attributeETag(*returned))
 			w.Header().Add("Content-Length", strconv.Itoa(len(serial)))
 			w.WriteHeader(http.StatusOK)
 		}
@@ -98,7 +122,10 @@ func (a *API) attribute(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			glog.Error(apiLogString(fmt.Sprintf("Error reading persisted attributes %v", err)))
 			w.WriteHeader(http.StatusInternalServerError)
+		} else if etag, err := FindAttributesETag(a.db, decodedID); err != nil {
+			errorhandler(err)
 		} else {
+			setETag(w, etag)
 			writeResponse(w, out, http.StatusOK)
 		}
 
@@ -121,8 +148,11 @@ func (a *API) attribute(w http.ResponseWriter, r *http.Request) {
 						w.WriteHeader(http.StatusInternalServerError)
 					}
 				} else if added != nil {
+					setETag(w, attributeETag(*added))
 					writeResponse(w, toOutModel(*added), http.StatusCreated)
-					msgQueue <- events.NewUpdatePolicyMessage(events.UPDATE_POLICY)
+					if !isRegistryAuthAttribute(*added) {
+						msgQueue <- events.NewUpdatePolicyMessage(events.UPDATE_POLICY)
+					}
 				} else {
 					glog.Error(apiLogString(fmt.Sprintf("Attribute was not successfully persisted but no error was returned from persistence module")))
 					w.WriteHeader(http.StatusInternalServerError)
@@ -167,3 +197,34 @@ func (a *API) attribute(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
+
+// Registry credentials are only used to pull images, changing them does not change the node's policies. The new
+// credentials are used by the next image pull, running containers are not affected.
+func isRegistryAuthAttribute(attr persistence.Attribute) bool {
+	switch attr.(type) {
+	case persistence.DockerRegistryAuthAttributes, *persistence.DockerRegistryAuthAttributes:
+		return true
+	default:
+		return false
+	}
+}
+
+// Check new registry credentials against the registries before they are saved. Returns true if the credentials
+// are not valid and the error has been written.
+func (a *API) verifyRegistryAuthAttribute(errorhandler ErrorHandler, attr persistence.Attribute) bool {
+	var auths []persistence.Auth
+	switch ra := attr.(type) {
+	case persistence.DockerRegistryAuthAttributes:
+		auths = ra.Auths
+	case *persistence.DockerRegistryAuthAttributes:
+		auths = ra.Auths
+	default:
+		return false
+	}
+
+	if err := imagefetch.VerifyRegistryAuths(a.Config, auths); err != nil {
+		glog.Errorf(apiLogString(fmt.Sprintf("Registry credential check failed: %v", err)))
+		return errorhandler(NewAPIUserInputError(fmt.Sprintf("registry credential check failed: %v", err), "mappings.auths"))
+	}
+	return false
+}
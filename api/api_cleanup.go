@@ -0,0 +1,80 @@
+package api
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/container"
+	"github.com/open-horizon/anax/persistence"
+	"net/http"
+)
+
+// Lists the networks and volumes owned by anax that would be removed by the orphaned resource cleanup. Nothing is
+// removed. Volumes are only listed when the node is not registered, that is the only time they are cleaned up.
+func (a *API) cleanupresources(w http.ResponseWriter, r *http.Request) {
+
+	resource := "cleanup/resources"
+	errorhandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		if a.Config.Edge.DockerEndpoint == "" {
+			errorhandler(NewBadRequestError("the container runtime is not configured on this node"))
+			return
+		}
+
+		pDevice, err := persistence.FindExchangeDevice(a.db)
+		if err != nil {
+			errorhandler(NewSystemError(fmt.Sprintf("Unable to read node object, error %v", err)))
+			return
+		}
+		includeVolumes := pDevice == nil || pDevice.Token == ""
+
+		orphans, err := container.RemoveOrphanedResources(a.db, a.Config, includeVolumes, true)
+		if err != nil {
+			errorhandler(NewSystemError(fmt.Sprintf("Error finding orphaned resources, error %v", err)))
+			return
+		}
+
+		writeResponse(w, orphans, http.StatusOK)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Lists (GET) or removes (POST) the images pulled by anax that have been superseded by newer versions and are no longer
+// used, keeping the configured number of previous versions of each image.
+func (a *API) cleanupimages(w http.ResponseWriter, r *http.Request) {
+
+	resource := "cleanup/images"
+	errorhandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET", "POST":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		if a.Config.Edge.DockerEndpoint == "" {
+			errorhandler(NewBadRequestError("the container runtime is not configured on this node"))
+			return
+		}
+
+		result, err := container.PruneImages(a.db, a.Config, r.Method == "GET")
+		if err != nil {
+			errorhandler(NewSystemError(fmt.Sprintf("Error pruning superseded images, error %v", err)))
+			return
+		}
+
+		writeResponse(w, result, http.StatusOK)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, POST, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
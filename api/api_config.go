@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/download"
+	"io/ioutil"
+	"net/http"
+)
+
+// Returns the config that anax is running with, with the secrets redacted, where the value of each field came from and
+// a digest that is equal on the nodes that run the same config.
+func (a *API) config(w http.ResponseWriter, r *http.Request) {
+
+	resource := "config"
+	errorhandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		effective, err := a.Config.Effective()
+		if err != nil {
+			errorhandler(NewSystemError(fmt.Sprintf("Unable to get the effective config, error %v", err)))
+			return
+		}
+
+		writeResponse(w, effective, http.StatusOK)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Re-reads the anax config file and applies the changed settings that are safe to change while anax is running. The
+// response lists the changed settings that were applied and the ones that take effect on restart. An invalid config
+// file is rejected and the current config stays in effect.
+func (a *API) configreload(w http.ResponseWriter, r *http.Request) {
+
+	resource := "config/reload"
+	errorhandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "POST":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		result, err := a.Config.Reload()
+		if err != nil {
+			errorhandler(NewBadRequestError(fmt.Sprintf("Unable to reload config, error %v", err)))
+			return
+		}
+
+		writeResponse(w, result, http.StatusOK)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "POST, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Returns the known features and whether they are enabled on this node, and turns the dynamic features on or off. The
+// PATCH body maps the feature names to true or false.
+func (a *API) configfeatures(w http.ResponseWriter, r *http.Request) {
+
+	resource := "config/features"
+	errorhandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		writeResponse(w, a.Config.FeatureStates(), http.StatusOK)
+
+	case "PATCH":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		var changes map[string]bool
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &changes); err != nil {
+			errorhandler(NewAPIUserInputError(fmt.Sprintf("Input body couldn't be deserialized to %v object: %v, error: %v", resource, string(body), err), "features"))
+			return
+		}
+
+		if err := a.Config.SetFeatures(changes); err != nil {
+			errorhandler(NewAPIUserInputError(err.Error(), "features"))
+			return
+		}
+
+		writeResponse(w, a.Config.FeatureStates(), http.StatusOK)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, PATCH, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// The download rate limits of the config, in kilobytes per second. A limit that is not in the body of a PATCH is not
+// changed.
+type DownloadLimits struct {
+	RateLimitKBps       *uint64 `json:"rate_limit_kbps"`
+	WindowRateLimitKBps *uint64 `json:"window_rate_limit_kbps"`
+}
+
+// The download rate limits, the limit that applies now and the downloads that are running.
+type DownloadOutput struct {
+	DownloadLimits
+	*download.Status
+}
+
+// Returns the download rate limits and the downloads that are running, and changes the limits while anax is running.
+// The changes last until anax is restarted or its config is reloaded.
+func (a *API) configdownload(w http.ResponseWriter, r *http.Request) {
+
+	resource := "config/download"
+	errorhandler := GetHTTPErrorHandler(w)
+
+	output := func() *DownloadOutput {
+		live := a.Config.LiveEdge()
+		rate, windowRate := live.Download.RateLimitKBps, live.Download.WindowRateLimitKBps
+		return &DownloadOutput{DownloadLimits: DownloadLimits{RateLimitKBps: &rate, WindowRateLimitKBps: &windowRate}, Status: download.GetStatus()}
+	}
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		writeResponse(w, output(), http.StatusOK)
+
+	case "PATCH":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		var limits DownloadLimits
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &limits); err != nil {
+			errorhandler(NewAPIUserInputError(fmt.Sprintf("Input body couldn't be deserialized to %v object: %v, error: %v", resource, string(body), err), "download"))
+			return
+		}
+
+		if err := a.Config.SetDownloadRateLimits(limits.RateLimitKBps, limits.WindowRateLimitKBps); err != nil {
+			errorhandler(NewAPIUserInputError(err.Error(), "download"))
+			return
+		}
+
+		writeResponse(w, output(), http.StatusOK)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, PATCH, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
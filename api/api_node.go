@@ -1,13 +1,19 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/containermessage"
 	"github.com/open-horizon/anax/eventlog"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
@@ -29,18 +35,26 @@ func (a *API) node(w http.ResponseWriter, r *http.Request) {
 	case "GET":
 		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
 
-		if out, err := FindHorizonDeviceForOutput(a.db); err != nil {
+		// The ETag is read first, a change made while the node is read makes the next conditional change fail rather
+		// than overwrite it.
+		if etag, err := FindDeviceETag(a.db); err != nil {
+			errorHandler(err)
+		} else if out, err := FindHorizonDeviceForOutput(a.db); err != nil {
 			errorHandler(NewSystemError(fmt.Sprintf("Error getting %v for output, error %v", resource, err)))
 		} else {
+			setETag(w, etag)
 			writeResponse(w, out, http.StatusOK)
 		}
 
 	case "HEAD":
 		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
 
-		if out, err := FindHorizonDeviceForOutput(a.db); err != nil {
+		if etag, err := FindDeviceETag(a.db); err != nil {
+			errorHandler(err)
+		} else if out, err := FindHorizonDeviceForOutput(a.db); err != nil {
 			errorHandler(NewSystemError(fmt.Sprintf("Error getting %v for output, error %v", resource, err)))
 		} else if serial, errWritten := serializeResponse(w, out); !errWritten {
+			setETag(w, etag)
 			w.Header().Add("Content-Length", strconv.Itoa(len(serial)))
 			w.WriteHeader(http.StatusOK)
 		}
@@ -51,64 +65,37 @@ func (a *API) node(w http.ResponseWriter, r *http.Request) {
 		// Read in the HTTP body and pass the device registration off to be validated and created.
 		var newDevice HorizonDevice
 		body, _ := ioutil.ReadAll(r.Body)
-		if err := json.Unmarshal(body, &newDevice); err != nil {
+		err := decodeInputBody(body, &newDevice, "device")
+		if err == nil {
+			err = validateHorizonDeviceInput(&newDevice, true)
+		}
+		if err != nil {
 			LogDeviceEvent(a.db, persistence.SEVERITY_ERROR,
 				persistence.NewMessageMeta(EL_API_ERR_PARSING_INPUT_FOR_NODE_REG, string(body), err.Error()),
 				persistence.EC_API_USER_INPUT_ERROR, nil)
-			errorHandler(NewAPIUserInputError(fmt.Sprintf("Input body couldn't be deserialized to %v object: %v, error: %v", resource, string(body), err), "device"))
+			errorHandler(err)
 			return
 		}
 
-		orgHandler := exchange.GetHTTPExchangeOrgHandlerWithContext(a.Config)
-		patternHandler := exchange.GetHTTPExchangePatternHandlerWithContext(a.Config)
-		versionHandler := exchange.GetHTTPExchangeVersionHandler(a.Config)
-		patchDeviceHandler := exchange.GetHTTPPatchDeviceHandler2(a.Config)
-		getDeviceHandler := exchange.GetHTTPDeviceHandler2(a.Config)
-
-		create_device_error_handler := func(err error) bool {
-			dev_id := ""
-			if newDevice.Id != nil {
-				dev_id = *newDevice.Id
-			}
-			LogDeviceEvent(a.db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_IN_NODE_REG, dev_id, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, &newDevice)
-			return errorHandler(err)
-		}
-
 		// Validate and create the new device registration.
-		errHandled, device, exDev := CreateHorizonDevice(&newDevice, create_device_error_handler, orgHandler, patternHandler, versionHandler, patchDeviceHandler, getDeviceHandler, a.em, a.db)
-		if errHandled {
-			return
-		}
-
-		a.EC = worker.NewExchangeContext(fmt.Sprintf("%v/%v", *device.Org, *device.Id), *device.Token, a.Config.Edge.ExchangeURL, a.Config.GetCSSURL(), a.Config.Collaborators.HTTPClientFactory)
-
-		// sync the node policy and userinput with the exchange
-		if err := exchangesync.NodeInitalSetup(a.db, exchange.GetHTTPDeviceHandler(a)); err != nil {
-			create_device_error_handler(fmt.Errorf("Failed to initially set up local copy of the exchange node. %v", err))
-		}
-		if _, err := exchangesync.NodePolicyInitalSetup(a.db, a.Config, exchange.GetHTTPNodePolicyHandler(a), exchange.GetHTTPPutNodePolicyHandler(a)); err != nil {
-			create_device_error_handler(fmt.Errorf("Failed to initially set up node policy. %v", err))
-			return
-		}
-		if err := exchangesync.NodeUserInputInitalSetup(a.db, exchange.GetHTTPPatchDeviceHandler(a)); err != nil {
-			create_device_error_handler(fmt.Errorf("Failed to initially set up node user input. %v", err))
-			return
+		if errHandled, exDev := a.registerNode(&newDevice, errorHandler); !errHandled {
+			writeResponse(w, exDev, http.StatusCreated)
 		}
 
-		a.Messages() <- events.NewEdgeRegisteredExchangeMessage(events.NEW_DEVICE_REG, *device.Id, *device.Token, *device.Org, *device.Pattern, *device.NodeType)
-
-		writeResponse(w, exDev, http.StatusCreated)
-
 	case "PATCH":
 		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
 
 		var device HorizonDevice
 		body, _ := ioutil.ReadAll(r.Body)
-		if err := json.Unmarshal(body, &device); err != nil {
+		err := decodeInputBody(body, &device, "device")
+		if err == nil {
+			err = validateHorizonDeviceInput(&device, false)
+		}
+		if err != nil {
 			LogDeviceEvent(a.db, persistence.SEVERITY_ERROR,
-				persistence.NewMessageMeta(EL_API_ERR_PARSING_INPUT_FOR_NODE_UPDATE, body, err.Error()),
+				persistence.NewMessageMeta(EL_API_ERR_PARSING_INPUT_FOR_NODE_UPDATE, string(body), err.Error()),
 				persistence.EC_API_USER_INPUT_ERROR, nil)
-			errorHandler(NewAPIUserInputError(fmt.Sprintf("Input body couldn't be deserialized to %v object: %v, error: %v", resource, string(body), err), "device"))
+			errorHandler(err)
 			return
 		}
 
@@ -122,16 +109,38 @@ func (a *API) node(w http.ResponseWriter, r *http.Request) {
 			return errorHandler(err)
 		}
 
+		// The exchange requests are cancelled when the client goes away.
+		ec := exchange.WithContext(r.Context(), a)
 		versionHandler := exchange.GetHTTPExchangeVersionHandler(a.Config)
-
-		// Validate the PATCH input and update the object in the database.
-		errHandled, dev, exDev := UpdateHorizonDevice(&device, update_device_error_handler, versionHandler, a.db)
+		live := a.Config.LiveEdge()
+		patternHandler := exchange.GetCachedPatternHandler(exchange.GetRetryPatternHandler(exchange.GetHTTPExchangePatternHandler(ec), &live.ExchangeRetry), live.PatternCacheTTLS)
+		serviceResolver := exchange.GetCachedServiceDefResolverHandler(exchange.GetRetryServiceDefResolverHandler(exchange.GetHTTPServiceDefResolverHandler(ec), &live.ExchangeRetry), live.PatternCacheTTLS)
+		patchDevice := exchange.GetHTTPPatchDeviceHandler(a)
+		getDevice := exchange.GetHTTPDeviceHandler(ec)
+
+		// Validate the PATCH input and update the object in the database. A change of pattern is made under the lock of
+		// the config state changes, it must not be mixed with the autoconfig of the old pattern.
+		// The If-Match header is checked under the same lock, no other change can be made between the check and the
+		// update.
+		lockConfigstate()
+		if err := a.checkDeviceIfMatch(r.Header.Get(IF_MATCH_HEADER), resource); err != nil {
+			unlockConfigstate()
+			errorHandler(err)
+			return
+		}
+		errHandled, dev, exDev := UpdateHorizonDevice(r.Context(), &device, update_device_error_handler, versionHandler, getDevice, patternHandler, serviceResolver, patchDevice, a.Messages(), a.db, a.Config)
+		etag, etagErr := FindDeviceETag(a.db)
+		unlockConfigstate()
 		if errHandled {
 			return
 		}
 
-		a.EC = worker.NewExchangeContext(fmt.Sprintf("%v/%v", *device.Org, *device.Id), *dev.Token, a.Config.Edge.ExchangeURL, a.Config.GetCSSURL(), a.Config.Collaborators.HTTPClientFactory)
+		// The org is not in the body of a token rotation, it is the one of the node.
+		a.EC = worker.NewExchangeContext(fmt.Sprintf("%v/%v", *exDev.Org, *exDev.Id), *dev.Token, a.Config.Edge.ExchangeURL, a.Config.GetCSSURL(), a.Config.Collaborators.HTTPClientFactory)
 
+		if etagErr == nil {
+			setETag(w, etag)
+		}
 		writeResponse(w, exDev, http.StatusOK)
 
 	case "DELETE":
@@ -167,6 +176,61 @@ func (a *API) node(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Register the node with the exchange and set up its local copy, as for a POST on /node. Returns true if the error
+// handler handled an error, otherwise the registered node.
+func (a *API) registerNode(newDevice *HorizonDevice, errorHandler ErrorHandler) (bool, *HorizonDevice) {
+
+	orgHandler := exchange.GetHTTPExchangeOrgHandlerWithContext(a.Config)
+	patternHandler := exchange.GetHTTPExchangePatternHandlerWithContext(a.Config)
+	versionHandler := exchange.GetHTTPExchangeVersionHandler(a.Config)
+	patchDeviceHandler := exchange.GetHTTPPatchDeviceHandler2(a.Config)
+	getDeviceHandler := exchange.GetHTTPDeviceHandler2(a.Config)
+
+	create_device_error_handler := func(err error) bool {
+		dev_id := ""
+		if newDevice.Id != nil {
+			dev_id = *newDevice.Id
+		}
+		LogDeviceEvent(a.db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_IN_NODE_REG, dev_id, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, newDevice)
+		return errorHandler(err)
+	}
+
+	// The node is registered with the offline definitions when the exchange cannot be reached.
+	defs, _ := loadOfflineDefinitions(&Configstate{}, a.Config, a.db)
+
+	errHandled, device, exDev := CreateHorizonDevice(newDevice, create_device_error_handler, orgHandler, patternHandler, versionHandler, patchDeviceHandler, getDeviceHandler, defs, a.em, a.db)
+	if errHandled {
+		return true, nil
+	}
+
+	a.EC = worker.NewExchangeContext(fmt.Sprintf("%v/%v", *device.Org, *device.Id), *device.Token, a.Config.Edge.ExchangeURL, a.Config.GetCSSURL(), a.Config.Collaborators.HTTPClientFactory)
+
+	// A node registered offline is set up with the exchange by the offline reconcile worker once it can be reached.
+	if reg, err := persistence.FindOfflineRegistration(a.db); err != nil {
+		create_device_error_handler(NewSystemError(fmt.Sprintf("Unable to read the offline registration. %v", err)))
+		return true, nil
+	} else if reg != nil {
+		a.Messages() <- events.NewEdgeRegisteredExchangeMessage(events.NEW_DEVICE_REG, *device.Id, *device.Token, *device.Org, *device.Pattern, *device.NodeType)
+		return false, exDev
+	}
+
+	// sync the node policy and userinput with the exchange
+	if err := exchangesync.NodeInitalSetup(a.db, exchange.GetHTTPDeviceHandler(a)); err != nil {
+		create_device_error_handler(fmt.Errorf("Failed to initially set up local copy of the exchange node. %v", err))
+	}
+	if _, err := exchangesync.NodePolicyInitalSetup(a.db, a.Config, exchange.GetHTTPNodePolicyHandler(a), exchange.GetHTTPPutNodePolicyHandler(a)); err != nil {
+		create_device_error_handler(fmt.Errorf("Failed to initially set up node policy. %v", err))
+		return true, nil
+	}
+	if err := exchangesync.NodeUserInputInitalSetup(a.db, exchange.GetHTTPPatchDeviceHandler(a)); err != nil {
+		create_device_error_handler(fmt.Errorf("Failed to initially set up node user input. %v", err))
+		return true, nil
+	}
+
+	a.Messages() <- events.NewEdgeRegisteredExchangeMessage(events.NEW_DEVICE_REG, *device.Id, *device.Token, *device.Org, *device.Pattern, *device.NodeType)
+	return false, exDev
+}
+
 func (a *API) nodeconfigstate(w http.ResponseWriter, r *http.Request) {
 
 	resource := "node/configstate"
@@ -175,77 +239,392 @@ func (a *API) nodeconfigstate(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case "GET":
-		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+		glog.V(5).Infof(apiRequestLogString(r.Context(), fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
 
-		if out, err := FindConfigstateForOutput(a.db); err != nil {
+		if etag, err := FindDeviceETag(a.db); err != nil {
+			errorHandler(err)
+		} else if out, err := a.findConfigstateForOutput(r.Context()); err != nil {
 			errorHandler(NewSystemError(fmt.Sprintf("Error getting %v for output, error %v", resource, err)))
 		} else {
+			setETag(w, etag)
 			writeResponse(w, out, http.StatusOK)
 		}
 
 	case "HEAD":
-		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+		glog.V(5).Infof(apiRequestLogString(r.Context(), fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
 
-		if out, err := FindConfigstateForOutput(a.db); err != nil {
+		if etag, err := FindDeviceETag(a.db); err != nil {
+			errorHandler(err)
+		} else if out, err := a.findConfigstateForOutput(r.Context()); err != nil {
 			errorHandler(NewSystemError(fmt.Sprintf("Error getting %v for output, error %v", resource, err)))
 		} else if serial, errWritten := serializeResponse(w, out); !errWritten {
+			setETag(w, etag)
 			w.Header().Add("Content-Length", strconv.Itoa(len(serial)))
 			w.WriteHeader(http.StatusOK)
 		}
 
 	case "PUT":
-		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
-
-		// make sure current exchange version meet the requirement
-		if err := version.VerifyExchangeVersion(a.GetHTTPFactory(), a.GetExchangeURL(), a.GetExchangeId(), a.GetExchangeToken(), false); err != nil {
-			eventlog.LogExchangeEvent(a.db, persistence.SEVERITY_ERROR,
-				persistence.NewMessageMeta(EL_API_ERR_IN_VERIFY_EXCH_VERSION, err.Error()),
-				persistence.EC_EXCHANGE_ERROR, a.GetExchangeURL())
-			errorHandler(NewSystemError(fmt.Sprintf("Error verifiying exchange version. error: %v", err)))
-			return
-		}
-
-		patternHandler := exchange.GetHTTPExchangePatternHandler(a)
-		serviceResolver := exchange.GetHTTPServiceDefResolverHandler(a)
-		getService := exchange.GetHTTPServiceHandler(a)
-		getDevice := exchange.GetHTTPDeviceHandler(a)
-		patchDevice := exchange.GetHTTPPatchDeviceHandler(a)
+		glog.V(5).Infof(apiRequestLogString(r.Context(), fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
 
 		// Read in the HTTP body and pass the device registration off to be validated and created.
 		var configState Configstate
 		body, _ := ioutil.ReadAll(r.Body)
-		if err := json.Unmarshal(body, &configState); err != nil {
+		err := decodeInputBody(body, &configState, "configstate")
+		if err == nil {
+			err = validateConfigstateInput(&configState)
+		}
+		if err != nil {
 			LogDeviceEvent(a.db, persistence.SEVERITY_ERROR,
 				persistence.NewMessageMeta(EL_API_ERR_PARSING_INPUT_FOR_NODE_UNREG, string(body), err.Error()),
 				persistence.EC_API_USER_INPUT_ERROR, nil)
-			errorHandler(NewAPIUserInputError(fmt.Sprintf("Input body couldn't be deserialized to %v object: %v, error: %v", resource, string(body), err), "configstate"))
+			errorHandler(err)
 			return
 		}
 
+		// The dry run can also be asked for with a query parameter.
+		if dryRun := r.URL.Query().Get("dryrun"); dryRun != "" {
+			if b, err := strconv.ParseBool(dryRun); err != nil {
+				errorHandler(NewAPIUserInputError(fmt.Sprintf("%v is an incorrect value for dryrun", dryRun), "url.dryrun"))
+				return
+			} else if b {
+				configState.DryRun = &b
+			}
+		}
+
+		// The patterns and resolved services cached from the previous requests can be bypassed, e.g. to check that a
+		// change to the pattern in the exchange is seen.
+		noCache := false
+		if cache := r.URL.Query().Get("cache"); cache != "" {
+			if b, err := strconv.ParseBool(cache); err != nil {
+				errorHandler(NewAPIUserInputError(fmt.Sprintf("%v is an incorrect value for cache", cache), "url.cache"))
+				return
+			} else {
+				noCache = !b
+			}
+		}
+
 		// Validate and update the config state.
-		errHandled, cfg, msgs := UpdateConfigstate(&configState, errorHandler, patternHandler, serviceResolver, getService, getDevice, patchDevice, a.db, a.Config)
-		if errHandled {
-			return
+		// The change stops when the client goes away.
+		if errHandled, cfg := a.updateConfigstate(r.Context(), &configState, errorHandler, noCache, r.Header.Get(IF_MATCH_HEADER)); !errHandled {
+			if etag, err := FindDeviceETag(a.db); err == nil {
+				setETag(w, etag)
+			}
+			if configState.DryRun != nil && *configState.DryRun {
+				writeResponse(w, cfg, http.StatusOK)
+			} else {
+				writeResponse(w, cfg, http.StatusCreated)
+			}
 		}
 
-		// Send out all messages
-		for _, msg := range msgs {
-			a.Messages() <- msg
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, POST, PATCH, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// The configstate with the counts of the services of the node's pattern. The pattern and the services are read from
+// the cache, the transient failures of the exchange are not retried so that an exchange that is not reachable does not
+// hold up the request, the counts are then reported as unavailable.
+func (a *API) findConfigstateForOutput(ctx context.Context) (*Configstate, error) {
+	out, err := FindConfigstateForOutput(a.db, a.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, configstateCountsTimeout)
+	defer cancel()
+
+	ec := exchange.WithContext(ctx, a)
+	patternHandler := exchange.GetCachedPatternHandler(exchange.GetHTTPExchangePatternHandler(ec), a.Config.LiveEdge().PatternCacheTTLS)
+	serviceResolver := exchange.GetCachedServiceDefResolverHandler(exchange.GetHTTPServiceDefResolverHandler(ec), a.Config.LiveEdge().PatternCacheTTLS)
+	if err := FindConfigstateServiceCounts(ctx, out, patternHandler, serviceResolver, exchange.GetHTTPServiceHandler(ec), a.db, a.Config); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (a *API) nodepatternservices(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/pattern/services"
+
+	errorHandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		live := a.Config.LiveEdge()
+		patternHandler := exchange.GetCachedPatternHandler(exchange.GetRetryPatternHandler(exchange.GetHTTPExchangePatternHandler(a), &live.ExchangeRetry), live.PatternCacheTTLS)
+		serviceResolver := exchange.GetCachedServiceDefResolverHandler(exchange.GetRetryServiceDefResolverHandler(exchange.GetHTTPServiceDefResolverHandler(a), &live.ExchangeRetry), live.PatternCacheTTLS)
+
+		if out, err := FindPatternServicesForOutput(a.db, patternHandler, serviceResolver, a.Config); err != nil {
+			errorHandler(err)
+		} else {
+			writeResponse(w, map[string][]PatternServiceOutput{"services": out}, http.StatusOK)
 		}
 
-		// Send out the config complete message that enables the device for agreements
-		a.Messages() <- events.NewEdgeConfigCompleteMessage(events.NEW_DEVICE_CONFIG_COMPLETE)
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
 
-		writeResponse(w, cfg, http.StatusCreated)
+func (a *API) nodeconfigstateprogress(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/configstate/progress"
+
+	errorHandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		if progress := GetConfigstateProgress(); progress == nil {
+			errorHandler(NewNotFoundError("the services of the node's pattern have not been configured since the agent started", "node/configstate"))
+		} else {
+			writeResponse(w, progress, http.StatusOK)
+		}
 
 	case "OPTIONS":
-		w.Header().Set("Allow", "GET, POST, PATCH, OPTIONS")
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *API) nodeconfigstateresolution(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/configstate/resolution"
+
+	errorHandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		// The pattern is only read from the exchange when asked for, and never from the cache.
+		check := false
+		if c := r.URL.Query().Get("check"); c != "" {
+			if b, err := strconv.ParseBool(c); err != nil {
+				errorHandler(NewAPIUserInputError(fmt.Sprintf("%v is an incorrect value for check", c), "url.check"))
+				return
+			} else {
+				check = b
+			}
+		}
+
+		live := a.Config.LiveEdge()
+		patternHandler := exchange.GetRetryPatternHandler(exchange.GetHTTPExchangePatternHandler(a), &live.ExchangeRetry)
+		if out, err := FindConfigstateResolutionForOutput(a.db, check, patternHandler); err != nil {
+			errorHandler(err)
+		} else {
+			writeResponse(w, out, http.StatusOK)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *API) nodereconcile(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/reconcile"
+
+	errorHandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		if out, err := FindServiceReconciliationForOutput(a.db); err != nil {
+			errorHandler(err)
+		} else {
+			writeResponse(w, out, http.StatusOK)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *API) nodeconnectivity(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/connectivity"
+
+	errorHandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		// The checks are run for an unregistered node too, its exchange record is then reported as not readable.
+		pDevice, err := persistence.FindExchangeDevice(a.db)
+		if err != nil {
+			errorHandler(NewSystemError(fmt.Sprintf("Unable to read node object, error %v", err)).WithCode(ERR_DATABASE))
+			return
+		}
+
+		// The checks stop when the client goes away.
+		writeResponse(w, CheckConnectivity(r.Context(), pDevice, exchange.GetHTTPDeviceHandler(exchange.WithContext(r.Context(), a)), a.Config), http.StatusOK)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
 		w.WriteHeader(http.StatusOK)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
+// Change the config state of the node, as for a PUT on /node/configstate. Returns true if the error handler handled
+// an error, otherwise the new config state. The patterns and resolved services are read from the exchange cache, unless
+// noCache is set, in which case the cached ones are dropped. The change fails when ctx is done, or after the
+// Edge.ConfigstateTimeoutS of the config. When ifMatch is set, the change is only made if the ETag of the node is one of
+// its ETags.
+func (a *API) updateConfigstate(ctx context.Context, configState *Configstate, errorHandler ErrorHandler, noCache bool, ifMatch string) (bool, *Configstate) {
+
+	ctx, cancel := NewConfigstateContext(ctx, a.Config)
+	defer cancel()
+
+	lockConfigstate()
+	defer unlockConfigstate()
+
+	if err := a.checkDeviceIfMatch(ifMatch, "node/configstate"); err != nil {
+		errorHandler(err)
+		return true, nil
+	}
+
+	// The exchange requests stop when the change is given up on. The update of the node is not cancelled, it is only
+	// made once the change is complete.
+	ec := exchange.WithContext(ctx, a)
+	getDevice := exchange.GetHTTPDeviceHandler(ec)
+	patchDevice := exchange.GetHTTPPatchDeviceHandler(a)
+
+	// The offline definitions of the config resolve the pattern when the change asks for them, or when the exchange
+	// cannot be reached. The exchange is not used at all then.
+	defs, err := loadOfflineDefinitions(configState, a.Config, a.db)
+	if err != nil {
+		LogDeviceEvent(a.db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_OFFLINE, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, nil)
+		errorHandler(err)
+		return true, nil
+	}
+	offlineOnly := defs != nil && configState.Offline != nil && *configState.Offline
+	if defs != nil && !offlineOnly {
+		// An unregistered node cannot be configured, the change then fails as usual.
+		if pDevice, err := persistence.FindExchangeDevice(a.db); err == nil && pDevice != nil && !exchangeReachable(ctx, fmt.Sprintf("%v/%v", pDevice.Org, pDevice.Id), pDevice.Token, getDevice) {
+			offlineOnly = true
+		}
+	}
+
+	// make sure current exchange version meet the requirement
+	if offlineOnly {
+		glog.Infof(apiRequestLogString(ctx, fmt.Sprintf("Configuring the node from the offline definitions in %v", defs.Dir)))
+	} else if err := version.VerifyExchangeVersion(ec.GetHTTPFactory(), a.GetExchangeURL(), a.GetExchangeId(), a.GetExchangeToken(), false); err != nil {
+		eventlog.LogExchangeEvent(a.db, persistence.SEVERITY_ERROR,
+			persistence.NewMessageMeta(EL_API_ERR_IN_VERIFY_EXCH_VERSION, err.Error()),
+			persistence.EC_EXCHANGE_ERROR, a.GetExchangeURL())
+		errorHandler(NewSystemError(fmt.Sprintf("Error verifiying exchange version. error: %v", err)))
+		return true, nil
+	}
+
+	live := a.Config.LiveEdge()
+	cacheTTL := live.PatternCacheTTLS
+	if noCache {
+		exchange.DeletePatternCache()
+		cacheTTL = 0
+	}
+
+	// The transient failures of the exchange are retried, the cache only keeps the successful calls.
+	patternHandler := exchange.GetCachedPatternHandler(exchange.GetRetryPatternHandler(exchange.GetHTTPExchangePatternHandler(ec), &live.ExchangeRetry), cacheTTL)
+	serviceResolver := exchange.GetCachedServiceDefResolverHandler(exchange.GetRetryServiceDefResolverHandler(exchange.GetHTTPServiceDefResolverHandler(ec), &live.ExchangeRetry), cacheTTL)
+	getService := exchange.GetHTTPServiceHandler(ec)
+	if defs != nil {
+		patternHandler, serviceResolver, getService = offlineHandlers(defs, offlineOnly, patternHandler, serviceResolver, getService)
+	}
+
+	errHandled, cfg, msgs := changeConfigstate(ctx, configState, errorHandler, patternHandler, serviceResolver, getService, getDevice, patchDevice, a.db, a.Config)
+	if errHandled {
+		return true, nil
+	}
+
+	// The offline definitions that configured the node are compared with the exchange once it can be reached.
+	if defs != nil && (configState.DryRun == nil || !*configState.DryRun) && *cfg.State != persistence.CONFIGSTATE_CONFIGURING {
+		if used := saveOfflineConfigstate(defs, a.db); used {
+			cfg.Offline = &used
+		}
+	}
+
+	// Send out all messages
+	for _, msg := range msgs {
+		a.Messages() <- msg
+	}
+
+	if configState.DryRun == nil || !*configState.DryRun {
+		// Send out the config complete message that enables the device for agreements
+		if *cfg.State == persistence.CONFIGSTATE_CONFIGURED {
+			a.Messages() <- events.NewEdgeConfigCompleteMessage(events.NEW_DEVICE_CONFIG_COMPLETE)
+		}
+		a.schedulePendingConfigstate(cfg)
+	}
+	return false, cfg
+}
+
+// How long to wait before trying again to configure a node whose effective time is reached.
+const pendingConfigstateRetry = time.Minute
+
+// Set the timer that configures the node at its effective time when it is configured_pending, otherwise stop the
+// timer. The caller holds configstateLock, except when the API is created.
+func (a *API) schedulePendingConfigstate(cfg *Configstate) {
+	if a.pendingTimer != nil {
+		a.pendingTimer.Stop()
+		a.pendingTimer = nil
+	}
+	if cfg == nil || cfg.State == nil || *cfg.State != persistence.CONFIGSTATE_CONFIGURED_PENDING || cfg.EffectiveTime == nil {
+		return
+	}
+
+	wait := time.Until(time.Unix(int64(*cfg.EffectiveTime), 0))
+	glog.V(3).Infof(apiLogString(fmt.Sprintf("the node will be configured at its effective time %v, in %v", *cfg.EffectiveTime, wait)))
+	a.pendingTimer = time.AfterFunc(wait, a.completePendingConfigstate)
+}
+
+// Configure the configured_pending node now that its effective time is reached, as a PUT of the configured state
+// would, and advertise the policies of its services.
+func (a *API) completePendingConfigstate() {
+	lockConfigstate()
+	defer unlockConfigstate()
+
+	cfg, msgs, err := CompletePendingConfigstate(a.db)
+	if err != nil {
+		glog.Errorf(apiLogString(fmt.Sprintf("unable to configure the node at its effective time, retrying in %v, error %v", pendingConfigstateRetry, err)))
+		a.pendingTimer = time.AfterFunc(pendingConfigstateRetry, a.completePendingConfigstate)
+		return
+	} else if cfg == nil {
+		a.pendingTimer = nil
+		return
+	}
+
+	for _, msg := range msgs {
+		a.Messages() <- msg
+	}
+	if *cfg.State == persistence.CONFIGSTATE_CONFIGURED {
+		a.Messages() <- events.NewEdgeConfigCompleteMessage(events.NEW_DEVICE_CONFIG_COMPLETE)
+	}
+
+	// the effective time was moved later in the meantime
+	a.schedulePendingConfigstate(cfg)
+}
+
 func (a *API) nodepolicy(w http.ResponseWriter, r *http.Request) {
 
 	resource := "node/policy"
@@ -407,22 +786,49 @@ func (a *API) nodeuserinput(w http.ResponseWriter, r *http.Request) {
 
 	errorHandler := GetHTTPErrorHandler(w)
 
+	// A change with an If-Match header is checked against the ETag of the node user input that the client read, under
+	// the lock of the config changes so that no other conditional change or config state change is made between the
+	// check and the change. A POST of the variables by service changes their attributes, it is checked against the
+	// ETag of the attributes, see /attribute.
+	ifMatch := r.Header.Get(IF_MATCH_HEADER)
+	if ifMatch != "" && r.Method != "GET" && r.Method != "HEAD" && r.Method != "OPTIONS" {
+		lockConfigstate()
+		defer unlockConfigstate()
+	}
+	ifMatchFailed := func(etag string, err error, what string) bool {
+		if ifMatch == "" {
+			return false
+		} else if err == nil {
+			err = checkIfMatch(ifMatch, etag, what)
+		}
+		if err != nil {
+			return errorHandler(err)
+		}
+		return false
+	}
+
 	switch r.Method {
 	case "GET":
 		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
 
-		if out, err := FindNodeUserInputForOutput(a.db); err != nil {
+		if etag, err := FindNodeUserInputETag(a.db); err != nil {
+			errorHandler(err)
+		} else if out, err := FindNodeUserInputForOutput(a.db); err != nil {
 			errorHandler(NewSystemError(fmt.Sprintf("Error getting %v for output, error %v", resource, err)))
 		} else {
+			setETag(w, etag)
 			writeResponse(w, out, http.StatusOK)
 		}
 
 	case "HEAD":
 		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
 
-		if out, err := FindNodeUserInputForOutput(a.db); err != nil {
+		if etag, err := FindNodeUserInputETag(a.db); err != nil {
+			errorHandler(err)
+		} else if out, err := FindNodeUserInputForOutput(a.db); err != nil {
 			errorHandler(NewSystemError(fmt.Sprintf("Error getting %v for output, error %v", resource, err)))
 		} else if serial, errWritten := serializeResponse(w, out); !errWritten {
+			setETag(w, etag)
 			w.Header().Add("Content-Length", strconv.Itoa(len(serial)))
 			w.WriteHeader(http.StatusOK)
 		}
@@ -435,6 +841,15 @@ func (a *API) nodeuserinput(w http.ResponseWriter, r *http.Request) {
 		// Read in the HTTP body and pass the device registration off to be validated and created.
 		var nodeUserInput []policy.UserInput
 		body, _ := ioutil.ReadAll(r.Body)
+
+		// A POST body can also be the variables of the services of the node's pattern, by service.
+		if r.Method == "POST" && strings.HasPrefix(strings.TrimSpace(string(body)), "{") {
+			if etag, err := FindAttributesETag(a.db, ""); !ifMatchFailed(etag, err, "The attributes of the node") {
+				a.postServicesUserInput(w, body, errorHandler)
+			}
+			return
+		}
+
 		if err := json.Unmarshal(body, &nodeUserInput); err != nil {
 			LogDeviceEvent(a.db, persistence.SEVERITY_ERROR,
 				persistence.NewMessageMeta(EL_API_ERR_PARSING_INPUT_FOR_NODE_UI, string(body), err.Error()),
@@ -451,6 +866,10 @@ func (a *API) nodeuserinput(w http.ResponseWriter, r *http.Request) {
 		patchDevice := exchange.GetHTTPPatchDeviceHandler(a)
 		getService := exchange.GetHTTPServiceHandler(a)
 
+		if etag, err := FindNodeUserInputETag(a.db); ifMatchFailed(etag, err, "The node user input") {
+			return
+		}
+
 		// Validate and create or update the node policy.
 		errHandled, cfg, msgs := UpdateNodeUserInput(nodeUserInput, update_node_userinput_error_handler, getDevice, patchDevice, getService, a.db)
 		if errHandled {
@@ -464,6 +883,9 @@ func (a *API) nodeuserinput(w http.ResponseWriter, r *http.Request) {
 
 		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handled %v on resource %v", r.Method, resource)))
 
+		if etag, err := FindNodeUserInputETag(a.db); err == nil {
+			setETag(w, etag)
+		}
 		writeResponse(w, cfg, http.StatusCreated)
 
 	case "PATCH":
@@ -489,6 +911,10 @@ func (a *API) nodeuserinput(w http.ResponseWriter, r *http.Request) {
 		patchDevice := exchange.GetHTTPPatchDeviceHandler(a)
 		getService := exchange.GetHTTPServiceHandler(a)
 
+		if etag, err := FindNodeUserInputETag(a.db); ifMatchFailed(etag, err, "The node user input") {
+			return
+		}
+
 		//Validate the patch and update the policy
 		errHandled, cfg, msgs := PatchNodeUserInput(nodeUserInput, patch_node_userinput_error_handler, getDevice, patchDevice, getService, a.db)
 
@@ -503,6 +929,9 @@ func (a *API) nodeuserinput(w http.ResponseWriter, r *http.Request) {
 
 		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handled %v on resource %v", r.Method, resource)))
 
+		if etag, err := FindNodeUserInputETag(a.db); err == nil {
+			setETag(w, etag)
+		}
 		writeResponse(w, cfg, http.StatusCreated)
 
 	case "DELETE":
@@ -515,6 +944,10 @@ func (a *API) nodeuserinput(w http.ResponseWriter, r *http.Request) {
 		getDevice := exchange.GetHTTPDeviceHandler(a)
 		patchDevice := exchange.GetHTTPPatchDeviceHandler(a)
 
+		if etag, err := FindNodeUserInputETag(a.db); ifMatchFailed(etag, err, "The node user input") {
+			return
+		}
+
 		// Validate the DELETE request and delete the object from the database.
 		errHandled, msgs := DeleteNodeUserInput(delete_node_userinput_error_handler, a.db, getDevice, patchDevice)
 		if errHandled {
@@ -537,3 +970,651 @@ func (a *API) nodeuserinput(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
+
+// Set the variables of the services of the node's pattern from a POST body of /node/userinput, see UpdateServicesUserInput.
+func (a *API) postServicesUserInput(w http.ResponseWriter, body []byte, errorHandler ErrorHandler) {
+
+	var servicesUserInput map[string]map[string]interface{}
+	if err := json.Unmarshal(body, &servicesUserInput); err != nil {
+		LogDeviceEvent(a.db, persistence.SEVERITY_ERROR,
+			persistence.NewMessageMeta(EL_API_ERR_PARSING_INPUT_FOR_NODE_UI, string(body), err.Error()),
+			persistence.EC_API_USER_INPUT_ERROR, nil)
+		errorHandler(NewAPIUserInputError(fmt.Sprintf("Input body could not be deserialized to the variables of the services by org/url: %v, error: %v", string(body), err), "body").WithCode(ERR_INVALID_INPUT))
+		return
+	}
+
+	update_services_userinput_error_handler := func(device interface{}, err error) bool {
+		LogDeviceEvent(a.db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_IN_NODE_UI_UPDATE, err.Error()), persistence.EC_ERROR_NODE_USERINPUT_UPDATE, device)
+		return errorHandler(err)
+	}
+
+	live := a.Config.LiveEdge()
+	patternHandler := exchange.GetCachedPatternHandler(exchange.GetRetryPatternHandler(exchange.GetHTTPExchangePatternHandler(a), &live.ExchangeRetry), live.PatternCacheTTLS)
+	serviceResolver := exchange.GetCachedServiceDefResolverHandler(exchange.GetRetryServiceDefResolverHandler(exchange.GetHTTPServiceDefResolverHandler(a), &live.ExchangeRetry), live.PatternCacheTTLS)
+
+	errHandled, out, msgs := UpdateServicesUserInput(servicesUserInput, update_services_userinput_error_handler, patternHandler, serviceResolver, exchange.GetHTTPServiceHandler(a), exchange.GetHTTPDeviceHandler(a), exchange.GetHTTPPatchDeviceHandler(a), a.db, a.Config)
+	if errHandled {
+		return
+	}
+
+	for _, msg := range msgs {
+		a.Messages() <- msg
+	}
+
+	writeResponse(w, map[string][]ServiceUserInputOutput{"services": out}, http.StatusCreated)
+}
+
+func (a *API) nodehostaccess(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/hostaccess"
+
+	errorHandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		live := a.Config.LiveEdge()
+		if allowList, err := persistence.GetHostAccessAllowList(a.db, live.DeviceAllowList, live.HostPathAllowList); err != nil {
+			errorHandler(NewSystemError(fmt.Sprintf("Error getting %v for output, error %v", resource, err)))
+		} else {
+			writeResponse(w, allowList, http.StatusOK)
+		}
+
+	case "PUT":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		var allowList persistence.HostAccessAllowList
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &allowList); err != nil {
+			errorHandler(NewAPIUserInputError(fmt.Sprintf("Input body could not be deserialized to %v object: %v, error: %v", resource, string(body), err), "body"))
+			return
+		}
+
+		// The patterns are matched against host paths, make sure they are valid before saving them.
+		for _, pattern := range allowList.Devices {
+			if _, err := filepath.Match(pattern, "/"); err != nil || !strings.HasPrefix(pattern, "/") {
+				errorHandler(NewAPIUserInputError(fmt.Sprintf("device %v must be an absolute path pattern", pattern), "devices"))
+				return
+			}
+		}
+		for _, pattern := range allowList.HostPaths {
+			hostPath := strings.TrimSuffix(pattern, containermessage.HOST_PATH_ALLOW_RW)
+			if _, err := filepath.Match(hostPath, "/"); err != nil || !strings.HasPrefix(hostPath, "/") {
+				errorHandler(NewAPIUserInputError(fmt.Sprintf("host path %v must be an absolute path pattern", pattern), "host_paths"))
+				return
+			}
+		}
+
+		if err := persistence.SaveHostAccessAllowList(a.db, &allowList); err != nil {
+			errorHandler(NewSystemError(fmt.Sprintf("Unable to save %v, error %v", resource, err)))
+			return
+		}
+
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handled %v on resource %v", r.Method, resource)))
+
+		writeResponse(w, allowList, http.StatusCreated)
+
+	case "DELETE":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		// The allow lists in the anax configuration file apply again.
+		if err := persistence.DeleteHostAccessAllowList(a.db); err != nil {
+			errorHandler(NewSystemError(fmt.Sprintf("Unable to delete %v, error %v", resource, err)))
+			return
+		}
+
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handled %v on resource %v", r.Method, resource)))
+
+		w.WriteHeader(http.StatusNoContent)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, PUT, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *API) nodeportpolicy(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/portpolicy"
+
+	errorHandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		if portPolicy, err := persistence.FindEffectivePortPolicy(a.db); err != nil {
+			errorHandler(NewSystemError(fmt.Sprintf("Error getting %v for output, error %v", resource, err)))
+		} else {
+			writeResponse(w, portPolicy, http.StatusOK)
+		}
+
+	case "PUT":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		var portPolicy persistence.PortPolicy
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &portPolicy); err != nil {
+			errorHandler(NewAPIUserInputError(fmt.Sprintf("Input body could not be deserialized to %v object: %v, error: %v", resource, string(body), err), "body"))
+			return
+		} else if err := portPolicy.Validate(); err != nil {
+			errorHandler(NewAPIUserInputError(err.Error(), "body"))
+			return
+		}
+
+		// Agreements that were made before are checked again when their containers are started.
+		if err := persistence.SavePortPolicy(a.db, &portPolicy); err != nil {
+			errorHandler(NewSystemError(fmt.Sprintf("Unable to save %v, error %v", resource, err)))
+			return
+		}
+
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handled %v on resource %v", r.Method, resource)))
+
+		writeResponse(w, portPolicy, http.StatusCreated)
+
+	case "DELETE":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		if err := persistence.DeletePortPolicy(a.db); err != nil {
+			errorHandler(NewSystemError(fmt.Sprintf("Unable to delete %v, error %v", resource, err)))
+			return
+		}
+
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handled %v on resource %v", r.Method, resource)))
+
+		w.WriteHeader(http.StatusNoContent)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, PUT, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// The audit log of the requests that changed the node, newest first. The since query parameter, a unix timestamp or
+// an RFC3339 time, keeps the entries at or after it, and limit keeps at most that many entries.
+func (a *API) nodeaudit(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/audit"
+
+	errorHandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		var since uint64
+		if s := r.URL.Query().Get("since"); s != "" {
+			if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+				since = n
+			} else if t, err := time.Parse(time.RFC3339, s); err == nil && t.Unix() >= 0 {
+				since = uint64(t.Unix())
+			} else {
+				errorHandler(NewAPIUserInputError(fmt.Sprintf("since must be a unix timestamp or an RFC3339 time, is %v", s), "since"))
+				return
+			}
+		}
+
+		limit := 0
+		if l := r.URL.Query().Get("limit"); l != "" {
+			if n, err := strconv.Atoi(l); err != nil || n < 0 {
+				errorHandler(NewAPIUserInputError(fmt.Sprintf("limit must be a non-negative integer, is %v", l), "limit"))
+				return
+			} else {
+				limit = n
+			}
+		}
+
+		if entries, err := persistence.FindAuditEntries(a.db, since, limit); err != nil {
+			errorHandler(NewSystemError(fmt.Sprintf("Error getting %v for output, error %v", resource, err)))
+		} else {
+			writeResponse(w, entries, http.StatusOK)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// The size and the buckets of the database of the agent.
+func (a *API) nodedb(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/db"
+
+	errorHandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		if out, err := FindDatabaseForOutput(a.db); err != nil {
+			errorHandler(err)
+		} else {
+			writeResponse(w, out, http.StatusOK)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Request the compaction of the database of the agent the next time it starts, the response has the space it is
+// expected to reclaim.
+func (a *API) nodedbcompaction(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/db/compaction"
+
+	errorHandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "POST":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		if request, err := RequestNodeDatabaseCompaction(a.db); err != nil {
+			errorHandler(err)
+		} else {
+			glog.V(5).Infof(apiLogString(fmt.Sprintf("Handled %v on resource %v", r.Method, resource)))
+			writeResponse(w, request, http.StatusAccepted)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "POST, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// The configuration of the node as a single document, without its secrets, so that it can be imported into another
+// node with POST /node/import.
+func (a *API) nodeexport(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/export"
+
+	errorHandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		if out, err := FindNodeExportForOutput(a.db); err != nil {
+			errorHandler(err)
+		} else {
+			writeResponse(w, out, http.StatusOK)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Import the configuration exported from another node, the response has the configuration of the node once it is
+// imported.
+func (a *API) nodeimport(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/import"
+
+	errorHandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "POST":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		var doc NodeExport
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := decodeInputBody(body, &doc, "import"); err != nil {
+			errorHandler(err)
+			return
+		}
+
+		if errHandled, out := a.importNode(r.Context(), &doc, errorHandler); !errHandled {
+			glog.V(5).Infof(apiLogString(fmt.Sprintf("Handled %v on resource %v", r.Method, resource)))
+			writeResponse(w, out, http.StatusCreated)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "POST, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Import a node configuration, as for a POST on /node/import. Returns true if the error handler handled an error,
+// otherwise the imported configuration. The import changes the config state of the node, so it is serialized with the
+// changes of the config state, and fails as they do when ctx is done or after the Edge.ConfigstateTimeoutS of the config.
+func (a *API) importNode(ctx context.Context, doc *NodeExport, errorHandler ErrorHandler) (bool, *NodeExport) {
+
+	ctx, cancel := NewConfigstateContext(ctx, a.Config)
+	defer cancel()
+
+	lockConfigstate()
+	defer unlockConfigstate()
+
+	ec := exchange.WithContext(ctx, a)
+	live := a.Config.LiveEdge()
+	patternHandler := exchange.GetCachedPatternHandler(exchange.GetRetryPatternHandler(exchange.GetHTTPExchangePatternHandler(ec), &live.ExchangeRetry), live.PatternCacheTTLS)
+	serviceResolver := exchange.GetCachedServiceDefResolverHandler(exchange.GetRetryServiceDefResolverHandler(exchange.GetHTTPServiceDefResolverHandler(ec), &live.ExchangeRetry), live.PatternCacheTTLS)
+	getService := exchange.GetHTTPServiceHandler(ec)
+	getDevice := exchange.GetHTTPDeviceHandler(ec)
+	patchDevice := exchange.GetHTTPPatchDeviceHandler(a)
+
+	errHandled, out, msgs := ImportNodeConfig(ctx, doc, errorHandler, a.verifyRegistryAuthAttribute, patternHandler, serviceResolver, getService, getDevice, patchDevice, a.db, a.Config)
+	if errHandled {
+		return true, nil
+	}
+
+	for _, msg := range msgs {
+		a.Messages() <- msg
+	}
+
+	// Send out the config complete message that enables the device for agreements
+	if out.Configstate != nil && out.Configstate.State != nil && *out.Configstate.State == persistence.CONFIGSTATE_CONFIGURED {
+		a.Messages() <- events.NewEdgeConfigCompleteMessage(events.NEW_DEVICE_CONFIG_COMPLETE)
+	}
+	return false, out
+}
+
+// The canary rollouts of new workload versions. Deleting a version that was rolled back lets the node accept it
+// again, the next agreements for it are canaries again.
+func (a *API) nodecanary(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/canary"
+
+	errorHandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		if canaries, err := persistence.FindWorkloadCanaries(a.db); err != nil {
+			errorHandler(NewSystemError(fmt.Sprintf("Error getting %v for output, error %v", resource, err)))
+		} else {
+			writeResponse(w, canaries, http.StatusOK)
+		}
+
+	case "DELETE":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		org, url, version := r.URL.Query().Get("org"), r.URL.Query().Get("url"), r.URL.Query().Get("version")
+		if org == "" || url == "" || version == "" {
+			errorHandler(NewAPIUserInputError("the org, url and version of the workload must be specified", "org, url, version"))
+			return
+		}
+
+		if canary, err := persistence.FindWorkloadCanary(a.db, org, url, version); err != nil {
+			errorHandler(NewSystemError(fmt.Sprintf("Error getting %v, error %v", resource, err)))
+			return
+		} else if canary == nil {
+			errorHandler(NewNotFoundError(fmt.Sprintf("version %v of %v/%v has no canary rollout", version, org, url), "version"))
+			return
+		} else if canary.State != persistence.CANARY_STATE_ROLLED_BACK {
+			errorHandler(NewConflictError(fmt.Sprintf("version %v of %v/%v is %v, only a version that was rolled back can be deleted", version, org, url, canary.State)))
+			return
+		} else if err := persistence.DeleteWorkloadCanary(a.db, org, url, version); err != nil {
+			errorHandler(NewSystemError(fmt.Sprintf("Unable to delete %v, error %v", resource, err)))
+			return
+		}
+
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handled %v on resource %v", r.Method, resource)))
+
+		w.WriteHeader(http.StatusNoContent)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// The maintenance schedule of the node. Service upgrades, the renegotiation of agreements after a change of the node
+// and the promotion of workload canaries wait for its windows. Without a schedule they run at any time.
+func (a *API) nodemaintenance(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/maintenance"
+
+	errorHandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		if schedule, err := persistence.FindMaintenanceSchedule(a.db); err != nil {
+			errorHandler(NewSystemError(fmt.Sprintf("Error getting %v for output, error %v", resource, err)))
+		} else if schedule == nil {
+			errorHandler(NewNotFoundError("the node has no maintenance schedule, disruptive actions run at any time", "maintenance"))
+		} else {
+			writeResponse(w, schedule, http.StatusOK)
+		}
+
+	case "PUT":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		var schedule persistence.MaintenanceSchedule
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &schedule); err != nil {
+			errorHandler(NewAPIUserInputError(fmt.Sprintf("Input body could not be deserialized to %v object: %v, error: %v", resource, string(body), err), "body"))
+			return
+		} else if err := schedule.Validate(); err != nil {
+			errorHandler(NewAPIUserInputError(err.Error(), "body"))
+			return
+		}
+
+		// a window opened by an operator stays open, it is only changed through the override
+		schedule.OverrideUntil = 0
+		if existing, err := persistence.FindMaintenanceSchedule(a.db); err != nil {
+			errorHandler(NewSystemError(fmt.Sprintf("Error getting %v, error %v", resource, err)))
+			return
+		} else if existing != nil {
+			schedule.OverrideUntil = existing.OverrideUntil
+		}
+
+		if err := persistence.SaveMaintenanceSchedule(a.db, &schedule); err != nil {
+			errorHandler(NewSystemError(fmt.Sprintf("Unable to save %v, error %v", resource, err)))
+			return
+		}
+
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handled %v on resource %v", r.Method, resource)))
+
+		writeResponse(w, schedule, http.StatusCreated)
+
+	case "DELETE":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		// the deferred actions run the next time the governance worker looks at them
+		if err := persistence.DeleteMaintenanceSchedule(a.db); err != nil {
+			errorHandler(NewSystemError(fmt.Sprintf("Unable to delete %v, error %v", resource, err)))
+			return
+		}
+
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handled %v on resource %v", r.Method, resource)))
+
+		w.WriteHeader(http.StatusNoContent)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, PUT, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Open an ad-hoc maintenance window for the given number of minutes, or close it before its time.
+func (a *API) nodemaintenanceoverride(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/maintenance/override"
+
+	errorHandler := GetHTTPErrorHandler(w)
+
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Allow", "POST, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+		return
+	} else if r.Method != "POST" && r.Method != "DELETE" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+	schedule, err := persistence.FindMaintenanceSchedule(a.db)
+	if err != nil {
+		errorHandler(NewSystemError(fmt.Sprintf("Error getting node/maintenance, error %v", err)))
+		return
+	} else if schedule == nil {
+		errorHandler(NewConflictError("the node has no maintenance schedule, disruptive actions run at any time"))
+		return
+	}
+
+	var meta *persistence.MessageMeta
+	var code string
+	if r.Method == "POST" {
+		minutes, err := strconv.Atoi(r.URL.Query().Get("minutes"))
+		if err != nil || minutes <= 0 {
+			errorHandler(NewAPIUserInputError("the number of minutes that the window is open must be a positive integer", "minutes"))
+			return
+		}
+		schedule.OverrideUntil = uint64(time.Now().Add(time.Duration(minutes) * time.Minute).Unix())
+		meta, code = persistence.NewMessageMeta(EL_API_MAINTENANCE_WINDOW_OPENED, minutes), persistence.EC_MAINTENANCE_WINDOW_OPENED
+	} else {
+		schedule.OverrideUntil = 0
+		meta, code = persistence.NewMessageMeta(EL_API_MAINTENANCE_WINDOW_CLOSED), persistence.EC_MAINTENANCE_WINDOW_CLOSED
+	}
+
+	if err := persistence.SaveMaintenanceSchedule(a.db, schedule); err != nil {
+		errorHandler(NewSystemError(fmt.Sprintf("Unable to save node/maintenance, error %v", err)))
+		return
+	}
+
+	if pDevice, err := persistence.FindExchangeDevice(a.db); err != nil {
+		glog.Errorf(apiLogString(fmt.Sprintf("Unable to read node object, error %v", err)))
+	} else if pDevice != nil {
+		LogDeviceEvent(a.db, persistence.SEVERITY_INFO, meta, code, pDevice)
+	}
+
+	glog.V(5).Infof(apiLogString(fmt.Sprintf("Handled %v on resource %v", r.Method, resource)))
+
+	writeResponse(w, schedule, http.StatusOK)
+}
+
+// The shortest time between two node syncs requested through the API.
+const NODE_SYNC_MIN_INTERVAL = 10 * time.Second
+
+// How long a node sync waits for each synchronization before it is reported as timed out. It is a variable so that the
+// tests do not wait.
+var NODE_SYNC_TIMEOUT = 30 * time.Second
+
+// The outcome of a node sync, one result for each synchronization with the exchange.
+type NodeSyncOutput struct {
+	StartTime uint64              `json:"start_time"`
+	Results   []events.SyncResult `json:"results"`
+}
+
+// Run the synchronizations of the node with the exchange now, rather than waiting for the next poll: the heartbeat and
+// the exchange changes, the node with its user input and pattern, the node policy, and the exchange version.
+func (a *API) nodesync(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/sync"
+
+	errorHandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "POST":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		if a.GetExchangeToken() == "" {
+			errorHandler(NewConflictError("the node is not registered with the exchange"))
+			return
+		}
+
+		a.nodeSyncLock.Lock()
+		if since := time.Since(a.lastNodeSync); since < NODE_SYNC_MIN_INTERVAL {
+			a.nodeSyncLock.Unlock()
+			errorHandler(NewServiceUnavailableError(fmt.Sprintf("the node was synchronized %v ago, try again in %v", since.Round(time.Second), (NODE_SYNC_MIN_INTERVAL - since).Round(time.Second))))
+			return
+		}
+		a.lastNodeSync = time.Now()
+		a.nodeSyncLock.Unlock()
+
+		output := NodeSyncOutput{StartTime: uint64(time.Now().Unix())}
+
+		// the workers synchronize while the exchange version is checked, all of them within the timeout
+		ctx, cancel := context.WithTimeout(r.Context(), NODE_SYNC_TIMEOUT)
+		defer cancel()
+
+		syncs := []string{events.SYNC_HEARTBEAT, events.SYNC_NODE, events.SYNC_NODE_POLICY}
+		msg := events.NewNodeSyncMessage(events.NODE_SYNC, len(syncs))
+		a.Messages() <- msg
+
+		versionResults := make(chan events.SyncResult, 1)
+		go func(httpFactory *config.HTTPClientFactory, url string, id string, token string) {
+			result := events.SyncResult{Name: events.SYNC_EXCHANGE_VERSION, Summary: "the exchange version is supported"}
+			if err := version.VerifyExchangeVersion(httpFactory, url, id, token, false); err != nil {
+				result.Summary = ""
+				result.Error = err.Error()
+			}
+			versionResults <- result
+		}(a.GetHTTPFactory().WithContext(ctx), a.GetExchangeURL(), a.GetExchangeId(), a.GetExchangeToken())
+
+		results := make(map[string]events.SyncResult)
+		for waiting := true; waiting && len(results) < len(syncs)+1; {
+			select {
+			case result := <-msg.Results:
+				results[result.Name] = result
+			case result := <-versionResults:
+				results[result.Name] = result
+			case <-ctx.Done():
+				waiting = false
+			}
+		}
+
+		for _, name := range append(syncs, events.SYNC_EXCHANGE_VERSION) {
+			if result, ok := results[name]; ok {
+				output.Results = append(output.Results, result)
+			} else if name == events.SYNC_EXCHANGE_VERSION {
+				// the check of the version is stopped with the request
+				glog.Warningf(apiLogString(fmt.Sprintf("node sync %v did not complete within %v", name, NODE_SYNC_TIMEOUT)))
+				output.Results = append(output.Results, events.SyncResult{Name: name, Error: fmt.Sprintf("did not complete within %v", NODE_SYNC_TIMEOUT)})
+			} else {
+				glog.Warningf(apiLogString(fmt.Sprintf("node sync %v did not complete within %v", name, NODE_SYNC_TIMEOUT)))
+				output.Results = append(output.Results, events.SyncResult{Name: name, Error: fmt.Sprintf("did not complete within %v, it keeps running in the background", NODE_SYNC_TIMEOUT)})
+			}
+		}
+
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handled %v on resource %v: %v", r.Method, resource, output.Results)))
+		writeResponse(w, output, http.StatusOK)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "POST, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Check the If-Match header of a change of the node or of its config state against the ETag of the node. The caller
+// holds configstateLock.
+func (a *API) checkDeviceIfMatch(ifMatch string, resource string) error {
+	if ifMatch == "" {
+		return nil
+	}
+	etag, err := FindDeviceETag(a.db)
+	if err != nil {
+		return err
+	}
+	return checkIfMatch(ifMatch, etag, resource)
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/tpm"
+)
+
+// An attestation quote of the node. The attestation key signature binds the TPM's attestation key to the node: it is
+// the RSA-PSS SHA-256 signature of the DER encoded attestation key by the messaging key of the node, whose public key
+// is registered in the exchange.
+type NodeQuote struct {
+	tpm.Quote
+	Nonce                   string `json:"nonce"`
+	AttestationKeySignature []byte `json:"attestation_key_signature"`
+}
+
+// The state of the node's TPM.
+func (a *API) nodetpm(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/tpm"
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+		writeResponse(w, tpm.GetStatus(), http.StatusOK)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// A quote of the PCRs of the node's TPM, for a verifier that chooses the nonce.
+func (a *API) nodetpmquote(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/tpm/quote"
+
+	errorHandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		t := tpm.GetTPM()
+		if t == nil || tpm.GetStatus().State != tpm.STATE_ACTIVE {
+			errorHandler(NewNotFoundError(fmt.Sprintf("the node has no usable TPM, the TPM state is %v", tpm.GetStatus().State), "tpm"))
+			return
+		}
+
+		nonce, err := hex.DecodeString(r.URL.Query().Get("nonce"))
+		if err != nil || len(nonce) == 0 || len(nonce) > tpm.MAX_NONCE_SIZE {
+			errorHandler(NewAPIUserInputError(fmt.Sprintf("the nonce must be 1 to %v hex encoded bytes", tpm.MAX_NONCE_SIZE), "nonce"))
+			return
+		}
+
+		quote, err := t.Quote(nonce, a.Config.Edge.TPM.GetPCRs())
+		if err != nil {
+			errorHandler(NewSystemError(fmt.Sprintf("Unable to get a quote from the TPM, error %v", err)))
+			return
+		}
+
+		_, messagingKey, err := exchange.GetKeys("")
+		if err != nil {
+			errorHandler(NewSystemError(fmt.Sprintf("Unable to get the messaging key of the node, error %v", err)))
+			return
+		}
+		block, _ := pem.Decode([]byte(quote.AttestationKey))
+		hash := sha256.Sum256(block.Bytes)
+		sig, err := rsa.SignPSS(rand.Reader, messagingKey, crypto.SHA256, hash[:], nil)
+		if err != nil {
+			errorHandler(NewSystemError(fmt.Sprintf("Unable to sign the attestation key, error %v", err)))
+			return
+		}
+
+		writeResponse(w, NodeQuote{Quote: *quote, Nonce: hex.EncodeToString(nonce), AttestationKeySignature: sig}, http.StatusOK)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -2,10 +2,12 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
+	"github.com/open-horizon/anax/container"
 	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
@@ -35,12 +37,15 @@ func (a *API) service(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+		glog.V(5).Infof(apiRequestLogString(r.Context(), fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
 
 		// Gather all the service info from the database and format for output.
-		if out, err := FindServicesForOutput(a.pm, a.db, a.Config); err != nil {
+		if etag, err := FindServicesETag(a.db); err != nil {
+			errorhandler(err)
+		} else if out, err := FindServicesForOutput(a.pm, a.db, a.Config); err != nil {
 			errorhandler(NewSystemError(fmt.Sprintf("Error getting %v for output, error %v", resource, err)))
 		} else {
+			setETag(w, etag)
 			writeResponse(w, *out, http.StatusOK)
 		}
 
@@ -66,59 +71,52 @@ func (a *API) serviceconfig(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 
-		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+		glog.V(5).Infof(apiRequestLogString(r.Context(), fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
 
-		if out, err := FindServiceConfigForOutput(a.pm, a.db); err != nil {
+		if etag, err := FindServicesETag(a.db); err != nil {
+			errorhandler(err)
+		} else if out, err := FindServiceConfigForOutput(a.pm, a.db); err != nil {
 			errorhandler(NewSystemError(fmt.Sprintf("Error getting %v for output, error %v", resource, err)))
 		} else {
+			setETag(w, etag)
 			writeResponse(w, out, http.StatusOK)
 		}
 
 	case "POST":
-		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
-
-		getService := exchange.GetHTTPServiceHandler(a)
-		getPatterns := exchange.GetHTTPExchangePatternHandler(a)
-		resolveService := exchange.GetHTTPServiceDefResolverHandler(a)
-		getDevice := exchange.GetHTTPDeviceHandler(a)
-		patchDevice := exchange.GetHTTPPatchDeviceHandler(a)
+		glog.V(5).Infof(apiRequestLogString(r.Context(), fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
 
 		// Input should be: Service type w/ zero or more Attribute types
 		var service Service
 		body, _ := ioutil.ReadAll(r.Body)
 
-		decoder := json.NewDecoder(bytes.NewReader(body))
-		decoder.UseNumber()
-
-		if err := decoder.Decode(&service); err != nil {
-			errorhandler(NewAPIUserInputError(fmt.Sprintf("Input body couldn't be deserialized to %v object: %v, error: %v", resource, string(body), err), "service"))
+		if err := decodeInputBody(body, &service, "service"); err != nil {
+			errorhandler(err)
 			return
 		}
 
-		create_service_error_handler := func(err error) bool {
-			service_url := ""
-			if service.Url != nil {
-				service_url = *service.Url
+		// A conditional request is checked against the services the client read, under the lock of the config changes
+		// so that the autoconfig cannot change them between the check and the creation.
+		if ifMatch := r.Header.Get(IF_MATCH_HEADER); ifMatch != "" {
+			lockConfigstate()
+			defer unlockConfigstate()
+			if etag, err := FindServicesETag(a.db); err != nil {
+				errorhandler(err)
+				return
+			} else if err := checkIfMatch(ifMatch, etag, resource); err != nil {
+				errorhandler(err)
+				return
 			}
-			LogServiceEvent(a.db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_CONFIG_SVC, service_url, err.Error()), persistence.EC_ERROR_SERVICE_CONFIG, &service)
-			return errorhandler(err)
 		}
 
 		// Validate and create the service object and all of the service specific attributes in the body
 		// of the request.
-		errHandled, newService, msg := CreateService(&service, create_service_error_handler, getPatterns, resolveService, getService, getDevice, patchDevice, nil, a.db, a.Config, true)
-		if errHandled {
-			return
-		}
-
-		// Send the policy created message to the internal bus.
-		if msg != nil {
-			a.Messages() <- msg
+		if errHandled, newService := a.createService(r.Context(), &service, errorhandler); !errHandled {
+			if etag, err := FindServicesETag(a.db); err == nil {
+				setETag(w, etag)
+			}
+			writeResponse(w, newService, http.StatusCreated)
 		}
 
-		// Write the new service back to the caller.
-		writeResponse(w, newService, http.StatusCreated)
-
 	case "OPTIONS":
 		w.Header().Set("Allow", "GET, POST, OPTIONS")
 		w.WriteHeader(http.StatusOK)
@@ -127,6 +125,41 @@ func (a *API) serviceconfig(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Configure a service on the node, as for a POST on /service/config. Returns true if the error handler handled an
+// error, otherwise the configured service.
+func (a *API) createService(ctx context.Context, service *Service, errorhandler ErrorHandler) (bool, *Service) {
+
+	// The exchange requests are cancelled with the request.
+	ec := exchange.WithContext(ctx, a)
+	getService := getServiceWithContext(ctx, exchange.GetHTTPServiceHandler(ec))
+	getPatterns := getPatternsWithContext(ctx, exchange.GetHTTPExchangePatternHandler(ec))
+	resolveService := resolveServiceWithContext(ctx, exchange.GetHTTPServiceDefResolverHandler(ec))
+	getDevice := getDeviceWithContext(ctx, exchange.GetHTTPDeviceHandler(ec))
+	patchDevice := exchange.GetHTTPPatchDeviceHandler(a)
+
+	create_service_error_handler := func(err error) bool {
+		service_url := ""
+		if service.Url != nil {
+			service_url = *service.Url
+		}
+		LogServiceEvent(a.db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_CONFIG_SVC, service_url, err.Error()), persistence.EC_ERROR_SERVICE_CONFIG, service)
+		return errorhandler(err)
+	}
+
+	// Validate and create the service object and all of the service specific attributes.
+	errHandled, newService, msg := CreateService(ctx, service, create_service_error_handler, getPatterns, resolveService, getService, getDevice, patchDevice, nil, a.db, a.Config, events.POLICY_ORIGIN_USER, false)
+	if errHandled {
+		return true, nil
+	}
+
+	// Send the policy created message to the internal bus.
+	if msg != nil {
+		a.Messages() <- msg
+	}
+
+	return false, newService
+}
+
 // For gettting or changing the service configstate. The supported stated are "suspended" and "active"
 func (a *API) service_configstate(w http.ResponseWriter, r *http.Request) {
 
@@ -141,7 +174,7 @@ func (a *API) service_configstate(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 
-		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+		glog.V(5).Infof(apiRequestLogString(r.Context(), fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
 
 		getServicesConfigState := exchange.GetHTTPServicesConfigStateHandler(a)
 
@@ -154,7 +187,7 @@ func (a *API) service_configstate(w http.ResponseWriter, r *http.Request) {
 
 	case "POST":
 
-		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+		glog.V(5).Infof(apiRequestLogString(r.Context(), fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
 
 		var service_cs exchange.ServiceConfigState
 		body, _ := ioutil.ReadAll(r.Body)
@@ -222,7 +255,7 @@ func (a *API) servicepolicy(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 
-		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+		glog.V(5).Infof(apiRequestLogString(r.Context(), fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
 
 		// Gather all the policies from the local filesystem and format them for output.
 		if out, err := findPoliciesForOutput(a.pm, a.db); err != nil {
@@ -239,3 +272,124 @@ func (a *API) servicepolicy(w http.ResponseWriter, r *http.Request) {
 	}
 
 }
+
+// For retrieving the container logs of a workload (by agreement id) or a service (by service url).
+func (a *API) servicelogs(w http.ResponseWriter, r *http.Request) {
+
+	resource := "service/logs"
+	errorhandler := GetHTTPErrorHandler(w)
+
+	_, errWritten := a.existingDeviceOrError(w)
+	if errWritten {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+
+		if err := r.ParseForm(); err != nil {
+			errorhandler(NewAPIUserInputError(fmt.Sprintf("Error parsing the query parameters %v. %v", r.Form, err), "query"))
+			return
+		}
+
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v with query %v", r.Method, resource, r.Form)))
+
+		lr, err := NewLogRequest(r.Form)
+		if err != nil {
+			errorhandler(err)
+			return
+		}
+
+		client, err := container.NewContainerRuntime(a.Config)
+		if err != nil {
+			errorhandler(NewSystemError(fmt.Sprintf("Unable to create %v client from %v, error %v", a.Config.GetContainerRuntime(), a.Config.Edge.DockerEndpoint, err)))
+			return
+		}
+
+		containers, err := FindContainersForLogs(a.db, client, lr)
+		if err != nil {
+			errorhandler(NewSystemError(fmt.Sprintf("Error getting containers for %v, error %v", resource, err)))
+			return
+		} else if len(containers) == 0 {
+			errorhandler(NewNotFoundError("no containers found for the requested agreement or service", "agreement_id"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+
+		// The status has already been written, so errors can only be logged from here on.
+		if err := WriteContainerLogs(r.Context(), client, containers, lr, w); err != nil {
+			glog.Errorf(apiLogString(fmt.Sprintf("Error writing container logs for %v, error %v", lr, err)))
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+}
+
+// For retrieving the health of the service containers that have a health check.
+func (a *API) servicehealth(w http.ResponseWriter, r *http.Request) {
+
+	resource := "service/health"
+	errorhandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiRequestLogString(r.Context(), fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		health, err := persistence.FindContainerHealth(a.db)
+		if err != nil {
+			errorhandler(NewSystemError(fmt.Sprintf("Error getting %v for output, error %v", resource, err)))
+			return
+		}
+
+		writeResponse(w, health, http.StatusOK)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// For getting the status of the service instances on the node, or of one of them by its key: the containers that
+// implement it, the agreements and services that depend on it, its restarts and whether its cleanup is pending.
+func (a *API) serviceinstance(w http.ResponseWriter, r *http.Request) {
+
+	resource := "service/instance"
+	errorhandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiRequestLogString(r.Context(), fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		key := mux.Vars(r)["key"]
+		includeArchived := r.URL.Query().Get("archived") == "true"
+
+		out, err := FindServiceInstancesForOutput(a.db, a.Config, key, includeArchived)
+		if err != nil {
+			errorhandler(NewSystemError(fmt.Sprintf("Error getting %v for output, error %v", resource, err)))
+			return
+		}
+
+		if key == "" {
+			writeResponse(w, out, http.StatusOK)
+		} else if len(out) == 0 {
+			errorhandler(NewNotFoundError(fmt.Sprintf("service instance %v not found", key), "key"))
+		} else {
+			writeResponse(w, out[0], http.StatusOK)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -1,17 +1,98 @@
 package api
 
 import (
+	"fmt"
+	"github.com/golang/glog"
 	"github.com/open-horizon/anax/apicommon"
+	"github.com/open-horizon/anax/container"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/download"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/worker"
 	"net/http"
+	"time"
 )
 
+// How long the status waits to connect over each address family.
+const ADDRESS_FAMILY_CHECK_TIMEOUT = 3 * time.Second
+
 func (a *API) status(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 
 		info := apicommon.NewInfo(a.GetHTTPFactory(), a.GetExchangeURL(), a.GetCSSURL(), a.GetExchangeId(), a.GetExchangeToken())
 
+		// report the container runtime that runs the service containers on this node
+		if a.Config.Edge.DockerEndpoint != "" {
+			info.Configuration.ContainerRuntime = a.Config.GetContainerRuntime()
+			if client, err := container.NewContainerRuntime(a.Config); err != nil {
+				glog.Errorf(apiLogString(fmt.Sprintf("Unable to create %v client, error %v", info.Configuration.ContainerRuntime, err)))
+			} else {
+				info.Configuration.ContainerRuntimeVersion = container.RuntimeVersion(client)
+			}
+		}
+
+		info.Configuration.Features = a.Config.EffectiveFeatures()
+		info.Configuration.APIListeners = a.listeners
+
+		if hostAddress, err := cutil.SelectHostAddress(a.Config.LiveEdge().HostAddress); err != nil {
+			glog.Errorf(apiLogString(fmt.Sprintf("Unable to select the host address, error %v", err)))
+		} else {
+			info.Configuration.HostAddress = hostAddress
+		}
+
+		// whether IPv4 and IPv6 are functional on the node, for IPv6-only and dual-stack sites, by connecting to the exchange
+		if addrs, err := cutil.GetHostInterfaceAddresses(); err != nil {
+			glog.Errorf(apiLogString(fmt.Sprintf("Unable to get the host addresses, error %v", err)))
+		} else {
+			families := cutil.CheckAddressFamilies(addrs, a.GetExchangeURL(), ADDRESS_FAMILY_CHECK_TIMEOUT)
+			info.Configuration.AddressFamilies = &families
+		}
+
+		// whether a newer version of the agent is available, once it was checked
+		if agentUpdate, err := persistence.FindAgentUpdateStatus(a.db); err != nil {
+			glog.Errorf(apiLogString(fmt.Sprintf("Unable to read the agent update status, error %v", err)))
+		} else {
+			info.AgentUpdate = agentUpdate
+		}
+
+		// the canary rollouts of new workload versions, and the versions that were rolled back
+		if canaries, err := persistence.FindWorkloadCanaries(a.db); err != nil {
+			glog.Errorf(apiLogString(fmt.Sprintf("Unable to read the workload canaries, error %v", err)))
+		} else if len(canaries) != 0 {
+			info.Canaries = canaries
+		}
+
+		// the offline bundle that was installed at startup, if the node has one
+		if bundle, err := persistence.FindOfflineBundleStatus(a.db); err != nil {
+			glog.Errorf(apiLogString(fmt.Sprintf("Unable to read the offline bundle status, error %v", err)))
+		} else {
+			info.OfflineBundle = bundle
+		}
+
+		// the download rate limit that applies now and the downloads that are running
+		info.Downloads = download.GetStatus()
+
+		// the free space of the partitions of the images and the database
+		info.Disk = cutil.GetAgentDiskUsage(a.Config)
+
+		// how far the clock of the node is off the exchange, as measured on the exchange responses
+		if skew := exchange.GetClockSkew(); skew != nil {
+			live := a.Config.LiveEdge()
+			if limit := live.ClockSkew.GetWarnS(); skew.Exceeds(limit) {
+				skew.Warning = fmt.Sprintf("The clock of the node is %.0f seconds off the exchange, more than %v seconds. %v", skew.SkewS, limit, exchange.CLOCK_SKEW_HINT)
+			}
+			info.ClockSkew = skew
+		}
+
+		// the disruptive actions that wait for the next maintenance window
+		if deferred, err := persistence.FindDeferredActions(a.db); err != nil {
+			glog.Errorf(apiLogString(fmt.Sprintf("Unable to read the deferred actions, error %v", err)))
+		} else if len(deferred) != 0 {
+			info.Deferred = deferred
+		}
+
 		writeResponse(w, info, http.StatusOK)
 	case "OPTIONS":
 		w.Header().Set("Allow", "GET, OPTIONS")
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/persistence"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The longest summary of a request that is kept in the audit log.
+const AUDIT_SUMMARY_MAX_LEN = 1024
+
+// The value of the fields of a request that hold a secret in its audit summary.
+const AUDIT_REDACTED = "<redacted>"
+
+// The words in the name of a field that mark it as a secret, e.g. token or exchangePassword.
+var auditSecretWords = []string{"token", "password", "secret", "credential", "auth", "key"}
+
+// A response writer that keeps the status of the response and its error, for the audit log.
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+	err    error
+}
+
+func (a *auditWriter) WriteHeader(status int) {
+	if a.status == 0 {
+		a.status = status
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *auditWriter) Write(b []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	return a.ResponseWriter.Write(b)
+}
+
+// The response of a streamed request, or of POST /node/shutdown, is sent to the client before the handler returns.
+func (a *auditWriter) Flush() {
+	if f, ok := a.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Record the requests served by h that change the node in the audit log, once they have been served. A failure to
+// save the audit entry is logged, it does not fail the request.
+func (a *API) audit(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		maxEntries := a.Config.LiveEdge().AuditLogMaxEntries
+		if r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" || maxEntries <= 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// The body is read for the summary, and given back to the handler.
+		var body []byte
+		if r.Body != nil {
+			var err error
+			if body, err = ioutil.ReadAll(r.Body); err != nil {
+				glog.Errorf(apiLogString(fmt.Sprintf("unable to read the body of %v %v for the audit log, error %v", r.Method, r.URL.Path, err)))
+			}
+			r.Body.Close()
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		aw := &auditWriter{ResponseWriter: w}
+		h.ServeHTTP(aw, r)
+
+		entry := &persistence.AuditEntry{
+			Timestamp: uint64(time.Now().Unix()),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Client:    auditClient(r),
+			Summary:   auditSummary(r, body),
+			Status:    aw.status,
+			Outcome:   persistence.AUDIT_OUTCOME_SUCCESS,
+		}
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		if entry.Status >= http.StatusBadRequest {
+			entry.Outcome = persistence.AUDIT_OUTCOME_FAILURE
+			// The error of an invalid input is recorded as it is returned to the client, its message and the input
+			// it is about.
+			if inputErr, ok := aw.err.(*APIUserInputError); ok {
+				entry.Error = inputErr.Err
+				entry.Input = inputErr.Input
+			} else if aw.err != nil {
+				entry.Error = aw.err.Error()
+			} else {
+				entry.Error = http.StatusText(entry.Status)
+			}
+		}
+
+		if err := persistence.SaveAuditEntry(a.db, entry, maxEntries); err != nil {
+			glog.Errorf(apiLogString(fmt.Sprintf("unable to save the audit entry %v, error %v", entry, err)))
+		}
+	})
+}
+
+// The client of a request, its address and the subject of its verified certificate when it sent one.
+func auditClient(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) != 0 && len(r.TLS.VerifiedChains[0]) != 0 {
+		return fmt.Sprintf("%v %v", r.RemoteAddr, r.TLS.VerifiedChains[0][0].Subject.String())
+	}
+	return r.RemoteAddr
+}
+
+// The summary of a request for the audit log: its query parameters and the top-level fields of its json body. The
+// fields that hold a secret are redacted, and only the names of the fields that hold an object or an array are kept.
+func auditSummary(r *http.Request, body []byte) string {
+	parts := make([]string, 0, 5)
+
+	query := r.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%v=%v", name, auditValue(name, strings.Join(query[name], ","))))
+	}
+
+	var fields map[string]interface{}
+	if len(bytes.TrimSpace(body)) != 0 {
+		if err := json.Unmarshal(body, &fields); err != nil {
+			parts = append(parts, fmt.Sprintf("body of %v bytes", len(body)))
+		}
+	}
+	names = make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch v := fields[name].(type) {
+		case map[string]interface{}:
+			parts = append(parts, fmt.Sprintf("%v={...}", name))
+		case []interface{}:
+			parts = append(parts, fmt.Sprintf("%v=[%v]", name, len(v)))
+		default:
+			parts = append(parts, fmt.Sprintf("%v=%v", name, auditValue(name, fmt.Sprintf("%v", v))))
+		}
+	}
+
+	summary := strings.Join(parts, ", ")
+	if len(summary) > AUDIT_SUMMARY_MAX_LEN {
+		summary = summary[:AUDIT_SUMMARY_MAX_LEN] + "..."
+	}
+	return summary
+}
+
+// The value of a field in the audit summary, redacted when the field holds a secret.
+func auditValue(name string, value string) string {
+	lower := strings.ToLower(name)
+	for _, word := range auditSecretWords {
+		if strings.Contains(lower, word) {
+			return AUDIT_REDACTED
+		}
+	}
+	return value
+}
@@ -0,0 +1,90 @@
+// +build unit
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/worker"
+)
+
+func Test_audit(t *testing.T) {
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanTestDir(dir)
+
+	cfg := getBasicConfig()
+	cfg.Edge.AuditLogMaxEntries = 10
+	a := &API{Manager: worker.Manager{Config: cfg}, db: db}
+
+	// the handler sees the whole body, and fails the requests for another node
+	handler := a.audit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var device HorizonDevice
+		json.Unmarshal(body, &device)
+		if device.Id == nil || *device.Id != "mynode" {
+			GetHTTPErrorHandler(w)(NewAPIUserInputError("the node id is not valid", "device.id"))
+			return
+		}
+		writeResponse(w, device, http.StatusCreated)
+	}))
+
+	for _, body := range []string{
+		`{"id":"mynode","organization":"myorg","pattern":"mypattern","token":"s3cr3t"}`,
+		`{"id":"another","organization":"myorg"}`,
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/node", strings.NewReader(body)))
+		if strings.Contains(body, "mynode") && w.Code != http.StatusCreated {
+			t.Errorf("the handler should get the whole body, got status %v", w.Code)
+		}
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/node", nil))
+
+	entries, err := persistence.FindAuditEntries(db, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to read the audit log, error %v", err)
+	} else if len(entries) != 2 {
+		t.Fatalf("only the 2 POST requests should be audited, got %v", entries)
+	}
+
+	if e := entries[0]; e.Outcome != persistence.AUDIT_OUTCOME_FAILURE || e.Status != http.StatusBadRequest || e.Error != "the node id is not valid" || e.Input != "device.id" {
+		t.Errorf("the newest entry should be the failed request, got %v", e)
+	}
+	if e := entries[1]; e.Outcome != persistence.AUDIT_OUTCOME_SUCCESS || e.Status != http.StatusCreated || e.Path != "/node" {
+		t.Errorf("the oldest entry should be the successful request, got %v", e)
+	} else if e.Summary != "id=mynode, organization=myorg, pattern=mypattern, token="+AUDIT_REDACTED {
+		t.Errorf("the summary should redact the token, got %v", e.Summary)
+	}
+}
+
+// The audit log is not kept when AuditLogMaxEntries is 0, and the requests are served as usual.
+func Test_audit_disabled(t *testing.T) {
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanTestDir(dir)
+
+	a := &API{Manager: worker.Manager{Config: &config.HorizonConfig{}}, db: db}
+	handler := a.audit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("DELETE", "/node", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("the request should be served, got status %v", w.Code)
+	} else if entries, err := persistence.FindAuditEntries(db, 0, 0); err != nil || len(entries) != 0 {
+		t.Errorf("the audit log should be empty, got %v, error %v", entries, err)
+	}
+}
@@ -0,0 +1,397 @@
+// Package client is a Go client of the agent API that the anax agent serves on the node, for the tools that drive the
+// agent from a program rather than through the hzn CLI. It sends and receives the structs of the api package, and
+// returns the errors of the agent as the error types of the api package, so that the callers can switch on them.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-horizon/anax/api"
+	"github.com/open-horizon/anax/persistence"
+)
+
+// The address that the agent API listens on by default.
+const DefaultBaseURL = "http://localhost:8510"
+
+// The default timeout of a request. Changing the node to configured can take minutes, the services of its pattern are
+// configured before the response is returned.
+const DefaultTimeout = 5 * time.Minute
+
+// A client of the agent API.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// An option of the client.
+type Option func(*Client)
+
+// The URL of the agent API, e.g. http://localhost:8510, the default is DefaultBaseURL.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// The longest time that a request can take, the default is DefaultTimeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.httpClient.Timeout = timeout
+	}
+}
+
+// The HTTP client that sends the requests, e.g. one with the TLS config of an agent API listener that serves TLS. Its
+// timeout is used, unless WithTimeout comes after it.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// Returns a client of the agent API.
+func New(opts ...Option) *Client {
+	c := &Client{
+		baseURL:    DefaultBaseURL,
+		httpClient: &http.Client{Timeout: DefaultTimeout},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Returns the node, as registered with the agent.
+func (c *Client) GetDevice() (*api.HorizonDevice, error) {
+	var device api.HorizonDevice
+	if err := c.do("GET", "/node", nil, &device); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// Returns the config state of the node.
+func (c *Client) GetConfigstate() (*api.Configstate, error) {
+	var cfg api.Configstate
+	if err := c.do("GET", "/node/configstate", nil, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Returns the services that the autoconfig registered the last time the node was changed to configured. With check,
+// the agent reads the node's pattern from the exchange and sets Stale when it changed since.
+func (c *Client) GetConfigstateResolution(check bool) (*api.ConfigstateResolutionOutput, error) {
+	path := "/node/configstate/resolution"
+	if check {
+		path += "?check=true"
+	}
+	var out api.ConfigstateResolutionOutput
+	if err := c.do("GET", path, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Returns the last comparison of the services registered on the node with the ones its pattern requires.
+func (c *Client) GetServiceReconciliation() (*persistence.ServiceReconciliation, error) {
+	var out persistence.ServiceReconciliation
+	if err := c.do("GET", "/node/reconcile", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Returns the outcome of the checks of the connectivity of the node to the exchange, and to the image registry of the
+// agent config. The checks that failed have their error.
+func (c *Client) GetConnectivity() (*api.ConnectivityOutput, error) {
+	var out api.ConnectivityOutput
+	if err := c.do("GET", "/node/connectivity", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// An option of a change of the config state, see SetConfigstate.
+type ConfigstateOption func(*api.Configstate)
+
+// Cancel the agreements without waiting for them to end gracefully, when the node is changed back to configuring.
+func Force() ConfigstateOption {
+	return func(cfg *api.Configstate) {
+		force := true
+		cfg.Force = &force
+	}
+}
+
+// Only report the services that changing the node to configured would register, without changing anything.
+func DryRun() ConfigstateOption {
+	return func(cfg *api.Configstate) {
+		dryRun := true
+		cfg.DryRun = &dryRun
+	}
+}
+
+// Register some of the services of the pattern with a version range, by org/url, when the node is changed to configured.
+func Versions(versions map[string]string) ConfigstateOption {
+	return func(cfg *api.Configstate) {
+		cfg.Versions = versions
+	}
+}
+
+// Configure the node even when the pattern has no services for its hardware architectures.
+func AllowEmpty() ConfigstateOption {
+	return func(cfg *api.Configstate) {
+		allowEmpty := true
+		cfg.AllowEmpty = &allowEmpty
+	}
+}
+
+// Skip the given services in the autoconfig of the pattern, none when there are no services. They can only be changed
+// while the node is configuring.
+func ExcludeServices(services ...persistence.ServiceSpec) ConfigstateOption {
+	return func(cfg *api.Configstate) {
+		excluded := persistence.ServiceSpecs(services)
+		if excluded == nil {
+			excluded = persistence.ServiceSpecs{}
+		}
+		cfg.ExcludedServices = &excluded
+	}
+}
+
+// Skip the given top-level services of the pattern in the autoconfig when they cannot be resolved, rather than failing
+// it, none when there are no services. They can only be changed while the node is configuring.
+func OptionalServices(services ...persistence.ServiceSpec) ConfigstateOption {
+	return func(cfg *api.Configstate) {
+		optional := persistence.ServiceSpecs(services)
+		if optional == nil {
+			optional = persistence.ServiceSpecs{}
+		}
+		cfg.OptionalServices = &optional
+	}
+}
+
+// Check the connectivity of the node to the exchange first, the change fails right away when it cannot reach it.
+func CheckConnectivity() ConfigstateOption {
+	return func(cfg *api.Configstate) {
+		check := true
+		cfg.CheckConnectivity = &check
+	}
+}
+
+// Change the node to configured at the given time, it is configured_pending until then.
+func EffectiveTime(t time.Time) ConfigstateOption {
+	return func(cfg *api.Configstate) {
+		effectiveTime := uint64(t.Unix())
+		cfg.EffectiveTime = &effectiveTime
+	}
+}
+
+// Change the config state of the node to state, configured or configuring, and return the new config state, or the
+// outcome of a dry run.
+func (c *Client) SetConfigstate(state string, opts ...ConfigstateOption) (*api.Configstate, error) {
+	in := api.Configstate{State: &state}
+	for _, opt := range opts {
+		opt(&in)
+	}
+
+	var out api.Configstate
+	if err := c.do("PUT", "/node/configstate", &in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Configure a service on the node, as hzn service config does, and return it as configured.
+func (c *Client) CreateService(service *api.Service) (*api.Service, error) {
+	var out api.Service
+	if err := c.do("POST", "/service/config", service, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Returns the configuration of the node as a single document, without its secrets, see ImportNode.
+func (c *Client) ExportNode() (*api.NodeExport, error) {
+	var out api.NodeExport
+	if err := c.do("GET", "/node/export", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Import the configuration exported from a node into this node, which must be registered and configuring, and return
+// it as imported. The secrets of the document must be set. Either all of it is imported, or nothing is and a
+// MultiInputError has the problems of the document.
+func (c *Client) ImportNode(doc *api.NodeExport) (*api.NodeExport, error) {
+	var out api.NodeExport
+	if err := c.do("POST", "/node/import", doc, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Shut the agent down once the changes of the node configuration that are running complete, or are cancelled after the
+// grace period of the agent, and return the operations it waited for. The agent then exits.
+func (c *Client) Shutdown() (*api.ShutdownResult, error) {
+	var out api.ShutdownResult
+	if err := c.do("POST", "/node/shutdown", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Send a request with the given body, serialized if it is not nil, and deserialize the response into out. An error
+// response is returned as the error of the api package that the agent returned.
+func (c *Client) do(method string, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		serial, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("unable to serialize the %v %v body %v, error %v", method, path, in, err)
+		}
+		body = bytes.NewReader(serial)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("unable to create the %v %v request, error %v", method, path, err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// the errors that only have a message are written as text otherwise, without their ERR_ code
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to send the %v %v request, error %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read the response of %v %v, error %v", method, path, err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return responseError(resp.StatusCode, resp.Header, respBody)
+	}
+	if out != nil && len(respBody) != 0 {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return fmt.Errorf("unable to deserialize the response of %v %v: %v, error %v", method, path, string(respBody), err)
+		}
+	}
+	return nil
+}
+
+// Returns the error of the api package that an error response was written for. The agents that do not send the error
+// code are handled from the status of the response. The ERR_ code of the error is in the code field of its body, the
+// older agents write the errors without an input as text, even when the request accepts json.
+func responseError(status int, header http.Header, body []byte) error {
+	msg := strings.TrimSpace(string(body))
+	code := header.Get(api.ERROR_CODE_HEADER)
+	reason := ""
+
+	var msgErr struct {
+		Err  string `json:"error"`
+		Code string `json:"code"`
+	}
+	if isJSONObject(body) && json.Unmarshal(body, &msgErr) == nil {
+		msg, reason = msgErr.Err, msgErr.Code
+	}
+
+	if code == "" {
+		switch status {
+		case http.StatusBadRequest:
+			code = api.ERROR_CODE_BAD_REQUEST
+			if isJSONObject(body) {
+				code = api.ERROR_CODE_USER_INPUT
+			}
+		case http.StatusNotFound:
+			code = api.ERROR_CODE_NOT_FOUND
+		case http.StatusConflict:
+			code = api.ERROR_CODE_CONFLICT
+		case http.StatusPreconditionFailed:
+			code = api.ERROR_CODE_PRECONDITION_FAILED
+		case http.StatusUnauthorized:
+			code = api.ERROR_CODE_UNAUTHORIZED
+		case http.StatusServiceUnavailable:
+			code = api.ERROR_CODE_SERVICE_UNAVAILABLE
+		case http.StatusTooManyRequests:
+			code = api.ERROR_CODE_TOO_MANY_REQUESTS
+		default:
+			code = api.ERROR_CODE_SYSTEM
+		}
+	}
+
+	// The errors with an input are read as is, the others from their message and code.
+	var jsonErr error
+	switch code {
+	case api.ERROR_CODE_USER_INPUT:
+		e := new(api.APIUserInputError)
+		if jsonErr = json.Unmarshal(body, e); jsonErr == nil {
+			return e
+		}
+	case api.ERROR_CODE_TYPE_MISMATCH:
+		e := new(api.TypeMismatchError)
+		if jsonErr = json.Unmarshal(body, e); jsonErr == nil {
+			return e
+		}
+	case api.ERROR_CODE_MISSING_VARIABLE:
+		e := new(api.MSMissingVariableConfigError)
+		if jsonErr = json.Unmarshal(body, e); jsonErr == nil {
+			return e
+		}
+	case api.ERROR_CODE_DUPLICATE_SERVICE:
+		e := new(api.DuplicateServiceError)
+		if jsonErr = json.Unmarshal(body, e); jsonErr == nil {
+			return e
+		}
+	case api.ERROR_CODE_MULTI_SERVICE:
+		e := new(api.MultiServiceConfigError)
+		if jsonErr = json.Unmarshal(body, e); jsonErr == nil {
+			return e
+		}
+	case api.ERROR_CODE_MULTI_INPUT:
+		e := new(api.MultiInputError)
+		if jsonErr = json.Unmarshal(body, e); jsonErr == nil {
+			return e
+		}
+	case api.ERROR_CODE_NOT_FOUND:
+		e := new(api.NotFoundError)
+		if jsonErr = json.Unmarshal(body, e); jsonErr == nil {
+			return e
+		}
+	case api.ERROR_CODE_CONFLICT:
+		return api.NewConflictError(msg).WithCode(reason)
+	case api.ERROR_CODE_PRECONDITION_FAILED:
+		return api.NewPreconditionFailedError(msg, header.Get("ETag")).WithCode(reason)
+	case api.ERROR_CODE_UNAUTHORIZED:
+		return api.NewUnauthorizedError(msg).WithCode(reason)
+	case api.ERROR_CODE_BAD_REQUEST:
+		return api.NewBadRequestError(msg).WithCode(reason)
+	case api.ERROR_CODE_SERVICE_UNAVAILABLE:
+		return api.NewServiceUnavailableError(msg).WithCode(reason)
+	case api.ERROR_CODE_TOO_MANY_REQUESTS:
+		seconds, _ := strconv.Atoi(header.Get("Retry-After"))
+		return api.NewTooManyRequestsError(msg, time.Duration(seconds)*time.Second).WithCode(reason)
+	case api.ERROR_CODE_SYSTEM:
+		return api.NewSystemError(msg).WithCode(reason)
+	}
+
+	if jsonErr != nil {
+		return api.NewSystemError(fmt.Sprintf("unable to deserialize the %v error response with status %v: %v, error %v", code, status, msg, jsonErr))
+	}
+	return api.NewSystemError(fmt.Sprintf("status %v, error %v", status, msg))
+}
+
+func isJSONObject(body []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(body), []byte("{"))
+}
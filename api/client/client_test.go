@@ -0,0 +1,117 @@
+// +build unit
+
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/open-horizon/anax/api"
+	"github.com/open-horizon/anax/persistence"
+)
+
+func Test_SetConfigstate(t *testing.T) {
+	var received api.Configstate
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.URL.Path != "/node/configstate" {
+			t.Errorf("unexpected request %v %v", r.Method, r.URL.Path)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &received); err != nil {
+			t.Errorf("unable to decode the body %v, error %v", string(body), err)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"state":"configured","last_update_time":1600000000,"last_update_time_utc":"2020-09-13T12:26:40Z"}`))
+	}))
+	defer server.Close()
+
+	c := New(WithBaseURL(server.URL+"/"), WithTimeout(time.Second))
+	cfg, err := c.SetConfigstate(persistence.CONFIGSTATE_CONFIGURED, Versions(map[string]string{"myorg/svc": "[1.0.0,2.0.0)"}), EffectiveTime(time.Unix(1700000000, 0)))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if *cfg.State != persistence.CONFIGSTATE_CONFIGURED || *cfg.LastUpdateTime != 1600000000 {
+		t.Errorf("wrong config state returned %v", cfg)
+	}
+
+	if *received.State != persistence.CONFIGSTATE_CONFIGURED {
+		t.Errorf("the state should be sent, got %v", received)
+	} else if received.Versions["myorg/svc"] != "[1.0.0,2.0.0)" {
+		t.Errorf("the versions should be sent, got %v", received.Versions)
+	} else if received.EffectiveTime == nil || *received.EffectiveTime != 1700000000 {
+		t.Errorf("the effective time should be sent, got %v", received.EffectiveTime)
+	} else if received.Force != nil || received.DryRun != nil {
+		t.Errorf("force and dryrun should not be sent, got %v and %v", received.Force, received.DryRun)
+	}
+}
+
+// The errors written by the error handler of the agent API are returned as the same error types.
+func Test_error_round_trip(t *testing.T) {
+	errs := []error{
+		api.NewAPIUserInputError("bad state", "configstate.state"),
+		api.NewAPIUserInputError("bad state", "configstate.state").WithCode(api.ERR_INVALID_STATE),
+		api.NewMSMissingVariableConfigError("variable var1 is not set", "variables").WithCode(api.ERR_MISSING_VARIABLE),
+		api.NewTypeMismatchError("wrong node type", "service").WithCode(api.ERR_NODE_TYPE_MISMATCH),
+		api.NewDuplicateServiceError("already configured", "service.url").WithCode(api.ERR_SERVICE_ALREADY_CONFIGURED),
+		api.NewMultiServiceConfigError("2 services cannot be configured", "configstate.state", []api.ServiceConfigProblem{
+			api.ServiceConfigProblem{Url: "svc", Org: "myorg", Version: "1.0.0", Err: "no user input", Code: api.ERR_MISSING_VARIABLE},
+		}).WithCode(api.ERR_SERVICE_CONFIG),
+		api.NewMultiInputError("1 problems were found in the node configuration, nothing was imported.", "import", []api.InputProblem{
+			api.InputProblem{Input: "import.attributes[0]", Err: "missing key", Code: api.ERR_INVALID_INPUT},
+		}).WithCode(api.ERR_INVALID_INPUT),
+		api.NewNotFoundError("node not registered", "node"),
+		api.NewNotFoundError("node not registered", "node").WithCode(api.ERR_NODE_NOT_REGISTERED),
+		api.NewSystemError("the database failed"),
+		api.NewSystemError("the database failed").WithCode(api.ERR_DATABASE),
+		api.NewConflictError("another change is in progress"),
+		api.NewBadRequestError("INVALID_NODE_STATE"),
+		api.NewServiceUnavailableError("not enough disk space").WithCode(api.ERR_DISK_SPACE),
+		api.NewTooManyRequestsError("the node configuration is changed too often", 5*time.Second).WithCode(api.ERR_RATE_LIMITED),
+	}
+
+	for _, expected := range errs {
+		server := httptest.NewServer(api.NegotiateErrorFormat(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			api.GetHTTPErrorHandler(w)(expected)
+		})))
+
+		_, err := New(WithBaseURL(server.URL)).GetConfigstate()
+		server.Close()
+
+		if !reflect.DeepEqual(err, expected) {
+			t.Errorf("expected (%T) %v, got (%T) %v", expected, expected, err, err)
+		}
+	}
+}
+
+// The errors of an agent that does not send the error code are returned from the status of the response.
+func Test_error_without_code(t *testing.T) {
+	tests := []struct {
+		status   int
+		body     string
+		expected error
+	}{
+		{http.StatusBadRequest, `{"error":"bad state","input":"configstate.state"}`, api.NewAPIUserInputError("bad state", "configstate.state")},
+		{http.StatusBadRequest, "INVALID_NODE_STATE\n", api.NewBadRequestError("INVALID_NODE_STATE")},
+		{http.StatusNotFound, `{"error":"node not registered","input":"node"}`, api.NewNotFoundError("node not registered", "node")},
+		{http.StatusInternalServerError, "the database failed\n", api.NewSystemError("the database failed")},
+		{http.StatusBadGateway, "bad gateway", api.NewSystemError("bad gateway")},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+
+		_, err := New(WithBaseURL(server.URL)).GetDevice()
+		server.Close()
+
+		if !reflect.DeepEqual(err, test.expected) {
+			t.Errorf("status %v: expected (%T) %v, got (%T) %v", test.status, test.expected, test.expected, err, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+	"strconv"
+	"strings"
+)
+
+// Returns the version choices of a top-level service of the pattern that are in the channel of the node, all of them
+// when the node has no channel. A choice is in the channel when its channel is the node's, or when it has none and its
+// priority is the node's channel, e.g. 1 for the highest priority. An APIUserInputError that names the channel and the
+// choices of the service is returned when none of them are in the channel.
+func channelChoices(service exchange.ServiceReference, channel string) ([]exchange.WorkloadChoice, error) {
+	if channel == "" {
+		return service.ServiceVersions, nil
+	}
+
+	choices := make([]exchange.WorkloadChoice, 0, len(service.ServiceVersions))
+	available := make([]string, 0, len(service.ServiceVersions))
+	for _, choice := range service.ServiceVersions {
+		if choice.Channel == channel || (choice.Channel == "" && strconv.Itoa(choice.Priority.PriorityValue) == channel) {
+			choices = append(choices, choice)
+		}
+		available = append(available, choiceChannelString(choice))
+	}
+
+	if len(choices) == 0 {
+		return nil, NewAPIUserInputError(fmt.Sprintf("None of the version choices of service %v/%v are in the channel %v of the node, the choices are: %v.", service.ServiceOrg, service.ServiceURL, channel, strings.Join(available, ", ")), "configstate.channel").WithCode(ERR_NO_CHANNEL_CHOICE)
+	}
+	return choices, nil
+}
+
+// The channel of the autoconfig of a pattern. The autoconfig manifest has a single version of each service, it is not
+// filtered by the channel of the node.
+func patternChannel(channel string, fromManifest bool) string {
+	if fromManifest {
+		return ""
+	}
+	return channel
+}
+
+// A version choice for the messages, with its channel or else its priority.
+func choiceChannelString(choice exchange.WorkloadChoice) string {
+	if choice.Channel != "" {
+		return fmt.Sprintf("%v (channel %v)", choice.Version, choice.Channel)
+	}
+	return fmt.Sprintf("%v (priority %v)", choice.Version, choice.Priority.PriorityValue)
+}
+
+// Set the channel that the configstate PUT body asks for on the node, before the node is changed to the requested state
+// so that the autoconfig resolves its choices. It can only be changed while the node is configuring, the autoconfig of
+// a configured node already ran with the one the node had. pDevice is updated with it.
+func updateChannel(cfg *Configstate, pDevice *persistence.ExchangeDevice, db *bolt.DB) error {
+	channel := strings.TrimSpace(*cfg.Channel)
+	if channel == pDevice.Config.Channel {
+		return nil
+	}
+
+	if pDevice.Config.State != persistence.CONFIGSTATE_CONFIGURING {
+		return NewAPIUserInputError(fmt.Sprintf("The channel cannot be changed while the node is '%v', the autoconfig already configured the services of the channel '%v'. Change the node to '%v', and then to '%v' with the new channel to run the autoconfig again.", pDevice.Config.State, pDevice.Config.Channel, persistence.CONFIGSTATE_CONFIGURING, persistence.CONFIGSTATE_CONFIGURED), "configstate.channel").WithCode(ERR_INVALID_STATE)
+	}
+
+	if _, err := pDevice.SetChannel(db, pDevice.Id, channel); err != nil {
+		return NewSystemError(fmt.Sprintf("error persisting the channel %v, error %v", channel, err)).WithCode(ERR_DATABASE)
+	}
+	pDevice.Config.Channel = channel
+	return nil
+}
@@ -0,0 +1,124 @@
+// +build unit
+
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+)
+
+func Test_channelChoices(t *testing.T) {
+	service := exchange.ServiceReference{
+		ServiceURL: "wurl",
+		ServiceOrg: "myorg",
+		ServiceVersions: []exchange.WorkloadChoice{
+			{Version: "1.0.0", Channel: "stable"},
+			{Version: "2.0.0", Channel: "beta"},
+			{Version: "0.9.0", Priority: exchange.WorkloadPriority{PriorityValue: 3}},
+		},
+	}
+
+	if choices, err := channelChoices(service, ""); err != nil || len(choices) != 3 {
+		t.Errorf("all the choices should be in no channel, got %v, error %v", choices, err)
+	}
+	if choices, err := channelChoices(service, "stable"); err != nil || len(choices) != 1 || choices[0].Version != "1.0.0" {
+		t.Errorf("only the stable choice should be in the stable channel, got %v, error %v", choices, err)
+	}
+	if choices, err := channelChoices(service, "3"); err != nil || len(choices) != 1 || choices[0].Version != "0.9.0" {
+		t.Errorf("the choice without a channel should be in the channel of its priority, got %v, error %v", choices, err)
+	}
+
+	_, err := channelChoices(service, "nightly")
+	if apiErr, ok := err.(*APIUserInputError); !ok || apiErr.Code != ERR_NO_CHANNEL_CHOICE || apiErr.Input != "configstate.channel" {
+		t.Fatalf("the error should be an APIUserInputError for the channel, got (%T) %v", err, err)
+	} else if !strings.Contains(apiErr.Error(), "nightly") || !strings.Contains(apiErr.Error(), "2.0.0 (channel beta)") || !strings.Contains(apiErr.Error(), "0.9.0 (priority 3)") {
+		t.Errorf("the error should name the channel and the choices, got %v", apiErr.Error())
+	}
+}
+
+// Only the version choices in the channel of the node are configured, and the channel cannot be changed once the node
+// is configured.
+func Test_UpdateConfigstate_channel(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	myOrg := "myorg"
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, myOrg, "mypattern", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	sref := exchange.ServiceReference{
+		ServiceURL:  "wurl",
+		ServiceOrg:  myOrg,
+		ServiceArch: cutil.ArchString(),
+		ServiceVersions: []exchange.WorkloadChoice{
+			{Version: "1.0.0", Channel: "stable"},
+			{Version: "2.0.0", Channel: "beta"},
+		},
+	}
+
+	// the beta version requires another service
+	stableURL, betaURL := "http://utest.com/mservice", "http://utest.com/betaservice"
+	stableResolver := getVariableServiceDefResolver(stableURL, myOrg, "1.0.0", cutil.ArchString(), nil)
+	betaResolver := getVariableServiceDefResolver(betaURL, myOrg, "1.0.0", cutil.ArchString(), nil)
+	sResolver := func(wUrl string, wOrg string, wVersion string, wArch string) (map[string]exchange.ServiceDefinition, *exchange.ServiceDefinition, string, error) {
+		if wVersion == "2.0.0" {
+			return betaResolver(wUrl, wOrg, wVersion, wArch)
+		}
+		return stableResolver(wUrl, wOrg, wVersion, wArch)
+	}
+
+	update := func(state string, channel string) (bool, *Configstate, error) {
+		var myError error
+		cs := &Configstate{State: &state, Channel: &channel}
+		errHandled, cfg, _ := UpdateConfigstate(context.Background(), cs, GetPassThroughErrorHandler(&myError), getVariablePatternHandler(sref), sResolver, getVariableServiceHandler(exchange.UserInput{}), getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+		return errHandled, cfg, myError
+	}
+
+	// a channel without any choice of the service
+	if errHandled, _, myError := update(persistence.CONFIGSTATE_CONFIGURED, "nightly"); !errHandled {
+		t.Fatalf("a channel without a choice of the service should be rejected")
+	} else if multiErr, ok := myError.(*MultiServiceConfigError); !ok || len(multiErr.Services) != 1 || multiErr.Services[0].Code != ERR_NO_CHANNEL_CHOICE {
+		t.Errorf("the service should have the channel problem, got (%T) %v", myError, myError)
+	}
+
+	errHandled, cfg, myError := update(persistence.CONFIGSTATE_CONFIGURED, "stable")
+	if errHandled {
+		t.Fatalf("unexpected error (%T) %v", myError, myError)
+	} else if cfg.Channel == nil || *cfg.Channel != "stable" {
+		t.Errorf("the channel should be returned, got %v", cfg.Channel)
+	}
+
+	if msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()}); err != nil {
+		t.Fatalf("unable to read the services, error %v", err)
+	} else if len(msdefs) != 2 {
+		t.Errorf("the workload and the stable service should be configured, got %v", msdefs)
+	} else {
+		for _, msdef := range msdefs {
+			if msdef.SpecRef == betaURL {
+				t.Errorf("the beta service should not be configured, got %v", msdef)
+			}
+		}
+	}
+
+	// the channel of a configured node cannot be changed
+	if errHandled, _, myError := update(persistence.CONFIGSTATE_CONFIGURED, "beta"); !errHandled {
+		t.Fatalf("changing the channel of a configured node should be rejected")
+	} else if apiErr, ok := myError.(*APIUserInputError); !ok || apiErr.Code != ERR_INVALID_STATE {
+		t.Errorf("the error should tell to configure the node again, got (%T) %v", myError, myError)
+	}
+	if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
+		t.Errorf("unable to read the device, error %v", err)
+	} else if pDevice.Config.Channel != "stable" {
+		t.Errorf("the channel should not change, got %v", pDevice.Config.Channel)
+	}
+}
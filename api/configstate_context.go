@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/exchange"
+)
+
+// Returns the context of a change of the config state, from the context of its caller, e.g. of the HTTP request so that
+// the change stops when the client goes away. It is done after the Edge.ConfigstateTimeoutS of the config.
+func NewConfigstateContext(parent context.Context, config *config.HorizonConfig) (context.Context, context.CancelFunc) {
+	if timeout := config.GetConfigstateTimeout(); timeout != 0 {
+		return context.WithTimeout(parent, timeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// Returns the error of a change of the config state whose context is done, nil when it is not. The change must not
+// write anything after its context is done, its caller has given up on it. The other changes of the node that are
+// cancelled with their request, e.g. when the agent shuts down, use it too.
+func configstateContextError(ctx context.Context) error {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return NewServiceUnavailableError("The change did not complete within Edge.ConfigstateTimeoutS, the exchange may not be responding. Nothing was changed, retry it later.").WithCode(ERR_TIMEOUT)
+	default:
+		return NewServiceUnavailableError(fmt.Sprintf("The change was cancelled by its caller, %v. Nothing was changed.", ctx.Err())).WithCode(ERR_TIMEOUT)
+	}
+}
+
+// The handlers below call the exchange on behalf of a change of the config state. The API makes its exchange requests
+// with the context of the change, see exchange.WithContext, so they return as soon as it is done. A call is not made
+// once the context is done, and a call that returns after that returns the error of the context, whatever the handler
+// returned, e.g. after its retries.
+
+func getPatternsWithContext(ctx context.Context, getPatterns exchange.PatternHandler) exchange.PatternHandler {
+	return func(org string, pattern string) (map[string]exchange.Pattern, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		patterns, err := getPatterns(org, pattern)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return patterns, err
+	}
+}
+
+func resolveServiceWithContext(ctx context.Context, resolveService exchange.ServiceDefResolverHandler) exchange.ServiceDefResolverHandler {
+	if resolveService == nil {
+		return nil
+	}
+	return func(wUrl string, wOrg string, wVersion string, wArch string) (map[string]exchange.ServiceDefinition, *exchange.ServiceDefinition, string, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, "", err
+		}
+		deps, sdef, sId, err := resolveService(wUrl, wOrg, wVersion, wArch)
+		if ctx.Err() != nil {
+			return nil, nil, "", ctx.Err()
+		}
+		return deps, sdef, sId, err
+	}
+}
+
+func getServiceWithContext(ctx context.Context, getService exchange.ServiceHandler) exchange.ServiceHandler {
+	return func(wUrl string, wOrg string, wVersion string, wArch string) (*exchange.ServiceDefinition, string, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
+		sdef, sId, err := getService(wUrl, wOrg, wVersion, wArch)
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+		return sdef, sId, err
+	}
+}
+
+func getDeviceWithContext(ctx context.Context, getDevice exchange.DeviceHandler) exchange.DeviceHandler {
+	return func(id string, token string) (*exchange.Device, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		dev, err := getDevice(id, token)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return dev, err
+	}
+}
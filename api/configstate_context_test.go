@@ -0,0 +1,106 @@
+// +build unit
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+	"testing"
+	"time"
+)
+
+// The change fails with ERR_TIMEOUT when the exchange does not return the pattern before the timeout, and the node is
+// left configuring.
+func Test_UpdateConfigstate_timeout(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, "myorg", "mypattern", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	// the exchange does not respond, the request returns when the change is given up on
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	patternHandler := func(org string, pattern string) (map[string]exchange.Pattern, error) {
+		<-ctx.Done()
+		return nil, fmt.Errorf("Invocation of GET failed invoking HTTP request, error: %v", ctx.Err())
+	}
+
+	state := persistence.CONFIGSTATE_CONFIGURED
+	var myError error
+	errHandled, _, _ := UpdateConfigstate(ctx, &Configstate{State: &state}, GetPassThroughErrorHandler(&myError), patternHandler, getDummyServiceDefResolver(), getDummyServiceHandler(), getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+	if !errHandled {
+		t.Fatalf("the change should time out")
+	} else if apiErr, ok := myError.(*ServiceUnavailableError); !ok || apiErr.code != ERR_TIMEOUT {
+		t.Errorf("myError has the wrong type or code (%T) %v", myError, myError)
+	}
+
+	if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
+		t.Errorf("unable to read the device, error %v", err)
+	} else if pDevice.Config.State != persistence.CONFIGSTATE_CONFIGURING {
+		t.Errorf("the node should still be configuring, is %v", pDevice.Config.State)
+	}
+}
+
+// The services created before the caller goes away are removed.
+func Test_UpdateConfigstate_cancelled(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, "myorg", "mypattern", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	sref := exchange.ServiceReference{
+		ServiceURL:      "wurl",
+		ServiceOrg:      "myorg",
+		ServiceArch:     cutil.ArchString(),
+		ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}},
+	}
+	sResolver := getVariableServiceDefResolver("http://utest.com/mservice", "myorg", "1.0.0", cutil.ArchString(), nil)
+
+	// the caller goes away once the dependency is being created
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sHandler := getVariableServiceHandler(exchange.UserInput{})
+	calls := 0
+	cancellingHandler := func(wUrl string, wOrg string, wVersion string, wArch string) (*exchange.ServiceDefinition, string, error) {
+		if calls++; calls > 2 {
+			cancel()
+		}
+		return sHandler(wUrl, wOrg, wVersion, wArch)
+	}
+
+	state := persistence.CONFIGSTATE_CONFIGURED
+	var myError error
+	errHandled, _, _ := UpdateConfigstate(ctx, &Configstate{State: &state}, GetPassThroughErrorHandler(&myError), getVariablePatternHandler(sref), sResolver, cancellingHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+	if !errHandled {
+		t.Fatalf("the change should be cancelled")
+	} else if apiErr, ok := myError.(*ServiceUnavailableError); !ok || apiErr.code != ERR_TIMEOUT {
+		t.Errorf("myError has the wrong type or code (%T) %v", myError, myError)
+	}
+
+	if msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()}); err != nil {
+		t.Errorf("unable to read the services, error %v", err)
+	} else if len(msdefs) != 0 {
+		t.Errorf("the services should be removed, got %v", msdefs)
+	}
+
+	if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
+		t.Errorf("unable to read the device, error %v", err)
+	} else if pDevice.Config.State != persistence.CONFIGSTATE_CONFIGURING {
+		t.Errorf("the node should still be configuring, is %v", pDevice.Config.State)
+	}
+}
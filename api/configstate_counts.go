@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+	"time"
+)
+
+// How long the resolution of the pattern for the service counts of the configstate output can take.
+const configstateCountsTimeout = 10 * time.Second
+
+// Set the counts of the services of the node's pattern in the configstate output: the services that the autoconfig of
+// the pattern configures, the ones that are already registered and the ones that lack user input. The pattern is
+// resolved as by a dry run of the change to configured. The counts are not set when the node has no pattern. The
+// configstate is still returned when the pattern cannot be resolved, e.g. the exchange is not reachable, with
+// ResolutionUnavailable set instead of the counts.
+func FindConfigstateServiceCounts(ctx context.Context,
+	cfg *Configstate,
+	getPatterns exchange.PatternHandler,
+	resolveService exchange.ServiceDefResolverHandler,
+	getService exchange.ServiceHandler,
+	db *bolt.DB,
+	config *config.HorizonConfig) error {
+
+	pDevice, err := persistence.FindExchangeDevice(db)
+	if err != nil {
+		return fmt.Errorf("unable to read node object, error %v", err)
+	} else if pDevice == nil || pDevice.Pattern == "" {
+		return nil
+	}
+
+	services, err := dryRunAutoconfig(ctx, pDevice, true, pDevice.Config.Excluded, newOptionalServices(pDevice.Config.Optional), pDevice.Config.Channel, getPatternsWithContext(ctx, getPatterns), resolveServiceWithContext(ctx, resolveService), getServiceWithContext(ctx, getService), db, config)
+	if err != nil {
+		glog.V(3).Infof(apiLogString(fmt.Sprintf("unable to resolve pattern %v for the configstate service counts, error %v", pDevice.Pattern, err)))
+		unavailable := true
+		cfg.ResolutionUnavailable = &unavailable
+		return nil
+	}
+
+	required, registered, missingConfig := len(services), 0, 0
+	for _, service := range services {
+		if service.Registered {
+			registered++
+		} else if service.MissingConfig != "" {
+			missingConfig++
+		}
+	}
+	cfg.ServicesRequired = &required
+	cfg.ServicesRegistered = &registered
+	cfg.ServicesMissingConfig = &missingConfig
+	return nil
+}
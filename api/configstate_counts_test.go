@@ -0,0 +1,68 @@
+// +build unit
+
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+)
+
+// The configstate has the counts of the services of the node's pattern, and is still returned when the pattern cannot
+// be resolved.
+func Test_FindConfigstateServiceCounts(t *testing.T) {
+
+	ui := exchange.UserInput{Name: "missingVar", Label: "label", Type: "string"}
+	sr := exchange.ServiceReference{
+		ServiceURL:      "http://mydomain.com/workload/test1",
+		ServiceOrg:      "testorg",
+		ServiceArch:     cutil.ArchString(),
+		ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}},
+	}
+	sResolver := getVariableServiceDefResolver("http://utest.com/mservice", "myorg", "1.0.0", cutil.ArchString(), &ui)
+	noExchange := func(org string, pattern string) (map[string]exchange.Pattern, error) {
+		return nil, errors.New("connection refused")
+	}
+
+	tests := []struct {
+		pattern     string
+		getPatterns exchange.PatternHandler
+		counts      []int
+		unavailable bool
+	}{
+		{"", getVariablePatternHandler(sr), nil, false},
+		{"mypattern", getVariablePatternHandler(sr), []int{2, 0, 2}, false},
+		{"mypattern", noExchange, nil, true},
+	}
+	for _, test := range tests {
+		dir, db, err := utsetup()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "device", false, "myorg", test.pattern, persistence.CONFIGSTATE_CONFIGURING); err != nil {
+			t.Errorf("failed to create persisted device, error %v", err)
+		}
+
+		out, err := FindConfigstateForOutput(db, getBasicConfig())
+		if err != nil {
+			t.Fatalf("failed to get the configstate, error %v", err)
+		} else if err := FindConfigstateServiceCounts(context.Background(), out, test.getPatterns, sResolver, getVariableServiceHandler(ui), db, getBasicConfig()); err != nil {
+			t.Errorf("pattern %q: the counts should not fail, error %v", test.pattern, err)
+		} else if test.counts == nil && (out.ServicesRequired != nil || out.ServicesRegistered != nil || out.ServicesMissingConfig != nil) {
+			t.Errorf("pattern %q: there should be no counts, got %v %v %v", test.pattern, out.ServicesRequired, out.ServicesRegistered, out.ServicesMissingConfig)
+		} else if test.counts != nil && (out.ServicesRequired == nil || *out.ServicesRequired != test.counts[0] || *out.ServicesRegistered != test.counts[1] || *out.ServicesMissingConfig != test.counts[2]) {
+			t.Errorf("pattern %q: the counts should be %v, got %v %v %v", test.pattern, test.counts, out.ServicesRequired, out.ServicesRegistered, out.ServicesMissingConfig)
+		} else if test.unavailable != (out.ResolutionUnavailable != nil && *out.ResolutionUnavailable) {
+			t.Errorf("pattern %q: resolution_unavailable should be %v, got %v", test.pattern, test.unavailable, out.ResolutionUnavailable)
+		} else if *out.State != persistence.CONFIGSTATE_CONFIGURING {
+			t.Errorf("pattern %q: the state should be returned, got %v", test.pattern, *out.State)
+		}
+
+		cleanTestDir(dir)
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+	"sort"
+	"strings"
+)
+
+// Returns the services that the configstate PUT body excludes from the autoconfig, see Configstate.ExcludedServices.
+// The org of a service defaults to the node's org, and a service listed more than once is excluded once. An
+// APIUserInputError is returned if a service has no url.
+func newServiceExclusions(specs persistence.ServiceSpecs, nodeOrg string) (persistence.ServiceSpecs, error) {
+	excluded := make(persistence.ServiceSpecs, 0, len(specs))
+	for i, spec := range specs {
+		if spec.Url == "" {
+			return nil, NewAPIUserInputError("the url of the excluded service must be set", fmt.Sprintf("configstate.excluded_services[%v].url", i)).WithCode(ERR_INVALID_INPUT)
+		}
+		if spec.Org == "" {
+			spec.Org = nodeOrg
+		}
+		if !isExcludedService(excluded, spec.Url, spec.Org) {
+			excluded = append(excluded, spec)
+		}
+	}
+	return excluded, nil
+}
+
+// Returns true if the service is one of the excluded services, the same URL written differently is the same service.
+func isExcludedService(excluded persistence.ServiceSpecs, url string, org string) bool {
+	for _, spec := range excluded {
+		if cutil.SameSpecURL(spec.Url, url) && spec.Org == org {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the excluded services of old that are not excluded by current, as org/url.
+func removedExclusions(old persistence.ServiceSpecs, current persistence.ServiceSpecs) []string {
+	removed := make([]string, 0, len(old))
+	for _, spec := range old {
+		if !isExcludedService(current, spec.Url, spec.Org) {
+			removed = append(removed, cutil.FormOrgSpecUrl(spec.Url, spec.Org))
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// Returns true if the two lists exclude the same services.
+func sameExclusions(a persistence.ServiceSpecs, b persistence.ServiceSpecs) bool {
+	return len(removedExclusions(a, b)) == 0 && len(removedExclusions(b, a)) == 0
+}
+
+// The excluded services as org/url, for the messages.
+func exclusionsString(excluded persistence.ServiceSpecs) string {
+	names := make([]string, 0, len(excluded))
+	for _, spec := range excluded {
+		names = append(names, cutil.FormOrgSpecUrl(spec.Url, spec.Org))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Returns the service definitions, by id, without the excluded services.
+func includedServiceDefs(defs map[string]exchange.ServiceDefinition, excluded persistence.ServiceSpecs) *map[string]exchange.ServiceDefinition {
+	included := make(map[string]exchange.ServiceDefinition, len(defs))
+	for sId, sDef := range defs {
+		if !isExcludedService(excluded, sDef.URL, exchange.GetOrg(sId)) {
+			included[sId] = sDef
+		}
+	}
+	return &included
+}
+
+// Set the services that the configstate PUT body excludes on the node, before the node is changed to the requested
+// state so that the autoconfig skips them. They can only be changed while the node is configuring, the autoconfig of a
+// configured node already ran with the ones the node had. pDevice is updated with them.
+func updateExcludedServices(cfg *Configstate, pDevice *persistence.ExchangeDevice, db *bolt.DB) error {
+	excluded, err := newServiceExclusions(*cfg.ExcludedServices, pDevice.Org)
+	if err != nil {
+		return err
+	} else if sameExclusions(pDevice.Config.Excluded, excluded) {
+		return nil
+	}
+
+	if pDevice.Config.State != persistence.CONFIGSTATE_CONFIGURING {
+		rerun := fmt.Sprintf("Change the node to '%v', and then to '%v' with the new excluded_services to run the autoconfig again.", persistence.CONFIGSTATE_CONFIGURING, persistence.CONFIGSTATE_CONFIGURED)
+		if removed := removedExclusions(pDevice.Config.Excluded, excluded); len(removed) != 0 {
+			return NewAPIUserInputError(fmt.Sprintf("The services %v cannot be removed from the excluded services while the node is '%v', the autoconfig skipped them. %v", strings.Join(removed, ", "), pDevice.Config.State, rerun), "configstate.excluded_services").WithCode(ERR_INVALID_STATE)
+		}
+		return NewAPIUserInputError(fmt.Sprintf("Services cannot be added to the excluded services while the node is '%v', the autoconfig already configured them. %v", pDevice.Config.State, rerun), "configstate.excluded_services").WithCode(ERR_INVALID_STATE)
+	}
+
+	if len(excluded) == 0 {
+		excluded = nil
+	}
+	if _, err := pDevice.SetExcludedServices(db, pDevice.Id, excluded); err != nil {
+		return NewSystemError(fmt.Sprintf("error persisting the excluded services %v, error %v", excluded, err)).WithCode(ERR_DATABASE)
+	}
+	pDevice.Config.Excluded = excluded
+	return nil
+}
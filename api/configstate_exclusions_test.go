@@ -0,0 +1,96 @@
+// +build unit
+
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+)
+
+// The autoconfig skips the services that the node excludes, the workload that requires them is still configured, and
+// the exclusions cannot be removed once the node is configured.
+func Test_UpdateConfigstate_excluded_services(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	myOrg := "myorg"
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, myOrg, "mypattern", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	sref := exchange.ServiceReference{
+		ServiceURL:      "wurl",
+		ServiceOrg:      myOrg,
+		ServiceArch:     cutil.ArchString(),
+		ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}},
+	}
+	mURL := "http://utest.com/mservice"
+	sResolver := getVariableServiceDefResolver(mURL, myOrg, "1.0.0", cutil.ArchString(), nil)
+	sHandler := getVariableServiceHandler(exchange.UserInput{})
+
+	update := func(state string, excluded *persistence.ServiceSpecs) (bool, *Configstate, []events.Message, error) {
+		var myError error
+		cs := &Configstate{State: &state, ExcludedServices: excluded}
+		errHandled, cfg, msgs := UpdateConfigstate(context.Background(), cs, GetPassThroughErrorHandler(&myError), getVariablePatternHandler(sref), sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+		return errHandled, cfg, msgs, myError
+	}
+
+	// a service without a url
+	if errHandled, _, _, myError := update(persistence.CONFIGSTATE_CONFIGURED, &persistence.ServiceSpecs{{Org: myOrg}}); !errHandled {
+		t.Fatalf("an excluded service without a url should be rejected")
+	} else if apiErr, ok := myError.(*APIUserInputError); !ok || apiErr.Input != "configstate.excluded_services[0].url" {
+		t.Errorf("myError has the wrong type or input (%T) %v", myError, myError)
+	}
+
+	// the dependency is excluded, its org is the node's org
+	excluded := persistence.ServiceSpecs{{Url: mURL}}
+	errHandled, cfg, msgs, myError := update(persistence.CONFIGSTATE_CONFIGURED, &excluded)
+	if errHandled {
+		t.Fatalf("unexpected error (%T) %v", myError, myError)
+	} else if *cfg.State != persistence.CONFIGSTATE_CONFIGURED {
+		t.Errorf("wrong state field %v", *cfg)
+	} else if cfg.ExcludedServices == nil || len(*cfg.ExcludedServices) != 1 || (*cfg.ExcludedServices)[0].Org != myOrg {
+		t.Errorf("the excluded service should be returned with the node's org, got %v", cfg.ExcludedServices)
+	} else if pols, ok := msgs[0].(*events.PoliciesCreatedMessage); !ok || len(pols.PolicyFiles()) != 1 {
+		t.Errorf("only the policy of the workload should be created, received %v", msgs[0])
+	}
+
+	if msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()}); err != nil {
+		t.Fatalf("unable to read the services, error %v", err)
+	} else if len(msdefs) != 1 || msdefs[0].SpecRef != "wurl" {
+		t.Errorf("only the workload should be configured, got %v", msdefs)
+	}
+
+	if out, err := FindConfigstateForOutput(db, getBasicConfig()); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if out.ExcludedServices == nil || !isExcludedService(*out.ExcludedServices, mURL, myOrg) {
+		t.Errorf("the configstate should have the excluded service, got %v", out.ExcludedServices)
+	}
+
+	// the same exclusions are a no-op, removing them is rejected
+	if errHandled, _, _, myError := update(persistence.CONFIGSTATE_CONFIGURED, &persistence.ServiceSpecs{{Url: mURL, Org: myOrg}}); errHandled {
+		t.Errorf("the same excluded services should not be rejected, error %v", myError)
+	}
+	errHandled, _, _, myError = update(persistence.CONFIGSTATE_CONFIGURED, &persistence.ServiceSpecs{})
+	if !errHandled {
+		t.Fatalf("removing an excluded service of a configured node should be rejected")
+	} else if apiErr, ok := myError.(*APIUserInputError); !ok || apiErr.Code != ERR_INVALID_STATE || !strings.Contains(apiErr.Error(), persistence.CONFIGSTATE_CONFIGURING) {
+		t.Errorf("the error should tell to configure the node again, got (%T) %v", myError, myError)
+	}
+
+	if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
+		t.Errorf("unable to read the device, error %v", err)
+	} else if len(pDevice.Config.Excluded) != 1 {
+		t.Errorf("the excluded services should not change, got %v", pDevice.Config.Excluded)
+	}
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"github.com/open-horizon/anax/semanticversion"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// The name of the pattern that the services of the autoconfig manifest are configured as, in the messages of the
+// autoconfig of a node without a pattern.
+const AUTOCONFIG_MANIFEST_PATTERN = "autoconfig-manifest"
+
+// A service of the autoconfig manifest, see the Edge.AutoconfigManifest of the config.
+type ManifestService struct {
+	Url          string                 `json:"url"`
+	Org          string                 `json:"org"`
+	VersionRange string                 `json:"versionRange,omitempty"` // the default is [0.0.0,INFINITY)
+	Arch         string                 `json:"arch,omitempty"`         // the default is the arch of the node
+	Variables    map[string]interface{} `json:"variables,omitempty"`    // the values of the user input variables of the service
+}
+
+func (s ManifestService) String() string {
+	return fmt.Sprintf("Url: %v, Org: %v, VersionRange: %v, Arch: %v, Variables: %v", s.Url, s.Org, s.VersionRange, s.Arch, s.Variables)
+}
+
+// Read the services of the autoconfig manifest at path, a json array of ManifestService. There are none when path is
+// empty or the file does not exist. An APIUserInputError with the line of the problem is returned when the manifest is
+// not valid.
+func LoadAutoconfigManifest(path string) ([]ManifestService, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, NewSystemError(fmt.Sprintf("Unable to read the autoconfig manifest %v, error %v", path, err)).WithCode(ERR_INVALID_MANIFEST)
+	}
+
+	manifestError := func(offset int64, input string, err error) error {
+		return NewAPIUserInputError(fmt.Sprintf("The autoconfig manifest %v is not valid, line %v: %v", path, lineOf(data, offset), err), input).WithCode(ERR_INVALID_MANIFEST)
+	}
+
+	var elements []json.RawMessage
+	if err := json.Unmarshal(data, &elements); err != nil {
+		switch e := err.(type) {
+		case *json.SyntaxError:
+			return nil, manifestError(e.Offset, "services", err)
+		case *json.UnmarshalTypeError:
+			return nil, manifestError(e.Offset, "services", fmt.Errorf("the manifest must be an array of services"))
+		}
+		return nil, manifestError(0, "services", err)
+	}
+
+	// The offset of each service in the file, for the line of its problems.
+	offsets := make([]int64, 0, len(elements))
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return nil, manifestError(0, "services", err)
+	}
+	for dec.More() {
+		offset := dec.InputOffset()
+		for offset < int64(len(data)) && bytes.IndexByte([]byte(" \t\r\n,"), data[offset]) != -1 {
+			offset++
+		}
+		offsets = append(offsets, offset)
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, manifestError(offset, "services", err)
+		}
+	}
+
+	services := make([]ManifestService, 0, len(elements))
+	for i, element := range elements {
+		input := fmt.Sprintf("services[%v]", i)
+
+		var s ManifestService
+		elementDec := json.NewDecoder(bytes.NewReader(element))
+		elementDec.DisallowUnknownFields()
+		if err := elementDec.Decode(&s); err != nil {
+			if e, ok := err.(*json.UnmarshalTypeError); ok {
+				return nil, manifestError(offsets[i]+e.Offset, fmt.Sprintf("%v.%v", input, e.Field), err)
+			}
+			return nil, manifestError(offsets[i], input, err)
+		}
+
+		if s.Url == "" {
+			return nil, manifestError(offsets[i], input+".url", fmt.Errorf("the url of the service must be set"))
+		} else if s.Org == "" {
+			return nil, manifestError(offsets[i], input+".org", fmt.Errorf("the org of service %v must be set", s.Url))
+		}
+		if s.VersionRange == "" {
+			s.VersionRange = "[0.0.0,INFINITY)"
+		} else if vr, err := semanticversion.CanonicalVersionRange(s.VersionRange); err != nil {
+			return nil, manifestError(offsets[i], input+".versionRange", fmt.Errorf("%v is not a valid version range of service %v, error %v", s.VersionRange, cutil.FormOrgSpecUrl(s.Url, s.Org), err))
+		} else {
+			s.VersionRange = vr
+		}
+		if s.Arch == "" {
+			s.Arch = cutil.ArchString()
+		}
+		for _, other := range services {
+			if cutil.SameSpecURL(other.Url, s.Url) && other.Org == s.Org && cutil.ArchEquivalent(other.Arch, s.Arch) {
+				return nil, manifestError(offsets[i], input+".url", fmt.Errorf("service %v is listed more than once", cutil.FormOrgSpecUrl(s.Url, s.Org)))
+			}
+		}
+		services = append(services, s)
+	}
+
+	return services, nil
+}
+
+// The line of the offset in data, starting at 1.
+func lineOf(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// The services of the autoconfig manifest as a pattern, with one version choice that is the version range of each
+// service, and the values of their variables as its user input.
+func manifestPattern(services []ManifestService) exchange.Pattern {
+	pattern := exchange.Pattern{
+		Label:     AUTOCONFIG_MANIFEST_PATTERN,
+		Services:  make([]exchange.ServiceReference, 0, len(services)),
+		UserInput: make([]policy.UserInput, 0, len(services)),
+	}
+
+	for _, s := range services {
+		pattern.Services = append(pattern.Services, exchange.ServiceReference{
+			ServiceURL:      s.Url,
+			ServiceOrg:      s.Org,
+			ServiceArch:     s.Arch,
+			ServiceVersions: []exchange.WorkloadChoice{{Version: s.VersionRange}},
+		})
+
+		if len(s.Variables) == 0 {
+			continue
+		}
+		names := make([]string, 0, len(s.Variables))
+		for name := range s.Variables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		ui := policy.UserInput{ServiceOrgid: s.Org, ServiceUrl: s.Url, ServiceArch: s.Arch, ServiceVersionRange: s.VersionRange, Inputs: make([]policy.Input, 0, len(names))}
+		for _, name := range names {
+			ui.Inputs = append(ui.Inputs, policy.Input{Name: name, Value: s.Variables[name]})
+		}
+		pattern.UserInput = append(pattern.UserInput, ui)
+	}
+	return pattern
+}
+
+// The pattern that the autoconfig of the node configures the services of. It is the node's pattern, or for a node
+// without a pattern, the services of the autoconfig manifest. The returned pattern handler gets the pattern, it is
+// nil when there is neither, and manifest is true for the autoconfig manifest.
+func autoconfigPattern(pDevice *persistence.ExchangeDevice, getPatterns exchange.PatternHandler, config *config.HorizonConfig) (org string, name string, handler exchange.PatternHandler, manifest bool, err error) {
+	if pDevice.Pattern != "" {
+		org, name, _ = persistence.GetFormatedPatternString(pDevice.Pattern, pDevice.Org)
+		return org, name, getPatterns, false, nil
+	}
+
+	services, err := LoadAutoconfigManifest(config.LiveEdge().AutoconfigManifest)
+	if err != nil || len(services) == 0 {
+		return "", "", nil, false, err
+	}
+
+	pattern := manifestPattern(services)
+	handler = func(patOrg string, patName string) (map[string]exchange.Pattern, error) {
+		return map[string]exchange.Pattern{fmt.Sprintf("%v/%v", patOrg, patName): pattern}, nil
+	}
+	return pDevice.Org, AUTOCONFIG_MANIFEST_PATTERN, handler, true, nil
+}
@@ -0,0 +1,181 @@
+// +build unit
+
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+)
+
+func writeManifest(t *testing.T, dir string, content string) string {
+	path := filepath.Join(dir, "autoconfig.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("unable to write the manifest, error %v", err)
+	}
+	return path
+}
+
+func Test_LoadAutoconfigManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if services, err := LoadAutoconfigManifest(filepath.Join(dir, "missing.json")); err != nil || services != nil {
+		t.Errorf("a missing manifest should have no services, got %v, error %v", services, err)
+	}
+
+	path := writeManifest(t, dir, `[
+  {"url": "http://mydomain.com/gps", "org": "myorg", "versionRange": "1.0.0", "variables": {"var1": "a"}},
+  {"url": "http://mydomain.com/net", "org": "myorg", "arch": "arm64"}
+]`)
+	services, err := LoadAutoconfigManifest(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if len(services) != 2 {
+		t.Fatalf("there should be 2 services, got %v", services)
+	} else if services[0].VersionRange != "[1.0.0,INFINITY)" || services[0].Arch != cutil.ArchString() || services[0].Variables["var1"] != "a" {
+		t.Errorf("the first service should have a canonical range and the node's arch, got %v", services[0])
+	} else if services[1].VersionRange != "[0.0.0,INFINITY)" || services[1].Arch != "arm64" {
+		t.Errorf("the second service should have the default range, got %v", services[1])
+	}
+
+	// The problems are reported with the line and the field of the service.
+	tests := []struct {
+		content string
+		input   string
+		line    string
+	}{
+		{"[\n  {\"url\": \"u1\", \"org\": \"myorg\"},\n  {\"url\": \"u2\" \"org\": \"myorg\"}\n]", "services", "line 3"},
+		{"{\"url\": \"u1\"}", "services", "line 1"},
+		{"[\n  {\"url\": \"u1\", \"org\": \"myorg\"},\n  {\"url\": \"u2\", \"org\": \"myorg\", \"verison\": \"1.0.0\"}\n]", "services[1]", "line 3"},
+		{"[\n  {\"url\": \"u1\", \"org\": \"myorg\"},\n\n  {\"url\": \"u2\",\n   \"org\": \"myorg\",\n   \"versionRange\": 1}\n]", "services[1].versionRange", "line 6"},
+		{"[\n  {\"url\": \"u1\", \"org\": \"myorg\", \"versionRange\": \"latest\"}\n]", "services[0].versionRange", "line 2"},
+		{"[\n  {\"url\": \"u1\"}\n]", "services[0].org", "line 2"},
+		{"[\n  {\"url\": \"u1\", \"org\": \"myorg\"},\n  {\"url\": \"u1\", \"org\": \"myorg\"}\n]", "services[1].url", "line 3"},
+	}
+	for _, test := range tests {
+		_, err := LoadAutoconfigManifest(writeManifest(t, dir, test.content))
+		if apiErr, ok := err.(*APIUserInputError); !ok {
+			t.Errorf("%v: expected an APIUserInputError, got (%T) %v", test.content, err, err)
+		} else if apiErr.Input != test.input || !strings.Contains(apiErr.Error(), test.line) {
+			t.Errorf("%v: expected input %v at %v, got %v %v", test.content, test.input, test.line, apiErr.Input, apiErr.Error())
+		}
+	}
+}
+
+// A node without a pattern configures the services of the autoconfig manifest, with the values of their variables.
+func Test_UpdateConfigstate_manifest(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	myOrg := "myorg"
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, myOrg, "", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	cfg := getBasicConfig()
+	cfg.Edge.AutoconfigManifest = writeManifest(t, dir, `[
+  {"url": "http://mydomain.com/workload", "org": "myorg", "versionRange": "[1.0.0,2.0.0)", "variables": {"var1": "value1"}}
+]`)
+
+	// the exchange has version 1.2.0 of each service
+	ui := exchange.UserInput{Name: "var1", Label: "label", Type: "string"}
+	dResolver := getVariableServiceDefResolver("http://utest.com/mservice", myOrg, "1.2.0", cutil.ArchString(), &ui)
+	sResolver := func(wUrl string, wOrg string, wVersion string, wArch string) (map[string]exchange.ServiceDefinition, *exchange.ServiceDefinition, string, error) {
+		return dResolver(wUrl, wOrg, "1.2.0", wArch)
+	}
+	sHandler := func(mUrl string, mOrg string, mVersion string, mArch string) (*exchange.ServiceDefinition, string, error) {
+		sdef, id, err := getVariableServiceHandler(exchange.UserInput{})(mUrl, mOrg, "1.2.0", mArch)
+		if mUrl == "http://mydomain.com/workload" {
+			sdef.UserInputs = []exchange.UserInput{ui}
+		}
+		return sdef, id, err
+	}
+
+	var myError error
+	cs := getBasicConfigstate()
+	state := persistence.CONFIGSTATE_CONFIGURED
+	cs.State = &state
+	errHandled, newCfg, msgs := UpdateConfigstate(context.Background(), cs, GetPassThroughErrorHandler(&myError), getVariablePatternHandler(exchange.ServiceReference{}), sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, cfg)
+
+	if errHandled {
+		t.Fatalf("unexpected error (%T) %v", myError, myError)
+	} else if *newCfg.State != persistence.CONFIGSTATE_CONFIGURED {
+		t.Errorf("wrong state field %v", *newCfg)
+	} else if len(msgs) != 1 {
+		t.Errorf("a node without a pattern has no policies, there should only be the config state change, received %v", msgs)
+	}
+
+	msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()})
+	if err != nil {
+		t.Fatalf("unable to read the services, error %v", err)
+	} else if len(msdefs) != 2 {
+		t.Fatalf("the top-level and the dependent service should be configured, got %v", msdefs)
+	}
+	for _, msdef := range msdefs {
+		if msdef.SpecRef == "http://mydomain.com/workload" && msdef.UpgradeVersionRange != "[1.0.0,2.0.0)" {
+			t.Errorf("the top-level service should be registered with its version range, got %v", msdef.UpgradeVersionRange)
+		}
+	}
+
+	if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
+		t.Errorf("unable to read the device, error %v", err)
+	} else if pDevice.Pattern != "" {
+		t.Errorf("the node should still have no pattern, has %v", pDevice.Pattern)
+	}
+}
+
+// The services of the autoconfig manifest whose variables are not set are reported, and nothing is configured.
+func Test_UpdateConfigstate_manifest_missing_variables(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, "myorg", "", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	cfg := getBasicConfig()
+	cfg.Edge.AutoconfigManifest = writeManifest(t, dir, `[{"url": "http://mydomain.com/workload", "org": "myorg", "versionRange": "1.0.0"}]`)
+
+	ui := exchange.UserInput{Name: "var1", Label: "label", Type: "string"}
+	dResolver := getVariableServiceDefResolver("", "", "", "", &ui)
+	sResolver := func(wUrl string, wOrg string, wVersion string, wArch string) (map[string]exchange.ServiceDefinition, *exchange.ServiceDefinition, string, error) {
+		return dResolver(wUrl, wOrg, "1.2.0", wArch)
+	}
+
+	var myError error
+	cs := getBasicConfigstate()
+	state := persistence.CONFIGSTATE_CONFIGURED
+	cs.State = &state
+	errHandled, _, _ := UpdateConfigstate(context.Background(), cs, GetPassThroughErrorHandler(&myError), getVariablePatternHandler(exchange.ServiceReference{}), sResolver, getVariableServiceHandler(ui), getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, cfg)
+
+	if !errHandled {
+		t.Fatalf("expected an error")
+	} else if multiErr, ok := myError.(*MultiServiceConfigError); !ok {
+		t.Errorf("myError has the wrong type (%T) %v", myError, myError)
+	} else if problem := findServiceConfigProblem(multiErr.Services, "http://mydomain.com/workload"); problem == nil || !strings.Contains(problem.Err, "var1") {
+		t.Errorf("the missing variable of the workload should be reported, got %v", multiErr.Services)
+	}
+
+	if msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()}); err != nil || len(msdefs) != 0 {
+		t.Errorf("no service should be configured, got %v, error %v", msdefs, err)
+	}
+}
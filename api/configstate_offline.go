@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/offline"
+	"github.com/open-horizon/anax/persistence"
+	"os"
+	"time"
+)
+
+// Returns the offline definitions of the offline bundle of the config, nil when there are none. They are the ones of
+// its definitions directory, and the ones of its manifest that were stored when it was installed. An error is returned
+// when the configstate PUT body asks for them and they cannot be used. When it does not, definitions that cannot be
+// read are only logged, the exchange is then used as usual.
+func loadOfflineDefinitions(cfg *Configstate, config *config.HorizonConfig, db *bolt.DB) (*offline.Definitions, error) {
+	requested := cfg.Offline != nil && *cfg.Offline
+	dir := offline.DefinitionsDir(config)
+
+	failed := func(err error) (*offline.Definitions, error) {
+		if requested {
+			return nil, NewSystemError(fmt.Sprintf("The node cannot be configured offline, %v", err)).WithCode(ERR_OFFLINE_DEFINITIONS)
+		}
+		glog.Errorf(apiLogString(fmt.Sprintf("The offline definitions are not used, %v", err)))
+		return nil, nil
+	}
+
+	_, statErr := os.Stat(dir)
+	dirExists := dir != "" && !os.IsNotExist(statErr)
+
+	var defs *offline.Definitions
+	if !dirExists {
+		defs = offline.NewDefinitions(dir)
+	} else if loaded, err := offline.LoadDefinitions(dir); err != nil {
+		return failed(err)
+	} else {
+		defs = loaded
+	}
+
+	if dir != "" && db != nil {
+		if _, err := defs.AddStored(db); err != nil {
+			return failed(err)
+		}
+	}
+
+	if !dirExists && len(defs.Patterns) == 0 && len(defs.Services) == 0 {
+		if requested {
+			return nil, NewAPIUserInputError(fmt.Sprintf("The node cannot be configured offline, the Edge.OfflineBundlePath of the config has no %v directory and its bundle has no definitions.", offline.DEFINITIONS_DIR), "configstate.offline").WithCode(ERR_OFFLINE_DEFINITIONS)
+		}
+		return nil, nil
+	}
+
+	if requested && cfg.CheckConnectivity != nil && *cfg.CheckConnectivity {
+		return nil, NewAPIUserInputError("The connectivity checks cannot be run when the node is configured offline.", "configstate.check_connectivity").WithCode(ERR_OFFLINE_DEFINITIONS)
+	}
+	return defs, nil
+}
+
+// Whether the exchange can be reached: the node reads its exchange record, with the org/id and token it registers or is
+// registered with, within the connectivity check timeout. Only the failures to reach the exchange count, e.g. an
+// expired token does not.
+func exchangeReachable(ctx context.Context, deviceId string, token string, getDevice exchange.DeviceHandler) bool {
+	ctx, cancel := context.WithTimeout(ctx, connectivityCheckTimeout)
+	defer cancel()
+
+	_, err := getDeviceWithContext(ctx, getDevice)(deviceId, token)
+	if err == context.DeadlineExceeded || exchange.IsRetryableError(err) || exchange.IsTransportError(nil, err) {
+		glog.Warningf(apiRequestLogString(ctx, fmt.Sprintf("The exchange cannot be reached, %v", err)))
+		return false
+	}
+	return true
+}
+
+// Returns the handlers of a change of the config state with the offline definitions. Offline, they only read the
+// definitions, otherwise they read them when the exchange cannot be reached.
+func offlineHandlers(defs *offline.Definitions, offlineOnly bool,
+	getPatterns exchange.PatternHandler,
+	resolveService exchange.ServiceDefResolverHandler,
+	getService exchange.ServiceHandler) (exchange.PatternHandler, exchange.ServiceDefResolverHandler, exchange.ServiceHandler) {
+
+	if offlineOnly {
+		return defs.PatternHandler(), defs.ServiceDefResolverHandler(), defs.ServiceHandler()
+	}
+	return defs.FallbackPatternHandler(getPatterns), defs.FallbackServiceDefResolverHandler(resolveService), defs.FallbackServiceHandler(getService)
+}
+
+// Record the offline definitions that configured the node, so that the offline reconcile worker compares them with the
+// exchange once it can be reached. Returns whether any were used. The node is already configured, a failure to record
+// them is only logged.
+func saveOfflineConfigstate(defs *offline.Definitions, db *bolt.DB) bool {
+	pDevice, err := persistence.FindExchangeDevice(db)
+	if err != nil || pDevice == nil {
+		glog.Errorf(apiLogString(fmt.Sprintf("Unable to read the node to record the offline definitions, error %v", err)))
+		return false
+	}
+
+	record, err := defs.UsedRecord(pDevice.Pattern)
+	if err != nil {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_OFFLINE, err.Error()), persistence.EC_NODE_CONFIG_OFFLINE, pDevice)
+		return true
+	} else if record == nil {
+		return false
+	} else if err := persistence.SaveOfflineConfigstate(db, record); err != nil {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_OFFLINE, err.Error()), persistence.EC_NODE_CONFIG_OFFLINE, pDevice)
+		return true
+	}
+
+	LogDeviceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_NODE_CONF_OFFLINE, pDevice.Id, record.Dir, len(record.Patterns), len(record.Services)), persistence.EC_NODE_CONFIG_OFFLINE, pDevice)
+	return true
+}
+
+// Record that the node was registered with the offline definitions, so that the offline reconcile worker sets it up
+// with the exchange once it can be reached.
+func saveOfflineRegistration(defs *offline.Definitions, device *HorizonDevice, db *bolt.DB) error {
+	reg := &persistence.OfflineRegistration{Dir: defs.Dir, RegisterTime: uint64(time.Now().Unix())}
+	if err := persistence.SaveOfflineRegistration(db, reg); err != nil {
+		return err
+	}
+	LogDeviceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_NODE_REG_OFFLINE, *device.Id, defs.Dir), persistence.EC_NODE_CONFIG_OFFLINE, device)
+	return nil
+}
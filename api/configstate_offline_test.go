@@ -0,0 +1,273 @@
+// +build unit
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/offline"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/worker"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Write an offline bundle directory with the given offline definitions files.
+func writeOfflineBundle(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "anax-offline-bundle-")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := os.Mkdir(filepath.Join(dir, offline.DEFINITIONS_DIR), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, offline.DEFINITIONS_DIR, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// The pattern myorg/mypattern with the service wurl 1.0.0 for the arch of the node.
+func offlinePatternFiles() map[string]string {
+	arch := cutil.ArchString()
+	return map[string]string{
+		"patterns.json": fmt.Sprintf(`{"patterns": {"myorg/mypattern": {"services": [{"serviceUrl": "wurl", "serviceOrgid": "myorg", "serviceArch": "%v", "serviceVersions": [{"version": "1.0.0"}]}]}}}`, arch),
+		"services.json": fmt.Sprintf(`{"services": {"myorg/wurl_1.0.0_%v": {"url": "wurl", "version": "1.0.0", "arch": "%v"}}}`, arch, arch),
+	}
+}
+
+func Test_loadOfflineDefinitions(t *testing.T) {
+
+	dir := writeOfflineBundle(t, nil)
+	defer os.RemoveAll(dir)
+
+	useOffline, check := true, true
+	config := getBasicConfig()
+
+	// without the bundle, the node can only be configured through the exchange
+	if defs, err := loadOfflineDefinitions(&Configstate{}, config, nil); defs != nil || err != nil {
+		t.Errorf("there should be no definitions, got %v, error %v", defs, err)
+	}
+	if _, err := loadOfflineDefinitions(&Configstate{Offline: &useOffline}, config, nil); err == nil || ErrorReason(err) != ERR_OFFLINE_DEFINITIONS {
+		t.Errorf("offline without a bundle should be rejected, got %v", err)
+	}
+
+	// a bundle without definitions is the same
+	config.Edge.OfflineBundlePath = filepath.Join(dir, "missing")
+	if _, err := loadOfflineDefinitions(&Configstate{Offline: &useOffline}, config, nil); err == nil || ErrorReason(err) != ERR_OFFLINE_DEFINITIONS {
+		t.Errorf("offline without definitions should be rejected, got %v", err)
+	}
+
+	config.Edge.OfflineBundlePath = dir
+	if _, err := loadOfflineDefinitions(&Configstate{Offline: &useOffline, CheckConnectivity: &check}, config, nil); err == nil {
+		t.Errorf("offline with the connectivity checks should be rejected")
+	}
+
+	file := filepath.Join(dir, offline.DEFINITIONS_DIR, "patterns.json")
+	if err := ioutil.WriteFile(file, []byte(`{"patterns": {"e2edev/sns": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadOfflineDefinitions(&Configstate{Offline: &useOffline}, config, nil); err == nil || !strings.Contains(err.Error(), file) {
+		t.Errorf("the error should name the file, got %v", err)
+	}
+	if defs, err := loadOfflineDefinitions(&Configstate{}, config, nil); defs != nil || err != nil {
+		t.Errorf("definitions that cannot be read should not be used without offline, got %v, error %v", defs, err)
+	}
+
+	if err := ioutil.WriteFile(file, []byte(`{"patterns": {"e2edev/sns": {"services": []}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if defs, err := loadOfflineDefinitions(&Configstate{Offline: &useOffline}, config, nil); err != nil || len(defs.Patterns) != 1 {
+		t.Errorf("the pattern should be read, got %v, error %v", defs, err)
+	}
+}
+
+// The definitions that were stored when the bundle was installed are used with the ones of its directory.
+func Test_loadOfflineDefinitions_stored(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanTestDir(dir)
+
+	arch := cutil.ArchString()
+	pattern := fmt.Sprintf(`{"name": "mypattern", "org": "myorg", "services": [{"serviceUrl": "wurl", "serviceOrgid": "myorg", "serviceArch": "%v", "serviceVersions": [{"version": "1.0.0"}]}]}`, arch)
+	service := fmt.Sprintf(`{"org": "myorg", "url": "wurl", "version": "1.0.0", "arch": "%v", "deployment": {"services": {"wurl": {"image": "wurl:1.0.0"}}}}`, arch)
+	if err := persistence.SaveOfflineDefinition(db, &persistence.OfflineDefinition{Kind: persistence.OFFLINE_DEFINITION_PATTERN, Key: "myorg/mypattern", Definition: []byte(pattern)}); err != nil {
+		t.Fatal(err)
+	} else if err := persistence.SaveOfflineDefinition(db, &persistence.OfflineDefinition{Kind: persistence.OFFLINE_DEFINITION_SERVICE, Key: fmt.Sprintf("myorg/wurl/1.0.0/%v", arch), Definition: []byte(service)}); err != nil {
+		t.Fatal(err)
+	}
+
+	useOffline := true
+	config := getBasicConfig()
+	config.Edge.OfflineBundlePath = filepath.Join(dir, "bundle")
+
+	// the bundle has no definitions directory, its stored definitions are used
+	defs, err := loadOfflineDefinitions(&Configstate{Offline: &useOffline}, config, db)
+	if err != nil {
+		t.Fatalf("the stored definitions should be used, error %v", err)
+	} else if _, ok := defs.Patterns["myorg/mypattern"]; !ok {
+		t.Errorf("the stored pattern should be used, got %v", defs.Patterns)
+	}
+	key := fmt.Sprintf("myorg/%v", cutil.FormExchangeIdForService("wurl", "1.0.0", arch))
+	if sd, ok := defs.Services[key]; !ok {
+		t.Errorf("the stored service should be used as %v, got %v", key, defs.Services)
+	} else if !strings.Contains(sd.Deployment, "wurl:1.0.0") {
+		t.Errorf("the deployment of the stored service should be kept, got %v", sd.Deployment)
+	}
+
+	// the definitions of the directory take precedence
+	bundle := writeOfflineBundle(t, map[string]string{
+		"patterns.json": fmt.Sprintf(`{"patterns": {"myorg/mypattern": {"label": "dir", "services": [{"serviceUrl": "wurl", "serviceOrgid": "myorg", "serviceArch": "%v", "serviceVersions": [{"version": "1.0.0"}]}]}}}`, arch),
+	})
+	defer os.RemoveAll(bundle)
+	config.Edge.OfflineBundlePath = bundle
+	if defs, err := loadOfflineDefinitions(&Configstate{Offline: &useOffline}, config, db); err != nil {
+		t.Fatalf("the definitions should be read, error %v", err)
+	} else if defs.Patterns["myorg/mypattern"].Label != "dir" {
+		t.Errorf("the pattern of the directory should be used, got %v", defs.Patterns["myorg/mypattern"])
+	} else if _, ok := defs.Services[key]; !ok {
+		t.Errorf("the stored service should be added, got %v", defs.Services)
+	}
+}
+
+// Only the failures to reach the exchange make it unreachable.
+func Test_exchangeReachable(t *testing.T) {
+
+	getDevice := func(err error) exchange.DeviceHandler {
+		return func(id string, token string) (*exchange.Device, error) {
+			if id != "myorg/myid" || token != "mytoken" {
+				t.Errorf("the node should be read with its credentials, got %v %v", id, token)
+			}
+			return nil, err
+		}
+	}
+
+	if !exchangeReachable(context.Background(), "myorg/myid", "mytoken", getDevice(nil)) {
+		t.Errorf("the exchange should be reachable")
+	}
+	if !exchangeReachable(context.Background(), "myorg/myid", "mytoken", getDevice(errors.New("Error invoking exchange, 401 Unauthorized"))) {
+		t.Errorf("the exchange should be reachable when the credentials are rejected")
+	}
+	if exchangeReachable(context.Background(), "myorg/myid", "mytoken", getDevice(errors.New("dial tcp: connection refused"))) {
+		t.Errorf("the exchange should not be reachable")
+	}
+}
+
+// An unregistered node is registered with the offline definitions when the exchange cannot be reached, without calling it.
+func Test_CreateHorizonDevice_offline(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	bundle := writeOfflineBundle(t, offlinePatternFiles())
+	defer os.RemoveAll(bundle)
+	defs, err := offline.LoadDefinitions(filepath.Join(bundle, offline.DEFINITIONS_DIR))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+	getOrg := func(org string, id string, token string) (*exchange.Organization, error) {
+		t.Errorf("the org should not be read from the exchange")
+		return nil, nil
+	}
+	getPatterns := func(org string, pattern string, id string, token string) (map[string]exchange.Pattern, error) {
+		t.Errorf("the pattern should not be read from the exchange")
+		return nil, nil
+	}
+	getVersion := func(id string, token string) (string, error) {
+		t.Errorf("the exchange version should not be read")
+		return "", nil
+	}
+	patchDevice := func(deviceId string, deviceToken string, pdr *exchange.PatchDeviceRequest) error {
+		t.Errorf("the exchange node should not be changed")
+		return nil
+	}
+	getDevice := func(id string, token string) (*exchange.Device, error) {
+		return nil, errors.New("dial tcp: connection refused")
+	}
+
+	hd := getBasicDevice("myorg", "mypattern")
+	errHandled, device, _ := CreateHorizonDevice(hd, errorhandler, getOrg, getPatterns, getVersion, patchDevice, getDevice, defs, events.NewEventStateManager(), db)
+	if errHandled {
+		t.Fatalf("unexpected error %v", myError)
+	} else if *device.Pattern != "myorg/mypattern" {
+		t.Errorf("the node should have the pattern, got %v", *device.Pattern)
+	}
+
+	if reg, err := persistence.FindOfflineRegistration(db); err != nil || reg == nil || reg.Dir != defs.Dir || reg.SetupTime != 0 {
+		t.Errorf("the offline registration should be recorded, got %v, error %v", reg, err)
+	}
+
+	// a pattern that is not in the definitions is rejected
+	if err := persistence.DeleteExchangeDevice(db); err != nil {
+		t.Fatal(err)
+	}
+	myError = nil
+	if errHandled, _, _ := CreateHorizonDevice(getBasicDevice("myorg", "otherpattern"), errorhandler, getOrg, getPatterns, getVersion, patchDevice, getDevice, defs, events.NewEventStateManager(), db); !errHandled {
+		t.Errorf("a pattern that is not in the offline definitions should be rejected")
+	}
+}
+
+// A node is configured from the offline definitions without calling the exchange.
+func Test_updateConfigstate_offline(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	bundle := writeOfflineBundle(t, offlinePatternFiles())
+	defer os.RemoveAll(bundle)
+
+	exchangeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("the exchange should not be called, got %v %v", r.Method, r.URL)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer exchangeServer.Close()
+
+	if _, err := persistence.SaveNewExchangeDevice(db, "myid", "mytoken", "myname", "device", false, "myorg", "myorg/mypattern", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := getBasicConfig()
+	cfg.Edge.OfflineBundlePath = bundle
+	cfg.Edge.ExchangeURL = exchangeServer.URL + "/"
+	a := &API{Manager: worker.Manager{Config: cfg, Messages: make(chan events.Message, 20)}, db: db}
+
+	var myError error
+	state, useOffline := persistence.CONFIGSTATE_CONFIGURED, true
+	errHandled, out := a.updateConfigstate(context.Background(), &Configstate{State: &state, Offline: &useOffline}, GetPassThroughErrorHandler(&myError), false, "")
+	if errHandled {
+		t.Fatalf("unexpected error %v", myError)
+	} else if *out.State != persistence.CONFIGSTATE_CONFIGURED {
+		t.Errorf("the node should be configured, got %v", *out.State)
+	} else if out.Offline == nil || !*out.Offline {
+		t.Errorf("the output should tell that the offline definitions were used, got %v", out)
+	}
+
+	if record, err := persistence.FindOfflineConfigstate(db); err != nil || record == nil || len(record.Patterns) != 1 || len(record.Services) != 1 {
+		t.Errorf("the definitions that configured the node should be recorded, got %v, error %v", record, err)
+	}
+	if services, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{}); err != nil || len(services) != 1 {
+		t.Errorf("the service of the pattern should be configured, got %v, error %v", services, err)
+	}
+}
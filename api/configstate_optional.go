@@ -0,0 +1,87 @@
+package api
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/persistence"
+)
+
+// Returns the top-level services that the configstate PUT body makes optional, see Configstate.OptionalServices. The
+// org of a service defaults to the node's org, and a service listed more than once is listed once. An
+// APIUserInputError is returned if a service has no url.
+func newOptionalServiceSpecs(specs persistence.ServiceSpecs, nodeOrg string) (persistence.ServiceSpecs, error) {
+	optional := make(persistence.ServiceSpecs, 0, len(specs))
+	for i, spec := range specs {
+		if spec.Url == "" {
+			return nil, NewAPIUserInputError("the url of the optional service must be set", fmt.Sprintf("configstate.optional_services[%v].url", i)).WithCode(ERR_INVALID_INPUT)
+		}
+		if spec.Org == "" {
+			spec.Org = nodeOrg
+		}
+		if !isExcludedService(optional, spec.Url, spec.Org) {
+			optional = append(optional, spec)
+		}
+	}
+	return optional, nil
+}
+
+// Set the optional services of the configstate PUT body on the node, before the node is changed to the requested state
+// so that the autoconfig uses them. As the excluded services, they can only be changed while the node is configuring.
+// pDevice is updated with them.
+func updateOptionalServices(cfg *Configstate, pDevice *persistence.ExchangeDevice, db *bolt.DB) error {
+	optional, err := newOptionalServiceSpecs(*cfg.OptionalServices, pDevice.Org)
+	if err != nil {
+		return err
+	} else if sameExclusions(pDevice.Config.Optional, optional) {
+		return nil
+	}
+
+	if pDevice.Config.State != persistence.CONFIGSTATE_CONFIGURING {
+		return NewAPIUserInputError(fmt.Sprintf("The optional services cannot be changed while the node is '%v', the autoconfig already ran with them. Change the node to '%v', and then to '%v' with the new optional_services to run the autoconfig again.", pDevice.Config.State, persistence.CONFIGSTATE_CONFIGURING, persistence.CONFIGSTATE_CONFIGURED), "configstate.optional_services").WithCode(ERR_INVALID_STATE)
+	}
+
+	if len(optional) == 0 {
+		optional = nil
+	}
+	if _, err := pDevice.SetOptionalServices(db, pDevice.Id, optional); err != nil {
+		return NewSystemError(fmt.Sprintf("error persisting the optional services %v, error %v", optional, err)).WithCode(ERR_DATABASE)
+	}
+	pDevice.Config.Optional = optional
+	return nil
+}
+
+// The optional top-level services of the autoconfig of a pattern, and the ones of them that it skipped because they
+// could not be resolved. A nil optionalServices has none, so every service is required.
+type optionalServices struct {
+	specs   persistence.ServiceSpecs
+	skipped []ServiceConfigProblem
+}
+
+func newOptionalServices(specs persistence.ServiceSpecs) *optionalServices {
+	return &optionalServices{specs: specs, skipped: make([]ServiceConfigProblem, 0, len(specs))}
+}
+
+// Returns true if the top-level service is optional.
+func (o *optionalServices) isOptional(url string, org string) bool {
+	return o != nil && isExcludedService(o.specs, url, org)
+}
+
+// Record that the optional top-level service is skipped, with the reason it cannot be resolved.
+func (o *optionalServices) skip(problem ServiceConfigProblem) {
+	if !o.isSkipped(problem.Url, problem.Org) {
+		o.skipped = append(o.skipped, problem)
+	}
+}
+
+// Returns true if the top-level service was skipped.
+func (o *optionalServices) isSkipped(url string, org string) bool {
+	return o != nil && hasServiceConfigProblem(o.skipped, url, org)
+}
+
+// The reasons the optional services were skipped, for the Warnings of the output. nil when none were skipped.
+func (o *optionalServices) warnings() []ServiceConfigProblem {
+	if o == nil || len(o.skipped) == 0 {
+		return nil
+	}
+	return o.skipped
+}
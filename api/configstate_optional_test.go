@@ -0,0 +1,146 @@
+// +build unit
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+)
+
+// A pattern with a required service and an optional analytics service, whose older version cannot be resolved.
+func getOptionalTestHandlers(org string, failRequired bool) (exchange.PatternHandler, exchange.ServiceDefResolverHandler) {
+	patternHandler := func(pOrg string, pattern string) (map[string]exchange.Pattern, error) {
+		srs := []exchange.ServiceReference{
+			{ServiceURL: "wurl", ServiceOrg: org, ServiceArch: cutil.ArchString(), ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}}},
+			{ServiceURL: "analytics", ServiceOrg: org, ServiceArch: cutil.ArchString(), ServiceVersions: []exchange.WorkloadChoice{{Version: "2.0.0"}, {Version: "1.0.0"}}},
+		}
+		return map[string]exchange.Pattern{fmt.Sprintf("%v/%v", pOrg, pattern): {Label: "label", Services: srs}}, nil
+	}
+
+	sResolver := func(wUrl string, wOrg string, wVersion string, wArch string) (map[string]exchange.ServiceDefinition, *exchange.ServiceDefinition, string, error) {
+		if (wUrl == "analytics" && wVersion == "1.0.0") || (wUrl == "wurl" && failRequired) {
+			return nil, nil, "", errors.New("dependency not found")
+		}
+		deps := map[string]exchange.ServiceDefinition{
+			fmt.Sprintf("%v/%v-dep_1.0.0_%v", wOrg, wUrl, wArch): {URL: wUrl + "-dep", Version: "1.0.0", Arch: wArch, Sharable: exchange.MS_SHARING_MODE_MULTIPLE},
+		}
+		sd := &exchange.ServiceDefinition{URL: wUrl, Version: wVersion, Arch: wArch, Sharable: exchange.MS_SHARING_MODE_EXCLUSIVE,
+			RequiredServices: []exchange.ServiceDependency{{URL: wUrl + "-dep", Org: wOrg, Version: "1.0.0", Arch: wArch}}}
+		return deps, sd, fmt.Sprintf("%v/%v_%v_%v", wOrg, wUrl, wVersion, wArch), nil
+	}
+	return patternHandler, sResolver
+}
+
+// An optional service that cannot be resolved is skipped with a warning, the required ones still fail the resolution.
+func Test_getSpecRefsForPattern_optional_services(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	myOrg := "myorg"
+	optionalSpecs := persistence.ServiceSpecs{{Url: "analytics", Org: myOrg}}
+	patternHandler, sResolver := getOptionalTestHandlers(myOrg, false)
+
+	// without the optional services, the analytics service fails the resolution
+	if _, _, err := getSpecRefsForPattern(context.Background(), persistence.DEVICE_TYPE_DEVICE, "apattern", myOrg, patternHandler, sResolver, db, getBasicConfig(), nil, nil, "", false, false, nil); err == nil {
+		t.Errorf("the analytics service should fail the resolution")
+	} else if multiErr, ok := err.(*MultiServiceConfigError); !ok || len(multiErr.Services) != 1 || multiErr.Services[0].Url != "analytics" {
+		t.Errorf("only the analytics service should have a problem, got (%T) %v", err, err)
+	}
+
+	// the optional service is skipped, with all its version choices, and its dependencies are not configured
+	optional := newOptionalServices(optionalSpecs)
+	specs, _, err := getSpecRefsForPattern(context.Background(), persistence.DEVICE_TYPE_DEVICE, "apattern", myOrg, patternHandler, sResolver, db, getBasicConfig(), nil, optional, "", false, false, nil)
+	if err != nil {
+		t.Fatalf("unexpected error (%T) %v", err, err)
+	} else if len(*specs) != 1 || (*specs)[0].SpecRef != "wurl-dep" {
+		t.Errorf("only the dependency of the required service should be resolved, got %v", *specs)
+	} else if warnings := optional.warnings(); len(warnings) != 1 || warnings[0].Url != "analytics" || warnings[0].Version != "1.0.0" || warnings[0].Code != ERR_SERVICE_NOT_FOUND {
+		t.Errorf("the analytics service should be skipped with a warning, got %v", warnings)
+	} else if !optional.isSkipped("analytics", myOrg) || optional.isSkipped("wurl", myOrg) {
+		t.Errorf("only the analytics service should be skipped")
+	}
+
+	// the required services keep failing the resolution
+	patternHandler, sResolver = getOptionalTestHandlers(myOrg, true)
+	if _, _, err := getSpecRefsForPattern(context.Background(), persistence.DEVICE_TYPE_DEVICE, "apattern", myOrg, patternHandler, sResolver, db, getBasicConfig(), nil, newOptionalServices(optionalSpecs), "", false, false, nil); err == nil {
+		t.Errorf("the required service should fail the resolution")
+	} else if multiErr, ok := err.(*MultiServiceConfigError); !ok || len(multiErr.Services) != 1 || multiErr.Services[0].Url != "wurl" {
+		t.Errorf("only the required service should have a problem, got (%T) %v", err, err)
+	}
+}
+
+// The autoconfig configures the node without the optional service that cannot be resolved, and returns it in the
+// warnings. The optional services cannot be changed once the node is configured.
+func Test_UpdateConfigstate_optional_services(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	myOrg := "myorg"
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, myOrg, "mypattern", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	patternHandler, sResolver := getOptionalTestHandlers(myOrg, false)
+	sHandler := getVariableServiceHandler(exchange.UserInput{})
+
+	update := func(state string, optional *persistence.ServiceSpecs) (bool, *Configstate, []events.Message, error) {
+		var myError error
+		cs := &Configstate{State: &state, OptionalServices: optional}
+		errHandled, cfg, msgs := UpdateConfigstate(context.Background(), cs, GetPassThroughErrorHandler(&myError), patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+		return errHandled, cfg, msgs, myError
+	}
+
+	// a service without a url
+	if errHandled, _, _, myError := update(persistence.CONFIGSTATE_CONFIGURED, &persistence.ServiceSpecs{{Org: myOrg}}); !errHandled {
+		t.Fatalf("an optional service without a url should be rejected")
+	} else if apiErr, ok := myError.(*APIUserInputError); !ok || apiErr.Input != "configstate.optional_services[0].url" {
+		t.Errorf("myError has the wrong type or input (%T) %v", myError, myError)
+	}
+
+	// the analytics service is optional, its org is the node's org
+	errHandled, cfg, _, myError := update(persistence.CONFIGSTATE_CONFIGURED, &persistence.ServiceSpecs{{Url: "analytics"}})
+	if errHandled {
+		t.Fatalf("unexpected error (%T) %v", myError, myError)
+	} else if *cfg.State != persistence.CONFIGSTATE_CONFIGURED {
+		t.Errorf("wrong state field %v", *cfg)
+	} else if len(cfg.Warnings) != 1 || cfg.Warnings[0].Url != "analytics" || cfg.Warnings[0].Org != myOrg {
+		t.Errorf("the analytics service should be in the warnings, got %v", cfg.Warnings)
+	} else if cfg.OptionalServices == nil || len(*cfg.OptionalServices) != 1 || (*cfg.OptionalServices)[0].Org != myOrg {
+		t.Errorf("the optional service should be returned with the node's org, got %v", cfg.OptionalServices)
+	}
+
+	if msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()}); err != nil {
+		t.Fatalf("unable to read the services, error %v", err)
+	} else {
+		for _, msdef := range msdefs {
+			if msdef.SpecRef == "analytics" || msdef.SpecRef == "analytics-dep" {
+				t.Errorf("the analytics service and its dependency should not be configured, got %v", msdefs)
+			}
+		}
+	}
+
+	// the same optional services are a no-op, changing them is rejected
+	if errHandled, _, _, myError := update(persistence.CONFIGSTATE_CONFIGURED, &persistence.ServiceSpecs{{Url: "analytics", Org: myOrg}}); errHandled {
+		t.Errorf("the same optional services should not be rejected, error %v", myError)
+	}
+	if errHandled, _, _, myError := update(persistence.CONFIGSTATE_CONFIGURED, &persistence.ServiceSpecs{}); !errHandled {
+		t.Errorf("changing the optional services of a configured node should be rejected")
+	} else if apiErr, ok := myError.(*APIUserInputError); !ok || apiErr.Code != ERR_INVALID_STATE {
+		t.Errorf("myError has the wrong type or code (%T) %v", myError, myError)
+	}
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/events"
+	"sync"
+	"time"
+)
+
+// The progress of the last autoconfig of the services of the node's pattern, as returned by GET /node/configstate/progress.
+type ConfigstateProgress struct {
+	Phase             string `json:"phase"`
+	Pattern           string `json:"pattern"`
+	ServicesResolved  int    `json:"services_resolved"`
+	ServicesToResolve int    `json:"services_to_resolve"`
+	ServicesCreated   int    `json:"services_created"`
+	ServicesToCreate  int    `json:"services_to_create"`
+	Complete          bool   `json:"complete"`
+	Error             string `json:"error,omitempty"`
+	StartTime         uint64 `json:"start_time"`
+	LastUpdateTime    uint64 `json:"last_update_time"`
+}
+
+func (p ConfigstateProgress) String() string {
+	return fmt.Sprintf("Phase: %v, Pattern: %v, ServicesResolved: %v/%v, ServicesCreated: %v/%v, Complete: %v, Error: %v", p.Phase, p.Pattern, p.ServicesResolved, p.ServicesToResolve, p.ServicesCreated, p.ServicesToCreate, p.Complete, p.Error)
+}
+
+// The most recent progress record, kept in memory only, and the function that publishes the progress messages.
+var progressLock sync.Mutex
+var lastProgress *ConfigstateProgress
+var publishProgress func(events.Message)
+
+// Set the function that publishes the ConfigstateProgressMessages on the message bus. The progress is still recorded
+// when there is none.
+func SetConfigstateProgressPublisher(publish func(events.Message)) {
+	progressLock.Lock()
+	defer progressLock.Unlock()
+	publishProgress = publish
+}
+
+// Returns a copy of the most recent progress record, nil if the node was never configured since the agent started.
+func GetConfigstateProgress() *ConfigstateProgress {
+	progressLock.Lock()
+	defer progressLock.Unlock()
+	if lastProgress == nil {
+		return nil
+	}
+	progress := *lastProgress
+	return &progress
+}
+
+// A publisher that queues the messages and sends them on the message bus from its own goroutine, in order, so that the
+// autoconfig never waits on the message bus. The messages that do not fit in the queue are dropped, the progress
+// record still has the latest progress.
+type progressPublisher struct {
+	queue   chan events.Message
+	lock    sync.Mutex
+	pending int           // the messages queued and not sent yet
+	idle    chan struct{} // closed when no message is pending
+}
+
+func newProgressPublisher(messages chan events.Message, queueSize int) *progressPublisher {
+	p := &progressPublisher{queue: make(chan events.Message, queueSize), idle: make(chan struct{})}
+	close(p.idle)
+	go func() {
+		for msg := range p.queue {
+			messages <- msg
+			p.sent()
+		}
+	}()
+	return p
+}
+
+func (p *progressPublisher) publish(msg events.Message) {
+	p.lock.Lock()
+	if p.pending == 0 {
+		p.idle = make(chan struct{})
+	}
+	p.pending++
+	p.lock.Unlock()
+
+	select {
+	case p.queue <- msg:
+	default:
+		glog.Warningf(apiLogString(fmt.Sprintf("dropped configstate progress message %v, the queue is full", msg)))
+		p.sent()
+	}
+}
+
+func (p *progressPublisher) sent() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.pending--; p.pending == 0 {
+		close(p.idle)
+	}
+}
+
+// Wait for the queued messages to be sent, at most timeout. Returns false when some are still queued.
+func (p *progressPublisher) flush(timeout time.Duration) bool {
+	p.lock.Lock()
+	idle := p.idle
+	p.lock.Unlock()
+
+	select {
+	case <-idle:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Tracks the progress of one autoconfig. The methods can be called on a nil tracker, they do nothing, so that the
+// functions shared with the dry run and the service config API do not need to check.
+type autoconfigProgress struct {
+	progress ConfigstateProgress
+}
+
+func newAutoconfigProgress(pattern string) *autoconfigProgress {
+	now := uint64(time.Now().Unix())
+	return &autoconfigProgress{progress: ConfigstateProgress{Pattern: pattern, StartTime: now, LastUpdateTime: now}}
+}
+
+// The pattern was read from the exchange, toResolve of its services will be resolved.
+func (p *autoconfigProgress) patternFetched(toResolve int) {
+	if p == nil {
+		return
+	}
+	p.progress.ServicesToResolve = toResolve
+	p.update(events.CONFIGSTATE_PHASE_PATTERN_FETCHED)
+}
+
+func (p *autoconfigProgress) serviceResolved() {
+	if p == nil {
+		return
+	}
+	p.progress.ServicesResolved++
+	p.update(events.CONFIGSTATE_PHASE_WORKLOAD_RESOLVED)
+}
+
+// toCreate services will be created.
+func (p *autoconfigProgress) creating(toCreate int) {
+	if p == nil {
+		return
+	}
+	p.progress.ServicesToCreate = toCreate
+}
+
+func (p *autoconfigProgress) serviceCreated() {
+	if p == nil {
+		return
+	}
+	p.progress.ServicesCreated++
+	p.update(events.CONFIGSTATE_PHASE_SERVICE_CREATED)
+}
+
+// The node was changed to configured.
+func (p *autoconfigProgress) complete() {
+	if p == nil {
+		return
+	}
+	p.progress.Complete = true
+	p.update(events.CONFIGSTATE_PHASE_COMPLETE)
+}
+
+// The autoconfig failed, the node was not changed to configured.
+func (p *autoconfigProgress) fail(err error) {
+	if p == nil {
+		return
+	}
+	p.progress.Complete = true
+	p.progress.Error = err.Error()
+	p.update(events.CONFIGSTATE_PHASE_FAILED)
+}
+
+// Returns true if the autoconfig completed and the node was changed to configured.
+func (p *autoconfigProgress) succeeded() bool {
+	return p != nil && p.progress.Phase == events.CONFIGSTATE_PHASE_COMPLETE
+}
+
+// Record the progress as the most recent one and publish it.
+func (p *autoconfigProgress) update(phase string) {
+	p.progress.Phase = phase
+	p.progress.LastUpdateTime = uint64(time.Now().Unix())
+	glog.V(5).Infof(apiLogString(fmt.Sprintf("configstate progress: %v", p.progress)))
+
+	progressLock.Lock()
+	progress := p.progress
+	lastProgress = &progress
+	publish := publishProgress
+	progressLock.Unlock()
+
+	if publish != nil {
+		publish(events.NewConfigstateProgressMessage(events.CONFIGSTATE_PROGRESS, progress.Phase, progress.Pattern, progress.ServicesResolved, progress.ServicesToResolve, progress.ServicesCreated, progress.ServicesToCreate, progress.Error))
+	}
+}
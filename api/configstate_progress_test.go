@@ -0,0 +1,132 @@
+// +build unit
+
+package api
+
+import (
+	"context"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+	"testing"
+)
+
+// Record the progress messages that the autoconfig publishes.
+func recordConfigstateProgress() *[]*events.ConfigstateProgressMessage {
+	msgs := []*events.ConfigstateProgressMessage{}
+	SetConfigstateProgressPublisher(func(msg events.Message) {
+		msgs = append(msgs, msg.(*events.ConfigstateProgressMessage))
+	})
+	return &msgs
+}
+
+func Test_ConfigstateProgress_complete(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+	defer SetConfigstateProgressPublisher(nil)
+
+	cs := getBasicConfigstate()
+	state := persistence.CONFIGSTATE_CONFIGURED
+	cs.State = &state
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+
+	_, err = persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, "myorg", "mypattern", persistence.CONFIGSTATE_CONFIGURING)
+	if err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	sref := exchange.ServiceReference{
+		ServiceURL:      "wurl",
+		ServiceOrg:      "myorg",
+		ServiceArch:     cutil.ArchString(),
+		ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}},
+	}
+	sResolver := getVariableServiceDefResolver("http://utest.com/mservice", "myorg", "1.0.0", cutil.ArchString(), nil)
+	sHandler := getVariableServiceHandler(exchange.UserInput{})
+
+	msgs := recordConfigstateProgress()
+	errHandled, _, _ := UpdateConfigstate(context.Background(), cs, errorhandler, getVariablePatternHandler(sref), sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+	if errHandled {
+		t.Fatalf("unexpected error %v", myError)
+	}
+
+	// one dependent service and one top-level service are created
+	phases := []string{}
+	for _, msg := range *msgs {
+		phases = append(phases, msg.Phase)
+	}
+	expected := []string{events.CONFIGSTATE_PHASE_PATTERN_FETCHED, events.CONFIGSTATE_PHASE_WORKLOAD_RESOLVED, events.CONFIGSTATE_PHASE_SERVICE_CREATED, events.CONFIGSTATE_PHASE_SERVICE_CREATED, events.CONFIGSTATE_PHASE_COMPLETE}
+	if len(phases) != len(expected) {
+		t.Fatalf("the phases should be %v, are %v", expected, phases)
+	}
+	for i := range expected {
+		if phases[i] != expected[i] {
+			t.Errorf("the phases should be %v, are %v", expected, phases)
+			break
+		}
+	}
+
+	if created := (*msgs)[2]; created.ServicesCreated != 1 || created.ServicesToCreate != 2 {
+		t.Errorf("the first service should be created out of 2, is %v", created)
+	}
+
+	if progress := GetConfigstateProgress(); progress == nil {
+		t.Errorf("the progress should be recorded")
+	} else if !progress.Complete || progress.Phase != events.CONFIGSTATE_PHASE_COMPLETE || progress.Error != "" {
+		t.Errorf("the progress should be complete, is %v", progress)
+	} else if progress.Pattern != "myorg/mypattern" || progress.ServicesResolved != 1 || progress.ServicesToResolve != 1 || progress.ServicesCreated != 2 {
+		t.Errorf("wrong progress %v", progress)
+	}
+}
+
+func Test_ConfigstateProgress_failed(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+	defer SetConfigstateProgressPublisher(nil)
+
+	cs := getBasicConfigstate()
+	state := persistence.CONFIGSTATE_CONFIGURED
+	cs.State = &state
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+
+	_, err = persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, "myorg", "mypattern", persistence.CONFIGSTATE_CONFIGURING)
+	if err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	sref := exchange.ServiceReference{
+		ServiceURL:      "wurl",
+		ServiceOrg:      "myorg",
+		ServiceArch:     cutil.ArchString(),
+		ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}},
+	}
+	sResolver := getVariableServiceDefResolver("http://utest.com/mservice", "myorg", "1.0.0", cutil.ArchString(), nil)
+	sHandler := getVariableServiceHandler(exchange.UserInput{Name: "missingVar", Label: "label", Type: "string"})
+
+	msgs := recordConfigstateProgress()
+	if errHandled, _, _ := UpdateConfigstate(context.Background(), cs, errorhandler, getVariablePatternHandler(sref), sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig()); !errHandled {
+		t.Fatalf("expected error")
+	}
+
+	if len(*msgs) == 0 || (*msgs)[len(*msgs)-1].Phase != events.CONFIGSTATE_PHASE_FAILED {
+		t.Errorf("the last progress message should be the failure, are %v", *msgs)
+	}
+
+	if progress := GetConfigstateProgress(); progress == nil {
+		t.Errorf("the progress should be recorded")
+	} else if !progress.Complete || progress.Phase != events.CONFIGSTATE_PHASE_FAILED || progress.Error == "" {
+		t.Errorf("the progress should be failed, is %v", progress)
+	}
+}
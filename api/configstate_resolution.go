@@ -0,0 +1,52 @@
+package api
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+	"net/http"
+)
+
+// The services that the autoconfig registered the last time the node was changed to configured, and the version of the
+// pattern they were resolved from. When the pattern is checked in the exchange, Stale tells whether it changed since.
+type ConfigstateResolutionOutput struct {
+	persistence.ConfigstateResolution
+	Stale                     *bool  `json:"stale,omitempty"`                        // the pattern was changed or deleted in the exchange since
+	CurrentPatternLastUpdated string `json:"current_pattern_last_updated,omitempty"` // the lastUpdated of the pattern in the exchange now
+}
+
+// Returns the last resolution of the services of the node's pattern, as for a GET on /node/configstate/resolution.
+// When check is set, the pattern is read from the exchange with getPatterns, to tell whether the resolution is stale.
+// The services configured from the autoconfig manifest are not checked, the manifest is not in the exchange.
+func FindConfigstateResolutionForOutput(db *bolt.DB, check bool, getPatterns exchange.PatternHandler) (*ConfigstateResolutionOutput, error) {
+
+	resolution, err := persistence.FindConfigstateResolution(db)
+	if err != nil {
+		return nil, NewSystemError(fmt.Sprintf("Unable to read the resolution of the services of the node, error %v", err)).WithCode(ERR_DATABASE)
+	} else if resolution == nil {
+		return nil, NewNotFoundError("The services of the node's pattern have not been configured since the node was last changed to configuring.", "node/configstate/resolution")
+	}
+
+	out := &ConfigstateResolutionOutput{ConfigstateResolution: *resolution}
+	if !check || resolution.FromManifest {
+		return out, nil
+	}
+
+	patOrg, patName := exchange.GetOrg(resolution.Pattern), exchange.GetId(resolution.Pattern)
+	patterns, err := getPatterns(patOrg, patName)
+	if exErr, ok := exchange.AsExchangeError(err); ok && exErr.Status == http.StatusNotFound {
+		patterns = nil
+	} else if err != nil {
+		return nil, patternReadError(resolution.Pattern, err)
+	}
+
+	// A pattern that was deleted is stale too.
+	stale := true
+	if pattern, ok := patterns[resolution.Pattern]; ok {
+		out.CurrentPatternLastUpdated = pattern.LastUpdated
+		stale = pattern.LastUpdated != resolution.PatternLastUpdated
+	}
+	out.Stale = &stale
+	return out, nil
+}
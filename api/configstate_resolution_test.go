@@ -0,0 +1,69 @@
+// +build unit
+
+package api
+
+import (
+	"testing"
+
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+)
+
+// The resolution is stale once the pattern is changed or deleted in the exchange, which is only read when asked for.
+func Test_FindConfigstateResolutionForOutput(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	getPatterns := func(lastUpdated string) exchange.PatternHandler {
+		return func(org string, pattern string) (map[string]exchange.Pattern, error) {
+			if lastUpdated == "" {
+				return map[string]exchange.Pattern{}, nil
+			}
+			return map[string]exchange.Pattern{org + "/" + pattern: exchange.Pattern{Label: "label", LastUpdated: lastUpdated}}, nil
+		}
+	}
+
+	if _, err := FindConfigstateResolutionForOutput(db, true, getPatterns("t1")); err == nil {
+		t.Errorf("there should be no resolution before the node is configured")
+	} else if _, ok := err.(*NotFoundError); !ok {
+		t.Errorf("the error should be a NotFoundError, is (%T) %v", err, err)
+	}
+
+	saved := &persistence.ConfigstateResolution{
+		Timestamp:          1600000000,
+		Pattern:            "myorg/mypattern",
+		PatternLastUpdated: "t1",
+		APISpecs:           policy.APISpecList{*policy.APISpecification_Factory("myservice", "myorg", "[1.0.0,2.0.0)", "amd64")},
+	}
+	if err := persistence.SaveConfigstateResolution(db, saved); err != nil {
+		t.Fatalf("failed to save the configstate resolution, error %v", err)
+	}
+
+	tests := []struct {
+		check       bool
+		lastUpdated string
+		stale       *bool
+	}{
+		{false, "t2", nil},
+		{true, "t1", &[]bool{false}[0]},
+		{true, "t2", &[]bool{true}[0]},
+		{true, "", &[]bool{true}[0]},
+	}
+	for _, test := range tests {
+		out, err := FindConfigstateResolutionForOutput(db, test.check, getPatterns(test.lastUpdated))
+		if err != nil {
+			t.Errorf("failed to get the resolution, error %v", err)
+		} else if len(out.APISpecs) != 1 || out.Pattern != saved.Pattern {
+			t.Errorf("the output should have the saved resolution, got %v", out)
+		} else if (test.stale == nil) != (out.Stale == nil) || (test.stale != nil && *test.stale != *out.Stale) {
+			t.Errorf("check %v with the pattern last updated at %q: stale should be %v, got %v", test.check, test.lastUpdated, test.stale, out.Stale)
+		} else if test.check && out.CurrentPatternLastUpdated != test.lastUpdated {
+			t.Errorf("the current lastUpdated should be %q, got %q", test.lastUpdated, out.CurrentPatternLastUpdated)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"fmt"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/semanticversion"
+	"sort"
+	"strings"
+)
+
+// The version ranges that the services of the pattern are pinned to by the configstate PUT body, see Configstate.Versions.
+// The autoconfig registers a pinned service with its pinned range rather than with all the versions the pattern allows.
+type versionPins struct {
+	ranges    map[string]string // the pinned range by org/url, in canonical form
+	effective map[string]string // the range each pinned service is registered with
+}
+
+// The key of a service in the pinned versions, so that the same URL written differently is pinned once.
+func versionPinKey(url string, org string) string {
+	return cutil.FormOrgSpecUrl(cutil.NormalizeSpecURL(url), org)
+}
+
+// Returns the pinned versions of the configstate PUT body, or an APIUserInputError if any of them is not a service
+// with a valid version range.
+func newVersionPins(versions map[string]string) (*versionPins, error) {
+	pins := &versionPins{ranges: map[string]string{}, effective: map[string]string{}}
+	for orgUrl, vr := range versions {
+		org, url := cutil.SplitOrgSpecUrl(orgUrl)
+		if org == "" || url == "" {
+			return nil, NewAPIUserInputError(fmt.Sprintf("the service %v must be given as org/url", orgUrl), "configstate.versions").WithCode(ERR_INVALID_VERSION_RANGE)
+		}
+		canonical, err := semanticversion.CanonicalVersionRange(vr)
+		if err != nil {
+			return nil, NewAPIUserInputError(fmt.Sprintf("the version range %v of service %v is not valid, error %v", vr, orgUrl, err), "configstate.versions").WithCode(ERR_INVALID_VERSION_RANGE)
+		}
+		pins.ranges[versionPinKey(url, org)] = canonical
+	}
+	return pins, nil
+}
+
+// Returns the version range to register a dependent service with, given the range that the pattern's services allow.
+// A pinned service gets the intersection of both ranges, an APIUserInputError is returned if they do not intersect.
+func (p *versionPins) dependencyRange(url string, org string, allowed string) (string, error) {
+	key := versionPinKey(url, org)
+	pinned, ok := p.ranges[key]
+	if !ok {
+		return allowed, nil
+	}
+
+	intersection, ok, err := semanticversion.IntersectVersionRanges(pinned, allowed)
+	if err != nil {
+		return "", NewSystemError(fmt.Sprintf("unable to intersect the version ranges %v and %v of service %v, error %v", pinned, allowed, key, err)).WithCode(ERR_INVALID_VERSION_RANGE)
+	} else if !ok {
+		return "", NewAPIUserInputError(fmt.Sprintf("the version range %v of service %v does not intersect the version range %v that the pattern allows", pinned, key, allowed), "configstate.versions").WithCode(ERR_INVALID_VERSION_RANGE)
+	}
+	p.effective[key] = intersection
+	return intersection, nil
+}
+
+// Returns the version range to register a top-level service with, given the versions of it that the pattern lists. A
+// pinned service gets its pinned range, an APIUserInputError is returned if none of the versions is in it.
+func (p *versionPins) topLevelRange(url string, org string, choices []exchange.WorkloadChoice) (string, error) {
+	key := versionPinKey(url, org)
+	pinned, ok := p.ranges[key]
+	if !ok {
+		return "[0.0.0,INFINITY)", nil
+	}
+
+	versions := make([]string, 0, len(choices))
+	for _, choice := range choices {
+		if inRange, err := semanticversion.VersionInRange(choice.Version, pinned); err != nil {
+			return "", NewSystemError(fmt.Sprintf("unable to check version %v of service %v against the version range %v, error %v", choice.Version, key, pinned, err)).WithCode(ERR_INVALID_VERSION_RANGE)
+		} else if inRange {
+			p.effective[key] = pinned
+			return pinned, nil
+		}
+		versions = append(versions, choice.Version)
+	}
+	return "", NewAPIUserInputError(fmt.Sprintf("the version range %v of service %v does not contain any of the versions %v that the pattern allows", pinned, key, strings.Join(versions, ",")), "configstate.versions").WithCode(ERR_INVALID_VERSION_RANGE)
+}
+
+// Returns an APIUserInputError if any of the pinned services is not one of the services of the pattern.
+func (p *versionPins) checkAllUsed(pattern string) error {
+	unused := make([]string, 0)
+	for key := range p.ranges {
+		if _, ok := p.effective[key]; !ok {
+			unused = append(unused, key)
+		}
+	}
+	if len(unused) != 0 {
+		sort.Strings(unused)
+		return NewAPIUserInputError(fmt.Sprintf("the services %v are not services of pattern %v for this node", strings.Join(unused, ","), pattern), "configstate.versions").WithCode(ERR_INVALID_VERSION_RANGE)
+	}
+	return nil
+}
+
+// Returns the version range that each pinned service is registered with, nil if no service is pinned.
+func (p *versionPins) pinned() map[string]string {
+	if len(p.effective) == 0 {
+		return nil
+	}
+	return p.effective
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The names of the connectivity checks.
+const (
+	CONNECTIVITY_EXCHANGE_DNS   = "exchange_dns"   // the host of the exchange URL resolves
+	CONNECTIVITY_EXCHANGE_NODE  = "exchange_node"  // the node reads its own exchange record with its token
+	CONNECTIVITY_IMAGE_REGISTRY = "image_registry" // the image registry of the config answers
+)
+
+// How long each connectivity check can take.
+const connectivityCheckTimeout = 10 * time.Second
+
+// The result of a connectivity check, how long it took and why it failed.
+type ConnectivityCheck struct {
+	Name      string `json:"name"`
+	Target    string `json:"target,omitempty"` // the host or URL that was checked
+	Passed    bool   `json:"passed"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+	err       error  // the error of the check, to tell the failures apart
+}
+
+// The connectivity of the node, as for a GET on /node/connectivity. Passed is set when all the checks passed.
+type ConnectivityOutput struct {
+	Passed bool                `json:"passed"`
+	Checks []ConnectivityCheck `json:"checks"`
+}
+
+// Returns the reason that the node failed the checks: the exchange rejected its credentials, or it cannot reach the
+// exchange or the image registry.
+func (c ConnectivityOutput) failureReason() string {
+	for _, check := range c.Checks {
+		if !check.Passed && check.Name == CONNECTIVITY_EXCHANGE_NODE && exchangeErrorReason(check.err, "") == ERR_EXCHANGE_CREDENTIALS {
+			return ERR_EXCHANGE_CREDENTIALS
+		}
+	}
+	return ERR_CONNECTIVITY
+}
+
+// Returns the checks that failed, for the messages.
+func (c ConnectivityOutput) failures() string {
+	failed := make([]string, 0, len(c.Checks))
+	for _, check := range c.Checks {
+		if !check.Passed {
+			failed = append(failed, fmt.Sprintf("%v %v: %v", check.Name, check.Target, check.Error))
+		}
+	}
+	return strings.Join(failed, "; ")
+}
+
+// Check that the node can reach the exchange and use it with its credentials, so that a bad exchange URL or an expired
+// token is found before the node is configured rather than deep in the resolution of its services: the host of the
+// exchange URL is resolved, and the node's own exchange record is read with its token. The image registry is also
+// checked when Edge.ImageRegistryURL is set. pDevice is nil when the node is not registered, its record then cannot be
+// read. The checks stop when ctx is done.
+func CheckConnectivity(ctx context.Context, pDevice *persistence.ExchangeDevice, getDevice exchange.DeviceHandler, config *config.HorizonConfig) *ConnectivityOutput {
+
+	out := &ConnectivityOutput{Passed: true, Checks: make([]ConnectivityCheck, 0, 3)}
+	run := func(name string, target string, check func(ctx context.Context) error) {
+		ctx, cancel := context.WithTimeout(ctx, connectivityCheckTimeout)
+		defer cancel()
+
+		start := time.Now()
+		err := check(ctx)
+		result := ConnectivityCheck{Name: name, Target: target, Passed: err == nil, LatencyMs: time.Since(start).Milliseconds()}
+		if err != nil {
+			result.Error = err.Error()
+			result.err = err
+			out.Passed = false
+		}
+		out.Checks = append(out.Checks, result)
+	}
+
+	exchangeHost := ""
+	if u, err := url.Parse(config.Edge.ExchangeURL); err == nil {
+		exchangeHost = u.Hostname()
+	}
+	run(CONNECTIVITY_EXCHANGE_DNS, exchangeHost, func(ctx context.Context) error {
+		if exchangeHost == "" {
+			return fmt.Errorf("the exchange URL %q of the config has no host", config.Edge.ExchangeURL)
+		}
+		_, err := net.DefaultResolver.LookupHost(ctx, exchangeHost)
+		return err
+	})
+
+	run(CONNECTIVITY_EXCHANGE_NODE, config.Edge.ExchangeURL, func(ctx context.Context) error {
+		if pDevice == nil {
+			return fmt.Errorf("the node is not registered, it has no exchange credentials")
+		}
+		_, err := getDeviceWithContext(ctx, getDevice)(fmt.Sprintf("%v/%v", pDevice.Org, pDevice.Id), pDevice.Token)
+		return err
+	})
+
+	if registry := config.LiveEdge().ImageRegistryURL; registry != "" {
+		run(CONNECTIVITY_IMAGE_REGISTRY, registry, func(ctx context.Context) error {
+			return checkRegistry(ctx, registry, config)
+		})
+	}
+
+	return out
+}
+
+// The registry is reachable when it answers on its API, with any status: the registries ask for credentials on it.
+func checkRegistry(ctx context.Context, registry string, config *config.HorizonConfig) error {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(registry, "/")+"/v2/", nil)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: connectivityCheckTimeout}
+	if factory := config.Collaborators.HTTPClientFactory; factory != nil {
+		timeoutS := uint(connectivityCheckTimeout / time.Second)
+		client = factory.NewHTTPClient(&timeoutS)
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
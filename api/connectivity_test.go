@@ -0,0 +1,138 @@
+// +build unit
+
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+)
+
+func Test_CheckConnectivity(t *testing.T) {
+
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/" {
+			t.Errorf("the registry should be checked on its API, got %v", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer registry.Close()
+
+	pDevice := &persistence.ExchangeDevice{Id: "testid", Org: "myorg", Token: "testtoken"}
+	getDevice := func(id string, token string) (*exchange.Device, error) {
+		if id != "myorg/testid" || token != "testtoken" {
+			t.Errorf("the node record should be read with the node's credentials, got %v %v", id, token)
+		}
+		return &exchange.Device{}, nil
+	}
+
+	config := getBasicConfig()
+	config.Edge.ExchangeURL = "http://127.0.0.1/v1/"
+	config.Edge.ImageRegistryURL = registry.URL
+
+	out := CheckConnectivity(context.Background(), pDevice, getDevice, config)
+	if !out.Passed || len(out.Checks) != 3 {
+		t.Fatalf("all the checks should pass, got %v", out)
+	}
+	for i, name := range []string{CONNECTIVITY_EXCHANGE_DNS, CONNECTIVITY_EXCHANGE_NODE, CONNECTIVITY_IMAGE_REGISTRY} {
+		if out.Checks[i].Name != name || !out.Checks[i].Passed || out.Checks[i].Error != "" {
+			t.Errorf("check %v should be %v and pass, got %v", i, name, out.Checks[i])
+		}
+	}
+
+	// an expired token, an unregistered node and a registry that does not answer
+	badToken := func(id string, token string) (*exchange.Device, error) {
+		return nil, errors.New("invalid credentials")
+	}
+	registry.Close()
+
+	out = CheckConnectivity(context.Background(), pDevice, badToken, config)
+	if out.Passed || out.Checks[1].Passed || out.Checks[1].Error != "invalid credentials" || out.Checks[2].Passed {
+		t.Errorf("the node and registry checks should fail, got %v", out)
+	} else if !out.Checks[0].Passed {
+		t.Errorf("the dns check should pass, got %v", out.Checks[0])
+	}
+
+	config.Edge.ImageRegistryURL = ""
+	out = CheckConnectivity(context.Background(), nil, getDevice, config)
+	if out.Passed || len(out.Checks) != 2 || out.Checks[1].Passed {
+		t.Errorf("an unregistered node should fail the node check, and the registry should not be checked, got %v", out)
+	}
+
+	config.Edge.ExchangeURL = ""
+	out = CheckConnectivity(context.Background(), pDevice, getDevice, config)
+	if out.Passed || out.Checks[0].Passed || out.Checks[0].Error == "" {
+		t.Errorf("an exchange URL without a host should fail the dns check, got %v", out.Checks[0])
+	}
+}
+
+// The node is not configured when it asks for the connectivity checks and they fail.
+func Test_UpdateConfigstate_check_connectivity(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, "myorg", "mypattern", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	badToken := func(id string, token string) (*exchange.Device, error) {
+		return nil, errors.New("invalid credentials")
+	}
+	config := getBasicConfig()
+	config.Edge.ExchangeURL = "http://127.0.0.1/v1/"
+
+	var myError error
+	state, check := persistence.CONFIGSTATE_CONFIGURED, true
+	cs := &Configstate{State: &state, CheckConnectivity: &check}
+	errHandled, _, _ := UpdateConfigstate(context.Background(), cs, GetPassThroughErrorHandler(&myError), getDummyGetPatterns(), nil, getVariableServiceHandler(exchange.UserInput{}), badToken, getDummyPatchDeviceHandler(), db, config)
+	if !errHandled {
+		t.Fatalf("the node should not be configured when it cannot read its exchange record")
+	} else if _, ok := myError.(*ServiceUnavailableError); !ok || ErrorReason(myError) != ERR_CONNECTIVITY {
+		t.Errorf("the error should be a connectivity error, got (%T) %v", myError, myError)
+	}
+
+	if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
+		t.Errorf("unable to read the device, error %v", err)
+	} else if pDevice.Config.State != persistence.CONFIGSTATE_CONFIGURING {
+		t.Errorf("the node should still be configuring, got %v", pDevice.Config.State)
+	}
+}
+
+// A node whose credentials the exchange rejects gets a user error, retrying does not help it.
+func Test_UpdateConfigstate_check_connectivity_credentials(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, "myorg", "mypattern", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	unauthorized := func(id string, token string) (*exchange.Device, error) {
+		return nil, exchange.NewExchangeError(http.MethodGet, "http://127.0.0.1/v1/orgs/myorg/nodes/testid", http.StatusUnauthorized, []byte(`{"msg": "invalid credentials"}`))
+	}
+	config := getBasicConfig()
+	config.Edge.ExchangeURL = "http://127.0.0.1/v1/"
+
+	var myError error
+	state, check := persistence.CONFIGSTATE_CONFIGURED, true
+	cs := &Configstate{State: &state, CheckConnectivity: &check}
+	errHandled, _, _ := UpdateConfigstate(context.Background(), cs, GetPassThroughErrorHandler(&myError), getDummyGetPatterns(), nil, getVariableServiceHandler(exchange.UserInput{}), unauthorized, getDummyPatchDeviceHandler(), db, config)
+	if !errHandled {
+		t.Fatalf("the node should not be configured when the exchange rejects its credentials")
+	} else if _, ok := myError.(*APIUserInputError); !ok || ErrorReason(myError) != ERR_EXCHANGE_CREDENTIALS {
+		t.Errorf("the error should be a credentials error, got (%T) %v", myError, myError)
+	}
+}
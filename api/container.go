@@ -68,3 +68,31 @@ func GetMicroserviceContainer(dockerEndpoint string, mURL string, mOrg string, m
 		}
 	}
 }
+
+// Get docker container metadata from the docker API for the containers of all the service instances at once, keyed
+// by the service instance key. Listing the containers once is cheaper than once for each instance.
+func GetServiceInstanceContainers(dockerEndpoint string) (map[string][]dockerclient.APIContainers, error) {
+	if client, err := dockerclient.NewClient(dockerEndpoint); err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to create docker client from %v, error %v", dockerEndpoint, err))
+	} else {
+		opts := dockerclient.ListContainersOptions{
+			All: true,
+		}
+
+		if containers, err := client.ListContainers(opts); err != nil {
+			return nil, errors.New(fmt.Sprintf("unable to list docker containers from %v, error %v", dockerEndpoint, err))
+		} else {
+			ret := make(map[string][]dockerclient.APIContainers)
+
+			// The infrastructure containers carry the service instance key in their agreement id label.
+			for _, c := range containers {
+				if _, exists := c.Labels[container.LABEL_PREFIX+".infrastructure"]; exists {
+					if key, exists := c.Labels[container.LABEL_PREFIX+".agreement_id"]; exists {
+						ret[key] = append(ret[key], c)
+					}
+				}
+			}
+			return ret, nil
+		}
+	}
+}
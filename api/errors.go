@@ -4,7 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/golang/glog"
+	"github.com/open-horizon/anax/cutil"
+	"math"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // This function type is used to enable plug replaceable error handlers within the API
@@ -21,6 +27,7 @@ type DeviceErrorHandler func(device interface{}, err error) bool
 type APIUserInputError struct {
 	Err   string `json:"error"`
 	Input string `json:"input,omitempty"`
+	Code  string `json:"code,omitempty"` // one of the ERR_ codes
 }
 
 func (e APIUserInputError) Error() string {
@@ -34,10 +41,16 @@ func NewAPIUserInputError(err string, input string) *APIUserInputError {
 	}
 }
 
+func (e *APIUserInputError) WithCode(code string) *APIUserInputError {
+	e.Code = code
+	return e
+}
+
 // TypeMismatchError is for node type and service type mismatch.
 type TypeMismatchError struct {
 	Err   string `json:"error"`
 	Input string `json:"input,omitempty"`
+	Code  string `json:"code,omitempty"` // one of the ERR_ codes
 }
 
 func (e TypeMismatchError) Error() string {
@@ -51,11 +64,17 @@ func NewTypeMismatchError(err string, input string) *TypeMismatchError {
 	}
 }
 
+func (e *TypeMismatchError) WithCode(code string) *TypeMismatchError {
+	e.Code = code
+	return e
+}
+
 // MSMissingVariableConfigError is for problems found with microservice configuration where the microservice definition
 // requires 1 or more input variables to be set but 1 or more of those variables has not been set.
 type MSMissingVariableConfigError struct {
 	Err   string `json:"error"`
 	Input string `json:"input,omitempty"`
+	Code  string `json:"code,omitempty"` // one of the ERR_ codes
 }
 
 func (e MSMissingVariableConfigError) Error() string {
@@ -69,11 +88,156 @@ func NewMSMissingVariableConfigError(err string, input string) *MSMissingVariabl
 	}
 }
 
+func (e *MSMissingVariableConfigError) WithCode(code string) *MSMissingVariableConfigError {
+	e.Code = code
+	return e
+}
+
+// ServiceConfigProblem is a problem found with one of the services of a request that configures several services at once.
+type ServiceConfigProblem struct {
+	Url     string `json:"url"`
+	Org     string `json:"organization"`
+	Version string `json:"version"`
+	Err     string `json:"error"`
+	Input   string `json:"input,omitempty"`
+	Code    string `json:"code,omitempty"` // one of the ERR_ codes
+
+	// The user input variables of the service that are not set or have the wrong type.
+	Variables []UserInputVariableProblem `json:"variables,omitempty"`
+
+	// The problem is a failure of the agent or of the exchange, not of the request, e.g. the exchange cannot be reached.
+	system bool
+}
+
+func (p ServiceConfigProblem) String() string {
+	return fmt.Sprintf("%v/%v %v: %v", p.Org, p.Url, p.Version, p.Err)
+}
+
+func (p ServiceConfigProblem) WithCode(code string) ServiceConfigProblem {
+	p.Code = code
+	return p
+}
+
+// A user input variable of a service that is not set, or that is set to a value of the wrong type.
+type UserInputVariableProblem struct {
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	DefaultValue string `json:"default_value"`
+	Err          string `json:"error"`
+}
+
+// Returns true if one of the problems is about the given service.
+func hasServiceConfigProblem(problems []ServiceConfigProblem, url string, org string) bool {
+	for _, p := range problems {
+		if p.Org == org && cutil.SameSpecURL(p.Url, url) {
+			return true
+		}
+	}
+	return false
+}
+
+// Make the problem of a service from the error returned for it. The input errors keep their input field, all the
+// errors keep their code. A SystemError is a failure of the agent or of the exchange.
+func NewServiceConfigProblem(url string, org string, version string, err error) ServiceConfigProblem {
+	p := ServiceConfigProblem{Url: url, Org: org, Version: version, Err: err.Error(), Code: ErrorReason(err)}
+	switch e := err.(type) {
+	case *SystemError:
+		p.system = true
+	case *APIUserInputError:
+		p.Err, p.Input = e.Err, e.Input
+	case *MSMissingVariableConfigError:
+		p.Err, p.Input = e.Err, e.Input
+	case *TypeMismatchError:
+		p.Err, p.Input = e.Err, e.Input
+	case *NotFoundError:
+		p.Err, p.Input = e.Err, e.Input
+	}
+	return p
+}
+
+// MultiServiceConfigError is for requests that configure several services at once, like the autoconfig of the services
+// of a node's pattern. All the services are checked before the error is returned, so that the problems of all of them
+// can be fixed at once rather than one at a time.
+type MultiServiceConfigError struct {
+	Err      string                 `json:"error"`
+	Input    string                 `json:"input,omitempty"`
+	Code     string                 `json:"code,omitempty"` // one of the ERR_ codes
+	Services []ServiceConfigProblem `json:"services"`
+}
+
+func (e MultiServiceConfigError) Error() string {
+	return fmt.Sprintf("Input: %v, Error: %v, Services: %v", e.Input, e.Err, e.Services)
+}
+
+func NewMultiServiceConfigError(err string, input string, services []ServiceConfigProblem) *MultiServiceConfigError {
+	return &MultiServiceConfigError{
+		Err:      err,
+		Input:    input,
+		Services: services,
+	}
+}
+
+func (e *MultiServiceConfigError) WithCode(code string) *MultiServiceConfigError {
+	e.Code = code
+	return e
+}
+
+// Returns true if one of the problems is a failure of the agent or of the exchange rather than of the request.
+func (e MultiServiceConfigError) IsSystemError() bool {
+	for _, p := range e.Services {
+		if p.system {
+			return true
+		}
+	}
+	return false
+}
+
+// InputProblem is a problem found with one of the items of a request that changes several things at once.
+type InputProblem struct {
+	Input string `json:"input"`
+	Err   string `json:"error"`
+	Code  string `json:"code,omitempty"` // one of the ERR_ codes
+}
+
+// Make the problem of an item from the error returned for it, input is where the item is in the request. The error
+// keeps its own input in its message, and its code.
+func NewInputProblem(input string, err error) InputProblem {
+	return InputProblem{Input: input, Err: err.Error(), Code: ErrorReason(err)}
+}
+
+// MultiInputError is for requests that change several things at once and either change all of them or none, like the
+// import of the node configuration. All the items are checked before the error is returned, so that the problems of
+// all of them can be fixed at once.
+type MultiInputError struct {
+	Err      string         `json:"error"`
+	Input    string         `json:"input,omitempty"`
+	Code     string         `json:"code,omitempty"` // one of the ERR_ codes
+	Problems []InputProblem `json:"problems"`
+}
+
+func (e MultiInputError) Error() string {
+	return fmt.Sprintf("Input: %v, Error: %v, Problems: %v", e.Input, e.Err, e.Problems)
+}
+
+func NewMultiInputError(err string, input string, problems []InputProblem) *MultiInputError {
+	return &MultiInputError{
+		Err:      err,
+		Input:    input,
+		Problems: problems,
+	}
+}
+
+func (e *MultiInputError) WithCode(code string) *MultiInputError {
+	e.Code = code
+	return e
+}
+
 // DuplicateServiceError occurs when a microservice configuration is attempted for a service that has already been
 // configured.
 type DuplicateServiceError struct {
 	Err   string `json:"error"`
 	Input string `json:"input,omitempty"`
+	Code  string `json:"code,omitempty"` // one of the ERR_ codes
 }
 
 func (e DuplicateServiceError) Error() string {
@@ -87,9 +251,15 @@ func NewDuplicateServiceError(err string, input string) *DuplicateServiceError {
 	}
 }
 
+func (e *DuplicateServiceError) WithCode(code string) *DuplicateServiceError {
+	e.Code = code
+	return e
+}
+
 // Conflict Errors are expected, since they can occur as the result of incorrect usage of the API.
 type ConflictError struct {
-	msg string
+	msg  string
+	code string
 }
 
 func (e ConflictError) Error() string {
@@ -102,9 +272,15 @@ func NewConflictError(err string) *ConflictError {
 	}
 }
 
+func (e *ConflictError) WithCode(code string) *ConflictError {
+	e.code = code
+	return e
+}
+
 // Bad Requests are expected, since they can occur as the result of incorrect usage of the API.
 type BadRequestError struct {
-	msg string
+	msg  string
+	code string
 }
 
 func (e BadRequestError) Error() string {
@@ -117,10 +293,16 @@ func NewBadRequestError(err string) *BadRequestError {
 	}
 }
 
+func (e *BadRequestError) WithCode(code string) *BadRequestError {
+	e.code = code
+	return e
+}
+
 // Not Found errors are expected, since they can occur as the result of incorrect usage of the API.
 type NotFoundError struct {
 	Err   string `json:"error"`
 	Input string `json:"input,omitempty"`
+	Code  string `json:"code,omitempty"` // one of the ERR_ codes
 }
 
 func (e NotFoundError) Error() string {
@@ -134,9 +316,15 @@ func NewNotFoundError(err string, input string) *NotFoundError {
 	}
 }
 
+func (e *NotFoundError) WithCode(code string) *NotFoundError {
+	e.Code = code
+	return e
+}
+
 // System Errors are generally unexpected, infrastructural problems that just need to be reported out to the caller.
 type SystemError struct {
-	msg string
+	msg  string
+	code string
 }
 
 func (e SystemError) Error() string {
@@ -149,9 +337,15 @@ func NewSystemError(err string) *SystemError {
 	}
 }
 
+func (e *SystemError) WithCode(code string) *SystemError {
+	e.code = code
+	return e
+}
+
 // Service Unavailable error are generally retryable, but our CLI does several retries so in our case, retry might not work.
 type ServiceUnavailableError struct {
-	msg string
+	msg  string
+	code string
 }
 
 func (e ServiceUnavailableError) Error() string {
@@ -164,6 +358,238 @@ func NewServiceUnavailableError(err string) *ServiceUnavailableError {
 	}
 }
 
+func (e *ServiceUnavailableError) WithCode(code string) *ServiceUnavailableError {
+	e.code = code
+	return e
+}
+
+// Too Many Requests errors are returned to the clients that change the node configuration more often than the agent
+// allows, they can retry after the given time.
+type TooManyRequestsError struct {
+	msg        string
+	code       string
+	retryAfter time.Duration
+}
+
+func (e TooManyRequestsError) Error() string {
+	return e.msg
+}
+
+func NewTooManyRequestsError(err string, retryAfter time.Duration) *TooManyRequestsError {
+	return &TooManyRequestsError{
+		msg:        err,
+		retryAfter: retryAfter,
+	}
+}
+
+func (e *TooManyRequestsError) WithCode(code string) *TooManyRequestsError {
+	e.code = code
+	return e
+}
+
+// The time to wait before the request can be made again.
+func (e TooManyRequestsError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// Precondition Failed errors are returned to the clients that change a resource with an If-Match header that is not
+// its current ETag, i.e. the resource was changed by another client since they read it. They can read it again.
+type PreconditionFailedError struct {
+	msg  string
+	code string
+	etag string
+}
+
+func (e PreconditionFailedError) Error() string {
+	return e.msg
+}
+
+func NewPreconditionFailedError(err string, etag string) *PreconditionFailedError {
+	return &PreconditionFailedError{
+		msg:  err,
+		etag: etag,
+	}
+}
+
+func (e *PreconditionFailedError) WithCode(code string) *PreconditionFailedError {
+	e.code = code
+	return e
+}
+
+// The current ETag of the resource, empty when it does not exist.
+func (e PreconditionFailedError) ETag() string {
+	return e.etag
+}
+
+// Unauthorized errors are returned to the clients that read a resource without the credentials that it requires, e.g.
+// the objects of an agreement without the credentials of its services.
+type UnauthorizedError struct {
+	msg  string
+	code string
+}
+
+func (e UnauthorizedError) Error() string {
+	return e.msg
+}
+
+func NewUnauthorizedError(err string) *UnauthorizedError {
+	return &UnauthorizedError{
+		msg: err,
+	}
+}
+
+func (e *UnauthorizedError) WithCode(code string) *UnauthorizedError {
+	e.code = code
+	return e
+}
+
+// The header of the error responses that holds the code of the type of the error, so that a client can tell the
+// errors apart without parsing their body, e.g. a MSMissingVariableConfigError from the APIUserInputError that it is
+// written as.
+const ERROR_CODE_HEADER = "X-Horizon-Error-Code"
+
+// The codes of the types of the errors, in the ERROR_CODE_HEADER of the error responses.
+const (
+	ERROR_CODE_USER_INPUT          = "user_input"          // APIUserInputError
+	ERROR_CODE_TYPE_MISMATCH       = "type_mismatch"       // TypeMismatchError
+	ERROR_CODE_MISSING_VARIABLE    = "missing_variable"    // MSMissingVariableConfigError
+	ERROR_CODE_DUPLICATE_SERVICE   = "duplicate_service"   // DuplicateServiceError
+	ERROR_CODE_MULTI_SERVICE       = "multi_service"       // MultiServiceConfigError
+	ERROR_CODE_MULTI_INPUT         = "multi_input"         // MultiInputError
+	ERROR_CODE_SYSTEM              = "system"              // SystemError
+	ERROR_CODE_CONFLICT            = "conflict"            // ConflictError
+	ERROR_CODE_BAD_REQUEST         = "bad_request"         // BadRequestError
+	ERROR_CODE_NOT_FOUND           = "not_found"           // NotFoundError
+	ERROR_CODE_SERVICE_UNAVAILABLE = "service_unavailable" // ServiceUnavailableError
+	ERROR_CODE_TOO_MANY_REQUESTS   = "too_many_requests"   // TooManyRequestsError
+	ERROR_CODE_PRECONDITION_FAILED = "precondition_failed" // PreconditionFailedError
+	ERROR_CODE_UNAUTHORIZED        = "unauthorized"        // UnauthorizedError
+	ERROR_CODE_INTERNAL            = "internal"            // any other error
+)
+
+// The codes of the reasons of the errors, in the code field of the error responses written as json. Unlike the
+// messages of the errors, they do not change, so a program can switch on them rather than match the messages.
+const (
+	ERR_INVALID_INPUT              = "ERR_INVALID_INPUT"              // the body or a parameter of the request is not valid
+	ERR_INVALID_STATE              = "ERR_INVALID_STATE"              // the config state cannot be set through the API
+	ERR_INVALID_STATE_TRANSITION   = "ERR_INVALID_STATE_TRANSITION"   // the node cannot change from its config state to the requested one
+	ERR_NODE_NOT_REGISTERED        = "ERR_NODE_NOT_REGISTERED"        // the node is not registered with the exchange
+	ERR_INVALID_VERSION_RANGE      = "ERR_INVALID_VERSION_RANGE"      // a version range is not valid, or not one the pattern allows
+	ERR_INVALID_EFFECTIVE_TIME     = "ERR_INVALID_EFFECTIVE_TIME"     // an effective time is set when the node is not being configured
+	ERR_INVALID_MANIFEST           = "ERR_INVALID_MANIFEST"           // the autoconfig manifest is not valid
+	ERR_MISSING_VARIABLE           = "ERR_MISSING_VARIABLE"           // a user input variable without a default value is not set
+	ERR_INVALID_VARIABLE           = "ERR_INVALID_VARIABLE"           // a user input variable is set to a value of the wrong type
+	ERR_SERVICE_CONFIG             = "ERR_SERVICE_CONFIG"             // some of the services cannot be configured, each one has its own code
+	ERR_SERVICE_NOT_FOUND          = "ERR_SERVICE_NOT_FOUND"          // the service cannot be found, or resolved, in the exchange
+	ERR_SERVICE_ALREADY_CONFIGURED = "ERR_SERVICE_ALREADY_CONFIGURED" // the service is already configured
+	ERR_SERVICE_PRIVILEGED         = "ERR_SERVICE_PRIVILEGED"         // the service requires privileged mode, which the node does not allow
+	ERR_NODE_TYPE_MISMATCH         = "ERR_NODE_TYPE_MISMATCH"         // the service is not for the type of the node
+	ERR_INCOMPATIBLE_VERSIONS      = "ERR_INCOMPATIBLE_VERSIONS"      // the services require versions of a service that do not intersect
+	ERR_NO_SERVICES_FOR_ARCH       = "ERR_NO_SERVICES_FOR_ARCH"       // the pattern has no services for the architectures of the node
+	ERR_UNSUPPORTED_ARCH           = "ERR_UNSUPPORTED_ARCH"           // the service, or a service it requires, is not for the architectures of the node
+	ERR_EXCHANGE_UNREACHABLE       = "ERR_EXCHANGE_UNREACHABLE"       // the exchange cannot be reached, or returned an unexpected response
+	ERR_POLICY_GENERATION          = "ERR_POLICY_GENERATION"          // the policy of the service cannot be generated
+	ERR_DATABASE                   = "ERR_DATABASE"                   // the local database cannot be read or written
+	ERR_DISK_SPACE                 = "ERR_DISK_SPACE"                 // not enough free disk space to configure the services
+	ERR_CLOCK_SKEW                 = "ERR_CLOCK_SKEW"                 // the clock of the node is too far off the exchange
+	ERR_TIMEOUT                    = "ERR_TIMEOUT"                    // the change did not complete within its timeout, or its caller went away
+	ERR_RATE_LIMITED               = "ERR_RATE_LIMITED"               // the node configuration is changed more often than the agent allows
+	ERR_NO_CHANNEL_CHOICE          = "ERR_NO_CHANNEL_CHOICE"          // none of the version choices of a service of the pattern are in the channel of the node
+	ERR_CONNECTIVITY               = "ERR_CONNECTIVITY"               // the exchange, or the image registry, cannot be reached with the node's credentials
+	ERR_OFFLINE_DEFINITIONS        = "ERR_OFFLINE_DEFINITIONS"        // the offline definitions of the config are not set, or cannot be read
+	ERR_PRECONDITION_FAILED        = "ERR_PRECONDITION_FAILED"        // the resource changed since the client read it, If-Match is not its ETag
+	ERR_PATTERN_NOT_FOUND          = "ERR_PATTERN_NOT_FOUND"          // the pattern of the node does not exist in the exchange
+	ERR_EXCHANGE_CREDENTIALS       = "ERR_EXCHANGE_CREDENTIALS"       // the exchange rejected the credentials of the node
+	ERR_SHUTTING_DOWN              = "ERR_SHUTTING_DOWN"              // the agent is shutting down, it does not accept changes of the node
+	ERR_UNAUTHORIZED               = "ERR_UNAUTHORIZED"               // the credentials of the request are missing or not the ones of the resource
+)
+
+// The JSON body of the error responses of the errors that only have a message, e.g. a SystemError.
+type messageErrorBody struct {
+	Err  string `json:"error"`
+	Code string `json:"code,omitempty"` // one of the ERR_ codes
+}
+
+// Returns the ERR_ code of the error, empty when it has none. The errors about a user input variable or a service that
+// cannot be configured have a code even when none was set.
+func ErrorReason(err error) string {
+	switch e := err.(type) {
+	case *APIUserInputError:
+		return e.Code
+	case *TypeMismatchError:
+		return reasonOrDefault(e.Code, ERR_NODE_TYPE_MISMATCH)
+	case *MSMissingVariableConfigError:
+		return reasonOrDefault(e.Code, ERR_MISSING_VARIABLE)
+	case *DuplicateServiceError:
+		return reasonOrDefault(e.Code, ERR_SERVICE_ALREADY_CONFIGURED)
+	case *MultiServiceConfigError:
+		return reasonOrDefault(e.Code, ERR_SERVICE_CONFIG)
+	case *MultiInputError:
+		return reasonOrDefault(e.Code, ERR_INVALID_INPUT)
+	case *NotFoundError:
+		return e.Code
+	case *SystemError:
+		return e.code
+	case *ConflictError:
+		return e.code
+	case *BadRequestError:
+		return e.code
+	case *ServiceUnavailableError:
+		return e.code
+	case *TooManyRequestsError:
+		return e.code
+	case *PreconditionFailedError:
+		return reasonOrDefault(e.code, ERR_PRECONDITION_FAILED)
+	case *UnauthorizedError:
+		return reasonOrDefault(e.code, ERR_UNAUTHORIZED)
+	default:
+		return ""
+	}
+}
+
+func reasonOrDefault(code string, def string) string {
+	if code == "" {
+		return def
+	}
+	return code
+}
+
+// Returns the code of the type of the error.
+func ErrorCode(err error) string {
+	switch err.(type) {
+	case *APIUserInputError:
+		return ERROR_CODE_USER_INPUT
+	case *TypeMismatchError:
+		return ERROR_CODE_TYPE_MISMATCH
+	case *MSMissingVariableConfigError:
+		return ERROR_CODE_MISSING_VARIABLE
+	case *DuplicateServiceError:
+		return ERROR_CODE_DUPLICATE_SERVICE
+	case *MultiServiceConfigError:
+		return ERROR_CODE_MULTI_SERVICE
+	case *MultiInputError:
+		return ERROR_CODE_MULTI_INPUT
+	case *SystemError:
+		return ERROR_CODE_SYSTEM
+	case *ConflictError:
+		return ERROR_CODE_CONFLICT
+	case *BadRequestError:
+		return ERROR_CODE_BAD_REQUEST
+	case *NotFoundError:
+		return ERROR_CODE_NOT_FOUND
+	case *ServiceUnavailableError:
+		return ERROR_CODE_SERVICE_UNAVAILABLE
+	case *TooManyRequestsError:
+		return ERROR_CODE_TOO_MANY_REQUESTS
+	case *PreconditionFailedError:
+		return ERROR_CODE_PRECONDITION_FAILED
+	case *UnauthorizedError:
+		return ERROR_CODE_UNAUTHORIZED
+	default:
+		return ERROR_CODE_INTERNAL
+	}
+}
+
 // Use this function to obtain an error handler that simply passes the error through itself back to caller. This is
 // done by modifying the error variable passed to this function.
 func GetPassThroughErrorHandler(passthruErr *error) ErrorHandler {
@@ -179,6 +605,9 @@ func GetHTTPErrorHandler(w http.ResponseWriter) ErrorHandler {
 	// returned value indicates whether or not processing can continue
 	return func(err error) bool {
 		if err != nil {
+			recordRequestError(w, err)
+			w.Header().Set(ERROR_CODE_HEADER, ErrorCode(err))
+			reason := ErrorReason(err)
 			switch err.(type) {
 			case *APIUserInputError:
 				apiErr := err.(*APIUserInputError)
@@ -186,50 +615,78 @@ func GetHTTPErrorHandler(w http.ResponseWriter) ErrorHandler {
 
 			case *TypeMismatchError:
 				tmmErr := err.(*TypeMismatchError)
-				apiErr := NewAPIUserInputError(tmmErr.Err, tmmErr.Input)
+				apiErr := NewAPIUserInputError(tmmErr.Err, tmmErr.Input).WithCode(reason)
 				writeInputErr(w, http.StatusBadRequest, apiErr)
 
 			case *MSMissingVariableConfigError:
 				// convert to an API Input Error
 				msErr := err.(*MSMissingVariableConfigError)
-				apiErr := NewAPIUserInputError(msErr.Err, msErr.Input)
+				apiErr := NewAPIUserInputError(msErr.Err, msErr.Input).WithCode(reason)
 				writeInputErr(w, http.StatusBadRequest, apiErr)
 
 			case *DuplicateServiceError:
 				// convert to an API Input Error
 				dupErr := err.(*DuplicateServiceError)
-				apiErr := NewAPIUserInputError(dupErr.Err, dupErr.Input)
+				apiErr := NewAPIUserInputError(dupErr.Err, dupErr.Input).WithCode(reason)
 				writeInputErr(w, http.StatusBadRequest, apiErr)
 
+			case *MultiServiceConfigError:
+				// written as is, with the problem of each service, a failure of the exchange is not a problem of the request
+				multiErr := *err.(*MultiServiceConfigError)
+				multiErr.Code = reason
+				if multiErr.IsSystemError() {
+					glog.Errorf(apiLogString(multiErr.Error()))
+					writeInputErr(w, http.StatusInternalServerError, &multiErr)
+				} else {
+					writeInputErr(w, http.StatusBadRequest, &multiErr)
+				}
+
+			case *MultiInputError:
+				// written as is, with the problem of each item
+				multiErr := *err.(*MultiInputError)
+				multiErr.Code = reason
+				writeInputErr(w, http.StatusBadRequest, &multiErr)
+
 			case *SystemError:
-				sysErr := err.(*SystemError)
-				glog.Errorf(apiLogString(sysErr.Error()))
-				http.Error(w, sysErr.Error(), http.StatusInternalServerError)
+				writeMessageErr(w, http.StatusInternalServerError, err.Error(), reason)
 
 			case *ConflictError:
-				conErr := err.(*ConflictError)
-				glog.Errorf(apiLogString(conErr.Error()))
-				http.Error(w, conErr.Error(), http.StatusConflict)
+				writeMessageErr(w, http.StatusConflict, err.Error(), reason)
 
 			case *BadRequestError:
-				badErr := err.(*BadRequestError)
-				glog.Errorf(apiLogString(badErr.Error()))
-				http.Error(w, badErr.Error(), http.StatusBadRequest)
+				writeMessageErr(w, http.StatusBadRequest, err.Error(), reason)
 
 			case *NotFoundError:
 				// convert to an API Input Error
 				notErr := err.(*NotFoundError)
-				apiErr := NewAPIUserInputError(notErr.Err, notErr.Input)
+				apiErr := NewAPIUserInputError(notErr.Err, notErr.Input).WithCode(reason)
 				writeInputErr(w, http.StatusNotFound, apiErr)
 
 			case *ServiceUnavailableError:
-				suErr := err.(*ServiceUnavailableError)
-				glog.Errorf(apiLogString(suErr.Error()))
-				http.Error(w, suErr.Error(), http.StatusServiceUnavailable)
+				writeMessageErr(w, http.StatusServiceUnavailable, err.Error(), reason)
+
+			case *TooManyRequestsError:
+				// the client is told when to retry, in whole seconds
+				tmrErr := err.(*TooManyRequestsError)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(tmrErr.RetryAfter().Seconds()))))
+				writeMessageErr(w, http.StatusTooManyRequests, err.Error(), reason)
+
+			case *PreconditionFailedError:
+				// the client is given the current ETag, to read the resource again
+				pfErr := err.(*PreconditionFailedError)
+				if pfErr.ETag() != "" {
+					w.Header().Set("ETag", pfErr.ETag())
+				}
+				writeMessageErr(w, http.StatusPreconditionFailed, err.Error(), reason)
+
+			case *UnauthorizedError:
+				// the client is told which credentials to give
+				w.Header().Set("WWW-Authenticate", `Basic realm="horizon"`)
+				writeMessageErr(w, http.StatusUnauthorized, err.Error(), reason)
 
 			default:
-				glog.Errorf(apiLogString(fmt.Sprintf("unknown error (%T) %v", err, err.Error())))
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				glog.Errorf(apiResponseLogString(w, fmt.Sprintf("unknown error (%T) %v", err, err.Error())))
+				writeMessageErr(w, http.StatusInternalServerError, "Internal server error", "")
 
 			}
 			// tell the caller they should not continue processing
@@ -241,19 +698,76 @@ func GetHTTPErrorHandler(w http.ResponseWriter) ErrorHandler {
 	}
 }
 
-// use this function to properly write a User Input Error to the http response.
-func writeInputErr(writer http.ResponseWriter, status int, inputErr *APIUserInputError) {
+// use this function to properly write a User Input Error, or another error with a json body, to the http response.
+func writeInputErr(writer http.ResponseWriter, status int, inputErr interface{}) {
 	if serial, err := json.Marshal(inputErr); err != nil {
-		glog.Errorf(apiLogString(fmt.Sprintf("Error serializing input error: %v, error %v", inputErr, err)))
+		glog.Errorf(apiResponseLogString(writer, fmt.Sprintf("Error serializing input error: %v, error %v", inputErr, err)))
 		http.Error(writer, "Internal server error", http.StatusInternalServerError)
 	} else {
+		serial = addRequestIDField(serial, responseRequestID(writer))
 		writer.WriteHeader(status)
 		writer.Header().Set("Content-Type", "application/json")
 		if _, err := writer.Write(serial); err != nil {
-			glog.Errorf(apiLogString(fmt.Sprintf("Error writing response: %v, error %v", serial, err)))
+			glog.Errorf(apiResponseLogString(writer, fmt.Sprintf("Error writing response: %v, error %v", serial, err)))
 			http.Error(writer, "Internal server error", http.StatusInternalServerError)
 		} else {
-			glog.Errorf(apiLogString(fmt.Sprintf("Returning status %v for error %v", status, string(serial))))
+			glog.Errorf(apiResponseLogString(writer, fmt.Sprintf("Returning status %v for error %v", status, string(serial))))
+		}
+	}
+}
+
+// Write an error that only has a message, e.g. a SystemError. It is written as text, with the request ID at its end, so
+// that the message read by people and by the existing clients does not change. A request that accepts
+// application/json gets it as JSON instead, with its ERR_ code.
+func writeMessageErr(w http.ResponseWriter, status int, msg string, reason string) {
+	if acceptsJSONErrors(w) {
+		writeInputErr(w, status, &messageErrorBody{Err: msg, Code: reason})
+		return
+	}
+	glog.Errorf(apiResponseLogString(w, msg))
+	http.Error(w, withRequestID(w, msg), status)
+}
+
+// The response writer of a request, that knows whether the request accepts its errors as JSON.
+type errorFormatWriter struct {
+	http.ResponseWriter
+	json bool
+}
+
+// The response of a streamed request, or of POST /node/shutdown, is sent to the client before the handler returns.
+func (e *errorFormatWriter) Flush() {
+	if f, ok := e.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Keep whether the requests served by h accept application/json, in which case the errors that only have a message
+// are written as JSON with their ERR_ code. The requests that do not ask for it get them as text.
+func NegotiateErrorFormat(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(&errorFormatWriter{ResponseWriter: w, json: acceptsJSON(r)}, r)
+	})
+}
+
+// Returns true when the Accept header of the request has application/json. A wildcard, e.g. */* sent by curl, does
+// not count, those clients get the errors as text.
+func acceptsJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			if mediaType, _, err := mime.ParseMediaType(mediaRange); err == nil && mediaType == "application/json" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns true when the request that w is the response writer of accepts its errors as JSON.
+func acceptsJSONErrors(w http.ResponseWriter) bool {
+	for ; w != nil; w = innerResponseWriter(w) {
+		if ew, ok := w.(*errorFormatWriter); ok {
+			return ew.json
 		}
 	}
+	return false
 }
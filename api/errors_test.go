@@ -3,8 +3,13 @@
 package api
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func Test_APIInputError(t *testing.T) {
@@ -61,3 +66,104 @@ func Test_PassthruHandler(t *testing.T) {
 	}
 
 }
+
+// The code of an error is written in the code field of its json body when the request accepts json, and its message
+// does not change.
+func Test_HTTPErrorHandler_code(t *testing.T) {
+
+	tests := []struct {
+		err    error
+		status int
+		reason string
+	}{
+		{NewAPIUserInputError("bad state", "configstate.state").WithCode(ERR_INVALID_STATE), http.StatusBadRequest, ERR_INVALID_STATE},
+		{NewAPIUserInputError("bad state", "configstate.state"), http.StatusBadRequest, ""},
+		{NewMSMissingVariableConfigError("variable var1 is not set", "variables"), http.StatusBadRequest, ERR_MISSING_VARIABLE},
+		{NewNotFoundError("node not registered", "node").WithCode(ERR_NODE_NOT_REGISTERED), http.StatusNotFound, ERR_NODE_NOT_REGISTERED},
+		{NewSystemError("the database failed").WithCode(ERR_DATABASE), http.StatusInternalServerError, ERR_DATABASE},
+		{NewConflictError("the node is configured"), http.StatusConflict, ""},
+		{NewBadRequestError("bad query").WithCode(ERR_INVALID_INPUT), http.StatusBadRequest, ERR_INVALID_INPUT},
+		{NewServiceUnavailableError("shutting down").WithCode(ERR_SHUTTING_DOWN), http.StatusServiceUnavailable, ERR_SHUTTING_DOWN},
+		{NewTooManyRequestsError("slow down", time.Second).WithCode(ERR_RATE_LIMITED), http.StatusTooManyRequests, ERR_RATE_LIMITED},
+		{NewUnauthorizedError("no credentials"), http.StatusUnauthorized, ERR_UNAUTHORIZED},
+		{NewMultiServiceConfigError("1 of the services cannot be configured.", "configstate.state", []ServiceConfigProblem{NewServiceConfigProblem("svc1", "myorg", "1.0.0", NewMSMissingVariableConfigError("variable var1 is not set", "configstate.state"))}).WithCode(ERR_SERVICE_CONFIG), http.StatusBadRequest, ERR_SERVICE_CONFIG},
+		{NewMultiServiceConfigError("2 of the services cannot be configured.", "configstate.state", []ServiceConfigProblem{NewServiceConfigProblem("svc1", "myorg", "1.0.0", NewMSMissingVariableConfigError("variable var1 is not set", "configstate.state")), NewServiceConfigProblem("svc2", "myorg", "1.0.0", NewSystemError("the exchange failed").WithCode(ERR_EXCHANGE_UNREACHABLE))}).WithCode(ERR_SERVICE_CONFIG), http.StatusInternalServerError, ERR_SERVICE_CONFIG},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		GetHTTPErrorHandler(&errorFormatWriter{ResponseWriter: w, json: true})(test.err)
+
+		if w.Code != test.status {
+			t.Errorf("%v: expected status %v, got %v", test.err, test.status, w.Code)
+		}
+
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Errorf("%v: the body should be json, got %v", test.err, w.Body.String())
+		} else if code, _ := body["code"].(string); code != test.reason {
+			t.Errorf("%v: expected the code %v, got %v", test.err, test.reason, body)
+		} else if msg, _ := body["error"].(string); msg == "" || !strings.Contains(test.err.Error(), msg) {
+			t.Errorf("%v: the message of the error should not change, got %v", test.err, body["error"])
+		}
+	}
+}
+
+// The errors that only have a message are written as text, unless the request accepts json.
+func Test_HTTPErrorHandler_text(t *testing.T) {
+
+	tests := []struct {
+		err    error
+		status int
+		body   string
+	}{
+		{NewSystemError("the database failed").WithCode(ERR_DATABASE), http.StatusInternalServerError, "the database failed"},
+		{NewConflictError("the node is configured"), http.StatusConflict, "the node is configured"},
+		{NewBadRequestError("bad query").WithCode(ERR_INVALID_INPUT), http.StatusBadRequest, "bad query"},
+		{NewServiceUnavailableError("shutting down").WithCode(ERR_SHUTTING_DOWN), http.StatusServiceUnavailable, "shutting down"},
+		{NewTooManyRequestsError("slow down", time.Second).WithCode(ERR_RATE_LIMITED), http.StatusTooManyRequests, "slow down"},
+		{NewUnauthorizedError("no credentials"), http.StatusUnauthorized, "no credentials"},
+		{fmt.Errorf("not an api error"), http.StatusInternalServerError, "Internal server error"},
+	}
+
+	for _, test := range tests {
+		for accept, text := range map[string]bool{"": true, "*/*": true, "text/plain": true, "text/html, application/json;q=0.9": false} {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/node", nil)
+			if accept != "" {
+				r.Header.Set("Accept", accept)
+			}
+			NegotiateErrorFormat(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				GetHTTPErrorHandler(w)(test.err)
+			})).ServeHTTP(w, r)
+
+			if w.Code != test.status {
+				t.Errorf("%v: expected status %v, got %v", test.err, test.status, w.Code)
+			} else if body := strings.TrimSpace(w.Body.String()); text && body != test.body {
+				t.Errorf("%v: the error should be the text %v with Accept %v, got %v", test.err, test.body, accept, body)
+			} else if !text && errorBodyMessage(w) != test.body {
+				t.Errorf("%v: the error should be json with Accept %v, got %v", test.err, accept, body)
+			}
+		}
+	}
+}
+
+// Returns the message in the body of a json error response.
+func errorBodyMessage(w *httptest.ResponseRecorder) string {
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		return ""
+	}
+	msg, _ := body["error"].(string)
+	return msg
+}
+
+// Returns the ERR_ code in the body of an error response.
+func errorBodyCode(w *httptest.ResponseRecorder) string {
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		return ""
+	}
+	code, _ := body["code"].(string)
+	return code
+}
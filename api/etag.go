@@ -0,0 +1,154 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// The header of the requests that change a resource only if it was not changed since the client read it. It holds the
+// ETag that the client read the resource with, or * for any version of it.
+const IF_MATCH_HEADER = "If-Match"
+
+// The ETag of the node and of its config state, from the revision of the node record. It changes each time the record
+// is updated, e.g. by a change of the config state, of the pattern or of the token.
+func deviceETag(pDevice *persistence.ExchangeDevice) string {
+	if pDevice == nil {
+		return ""
+	}
+	return fmt.Sprintf("\"%v\"", pDevice.Revision)
+}
+
+// The ETag of the services configured on the node, from the ids and the revisions of their definitions. It changes
+// when a service is configured, removed or updated.
+func servicesETag(msdefs []persistence.MicroserviceDefinition) string {
+	revisions := make([]string, 0, len(msdefs))
+	for _, msdef := range msdefs {
+		revisions = append(revisions, fmt.Sprintf("%v:%v", msdef.Id, msdef.Revision))
+	}
+	return hashETag(revisions)
+}
+
+// The ETag of an attribute, from the revision of its record. Empty when the attribute does not exist.
+func attributeETag(attr persistence.Attribute) string {
+	if attr == nil {
+		return ""
+	}
+	return fmt.Sprintf("\"%v\"", attr.GetMeta().Revision)
+}
+
+// The ETag of the attributes of the node, from their ids and revisions. It changes when an attribute is added, removed
+// or updated.
+func attributesETag(attrs []persistence.Attribute) string {
+	revisions := make([]string, 0, len(attrs))
+	for _, attr := range attrs {
+		revisions = append(revisions, fmt.Sprintf("%v:%v", attr.GetMeta().Id, attr.GetMeta().Revision))
+	}
+	return hashETag(revisions)
+}
+
+// The ETag of the node user input, from its content. It changes when a variable is set or removed, empty when the
+// node has no user input.
+func userInputETag(userInput []policy.UserInput) (string, error) {
+	if userInput == nil {
+		return "", nil
+	}
+	serial, err := json.Marshal(userInput)
+	if err != nil {
+		return "", err
+	}
+	return hashETag([]string{string(serial)}), nil
+}
+
+// An ETag from the hash of the parts, in any order.
+func hashETag(parts []string) string {
+	sort.Strings(parts)
+	hash := sha256.Sum256([]byte(strings.Join(parts, ",")))
+	return fmt.Sprintf("\"%v\"", hex.EncodeToString(hash[:8]))
+}
+
+// Returns the ETag of the services configured on the node.
+func FindServicesETag(db *bolt.DB) (string, error) {
+	msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()})
+	if err != nil {
+		return "", NewSystemError(fmt.Sprintf("Error accessing db to find service definitions: %v", err)).WithCode(ERR_DATABASE)
+	}
+	return servicesETag(msdefs), nil
+}
+
+// Returns the ETag of the attribute with the id, empty when it does not exist, or of all the attributes of the node
+// when the id is empty.
+func FindAttributesETag(db *bolt.DB, id string) (string, error) {
+	if id != "" {
+		attr, err := persistence.FindAttributeByKey(db, id)
+		if err != nil {
+			return "", NewSystemError(fmt.Sprintf("Unable to read attribute %v, error %v", id, err)).WithCode(ERR_DATABASE)
+		} else if attr == nil {
+			return "", nil
+		}
+		return attributeETag(*attr), nil
+	}
+
+	attrs, err := persistence.FindApplicableAttributes(db, "", "")
+	if err != nil {
+		return "", NewSystemError(fmt.Sprintf("Unable to read the attributes, error %v", err)).WithCode(ERR_DATABASE)
+	}
+	return attributesETag(attrs), nil
+}
+
+// Returns the ETag of the node user input, empty when the node has none.
+func FindNodeUserInputETag(db *bolt.DB) (string, error) {
+	userInput, err := persistence.FindNodeUserInput(db)
+	if err != nil {
+		return "", NewSystemError(fmt.Sprintf("Unable to read the node user input, error %v", err)).WithCode(ERR_DATABASE)
+	}
+	etag, err := userInputETag(userInput)
+	if err != nil {
+		return "", NewSystemError(fmt.Sprintf("Unable to serialize the node user input, error %v", err))
+	}
+	return etag, nil
+}
+
+// Returns the ETag of the node and of its config state, empty when the node is not registered.
+func FindDeviceETag(db *bolt.DB) (string, error) {
+	pDevice, err := persistence.FindExchangeDevice(db)
+	if err != nil {
+		return "", NewSystemError(fmt.Sprintf("Unable to read node object, error %v", err)).WithCode(ERR_DATABASE)
+	}
+	return deviceETag(pDevice), nil
+}
+
+// Check the If-Match header of a request that changes a resource against the current ETag of the resource, empty
+// when it does not exist. A PreconditionFailedError is returned when none of the ETags of the header is the current
+// one. A request without the header always passes, the last change wins as it did before.
+func checkIfMatch(ifMatch string, current string, resource string) error {
+	ifMatch = strings.TrimSpace(ifMatch)
+	if ifMatch == "" {
+		return nil
+	}
+
+	for _, etag := range strings.Split(ifMatch, ",") {
+		etag = strings.TrimSpace(etag)
+		if current != "" && (etag == "*" || etag == current) {
+			return nil
+		}
+	}
+	if current == "" {
+		return NewPreconditionFailedError(fmt.Sprintf("%v does not exist, %v %v is not met.", resource, IF_MATCH_HEADER, ifMatch), current).WithCode(ERR_PRECONDITION_FAILED)
+	}
+	return NewPreconditionFailedError(fmt.Sprintf("%v was changed since it was read, its ETag is %v rather than %v %v. Read it again before changing it.", resource, current, IF_MATCH_HEADER, ifMatch), current).WithCode(ERR_PRECONDITION_FAILED)
+}
+
+// Set the ETag header of a response, when the resource has one.
+func setETag(w http.ResponseWriter, etag string) {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+}
@@ -0,0 +1,148 @@
+// +build unit
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+)
+
+func Test_checkIfMatch(t *testing.T) {
+
+	tests := []struct {
+		ifMatch string
+		current string
+		ok      bool
+	}{
+		{"", "\"3\"", true},
+		{"", "", true},
+		{"\"3\"", "\"3\"", true},
+		{"\"2\", \"3\"", "\"3\"", true},
+		{"*", "\"3\"", true},
+		{"\"2\"", "\"3\"", false},
+		{"*", "", false},
+		{"\"3\"", "", false},
+	}
+
+	for _, test := range tests {
+		err := checkIfMatch(test.ifMatch, test.current, "node")
+		if test.ok && err != nil {
+			t.Errorf("If-Match %v should match %v, error %v", test.ifMatch, test.current, err)
+		} else if !test.ok {
+			if pfErr, ok := err.(*PreconditionFailedError); !ok {
+				t.Errorf("If-Match %v should not match %v, got (%T) %v", test.ifMatch, test.current, err, err)
+			} else if pfErr.ETag() != test.current || ErrorReason(err) != ERR_PRECONDITION_FAILED {
+				t.Errorf("wrong ETag or reason of %v", pfErr)
+			}
+		}
+	}
+}
+
+// The ETag of the node changes with each update of its record, an If-Match with the previous one then fails.
+func Test_deviceETag_revision(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	if etag, err := FindDeviceETag(db); err != nil || etag != "" {
+		t.Errorf("an unregistered node should have no ETag, got %v, error %v", etag, err)
+	}
+
+	pDevice, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, "myorg", "mypattern", persistence.CONFIGSTATE_CONFIGURING)
+	if err != nil {
+		t.Fatalf("failed to create persisted device, error %v", err)
+	}
+	etag, err := FindDeviceETag(db)
+	if err != nil || etag != "\"1\"" {
+		t.Errorf("a new node should have the first revision, got %v, error %v", etag, err)
+	}
+
+	if _, err := pDevice.SetConfigstate(db, pDevice.Id, persistence.CONFIGSTATE_CONFIGURED); err != nil {
+		t.Fatalf("failed to update persisted device, error %v", err)
+	}
+	newETag, err := FindDeviceETag(db)
+	if err != nil || newETag != "\"2\"" {
+		t.Errorf("the update should increment the revision, got %v, error %v", newETag, err)
+	} else if err := checkIfMatch(etag, newETag, "node"); err == nil {
+		t.Errorf("the ETag read before the update should not match")
+	}
+}
+
+// The ETag of the services changes when a service is configured or updated.
+func Test_servicesETag(t *testing.T) {
+
+	msdefs := []persistence.MicroserviceDefinition{{Id: "1", Revision: 1}, {Id: "2", Revision: 1}}
+	etag := servicesETag(msdefs)
+
+	if reordered := servicesETag([]persistence.MicroserviceDefinition{msdefs[1], msdefs[0]}); reordered != etag {
+		t.Errorf("the order of the services should not change the ETag, got %v and %v", etag, reordered)
+	}
+	if added := servicesETag(append(msdefs, persistence.MicroserviceDefinition{Id: "3", Revision: 1})); added == etag {
+		t.Errorf("a new service should change the ETag")
+	}
+	if updated := servicesETag([]persistence.MicroserviceDefinition{{Id: "1", Revision: 2}, msdefs[1]}); updated == etag {
+		t.Errorf("an updated service should change the ETag")
+	}
+}
+
+func Test_HTTPErrorHandler_precondition_failed(t *testing.T) {
+
+	w := httptest.NewRecorder()
+	GetHTTPErrorHandler(w)(checkIfMatch("\"1\"", "\"2\"", "node"))
+
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status %v, got %v", http.StatusPreconditionFailed, w.Code)
+	} else if w.Header().Get("ETag") != "\"2\"" {
+		t.Errorf("the current ETag should be in the response, got %v", w.Header().Get("ETag"))
+	} else if w.Header().Get(ERROR_CODE_HEADER) != ERROR_CODE_PRECONDITION_FAILED {
+		t.Errorf("wrong error code %v", w.Header().Get(ERROR_CODE_HEADER))
+	}
+}
+
+// The ETag of the attributes changes when an attribute is added or updated, the ETag of one only when it is updated.
+func Test_attributesETag(t *testing.T) {
+
+	a1 := persistence.AutoReconcileAttributes{Meta: &persistence.AttributeMeta{Id: "a1", Revision: 1}}
+	a2 := persistence.AutoReconcileAttributes{Meta: &persistence.AttributeMeta{Id: "a2", Revision: 1}}
+	etag := attributesETag([]persistence.Attribute{a1, a2})
+
+	if reordered := attributesETag([]persistence.Attribute{a2, a1}); reordered != etag {
+		t.Errorf("the order of the attributes should not change the ETag, got %v and %v", etag, reordered)
+	}
+	if removed := attributesETag([]persistence.Attribute{a1}); removed == etag {
+		t.Errorf("a removed attribute should change the ETag")
+	}
+
+	updated := persistence.AutoReconcileAttributes{Meta: &persistence.AttributeMeta{Id: "a2", Revision: 2}}
+	if attributesETag([]persistence.Attribute{a1, updated}) == etag {
+		t.Errorf("an updated attribute should change the ETag")
+	} else if attributeETag(updated) != "\"2\"" || attributeETag(a2) != "\"1\"" {
+		t.Errorf("wrong ETag of the attribute, got %v", attributeETag(updated))
+	} else if attributeETag(nil) != "" {
+		t.Errorf("an attribute that does not exist has no ETag")
+	}
+}
+
+// The ETag of the node user input changes with its content.
+func Test_userInputETag(t *testing.T) {
+
+	userInput := []policy.UserInput{{ServiceOrgid: "org1", ServiceUrl: "svc1", Inputs: []policy.Input{{Name: "var1", Value: "a"}}}}
+	etag, err := userInputETag(userInput)
+	if err != nil || etag == "" {
+		t.Fatalf("expected an ETag, got %v, error %v", etag, err)
+	}
+
+	changed := []policy.UserInput{{ServiceOrgid: "org1", ServiceUrl: "svc1", Inputs: []policy.Input{{Name: "var1", Value: "b"}}}}
+	if other, err := userInputETag(changed); err != nil || other == etag {
+		t.Errorf("a changed variable should change the ETag, got %v, error %v", other, err)
+	} else if none, err := userInputETag(nil); err != nil || none != "" {
+		t.Errorf("a node without user input has no ETag, got %v, error %v", none, err)
+	}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"fmt"
+	"github.com/open-horizon/anax/exchange"
+	"net/http"
+)
+
+// Returns the reason of a failed exchange call from the status of the response of the exchange, def when the status
+// does not tell more than the call that failed. The 401 responses are the credentials of the node, the 429 and 5xx
+// responses an exchange that cannot serve the node.
+func exchangeErrorReason(err error, def string) string {
+	exErr, ok := exchange.AsExchangeError(err)
+	if !ok {
+		return def
+	}
+	switch {
+	case exErr.Status == http.StatusUnauthorized:
+		return ERR_EXCHANGE_CREDENTIALS
+	case exErr.Status == http.StatusTooManyRequests || exErr.Status >= http.StatusInternalServerError:
+		return ERR_EXCHANGE_UNREACHABLE
+	}
+	return def
+}
+
+// Returns the reason of an exchange error that is a failure of the exchange rather than of what was asked for, e.g.
+// the exchange cannot be reached or rejected the credentials of the node, or "" for the other errors.
+func exchangeFailureReason(err error) string {
+	if reason := exchangeErrorReason(err, ""); reason != "" {
+		return reason
+	} else if exchange.IsRetryableError(err) {
+		return ERR_EXCHANGE_UNREACHABLE
+	}
+	return ""
+}
+
+// Returns the error of a pattern that cannot be read from the exchange. When the exchange responded, its status, its
+// message and the URL that was read are in the error. A pattern that does not exist is an error of the pattern of the
+// node.
+func patternReadError(patId string, err error) error {
+	exErr, ok := exchange.AsExchangeError(err)
+	if !ok {
+		return NewSystemError(fmt.Sprintf("Unable to read pattern object %v from exchange, error %v", patId, err)).WithCode(ERR_EXCHANGE_UNREACHABLE)
+	} else if exErr.Status == http.StatusNotFound {
+		return patternNotFoundError(patId)
+	}
+	return NewSystemError(fmt.Sprintf("Unable to read pattern object %v from exchange at %v, status %v, error %v", patId, exErr.URL, exErr.Status, exErr.Message())).WithCode(exchangeErrorReason(err, ERR_EXCHANGE_UNREACHABLE))
+}
+
+// Returns the error of a pattern of the node that does not exist in the exchange.
+func patternNotFoundError(patId string) error {
+	return NewAPIUserInputError(fmt.Sprintf("The pattern %v does not exist in the exchange. Create it, or change the node to a pattern that exists.", patId), "device.pattern").WithCode(ERR_PATTERN_NOT_FOUND)
+}
@@ -0,0 +1,108 @@
+// +build unit
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+)
+
+// A pattern that does not exist in the exchange is an error of the pattern of the node, not of the agent.
+func Test_UpdateConfigstate_pattern_not_found(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	myOrg := "myorg"
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, myOrg, "mypattern", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	update := func(patternHandler exchange.PatternHandler) (bool, []events.Message, error) {
+		var myError error
+		state := persistence.CONFIGSTATE_CONFIGURED
+		errHandled, _, msgs := UpdateConfigstate(context.Background(), &Configstate{State: &state}, GetPassThroughErrorHandler(&myError), patternHandler, getDummyServiceDefResolver(), getDummyServiceHandler(), getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+		return errHandled, msgs, myError
+	}
+
+	exchangeError := func(status int, body string) exchange.PatternHandler {
+		return func(org string, pattern string) (map[string]exchange.Pattern, error) {
+			return nil, exchange.NewExchangeError("GET", fmt.Sprintf("http://exchange/v1/orgs/%v/patterns/%v", org, pattern), status, []byte(body))
+		}
+	}
+
+	// the exchange responded with a 404, or did not return the pattern
+	for _, patternHandler := range []exchange.PatternHandler{exchangeError(http.StatusNotFound, `{"code":"not found","msg":"pattern not found"}`), getDummyGetPatterns()} {
+		if errHandled, msgs, myError := update(patternHandler); !errHandled {
+			t.Errorf("the node should not be configured with a pattern that does not exist")
+		} else if apiErr, ok := myError.(*APIUserInputError); !ok {
+			t.Errorf("myError has the wrong type (%T) %v", myError, myError)
+		} else if apiErr.Input != "device.pattern" || apiErr.Code != ERR_PATTERN_NOT_FOUND {
+			t.Errorf("wrong error input field or code %v", *apiErr)
+		} else if len(msgs) != 0 {
+			t.Errorf("there should be no messages, received %v", len(msgs))
+		}
+	}
+
+	// the credentials of the node are rejected, the status and the message of the exchange are in the error
+	if errHandled, _, myError := update(exchangeError(http.StatusUnauthorized, `{"code":"access denied","msg":"invalid credentials"}`)); !errHandled {
+		t.Errorf("the node should not be configured with rejected credentials")
+	} else if _, ok := myError.(*SystemError); !ok || ErrorReason(myError) != ERR_EXCHANGE_CREDENTIALS {
+		t.Errorf("myError has the wrong type or reason (%T) %v", myError, myError)
+	} else if msg := myError.Error(); !containsAll(msg, "401", "invalid credentials", "http://exchange/v1/orgs/myorg/patterns/mypattern") {
+		t.Errorf("the error should have the status, the message and the URL of the exchange, got %v", msg)
+	}
+
+	// an exchange that fails is unreachable
+	if errHandled, _, myError := update(exchangeError(http.StatusBadGateway, "bad gateway")); !errHandled {
+		t.Errorf("the node should not be configured when the exchange fails")
+	} else if ErrorReason(myError) != ERR_EXCHANGE_UNREACHABLE {
+		t.Errorf("myError has the wrong reason (%T) %v", myError, myError)
+	}
+
+	if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
+		t.Errorf("failed to read device, error %v", err)
+	} else if pDevice.Config.State != persistence.CONFIGSTATE_CONFIGURING {
+		t.Errorf("the state should not change, is %v", pDevice.Config.State)
+	}
+}
+
+// The failures of the exchange are told apart from the errors about what was asked for.
+func Test_exchangeFailureReason(t *testing.T) {
+	tests := []struct {
+		err    error
+		reason string
+	}{
+		{exchange.NewExchangeError("GET", "http://exchange/v1/orgs/myorg/services", http.StatusBadGateway, []byte("bad gateway")), ERR_EXCHANGE_UNREACHABLE},
+		{exchange.NewExchangeError("GET", "http://exchange/v1/orgs/myorg/services", http.StatusUnauthorized, []byte("invalid credentials")), ERR_EXCHANGE_CREDENTIALS},
+		{errors.New("Exceeded 3 retries for error: dial tcp 10.0.0.1:443: connect: connection refused"), ERR_EXCHANGE_UNREACHABLE},
+		{exchange.NewExchangeError("GET", "http://exchange/v1/orgs/myorg/services", http.StatusBadRequest, []byte("bad request")), ""},
+		{errors.New("unable to find service svc1 myorg 1.0.0 amd64 on the exchange."), ""},
+	}
+
+	for _, test := range tests {
+		if reason := exchangeFailureReason(test.err); reason != test.reason {
+			t.Errorf("%v: expected the reason %v, got %v", test.err, test.reason, reason)
+		}
+	}
+}
+
+func containsAll(s string, subs ...string) bool {
+	for _, sub := range subs {
+		if !strings.Contains(s, sub) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/persistence"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// The suffixes of the files that first boot provisioning writes next to the provisioning file.
+const PROVISIONING_RESULT_SUFFIX = ".result"
+const PROVISIONING_DONE_SUFFIX = ".done"
+
+// The provisioning file that cloud-init, or the vendor data of the device, writes for the first boot. The node and
+// the services have the format of the bodies of POST /node and POST /service/config.
+type Provisioning struct {
+	ExchangeURL string        `json:"exchange_url,omitempty"` // checked against the exchange URL of the config of anax
+	Node        HorizonDevice `json:"node"`
+	Services    []Service     `json:"services,omitempty"`
+	ConfigState string        `json:"configstate,omitempty"` // configured (the default), or configuring to finish the configuration later
+}
+
+// The outcome of first boot provisioning, written to the result file.
+type ProvisioningResult struct {
+	File      string             `json:"file"`
+	StartTime uint64             `json:"start_time"`
+	EndTime   uint64             `json:"end_time"`
+	Succeeded bool               `json:"succeeded"`
+	Steps     []ProvisioningStep `json:"steps"`
+}
+
+// A step of first boot provisioning: validate, register, service <org>/<url> or configstate.
+type ProvisioningStep struct {
+	Step  string `json:"step"`
+	Error string `json:"error,omitempty"`
+}
+
+// Check that the provisioning file has everything that the registration needs.
+func (p *Provisioning) Validate() error {
+	if p.Node.Id == nil || *p.Node.Id == "" {
+		return errors.New("node id is required")
+	} else if p.Node.Org == nil || *p.Node.Org == "" {
+		return errors.New("node organization is required")
+	} else if p.Node.Token == nil || *p.Node.Token == "" {
+		return errors.New("node token is required")
+	}
+	for i, s := range p.Services {
+		if s.Url == nil || *s.Url == "" || s.Org == nil || *s.Org == "" {
+			return fmt.Errorf("service %v must have a url and organization", i)
+		}
+	}
+	if p.ConfigState != "" && p.ConfigState != persistence.CONFIGSTATE_CONFIGURED && p.ConfigState != persistence.CONFIGSTATE_CONFIGURING {
+		return fmt.Errorf("configstate %v is not supported, it must be %v or %v", p.ConfigState, persistence.CONFIGSTATE_CONFIGURED, persistence.CONFIGSTATE_CONFIGURING)
+	}
+	return nil
+}
+
+// Provision the node from the provisioning file of the config, when the node is not registered and the file exists.
+// The node is registered, the services are configured and the configstate is changed with the same logic as the
+// API calls that hzn register makes. It runs once when the API starts, on its own goroutine because the registration
+// sends messages to the other workers. The provisioning file is renamed once it succeeded, so that it is not used again
+// when the node restarts. A file that failed stays in place to be tried again, unless the node was registered by then.
+func (a *API) provisionOnFirstBoot() {
+	file := a.Config.Edge.ProvisioningFile
+	if file == "" {
+		return
+	} else if _, err := os.Stat(file); os.IsNotExist(err) {
+		return
+	} else if pDevice, err := persistence.FindExchangeDevice(a.db); err != nil {
+		glog.Errorf(apiLogString(fmt.Sprintf("Unable to read node object, error %v", err)))
+		return
+	} else if pDevice != nil {
+		glog.Warningf(apiLogString(fmt.Sprintf("Ignoring provisioning file %v, the node is already registered", file)))
+		return
+	}
+
+	go func() {
+		result := a.provision(file)
+		a.saveProvisioningResult(file, result)
+	}()
+}
+
+func (a *API) provision(file string) *ProvisioningResult {
+	result := &ProvisioningResult{File: file, StartTime: uint64(time.Now().Unix())}
+
+	// The log lines of the provisioning have a request ID, as the ones of the API requests it stands for.
+	ctx := WithRequestID(context.Background(), "firstboot-"+newRequestID())
+
+	// Runs a step and records its outcome. The error handler gets the errors that the API calls would return.
+	step := func(name string, fn func(errorHandler ErrorHandler) bool) bool {
+		var stepErr error
+		errorHandler := func(err error) bool {
+			if err != nil && stepErr == nil {
+				stepErr = err
+			}
+			return err != nil
+		}
+		if errHandled := fn(errorHandler); errHandled && stepErr == nil {
+			stepErr = errors.New("failed")
+		}
+
+		s := ProvisioningStep{Step: name}
+		if stepErr != nil {
+			s.Error = stepErr.Error()
+			LogDeviceEvent(a.db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_PROVISIONING, file, name, s.Error), persistence.EC_ERROR_NODE_PROVISIONING, nil)
+		}
+		result.Steps = append(result.Steps, s)
+		return stepErr == nil
+	}
+
+	var prov Provisioning
+	ok := step("validate", func(errorHandler ErrorHandler) bool {
+		if data, err := ioutil.ReadFile(file); err != nil {
+			return errorHandler(fmt.Errorf("unable to read %v, %v", file, err))
+		} else if err := json.Unmarshal(data, &prov); err != nil {
+			return errorHandler(fmt.Errorf("unable to parse %v, %v", file, err))
+		} else if err := prov.Validate(); err != nil {
+			return errorHandler(err)
+		}
+		return a.checkProvisioningExchange(prov.ExchangeURL, errorHandler)
+	})
+	if !ok {
+		return result
+	}
+
+	LogDeviceEvent(a.db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_START_PROVISIONING, *prov.Node.Id, file), persistence.EC_START_NODE_PROVISIONING, &prov.Node)
+
+	if !step("register", func(errorHandler ErrorHandler) bool {
+		errHandled, _ := a.registerNode(&prov.Node, errorHandler)
+		return errHandled
+	}) {
+		return result
+	}
+
+	for i := range prov.Services {
+		service := &prov.Services[i]
+		if !step(fmt.Sprintf("service %v/%v", *service.Org, *service.Url), func(errorHandler ErrorHandler) bool {
+			errHandled, _ := a.createService(ctx, service, errorHandler)
+			return errHandled
+		}) {
+			return result
+		}
+	}
+
+	if prov.ConfigState != persistence.CONFIGSTATE_CONFIGURING {
+		state := persistence.CONFIGSTATE_CONFIGURED
+		if !step("configstate", func(errorHandler ErrorHandler) bool {
+			errHandled, _ := a.updateConfigstate(ctx, &Configstate{State: &state}, errorHandler, false, "")
+			return errHandled
+		}) {
+			return result
+		}
+	}
+
+	result.Succeeded = true
+	LogDeviceEvent(a.db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_COMPLETE_PROVISIONING, *prov.Node.Id, file), persistence.EC_NODE_PROVISIONING_COMPLETE, &prov.Node)
+	return result
+}
+
+// The exchange of the provisioning file must be the one anax is configured with. The workers read the exchange URL of
+// the config when they start, so it cannot be set from the provisioning file.
+func (a *API) checkProvisioningExchange(exchangeURL string, errorHandler ErrorHandler) bool {
+	configured := a.Config.Edge.ExchangeURL
+	if configured == "" {
+		return errorHandler(fmt.Errorf("anax has no exchange URL configured, set Edge.ExchangeURL or %v", config.ExchangeURLEnvvarName))
+	} else if exchangeURL != "" && strings.TrimSuffix(configured, "/") != strings.TrimSuffix(exchangeURL, "/") {
+		return errorHandler(fmt.Errorf("exchange_url %v is not the exchange URL %v that anax is configured with", exchangeURL, configured))
+	}
+	return false
+}
+
+// Write the result file and rename the provisioning file when it succeeded, so that it is not used again.
+func (a *API) saveProvisioningResult(file string, result *ProvisioningResult) {
+	result.EndTime = uint64(time.Now().Unix())
+	glog.Infof(apiLogString(fmt.Sprintf("Provisioning from %v succeeded: %v, steps: %v", file, result.Succeeded, result.Steps)))
+
+	if serial, err := json.MarshalIndent(result, "", "  "); err != nil {
+		glog.Errorf(apiLogString(fmt.Sprintf("Unable to serialize the provisioning result %v, error %v", result, err)))
+	} else if err := ioutil.WriteFile(file+PROVISIONING_RESULT_SUFFIX, serial, 0600); err != nil {
+		glog.Errorf(apiLogString(fmt.Sprintf("Unable to write the provisioning result file, error %v", err)))
+	}
+
+	if !result.Succeeded {
+		glog.Warningf(apiLogString(fmt.Sprintf("Provisioning file %v failed, it is kept to be used again when anax restarts", file)))
+	} else if err := os.Rename(file, file+PROVISIONING_DONE_SUFFIX); err != nil {
+		glog.Errorf(apiLogString(fmt.Sprintf("Unable to rename provisioning file %v, it will be used again when anax restarts, error %v", file, err)))
+	}
+}
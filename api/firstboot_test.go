@@ -0,0 +1,86 @@
+// +build unit
+
+package api
+
+import (
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/worker"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ProvisioningValidate(t *testing.T) {
+
+	id, org, token, url := "mydevice", "mycompany", "abc", "https://bluehorizon.network/services/netspeed"
+
+	tests := []struct {
+		prov  Provisioning
+		valid bool
+	}{
+		{Provisioning{Node: HorizonDevice{Id: &id, Org: &org, Token: &token}}, true},
+		{Provisioning{Node: HorizonDevice{Id: &id, Org: &org}}, false},
+		{Provisioning{Node: HorizonDevice{Id: &id, Org: &org, Token: &token}, Services: []Service{{Url: &url, Org: &org}}}, true},
+		{Provisioning{Node: HorizonDevice{Id: &id, Org: &org, Token: &token}, Services: []Service{{Url: &url}}}, false},
+		{Provisioning{Node: HorizonDevice{Id: &id, Org: &org, Token: &token}, ConfigState: "configuring"}, true},
+		{Provisioning{Node: HorizonDevice{Id: &id, Org: &org, Token: &token}, ConfigState: "unconfigured"}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.prov.Validate(); (err == nil) != test.valid {
+			t.Errorf("provisioning %v should be valid %v, got error %v", test.prov, test.valid, err)
+		}
+	}
+}
+
+func Test_checkProvisioningExchange(t *testing.T) {
+
+	errorHandler := func(err error) bool { return err != nil }
+
+	a := &API{Manager: worker.Manager{Config: &config.HorizonConfig{}}}
+	if a.checkProvisioningExchange("https://exchange/v1", errorHandler) == false {
+		t.Errorf("expected an error without a configured exchange URL")
+	} else if a.Config.Edge.ExchangeURL != "" {
+		t.Errorf("expected the config not to be changed, got %v", a.Config.Edge.ExchangeURL)
+	}
+
+	a.Config.Edge.ExchangeURL = "https://exchange/v1/"
+	if a.checkProvisioningExchange("", errorHandler) == true {
+		t.Errorf("expected the configured exchange URL to be used")
+	} else if a.checkProvisioningExchange("https://exchange/v1", errorHandler) == true {
+		t.Errorf("expected the same exchange URL to be accepted")
+	} else if a.checkProvisioningExchange("https://other/v1", errorHandler) == false {
+		t.Errorf("expected an error for another exchange URL")
+	}
+}
+
+// The provisioning file is only renamed when it succeeded, a failed one is used again.
+func Test_saveProvisioningResult(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "firstboot")
+	if err != nil {
+		t.Fatalf("unable to create a temp dir, error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := &API{Manager: worker.Manager{Config: &config.HorizonConfig{}}}
+	file := filepath.Join(dir, "provisioning.json")
+	if err := ioutil.WriteFile(file, []byte("{}"), 0600); err != nil {
+		t.Fatalf("unable to write the provisioning file, error %v", err)
+	}
+
+	a.saveProvisioningResult(file, &ProvisioningResult{File: file, Steps: []ProvisioningStep{{Step: "validate", Error: "node id is required"}}})
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("expected the failed provisioning file to be kept, error %v", err)
+	} else if _, err := os.Stat(file + PROVISIONING_RESULT_SUFFIX); err != nil {
+		t.Errorf("expected the result file to be written, error %v", err)
+	}
+
+	a.saveProvisioningResult(file, &ProvisioningResult{File: file, Succeeded: true})
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("expected the provisioning file to be renamed, error %v", err)
+	} else if _, err := os.Stat(file + PROVISIONING_DONE_SUFFIX); err != nil {
+		t.Errorf("expected the provisioning file to be renamed with the done suffix, error %v", err)
+	}
+}
@@ -11,13 +11,89 @@ import (
 type Configstate struct {
 	State          *string `json:"state"`
 	LastUpdateTime *uint64 `json:"last_update_time,omitempty"`
+	Force          *bool   `json:"force,omitempty"`  // when going back to configuring, cancel the agreements without waiting for them to end gracefully
+	DryRun         *bool   `json:"dryrun,omitempty"` // report the services that configuring the node would register, without changing anything
+
+	// Configure the node even when the pattern has no services for its hardware architectures, with nothing to run.
+	AllowEmpty *bool `json:"allow_empty,omitempty"`
+
+	// Check that the node can reach the exchange with its credentials before the node is configured, as GET
+	// /node/connectivity does, and fail right away when it cannot.
+	CheckConnectivity *bool `json:"check_connectivity,omitempty"`
+
+	// Resolve the pattern of the node from the offline definitions of the Edge.OfflineBundlePath of the config rather
+	// than the exchange, e.g. for a node that is configured before it can reach the exchange. They are also used without
+	// it when the exchange cannot be reached. The output of the change has it set when they were used.
+	Offline *bool `json:"offline,omitempty"`
+
+	Archs    []string             `json:"archs,omitempty"`    // the hardware architectures of the services that the autoconfig configures, output only
+	Services *[]AutoconfigService `json:"services,omitempty"` // the output of a dry run
+
+	// The version range to register for some of the services of the pattern, by org/url, instead of all the versions
+	// the pattern allows. The output has the version ranges that the services were pinned to when the node was configured.
+	Versions map[string]string `json:"versions,omitempty"`
+
+	// When the node is changed to configured, in seconds since the epoch. A time in the future configures the services
+	// right away but leaves the node configured_pending, without agreements, until then.
+	EffectiveTime *uint64 `json:"effective_time,omitempty"`
+
+	// The services that the autoconfig of the pattern skips, e.g. the ones for hardware that the node does not have. The
+	// org of a service defaults to the node's org. They are kept until they are changed, and can only be changed while the
+	// node is configuring. The workloads that require them do not get agreements.
+	ExcludedServices *persistence.ServiceSpecs `json:"excluded_services,omitempty"`
+
+	// The channel of the version choices of the pattern that the autoconfig resolves, e.g. stable, so that the services
+	// of the other tracks of the pattern, e.g. beta, are not configured on the node. It is kept until it is changed, and
+	// can only be changed while the node is configuring. Empty resolves all the choices.
+	Channel *string `json:"channel,omitempty"`
+
+	// The top-level services of the pattern that the node can run without, e.g. an analytics workload whose dependencies
+	// are not always available. The autoconfig skips the ones that cannot be resolved rather than failing, and reports
+	// them in the Warnings of the output. The org of a service defaults to the node's org. They are kept until they are
+	// changed, and can only be changed while the node is configuring.
+	OptionalServices *persistence.ServiceSpecs `json:"optional_services,omitempty"`
+
+	// The optional services that the autoconfig skipped because they could not be resolved, output only.
+	Warnings []ServiceConfigProblem `json:"warnings,omitempty"`
+
+	LastError *persistence.ConfigstateAttempt `json:"last_error,omitempty"` // the last change of the state that failed, output only
+
+	// The services of the node's pattern that the autoconfig configures, the ones of them that are already registered and
+	// the ones that lack user input, output only. ResolutionUnavailable is set instead when the pattern cannot be resolved.
+	ServicesRequired      *int  `json:"services_required,omitempty"`
+	ServicesRegistered    *int  `json:"services_registered,omitempty"`
+	ServicesMissingConfig *int  `json:"services_missing_config,omitempty"`
+	ResolutionUnavailable *bool `json:"resolution_unavailable,omitempty"`
+}
+
+// A service that the autoconfig of the node's pattern would register, as reported by a dry run of the change to configured.
+type AutoconfigService struct {
+	Url           string `json:"url"`
+	Org           string `json:"organization"`
+	Version       string `json:"version"` // the version range that would be registered
+	Arch          string `json:"arch"`
+	Registered    bool   `json:"registered"`               // already registered, e.g. through /service/config, so it would be left as is
+	MissingConfig string `json:"missing_config,omitempty"` // why the service would fail to register for lack of user input
+	Dependency    bool   `json:"dependency,omitempty"`     // required by the top-level services, registered with them
+}
+
+func (a AutoconfigService) String() string {
+	return fmt.Sprintf("Url: %v, Org: %v, Version: %v, Arch: %v, Registered: %v, MissingConfig: %v, Dependency: %v", a.Url, a.Org, a.Version, a.Arch, a.Registered, a.MissingConfig, a.Dependency)
 }
 
 func (c *Configstate) String() string {
 	if c == nil {
 		return "Configstate: not set"
 	} else {
-		return fmt.Sprintf("State: %v, Time: %v", *c.State, *c.LastUpdateTime)
+		lastUpdateTime := uint64(0)
+		if c.LastUpdateTime != nil {
+			lastUpdateTime = *c.LastUpdateTime
+		}
+		effectiveTime := uint64(0)
+		if c.EffectiveTime != nil {
+			effectiveTime = *c.EffectiveTime
+		}
+		return fmt.Sprintf("State: %v, Time: %v, Versions: %v, EffectiveTime: %v", *c.State, lastUpdateTime, c.Versions, effectiveTime)
 	}
 }
 
@@ -87,7 +163,7 @@ func (h HorizonDevice) String() string {
 // This is a type conversion function but note that the token field within the persistent
 // is explicitly omitted so that it's not exposed in the API.
 func ConvertFromPersistentHorizonDevice(pDevice *persistence.ExchangeDevice) *HorizonDevice {
-	return &HorizonDevice{
+	hd := &HorizonDevice{
 		Id:                 &pDevice.Id,
 		Org:                &pDevice.Org,
 		Pattern:            &pDevice.Pattern,
@@ -99,8 +175,22 @@ func ConvertFromPersistentHorizonDevice(pDevice *persistence.ExchangeDevice) *Ho
 		Config: &Configstate{
 			State:          &pDevice.Config.State,
 			LastUpdateTime: &pDevice.Config.LastUpdateTime,
+			Versions:       pDevice.Config.Versions,
 		},
 	}
+	if pDevice.Config.EffectiveTime != 0 {
+		hd.Config.EffectiveTime = &pDevice.Config.EffectiveTime
+	}
+	if len(pDevice.Config.Excluded) != 0 {
+		hd.Config.ExcludedServices = &pDevice.Config.Excluded
+	}
+	if pDevice.Config.Channel != "" {
+		hd.Config.Channel = &pDevice.Config.Channel
+	}
+	if len(pDevice.Config.Optional) != 0 {
+		hd.Config.OptionalServices = &pDevice.Config.Optional
+	}
+	return hd
 }
 
 type Attribute struct {
@@ -1,8 +1,13 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"reflect"
 	"regexp"
+	"strings"
 )
 
 // \pL -- unicode letter
@@ -49,7 +54,7 @@ func checkInputString(errorHandler ErrorHandler, fieldId string, input *string)
 
 	// if true, bail
 	if input == nil {
-		return errorHandler(NewAPIUserInputError(nErrMsg, fieldId))
+		return errorHandler(NewAPIUserInputError(nErrMsg, fieldId).WithCode(ERR_INVALID_INPUT))
 	}
 	inputErr, err := InputIsIllegal(*input)
 	if err != nil {
@@ -57,8 +62,94 @@ func checkInputString(errorHandler ErrorHandler, fieldId string, input *string)
 	}
 
 	if inputErr != "" {
-		return errorHandler(NewAPIUserInputError(inputErr, fieldId))
+		return errorHandler(NewAPIUserInputError(inputErr, fieldId).WithCode(ERR_INVALID_INPUT))
 	}
 
 	return false
 }
+
+// The prefix of the error that a decoder which disallows unknown fields returns for a field that is not in the object.
+const unknownFieldErrPrefix = "json: unknown field "
+
+// Decode the JSON body of a request into obj, a pointer to one of the input objects of this API. A field that is not
+// in the object is rejected rather than ignored, it is usually a misspelled field. Numbers are kept as json.Number for
+// the fields whose type is not known until the input is validated, e.g. the mappings of an attribute. The errors are
+// APIUserInputErrors scoped to the offending field when it is known, otherwise to input.
+func decodeInputBody(body []byte, obj interface{}, input string) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(obj); err != nil {
+		return inputDecodeError(err, input)
+	} else if _, err := decoder.Token(); err != io.EOF {
+		return NewAPIUserInputError("the body must hold a single JSON object, there is more input after it", input).WithCode(ERR_INVALID_INPUT)
+	}
+	return nil
+}
+
+// Convert an error of the JSON decoder to an APIUserInputError that says what is wrong with the input.
+func inputDecodeError(err error, input string) error {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		return NewAPIUserInputError(fmt.Sprintf("the body is not valid JSON, error at offset %v: %v", e.Offset, e), input).WithCode(ERR_INVALID_INPUT)
+	case *json.UnmarshalTypeError:
+		if e.Field == "" {
+			return NewAPIUserInputError(fmt.Sprintf("the body must be %v, not a JSON %v", jsonTypeName(e.Type), e.Value), input).WithCode(ERR_INVALID_INPUT)
+		}
+		return NewAPIUserInputError(fmt.Sprintf("the value must be %v, not a JSON %v", jsonTypeName(e.Type), e.Value), fmt.Sprintf("%v.%v", input, e.Field)).WithCode(ERR_INVALID_INPUT)
+	}
+
+	if err == io.EOF {
+		return NewAPIUserInputError("the body is empty", input).WithCode(ERR_INVALID_INPUT)
+	} else if err == io.ErrUnexpectedEOF {
+		return NewAPIUserInputError("the body is not valid JSON, it ends before the object is complete", input).WithCode(ERR_INVALID_INPUT)
+	} else if msg := err.Error(); strings.HasPrefix(msg, unknownFieldErrPrefix) {
+		field := strings.Trim(strings.TrimPrefix(msg, unknownFieldErrPrefix), `"`)
+		return NewAPIUserInputError(fmt.Sprintf("unknown field %v, check the spelling of the field name", field), input).WithCode(ERR_INVALID_INPUT)
+	}
+	return NewAPIUserInputError(fmt.Sprintf("the body could not be decoded, error: %v", err), input).WithCode(ERR_INVALID_INPUT)
+}
+
+// Returns the JSON type that a value of type t is decoded from, for the error messages.
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	default:
+		return t.String()
+	}
+}
+
+// Check the fields that a config state change must have. The values are validated when the state is changed.
+func validateConfigstateInput(cfg *Configstate) error {
+	if cfg.State == nil {
+		return NewAPIUserInputError("null and must not be", "configstate.state").WithCode(ERR_INVALID_INPUT)
+	}
+	return nil
+}
+
+// Check the fields that the node must have to be registered (create is true) or updated. The values are validated
+// when the node is registered or updated. The id of a new node may come from the HZN_DEVICE_ID environment variable.
+func validateHorizonDeviceInput(device *HorizonDevice, create bool) error {
+	if !create && device.Id == nil {
+		return NewAPIUserInputError("null and must not be", "device.id").WithCode(ERR_INVALID_INPUT)
+	} else if create && device.Org == nil {
+		return NewAPIUserInputError("null and must not be", "device.organization").WithCode(ERR_INVALID_INPUT)
+	} else if device.Token == nil {
+		return NewAPIUserInputError("null and must not be", "device.token").WithCode(ERR_INVALID_INPUT)
+	}
+	return nil
+}
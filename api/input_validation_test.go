@@ -3,6 +3,8 @@
 package api
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
 )
 
@@ -203,3 +205,81 @@ func Test_CheckInputString_unicode(t *testing.T) {
 	}
 
 }
+
+func Test_decodeInputBody(t *testing.T) {
+
+	// the fields of the object are decoded, the numbers of the mappings are kept as json.Number
+	var attr Attribute
+	if err := decodeInputBody([]byte(`{"type":"UserInputAttributes","label":"l","mappings":{"n":3}}`), &attr, "attribute"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if attr.Type == nil || *attr.Type != "UserInputAttributes" {
+		t.Errorf("wrong type %v", attr)
+	} else if _, ok := (*attr.Mappings)["n"].(json.Number); !ok {
+		t.Errorf("the number should be a json.Number, is %T", (*attr.Mappings)["n"])
+	}
+
+	// the errors are scoped to the object, or to the field when it is known
+	failures := []struct {
+		body  string
+		obj   interface{}
+		input string // the input that the body is decoded as
+		path  string // the input that the error is scoped to
+		msg   string
+	}{
+		{``, &Configstate{}, "configstate", "configstate", "empty"},
+		{`{"state":"configured"`, &Configstate{}, "configstate", "configstate", "not valid JSON"},
+		{`{"state":configured}`, &Configstate{}, "configstate", "configstate", "not valid JSON"},
+		{`{"state":"configured","stat":"configured"}`, &Configstate{}, "configstate", "configstate", "unknown field stat"},
+		{`{"state":"configured"} {}`, &Configstate{}, "configstate", "configstate", "single JSON object"},
+		{`{"state":true}`, &Configstate{}, "configstate", "configstate.state", "must be a string, not a JSON bool"},
+		{`{"effective_time":"soon"}`, &Configstate{}, "configstate", "configstate.effective_time", "must be a number"},
+		{`["configured"]`, &Configstate{}, "configstate", "configstate", "must be an object"},
+		{`{"id":"n","org":"o"}`, &HorizonDevice{}, "device", "device", "unknown field org"},
+		{`{"url":"u","attributes":[{"type":"t","mapings":{}}]}`, &Service{}, "service", "service", "unknown field mapings"},
+		{`{"type":"t","publishable":"yes"}`, &Attribute{}, "attribute", "attribute.publishable", "must be a boolean"},
+	}
+
+	for _, f := range failures {
+		err := decodeInputBody([]byte(f.body), f.obj, f.input)
+		if apiErr, ok := err.(*APIUserInputError); !ok {
+			t.Errorf("%v: error has the wrong type (%T) %v", f.body, err, err)
+		} else if apiErr.Input != f.path {
+			t.Errorf("%v: wrong error input field %v", f.body, *apiErr)
+		} else if !strings.Contains(apiErr.Error(), f.msg) {
+			t.Errorf("%v: the error %v should contain %v", f.body, apiErr, f.msg)
+		}
+	}
+}
+
+func Test_validateHorizonDeviceInput(t *testing.T) {
+
+	id := "n"
+	org := "o"
+	token := "t"
+
+	checks := []struct {
+		device HorizonDevice
+		create bool
+		input  string
+	}{
+		{HorizonDevice{Org: &org, Token: &token}, true, ""},
+		{HorizonDevice{Token: &token}, true, "device.organization"},
+		{HorizonDevice{Org: &org}, true, "device.token"},
+		{HorizonDevice{Id: &id, Token: &token}, false, ""},
+		{HorizonDevice{Token: &token}, false, "device.id"},
+		{HorizonDevice{Id: &id}, false, "device.token"},
+	}
+
+	for _, c := range checks {
+		err := validateHorizonDeviceInput(&c.device, c.create)
+		if c.input == "" {
+			if err != nil {
+				t.Errorf("%v: unexpected error %v", c.device, err)
+			}
+		} else if apiErr, ok := err.(*APIUserInputError); !ok {
+			t.Errorf("%v: error has the wrong type (%T) %v", c.device, err, err)
+		} else if apiErr.Input != c.input {
+			t.Errorf("%v: wrong error input field %v", c.device, *apiErr)
+		}
+	}
+}
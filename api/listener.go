@@ -0,0 +1,215 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/apicommon"
+	"github.com/open-horizon/anax/config"
+)
+
+// How often the certificate files of the TLS listeners are checked for changes.
+const certCheckInterval = 60 * time.Second
+
+// Returns the listeners of the agent API, the APIListen listener first.
+func apiListeners(cfg *config.HorizonConfig) []config.APIListenerConfig {
+	listeners := []config.APIListenerConfig{{Address: cfg.Edge.APIListen}}
+	return append(listeners, cfg.Edge.APIListeners...)
+}
+
+// Bind the listener to its address. The TLS server certificate and the client CAs are loaded here, so that a listener
+// that cannot serve is reported at startup.
+func bindListener(cfg *config.HorizonConfig, lc config.APIListenerConfig) (net.Listener, error) {
+	var tlsConfig *tls.Config
+	if lc.IsTLS() {
+		certs, err := newCertReloader(cfg, lc.TLSCertFile, lc.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		go certs.watch()
+		tlsConfig = &tls.Config{GetCertificate: certs.GetCertificate, MinVersion: tls.VersionTLS12}
+
+		// The client certificate is checked for the requests that make changes, by requireClientCert.
+		if lc.RequiresClientCert() {
+			pem, err := ioutil.ReadFile(lc.ClientCAFile)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read the client CA file %v, %v", lc.ClientCAFile, err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in the client CA file %v", lc.ClientCAFile)
+			}
+			tlsConfig.ClientCAs = pool
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	network, address := lc.NetworkAddress()
+
+	// A socket left behind by a previous anax process would prevent the bind.
+	if network == "unix" {
+		if info, err := os.Lstat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(address); err != nil {
+				return nil, fmt.Errorf("unable to remove the old socket %v, %v", address, err)
+			}
+		}
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig != nil {
+		return tls.NewListener(l, tlsConfig), nil
+	}
+	return l, nil
+}
+
+// Only lets the requests that make changes through when they present a verified client certificate. The requests
+// that only read are always let through.
+func requireClientCert(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" && r.Method != "OPTIONS" && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			glog.Warningf(apiLogString(fmt.Sprintf("Rejected %v %v from %v without a client certificate", r.Method, r.URL.Path, r.RemoteAddr)))
+			http.Error(w, "a client certificate is required to make changes through this listener", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Returns the listener as shown in the node status.
+func listenerStatus(lc config.APIListenerConfig) apicommon.APIListener {
+	return apicommon.APIListener{
+		Address:            lc.Address,
+		TLS:                lc.IsTLS(),
+		ClientCertRequired: lc.RequiresClientCert(),
+	}
+}
+
+// Serves the server certificate of a TLS listener, and reloads it on SIGHUP and when its files change so that short
+// lived certificates can be renewed without restarting anax.
+type certReloader struct {
+	cfg      *config.HorizonConfig
+	certFile string
+	keyFile  string
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time // of the certificate and key files when they were loaded
+}
+
+// Load the certificate, it must be valid for anax to start.
+func newCertReloader(cfg *config.HorizonConfig, certFile string, keyFile string) (*certReloader, error) {
+	r := &certReloader{cfg: cfg, certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
+
+// Load the certificate and key files. An expired certificate is rejected, one that expires soon is logged. The
+// current certificate stays in use when the files cannot be loaded.
+func (r *certReloader) reload() error {
+	modTimes, err := r.fileModTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load the server certificate %v and key %v, %v", r.certFile, r.keyFile, err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("unable to parse the server certificate %v, %v", r.certFile, err)
+	}
+
+	if err := checkCertExpiry(r.certFile, leaf, time.Now(), r.cfg.LiveEdge().APICertExpiryWarningDays); err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cert = &cert
+	r.modTimes = modTimes
+	return nil
+}
+
+func (r *certReloader) fileModTimes() ([2]time.Time, error) {
+	modTimes := [2]time.Time{}
+	for i, f := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return modTimes, fmt.Errorf("unable to access %v, %v", f, err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// Returns true if the certificate or key file changed since they were loaded.
+func (r *certReloader) changed() bool {
+	modTimes, err := r.fileModTimes()
+	if err != nil {
+		glog.Errorf(apiLogString(err.Error()))
+		return false
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return modTimes != r.modTimes
+}
+
+// Reload the certificate on SIGHUP and when its files change. This routine terminates when the main anax process
+// goes away.
+func (r *certReloader) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(certCheckInterval)
+
+	for {
+		select {
+		case <-hup:
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+		}
+
+		if err := r.reload(); err != nil {
+			glog.Errorf(apiLogString(fmt.Sprintf("Unable to reload the server certificate, the current one stays in use. %v", err)))
+		} else {
+			glog.Infof(apiLogString(fmt.Sprintf("Reloaded the server certificate %v", r.certFile)))
+		}
+	}
+}
+
+// An expired certificate or one that is not valid yet is an error, one that expires within the warning days is
+// logged.
+func checkCertExpiry(certFile string, cert *x509.Certificate, now time.Time, warningDays int) error {
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("the server certificate %v expired on %v", certFile, cert.NotAfter)
+	} else if now.Before(cert.NotBefore) {
+		return fmt.Errorf("the server certificate %v is not valid before %v", certFile, cert.NotBefore)
+	} else if left := cert.NotAfter.Sub(now); left < time.Duration(warningDays)*24*time.Hour {
+		glog.Warningf(apiLogString(fmt.Sprintf("The server certificate %v expires in %v days, on %v", certFile, int(left.Hours()/24), cert.NotAfter)))
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+// +build unit
+
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// Write a self signed certificate and its key that are valid between the given times.
+func writeTestCert(t *testing.T, dir string, notBefore time.Time, notAfter time.Time) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key, error %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(notAfter.Unix()),
+		Subject:      pkix.Name{CommonName: "anax-test"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unable to create certificate, error %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("unable to marshal key, error %v", err)
+	}
+
+	certFile, keyFile := path.Join(dir, "cert.pem"), path.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("unable to write certificate, error %v", err)
+	} else if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatalf("unable to write key, error %v", err)
+	}
+	return certFile, keyFile
+}
+
+func Test_certReloader(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "anax-certs-")
+	if err != nil {
+		t.Fatalf("unable to create dir, error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := getBasicConfig()
+	cfg.Edge.APICertExpiryWarningDays = 30
+
+	// an expired certificate is rejected at startup
+	now := time.Now()
+	certFile, keyFile := writeTestCert(t, dir, now.Add(-48*time.Hour), now.Add(-24*time.Hour))
+	if _, err := newCertReloader(cfg, certFile, keyFile); err == nil {
+		t.Errorf("expected an error for an expired certificate")
+	}
+
+	writeTestCert(t, dir, now.Add(-time.Hour), now.Add(24*time.Hour))
+	r, err := newCertReloader(cfg, certFile, keyFile)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if r.changed() {
+		t.Errorf("the certificate files have not changed")
+	}
+	first, _ := r.GetCertificate(nil)
+
+	// a renewed certificate is picked up when it is reloaded
+	writeTestCert(t, dir, now.Add(-time.Hour), now.Add(48*time.Hour))
+	os.Chtimes(certFile, now.Add(time.Minute), now.Add(time.Minute))
+	if !r.changed() {
+		t.Errorf("the certificate file has changed")
+	} else if err := r.reload(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if second, _ := r.GetCertificate(nil); second == first {
+		t.Errorf("the certificate was not reloaded")
+	}
+
+	// a broken certificate file does not replace the current certificate
+	current, _ := r.GetCertificate(nil)
+	ioutil.WriteFile(certFile, []byte("not a certificate"), 0600)
+	if err := r.reload(); err == nil {
+		t.Errorf("expected an error for a broken certificate")
+	} else if cert, _ := r.GetCertificate(nil); cert != current {
+		t.Errorf("the current certificate was replaced")
+	}
+}
+
+func Test_requireClientCert(t *testing.T) {
+
+	h := requireClientCert(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{&x509.Certificate{}}}}
+	tests := []struct {
+		method string
+		tls    *tls.ConnectionState
+		status int
+	}{
+		{"GET", nil, http.StatusOK},
+		{"OPTIONS", &tls.ConnectionState{}, http.StatusOK},
+		{"POST", nil, http.StatusForbidden},
+		{"PUT", &tls.ConnectionState{}, http.StatusForbidden},
+		{"DELETE", verified, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "/node", nil)
+		req.TLS = test.tls
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != test.status {
+			t.Errorf("%v with tls %v: expected status %v, got %v", test.method, test.tls != nil, test.status, rr.Code)
+		}
+	}
+}
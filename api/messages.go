@@ -26,13 +26,25 @@ const (
 	EL_API_ERR_IN_NODE_UI_PATCH       = "Error in patching node user input. %v"
 	EL_API_ERR_IN_NODE_UI_DEL         = "Error in deleting node userinput. %v"
 
+	EL_API_MAINTENANCE_WINDOW_OPENED = "Maintenance window opened by an operator for %v minutes."
+	EL_API_MAINTENANCE_WINDOW_CLOSED = "Maintenance window opened by an operator is closed."
+
+	// from firstboot.go
+	EL_API_START_PROVISIONING    = "Start provisioning node %v from %v."
+	EL_API_COMPLETE_PROVISIONING = "Provisioning node %v from %v complete."
+	EL_API_ERR_PROVISIONING      = "Error provisioning the node from %v at step %v. %v"
+
 	// from path_node.go
 	EL_API_START_NODE_REG       = "Start node configuration/registration for node %v."
 	EL_API_START_NODE_UPDATE    = "Start updating node %v."
 	EL_API_COMPLETE_NODE_UPDATE = "Complete node update for %v."
+	EL_API_NODE_TOKEN_ROTATED   = "The exchange token of node %v was rotated."
 	EL_API_START_NODE_UNREG     = "Start node unregistration."
 	EL_API_COMPLETE_NODE_UNREG  = "Node unregistration complete for node %v."
 
+	EL_API_NODE_PATTERN_CHANGED         = "Node pattern changed from %v to %v. Kept the configuration of services %v, removed services %v, services %v will be configured when the node is configured."
+	EL_API_ERR_NODE_PATTERN_SVC_REMOVAL = "Error removing service %v that pattern %v does not need. %v"
+
 	EL_API_ERR_NODE_UNREG_NOT_FOUND             = "Error unregistering the node. The node is not found from the database."
 	EL_API_ERR_NODE_UNREG_NOT_IN_STATE          = "Error unregistering the node. The node must be in 'configured' or 'configuring' state in order to unconfigure it."
 	EL_API_ERR_NODE_UNREG_WRONG_VALUE_FOR_RN    = "Input error for node unregistration. %v is an incorrect value for removeNode"
@@ -43,16 +55,49 @@ const (
 	EL_API_ERR_SAVE_NODE_CONF_TO_DB = "Error saving new node config state (unconfiguring) in the database: %v"
 
 	// from path_node_configstate.go
-	EL_API_ERR_NODE_CONF_NOT_FOUND    = "Error in node configuration. The node is not found from the database."
-	EL_API_ERR_NODE_CONF_WRONG_STATE  = "Error in node configuration. The node must be in 'configured' or 'configuring' state in order to change the state to %v."
-	EL_API_UNSUP_NODE_STATE_TRANS     = "Node state transition from '%v' to '%v' is not supported."
-	EL_API_FAIL_GET_UI_FROM_DB        = "Failed get user input from local db. %v"
-	EL_API_FAIL_FIND_SVC_PREF_FROM_UI = "Failed to find preferences for service %v/%v from the local user input, error: %v"
-	EL_API_ERR_SAVE_NODE_CONFSTATE    = "Error saving new node config state to database: %v"
-	EL_API_COMPLETE_NODE_REG          = "Complete node configuration/registration for node %v."
-	EL_API_ERR_SVC_CONF               = "Error in service configuration for %v. %v"
-	EL_API_ERR_GET_SREFS_FOR_PATTERN  = "Error getting service references for pattern %v. %v"
-	EL_API_IGNORE_TYPE_MISMATCH       = "Ignoring service. %v"
+	EL_API_ERR_NODE_CONF_NOT_FOUND      = "Error in node configuration. The node is not found from the database."
+	EL_API_ERR_NODE_CONF_WRONG_STATE    = "Error in node configuration. The node must be in 'configured' or 'configuring' state in order to change the state to %v."
+	EL_API_UNSUP_NODE_STATE_TRANS       = "Node state transition from '%v' to '%v' is not supported."
+	EL_API_ERR_NODE_CONF_DISK_SPACE     = "Error in node configuration. Not enough disk space to configure the services: %v"
+	EL_API_ERR_NODE_CONF_CLOCK_SKEW     = "Error in node configuration. The clock of the node is %.0f seconds off the clock of the exchange, more than %v seconds."
+	EL_API_ERR_NODE_CONF_CONNECTIVITY   = "Error in node configuration. The connectivity checks failed: %v"
+	EL_API_ERR_NODE_CONF_OFFLINE        = "Error in node configuration. The offline definitions cannot be used: %v"
+	EL_API_NODE_CONF_OFFLINE            = "Configured node %v from the offline definitions in %v, %v patterns and %v services were used. They will be compared with the exchange once it can be reached."
+	EL_API_NODE_REG_OFFLINE             = "Registered node %v with the offline definitions in %v, the exchange cannot be reached. The node will be set up with the exchange once it can be reached."
+	EL_API_ERR_NODE_CONF_VERSIONS       = "Error in node configuration. The services cannot be pinned to the version ranges: %v"
+	EL_API_FAIL_GET_UI_FROM_DB          = "Failed get user input from local db. %v"
+	EL_API_FAIL_FIND_SVC_PREF_FROM_UI   = "Failed to find preferences for service %v/%v from the local user input, error: %v"
+	EL_API_ERR_SAVE_NODE_CONFSTATE      = "Error saving new node config state to database: %v"
+	EL_API_COMPLETE_NODE_REG            = "Complete node configuration/registration for node %v."
+	EL_API_NODE_CONF_PENDING            = "Completed the configuration of the services of node %v, the node will be configured at %v."
+	EL_API_ERR_NODE_CONF_EFFECTIVE_TIME = "Error in node configuration. The effective time cannot be set: %v"
+	EL_API_ERR_NODE_CONF_EXCLUSIONS     = "Error in node configuration. The excluded services cannot be set: %v"
+	EL_API_ERR_NODE_CONF_CHANNEL        = "Error in node configuration. The channel cannot be set: %v"
+	EL_API_ERR_NODE_CONF_CANCELLED      = "Error in node configuration. The change was stopped: %v"
+	EL_API_NODE_AUTOCONFIG_EXCLUDED     = "Skipped the excluded services %v in the autoconfig of pattern %v."
+	EL_API_ERR_NODE_CONF_OPTIONAL       = "Error in node configuration. The optional services cannot be set: %v"
+	EL_API_NODE_AUTOCONFIG_OPTIONAL     = "Skipped the optional services of pattern %v that cannot be resolved: %v"
+	EL_API_ERR_SVC_CONF                 = "Error in service configuration for %v. %v"
+	EL_API_ERR_GET_SREFS_FOR_PATTERN    = "Error getting service references for pattern %v. %v"
+	EL_API_ERR_NODE_AUTOCONFIG          = "Error in the autoconfig of %v services of pattern %v: %v"
+	EL_API_NODE_AUTOCONFIG_ROLLBACK     = "Removed the %v services created by the failed autoconfig of pattern %v."
+	EL_API_ERR_NODE_AUTOCONFIG_ROLLBACK = "Error removing %v created by the failed autoconfig. %v"
+	EL_API_IGNORE_TYPE_MISMATCH         = "Ignoring service. %v"
+	EL_API_START_NODE_UNCONFIG          = "Start returning node %v to the configuring state, force: %v."
+	EL_API_COMPLETE_NODE_UNCONFIG       = "Node %v returned to the configuring state, %v agreements are being cancelled."
+	EL_API_ERR_NODE_UNCONFIG            = "Error returning the node to the configuring state. %v"
+
+	// from path_node_export.go
+	EL_API_START_NODE_IMPORT    = "Start importing the configuration of node %v into node %v."
+	EL_API_COMPLETE_NODE_IMPORT = "Complete importing the configuration of node %v, %v attributes and %v services."
+	EL_API_ERR_NODE_IMPORT      = "Error importing the node configuration, nothing was changed. %v"
+
+	// from reconcile.go
+	EL_API_SVC_MISSING            = "Service %v of pattern %v is not registered on the node."
+	EL_API_SVC_SUPERFLUOUS        = "Service %v is registered on the node, pattern %v does not require it."
+	EL_API_SVC_RECONCILE_CREATED  = "Created the missing service %v of pattern %v."
+	EL_API_ERR_SVC_RECONCILE      = "Error comparing the services of the node with the ones of pattern %v. %v"
+	EL_API_SVC_RECONCILE_COMPLETE = "Compared the services of the node with the ones of pattern %v, %v are missing, %v are superfluous, %v were created."
 
 	// from path_node_policy.go
 	EL_API_NEW_NODE_POL     = "New node policy: %v"
@@ -68,6 +113,7 @@ const (
 	EL_API_START_SVC_AUTO_CONFIG      = "Start service auto configuration for %v/%v."
 	EL_API_COMPLETE_SVC_CONFIG        = "Complete service configuration for %v/%v."
 	EL_API_COMPLETE_SVC_AUTO_CONFIG   = "Complete service auto configuration for %v/%v."
+	EL_API_SVC_UPDATED                = "Updated service %v/%v from version %v to version %v, the version the pattern requires."
 	EL_API_ERR_MISS_VAR_IN_SVC_CONFIG = "Variable %v is missing in the service configuration for %v/%v. It may prevent an agreement if the deployment policy does not contain the setting for the missing variable."
 
 	// from api_service.go
@@ -105,13 +151,20 @@ func MarkI18nMessages() {
 	msgPrinter.Sprintf(EL_API_ERR_IN_NODE_UI_PATCH)
 	msgPrinter.Sprintf(EL_API_ERR_IN_NODE_UI_DEL)
 
+	msgPrinter.Sprintf(EL_API_MAINTENANCE_WINDOW_OPENED)
+	msgPrinter.Sprintf(EL_API_MAINTENANCE_WINDOW_CLOSED)
+
 	// from path_node.go
 	msgPrinter.Sprintf(EL_API_START_NODE_REG)
 	msgPrinter.Sprintf(EL_API_START_NODE_UPDATE)
 	msgPrinter.Sprintf(EL_API_COMPLETE_NODE_UPDATE)
+	msgPrinter.Sprintf(EL_API_NODE_TOKEN_ROTATED)
 	msgPrinter.Sprintf(EL_API_START_NODE_UNREG)
 	msgPrinter.Sprintf(EL_API_COMPLETE_NODE_UNREG)
 
+	msgPrinter.Sprintf(EL_API_NODE_PATTERN_CHANGED)
+	msgPrinter.Sprintf(EL_API_ERR_NODE_PATTERN_SVC_REMOVAL)
+
 	msgPrinter.Sprintf(EL_API_ERR_NODE_UNREG_NOT_FOUND)
 	msgPrinter.Sprintf(EL_API_ERR_NODE_UNREG_NOT_IN_STATE)
 	msgPrinter.Sprintf(EL_API_ERR_NODE_UNREG_WRONG_VALUE_FOR_RN)
@@ -125,13 +178,51 @@ func MarkI18nMessages() {
 	msgPrinter.Sprintf(EL_API_ERR_NODE_CONF_NOT_FOUND)
 	msgPrinter.Sprintf(EL_API_ERR_NODE_CONF_WRONG_STATE)
 	msgPrinter.Sprintf(EL_API_UNSUP_NODE_STATE_TRANS)
+	msgPrinter.Sprintf(EL_API_ERR_NODE_CONF_DISK_SPACE)
+	msgPrinter.Sprintf(EL_API_ERR_NODE_CONF_CLOCK_SKEW)
+	msgPrinter.Sprintf(EL_API_ERR_NODE_CONF_CONNECTIVITY)
+	msgPrinter.Sprintf(EL_API_ERR_NODE_CONF_OFFLINE)
+	msgPrinter.Sprintf(EL_API_NODE_CONF_OFFLINE)
+	msgPrinter.Sprintf(EL_API_NODE_REG_OFFLINE)
+	msgPrinter.Sprintf(EL_API_ERR_NODE_CONF_VERSIONS)
 	msgPrinter.Sprintf(EL_API_FAIL_GET_UI_FROM_DB)
 	msgPrinter.Sprintf(EL_API_FAIL_FIND_SVC_PREF_FROM_UI)
 	msgPrinter.Sprintf(EL_API_ERR_SAVE_NODE_CONFSTATE)
 	msgPrinter.Sprintf(EL_API_COMPLETE_NODE_REG)
+	msgPrinter.Sprintf(EL_API_NODE_CONF_PENDING)
+	msgPrinter.Sprintf(EL_API_ERR_NODE_CONF_EFFECTIVE_TIME)
+	msgPrinter.Sprintf(EL_API_ERR_NODE_CONF_EXCLUSIONS)
+	msgPrinter.Sprintf(EL_API_ERR_NODE_CONF_CHANNEL)
+	msgPrinter.Sprintf(EL_API_ERR_NODE_CONF_CANCELLED)
+	msgPrinter.Sprintf(EL_API_NODE_AUTOCONFIG_EXCLUDED)
+	msgPrinter.Sprintf(EL_API_ERR_NODE_CONF_OPTIONAL)
+	msgPrinter.Sprintf(EL_API_NODE_AUTOCONFIG_OPTIONAL)
 	msgPrinter.Sprintf(EL_API_ERR_SVC_CONF)
 	msgPrinter.Sprintf(EL_API_ERR_GET_SREFS_FOR_PATTERN)
+	msgPrinter.Sprintf(EL_API_ERR_NODE_AUTOCONFIG)
+	msgPrinter.Sprintf(EL_API_NODE_AUTOCONFIG_ROLLBACK)
+	msgPrinter.Sprintf(EL_API_ERR_NODE_AUTOCONFIG_ROLLBACK)
 	msgPrinter.Sprintf(EL_API_IGNORE_TYPE_MISMATCH)
+	msgPrinter.Sprintf(EL_API_START_NODE_UNCONFIG)
+	msgPrinter.Sprintf(EL_API_COMPLETE_NODE_UNCONFIG)
+	msgPrinter.Sprintf(EL_API_ERR_NODE_UNCONFIG)
+
+	// from firstboot.go
+	msgPrinter.Sprintf(EL_API_START_PROVISIONING)
+	msgPrinter.Sprintf(EL_API_COMPLETE_PROVISIONING)
+	msgPrinter.Sprintf(EL_API_ERR_PROVISIONING)
+
+	// from path_node_export.go
+	msgPrinter.Sprintf(EL_API_START_NODE_IMPORT)
+	msgPrinter.Sprintf(EL_API_COMPLETE_NODE_IMPORT)
+	msgPrinter.Sprintf(EL_API_ERR_NODE_IMPORT)
+
+	// from reconcile.go
+	msgPrinter.Sprintf(EL_API_SVC_MISSING)
+	msgPrinter.Sprintf(EL_API_SVC_SUPERFLUOUS)
+	msgPrinter.Sprintf(EL_API_SVC_RECONCILE_CREATED)
+	msgPrinter.Sprintf(EL_API_ERR_SVC_RECONCILE)
+	msgPrinter.Sprintf(EL_API_SVC_RECONCILE_COMPLETE)
 
 	// from path_node_policy.go
 	msgPrinter.Sprintf(EL_API_NEW_NODE_POL)
@@ -147,6 +238,7 @@ func MarkI18nMessages() {
 	msgPrinter.Sprintf(EL_API_START_SVC_AUTO_CONFIG)
 	msgPrinter.Sprintf(EL_API_COMPLETE_SVC_CONFIG)
 	msgPrinter.Sprintf(EL_API_COMPLETE_SVC_AUTO_CONFIG)
+	msgPrinter.Sprintf(EL_API_SVC_UPDATED)
 	msgPrinter.Sprintf(EL_API_ERR_MISS_VAR_IN_SVC_CONFIG)
 
 	// from api_service.go
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	"github.com/open-horizon/anax/metrics"
+	"github.com/open-horizon/anax/persistence"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The metrics of the API requests, by the path template of their route, e.g. /agreement/{id}.
+var apiRequests = metrics.NewCounterVec("anax_api_requests_total",
+	"The agent API requests, by path, method and status.", "path", "method", "status")
+
+var apiRequestDuration = metrics.NewHistogramVec("anax_api_request_duration_seconds",
+	"The duration of the agent API requests, by path and method.", metrics.DefaultDurationBuckets, "path", "method")
+
+var apiErrors = metrics.NewCounterVec("anax_api_errors_total",
+	"The agent API requests that failed, by path and the category of their error, as the categories of GET /node/configstate.",
+	"path", "category")
+
+// The metrics of the autoconfig of the node's pattern, when the node is changed to configured.
+var autoconfigDuration = metrics.NewHistogramVec("anax_autoconfig_duration_seconds",
+	"The duration of the autoconfig of the services of the node's pattern, by result.",
+	[]float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}, "result")
+
+var autoconfigServicesCreated = metrics.NewHistogramVec("anax_autoconfig_services_created",
+	"The number of services created by each autoconfig of the services of the node's pattern, by result.",
+	[]float64{0, 1, 2, 5, 10, 20, 50, 100}, "result")
+
+// The path label of the requests that do not match any route.
+const unmatchedPath = "unmatched"
+
+// A response writer that keeps the status of the response and the category of its error, for the request metrics.
+type metricsWriter struct {
+	http.ResponseWriter
+	status   int
+	category string
+}
+
+func (m *metricsWriter) WriteHeader(status int) {
+	if m.status == 0 {
+		m.status = status
+	}
+	m.ResponseWriter.WriteHeader(status)
+}
+
+func (m *metricsWriter) Write(b []byte) (int, error) {
+	if m.status == 0 {
+		m.status = http.StatusOK
+	}
+	return m.ResponseWriter.Write(b)
+}
+
+func (m *metricsWriter) Flush() {
+	if f, ok := m.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Record the count, the duration and the error category of each request served by h, by the path template of the route
+// of the router that the request matches.
+func recordRequestMetrics(router *mux.Router, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := unmatchedPath
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.Route != nil {
+			if tmpl, err := match.Route.GetPathTemplate(); err == nil {
+				path = tmpl
+			}
+		}
+
+		start := time.Now()
+		mw := &metricsWriter{ResponseWriter: w}
+		h.ServeHTTP(mw, r)
+
+		if mw.status == 0 {
+			mw.status = http.StatusOK
+		}
+		apiRequests.Inc(path, r.Method, strconv.Itoa(mw.status))
+		apiRequestDuration.ObserveSince(start, path, r.Method)
+		if mw.status >= http.StatusBadRequest {
+			category := mw.category
+			if category == "" {
+				category = statusErrorCategory(mw.status)
+			}
+			apiErrors.Inc(path, category)
+		}
+	})
+}
+
+// Keep the error of a request for its metrics and its audit entry, w is the response writer of the request.
+func recordRequestError(w http.ResponseWriter, err error) {
+	for ; w != nil; w = innerResponseWriter(w) {
+		switch rw := w.(type) {
+		case *metricsWriter:
+			rw.category = configstateFailureCategory(err)
+		case *auditWriter:
+			rw.err = err
+		case *limitWriter:
+			rw.result.err = err
+		}
+	}
+}
+
+// Returns the response writer that w wraps, nil when w is not one of the writers of the API.
+func innerResponseWriter(w http.ResponseWriter) http.ResponseWriter {
+	switch rw := w.(type) {
+	case *metricsWriter:
+		return rw.ResponseWriter
+	case *auditWriter:
+		return rw.ResponseWriter
+	case *timezoneWriter:
+		return rw.ResponseWriter
+	case *limitWriter:
+		return rw.ResponseWriter
+	case *operationWriter:
+		return rw.ResponseWriter
+	case *errorFormatWriter:
+		return rw.ResponseWriter
+	default:
+		return nil
+	}
+}
+
+// The category of the error of a request that failed without going through an error handler, from its status.
+func statusErrorCategory(status int) string {
+	switch status {
+	case http.StatusNotFound:
+		return persistence.CONFIGSTATE_FAILURE_NOT_FOUND
+	case http.StatusServiceUnavailable:
+		return persistence.CONFIGSTATE_FAILURE_UNAVAILABLE
+	}
+	if status < http.StatusInternalServerError {
+		return persistence.CONFIGSTATE_FAILURE_INPUT
+	}
+	return persistence.CONFIGSTATE_FAILURE_SYSTEM
+}
+
+// Record the duration of an autoconfig that started at start, and the number of services it created.
+func recordAutoconfig(start time.Time, succeeded bool, servicesCreated int) {
+	result := "success"
+	if !succeeded {
+		result = "failure"
+	}
+	autoconfigDuration.ObserveSince(start, result)
+	autoconfigServicesCreated.Observe(float64(servicesCreated), result)
+}
+
+// Serve the metrics of the agent in the Prometheus text format.
+func (a *API) agentmetrics(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		if err := metrics.Default.WriteText(w); err != nil {
+			glog.Errorf(apiLogString(fmt.Sprintf("unable to write the metrics, error %v", err)))
+		}
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
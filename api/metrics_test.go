@@ -0,0 +1,79 @@
+// +build unit
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/open-horizon/anax/metrics"
+	"github.com/open-horizon/anax/persistence"
+)
+
+func Test_recordRequestMetrics(t *testing.T) {
+	metrics.Enable(true)
+	defer metrics.Enable(false)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/agreement/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["id"] == "missing" {
+			GetHTTPErrorHandler(w)(NewNotFoundError("agreement missing not found", "id"))
+			return
+		}
+		writeResponse(w, map[string]string{"id": mux.Vars(r)["id"]}, http.StatusOK)
+	}).Methods("GET")
+
+	// the timezone writer is between the metrics writer and the handler, as in the listeners
+	handler := recordRequestMetrics(router, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(&timezoneWriter{ResponseWriter: w, loc: time.UTC}, r)
+	}))
+
+	okBefore := apiRequests.Value("/agreement/{id}", "GET", "200")
+	notFoundBefore := apiRequests.Value("/agreement/{id}", "GET", "404")
+	errorsBefore := apiErrors.Value("/agreement/{id}", persistence.CONFIGSTATE_FAILURE_NOT_FOUND)
+	unmatchedBefore := apiRequests.Value(unmatchedPath, "GET", "404")
+
+	for _, path := range []string{"/agreement/a1", "/agreement/a2", "/agreement/missing", "/nothing"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	if v := apiRequests.Value("/agreement/{id}", "GET", "200") - okBefore; v != 2 {
+		t.Errorf("there should be 2 successful requests on the route template, got %v", v)
+	} else if v := apiRequests.Value("/agreement/{id}", "GET", "404") - notFoundBefore; v != 1 {
+		t.Errorf("there should be 1 not found request on the route template, got %v", v)
+	} else if v := apiErrors.Value("/agreement/{id}", persistence.CONFIGSTATE_FAILURE_NOT_FOUND) - errorsBefore; v != 1 {
+		t.Errorf("there should be 1 not_found error through the timezone writer, got %v", v)
+	} else if v := apiRequests.Value(unmatchedPath, "GET", "404") - unmatchedBefore; v != 1 {
+		t.Errorf("there should be 1 unmatched request, got %v", v)
+	}
+}
+
+func Test_agentmetrics(t *testing.T) {
+	metrics.Enable(true)
+	defer metrics.Enable(false)
+
+	recordAutoconfig(time.Now().Add(-3*time.Second), true, 4)
+
+	w := httptest.NewRecorder()
+	(&API{}).agentmetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %v", w.Code)
+	}
+	body := w.Body.String()
+	for _, expected := range []string{
+		"# TYPE anax_autoconfig_duration_seconds histogram",
+		`anax_autoconfig_services_created_bucket{result="success",le="5"}`,
+		"# TYPE anax_api_requests_total counter",
+		"# TYPE anax_exchange_handler_calls_total counter",
+		"# TYPE anax_db_transaction_duration_seconds histogram",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("the metrics should contain %v, got\n%v", expected, body)
+		}
+	}
+}
@@ -14,6 +14,10 @@ type MicroserviceConfig struct {
 	AutoUpgrade   bool                    `json:"auto_upgrade"`   // added for ms split. The default is true. If the sensor (microservice) should be automatically upgraded when new versions become available.
 	ActiveUpgrade bool                    `json:"active_upgrade"` // added for ms split. The default is false. If horizon should actively terminate agreements when new versions become available (active) or wait for all the associated agreements terminated before making upgrade.
 	Attributes    []persistence.Attribute `json:"attributes"`
+
+	VariableSources map[string]string `json:"variable_sources,omitempty"` // The user input layer (pattern, node or service_config) that set each variable.
+	Origin          string            `json:"origin,omitempty"`           // How the service came to exist: autoconfig, user or import.
+	OriginPattern   string            `json:"origin_pattern,omitempty"`   // The pattern that the autoconfig configured the service from.
 }
 
 type APIMicroserviceConfig struct {
@@ -23,6 +27,8 @@ type APIMicroserviceConfig struct {
 	AutoUpgrade   bool          `json:"auto_upgrade"`   // added for ms split. The default is true. If the sensor (microservice) should be automatically upgraded when new versions become available.
 	ActiveUpgrade bool          `json:"active_upgrade"` // added for ms split. The default is false. If horizon should actively terminate agreements when new versions become available (active) or wait for all the associated agreements terminated before making upgrade.
 	Attributes    []interface{} `json:"attributes"`
+
+	VariableSources map[string]string `json:"variable_sources,omitempty"` // The user input layer (pattern, node or service_config) that set each variable.
 }
 
 func NewMicroserviceConfig(url string, org string, version string) *MicroserviceConfig {
@@ -86,6 +92,100 @@ func NewAgreementServiceInstanceOutput(ag *persistence.EstablishedAgreement, con
 	}
 }
 
+// The output format for GET service/instance, the status of a service instance joined from the node's database and
+// the container runtime.
+type ServiceInstanceStatus struct {
+	Key                  string                                   `json:"key"`
+	URL                  string                                   `json:"url"`
+	Org                  string                                   `json:"organization"`
+	Version              string                                   `json:"version"`
+	Arch                 string                                   `json:"arch"`
+	InstanceId           string                                   `json:"instance_id"`
+	DefinitionId         string                                   `json:"definition_id"`      // The record id of the service definition the instance was registered from.
+	Sharable             string                                   `json:"sharable,omitempty"` // The sharing mode of the service definition, e.g. singleton.
+	Shared               bool                                     `json:"shared"`             // Whether the instance serves more than one agreement or parent service.
+	AgreementLess        bool                                     `json:"agreement_less"`     // Whether the instance runs without an agreement, as defined in the pattern.
+	Agreements           []string                                 `json:"agreements"`         // The ids of the agreements that depend on the instance.
+	Parents              []persistence.ServiceInstancePathElement `json:"parents"`            // The services that directly depend on the instance.
+	Containers           []ServiceContainerStatus                 `json:"containers"`
+	ContainerError       string                                   `json:"container_error,omitempty"` // Why the containers could not be read from the container runtime.
+	Health               []persistence.ContainerHealth            `json:"health,omitempty"`
+	InstanceCreationTime uint64                                   `json:"instance_creation_time"`
+	ExecutionStartTime   uint64                                   `json:"execution_start_time"`
+	ExecutionFailureCode uint                                     `json:"execution_failure_code"`
+	ExecutionFailureDesc string                                   `json:"execution_failure_desc"`
+	RestartPolicy        string                                   `json:"restart_policy,omitempty"`
+	CurrentRetryCount    uint                                     `json:"current_retry_count"`
+	MaxRetries           uint                                     `json:"max_retries"`
+	RetryStartTime       uint64                                   `json:"retry_start_time"`
+	RetryBackoffS        uint                                     `json:"retry_backoff_s"`
+	NextRetryTime        uint64                                   `json:"next_retry_time"`
+	Archived             bool                                     `json:"archived"`
+	CleanupPending       bool                                     `json:"cleanup_pending"` // The cleanup of the instance has started but is not done.
+	CleanupStartTime     uint64                                   `json:"cleanup_start_time"`
+}
+
+// The runtime state of a container of a service instance.
+type ServiceContainerStatus struct {
+	Id     string   `json:"id"`
+	Names  []string `json:"names"`
+	Image  string   `json:"image"`
+	State  string   `json:"state"`  // e.g. running or exited
+	Status string   `json:"status"` // e.g. Up 2 hours
+}
+
+// Join a service instance with its service definition, its containers and their health. The definition is nil when
+// it is not in the database anymore.
+func NewServiceInstanceStatus(msinst *persistence.MicroserviceInstance, msdef *persistence.MicroserviceDefinition, containers []dockerclient.APIContainers, health []persistence.ContainerHealth) *ServiceInstanceStatus {
+	key := msinst.GetKey()
+
+	status := &ServiceInstanceStatus{
+		Key:                  key,
+		URL:                  msinst.SpecRef,
+		Org:                  msinst.Org,
+		Version:              msinst.Version,
+		Arch:                 msinst.Arch,
+		InstanceId:           msinst.InstanceId,
+		DefinitionId:         msinst.MicroserviceDefId,
+		AgreementLess:        msinst.AgreementLess,
+		Agreements:           make([]string, 0, len(msinst.AssociatedAgreements)),
+		Parents:              msinst.GetDirectParents(),
+		Containers:           make([]ServiceContainerStatus, 0, len(containers)),
+		InstanceCreationTime: msinst.InstanceCreationTime,
+		ExecutionStartTime:   msinst.ExecutionStartTime,
+		ExecutionFailureCode: msinst.ExecutionFailureCode,
+		ExecutionFailureDesc: msinst.ExecutionFailureDesc,
+		RestartPolicy:        msinst.RestartPolicy,
+		CurrentRetryCount:    msinst.CurrentRetryCount,
+		MaxRetries:           msinst.MaxRetries,
+		RetryStartTime:       msinst.RetryStartTime,
+		RetryBackoffS:        msinst.RetryBackoffS,
+		NextRetryTime:        msinst.NextRetryTime,
+		Archived:             msinst.Archived,
+		CleanupPending:       !msinst.Archived && msinst.CleanupStartTime != 0,
+		CleanupStartTime:     msinst.CleanupStartTime,
+	}
+
+	status.Agreements = append(status.Agreements, msinst.AssociatedAgreements...)
+	status.Shared = len(status.Agreements) > 1 || len(status.Parents) > 1
+
+	if msdef != nil {
+		status.Sharable = msdef.Sharable
+	}
+
+	for _, c := range containers {
+		status.Containers = append(status.Containers, ServiceContainerStatus{Id: c.ID, Names: c.Names, Image: c.Image, State: c.State, Status: c.Status})
+	}
+
+	for _, h := range health {
+		if h.Owner == key {
+			status.Health = append(status.Health, h)
+		}
+	}
+
+	return status
+}
+
 // The output format for GET workload
 type AllWorkloads struct {
 	Containers *[]dockerclient.APIContainers `json:"containers"` // the docker info for a running container
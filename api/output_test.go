@@ -3,6 +3,7 @@
 package api
 
 import (
+	dockerclient "github.com/fsouza/go-dockerclient"
 	"github.com/open-horizon/anax/persistence"
 	"sort"
 	"testing"
@@ -73,3 +74,57 @@ func Test_EstablishedAgreementsByTerminatedTime(t *testing.T) {
 		t.Errorf("Unexpected sorted state %v", tTime)
 	}
 }
+
+func Test_NewServiceInstanceStatus(t *testing.T) {
+
+	parent1 := persistence.ServiceInstancePathElement{URL: "http://mydomain.com/location", Org: "myorg", Version: "2.0.6"}
+	parent2 := persistence.ServiceInstancePathElement{URL: "http://mydomain.com/weather", Org: "myorg", Version: "1.0.0"}
+	self := persistence.ServiceInstancePathElement{URL: "http://mydomain.com/gps", Org: "myorg", Version: "2.0.3"}
+
+	msinst := persistence.MicroserviceInstance{
+		SpecRef:              "http://mydomain.com/gps",
+		Org:                  "myorg",
+		Version:              "2.0.3",
+		InstanceId:           "e2b6",
+		MicroserviceDefId:    "3",
+		AssociatedAgreements: []string{"ag1", "ag2"},
+		ParentPath:           [][]persistence.ServiceInstancePathElement{{parent1, self}, {parent2, self}},
+		CurrentRetryCount:    1,
+		NextRetryTime:        1600000000,
+		CleanupStartTime:     1600000100,
+	}
+	msdef := &persistence.MicroserviceDefinition{Id: "3", Sharable: "singleton"}
+	containers := []dockerclient.APIContainers{{ID: "c1", Names: []string{"/gps"}, Image: "gps:2.0.3", State: "running", Status: "Up 2 hours"}}
+	health := []persistence.ContainerHealth{
+		{ContainerName: "gps", Owner: msinst.GetKey(), Status: persistence.CONTAINER_UNHEALTHY},
+		{ContainerName: "other", Owner: "ag3", Status: persistence.CONTAINER_HEALTHY},
+	}
+
+	status := NewServiceInstanceStatus(&msinst, msdef, containers, health)
+
+	if status.Key != msinst.GetKey() || status.DefinitionId != "3" || status.Sharable != "singleton" {
+		t.Errorf("wrong service instance identity %v", status)
+	} else if !status.Shared || len(status.Agreements) != 2 || len(status.Parents) != 2 {
+		t.Errorf("the service instance should be shared by 2 agreements and 2 parents: %v", status)
+	} else if len(status.Containers) != 1 || status.Containers[0].Id != "c1" || status.Containers[0].State != "running" {
+		t.Errorf("wrong containers %v", status.Containers)
+	} else if len(status.Health) != 1 || status.Health[0].ContainerName != "gps" {
+		t.Errorf("only the health of the containers of the instance should be joined: %v", status.Health)
+	} else if status.CurrentRetryCount != 1 || status.NextRetryTime != 1600000000 {
+		t.Errorf("wrong restart counters %v", status)
+	} else if !status.CleanupPending {
+		t.Errorf("the cleanup of the service instance should be pending")
+	}
+
+	// an archived instance whose definition is gone, without containers
+	msinst.Archived = true
+	msinst.AssociatedAgreements = nil
+	msinst.ParentPath = [][]persistence.ServiceInstancePathElement{{parent1, self}}
+	status = NewServiceInstanceStatus(&msinst, nil, nil, nil)
+
+	if status.Shared || status.CleanupPending || status.Sharable != "" {
+		t.Errorf("wrong archived service instance %v", status)
+	} else if status.Agreements == nil || status.Containers == nil {
+		t.Errorf("the agreements and containers should be empty lists, not null: %v", status)
+	}
+}
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,6 +9,8 @@ import (
 
 	"github.com/boltdb/bolt"
 	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/containermessage"
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/policy"
 )
@@ -249,6 +250,243 @@ func parseMetering(errorhandler ErrorHandler, permitEmpty bool, given *Attribute
 	}, false, nil
 }
 
+func parseRestartPolicy(errorhandler ErrorHandler, permitEmpty bool, given *Attribute) (*persistence.RestartPolicyAttributes, bool, error) {
+	if permitEmpty {
+		return nil, errorhandler(NewAPIUserInputError("partial update unsupported", "restartpolicy.mappings")), nil
+	}
+
+	var policy string
+	p, exists := (*given.Mappings)["policy"]
+	if !exists {
+		return nil, errorhandler(NewAPIUserInputError("missing key", "restartpolicy.mappings.policy")), nil
+	} else if policy, exists = p.(string); !exists {
+		return nil, errorhandler(NewAPIUserInputError("expected string", "restartpolicy.mappings.policy")), nil
+	} else if !config.IsValidServiceRestartPolicy(policy) {
+		return nil, errorhandler(NewAPIUserInputError(fmt.Sprintf("must be %v, %v or %v", config.SERVICE_RESTART_POLICY_NO, config.SERVICE_RESTART_POLICY_ON_FAILURE, config.SERVICE_RESTART_POLICY_ALWAYS), "restartpolicy.mappings.policy")), nil
+	}
+
+	var maxRetries int64
+	if m, exists := (*given.Mappings)["maxRetries"]; exists {
+		var err error
+		if _, ok := m.(json.Number); !ok {
+			return nil, errorhandler(NewAPIUserInputError("expected integer", "restartpolicy.mappings.maxRetries")), nil
+		} else if maxRetries, err = m.(json.Number).Int64(); err != nil {
+			return nil, errorhandler(NewAPIUserInputError("could not convert to integer", "restartpolicy.mappings.maxRetries")), nil
+		} else if maxRetries < 0 {
+			return nil, errorhandler(NewAPIUserInputError("must not be negative", "restartpolicy.mappings.maxRetries")), nil
+		} else if maxRetries != 0 && policy != config.SERVICE_RESTART_POLICY_ON_FAILURE {
+			return nil, errorhandler(NewAPIUserInputError(fmt.Sprintf("only supported with the %v policy", config.SERVICE_RESTART_POLICY_ON_FAILURE), "restartpolicy.mappings.maxRetries")), nil
+		}
+	}
+
+	sps := new(persistence.ServiceSpecs)
+	if given.ServiceSpecs != nil {
+		sps = given.ServiceSpecs
+	}
+
+	return &persistence.RestartPolicyAttributes{
+		Meta:         generateAttributeMetadata(*given, reflect.TypeOf(persistence.RestartPolicyAttributes{}).Name()),
+		ServiceSpecs: sps,
+		Policy:       policy,
+		MaxRetries:   uint(maxRetries),
+	}, false, nil
+}
+
+func parseHealthCheck(errorhandler ErrorHandler, permitEmpty bool, given *Attribute) (*persistence.HealthCheckAttributes, bool, error) {
+	if permitEmpty {
+		return nil, errorhandler(NewAPIUserInputError("partial update unsupported", "healthcheck.mappings")), nil
+	}
+
+	var hc containermessage.HealthCheck
+
+	if e, exists := (*given.Mappings)["exec"]; exists {
+		if cmd, ok := e.([]interface{}); !ok {
+			return nil, errorhandler(NewAPIUserInputError(fmt.Sprintf("expected []string received %T", e), "healthcheck.mappings.exec")), nil
+		} else {
+			for _, arg := range cmd {
+				if s, ok := arg.(string); !ok {
+					return nil, errorhandler(NewAPIUserInputError(fmt.Sprintf("array value is not a string, it is %T", arg), "healthcheck.mappings.exec")), nil
+				} else {
+					hc.Exec = append(hc.Exec, s)
+				}
+			}
+		}
+	}
+
+	// The integer settings of the health check, by mapping name.
+	ints := map[string]*int{
+		"tcpPort":          &hc.TCPPort,
+		"httpPort":         &hc.HTTPPort,
+		"interval":         &hc.IntervalS,
+		"timeout":          &hc.TimeoutS,
+		"failureThreshold": &hc.FailureThreshold,
+	}
+	for name, field := range ints {
+		if v, exists := (*given.Mappings)[name]; exists {
+			if n, ok := v.(json.Number); !ok {
+				return nil, errorhandler(NewAPIUserInputError("expected integer", "healthcheck.mappings."+name)), nil
+			} else if i, err := n.Int64(); err != nil {
+				return nil, errorhandler(NewAPIUserInputError("could not convert to integer", "healthcheck.mappings."+name)), nil
+			} else {
+				*field = int(i)
+			}
+		}
+	}
+
+	strs := map[string]*string{
+		"httpPath": &hc.HTTPPath,
+		"action":   &hc.Action,
+	}
+	for name, field := range strs {
+		if v, exists := (*given.Mappings)[name]; exists {
+			if s, ok := v.(string); !ok {
+				return nil, errorhandler(NewAPIUserInputError("expected string", "healthcheck.mappings."+name)), nil
+			} else {
+				*field = s
+			}
+		}
+	}
+
+	if err := hc.Validate(); err != nil {
+		return nil, errorhandler(NewAPIUserInputError(err.Error(), "healthcheck.mappings")), nil
+	}
+
+	sps := new(persistence.ServiceSpecs)
+	if given.ServiceSpecs != nil {
+		sps = given.ServiceSpecs
+	}
+
+	return &persistence.HealthCheckAttributes{
+		Meta:         generateAttributeMetadata(*given, reflect.TypeOf(persistence.HealthCheckAttributes{}).Name()),
+		ServiceSpecs: sps,
+		HealthCheck:  hc,
+	}, false, nil
+}
+
+func parseCPUPinning(errorhandler ErrorHandler, permitEmpty bool, given *Attribute) (*persistence.CPUPinningAttributes, bool, error) {
+	if permitEmpty {
+		return nil, errorhandler(NewAPIUserInputError("partial update unsupported", "cpupinning.mappings")), nil
+	}
+
+	var cp containermessage.CPUPinning
+
+	if v, exists := (*given.Mappings)["cpuset"]; !exists {
+		return nil, errorhandler(NewAPIUserInputError("missing key", "cpupinning.mappings.cpuset")), nil
+	} else if s, ok := v.(string); !ok {
+		return nil, errorhandler(NewAPIUserInputError("expected string", "cpupinning.mappings.cpuset")), nil
+	} else if _, err := containermessage.ParseCPUSet(s); err != nil {
+		return nil, errorhandler(NewAPIUserInputError(err.Error(), "cpupinning.mappings.cpuset")), nil
+	} else {
+		cp.CPUSet = s
+	}
+
+	if v, exists := (*given.Mappings)["cpuRealtimeRuntime"]; exists {
+		if n, ok := v.(json.Number); !ok {
+			return nil, errorhandler(NewAPIUserInputError("expected integer", "cpupinning.mappings.cpuRealtimeRuntime")), nil
+		} else if i, err := n.Int64(); err != nil || i < 0 {
+			return nil, errorhandler(NewAPIUserInputError("could not convert to a non-negative integer", "cpupinning.mappings.cpuRealtimeRuntime")), nil
+		} else {
+			cp.CPURealtimeRuntime = i
+		}
+	}
+
+	sps := new(persistence.ServiceSpecs)
+	if given.ServiceSpecs != nil {
+		sps = given.ServiceSpecs
+	}
+
+	return &persistence.CPUPinningAttributes{
+		Meta:         generateAttributeMetadata(*given, reflect.TypeOf(persistence.CPUPinningAttributes{}).Name()),
+		ServiceSpecs: sps,
+		CPUPinning:   cp,
+	}, false, nil
+}
+
+func parseNetwork(errorhandler ErrorHandler, permitEmpty bool, given *Attribute) (*persistence.NetworkAttributes, bool, error) {
+	if permitEmpty {
+		return nil, errorhandler(NewAPIUserInputError("partial update unsupported", "network.mappings")), nil
+	}
+
+	na := &persistence.NetworkAttributes{
+		Meta:         generateAttributeMetadata(*given, reflect.TypeOf(persistence.NetworkAttributes{}).Name()),
+		ServiceSpecs: new(persistence.ServiceSpecs),
+	}
+	if given.ServiceSpecs != nil {
+		na.ServiceSpecs = given.ServiceSpecs
+	}
+
+	for key, v := range *given.Mappings {
+		switch key {
+		case "subnetPool":
+			if s, ok := v.(string); !ok {
+				return nil, errorhandler(NewAPIUserInputError("expected string", "network.mappings.subnetPool")), nil
+			} else {
+				na.SubnetPool = s
+			}
+		case "subnetPrefixLen", "mtu":
+			if n, ok := v.(json.Number); !ok {
+				return nil, errorhandler(NewAPIUserInputError("expected integer", "network.mappings."+key)), nil
+			} else if i, err := n.Int64(); err != nil || i <= 0 {
+				return nil, errorhandler(NewAPIUserInputError("could not convert to a positive integer", "network.mappings."+key)), nil
+			} else if key == "mtu" {
+				na.MTU = int(i)
+			} else {
+				na.SubnetPrefixLen = int(i)
+			}
+		case "icc", "ipv6":
+			if b, ok := v.(bool); !ok {
+				return nil, errorhandler(NewAPIUserInputError("expected bool", "network.mappings."+key)), nil
+			} else if key == "icc" {
+				na.ICC = &b
+			} else {
+				na.IPv6 = &b
+			}
+		default:
+			return nil, errorhandler(NewAPIUserInputError("unknown key", "network.mappings."+key)), nil
+		}
+	}
+
+	if len(na.GetGenericMappings()) == 0 {
+		return nil, errorhandler(NewAPIUserInputError("at least one of subnetPool, subnetPrefixLen, mtu, icc and ipv6 must be set", "network.mappings")), nil
+	} else if na.SubnetPrefixLen > 32 {
+		return nil, errorhandler(NewAPIUserInputError("must not be more than 32", "network.mappings.subnetPrefixLen")), nil
+	}
+
+	// the subnet pool is checked as the one of the node's network configuration
+	netConfig := config.NetworkConfig{SubnetPool: na.SubnetPool, SubnetPrefixLen: na.SubnetPrefixLen}
+	if err := netConfig.Validate(); err != nil {
+		return nil, errorhandler(NewAPIUserInputError(err.Error(), "network.mappings.subnetPool")), nil
+	}
+
+	return na, false, nil
+}
+
+func parseAutoReconcile(errorhandler ErrorHandler, permitEmpty bool, given *Attribute) (*persistence.AutoReconcileAttributes, bool, error) {
+	if permitEmpty {
+		return nil, errorhandler(NewAPIUserInputError("partial update unsupported", "autoreconcile.mappings")), nil
+	}
+
+	// It applies to the node, the services it creates are the ones of the pattern.
+	if given.ServiceSpecs != nil && len(*given.ServiceSpecs) != 0 {
+		return nil, errorhandler(NewAPIUserInputError("the attribute applies to the node, it cannot be given service specs", "autoreconcile.service_specs")), nil
+	}
+
+	var autoReconcile bool
+	if v, exists := (*given.Mappings)["auto_reconcile"]; !exists {
+		return nil, errorhandler(NewAPIUserInputError("missing key", "autoreconcile.mappings.auto_reconcile")), nil
+	} else if b, ok := v.(bool); !ok {
+		return nil, errorhandler(NewAPIUserInputError("expected bool", "autoreconcile.mappings.auto_reconcile")), nil
+	} else {
+		autoReconcile = b
+	}
+
+	return &persistence.AutoReconcileAttributes{
+		Meta:          generateAttributeMetadata(*given, reflect.TypeOf(persistence.AutoReconcileAttributes{}).Name()),
+		ServiceSpecs:  new(persistence.ServiceSpecs),
+		AutoReconcile: autoReconcile,
+	}, false, nil
+}
+
 func parseAgreementProtocol(errorhandler ErrorHandler, permitEmpty bool, given *Attribute) (*persistence.AgreementProtocolAttributes, bool, error) {
 	if permitEmpty {
 		return nil, errorhandler(NewAPIUserInputError("partial update unsupported", "agreementprotocol.mappings")), nil
@@ -430,6 +668,41 @@ func ValidateAndConvertAPIAttribute(errorhandler ErrorHandler, permitEmpty bool,
 			}
 			attribute = attr
 
+		case reflect.TypeOf(persistence.RestartPolicyAttributes{}).Name():
+			attr, inputErr, err := parseRestartPolicy(errorhandler, permitEmpty, &given)
+			if err != nil || inputErr {
+				return nil, inputErr, err
+			}
+			attribute = attr
+
+		case reflect.TypeOf(persistence.HealthCheckAttributes{}).Name():
+			attr, inputErr, err := parseHealthCheck(errorhandler, permitEmpty, &given)
+			if err != nil || inputErr {
+				return nil, inputErr, err
+			}
+			attribute = attr
+
+		case reflect.TypeOf(persistence.CPUPinningAttributes{}).Name():
+			attr, inputErr, err := parseCPUPinning(errorhandler, permitEmpty, &given)
+			if err != nil || inputErr {
+				return nil, inputErr, err
+			}
+			attribute = attr
+
+		case reflect.TypeOf(persistence.NetworkAttributes{}).Name():
+			attr, inputErr, err := parseNetwork(errorhandler, permitEmpty, &given)
+			if err != nil || inputErr {
+				return nil, inputErr, err
+			}
+			attribute = attr
+
+		case reflect.TypeOf(persistence.AutoReconcileAttributes{}).Name():
+			attr, inputErr, err := parseAutoReconcile(errorhandler, permitEmpty, &given)
+			if err != nil || inputErr {
+				return nil, inputErr, err
+			}
+			attribute = attr
+
 		case reflect.TypeOf(persistence.AgreementProtocolAttributes{}).Name():
 			attr, inputErr, err := parseAgreementProtocol(errorhandler, permitEmpty, &given)
 			if err != nil || inputErr {
@@ -550,12 +823,10 @@ func payloadToAttributes(errorhandler ErrorHandler, body io.Reader, permitPartia
 	if err != nil {
 		return nil, false, fmt.Errorf("Failed to read request bytes: %v", err)
 	}
-	decoder := json.NewDecoder(bytes.NewReader(by))
-	decoder.UseNumber()
 
 	var attribute Attribute
-	if err := decoder.Decode(&attribute); err != nil {
-		return nil, errorhandler(NewAPIUserInputError(fmt.Sprintf("attribute could not be demarshalled, error: %v", err), "attribute")), err
+	if err := decodeInputBody(by, &attribute, "attribute"); err != nil {
+		return nil, errorhandler(err), err
 	}
 	glog.V(6).Infof(apiLogString(fmt.Sprintf("Decoded Attribute from payload: %v", attribute)))
 
@@ -1,14 +1,17 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/boltdb/bolt"
 	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
 	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/eventlog"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/offline"
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/version"
 	"os"
@@ -77,12 +80,14 @@ func FindHorizonDeviceForOutput(db *bolt.DB) (*HorizonDevice, error) {
 		}
 	} else {
 		device = ConvertFromPersistentHorizonDevice(pDevice)
+		reportConfigstateTeardown(device.Config)
 	}
 
 	return device, nil
 }
 
-// Given a demarshalled HorizonDevice object, validate it and save it, returning any errors.
+// Given a demarshalled HorizonDevice object, validate it and save it, returning any errors. When defs is set and the
+// exchange cannot be reached, the node is registered with the offline definitions instead of the exchange.
 func CreateHorizonDevice(device *HorizonDevice,
 	errorhandler ErrorHandler,
 	getOrg exchange.OrgHandlerWithContext,
@@ -90,6 +95,7 @@ func CreateHorizonDevice(device *HorizonDevice,
 	getExchangeVersion exchange.ExchangeVersionHandler,
 	patchDeviceHandler exchange.PatchDeviceHandler,
 	getDeviceHandler exchange.DeviceHandler,
+	defs *offline.Definitions,
 	em *events.EventStateManager,
 	db *bolt.DB) (bool, *HorizonDevice, *HorizonDevice) {
 
@@ -112,7 +118,16 @@ func CreateHorizonDevice(device *HorizonDevice,
 
 	glog.V(5).Infof(apiLogString(fmt.Sprintf("Create node payload: %v", device)))
 
-	LogDeviceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_START_NODE_REG, *device.Id), persistence.EC_START_NODE_CONFIG_REG, device)
+	if err := validateHorizonDeviceInput(device, true); err != nil {
+		return errorhandler(err), nil, nil
+	}
+
+	// The id may be left out, it is then taken from the environment below.
+	logId := ""
+	if device.Id != nil {
+		logId = *device.Id
+	}
+	LogDeviceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_START_NODE_REG, logId), persistence.EC_START_NODE_CONFIG_REG, device)
 
 	// There is no existing device registration in the database, so proceed to verifying the input device object.
 	if device.Id == nil || *device.Id == "" {
@@ -157,54 +172,65 @@ func CreateHorizonDevice(device *HorizonDevice,
 
 	// HA validation. Since the HA declaration is a boolean, there is nothing to validate for HA.
 
-	// make sure current exchange version meet the requirement
+	// When the exchange cannot be reached, the node is registered with the offline definitions of the config. The
+	// exchange is not called then, the node is set up with it once it can be reached.
 	deviceId := fmt.Sprintf("%v/%v", *device.Org, *device.Id)
-	if exchangeVersion, err := getExchangeVersion(deviceId, *device.Token); err != nil {
-		return errorhandler(NewSystemError(fmt.Sprintf("Error getting exchange version. error: %v", err))), nil, nil
-	} else {
-		if err := version.VerifyExchangeVersion1(exchangeVersion, false); err != nil {
-			return errorhandler(NewSystemError(fmt.Sprintf("Error verifiying exchange version. error: %v", err))), nil, nil
+	offlineReg := defs != nil && !exchangeReachable(context.Background(), deviceId, *device.Token, getDeviceHandler)
+	if offlineReg {
+		glog.Infof(apiLogString(fmt.Sprintf("The exchange cannot be reached, registering node %v with the offline definitions in %v", deviceId, defs.Dir)))
+		getOfflinePatterns := defs.PatternHandler()
+		getPatterns = func(org string, pattern string, id string, token string) (map[string]exchange.Pattern, error) {
+			return getOfflinePatterns(org, pattern)
 		}
-	}
-
-	// Verify that the input organization exists in the exchange.
-	if _, err := getOrg(*device.Org, deviceId, *device.Token); err != nil {
-		return errorhandler(NewAPIUserInputError(fmt.Sprintf("organization %v not found in exchange, error: %v", *device.Org, err), "device.organization")), nil, nil
-	}
-
-	// Verify the pattern org if the patter is not in the same org as the device.
-
-	// Check the node on the exchange to see if there is a pattern already defined for the node.
-	// Check if the node type on the exchange is the same as the given node type
-	exchDevice, err1 := getDeviceHandler(deviceId, *device.Token)
-	if err1 != nil {
-		return errorhandler(NewSystemError(fmt.Sprintf("Error getting device %v from the exchange. %v", deviceId, err1))), nil, nil
 	} else {
-		// the exchange should always return a non-empty node type. But just in case it does not, 'device' is default.
-		if exchDevice.NodeType == "" {
-			exchDevice.NodeType = persistence.DEVICE_TYPE_DEVICE
+		// make sure current exchange version meet the requirement
+		if exchangeVersion, err := getExchangeVersion(deviceId, *device.Token); err != nil {
+			return errorhandler(NewSystemError(fmt.Sprintf("Error getting exchange version. error: %v", err))), nil, nil
+		} else {
+			if err := version.VerifyExchangeVersion1(exchangeVersion, false); err != nil {
+				return errorhandler(NewSystemError(fmt.Sprintf("Error verifiying exchange version. error: %v", err))), nil, nil
+			}
 		}
-		// the device should have the same node type as the exchange node
-		if *device.NodeType != exchDevice.NodeType {
-			return errorhandler(NewAPIUserInputError(fmt.Sprintf("the exchange node type '%v' is different from the given node type '%v'.", exchDevice.NodeType, *device.NodeType), "device.nodeType")), nil, nil
+
+		// Verify that the input organization exists in the exchange.
+		if _, err := getOrg(*device.Org, deviceId, *device.Token); err != nil {
+			return errorhandler(NewAPIUserInputError(fmt.Sprintf("organization %v not found in exchange, error: %v", *device.Org, err), "device.organization")), nil, nil
 		}
 
-		if exchDevice != nil && exchDevice.Pattern != "" {
-			_, _, exchange_pattern := persistence.GetFormatedPatternString(exchDevice.Pattern, *device.Org)
+		// Verify the pattern org if the patter is not in the same org as the device.
+
+		// Check the node on the exchange to see if there is a pattern already defined for the node.
+		// Check if the node type on the exchange is the same as the given node type
+		exchDevice, err1 := getDeviceHandler(deviceId, *device.Token)
+		if err1 != nil {
+			return errorhandler(NewSystemError(fmt.Sprintf("Error getting device %v from the exchange. %v", deviceId, err1))), nil, nil
+		} else {
+			// the exchange should always return a non-empty node type. But just in case it does not, 'device' is default.
+			if exchDevice.NodeType == "" {
+				exchDevice.NodeType = persistence.DEVICE_TYPE_DEVICE
+			}
+			// the device should have the same node type as the exchange node
+			if *device.NodeType != exchDevice.NodeType {
+				return errorhandler(NewAPIUserInputError(fmt.Sprintf("the exchange node type '%v' is different from the given node type '%v'.", exchDevice.NodeType, *device.NodeType), "device.nodeType")), nil, nil
+			}
 
-			if device.Pattern != nil && *device.Pattern != "" {
-				_, _, input_pattern := persistence.GetFormatedPatternString(*device.Pattern, *device.Org)
+			if exchDevice != nil && exchDevice.Pattern != "" {
+				_, _, exchange_pattern := persistence.GetFormatedPatternString(exchDevice.Pattern, *device.Org)
 
-				if input_pattern != exchange_pattern {
-					// error if the pattern from the input is different from the pattern on the exchange
-					return errorhandler(NewAPIUserInputError(fmt.Sprintf("There is a conflict between the node pattern %v defined in the exchange and pattern %v. Please leave the pattern field empty if you want to use the pattern defined for the node in the exchange.", exchDevice.Pattern, *device.Pattern), "device.pattern")), nil, nil
+				if device.Pattern != nil && *device.Pattern != "" {
+					_, _, input_pattern := persistence.GetFormatedPatternString(*device.Pattern, *device.Org)
+
+					if input_pattern != exchange_pattern {
+						// error if the pattern from the input is different from the pattern on the exchange
+						return errorhandler(NewAPIUserInputError(fmt.Sprintf("There is a conflict between the node pattern %v defined in the exchange and pattern %v. Please leave the pattern field empty if you want to use the pattern defined for the node in the exchange.", exchDevice.Pattern, *device.Pattern), "device.pattern")), nil, nil
+					}
+				} else {
+					glog.Infof(apiLogString(fmt.Sprintf("No pattern specified with the device, will use the pattern %v defined for the node in the exchange.", exchDevice.Pattern)))
 				}
-			} else {
-				glog.Infof(apiLogString(fmt.Sprintf("No pattern specified with the device, will use the pattern %v defined for the node in the exchange.", exchDevice.Pattern)))
-			}
 
-			// use the pattern from the exchange if there is no pattern in the input device
-			device.Pattern = &exchange_pattern
+				// use the pattern from the exchange if there is no pattern in the input device
+				device.Pattern = &exchange_pattern
+			}
 		}
 	}
 
@@ -236,14 +262,23 @@ func CreateHorizonDevice(device *HorizonDevice,
 
 	glog.V(5).Infof(apiLogString(fmt.Sprintf("Create node updated: %v", pDev)))
 
+	// The patterns read while configuring a previous registration are not used for this one.
+	exchange.DeletePatternCache()
+
 	exDev := ConvertFromPersistentHorizonDevice(pDev)
 
-	// update the arch for the exchange node
-	pdr := exchange.PatchDeviceRequest{}
-	tmpArch := cutil.ArchString()
-	pdr.Arch = &tmpArch
-	if err := patchDeviceHandler(deviceId, *device.Token, &pdr); err != nil {
-		return errorhandler(NewSystemError(fmt.Sprintf("error adding architecture for the exchange node. %v", err))), nil, nil
+	// update the arch for the exchange node, it is updated once the exchange can be reached when it cannot
+	if offlineReg {
+		if err := saveOfflineRegistration(defs, device, db); err != nil {
+			return errorhandler(NewSystemError(fmt.Sprintf("error persisting the offline registration: %v", err))), nil, nil
+		}
+	} else {
+		pdr := exchange.PatchDeviceRequest{}
+		tmpArch := cutil.ArchString()
+		pdr.Arch = &tmpArch
+		if err := patchDeviceHandler(deviceId, *device.Token, &pdr); err != nil {
+			return errorhandler(NewSystemError(fmt.Sprintf("error adding architecture for the exchange node. %v", err))), nil, nil
+		}
 	}
 
 	// Return 2 device objects, the first is the fully populated newly created device object. The second is a device
@@ -251,11 +286,30 @@ func CreateHorizonDevice(device *HorizonDevice,
 	return false, device, exDev
 }
 
-// Handles the PATCH verb on this resource. Only the exchange token is updateable.
-func UpdateHorizonDevice(device *HorizonDevice,
+// Handles the PATCH verb on this resource. The exchange token and the pattern are updateable. A new pattern
+// re-registers the node with it, see changeNodePattern, the node must be configuring. A new token is verified with
+// getDevice and can be rotated in any state, the workers are told about it with a NodeTokenMessage on msgQueue. Nothing
+// is changed once ctx is done.
+func UpdateHorizonDevice(ctx context.Context,
+	device *HorizonDevice,
 	errorhandler ErrorHandler,
 	getExchangeVersion exchange.ExchangeVersionHandler,
-	db *bolt.DB) (bool, *HorizonDevice, *HorizonDevice) {
+	getDevice exchange.DeviceHandler,
+	getPatterns exchange.PatternHandler,
+	resolveService exchange.ServiceDefResolverHandler,
+	patchDevice exchange.PatchDeviceHandler,
+	msgQueue chan events.Message,
+	db *bolt.DB,
+	config *config.HorizonConfig) (bool, *HorizonDevice, *HorizonDevice) {
+
+	if err := validateHorizonDeviceInput(device, false); err != nil {
+		return errorhandler(err), nil, nil
+	}
+
+	// The exchange calls return as soon as the update is given up on.
+	getDevice = getDeviceWithContext(ctx, getDevice)
+	getPatterns = getPatternsWithContext(ctx, getPatterns)
+	resolveService = resolveServiceWithContext(ctx, resolveService)
 
 	LogDeviceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_START_NODE_UPDATE, *device.Id), persistence.EC_START_NODE_UPDATE, device)
 
@@ -266,8 +320,8 @@ func UpdateHorizonDevice(device *HorizonDevice,
 		return errorhandler(NewSystemError(fmt.Sprintf("Unable to read node object, error %v", err))), nil, nil
 	} else if pDevice == nil {
 		return errorhandler(NewNotFoundError("Exchange registration not recorded. Complete account and device registration with an exchange and then record device registration using this API.", "node")), nil, nil
-	} else if !pDevice.IsState(persistence.CONFIGSTATE_CONFIGURING) {
-		return errorhandler(NewBadRequestError(fmt.Sprintf("The node must be in configuring state in order to PATCH."))), nil, nil
+	} else if !pDevice.IsState(persistence.CONFIGSTATE_CONFIGURING) && !onlyRotatesToken(device, pDevice) {
+		return errorhandler(NewBadRequestError(fmt.Sprintf("The node must be in configuring state in order to PATCH its pattern, only its token can be changed in the other states."))), nil, nil
 	}
 
 	// Verify that the input id is ok.
@@ -297,11 +351,50 @@ func UpdateHorizonDevice(device *HorizonDevice,
 		}
 	}
 
+	// A new token is verified by reading the node's own record in the exchange with it, the old token is kept when it
+	// cannot be, so that a wrong token does not cut the node off the exchange.
+	rotated := *device.Token != pDevice.Token
+	if rotated {
+		if _, err := getDevice(deviceId, *device.Token); err != nil {
+			return errorhandler(NewAPIUserInputError(fmt.Sprintf("the node %v cannot read its record in the exchange with the new token, the token was not changed. Error: %v", deviceId, err), "device.token")), nil, nil
+		}
+	}
+
+	if err := configstateContextError(ctx); err != nil {
+		return errorhandler(err), nil, nil
+	}
+
 	updatedDev, err := pDevice.SetExchangeDeviceToken(db, *device.Id, *device.Token)
 	if err != nil {
 		return errorhandler(NewSystemError(fmt.Sprintf("error persisting token update on node object: %v", err))), nil, nil
 	}
 
+	// The workers that keep the credentials of the node use the new token from now on.
+	if rotated {
+		LogDeviceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_NODE_TOKEN_ROTATED, deviceId), persistence.EC_NODE_UPDATE_COMPLETE, device)
+		msgQueue <- events.NewNodeTokenMessage(events.NODE_TOKEN_ROTATED, updatedDev.Id, updatedDev.Org, updatedDev.Token)
+	}
+
+	// A different pattern re-registers the node with it. A node registered without a pattern is configured through
+	// its policy, it cannot be given one, nor can a node registered with a pattern be left without one.
+	if device.Pattern != nil {
+		newPattern := ""
+		if *device.Pattern != "" {
+			if bail := checkInputString(errorhandler, "device.pattern", device.Pattern); bail {
+				return true, nil, nil
+			}
+			_, _, newPattern = persistence.GetFormatedPatternString(*device.Pattern, updatedDev.Org)
+		}
+
+		if newPattern != updatedDev.Pattern {
+			if updatedDev.Pattern == "" || newPattern == "" {
+				return errorhandler(NewAPIUserInputError("the pattern can only be changed to another pattern, unregister the node to change between a pattern and a policy.", "device.pattern")), nil, nil
+			} else if updatedDev, err = changeNodePattern(updatedDev, newPattern, getPatterns, resolveService, patchDevice, msgQueue, db, config); err != nil {
+				return errorhandler(err), nil, nil
+			}
+		}
+	}
+
 	// Return 2 device objects, the first is the fully populated newly updated device object. The second is a device
 	// object suitable for output (external consumption). Specifically the token is omitted.
 	exDev := ConvertFromPersistentHorizonDevice(updatedDev)
@@ -312,6 +405,18 @@ func UpdateHorizonDevice(device *HorizonDevice,
 
 }
 
+// Returns true when the PATCH of the node does not change its pattern, the token of a node can be rotated in any state
+// without unregistering it.
+func onlyRotatesToken(device *HorizonDevice, pDevice *persistence.ExchangeDevice) bool {
+	if device.Pattern == nil {
+		return true
+	} else if *device.Pattern == "" {
+		return pDevice.Pattern == ""
+	}
+	_, _, newPattern := persistence.GetFormatedPatternString(*device.Pattern, pDevice.Org)
+	return newPattern == pDevice.Pattern
+}
+
 // Handles the DELETE verb on this resource.
 func DeleteHorizonDevice(removeNode string,
 	deepClean string,
@@ -332,9 +437,9 @@ func DeleteHorizonDevice(removeNode string,
 	} else if pDevice == nil {
 		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_UNREG_NOT_FOUND), persistence.EC_ERROR_NODE_UNREG, nil)
 		return errorhandler(NewNotFoundError("The node is not registered.", "node"))
-	} else if !pDevice.IsState(persistence.CONFIGSTATE_CONFIGURED) && !pDevice.IsState(persistence.CONFIGSTATE_CONFIGURING) {
+	} else if !pDevice.IsState(persistence.CONFIGSTATE_CONFIGURED) && !pDevice.IsState(persistence.CONFIGSTATE_CONFIGURING) && !pDevice.IsState(persistence.CONFIGSTATE_CONFIGURED_PENDING) {
 		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_UNREG_NOT_IN_STATE), persistence.EC_ERROR_NODE_UNREG, pDevice)
-		return errorhandler(NewBadRequestError(fmt.Sprintf("INVALID_NODE_STATE. The node must be in configured or configuring state in order to unconfigure it.")))
+		return errorhandler(NewBadRequestError(fmt.Sprintf("INVALID_NODE_STATE. The node must be in configured, configured_pending or configuring state in order to unconfigure it.")))
 	}
 
 	// Verify optional input
@@ -366,13 +471,11 @@ func DeleteHorizonDevice(removeNode string,
 	}
 
 	// Mark the device as "unconfigure in progress"
-	_, err = pDevice.SetConfigstate(db, pDevice.Id, persistence.CONFIGSTATE_UNCONFIGURING)
+	pDevice, err = setUnconfiguring(db)
 	if err != nil {
-		eventlog.LogDatabaseEvent(db, persistence.SEVERITY_ERROR,
-			persistence.NewMessageMeta(EL_API_ERR_SAVE_NODE_CONF_TO_DB, err.Error()),
-			persistence.EC_DATABASE_ERROR)
-		return errorhandler(NewSystemError(fmt.Sprintf("error persisting unconfiguring on node object: %v", err)))
+		return errorhandler(err)
 	}
+	msgQueue <- events.NewConfigstateChangedMessage(events.CONFIGSTATE_CHANGED, pDevice.Config.State, persistence.CONFIGSTATE_UNCONFIGURING, pDevice.Id, pDevice.Org, pDevice.Pattern)
 
 	// Remember that unconfiguration is in progress.
 	Unconfiguring = true
@@ -408,3 +511,26 @@ func DeleteHorizonDevice(removeNode string,
 	return false
 
 }
+
+// Change the node to unconfiguring under the lock of the config state changes, so that it is not mixed with a change
+// that was in progress when the node was read, e.g. a PUT of the configured state. The node is read again under the
+// lock and returned as it was before the change. Returns a ConflictError if the node can no longer be unconfigured.
+func setUnconfiguring(db *bolt.DB) (*persistence.ExchangeDevice, error) {
+	lockConfigstate()
+	defer unlockConfigstate()
+
+	pDevice, err := persistence.FindExchangeDevice(db)
+	if err != nil {
+		return nil, NewSystemError(fmt.Sprintf("Unable to read node object, error %v", err))
+	} else if pDevice == nil || (!pDevice.IsState(persistence.CONFIGSTATE_CONFIGURED) && !pDevice.IsState(persistence.CONFIGSTATE_CONFIGURING) && !pDevice.IsState(persistence.CONFIGSTATE_CONFIGURED_PENDING)) {
+		return nil, NewConflictError("the node was changed while it was being unconfigured, it is no longer in configured, configured_pending or configuring state")
+	}
+
+	if _, err := pDevice.SetConfigstate(db, pDevice.Id, persistence.CONFIGSTATE_UNCONFIGURING); err != nil {
+		eventlog.LogDatabaseEvent(db, persistence.SEVERITY_ERROR,
+			persistence.NewMessageMeta(EL_API_ERR_SAVE_NODE_CONF_TO_DB, err.Error()),
+			persistence.EC_DATABASE_ERROR)
+		return nil, NewSystemError(fmt.Sprintf("error persisting unconfiguring on node object: %v", err))
+	}
+	return pDevice, nil
+}
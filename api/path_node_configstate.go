@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+	"crypto/sha1"
 	"errors"
 	"fmt"
 	"github.com/boltdb/bolt"
@@ -15,9 +17,33 @@ import (
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/policy"
 	"github.com/open-horizon/anax/semanticversion"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Serializes the changes of the config state of the node, whoever makes them: the agent API, the provisioning on the
+// first boot, the effective time of a configured_pending node, or the governance worker when the pattern of the node is
+// changed in the exchange. A change waits for the one in progress, and then starts from the state that it left, so
+// that the services of the pattern are not configured twice. The API also holds it for the changes of the node and of
+// its services that must not be mixed with a change of the config state, e.g. a PATCH of the pattern.
+var configstateLock sync.Mutex
+
+// The number of changes of the config state that hold or wait for configstateLock.
+var configstateChanges int32
+
+func lockConfigstate() {
+	atomic.AddInt32(&configstateChanges, 1)
+	configstateLock.Lock()
+}
+
+func unlockConfigstate() {
+	configstateLock.Unlock()
+	atomic.AddInt32(&configstateChanges, -1)
+}
+
 func NoOpStateChange(from string, to string) bool {
 	if from == to {
 		return true
@@ -28,11 +54,35 @@ func NoOpStateChange(from string, to string) bool {
 func ValidStateChange(from string, to string) bool {
 	if from == persistence.CONFIGSTATE_CONFIGURING && to == persistence.CONFIGSTATE_CONFIGURED {
 		return true
+	} else if from == persistence.CONFIGSTATE_CONFIGURED && to == persistence.CONFIGSTATE_CONFIGURING {
+		return true
+	} else if from == persistence.CONFIGSTATE_CONFIGURED_PENDING && to == persistence.CONFIGSTATE_CONFIGURING {
+		return true
+	} else if from == persistence.CONFIGSTATE_UNCONFIGURING && to == persistence.CONFIGSTATE_CONFIGURING {
+		// A node left unconfiguring by an agent that stopped in the middle of a teardown recovers by completing it.
+		return true
 	}
 	return false
 }
 
-func FindConfigstateForOutput(db *bolt.DB) (*Configstate, error) {
+// The time at which the teardown of the configuration of the node started, 0 when none is in progress. It is kept in
+// memory only, the persisted state changes once the teardown completes, so that the governance worker still handles a
+// pattern change during the teardown and an agent that stops in the middle of it does not leave the node unconfiguring.
+var teardownStart int64
+
+// Reports the node as unconfiguring while its configuration is torn down.
+func reportConfigstateTeardown(cfg *Configstate) {
+	if start := atomic.LoadInt64(&teardownStart); start != 0 {
+		state := persistence.CONFIGSTATE_UNCONFIGURING
+		lut := uint64(start)
+		cfg.State = &state
+		cfg.LastUpdateTime = &lut
+	}
+}
+
+// The configstate of a registered node includes the hardware architectures of the services that the autoconfig of its
+// pattern configures.
+func FindConfigstateForOutput(db *bolt.DB, config *config.HorizonConfig) (*Configstate, error) {
 
 	var device *HorizonDevice
 
@@ -40,24 +90,133 @@ func FindConfigstateForOutput(db *bolt.DB) (*Configstate, error) {
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("unable to read node object, error %v", err))
 	} else if pDevice == nil {
-		state := persistence.CONFIGSTATE_UNCONFIGURED
-		if Unconfiguring {
-			state = persistence.CONFIGSTATE_UNCONFIGURING
-		}
+		state := persistence.CONFIGSTATE_UNCONFIGURING
 		cfg := &Configstate{
 			State: &state,
 		}
+		if !Unconfiguring {
+			// The node was reset when it was last unregistered, if ever.
+			state = persistence.CONFIGSTATE_UNCONFIGURED
+			if lastUnreg, err := persistence.GetLastUnregistrationTime(db); err != nil {
+				return nil, errors.New(fmt.Sprintf("unable to read the last unregistration time, error %v", err))
+			} else if lastUnreg != 0 {
+				cfg.LastUpdateTime = &lastUnreg
+			}
+		}
+		cfg.LastError, err = persistence.FindConfigstateAttempt(db)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("unable to read the last failed configstate change, error %v", err))
+		}
 		return cfg, nil
 
 	} else {
 		device = ConvertFromPersistentHorizonDevice(pDevice)
+		reportConfigstateTeardown(device.Config)
+		device.Config.Archs = cutil.SupportedArchs(config)
+		device.Config.LastError, err = persistence.FindConfigstateAttempt(db)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("unable to read the last failed configstate change, error %v", err))
+		}
 		return device.Config, nil
 	}
 
 }
 
-// Given a demarshalled Configstate object, validate it and save, returning any errors.
-func UpdateConfigstate(cfg *Configstate,
+// Returns an error handler that records the error in the database, as the last failed change to the requested state,
+// before it handles it.
+func recordConfigstateFailure(cfg *Configstate, errorhandler ErrorHandler, db *bolt.DB) ErrorHandler {
+	return func(err error) bool {
+		attempt := &persistence.ConfigstateAttempt{
+			Timestamp: uint64(time.Now().Unix()),
+			Category:  configstateFailureCategory(err),
+			Message:   err.Error(),
+		}
+		if cfg.State != nil {
+			attempt.RequestedState = *cfg.State
+		}
+		if serr := persistence.SaveConfigstateAttempt(db, attempt); serr != nil {
+			glog.Errorf(apiLogString(fmt.Sprintf("unable to save the failed configstate change %v, error %v", attempt, serr)))
+		}
+		return errorhandler(err)
+	}
+}
+
+// Returns the category of the failure of a config state change from the type of its error.
+func configstateFailureCategory(err error) string {
+	switch err.(type) {
+	case *APIUserInputError, *BadRequestError, *ConflictError, *PreconditionFailedError:
+		return persistence.CONFIGSTATE_FAILURE_INPUT
+	case *NotFoundError:
+		return persistence.CONFIGSTATE_FAILURE_NOT_FOUND
+	case *MultiServiceConfigError:
+		if err.(*MultiServiceConfigError).IsSystemError() {
+			return persistence.CONFIGSTATE_FAILURE_SYSTEM
+		}
+		return persistence.CONFIGSTATE_FAILURE_SERVICE_CONFIG
+	case *MSMissingVariableConfigError, *TypeMismatchError, *DuplicateServiceError:
+		return persistence.CONFIGSTATE_FAILURE_SERVICE_CONFIG
+	case *ServiceUnavailableError, *TooManyRequestsError:
+		return persistence.CONFIGSTATE_FAILURE_UNAVAILABLE
+	default:
+		return persistence.CONFIGSTATE_FAILURE_SYSTEM
+	}
+}
+
+// The config state was changed, the last failed change is no longer relevant.
+func clearConfigstateFailure(db *bolt.DB) {
+	if err := persistence.DeleteConfigstateAttempt(db); err != nil {
+		glog.Errorf(apiLogString(fmt.Sprintf("unable to delete the last failed configstate change, error %v", err)))
+	}
+}
+
+// Record the services that the autoconfig registered, the dependencies as they were resolved from pattern and the
+// top-level services with the version range they were registered with. A service that is also a dependency is only
+// in the list once, as a dependency.
+func newConfigstateResolution(pat string,
+	pattern *exchange.Pattern,
+	fromManifest bool,
+	apiSpecs *policy.APISpecList,
+	services []*Service,
+	excluded persistence.ServiceSpecs) *persistence.ConfigstateResolution {
+
+	resolved := make(policy.APISpecList, 0, len(services))
+	if apiSpecs != nil {
+		resolved = append(resolved, *apiSpecs...)
+	}
+	for _, s := range services {
+		resolved.Add_API_Spec(policy.APISpecification_Factory(*s.Url, *s.Org, *s.VersionRange, *s.Arch))
+	}
+
+	return &persistence.ConfigstateResolution{
+		Timestamp:          uint64(time.Now().Unix()),
+		Pattern:            pat,
+		PatternLabel:       pattern.Label,
+		PatternLastUpdated: pattern.LastUpdated,
+		FromManifest:       fromManifest,
+		APISpecs:           resolved,
+		Excluded:           excluded,
+	}
+}
+
+// Keep the services that the autoconfig resolved, a failure to save them does not fail the change of the state.
+func saveConfigstateResolution(db *bolt.DB, resolution *persistence.ConfigstateResolution) {
+	if err := persistence.SaveConfigstateResolution(db, resolution); err != nil {
+		glog.Errorf(apiLogString(fmt.Sprintf("unable to save the resolution of the services of the node, error %v", err)))
+	}
+}
+
+// Returns the message that tells the configstate hooks that the config state of the node was changed from oldState to
+// the state of the updated node.
+func newConfigstateChangedMessage(oldState string, updatedDev *persistence.ExchangeDevice) *events.ConfigstateChangedMessage {
+	return events.NewConfigstateChangedMessage(events.CONFIGSTATE_CHANGED, oldState, updatedDev.Config.State, updatedDev.Id, updatedDev.Org, updatedDev.Pattern)
+}
+
+// Given a demarshalled Configstate object, validate it and save, returning any errors. The change fails once ctx is
+// done, e.g. when the client went away or after the Edge.ConfigstateTimeoutS of the config, and nothing is written
+// after that: the services that it configured are removed as for any other failure. The change waits for the one in
+// progress, see configstateLock, a dry run does not.
+func UpdateConfigstate(ctx context.Context,
+	cfg *Configstate,
 	errorhandler ErrorHandler,
 	getPatterns exchange.PatternHandler,
 	resolveService exchange.ServiceDefResolverHandler,
@@ -65,129 +224,763 @@ func UpdateConfigstate(cfg *Configstate,
 	getDevice exchange.DeviceHandler,
 	patchDevice exchange.PatchDeviceHandler,
 	db *bolt.DB,
-	config *config.HorizonConfig) (bool, *Configstate, []*events.PolicyCreatedMessage) {
+	config *config.HorizonConfig) (bool, *Configstate, []events.Message) {
+
+	if cfg == nil || cfg.DryRun == nil || !*cfg.DryRun {
+		lockConfigstate()
+		defer unlockConfigstate()
+	}
+	return changeConfigstate(ctx, cfg, errorhandler, getPatterns, resolveService, getService, getDevice, patchDevice, db, config)
+}
+
+// Same as UpdateConfigstate, for a caller that holds configstateLock.
+func changeConfigstate(ctx context.Context,
+	cfg *Configstate,
+	errorhandler ErrorHandler,
+	getPatterns exchange.PatternHandler,
+	resolveService exchange.ServiceDefResolverHandler,
+	getService exchange.ServiceHandler,
+	getDevice exchange.DeviceHandler,
+	patchDevice exchange.PatchDeviceHandler,
+	db *bolt.DB,
+	config *config.HorizonConfig) (bool, *Configstate, []events.Message) {
+
+	// The state is the only field that must be given, the body may not have been validated, e.g. on a first boot.
+	if err := validateConfigstateInput(cfg); err != nil {
+		return errorhandler(err), nil, nil
+	}
+
+	// The exchange calls return as soon as the change is given up on.
+	getPatterns = getPatternsWithContext(ctx, getPatterns)
+	resolveService = resolveServiceWithContext(ctx, resolveService)
+	getService = getServiceWithContext(ctx, getService)
+	getDevice = getDeviceWithContext(ctx, getDevice)
+
+	// A dry run only reads, so its errors are not logged in the event log either.
+	if cfg.DryRun != nil && *cfg.DryRun {
+		return dryRunConfigstate(ctx, cfg, errorhandler, getPatterns, resolveService, getService, db, config)
+	}
+
+	// The caller may have given up while the change waited for the one in progress.
+	if err := configstateContextError(ctx); err != nil {
+		return errorhandler(err), nil, nil
+	}
+
+	// The errors are kept in the database until the state is changed, so that the reason of a failure can be seen after
+	// the response is gone.
+	errorhandler = recordConfigstateFailure(cfg, errorhandler, db)
 
 	// Check for the device in the local database. If there are errors, they will be written
 	// to the HTTP response.
 	pDevice, err := persistence.FindExchangeDevice(db)
 	if err != nil {
 		eventlog.LogDatabaseEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_READ_NODE_FROM_DB, err.Error()), persistence.EC_DATABASE_ERROR)
-		return errorhandler(NewSystemError(fmt.Sprintf("Unable to read node object, error %v", err))), nil, nil
+		return errorhandler(NewSystemError(fmt.Sprintf("Unable to read node object, error %v", err)).WithCode(ERR_DATABASE)), nil, nil
 	} else if pDevice == nil {
 		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_NOT_FOUND), persistence.EC_ERROR_NODE_CONFIG_REG, nil)
-		return errorhandler(NewNotFoundError("Exchange registration not recorded. Complete account and node registration with an exchange and then record node registration using this API's /node path.", "node")), nil, nil
+		return errorhandler(NewNotFoundError("Exchange registration not recorded. Complete account and node registration with an exchange and then record node registration using this API's /node path.", "node").WithCode(ERR_NODE_NOT_REGISTERED)), nil, nil
 	}
 
-	glog.V(3).Infof(apiLogString(fmt.Sprintf("Update configstate: device in local database: %v", pDevice)))
-	msgs := make([]*events.PolicyCreatedMessage, 0, 10)
+	glog.V(3).Infof(apiRequestLogString(ctx, fmt.Sprintf("Update configstate: device in local database: %v", pDevice)))
+	msgs := make([]events.Message, 0, 10)
+
+	// The services created by the autoconfig below, they are removed if the node cannot be changed to configured. The
+	// policy messages of the services are only returned on success, so they are never published for a failed autoconfig.
+	created := new(autoconfigRollback)
+
+	// The excluded services, the optional services and the channel are set first, so that the autoconfig below uses them
+	// and the output of a no-op change has them. The other states are rejected below.
+	if cfg.ExcludedServices != nil && (*cfg.State == persistence.CONFIGSTATE_CONFIGURING || *cfg.State == persistence.CONFIGSTATE_CONFIGURED) {
+		if err := updateExcludedServices(cfg, pDevice, db); err != nil {
+			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_EXCLUSIONS, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+			return errorhandler(err), nil, nil
+		}
+	}
+	if cfg.OptionalServices != nil && (*cfg.State == persistence.CONFIGSTATE_CONFIGURING || *cfg.State == persistence.CONFIGSTATE_CONFIGURED) {
+		if err := updateOptionalServices(cfg, pDevice, db); err != nil {
+			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_OPTIONAL, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+			return errorhandler(err), nil, nil
+		}
+	}
+	if cfg.Channel != nil && (*cfg.State == persistence.CONFIGSTATE_CONFIGURING || *cfg.State == persistence.CONFIGSTATE_CONFIGURED) {
+		if err := updateChannel(cfg, pDevice, db); err != nil {
+			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_CHANNEL, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+			return errorhandler(err), nil, nil
+		}
+	}
 
 	// Device registration is in the database, so verify that the requested state change is suported.
-	// The only (valid) state transition that is currently unsupported is configuring to configured. The state
+	// The supported state transitions are configuring to configured, and configured back to configuring. The state
 	// transition of unconfigured to configuring occurs when POST /node is called.
 	// If the caller is requesting a state change that is a noop, just return the current state.
-	if *cfg.State != persistence.CONFIGSTATE_CONFIGURING && *cfg.State != persistence.CONFIGSTATE_CONFIGURED {
+	if *cfg.State == persistence.CONFIGSTATE_UNCONFIGURING || *cfg.State == persistence.CONFIGSTATE_UNCONFIGURED {
 		LogDeviceEvent(db, persistence.SEVERITY_ERROR,
 			persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_WRONG_STATE, *cfg.State),
 			persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
-		return errorhandler(NewAPIUserInputError(fmt.Sprintf("Supported state values are '%v' and '%v'.", persistence.CONFIGSTATE_CONFIGURING, persistence.CONFIGSTATE_CONFIGURED), "configstate.state")), nil, nil
+		return errorhandler(NewAPIUserInputError(fmt.Sprintf("The '%v' state is set by the agent while it unconfigures the node, it cannot be set through this API. Supported state values are '%v' and '%v'.", *cfg.State, persistence.CONFIGSTATE_CONFIGURING, persistence.CONFIGSTATE_CONFIGURED), "configstate.state").WithCode(ERR_INVALID_STATE)), nil, nil
+	} else if *cfg.State == persistence.CONFIGSTATE_CONFIGURED_PENDING {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR,
+			persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_WRONG_STATE, *cfg.State),
+			persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+		return errorhandler(NewAPIUserInputError(fmt.Sprintf("The '%v' state is set by the agent when the node is changed to '%v' with an effective_time in the future, it cannot be set through this API.", *cfg.State, persistence.CONFIGSTATE_CONFIGURED), "configstate.state").WithCode(ERR_INVALID_STATE)), nil, nil
+	} else if *cfg.State != persistence.CONFIGSTATE_CONFIGURING && *cfg.State != persistence.CONFIGSTATE_CONFIGURED {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR,
+			persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_WRONG_STATE, *cfg.State),
+			persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+		return errorhandler(NewAPIUserInputError(fmt.Sprintf("Supported state values are '%v' and '%v'.", persistence.CONFIGSTATE_CONFIGURING, persistence.CONFIGSTATE_CONFIGURED), "configstate.state").WithCode(ERR_INVALID_STATE)), nil, nil
+	} else if pDevice.Config.State == persistence.CONFIGSTATE_CONFIGURED_PENDING && *cfg.State == persistence.CONFIGSTATE_CONFIGURED {
+		// The services are already configured, only the effective time changes.
+		return updatePendingConfigstate(cfg, pDevice, errorhandler, db)
 	} else if NoOpStateChange(pDevice.Config.State, *cfg.State) {
 		exDev := ConvertFromPersistentHorizonDevice(pDevice)
 		return false, exDev.Config, nil
+	} else if pDevice.Config.State == persistence.CONFIGSTATE_UNCONFIGURING && unconfiguredByAgent(db) {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_UNSUP_NODE_STATE_TRANS, pDevice.Config.State, *cfg.State), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+		return errorhandler(NewAPIUserInputError(fmt.Sprintf("Transition from '%v' to '%v' is not supported while the node is unregistered or its pattern is changed.", pDevice.Config.State, *cfg.State), "configstate.state").WithCode(ERR_INVALID_STATE_TRANSITION)), nil, nil
 	} else if !ValidStateChange(pDevice.Config.State, *cfg.State) {
 		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_UNSUP_NODE_STATE_TRANS, pDevice.Config.State, *cfg.State), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
-		return errorhandler(NewAPIUserInputError(fmt.Sprintf("Transition from '%v' to '%v' is not supported.", pDevice.Config.State, *cfg.State), "configstate.state")), nil, nil
+		return errorhandler(NewAPIUserInputError(fmt.Sprintf("Transition from '%v' to '%v' is not supported.", pDevice.Config.State, *cfg.State), "configstate.state").WithCode(ERR_INVALID_STATE_TRANSITION)), nil, nil
 	}
 
-	// From the node's pattern, resolve all the top-level services to dependent services and then register each service that is not already registered.
-	if pDevice.Pattern != "" {
+	// The services can only be pinned to a version range when the autoconfig registers them.
+	if len(cfg.Versions) != 0 && (*cfg.State != persistence.CONFIGSTATE_CONFIGURED || pDevice.Pattern == "") {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_VERSIONS, "the node has no pattern"), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+		return errorhandler(NewAPIUserInputError(fmt.Sprintf("The services can only be pinned to a version range when a node with a pattern is changed to '%v'.", persistence.CONFIGSTATE_CONFIGURED), "configstate.versions").WithCode(ERR_INVALID_VERSION_RANGE)), nil, nil
+	}
+	if cfg.EffectiveTime != nil && *cfg.State != persistence.CONFIGSTATE_CONFIGURED {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_EFFECTIVE_TIME, "the node is not being configured"), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+		return errorhandler(NewAPIUserInputError(fmt.Sprintf("An effective_time can only be set when the node is changed to '%v'.", persistence.CONFIGSTATE_CONFIGURED), "configstate.effective_time").WithCode(ERR_INVALID_EFFECTIVE_TIME)), nil, nil
+	}
+	pins, err := newVersionPins(cfg.Versions)
+	if err != nil {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_VERSIONS, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+		return errorhandler(err), nil, nil
+	}
+
+	// Going back to configuring tears down what was set up when the node was configured.
+	if *cfg.State == persistence.CONFIGSTATE_CONFIGURING {
+		return unconfigureNode(ctx, cfg, pDevice, errorhandler, db, config)
+	}
 
-		glog.V(3).Infof(apiLogString(fmt.Sprintf("Configstate autoconfig of services starting")))
+	// The services that are configured start agreements that pull their images, which fail halfway on a full disk.
+	if err := cutil.CheckDiskSpace(config); err != nil {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_DISK_SPACE, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+		return errorhandler(NewServiceUnavailableError(fmt.Sprintf("Not enough disk space to configure the node: %v", err)).WithCode(ERR_DISK_SPACE)), nil, nil
+	}
 
-		pattern_org, pattern_name, pat := persistence.GetFormatedPatternString(pDevice.Pattern, pDevice.Org)
-		pDevice.Pattern = pat
+	// A clock that is far off the exchange breaks the TLS connections and the timestamps of the agreements.
+	if skew := exchange.GetClockSkew(); skew != nil && skew.Exceeds(config.LiveEdge().ClockSkew.MaxS) {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_CLOCK_SKEW, skew.SkewS, config.LiveEdge().ClockSkew.MaxS), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+		return errorhandler(NewServiceUnavailableError(fmt.Sprintf("The clock of the node is %.0f seconds off the exchange, more than %v seconds. %v", skew.SkewS, config.LiveEdge().ClockSkew.MaxS, exchange.CLOCK_SKEW_HINT)).WithCode(ERR_CLOCK_SKEW)), nil, nil
+	}
 
-		common_apispec_list, pattern, err := getSpecRefsForPattern(pDevice.GetNodeType(), pattern_name, pattern_org, getPatterns, resolveService, db, config, true, true)
-		if err != nil {
+	// A bad exchange URL or an expired token otherwise fails deep in the resolution of the services of the pattern.
+	if cfg.CheckConnectivity != nil && *cfg.CheckConnectivity {
+		if conn := CheckConnectivity(ctx, pDevice, getDevice, config); !conn.Passed {
+			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_CONNECTIVITY, conn.failures()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+			msg := fmt.Sprintf("The node failed the connectivity checks, %v. Nothing was changed, see GET /node/connectivity.", conn.failures())
+			if conn.failureReason() == ERR_EXCHANGE_CREDENTIALS {
+				// retrying does not help, the node must be registered with other credentials
+				return errorhandler(NewAPIUserInputError(msg, "device.token").WithCode(ERR_EXCHANGE_CREDENTIALS)), nil, nil
+			}
+			return errorhandler(NewServiceUnavailableError(msg).WithCode(ERR_CONNECTIVITY)), nil, nil
+		}
+	}
+
+	// The progress of the autoconfig is published and kept for GET /node/configstate/progress, it ends when the state is
+	// changed or the request fails.
+	var progress *autoconfigProgress
+
+	// The services that the autoconfig resolved from the pattern, they are recorded once the state is changed.
+	var resolution *persistence.ConfigstateResolution
+
+	// The optional services of the node that the autoconfig skipped, they are returned as warnings.
+	var optional *optionalServices
+
+	// The services of a node without a pattern are configured from the autoconfig manifest, when there is one.
+	pattern_org, pattern_name, getAutoconfigPattern, fromManifest, err := autoconfigPattern(pDevice, getPatterns, config)
+	if err != nil {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_GET_SREFS_FOR_PATTERN, AUTOCONFIG_MANIFEST_PATTERN, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+		return errorhandler(err), nil, nil
+	}
+
+	// From the node's pattern, resolve all the top-level services to dependent services and then register each service that is not already registered.
+	if getAutoconfigPattern != nil {
+
+		glog.V(3).Infof(apiRequestLogString(ctx, fmt.Sprintf("Configstate autoconfig of services starting")))
+
+		pat := fmt.Sprintf("%v/%v", pattern_org, pattern_name)
+		if !fromManifest {
+			pDevice.Pattern = pat
+		}
+		progress = newAutoconfigProgress(pat)
+
+		// The services of a failed autoconfig are rolled back, so it did not create any in the end.
+		autoconfigStart := time.Now()
+		defer func() {
+			recordAutoconfig(autoconfigStart, progress.succeeded(), len(created.msdefs))
+		}()
+
+		// The problems of all the services are collected and returned together, so that they can be fixed at once. The
+		// problems found before any service is created stop the autoconfig before it creates anything, the node is not
+		// changed to configured when there is any.
+		problems := make([]ServiceConfigProblem, 0, 5)
+
+		optional = newOptionalServices(pDevice.Config.Optional)
+		common_apispec_list, pattern, err := getSpecRefsForPattern(ctx, pDevice.GetNodeType(), pattern_name, pattern_org, getAutoconfigPattern, resolveService, db, config, pDevice.Config.Excluded, optional, patternChannel(pDevice.Config.Channel, fromManifest), true, true, progress)
+		if cerr := configstateContextError(ctx); cerr != nil {
+			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_CANCELLED, cerr.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+			progress.fail(cerr)
+			return errorhandler(cerr), nil, nil
+		} else if multiErr, ok := err.(*MultiServiceConfigError); ok {
+			problems = append(problems, multiErr.Services...)
+		} else if err != nil {
 			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_GET_SREFS_FOR_PATTERN, pattern_name, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+			progress.fail(err)
 			return errorhandler(err), nil, nil
 		}
 
+		// A node that none of the services of the pattern can run on would be configured with nothing to run.
+		if cfg.AllowEmpty == nil || !*cfg.AllowEmpty {
+			if err := checkPatternArchs(pattern, pat, config); err != nil {
+				LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_GET_SREFS_FOR_PATTERN, pattern_name, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+				progress.fail(err)
+				return errorhandler(err), nil, nil
+			}
+		}
+
 		// get node and pattern user input
 		nodeUserInput, err := persistence.FindNodeUserInput(db)
 		if err != nil {
 			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_FAIL_GET_UI_FROM_DB, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
-			return errorhandler(fmt.Errorf("Failed get user input from local db. %v", err)), nil, nil
+			err = fmt.Errorf("Failed get user input from local db. %v", err)
+			progress.fail(err)
+			return errorhandler(err), nil, nil
 		}
 
-		// merge node user input it with pattern user input
-		mergedUserInput := policy.MergeUserInputArrays(pattern.UserInput, nodeUserInput, true)
-		if mergedUserInput == nil {
-			mergedUserInput = []policy.UserInput{}
-		}
+		// the node user input is merged with the pattern user input for each service
+		userInputLayers := newUserInputLayers(pattern.UserInput, nodeUserInput)
 
 		// Using the list of APISpec objects, we can create a service on this node automatically, for each service
-		// that already has configuration or which doesn't need it.
-		if pDevice.GetNodeType() == persistence.DEVICE_TYPE_DEVICE {
-			for _, apiSpec := range *common_apispec_list {
-
-				// get the user input for this service
-				ui_merged, _, err := policy.FindUserInput(apiSpec.SpecRef, apiSpec.Org, "", apiSpec.Arch, mergedUserInput)
-				if err != nil {
-					LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_FAIL_FIND_SVC_PREF_FROM_UI, apiSpec.Org, apiSpec.SpecRef, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
-					return errorhandler(fmt.Errorf("Failed to find preferences for service %v/%v from the merged user input, error: %v", apiSpec.Org, apiSpec.SpecRef, err)), nil, nil
-				}
+		// that already has configuration or which doesn't need it. The top-level services in a pattern also need to be
+		// registered just like the dependent services.
+		// The services pinned to a version range are registered with that range.
+		depSpecs, topLevelSpecs, err := autoconfigServiceSpecs(pDevice.GetNodeType(), pattern, common_apispec_list, problems, pDevice.Config.Excluded, optional, patternChannel(pDevice.Config.Channel, fromManifest), fromManifest, pins, config)
+		if err != nil {
+			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_VERSIONS, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+			progress.fail(err)
+			return errorhandler(err), nil, nil
+		}
+		services := make([]*Service, 0, len(*depSpecs)+len(*topLevelSpecs))
+		for _, apiSpec := range append(*depSpecs, *topLevelSpecs...) {
+			services = append(services, NewService(apiSpec.SpecRef, apiSpec.Org, autoconfigServiceName(db, apiSpec.SpecRef, apiSpec.Org, apiSpec.Version), apiSpec.Arch, apiSpec.Version))
+		}
+		if err := pins.checkAllUsed(pDevice.Pattern); err != nil {
+			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_VERSIONS, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+			progress.fail(err)
+			return errorhandler(err), nil, nil
+		}
 
-				s := NewService(apiSpec.SpecRef, apiSpec.Org, makeServiceName(apiSpec.SpecRef, apiSpec.Org, apiSpec.Version), apiSpec.Arch, apiSpec.Version)
-				if errHandled := configureService(s, getPatterns, resolveService, getService, getDevice, patchDevice, ui_merged, errorhandler, &msgs, db, config); errHandled {
-					return errHandled, nil, nil
+		// Check the user input of all the services before any of them is created.
+		if uiProblems, err := validateAutoconfigUserInput(ctx, services, getService, userInputLayers, pDevice.GetNodeType(), db); err != nil {
+			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_AUTOCONFIG, len(services), pattern_name, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+			progress.fail(err)
+			return errorhandler(err), nil, nil
+		} else {
+			problems = append(problems, uiProblems...)
+		}
+
+		if len(problems) == 0 {
+			progress.creating(len(services))
+			for _, s := range services {
+				if ctx.Err() != nil {
+					break
+				}
+				version := *s.VersionRange
+				if err := configureService(ctx, s, getPatterns, resolveService, getService, getDevice, patchDevice, userInputLayers, created, db, config); err != nil {
+					problems = append(problems, NewServiceConfigProblem(*s.Url, *s.Org, version, err))
 				}
+				progress.serviceCreated()
 			}
 		}
 
-		// The top-level services in a pattern also need to be registered just like the dependent services.
-		for _, service := range pattern.Services {
-
-			// Ignore top-level services that don't match this node's hardware architecture.
-			thisArch := cutil.ArchString()
-			if service.ServiceArch != thisArch && config.ArchSynonyms.GetCanonicalArch(service.ServiceArch) != thisArch {
-				glog.Infof(apiLogString(fmt.Sprintf("skipping service because it is for a different hardware architecture, this node is %v. Skipped service is: %v", thisArch, service.ServiceArch)))
-				continue
-			}
+		// The services are not all configured when the change was given up on, the ones that were are removed.
+		if err := configstateContextError(ctx); err != nil {
+			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_CANCELLED, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+			created.rollback(db, pDevice)
+			progress.fail(err)
+			return errorhandler(err), nil, nil
+		}
 
-			// get the user input for this service
-			ui_merged, _, err := policy.FindUserInput(service.ServiceURL, service.ServiceOrg, "", service.ServiceArch, mergedUserInput)
-			if err != nil {
-				LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_FAIL_FIND_SVC_PREF_FROM_UI, service.ServiceOrg, service.ServiceURL, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
-				return errorhandler(fmt.Errorf("Failed to find preferences for service %v/%v from the merged user input, error: %v", service.ServiceOrg, service.ServiceURL, err)), nil, nil
-			}
+		if len(problems) != 0 {
+			multiErr := NewMultiServiceConfigError(fmt.Sprintf("Configstate autoconfig, %v of the services of pattern %v cannot be configured.", len(problems), pat), "configstate.state", problems).WithCode(ERR_SERVICE_CONFIG)
+			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_AUTOCONFIG, len(problems), pattern_name, problems), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+			created.rollback(db, pDevice)
+			progress.fail(multiErr)
+			return errorhandler(multiErr), nil, nil
+		}
 
-			s := NewService(service.ServiceURL, service.ServiceOrg, makeServiceName(service.ServiceURL, service.ServiceOrg, "[0.0.0,INFINITY)"), service.ServiceArch, "[0.0.0,INFINITY)")
-			if errHandled := configureService(s, getPatterns, resolveService, getService, getDevice, patchDevice, ui_merged, errorhandler, &msgs, db, config); errHandled {
-				return errHandled, nil, nil
-			}
+		if len(pDevice.Config.Excluded) != 0 {
+			LogDeviceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_NODE_AUTOCONFIG_EXCLUDED, exclusionsString(pDevice.Config.Excluded), pattern_name), persistence.EC_START_NODE_CONFIG_REG, pDevice)
 		}
+		if warnings := optional.warnings(); len(warnings) != 0 {
+			LogDeviceEvent(db, persistence.SEVERITY_WARN, persistence.NewMessageMeta(EL_API_NODE_AUTOCONFIG_OPTIONAL, pattern_name, warnings), persistence.EC_START_NODE_CONFIG_REG, pDevice)
+		}
+
+		resolution = newConfigstateResolution(pat, pattern, fromManifest, common_apispec_list, services, pDevice.Config.Excluded)
+
+		glog.V(3).Infof(apiRequestLogString(ctx, fmt.Sprintf("Configstate autoconfig of services complete")))
 
-		glog.V(3).Infof(apiLogString(fmt.Sprintf("Configstate autoconfig of services complete")))
+	}
 
+	// The caller has given up on the change, the node is left as it was.
+	if err := configstateContextError(ctx); err != nil {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_CANCELLED, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+		created.rollback(db, pDevice)
+		progress.fail(err)
+		return errorhandler(err), nil, nil
 	}
 
-	// Update the state in the local database
-	updatedDev, err := pDevice.SetConfigstate(db, pDevice.Id, *cfg.State)
+	// Update the state in the local database, with the version ranges the services were pinned to. A node with an
+	// effective time in the future waits in configured_pending, its policies are advertised when it is configured.
+	pending := cfg.EffectiveTime != nil && *cfg.EffectiveTime > uint64(time.Now().Unix())
+	var updatedDev *persistence.ExchangeDevice
+	if pending {
+		updatedDev, err = pDevice.SetConfigstatePending(db, pDevice.Id, pins.pinned(), *cfg.EffectiveTime, created.policyFiles())
+	} else {
+		updatedDev, err = pDevice.SetConfigstateVersions(db, pDevice.Id, *cfg.State, pins.pinned())
+	}
 	if err != nil {
 		eventlog.LogDatabaseEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_SAVE_NODE_CONFSTATE, err.Error()), persistence.EC_DATABASE_ERROR)
-		return errorhandler(NewSystemError(fmt.Sprintf("error persisting new config state: %v", err))), nil, nil
+		created.rollback(db, pDevice)
+		err = NewSystemError(fmt.Sprintf("error persisting new config state: %v", err)).WithCode(ERR_DATABASE)
+		progress.fail(err)
+		return errorhandler(err), nil, nil
+	}
+	progress.complete()
+	clearConfigstateFailure(db)
+	if resolution != nil {
+		saveConfigstateResolution(db, resolution)
 	}
 
-	glog.V(5).Infof(apiLogString(fmt.Sprintf("Update configstate: updated device: %v", updatedDev)))
+	glog.V(5).Infof(apiRequestLogString(ctx, fmt.Sprintf("Update configstate: updated device: %v", updatedDev)))
 
 	exDev := ConvertFromPersistentHorizonDevice(updatedDev)
+	exDev.Config.Warnings = optional.warnings()
+
+	if pending {
+		LogDeviceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_NODE_CONF_PENDING, updatedDev.Id, time.Unix(int64(updatedDev.Config.EffectiveTime), 0).UTC().Format(time.RFC3339)), persistence.EC_NODE_CONFIG_REG_COMPLETE, updatedDev)
+		// The previous versions of the updated services are replaced now, the new ones start when the node is configured.
+		replaced := []events.Message{}
+		for _, u := range created.updated {
+			replaced = append(replaced, u.replacedMessage())
+		}
+		return false, exDev.Config, append(replaced, newConfigstateChangedMessage(pDevice.Config.State, updatedDev))
+	}
 
 	LogDeviceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_COMPLETE_NODE_REG, updatedDev.Id), persistence.EC_NODE_CONFIG_REG_COMPLETE, updatedDev)
 
+	// The policies of all the services that were created are advertised together, rather than one exchange update each.
+	if len(created.policies) != 0 {
+		msgs = append(msgs, events.NewPoliciesCreatedMessage(events.NEW_POLICIES, created.policies).WithOrigin(events.POLICY_ORIGIN_AUTOCONFIG, pDevice.Pattern))
+	}
+	for _, u := range created.updated {
+		msgs = append(msgs, u.PolicyChangedMessage, u.replacedMessage())
+	}
+	msgs = append(msgs, newConfigstateChangedMessage(pDevice.Config.State, updatedDev))
 	return false, exDev.Config, msgs
 
 }
 
+// Change a configured_pending node to configured. With an effective time that is still in the future, only the time
+// is changed, otherwise the node is configured right away, as when its effective time is reached.
+func updatePendingConfigstate(cfg *Configstate,
+	pDevice *persistence.ExchangeDevice,
+	errorhandler ErrorHandler,
+	db *bolt.DB) (bool, *Configstate, []events.Message) {
+
+	if len(cfg.Versions) != 0 {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_CONF_VERSIONS, "the services are already configured"), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+		return errorhandler(NewAPIUserInputError(fmt.Sprintf("The services of a '%v' node are already configured, they cannot be pinned to a version range.", persistence.CONFIGSTATE_CONFIGURED_PENDING), "configstate.versions").WithCode(ERR_INVALID_VERSION_RANGE)), nil, nil
+	}
+
+	if cfg.EffectiveTime != nil && *cfg.EffectiveTime > uint64(time.Now().Unix()) {
+		updatedDev, err := pDevice.SetConfigstatePending(db, pDevice.Id, pDevice.Config.Versions, *cfg.EffectiveTime, pDevice.Config.PendingPolicies)
+		if err != nil {
+			eventlog.LogDatabaseEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_SAVE_NODE_CONFSTATE, err.Error()), persistence.EC_DATABASE_ERROR)
+			return errorhandler(NewSystemError(fmt.Sprintf("error persisting new effective time: %v", err)).WithCode(ERR_DATABASE)), nil, nil
+		}
+		clearConfigstateFailure(db)
+		LogDeviceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_NODE_CONF_PENDING, updatedDev.Id, time.Unix(int64(updatedDev.Config.EffectiveTime), 0).UTC().Format(time.RFC3339)), persistence.EC_NODE_CONFIG_REG_COMPLETE, updatedDev)
+		return false, ConvertFromPersistentHorizonDevice(updatedDev).Config, nil
+	}
+
+	out, msgs, err := completePendingConfigstate(pDevice, db)
+	if err != nil {
+		return errorhandler(err), nil, nil
+	}
+	return false, out, msgs
+}
+
+// Change the configured_pending node to configured once its effective time is reached, and return the messages that
+// advertise the policies of its services. Nothing is changed when the node is not configured_pending, nil is returned
+// then. The config state is returned unchanged when the effective time is still in the future. The caller holds
+// configstateLock.
+func CompletePendingConfigstate(db *bolt.DB) (*Configstate, []events.Message, error) {
+
+	pDevice, err := persistence.FindExchangeDevice(db)
+	if err != nil {
+		return nil, nil, NewSystemError(fmt.Sprintf("Unable to read node object, error %v", err)).WithCode(ERR_DATABASE)
+	} else if pDevice == nil || pDevice.Config.State != persistence.CONFIGSTATE_CONFIGURED_PENDING {
+		return nil, nil, nil
+	} else if pDevice.Config.EffectiveTime > uint64(time.Now().Unix()) {
+		return ConvertFromPersistentHorizonDevice(pDevice).Config, nil, nil
+	}
+	return completePendingConfigstate(pDevice, db)
+}
+
+// Change the configured_pending node to configured now.
+func completePendingConfigstate(pDevice *persistence.ExchangeDevice, db *bolt.DB) (*Configstate, []events.Message, error) {
+	updatedDev, err := pDevice.SetConfigstate(db, pDevice.Id, persistence.CONFIGSTATE_CONFIGURED)
+	if err != nil {
+		eventlog.LogDatabaseEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_SAVE_NODE_CONFSTATE, err.Error()), persistence.EC_DATABASE_ERROR)
+		return nil, nil, NewSystemError(fmt.Sprintf("error persisting new config state: %v", err)).WithCode(ERR_DATABASE)
+	}
+	clearConfigstateFailure(db)
+
+	LogDeviceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_COMPLETE_NODE_REG, updatedDev.Id), persistence.EC_NODE_CONFIG_REG_COMPLETE, updatedDev)
+
+	msgs := make([]events.Message, 0, 2)
+	if len(pDevice.Config.PendingPolicies) != 0 {
+		msgs = append(msgs, events.NewPoliciesCreatedMessage(events.NEW_POLICIES, pDevice.Config.PendingPolicies).WithOrigin(events.POLICY_ORIGIN_AUTOCONFIG, pDevice.Pattern))
+	}
+	msgs = append(msgs, newConfigstateChangedMessage(pDevice.Config.State, updatedDev))
+	return ConvertFromPersistentHorizonDevice(updatedDev).Config, msgs, nil
+}
+
+// Report the services that the autoconfig would register when the node is changed to configured, without registering
+// them or changing the config state. The current config state is returned with the services.
+func dryRunConfigstate(ctx context.Context,
+	cfg *Configstate,
+	errorhandler ErrorHandler,
+	getPatterns exchange.PatternHandler,
+	resolveService exchange.ServiceDefResolverHandler,
+	getService exchange.ServiceHandler,
+	db *bolt.DB,
+	config *config.HorizonConfig) (bool, *Configstate, []events.Message) {
+
+	if *cfg.State != persistence.CONFIGSTATE_CONFIGURED {
+		return errorhandler(NewAPIUserInputError(fmt.Sprintf("A dry run is only supported for the '%v' state.", persistence.CONFIGSTATE_CONFIGURED), "configstate.dryrun").WithCode(ERR_INVALID_INPUT)), nil, nil
+	}
+
+	pDevice, err := persistence.FindExchangeDevice(db)
+	if err != nil {
+		return errorhandler(NewSystemError(fmt.Sprintf("Unable to read node object, error %v", err)).WithCode(ERR_DATABASE)), nil, nil
+	} else if pDevice == nil {
+		return errorhandler(NewNotFoundError("Exchange registration not recorded. Complete account and node registration with an exchange and then record node registration using this API's /node path.", "node").WithCode(ERR_NODE_NOT_REGISTERED)), nil, nil
+	}
+
+	// The services that the request excludes are skipped instead of the ones the node excludes.
+	excluded := pDevice.Config.Excluded
+	if cfg.ExcludedServices != nil {
+		if excluded, err = newServiceExclusions(*cfg.ExcludedServices, pDevice.Org); err != nil {
+			return errorhandler(err), nil, nil
+		}
+	}
+
+	// The optional services of the request are used instead of the ones of the node.
+	optionalSpecs := pDevice.Config.Optional
+	if cfg.OptionalServices != nil {
+		if optionalSpecs, err = newOptionalServiceSpecs(*cfg.OptionalServices, pDevice.Org); err != nil {
+			return errorhandler(err), nil, nil
+		}
+	}
+	optional := newOptionalServices(optionalSpecs)
+
+	// The channel of the request is used instead of the one of the node.
+	channel := pDevice.Config.Channel
+	if cfg.Channel != nil {
+		channel = strings.TrimSpace(*cfg.Channel)
+	}
+
+	allowEmpty := cfg.AllowEmpty != nil && *cfg.AllowEmpty
+	services, err := dryRunAutoconfig(ctx, pDevice, allowEmpty, excluded, optional, channel, getPatterns, resolveService, getService, db, config)
+	if err != nil {
+		return errorhandler(err), nil, nil
+	}
+
+	exDev := ConvertFromPersistentHorizonDevice(pDevice)
+	exDev.Config.Services = &services
+	exDev.Config.Warnings = optional.warnings()
+	return false, exDev.Config, nil
+}
+
+// Resolve the node's pattern to the services that the autoconfig would register, without registering them. Each service
+// that would fail to register because some of its user input is not set is flagged with the reason. Unless allowEmpty,
+// a pattern without services for the node's hardware architectures is an error, as for the autoconfig. The excluded
+// services are skipped, as the optional services that cannot be resolved, and only the version choices in the channel
+// are resolved.
+func dryRunAutoconfig(ctx context.Context,
+	pDevice *persistence.ExchangeDevice,
+	allowEmpty bool,
+	excluded persistence.ServiceSpecs,
+	optional *optionalServices,
+	channel string,
+	getPatterns exchange.PatternHandler,
+	resolveService exchange.ServiceDefResolverHandler,
+	getService exchange.ServiceHandler,
+	db *bolt.DB,
+	config *config.HorizonConfig) ([]AutoconfigService, error) {
+
+	services := make([]AutoconfigService, 0, 10)
+	pattern_org, pattern_name, getAutoconfigPattern, fromManifest, err := autoconfigPattern(pDevice, getPatterns, config)
+	if err != nil {
+		return nil, err
+	} else if getAutoconfigPattern == nil {
+		return services, nil
+	}
+
+	glog.V(3).Infof(apiRequestLogString(ctx, fmt.Sprintf("Configstate autoconfig dry run starting")))
+
+	// The user input of the top-level services is checked with the other services below, rather than failing on the first
+	// one that is missing.
+	common_apispec_list, pattern, err := getSpecRefsForPattern(ctx, pDevice.GetNodeType(), pattern_name, pattern_org, getAutoconfigPattern, resolveService, db, config, excluded, optional, patternChannel(channel, fromManifest), false, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !allowEmpty {
+		if err := checkPatternArchs(pattern, fmt.Sprintf("%v/%v", pattern_org, pattern_name), config); err != nil {
+			return nil, err
+		}
+	}
+
+	nodeUserInput, err := persistence.FindNodeUserInput(db)
+	if err != nil {
+		return nil, NewSystemError(fmt.Sprintf("Failed get user input from local db. %v", err)).WithCode(ERR_DATABASE)
+	}
+	userInputLayers := newUserInputLayers(pattern.UserInput, nodeUserInput)
+
+	// Same order as the autoconfig, the dependent services first and then the top-level services.
+	candidates := make([]AutoconfigService, 0, 10)
+	if pDevice.GetNodeType() == persistence.DEVICE_TYPE_DEVICE {
+		for _, apiSpec := range *common_apispec_list {
+			candidates = append(candidates, AutoconfigService{Url: apiSpec.SpecRef, Org: apiSpec.Org, Version: apiSpec.Version, Arch: apiSpec.Arch, Dependency: true})
+		}
+	}
+	thisArch := cutil.ArchString()
+	for _, service := range pattern.Services {
+		if cutil.ArchSupported(config, service.ServiceArch) && !isExcludedService(excluded, service.ServiceURL, service.ServiceOrg) && !optional.isSkipped(service.ServiceURL, service.ServiceOrg) {
+			version := "[0.0.0,INFINITY)"
+			if fromManifest {
+				version = service.ServiceVersions[0].Version
+			}
+			candidates = append(candidates, AutoconfigService{Url: service.ServiceURL, Org: service.ServiceOrg, Version: version, Arch: service.ServiceArch})
+		}
+	}
+
+	for _, candidate := range candidates {
+		// A service that is already registered is left as is by the autoconfig.
+		if pms, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter(), persistence.UrlOrgMSFilter(candidate.Url, candidate.Org)}); err != nil {
+			return nil, NewSystemError(fmt.Sprintf("Error accessing db to find service definition: %v", err)).WithCode(ERR_DATABASE)
+		} else if len(pms) > 0 {
+			candidate.Registered = true
+			services = append(services, candidate)
+			continue
+		}
+
+		sdef, _, err := getService(candidate.Url, candidate.Org, candidate.Version, candidate.Arch)
+		if (err != nil || sdef == nil) && candidate.Arch != thisArch {
+			sdef, _, err = getService(candidate.Url, candidate.Org, candidate.Version, thisArch)
+		}
+		if err != nil {
+			return nil, NewSystemError(fmt.Sprintf("Unable to find the service definition using %v/%v %v %v in the exchange, error %v", candidate.Org, candidate.Url, candidate.Version, candidate.Arch, err)).WithCode(exchangeErrorReason(err, ERR_SERVICE_NOT_FOUND))
+		} else if sdef == nil {
+			return nil, NewSystemError(fmt.Sprintf("Unable to find the service definition using %v/%v %v %v in the exchange.", candidate.Org, candidate.Url, candidate.Version, candidate.Arch)).WithCode(ERR_SERVICE_NOT_FOUND)
+		}
+
+		// The autoconfig ignores the services that do not match the node type.
+		if serviceType := sdef.GetServiceType(); serviceType != exchange.SERVICE_TYPE_BOTH && serviceType != pDevice.GetNodeType() {
+			continue
+		}
+
+		var merged_ui *policy.UserInput
+		if mergedUserInput := policy.MergeUserInputLayers(candidate.Url, candidate.Org, sdef.Version, candidate.Arch, userInputLayers); mergedUserInput != nil {
+			merged_ui = &mergedUserInput.UserInput
+		}
+		if present, missingVarName := validateUserInput(sdef, merged_ui); !present {
+			candidate.MissingConfig = fmt.Sprintf(cutil.ANAX_SVC_MISSING_VARIABLE, missingVarName, cutil.FormOrgSpecUrl(candidate.Url, candidate.Org))
+		}
+		services = append(services, candidate)
+	}
+
+	glog.V(3).Infof(apiRequestLogString(ctx, fmt.Sprintf("Configstate autoconfig dry run complete: %v", services)))
+
+	return services, nil
+}
+
+// Return a configured node to the configuring state so that it can be configured again. The agreements are cancelled and
+// the services created by the autoconfig of the node's pattern are removed. The cancellation messages are returned for the
+// caller to publish, the agreements end asynchronously. The node is unconfiguring during the teardown, and is changed to
+// configuring once the rest of the teardown is done. A failure puts the node back to configured so that the request can
+// be retried. With force, the agreements that are stuck terminating are cancelled again, and the workload containers are
+// removed without waiting for the agreement protocol to end the agreements.
+func unconfigureNode(ctx context.Context,
+	cfg *Configstate,
+	pDevice *persistence.ExchangeDevice,
+	errorhandler ErrorHandler,
+	db *bolt.DB,
+	config *config.HorizonConfig) (bool, *Configstate, []events.Message) {
+
+	force := cfg.Force != nil && *cfg.Force
+
+	// The node is configured, or configured_pending if it is waiting for its effective time.
+	orig := pDevice.Config
+
+	glog.V(3).Infof(apiRequestLogString(ctx, fmt.Sprintf("Configstate unconfigure starting, force: %v", force)))
+	LogDeviceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_START_NODE_UNCONFIG, pDevice.Id, force), persistence.EC_START_NODE_UNCONFIG, pDevice)
+
+	// A client polling the config state can tell the teardown from the configured and configuring states.
+	atomic.StoreInt64(&teardownStart, time.Now().Unix())
+	defer atomic.StoreInt64(&teardownStart, 0)
+
+	// The services that are removed, so that they can be restored when the teardown fails before the node's state is
+	// changed. The persisted state is unchanged then.
+	archived := make([]persistence.MicroserviceDefinition, 0, 10)
+
+	unconfigError := func(err error) (bool, *Configstate, []events.Message) {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_UNCONFIG, err.Error()), persistence.EC_ERROR_NODE_UNCONFIG, pDevice)
+		for _, msdef := range archived {
+			if _, uerr := persistence.MsDefUnarchived(db, msdef.Id); uerr != nil {
+				LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_UNCONFIG, uerr.Error()), persistence.EC_ERROR_NODE_UNCONFIG, pDevice)
+			}
+		}
+		return errorhandler(NewSystemError(err.Error()).WithCode(ERR_DATABASE)), nil, nil
+	}
+
+	// Everything that is torn down is read first, so that nothing is changed when it cannot be read.
+	agreements, err := persistence.FindEstablishedAgreementsAllProtocols(db, policy.AllAgreementProtocols(), []persistence.EAFilter{persistence.UnarchivedEAFilter()})
+	if err != nil {
+		return unconfigError(fmt.Errorf("Unable to read agreements, error %v", err))
+	}
+
+	// Only the services that the autoconfig created from the node's pattern are removed, they are created again when
+	// the node is configured. The services that the user registered through /service/config are kept, with or
+	// without a pattern.
+	msdefs := []persistence.MicroserviceDefinition{}
+	if pDevice.Pattern != "" {
+		if msdefs, err = persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter(), autoconfigMSFilter()}); err != nil {
+			return unconfigError(fmt.Errorf("Unable to read service definitions, error %v", err))
+		}
+	}
+
+	// Nothing is changed once the caller has given up on the teardown.
+	if err := configstateContextError(ctx); err != nil {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_UNCONFIG, err.Error()), persistence.EC_ERROR_NODE_UNCONFIG, pDevice)
+		return errorhandler(err), nil, nil
+	}
+
+	// The services are archived first, it is undone when a later step fails.
+	for _, msdef := range msdefs {
+		glog.V(3).Infof(apiRequestLogString(ctx, fmt.Sprintf("Configstate unconfigure removing service %v/%v %v", msdef.Org, msdef.SpecRef, msdef.Version)))
+		if _, err := persistence.MsDefArchived(db, msdef.Id); err != nil {
+			return unconfigError(fmt.Errorf("Unable to remove service %v/%v, error %v", msdef.Org, msdef.SpecRef, err))
+		}
+		archived = append(archived, msdef)
+	}
+
+	// Cancel the agreements. The ones that are already terminating are left alone unless forced, their workloads are
+	// then removed without waiting for the termination to complete. Each agreement is cancelled once.
+	cancels := make([]events.Message, 0, len(agreements))
+	for _, ag := range agreements {
+		if ag.AgreementTerminatedTime == 0 {
+			glog.V(3).Infof(apiRequestLogString(ctx, fmt.Sprintf("Configstate unconfigure cancelling agreement %v", ag.CurrentAgreementId)))
+			cancels = append(cancels, events.NewApiAgreementCancelationMessage(events.AGREEMENT_ENDED, events.AG_TERMINATED, ag.AgreementProtocol, ag.CurrentAgreementId, ag.GetDeploymentConfig()))
+		} else if force {
+			glog.V(3).Infof(apiRequestLogString(ctx, fmt.Sprintf("Configstate unconfigure removing the workload of terminating agreement %v", ag.CurrentAgreementId)))
+			cancels = append(cancels, events.NewGovernanceWorkloadCancelationMessage(events.AGREEMENT_ENDED, events.AG_TERMINATED, ag.AgreementProtocol, ag.CurrentAgreementId, ag.GetDeploymentConfig()))
+		} else {
+			glog.V(3).Infof(apiRequestLogString(ctx, fmt.Sprintf("Configstate unconfigure skipping agreement %v, it is already terminating", ag.CurrentAgreementId)))
+		}
+	}
+
+	// The forced terminations cannot be undone, they are recorded last before the cancellations are sent.
+	if force {
+		for _, ag := range agreements {
+			if ag.AgreementTerminatedTime == 0 {
+				continue
+			} else if _, err := persistence.AgreementStateForceTerminated(db, ag.CurrentAgreementId, ag.AgreementProtocol); err != nil {
+				return unconfigError(fmt.Errorf("Unable to force the termination of agreement %v, error %v", ag.CurrentAgreementId, err))
+			}
+		}
+	}
+
+	// The cancellations are queued before the state of the node changes, so that a node that is configuring again never
+	// has agreements of the previous configuration left running.
+	msgs := publishTeardown(cancels)
+
+	// Update the state in the local database, the services are no longer pinned to a version range
+	updatedDev, err := pDevice.SetConfigstateVersions(db, pDevice.Id, persistence.CONFIGSTATE_CONFIGURING, nil)
+	if err != nil {
+		return unconfigError(fmt.Errorf("error persisting new config state: %v", err))
+	}
+	clearConfigstateFailure(db)
+	if err := persistence.DeleteConfigstateResolution(db); err != nil {
+		glog.Errorf(apiRequestLogString(ctx, fmt.Sprintf("unable to delete the resolution of the services of the node, error %v", err)))
+	}
+
+	// The policies of the removed services are deleted once the node is configuring. A policy file that is left behind
+	// is replaced when the service is created again.
+	for _, msdef := range archived {
+		if err := policy.DeletePolicyFilesForService(config.Edge.PolicyPath, pDevice.Org, msdef.SpecRef, msdef.Org); err != nil {
+			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_UNCONFIG, err.Error()), persistence.EC_ERROR_NODE_UNCONFIG, updatedDev)
+		}
+	}
+
+	glog.V(3).Infof(apiRequestLogString(ctx, fmt.Sprintf("Configstate unconfigure complete, cancelling %v agreements", len(cancels))))
+	LogDeviceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_COMPLETE_NODE_UNCONFIG, updatedDev.Id, len(cancels)), persistence.EC_NODE_UNCONFIG_COMPLETE, updatedDev)
+
+	msgs = append(msgs, newConfigstateChangedMessage(orig.State, updatedDev))
+
+	exDev := ConvertFromPersistentHorizonDevice(updatedDev)
+	return false, exDev.Config, msgs
+}
+
+// Returns true when the node is unconfiguring because it is being unregistered, or because its pattern was changed in
+// the exchange and the agent restarts to register it with the new pattern. The node is left to the agent then.
+func unconfiguredByAgent(db *bolt.DB) bool {
+	if Unconfiguring {
+		return true
+	}
+	pattern, err := persistence.FindSavedNodeExchPattern(db)
+	return err != nil || pattern != ""
+}
+
+// Returns a filter that selects the service definitions that the autoconfig created from the node's pattern.
+func autoconfigMSFilter() persistence.MSFilter {
+	return func(e persistence.MicroserviceDefinition) bool { return e.Origin == events.POLICY_ORIGIN_AUTOCONFIG }
+}
+
+// The function that sends the messages that cancel the agreements of a node being unconfigured. It is set by the API
+// so that they are on the message bus before the state of the node changes.
+var teardownPublisher func(events.Message)
+
+// Set the function that publishes the messages of the teardown of the node on the message bus.
+func SetConfigstateTeardownPublisher(publish func(events.Message)) {
+	teardownPublisher = publish
+}
+
+// Publishes the given messages, they are returned to be sent with the other messages of the change when there is no
+// publisher.
+func publishTeardown(msgs []events.Message) []events.Message {
+	if teardownPublisher == nil {
+		return msgs
+	}
+	for _, msg := range msgs {
+		teardownPublisher(msg)
+	}
+	return []events.Message{}
+}
+
 // check if the node has the 'openhorizon.allowPrivileged' set to true
 func nodeAllowPrivilegedService(db *bolt.DB) (bool, error) {
 	nodePol, err := FindNodePolicyForOutput(db)
@@ -206,19 +999,135 @@ func nodeAllowPrivilegedService(db *bolt.DB) (bool, error) {
 	return nodePriv, nil
 }
 
-// Common function used to create/configure a service on an edge node. The boolean response indicates that an error occurred
-// and was handled (or no error occurred).
-func configureService(service *Service,
+// The services that the autoconfig created in one configstate change, so that they can be removed when the change fails.
+// The services that were registered before the change are not part of it.
+type autoconfigRollback struct {
+	msdefs   []persistence.MicroserviceDefinition
+	policies []string
+	// The ids of the attributes that were saved with the created services.
+	attributes []string
+	// The services that were updated to the version the pattern requires, a rollback restores their previous version.
+	updated []*serviceUpdate
+}
+
+// Returns the policy files of the created and of the updated services.
+func (r *autoconfigRollback) policyFiles() []string {
+	files := append([]string{}, r.policies...)
+	for _, u := range r.updated {
+		files = append(files, u.PolicyFile())
+	}
+	return files
+}
+
+// Returns the ids of the attributes that apply to the given service.
+func serviceAttributeIds(db *bolt.DB, url string, org string) ([]string, error) {
+	attrs, err := persistence.FindApplicableAttributes(db, url, org)
+	if err != nil {
+		return nil, NewSystemError(fmt.Sprintf("Error accessing db to find attributes: %v", err)).WithCode(ERR_DATABASE)
+	}
+	ids := make([]string, 0, len(attrs))
+	for _, attr := range attrs {
+		ids = append(ids, attr.GetMeta().Id)
+	}
+	return ids, nil
+}
+
+// Record the service definitions and the attributes of the given service that are not in the ones found before the
+// service was created.
+func (r *autoconfigRollback) addCreated(db *bolt.DB, url string, org string, before []persistence.MicroserviceDefinition, attributesBefore []string) error {
+	after, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter(), persistence.UrlOrgMSFilter(url, org)})
+	if err != nil {
+		return NewSystemError(fmt.Sprintf("Error accessing db to find service definition: %v", err)).WithCode(ERR_DATABASE)
+	}
+	attributesAfter, err := serviceAttributeIds(db, url, org)
+	if err != nil {
+		return err
+	}
+	for _, id := range attributesAfter {
+		if !cutil.SliceContains(attributesBefore, id) {
+			r.attributes = append(r.attributes, id)
+		}
+	}
+	for _, msdef := range after {
+		existed := false
+		for _, old := range before {
+			if old.Id == msdef.Id {
+				existed = true
+				break
+			}
+		}
+		if !existed {
+			r.msdefs = append(r.msdefs, msdef)
+		}
+	}
+	return nil
+}
+
+// Remove the services, their attributes and the policy files that were created. It carries on past the errors so that
+// as much as possible is removed, the errors are logged.
+func (r *autoconfigRollback) rollback(db *bolt.DB, pDevice *persistence.ExchangeDevice) {
+	if len(r.msdefs) == 0 && len(r.policies) == 0 && len(r.updated) == 0 && len(r.attributes) == 0 {
+		return
+	}
+
+	for _, u := range r.updated {
+		glog.V(3).Infof(apiLogString(fmt.Sprintf("Configstate autoconfig rollback restoring service %v/%v %v", u.previous.Org, u.previous.SpecRef, u.previous.Version)))
+		if err := u.undo(db); err != nil {
+			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_AUTOCONFIG_ROLLBACK, cutil.FormOrgSpecUrl(u.previous.SpecRef, u.previous.Org), err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+		}
+	}
+
+	for _, msdef := range r.msdefs {
+		glog.V(3).Infof(apiLogString(fmt.Sprintf("Configstate autoconfig rollback removing service %v/%v %v", msdef.Org, msdef.SpecRef, msdef.Version)))
+		if _, err := persistence.MsDefArchived(db, msdef.Id); err != nil {
+			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_AUTOCONFIG_ROLLBACK, cutil.FormOrgSpecUrl(msdef.SpecRef, msdef.Org), err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+		}
+	}
+	for _, id := range r.attributes {
+		glog.V(3).Infof(apiLogString(fmt.Sprintf("Configstate autoconfig rollback removing attribute %v", id)))
+		if _, err := persistence.DeleteAttribute(db, id); err != nil {
+			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_AUTOCONFIG_ROLLBACK, id, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+		}
+	}
+	for _, fileName := range r.policies {
+		if err := policy.DeletePolicyFile(fileName); err != nil {
+			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_AUTOCONFIG_ROLLBACK, fileName, err.Error()), persistence.EC_ERROR_NODE_CONFIG_REG, pDevice)
+		}
+	}
+
+	LogDeviceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_NODE_AUTOCONFIG_ROLLBACK, len(r.msdefs), pDevice.Pattern), persistence.EC_NODE_CONFIG_ROLLBACK, pDevice)
+	r.msdefs = nil
+	r.policies = nil
+	r.updated = nil
+	r.attributes = nil
+}
+
+// Common function used to create/configure a service on an edge node. The error that prevented the service from being
+// configured is returned, nil when the service is configured or is ignored by the autoconfig. What the call creates is
+// recorded in created, even when it fails halfway.
+func configureService(ctx context.Context,
+	service *Service,
 	getPatterns exchange.PatternHandler,
 	resolveService exchange.ServiceDefResolverHandler,
 	getService exchange.ServiceHandler,
 	getDevice exchange.DeviceHandler,
 	patchDevice exchange.PatchDeviceHandler,
-	mergedUserInput *policy.UserInput,
-	errorhandler ErrorHandler,
-	msgs *[]*events.PolicyCreatedMessage,
+	userInputLayers []policy.UserInputLayer,
+	created *autoconfigRollback,
 	db *bolt.DB,
-	config *config.HorizonConfig) bool {
+	config *config.HorizonConfig) error {
+
+	// The service definitions and attributes that exist before the service is created are not removed by a rollback,
+	// e.g. the ones that were registered through /service/config.
+	url, org := *service.Url, *service.Org
+	before, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter(), persistence.UrlOrgMSFilter(url, org)})
+	if err != nil {
+		return NewSystemError(fmt.Sprintf("Error accessing db to find service definition: %v", err)).WithCode(ERR_DATABASE)
+	}
+	attributesBefore, err := serviceAttributeIds(db, url, org)
+	if err != nil {
+		return err
+	}
 
 	var createServiceError error
 	passthruHandler := GetPassThroughErrorHandler(&createServiceError)
@@ -232,48 +1141,54 @@ func configureService(service *Service,
 		return passthruHandler(err)
 	}
 
-	// Make sure it is not nil
-	if mergedUserInput == nil {
-		mergedUserInput = &policy.UserInput{
-			ServiceOrgid:        *service.Org,
-			ServiceUrl:          *service.Url,
-			ServiceArch:         *service.Arch,
-			ServiceVersionRange: "",
-			Inputs:              []policy.Input{},
-		}
+	// Make sure it is not nil, so that CreateService does not look up the user input again
+	if userInputLayers == nil {
+		userInputLayers = []policy.UserInputLayer{}
+	}
+	errHandled, newService, msg := CreateService(ctx, service, create_service_error_handler, getPatterns, resolveService, getService, getDevice, patchDevice, userInputLayers, db, config, events.POLICY_ORIGIN_AUTOCONFIG, true)
+	if err := created.addCreated(db, url, org, before, attributesBefore); err != nil {
+		return err
 	}
-	if errHandled, newService, msg := CreateService(service, create_service_error_handler, getPatterns, resolveService, getService, getDevice, patchDevice, mergedUserInput, db, config, false); errHandled {
+
+	if errHandled {
 
 		switch createServiceError.(type) {
 
 		// This is a real error, the service is not configurable without supplying values for non-defaulted user inputs.
 		case *MSMissingVariableConfigError:
-			glog.Errorf(apiLogString(fmt.Sprintf("Configstate autoconfig received error (%T) %v", createServiceError, createServiceError)))
+			glog.Errorf(apiRequestLogString(ctx, fmt.Sprintf("Configstate autoconfig received error (%T) %v", createServiceError, createServiceError)))
 			msErr := createServiceError.(*MSMissingVariableConfigError)
 			// Cannot autoconfig this microservice because it has variables that need to be configured.
-			return errorhandler(NewAPIUserInputError(fmt.Sprintf("Configstate autoconfig, service %v %v %v, %v", *service.Url, *service.Org, "[0.0.0,INFINITY)", msErr.Err), "configstate.state"))
+			return NewMSMissingVariableConfigError(msErr.Err, "configstate.state").WithCode(ERR_MISSING_VARIABLE)
 
 		// This is not an error because the service has already been registered by a call to /service/config. The node user is allowed
 		// to configure any of the required services before calling the configstate API.
 		case *DuplicateServiceError:
-			glog.V(3).Infof(apiLogString(fmt.Sprintf("Configstate autoconfig found duplicate service %v %v, overwriting the version range to %v.", *service.Url, *service.Org, "[0.0.0,INFINITY)")))
+			glog.V(3).Infof(apiRequestLogString(ctx, fmt.Sprintf("Configstate autoconfig found duplicate service %v %v at a version within the range of the pattern, keeping it.", *service.Url, *service.Org)))
+
+		// The service is registered from another org, the pattern cannot use it.
+		case *ConflictError:
+			return createServiceError
 
 		// This occurs when a patterns contains a service that does not match the node type. Ignore it.
 		case *TypeMismatchError:
-			glog.V(3).Infof(apiLogString(fmt.Sprintf("Configstate autoconfig found service type not match the node type for service %v %v, ignoring it.", *service.Url, *service.Org)))
+			glog.V(3).Infof(apiRequestLogString(ctx, fmt.Sprintf("Configstate autoconfig found service type not match the node type for service %v %v, ignoring it.", *service.Url, *service.Org)))
 
 		default:
-			return errorhandler(NewSystemError(fmt.Sprintf("unexpected error returned from service create (%T) %v", createServiceError, createServiceError)))
+			return NewSystemError(fmt.Sprintf("unexpected error returned from service create (%T) %v", createServiceError, createServiceError))
 		}
 
 	} else {
-		glog.V(5).Infof(apiLogString(fmt.Sprintf("Configstate autoconfig created service %v", newService)))
-		if msg != nil {
-			(*msgs) = append((*msgs), msg)
+		glog.V(5).Infof(apiRequestLogString(ctx, fmt.Sprintf("Configstate autoconfig created service %v", newService)))
+		switch m := msg.(type) {
+		case *events.PolicyCreatedMessage:
+			created.policies = append(created.policies, m.PolicyFile())
+		case *serviceUpdate:
+			created.updated = append(created.updated, m)
 		}
 	}
 
-	return false
+	return nil
 }
 
 // This function verifies that if the given workload needs variable configuration, that there is a workloadconfig
@@ -326,44 +1241,75 @@ func workloadConfigPresent(sd *exchange.ServiceDefinition, wUrl string, wOrg, wV
 
 }
 
+// Returns an error when none of the top-level services of the pattern are for the hardware architectures of the node,
+// with the architectures that the pattern has services for.
+func checkPatternArchs(pattern *exchange.Pattern, patId string, config *config.HorizonConfig) error {
+	patternArchs := make([]string, 0, len(pattern.Services))
+	for _, service := range pattern.Services {
+		if cutil.ArchSupported(config, service.ServiceArch) {
+			return nil
+		} else if !cutil.SliceContains(patternArchs, service.ServiceArch) {
+			patternArchs = append(patternArchs, service.ServiceArch)
+		}
+	}
+	sort.Strings(patternArchs)
+
+	msg := fmt.Sprintf("Pattern %v has no services for the hardware architectures of this node %v", patId, cutil.SupportedArchs(config))
+	if len(patternArchs) != 0 {
+		msg += fmt.Sprintf(", its services are for %v", strings.Join(patternArchs, ", "))
+	}
+	return NewAPIUserInputError(msg+". Set allow_empty to configure the node without any service.", "configstate.state").WithCode(ERR_NO_SERVICES_FOR_ARCH)
+}
+
 // This function returns the referenced dependent services from a given pattern.
 // If the checkWorkloadConfig is true, it will check if the user has given the correct input for the workload/top-level service already.
-func getSpecRefsForPattern(nodeType string, patName string,
+// All the top-level services are checked before returning, the problems found with them are returned together in a
+// MultiServiceConfigError, with the dependent services of the top-level services that have no problem.
+func getSpecRefsForPattern(ctx context.Context,
+	nodeType string,
+	patName string,
 	patOrg string,
 	getPatterns exchange.PatternHandler,
 	resolveService exchange.ServiceDefResolverHandler,
 	db *bolt.DB,
 	config *config.HorizonConfig,
+	excluded persistence.ServiceSpecs,
+	optional *optionalServices,
+	channel string,
 	checkWorkloadConfig bool,
-	checkNodePrivilege bool) (*policy.APISpecList, *exchange.Pattern, error) {
+	checkNodePrivilege bool,
+	progress *autoconfigProgress) (*policy.APISpecList, *exchange.Pattern, error) {
 
-	glog.V(5).Infof(apiLogString(fmt.Sprintf("getSpecRefsForPattern %v org %v. Check service config: %v", patName, patOrg, checkWorkloadConfig)))
+	glog.V(5).Infof(apiRequestLogString(ctx, fmt.Sprintf("getSpecRefsForPattern %v org %v. Check service config: %v", patName, patOrg, checkWorkloadConfig)))
 
 	// Get the pattern definition from the exchange. There should only be one pattern returned in the map.
+	// The exchange does not return the patterns that do not exist.
+	patId := fmt.Sprintf("%v/%v", patOrg, patName)
 	pattern, err := getPatterns(patOrg, patName)
 	if err != nil {
-		return nil, nil, NewSystemError(fmt.Sprintf("Unable to read pattern object %v from exchange, error %v", patName, err))
+		return nil, nil, patternReadError(patId, err)
+	} else if len(pattern) == 0 {
+		return nil, nil, patternNotFoundError(patId)
 	} else if len(pattern) != 1 {
-		return nil, nil, NewSystemError(fmt.Sprintf("Expected only 1 pattern from exchange, received %v", len(pattern)))
+		return nil, nil, NewSystemError(fmt.Sprintf("Expected only 1 pattern from exchange, received %v", len(pattern))).WithCode(ERR_EXCHANGE_UNREACHABLE)
 	}
 
 	// Get the pattern definition that we need to analyze.
-	patId := fmt.Sprintf("%v/%v", patOrg, patName)
 	patternDef, ok := pattern[patId]
 	if !ok {
-		return nil, nil, NewSystemError(fmt.Sprintf("Expected pattern id not found in GET pattern response: %v", pattern))
+		return nil, nil, NewSystemError(fmt.Sprintf("Expected pattern id not found in GET pattern response: %v", pattern)).WithCode(ERR_EXCHANGE_UNREACHABLE)
 	}
 
-	glog.V(5).Infof(apiLogString(fmt.Sprintf("working with pattern definition %v", patternDef)))
+	glog.V(5).Infof(apiRequestLogString(ctx, fmt.Sprintf("working with pattern definition %v", patternDef)))
 
 	// For each workload/top-level service in the pattern, resolve it to a list of required services.
 	// A pattern can have references to workloads or to services, but not a mixture of both.
 	completeAPISpecList := new(policy.APISpecList)
-	thisArch := cutil.ArchString()
+	archs := cutil.SupportedArchs(config)
 
 	// This parameter is nil if the caller is configuring a workload based pattern.
 	if resolveService == nil {
-		return nil, nil, NewAPIUserInputError(fmt.Sprintf("cannot configure a dependent service on a node that is using a service based pattern: %v", patId), "microservice")
+		return nil, nil, NewAPIUserInputError(fmt.Sprintf("cannot configure a dependent service on a node that is using a service based pattern: %v", patId), "microservice").WithCode(ERR_INVALID_INPUT)
 	}
 
 	// get node policy and then check if it has PROP_NODE_PRIVILEGED to true
@@ -372,41 +1318,127 @@ func getSpecRefsForPattern(nodeType string, patName string,
 	if checkNodePrivilege {
 		nodePriv, err1 = nodeAllowPrivilegedService(db)
 		if err1 != nil {
-			return nil, nil, NewSystemError(fmt.Sprintf("Error getting node openhorizon.allowPrivileged setting. %v", err))
+			return nil, nil, NewSystemError(fmt.Sprintf("Error getting node openhorizon.allowPrivileged setting. %v", err)).WithCode(ERR_DATABASE)
 		}
 	}
 
-	for _, service := range patternDef.Services {
+	// Resolve the version choices of all the top-level services concurrently, the exchange calls are the bulk of the time
+	// it takes to configure a pattern with many services. The resolved services are then checked and merged in the order
+	// of the pattern, so that the resulting list does not depend on the order in which the resolutions complete.
+	resolutions := make([]*serviceResolution, 0, len(patternDef.Services))
+	problems := make([]ServiceConfigProblem, 0, 5)
+	for svcIndex, service := range patternDef.Services {
+
+		// Ignore the top-level services that the node excludes from the autoconfig.
+		if isExcludedService(excluded, service.ServiceURL, service.ServiceOrg) {
+			glog.Infof(apiRequestLogString(ctx, fmt.Sprintf("skipping service %v/%v because the node excludes it", service.ServiceOrg, service.ServiceURL)))
+			continue
+		}
 
-		// Ignore top-level services that don't match this node's hardware architecture.
-		if service.ServiceArch != thisArch && config.ArchSynonyms.GetCanonicalArch(service.ServiceArch) != thisArch {
-			glog.Infof(apiLogString(fmt.Sprintf("skipping service %v/%v because it is for a different hardware architecture, this node is %v. Skipped service is: %v", service.ServiceOrg, service.ServiceURL, thisArch, service.ServiceArch)))
+		// Ignore top-level services that don't match the hardware architectures this node supports.
+		if !cutil.ArchSupported(config, service.ServiceArch) {
+			glog.V(1).Infof(apiRequestLogString(ctx, fmt.Sprintf("skipping service %v/%v because it is for a different hardware architecture, this node supports %v. Skipped service is: %v", service.ServiceOrg, service.ServiceURL, archs, service.ServiceArch)))
+			continue
+		}
+
+		// Only the version choices in the channel of the node are resolved, e.g. not the beta versions on a stable node.
+		choices, err := channelChoices(service, channel)
+		if err != nil {
+			problems = append(problems, NewServiceConfigProblem(service.ServiceURL, service.ServiceOrg, "", err))
 			continue
 		}
 
 		// Each top-level service in the pattern can specify rollback versions, so to get a fully qualified top-level service URL,
 		// we need to iterate each "workloadChoice" to grab the version.
-		for _, serviceChoice := range service.ServiceVersions {
+		for _, serviceChoice := range choices {
+			resolutions = append(resolutions, &serviceResolution{svcIndex: svcIndex, service: service, version: serviceChoice.Version})
+		}
+	}
+
+	progress.patternFetched(len(resolutions))
 
-			dependentDefs, serviceDef, topSvcID, err := resolveService(service.ServiceURL, service.ServiceOrg, serviceChoice.Version, service.ServiceArch)
-			if err != nil {
-				return nil, nil, NewSystemError(fmt.Sprintf("Error resolving service %v/%v %v %v, error %v", service.ServiceOrg, service.ServiceURL, serviceChoice.Version, thisArch, err))
+	// The first service that cannot be resolved fails the change, unless it is optional, so it stops the other resolutions.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopOnError := func(res *serviceResolution) bool {
+		return !optional.isOptional(res.service.ServiceURL, res.service.ServiceOrg)
+	}
+	resolved := resolveServices(ctx, resolutions, resolveService, config.GetServiceResolutionConcurrency(), stopOnError)
+
+	skippedServices := make(map[int]bool)
+
+	// The version ranges that the top-level services require of their dependencies. Only the first version choice of
+	// each top-level service that can be configured is used, the rollback versions are not deployed with it.
+	requirements := make([]policy.DependencyRequirement, 0, 10)
+	requirementsCollected := make(map[int]bool)
+	done := make([]bool, len(resolutions))
+	next := 0
+	for next < len(resolutions) {
+		done[<-resolved] = true
+
+		for ; next < len(resolutions) && done[next]; next++ {
+			res := resolutions[next]
+			service := res.service
+
+			// An optional service is skipped when one of its version choices cannot be resolved, rather than failing the
+			// autoconfig, so all of them are resolved before the first one is used.
+			var failed *serviceResolution
+			if optional.isOptional(service.ServiceURL, service.ServiceOrg) && (next == 0 || resolutions[next-1].svcIndex != res.svcIndex) {
+				var ready bool
+				if ready, failed = choicesResolved(resolutions, done, next); !ready {
+					break
+				}
 			}
 
+			progress.serviceResolved()
+			if skippedServices[res.svcIndex] {
+				continue
+			}
+
+			// the resolutions that were stopped by the failure of another service are not reported, that failure is
+			if failed != nil && failed.cancelled && ctx.Err() == nil {
+				skippedServices[res.svcIndex] = true
+				continue
+			} else if res.err != nil && res.cancelled && ctx.Err() == nil {
+				continue
+			}
+
+			if failed != nil {
+				glog.Warningf(apiRequestLogString(ctx, fmt.Sprintf("skipping optional service %v/%v because version %v cannot be resolved, error %v", service.ServiceOrg, service.ServiceURL, failed.version, failed.err)))
+				optional.skip(NewServiceConfigProblem(service.ServiceURL, service.ServiceOrg, failed.version, fmt.Errorf("Error resolving optional service %v/%v %v %v, error %v", service.ServiceOrg, service.ServiceURL, failed.version, service.ServiceArch, failed.err)).WithCode(exchangeErrorReason(failed.err, ERR_SERVICE_NOT_FOUND)))
+				skippedServices[res.svcIndex] = true
+				continue
+			}
+
+			if res.err != nil {
+				msg := fmt.Sprintf("Error resolving service %v/%v %v %v, error %v", service.ServiceOrg, service.ServiceURL, res.version, service.ServiceArch, res.err)
+				if reason := exchangeFailureReason(res.err); reason != "" {
+					problems = append(problems, NewServiceConfigProblem(service.ServiceURL, service.ServiceOrg, res.version, NewSystemError(msg).WithCode(reason)))
+				} else {
+					problems = append(problems, NewServiceConfigProblem(service.ServiceURL, service.ServiceOrg, res.version, errors.New(msg)).WithCode(ERR_SERVICE_NOT_FOUND))
+				}
+				continue
+			}
+			dependentDefs, serviceDef, topSvcID := res.dependentDefs, res.serviceDef, res.topSvcID
+
 			// skip the service because the type mis-match.
 			serviceType := serviceDef.GetServiceType()
 			if serviceType != exchange.SERVICE_TYPE_BOTH && nodeType != serviceType {
-				glog.Infof(apiLogString(fmt.Sprintf("skipping service %v/%v because it's type %v does not match the node type %v. ", service.ServiceOrg, service.ServiceURL, serviceType, nodeType)))
-				break
+				glog.Infof(apiRequestLogString(ctx, fmt.Sprintf("skipping service %v/%v because it's type %v does not match the node type %v. ", service.ServiceOrg, service.ServiceURL, serviceType, nodeType)))
+				skippedServices[res.svcIndex] = true
+				continue
 			}
 
+			// The errors below are not about the service, returning them cancels the resolutions that are still in flight.
 			if checkWorkloadConfig {
 				// The top-level service might have variables that need to be configured. If so, find all relevant service attribute objects to make sure
 				// there is userinput config available.
-				if present, err := workloadConfigPresent(serviceDef, service.ServiceURL, service.ServiceOrg, serviceChoice.Version, patternDef.UserInput, db); err != nil {
-					return nil, nil, NewSystemError(fmt.Sprintf("Error checking service config, error %v", err))
+				if present, err := workloadConfigPresent(serviceDef, service.ServiceURL, service.ServiceOrg, serviceDef.Version, patternDef.UserInput, db); err != nil {
+					return nil, nil, NewSystemError(fmt.Sprintf("Error checking service config, error %v", err)).WithCode(ERR_DATABASE)
 				} else if !present {
-					return nil, nil, NewMSMissingVariableConfigError(fmt.Sprintf(cutil.ANAX_SVC_MISSING_CONFIG, serviceChoice.Version, cutil.FormOrgSpecUrl(service.ServiceURL, service.ServiceOrg)), "configstate.state")
+					missingVars := joinVariableNames(missingUserInput(serviceDef, nil))
+					problems = append(problems, NewServiceConfigProblem(service.ServiceURL, service.ServiceOrg, res.version, NewMSMissingVariableConfigError(fmt.Sprintf(cutil.ANAX_SVC_MISSING_CONFIG+" The variables without a default value are: %v.", res.version, cutil.FormOrgSpecUrl(service.ServiceURL, service.ServiceOrg), missingVars), "configstate.state").WithCode(ERR_MISSING_VARIABLE)))
+					continue
 				}
 			}
 
@@ -414,17 +1446,37 @@ func getSpecRefsForPattern(nodeType string, patName string,
 				if svcPriv, err := compcheck.DeploymentRequiresPrivilege(serviceDef.GetDeploymentString(), nil); err != nil {
 					return nil, nil, NewSystemError(fmt.Sprintf("Error checking if service %v requires privileged mode. %v", topSvcID, err))
 				} else if svcPriv && !nodePriv {
-					return nil, nil, NewSystemError(fmt.Sprintf("Service %v requires privileged mode, but the node does not have openhorizon.allowPrivileged property set to true.", topSvcID))
+					problems = append(problems, NewServiceConfigProblem(service.ServiceURL, service.ServiceOrg, res.version, fmt.Errorf("Service %v requires privileged mode, but the node does not have openhorizon.allowPrivileged property set to true.", topSvcID)).WithCode(ERR_SERVICE_PRIVILEGED))
+					continue
 				}
 			}
 
 			if dependentDefs != nil {
 				apiSpecList := new(policy.APISpecList)
 
-				for sId, dDef := range dependentDefs {
+				// The dependencies are added in the order of their ids, the map order is random.
+				sIds := make([]string, 0, len(dependentDefs))
+				for sId := range dependentDefs {
+					sIds = append(sIds, sId)
+				}
+				sort.Strings(sIds)
+
+				archProblem := false
+				for _, sId := range sIds {
+					dDef := dependentDefs[sId]
+
+					// The dependencies that the node excludes are not registered, the workloads that require them do
+					// not get agreements.
+					if isExcludedService(excluded, dDef.URL, exchange.GetOrg(sId)) {
+						glog.Infof(apiRequestLogString(ctx, fmt.Sprintf("skipping service %v required by %v/%v because the node excludes it", sId, service.ServiceOrg, service.ServiceURL)))
+						continue
+					}
+
 					// Look for inconsistencies in the hardware architecture of the list of dependencies.
-					if dDef.Arch != thisArch && config.ArchSynonyms.GetCanonicalArch(dDef.Arch) != thisArch {
-						return nil, nil, NewSystemError(fmt.Sprintf("The referenced service %v by service %v/%v has a hardware architecture that is not supported by this node: %v.", sId, service.ServiceOrg, service.ServiceURL, thisArch))
+					if !cutil.ArchSupported(config, dDef.Arch) {
+						problems = append(problems, NewServiceConfigProblem(service.ServiceURL, service.ServiceOrg, res.version, fmt.Errorf("The referenced service %v by service %v/%v has a hardware architecture that is not supported by this node: %v.", sId, service.ServiceOrg, service.ServiceURL, archs)).WithCode(ERR_UNSUPPORTED_ARCH))
+						archProblem = true
+						break
 					}
 
 					// generate apiSpecList from dependent def
@@ -434,39 +1486,275 @@ func getSpecRefsForPattern(nodeType string, patName string,
 					}
 					apiSpecList.Add_API_Spec(newAPISpec)
 				}
+				if archProblem {
+					continue
+				}
 
 				if checkNodePrivilege {
-					if svcPriv, err, privSvcs := compcheck.ServicesRequirePrivilege(&dependentDefs, nil); err != nil {
+					if svcPriv, err, privSvcs := compcheck.ServicesRequirePrivilege(includedServiceDefs(dependentDefs, excluded), nil); err != nil {
 						return nil, nil, NewSystemError(fmt.Sprintf("Error checking if dependent services for %v require privileged mode. %v", topSvcID, err))
 					} else if svcPriv && !nodePriv {
-						return nil, nil, NewSystemError(fmt.Sprintf("Dependent services %v for %v require privileged mode, but the node does not have openhorizon.allowPrivileged property set to true.", privSvcs, topSvcID))
+						problems = append(problems, NewServiceConfigProblem(service.ServiceURL, service.ServiceOrg, res.version, fmt.Errorf("Dependent services %v for %v require privileged mode, but the node does not have openhorizon.allowPrivileged property set to true.", privSvcs, topSvcID)).WithCode(ERR_SERVICE_PRIVILEGED))
+						continue
 					}
 				}
 
 				// MergeWith will omit exact duplicates when merging the 2 lists.
 				(*completeAPISpecList) = completeAPISpecList.MergeWith(apiSpecList)
+
+				if !requirementsCollected[res.svcIndex] {
+					requirementsCollected[res.svcIndex] = true
+					requirements = append(requirements, dependencyRequirements(serviceDef, dependentDefs, fmt.Sprintf("%v %v", cutil.FormOrgSpecUrl(service.ServiceURL, service.ServiceOrg), serviceDef.Version))...)
+				}
 			}
 		}
 	}
 
+	var problemsErr error
+	if len(problems) != 0 {
+		problemsErr = NewMultiServiceConfigError(fmt.Sprintf("%v of the services of pattern %v cannot be configured.", len(problems), patId), "configstate.state", problems).WithCode(ERR_SERVICE_CONFIG)
+	}
+
 	// If the pattern search doesnt find any microservices/services then there might be a problem.
 	if len(*completeAPISpecList) == 0 {
-		return completeAPISpecList, &patternDef, nil
+		return completeAPISpecList, &patternDef, problemsErr
 	}
 
 	// for now, anax only allow one service version, so we need to get the common version range for each service.
 	common_apispec_list, err := completeAPISpecList.GetCommonVersionRanges()
 	if err != nil {
-		return nil, nil, NewAPIUserInputError(fmt.Sprintf("Error resolving the common version ranges for the referenced services for %v %v. %v", patId, thisArch, err), "configstate.state")
+		return nil, nil, NewAPIUserInputError(fmt.Sprintf("Error resolving the common version ranges for the referenced services for %v %v. %v", patId, archs, err), "configstate.state").WithCode(ERR_INCOMPATIBLE_VERSIONS)
+	}
+
+	// The common range starts at the highest version resolved for each dependency, which is not always one that all the
+	// top-level services accept, e.g. when one of them requires an exact lower version of a shared singleton.
+	if err := common_apispec_list.ApplyRequirements(requirements); err != nil {
+		return nil, nil, NewAPIUserInputError(fmt.Sprintf("Error resolving the version ranges of the referenced services for %v %v. %v", patId, archs, err), "configstate.state").WithCode(ERR_INCOMPATIBLE_VERSIONS)
+	}
+	glog.V(5).Infof(apiRequestLogString(ctx, fmt.Sprintf("getSpecRefsForPattern resolved service version ranges to %v", *common_apispec_list)))
+
+	return common_apispec_list, &patternDef, problemsErr
+}
+
+// The version ranges that a top-level service and its dependencies require of their own dependencies, requiredBy names
+// the top-level service. The requirements of a dependency name it too.
+func dependencyRequirements(serviceDef *exchange.ServiceDefinition, dependentDefs map[string]exchange.ServiceDefinition, requiredBy string) []policy.DependencyRequirement {
+	reqs := make([]policy.DependencyRequirement, 0, len(serviceDef.RequiredServices))
+	for _, sDep := range serviceDef.RequiredServices {
+		reqs = append(reqs, policy.DependencyRequirement{SpecRef: sDep.URL, Org: sDep.Org, Arch: sDep.Arch, Version: sDep.GetVersionRange(), RequiredBy: requiredBy})
+	}
+
+	sIds := make([]string, 0, len(dependentDefs))
+	for sId := range dependentDefs {
+		sIds = append(sIds, sId)
+	}
+	sort.Strings(sIds)
+
+	for _, sId := range sIds {
+		dDef := dependentDefs[sId]
+		for _, sDep := range dDef.RequiredServices {
+			reqs = append(reqs, policy.DependencyRequirement{SpecRef: sDep.URL, Org: sDep.Org, Arch: sDep.Arch, Version: sDep.GetVersionRange(),
+				RequiredBy: fmt.Sprintf("%v through %v %v", requiredBy, cutil.FormOrgSpecUrl(dDef.URL, exchange.GetOrg(sId)), dDef.Version)})
+		}
+	}
+	return reqs
+}
+
+// The resolution of one version choice of a top-level service of a pattern.
+type serviceResolution struct {
+	svcIndex      int // the index of the service in the pattern
+	service       exchange.ServiceReference
+	version       string
+	dependentDefs map[string]exchange.ServiceDefinition
+	serviceDef    *exchange.ServiceDefinition
+	topSvcID      string
+	err           error
+	cancelled     bool // the resolution was stopped, or did not start, because its context was done
+}
+
+// Returns true when all the version choices of the top-level service of resolutions[i] are resolved, from the i-th on,
+// with the first of them that failed. The choices of a service are next to each other in resolutions.
+func choicesResolved(resolutions []*serviceResolution, done []bool, i int) (bool, *serviceResolution) {
+	var failed *serviceResolution
+	for j := i; j < len(resolutions) && resolutions[j].svcIndex == resolutions[i].svcIndex; j++ {
+		if !done[j] {
+			return false, nil
+		} else if failed == nil && resolutions[j].err != nil {
+			failed = resolutions[j]
+		}
+	}
+	return true, failed
+}
+
+// Resolve the services with at most concurrency calls to the exchange at the same time. The index of each resolution is
+// sent on the returned channel when it is complete. A resolution that fails and for which stopOnError is true stops the
+// others, as does cancelling the context: the resolutions that have not started, and the ones in flight, complete with
+// the context error.
+func resolveServices(ctx context.Context, resolutions []*serviceResolution, resolveService exchange.ServiceDefResolverHandler, concurrency int, stopOnError func(res *serviceResolution) bool) <-chan int {
+
+	// The channel can hold all the results, so the workers never block on a caller that has returned.
+	resolved := make(chan int, len(resolutions))
+	pending := make(chan int, len(resolutions))
+	for i := range resolutions {
+		pending <- i
+	}
+	close(pending)
+
+	if concurrency > len(resolutions) {
+		concurrency = len(resolutions)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var workers sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range pending {
+				res := resolutions[i]
+				if err := ctx.Err(); err != nil {
+					res.err, res.cancelled = err, true
+				} else {
+					res.dependentDefs, res.serviceDef, res.topSvcID, res.err = resolveServiceWithin(ctx, res, resolveService)
+					if res.err != nil && ctx.Err() != nil {
+						res.cancelled = true
+					} else if res.err != nil && stopOnError != nil && stopOnError(res) {
+						cancel()
+					}
+				}
+				resolved <- i
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		cancel()
+	}()
+
+	return resolved
+}
+
+// Resolve the service of the resolution, giving up on it as soon as ctx is done. The exchange requests of a resolution
+// that is given up on stop with the change they are made for, the API makes them with its context, see
+// exchange.WithContext.
+func resolveServiceWithin(ctx context.Context, res *serviceResolution, resolveService exchange.ServiceDefResolverHandler) (map[string]exchange.ServiceDefinition, *exchange.ServiceDefinition, string, error) {
+	type outcome struct {
+		dependentDefs map[string]exchange.ServiceDefinition
+		serviceDef    *exchange.ServiceDefinition
+		topSvcID      string
+		err           error
+	}
+
+	// the outcome of a resolution that is given up on is dropped
+	outcomes := make(chan outcome, 1)
+	go func() {
+		var o outcome
+		o.dependentDefs, o.serviceDef, o.topSvcID, o.err = resolveService(res.service.ServiceURL, res.service.ServiceOrg, res.version, res.service.ServiceArch)
+		outcomes <- o
+	}()
+
+	select {
+	case o := <-outcomes:
+		return o.dependentDefs, o.serviceDef, o.topSvcID, o.err
+	case <-ctx.Done():
+		return nil, nil, "", ctx.Err()
+	}
+}
+
+// Check the user input of the services that the autoconfig creates before creating any of them, so that all the
+// variables that are not set, or that are set to a value of the wrong type, are reported at once instead of one service
+// at a time, or later when an agreement is made. The user input is merged from the layers, as it is when the services
+// are created. The services that are already registered and the ones that cannot be read from the exchange are left to
+// the creation, which keeps the registered ones and reports the others.
+func validateAutoconfigUserInput(ctx context.Context, services []*Service, getService exchange.ServiceHandler, userInputLayers []policy.UserInputLayer, nodeType string, db *bolt.DB) ([]ServiceConfigProblem, error) {
+
+	problems := make([]ServiceConfigProblem, 0, 5)
+	for _, service := range services {
+
+		if pms, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter(), persistence.UrlOrgMSFilter(*service.Url, *service.Org)}); err != nil {
+			return nil, NewSystemError(fmt.Sprintf("Error accessing db to find service definition: %v", err)).WithCode(ERR_DATABASE)
+		} else if len(pms) != 0 {
+			continue
+		}
+
+		vExp, err := semanticversion.Version_Expression_Factory(*service.VersionRange)
+		if err != nil {
+			continue
+		}
+		sdef, _, err := getService(*service.Url, *service.Org, vExp.Get_expression(), *service.Arch)
+		if err != nil || sdef == nil {
+			glog.V(3).Infof(apiRequestLogString(ctx, fmt.Sprintf("Configstate autoconfig cannot read %v/%v %v %v to check its user input, error %v", *service.Org, *service.Url, vExp.Get_expression(), *service.Arch, err)))
+			continue
+		} else if serviceType := sdef.GetServiceType(); serviceType != exchange.SERVICE_TYPE_BOTH && serviceType != nodeType {
+			continue
+		}
+
+		var merged_ui *policy.UserInput
+		if mergedUserInput := policy.MergeUserInputLayers(*service.Url, *service.Org, sdef.Version, *service.Arch, userInputLayers); mergedUserInput != nil {
+			merged_ui = &mergedUserInput.UserInput
+		}
+
+		missing := []string{}
+		errs := []string{}
+		variables := []UserInputVariableProblem{}
+		for _, ui := range sdef.UserInputs {
+			if ui.Name == "" {
+				continue
+			}
+
+			var input *policy.Input
+			if merged_ui != nil {
+				input = merged_ui.FindInput(ui.Name)
+			}
+
+			if input == nil && ui.DefaultValue == "" {
+				missing = append(missing, ui.Name)
+				variables = append(variables, UserInputVariableProblem{Name: ui.Name, Type: ui.Type, DefaultValue: ui.DefaultValue, Err: "not set"})
+			} else if input != nil {
+				if err := cutil.VerifyWorkloadVarTypes(input.Value, ui.Type); err != nil {
+					errs = append(errs, fmt.Sprintf(cutil.ANAX_SVC_WRONG_TYPE+"%v", ui.Name, cutil.FormOrgSpecUrl(*service.Url, *service.Org), err))
+					variables = append(variables, UserInputVariableProblem{Name: ui.Name, Type: ui.Type, DefaultValue: ui.DefaultValue, Err: err.Error()})
+				}
+			}
+		}
+
+		if len(variables) != 0 {
+			if len(missing) != 0 {
+				errs = append([]string{fmt.Sprintf(cutil.ANAX_SVC_MISSING_VARIABLE, joinVariableNames(missing), cutil.FormOrgSpecUrl(*service.Url, *service.Org))}, errs...)
+			}
+			problem := NewServiceConfigProblem(*service.Url, *service.Org, *service.VersionRange, NewMSMissingVariableConfigError(strings.Join(errs, " "), "configstate.state").WithCode(ERR_MISSING_VARIABLE))
+			problem.Variables = variables
+			problems = append(problems, problem)
+		}
 	}
-	glog.V(5).Infof(apiLogString(fmt.Sprintf("getSpecRefsForPattern resolved service version ranges to %v", *common_apispec_list)))
 
-	return common_apispec_list, &patternDef, nil
+	return problems, nil
 }
 
-// Generate a name for the autoconfigured services.
+// The longest name given to an autoconfigured service, so that the names derived from it stay within the limits of
+// docker's container and network names.
+const maxServiceNameLen = 63
+
+// Generate a name for the autoconfigured services. The name starts with the readable parts of the URL, org and version
+// and ends with a short hash of the full normalized URL, so that URLs which differ only in their scheme or in the case
+// of their path do not get the same name.
 func makeServiceName(msURL string, msOrg string, msVersion string) string {
 
+	normalized := cutil.NormalizeSpecURL(msURL)
+	hash := fmt.Sprintf("%x", sha1.Sum([]byte(normalized)))[:8]
+
+	name := strings.ToLower(legacyServiceName(msURL, msOrg, msVersion))
+	if len(name) > maxServiceNameLen-len(hash)-1 {
+		name = name[:maxServiceNameLen-len(hash)-1]
+	}
+
+	return fmt.Sprintf("%v_%v", name, hash)
+}
+
+// The name the autoconfigured services were given before the hash of the URL was added to it. The URL is used as it
+// was given, as it was then, so that the services registered under this name are still found.
+func legacyServiceName(msURL string, msOrg string, msVersion string) string {
+
 	url := ""
 	pieces := strings.SplitN(msURL, "/", 3)
 	if len(pieces) >= 3 {
@@ -483,3 +1771,21 @@ func makeServiceName(msURL string, msOrg string, msVersion string) string {
 	return fmt.Sprintf("%v_%v_%v", url, msOrg, version)
 
 }
+
+// Returns the name of an autoconfigured service. A service that is already registered under its legacy name keeps
+// that name, otherwise the name is generated by makeServiceName.
+func autoconfigServiceName(db *bolt.DB, msURL string, msOrg string, msVersion string) string {
+
+	legacyName := legacyServiceName(msURL, msOrg, msVersion)
+	if msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter(), persistence.UrlOrgMSFilter(msURL, msOrg)}); err != nil {
+		glog.Warningf(apiLogString(fmt.Sprintf("unable to read the service definitions for %v/%v, error %v", msOrg, msURL, err)))
+	} else {
+		for _, msdef := range msdefs {
+			if msdef.Name == legacyName {
+				return legacyName
+			}
+		}
+	}
+
+	return makeServiceName(msURL, msOrg, msVersion)
+}
@@ -3,12 +3,22 @@
 package api
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"path"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func init() {
@@ -25,7 +35,7 @@ func Test_FindCSForOutput0(t *testing.T) {
 	}
 	defer cleanTestDir(dir)
 
-	if cfg, err := FindConfigstateForOutput(db); err != nil {
+	if cfg, err := FindConfigstateForOutput(db, getBasicConfig()); err != nil {
 		t.Errorf("failed to find device in db, error %v", err)
 	} else if *cfg.State != persistence.CONFIGSTATE_UNCONFIGURED {
 		t.Errorf("incorrect configstate found: %v", *cfg)
@@ -33,6 +43,29 @@ func Test_FindCSForOutput0(t *testing.T) {
 
 }
 
+// The configstate of a node that was unregistered has the time of the unregistration
+func Test_FindCSForOutput_unregistered(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	if err := persistence.SaveLastUnregistrationTime(db, 1600000000); err != nil {
+		t.Errorf("failed to save the last unregistration time, error %v", err)
+	}
+
+	if cfg, err := FindConfigstateForOutput(db, getBasicConfig()); err != nil {
+		t.Errorf("failed to find device in db, error %v", err)
+	} else if *cfg.State != persistence.CONFIGSTATE_UNCONFIGURED {
+		t.Errorf("incorrect configstate found: %v", *cfg)
+	} else if cfg.LastUpdateTime == nil || *cfg.LastUpdateTime != 1600000000 {
+		t.Errorf("the last update time should be the last unregistration time, found: %v", cfg)
+	}
+
+}
+
 // Create output configstate object based on object in the DB
 func Test_FindCSForOutput1(t *testing.T) {
 
@@ -49,12 +82,14 @@ func Test_FindCSForOutput1(t *testing.T) {
 		t.Errorf("failed to create persisted device, error %v", err)
 	}
 
-	if cfg, err := FindConfigstateForOutput(db); err != nil {
+	if cfg, err := FindConfigstateForOutput(db, getBasicConfig()); err != nil {
 		t.Errorf("failed to find device in db, error %v", err)
 	} else if *cfg.State != persistence.CONFIGSTATE_CONFIGURING {
 		t.Errorf("incorrect configstate, found: %v", *cfg)
 	} else if *cfg.LastUpdateTime == uint64(0) {
 		t.Errorf("incorrect last update time, found: %v", *cfg)
+	} else if len(cfg.Archs) != 1 || cfg.Archs[0] != cutil.ArchString() {
+		t.Errorf("the archs should be the node arch, found: %v", cfg.Archs)
 	}
 
 }
@@ -108,7 +143,7 @@ func Test_UpdateConfigstate2services(t *testing.T) {
 	sHandler := getVariableServiceHandler(exchange.UserInput{})
 
 	patternHandler := getVariablePatternHandler(sref)
-	errHandled, cfg, msgs := UpdateConfigstate(cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+	errHandled, cfg, msgs := UpdateConfigstate(context.Background(), cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
 
 	if errHandled {
 		t.Errorf("%v", myError)
@@ -121,9 +156,163 @@ func Test_UpdateConfigstate2services(t *testing.T) {
 	} else if *cfg.LastUpdateTime == uint64(0) {
 		t.Errorf("last update time should be set, is %v", *cfg)
 	} else if len(msgs) != 2 {
-		t.Errorf("there should be 2 messages, received %v", len(msgs))
+		t.Errorf("there should be 2 messages, the policies and the config state change, received %v", len(msgs))
+	} else if pols, ok := msgs[0].(*events.PoliciesCreatedMessage); !ok || len(pols.PolicyFiles()) != 2 {
+		t.Errorf("the policies of the 2 services should be in one message, received %v", msgs[0])
+	}
+
+	// the dependency and the top-level service are recorded with the pattern they were resolved from
+	if resolution, err := persistence.FindConfigstateResolution(db); err != nil {
+		t.Errorf("failed to read the configstate resolution, error %v", err)
+	} else if resolution == nil || resolution.Pattern != "myorg/mypattern" || len(resolution.APISpecs) != 2 {
+		t.Errorf("the resolution of the 2 services should be saved, got %v", resolution)
+	}
+
+}
+
+// concurrent changes to configured - only one of them configures the services of the pattern, the others find the node
+// configured when they get the lock
+func Test_UpdateConfigstate_concurrent(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	myOrg := "myorg"
+	myPattern := "mypattern"
+
+	_, err = persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, myOrg, myPattern, persistence.CONFIGSTATE_CONFIGURING)
+	if err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	sref := exchange.ServiceReference{
+		ServiceURL:      "wurl",
+		ServiceOrg:      myOrg,
+		ServiceArch:     cutil.ArchString(),
+		ServiceVersions: []exchange.WorkloadChoice{exchange.WorkloadChoice{Version: "1.0.0"}},
+	}
+
+	sResolver := getVariableServiceDefResolver("http://utest.com/mservice", myOrg, "1.0.0", cutil.ArchString(), nil)
+	sHandler := getVariableServiceHandler(exchange.UserInput{})
+
+	// the pattern is slow to read, so that the requests are all in progress together
+	variablePatternHandler := getVariablePatternHandler(sref)
+	patternHandler := func(org string, pattern string) (map[string]exchange.Pattern, error) {
+		time.Sleep(50 * time.Millisecond)
+		return variablePatternHandler(org, pattern)
+	}
+
+	const requests = 5
+	var policyMessages int32
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			state := persistence.CONFIGSTATE_CONFIGURED
+			cs := getBasicConfigstate()
+			cs.State = &state
+
+			var myError error
+			errHandled, cfg, msgs := UpdateConfigstate(context.Background(), cs, GetPassThroughErrorHandler(&myError), patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+			if errHandled {
+				t.Errorf("unexpected error %v", myError)
+				return
+			} else if cfg == nil || *cfg.State != persistence.CONFIGSTATE_CONFIGURED {
+				t.Errorf("the node should be configured, is %v", cfg)
+			}
+			for _, msg := range msgs {
+				if _, ok := msg.(*events.PoliciesCreatedMessage); ok {
+					atomic.AddInt32(&policyMessages, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if policyMessages != 1 {
+		t.Errorf("the policies should be advertised by 1 request, were by %v", policyMessages)
+	}
+
+	if msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if len(msdefs) != 2 {
+		t.Errorf("the 2 services of the pattern should be created once, there are %v service definitions", len(msdefs))
+	}
+}
+
+// change state to configured - the pattern and the dependent service are published with a synonym of the node arch
+func Test_UpdateConfigstate_arch_synonym(t *testing.T) {
+
+	synonym := map[string]string{"amd64": "x86_64", "arm64": "aarch64", "arm": "armhf"}[cutil.ArchString()]
+	if synonym == "" {
+		t.Skipf("no synonym for %v", cutil.ArchString())
+	}
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	cs := getBasicConfigstate()
+	state := persistence.CONFIGSTATE_CONFIGURED
+	cs.State = &state
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+
+	myOrg := "myorg"
+	myPattern := "mypattern"
+
+	_, err = persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "device", false, myOrg, myPattern, persistence.CONFIGSTATE_CONFIGURING)
+	if err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	wc := exchange.WorkloadChoice{
+		Version: "1.0.0",
+	}
+
+	sref := exchange.ServiceReference{
+		ServiceURL:      "wurl",
+		ServiceOrg:      myOrg,
+		ServiceArch:     synonym,
+		ServiceVersions: []exchange.WorkloadChoice{wc},
+	}
+
+	sResolver := getVariableServiceDefResolver("http://utest.com/mservice", myOrg, "1.0.0", synonym, nil)
+	sHandler := getVariableServiceHandler(exchange.UserInput{})
+
+	patternHandler := getVariablePatternHandler(sref)
+	errHandled, cfg, msgs := UpdateConfigstate(context.Background(), cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+
+	if errHandled {
+		t.Errorf("unexpected error %v", myError)
+	} else if cfg == nil {
+		t.Errorf("no configstate returned")
+	} else if *cfg.State != persistence.CONFIGSTATE_CONFIGURED {
+		t.Errorf("wrong state field %v", *cfg)
+	} else if len(msgs) != 2 {
+		t.Errorf("there should be 2 messages, the policies and the config state change, the synonym arch services were skipped, received %v", len(msgs))
 	}
 
+	// the services of the pattern are registered with the arch they are published with
+	if msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if len(msdefs) != 2 {
+		t.Errorf("the 2 services of the pattern should be created, there are %v service definitions", len(msdefs))
+	} else {
+		for _, msdef := range msdefs {
+			if msdef.Arch != synonym {
+				t.Errorf("service %v should be registered with arch %v, is %v", msdef.SpecRef, synonym, msdef.Arch)
+			}
+		}
+	}
 }
 
 // change state to configured - top level service only
@@ -171,7 +360,7 @@ func Test_UpdateConfigstate2service_only(t *testing.T) {
 	sHandler := getVariableServiceHandler(exchange.UserInput{})
 
 	patternHandler := getVariablePatternHandler(sref)
-	errHandled, cfg, msgs := UpdateConfigstate(cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+	errHandled, cfg, msgs := UpdateConfigstate(context.Background(), cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
 
 	if errHandled {
 		t.Errorf("%v", myError)
@@ -183,13 +372,13 @@ func Test_UpdateConfigstate2service_only(t *testing.T) {
 		t.Errorf("wrong state field %v", *cfg)
 	} else if *cfg.LastUpdateTime == uint64(0) {
 		t.Errorf("last update time should be set, is %v", *cfg)
-	} else if len(msgs) != 1 {
-		t.Errorf("there should be 1 message, received %v", len(msgs))
+	} else if len(msgs) != 2 {
+		t.Errorf("there should be 2 messages, the policy and the config state change, received %v", len(msgs))
 	}
 
 }
 
-// change state from configured to configuring
+// change state from unconfiguring to configured
 func Test_UpdateConfigstate_Illegal_state_change_services(t *testing.T) {
 
 	dir, db, err := utsetup()
@@ -237,7 +426,7 @@ func Test_UpdateConfigstate_Illegal_state_change_services(t *testing.T) {
 	sHandler := getVariableServiceHandler(exchange.UserInput{})
 
 	patternHandler := getVariablePatternHandler(sref)
-	errHandled, cfg, msgs := UpdateConfigstate(cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+	errHandled, cfg, msgs := UpdateConfigstate(context.Background(), cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
 
 	if errHandled {
 		t.Errorf("unexpected error %v", myError)
@@ -250,13 +439,17 @@ func Test_UpdateConfigstate_Illegal_state_change_services(t *testing.T) {
 	} else if *cfg.LastUpdateTime == uint64(0) {
 		t.Errorf("last update time should be set, is %v", *cfg)
 	} else if len(msgs) != 2 {
-		t.Errorf("there should be 2 message, received %v", len(msgs))
+		t.Errorf("there should be 2 messages, the policies and the config state change, received %v", len(msgs))
 	}
 
-	state = persistence.CONFIGSTATE_CONFIGURING
-	cs.State = &state
+	// the node is unconfigured by DELETE /node, it cannot be configured again
+	if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
+		t.Errorf("failed to read device, error %v", err)
+	} else if _, err := pDevice.SetConfigstate(db, pDevice.Id, persistence.CONFIGSTATE_UNCONFIGURING); err != nil {
+		t.Errorf("failed to update device, error %v", err)
+	}
 
-	errHandled, cfg, _ = UpdateConfigstate(cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+	errHandled, cfg, _ = UpdateConfigstate(context.Background(), cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
 
 	if !errHandled {
 		t.Errorf("expected error")
@@ -270,6 +463,48 @@ func Test_UpdateConfigstate_Illegal_state_change_services(t *testing.T) {
 
 }
 
+// a body without a state is rejected, also for a dry run, rather than dereferencing the missing state
+func Test_UpdateConfigstate_nil_state(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	_, err = persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "device", false, "myorg", "mypattern", persistence.CONFIGSTATE_CONFIGURING)
+	if err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	dryRun := true
+	for _, cs := range []*Configstate{{}, {DryRun: &dryRun}} {
+		var myError error
+		errorhandler := GetPassThroughErrorHandler(&myError)
+
+		errHandled, cfg, msgs := UpdateConfigstate(context.Background(), cs, errorhandler, getDummyGetPatterns(), getDummyServiceDefResolver(), getDummyServiceHandler(), getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+
+		if !errHandled {
+			t.Errorf("expected error for %v", cs)
+		} else if apiErr, ok := myError.(*APIUserInputError); !ok {
+			t.Errorf("myError has the wrong type (%T)", myError)
+		} else if apiErr.Input != "configstate.state" {
+			t.Errorf("wrong error input field %v", *apiErr)
+		} else if cfg != nil {
+			t.Errorf("configstate should not be returned")
+		} else if len(msgs) != 0 {
+			t.Errorf("there should be no messages, received %v", len(msgs))
+		}
+	}
+
+	// the node is left as it was
+	if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
+		t.Errorf("failed to read device, error %v", err)
+	} else if pDevice.Config.State != persistence.CONFIGSTATE_CONFIGURING {
+		t.Errorf("the state should not change, is %v", pDevice.Config.State)
+	}
+}
+
 // no change in state - configured to configured
 func Test_UpdateConfigstate_no_state_change_services(t *testing.T) {
 
@@ -318,7 +553,7 @@ func Test_UpdateConfigstate_no_state_change_services(t *testing.T) {
 	sHandler := getVariableServiceHandler(exchange.UserInput{})
 
 	patternHandler := getVariablePatternHandler(sref)
-	errHandled, cfg, msgs := UpdateConfigstate(cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+	errHandled, cfg, msgs := UpdateConfigstate(context.Background(), cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
 
 	if errHandled {
 		t.Errorf("unexpected error %v", myError)
@@ -331,10 +566,10 @@ func Test_UpdateConfigstate_no_state_change_services(t *testing.T) {
 	} else if *cfg.LastUpdateTime == uint64(0) {
 		t.Errorf("last update time should be set, is %v", *cfg)
 	} else if len(msgs) != 2 {
-		t.Errorf("there should be 2 messages, received %v", len(msgs))
+		t.Errorf("there should be 2 messages, the policies and the config state change, received %v", len(msgs))
 	}
 
-	errHandled, cfg, msgs = UpdateConfigstate(cs, errorhandler, patternHandler, getDummyServiceDefResolver(), getDummyServiceHandler(), getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+	errHandled, cfg, msgs = UpdateConfigstate(context.Background(), cs, errorhandler, patternHandler, getDummyServiceDefResolver(), getDummyServiceHandler(), getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
 
 	if errHandled {
 		t.Errorf("unexpected error %v", myError)
@@ -394,7 +629,7 @@ func Test_UpdateConfigstateWith_services(t *testing.T) {
 	mArch := "amd64"
 	sResolver := getVariableServiceDefResolver(mURL, myOrg, mVersion, mArch, nil)
 
-	errHandled, cfg, msgs := UpdateConfigstate(cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+	errHandled, cfg, msgs := UpdateConfigstate(context.Background(), cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
 
 	if errHandled {
 		t.Errorf("unexpected error %v", myError)
@@ -407,7 +642,7 @@ func Test_UpdateConfigstateWith_services(t *testing.T) {
 	} else if *cfg.LastUpdateTime == uint64(0) {
 		t.Errorf("last update time should be set, is %v", *cfg)
 	} else if len(msgs) != 2 {
-		t.Errorf("there should be 2 messages, received %v", len(msgs))
+		t.Errorf("there should be 2 messages, the policies and the config state change, received %v", len(msgs))
 	}
 
 	cleanTestDir(getBasicConfig().Edge.PolicyPath + "/" + myOrg)
@@ -462,22 +697,188 @@ func Test_UpdateConfigstate_unconfig_services(t *testing.T) {
 	patternHandler := getVariablePatternHandler(sr)
 	sResolver := getVariableServiceDefResolver(mURL, theOrg, mVersion, mArch, nil)
 
-	errHandled, cfg, _ := UpdateConfigstate(cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+	errHandled, cfg, _ := UpdateConfigstate(context.Background(), cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
 
 	if !errHandled {
 		t.Errorf("expected error")
-	} else if apiErr, ok := myError.(*APIUserInputError); !ok {
+	} else if apiErr, ok := myError.(*MultiServiceConfigError); !ok {
 		t.Errorf("myError has the wrong type (%T)", myError)
 	} else if apiErr.Input != "configstate.state" {
 		t.Errorf("wrong error input field %v", *apiErr)
-	} else if !strings.Contains(apiErr.Err, missingVarName) {
-		t.Errorf("wrong error reason, is %v", apiErr.Err)
+	} else if problem := findServiceConfigProblem(apiErr.Services, sr.ServiceURL); problem == nil {
+		t.Errorf("there should be a problem for %v, are %v", sr.ServiceURL, apiErr.Services)
+	} else if !strings.Contains(problem.Err, missingVarName) {
+		t.Errorf("wrong error reason, is %v", problem.Err)
+	} else if cfg != nil {
+		t.Errorf("configstate should not be returned")
+	}
+
+}
+
+// change state with a pattern whose dependent service has several variables that are not set, the error names all of them.
+func Test_UpdateConfigstate_missing_variables(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	cs := getBasicConfigstate()
+	state := persistence.CONFIGSTATE_CONFIGURED
+	cs.State = &state
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+
+	theOrg := "myorg"
+	thePattern := "apattern"
+
+	_, err = persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, theOrg, thePattern, persistence.CONFIGSTATE_CONFIGURING)
+	if err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	sHandler := func(mUrl string, mOrg string, mVersion string, mArch string) (*exchange.ServiceDefinition, string, error) {
+		sdef, id, err := getVariableServiceHandler(exchange.UserInput{})(mUrl, mOrg, mVersion, mArch)
+		sdef.UserInputs = []exchange.UserInput{
+			{Name: "missingVar1", Label: "label", Type: "string"},
+			{Name: "defaultedVar", Label: "label", Type: "string", DefaultValue: "value"},
+			{Name: "missingVar2", Label: "label", Type: "int"},
+		}
+		return sdef, id, err
+	}
+	sr := exchange.ServiceReference{
+		ServiceURL:      "http://mydomain.com/workload/test1",
+		ServiceOrg:      "testorg",
+		ServiceArch:     "amd64",
+		ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}},
+	}
+	sResolver := getVariableServiceDefResolver("http://utest.com/mservice", theOrg, "1.0.0", "amd64", nil)
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("the missing variables should be reported, not panic: %v", r)
+		}
+	}()
+	errHandled, cfg, _ := UpdateConfigstate(context.Background(), cs, errorhandler, getVariablePatternHandler(sr), sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+
+	if !errHandled {
+		t.Errorf("expected error")
+	} else if apiErr, ok := myError.(*MultiServiceConfigError); !ok {
+		t.Errorf("myError has the wrong type (%T)", myError)
+	} else if problem := findServiceConfigProblem(apiErr.Services, "http://utest.com/mservice"); problem == nil {
+		t.Errorf("there should be a problem for the dependent service, are %v", apiErr.Services)
+	} else if problem.Input != "configstate.state" {
+		t.Errorf("wrong error input field %v", problem)
+	} else if !strings.Contains(problem.Err, "missingVar1, missingVar2") {
+		t.Errorf("the error should name all the missing variables, is %v", problem.Err)
+	} else if strings.Contains(problem.Err, "defaultedVar") {
+		t.Errorf("the error should not name the variable with a default value, is %v", problem.Err)
 	} else if cfg != nil {
 		t.Errorf("configstate should not be returned")
 	}
 
 }
 
+// change state with a pattern whose dependent service has user input that is not set or has the wrong type, all of
+// it is reported before any service is created.
+func Test_UpdateConfigstate_userinput_preflight(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	cs := getBasicConfigstate()
+	state := persistence.CONFIGSTATE_CONFIGURED
+	cs.State = &state
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+
+	theOrg := "myorg"
+	thePattern := "apattern"
+	mURL := "http://utest.com/mservice"
+
+	_, err = persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "device", false, theOrg, thePattern, persistence.CONFIGSTATE_CONFIGURING)
+	if err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	sr := exchange.ServiceReference{
+		ServiceURL:      "http://mydomain.com/workload/test1",
+		ServiceOrg:      "testorg",
+		ServiceArch:     "amd64",
+		ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}},
+	}
+	patternHandler := func(org string, pattern string) (map[string]exchange.Pattern, error) {
+		ui := []policy.UserInput{{
+			ServiceOrgid: theOrg,
+			ServiceUrl:   mURL,
+			Inputs:       []policy.Input{{Name: "setVar", Value: "value"}, {Name: "intVar", Value: "not a number"}},
+		}}
+		return map[string]exchange.Pattern{fmt.Sprintf("%v/%v", org, pattern): exchange.Pattern{Label: "label", Services: []exchange.ServiceReference{sr}, UserInput: ui}}, nil
+	}
+
+	sHandler := func(url string, org string, version string, arch string) (*exchange.ServiceDefinition, string, error) {
+		sdef, id, err := getVariableServiceHandler(exchange.UserInput{})(url, org, version, arch)
+		if url == mURL {
+			sdef.UserInputs = []exchange.UserInput{
+				{Name: "setVar", Label: "label", Type: "string"},
+				{Name: "intVar", Label: "label", Type: "int"},
+				{Name: "missingVar", Label: "label", Type: "float"},
+				{Name: "defaultedVar", Label: "label", Type: "string", DefaultValue: "value"},
+			}
+		}
+		return sdef, id, err
+	}
+	sResolver := getVariableServiceDefResolver(mURL, theOrg, "1.0.0", "amd64", nil)
+
+	errHandled, cfg, msgs := UpdateConfigstate(context.Background(), cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+
+	if !errHandled {
+		t.Fatalf("expected error")
+	} else if cfg != nil || len(msgs) != 0 {
+		t.Errorf("no configstate or message should be returned, are %v %v", cfg, msgs)
+	}
+
+	apiErr, ok := myError.(*MultiServiceConfigError)
+	if !ok {
+		t.Fatalf("myError has the wrong type (%T)", myError)
+	} else if len(apiErr.Services) != 1 {
+		t.Fatalf("only the dependent service should have a problem, are %v", apiErr.Services)
+	}
+
+	problem := apiErr.Services[0]
+	if problem.Url != mURL || problem.Input != "configstate.state" {
+		t.Errorf("wrong problem %v", problem)
+	} else if !strings.Contains(problem.Err, "missingVar") || !strings.Contains(problem.Err, "intVar") {
+		t.Errorf("the error should name the missing variable and the one with the wrong type, is %v", problem.Err)
+	}
+
+	if len(problem.Variables) != 2 {
+		t.Errorf("there should be 2 variables with a problem, are %v", problem.Variables)
+	} else {
+		for _, v := range problem.Variables {
+			if v.Name == "intVar" && v.Type == "int" && v.Err != "not set" {
+				continue
+			} else if v.Name == "missingVar" && v.Type == "float" && v.Err == "not set" {
+				continue
+			}
+			t.Errorf("wrong variable problem %v", v)
+		}
+	}
+
+	// nothing was created
+	if pms, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()}); err != nil {
+		t.Errorf("failed to read service definitions, error %v", err)
+	} else if len(pms) != 0 {
+		t.Errorf("no service should be created, found %v", pms)
+	}
+}
+
 // change state with a pattern that has a top-level service which requires config, error results.
 func Test_UpdateConfigstate_unconfig_top_level_services(t *testing.T) {
 
@@ -526,35 +927,1217 @@ func Test_UpdateConfigstate_unconfig_top_level_services(t *testing.T) {
 	patternHandler := getVariablePatternHandler(sr)
 	sResolver := getVariableServiceDefResolver(mURL, theOrg, mVersion, mArch, &ui)
 
-	errHandled, cfg, _ := UpdateConfigstate(cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+	errHandled, cfg, _ := UpdateConfigstate(context.Background(), cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
 
 	if !errHandled {
 		t.Errorf("expected error")
-	} else if apiErr, ok := myError.(*MSMissingVariableConfigError); !ok {
+	} else if apiErr, ok := myError.(*MultiServiceConfigError); !ok {
 		t.Errorf("myError has the wrong type (%T)", myError)
 	} else if apiErr.Input != "configstate.state" {
 		t.Errorf("wrong error input field %v", *apiErr)
-	} else if !strings.Contains(apiErr.Err, "missing") {
-		t.Errorf("wrong error reason, is %v", apiErr.Err)
+	} else if problem := findServiceConfigProblem(apiErr.Services, sr.ServiceURL); problem == nil {
+		t.Errorf("there should be a problem for %v, are %v", sr.ServiceURL, apiErr.Services)
+	} else if !strings.Contains(problem.Err, "missing") {
+		t.Errorf("wrong error reason, is %v", problem.Err)
+	} else if !strings.Contains(problem.Err, ui.Name) {
+		t.Errorf("the error should name the missing variable, is %v", problem.Err)
+	} else if problem.Version != "1.0.0" {
+		t.Errorf("wrong version of the problem, is %v", problem)
 	} else if cfg != nil {
 		t.Errorf("configstate should not be returned")
 	}
 
 }
 
-func getBasicConfigstate() *Configstate {
-	state := persistence.CONFIGSTATE_CONFIGURING
-	cs := &Configstate{
-		State: &state,
+// the problems of all the top-level services are returned together, and the node is not configured
+func Test_UpdateConfigstate_all_service_problems(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	cs := getBasicConfigstate()
+	state := persistence.CONFIGSTATE_CONFIGURED
+	cs.State = &state
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+
+	theOrg := "myorg"
+	thePattern := "apattern"
+
+	_, err = persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "device", false, theOrg, thePattern, persistence.CONFIGSTATE_CONFIGURING)
+	if err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	ui := exchange.UserInput{
+		Name:         "missingVar",
+		Label:        "label",
+		Type:         "string",
+		DefaultValue: "",
+	}
+	sHandler := getVariableServiceHandler(exchange.UserInput{})
+	sResolver := getVariableServiceDefResolver("http://utest.com/mservice", theOrg, "1.0.0", "amd64", &ui)
+
+	urls := []string{"http://mydomain.com/workload/test1", "http://mydomain.com/workload/test2"}
+	patternHandler := func(org string, pattern string) (map[string]exchange.Pattern, error) {
+		srs := []exchange.ServiceReference{}
+		for _, url := range urls {
+			srs = append(srs, exchange.ServiceReference{
+				ServiceURL:      url,
+				ServiceOrg:      "testorg",
+				ServiceArch:     "amd64",
+				ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}},
+			})
+		}
+		return map[string]exchange.Pattern{fmt.Sprintf("%v/%v", org, pattern): exchange.Pattern{Label: "label", Services: srs}}, nil
+	}
+
+	errHandled, cfg, _ := UpdateConfigstate(context.Background(), cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+
+	if !errHandled {
+		t.Errorf("expected error")
+	} else if apiErr, ok := myError.(*MultiServiceConfigError); !ok {
+		t.Errorf("myError has the wrong type (%T)", myError)
+	} else {
+		for _, url := range urls {
+			if problem := findServiceConfigProblem(apiErr.Services, url); problem == nil {
+				t.Errorf("there should be a problem for %v, are %v", url, apiErr.Services)
+			} else if problem.Org != "testorg" || problem.Version != "1.0.0" {
+				t.Errorf("wrong service of the problem, is %v", problem)
+			}
+		}
+		if cfg != nil {
+			t.Errorf("configstate should not be returned")
+		}
+	}
+
+	if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
+		t.Errorf("failed to read device, error %v", err)
+	} else if pDevice.Config.State != persistence.CONFIGSTATE_CONFIGURING {
+		t.Errorf("the node should still be configuring, is %v", pDevice.Config.State)
 	}
-	return cs
 }
 
-func getSingleOrgHandler(org string, id string, token string) (*exchange.Organization, error) {
-	o := &exchange.Organization{
-		Label:       "label",
-		Description: "desc",
-		LastUpdated: "today",
+func findServiceConfigProblem(problems []ServiceConfigProblem, url string) *ServiceConfigProblem {
+	for ix := range problems {
+		if problems[ix].Url == url {
+			return &problems[ix]
+		}
+	}
+	return nil
+}
+
+// change state to configured - the services of the pattern for an additional arch of the node are configured, a node
+// that none of them are for is only configured with allow_empty
+func Test_UpdateConfigstate_additional_arch(t *testing.T) {
+
+	other := "arm64"
+	if cutil.ArchString() == "arm64" {
+		other = "amd64"
+	}
+
+	for _, additional := range []bool{false, true} {
+
+		dir, db, err := utsetup()
+		if err != nil {
+			t.Error(err)
+		}
+
+		cs := getBasicConfigstate()
+		state := persistence.CONFIGSTATE_CONFIGURED
+		cs.State = &state
+
+		var myError error
+		errorhandler := GetPassThroughErrorHandler(&myError)
+
+		myOrg := "myorg"
+		_, err = persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "device", false, myOrg, "mypattern", persistence.CONFIGSTATE_CONFIGURING)
+		if err != nil {
+			t.Errorf("failed to create persisted device, error %v", err)
+		}
+
+		sref := exchange.ServiceReference{
+			ServiceURL:      "wurl",
+			ServiceOrg:      myOrg,
+			ServiceArch:     other,
+			ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}},
+		}
+		patternHandler := getVariablePatternHandler(sref)
+		sResolver := getVariableServiceDefResolver("http://utest.com/mservice", myOrg, "1.0.0", other, nil)
+		sHandler := getVariableServiceHandler(exchange.UserInput{})
+
+		cfg := getBasicConfig()
+		cfg.Edge.PolicyPath = dir + "/"
+		if additional {
+			cfg.Edge.AdditionalArchs = []string{other}
+		} else {
+			errHandled, _, _ := UpdateConfigstate(context.Background(), cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, cfg)
+			if !errHandled {
+				t.Errorf("the node should not be configured without any service")
+			} else if apiErr, ok := myError.(*APIUserInputError); !ok || apiErr.Code != ERR_NO_SERVICES_FOR_ARCH || !strings.Contains(apiErr.Error(), "its services are for "+other) {
+				t.Errorf("the error should have its code and list the archs of the pattern, got (%T) %v", myError, myError)
+			}
+			allowEmpty := true
+			cs.AllowEmpty = &allowEmpty
+		}
+
+		errHandled, out, msgs := UpdateConfigstate(context.Background(), cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, cfg)
+
+		if errHandled {
+			t.Errorf("unexpected error %v", myError)
+		} else if out == nil || *out.State != persistence.CONFIGSTATE_CONFIGURED {
+			t.Errorf("the node should be configured, is %v", out)
+		} else if additional && len(msgs) != 2 {
+			t.Errorf("the %v services should be configured, received %v messages", other, len(msgs))
+		} else if !additional && len(msgs) != 1 {
+			t.Errorf("the %v services should be skipped, received %v messages", other, len(msgs))
+		}
+
+		if pms, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter(), persistence.UrlOrgMSFilter(sref.ServiceURL, myOrg)}); err != nil {
+			t.Errorf("failed to read service definitions, error %v", err)
+		} else if additional && (len(pms) != 1 || pms[0].Arch != other) {
+			t.Errorf("the %v service should be registered, found %v", other, pms)
+		} else if !additional && len(pms) != 0 {
+			t.Errorf("no service should be registered, found %v", pms)
+		}
+
+		if additional {
+			if out, err := FindConfigstateForOutput(db, cfg); err != nil {
+				t.Errorf("failed to find device in db, error %v", err)
+			} else if len(out.Archs) != 2 || out.Archs[1] != other {
+				t.Errorf("the archs should include %v, found: %v", other, out.Archs)
+			}
+		}
+
+		cleanTestDir(dir)
+	}
+}
+
+// the services created by a failed autoconfig are removed, the ones registered before are kept
+func Test_UpdateConfigstate_rollback(t *testing.T) {
+
+	for _, preregistered := range []bool{false, true} {
+
+		dir, db, err := utsetup()
+		if err != nil {
+			t.Error(err)
+		}
+
+		cs := getBasicConfigstate()
+		state := persistence.CONFIGSTATE_CONFIGURED
+		cs.State = &state
+
+		var myError error
+		errorhandler := GetPassThroughErrorHandler(&myError)
+
+		theOrg := "myorg"
+		thePattern := "apattern"
+
+		_, err = persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "device", false, theOrg, thePattern, persistence.CONFIGSTATE_CONFIGURING)
+		if err != nil {
+			t.Errorf("failed to create persisted device, error %v", err)
+		}
+
+		mURL := "http://utest.com/mservice"
+		if preregistered {
+			if err := persistence.SaveOrUpdateMicroserviceDef(db, &persistence.MicroserviceDefinition{SpecRef: mURL, Org: theOrg, Version: "1.0.0"}); err != nil {
+				t.Errorf("failed to save service definition, error %v", err)
+			}
+		}
+
+		sr := exchange.ServiceReference{
+			ServiceURL:      "http://mydomain.com/workload/test1",
+			ServiceOrg:      "testorg",
+			ServiceArch:     "amd64",
+			ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}},
+		}
+
+		// the dependent service is created, the top-level service passes the user input check but cannot be read from
+		// the exchange when it is created
+		okHandler := getVariableServiceHandler(exchange.UserInput{Name: "var", Label: "label", Type: "string", DefaultValue: "value"})
+		topLevelReads := 0
+		sHandler := func(url string, org string, version string, arch string) (*exchange.ServiceDefinition, string, error) {
+			if url == sr.ServiceURL {
+				if topLevelReads++; topLevelReads > 1 {
+					return nil, "", fmt.Errorf("service %v is not readable", url)
+				}
+			}
+			return okHandler(url, org, version, arch)
+		}
+		patternHandler := getVariablePatternHandler(sr)
+		sResolver := getVariableServiceDefResolver(mURL, theOrg, "1.0.0", "amd64", nil)
+
+		cfg := getBasicConfig()
+		cfg.Edge.PolicyPath = dir + "/"
+
+		errHandled, out, msgs := UpdateConfigstate(context.Background(), cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, cfg)
+
+		if !errHandled {
+			t.Errorf("expected error")
+		} else if _, ok := myError.(*MultiServiceConfigError); !ok {
+			t.Errorf("myError has the wrong type (%T)", myError)
+		} else if out != nil {
+			t.Errorf("configstate should not be returned")
+		} else if len(msgs) != 0 {
+			t.Errorf("no policy message should be returned, received %v", msgs)
+		}
+
+		if pms, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter(), persistence.UrlOrgMSFilter(mURL, theOrg)}); err != nil {
+			t.Errorf("failed to read service definitions, error %v", err)
+		} else if preregistered && len(pms) != 1 {
+			t.Errorf("the service registered before should be kept, found %v", pms)
+		} else if !preregistered && len(pms) != 0 {
+			t.Errorf("the service created by the autoconfig should be removed, found %v", pms)
+		}
+
+		if files, err := filepath.Glob(path.Join(dir, theOrg, "*.policy")); err != nil {
+			t.Errorf("failed to list the policy files, error %v", err)
+		} else if len(files) != 0 {
+			t.Errorf("the policy files of the autoconfig should be removed, found %v", files)
+		}
+
+		if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
+			t.Errorf("failed to read device, error %v", err)
+		} else if pDevice.Config.State != persistence.CONFIGSTATE_CONFIGURING {
+			t.Errorf("the node should still be configuring, is %v", pDevice.Config.State)
+		}
+
+		cleanTestDir(dir)
+	}
+}
+
+// the attributes saved with a service that the autoconfig created are removed by the rollback, the ones saved before
+// are kept
+func Test_autoconfigRollback_attributes(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	theOrg := "myorg"
+	mURL := "http://utest.com/mservice"
+	pDevice, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "device", false, theOrg, "apattern", persistence.CONFIGSTATE_CONFIGURING)
+	if err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	saveAttribute := func(label string) string {
+		sps := new(persistence.ServiceSpecs)
+		sps.AppendServiceSpec(persistence.ServiceSpec{Url: mURL, Org: theOrg})
+		attr, err := persistence.SaveOrUpdateAttribute(db, &persistence.RestartPolicyAttributes{Meta: &persistence.AttributeMeta{Label: label, Type: "RestartPolicyAttributes"}, ServiceSpecs: sps, Policy: "always"}, "", false)
+		if err != nil {
+			t.Fatalf("failed to save attribute %v, error %v", label, err)
+		}
+		return (*attr).GetMeta().Id
+	}
+
+	kept := saveAttribute("before")
+	before, err := serviceAttributeIds(db, mURL, theOrg)
+	if err != nil {
+		t.Errorf("failed to read the attributes, error %v", err)
+	}
+	created := saveAttribute("created")
+
+	r := new(autoconfigRollback)
+	if err := r.addCreated(db, mURL, theOrg, []persistence.MicroserviceDefinition{}, before); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if len(r.attributes) != 1 || r.attributes[0] != created {
+		t.Errorf("only attribute %v should be recorded, are %v", created, r.attributes)
+	}
+
+	r.rollback(db, pDevice)
+
+	if attr, err := persistence.FindAttributeByKey(db, created); err != nil {
+		t.Errorf("failed to read attribute, error %v", err)
+	} else if attr != nil && *attr != nil {
+		t.Errorf("the attribute of the created service should be removed, found %v", *attr)
+	} else if attr, err := persistence.FindAttributeByKey(db, kept); err != nil {
+		t.Errorf("failed to read attribute, error %v", err)
+	} else if attr == nil || *attr == nil {
+		t.Errorf("the attribute saved before should be kept")
+	}
+}
+
+// a dry run reports the services that would be registered and the ones missing user input, without registering them
+func Test_UpdateConfigstate_dryrun(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+
+	theOrg := "myorg"
+	_, err = persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "device", false, theOrg, "apattern", persistence.CONFIGSTATE_CONFIGURING)
+	if err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	missingVarName := "missingVar"
+	ui := exchange.UserInput{
+		Name:         missingVarName,
+		Label:        "label",
+		Type:         "string",
+		DefaultValue: "",
+	}
+	mURL := "http://utest.com/mservice"
+	sr := exchange.ServiceReference{
+		ServiceURL:      "http://mydomain.com/workload/test1",
+		ServiceOrg:      "testorg",
+		ServiceArch:     cutil.ArchString(),
+		ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}},
+	}
+
+	cs := getBasicConfigstate()
+	state := persistence.CONFIGSTATE_CONFIGURED
+	dryRun := true
+	cs.State = &state
+	cs.DryRun = &dryRun
+
+	sResolver := getVariableServiceDefResolver(mURL, theOrg, "1.0.0", cutil.ArchString(), &ui)
+	errHandled, out, msgs := UpdateConfigstate(context.Background(), cs, errorhandler, getVariablePatternHandler(sr), sResolver, getVariableServiceHandler(ui), getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+
+	if errHandled {
+		t.Errorf("unexpected error %v", myError)
+	} else if *out.State != persistence.CONFIGSTATE_CONFIGURING {
+		t.Errorf("the state should not change, is %v", *out.State)
+	} else if len(msgs) != 0 {
+		t.Errorf("there should be no messages, received %v", len(msgs))
+	} else if out.Services == nil || len(*out.Services) != 2 {
+		t.Errorf("there should be 2 services, the dependent and the top-level service, found %v", out.Services)
+	} else {
+		if svc := (*out.Services)[0]; svc.Url != mURL || svc.Org != theOrg || svc.Registered {
+			t.Errorf("the first service should be the dependent service, is %v", svc)
+		}
+		if svc := (*out.Services)[1]; svc.Url != sr.ServiceURL || svc.Org != sr.ServiceOrg || svc.Version != "[0.0.0,INFINITY)" {
+			t.Errorf("the second service should be the top-level service, is %v", svc)
+		}
+		for _, svc := range *out.Services {
+			if !strings.Contains(svc.MissingConfig, missingVarName) {
+				t.Errorf("the service should be missing %v, is %v", missingVarName, svc)
+			}
+		}
+	}
+
+	if msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{}); err != nil {
+		t.Errorf("failed to read services, error %v", err)
+	} else if len(msdefs) != 0 {
+		t.Errorf("no service should be registered, found %v", msdefs)
+	}
+
+	if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
+		t.Errorf("failed to read device, error %v", err)
+	} else if pDevice.Config.State != persistence.CONFIGSTATE_CONFIGURING {
+		t.Errorf("the persisted state should not change, is %v", pDevice.Config.State)
+	}
+}
+
+// change state from configured back to configuring, the agreements are cancelled and the autoconfig services removed
+func Test_UpdateConfigstate_unconfigure(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+
+	_, err = persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "device", false, "myorg", "apattern", persistence.CONFIGSTATE_CONFIGURED)
+	if err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	// agreement1 is active, agreement2 is already terminating
+	sps := []persistence.ServiceSpec{{Url: "http://sensor.org", Org: "myorg"}}
+	wi, _ := persistence.NewWorkloadInfo("url", "org", "version", "")
+	if _, err := persistence.NewEstablishedAgreement(db, "name1", "agreementId1", "consumerId", "{}", "Basic", 1, sps, "signature", "address", "bcType", "bcName", "bcOrg", wi, 180); err != nil {
+		t.Errorf("failed to create agreement, error %v", err)
+	} else if _, err := persistence.NewEstablishedAgreement(db, "name1", "agreementId2", "consumerId", "{}", "Basic", 1, sps, "signature", "address", "bcType", "bcName", "bcOrg", wi, 180); err != nil {
+		t.Errorf("failed to create agreement, error %v", err)
+	} else if _, err := persistence.AgreementStateTerminated(db, "agreementId2", 100, "unit test termination", "Basic"); err != nil {
+		t.Errorf("failed to terminate agreement, error %v", err)
+	}
+
+	// the service created by the autoconfig is removed, the one registered by the user is kept
+	if err := persistence.SaveOrUpdateMicroserviceDef(db, &persistence.MicroserviceDefinition{SpecRef: "http://utest.com/mservice", Org: "myorg", Version: "1.0.0", Origin: events.POLICY_ORIGIN_AUTOCONFIG, OriginPattern: "myorg/apattern"}); err != nil {
+		t.Errorf("failed to create service, error %v", err)
+	} else if err := persistence.SaveOrUpdateMicroserviceDef(db, &persistence.MicroserviceDefinition{SpecRef: "http://utest.com/userservice", Org: "myorg", Version: "1.0.0"}); err != nil {
+		t.Errorf("failed to create service, error %v", err)
+	}
+
+	cfg := getBasicConfig()
+	cfg.Edge.PolicyPath = dir
+
+	// the cancellation is published while the node is still configured, it is reported as unconfiguring
+	published := []events.Message{}
+	SetConfigstateTeardownPublisher(func(msg events.Message) {
+		if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
+			t.Errorf("failed to read device, error %v", err)
+		} else if pDevice.Config.State != persistence.CONFIGSTATE_CONFIGURED {
+			t.Errorf("the cancellation should be published before the state changes, the state is %v", pDevice.Config.State)
+		}
+		if out, err := FindConfigstateForOutput(db, cfg); err != nil {
+			t.Errorf("failed to read the configstate, error %v", err)
+		} else if *out.State != persistence.CONFIGSTATE_UNCONFIGURING || out.LastUpdateTime == nil || *out.LastUpdateTime == 0 {
+			t.Errorf("the node should be reported unconfiguring, is %v", out)
+		}
+		published = append(published, msg)
+	})
+	defer SetConfigstateTeardownPublisher(nil)
+
+	cs := getBasicConfigstate()
+	errHandled, out, msgs := UpdateConfigstate(context.Background(), cs, errorhandler, nil, nil, nil, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, cfg)
+
+	if errHandled {
+		t.Errorf("unexpected error %v", myError)
+	} else if out == nil {
+		t.Errorf("no configstate returned")
+	} else if *out.State != persistence.CONFIGSTATE_CONFIGURING {
+		t.Errorf("wrong state field %v", *out)
+	} else if len(published) != 1 {
+		t.Errorf("there should be 1 cancellation, the terminating agreement is skipped, received %v", len(published))
+	} else if msg, ok := published[0].(*events.ApiAgreementCancelationMessage); !ok {
+		t.Errorf("the message has the wrong type (%T)", published[0])
+	} else if msg.AgreementId != "agreementId1" {
+		t.Errorf("the wrong agreement is cancelled: %v", msg.AgreementId)
+	} else if len(msgs) != 1 {
+		t.Errorf("there should be 1 message, the config state change, received %v", len(msgs))
+	} else if changed, ok := msgs[0].(*events.ConfigstateChangedMessage); !ok {
+		t.Errorf("the last message has the wrong type (%T)", msgs[0])
+	} else if changed.OldState != persistence.CONFIGSTATE_CONFIGURED || changed.NewState != persistence.CONFIGSTATE_CONFIGURING {
+		t.Errorf("the config state change is wrong: %v", changed)
+	}
+
+	if msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()}); err != nil {
+		t.Errorf("failed to read services, error %v", err)
+	} else if len(msdefs) != 1 || msdefs[0].SpecRef != "http://utest.com/userservice" {
+		t.Errorf("only the autoconfig service should be removed, found %v", msdefs)
+	}
+
+	// the node can be set back to configured without the config state changing again
+	if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
+		t.Errorf("failed to read device, error %v", err)
+	} else if pDevice.Config.State != persistence.CONFIGSTATE_CONFIGURING {
+		t.Errorf("the persisted state should be configuring, is %v", pDevice.Config.State)
+	}
+}
+
+// a node left unconfiguring by an agent that stopped during the teardown can be changed to configuring, unless it is
+// unconfiguring for a pattern change
+func Test_UpdateConfigstate_unconfiguring_recovery(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+
+	_, err = persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "device", false, "myorg", "apattern", persistence.CONFIGSTATE_UNCONFIGURING)
+	if err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	} else if err := persistence.SaveNodeExchPattern(db, "myorg/newpattern"); err != nil {
+		t.Errorf("failed to save the pattern change, error %v", err)
+	}
+
+	cfg := getBasicConfig()
+	cfg.Edge.PolicyPath = dir
+
+	errHandled, out, _ := UpdateConfigstate(context.Background(), getBasicConfigstate(), errorhandler, nil, nil, nil, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, cfg)
+	if !errHandled {
+		t.Errorf("expected error")
+	} else if apiErr, ok := myError.(*APIUserInputError); !ok || apiErr.Code != ERR_INVALID_STATE_TRANSITION {
+		t.Errorf("wrong error (%T) %v", myError, myError)
+	} else if out != nil {
+		t.Errorf("configstate should not be returned")
+	}
+
+	if err := persistence.DeleteNodeExchPattern(db); err != nil {
+		t.Errorf("failed to delete the pattern change, error %v", err)
+	}
+
+	errHandled, out, msgs := UpdateConfigstate(context.Background(), getBasicConfigstate(), errorhandler, nil, nil, nil, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, cfg)
+	if errHandled {
+		t.Errorf("unexpected error %v", myError)
+	} else if *out.State != persistence.CONFIGSTATE_CONFIGURING {
+		t.Errorf("wrong state field %v", *out)
+	} else if len(msgs) != 1 {
+		t.Errorf("there should be 1 message, the config state change, received %v", len(msgs))
+	} else if changed, ok := msgs[0].(*events.ConfigstateChangedMessage); !ok || changed.OldState != persistence.CONFIGSTATE_UNCONFIGURING {
+		t.Errorf("wrong config state change %v", msgs[0])
+	}
+}
+
+// the unconfiguring state is set by the agent only
+func Test_UpdateConfigstate_unconfiguring_rejected(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+
+	_, err = persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "device", false, "myorg", "apattern", persistence.CONFIGSTATE_CONFIGURED)
+	if err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	cs := getBasicConfigstate()
+	state := persistence.CONFIGSTATE_UNCONFIGURING
+	cs.State = &state
+	errHandled, out, _ := UpdateConfigstate(context.Background(), cs, errorhandler, nil, nil, nil, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+
+	if !errHandled {
+		t.Errorf("expected error")
+	} else if apiErr, ok := myError.(*APIUserInputError); !ok {
+		t.Errorf("myError has the wrong type (%T)", myError)
+	} else if apiErr.Input != "configstate.state" {
+		t.Errorf("wrong error input field %v", *apiErr)
+	} else if out != nil {
+		t.Errorf("configstate should not be returned")
+	}
+
+	if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
+		t.Errorf("failed to read device, error %v", err)
+	} else if pDevice.Config.State != persistence.CONFIGSTATE_CONFIGURED {
+		t.Errorf("the persisted state should not change, is %v", pDevice.Config.State)
+	}
+}
+
+// force the change from configured back to configuring, the workloads of the terminating agreements are removed
+func Test_UpdateConfigstate_unconfigure_force(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+
+	_, err = persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "device", false, "myorg", "", persistence.CONFIGSTATE_CONFIGURED)
+	if err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	sps := []persistence.ServiceSpec{{Url: "http://sensor.org", Org: "myorg"}}
+	wi, _ := persistence.NewWorkloadInfo("url", "org", "version", "")
+	if _, err := persistence.NewEstablishedAgreement(db, "name1", "agreementId1", "consumerId", "{}", "Basic", 1, sps, "signature", "address", "bcType", "bcName", "bcOrg", wi, 180); err != nil {
+		t.Errorf("failed to create agreement, error %v", err)
+	} else if _, err := persistence.AgreementStateTerminated(db, "agreementId1", 100, "unit test termination", "Basic"); err != nil {
+		t.Errorf("failed to terminate agreement, error %v", err)
+	}
+
+	// the services of a node without a pattern are configured by the user and kept
+	if err := persistence.SaveOrUpdateMicroserviceDef(db, &persistence.MicroserviceDefinition{SpecRef: "http://utest.com/mservice", Org: "myorg", Version: "1.0.0"}); err != nil {
+		t.Errorf("failed to create service, error %v", err)
+	}
+
+	cs := getBasicConfigstate()
+	force := true
+	cs.Force = &force
+	errHandled, out, msgs := UpdateConfigstate(context.Background(), cs, errorhandler, nil, nil, nil, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+
+	if errHandled {
+		t.Errorf("unexpected error %v", myError)
+	} else if *out.State != persistence.CONFIGSTATE_CONFIGURING {
+		t.Errorf("wrong state field %v", *out)
+	} else if len(msgs) != 2 {
+		t.Errorf("there should be 2 messages, 1 cancellation and the config state change, received %v", len(msgs))
+	} else if _, ok := msgs[0].(*events.GovernanceWorkloadCancelationMessage); !ok {
+		t.Errorf("the first message has the wrong type (%T)", msgs[0])
+	} else if _, ok := msgs[1].(*events.ConfigstateChangedMessage); !ok {
+		t.Errorf("the second message has the wrong type (%T)", msgs[1])
+	}
+
+	if ags, err := persistence.FindEstablishedAgreements(db, "Basic", []persistence.EAFilter{persistence.IdEAFilter("agreementId1")}); err != nil {
+		t.Errorf("failed to read agreement, error %v", err)
+	} else if len(ags) != 1 || ags[0].AgreementForceTerminatedTime == 0 {
+		t.Errorf("the agreement should be force terminated: %v", ags)
+	}
+
+	if msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()}); err != nil {
+		t.Errorf("failed to read services, error %v", err)
+	} else if len(msdefs) != 1 {
+		t.Errorf("the services should be kept, found %v", msdefs)
+	}
+}
+
+func getBasicConfigstate() *Configstate {
+	state := persistence.CONFIGSTATE_CONFIGURING
+	cs := &Configstate{
+		State: &state,
+	}
+	return cs
+}
+
+func getSingleOrgHandler(org string, id string, token string) (*exchange.Organization, error) {
+	o := &exchange.Organization{
+		Label:       "label",
+		Description: "desc",
+		LastUpdated: "today",
+	}
+	return o, nil
+}
+
+func Test_getSpecRefsForPattern_concurrent(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	numServices := 8
+	patternHandler := func(org string, pattern string) (map[string]exchange.Pattern, error) {
+		srs := []exchange.ServiceReference{}
+		for i := 0; i < numServices; i++ {
+			srs = append(srs, exchange.ServiceReference{
+				ServiceURL:      fmt.Sprintf("http://mydomain.com/workload/test%v", i),
+				ServiceOrg:      "testorg",
+				ServiceArch:     "amd64",
+				ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}},
+			})
+		}
+		return map[string]exchange.Pattern{fmt.Sprintf("%v/%v", org, pattern): exchange.Pattern{Label: "label", Services: srs}}, nil
+	}
+
+	// The first services take the longest to resolve, so the resolutions complete in the reverse order of the pattern.
+	var inFlight, maxInFlight, calls int32
+	sResolver := func(wUrl string, wOrg string, wVersion string, wArch string) (map[string]exchange.ServiceDefinition, *exchange.ServiceDefinition, string, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		atomic.AddInt32(&calls, 1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+
+		var i int
+		fmt.Sscanf(wUrl, "http://mydomain.com/workload/test%d", &i)
+		time.Sleep(time.Duration(numServices-i) * 5 * time.Millisecond)
+
+		deps := map[string]exchange.ServiceDefinition{
+			fmt.Sprintf("%v/dep%v_1.0.0_%v", wOrg, i, wArch): exchange.ServiceDefinition{URL: fmt.Sprintf("http://mydomain.com/dep%v", i), Version: "1.0.0", Arch: wArch},
+		}
+		return deps, &exchange.ServiceDefinition{URL: wUrl, Version: wVersion, Arch: wArch}, fmt.Sprintf("%v/%v_%v_%v", wOrg, wUrl, wVersion, wArch), nil
+	}
+
+	cfg := getBasicConfig()
+	cfg.Edge.ServiceResolutionConcurrency = 3
+
+	var first []string
+	for run := 0; run < 3; run++ {
+		specs, _, err := getSpecRefsForPattern(context.Background(), persistence.DEVICE_TYPE_DEVICE, "apattern", "myorg", patternHandler, sResolver, db, cfg, nil, nil, "", false, false, nil)
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+			continue
+		}
+
+		refs := []string{}
+		for _, spec := range *specs {
+			refs = append(refs, spec.SpecRef)
+		}
+		if len(refs) != numServices {
+			t.Errorf("there should be %v services, are %v", numServices, refs)
+		} else if first == nil {
+			first = refs
+			for i, ref := range refs {
+				if ref != fmt.Sprintf("http://mydomain.com/dep%v", i) {
+					t.Errorf("the services should be in the order of the pattern, are %v", refs)
+					break
+				}
+			}
+		} else if !reflect.DeepEqual(first, refs) {
+			t.Errorf("the services should be in the same order on each run, are %v and %v", first, refs)
+		}
+	}
+
+	if calls != int32(3*numServices) {
+		t.Errorf("each service should be resolved once per run, there were %v resolutions", calls)
+	}
+	if maxInFlight > 3 {
+		t.Errorf("at most 3 services should be resolved at the same time, there were %v", maxInFlight)
+	}
+}
+
+func Test_resolveServices_cancelled(t *testing.T) {
+
+	calls := 0
+	sResolver := func(wUrl string, wOrg string, wVersion string, wArch string) (map[string]exchange.ServiceDefinition, *exchange.ServiceDefinition, string, error) {
+		calls++
+		return nil, &exchange.ServiceDefinition{}, "", nil
+	}
+
+	resolutions := []*serviceResolution{
+		&serviceResolution{service: exchange.ServiceReference{ServiceURL: "http://mydomain.com/workload/test1"}, version: "1.0.0"},
+		&serviceResolution{service: exchange.ServiceReference{ServiceURL: "http://mydomain.com/workload/test2"}, version: "1.0.0"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	resolved := resolveServices(ctx, resolutions, sResolver, 1, nil)
+	for range resolutions {
+		if res := resolutions[<-resolved]; res.err != context.Canceled || !res.cancelled {
+			t.Errorf("the resolution of %v should be cancelled, the error is %v", res.service.ServiceURL, res.err)
+		}
+	}
+	if calls != 0 {
+		t.Errorf("no service should be resolved after the cancel, %v were", calls)
+	}
+}
+
+// The first resolution that fails stops the one in flight and the ones that have not started.
+func Test_resolveServices_stopOnError(t *testing.T) {
+
+	release := make(chan bool)
+	defer close(release)
+
+	var calls int32
+	sResolver := func(wUrl string, wOrg string, wVersion string, wArch string) (map[string]exchange.ServiceDefinition, *exchange.ServiceDefinition, string, error) {
+		atomic.AddInt32(&calls, 1)
+		if strings.HasSuffix(wUrl, "fails") {
+			time.Sleep(10 * time.Millisecond)
+			return nil, nil, "", fmt.Errorf("service %v not found", wUrl)
+		}
+		// the exchange does not answer
+		<-release
+		return nil, &exchange.ServiceDefinition{}, "", nil
+	}
+
+	resolutions := []*serviceResolution{
+		&serviceResolution{service: exchange.ServiceReference{ServiceURL: "http://mydomain.com/workload/hangs"}, version: "1.0.0"},
+		&serviceResolution{service: exchange.ServiceReference{ServiceURL: "http://mydomain.com/workload/fails"}, version: "1.0.0"},
+		&serviceResolution{service: exchange.ServiceReference{ServiceURL: "http://mydomain.com/workload/later"}, version: "1.0.0"},
+	}
+
+	stopOnError := func(res *serviceResolution) bool { return true }
+	resolved := resolveServices(context.Background(), resolutions, sResolver, 2, stopOnError)
+
+	timeout := time.After(5 * time.Second)
+	for range resolutions {
+		select {
+		case <-resolved:
+		case <-timeout:
+			t.Fatalf("the resolutions should be stopped by the failure")
+		}
+	}
+
+	if res := resolutions[1]; res.err == nil || res.cancelled {
+		t.Errorf("the failed resolution should keep its error, got %v, cancelled %v", res.err, res.cancelled)
+	}
+	for _, i := range []int{0, 2} {
+		if res := resolutions[i]; res.err != context.Canceled || !res.cancelled {
+			t.Errorf("the resolution of %v should be cancelled, the error is %v", res.service.ServiceURL, res.err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("the resolution that had not started should not be made, there were %v resolutions", calls)
+	}
+}
+
+// A required service that cannot be resolved fails the pattern without waiting for the other services, which are not
+// reported.
+func Test_getSpecRefsForPattern_resolutionError(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	patternHandler := func(org string, pattern string) (map[string]exchange.Pattern, error) {
+		srs := []exchange.ServiceReference{}
+		for _, name := range []string{"hangs", "fails"} {
+			srs = append(srs, exchange.ServiceReference{
+				ServiceURL:      "http://mydomain.com/workload/" + name,
+				ServiceOrg:      "testorg",
+				ServiceArch:     "amd64",
+				ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}},
+			})
+		}
+		return map[string]exchange.Pattern{fmt.Sprintf("%v/%v", org, pattern): exchange.Pattern{Label: "label", Services: srs}}, nil
+	}
+
+	release := make(chan bool)
+	defer close(release)
+	sResolver := func(wUrl string, wOrg string, wVersion string, wArch string) (map[string]exchange.ServiceDefinition, *exchange.ServiceDefinition, string, error) {
+		if strings.HasSuffix(wUrl, "fails") {
+			return nil, nil, "", fmt.Errorf("service %v not found", wUrl)
+		}
+		<-release
+		return nil, &exchange.ServiceDefinition{URL: wUrl, Version: wVersion, Arch: wArch}, "", nil
+	}
+
+	start := time.Now()
+	_, _, err = getSpecRefsForPattern(context.Background(), persistence.DEVICE_TYPE_DEVICE, "apattern", "myorg", patternHandler, sResolver, db, getBasicConfig(), nil, nil, "", false, false, nil)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the failure should stop the other resolutions, it took %v", elapsed)
+	}
+	if err == nil {
+		t.Fatalf("the pattern should fail")
+	} else if !strings.Contains(err.Error(), "workload/fails") {
+		t.Errorf("the error should report the service that failed, got %v", err)
+	} else if strings.Contains(err.Error(), "workload/hangs") {
+		t.Errorf("the error should not report the service that was stopped, got %v", err)
+	}
+}
+
+func Test_makeServiceName(t *testing.T) {
+
+	names := map[string]string{}
+	for _, url := range []string{"http://mydomain.com/workload/test", "https://mydomain.com/workload/test", "http://mydomain.com/workload/Test", "http://mydomain.com/workload-test"} {
+		name := makeServiceName(url, "testorg", "[1.0.0,INFINITY)")
+		if other, ok := names[name]; ok {
+			t.Errorf("services %v and %v have the same name %v", other, url, name)
+		}
+		names[name] = url
+		if !strings.HasPrefix(name, "mydomain.com-workload") {
+			t.Errorf("name %v of service %v should start with its URL", name, url)
+		} else if name != strings.ToLower(name) {
+			t.Errorf("name %v of service %v should be lower case", name, url)
+		}
+	}
+
+	// the name does not change when the URL is written differently
+	if n1, n2 := makeServiceName("http://mydomain.com/workload/test", "testorg", "1.0.0"), makeServiceName("HTTP://MyDomain.com/workload/test", "testorg", "1.0.0"); n1 != n2 {
+		t.Errorf("the same service has names %v and %v", n1, n2)
+	}
+
+	long := makeServiceName("http://mydomain.com/"+strings.Repeat("workload/", 20), "testorg", "[1.0.0,INFINITY)")
+	if len(long) > maxServiceNameLen {
+		t.Errorf("name %v is longer than %v", long, maxServiceNameLen)
+	} else if long == makeServiceName("http://mydomain.com/"+strings.Repeat("workload/", 21), "testorg", "[1.0.0,INFINITY)") {
+		t.Errorf("truncated names of different services should not be the same, got %v", long)
+	}
+}
+
+func Test_autoconfigServiceName_legacy(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	url := "http://mydomain.com/workload/test"
+	version := "[0.0.0,INFINITY)"
+
+	if name := autoconfigServiceName(db, url, "testorg", version); name != makeServiceName(url, "testorg", version) {
+		t.Errorf("new service should get the new name, got %v", name)
+	}
+
+	// a service registered by an older agent keeps its name
+	legacyName := legacyServiceName(url, "testorg", version)
+	if legacyName != "mydomain.com-workload-test_testorg_0.0.0-INFINITY" {
+		t.Errorf("unexpected legacy name %v", legacyName)
+	} else if n := legacyServiceName("https://MyDomain.com/Workload/test/", "testorg", version); n != "MyDomain.com-Workload-test_testorg_0.0.0-INFINITY" {
+		t.Errorf("the legacy name should be made from the URL as it was given, got %v", n)
+	}
+	if err := persistence.SaveOrUpdateMicroserviceDef(db, &persistence.MicroserviceDefinition{SpecRef: url, Org: "testorg", Version: "1.0.0", Name: legacyName}); err != nil {
+		t.Errorf("error saving service definition, error %v", err)
+	}
+
+	if name := autoconfigServiceName(db, url, "testorg", version); name != legacyName {
+		t.Errorf("registered service should keep the legacy name %v, got %v", legacyName, name)
+	}
+	if name := autoconfigServiceName(db, url, "otherorg", version); name != makeServiceName(url, "otherorg", version) {
+		t.Errorf("service in another org should get the new name, got %v", name)
+	}
+}
+
+// change state to configured with the services pinned to version ranges
+func Test_UpdateConfigstate_pinned_versions(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	myOrg := "myorg"
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, myOrg, "mypattern", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	sref := exchange.ServiceReference{
+		ServiceURL:      "wurl",
+		ServiceOrg:      myOrg,
+		ServiceArch:     cutil.ArchString(),
+		ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}, {Version: "2.0.0"}},
+	}
+
+	mURL := "http://utest.com/mservice"
+	sResolver := getVariableServiceDefResolver(mURL, myOrg, "1.0.0", cutil.ArchString(), nil)
+	sHandler := getVariableServiceHandler(exchange.UserInput{})
+	patternHandler := getVariablePatternHandler(sref)
+
+	// the pins that cannot be honored are rejected before any service is created
+	for _, versions := range []map[string]string{
+		{"myorg/wurl": "[3.0.0,4.0.0)"},
+		{"myorg/http://utest.com/mservice": "[0.1.0,0.2.0)"},
+		{"myorg/http://utest.com/other": "1.0.0"},
+		{"myorg/wurl": "not-a-version"},
+		{"wurl": "1.0.0"},
+	} {
+		state := persistence.CONFIGSTATE_CONFIGURED
+		cs := &Configstate{State: &state, Versions: versions}
+
+		var myError error
+		errHandled, _, _ := UpdateConfigstate(context.Background(), cs, GetPassThroughErrorHandler(&myError), patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+		if !errHandled {
+			t.Errorf("versions %v should be rejected", versions)
+		} else if uiErr, ok := myError.(*APIUserInputError); !ok {
+			t.Errorf("versions %v should be rejected with an APIUserInputError, got (%T) %v", versions, myError, myError)
+		} else if uiErr.Input != "configstate.versions" {
+			t.Errorf("wrong input %v in error %v", uiErr.Input, uiErr)
+		}
+
+		if msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()}); err != nil {
+			t.Errorf("error reading the service definitions, error %v", err)
+		} else if len(msdefs) != 0 {
+			t.Errorf("no service should be created for versions %v, got %v", versions, msdefs)
+		}
+	}
+
+	state := persistence.CONFIGSTATE_CONFIGURED
+	cs := &Configstate{State: &state, Versions: map[string]string{"myorg/wurl": "2.0.0", "myorg/http://utest.com/mservice": "[1.0.0,2.0.0)"}}
+
+	var myError error
+	errHandled, cfg, _ := UpdateConfigstate(context.Background(), cs, GetPassThroughErrorHandler(&myError), patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+	if errHandled {
+		t.Errorf("unexpected error %v", myError)
+	} else if *cfg.State != persistence.CONFIGSTATE_CONFIGURED {
+		t.Errorf("wrong state field %v", *cfg)
+	}
+
+	expected := map[string]string{"myorg/wurl": "[2.0.0,INFINITY)", "myorg/http://utest.com/mservice": "[1.0.0,2.0.0)"}
+	if out, err := FindConfigstateForOutput(db, getBasicConfig()); err != nil {
+		t.Errorf("error reading the configstate, error %v", err)
+	} else if !reflect.DeepEqual(out.Versions, expected) {
+		t.Errorf("the pinned versions should be %v, got %v", expected, out.Versions)
+	}
+
+	if msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter(), persistence.UrlOrgMSFilter(mURL, myOrg)}); err != nil {
+		t.Errorf("error reading the service definitions, error %v", err)
+	} else if len(msdefs) != 1 {
+		t.Errorf("there should be 1 definition of %v, got %v", mURL, msdefs)
+	} else if msdefs[0].UpgradeVersionRange != "[1.0.0,2.0.0)" {
+		t.Errorf("service %v should be registered with its pinned version range, got %v", mURL, msdefs[0].UpgradeVersionRange)
+	}
+}
+
+// a failed change of the state is kept until the state is changed
+func Test_UpdateConfigstate_last_error(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	myOrg := "myorg"
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, myOrg, "mypattern", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	sref := exchange.ServiceReference{
+		ServiceURL:      "wurl",
+		ServiceOrg:      myOrg,
+		ServiceArch:     cutil.ArchString(),
+		ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}},
+	}
+	sResolver := getVariableServiceDefResolver("http://utest.com/mservice", myOrg, "1.0.0", cutil.ArchString(), nil)
+	sHandler := getVariableServiceHandler(exchange.UserInput{})
+	patternHandler := getVariablePatternHandler(sref)
+
+	updateConfigstate := func(state string) error {
+		var myError error
+		UpdateConfigstate(context.Background(), &Configstate{State: &state}, GetPassThroughErrorHandler(&myError), patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+		return myError
+	}
+
+	if cfg, err := FindConfigstateForOutput(db, getBasicConfig()); err != nil {
+		t.Errorf("failed to find device in db, error %v", err)
+	} else if cfg.LastError != nil {
+		t.Errorf("no change of the state failed yet, got %v", *cfg.LastError)
+	}
+
+	if err := updateConfigstate(persistence.CONFIGSTATE_UNCONFIGURED); err == nil {
+		t.Errorf("the state %v should not be settable", persistence.CONFIGSTATE_UNCONFIGURED)
+	}
+
+	if cfg, err := FindConfigstateForOutput(db, getBasicConfig()); err != nil {
+		t.Errorf("failed to find device in db, error %v", err)
+	} else if cfg.LastError == nil {
+		t.Errorf("the failed change of the state should be recorded")
+	} else if cfg.LastError.RequestedState != persistence.CONFIGSTATE_UNCONFIGURED || cfg.LastError.Category != persistence.CONFIGSTATE_FAILURE_INPUT || cfg.LastError.Message == "" || cfg.LastError.Timestamp == 0 {
+		t.Errorf("wrong failed change of the state %v", *cfg.LastError)
+	}
+
+	if err := updateConfigstate(persistence.CONFIGSTATE_CONFIGURED); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if cfg, err := FindConfigstateForOutput(db, getBasicConfig()); err != nil {
+		t.Errorf("failed to find device in db, error %v", err)
+	} else if *cfg.State != persistence.CONFIGSTATE_CONFIGURED {
+		t.Errorf("wrong state %v", *cfg.State)
+	} else if cfg.LastError != nil {
+		t.Errorf("the failed change of the state should be cleared, got %v", *cfg.LastError)
+	}
+}
+
+// change state to configured with an effective time in the future - the services are configured but the node waits in
+// configured_pending, then a PUT without an effective time configures it right away
+func Test_UpdateConfigstate_effective_time(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	cs := getBasicConfigstate()
+	state := persistence.CONFIGSTATE_CONFIGURED
+	cs.State = &state
+	effectiveTime := uint64(time.Now().Unix()) + 3600
+	cs.EffectiveTime = &effectiveTime
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+
+	myOrg := "myorg"
+	myPattern := "mypattern"
+
+	_, err = persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, myOrg, myPattern, persistence.CONFIGSTATE_CONFIGURING)
+	if err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	sref := exchange.ServiceReference{
+		ServiceURL:      "wurl",
+		ServiceOrg:      myOrg,
+		ServiceArch:     cutil.ArchString(),
+		ServiceVersions: []exchange.WorkloadChoice{exchange.WorkloadChoice{Version: "1.0.0"}},
+	}
+
+	mURL := "http://utest.com/mservice"
+	sResolver := getVariableServiceDefResolver(mURL, myOrg, "1.0.0", cutil.ArchString(), nil)
+	sHandler := getVariableServiceHandler(exchange.UserInput{})
+	patternHandler := getVariablePatternHandler(sref)
+
+	errHandled, cfg, msgs := UpdateConfigstate(context.Background(), cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+
+	if errHandled {
+		t.Errorf("unexpected error %v", myError)
+	} else if cfg == nil || *cfg.State != persistence.CONFIGSTATE_CONFIGURED_PENDING {
+		t.Errorf("the node should be configured_pending, is %v", cfg)
+	} else if cfg.EffectiveTime == nil || *cfg.EffectiveTime != effectiveTime {
+		t.Errorf("the effective time should be %v, is %v", effectiveTime, cfg.EffectiveTime)
+	} else if len(msgs) != 1 {
+		t.Errorf("there should only be the config state change message, the policies are advertised at the effective time, received %v", msgs)
+	} else if pms, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()}); err != nil {
+		t.Errorf("failed to read services, error %v", err)
+	} else if len(pms) != 2 {
+		t.Errorf("the services should be configured, found %v", pms)
+	}
+
+	// the effective time is not reached yet
+	if out, msgs, err := CompletePendingConfigstate(db); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if out == nil || *out.State != persistence.CONFIGSTATE_CONFIGURED_PENDING || len(msgs) != 0 {
+		t.Errorf("the node should still be configured_pending, is %v, messages %v", out, msgs)
+	}
+
+	// the output of GET has the pending state and the effective time
+	if out, err := FindConfigstateForOutput(db, getBasicConfig()); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if *out.State != persistence.CONFIGSTATE_CONFIGURED_PENDING || out.EffectiveTime == nil || *out.EffectiveTime != effectiveTime {
+		t.Errorf("the output should have the pending state and the effective time, is %v", out)
+	}
+
+	// a PUT without an effective time configures the node now
+	cs.EffectiveTime = nil
+	errHandled, cfg, msgs = UpdateConfigstate(context.Background(), cs, errorhandler, patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+
+	if errHandled {
+		t.Errorf("unexpected error %v", myError)
+	} else if cfg == nil || *cfg.State != persistence.CONFIGSTATE_CONFIGURED || cfg.EffectiveTime != nil {
+		t.Errorf("the node should be configured without an effective time, is %v", cfg)
+	} else if len(msgs) != 2 {
+		t.Errorf("there should be 2 messages, the policies and the config state change, received %v", msgs)
+	} else if pols, ok := msgs[0].(*events.PoliciesCreatedMessage); !ok || len(pols.PolicyFiles()) != 2 {
+		t.Errorf("the policies of the 2 services should be advertised, received %v", msgs[0])
+	} else if changed, ok := msgs[1].(*events.ConfigstateChangedMessage); !ok || changed.OldState != persistence.CONFIGSTATE_CONFIGURED_PENDING {
+		t.Errorf("the config state change should be from configured_pending, received %v", msgs[1])
+	}
+
+	if dev, err := persistence.FindExchangeDevice(db); err != nil {
+		t.Errorf("failed to read the node, error %v", err)
+	} else if dev.Config.EffectiveTime != 0 || len(dev.Config.PendingPolicies) != 0 {
+		t.Errorf("the pending configuration should be cleared, is %v", dev.Config)
+	}
+}
+
+// an effective time in the past configures the node right away
+func Test_UpdateConfigstate_effective_time_past(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	cs := getBasicConfigstate()
+	state := persistence.CONFIGSTATE_CONFIGURED
+	cs.State = &state
+	effectiveTime := uint64(time.Now().Unix()) - 60
+	cs.EffectiveTime = &effectiveTime
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+
+	_, err = persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, "myorg", "mypattern", persistence.CONFIGSTATE_CONFIGURING)
+	if err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	sref := exchange.ServiceReference{
+		ServiceURL:      "wurl",
+		ServiceOrg:      "myorg",
+		ServiceArch:     cutil.ArchString(),
+		ServiceVersions: []exchange.WorkloadChoice{exchange.WorkloadChoice{Version: "1.0.0"}},
+	}
+
+	sResolver := getVariableServiceDefResolver("http://utest.com/mservice", "myorg", "1.0.0", cutil.ArchString(), nil)
+	errHandled, cfg, msgs := UpdateConfigstate(context.Background(), cs, errorhandler, getVariablePatternHandler(sref), sResolver, getVariableServiceHandler(exchange.UserInput{}), getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+
+	if errHandled {
+		t.Errorf("unexpected error %v", myError)
+	} else if cfg == nil || *cfg.State != persistence.CONFIGSTATE_CONFIGURED || cfg.EffectiveTime != nil {
+		t.Errorf("the node should be configured, is %v", cfg)
+	} else if len(msgs) != 2 {
+		t.Errorf("there should be 2 messages, the policies and the config state change, received %v", msgs)
 	}
-	return o, nil
 }
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/persistence"
+)
+
+// The database of the agent, as returned by GET /node/db.
+type NodeDatabase struct {
+	persistence.DatabaseStats
+	CompactionRequested bool                            `json:"compaction_requested"` // the file is compacted the next time the agent starts
+	LastCompaction      *persistence.DatabaseCompaction `json:"last_compaction,omitempty"`
+}
+
+// The compaction of the database requested with POST /node/db/compaction, it is made the next time the agent starts.
+type DatabaseCompactionRequest struct {
+	Size        int64 `json:"size"`
+	Reclaimable int64 `json:"reclaimable"` // estimated from the free pages of the database
+}
+
+func (d DatabaseCompactionRequest) String() string {
+	return fmt.Sprintf("Size: %v, Reclaimable: %v", d.Size, d.Reclaimable)
+}
+
+// Returns the stats of the database, with its pending and its last compaction.
+func FindDatabaseForOutput(db *bolt.DB) (*NodeDatabase, error) {
+	stats, err := persistence.GetDatabaseStats(db)
+	if err != nil {
+		return nil, NewSystemError(err.Error())
+	}
+	out := &NodeDatabase{DatabaseStats: *stats}
+
+	if out.CompactionRequested, err = persistence.DatabaseCompactionRequested(db); err != nil {
+		return nil, NewSystemError(fmt.Sprintf("Unable to read the compaction request of the database, error %v", err))
+	} else if out.LastCompaction, err = persistence.FindLastDatabaseCompaction(db); err != nil {
+		return nil, NewSystemError(fmt.Sprintf("Unable to read the last compaction of the database, error %v", err))
+	}
+	return out, nil
+}
+
+// Request the compaction of the database. The agent holds the database open and every worker uses it, so the file
+// cannot be replaced while the agent runs: it is compacted the next time the agent starts, before it is opened, see
+// persistence.CompactDatabaseFile. The space the compaction reclaims is estimated from the pages of the database that
+// are free, nothing is copied. The space it reclaimed is in the last compaction of the database.
+func RequestNodeDatabaseCompaction(db *bolt.DB) (*DatabaseCompactionRequest, error) {
+	stats, err := persistence.GetDatabaseStats(db)
+	if err != nil {
+		return nil, NewSystemError(err.Error())
+	}
+
+	if err := persistence.RequestDatabaseCompaction(db); err != nil {
+		return nil, NewSystemError(fmt.Sprintf("Unable to request the compaction of the database, error %v", err))
+	}
+
+	request := &DatabaseCompactionRequest{Size: stats.Size}
+	if reclaimable := int64(stats.FreePages+stats.PendingPages) * int64(db.Info().PageSize); reclaimable < stats.Size {
+		request.Reclaimable = reclaimable
+	}
+	glog.V(3).Infof(apiLogString(fmt.Sprintf("Requested the compaction of the database: %v", request)))
+	return request, nil
+}
@@ -0,0 +1,43 @@
+// +build unit
+
+package api
+
+import (
+	"testing"
+
+	"github.com/open-horizon/anax/persistence"
+)
+
+// The compaction is requested for the next start, the database file is left as it is.
+func Test_RequestNodeDatabaseCompaction(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanTestDir(dir)
+
+	wi, _ := persistence.NewWorkloadInfo("url", "org", "version", "")
+	sps := []persistence.ServiceSpec{{Url: "http://sensor.org", Org: "myorg"}}
+	if _, err := persistence.NewEstablishedAgreement(db, "name1", "agreementId1", "consumerId", "{}", "Basic", 1, sps, "signature", "address", "bcType", "bcName", "bcOrg", wi, 180); err != nil {
+		t.Fatalf("error writing agreement1: %v", err)
+	}
+
+	before, err := persistence.GetDatabaseStats(db)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	request, err := RequestNodeDatabaseCompaction(db)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if request.Size != before.Size || request.Reclaimable < 0 || request.Reclaimable >= request.Size {
+		t.Errorf("wrong request %v for a database of %v bytes", request, before.Size)
+	}
+
+	if out, err := FindDatabaseForOutput(db); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if !out.CompactionRequested || out.LastCompaction != nil || len(out.Buckets) == 0 {
+		t.Errorf("the compaction should be requested, got %v", out)
+	}
+}
@@ -0,0 +1,539 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/eventlog"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/exchangesync"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"sort"
+)
+
+// The version of the document of GET /node/export, an import rejects the documents of the other versions.
+const NODE_EXPORT_VERSION = 1
+
+// The node of an exported configuration, without its token. The import checks that it is imported into the same kind
+// of node, the id and name of the node may differ.
+type ExportNode struct {
+	Id       string `json:"id"`
+	Org      string `json:"organization"`
+	Pattern  string `json:"pattern"` // org/pattern, empty for a policy node
+	Name     string `json:"name"`
+	NodeType string `json:"nodeType"`
+	HA       bool   `json:"ha"`
+}
+
+// The configuration of a node as a single document, see GET /node/export and POST /node/import. The secrets of the
+// attributes are not exported, Secrets has their keys with empty values, and an import requires them all to be set.
+type NodeExport struct {
+	Version     int                `json:"version"`
+	Node        *ExportNode        `json:"node"`
+	UserInput   []policy.UserInput `json:"userInput,omitempty"` // the node user input, replaces the one of the node on import
+	Attributes  []Attribute        `json:"attributes,omitempty"`
+	Services    []Service          `json:"services,omitempty"`
+	Configstate *Configstate       `json:"configstate,omitempty"`
+	Secrets     map[string]string  `json:"secrets,omitempty"` // the secrets of the attributes, e.g. attributes[0].mappings.password
+}
+
+// Return the configuration of the node, without its secrets.
+func FindNodeExportForOutput(db *bolt.DB) (*NodeExport, error) {
+
+	pDevice, err := persistence.FindExchangeDevice(db)
+	if err != nil {
+		return nil, NewSystemError(fmt.Sprintf("Unable to read node object, error %v", err)).WithCode(ERR_DATABASE)
+	} else if pDevice == nil {
+		return nil, NewAPIUserInputError("The node is not registered, there is no configuration to export.", "node").WithCode(ERR_NODE_NOT_REGISTERED)
+	}
+
+	_, _, pattern := persistence.GetFormatedPatternString(pDevice.Pattern, pDevice.Org)
+	doc := &NodeExport{
+		Version: NODE_EXPORT_VERSION,
+		Node: &ExportNode{
+			Id:       pDevice.Id,
+			Org:      pDevice.Org,
+			Pattern:  pattern,
+			Name:     pDevice.Name,
+			NodeType: pDevice.GetNodeType(),
+			HA:       pDevice.HA,
+		},
+	}
+
+	if doc.UserInput, err = persistence.FindNodeUserInput(db); err != nil {
+		return nil, NewSystemError(fmt.Sprintf("Unable to read the node user input, error %v", err)).WithCode(ERR_DATABASE)
+	}
+
+	attrs, err := persistence.FindApplicableAttributes(db, "", "")
+	if err != nil {
+		return nil, NewSystemError(fmt.Sprintf("Unable to read the attributes, error %v", err)).WithCode(ERR_DATABASE)
+	}
+	for _, attr := range attrs {
+		doc.Attributes = append(doc.Attributes, exportAttribute(attr))
+	}
+
+	msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()})
+	if err != nil {
+		return nil, NewSystemError(fmt.Sprintf("Unable to read the services, error %v", err)).WithCode(ERR_DATABASE)
+	}
+	for _, msdef := range msdefs {
+		url, org, name, arch, versionRange := msdef.SpecRef, msdef.Org, msdef.Name, msdef.RequestedArch, msdef.UpgradeVersionRange
+		autoUpgrade, activeUpgrade := msdef.AutoUpgrade, msdef.ActiveUpgrade
+		doc.Services = append(doc.Services, Service{
+			Url:           &url,
+			Org:           &org,
+			Name:          &name,
+			Arch:          &arch,
+			VersionRange:  &versionRange,
+			AutoUpgrade:   &autoUpgrade,
+			ActiveUpgrade: &activeUpgrade,
+		})
+	}
+
+	// A configured_pending node is exported as configured, without its effective time.
+	state := pDevice.Config.State
+	if state == persistence.CONFIGSTATE_CONFIGURED_PENDING {
+		state = persistence.CONFIGSTATE_CONFIGURED
+	} else if state != persistence.CONFIGSTATE_CONFIGURED {
+		state = persistence.CONFIGSTATE_CONFIGURING
+	}
+	doc.Configstate = &Configstate{State: &state}
+	if state == persistence.CONFIGSTATE_CONFIGURED {
+		doc.Configstate.Versions = pDevice.Config.Versions
+	}
+	if len(pDevice.Config.Excluded) != 0 {
+		excluded := pDevice.Config.Excluded
+		doc.Configstate.ExcludedServices = &excluded
+	}
+	if pDevice.Config.Channel != "" {
+		channel := pDevice.Config.Channel
+		doc.Configstate.Channel = &channel
+	}
+	if len(pDevice.Config.Optional) != 0 {
+		optional := pDevice.Config.Optional
+		doc.Configstate.OptionalServices = &optional
+	}
+
+	for _, secret := range attributeSecrets(doc.Attributes) {
+		if doc.Secrets == nil {
+			doc.Secrets = map[string]string{}
+		}
+		doc.Secrets[secret.key] = ""
+	}
+
+	return doc, nil
+}
+
+// Returns the attribute as exported, without its id and secrets.
+func exportAttribute(attr persistence.Attribute) Attribute {
+	out := toOutModel(attr)
+	out.Id = nil
+
+	mappings := *out.Mappings
+	switch ra := attr.(type) {
+	case persistence.HTTPSBasicAuthAttributes, *persistence.HTTPSBasicAuthAttributes:
+		delete(mappings, "password")
+	case persistence.DockerRegistryAuthAttributes:
+		mappings["auths"] = exportAuths(ra.Auths)
+	case *persistence.DockerRegistryAuthAttributes:
+		mappings["auths"] = exportAuths(ra.Auths)
+	}
+	return *out
+}
+
+func exportAuths(auths []persistence.Auth) []interface{} {
+	out := make([]interface{}, 0, len(auths))
+	for _, auth := range auths {
+		out = append(out, map[string]interface{}{"registry": auth.Registry, "username": auth.UserName})
+	}
+	return out
+}
+
+// A secret of an attribute, which the export leaves out and the import requires in the secrets of the document.
+type attributeSecret struct {
+	key   string           // the key of the secret in the secrets of the document
+	given bool             // the mappings of the attribute have the secret, which must not be exported with them
+	set   func(val string) // puts the secret in the mappings of the attribute
+}
+
+// Returns the secrets of the attributes, the password of the basic auth attributes and the token of each registry of
+// the registry auth attributes.
+func attributeSecrets(attrs []Attribute) []attributeSecret {
+	secrets := []attributeSecret{}
+	for i, attr := range attrs {
+		if attr.Type == nil || attr.Mappings == nil {
+			continue
+		}
+		mappings := *attr.Mappings
+
+		switch *attr.Type {
+		case "HTTPSBasicAuthAttributes":
+			_, given := mappings["password"]
+			secrets = append(secrets, attributeSecret{
+				key:   fmt.Sprintf("attributes[%v].mappings.password", i),
+				given: given,
+				set:   func(val string) { mappings["password"] = val },
+			})
+
+		case "DockerRegistryAuthAttributes":
+			auths, _ := mappings["auths"].([]interface{})
+			for j := range auths {
+				auth, ok := auths[j].(map[string]interface{})
+				if !ok {
+					continue
+				}
+				_, given := auth["token"]
+				secrets = append(secrets, attributeSecret{
+					key:   fmt.Sprintf("attributes[%v].mappings.auths[%v].token", i, j),
+					given: given,
+					set:   func(val string) { auth["token"] = val },
+				})
+			}
+		}
+	}
+	return secrets
+}
+
+// The problems of the document that are found before anything is changed: its version, the node it is imported into
+// and its secrets.
+func checkNodeImport(doc *NodeExport, pDevice *persistence.ExchangeDevice) []InputProblem {
+	problems := []InputProblem{}
+
+	if doc.Version != NODE_EXPORT_VERSION {
+		problems = append(problems, NewInputProblem("import.version", NewAPIUserInputError(fmt.Sprintf("the version of the document is %v, only version %v can be imported", doc.Version, NODE_EXPORT_VERSION), "import.version").WithCode(ERR_INVALID_INPUT)))
+	}
+
+	if doc.Node == nil {
+		problems = append(problems, NewInputProblem("import.node", NewAPIUserInputError("the node of the document must be set", "import.node").WithCode(ERR_INVALID_INPUT)))
+	} else {
+		_, _, pattern := persistence.GetFormatedPatternString(pDevice.Pattern, pDevice.Org)
+		_, _, docPattern := persistence.GetFormatedPatternString(doc.Node.Pattern, doc.Node.Org)
+		nodeType := doc.Node.NodeType
+		if nodeType == "" {
+			nodeType = persistence.DEVICE_TYPE_DEVICE
+		}
+
+		mismatch := func(field string, exported interface{}, node interface{}) {
+			input := "import.node." + field
+			problems = append(problems, NewInputProblem(input, NewAPIUserInputError(fmt.Sprintf("the configuration was exported from a node with %v %v, it cannot be imported into a node with %v %v", field, exported, field, node), input).WithCode(ERR_INVALID_INPUT)))
+		}
+		if doc.Node.Org != pDevice.Org {
+			mismatch("organization", doc.Node.Org, pDevice.Org)
+		}
+		if docPattern != pattern {
+			mismatch("pattern", docPattern, pattern)
+		}
+		if nodeType != pDevice.GetNodeType() {
+			mismatch("nodeType", nodeType, pDevice.GetNodeType())
+		}
+		if doc.Node.HA != pDevice.HA {
+			mismatch("ha", doc.Node.HA, pDevice.HA)
+		}
+	}
+
+	// Every secret of the attributes must be set in the secrets, and only there.
+	required := map[string]bool{}
+	for _, secret := range attributeSecrets(doc.Attributes) {
+		required[secret.key] = true
+		input := "import.secrets." + secret.key
+		if secret.given {
+			problems = append(problems, NewInputProblem(input, NewAPIUserInputError(fmt.Sprintf("the secret must be set in the secrets of the document, not in %v", secret.key), input).WithCode(ERR_INVALID_INPUT)))
+		} else if doc.Secrets[secret.key] == "" {
+			problems = append(problems, NewInputProblem(input, NewAPIUserInputError("the secret is not exported, it must be set", input).WithCode(ERR_INVALID_INPUT)))
+		}
+	}
+	unknown := []string{}
+	for key := range doc.Secrets {
+		if !required[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		input := "import.secrets." + key
+		problems = append(problems, NewInputProblem(input, NewAPIUserInputError("none of the attributes has this secret", input).WithCode(ERR_INVALID_INPUT)))
+	}
+
+	return problems
+}
+
+// The changes that an import makes to the node in the exchange, held back until the import succeeds so that a failed
+// import leaves the exchange node as it was. The node read through it has the changes that are held back, so that the
+// import sees its own changes. The user input is patched as a whole, only the last patch of each field is kept.
+type deferredDeviceWrites struct {
+	getDevice   exchange.DeviceHandler
+	patchDevice exchange.PatchDeviceHandler
+	pdr         *exchange.PatchDeviceRequest
+}
+
+func (d *deferredDeviceWrites) get(id string, token string) (*exchange.Device, error) {
+	dev, err := d.getDevice(id, token)
+	if err != nil || dev == nil || d.pdr == nil || d.pdr.UserInput == nil {
+		return dev, err
+	}
+	held := *dev
+	held.UserInput = *d.pdr.UserInput
+	return &held, nil
+}
+
+func (d *deferredDeviceWrites) patch(id string, token string, pdr *exchange.PatchDeviceRequest) error {
+	if d.pdr == nil {
+		d.pdr = new(exchange.PatchDeviceRequest)
+	}
+	if pdr.UserInput != nil {
+		d.pdr.UserInput = pdr.UserInput
+	}
+	if pdr.Pattern != nil {
+		d.pdr.Pattern = pdr.Pattern
+	}
+	if pdr.Arch != nil {
+		d.pdr.Arch = pdr.Arch
+	}
+	if pdr.RegisteredServices != nil {
+		d.pdr.RegisteredServices = pdr.RegisteredServices
+	}
+	return nil
+}
+
+// Make the changes that were held back, in a single patch of the exchange node.
+func (d *deferredDeviceWrites) flush(pDevice *persistence.ExchangeDevice) error {
+	if d.pdr == nil {
+		return nil
+	}
+	if err := d.patchDevice(fmt.Sprintf("%v/%v", pDevice.Org, pDevice.Id), pDevice.Token, d.pdr); err != nil {
+		return err
+	}
+	d.pdr = nil
+	return nil
+}
+
+// What an import has changed on the node, so that it can be undone when the import fails.
+type nodeImportRollback struct {
+	snapshot     *persistence.BucketSnapshot
+	exchangeNode *exchange.Device
+	policies     []string
+}
+
+// Record the policy files of the policy messages.
+func (r *nodeImportRollback) addPolicies(msgs ...events.Message) {
+	for _, msg := range msgs {
+		switch m := msg.(type) {
+		case *events.PolicyCreatedMessage:
+			r.policies = append(r.policies, m.PolicyFile())
+		case *events.PoliciesCreatedMessage:
+			r.policies = append(r.policies, m.PolicyFiles()...)
+		}
+	}
+}
+
+// Put the node back as it was before the import. It carries on past the errors so that as much as possible is put
+// back, the errors are logged.
+func (r *nodeImportRollback) rollback(db *bolt.DB, pDevice *persistence.ExchangeDevice) {
+	if err := r.snapshot.Restore(db); err != nil {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_IMPORT, err.Error()), persistence.EC_ERROR_NODE_IMPORT, pDevice)
+	}
+	for _, fileName := range r.policies {
+		if err := policy.DeletePolicyFile(fileName); err != nil {
+			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_IMPORT, err.Error()), persistence.EC_ERROR_NODE_IMPORT, pDevice)
+		}
+	}
+	if r.exchangeNode != nil {
+		exchangesync.SetExchangeNode(r.exchangeNode)
+	}
+}
+
+// The buckets that an import writes to.
+var nodeImportBuckets = []string{
+	persistence.ATTRIBUTES,
+	persistence.MICROSERVICE_DEFINITIONS,
+	persistence.NODE_USERINPUT,
+	persistence.EXCHANGE_NODE_USERINPUT_HASH,
+	persistence.DEVICES,
+	persistence.CONFIGSTATE_RESOLUTION,
+}
+
+// Import the configuration exported from a node, as for a POST on /node/import. The node must be registered and
+// configuring. Each part of the document is validated as by its own API: the node user input as by /node/userinput,
+// the attributes as by /attribute, the services as by /service/config and the config state, with the user input of
+// the autoconfig, as by /node/configstate. Either all of it is imported, or nothing is and the error handler is given a
+// MultiInputError with the problems of all the parts that were checked. Returns true if the error handler handled an
+// error, otherwise the imported configuration and the messages to publish. The caller holds configstateLock.
+func ImportNodeConfig(ctx context.Context,
+	doc *NodeExport,
+	errorhandler ErrorHandler,
+	verifyAttribute func(errorhandler ErrorHandler, attr persistence.Attribute) bool,
+	getPatterns exchange.PatternHandler,
+	resolveService exchange.ServiceDefResolverHandler,
+	getService exchange.ServiceHandler,
+	getDevice exchange.DeviceHandler,
+	patchDevice exchange.PatchDeviceHandler,
+	db *bolt.DB,
+	config *config.HorizonConfig) (bool, *NodeExport, []events.Message) {
+
+	pDevice, err := persistence.FindExchangeDevice(db)
+	if err != nil {
+		eventlog.LogDatabaseEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_READ_NODE_FROM_DB, err.Error()), persistence.EC_DATABASE_ERROR)
+		return errorhandler(NewSystemError(fmt.Sprintf("Unable to read node object, error %v", err)).WithCode(ERR_DATABASE)), nil, nil
+	} else if pDevice == nil {
+		return errorhandler(NewAPIUserInputError("The node must be registered before a configuration is imported into it. Register it with POST /node first.", "import").WithCode(ERR_NODE_NOT_REGISTERED)), nil, nil
+	} else if pDevice.Config.State != persistence.CONFIGSTATE_CONFIGURING {
+		return errorhandler(NewAPIUserInputError(fmt.Sprintf("A configuration can only be imported into a node that is '%v', the node is '%v'.", persistence.CONFIGSTATE_CONFIGURING, pDevice.Config.State), "import").WithCode(ERR_INVALID_STATE)), nil, nil
+	}
+
+	importError := func(problems []InputProblem) error {
+		return NewMultiInputError(fmt.Sprintf("%v problems were found in the node configuration, nothing was imported.", len(problems)), "import", problems).WithCode(ERR_INVALID_INPUT)
+	}
+
+	if problems := checkNodeImport(doc, pDevice); len(problems) != 0 {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_IMPORT, problems), persistence.EC_ERROR_NODE_IMPORT, pDevice)
+		return errorhandler(importError(problems)), nil, nil
+	}
+
+	LogDeviceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_START_NODE_IMPORT, doc.Node.Id, pDevice.Id), persistence.EC_START_NODE_IMPORT, pDevice)
+
+	// The secrets are put back in the attributes, the document was checked to have them all.
+	for _, secret := range attributeSecrets(doc.Attributes) {
+		secret.set(doc.Secrets[secret.key])
+	}
+
+	// The exchange calls return as soon as the import is given up on.
+	getPatterns = getPatternsWithContext(ctx, getPatterns)
+	resolveService = resolveServiceWithContext(ctx, resolveService)
+	getService = getServiceWithContext(ctx, getService)
+
+	// Each part is written by the code of its own API, in its own transactions. The buckets they write to are put back
+	// as they were if any part fails, and the changes to the exchange node are only made once all of them succeed.
+	undo := new(nodeImportRollback)
+	if undo.snapshot, err = persistence.SnapshotBuckets(db, nodeImportBuckets...); err != nil {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_IMPORT, err.Error()), persistence.EC_ERROR_NODE_IMPORT, pDevice)
+		return errorhandler(NewSystemError(err.Error()).WithCode(ERR_DATABASE)), nil, nil
+	}
+	undo.exchangeNode, _ = exchangesync.GetExchangeNode()
+	writes := &deferredDeviceWrites{getDevice: getDevice, patchDevice: patchDevice}
+
+	fail := func(err error) (bool, *NodeExport, []events.Message) {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_NODE_IMPORT, err.Error()), persistence.EC_ERROR_NODE_IMPORT, pDevice)
+		undo.rollback(db, pDevice)
+		return errorhandler(err), nil, nil
+	}
+
+	problems := []InputProblem{}
+	msgs := make([]events.Message, 0, 10)
+
+	// The node user input first, the services and the autoconfig need the variables it sets.
+	if doc.UserInput != nil {
+		for i, ui := range doc.UserInput {
+			if validated, err := ValidateUserInput(pDevice, ui, getService); !validated {
+				input := fmt.Sprintf("import.userInput[%v]", i)
+				problems = append(problems, NewInputProblem(input, NewAPIUserInputError(fmt.Sprintf("Unable to validate node userInput, error: %v", err), input).WithCode(ERR_INVALID_VARIABLE)))
+			} else if err != nil {
+				glog.Warningf(apiLogString(fmt.Sprintf("Import node/userinput %v ", err)))
+			}
+		}
+		if len(problems) == 0 {
+			if changed, err := exchangesync.UpdateNodeUserInput(pDevice, db, doc.UserInput, writes.get, writes.patch); err != nil {
+				return fail(NewSystemError(fmt.Sprintf("Unable to update the node user input. %v", err)).WithCode(ERR_DATABASE))
+			} else {
+				msgs = append(msgs, events.NewNodeUserInputMessage(events.UPDATE_NODE_USERINPUT, changed))
+			}
+		}
+	}
+
+	updatePolicy := false
+	for i, given := range doc.Attributes {
+		if attr, err := importAttribute(given, pDevice, verifyAttribute, db); err != nil {
+			problems = append(problems, NewInputProblem(fmt.Sprintf("import.attributes[%v]", i), err))
+		} else if !isRegistryAuthAttribute(attr) {
+			updatePolicy = true
+		}
+	}
+	if updatePolicy {
+		msgs = append(msgs, events.NewUpdatePolicyMessage(events.UPDATE_POLICY))
+	}
+
+	for i := range doc.Services {
+		input := fmt.Sprintf("import.services[%v]", i)
+		service := doc.Services[i]
+		if service.Url == nil || *service.Url == "" {
+			problems = append(problems, NewInputProblem(input, NewAPIUserInputError("the url of the service must be set", input+".url").WithCode(ERR_INVALID_INPUT)))
+			continue
+		}
+
+		var serviceErr error
+		if errHandled, _, msg := CreateService(ctx, &service, GetPassThroughErrorHandler(&serviceErr), getPatterns, resolveService, getService, writes.get, writes.patch, nil, db, config, events.POLICY_ORIGIN_IMPORT, false); errHandled {
+			problems = append(problems, NewInputProblem(input, serviceErr))
+		} else if msg != nil {
+			undo.addPolicies(msg)
+			msgs = append(msgs, msg)
+		}
+	}
+
+	if err := configstateContextError(ctx); err != nil {
+		return fail(err)
+	}
+
+	// The config state last, the autoconfig of the pattern reuses the services imported above. It rolls back its own
+	// changes when it fails.
+	if len(problems) == 0 && doc.Configstate != nil && doc.Configstate.State != nil {
+		cfg := &Configstate{State: doc.Configstate.State, Versions: doc.Configstate.Versions, ExcludedServices: doc.Configstate.ExcludedServices, OptionalServices: doc.Configstate.OptionalServices, Channel: doc.Configstate.Channel}
+		var cfgErr error
+		if errHandled, _, cfgMsgs := changeConfigstate(ctx, cfg, GetPassThroughErrorHandler(&cfgErr), getPatterns, resolveService, getService, writes.get, writes.patch, db, config); errHandled {
+			if multiErr, ok := cfgErr.(*MultiServiceConfigError); ok {
+				for _, p := range multiErr.Services {
+					problems = append(problems, InputProblem{Input: "import.configstate", Err: p.String(), Code: p.Code})
+				}
+			} else {
+				problems = append(problems, NewInputProblem("import.configstate", cfgErr))
+			}
+		} else {
+			undo.addPolicies(cfgMsgs...)
+			msgs = append(msgs, cfgMsgs...)
+		}
+	}
+
+	if len(problems) != 0 {
+		return fail(importError(problems))
+	}
+
+	if err := writes.flush(pDevice); err != nil {
+		return fail(NewSystemError(fmt.Sprintf("Unable to update the node in the exchange, error %v", err)).WithCode(ERR_EXCHANGE_UNREACHABLE))
+	}
+
+	LogDeviceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_COMPLETE_NODE_IMPORT, doc.Node.Id, len(doc.Attributes), len(doc.Services)), persistence.EC_NODE_IMPORT_COMPLETE, pDevice)
+
+	out, err := FindNodeExportForOutput(db)
+	if err != nil {
+		// The import is done, only its output cannot be read.
+		glog.Errorf(apiLogString(fmt.Sprintf("unable to read the imported node configuration, error %v", err)))
+		return false, &NodeExport{Version: NODE_EXPORT_VERSION, Node: doc.Node}, msgs
+	}
+	return false, out, msgs
+}
+
+// Validate and save an attribute of an import, as a POST on /attribute does.
+func importAttribute(given Attribute, pDevice *persistence.ExchangeDevice, verifyAttribute func(errorhandler ErrorHandler, attr persistence.Attribute) bool, db *bolt.DB) (persistence.Attribute, error) {
+	var attrErr error
+	handler := GetPassThroughErrorHandler(&attrErr)
+
+	given.Id = nil
+	attrs, inputErr, err := toPersistedAttributes(handler, false, pDevice, []Attribute{given}, nil)
+	if inputErr {
+		return nil, attrErr
+	} else if err != nil {
+		return nil, NewSystemError(fmt.Sprintf("Error processing the attribute, error %v", err))
+	} else if verifyAttribute != nil && verifyAttribute(handler, attrs[0]) {
+		return nil, attrErr
+	}
+
+	if _, err := persistence.SaveOrUpdateAttribute(db, attrs[0], "", false); err != nil {
+		if _, ok := err.(*persistence.ConflictingAttributeFound); ok {
+			return nil, NewConflictError(fmt.Sprintf("the attribute conflicts with an attribute of the node, %v", err)).WithCode(ERR_INVALID_INPUT)
+		}
+		return nil, NewSystemError(fmt.Sprintf("Error persisting attribute, error %v", err)).WithCode(ERR_DATABASE)
+	}
+	return attrs[0], nil
+}
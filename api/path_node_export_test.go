@@ -0,0 +1,144 @@
+// +build unit
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/open-horizon/anax/persistence"
+	"strings"
+	"testing"
+)
+
+// The export has no secrets, and is imported into another node once its secrets are set.
+func Test_NodeExport_roundtrip(t *testing.T) {
+
+	srcDir, src, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(srcDir)
+
+	if _, err := persistence.SaveNewExchangeDevice(src, "testid", "testtoken", "testname", "", false, "myorg", "", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+	f := false
+	attr := persistence.HTTPSBasicAuthAttributes{
+		Meta:     &persistence.AttributeMeta{Type: "HTTPSBasicAuthAttributes", Label: "basic", Publishable: &f, HostOnly: &f},
+		Url:      "https://myrepo.com",
+		Username: "me",
+		Password: "secretpw",
+	}
+	if _, err := persistence.SaveOrUpdateAttribute(src, attr, "", false); err != nil {
+		t.Errorf("failed to save the attribute, error %v", err)
+	}
+
+	doc, err := FindNodeExportForOutput(src)
+	if err != nil {
+		t.Fatalf("failed to export the node, error %v", err)
+	}
+	secretKey := "attributes[0].mappings.password"
+	if val, ok := doc.Secrets[secretKey]; !ok || val != "" {
+		t.Errorf("the export should have the key of the password with no value, has %v", doc.Secrets)
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("failed to serialize the export, error %v", err)
+	} else if strings.Contains(string(body), "secretpw") {
+		t.Errorf("the export should not have the password, is %v", string(body))
+	}
+
+	dstDir, dst, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dstDir)
+
+	if _, err := persistence.SaveNewExchangeDevice(dst, "otherid", "othertoken", "othername", "", false, "myorg", "", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	// the secret must be set
+	var imported NodeExport
+	if err := decodeInputBody(body, &imported, "import"); err != nil {
+		t.Fatalf("failed to deserialize the export, error %v", err)
+	}
+	var myError error
+	errHandled, _, _ := ImportNodeConfig(context.Background(), &imported, GetPassThroughErrorHandler(&myError), nil, getDummyGetPatterns(), getDummyServiceDefResolver(), getDummyServiceHandler(), getDummyDeviceHandler(), getDummyPatchDeviceHandler(), dst, getBasicConfig())
+	if !errHandled {
+		t.Fatalf("the import without the secret should fail")
+	} else if multiErr, ok := myError.(*MultiInputError); !ok {
+		t.Errorf("myError has the wrong type (%T) %v", myError, myError)
+	} else if len(multiErr.Problems) != 1 || multiErr.Problems[0].Input != "import.secrets."+secretKey {
+		t.Errorf("the problem should be the missing secret, is %v", multiErr.Problems)
+	}
+
+	imported = NodeExport{}
+	if err := decodeInputBody(body, &imported, "import"); err != nil {
+		t.Fatalf("failed to deserialize the export, error %v", err)
+	}
+	imported.Secrets[secretKey] = "secretpw"
+	errHandled, out, _ := ImportNodeConfig(context.Background(), &imported, GetPassThroughErrorHandler(&myError), nil, getDummyGetPatterns(), getDummyServiceDefResolver(), getDummyServiceHandler(), getDummyDeviceHandler(), getDummyPatchDeviceHandler(), dst, getBasicConfig())
+	if errHandled {
+		t.Fatalf("the import should succeed, error (%T) %v", myError, myError)
+	} else if out.Node.Id != "otherid" || len(out.Attributes) != 1 {
+		t.Errorf("the output should be the configuration of the node, is %v", out)
+	}
+
+	if attrs, err := persistence.FindApplicableAttributes(dst, "", ""); err != nil {
+		t.Errorf("unable to read the attributes, error %v", err)
+	} else if len(attrs) != 1 {
+		t.Errorf("the attribute should be imported, got %v", attrs)
+	} else if basic, ok := attrs[0].(persistence.HTTPSBasicAuthAttributes); !ok || basic.Password != "secretpw" {
+		t.Errorf("the attribute should be imported with its secret, got %v", attrs[0])
+	}
+}
+
+// A failed import leaves the node as it was, even the parts of the document that had no problem.
+func Test_ImportNodeConfig_nothing_persisted(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, "myorg", "", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	attrType, label, f := "HTTPSBasicAuthAttributes", "basic", false
+	mappings := map[string]interface{}{"url": "https://myrepo.com", "username": "me"}
+	url, org := "http://utest.com/notthere", "myorg"
+	state := persistence.CONFIGSTATE_CONFIGURED
+	doc := &NodeExport{
+		Version:     NODE_EXPORT_VERSION,
+		Node:        &ExportNode{Id: "otherid", Org: "myorg", NodeType: persistence.DEVICE_TYPE_DEVICE},
+		Attributes:  []Attribute{{Type: &attrType, Label: &label, Publishable: &f, HostOnly: &f, Mappings: &mappings}},
+		Services:    []Service{{Url: &url, Org: &org}},
+		Configstate: &Configstate{State: &state},
+		Secrets:     map[string]string{"attributes[0].mappings.password": "secretpw"},
+	}
+
+	var myError error
+	errHandled, _, _ := ImportNodeConfig(context.Background(), doc, GetPassThroughErrorHandler(&myError), nil, getDummyGetPatterns(), getDummyServiceDefResolver(), getDummyServiceHandler(), getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+	if !errHandled {
+		t.Fatalf("the import of a service that does not exist should fail")
+	} else if multiErr, ok := myError.(*MultiInputError); !ok {
+		t.Errorf("myError has the wrong type (%T) %v", myError, myError)
+	} else if len(multiErr.Problems) != 1 || multiErr.Problems[0].Input != "import.services[0]" {
+		t.Errorf("the problem should be the service, is %v", multiErr.Problems)
+	}
+
+	if attrs, err := persistence.FindApplicableAttributes(db, "", ""); err != nil {
+		t.Errorf("unable to read the attributes, error %v", err)
+	} else if len(attrs) != 0 {
+		t.Errorf("the attribute should not be imported, got %v", attrs)
+	}
+
+	if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
+		t.Errorf("unable to read the device, error %v", err)
+	} else if pDevice.Config.State != persistence.CONFIGSTATE_CONFIGURING {
+		t.Errorf("the node should still be configuring, is %v", pDevice.Config.State)
+	}
+}
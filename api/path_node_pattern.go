@@ -0,0 +1,339 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+)
+
+// A service that the node's pattern requires, as returned by GET /node/pattern/services.
+type PatternServiceOutput struct {
+	Url             string `json:"url"`
+	Org             string `json:"organization"`
+	Version         string `json:"version"`
+	Arch            string `json:"arch"`
+	SharedSingleton bool   `json:"shared_singleton"`
+}
+
+// Resolve the services that the node's pattern requires, as the autoconfig does when the node is changed to configured,
+// but without checking their user input or registering them, so it can be done whatever the config state of the node.
+// The list has the dependencies that the autoconfig registers, with their common version ranges, followed by the
+// top-level services, with the version range they are registered with. ExclusiveAccess is false for the services that
+// are shared singletons. Returns a NotFoundError when the node is not registered or has no pattern.
+func ResolvePatternServices(db *bolt.DB,
+	getPatterns exchange.PatternHandler,
+	resolveService exchange.ServiceDefResolverHandler,
+	config *config.HorizonConfig) (*policy.APISpecList, error) {
+
+	pDevice, err := persistence.FindExchangeDevice(db)
+	if err != nil {
+		return nil, NewSystemError(fmt.Sprintf("Unable to read node object, error %v", err))
+	} else if pDevice == nil {
+		return nil, NewNotFoundError("Exchange registration not recorded. Complete account and node registration with an exchange and then record node registration using this API's /node path.", "node")
+	} else if pDevice.Pattern == "" {
+		return nil, NewNotFoundError(fmt.Sprintf("node %v/%v has no pattern", pDevice.Org, pDevice.Id), "pattern")
+	}
+
+	pattern_org, pattern_name, _ := persistence.GetFormatedPatternString(pDevice.Pattern, pDevice.Org)
+
+	optional := newOptionalServices(pDevice.Config.Optional)
+	depSpecs, pattern, err := getSpecRefsForPattern(context.Background(), pDevice.GetNodeType(), pattern_name, pattern_org, getPatterns, resolveService, db, config, pDevice.Config.Excluded, optional, pDevice.Config.Channel, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The version ranges that the node was configured with still apply.
+	pins, err := newVersionPins(pDevice.Config.Versions)
+	if err != nil {
+		return nil, err
+	}
+	depSpecs, topLevelSpecs, err := autoconfigServiceSpecs(pDevice.GetNodeType(), pattern, depSpecs, nil, pDevice.Config.Excluded, optional, pDevice.Config.Channel, false, pins, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// The sharing mode of a top-level service is the one of its first version choice, the one that is deployed. The
+	// resolution of that choice is cached by getSpecRefsForPattern.
+	for i, apiSpec := range *topLevelSpecs {
+		for _, service := range pattern.Services {
+			if !cutil.SameSpecURL(service.ServiceURL, apiSpec.SpecRef) || service.ServiceOrg != apiSpec.Org || service.ServiceArch != apiSpec.Arch {
+				continue
+			}
+			if choices, err := channelChoices(service, pDevice.Config.Channel); err == nil {
+				if _, serviceDef, _, err := resolveService(service.ServiceURL, service.ServiceOrg, choices[0].Version, service.ServiceArch); err != nil {
+					return nil, NewSystemError(fmt.Sprintf("Error resolving service %v/%v %v %v, error %v", service.ServiceOrg, service.ServiceURL, choices[0].Version, service.ServiceArch, err)).WithCode(exchangeErrorReason(err, ERR_SERVICE_NOT_FOUND))
+				} else if serviceDef != nil && (serviceDef.Sharable == exchange.MS_SHARING_MODE_SINGLETON || serviceDef.Sharable == exchange.MS_SHARING_MODE_SINGLE) {
+					(*topLevelSpecs)[i].ExclusiveAccess = false
+				}
+			}
+			break
+		}
+	}
+
+	apiSpecs := append(*depSpecs, *topLevelSpecs...)
+	glog.V(5).Infof(apiLogString(fmt.Sprintf("resolved the services of pattern %v/%v to %v", pattern_org, pattern_name, apiSpecs)))
+	return &apiSpecs, nil
+}
+
+// Returns the services that the autoconfig registers for the pattern of a node, given the dependencies that
+// getSpecRefsForPattern resolved for it and the problems that it found. The first list has the dependencies, only on a
+// device, with the version range that the node pins them to if any. The second one has the top-level services that the
+// node runs, with the version range they are registered with, without the ones that have a problem and the optional
+// ones that were skipped.
+func autoconfigServiceSpecs(nodeType string,
+	pattern *exchange.Pattern,
+	depSpecs *policy.APISpecList,
+	problems []ServiceConfigProblem,
+	excluded persistence.ServiceSpecs,
+	optional *optionalServices,
+	channel string,
+	fromManifest bool,
+	pins *versionPins,
+	config *config.HorizonConfig) (*policy.APISpecList, *policy.APISpecList, error) {
+
+	deps := new(policy.APISpecList)
+	if nodeType == persistence.DEVICE_TYPE_DEVICE {
+		for _, apiSpec := range *depSpecs {
+			version, err := pins.dependencyRange(apiSpec.SpecRef, apiSpec.Org, apiSpec.Version)
+			if err != nil {
+				return nil, nil, err
+			}
+			apiSpec.Version = version
+			(*deps) = append(*deps, apiSpec)
+		}
+	}
+
+	topLevel := new(policy.APISpecList)
+	for _, service := range pattern.Services {
+
+		// Ignore top-level services that don't match the hardware architectures this node supports, or that the node
+		// excludes.
+		if !cutil.ArchSupported(config, service.ServiceArch) {
+			glog.Infof(apiLogString(fmt.Sprintf("skipping service because it is for a different hardware architecture, this node supports %v. Skipped service is: %v", cutil.SupportedArchs(config), service.ServiceArch)))
+			continue
+		} else if isExcludedService(excluded, service.ServiceURL, service.ServiceOrg) {
+			continue
+		}
+
+		// Only the version choices in the channel of the node are registered, the services without any already
+		// have a problem.
+		choices, err := channelChoices(service, channel)
+		if err != nil {
+			continue
+		}
+
+		// The services of the autoconfig manifest are registered with their version range.
+		var version string
+		if fromManifest {
+			version = choices[0].Version
+		} else if version, err = pins.topLevelRange(service.ServiceURL, service.ServiceOrg, choices); err != nil {
+			return nil, nil, err
+		}
+
+		// The top-level services with a problem already have their user input checked, the optional ones that cannot
+		// be resolved are not configured.
+		if hasServiceConfigProblem(problems, service.ServiceURL, service.ServiceOrg) || optional.isSkipped(service.ServiceURL, service.ServiceOrg) {
+			continue
+		}
+		(*topLevel) = append(*topLevel, *policy.APISpecification_Factory(service.ServiceURL, service.ServiceOrg, version, service.ServiceArch))
+	}
+	return deps, topLevel, nil
+}
+
+// Resolve the services of the node's pattern for output.
+func FindPatternServicesForOutput(db *bolt.DB,
+	getPatterns exchange.PatternHandler,
+	resolveService exchange.ServiceDefResolverHandler,
+	config *config.HorizonConfig) ([]PatternServiceOutput, error) {
+
+	apiSpecs, err := ResolvePatternServices(db, getPatterns, resolveService, config)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]PatternServiceOutput, 0, len(*apiSpecs))
+	for _, apiSpec := range *apiSpecs {
+		out = append(out, PatternServiceOutput{
+			Url:             apiSpec.SpecRef,
+			Org:             apiSpec.Org,
+			Version:         apiSpec.Version,
+			Arch:            apiSpec.Arch,
+			SharedSingleton: !apiSpec.ExclusiveAccess,
+		})
+	}
+	return out, nil
+}
+
+// Returns the services that the autoconfig configures for a pattern: the dependencies of its top-level services, resolved
+// to their common version ranges as for ResolvePatternServices, and the top-level services for the hardware
+// architectures of the node, with the version range that the autoconfig registers them with.
+func patternServiceSpecs(nodeType string,
+	patOrg string,
+	patName string,
+	getPatterns exchange.PatternHandler,
+	resolveService exchange.ServiceDefResolverHandler,
+	db *bolt.DB,
+	config *config.HorizonConfig) (*policy.APISpecList, error) {
+
+	apiSpecs, patternDef, err := getSpecRefsForPattern(context.Background(), nodeType, patName, patOrg, getPatterns, resolveService, db, config, nil, nil, "", false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, service := range patternDef.Services {
+		if cutil.ArchSupported(config, service.ServiceArch) {
+			// A top-level service that is also a dependency of another one is only in the list once, as a dependency.
+			apiSpecs.Add_API_Spec(policy.APISpecification_Factory(service.ServiceURL, service.ServiceOrg, "[0.0.0,INFINITY)", service.ServiceArch))
+		}
+	}
+	return apiSpecs, nil
+}
+
+// Change the pattern of a node that is configuring to newPattern, without dropping the configuration of the services
+// that both patterns need. The services that only the old pattern needs are removed with their attributes. The ones
+// that both patterns need at different versions keep their attributes, but they are removed so that the autoconfig
+// registers them again with the versions of the new pattern, as it registers the ones that only the new pattern needs,
+// when the node is changed to configured. Returns the updated node.
+func changeNodePattern(pDevice *persistence.ExchangeDevice,
+	newPattern string,
+	getPatterns exchange.PatternHandler,
+	resolveService exchange.ServiceDefResolverHandler,
+	patchDevice exchange.PatchDeviceHandler,
+	msgQueue chan events.Message,
+	db *bolt.DB,
+	config *config.HorizonConfig) (*persistence.ExchangeDevice, error) {
+
+	oldOrg, oldName, _ := persistence.GetFormatedPatternString(pDevice.Pattern, pDevice.Org)
+	newOrg, newName, _ := persistence.GetFormatedPatternString(newPattern, pDevice.Org)
+
+	if patternDefs, err := getPatterns(newOrg, newName); err != nil {
+		return nil, NewAPIUserInputError(fmt.Sprintf("error searching for pattern %v in exchange, error: %v", newPattern, err), "device.pattern")
+	} else if _, ok := patternDefs[newPattern]; !ok {
+		return nil, NewAPIUserInputError(fmt.Sprintf("pattern %v not found in exchange.", newPattern), "device.pattern")
+	}
+
+	oldSpecs, err := patternServiceSpecs(pDevice.GetNodeType(), oldOrg, oldName, getPatterns, resolveService, db, config)
+	if err != nil {
+		return nil, err
+	}
+	newSpecs, err := patternServiceSpecs(pDevice.GetNodeType(), newOrg, newName, getPatterns, resolveService, db, config)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := policy.DiffAPISpecLists(*oldSpecs, *newSpecs)
+	glog.V(3).Infof(apiLogString(fmt.Sprintf("changing the node pattern from %v to %v, services %v", pDevice.Pattern, newPattern, diff)))
+
+	// The node has the new pattern locally before it has it in the exchange, so that the agent does not take the change
+	// in the exchange for one that the node must be re-registered for.
+	updatedDev, err := pDevice.SetPattern(db, pDevice.Id, newPattern)
+	if err != nil {
+		return nil, NewSystemError(fmt.Sprintf("error persisting pattern %v on node object: %v", newPattern, err))
+	}
+	msgQueue <- events.NewNodePatternMessage(events.NODE_PATTERN_UPDATED, newPattern)
+
+	pdr := exchange.PatchDeviceRequest{Pattern: &newPattern}
+	if err := patchDevice(fmt.Sprintf("%v/%v", pDevice.Org, pDevice.Id), pDevice.Token, &pdr); err != nil {
+		if _, serr := updatedDev.SetPattern(db, pDevice.Id, pDevice.Pattern); serr != nil {
+			glog.Errorf(apiLogString(fmt.Sprintf("unable to restore the node pattern %v, error %v", pDevice.Pattern, serr)))
+		}
+		msgQueue <- events.NewNodePatternMessage(events.NODE_PATTERN_UPDATED, pDevice.Pattern)
+		return nil, NewSystemError(fmt.Sprintf("error setting the node pattern to %v in the exchange, error %v", newPattern, err))
+	}
+
+	// The patterns and services cached for the old pattern are not used for the new one.
+	exchange.DeletePatternCache()
+
+	removePatternServices(diff.Remove, false, newPattern, updatedDev, db, config)
+	removePatternServices(diff.Changed, true, newPattern, updatedDev, db, config)
+
+	LogDeviceEvent(db, persistence.SEVERITY_INFO,
+		persistence.NewMessageMeta(EL_API_NODE_PATTERN_CHANGED, pDevice.Pattern, newPattern, diff.Keep.AsStringArray(), diff.Remove.AsStringArray(), diff.Add.AsStringArray()),
+		persistence.EC_NODE_UPDATE_COMPLETE, updatedDev)
+
+	return updatedDev, nil
+}
+
+// Remove the service definitions and policies of the services that the new pattern of the node does not need as they
+// are configured, and their attributes unless keepAttributes is true. An attribute is only removed when all the
+// services it applies to are removed. The node already has the new pattern, so this carries on past the errors so that
+// as much as possible is removed, the errors are logged.
+func removePatternServices(specs policy.APISpecList,
+	keepAttributes bool,
+	newPattern string,
+	pDevice *persistence.ExchangeDevice,
+	db *bolt.DB,
+	config *config.HorizonConfig) {
+
+	logError := func(spec policy.APISpecification, err error) {
+		LogDeviceEvent(db, persistence.SEVERITY_ERROR,
+			persistence.NewMessageMeta(EL_API_ERR_NODE_PATTERN_SVC_REMOVAL, cutil.FormOrgSpecUrl(spec.SpecRef, spec.Org), newPattern, err.Error()),
+			persistence.EC_ERROR_NODE_UPDATE, pDevice)
+	}
+
+	for _, spec := range specs {
+		msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter(), persistence.UrlOrgMSFilter(spec.SpecRef, spec.Org)})
+		if err != nil {
+			logError(spec, err)
+			continue
+		}
+		for _, msdef := range msdefs {
+			glog.V(3).Infof(apiLogString(fmt.Sprintf("node pattern change removing service %v/%v %v", msdef.Org, msdef.SpecRef, msdef.Version)))
+			if _, err := persistence.MsDefArchived(db, msdef.Id); err != nil {
+				logError(spec, err)
+			}
+		}
+		if err := policy.DeletePolicyFilesForService(config.Edge.PolicyPath, pDevice.Org, spec.SpecRef, spec.Org); err != nil {
+			logError(spec, err)
+		}
+	}
+
+	if keepAttributes || len(specs) == 0 {
+		return
+	}
+
+	attributes, err := persistence.FindApplicableAttributes(db, "", "")
+	if err != nil {
+		glog.Errorf(apiLogString(fmt.Sprintf("unable to read the attributes of the services that pattern %v does not need, error %v", newPattern, err)))
+		return
+	}
+	for _, attr := range attributes {
+		serviceSpecs := persistence.GetAttributeServiceSpecs(&attr)
+		if serviceSpecs == nil || len(*serviceSpecs) == 0 {
+			continue
+		}
+
+		removed := true
+		for _, sp := range *serviceSpecs {
+			org := sp.Org
+			if org == "" {
+				org = pDevice.Org
+			}
+			found := false
+			for _, spec := range specs {
+				if cutil.SameSpecURL(spec.SpecRef, sp.Url) && spec.Org == org {
+					found = true
+					break
+				}
+			}
+			if !found {
+				removed = false
+				break
+			}
+		}
+
+		if removed {
+			glog.V(3).Infof(apiLogString(fmt.Sprintf("node pattern change removing attribute %v", attr.GetMeta().Id)))
+			if _, err := persistence.DeleteAttribute(db, attr.GetMeta().Id); err != nil {
+				glog.Errorf(apiLogString(fmt.Sprintf("unable to remove attribute %v, error %v", attr.GetMeta().Id, err)))
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// +build unit
+
+package api
+
+import (
+	"fmt"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+	"testing"
+)
+
+func Test_ResolvePatternServices_no_pattern(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	sr := exchange.ServiceReference{ServiceURL: "http://mydomain.com/workload/test1", ServiceOrg: "testorg", ServiceArch: "amd64", ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}}}
+	sResolver := getVariableServiceDefResolver("http://utest.com/mservice", "myorg", "1.0.0", "amd64", nil)
+
+	// not registered
+	if _, err := ResolvePatternServices(db, getVariablePatternHandler(sr), sResolver, getBasicConfig()); err == nil {
+		t.Errorf("expected error")
+	} else if _, ok := err.(*NotFoundError); !ok {
+		t.Errorf("the error should be a not found error, is (%T) %v", err, err)
+	}
+
+	// registered for policy
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "device", false, "myorg", "", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+	if _, err := ResolvePatternServices(db, getVariablePatternHandler(sr), sResolver, getBasicConfig()); err == nil {
+		t.Errorf("expected error")
+	} else if nfErr, ok := err.(*NotFoundError); !ok {
+		t.Errorf("the error should be a not found error, is (%T) %v", err, err)
+	} else if nfErr.Input != "pattern" {
+		t.Errorf("the error should be about the pattern, is %v", nfErr)
+	}
+}
+
+func Test_FindPatternServicesForOutput(t *testing.T) {
+
+	for _, state := range []string{persistence.CONFIGSTATE_CONFIGURING, persistence.CONFIGSTATE_CONFIGURED} {
+
+		dir, db, err := utsetup()
+		if err != nil {
+			t.Error(err)
+		}
+
+		if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "device", false, "myorg", "mypattern", state); err != nil {
+			t.Errorf("failed to create persisted device, error %v", err)
+		}
+
+		sr := exchange.ServiceReference{ServiceURL: "http://mydomain.com/workload/test1", ServiceOrg: "testorg", ServiceArch: "amd64", ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}}}
+		sResolver := func(wUrl string, wOrg string, wVersion string, wArch string) (map[string]exchange.ServiceDefinition, *exchange.ServiceDefinition, string, error) {
+			deps := map[string]exchange.ServiceDefinition{
+				"myorg/exclusive_1.0.0_amd64": exchange.ServiceDefinition{URL: "http://utest.com/exclusive", Version: "1.0.0", Arch: wArch, Sharable: exchange.MS_SHARING_MODE_EXCLUSIVE},
+				"myorg/singleton_2.0.0_amd64": exchange.ServiceDefinition{URL: "http://utest.com/singleton", Version: "2.0.0", Arch: wArch, Sharable: exchange.MS_SHARING_MODE_SINGLETON},
+			}
+			return deps, &exchange.ServiceDefinition{URL: wUrl, Version: wVersion, Arch: wArch, Sharable: exchange.MS_SHARING_MODE_SINGLETON}, fmt.Sprintf("%v/%v_%v_%v", wOrg, wUrl, wVersion, wArch), nil
+		}
+
+		out, err := FindPatternServicesForOutput(db, getVariablePatternHandler(sr), sResolver, getBasicConfig())
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if len(out) != 3 {
+			t.Errorf("there should be 3 services when the node is %v, are %v", state, out)
+		} else if top := out[2]; top.Url != sr.ServiceURL || top.Org != "testorg" || top.Version != "[0.0.0,INFINITY)" || !top.SharedSingleton {
+			t.Errorf("the top-level service should be last, with the range it is registered with, is %v", top)
+		} else {
+			for _, svc := range out[:2] {
+				if svc.Org != "myorg" || svc.Arch != "amd64" {
+					t.Errorf("wrong service %v", svc)
+				} else if svc.Url == "http://utest.com/exclusive" && (svc.SharedSingleton || svc.Version != "[1.0.0,INFINITY)") {
+					t.Errorf("wrong exclusive service %v", svc)
+				} else if svc.Url == "http://utest.com/singleton" && (!svc.SharedSingleton || svc.Version != "[2.0.0,INFINITY)") {
+					t.Errorf("wrong singleton service %v", svc)
+				}
+			}
+		}
+
+		cleanTestDir(dir)
+	}
+}
@@ -0,0 +1,141 @@
+// +build unit
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/worker"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Returns an API of a registered node whose exchange is served by the handler, and whose workers answer a node sync
+// with the given results.
+func nodeSyncTestAPI(t *testing.T, exchangeHandler http.HandlerFunc, answers []events.SyncResult) (*API, func()) {
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(exchangeHandler)
+
+	factory := &config.HTTPClientFactory{NewHTTPClient: func(*uint) *http.Client { return &http.Client{} }, RetryCount: 1, RetryInterval: 1}
+	a := &API{Manager: worker.Manager{Config: getBasicConfig(), Messages: make(chan events.Message, 20)}, db: db}
+	a.EC = worker.NewExchangeContext("myorg/myid", "mytoken", server.URL+"/", "", factory)
+
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case msg := <-a.Messages():
+				if m, ok := msg.(*events.NodeSyncMessage); ok {
+					for _, result := range answers {
+						m.Results <- result
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return a, func() {
+		close(done)
+		server.Close()
+		cleanTestDir(dir)
+	}
+}
+
+func postNodeSync(t *testing.T, a *API) (int, *NodeSyncOutput) {
+	w := httptest.NewRecorder()
+	a.nodesync(w, httptest.NewRequest("POST", "/node/sync", nil))
+
+	var output NodeSyncOutput
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &output); err != nil {
+			t.Fatalf("unable to decode the output %v, error %v", w.Body.String(), err)
+		}
+	}
+	return w.Code, &output
+}
+
+func syncResultsByName(output *NodeSyncOutput) map[string]events.SyncResult {
+	results := make(map[string]events.SyncResult)
+	for _, result := range output.Results {
+		results[result.Name] = result
+	}
+	return results
+}
+
+// Each synchronization and the exchange version are reported, and a second sync right after is refused.
+func Test_nodesync(t *testing.T) {
+	a, cleanup := nodeSyncTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("2.44.0"))
+	}, []events.SyncResult{
+		{Name: events.SYNC_HEARTBEAT},
+		{Name: events.SYNC_NODE, Changed: true, Summary: "the user input was updated"},
+		{Name: events.SYNC_NODE_POLICY, Error: "the exchange returned 503"},
+	})
+	defer cleanup()
+
+	code, output := postNodeSync(t, a)
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %v", code)
+	}
+
+	results := syncResultsByName(output)
+	if len(output.Results) != 4 {
+		t.Errorf("expected 4 results, got %v", output.Results)
+	} else if !results[events.SYNC_NODE].Changed {
+		t.Errorf("expected the node sync to report the change, got %v", results[events.SYNC_NODE])
+	} else if results[events.SYNC_NODE_POLICY].Error == "" {
+		t.Errorf("expected the node policy sync to report its error, got %v", results[events.SYNC_NODE_POLICY])
+	} else if results[events.SYNC_EXCHANGE_VERSION].Error != "" {
+		t.Errorf("expected the exchange version to be supported, got %v", results[events.SYNC_EXCHANGE_VERSION])
+	}
+
+	if code, _ := postNodeSync(t, a); code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 for a sync right after another, got %v", code)
+	}
+}
+
+// The syncs that do not complete, including the check of the exchange version, are reported when the timeout expires.
+func Test_nodesync_timeout(t *testing.T) {
+	timeout := NODE_SYNC_TIMEOUT
+	NODE_SYNC_TIMEOUT = 200 * time.Millisecond
+	defer func() { NODE_SYNC_TIMEOUT = timeout }()
+
+	a, cleanup := nodeSyncTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}, []events.SyncResult{{Name: events.SYNC_HEARTBEAT}})
+	defer cleanup()
+
+	start := time.Now()
+	code, output := postNodeSync(t, a)
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %v", code)
+	} else if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the sync to stop after the timeout, it took %v", elapsed)
+	}
+
+	results := syncResultsByName(output)
+	if results[events.SYNC_HEARTBEAT].Error != "" {
+		t.Errorf("expected the heartbeat to complete, got %v", results[events.SYNC_HEARTBEAT])
+	}
+	for _, name := range []string{events.SYNC_NODE, events.SYNC_NODE_POLICY, events.SYNC_EXCHANGE_VERSION} {
+		if results[name].Error == "" {
+			t.Errorf("expected %v to time out, got %v", name, results[name])
+		}
+	}
+}
+
+// A node that is not registered cannot be synchronized.
+func Test_nodesync_unregistered(t *testing.T) {
+	a := &API{Manager: worker.Manager{Config: getBasicConfig()}}
+	if code, _ := postNodeSync(t, a); code != http.StatusConflict {
+		t.Errorf("expected status 409, got %v", code)
+	}
+}
@@ -3,9 +3,11 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/persistence"
@@ -130,7 +132,7 @@ func Test_CreateHorizonDevice_NoDeviceid(t *testing.T) {
 	var myError error
 	errorhandler := GetPassThroughErrorHandler(&myError)
 
-	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getDummyGetOrg(), getDummyGetPatternsWithContext(), getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getDummyDeviceHandler(), events.NewEventStateManager(), db)
+	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getDummyGetOrg(), getDummyGetPatternsWithContext(), getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getDummyDeviceHandler(), nil, events.NewEventStateManager(), db)
 
 	if !errHandled {
 		t.Errorf("expected error")
@@ -162,7 +164,7 @@ func Test_CreateHorizonDevice_IllegalId(t *testing.T) {
 	var myError error
 	errorhandler := GetPassThroughErrorHandler(&myError)
 
-	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getDummyGetOrg(), getDummyGetPatternsWithContext(), getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getDummyDeviceHandler(), events.NewEventStateManager(), db)
+	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getDummyGetOrg(), getDummyGetPatternsWithContext(), getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getDummyDeviceHandler(), nil, events.NewEventStateManager(), db)
 
 	if !errHandled {
 		t.Errorf("expected error")
@@ -193,7 +195,7 @@ func Test_CreateHorizonDevice_IllegalOrg(t *testing.T) {
 	var myError error
 	errorhandler := GetPassThroughErrorHandler(&myError)
 
-	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getDummyGetOrg(), getDummyGetPatternsWithContext(), getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getDummyDeviceHandler(), events.NewEventStateManager(), db)
+	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getDummyGetOrg(), getDummyGetPatternsWithContext(), getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getDummyDeviceHandler(), nil, events.NewEventStateManager(), db)
 
 	if !errHandled {
 		t.Errorf("expected error")
@@ -224,7 +226,7 @@ func Test_CreateHorizonDevice_IllegalPattern(t *testing.T) {
 	var myError error
 	errorhandler := GetPassThroughErrorHandler(&myError)
 
-	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getDummyGetOrg(), getDummyGetPatternsWithContext(), getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getDummyDeviceHandler(), events.NewEventStateManager(), db)
+	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getDummyGetOrg(), getDummyGetPatternsWithContext(), getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getDummyDeviceHandler(), nil, events.NewEventStateManager(), db)
 
 	if !errHandled {
 		t.Errorf("expected error")
@@ -256,7 +258,7 @@ func Test_CreateHorizonDevice_IllegalName(t *testing.T) {
 	var myError error
 	errorhandler := GetPassThroughErrorHandler(&myError)
 
-	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getDummyGetOrg(), getDummyGetPatternsWithContext(), getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getDummyDeviceHandler(), events.NewEventStateManager(), db)
+	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getDummyGetOrg(), getDummyGetPatternsWithContext(), getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getDummyDeviceHandler(), nil, events.NewEventStateManager(), db)
 
 	if !errHandled {
 		t.Errorf("expected error")
@@ -287,7 +289,7 @@ func Test_CreateHorizonDevice_IllegalToken(t *testing.T) {
 	var myError error
 	errorhandler := GetPassThroughErrorHandler(&myError)
 
-	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getDummyGetOrg(), getDummyGetPatternsWithContext(), getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getDummyDeviceHandler(), events.NewEventStateManager(), db)
+	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getDummyGetOrg(), getDummyGetPatternsWithContext(), getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getDummyDeviceHandler(), nil, events.NewEventStateManager(), db)
 
 	if !errHandled {
 		t.Errorf("expected error")
@@ -320,7 +322,7 @@ func Test_CreateHorizonDevice_WrongExchangeVersion(t *testing.T) {
 		return "0.1.1", nil
 	}
 
-	errHandled, _, _ := CreateHorizonDevice(hd, errorhandler, getDummyGetOrg(), getDummyGetPatternsWithContext(), getExchangeVersion, getDummyPatchDeviceHandler(), getDummyDeviceHandler(), events.NewEventStateManager(), db)
+	errHandled, _, _ := CreateHorizonDevice(hd, errorhandler, getDummyGetOrg(), getDummyGetPatternsWithContext(), getExchangeVersion, getDummyPatchDeviceHandler(), getDummyDeviceHandler(), nil, events.NewEventStateManager(), db)
 
 	if !errHandled {
 		t.Errorf("expected error")
@@ -375,7 +377,7 @@ func Test_CreateHorizonDevice0(t *testing.T) {
 		}
 	}
 
-	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getOrg, getPatterns, getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getExchangeDevice(""), events.NewEventStateManager(), db)
+	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getOrg, getPatterns, getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getExchangeDevice(""), nil, events.NewEventStateManager(), db)
 
 	if errHandled {
 		t.Errorf("unexpected error %v", myError)
@@ -436,7 +438,7 @@ func Test_CreateHorizonDevice_EnvVarDeviceid(t *testing.T) {
 		}
 	}
 
-	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getOrg, getPatterns, getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getExchangeDevice(""), events.NewEventStateManager(), db)
+	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getOrg, getPatterns, getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getExchangeDevice(""), nil, events.NewEventStateManager(), db)
 
 	if errHandled {
 		t.Errorf("unexpected error %v", myError)
@@ -496,12 +498,12 @@ func Test_CreateHorizonDevice_alreadythere(t *testing.T) {
 		}
 	}
 
-	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getOrg, getPatterns, getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getExchangeDevice(""), events.NewEventStateManager(), db)
+	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getOrg, getPatterns, getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getExchangeDevice(""), nil, events.NewEventStateManager(), db)
 	if errHandled {
 		t.Errorf("unexpected error %v", myError)
 	}
 
-	errHandled, device, exDevice = CreateHorizonDevice(hd, errorhandler, getOrg, getPatterns, getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getExchangeDevice(""), events.NewEventStateManager(), db)
+	errHandled, device, exDevice = CreateHorizonDevice(hd, errorhandler, getOrg, getPatterns, getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getExchangeDevice(""), nil, events.NewEventStateManager(), db)
 
 	if !errHandled {
 		t.Errorf("expected error")
@@ -561,7 +563,7 @@ func Test_CreateHorizonDevice_badorg(t *testing.T) {
 		}
 	}
 
-	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getOrg, getPatterns, getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getExchangeDevice(""), events.NewEventStateManager(), db)
+	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getOrg, getPatterns, getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getExchangeDevice(""), nil, events.NewEventStateManager(), db)
 
 	if !errHandled {
 		t.Errorf("expected error")
@@ -621,7 +623,7 @@ func Test_CreateHorizonDevice_badpattern(t *testing.T) {
 		}
 	}
 
-	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getOrg, getPatterns, getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getExchangeDevice(""), events.NewEventStateManager(), db)
+	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getOrg, getPatterns, getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getExchangeDevice(""), nil, events.NewEventStateManager(), db)
 
 	if !errHandled {
 		t.Errorf("expected error")
@@ -681,7 +683,7 @@ func Test_CreateHorizonDevice_conflictpattern(t *testing.T) {
 		}
 	}
 
-	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getOrg, getPatterns, getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getExchangeDevice("DifferentPattern"), events.NewEventStateManager(), db)
+	errHandled, device, exDevice := CreateHorizonDevice(hd, errorhandler, getOrg, getPatterns, getDummyGetExchangeVersion(), getDummyPatchDeviceHandler(), getExchangeDevice("DifferentPattern"), nil, events.NewEventStateManager(), db)
 
 	if !errHandled {
 		t.Errorf("expected error")
@@ -728,8 +730,8 @@ func Test_DeleteHorizonDevice_success(t *testing.T) {
 
 	if errHandled {
 		t.Errorf("unexpected error %v", myError)
-	} else if len(msgQueue) != 1 {
-		t.Errorf("there should be a message on the queue")
+	} else if len(msgQueue) != 2 {
+		t.Errorf("there should be the config state change and the shutdown messages on the queue, there are %v", len(msgQueue))
 	} else if dev, err := FindHorizonDeviceForOutput(db); err != nil {
 		t.Errorf("failed to find device in db, error %v", err)
 	} else if *dev.Config.State != persistence.CONFIGSTATE_UNCONFIGURING {
@@ -779,7 +781,7 @@ func Test_DeleteHorizonDevice_fail1(t *testing.T) {
 
 }
 
-// Patch of horizondevice fails because its in the wrong state
+// Patch of the pattern of horizondevice fails because its in the wrong state
 func Test_PatchHorizonDevice_fail1(t *testing.T) {
 
 	dir, db, err := utsetup()
@@ -799,15 +801,17 @@ func Test_PatchHorizonDevice_fail1(t *testing.T) {
 
 	myId := "testid"
 	myToken := "testToken"
+	otherPattern := "otherPattern"
 	hd := &HorizonDevice{
-		Id:    &myId,
-		Token: &myToken,
+		Id:      &myId,
+		Token:   &myToken,
+		Pattern: &otherPattern,
 	}
 
 	var myError error
 	errorhandler := GetPassThroughErrorHandler(&myError)
 
-	errHandled, dev1, dev2 := UpdateHorizonDevice(hd, errorhandler, getDummyGetExchangeVersion(), db)
+	errHandled, dev1, dev2 := UpdateHorizonDevice(context.Background(), hd, errorhandler, getDummyGetExchangeVersion(), getDummyDeviceHandler(), getDummyGetPatterns(), getDummyServiceDefResolver(), getDummyPatchDeviceHandler(), make(chan events.Message, 10), db, getBasicConfig())
 
 	if !errHandled {
 		t.Errorf("expected error")
@@ -822,6 +826,207 @@ func Test_PatchHorizonDevice_fail1(t *testing.T) {
 	}
 }
 
+// The token of a configured node is rotated once the exchange accepts it, the old one is kept when it does not
+func Test_PatchHorizonDevice_token(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	myOrg := "myorg"
+	device := getBasicDevice(myOrg, "mypattern")
+
+	_, err = persistence.SaveNewExchangeDevice(db, *device.Id, *device.Token, *device.Name, "", false, *device.Org, *device.Pattern, persistence.CONFIGSTATE_CONFIGURED)
+	if err != nil {
+		t.Errorf("unexpected error creating device %v", err)
+	}
+
+	validToken := "rotatedToken"
+	getDevice := func(id string, token string) (*exchange.Device, error) {
+		if id != "myorg/testid" {
+			t.Errorf("the node record should be read with the node's id, got %v", id)
+		} else if token != validToken {
+			return nil, fmt.Errorf("invalid credentials")
+		}
+		return &exchange.Device{}, nil
+	}
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+	msgQueue := make(chan events.Message, 10)
+
+	wrongToken := "wrongToken"
+	hd := &HorizonDevice{Id: device.Id, Token: &wrongToken}
+	errHandled, _, _ := UpdateHorizonDevice(context.Background(), hd, errorhandler, getDummyGetExchangeVersion(), getDevice, getDummyGetPatterns(), getDummyServiceDefResolver(), getDummyPatchDeviceHandler(), msgQueue, db, getBasicConfig())
+
+	if !errHandled {
+		t.Errorf("a token that the exchange does not accept should be rejected")
+	} else if apiErr, ok := myError.(*APIUserInputError); !ok || apiErr.Input != "device.token" {
+		t.Errorf("the error should be about the token, got (%T) %v", myError, myError)
+	} else if pDevice, err := persistence.FindExchangeDevice(db); err != nil || pDevice.Token != *device.Token {
+		t.Errorf("the node should keep its token, is %v, error %v", pDevice, err)
+	} else if len(msgQueue) != 0 {
+		t.Errorf("there should be no message, received %v", len(msgQueue))
+	}
+
+	hd.Token = &validToken
+	errHandled, _, exDev := UpdateHorizonDevice(context.Background(), hd, errorhandler, getDummyGetExchangeVersion(), getDevice, getDummyGetPatterns(), getDummyServiceDefResolver(), getDummyPatchDeviceHandler(), msgQueue, db, getBasicConfig())
+
+	if errHandled {
+		t.Errorf("unexpected error %v", myError)
+	} else if exDev == nil || *exDev.Config.State != persistence.CONFIGSTATE_CONFIGURED {
+		t.Errorf("the node should still be configured, is %v", exDev)
+	} else if pDevice, err := persistence.FindExchangeDevice(db); err != nil || pDevice.Token != validToken {
+		t.Errorf("the node should have the new token, is %v, error %v", pDevice, err)
+	} else if len(msgQueue) != 1 {
+		t.Errorf("there should be 1 message, received %v", len(msgQueue))
+	} else if msg, ok := (<-msgQueue).(*events.NodeTokenMessage); !ok || msg.Event().Id != events.NODE_TOKEN_ROTATED || msg.Token() != validToken || msg.Org() != myOrg {
+		t.Errorf("wrong message %v", msg)
+	}
+}
+
+// Patch of the pattern of a configuring node keeps the services that both patterns need
+func Test_PatchHorizonDevice_pattern(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	myOrg := "myorg"
+	device := getBasicDevice(myOrg, "myorg/pat1")
+
+	_, err = persistence.SaveNewExchangeDevice(db, *device.Id, *device.Token, *device.Name, "device", false, *device.Org, *device.Pattern, persistence.CONFIGSTATE_CONFIGURING)
+	if err != nil {
+		t.Errorf("unexpected error creating device %v", err)
+	}
+
+	// pat1 has top1, which needs the shared singleton at 1.0.0 and old. pat2 has top2, which needs the shared singleton
+	// at 2.0.0 and new.
+	patterns := map[string]string{"pat1": "top1", "pat2": "top2"}
+	getPatterns := func(org string, pattern string) (map[string]exchange.Pattern, error) {
+		top, ok := patterns[pattern]
+		if !ok {
+			return map[string]exchange.Pattern{}, nil
+		}
+		sref := exchange.ServiceReference{
+			ServiceURL:      top,
+			ServiceOrg:      myOrg,
+			ServiceArch:     cutil.ArchString(),
+			ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}},
+		}
+		return map[string]exchange.Pattern{fmt.Sprintf("%v/%v", org, pattern): {Services: []exchange.ServiceReference{sref}}}, nil
+	}
+
+	deps := map[string]map[string]string{"top1": {"shared": "1.0.0", "old": "1.0.0"}, "top2": {"shared": "2.0.0", "new": "1.0.0"}}
+	resolveService := func(wUrl string, wOrg string, wVersion string, wArch string) (map[string]exchange.ServiceDefinition, *exchange.ServiceDefinition, string, error) {
+		depDefs := map[string]exchange.ServiceDefinition{}
+		for url, version := range deps[wUrl] {
+			depDefs[fmt.Sprintf("%v/%v_%v", myOrg, url, version)] = exchange.ServiceDefinition{URL: url, Version: version, Arch: wArch, Sharable: exchange.MS_SHARING_MODE_SINGLETON}
+		}
+		return depDefs, &exchange.ServiceDefinition{URL: wUrl, Version: wVersion, Arch: wArch, Sharable: exchange.MS_SHARING_MODE_MULTIPLE}, fmt.Sprintf("%v/%v", myOrg, wUrl), nil
+	}
+
+	exchangePattern := ""
+	patchDevice := func(deviceId string, deviceToken string, pdr *exchange.PatchDeviceRequest) error {
+		if pdr.Pattern != nil {
+			exchangePattern = *pdr.Pattern
+		}
+		return nil
+	}
+
+	// the services configured for pat1, with their attributes
+	attributeIds := make(map[string]string)
+	for _, url := range []string{"shared", "old", "top1"} {
+		if err := persistence.SaveOrUpdateMicroserviceDef(db, &persistence.MicroserviceDefinition{SpecRef: url, Org: myOrg, Version: "1.0.0"}); err != nil {
+			t.Errorf("unexpected error creating service %v, error %v", url, err)
+		}
+		sps := new(persistence.ServiceSpecs)
+		sps.AppendServiceSpec(persistence.ServiceSpec{Url: url, Org: myOrg})
+		if attr, err := persistence.SaveOrUpdateAttribute(db, &persistence.UserInputAttributes{
+			Meta:         &persistence.AttributeMeta{Type: "UserInputAttributes"},
+			ServiceSpecs: sps,
+			Mappings:     map[string]interface{}{"VAR": url},
+		}, "", false); err != nil {
+			t.Fatalf("unexpected error creating attribute for %v, error %v", url, err)
+		} else {
+			attributeIds[url] = (*attr).GetMeta().Id
+		}
+	}
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+	msgQueue := make(chan events.Message, 10)
+
+	newPattern := "pat2"
+	hd := &HorizonDevice{Id: device.Id, Token: device.Token, Pattern: &newPattern}
+
+	errHandled, _, exDev := UpdateHorizonDevice(context.Background(), hd, errorhandler, getDummyGetExchangeVersion(), getDummyDeviceHandler(), getPatterns, resolveService, patchDevice, msgQueue, db, getBasicConfig())
+
+	if errHandled {
+		t.Errorf("unexpected error %v", myError)
+	} else if exDev == nil || *exDev.Pattern != "myorg/pat2" {
+		t.Errorf("the node should have the new pattern, is %v", exDev)
+	} else if exchangePattern != "myorg/pat2" {
+		t.Errorf("the node in the exchange should have the new pattern, is %v", exchangePattern)
+	} else if len(msgQueue) != 1 {
+		t.Errorf("there should be 1 message, received %v", len(msgQueue))
+	} else if msg, ok := (<-msgQueue).(*events.NodePatternMessage); !ok || msg.Event().Id != events.NODE_PATTERN_UPDATED || msg.Pattern != "myorg/pat2" {
+		t.Errorf("wrong message %v", msg)
+	}
+
+	// the shared singleton at another version is registered again by the autoconfig, but keeps its attribute, the
+	// services that pat2 does not need are removed with their attributes
+	for url, kept := range map[string]bool{"shared": true, "old": false, "top1": false} {
+		if msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter(), persistence.UrlOrgMSFilter(url, myOrg)}); err != nil {
+			t.Errorf("unexpected error reading service %v, error %v", url, err)
+		} else if len(msdefs) != 0 {
+			t.Errorf("service %v should be removed, found %v", url, msdefs)
+		}
+
+		if attr, err := persistence.FindAttributeByKey(db, attributeIds[url]); err != nil {
+			t.Errorf("unexpected error reading attribute %v, error %v", url, err)
+		} else if kept && *attr == nil {
+			t.Errorf("the attribute of %v should be kept", url)
+		} else if !kept && *attr != nil {
+			t.Errorf("the attribute of %v should be removed, is %v", url, *attr)
+		}
+	}
+
+	// a node with a pattern cannot be left without one
+	noPattern := ""
+	hd.Pattern = &noPattern
+	myError = nil
+	errHandled, _, _ = UpdateHorizonDevice(context.Background(), hd, errorhandler, getDummyGetExchangeVersion(), getDummyDeviceHandler(), getPatterns, resolveService, patchDevice, msgQueue, db, getBasicConfig())
+
+	if !errHandled {
+		t.Errorf("expected error")
+	} else if apiErr, ok := myError.(*APIUserInputError); !ok {
+		t.Errorf("myError has the wrong type (%T)", myError)
+	} else if apiErr.Input != "device.pattern" {
+		t.Errorf("wrong error input field %v", *apiErr)
+	}
+
+	// a pattern that is not in the exchange
+	unknown := "pat3"
+	hd.Pattern = &unknown
+	myError = nil
+	errHandled, _, _ = UpdateHorizonDevice(context.Background(), hd, errorhandler, getDummyGetExchangeVersion(), getDummyDeviceHandler(), getPatterns, resolveService, patchDevice, msgQueue, db, getBasicConfig())
+
+	if !errHandled {
+		t.Errorf("expected error")
+	} else if apiErr, ok := myError.(*APIUserInputError); !ok {
+		t.Errorf("myError has the wrong type (%T)", myError)
+	} else if apiErr.Input != "device.pattern" {
+		t.Errorf("wrong error input field %v", *apiErr)
+	} else if pDevice, err := persistence.FindExchangeDevice(db); err != nil || pDevice.Pattern != "myorg/pat2" {
+		t.Errorf("the node should keep its pattern, is %v, error %v", pDevice, err)
+	}
+}
+
 func getBasicDevice(org string, pattern string) *HorizonDevice {
 	myId := "testid"
 	myName := "testName"
@@ -1,10 +1,12 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/boltdb/bolt"
 	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
 	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
@@ -12,6 +14,8 @@ import (
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/policy"
 	"github.com/open-horizon/anax/semanticversion"
+	"sort"
+	"strings"
 )
 
 // Return an empty user input object or the object that's in the local database.
@@ -237,3 +241,131 @@ func ValidateUserInput(pDevice *persistence.ExchangeDevice, userInput policy.Use
 
 	return true, nil
 }
+
+// The user input variables that a POST of /node/userinput sets on a service of the node's pattern, when its body has the
+// variables by service rather than a node user input array.
+type ServiceUserInputOutput struct {
+	Url       string   `json:"url"`
+	Org       string   `json:"organization"`
+	Version   string   `json:"version"` // the version range of the service that the variables were checked against
+	Variables []string `json:"variables"`
+}
+
+// Set the user input variables of the services of the node's pattern, given as variable name/value pairs by org/url, so
+// that the services can all be configured with a single request before the node is changed to configured. Each service
+// must be one that the autoconfig of the pattern configures, and each variable must be one of its user inputs, with a
+// value of its type. All the services are checked before any is changed, the problems of all of them are returned in a
+// MultiServiceConfigError and nothing is saved. The variables are then added to the node user input, where the
+// autoconfig finds them.
+func UpdateServicesUserInput(input map[string]map[string]interface{},
+	errorhandler DeviceErrorHandler,
+	getPatterns exchange.PatternHandler,
+	resolveService exchange.ServiceDefResolverHandler,
+	getService exchange.ServiceHandler,
+	getDevice exchange.DeviceHandler,
+	patchDevice exchange.PatchDeviceHandler,
+	db *bolt.DB,
+	config *config.HorizonConfig) (bool, []ServiceUserInputOutput, []*events.NodeUserInputMessage) {
+
+	pDevice, err := persistence.FindExchangeDevice(db)
+	if err != nil {
+		return errorhandler(nil, NewSystemError(fmt.Sprintf("Unable to read node object, error %v", err)).WithCode(ERR_DATABASE)), nil, nil
+	} else if pDevice == nil {
+		return errorhandler(nil, NewNotFoundError("Exchange registration not recorded. Complete account and node registration with an exchange and then record node registration using this API's /node path.", "node").WithCode(ERR_NODE_NOT_REGISTERED)), nil, nil
+	} else if pDevice.Pattern == "" {
+		return errorhandler(pDevice, NewAPIUserInputError("The node has no pattern, the user input of its services must be set as an array of node user input.", "userinput").WithCode(ERR_INVALID_INPUT)), nil, nil
+	} else if len(input) == 0 {
+		return errorhandler(pDevice, NewAPIUserInputError("No service is given.", "userinput").WithCode(ERR_INVALID_INPUT)), nil, nil
+	}
+
+	// The services that the autoconfig of the pattern configures, with the version range and arch it configures them with.
+	services, err := dryRunAutoconfig(context.Background(), pDevice, true, pDevice.Config.Excluded, newOptionalServices(pDevice.Config.Optional), pDevice.Config.Channel, getPatterns, resolveService, getService, db, config)
+	if err != nil {
+		return errorhandler(pDevice, err), nil, nil
+	}
+
+	orgUrls := make([]string, 0, len(input))
+	for orgUrl := range input {
+		orgUrls = append(orgUrls, orgUrl)
+	}
+	sort.Strings(orgUrls)
+
+	out := make([]ServiceUserInputOutput, 0, len(input))
+	userInput := make([]policy.UserInput, 0, len(input))
+	problems := make([]ServiceConfigProblem, 0, 5)
+	for _, orgUrl := range orgUrls {
+		org, url := cutil.SplitOrgSpecUrl(orgUrl)
+		if org == "" || url == "" {
+			problems = append(problems, NewServiceConfigProblem(url, org, "", NewAPIUserInputError(fmt.Sprintf("the service %v must be given as org/url", orgUrl), "userinput").WithCode(ERR_INVALID_INPUT)))
+			continue
+		}
+
+		var service *AutoconfigService
+		for i := range services {
+			if services[i].Org == org && cutil.SameSpecURL(services[i].Url, url) {
+				service = &services[i]
+				break
+			}
+		}
+		if service == nil {
+			problems = append(problems, NewServiceConfigProblem(url, org, "", NewAPIUserInputError(fmt.Sprintf("The service %v is not one that pattern %v configures on the node.", orgUrl, pDevice.Pattern), "userinput."+orgUrl).WithCode(ERR_SERVICE_NOT_FOUND)))
+			continue
+		}
+
+		sdef, _, err := getService(service.Url, service.Org, service.Version, service.Arch)
+		if (err != nil || sdef == nil) && service.Arch != cutil.ArchString() {
+			sdef, _, err = getService(service.Url, service.Org, service.Version, cutil.ArchString())
+		}
+		if err != nil || sdef == nil {
+			problems = append(problems, NewServiceConfigProblem(service.Url, service.Org, service.Version, NewAPIUserInputError(fmt.Sprintf("Unable to find the service definition using %v/%v %v %v in the exchange.", service.Org, service.Url, service.Version, service.Arch), "userinput."+orgUrl).WithCode(ERR_SERVICE_NOT_FOUND)))
+			continue
+		}
+
+		names := make([]string, 0, len(input[orgUrl]))
+		for name := range input[orgUrl] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		inputs := make([]policy.Input, 0, len(names))
+		errs := []string{}
+		variables := []UserInputVariableProblem{}
+		for _, name := range names {
+			value := input[orgUrl][name]
+			ui := sdef.GetUserInputName(name)
+			if ui == nil {
+				errs = append(errs, fmt.Sprintf("Variable %v is not a user input of service %v.", name, cutil.FormOrgSpecUrl(service.Url, service.Org)))
+				variables = append(variables, UserInputVariableProblem{Name: name, Err: "not defined by the service"})
+			} else if err := cutil.VerifyWorkloadVarTypes(value, ui.Type); err != nil {
+				errs = append(errs, fmt.Sprintf(cutil.ANAX_SVC_WRONG_TYPE+"%v", name, cutil.FormOrgSpecUrl(service.Url, service.Org), err))
+				variables = append(variables, UserInputVariableProblem{Name: ui.Name, Type: ui.Type, DefaultValue: ui.DefaultValue, Err: err.Error()})
+			} else {
+				inputs = append(inputs, policy.Input{Name: name, Value: value})
+			}
+		}
+
+		if len(variables) != 0 {
+			problem := NewServiceConfigProblem(service.Url, service.Org, service.Version, NewAPIUserInputError(strings.Join(errs, " "), "userinput."+orgUrl).WithCode(ERR_INVALID_VARIABLE))
+			problem.Variables = variables
+			problems = append(problems, problem)
+		} else if len(inputs) != 0 {
+			userInput = append(userInput, policy.UserInput{ServiceOrgid: service.Org, ServiceUrl: service.Url, ServiceVersionRange: "[0.0.0,INFINITY)", Inputs: inputs})
+			out = append(out, ServiceUserInputOutput{Url: service.Url, Org: service.Org, Version: service.Version, Variables: names})
+		}
+	}
+
+	if len(problems) != 0 {
+		return errorhandler(pDevice, NewMultiServiceConfigError(fmt.Sprintf("The user input of %v of the services is not valid, none of it is saved.", len(problems)), "userinput", problems)), nil, nil
+	}
+
+	if err := exchangesync.PatchNodeUserInput(pDevice, db, userInput, getDevice, patchDevice); err != nil {
+		return errorhandler(pDevice, NewSystemError(fmt.Sprintf("Unable to add the user input of the services to the node user input. %v", err))), nil, nil
+	}
+	LogDeviceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_NEW_NODE_UI, userInput), persistence.EC_NODE_USERINPUT_UPDATED, pDevice)
+
+	changedSvcSpecs := new(persistence.ServiceSpecs)
+	for _, ui := range userInput {
+		changedSvcSpecs.AppendServiceSpec(persistence.ServiceSpec{Url: ui.ServiceUrl, Org: ui.ServiceOrgid})
+	}
+	return false, out, []*events.NodeUserInputMessage{events.NewNodeUserInputMessage(events.UPDATE_NODE_USERINPUT, *changedSvcSpecs)}
+}
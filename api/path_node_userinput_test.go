@@ -0,0 +1,102 @@
+// +build unit
+
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+)
+
+// The variables of all the services of the pattern are set at once, or none of them when any is not valid, and the
+// autoconfig then finds them.
+func Test_UpdateServicesUserInput(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "device", false, "myorg", "mypattern", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	ui := exchange.UserInput{Name: "missingVar", Label: "label", Type: "string"}
+	mURL := "http://utest.com/mservice"
+	sr := exchange.ServiceReference{
+		ServiceURL:      "http://mydomain.com/workload/test1",
+		ServiceOrg:      "testorg",
+		ServiceArch:     cutil.ArchString(),
+		ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}},
+	}
+	getPatterns := getVariablePatternHandler(sr)
+	sResolver := getVariableServiceDefResolver(mURL, "myorg", "1.0.0", cutil.ArchString(), &ui)
+	getService := func(mUrl string, mOrg string, mVersion string, mArch string) (*exchange.ServiceDefinition, string, error) {
+		sdef, id, err := getVariableServiceHandler(ui)(mUrl, mOrg, mVersion, mArch)
+		sdef.Version = "1.0.0"
+		return sdef, id, err
+	}
+	getDevice := func(id string, token string) (*exchange.Device, error) {
+		return &exchange.Device{}, nil
+	}
+
+	update := func(input map[string]map[string]interface{}) (bool, []ServiceUserInputOutput, error) {
+		var myError error
+		errorhandler := GetPassThroughErrorHandler(&myError)
+		userinput_error_handler := func(device interface{}, err error) bool {
+			return errorhandler(err)
+		}
+		errHandled, out, _ := UpdateServicesUserInput(input, userinput_error_handler, getPatterns, sResolver, getService, getDevice, getDummyPatchDeviceHandler(), db, getBasicConfig())
+		return errHandled, out, myError
+	}
+
+	// a value of the wrong type, a variable the service does not have and a service that is not in the pattern
+	errHandled, _, myError := update(map[string]map[string]interface{}{
+		"myorg/" + mURL:                  {"missingVar": 5},
+		"testorg/" + sr.ServiceURL:       {"missingVar": "ok", "otherVar": "x"},
+		"myorg/http://utest.com/unknown": {"missingVar": "ok"},
+	})
+	if !errHandled {
+		t.Fatalf("the user input should be rejected")
+	} else if multiErr, ok := myError.(*MultiServiceConfigError); !ok || len(multiErr.Services) != 3 {
+		t.Fatalf("each service should have its problem, got (%T) %v", myError, myError)
+	} else {
+		codes := map[string]string{mURL: ERR_INVALID_VARIABLE, sr.ServiceURL: ERR_INVALID_VARIABLE, "http://utest.com/unknown": ERR_SERVICE_NOT_FOUND}
+		for _, p := range multiErr.Services {
+			if p.Code != codes[p.Url] {
+				t.Errorf("service %v should have the code %v, got %v", p.Url, codes[p.Url], p)
+			}
+		}
+	}
+	if nodeUserInput, err := persistence.FindNodeUserInput(db); err != nil {
+		t.Errorf("unable to read the node user input, error %v", err)
+	} else if len(nodeUserInput) != 0 {
+		t.Errorf("none of the user input should be saved, got %v", nodeUserInput)
+	}
+
+	errHandled, out, myError := update(map[string]map[string]interface{}{
+		"myorg/" + mURL:            {"missingVar": "a"},
+		"testorg/" + sr.ServiceURL: {"missingVar": "b"},
+	})
+	if errHandled {
+		t.Fatalf("unexpected error (%T) %v", myError, myError)
+	} else if len(out) != 2 {
+		t.Errorf("both services should be set, got %v", out)
+	}
+
+	// the autoconfig no longer misses any variable
+	pDevice, _ := persistence.FindExchangeDevice(db)
+	if services, err := dryRunAutoconfig(context.Background(), pDevice, false, nil, nil, "", getPatterns, sResolver, getService, db, getBasicConfig()); err != nil {
+		t.Errorf("unexpected dry run error %v", err)
+	} else {
+		for _, service := range services {
+			if service.MissingConfig != "" {
+				t.Errorf("service %v should not miss any variable", service)
+			}
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
 	"github.com/open-horizon/anax/config"
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/policy"
@@ -99,3 +100,52 @@ func FindServicesForOutput(pm *policy.PolicyManager,
 
 	return wrap, nil
 }
+
+// Gather the status of the service instances, or of the one with the given key when it is not empty, for output. The
+// archived instances are only included when asked for. The containers are listed once for all the instances, when the
+// container runtime cannot be reached the instances are returned without them.
+func FindServiceInstancesForOutput(db *bolt.DB, config *config.HorizonConfig, key string, includeArchived bool) ([]*ServiceInstanceStatus, error) {
+
+	filters := []persistence.MIFilter{}
+	if key != "" {
+		filters = append(filters, func(e persistence.MicroserviceInstance) bool { return e.GetKey() == key })
+	} else if !includeArchived {
+		filters = append(filters, persistence.UnarchivedMIFilter())
+	}
+
+	msinsts, err := persistence.FindMicroserviceInstances(db, filters)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to read service instances, error %v", err))
+	}
+
+	health, err := persistence.FindContainerHealth(db)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to read container health, error %v", err))
+	}
+
+	containers, containerErr := GetServiceInstanceContainers(config.Edge.DockerEndpoint)
+	if containerErr != nil {
+		glog.Warningf(apiLogString(fmt.Sprintf("unable to get the service instance containers, error %v", containerErr)))
+	}
+
+	out := make([]*ServiceInstanceStatus, 0, len(msinsts))
+	for _, msinst := range msinsts {
+		// the definition of an instance can be gone once the instance is archived
+		var msdef *persistence.MicroserviceDefinition
+		if msinst.MicroserviceDefId != "" {
+			if msdef, err = persistence.FindMicroserviceDefWithKey(db, msinst.MicroserviceDefId); err != nil {
+				glog.Warningf(apiLogString(fmt.Sprintf("unable to read service definition %v of service instance %v, error %v", msinst.MicroserviceDefId, msinst.GetKey(), err)))
+			}
+		}
+
+		status := NewServiceInstanceStatus(&msinst, msdef, containers[msinst.GetKey()], health)
+		if containerErr != nil && !msinst.Archived {
+			status.ContainerError = containerErr.Error()
+		}
+		out = append(out, status)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+
+	return out, nil
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/boltdb/bolt"
@@ -16,6 +17,7 @@ import (
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/policy"
 	"github.com/open-horizon/anax/semanticversion"
+	"os"
 	"strconv"
 	"strings"
 )
@@ -102,6 +104,8 @@ func FindServiceConfigForOutput(pm *policy.PolicyManager, db *bolt.DB) (map[stri
 		} else if msDefs != nil && len(msDefs) > 0 {
 			mc.AutoUpgrade = msDefs[0].AutoUpgrade
 			mc.ActiveUpgrade = msDefs[0].ActiveUpgrade
+			mc.VariableSources = msDefs[0].VariableSources
+			mc.Origin, mc.OriginPattern = msDefs[0].Origin, msDefs[0].OriginPattern
 		} else {
 			// take the default
 			mc.AutoUpgrade = microservice.MS_DEFAULT_AUTOUPGRADE
@@ -125,18 +129,28 @@ func FindServiceConfigForOutput(pm *policy.PolicyManager, db *bolt.DB) (map[stri
 	return out, nil
 }
 
-// Given a demarshalled Service object, validate it and save it, returning any errors.
-func CreateService(service *Service,
+// Given a demarshalled Service object, validate it and save it, returning any errors. The message to publish for the
+// policy of the service is returned, a PolicyCreatedMessage for a new service. In upsert mode, a service of the node's
+// pattern that is already registered at a version outside of the requested version range is updated to the version
+// the exchange resolves rather than being a DuplicateServiceError, its attributes are kept and a *serviceUpdate is
+// returned for its regenerated policy. A service with the same url in another org is a ConflictError then.
+func CreateService(ctx context.Context,
+	service *Service,
 	errorhandler ErrorHandler,
 	getPatterns exchange.PatternHandler,
 	resolveService exchange.ServiceDefResolverHandler,
 	getService exchange.ServiceHandler,
 	getDevice exchange.DeviceHandler,
 	patchDevice exchange.PatchDeviceHandler,
-	mergedUserInput *policy.UserInput, //nil for /service/config case. non-nil for auto-complete case to save some getPatterns calls.
+	userInputLayers []policy.UserInputLayer, //nil for /service/config case. non-nil for auto-complete case to save some getPatterns calls.
 	db *bolt.DB,
 	config *config.HorizonConfig,
-	from_user bool) (bool, *Service, *events.PolicyCreatedMessage) {
+	origin string,
+	upsert bool) (bool, *Service, events.Message) {
+
+	// The services of the autoconfig are the only ones that are not configured by the user, an import configures the
+	// services of the exported node as the user did on it.
+	from_user := origin != events.POLICY_ORIGIN_AUTOCONFIG
 
 	org_forlog := ""
 	if service.Org != nil {
@@ -156,16 +170,19 @@ func CreateService(service *Service,
 	// to the HTTP response.
 	pDevice, err := persistence.FindExchangeDevice(db)
 	if err != nil {
-		return errorhandler(NewSystemError(fmt.Sprintf("Unable to read horizondevice object, error %v", err))), nil, nil
+		return errorhandler(NewSystemError(fmt.Sprintf("Unable to read horizondevice object, error %v", err)).WithCode(ERR_DATABASE)), nil, nil
 	} else if pDevice == nil {
-		return errorhandler(NewAPIUserInputError("Exchange registration not recorded. Complete account and device registration with an exchange and then record device registration using this API's /horizondevice path.", "service")), nil, nil
+		return errorhandler(NewAPIUserInputError("Exchange registration not recorded. Complete account and device registration with an exchange and then record device registration using this API's /horizondevice path.", "service").WithCode(ERR_NODE_NOT_REGISTERED)), nil, nil
 	}
 
-	glog.V(5).Infof(apiLogString(fmt.Sprintf("Create service payload: %v", service)))
+	// Only the services of a pattern are updated to the version the pattern requires.
+	upsert = upsert && pDevice.Pattern != ""
+
+	glog.V(5).Infof(apiRequestLogString(ctx, fmt.Sprintf("Create service payload: %v", service)))
 
 	// Validate all the inputs in the service object.
 	if *service.Url == "" {
-		return errorhandler(NewAPIUserInputError("not specified", "service.url")), nil, nil
+		return errorhandler(NewAPIUserInputError("not specified", "service.url").WithCode(ERR_INVALID_INPUT)), nil, nil
 	}
 	if bail := checkInputString(errorhandler, "service.url", service.Url); bail {
 		return true, nil, nil
@@ -178,13 +195,13 @@ func CreateService(service *Service,
 		return true, nil, nil
 	}
 
-	// Return error if the arch in the service object is not a synonym of the node's arch.
+	// Return error if the arch in the service object is not a synonym of the node's arch or one of its AdditionalArchs.
 	// Use the device's arch if not specified in the service object.
 	thisArch := cutil.ArchString()
 	if service.Arch == nil || *service.Arch == "" {
 		service.Arch = &thisArch
-	} else if *service.Arch != thisArch && config.ArchSynonyms.GetCanonicalArch(*service.Arch) != thisArch {
-		return errorhandler(NewAPIUserInputError(fmt.Sprintf("arch %v is not supported by this node.", *service.Arch), "service.arch")), nil, nil
+	} else if *service.Arch != thisArch && config.ArchSynonyms.GetCanonicalArch(*service.Arch) != thisArch && !cutil.ArchSupported(config, *service.Arch) {
+		return errorhandler(NewAPIUserInputError(fmt.Sprintf("arch %v is not supported by this node.", *service.Arch), "service.arch").WithCode(ERR_UNSUPPORTED_ARCH)), nil, nil
 	} else if bail := checkInputString(errorhandler, "service.arch", service.Arch); bail {
 		return true, nil, nil
 	}
@@ -203,14 +220,14 @@ func CreateService(service *Service,
 			// We might be registering a dependent service, so look through the pattern and get a list of all dependent services, then
 			// come up with a common version for all references. If the service we're registering is one of these, then use the
 			// common version range in our service instead of the version range that was passed as input.
-			common_apispec_list, exchPattern, err := getSpecRefsForPattern(nodeType, pattern_name, pattern_org, getPatterns, resolveService, db, config, false, false)
+			common_apispec_list, exchPattern, err := getSpecRefsForPattern(ctx, nodeType, pattern_name, pattern_org, getPatterns, resolveService, db, config, nil, nil, "", false, false, nil)
 			if err != nil {
 				return errorhandler(err), nil, nil
 			}
 
 			if len(*common_apispec_list) != 0 {
 				for _, apiSpec := range *common_apispec_list {
-					if apiSpec.SpecRef == *service.Url && apiSpec.Org == *service.Org {
+					if cutil.SameSpecURL(apiSpec.SpecRef, *service.Url) && apiSpec.Org == *service.Org {
 						service.VersionRange = &apiSpec.Version
 						service.Arch = &apiSpec.Arch
 						break
@@ -219,21 +236,21 @@ func CreateService(service *Service,
 			}
 
 			// get the user input from the pattern so that we can merge it with the given service attributes to make sure all the necessary user inputs are set.
-			if mergedUserInput == nil {
+			if userInputLayers == nil {
 				var err1 error
-				mergedUserInput, err1 = getMergedUserInput(exchPattern.UserInput, *service.Url, *service.Org, *service.Arch, db)
+				userInputLayers, err1 = getUserInputLayers(exchPattern.UserInput, db)
 				if err1 != nil {
-					return errorhandler(NewSystemError(fmt.Sprintf("Failed to get the service config from the merged node user input with pattern user input. %v", err1))), nil, nil
+					return errorhandler(NewSystemError(fmt.Sprintf("Failed to get the service config from the merged node user input with pattern user input. %v", err1)).WithCode(ERR_DATABASE)), nil, nil
 				}
 			}
 		}
 	} else {
 		// this is the case where /service/config is called for policy
-		if mergedUserInput == nil {
+		if userInputLayers == nil {
 			var err1 error
-			mergedUserInput, err1 = getMergedUserInput([]policy.UserInput{}, *service.Url, *service.Org, *service.Arch, db)
+			userInputLayers, err1 = getUserInputLayers([]policy.UserInput{}, db)
 			if err1 != nil {
-				return errorhandler(NewSystemError(fmt.Sprintf("Failed to get the service config from the node user input. %v", err1))), nil, nil
+				return errorhandler(NewSystemError(fmt.Sprintf("Failed to get the service config from the node user input. %v", err1)).WithCode(ERR_DATABASE)), nil, nil
 			}
 		}
 	}
@@ -250,7 +267,7 @@ func CreateService(service *Service,
 	// Convert the sensor version to a version expression.
 	vExp, err := semanticversion.Version_Expression_Factory(*service.VersionRange)
 	if err != nil {
-		return errorhandler(NewAPIUserInputError(fmt.Sprintf("versionRange %v cannot be converted to a version expression, error %v", *service.VersionRange, err), "service.versionRange")), nil, nil
+		return errorhandler(NewAPIUserInputError(fmt.Sprintf("versionRange %v cannot be converted to a version expression, error %v", *service.VersionRange, err), "service.versionRange").WithCode(ERR_INVALID_VERSION_RANGE)), nil, nil
 	}
 
 	// Verify with the exchange to make sure the service definition is readable by this node.
@@ -259,17 +276,19 @@ func CreateService(service *Service,
 	var err1 error
 	sdef, _, err1 = getService(*service.Url, *service.Org, vExp.Get_expression(), *service.Arch)
 	if err1 != nil || sdef == nil {
-		if *service.Arch == thisArch {
+		// The arch can be a synonym of the one the service is defined for, the node's or one of its AdditionalArchs.
+		canonicalArch := cutil.CanonicalArch(*service.Arch)
+		if *service.Arch == canonicalArch {
 			// failed with user defined arch
-			return errorhandler(NewAPIUserInputError(fmt.Sprintf("Unable to find the service definition using %v/%v %v %v in the exchange.", *service.Org, *service.Url, vExp.Get_expression(), *service.Arch), "service")), nil, nil
+			return errorhandler(NewAPIUserInputError(fmt.Sprintf("Unable to find the service definition using %v/%v %v %v in the exchange.", *service.Org, *service.Url, vExp.Get_expression(), *service.Arch), "service").WithCode(ERR_SERVICE_NOT_FOUND)), nil, nil
 		} else {
-			// try node's arch
-			sdef, _, err1 = getService(*service.Url, *service.Org, vExp.Get_expression(), thisArch)
+			// try the canonical arch
+			sdef, _, err1 = getService(*service.Url, *service.Org, vExp.Get_expression(), canonicalArch)
 			if err1 != nil || sdef == nil {
 				if pDevice.Pattern != "" {
-					return errorhandler(NewAPIUserInputError(fmt.Sprintf("Unable to find the service definition using  %v/%v %v %v in the exchange. Please ensure all services referenced in the user input file are included in pattern %v.", *service.Org, *service.Url, vExp.Get_expression(), thisArch, pDevice.Pattern), "service")), nil, nil
+					return errorhandler(NewAPIUserInputError(fmt.Sprintf("Unable to find the service definition using  %v/%v %v %v in the exchange. Please ensure all services referenced in the user input file are included in pattern %v.", *service.Org, *service.Url, vExp.Get_expression(), canonicalArch, pDevice.Pattern), "service").WithCode(ERR_SERVICE_NOT_FOUND)), nil, nil
 				}
-				return errorhandler(NewAPIUserInputError(fmt.Sprintf("Unable to find the service definition using %v/%v %v %v in the exchange.", *service.Org, *service.Url, vExp.Get_expression(), thisArch), "service")), nil, nil
+				return errorhandler(NewAPIUserInputError(fmt.Sprintf("Unable to find the service definition using %v/%v %v %v in the exchange.", *service.Org, *service.Url, vExp.Get_expression(), canonicalArch), "service").WithCode(ERR_SERVICE_NOT_FOUND)), nil, nil
 			}
 		}
 	}
@@ -277,13 +296,13 @@ func CreateService(service *Service,
 	// make sure that the node type and the service type match
 	serviceType := sdef.GetServiceType()
 	if serviceType != exchange.SERVICE_TYPE_BOTH && nodeType != serviceType {
-		return errorhandler(NewTypeMismatchError(fmt.Sprintf("Type mismatch. The service %v/%v is for '%v' node type but the current node type is '%v'.", *service.Org, *service.Url, serviceType, nodeType), "service")), nil, nil
+		return errorhandler(NewTypeMismatchError(fmt.Sprintf("Type mismatch. The service %v/%v is for '%v' node type but the current node type is '%v'.", *service.Org, *service.Url, serviceType, nodeType), "service").WithCode(ERR_NODE_TYPE_MISMATCH)), nil, nil
 	}
 
 	// Convert the service definition to a persistent format so that it can be saved to the db.
 	msdef, err = microservice.ConvertServiceToPersistent(sdef, *service.Org)
 	if err != nil {
-		return errorhandler(NewAPIUserInputError(fmt.Sprintf("Error converting the service metadata to persistent.MicroserviceDefinition for %v/%v version %v, error %v", *service.Org, sdef.URL, sdef.Version, err), "service")), nil, nil
+		return errorhandler(NewAPIUserInputError(fmt.Sprintf("Error converting the service metadata to persistent.MicroserviceDefinition for %v/%v version %v, error %v", *service.Org, sdef.URL, sdef.Version, err), "service").WithCode(ERR_EXCHANGE_UNREACHABLE)), nil, nil
 	}
 
 	// Save some of the items in the MicroserviceDefinition object for use in the upgrading process.
@@ -307,15 +326,42 @@ func CreateService(service *Service,
 	// the actual version of the service so that we know if we need to upgrade in the future.
 	service.VersionRange = &msdef.Version
 
-	// Check if the service has been registered or not (currently only support one service registration)
-	if pms, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter(), persistence.UrlOrgMSFilter(*service.Url, *service.Org)}); err != nil {
-		return errorhandler(NewSystemError(fmt.Sprintf("Error accessing db to find service definition: %v", err))), nil, nil
-	} else if pms != nil && len(pms) > 0 {
+	// Check if the service has been registered or not (currently only support one service registration). In upsert
+	// mode, the registered service is updated when its version is not in the requested range.
+	var existing *persistence.MicroserviceDefinition
+	registered, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter(), persistence.UrlMSFilter(*service.Url)})
+	if err != nil {
+		return errorhandler(NewSystemError(fmt.Sprintf("Error accessing db to find service definition: %v", err)).WithCode(ERR_DATABASE)), nil, nil
+	}
+	for i, pms := range registered {
+		if pms.Org != *service.Org {
+			// The same url in another org is another service, it cannot be updated to this one.
+			if upsert {
+				return errorhandler(NewConflictError(fmt.Sprintf("The service %v is registered in org %v, it cannot be updated to %v/%v %v.", pms.SpecRef, pms.Org, *service.Org, *service.Url, vExp.Get_expression())).WithCode(ERR_SERVICE_ALREADY_CONFIGURED)), nil, nil
+			}
+			continue
+		}
+
+		if upsert {
+			if inRange, err := vExp.Is_within_range(pms.Version); err != nil {
+				return errorhandler(NewSystemError(fmt.Sprintf("Unable to compare version %v of the registered service %v/%v with the range %v, error %v", pms.Version, pms.Org, pms.SpecRef, vExp.Get_expression(), err)).WithCode(ERR_INVALID_VERSION_RANGE)), nil, nil
+			} else if !inRange {
+				existing = &registered[i]
+				break
+			}
+		}
+
 		// this is for the auto service registration case.
 		if !from_user {
 			LogServiceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_COMPLETE_SVC_AUTO_CONFIG, *service.Org, *service.Url), persistence.EC_SERVICE_CONFIG_COMPLETE, service)
 		}
-		return errorhandler(NewDuplicateServiceError(fmt.Sprintf("Duplicate registration for %v/%v %v %v. Only one registration per service is supported.", *service.Org, *service.Url, vExp.Get_expression(), cutil.ArchString()), "service")), nil, nil
+		return errorhandler(NewDuplicateServiceError(fmt.Sprintf("Duplicate registration for %v/%v %v %v. Only one registration per service is supported.", *service.Org, *service.Url, vExp.Get_expression(), *service.Arch), "service").WithCode(ERR_SERVICE_ALREADY_CONFIGURED)), nil, nil
+	}
+
+	// The updated service keeps what was set on it when it was registered.
+	if existing != nil {
+		msdef.Name, msdef.AutoUpgrade, msdef.ActiveUpgrade = existing.Name, existing.AutoUpgrade, existing.ActiveUpgrade
+		service.Name = &msdef.Name
 	}
 
 	// Validate any attributes specified in the attribute list and convert them to persistent objects.
@@ -330,10 +376,10 @@ func CreateService(service *Service,
 			// Loop through each input variable and verify that it is defined in the service's user input section, and that the
 			// type matches.
 			for varName, varValue := range attr.GetGenericMappings() {
-				glog.V(5).Infof(apiLogString(fmt.Sprintf("checking input variable: %v", varName)))
+				glog.V(5).Infof(apiRequestLogString(ctx, fmt.Sprintf("checking input variable: %v", varName)))
 				if ui := msdef.GetUserInputName(varName); ui != nil {
 					if err := cutil.VerifyWorkloadVarTypes(varValue, ui.Type); err != nil {
-						return errorhandler(NewAPIUserInputError(fmt.Sprintf(cutil.ANAX_SVC_WRONG_TYPE+"%v", varName, cutil.FormOrgSpecUrl(*service.Url, *service.Org), err), "variables")), nil
+						return errorhandler(NewAPIUserInputError(fmt.Sprintf(cutil.ANAX_SVC_WRONG_TYPE+"%v", varName, cutil.FormOrgSpecUrl(*service.Url, *service.Org), err), "variables").WithCode(ERR_INVALID_VARIABLE)), nil
 					}
 				}
 			}
@@ -349,22 +395,28 @@ func CreateService(service *Service,
 		// If the device declared itself to be using a pattern, then it CANNOT specify any attributes that generate policy settings.
 		if pDevice.Pattern != "" {
 			if attr.GetMeta().Type == "MeteringAttributes" || attr.GetMeta().Type == "PropertyAttributes" || attr.GetMeta().Type == "AgreementProtocolAttributes" {
-				return errorhandler(NewAPIUserInputError(fmt.Sprintf("device is using a pattern %v, policy attributes are not supported.", pDevice.Pattern), "service.[attribute].type")), nil
+				return errorhandler(NewAPIUserInputError(fmt.Sprintf("device is using a pattern %v, policy attributes are not supported.", pDevice.Pattern), "service.[attribute].type").WithCode(ERR_INVALID_INPUT)), nil
 			}
 		}
 
 		return false, nil
 	}
 
+	// The attributes of an updated service are the ones it has, the ones of the request do not replace them.
 	var attributes []persistence.Attribute
-	if service.Attributes != nil {
+	if existing != nil {
+		var err error
+		if attributes, err = persistence.FindApplicableAttributes(db, *service.Url, *service.Org); err != nil {
+			return errorhandler(NewSystemError(fmt.Sprintf("Unable to fetch the attributes of service %v/%v, error %v", *service.Org, *service.Url, err)).WithCode(ERR_DATABASE)), nil, nil
+		}
+	} else if service.Attributes != nil {
 		// build a serviceAttribute for each one
 		var err error
 		var inputErrWritten bool
 
 		attributes, inputErrWritten, err = toPersistedAttributesAttachedToService(errorhandler, pDevice, *service.Attributes, persistence.NewServiceSpec(*service.Url, *service.Org), []AttributeVerifier{msdefAttributeVerifier, patternedDeviceAttributeVerifier})
 		if !inputErrWritten && err != nil {
-			return errorhandler(NewSystemError(fmt.Sprintf("Failure deserializing attributes: %v", err))), nil, nil
+			return errorhandler(NewSystemError(fmt.Sprintf("Failure deserializing attributes: %v", err)).WithCode(ERR_INVALID_INPUT)), nil, nil
 		} else if inputErrWritten {
 			return true, nil, nil
 		}
@@ -379,7 +431,7 @@ func CreateService(service *Service,
 	// There might be node wide global attributes. Check for them and grab the values to use as defaults for later.
 	allAttrs, aerr := persistence.FindApplicableAttributes(db, "", "")
 	if aerr != nil {
-		return errorhandler(NewSystemError(fmt.Sprintf("Unable to fetch global attributes, error %v", err))), nil, nil
+		return errorhandler(NewSystemError(fmt.Sprintf("Unable to fetch global attributes, error %v", err)).WithCode(ERR_DATABASE)), nil, nil
 	}
 
 	// For each node wide attribute, extract the value and save it for use later in this function.
@@ -387,13 +439,18 @@ func CreateService(service *Service,
 		// Extract HA property
 		if attr.GetMeta().Type == "HAAttributes" {
 			haPartner = attr.(persistence.HAAttributes).Partners
-			glog.V(5).Infof(apiLogString(fmt.Sprintf("Found default global HA attribute %v", attr)))
+			glog.V(5).Infof(apiRequestLogString(ctx, fmt.Sprintf("Found default global HA attribute %v", attr)))
 		}
 	}
 
 	// If an HA device has no HA attribute then the configuration is invalid.
 	if pDevice.HA && len(haPartner) == 0 {
-		return errorhandler(NewAPIUserInputError("services on an HA device must specify an HA partner.", "service.[attribute].type")), nil, nil
+		return errorhandler(NewAPIUserInputError("services on an HA device must specify an HA partner.", "service.[attribute].type").WithCode(ERR_INVALID_INPUT)), nil, nil
+	}
+
+	// Nothing is written once the caller has given up on the service, e.g. when the agent shuts down.
+	if err := configstateContextError(ctx); err != nil {
+		return errorhandler(err), nil, nil
 	}
 
 	// Persist all attributes on this service, and while we're at it, fetch the attribute values we need for the node side policy file.
@@ -421,43 +478,58 @@ func CreateService(service *Service,
 			agpl := attr.(*persistence.AgreementProtocolAttributes).Protocols
 			serviceAgreementProtocols = agpl.([]policy.AgreementProtocol)
 
+		// the attributes of an updated service, as read from the db
+		case persistence.UserInputAttributes:
+			bSave = false
+
+		case persistence.HAAttributes:
+			haPartner = attr.(persistence.HAAttributes).Partners
+
+		case persistence.AgreementProtocolAttributes:
+			protocols, _ := attr.(persistence.AgreementProtocolAttributes).Protocols.([]interface{})
+			if agpl, err := policy.ConvertToAgreementProtocolList(protocols); err != nil {
+				return errorhandler(NewSystemError(fmt.Sprintf("Error converting agreement protocol attribute %v to agreement protocol list, error: %v", attr, err)).WithCode(ERR_INVALID_INPUT)), nil, nil
+			} else if agpl != nil {
+				serviceAgreementProtocols = *agpl
+			}
+
 		default:
-			glog.V(4).Infof(apiLogString(fmt.Sprintf("Unhandled attr type (%T): %v", attr, attr)))
+			glog.V(4).Infof(apiRequestLogString(ctx, fmt.Sprintf("Unhandled attr type (%T): %v", attr, attr)))
 		}
 
-		if bSave {
+		if bSave && existing == nil {
 			_, err := persistence.SaveOrUpdateAttribute(db, attr, "", false)
 			if err != nil {
-				return errorhandler(NewSystemError(fmt.Sprintf("error saving attribute %v, error %v", attr, err))), nil, nil
+				return errorhandler(NewSystemError(fmt.Sprintf("error saving attribute %v, error %v", attr, err)).WithCode(ERR_DATABASE)), nil, nil
 			}
 		}
 	}
 
 	// merge the user input with the pattern and existing node user input to get a whole user input for this service
-	merged_ui := mergedUserInput
-	if len(userInput) > 0 {
-		if mergedUserInput == nil {
-			merged_ui = &userInput[0]
-		} else {
-			// there should be only one for this service
-			merged_ui, _ = policy.MergeUserInput(*mergedUserInput, userInput[0], false)
+	layers := append(userInputLayers[:len(userInputLayers):len(userInputLayers)], policy.UserInputLayer{Name: USER_INPUT_LAYER_SERVICE_CONFIG, Precedence: USER_INPUT_PRECEDENCE_SERVICE_CONFIG, UserInput: userInput})
+	var merged_ui *policy.UserInput
+	if mergedUserInput := policy.MergeUserInputLayers(*service.Url, *service.Org, msdef.Version, *service.Arch, layers); mergedUserInput != nil {
+		merged_ui = &mergedUserInput.UserInput
+		msdef.VariableSources = mergedUserInput.Sources
+		for _, conflict := range mergedUserInput.Conflicts {
+			glog.Warningf(apiRequestLogString(ctx, fmt.Sprintf("Conflicting user input for service %v/%v, variable %v is set to %v by %v, using the last value.", *service.Org, *service.Url, conflict.Key, conflict.Values, conflict.Layers)))
 		}
 	}
 
 	// make sure we have all the required user settings for this service. We can only check for the pattern case.
 	if present, missingVarName := validateUserInput(sdef, merged_ui); !present {
 		if pDevice.Pattern != "" {
-			return errorhandler(NewMSMissingVariableConfigError(fmt.Sprintf(cutil.ANAX_SVC_MISSING_VARIABLE, missingVarName, cutil.FormOrgSpecUrl(*service.Url, *service.Org)), "service.[attribute].mappings")), nil, nil
+			return errorhandler(NewMSMissingVariableConfigError(fmt.Sprintf(cutil.ANAX_SVC_MISSING_VARIABLE, missingVarName, cutil.FormOrgSpecUrl(*service.Url, *service.Org)), "service.[attribute].mappings").WithCode(ERR_MISSING_VARIABLE)), nil, nil
 		} else {
 			// For policy case, we do not know what business policy will form agreement with it, so we just give warning for the missing variable name
-			glog.Warningf(apiLogString(fmt.Sprintf("Variable %v is missing in the service configuration for %v/%v. It may prevent an agreement if the business policy does not contain the setting for the missing variable.", missingVarName, *service.Org, *service.Url)))
+			glog.Warningf(apiRequestLogString(ctx, fmt.Sprintf("Variable %v is missing in the service configuration for %v/%v. It may prevent an agreement if the business policy does not contain the setting for the missing variable.", missingVarName, *service.Org, *service.Url)))
 			LogServiceEvent(db, persistence.SEVERITY_WARN, persistence.NewMessageMeta(EL_API_ERR_MISS_VAR_IN_SVC_CONFIG, missingVarName, *service.Org, *service.Url), persistence.EC_WARNING_SERVICE_CONFIG, service)
 		}
 	}
 
 	if from_user && len(userInput) > 0 {
 		if err := exchangesync.PatchNodeUserInput(pDevice, db, userInput, getDevice, patchDevice); err != nil {
-			return errorhandler(NewSystemError(fmt.Sprintf("Failed to add the user input %v to node. %v", userInput, err))), nil, nil
+			return errorhandler(NewSystemError(fmt.Sprintf("Failed to add the user input %v to node. %v", userInput, err)).WithCode(ERR_DATABASE)), nil, nil
 		}
 	}
 
@@ -477,11 +549,38 @@ func CreateService(service *Service,
 		}
 	}
 
-	glog.V(5).Infof(apiLogString(fmt.Sprintf("Complete Attr list for registration of service %v/%v: %v", *service.Org, *service.Url, attributes)))
+	glog.V(5).Infof(apiRequestLogString(ctx, fmt.Sprintf("Complete Attr list for registration of service %v/%v: %v", *service.Org, *service.Url, attributes)))
+
+	// Record how the service came to exist, so that GET /service shows it.
+	originPattern := ""
+	if origin == events.POLICY_ORIGIN_AUTOCONFIG {
+		originPattern = pDevice.Pattern
+	}
+	msdef.Origin, msdef.OriginPattern = origin, originPattern
 
-	// Save the service definition in the local database.
+	// Save the service definition in the local database. An updated service is saved as a new service definition, as
+	// the service upgrade does, so that the instances of the previous version are cleaned up by the governance worker.
+	if existing != nil {
+		msdef.Origin, msdef.OriginPattern = existing.Origin, existing.OriginPattern
+	}
 	if err := persistence.SaveOrUpdateMicroserviceDef(db, msdef); err != nil {
-		return errorhandler(NewSystemError(fmt.Sprintf("Error saving service definition %v into db: %v", *msdef, err))), nil, nil
+		return errorhandler(NewSystemError(fmt.Sprintf("Error saving service definition %v into db: %v", *msdef, err)).WithCode(ERR_DATABASE)), nil, nil
+	}
+
+	// The previous version is archived, with its policy kept so that the update can be undone.
+	var update *serviceUpdate
+	if existing != nil {
+		var err error
+		if update, err = newServiceUpdate(db, *existing, msdef.Id, policy.ServicePolicyFileName(config.Edge.PolicyPath, pDevice.Org, *service.Url, *service.Org), config); err != nil {
+			return errorhandler(NewSystemError(fmt.Sprintf("Error updating service definition %v in db: %v", *msdef, err)).WithCode(ERR_DATABASE)), nil, nil
+		}
+		LogServiceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_SVC_UPDATED, *service.Org, *service.Url, existing.Version, msdef.Version), persistence.EC_SERVICE_CONFIG_COMPLETE, service)
+	}
+	updateError := func(err error) (bool, *Service, events.Message) {
+		if update != nil {
+			update.undo(db)
+		}
+		return errorhandler(err), nil, nil
 	}
 
 	if pDevice.Pattern == "" {
@@ -494,7 +593,7 @@ func CreateService(service *Service,
 		if len(serviceAgreementProtocols) != 0 {
 			agpList = &serviceAgreementProtocols
 		} else if list, err := policy.ConvertToAgreementProtocolList(globalAgreementProtocols); err != nil {
-			return errorhandler(NewSystemError(fmt.Sprintf("Error converting global agreement protocol list attribute %v to agreement protocol list, error: %v", globalAgreementProtocols, err))), nil, nil
+			return updateError(NewSystemError(fmt.Sprintf("Error converting global agreement protocol list attribute %v to agreement protocol list, error: %v", globalAgreementProtocols, err)).WithCode(ERR_INVALID_INPUT))
 		} else {
 			agpList = list
 		}
@@ -505,11 +604,23 @@ func CreateService(service *Service,
 			maxAgreements = 0 // no limites for pattern
 		}
 
-		glog.V(5).Infof(apiLogString(fmt.Sprintf("Create service policy: %v", service)))
+		glog.V(5).Infof(apiRequestLogString(ctx, fmt.Sprintf("Create service policy: %v", service)))
 
 		// Generate a policy based on all the attributes and the service definition.
 		if polFileName, genErr := policy.GeneratePolicy(*service.Url, *service.Org, *service.Name, *service.VersionRange, *service.Arch, &props, haPartner, *agpList, maxAgreements, config.Edge.PolicyPath, pDevice.Org); genErr != nil {
-			return errorhandler(NewSystemError(fmt.Sprintf("Error generating policy, error: %v", genErr))), nil, nil
+			return updateError(NewSystemError(fmt.Sprintf("Error generating policy, error: %v", genErr)).WithCode(ERR_POLICY_GENERATION))
+		} else if update != nil {
+			// The policy of the updated service replaces the one of its previous version.
+			pol, err := policy.ReadPolicyFile(polFileName, config.ArchSynonyms)
+			if err != nil {
+				return updateError(NewSystemError(fmt.Sprintf("Error reading the generated policy %v, error: %v", polFileName, err)).WithCode(ERR_POLICY_GENERATION))
+			}
+			polString, err := policy.MarshalPolicy(pol)
+			if err != nil {
+				return updateError(NewSystemError(fmt.Sprintf("Error marshalling the generated policy %v, error: %v", polFileName, err)).WithCode(ERR_POLICY_GENERATION))
+			}
+			update.PolicyChangedMessage = events.NewPolicyChangedMessage(events.CHANGED_POLICY, polFileName, pol.Header.Name, pDevice.Org, polString)
+			return false, service, update
 		} else {
 			if from_user {
 				LogServiceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_COMPLETE_SVC_CONFIG, *service.Org, *service.Url), persistence.EC_SERVICE_CONFIG_COMPLETE, service)
@@ -517,13 +628,71 @@ func CreateService(service *Service,
 				LogServiceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_COMPLETE_SVC_AUTO_CONFIG, *service.Org, *service.Url), persistence.EC_SERVICE_CONFIG_COMPLETE, service)
 			}
 			// Create the new policy event
-			msg := events.NewPolicyCreatedMessage(events.NEW_POLICY, polFileName)
+			msg := events.NewPolicyCreatedMessage(events.NEW_POLICY, polFileName).WithOrigin(origin, originPattern)
 
 			return false, service, msg
 		}
 	}
 }
 
+// The update of a registered service to the version that the node's pattern requires, made by CreateService in upsert
+// mode. The previous service definition is archived rather than changed, the governance worker cleans up its agreements
+// and containers once the update is published. Until then, the update can be undone.
+type serviceUpdate struct {
+	*events.PolicyChangedMessage                                    // the policy of the new version
+	previous                     persistence.MicroserviceDefinition // the archived service definition
+	msdefId                      string                             // the key of the new service definition
+	policyFile                   string
+	previousPolicy               *policy.Policy // nil when the previous version had no policy
+}
+
+// Archive the previous service definition, replaced by the one with the given key. The policy file of the service is
+// read before it is generated for the new version.
+func newServiceUpdate(db *bolt.DB, previous persistence.MicroserviceDefinition, msdefId string, policyFile string, config *config.HorizonConfig) (*serviceUpdate, error) {
+	u := &serviceUpdate{previous: previous, msdefId: msdefId, policyFile: policyFile}
+	if _, err := os.Stat(policyFile); err == nil {
+		if u.previousPolicy, err = policy.ReadPolicyFile(policyFile, config.ArchSynonyms); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := persistence.MsDefArchived(db, previous.Id); err != nil {
+		return nil, err
+	} else if _, err := persistence.MSDefUpgradeNewMsId(db, previous.Id, msdefId); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// The message that has the governance worker clean up the previous version.
+func (u *serviceUpdate) replacedMessage() events.Message {
+	return events.NewMicroserviceReplacedMessage(events.SERVICE_REPLACED, u.previous.Id, u.msdefId)
+}
+
+// Restore the previous service definition and its policy, and archive the new one. It carries on past the errors so
+// that as much as possible is restored, the first one is returned.
+func (u *serviceUpdate) undo(db *bolt.DB) error {
+	var firstErr error
+	record := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	_, err := persistence.MsDefArchived(db, u.msdefId)
+	record(err)
+	_, err = persistence.MsDefUnarchived(db, u.previous.Id)
+	record(err)
+	_, err = persistence.MSDefUpgradeNewMsId(db, u.previous.Id, "")
+	record(err)
+
+	if u.previousPolicy != nil {
+		record(policy.WritePolicyFile(u.previousPolicy, u.policyFile))
+	} else if _, err := os.Stat(u.policyFile); err == nil {
+		record(policy.DeletePolicyFile(u.policyFile))
+	}
+	return firstErr
+}
+
 // Convert the UserInputAttributes to UserInput of policy.
 func convertAttributeToExchangeUserInput(service *Service, vr string, attr *persistence.UserInputAttributes) *policy.UserInput {
 	userInput := new(policy.UserInput)
@@ -557,29 +726,32 @@ func convertAttributeToExchangeUserInput(service *Service, vr string, attr *pers
 }
 
 // check if the given merged user input satisfies the service requirement. It is only called in the pattern case.
+// The names of all the missing variables are returned, as joined by joinVariableNames.
 func validateUserInput(sdef *exchange.ServiceDefinition, mergedUserInput *policy.UserInput) (bool, string) {
+	missing := missingUserInput(sdef, mergedUserInput)
+	return len(missing) == 0, joinVariableNames(missing)
+}
+
+// Join the names of user input variables for an error message, so that all the errors name them the same way.
+func joinVariableNames(names []string) string {
+	return strings.Join(names, ", ")
+}
+
+// The names of the user input variables of the service that have no default value and are not set in the merged user input.
+func missingUserInput(sdef *exchange.ServiceDefinition, mergedUserInput *policy.UserInput) []string {
+	missing := []string{}
 	if !sdef.NeedsUserInput() {
-		return true, ""
+		return missing
 	}
 
-	if mergedUserInput == nil || mergedUserInput.Inputs == nil || len(mergedUserInput.Inputs) == 0 {
-		for _, ui := range sdef.UserInputs {
-			if ui.DefaultValue == "" {
-				return false, ui.Name
-			}
-		}
-	} else {
-		// check if the user input has all the necessary values
-		for _, ui := range sdef.UserInputs {
-			if ui.DefaultValue != "" {
-				continue
-			} else if mergedUserInput.FindInput(ui.Name) == nil {
-				return false, ui.Name
-			}
+	for _, ui := range sdef.UserInputs {
+		if ui.Name == "" || ui.DefaultValue != "" {
+			continue
+		} else if mergedUserInput == nil || mergedUserInput.FindInput(ui.Name) == nil {
+			missing = append(missing, ui.Name)
 		}
 	}
-
-	return true, ""
+	return missing
 }
 
 // get the pattern from exchange
@@ -601,8 +773,20 @@ func getExchangePattern(patOrg string, patName string, getPatterns exchange.Patt
 	return &exchPattern, nil
 }
 
-// get the merged user input for a service from the pattern and node.
-func getMergedUserInput(patternUserInput []policy.UserInput, svcUrl, svcOrg, svcArch string, db *bolt.DB) (*policy.UserInput, error) {
+// The layers of user input that are merged to get the user input of a service, and their precedence. The user input
+// in the service configuration overrides the node user input, which overrides the pattern user input.
+const (
+	USER_INPUT_LAYER_PATTERN        = "pattern"
+	USER_INPUT_LAYER_NODE           = "node"
+	USER_INPUT_LAYER_SERVICE_CONFIG = "service_config"
+
+	USER_INPUT_PRECEDENCE_PATTERN        = 1
+	USER_INPUT_PRECEDENCE_NODE           = 2
+	USER_INPUT_PRECEDENCE_SERVICE_CONFIG = 3
+)
+
+// get the pattern and node user input layers, in the order of their precedence.
+func getUserInputLayers(patternUserInput []policy.UserInput, db *bolt.DB) ([]policy.UserInputLayer, error) {
 
 	// get node user input
 	nodeUserInput, err := persistence.FindNodeUserInput(db)
@@ -610,16 +794,12 @@ func getMergedUserInput(patternUserInput []policy.UserInput, svcUrl, svcOrg, svc
 		return nil, fmt.Errorf("Failed get user input from local db. %v", err)
 	}
 
-	// merge node user input it with pattern user input
-	mergedUserInput := policy.MergeUserInputArrays(patternUserInput, nodeUserInput, true)
-	if mergedUserInput == nil {
-		mergedUserInput = []policy.UserInput{}
-	}
+	return newUserInputLayers(patternUserInput, nodeUserInput), nil
+}
 
-	// get the user input for this service
-	ui_merged, _, err := policy.FindUserInput(svcUrl, svcOrg, "", svcArch, mergedUserInput)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to find preferences for service %v/%v from the merged user input, error: %v", svcOrg, svcUrl, err)
+func newUserInputLayers(patternUserInput []policy.UserInput, nodeUserInput []policy.UserInput) []policy.UserInputLayer {
+	return []policy.UserInputLayer{
+		{Name: USER_INPUT_LAYER_PATTERN, Precedence: USER_INPUT_PRECEDENCE_PATTERN, UserInput: patternUserInput},
+		{Name: USER_INPUT_LAYER_NODE, Precedence: USER_INPUT_PRECEDENCE_NODE, UserInput: nodeUserInput},
 	}
-	return ui_merged, nil
 }
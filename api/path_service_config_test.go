@@ -3,8 +3,10 @@
 package api
 
 import (
+	"context"
 	"flag"
 	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/policy"
@@ -59,15 +61,115 @@ func Test_CreateService0(t *testing.T) {
 	var myError error
 	errorhandler := GetPassThroughErrorHandler(&myError)
 	sHandler := getVariableServiceHandler(exchange.UserInput{})
-	errHandled, newService, msg := CreateService(service, errorhandler, patternHandler, getDummyServiceDefResolver(), sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), nil, db, getBasicConfig(), false)
+	errHandled, newService, m := CreateService(context.Background(), service, errorhandler, patternHandler, getDummyServiceDefResolver(), sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), nil, db, getBasicConfig(), events.POLICY_ORIGIN_AUTOCONFIG, false)
+	msg, _ := m.(*events.PolicyCreatedMessage)
 	if errHandled {
 		t.Errorf("unexpected error (%T) %v", myError, myError)
 	} else if newService == nil {
 		t.Errorf("returned service should not be nil")
 	} else if msg == nil {
 		t.Errorf("returned msg should not be nil")
+	} else if msg.Origin() != events.POLICY_ORIGIN_AUTOCONFIG || msg.Pattern() == "" || msg.Created() == 0 {
+		t.Errorf("returned msg should have the origin, the pattern and the time of the service, got %v", msg)
 	}
 
+	if msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()}); err != nil {
+		t.Errorf("unable to read the services, error %v", err)
+	} else if len(msdefs) != 1 || msdefs[0].Origin != events.POLICY_ORIGIN_AUTOCONFIG || msg == nil || msdefs[0].OriginPattern != msg.Pattern() {
+		t.Errorf("the service should be saved with its origin, got %v", msdefs)
+	}
+}
+
+// In upsert mode, a service registered at a version outside of the version range is updated to the version of the
+// exchange, a service within it is a duplicate and a service from another org is a conflict.
+func Test_CreateService_upsert(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	surl := "http://dummy.com"
+	myOrg := "myorg"
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "device", false, myOrg, "apattern", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	cfg := getBasicConfig()
+	cfg.Edge.PolicyPath = dir + "/"
+
+	create := func(org string, vers string, exVersion string) (bool, events.Message, error) {
+		service := NewService(surl, org, "svcname", cutil.ArchString(), vers)
+		getService := func(mUrl string, mOrg string, mVersion string, mArch string) (*exchange.ServiceDefinition, string, error) {
+			sdef, id, err := getVariableServiceHandler(exchange.UserInput{})(mUrl, mOrg, mVersion, mArch)
+			sdef.Version = exVersion
+			return sdef, id, err
+		}
+		var myError error
+		errHandled, _, msg := CreateService(context.Background(), service, GetPassThroughErrorHandler(&myError), getDummyGetPatterns(), getDummyServiceDefResolver(), getService, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), []policy.UserInputLayer{}, db, cfg, events.POLICY_ORIGIN_AUTOCONFIG, true)
+		return errHandled, msg, myError
+	}
+
+	if errHandled, _, myError := create(myOrg, "[1.0.0,2.0.0)", "1.0.0"); errHandled {
+		t.Fatalf("unexpected error (%T) %v", myError, myError)
+	}
+	before, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()})
+	if err != nil || len(before) != 1 {
+		t.Fatalf("there should be one service, got %v, error %v", before, err)
+	}
+
+	// the version of the service is within the range
+	if errHandled, _, myError := create(myOrg, "[1.0.0,2.0.0)", "1.5.0"); !errHandled {
+		t.Errorf("a service within the version range should be a duplicate")
+	} else if _, ok := myError.(*DuplicateServiceError); !ok {
+		t.Errorf("myError has the wrong type (%T) %v", myError, myError)
+	}
+
+	// the version of the service is outside of the range, the service is updated, the previous version is archived
+	var update *serviceUpdate
+	if errHandled, msg, myError := create(myOrg, "[2.0.0,3.0.0)", "2.0.0"); errHandled {
+		t.Fatalf("unexpected error (%T) %v", myError, myError)
+	} else if u, ok := msg.(*serviceUpdate); !ok {
+		t.Fatalf("the msg should be a serviceUpdate, got (%T) %v", msg, msg)
+	} else if u.Event().Id != events.CHANGED_POLICY || u.PolicyFile() == "" || u.Org() != myOrg {
+		t.Errorf("wrong policy changed message %v", u.PolicyChangedMessage)
+	} else if u.previous.Id != before[0].Id || u.previousPolicy == nil {
+		t.Errorf("the previous version should be recorded, got %v", u.previous)
+	} else {
+		update = u
+	}
+
+	checkVersion := func(version string) {
+		if after, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()}); err != nil {
+			t.Errorf("unable to read the services, error %v", err)
+		} else if len(after) != 1 || after[0].Version != version {
+			t.Errorf("the service should be at version %v, got %v", version, after)
+		} else if pol, err := policy.ReadPolicyFile(update.PolicyFile(), cfg.ArchSynonyms); err != nil {
+			t.Errorf("unable to read the policy, error %v", err)
+		} else if len(pol.APISpecs) != 1 || pol.APISpecs[0].Version != version {
+			t.Errorf("the policy should be for version %v, got %v", version, pol.APISpecs)
+		}
+	}
+	if update != nil {
+		checkVersion("2.0.0")
+		if replaced, ok := update.replacedMessage().(*events.MicroserviceReplacedMessage); !ok || replaced.OldMsDefId != before[0].Id || replaced.NewMsDefId == before[0].Id {
+			t.Errorf("wrong replaced message %v", update.replacedMessage())
+		}
+
+		// the update is undone, the previous version and its policy are restored
+		if err := update.undo(db); err != nil {
+			t.Errorf("unable to undo the update, error %v", err)
+		}
+		checkVersion("1.0.0")
+	}
+
+	// the service is registered from another org
+	if errHandled, _, myError := create("otherorg", "[3.0.0,4.0.0)", "3.0.0"); !errHandled {
+		t.Errorf("a service of another org should be a conflict")
+	} else if _, ok := myError.(*ConflictError); !ok || ErrorReason(myError) != ERR_SERVICE_ALREADY_CONFIGURED {
+		t.Errorf("myError has the wrong type or reason (%T) %v", myError, myError)
+	}
 }
 
 func Test_validateUserInput(t *testing.T) {
@@ -109,16 +211,16 @@ func Test_validateUserInput(t *testing.T) {
 	ok, missedName = validateUserInput(&sdef, &userInput)
 	if ok {
 		t.Errorf("validateUserInput should return false, but not.")
-	} else if missedName != "var2" {
-		t.Errorf("missedName should be var2 but got: %v.", missedName)
+	} else if missedName != "var2, var3" {
+		t.Errorf("missedName should be var2, var3 but got: %v.", missedName)
 	}
 
 	userInput.Inputs = nil
 	ok, missedName = validateUserInput(&sdef, &userInput)
 	if ok {
 		t.Errorf("validateUserInput should return false, but not.")
-	} else if missedName != "var2" {
-		t.Errorf("missedName should be var2 but got: %v.", missedName)
+	} else if missedName != "var2, var3, var4" {
+		t.Errorf("missedName should be var2, var3, var4 but got: %v.", missedName)
 	}
 
 	ip = []policy.Input{policy.Input{Name: "var2", Value: 21},
@@ -17,15 +17,15 @@ func FindServiceConfigStateForOutput(errorhandler ErrorHandler, getServicesConfi
 	// to the HTTP response.
 	pLocalDevice, err := persistence.FindExchangeDevice(db)
 	if err != nil {
-		return errorhandler(NewSystemError(fmt.Sprintf("Unable to read horizondevice object, error %v", err))), nil
+		return errorhandler(NewSystemError(fmt.Sprintf("Unable to read horizondevice object, error %v", err)).WithCode(ERR_DATABASE)), nil
 	} else if pLocalDevice == nil {
-		return errorhandler(NewAPIUserInputError("Exchange registration not recorded. Complete account and device registration with an exchange and then record device registration using this API's /horizondevice path.", "service/configstate")), nil
+		return errorhandler(NewAPIUserInputError("Exchange registration not recorded. Complete account and device registration with an exchange and then record device registration using this API's /horizondevice path.", "service/configstate").WithCode(ERR_NODE_NOT_REGISTERED)), nil
 	}
 
 	outConfigState, err := getServicesConfigState(pLocalDevice.Id, pLocalDevice.Token)
 	if err != nil {
 		glog.Errorf(apiLogString(fmt.Sprintf("Unable to retrieve the service configurations for node %v from the exchange, error %v", pLocalDevice.Id, err)))
-		return errorhandler(NewSystemError(fmt.Sprintf("Unable to retrieve the service configurations for node %v from the exchange, error %v", pLocalDevice.Id, err))), nil
+		return errorhandler(NewSystemError(fmt.Sprintf("Unable to retrieve the service configurations for node %v from the exchange, error %v", pLocalDevice.Id, err)).WithCode(ERR_EXCHANGE_UNREACHABLE)), nil
 	}
 
 	out := make(map[string][]exchange.ServiceConfigState)
@@ -47,17 +47,17 @@ func ChangeServiceConfigState(service_cs *exchange.ServiceConfigState,
 	// to the HTTP response.
 	pLocalDevice, err := persistence.FindExchangeDevice(db)
 	if err != nil {
-		return errorhandler(NewSystemError(fmt.Sprintf("Unable to read horizondevice object, error %v", err))), nil
+		return errorhandler(NewSystemError(fmt.Sprintf("Unable to read horizondevice object, error %v", err)).WithCode(ERR_DATABASE)), nil
 	} else if pLocalDevice == nil {
-		return errorhandler(NewAPIUserInputError("Exchange registration not recorded. Complete account and device registration with an exchange and then record device registration using this API's /horizondevice path.", "service/configstate")), nil
+		return errorhandler(NewAPIUserInputError("Exchange registration not recorded. Complete account and device registration with an exchange and then record device registration using this API's /horizondevice path.", "service/configstate").WithCode(ERR_NODE_NOT_REGISTERED)), nil
 	}
 
 	// input error checking
 	if service_cs.Url != "" && service_cs.Org == "" {
-		return errorhandler(NewAPIUserInputError(fmt.Sprintf("Please specify organization when the service url is not an empty string: %v", service_cs), "org")), nil
+		return errorhandler(NewAPIUserInputError(fmt.Sprintf("Please specify organization when the service url is not an empty string: %v", service_cs), "org").WithCode(ERR_INVALID_INPUT)), nil
 	}
 	if service_cs.ConfigState != exchange.SERVICE_CONFIGSTATE_ACTIVE && service_cs.ConfigState != exchange.SERVICE_CONFIGSTATE_SUSPENDED {
-		return errorhandler(NewAPIUserInputError(fmt.Sprintf("The service configstate '%v' is not supported. The supported states are: %v, %v", service_cs.ConfigState, exchange.SERVICE_CONFIGSTATE_ACTIVE, exchange.SERVICE_CONFIGSTATE_SUSPENDED), "configState")), nil
+		return errorhandler(NewAPIUserInputError(fmt.Sprintf("The service configstate '%v' is not supported. The supported states are: %v, %v", service_cs.ConfigState, exchange.SERVICE_CONFIGSTATE_ACTIVE, exchange.SERVICE_CONFIGSTATE_SUSPENDED), "configState").WithCode(ERR_INVALID_STATE)), nil
 	}
 
 	glog.V(5).Infof(apiLogString(fmt.Sprintf("Start changing service configuration state for %v for the node.", service_cs)))
@@ -65,7 +65,7 @@ func ChangeServiceConfigState(service_cs *exchange.ServiceConfigState,
 	pDevice, err := getDevice(fmt.Sprintf("%v/%v", pLocalDevice.Org, pLocalDevice.Id), pLocalDevice.Token)
 	if err != nil {
 		glog.Errorf(apiLogString(fmt.Sprintf("Unable to retrieve node resource for %v from the exchange, error %v", pLocalDevice.Id, err)))
-		return errorhandler(NewSystemError(fmt.Sprintf("Unable to retrieve node resource for %v from the exchange, error %v", pLocalDevice.Id, err))), nil
+		return errorhandler(NewSystemError(fmt.Sprintf("Unable to retrieve node resource for %v from the exchange, error %v", pLocalDevice.Id, err)).WithCode(ERR_EXCHANGE_UNREACHABLE)), nil
 	}
 
 	// save the services that are turned into suspeded state
@@ -113,12 +113,12 @@ func ChangeServiceConfigState(service_cs *exchange.ServiceConfigState,
 	//handle not-found error
 	if !found {
 		if service_cs.Url != "" {
-			return errorhandler(NewAPIUserInputError(fmt.Sprintf("No changes made. The service %v does not exist or is not a registered service in the exchange for node %v.", cutil.FormOrgSpecUrl(service_cs.Url, service_cs.Org), pDevice.Name), "url, org")), nil
+			return errorhandler(NewAPIUserInputError(fmt.Sprintf("No changes made. The service %v does not exist or is not a registered service in the exchange for node %v.", cutil.FormOrgSpecUrl(service_cs.Url, service_cs.Org), pDevice.Name), "url, org").WithCode(ERR_SERVICE_NOT_FOUND)), nil
 		} else {
 			if service_cs.Org == "" {
-				return errorhandler(NewAPIUserInputError(fmt.Sprintf("No changes made. No registered services found in the exchange for node %v.", pDevice.Name), "url, org")), nil
+				return errorhandler(NewAPIUserInputError(fmt.Sprintf("No changes made. No registered services found in the exchange for node %v.", pDevice.Name), "url, org").WithCode(ERR_SERVICE_NOT_FOUND)), nil
 			} else {
-				return errorhandler(NewAPIUserInputError(fmt.Sprintf("No changes made. No registered services from organization %v found in the exchange for node %v.", service_cs.Org, pDevice.Name), "org")), nil
+				return errorhandler(NewAPIUserInputError(fmt.Sprintf("No changes made. No registered services from organization %v found in the exchange for node %v.", service_cs.Org, pDevice.Name), "org").WithCode(ERR_SERVICE_NOT_FOUND)), nil
 			}
 		}
 	}
@@ -127,7 +127,7 @@ func ChangeServiceConfigState(service_cs *exchange.ServiceConfigState,
 	err = postDeviceSCS(pLocalDevice.Name, pLocalDevice.Token, service_cs)
 	if err != nil {
 		glog.Errorf(apiLogString(fmt.Sprintf("Failed to change the service configuration state for the node %v in the exchange, error %v", pDevice.Name, err)))
-		return errorhandler(NewSystemError(fmt.Sprintf("Failed to change the service configuration state for the node %v in the exchange, error %v", pDevice.Name, err))), nil
+		return errorhandler(NewSystemError(fmt.Sprintf("Failed to change the service configuration state for the node %v in the exchange, error %v", pDevice.Name, err)).WithCode(ERR_EXCHANGE_UNREACHABLE)), nil
 	}
 	glog.V(5).Infof(apiLogString(fmt.Sprintf("Complete changing service configuration state to %v for the node.", service_cs)))
 
@@ -0,0 +1,286 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	dockerclient "github.com/fsouza/go-dockerclient"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/container"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/persistence"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Bounds on the amount of log data that can be requested through the API, to protect the device.
+const (
+	LOGS_DEFAULT_TAIL     = 100
+	LOGS_MAX_TAIL         = 10000
+	LOGS_MAX_FOLLOW_S     = 300
+	LOGS_DEFAULT_FOLLOW_S = 60
+	LOGS_MAX_BYTES        = 16 * 1024 * 1024
+)
+
+// The parsed form of a request for container logs.
+type LogRequest struct {
+	AgreementId string        // The agreement whose workload containers are being requested.
+	ServiceURL  string        // The service whose containers are being requested. Mutually exclusive with AgreementId.
+	ServiceOrg  string        // The org of the service, optional.
+	Tail        int           // The number of lines to return from the end of each container's log.
+	Follow      bool          // Keep streaming new log lines until the Duration expires.
+	Duration    time.Duration // How long to follow the logs.
+	Since       int64         // Only return log lines written after this unix timestamp. 0 means no filter.
+}
+
+func (l LogRequest) String() string {
+	return fmt.Sprintf("AgreementId: %v, ServiceURL: %v, ServiceOrg: %v, Tail: %v, Follow: %v, Duration: %v, Since: %v",
+		l.AgreementId, l.ServiceURL, l.ServiceOrg, l.Tail, l.Follow, l.Duration, l.Since)
+}
+
+// Validate the query parameters of a log request and apply the defaults and upper bounds.
+func NewLogRequest(form url.Values) (*LogRequest, error) {
+
+	lr := &LogRequest{
+		AgreementId: form.Get("agreement_id"),
+		ServiceURL:  form.Get("service_url"),
+		ServiceOrg:  form.Get("org"),
+		Tail:        LOGS_DEFAULT_TAIL,
+		Duration:    time.Duration(LOGS_DEFAULT_FOLLOW_S) * time.Second,
+	}
+
+	if lr.AgreementId == "" && lr.ServiceURL == "" {
+		return nil, NewAPIUserInputError("either agreement_id or service_url must be specified", "agreement_id")
+	} else if lr.AgreementId != "" && lr.ServiceURL != "" {
+		return nil, NewAPIUserInputError("agreement_id and service_url are mutually exclusive", "agreement_id")
+	}
+
+	if tail := form.Get("tail"); tail != "" {
+		if t, err := strconv.Atoi(tail); err != nil || t < 0 {
+			return nil, NewAPIUserInputError(fmt.Sprintf("tail must be a non-negative integer, is %v", tail), "tail")
+		} else if t > LOGS_MAX_TAIL {
+			lr.Tail = LOGS_MAX_TAIL
+		} else {
+			lr.Tail = t
+		}
+	}
+
+	if follow := form.Get("follow"); follow != "" {
+		if f, err := strconv.ParseBool(follow); err != nil {
+			return nil, NewAPIUserInputError(fmt.Sprintf("follow must be true or false, is %v", follow), "follow")
+		} else {
+			lr.Follow = f
+		}
+	}
+
+	if duration := form.Get("duration"); duration != "" {
+		if d, err := strconv.Atoi(duration); err != nil || d <= 0 {
+			return nil, NewAPIUserInputError(fmt.Sprintf("duration must be a positive number of seconds, is %v", duration), "duration")
+		} else if d > LOGS_MAX_FOLLOW_S {
+			lr.Duration = time.Duration(LOGS_MAX_FOLLOW_S) * time.Second
+		} else {
+			lr.Duration = time.Duration(d) * time.Second
+		}
+	}
+
+	if since := form.Get("since"); since != "" {
+		if s, err := strconv.ParseInt(since, 10, 64); err == nil && s >= 0 {
+			lr.Since = s
+		} else if t, err := time.Parse(time.RFC3339, since); err == nil {
+			lr.Since = t.Unix()
+		} else {
+			return nil, NewAPIUserInputError(fmt.Sprintf("since must be a unix timestamp or an RFC3339 time, is %v", since), "since")
+		}
+	}
+
+	return lr, nil
+}
+
+// Find the containers that belong to the agreement or service in the log request. Workload containers are labelled
+// with their agreement id, service containers are labelled with their service instance key.
+func FindContainersForLogs(db *bolt.DB, client container.ContainerRuntime, lr *LogRequest) ([]dockerclient.APIContainers, error) {
+
+	owners := make(map[string]bool)
+	if lr.AgreementId != "" {
+		owners[lr.AgreementId] = true
+	} else {
+		msInsts, err := persistence.FindMicroserviceInstances(db, []persistence.MIFilter{persistence.UnarchivedMIFilter()})
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("unable to read service instances, error %v", err))
+		}
+		for _, msi := range msInsts {
+			if cutil.SameSpecURL(msi.SpecRef, lr.ServiceURL) && (lr.ServiceOrg == "" || msi.Org == "" || msi.Org == lr.ServiceOrg) {
+				owners[msi.GetKey()] = true
+			}
+		}
+	}
+
+	containers, err := client.ListContainers(dockerclient.ListContainersOptions{All: true})
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to list docker containers, error %v", err))
+	}
+
+	ret := make([]dockerclient.APIContainers, 0, 5)
+	for _, c := range containers {
+		if _, exists := c.Labels[container.LABEL_PREFIX+".service_name"]; !exists {
+			continue
+		}
+		if owners[c.Labels[container.LABEL_PREFIX+".agreement_id"]] {
+			ret = append(ret, c)
+		}
+	}
+	return ret, nil
+}
+
+// A writer that prefixes each line with a marker identifying the container and stream it came from, and that stops
+// accepting data once the shared byte budget is exhausted. Writes from multiple containers are serialized.
+type logLineWriter struct {
+	prefix  string
+	out     io.Writer
+	lock    *sync.Mutex
+	budget  *int64
+	partial []byte
+}
+
+var errLogBudgetExceeded = errors.New("log output size limit reached")
+
+func (l *logLineWriter) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.partial = append(l.partial, p...)
+	for {
+		ix := bytes.IndexByte(l.partial, '\n')
+		if ix < 0 {
+			break
+		}
+		// the lines of a TTY end with \r\n
+		line := append(append([]byte(l.prefix), bytes.TrimRight(l.partial[:ix], "\r")...), '\n')
+		l.partial = l.partial[ix+1:]
+
+		if err := l.emit(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Write the last line of the log when it did not end with a newline. Called when the log stream has ended.
+func (l *logLineWriter) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if len(l.partial) == 0 {
+		return nil
+	}
+	line := append(append([]byte(l.prefix), l.partial...), '\n')
+	l.partial = nil
+	return l.emit(line)
+}
+
+// Write a line within the byte budget and flush it to the client. The caller holds the lock.
+func (l *logLineWriter) emit(line []byte) error {
+	if *l.budget < int64(len(line)) {
+		return errLogBudgetExceeded
+	}
+	*l.budget -= int64(len(line))
+	if _, err := l.out.Write(line); err != nil {
+		return err
+	}
+	if f, ok := l.out.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// Write the logs of the given containers to the output writer. Each line is prefixed with the container name and the
+// stream (stdout or stderr) it came from. The log of a container with a TTY is a single stream, its lines are marked
+// tty. The logs stop when the context is cancelled, e.g. when the client goes away.
+func WriteContainerLogs(ctx context.Context, client container.ContainerRuntime, containers []dockerclient.APIContainers, lr *LogRequest, out io.Writer) error {
+
+	if lr.Follow {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, lr.Duration)
+		defer cancel()
+	}
+
+	lock := new(sync.Mutex)
+	budget := int64(LOGS_MAX_BYTES)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(containers))
+
+	for _, c := range containers {
+		name := c.ID
+		if len(c.Names) != 0 {
+			name = c.Names[0]
+		}
+
+		// The log of a container with a TTY is not multiplexed, it has to be read raw.
+		tty := false
+		if detail, err := client.InspectContainer(c.ID); err != nil {
+			errs <- errors.New(fmt.Sprintf("unable to inspect container %v, error %v", name, err))
+			continue
+		} else if detail.Config != nil {
+			tty = detail.Config.Tty
+		}
+
+		newWriter := func(stream string) *logLineWriter {
+			return &logLineWriter{prefix: fmt.Sprintf("[%v %v] ", name, stream), out: out, lock: lock, budget: &budget}
+		}
+		var writers []*logLineWriter
+		if tty {
+			writers = []*logLineWriter{newWriter("tty")}
+		} else {
+			writers = []*logLineWriter{newWriter("stdout"), newWriter("stderr")}
+		}
+
+		opts := dockerclient.LogsOptions{
+			Context:     ctx,
+			Container:   c.ID,
+			Stdout:      true,
+			Stderr:      true,
+			Follow:      lr.Follow,
+			Tail:        strconv.Itoa(lr.Tail),
+			Since:       lr.Since,
+			Timestamps:  true,
+			RawTerminal: tty,
+		}
+		opts.OutputStream = writers[0]
+		if !tty {
+			opts.ErrorStream = writers[1]
+		}
+
+		wg.Add(1)
+		go func(opts dockerclient.LogsOptions, writers []*logLineWriter) {
+			defer wg.Done()
+			err := client.Logs(opts)
+			for _, w := range writers {
+				if cErr := w.Close(); cErr != nil && err == nil {
+					err = cErr
+				}
+			}
+			if err != nil && err != context.DeadlineExceeded && err != context.Canceled && ctx.Err() == nil {
+				errs <- err
+			}
+		}(opts, writers)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err == errLogBudgetExceeded {
+			glog.Warningf(apiLogString(fmt.Sprintf("log request %v truncated, %v", lr, err)))
+			continue
+		}
+		return err
+	}
+	return nil
+}
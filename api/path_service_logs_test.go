@@ -0,0 +1,175 @@
+// +build unit
+
+package api
+
+import (
+	"bytes"
+	"context"
+	dockerclient "github.com/fsouza/go-dockerclient"
+	"github.com/open-horizon/anax/container"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_NewLogRequest_defaults(t *testing.T) {
+
+	lr, err := NewLogRequest(url.Values{"agreement_id": []string{"ag1"}})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if lr.Tail != LOGS_DEFAULT_TAIL || lr.Follow || lr.Since != 0 || lr.Duration != time.Duration(LOGS_DEFAULT_FOLLOW_S)*time.Second {
+		t.Errorf("wrong defaults in log request %v", lr)
+	}
+}
+
+func Test_NewLogRequest_bounds(t *testing.T) {
+
+	form := url.Values{
+		"service_url": []string{"https://bluehorizon.network/services/netspeed"},
+		"tail":        []string{"1000000"},
+		"follow":      []string{"true"},
+		"duration":    []string{"100000"},
+		"since":       []string{"2020-08-20T14:10:01Z"},
+	}
+
+	lr, err := NewLogRequest(form)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if lr.Tail != LOGS_MAX_TAIL {
+		t.Errorf("tail should be capped at %v, is %v", LOGS_MAX_TAIL, lr.Tail)
+	} else if !lr.Follow {
+		t.Errorf("follow should be true")
+	} else if lr.Duration != time.Duration(LOGS_MAX_FOLLOW_S)*time.Second {
+		t.Errorf("duration should be capped at %v seconds, is %v", LOGS_MAX_FOLLOW_S, lr.Duration)
+	} else if lr.Since != 1597932601 {
+		t.Errorf("since should be 1597932601, is %v", lr.Since)
+	}
+}
+
+func Test_NewLogRequest_errors(t *testing.T) {
+
+	bad := []url.Values{
+		url.Values{},
+		url.Values{"agreement_id": []string{"ag1"}, "service_url": []string{"svc"}},
+		url.Values{"agreement_id": []string{"ag1"}, "tail": []string{"-1"}},
+		url.Values{"agreement_id": []string{"ag1"}, "follow": []string{"maybe"}},
+		url.Values{"agreement_id": []string{"ag1"}, "duration": []string{"0"}},
+		url.Values{"agreement_id": []string{"ag1"}, "since": []string{"yesterday"}},
+	}
+
+	for _, form := range bad {
+		if lr, err := NewLogRequest(form); err == nil {
+			t.Errorf("expected an error for %v, got %v", form, lr)
+		} else if _, ok := err.(*APIUserInputError); !ok {
+			t.Errorf("expected an APIUserInputError for %v, got %T", form, err)
+		}
+	}
+}
+
+func Test_logLineWriter(t *testing.T) {
+
+	out := new(bytes.Buffer)
+	budget := int64(50)
+	lw := &logLineWriter{prefix: "[c1 stdout] ", out: out, lock: new(sync.Mutex), budget: &budget}
+
+	if _, err := lw.Write([]byte("line one\nline")); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if out.String() != "[c1 stdout] line one\n" {
+		t.Errorf("unexpected output: %v", out.String())
+	}
+
+	if _, err := lw.Write([]byte(" two\n")); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if out.String() != "[c1 stdout] line one\n[c1 stdout] line two\n" {
+		t.Errorf("unexpected output: %v", out.String())
+	}
+
+	if _, err := lw.Write([]byte("line three\n")); err != errLogBudgetExceeded {
+		t.Errorf("expected the byte budget to be exceeded, error was %v", err)
+	}
+}
+
+func Test_logLineWriter_Close(t *testing.T) {
+
+	out := new(bytes.Buffer)
+	budget := int64(100)
+	lw := &logLineWriter{prefix: "[c1 tty] ", out: out, lock: new(sync.Mutex), budget: &budget}
+
+	if _, err := lw.Write([]byte("line one\r\nno newline")); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if err := lw.Close(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if out.String() != "[c1 tty] line one\n[c1 tty] no newline\n" {
+		t.Errorf("unexpected output: %q", out.String())
+	}
+}
+
+// A container runtime that has one container and writes a fixed log for it.
+type logsRuntime struct {
+	container.ContainerRuntime
+	tty  bool
+	opts *dockerclient.LogsOptions
+}
+
+func (l *logsRuntime) InspectContainer(id string) (*dockerclient.Container, error) {
+	return &dockerclient.Container{ID: id, Config: &dockerclient.Config{Tty: l.tty}}, nil
+}
+
+func (l *logsRuntime) Logs(opts dockerclient.LogsOptions) error {
+	l.opts = &opts
+	opts.OutputStream.Write([]byte("out\nlast"))
+	if opts.ErrorStream != nil {
+		opts.ErrorStream.Write([]byte("err\n"))
+	}
+	if opts.Follow {
+		<-opts.Context.Done()
+		return opts.Context.Err()
+	}
+	return nil
+}
+
+func Test_WriteContainerLogs(t *testing.T) {
+
+	containers := []dockerclient.APIContainers{dockerclient.APIContainers{ID: "c1", Names: []string{"/c1"}}}
+
+	client := &logsRuntime{}
+	out := new(bytes.Buffer)
+	if err := WriteContainerLogs(context.Background(), client, containers, &LogRequest{Tail: 10}, out); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if out.String() != "[/c1 stdout] out\n[/c1 stderr] err\n[/c1 stdout] last\n" {
+		t.Errorf("unexpected output: %q", out.String())
+	} else if client.opts.RawTerminal {
+		t.Errorf("the log of a container without a TTY should be demultiplexed")
+	}
+
+	client = &logsRuntime{tty: true}
+	out = new(bytes.Buffer)
+	if err := WriteContainerLogs(context.Background(), client, containers, &LogRequest{Tail: 10}, out); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if out.String() != "[/c1 tty] out\n[/c1 tty] last\n" {
+		t.Errorf("unexpected output: %q", out.String())
+	} else if !client.opts.RawTerminal || client.opts.ErrorStream != nil {
+		t.Errorf("the log of a container with a TTY should be read raw, options %v", client.opts)
+	}
+}
+
+func Test_WriteContainerLogs_cancelled(t *testing.T) {
+
+	containers := []dockerclient.APIContainers{dockerclient.APIContainers{ID: "c1", Names: []string{"/c1"}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- WriteContainerLogs(ctx, &logsRuntime{}, containers, &LogRequest{Tail: 10, Follow: true, Duration: time.Minute}, new(bytes.Buffer))
+	}()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("following the logs should stop when the client goes away")
+	}
+}
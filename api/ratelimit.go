@@ -0,0 +1,276 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/persistence"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The state of the limit of the rate of the changes of the node configuration, see limitConfigChanges. The zero value
+// is ready to use.
+type configLimiter struct {
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket  // by client address, or "" for all the clients
+	results map[string]*configResult // by client, method, path, If-Match and body of the request
+}
+
+// The tokens left to a client, a change takes one and they are given back at the configured rate.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time // when the tokens were last given back
+}
+
+// The response to a change of the node configuration, kept for the same requests that are made while it runs or
+// shortly after.
+type configResult struct {
+	key      string
+	done     chan struct{} // closed once the request has been served
+	admitted time.Time
+	finished time.Time
+	status   int
+	header   http.Header
+	body     []byte
+	err      error
+}
+
+// A response writer that keeps the response of a change of the node configuration, for the same requests.
+type limitWriter struct {
+	http.ResponseWriter
+	result *configResult
+}
+
+func (l *limitWriter) WriteHeader(status int) {
+	if l.result.status == 0 {
+		l.result.status = status
+		l.result.header = l.Header().Clone()
+	}
+	l.ResponseWriter.WriteHeader(status)
+}
+
+func (l *limitWriter) Write(b []byte) (int, error) {
+	if l.result.status == 0 {
+		l.WriteHeader(http.StatusOK)
+	}
+	l.result.body = append(l.result.body, b...)
+	return l.ResponseWriter.Write(b)
+}
+
+// The response is still kept whole for the same requests, only its start is sent early.
+func (l *limitWriter) Flush() {
+	if f, ok := l.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Whether the change failed, its response is not given to the same requests, they are run again.
+func (c *configResult) failed() bool {
+	return c.err != nil || c.status >= http.StatusBadRequest
+}
+
+// Write the response kept for a change to the response of the same request. The request keeps its own ID.
+func (c *configResult) replay(w http.ResponseWriter) {
+	for name, values := range c.header {
+		if name != REQUEST_ID_HEADER {
+			w.Header()[name] = values
+		}
+	}
+	status := c.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(c.body)
+}
+
+// Limit the rate of the changes of the node configuration made through h, from the Edge.ConfigRateLimit of the config,
+// so that a client that retries them in a loop does not make the agent do the same work again and again. A request
+// made by the same client while the same one is running waits for its result, and one made within DuplicateWindowS of
+// it gets the same response, without running, unless it failed or the node was changed since. The other requests take a
+// token of their client, they are refused with a TooManyRequestsError when it has none left. The requests that exempt
+// returns true for, e.g. the ones that do not change anything, are always run.
+func (a *API) limitConfigChanges(h http.HandlerFunc, exempt func(r *http.Request, body []byte) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" {
+			h(w, r)
+			return
+		}
+
+		// The body is read for the key of the request, and given back to the handler.
+		var body []byte
+		if r.Body != nil {
+			var err error
+			if body, err = ioutil.ReadAll(r.Body); err != nil {
+				GetHTTPErrorHandler(w)(NewBadRequestError(fmt.Sprintf("unable to read the body of %v %v, error %v", r.Method, r.URL.Path, err)))
+				return
+			}
+			r.Body.Close()
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		if exempt != nil && exempt(r, body) {
+			h(w, r)
+			return
+		}
+
+		// The same requests are only the ones of the same client, the responses of the others are not replayed to it.
+		limits := a.Config.LiveEdge().ConfigRateLimit
+		client := ""
+		if limits.PerClient {
+			client = limiterClient(r)
+		}
+		key := configRequestKey(limiterClient(r), r, body)
+
+		for {
+			result, replay, retryAfter := a.limiter.admit(key, client, &limits, time.Now())
+			if retryAfter != 0 {
+				markNotRun(w)
+				GetHTTPErrorHandler(w)(NewTooManyRequestsError(fmt.Sprintf("%v %v from %v is refused, the node configuration is changed too often, retry in %v.", r.Method, r.URL.Path, r.RemoteAddr, retryAfter.Round(time.Second)), retryAfter).WithCode(ERR_RATE_LIMITED))
+				return
+			} else if replay {
+				select {
+				case <-result.done:
+					if result.failed() {
+						// it is not kept, the request is admitted on its own
+						continue
+					}
+					glog.V(3).Infof(apiLogString(fmt.Sprintf("%v %v is the same as a previous request, returning its response", r.Method, r.URL.Path)))
+					result.replay(w)
+				case <-r.Context().Done():
+					// the client went away, there is no one to answer
+				}
+				markNotRun(w)
+				return
+			}
+
+			defer a.limiter.finish(result)
+			lw := &limitWriter{ResponseWriter: w, result: result}
+			h(lw, r)
+			return
+		}
+	}
+}
+
+// Returns the result of the same request when it is running or finished within the DuplicateWindowS of c, with true.
+// Otherwise takes a token of the client and returns the result to fill for the request, or the time to wait when the
+// client has no token left.
+func (l *configLimiter) admit(key string, client string, c *config.ConfigRateLimitConfig, now time.Time) (*configResult, bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	window := time.Duration(c.DuplicateWindowS) * time.Second
+	l.prune(c, window, now)
+
+	if result, ok := l.results[key]; ok {
+		return result, true, 0
+	}
+
+	if c.PerMinute > 0 {
+		rate := float64(c.PerMinute) / 60
+		burst := float64(c.GetBurst())
+		if l.buckets == nil {
+			l.buckets = make(map[string]*tokenBucket)
+		}
+		bucket, ok := l.buckets[client]
+		if !ok {
+			bucket = &tokenBucket{tokens: burst, last: now}
+			l.buckets[client] = bucket
+		}
+		bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+		bucket.last = now
+		if bucket.tokens < 1 {
+			return nil, false, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+		}
+		bucket.tokens--
+	}
+
+	result := &configResult{key: key, done: make(chan struct{}), admitted: now}
+	if window > 0 {
+		if l.results == nil {
+			l.results = make(map[string]*configResult)
+		}
+		l.results[key] = result
+	}
+	return result, false, 0
+}
+
+// The request of result has been served, the same requests get its response unless it failed.
+func (l *configLimiter) finish(result *configResult) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	result.finished = time.Now()
+	if result.failed() && l.results[result.key] == result {
+		delete(l.results, result.key)
+	}
+	close(result.done)
+}
+
+// A change of the node that started at started has completed, the responses of the changes admitted before it are
+// stale, they are not given to the same requests anymore. The ones that are running are still waited for.
+func (l *configLimiter) invalidate(started time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for key, result := range l.results {
+		if !result.finished.IsZero() && result.admitted.Before(started) {
+			delete(l.results, key)
+		}
+	}
+}
+
+// Forget the results older than window, and the clients that have all their tokens back. Called with the lock held.
+func (l *configLimiter) prune(c *config.ConfigRateLimitConfig, window time.Duration, now time.Time) {
+	for key, result := range l.results {
+		if !result.finished.IsZero() && now.Sub(result.finished) >= window {
+			delete(l.results, key)
+		}
+	}
+	for client, bucket := range l.buckets {
+		if c.PerMinute <= 0 || bucket.tokens+now.Sub(bucket.last).Seconds()*float64(c.PerMinute)/60 >= float64(c.GetBurst()) {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// The address of the client of a request, without its port, for its own limit.
+func limiterClient(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// The key of the same requests: the client, the method, the path with the query parameters, the If-Match header, and
+// a hash of the body.
+func configRequestKey(client string, r *http.Request, body []byte) string {
+	hash := sha256.Sum256(body)
+	return fmt.Sprintf("%v %v %v %q %v", client, r.Method, r.URL.RequestURI(), r.Header.Get("If-Match"), hex.EncodeToString(hash[:]))
+}
+
+// A PUT of the config state the node is already in does not change anything, it is not limited. The changes that set
+// something else along the way, e.g. the excluded services, are.
+func (a *API) configstateNoOp(r *http.Request, body []byte) bool {
+	if r.Method != "PUT" || r.URL.Query().Get("dryrun") != "" {
+		return false
+	}
+	var cfg Configstate
+	if err := decodeInputBody(body, &cfg, "configstate"); err != nil || cfg.State == nil {
+		return false
+	} else if (cfg.DryRun != nil && *cfg.DryRun) || cfg.ExcludedServices != nil || cfg.OptionalServices != nil || cfg.Channel != nil || len(cfg.Versions) != 0 || cfg.EffectiveTime != nil {
+		return false
+	}
+	pDevice, err := persistence.FindExchangeDevice(a.db)
+	if err != nil || pDevice == nil {
+		return false
+	}
+	return NoOpStateChange(pDevice.Config.State, *cfg.State)
+}
@@ -0,0 +1,160 @@
+// +build unit
+
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/worker"
+)
+
+// The same request gets the response of the first one, the others are refused once the burst is used up.
+func Test_limitConfigChanges(t *testing.T) {
+	cfg := getBasicConfig()
+	cfg.Edge.ConfigRateLimit = config.ConfigRateLimitConfig{PerMinute: 1, Burst: 4, DuplicateWindowS: 60}
+	a := &API{Manager: worker.Manager{Config: cfg}}
+
+	calls := 0
+	handler := NegotiateErrorFormat(a.requestID(a.limitConfigChanges(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), "bad") {
+			GetHTTPErrorHandler(w)(NewAPIUserInputError("the state is not valid", "configstate.state").WithCode(ERR_INVALID_STATE))
+			return
+		}
+		writeResponse(w, map[string]string{"state": "configured"}, http.StatusCreated)
+	}, nil)))
+
+	// the errors are read as JSON, with their code
+	put := func(body string, client string, ifMatch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("PUT", "/node/configstate", strings.NewReader(body))
+		r.RemoteAddr = client + ":1234"
+		r.Header.Set("Accept", "application/json")
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	first := put(`{"state":"configured"}`, "10.0.0.1", "")
+	again := put(`{"state":"configured"}`, "10.0.0.1", "")
+	if calls != 1 {
+		t.Errorf("the same request should not be run again, the handler was called %v times", calls)
+	} else if again.Code != first.Code || again.Body.String() != first.Body.String() {
+		t.Errorf("the same request should get the first response %v %v, got %v %v", first.Code, first.Body.String(), again.Code, again.Body.String())
+	} else if id := again.Header().Get(REQUEST_ID_HEADER); id == "" || id == first.Header().Get(REQUEST_ID_HEADER) {
+		t.Errorf("the same request should keep its own request ID, got %v and %v", first.Header().Get(REQUEST_ID_HEADER), id)
+	}
+
+	// the responses are not shared between the clients, nor between the If-Match conditions
+	if w := put(`{"state":"configured"}`, "10.0.0.2", ""); w.Code != http.StatusCreated || calls != 2 {
+		t.Errorf("the request of another client should be run, got status %v, %v calls", w.Code, calls)
+	}
+	if w := put(`{"state":"configured"}`, "10.0.0.1", `"1"`); w.Code != http.StatusCreated || calls != 3 {
+		t.Errorf("the request with another If-Match should be run, got status %v, %v calls", w.Code, calls)
+	}
+
+	// a failure is not kept
+	if w := put(`{"state":"bad"}`, "10.0.0.1", ""); w.Code != http.StatusBadRequest || errorBodyCode(w) != ERR_INVALID_STATE || calls != 4 {
+		t.Errorf("the failed request should be run, got status %v, %v calls", w.Code, calls)
+	}
+	w := put(`{"state":"bad"}`, "10.0.0.1", "")
+	if w.Code != http.StatusTooManyRequests || calls != 4 {
+		t.Errorf("the failed request should be run again, and be refused after the burst, got status %v, %v calls", w.Code, calls)
+	} else if w.Header().Get("Retry-After") == "" || errorBodyCode(w) != ERR_RATE_LIMITED {
+		t.Errorf("the refused request should have a Retry-After and the %v reason, got %v", ERR_RATE_LIMITED, w.Header())
+	}
+
+	// the reads are not limited
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/node/configstate", nil))
+	if w.Code != http.StatusCreated || calls != 5 {
+		t.Errorf("the GET should be run, got status %v", w.Code)
+	}
+}
+
+// A change of the node that completes makes the responses kept for the same requests stale, the ones that were
+// replayed or refused do not.
+func Test_limitConfigChanges_invalidate(t *testing.T) {
+	cfg := getBasicConfig()
+	cfg.Edge.ConfigRateLimit = config.ConfigRateLimitConfig{DuplicateWindowS: 60}
+	a := &API{Manager: worker.Manager{Config: cfg}}
+
+	calls := 0
+	router := http.NewServeMux()
+	router.HandleFunc("/node/configstate", a.limitConfigChanges(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	}, nil))
+	router.HandleFunc("/attribute", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	handler := a.trackOperations(router)
+
+	serve := func(method string, path string) {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, strings.NewReader(`{"state":"configured"}`)))
+	}
+
+	serve("PUT", "/node/configstate")
+	serve("PUT", "/node/configstate")
+	serve("PUT", "/node/configstate")
+	if calls != 1 {
+		t.Fatalf("the same requests should get the first response, the handler was called %v times", calls)
+	}
+
+	serve("POST", "/attribute")
+	serve("PUT", "/node/configstate")
+	if calls != 2 {
+		t.Errorf("the request should be run again after another change, the handler was called %v times", calls)
+	}
+}
+
+// A change to the state the node is already in is not limited.
+func Test_limitConfigChanges_noop(t *testing.T) {
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanTestDir(dir)
+
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, "myorg", "", persistence.CONFIGSTATE_CONFIGURED); err != nil {
+		t.Fatalf("failed to create persisted device, error %v", err)
+	}
+
+	cfg := getBasicConfig()
+	cfg.Edge.ConfigRateLimit = config.ConfigRateLimitConfig{PerMinute: 1, Burst: 1}
+	a := &API{Manager: worker.Manager{Config: cfg}, db: db}
+
+	calls := 0
+	handler := a.limitConfigChanges(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	}, a.configstateNoOp)
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("PUT", "/node/configstate", strings.NewReader(`{"state":"configured"}`)))
+		if w.Code != http.StatusCreated {
+			t.Errorf("the no-op change %v should not be limited, got status %v", i, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("PUT", "/node/configstate", strings.NewReader(`{"state":"configuring"}`)))
+	if w.Code != http.StatusCreated {
+		t.Errorf("the first change should be run, got status %v", w.Code)
+	}
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("PUT", "/node/configstate", strings.NewReader(`{"state":"configuring","force":true}`)))
+	if w.Code != http.StatusTooManyRequests || calls != 4 {
+		t.Errorf("the second change should be refused, got status %v after %v calls", w.Code, calls)
+	}
+}
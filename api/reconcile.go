@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+	"time"
+)
+
+// Compare the services registered on the configured node with the ones its pattern requires, as the autoconfig resolves
+// them, see the Edge.ServiceReconcileIntervalS of the config. The services that are missing or superfluous since the
+// last comparison are logged in the event log. When the node has an AutoReconcileAttributes attribute that is true, the
+// missing top-level services that need no user input are created, and the messages to publish for their policies are
+// returned. The comparison is saved for GET /node/reconcile, nil is returned when the node is not configured with a
+// pattern, or when its config state is changed while its services are resolved. An error with the
+// ERR_EXCHANGE_UNREACHABLE reason is returned when the pattern cannot be read.
+func ReconcileServices(ctx context.Context,
+	getPatterns exchange.PatternHandler,
+	resolveService exchange.ServiceDefResolverHandler,
+	getService exchange.ServiceHandler,
+	getDevice exchange.DeviceHandler,
+	patchDevice exchange.PatchDeviceHandler,
+	db *bolt.DB,
+	config *config.HorizonConfig) (*persistence.ServiceReconciliation, []events.Message, error) {
+
+	pDevice, err := persistence.FindExchangeDevice(db)
+	if err != nil {
+		return nil, nil, NewSystemError(fmt.Sprintf("Unable to read node object, error %v", err)).WithCode(ERR_DATABASE)
+	} else if pDevice == nil || pDevice.Config.State != persistence.CONFIGSTATE_CONFIGURED {
+		return nil, nil, nil
+	}
+
+	patOrg, patName, getAutoconfigPattern, _, err := autoconfigPattern(pDevice, getPatterns, config)
+	if err != nil {
+		return nil, nil, err
+	} else if getAutoconfigPattern == nil {
+		return nil, nil, nil
+	}
+	pat := fmt.Sprintf("%v/%v", patOrg, patName)
+
+	previous, err := persistence.FindServiceReconciliation(db)
+	if err != nil {
+		return nil, nil, NewSystemError(fmt.Sprintf("Unable to read the last reconciliation of the services, error %v", err)).WithCode(ERR_DATABASE)
+	} else if previous == nil {
+		previous = &persistence.ServiceReconciliation{}
+	}
+
+	autoReconcile, err := persistence.FindAutoReconcile(db)
+	if err != nil {
+		return nil, nil, NewSystemError(fmt.Sprintf("Unable to read the auto reconcile attribute of the node, error %v", err)).WithCode(ERR_DATABASE)
+	}
+
+	record := &persistence.ServiceReconciliation{
+		Timestamp:     uint64(time.Now().Unix()),
+		Pattern:       pat,
+		AutoReconcile: autoReconcile,
+		Missing:       persistence.ServiceSpecs{},
+		Superfluous:   persistence.ServiceSpecs{},
+		Created:       persistence.ServiceSpecs{},
+	}
+
+	// Same resolution as the autoconfig, with the services the node excludes or skipped as optional.
+	services, err := dryRunAutoconfig(ctx, pDevice, true, pDevice.Config.Excluded, newOptionalServices(pDevice.Config.Optional), pDevice.Config.Channel, getPatterns, resolveService, getService, db, config)
+
+	// The comparison is recorded, and the services created as the autoconfig creates them, under configstateLock: not
+	// while the config state is changed, nor once the agent is shutting down. The node may have been unconfigured, or
+	// given another pattern, while the services were resolved, they are compared again later then.
+	lockConfigstate()
+	defer unlockConfigstate()
+
+	if current, cerr := persistence.FindExchangeDevice(db); cerr != nil {
+		return nil, nil, NewSystemError(fmt.Sprintf("Unable to read node object, error %v", cerr)).WithCode(ERR_DATABASE)
+	} else if current == nil || current.Config.State != persistence.CONFIGSTATE_CONFIGURED || current.Pattern != pDevice.Pattern {
+		glog.V(3).Infof(apiRequestLogString(ctx, fmt.Sprintf("the node is no longer configured with the pattern %v, the services are not compared", pat)))
+		return nil, nil, nil
+	}
+
+	if err != nil {
+		record.Error = err.Error()
+		if ErrorReason(err) != ERR_EXCHANGE_UNREACHABLE {
+			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_SVC_RECONCILE, pat, err.Error()), persistence.EC_ERROR_SERVICE_RECONCILE, pDevice)
+		}
+		if saveErr := persistence.SaveServiceReconciliation(db, record); saveErr != nil {
+			glog.Errorf(apiRequestLogString(ctx, fmt.Sprintf("unable to save the reconciliation of the services, error %v", saveErr)))
+		}
+		return record, nil, err
+	}
+
+	create := make([]AutoconfigService, 0, 2)
+	for _, service := range services {
+		if service.Registered {
+			continue
+		}
+		record.Missing = append(record.Missing, persistence.ServiceSpec{Url: service.Url, Org: service.Org})
+		if !isExcludedService(previous.Missing, service.Url, service.Org) {
+			LogDeviceEvent(db, persistence.SEVERITY_WARN, persistence.NewMessageMeta(EL_API_SVC_MISSING, cutil.FormOrgSpecUrl(service.Url, service.Org), pat), persistence.EC_SERVICE_DRIFT, pDevice)
+		}
+
+		// The dependencies are created with their top-level service, and the services that need user input are left to
+		// the user.
+		if !autoReconcile || service.Dependency || service.MissingConfig != "" {
+			record.NotCreated = append(record.NotCreated, persistence.ServiceSpec{Url: service.Url, Org: service.Org})
+		} else {
+			create = append(create, service)
+		}
+	}
+
+	msgs := make([]events.Message, 0, 2)
+	for _, service := range create {
+		if msg, err := reconcileService(ctx, pDevice, service, getPatterns, resolveService, getService, getDevice, patchDevice, db, config); err != nil {
+			glog.Errorf(apiRequestLogString(ctx, fmt.Sprintf("unable to create the missing service %v/%v, error %v", service.Org, service.Url, err)))
+			LogDeviceEvent(db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_API_ERR_SVC_RECONCILE, pat, err.Error()), persistence.EC_ERROR_SERVICE_RECONCILE, pDevice)
+			record.NotCreated = append(record.NotCreated, persistence.ServiceSpec{Url: service.Url, Org: service.Org})
+		} else {
+			LogDeviceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_SVC_RECONCILE_CREATED, cutil.FormOrgSpecUrl(service.Url, service.Org), pat), persistence.EC_SERVICE_RECONCILED, pDevice)
+			record.Created = append(record.Created, persistence.ServiceSpec{Url: service.Url, Org: service.Org})
+			if msg != nil {
+				msgs = append(msgs, msg)
+			}
+		}
+	}
+
+	// The registered services that the pattern does not require, e.g. registered through /service/config or left from a
+	// previous version of the pattern.
+	msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()})
+	if err != nil {
+		return nil, msgs, NewSystemError(fmt.Sprintf("Error accessing db to find service definitions: %v", err)).WithCode(ERR_DATABASE)
+	}
+	for _, msdef := range msdefs {
+		if isAutoconfigService(services, msdef.SpecRef, msdef.Org) || isExcludedService(record.Superfluous, msdef.SpecRef, msdef.Org) {
+			continue
+		}
+		record.Superfluous = append(record.Superfluous, persistence.ServiceSpec{Url: msdef.SpecRef, Org: msdef.Org})
+		if !isExcludedService(previous.Superfluous, msdef.SpecRef, msdef.Org) {
+			LogDeviceEvent(db, persistence.SEVERITY_WARN, persistence.NewMessageMeta(EL_API_SVC_SUPERFLUOUS, cutil.FormOrgSpecUrl(msdef.SpecRef, msdef.Org), pat), persistence.EC_SERVICE_DRIFT, pDevice)
+		}
+	}
+
+	if len(record.Missing) != 0 || len(record.Superfluous) != 0 {
+		LogDeviceEvent(db, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_API_SVC_RECONCILE_COMPLETE, pat, len(record.Missing), len(record.Superfluous), len(record.Created)), persistence.EC_SERVICE_RECONCILED, pDevice)
+	}
+	glog.V(3).Infof(apiRequestLogString(ctx, fmt.Sprintf("Service reconciliation complete: %v", record)))
+
+	if err := persistence.SaveServiceReconciliation(db, record); err != nil {
+		return nil, msgs, NewSystemError(fmt.Sprintf("Unable to save the reconciliation of the services, error %v", err)).WithCode(ERR_DATABASE)
+	}
+	return record, msgs, nil
+}
+
+// Create a missing top-level service of the pattern as the autoconfig does, with the version range the node pinned it
+// to when it was configured. Returns the message to publish for its policy.
+func reconcileService(ctx context.Context,
+	pDevice *persistence.ExchangeDevice,
+	service AutoconfigService,
+	getPatterns exchange.PatternHandler,
+	resolveService exchange.ServiceDefResolverHandler,
+	getService exchange.ServiceHandler,
+	getDevice exchange.DeviceHandler,
+	patchDevice exchange.PatchDeviceHandler,
+	db *bolt.DB,
+	config *config.HorizonConfig) (events.Message, error) {
+
+	version := service.Version
+	if pinned, ok := pDevice.Config.Versions[versionPinKey(service.Url, service.Org)]; ok {
+		version = pinned
+	}
+
+	var createServiceError error
+	s := NewService(service.Url, service.Org, autoconfigServiceName(db, service.Url, service.Org, version), service.Arch, version)
+	if errHandled, _, msg := CreateService(ctx, s, GetPassThroughErrorHandler(&createServiceError), getPatterns, resolveService, getService, getDevice, patchDevice, nil, db, config, events.POLICY_ORIGIN_AUTOCONFIG, false); errHandled {
+		return nil, createServiceError
+	} else if msg != nil {
+		return msg, nil
+	}
+	return nil, nil
+}
+
+// Returns true if the service is one of the services that the autoconfig resolved.
+func isAutoconfigService(services []AutoconfigService, url string, org string) bool {
+	for _, service := range services {
+		if cutil.SameSpecURL(service.Url, url) && service.Org == org {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the last reconciliation of the services of the node, as for a GET on /node/reconcile.
+func FindServiceReconciliationForOutput(db *bolt.DB) (*persistence.ServiceReconciliation, error) {
+	reconciliation, err := persistence.FindServiceReconciliation(db)
+	if err != nil {
+		return nil, NewSystemError(fmt.Sprintf("Unable to read the reconciliation of the services of the node, error %v", err)).WithCode(ERR_DATABASE)
+	} else if reconciliation == nil {
+		return nil, NewNotFoundError("The services of the node have not been compared with the ones of its pattern yet.", "node/reconcile")
+	}
+	return reconciliation, nil
+}
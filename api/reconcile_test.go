@@ -0,0 +1,141 @@
+// +build unit
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+)
+
+// The services of a configured node are compared with the ones of its pattern, the missing ones are only created with
+// an AutoReconcileAttributes attribute of the node.
+func Test_ReconcileServices(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	myOrg := "myorg"
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "", false, myOrg, "mypattern", persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Errorf("failed to create persisted device, error %v", err)
+	}
+
+	unreachable := false
+	patternHandler := func(pOrg string, pattern string) (map[string]exchange.Pattern, error) {
+		if unreachable {
+			return nil, errors.New("connection refused")
+		}
+		srs := []exchange.ServiceReference{
+			{ServiceURL: "wurl", ServiceOrg: myOrg, ServiceArch: cutil.ArchString(), ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}}},
+		}
+		return map[string]exchange.Pattern{fmt.Sprintf("%v/%v", pOrg, pattern): {Label: "label", Services: srs}}, nil
+	}
+	_, sResolver := getOptionalTestHandlers(myOrg, false)
+	sHandler := getVariableServiceHandler(exchange.UserInput{})
+
+	reconcile := func() (*persistence.ServiceReconciliation, error) {
+		record, _, err := ReconcileServices(context.Background(), patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig())
+		return record, err
+	}
+
+	// nothing is compared until the node is configured
+	if record, err := reconcile(); err != nil || record != nil {
+		t.Fatalf("a configuring node should not be reconciled, got %v, error %v", record, err)
+	} else if _, err := FindServiceReconciliationForOutput(db); err == nil {
+		t.Errorf("there should be no reconciliation yet")
+	}
+
+	var myError error
+	state := persistence.CONFIGSTATE_CONFIGURED
+	if errHandled, _, _ := UpdateConfigstate(context.Background(), &Configstate{State: &state}, GetPassThroughErrorHandler(&myError), patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig()); errHandled {
+		t.Fatalf("unexpected error (%T) %v", myError, myError)
+	}
+
+	// the services of the pattern are all registered, an extra one is superfluous
+	if err := persistence.SaveOrUpdateMicroserviceDef(db, &persistence.MicroserviceDefinition{SpecRef: "extra", Org: myOrg, Version: "1.0.0"}); err != nil {
+		t.Fatalf("unable to save the extra service, error %v", err)
+	}
+	if record, err := reconcile(); err != nil {
+		t.Fatalf("unexpected error (%T) %v", err, err)
+	} else if len(record.Missing) != 0 || len(record.Superfluous) != 1 || record.Superfluous[0].Url != "extra" {
+		t.Errorf("only the extra service should be superfluous, got %v", record)
+	}
+
+	// the top-level service is removed, it is missing but not created without auto_reconcile
+	msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter(), persistence.UrlOrgMSFilter("wurl", myOrg)})
+	if err != nil || len(msdefs) != 1 {
+		t.Fatalf("the wurl service should be registered, got %v, error %v", msdefs, err)
+	} else if _, err := persistence.MsDefArchived(db, msdefs[0].Id); err != nil {
+		t.Fatalf("unable to archive the wurl service, error %v", err)
+	}
+	if record, err := reconcile(); err != nil {
+		t.Fatalf("unexpected error (%T) %v", err, err)
+	} else if len(record.Missing) != 1 || record.Missing[0].Url != "wurl" || len(record.Created) != 0 || len(record.NotCreated) != 1 || record.AutoReconcile {
+		t.Errorf("the wurl service should be missing and not created, got %v", record)
+	}
+
+	// with auto_reconcile, it is created again
+	if _, err := persistence.SaveOrUpdateAttribute(db, persistence.AutoReconcileAttributes{Meta: &persistence.AttributeMeta{Id: "autoreconcile", Label: "auto reconcile", Type: "AutoReconcileAttributes"}, ServiceSpecs: new(persistence.ServiceSpecs), AutoReconcile: true}, "", false); err != nil {
+		t.Fatalf("unable to save the auto reconcile attribute, error %v", err)
+	}
+	if record, _, err := ReconcileServices(context.Background(), patternHandler, sResolver, sHandler, getDummyDeviceHandler(), getDummyPatchDeviceHandler(), db, getBasicConfig()); err != nil {
+		t.Fatalf("unexpected error (%T) %v", err, err)
+	} else if !record.AutoReconcile || len(record.Created) != 1 || record.Created[0].Url != "wurl" || len(record.NotCreated) != 0 {
+		t.Errorf("the wurl service should be created, got %v", record)
+	}
+	if msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter(), persistence.UrlOrgMSFilter("wurl", myOrg)}); err != nil || len(msdefs) != 1 {
+		t.Errorf("the wurl service should be registered again, got %v, error %v", msdefs, err)
+	}
+
+	// the node is unconfigured while the services are resolved, they are not compared
+	if msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter(), persistence.UrlOrgMSFilter("wurl", myOrg)}); err != nil || len(msdefs) != 1 {
+		t.Fatalf("the wurl service should be registered, got %v, error %v", msdefs, err)
+	} else if _, err := persistence.MsDefArchived(db, msdefs[0].Id); err != nil {
+		t.Fatalf("unable to archive the wurl service, error %v", err)
+	}
+	lockConfigstate()
+	done := make(chan *persistence.ServiceReconciliation)
+	go func() {
+		record, _ := reconcile()
+		done <- record
+	}()
+	for atomic.LoadInt32(&configstateChanges) != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
+		t.Fatalf("unable to read the node, error %v", err)
+	} else if _, err := pDevice.SetConfigstate(db, pDevice.Id, persistence.CONFIGSTATE_CONFIGURING); err != nil {
+		t.Fatalf("unable to change the config state, error %v", err)
+	}
+	unlockConfigstate()
+	if record := <-done; record != nil {
+		t.Errorf("the services should not be compared, got %v", record)
+	} else if out, err := FindServiceReconciliationForOutput(db); err != nil || len(out.Created) != 1 {
+		t.Errorf("the last reconciliation should be kept, got %v, error %v", out, err)
+	}
+	if pDevice, err := persistence.FindExchangeDevice(db); err != nil {
+		t.Fatalf("unable to read the node, error %v", err)
+	} else if _, err := pDevice.SetConfigstate(db, pDevice.Id, persistence.CONFIGSTATE_CONFIGURED); err != nil {
+		t.Fatalf("unable to change the config state, error %v", err)
+	}
+
+	// the exchange cannot be reached, the error is recorded
+	unreachable = true
+	if _, err := reconcile(); err == nil || ErrorReason(err) != ERR_EXCHANGE_UNREACHABLE {
+		t.Errorf("the error should have the ERR_EXCHANGE_UNREACHABLE reason, got (%T) %v", err, err)
+	} else if out, err := FindServiceReconciliationForOutput(db); err != nil {
+		t.Errorf("unexpected error (%T) %v", err, err)
+	} else if out.Error == "" || len(out.Missing) != 0 {
+		t.Errorf("the last reconciliation should have the error, got %v", out)
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// The header of the request ID of a response. A request can give its own ID in it, e.g. the ID of the client's own
+// request, otherwise the API generates one.
+const REQUEST_ID_HEADER = "X-Request-Id"
+
+// The field of the JSON error bodies that holds the request ID, so that it can be quoted when an issue is filed.
+const REQUEST_ID_FIELD = "request_id"
+
+// The request IDs that a client can give, anything else is replaced by a generated one so that the logs stay readable.
+var requestIDRE = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type requestIDKey struct{}
+
+// Give each request an ID, in the X-Request-Id header of its response and in the context of the request, so that the
+// log lines of a request can be told apart from the ones of the requests that run at the same time.
+func (a *API) requestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(REQUEST_ID_HEADER)
+		if !requestIDRE.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, id)
+		h.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
+
+// A new random request ID, 16 hex characters.
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(id)
+}
+
+// Returns a context with the request ID, e.g. for the changes of the config state that are not made by a request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// The request ID of the context, "" when it has none.
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// The request ID of a response, "" when the request has none.
+func responseRequestID(w http.ResponseWriter) string {
+	return w.Header().Get(REQUEST_ID_HEADER)
+}
+
+// Like apiLogString, with the request ID of the context so that the log lines of a request can be found together. The
+// paths of the API that have the context of their request use it instead of apiLogString.
+func apiRequestLogString(ctx context.Context, v interface{}) string {
+	return requestLogString(RequestID(ctx), v)
+}
+
+// Like apiRequestLogString, with the request ID of a response, for the code that writes the response.
+func apiResponseLogString(w http.ResponseWriter, v interface{}) string {
+	return requestLogString(responseRequestID(w), v)
+}
+
+func requestLogString(id string, v interface{}) string {
+	if id != "" {
+		return apiLogString(fmt.Sprintf("[request %v] %v", id, v))
+	}
+	return apiLogString(v)
+}
+
+// Add the request ID to a serialized JSON error body. The body is returned as is when it is not an object.
+func addRequestIDField(serial []byte, id string) []byte {
+	if id == "" {
+		return serial
+	}
+
+	var body map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(serial))
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil || body == nil {
+		return serial
+	}
+	body[REQUEST_ID_FIELD] = id
+	if withID, err := json.Marshal(body); err == nil {
+		return withID
+	}
+	return serial
+}
+
+// The message of an error that is written as text, with the request ID of the response.
+func withRequestID(w http.ResponseWriter, msg string) string {
+	if id := responseRequestID(w); id != "" {
+		return fmt.Sprintf("%v (request %v)", msg, id)
+	}
+	return msg
+}
@@ -0,0 +1,88 @@
+// +build unit
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_requestID(t *testing.T) {
+
+	a := &API{}
+	var seen string
+	handler := NegotiateErrorFormat(a.requestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+		if r.URL.Query().Get("fail") != "" {
+			GetHTTPErrorHandler(w)(NewAPIUserInputError("the node id is not valid", "device.id"))
+		} else if r.URL.Query().Get("system") != "" {
+			GetHTTPErrorHandler(w)(NewSystemError("the database cannot be read"))
+		}
+	})))
+
+	// a generated ID is in the header and in the context of the request
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/node", nil))
+	if id := w.Header().Get(REQUEST_ID_HEADER); len(id) != 16 || id != seen {
+		t.Errorf("the generated request ID %v should be in the context, got %v", id, seen)
+	}
+
+	// the ID of the client is kept when it is valid, replaced otherwise
+	for id, kept := range map[string]bool{"my-request.1": true, "not valid": false, strings.Repeat("a", 65): false} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/node", nil)
+		r.Header.Set(REQUEST_ID_HEADER, id)
+		handler.ServeHTTP(w, r)
+		if got := w.Header().Get(REQUEST_ID_HEADER); (got == id) != kept || got != seen {
+			t.Errorf("the request ID %v should be kept %v, got %v in the header and %v in the context", id, kept, got, seen)
+		}
+	}
+
+	// the ID is in the JSON errors and at the end of the text ones
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/node?fail=true", nil)
+	r.Header.Set(REQUEST_ID_HEADER, "my-request")
+	handler.ServeHTTP(w, r)
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("the error should be JSON, got %v, error %v", w.Body.String(), err)
+	} else if body[REQUEST_ID_FIELD] != "my-request" || body["input"] != "device.id" {
+		t.Errorf("the error should have the request ID, got %v", body)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/node?system=true", nil)
+	r.Header.Set(REQUEST_ID_HEADER, "my-request")
+	handler.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), "the database cannot be read (request my-request)") {
+		t.Errorf("the text error should have the request ID, got %v", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/node?system=true", nil)
+	r.Header.Set(REQUEST_ID_HEADER, "my-request")
+	r.Header.Set("Accept", "application/json")
+	handler.ServeHTTP(w, r)
+	body = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("the error should be JSON, got %v, error %v", w.Body.String(), err)
+	} else if body[REQUEST_ID_FIELD] != "my-request" || body["error"] != "the database cannot be read" {
+		t.Errorf("the error should have the request ID, got %v", body)
+	}
+}
+
+func Test_addRequestIDField(t *testing.T) {
+
+	if got := string(addRequestIDField([]byte(`{"error":"bad","count":12345678901234567890}`), "abc")); got != `{"count":12345678901234567890,"error":"bad","request_id":"abc"}` {
+		t.Errorf("the request ID should be added and the number kept, got %v", got)
+	}
+	if got := string(addRequestIDField([]byte(`["not","an","object"]`), "abc")); got != `["not","an","object"]` {
+		t.Errorf("a body that is not an object should not change, got %v", got)
+	}
+	if got := string(addRequestIDField([]byte(`{"error":"bad"}`), "")); got != `{"error":"bad"}` {
+		t.Errorf("a body without a request ID should not change, got %v", got)
+	}
+}
@@ -0,0 +1,429 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/persistence"
+)
+
+// How long the operations that were cancelled by a shutdown are given to roll back what they changed, after the grace
+// period.
+const shutdownRollbackWait = 10 * time.Second
+
+// How long the messages queued by the API are given to be sent on the message bus during a shutdown.
+const shutdownFlushWait = 5 * time.Second
+
+// How long the requests that are being served when the API has been shut down are given to complete before its
+// listeners are closed, e.g. the response of POST /node/shutdown and its audit entry.
+const shutdownServeWait = 5 * time.Second
+
+// The states of the operations that a shutdown waited for.
+const (
+	SHUTDOWN_OP_COMPLETED = "completed" // the operation completed, within the grace period or because it could no longer be cancelled
+	SHUTDOWN_OP_CANCELLED = "cancelled" // the operation was cancelled after the grace period, and what it changed rolled back
+	SHUTDOWN_OP_RUNNING   = "running"   // the operation was cancelled, but was still running when the agent stopped waiting
+)
+
+// A request of the API that changes the node and was running when the agent was shut down.
+type ShutdownOperation struct {
+	RequestId string `json:"request_id"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	StartTime uint64 `json:"start_time"`
+	State     string `json:"state"`
+}
+
+func (o ShutdownOperation) String() string {
+	return fmt.Sprintf("RequestId: %v, Method: %v, Path: %v, StartTime: %v, State: %v", o.RequestId, o.Method, o.Path, o.StartTime, o.State)
+}
+
+// The outcome of the shutdown of the API, as returned by POST /node/shutdown.
+type ShutdownResult struct {
+	GracePeriodS uint64              `json:"grace_period_s"`
+	StartTime    uint64              `json:"start_time"`
+	EndTime      uint64              `json:"end_time"`
+	Operations   []ShutdownOperation `json:"operations"`
+}
+
+func (s ShutdownResult) String() string {
+	return fmt.Sprintf("GracePeriodS: %v, StartTime: %v, EndTime: %v, Operations: %v", s.GracePeriodS, s.StartTime, s.EndTime, s.Operations)
+}
+
+// The requests that change the node and are running, so that a shutdown waits for them. The zero value is ready to
+// use.
+type operationTracker struct {
+	lock     sync.Mutex
+	draining bool // no new operation is accepted
+	next     uint64
+	running  map[uint64]*runningOperation
+}
+
+type runningOperation struct {
+	seq    uint64
+	op     ShutdownOperation
+	cancel context.CancelFunc
+	done   chan struct{} // closed once the request has been served
+	failed bool          // the request was served with an error, set before done is closed
+}
+
+// A response writer that keeps the status of the response of an operation, so that one that was cancelled but completed
+// anyway, past the point where it could stop, is not reported as rolled back.
+type operationWriter struct {
+	http.ResponseWriter
+	status int
+	notRun bool // the change was not made, e.g. the request got the response of the same request
+}
+
+// Record that the request of w did not change the node.
+func markNotRun(w http.ResponseWriter) {
+	if ow, ok := w.(*operationWriter); ok {
+		ow.notRun = true
+	}
+}
+
+func (o *operationWriter) WriteHeader(status int) {
+	if o.status == 0 {
+		o.status = status
+	}
+	o.ResponseWriter.WriteHeader(status)
+}
+
+func (o *operationWriter) Write(b []byte) (int, error) {
+	if o.status == 0 {
+		o.status = http.StatusOK
+	}
+	return o.ResponseWriter.Write(b)
+}
+
+func (o *operationWriter) Flush() {
+	if f, ok := o.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Record the operation of the request, with a context that the shutdown cancels when the operation takes longer than
+// the grace period. Returns false when the agent is shutting down, the request must not be served then.
+func (t *operationTracker) begin(r *http.Request) (*runningOperation, context.Context, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.draining {
+		return nil, nil, false
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	t.next++
+	op := &runningOperation{
+		seq:    t.next,
+		op:     ShutdownOperation{RequestId: RequestID(r.Context()), Method: r.Method, Path: r.URL.Path, StartTime: uint64(time.Now().Unix())},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	if t.running == nil {
+		t.running = make(map[uint64]*runningOperation)
+	}
+	t.running[op.seq] = op
+	return op, ctx, true
+}
+
+// The request of the operation has been served with the given status.
+func (t *operationTracker) end(op *runningOperation, status int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.running, op.seq)
+	op.cancel()
+	op.failed = status >= http.StatusBadRequest
+	close(op.done)
+}
+
+// Accept new operations again, after a shutdown that did not stop anax.
+func (t *operationTracker) resume() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.draining = false
+}
+
+// Stop accepting new operations and wait for the running ones to complete, at most grace. The ones that are still
+// running are then cancelled and given rollbackWait to roll back. Returns the operations that were waited for, in the
+// order they started.
+func (t *operationTracker) drain(grace time.Duration, rollbackWait time.Duration) []ShutdownOperation {
+	t.lock.Lock()
+	t.draining = true
+	running := make([]*runningOperation, 0, len(t.running))
+	for _, op := range t.running {
+		running = append(running, op)
+	}
+	t.lock.Unlock()
+
+	sort.Slice(running, func(i, j int) bool { return running[i].seq < running[j].seq })
+
+	waitOperations(running, grace)
+
+	cancelled := []*runningOperation{}
+	for _, op := range running {
+		if operationDone(op) {
+			op.op.State = SHUTDOWN_OP_COMPLETED
+		} else {
+			glog.Warningf(apiLogString(fmt.Sprintf("cancelling %v %v of request %v, it did not complete within the shutdown grace period of %v", op.op.Method, op.op.Path, op.op.RequestId, grace)))
+			op.cancel()
+			cancelled = append(cancelled, op)
+		}
+	}
+
+	waitOperations(cancelled, rollbackWait)
+
+	ops := make([]ShutdownOperation, 0, len(running))
+	for _, op := range running {
+		if op.op.State == "" && operationDone(op) && op.failed {
+			op.op.State = SHUTDOWN_OP_CANCELLED
+		} else if op.op.State == "" && operationDone(op) {
+			op.op.State = SHUTDOWN_OP_COMPLETED
+		} else if op.op.State == "" {
+			op.op.State = SHUTDOWN_OP_RUNNING
+		}
+		ops = append(ops, op.op)
+	}
+	return ops
+}
+
+// Wait for the operations to be done, at most timeout. Returns false when some are still running.
+func waitOperations(ops []*runningOperation, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for _, op := range ops {
+		select {
+		case <-op.done:
+		case <-timer.C:
+			return false
+		}
+	}
+	return true
+}
+
+func operationDone(op *runningOperation) bool {
+	select {
+	case <-op.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Refuse the requests that change the node once the agent is shutting down, and record the ones that are served so
+// that the shutdown waits for them. The context of a request is cancelled when it does not complete within the
+// grace period of the shutdown: a change stops, and rolls back what it changed, unless it is already writing its last
+// changes.
+func (a *API) trackOperations(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" || r.URL.Path == "/node/shutdown" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		op, ctx, ok := a.operations.begin(r)
+		if !ok {
+			GetHTTPErrorHandler(w)(NewServiceUnavailableError(fmt.Sprintf("%v %v is refused, the agent is shutting down.", r.Method, r.URL.Path)).WithCode(ERR_SHUTTING_DOWN))
+			return
+		}
+		// The responses of the changes made before this one, kept for the same requests, are stale once it completes.
+		started := time.Now()
+		ow := &operationWriter{ResponseWriter: w}
+		defer func() {
+			if !ow.notRun {
+				a.limiter.invalidate(started)
+			}
+			a.operations.end(op, ow.status)
+		}()
+		h.ServeHTTP(ow, r.WithContext(ctx))
+	})
+}
+
+// Shut the API down before anax exits: the requests that change the node are refused, the ones that are running are
+// given the Edge.ShutdownGracePeriodS of the config to complete and are cancelled after it, and the changes of the config
+// state made in the background are waited for. The messages queued by the API are then sent. Only the first call shuts
+// the API down, the other ones wait for it and return the same result, until resume is called.
+func (a *API) Shutdown() *ShutdownResult {
+	a.shutdownLock.Lock()
+	defer a.shutdownLock.Unlock()
+
+	if a.shutdownResult != nil {
+		return a.shutdownResult
+	}
+
+	grace := a.Config.GetShutdownGracePeriod()
+	result := &ShutdownResult{GracePeriodS: uint64(grace / time.Second), StartTime: uint64(time.Now().Unix())}
+	glog.Infof(apiLogString(fmt.Sprintf("shutting down, waiting up to %v for the operations that are running", grace)))
+
+	result.Operations = a.operations.drain(grace, shutdownRollbackWait)
+
+	// The changes of the config state that are not made by a request, e.g. at the effective time of a
+	// configured_pending node or by the service reconciliation, hold configstateLock. The shutdown takes it so that
+	// none starts while anax exits, and gives it back in resume.
+	a.configstateHeld = a.holdConfigstate()
+	select {
+	case <-a.configstateHeld:
+	case <-time.After(shutdownRollbackWait):
+		glog.Warningf(apiLogString(fmt.Sprintf("a change of the config state is still running after %v", shutdownRollbackWait)))
+	}
+
+	if a.progress != nil && !a.progress.flush(shutdownFlushWait) {
+		glog.Warningf(apiLogString(fmt.Sprintf("the configstate progress messages were not all sent within %v", shutdownFlushWait)))
+	}
+	if !flushMessages(a.Messages(), shutdownFlushWait) {
+		glog.Warningf(apiLogString(fmt.Sprintf("the messages queued by the API were not all sent within %v", shutdownFlushWait)))
+	}
+
+	result.EndTime = uint64(time.Now().Unix())
+	glog.Infof(apiLogString(fmt.Sprintf("shut down, %v", result)))
+	a.shutdownResult = result
+	return result
+}
+
+// Take configstateLock for the shutdown, and stop the timer of a configured_pending node once it is taken. Returns a
+// channel that is closed once it is taken.
+func (a *API) holdConfigstate() chan struct{} {
+	held := make(chan struct{})
+	go func() {
+		lockConfigstate()
+		if a.pendingTimer != nil {
+			a.pendingTimer.Stop()
+		}
+		close(held)
+	}()
+	return held
+}
+
+// Undo the shutdown of the API when anax cannot exit after it, so that the agent is not left refusing every change:
+// the requests that change the node are accepted again, and configstateLock is given back once the shutdown has taken
+// it, with the timer of a configured_pending node set again.
+func (a *API) resume() {
+	a.shutdownLock.Lock()
+	defer a.shutdownLock.Unlock()
+
+	if a.shutdownResult == nil {
+		return
+	}
+	a.shutdownResult = nil
+	a.operations.resume()
+
+	held := a.configstateHeld
+	go func() {
+		<-held
+		defer unlockConfigstate()
+		if a.db == nil {
+			return
+		} else if pDevice, err := persistence.FindExchangeDevice(a.db); err != nil {
+			glog.Errorf(apiLogString(fmt.Sprintf("unable to read the node to resume its effective time, error %v", err)))
+		} else if pDevice != nil {
+			a.schedulePendingConfigstate(ConvertFromPersistentHorizonDevice(pDevice).Config)
+		}
+	}()
+	glog.Infof(apiLogString("the shutdown was undone, the changes of the node are accepted again"))
+}
+
+// Wait for the message bus to take the messages queued on messages, at most timeout. Returns false when some are still
+// queued.
+func flushMessages(messages chan events.Message, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for len(messages) != 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// Close the listeners of the API once the requests that they are serving are done, at most timeout. The ones that are
+// still being served are then dropped.
+func (a *API) closeListeners(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, server := range a.servers {
+		if err := server.Shutdown(ctx); err != nil {
+			glog.Warningf(apiLogString(fmt.Sprintf("closing the listener on %v, its requests did not complete within %v, error %v", server.Addr, timeout, err)))
+			server.Close()
+		}
+	}
+}
+
+// The API listener that Shutdown shuts down.
+var shutdownLock sync.Mutex
+var shutdownAPI *API
+
+func setShutdownAPI(a *API) {
+	shutdownLock.Lock()
+	defer shutdownLock.Unlock()
+	shutdownAPI = a
+}
+
+// Shut the API listener of the agent down, see API.Shutdown, e.g. when anax receives SIGTERM, and close its listeners
+// once the requests that they are serving are done, so that the database can be closed. Returns nil when there is no
+// API listener.
+func Shutdown() *ShutdownResult {
+	shutdownLock.Lock()
+	a := shutdownAPI
+	shutdownLock.Unlock()
+
+	if a == nil {
+		return nil
+	}
+	result := a.Shutdown()
+	a.closeListeners(shutdownServeWait)
+	return result
+}
+
+// Make anax exit once POST /node/shutdown has drained the API. The SIGTERM handler of anax shuts the API down, which
+// waits for the request to be served, then closes the database and exits.
+func signalExit() error {
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		return err
+	}
+	return p.Signal(syscall.SIGTERM)
+}
+
+func (a *API) nodeshutdown(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/shutdown"
+
+	switch r.Method {
+	case "POST":
+		glog.V(5).Infof(apiRequestLogString(r.Context(), fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		result := a.Shutdown()
+		writeResponse(w, result, http.StatusOK)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+
+		glog.V(5).Infof(apiRequestLogString(r.Context(), fmt.Sprintf("Handled %v on resource %v", r.Method, resource)))
+		exitProcess := a.exitProcess
+		if exitProcess == nil {
+			exitProcess = signalExit
+		}
+		go func() {
+			if err := exitProcess(); err != nil {
+				glog.Errorf(apiLogString(fmt.Sprintf("unable to stop the anax process, error %v", err)))
+				a.resume()
+			}
+		}()
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "POST, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,257 @@
+// +build unit
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/worker"
+)
+
+// The operations that complete within the grace period are waited for, the other ones are cancelled.
+func Test_operationTracker_drain(t *testing.T) {
+
+	var tracker operationTracker
+	begin := func(method string, path string) (*runningOperation, context.Context) {
+		op, ctx, ok := tracker.begin(httptest.NewRequest(method, path, nil))
+		if !ok {
+			t.Fatalf("%v %v should be accepted", method, path)
+		}
+		return op, ctx
+	}
+
+	quick, _ := begin("POST", "/service/config")
+	cancellable, ctx := begin("PUT", "/node/configstate")
+	stuck, _ := begin("POST", "/node/import")
+	late, lateCtx := begin("DELETE", "/node/userinput")
+	finished, _ := begin("PATCH", "/node")
+	tracker.end(finished, http.StatusOK)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		tracker.end(quick, http.StatusCreated)
+	}()
+	go func() {
+		<-ctx.Done()
+		tracker.end(cancellable, http.StatusServiceUnavailable)
+	}()
+	// it was past the point where it could stop when it was cancelled
+	go func() {
+		<-lateCtx.Done()
+		tracker.end(late, http.StatusNoContent)
+	}()
+
+	ops := tracker.drain(200*time.Millisecond, 50*time.Millisecond)
+	defer tracker.end(stuck, http.StatusOK)
+
+	expected := []struct {
+		path  string
+		state string
+	}{
+		{"/service/config", SHUTDOWN_OP_COMPLETED},
+		{"/node/configstate", SHUTDOWN_OP_CANCELLED},
+		{"/node/import", SHUTDOWN_OP_RUNNING},
+		{"/node/userinput", SHUTDOWN_OP_COMPLETED},
+	}
+	if len(ops) != len(expected) {
+		t.Fatalf("expected %v operations, got %v", len(expected), ops)
+	}
+	for i, e := range expected {
+		if ops[i].Path != e.path || ops[i].State != e.state {
+			t.Errorf("operation %v should be %v %v, got %v", i, e.path, e.state, ops[i])
+		}
+	}
+
+	if _, _, ok := tracker.begin(httptest.NewRequest("PUT", "/node/configstate", nil)); ok {
+		t.Errorf("no operation should be accepted once the tracker is drained")
+	}
+}
+
+// POST /node/shutdown waits for the changes that are running, refuses the new ones and makes anax exit.
+func Test_nodeshutdown(t *testing.T) {
+
+	exited := make(chan struct{}, 2)
+	exitProcess := func() error {
+		exited <- struct{}{}
+		return nil
+	}
+
+	cfg := getBasicConfig()
+	cfg.Edge.ShutdownGracePeriodS = 5
+	a := &API{Manager: worker.Manager{Config: cfg}, exitProcess: exitProcess}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	router := http.NewServeMux()
+	router.HandleFunc("/node/configstate", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	})
+	router.HandleFunc("/node/shutdown", a.nodeshutdown)
+	handler := NegotiateErrorFormat(a.trackOperations(router))
+
+	// the errors are read as JSON, with their code
+	serve := func(method string, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, nil).WithContext(WithRequestID(context.Background(), "req1"))
+		r.Header.Set("Accept", "application/json")
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	go serve("PUT", "/node/configstate")
+	<-started
+
+	shutdown := make(chan *httptest.ResponseRecorder)
+	go func() { shutdown <- serve("POST", "/node/shutdown") }()
+
+	// the change completes once the shutdown waits for it
+	for draining := false; !draining; {
+		time.Sleep(time.Millisecond)
+		a.operations.lock.Lock()
+		draining = a.operations.draining
+		a.operations.lock.Unlock()
+	}
+	close(release)
+
+	var result ShutdownResult
+	if w := <-shutdown; w.Code != http.StatusOK {
+		t.Fatalf("expected status %v, got %v", http.StatusOK, w.Code)
+	} else if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("unable to demarshal the response %v, error %v", w.Body.String(), err)
+	} else if result.GracePeriodS != 5 || len(result.Operations) != 1 {
+		t.Errorf("wrong shutdown result %v", result)
+	} else if op := result.Operations[0]; op.RequestId != "req1" || op.Method != "PUT" || op.Path != "/node/configstate" || op.State != SHUTDOWN_OP_COMPLETED {
+		t.Errorf("wrong operation %v", op)
+	}
+
+	waitExit := func() {
+		select {
+		case <-exited:
+		case <-time.After(time.Second):
+			t.Errorf("anax should exit after the shutdown")
+		}
+	}
+	waitExit()
+
+	// give back configstateLock, taken by the shutdown, for the other tests
+	defer unlockConfigstate()
+	<-a.configstateHeld
+
+	// the changes are refused, the reads are still served
+	if w := serve("PUT", "/node/configstate"); w.Code != http.StatusServiceUnavailable || errorBodyCode(w) != ERR_SHUTTING_DOWN {
+		t.Errorf("a change should be refused with %v %v, got %v %v", http.StatusServiceUnavailable, ERR_SHUTTING_DOWN, w.Code, errorBodyCode(w))
+	}
+	if w := serve("OPTIONS", "/node/shutdown"); w.Code != http.StatusOK {
+		t.Errorf("a read should be served, got %v", w.Code)
+	}
+
+	// the shutdown requested again gets the same result
+	if w := serve("POST", "/node/shutdown"); w.Code != http.StatusOK {
+		t.Errorf("expected status %v, got %v", http.StatusOK, w.Code)
+	} else if again := (ShutdownResult{}); json.Unmarshal(w.Body.Bytes(), &again) != nil || again.StartTime != result.StartTime || len(again.Operations) != 1 {
+		t.Errorf("the shutdown should return the same result, got %v", w.Body.String())
+	}
+	waitExit()
+}
+
+// A shutdown after which anax cannot exit is undone, the agent accepts the changes again.
+func Test_nodeshutdown_resume(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	exitProcess := func() error { return errors.New("not permitted") }
+	a := &API{Manager: worker.Manager{Config: getBasicConfig()}, db: db, exitProcess: exitProcess}
+	router := http.NewServeMux()
+	router.HandleFunc("/node/configstate", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusCreated) })
+	router.HandleFunc("/node/shutdown", a.nodeshutdown)
+	handler := a.trackOperations(router)
+
+	serve := func(method string, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	if w := serve("POST", "/node/shutdown"); w.Code != http.StatusOK {
+		t.Fatalf("expected status %v, got %v", http.StatusOK, w.Code)
+	}
+
+	// the changes are accepted again and configstateLock is given back
+	for resumed := false; !resumed; {
+		time.Sleep(time.Millisecond)
+		a.shutdownLock.Lock()
+		resumed = a.shutdownResult == nil
+		a.shutdownLock.Unlock()
+	}
+	if w := serve("PUT", "/node/configstate"); w.Code != http.StatusCreated {
+		t.Errorf("a change should be served again, got %v", w.Code)
+	}
+	locked := make(chan struct{})
+	go func() {
+		lockConfigstate()
+		unlockConfigstate()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Errorf("configstateLock should be released")
+	}
+}
+
+// The messages queued by the API are waited for until they are taken off the queue.
+func Test_flushMessages(t *testing.T) {
+
+	messages := make(chan events.Message, 10)
+	messages <- events.NewUpdatePolicyMessage(events.UPDATE_POLICY)
+	if flushMessages(messages, 10*time.Millisecond) {
+		t.Errorf("the message cannot be sent until it is received")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-messages
+	}()
+	if !flushMessages(messages, time.Second) {
+		t.Errorf("the message should be sent")
+	}
+}
+
+// The progress messages that are queued are sent before the flush returns.
+func Test_progressPublisher_flush(t *testing.T) {
+
+	messages := make(chan events.Message)
+	p := newProgressPublisher(messages, 10)
+
+	if !p.flush(time.Millisecond) {
+		t.Errorf("a publisher without messages should be flushed")
+	}
+
+	p.publish(events.NewConfigstateProgressMessage(events.CONFIGSTATE_PROGRESS, events.CONFIGSTATE_PHASE_PATTERN_FETCHED, "myorg/mypattern", 0, 1, 0, 0, ""))
+	p.publish(events.NewConfigstateProgressMessage(events.CONFIGSTATE_PROGRESS, events.CONFIGSTATE_PHASE_PATTERN_FETCHED, "myorg/mypattern", 0, 1, 0, 0, ""))
+	if p.flush(10 * time.Millisecond) {
+		t.Errorf("the messages cannot be sent until they are received")
+	}
+
+	go func() {
+		for range messages {
+		}
+	}()
+	if !p.flush(time.Second) {
+		t.Errorf("the messages should be sent")
+	}
+	close(messages)
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The query parameter that chooses the timezone of the _local timestamp fields of a response, e.g. ?timezone=Europe/Paris.
+const TIMEZONE_PARAM = "timezone"
+
+// The suffixes of the fields that the API adds next to each timestamp field of its output. The _utc field is always
+// added, the _local field only when a timezone is chosen by the request or by the APITimezone of the config.
+const TIMESTAMP_UTC_SUFFIX = "_utc"
+const TIMESTAMP_LOCAL_SUFFIX = "_local"
+
+// The fields of the API output that hold a time but whose name does not end in _time.
+var timestampFields = map[string]bool{
+	"timestamp":       true,
+	"override_until":  true,
+	"last_measured":   true,
+	"lastDBHeartbeat": true,
+}
+
+// The fields whose name ends in _time but that hold a number of seconds rather than a time.
+var durationFields = map[string]bool{
+	"missed_time": true,
+}
+
+// The layout of the local time strings that older versions of anax saved in the surface errors.
+const legacyTimestampLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+func isTimestampField(name string) bool {
+	return timestampFields[name] || (strings.HasSuffix(name, "_time") && !durationFields[name])
+}
+
+// A response writer that carries the timezone of the _local timestamp fields of the response.
+type timezoneWriter struct {
+	http.ResponseWriter
+	loc *time.Location
+}
+
+func (t *timezoneWriter) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Resolve the timezone of the _local timestamp fields of each request, from its timezone query parameter or else
+// from the APITimezone of the config. A request with an unknown timezone is rejected.
+func (a *API) timezone(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get(TIMEZONE_PARAM)
+		if name == "" {
+			name = a.Config.LiveEdge().APITimezone
+		}
+		if name == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			writeInputErr(w, http.StatusBadRequest, NewAPIUserInputError(fmt.Sprintf("%v is not a known timezone", name), TIMEZONE_PARAM))
+			return
+		}
+		h.ServeHTTP(&timezoneWriter{ResponseWriter: w, loc: loc}, r)
+	})
+}
+
+// The timezone of the _local timestamp fields of a response, nil when there are none.
+func responseLocation(w http.ResponseWriter) *time.Location {
+	for {
+		switch rw := w.(type) {
+		case *timezoneWriter:
+			return rw.loc
+		case *limitWriter:
+			w = rw.ResponseWriter
+		case *operationWriter:
+			w = rw.ResponseWriter
+		default:
+			return nil
+		}
+	}
+}
+
+// Add the RFC3339 UTC form of each timestamp field of a serialized response in a field with the _utc suffix, and its
+// form in the given timezone in a field with the _local suffix. The timestamp fields that hold seconds since 1970 keep
+// their value for the existing clients, the ones that hold a time string are rewritten in RFC3339 UTC.
+func addTimestampFields(serial []byte, loc *time.Location) ([]byte, error) {
+	var payload interface{}
+	decoder := json.NewDecoder(bytes.NewReader(serial))
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	if !convertTimestamps(payload, loc) {
+		return serial, nil
+	}
+	return json.Marshal(payload)
+}
+
+// Walk a decoded response and add the timestamp fields, return true when any were added.
+func convertTimestamps(payload interface{}, loc *time.Location) bool {
+	changed := false
+
+	switch v := payload.(type) {
+	case map[string]interface{}:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+
+		for _, name := range names {
+			if !isTimestampField(name) {
+				if convertTimestamps(v[name], loc) {
+					changed = true
+				}
+				continue
+			} else if _, ok := v[name+TIMESTAMP_UTC_SUFFIX]; ok {
+				continue
+			}
+
+			t, ok := parseTimestamp(v[name])
+			if !ok {
+				continue
+			}
+
+			if _, isString := v[name].(string); isString {
+				v[name] = t.UTC().Format(time.RFC3339)
+			} else {
+				v[name+TIMESTAMP_UTC_SUFFIX] = t.UTC().Format(time.RFC3339)
+			}
+			if loc != nil {
+				v[name+TIMESTAMP_LOCAL_SUFFIX] = t.In(loc).Format(time.RFC3339)
+			}
+			changed = true
+		}
+
+	case []interface{}:
+		for _, e := range v {
+			if convertTimestamps(e, loc) {
+				changed = true
+			}
+		}
+	}
+
+	return changed
+}
+
+// Parse the value of a timestamp field, either seconds since 1970 or a time string. Zero means the time is not set.
+func parseTimestamp(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		if secs, err := v.Int64(); err == nil && secs > 0 {
+			return time.Unix(secs, 0), true
+		}
+	case string:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, true
+		} else if t, err := time.Parse(legacyTimestampLayout, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,162 @@
+// +build unit
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/open-horizon/anax/apicommon"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/persistence"
+)
+
+// 2020-09-13T12:26:40Z
+const testTimestamp = 1600000000
+
+// Serialize a payload the way the API handlers do, in the given timezone, and decode it again.
+func serializeWithTimezone(t *testing.T, payload interface{}, tz string) map[string]interface{} {
+	var w http.ResponseWriter = httptest.NewRecorder()
+	if tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			t.Fatalf("unable to load timezone %v: %v", tz, err)
+		}
+		w = &timezoneWriter{ResponseWriter: w, loc: loc}
+	}
+
+	serial, errWritten := serializeResponse(w, payload)
+	if errWritten {
+		t.Fatalf("unable to serialize %v", payload)
+	}
+
+	var out map[string]interface{}
+	if err := json.Unmarshal(serial, &out); err != nil {
+		t.Fatalf("unable to decode %v: %v", string(serial), err)
+	}
+	return out
+}
+
+func checkTimestampFields(t *testing.T, out map[string]interface{}, name string, utc string, local string) {
+	if out[name+TIMESTAMP_UTC_SUFFIX] != utc {
+		t.Errorf("%v%v should be %v, is %v", name, TIMESTAMP_UTC_SUFFIX, utc, out[name+TIMESTAMP_UTC_SUFFIX])
+	}
+	if local == "" {
+		if _, ok := out[name+TIMESTAMP_LOCAL_SUFFIX]; ok {
+			t.Errorf("%v%v should not be set: %v", name, TIMESTAMP_LOCAL_SUFFIX, out)
+		}
+	} else if out[name+TIMESTAMP_LOCAL_SUFFIX] != local {
+		t.Errorf("%v%v should be %v, is %v", name, TIMESTAMP_LOCAL_SUFFIX, local, out[name+TIMESTAMP_LOCAL_SUFFIX])
+	}
+}
+
+func Test_Timestamps_agreement(t *testing.T) {
+	ag := persistence.EstablishedAgreement{Name: "a", AgreementCreationTime: testTimestamp, AgreementTimeout: 600}
+	out := serializeWithTimezone(t, map[string]interface{}{"agreements": []persistence.EstablishedAgreement{ag}}, "")
+
+	agreement := out["agreements"].([]interface{})[0].(map[string]interface{})
+	checkTimestampFields(t, agreement, "agreement_creation_time", "2020-09-13T12:26:40Z", "")
+	if agreement["agreement_creation_time"] != float64(testTimestamp) {
+		t.Errorf("the deprecated agreement_creation_time should be kept, is %v", agreement["agreement_creation_time"])
+	}
+
+	// the times that are not set and the durations have no _utc field
+	for _, name := range []string{"agreement_terminated_time", "agreement_timeout"} {
+		if _, ok := agreement[name+TIMESTAMP_UTC_SUFFIX]; ok {
+			t.Errorf("%v should not have a %v field", name, TIMESTAMP_UTC_SUFFIX)
+		}
+	}
+}
+
+func Test_Timestamps_service_instance(t *testing.T) {
+	mi := persistence.MicroserviceInstance{SpecRef: "http://mydomain.com/ms", InstanceCreationTime: testTimestamp, ExecutionStartTime: testTimestamp + 60}
+	out := serializeWithTimezone(t, NewMicroserviceInstanceOutput(mi, nil), "America/New_York")
+
+	checkTimestampFields(t, out, "instance_creation_time", "2020-09-13T12:26:40Z", "2020-09-13T08:26:40-04:00")
+	checkTimestampFields(t, out, "execution_start_time", "2020-09-13T12:27:40Z", "2020-09-13T08:27:40-04:00")
+}
+
+func Test_Timestamps_eventlog(t *testing.T) {
+	el := persistence.EventLog{EventLogBase: persistence.EventLogBase{Id: "1", Timestamp: testTimestamp}}
+	out := serializeWithTimezone(t, el, "Asia/Kolkata")
+
+	checkTimestampFields(t, out, "timestamp", "2020-09-13T12:26:40Z", "2020-09-13T17:56:40+05:30")
+}
+
+func Test_Timestamps_surface_error(t *testing.T) {
+	el := persistence.EventLog{EventLogBase: persistence.EventLogBase{Id: "1", Timestamp: testTimestamp, MessageMeta: &persistence.MessageMeta{}}}
+	se := persistence.NewSurfaceError(el)
+	out := serializeWithTimezone(t, se, "Europe/Paris")
+
+	if out["timestamp"] != "2020-09-13T12:26:40Z" {
+		t.Errorf("timestamp should be RFC3339 UTC, is %v", out["timestamp"])
+	}
+	if out["timestamp"+TIMESTAMP_LOCAL_SUFFIX] != "2020-09-13T14:26:40+02:00" {
+		t.Errorf("timestamp%v should be in the timezone of the request, is %v", TIMESTAMP_LOCAL_SUFFIX, out["timestamp"+TIMESTAMP_LOCAL_SUFFIX])
+	}
+
+	// the surface errors saved by older versions have a local time string
+	se.Timestamp = "2020-09-13 14:26:40 +0200 CEST"
+	out = serializeWithTimezone(t, se, "")
+	if out["timestamp"] != "2020-09-13T12:26:40Z" {
+		t.Errorf("timestamp should be RFC3339 UTC, is %v", out["timestamp"])
+	}
+}
+
+func Test_Timestamps_node_and_status(t *testing.T) {
+	device := persistence.ExchangeDevice{Id: "node1", Config: persistence.Configstate{LastUpdateTime: testTimestamp}}
+	out := serializeWithTimezone(t, device, "UTC")
+	checkTimestampFields(t, out["configstate"].(map[string]interface{}), "last_update_time", "2020-09-13T12:26:40Z", "2020-09-13T12:26:40Z")
+
+	info := apicommon.Info{
+		LiveHealth:  &apicommon.HealthTimestamps{LastDBHeartbeatTime: testTimestamp},
+		AgentUpdate: &persistence.AgentUpdateStatus{LastCheckTime: testTimestamp},
+	}
+	out = serializeWithTimezone(t, info, "")
+	checkTimestampFields(t, out["liveHealth"].(map[string]interface{}), "lastDBHeartbeat", "2020-09-13T12:26:40Z", "")
+	checkTimestampFields(t, out["agent_update"].(map[string]interface{}), "last_check_time", "2020-09-13T12:26:40Z", "")
+
+	schedule := persistence.MaintenanceSchedule{OverrideUntil: testTimestamp}
+	out = serializeWithTimezone(t, schedule, "")
+	checkTimestampFields(t, out, "override_until", "2020-09-13T12:26:40Z", "")
+}
+
+func Test_Timestamps_timezone_param(t *testing.T) {
+	cfg := &config.HorizonConfig{Edge: config.Config{APITimezone: "Europe/Paris"}}
+	a := &API{}
+	a.Config = cfg
+
+	var loc *time.Location
+	handler := a.timezone(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loc = responseLocation(w)
+	}))
+
+	// the config default
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/agreement", nil))
+	if loc == nil || loc.String() != "Europe/Paris" {
+		t.Errorf("the timezone should be the config default, is %v", loc)
+	}
+
+	// the query parameter has precedence
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/agreement?timezone=Asia/Tokyo", nil))
+	if loc == nil || loc.String() != "Asia/Tokyo" {
+		t.Errorf("the timezone should be the one of the query parameter, is %v", loc)
+	}
+
+	// no timezone at all
+	cfg.Edge.APITimezone = ""
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/agreement", nil))
+	if loc != nil {
+		t.Errorf("there should be no timezone, is %v", loc)
+	}
+
+	// an unknown timezone
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/agreement?timezone=Nowhere/Atlantis", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("an unknown timezone should be rejected, the status is %v", rec.Code)
+	}
+}
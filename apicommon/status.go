@@ -6,19 +6,36 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/download"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/version"
 )
 
 type Configuration struct {
-	ExchangeAPI     string `json:"exchange_api"`
-	ExchangeVersion string `json:"exchange_version,omitempty"`
-	MinExchVersion  string `json:"required_minimum_exchange_version"`
-	PrefExchVersion string `json:"preferred_exchange_version"`
-	MMSAPI          string `json:"mms_api"`
-	Arch            string `json:"architecture"`
-	HorizonVersion  string `json:"horizon_version"`
+	ExchangeAPI             string          `json:"exchange_api"`
+	ExchangeVersion         string          `json:"exchange_version,omitempty"`
+	MinExchVersion          string          `json:"required_minimum_exchange_version"`
+	PrefExchVersion         string          `json:"preferred_exchange_version"`
+	MMSAPI                  string          `json:"mms_api"`
+	Arch                    string          `json:"architecture"`
+	HorizonVersion          string          `json:"horizon_version"`
+	ContainerRuntime        string          `json:"container_runtime,omitempty"`
+	ContainerRuntimeVersion string          `json:"container_runtime_version,omitempty"`
+	Features                map[string]bool `json:"features,omitempty"`
+	APIListeners            []APIListener   `json:"api_listeners,omitempty"`
+
+	HostAddress     *cutil.HostAddress     `json:"host_address,omitempty"`
+	AddressFamilies *cutil.AddressFamilies `json:"address_families,omitempty"` // The address families the node has usable addresses for.
+}
+
+// A listener of the agent API.
+type APIListener struct {
+	Address            string `json:"address"`
+	TLS                bool   `json:"tls"`
+	ClientCertRequired bool   `json:"client_cert_required"`
 }
 
 // These fields are filled in by the API specific code, not the common code.
@@ -27,9 +44,16 @@ type HealthTimestamps struct {
 }
 
 type Info struct {
-	Configuration *Configuration    `json:"configuration"`
-	Connectivity  map[string]bool   `json:"connectivity,omitempty"`
-	LiveHealth    *HealthTimestamps `json:"liveHealth"`
+	Configuration *Configuration                   `json:"configuration"`
+	Connectivity  map[string]bool                  `json:"connectivity,omitempty"`
+	LiveHealth    *HealthTimestamps                `json:"liveHealth"`
+	AgentUpdate   *persistence.AgentUpdateStatus   `json:"agent_update,omitempty"`
+	Canaries      []persistence.WorkloadCanary     `json:"workload_canaries,omitempty"`
+	OfflineBundle *persistence.OfflineBundleStatus `json:"offline_bundle,omitempty"`
+	Deferred      []persistence.DeferredAction     `json:"deferred_actions,omitempty"`
+	Downloads     *download.Status                 `json:"downloads,omitempty"`
+	Disk          []cutil.DiskUsage                `json:"disk,omitempty"`
+	ClockSkew     *exchange.ClockSkew              `json:"clock_skew,omitempty"`
 }
 
 func NewInfo(httpClientFactory *config.HTTPClientFactory, exchangeUrl string, mmsUrl string, id string, token string) *Info {
@@ -353,6 +353,8 @@ const CANCEL_MS_DOWNGRADE_REQUIRED = 118
 const CANCEL_SERVICE_SUSPENDED = 119
 const CANCEL_NODE_USERINPUT_CHANGED = 120
 const CANCEL_NODE_PATTERN_CHANGED = 121
+const CANCEL_HEALTH_CHECK_FAILURE = 122
+const CANCEL_CANARY_ROLLBACK = 123
 
 // These constants represent consumer cancellation reason codes
 // const AB_CANCEL_NOT_FINALIZED_TIMEOUT = 200  // xc8
@@ -391,6 +393,8 @@ func DecodeReasonCode(code uint64) string {
 		CANCEL_SERVICE_SUSPENDED:        "service suspended",
 		CANCEL_NODE_USERINPUT_CHANGED:   "node user input changed",
 		CANCEL_NODE_PATTERN_CHANGED:     "node pattern changed",
+		CANCEL_HEALTH_CHECK_FAILURE:     "service health check failed",
+		CANCEL_CANARY_ROLLBACK:          "canary version rolled back",
 		// AB_CANCEL_NOT_FINALIZED_TIMEOUT: "agreement bot never detected agreement on the blockchain",
 		AB_CANCEL_NO_REPLY:         "agreement bot never received reply to proposal",
 		AB_CANCEL_NEGATIVE_REPLY:   "agreement bot received negative reply",
@@ -147,6 +147,10 @@ func (w *ChangesWorker) NewEvent(incoming events.Message) {
 		msg, _ := incoming.(*events.EdgeRegisteredExchangeMessage)
 		w.Commands <- NewDeviceRegisteredCommand(msg)
 
+	case *events.NodeTokenMessage:
+		msg, _ := incoming.(*events.NodeTokenMessage)
+		w.EC = worker.NewExchangeContext(fmt.Sprintf("%v/%v", msg.Org(), msg.DeviceId()), msg.Token(), w.Config.Edge.ExchangeURL, w.Config.GetCSSURL(), newLimitedRetryHTTPFactory(w.Config.Collaborators.HTTPClientFactory))
+
 	case *events.AgreementReachedMessage:
 		w.Commands <- NewAgreementCommand()
 
@@ -166,6 +170,10 @@ func (w *ChangesWorker) NewEvent(incoming events.Message) {
 			w.Commands <- NewUpdateIntervalCommand(UPDATE_TYPE_ALERT)
 		}
 
+	case *events.NodeSyncMessage:
+		msg, _ := incoming.(*events.NodeSyncMessage)
+		w.Commands <- NewNodeSyncCommand(msg)
+
 	case *events.ExchangeChangesShutdownMessage:
 		msg, _ := incoming.(*events.ExchangeChangesShutdownMessage)
 		switch msg.Event().Id {
@@ -211,6 +219,10 @@ func (w *ChangesWorker) CommandHandler(command worker.Command) bool {
 		cmd, _ := command.(*DeviceRegisteredCommand)
 		w.handleDeviceRegistration(cmd)
 
+	case *NodeSyncCommand:
+		cmd, _ := command.(*NodeSyncCommand)
+		cmd.Msg.Results <- w.syncNow()
+
 	default:
 		return false
 	}
@@ -235,8 +247,23 @@ func (w *ChangesWorker) NoWorkHandler() {
 	return
 }
 
-// Go get the latest changes and process them, notifying other workers that they might have work to do.
-func (w *ChangesWorker) findAndProcessChanges() {
+// Heartbeat and check for changes now, for a node sync requested through the API.
+func (w *ChangesWorker) syncNow() events.SyncResult {
+	result := events.SyncResult{Name: events.SYNC_HEARTBEAT}
+	if w.GetExchangeToken() == "" {
+		result.Error = "the node is not registered"
+	} else if found, err := w.findAndProcessChanges(); err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Changed = found != 0
+		result.Summary = fmt.Sprintf("heartbeat sent, %v changes found", found)
+	}
+	return result
+}
+
+// Go get the latest changes and process them, notifying other workers that they might have work to do. Returns the
+// number of changes that were found.
+func (w *ChangesWorker) findAndProcessChanges() (int, error) {
 
 	w.noworkDispatch = time.Now().Unix()
 
@@ -245,10 +272,10 @@ func (w *ChangesWorker) findAndProcessChanges() {
 	if w.changeID == 0 {
 		if err := w.getChangeId(); err != nil {
 			glog.Errorf(chglog(fmt.Sprintf("Failed to get the max change id. %v", err)))
-			return
+			return 0, fmt.Errorf("Failed to get the max change id. %v", err)
 		} else if w.changeID == 0 {
 			glog.Warningf(chglog(fmt.Sprintf("No starting change ID")))
-			return
+			return 0, fmt.Errorf("No starting change ID")
 		}
 	}
 
@@ -259,7 +286,10 @@ func (w *ChangesWorker) findAndProcessChanges() {
 
 	// Handle heartbeat state changes and errors. Returns true if there was an error to be handled.
 	if w.handleHeartbeatStateAndError(changes, err) {
-		return
+		if err == nil {
+			err = fmt.Errorf("the exchange returned no response to the changes query")
+		}
+		return 0, err
 	}
 
 	// Loop through each change to identify resources that we are interested in, and then send out event messages
@@ -298,6 +328,7 @@ func (w *ChangesWorker) findAndProcessChanges() {
 
 	glog.V(3).Infof(chglog(fmt.Sprintf("done looking for changes")))
 
+	return len(changes.Changes), nil
 }
 
 // Create a map of exchange resources that a device cares about. The resources in the map are set to boolean
@@ -43,3 +43,15 @@ func (c UpdateIntervalCommand) ShortString() string {
 func NewUpdateIntervalCommand(updateType string) *UpdateIntervalCommand {
 	return &UpdateIntervalCommand{UpdateType: updateType}
 }
+
+type NodeSyncCommand struct {
+	Msg *events.NodeSyncMessage
+}
+
+func (c NodeSyncCommand) ShortString() string {
+	return fmt.Sprintf("NodeSyncCommand Msg: %v", c.Msg)
+}
+
+func NewNodeSyncCommand(msg *events.NodeSyncMessage) *NodeSyncCommand {
+	return &NodeSyncCommand{Msg: msg}
+}
@@ -811,6 +811,8 @@ func getContainerImages(containerConfig *events.ContainerConfig, currentUIs *com
 		Edge: config.Config{
 			TrustSystemCACerts:     true,
 			TrustDockerAuthFromOrg: true,
+			ImagePullRetries:       config.ImagePullRetries_DEFAULT,
+			ImagePullBackoffS:      config.ImagePullBackoffS_DEFAULT,
 		},
 		AgreementBot:  config.AGConfig{},
 		Collaborators: config.Collaborators{},
@@ -850,11 +852,11 @@ func getContainerImages(containerConfig *events.ContainerConfig, currentUIs *com
 	return nil
 }
 
-func CreateNetwork(client *docker.Client, name string) (*docker.Network, error) {
+func CreateNetwork(client container.ContainerRuntime, name string) (*docker.Network, error) {
 	// get message printer
 	msgPrinter := i18n.GetMessagePrinter()
 
-	bridge, err := container.MakeBridge(client, name, true, false)
+	bridge, err := container.MakeBridge(client, name, true, false, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -863,7 +865,7 @@ func CreateNetwork(client *docker.Client, name string) (*docker.Network, error)
 	return bridge, nil
 }
 
-func RemoveNetwork(client *docker.Client, name string) error {
+func RemoveNetwork(client container.ContainerRuntime, name string) error {
 
 	// Remove named network
 	networks, err := client.ListNetworks()
@@ -676,6 +676,8 @@ func GetOpYamlArchiveFromClusterDepl(deploymentConfig string) []byte {
 
 	if kd, err := persistence.GetKubeDeployment(deploymentConfig); err != nil {
 		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("error getting kube deployment configuration: %v", err))
+	} else if kd.IsWorkload() {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("the cluster deployment configuration is a workload, it has no operator yaml archive"))
 	} else {
 		archiveData, err := base64.StdEncoding.DecodeString(kd.OperatorYamlArchive)
 		if err != nil {
@@ -9,6 +9,7 @@ import (
 	"github.com/open-horizon/anax/cli/dev"
 	"github.com/open-horizon/anax/cli/plugin_registry"
 	"github.com/open-horizon/anax/i18n"
+	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/rsapss-tool/sign"
 	"io/ioutil"
 	"os"
@@ -37,24 +38,27 @@ func (p *KubeDeploymentConfigPlugin) Sign(dep map[string]interface{}, keyFilePat
 		return owned, "", "", err
 	}
 
-	// Grab the kube operator file from the deployment config. The file might be relative to the
-	// service definition file.
-	operatorFilePath := dep["operatorYamlArchive"].(string)
-	if operatorFilePath = filepath.Clean(operatorFilePath); operatorFilePath == "." {
-		return true, "", "", errors.New(msgPrinter.Sprintf("cleaned %v resulted in an empty string.", dep["operatorYamlArchive"].(string)))
-	}
-
-	if currentDir, ok := (ctx.Get("currentDir")).(string); !ok {
-		return true, "", "", errors.New(msgPrinter.Sprintf("plugin context must include 'currentDir' as the current directory of the service definition file"))
-	} else if !filepath.IsAbs(operatorFilePath) {
-		operatorFilePath = filepath.Join(currentDir, operatorFilePath)
-	}
-
-	// Get the base 64 encoding of the kube operator, and put it into the deployment config.
-	if b64, err := ConvertFileToB64String(operatorFilePath); err != nil {
-		return true, "", "", errors.New(msgPrinter.Sprintf("unable to read kube operator %v, error %v", dep["operatorYamlArchive"], err))
-	} else {
-		dep["operatorYamlArchive"] = b64
+	// A workload is signed as it is, an operator is replaced by the base 64 encoding of its yaml archive.
+	if _, isWorkload := dep["workload"]; !isWorkload {
+		// Grab the kube operator file from the deployment config. The file might be relative to the
+		// service definition file.
+		operatorFilePath := dep["operatorYamlArchive"].(string)
+		if operatorFilePath = filepath.Clean(operatorFilePath); operatorFilePath == "." {
+			return true, "", "", errors.New(msgPrinter.Sprintf("cleaned %v resulted in an empty string.", dep["operatorYamlArchive"].(string)))
+		}
+
+		if currentDir, ok := (ctx.Get("currentDir")).(string); !ok {
+			return true, "", "", errors.New(msgPrinter.Sprintf("plugin context must include 'currentDir' as the current directory of the service definition file"))
+		} else if !filepath.IsAbs(operatorFilePath) {
+			operatorFilePath = filepath.Join(currentDir, operatorFilePath)
+		}
+
+		// Get the base 64 encoding of the kube operator, and put it into the deployment config.
+		if b64, err := ConvertFileToB64String(operatorFilePath); err != nil {
+			return true, "", "", errors.New(msgPrinter.Sprintf("unable to read kube operator %v, error %v", dep["operatorYamlArchive"], err))
+		} else {
+			dep["operatorYamlArchive"] = b64
+		}
 	}
 
 	// Stringify and sign the deployment string.
@@ -99,6 +103,8 @@ func (p *KubeDeploymentConfigPlugin) Validate(dep interface{}, cdep interface{})
 
 	if dc, ok := cdep.(map[string]interface{}); !ok {
 		return false, nil
+	} else if _, ok := dc["workload"]; ok {
+		return true, validateWorkload(dc)
 	} else if c, ok := dc["operatorYamlArchive"]; !ok {
 		return false, nil
 	} else if ca, ok := c.(string); !ok {
@@ -168,6 +174,19 @@ func (p *KubeDeploymentConfigPlugin) StopTest(homeDirectory string) bool {
 	return true
 }
 
+// Check the workload form of the cluster deployment config, which anax renders into Kubernetes objects itself.
+func validateWorkload(dc map[string]interface{}) error {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	if b, err := json.Marshal(dc); err != nil {
+		return errors.New(msgPrinter.Sprintf("failed to marshal the workload %v, error %v", dc["workload"], err))
+	} else if _, err := persistence.GetKubeDeployment(string(b)); err != nil {
+		return errors.New(msgPrinter.Sprintf("invalid cluster deployment: %v", err))
+	}
+	return nil
+}
+
 // Convert a file into a base 64 encoded string. The input filepath is assumed to be absolute.
 func ConvertFileToB64String(filePath string) (string, error) {
 
@@ -94,7 +94,7 @@ func Start(cw *container.ContainerWorker, org string, configFiles []string, conf
 	return nil
 }
 
-func Stop(dc *docker.Client) error {
+func Stop(dc container.ContainerRuntime) error {
 	// get message printer
 	msgPrinter := i18n.GetMessagePrinter()
 
@@ -119,7 +119,7 @@ func Stop(dc *docker.Client) error {
 // Make sure the file sync service docker images are available locally. Either they are already present in the
 // local docker repo or we need to pull them in. This function checks for an exact match of image and tag name.
 // It does not try to re-pull if the image is already local.
-func getImage(imageName string, tagName string, dc *docker.Client) error {
+func getImage(imageName string, tagName string, dc container.ContainerRuntime) error {
 	// get message printer
 	msgPrinter := i18n.GetMessagePrinter()
 
@@ -164,7 +164,7 @@ func getImage(imageName string, tagName string, dc *docker.Client) error {
 }
 
 // remove image. Ignore error if image does not exist
-func removeImage(imageName string, tagName string, dc *docker.Client) error {
+func removeImage(imageName string, tagName string, dc container.ContainerRuntime) error {
 	// get message printer
 	msgPrinter := i18n.GetMessagePrinter()
 
@@ -182,7 +182,7 @@ func removeImage(imageName string, tagName string, dc *docker.Client) error {
 }
 
 // Start the CSS container.
-func startCSS(dc *docker.Client, network *docker.Network) error {
+func startCSS(dc container.ContainerRuntime, network *docker.Network) error {
 	// get message printer
 	msgPrinter := i18n.GetMessagePrinter()
 
@@ -380,7 +380,7 @@ func startESS(cw *container.ContainerWorker, network *docker.Network, org string
 }
 
 // Stop the container.
-func stopContainer(dc *docker.Client, name string) error {
+func stopContainer(dc container.ContainerRuntime, name string) error {
 	// get message printer
 	msgPrinter := i18n.GetMessagePrinter()
 
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/open-horizon/anax/containermessage"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/i18n"
 	"github.com/open-horizon/anax/policy"
@@ -208,7 +209,7 @@ func (sf *ServiceFile) HasDependencies() bool {
 // Return true if the service definition is a dependency in the input list of service references.
 func (sf *ServiceFile) IsDependent(deps []exchange.ServiceDependency) bool {
 	for _, dep := range deps {
-		if sf.URL == dep.URL && sf.Org == dep.Org {
+		if cutil.SameSpecURL(sf.URL, dep.URL) && sf.Org == dep.Org {
 			return true
 		}
 	}
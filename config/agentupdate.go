@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The defaults of the agent update settings.
+const AgentUpdateCheckIntervalS_DEFAULT = 3600
+const AgentUpdateDrainTimeoutS_DEFAULT = 600
+const AgentUpdateCommandTimeoutS_DEFAULT = 1800
+
+// The check for new versions of the agent, and the hand-off to the command that updates it. Anax only finds out that
+// it is outdated and decides when the update can run, the command replaces the agent.
+type AgentUpdateConfig struct {
+	ManifestURL     string `doc:"The URL of the agent version manifest, a JSON document with the latest version of the agent, e.g. {\"version\": \"2.29.0\", \"url\": \"https://example.com/agent/2.29.0\"}. Empty means the agent does not check for updates."`
+	CheckIntervalS  uint64 `unit:"s" doc:"The number of seconds between checks of the version manifest. The default is 3600 seconds."`
+	Command         string `reload:"live" doc:"The command that updates the agent, run with sh -c. The new version and the URL from the manifest are in the HZN_AGENT_UPDATE_VERSION and HZN_AGENT_UPDATE_URL environment variables. Empty means updates are only reported."`
+	Window          string `reload:"live" doc:"The maintenance window in which the update command can run, in the local time of the node, e.g. \"02:00-04:00\" or \"Sat,Sun 22:00-02:00\". Empty means any time."`
+	DrainTimeoutS   uint64 `unit:"s" doc:"The number of seconds to wait for the agreements being made to finish before the update command runs anyway. New agreements are not accepted meanwhile. The default is 600 seconds."`
+	CommandTimeoutS uint64 `unit:"s" doc:"The number of seconds the update command can run. The default is 1800 seconds."`
+}
+
+func (a *AgentUpdateConfig) String() string {
+	return fmt.Sprintf("ManifestURL: %v, CheckIntervalS: %v, Command: %v, Window: %v, DrainTimeoutS: %v, CommandTimeoutS: %v",
+		a.ManifestURL, a.CheckIntervalS, a.Command, a.Window, a.DrainTimeoutS, a.CommandTimeoutS)
+}
+
+func (a *AgentUpdateConfig) GetCheckIntervalS() int {
+	if a.CheckIntervalS == 0 {
+		return AgentUpdateCheckIntervalS_DEFAULT
+	}
+	return int(a.CheckIntervalS)
+}
+
+func (a *AgentUpdateConfig) GetDrainTimeout() time.Duration {
+	if a.DrainTimeoutS == 0 {
+		return AgentUpdateDrainTimeoutS_DEFAULT * time.Second
+	}
+	return time.Duration(a.DrainTimeoutS) * time.Second
+}
+
+func (a *AgentUpdateConfig) GetCommandTimeout() time.Duration {
+	if a.CommandTimeoutS == 0 {
+		return AgentUpdateCommandTimeoutS_DEFAULT * time.Second
+	}
+	return time.Duration(a.CommandTimeoutS) * time.Second
+}
+
+// Check the agent update settings, they are only checked when the manifest URL is set.
+func (e *ConfigErrors) checkAgentUpdate(path string, a *AgentUpdateConfig) {
+	if a.ManifestURL == "" {
+		return
+	}
+	e.checkURL(path+".ManifestURL", a.ManifestURL)
+	if _, err := ParseMaintenanceWindow(a.Window); err != nil {
+		e.add(path+".Window", "%v", err)
+	}
+}
+
+// A daily period of time, on some days of the week or on all of them. A window that ends before it starts ends on
+// the next day.
+type MaintenanceWindow struct {
+	Days  map[time.Weekday]bool // empty means every day
+	Start int                   // minutes after midnight
+	End   int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Parse a window like "02:00-04:00" or "Sat,Sun 22:00-02:00". An empty window is nil, it contains all the time.
+func ParseMaintenanceWindow(window string) (*MaintenanceWindow, error) {
+	window = strings.TrimSpace(window)
+	if window == "" {
+		return nil, nil
+	}
+
+	w := &MaintenanceWindow{Days: make(map[time.Weekday]bool)}
+	times := window
+	if fields := strings.Fields(window); len(fields) == 2 {
+		for _, day := range strings.Split(fields[0], ",") {
+			wd, ok := weekdays[strings.ToLower(strings.TrimSpace(day))]
+			if !ok {
+				return nil, fmt.Errorf("%v is not a day of the week in maintenance window %v, use Mon, Tue, Wed, Thu, Fri, Sat or Sun", day, window)
+			}
+			w.Days[wd] = true
+		}
+		times = fields[1]
+	} else if len(fields) != 1 {
+		return nil, fmt.Errorf("maintenance window %v must be a time range, optionally after the days of the week, e.g. Sat,Sun 22:00-02:00", window)
+	}
+
+	bounds := strings.Split(times, "-")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("maintenance window %v must have a start and an end time, e.g. 02:00-04:00", window)
+	}
+	var err error
+	if w.Start, err = parseTimeOfDay(bounds[0]); err != nil {
+		return nil, fmt.Errorf("maintenance window %v: %v", window, err)
+	} else if w.End, err = parseTimeOfDay(bounds[1]); err != nil {
+		return nil, fmt.Errorf("maintenance window %v: %v", window, err)
+	} else if w.Start == w.End {
+		return nil, fmt.Errorf("maintenance window %v is empty", window)
+	}
+	return w, nil
+}
+
+func parseTimeOfDay(t string) (int, error) {
+	parts := strings.Split(t, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("%v is not a time of day, e.g. 02:30", t)
+	}
+	h, herr := strconv.Atoi(parts[0])
+	m, merr := strconv.Atoi(parts[1])
+	if herr != nil || merr != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, fmt.Errorf("%v is not a time of day, e.g. 02:30", t)
+	}
+	return h*60 + m, nil
+}
+
+// Returns true if the time is in the window, a nil window contains all the time.
+func (w *MaintenanceWindow) Contains(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+
+	onDay := func(d time.Weekday) bool {
+		return len(w.Days) == 0 || w.Days[d]
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	if w.Start < w.End {
+		return onDay(t.Weekday()) && minute >= w.Start && minute < w.End
+	}
+	// the window crosses midnight, the early hours belong to the window of the day before
+	return (onDay(t.Weekday()) && minute >= w.Start) || (onDay((t.Weekday()+6)%7) && minute < w.End)
+}
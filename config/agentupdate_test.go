@@ -0,0 +1,45 @@
+// +build unit
+
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_ParseMaintenanceWindow(t *testing.T) {
+
+	if w, err := ParseMaintenanceWindow(""); err != nil || w != nil {
+		t.Errorf("empty window should be nil, got %v, error %v", w, err)
+	} else if !w.Contains(time.Now()) {
+		t.Errorf("nil window should contain all the time")
+	}
+
+	for _, bad := range []string{"02:00", "02:00-02:00", "25:00-03:00", "Fri 02:00-03:00 extra", "Caturday 02:00-03:00", "2-3"} {
+		if _, err := ParseMaintenanceWindow(bad); err == nil {
+			t.Errorf("window %v should be invalid", bad)
+		}
+	}
+
+	// Saturday 2021-01-02
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2021, 1, day, hour, minute, 0, 0, time.Local)
+	}
+
+	w, err := ParseMaintenanceWindow("02:00-04:00")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !w.Contains(at(2, 2, 0)) || !w.Contains(at(5, 3, 59)) || w.Contains(at(2, 4, 0)) || w.Contains(at(2, 1, 59)) {
+		t.Errorf("unexpected daily window %v", w)
+	}
+
+	// the early hours of Monday belong to the Sunday window
+	w, err = ParseMaintenanceWindow("Sat,sun 22:00-02:00")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !w.Contains(at(2, 23, 0)) || !w.Contains(at(4, 1, 0)) || w.Contains(at(4, 2, 0)) || w.Contains(at(2, 1, 0)) || w.Contains(at(1, 23, 0)) {
+		t.Errorf("unexpected weekend window %v", w)
+	}
+}
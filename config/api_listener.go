@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+)
+
+// The prefix of an API listener address that is a unix socket path.
+const API_LISTENER_UNIX_PREFIX = "unix:"
+
+// Configuration for an additional listener of the agent API. The listeners share the API routes of the APIListen
+// listener, each one can serve TLS and require the clients to authenticate with a certificate to make changes.
+type APIListenerConfig struct {
+	Address      string `doc:"The host and port to listen on, e.g. 10.1.2.3:8510 or [fd00::1]:8510 (an IPv6 address is bracketed), or a unix socket path, e.g. unix:/var/run/horizon/anax.sock. The host [::] listens on all the IPv4 and IPv6 addresses."`
+	TLSCertFile  string `doc:"The path to the server certificate file. The listener serves TLS when it is set, TLSKeyFile must be set too."`
+	TLSKeyFile   string `doc:"The path to the server key file."`
+	ClientCAFile string `doc:"The path to a file of CA certificates. When it is set, the requests that make changes (all but GET, HEAD and OPTIONS) must present a client certificate signed by one of them. Requires TLS."`
+}
+
+func (l *APIListenerConfig) String() string {
+	return fmt.Sprintf("Address: %v, TLSCertFile: %v, TLSKeyFile: %v, ClientCAFile: %v", l.Address, l.TLSCertFile, l.TLSKeyFile, l.ClientCAFile)
+}
+
+// Returns true if the listener is a unix socket. An absolute path is a unix socket too.
+func (l *APIListenerConfig) IsUnix() bool {
+	return strings.HasPrefix(l.Address, API_LISTENER_UNIX_PREFIX) || filepath.IsAbs(l.Address)
+}
+
+// Returns the network and address to listen on, as expected by net.Listen.
+func (l *APIListenerConfig) NetworkAddress() (string, string) {
+	if l.IsUnix() {
+		return "unix", strings.TrimPrefix(l.Address, API_LISTENER_UNIX_PREFIX)
+	}
+	return "tcp", l.Address
+}
+
+func (l *APIListenerConfig) IsTLS() bool {
+	return l.TLSCertFile != ""
+}
+
+func (l *APIListenerConfig) RequiresClientCert() bool {
+	return l.ClientCAFile != ""
+}
+
+// Verify that the listener configuration is usable.
+func (l *APIListenerConfig) Validate() error {
+	if l.Address == "" {
+		return fmt.Errorf("Address is required")
+	} else if network, address := l.NetworkAddress(); network == "unix" && !filepath.IsAbs(address) {
+		return fmt.Errorf("unix socket path %v must be absolute", address)
+	} else if network == "tcp" {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return fmt.Errorf("Address %v is not a host and port, %v", address, err)
+		}
+	}
+
+	if (l.TLSCertFile == "") != (l.TLSKeyFile == "") {
+		return fmt.Errorf("TLSCertFile and TLSKeyFile must be set together")
+	} else if l.RequiresClientCert() && !l.IsTLS() {
+		return fmt.Errorf("ClientCAFile requires TLSCertFile and TLSKeyFile")
+	}
+	return nil
+}
+
+// Returns the listen address with its port replaced by the given port. The host of an IPv6 address stays bracketed,
+// e.g. [::1]:8510. An address that has no host and port, or no address at all, gets the IPv4 loopback host.
+func ListenAddressWithPort(address string, port string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		// an IPv6 literal without a port, bracketed or not
+		if ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")); ip != nil {
+			host = ip.String()
+		} else {
+			host = "127.0.0.1"
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
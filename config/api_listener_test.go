@@ -0,0 +1,82 @@
+// +build unit
+
+package config
+
+import (
+	"testing"
+)
+
+func Test_APIListenerConfig_Validate(t *testing.T) {
+
+	valid := []APIListenerConfig{
+		{Address: "10.1.2.3:8510"},
+		{Address: "[::1]:8510"},
+		{Address: "unix:/var/run/horizon/anax.sock"},
+		{Address: "/var/run/horizon/anax.sock"},
+		{Address: ":8443", TLSCertFile: "/etc/horizon/cert.pem", TLSKeyFile: "/etc/horizon/key.pem"},
+		{Address: ":8443", TLSCertFile: "/etc/horizon/cert.pem", TLSKeyFile: "/etc/horizon/key.pem", ClientCAFile: "/etc/horizon/ca.pem"},
+	}
+	for _, l := range valid {
+		if err := l.Validate(); err != nil {
+			t.Errorf("listener config %v should be valid, error %v", l.String(), err)
+		}
+	}
+
+	invalid := []APIListenerConfig{
+		{},
+		{Address: "10.1.2.3"},
+		{Address: "unix:anax.sock"},
+		{Address: ":8443", TLSCertFile: "/etc/horizon/cert.pem"},
+		{Address: ":8443", ClientCAFile: "/etc/horizon/ca.pem"},
+	}
+	for _, l := range invalid {
+		if err := l.Validate(); err == nil {
+			t.Errorf("listener config %v should be invalid", l.String())
+		}
+	}
+}
+
+func Test_APIListenerConfig_NetworkAddress(t *testing.T) {
+
+	l := APIListenerConfig{Address: "unix:/var/run/horizon/anax.sock"}
+	if network, address := l.NetworkAddress(); network != "unix" || address != "/var/run/horizon/anax.sock" {
+		t.Errorf("wrong network address %v %v", network, address)
+	}
+
+	l = APIListenerConfig{Address: "10.1.2.3:8510"}
+	if network, address := l.NetworkAddress(); network != "tcp" || address != "10.1.2.3:8510" {
+		t.Errorf("wrong network address %v %v", network, address)
+	}
+}
+
+func Test_APIListeners_Duplicate(t *testing.T) {
+	cfg := newDefaultConfig()
+	cfg.setZeroDefaults()
+	cfg.Edge.APIListeners = []APIListenerConfig{{Address: cfg.Edge.APIListen}, {Address: "unix:/tmp/anax.sock"}, {Address: "unix:/tmp/anax.sock"}}
+
+	if problems := cfg.checkValues(); len(problems) != 2 || problems[0].Path != "Edge.APIListeners[0]" || problems[1].Path != "Edge.APIListeners[2]" {
+		t.Errorf("expected the duplicate listeners to be problems, got %v", problems)
+	}
+}
+
+func Test_ListenAddressWithPort(t *testing.T) {
+
+	tests := []struct {
+		address  string
+		expected string
+	}{
+		{"127.0.0.1:8510", "127.0.0.1:9000"},
+		{"0.0.0.0:8510", "0.0.0.0:9000"},
+		{"[::1]:8510", "[::1]:9000"},
+		{"[::]:8510", "[::]:9000"},
+		{"[fd00::1]", "[fd00::1]:9000"},
+		{"fd00::1", "[fd00::1]:9000"},
+		{"localhost", "127.0.0.1:9000"},
+		{"", "127.0.0.1:9000"},
+	}
+	for _, test := range tests {
+		if address := ListenAddressWithPort(test.address, "9000"); address != test.expected {
+			t.Errorf("address %v with port 9000 should be %v, is %v", test.address, test.expected, address)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+)
+
+// The default number of seconds that the canaries of a new workload version run before the version is trusted.
+const CanarySoakTimeS_DEFAULT = 600
+
+// The canary rollout of new workload versions. When the node has agreements for a workload and the agreement bot
+// proposes a new version of it, only a part of the agreements moves to the new version first. The rest follows when
+// the canaries stayed healthy for the soak time. Canaries that fail are cancelled and the version is rejected by the
+// node from then on.
+type CanaryConfig struct {
+	Percent   uint64 `reload:"live" doc:"The percentage of the agreements for a workload that move to a new version of it first, rounded up to at least 1 agreement. 0 means the canary rollout is off, unless Count is set."`
+	Count     uint64 `reload:"live" doc:"The number of agreements for a workload that move to a new version of it first, instead of a percentage. 0 means Percent is used."`
+	SoakTimeS uint64 `reload:"live" unit:"s" doc:"The number of seconds that the canaries must run without failing before the other agreements move to the new version. The default is 600 seconds."`
+}
+
+func (c *CanaryConfig) String() string {
+	return fmt.Sprintf("Percent: %v, Count: %v, SoakTimeS: %v", c.Percent, c.Count, c.SoakTimeS)
+}
+
+func (c *CanaryConfig) Enabled() bool {
+	return c.Percent != 0 || c.Count != 0
+}
+
+func (c *CanaryConfig) GetSoakTimeS() uint64 {
+	if c.SoakTimeS == 0 {
+		return CanarySoakTimeS_DEFAULT
+	}
+	return c.SoakTimeS
+}
+
+// Returns the number of canaries out of the agreements that a new version affects, at least 1.
+func (c *CanaryConfig) Canaries(affected int) int {
+	n := int(c.Count)
+	if n == 0 {
+		n = (affected*int(c.Percent) + 99) / 100
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// Check the canary settings.
+func (e *ConfigErrors) checkCanary(path string, c *CanaryConfig) {
+	if c.Percent > 100 {
+		e.add(path+".Percent", "%v is not a percentage", c.Percent)
+	}
+	if c.Percent != 0 && c.Count != 0 {
+		e.add(path+".Count", "set either Percent or Count, not both")
+	}
+}
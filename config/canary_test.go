@@ -0,0 +1,29 @@
+// +build unit
+
+package config
+
+import (
+	"testing"
+)
+
+func Test_Canaries(t *testing.T) {
+
+	tests := []struct {
+		config   CanaryConfig
+		affected int
+		expected int
+	}{
+		{CanaryConfig{Percent: 10}, 20, 2},
+		{CanaryConfig{Percent: 10}, 21, 3},
+		{CanaryConfig{Percent: 10}, 1, 1},
+		{CanaryConfig{Percent: 100}, 5, 5},
+		{CanaryConfig{Count: 2}, 10, 2},
+		{CanaryConfig{Count: 2}, 1, 2},
+	}
+
+	for _, test := range tests {
+		if n := test.config.Canaries(test.affected); n != test.expected {
+			t.Errorf("%v of %v agreements should be %v canaries, got %v", test.config, test.affected, test.expected, n)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"fmt"
+)
+
+// The default number of seconds that the clock of the node can be off the clock of the exchange before a warning.
+const ClockSkewWarnS_DEFAULT = 60
+
+// The limits of the difference between the clock of the node and the clock of the exchange, which the agent measures
+// on the exchange responses. A clock that is far off breaks the TLS connections and confuses the timestamps of the
+// agreements.
+type ClockSkewConfig struct {
+	WarnS uint64 `reload:"live" unit:"s" doc:"The number of seconds that the clock of the node can be off the exchange before a warning is logged and shown in the node status. The default is 60 seconds."`
+	MaxS  uint64 `reload:"live" unit:"s" doc:"The number of seconds that the clock of the node can be off the exchange before the node refuses to be configured. 0 means it is never refused."`
+}
+
+func (c *ClockSkewConfig) String() string {
+	return fmt.Sprintf("WarnS: %v, MaxS: %v", c.WarnS, c.MaxS)
+}
+
+func (c *ClockSkewConfig) GetWarnS() uint64 {
+	if c.WarnS == 0 {
+		return ClockSkewWarnS_DEFAULT
+	}
+	return c.WarnS
+}
+
+// Check the clock skew settings.
+func (e *ConfigErrors) checkClockSkew(path string, c *ClockSkewConfig) {
+	if c.MaxS != 0 && c.MaxS < c.GetWarnS() {
+		e.add(path+".MaxS", "must be at least WarnS %v, so that the warning comes before the node is refused", c.GetWarnS())
+	}
+}
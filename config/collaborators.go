@@ -1,6 +1,7 @@
 package config
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -46,9 +47,36 @@ func (c *Collaborators) String() string {
 }
 
 type HTTPClientFactory struct {
-	NewHTTPClient func(overrideTimeoutS *uint) *http.Client
-	RetryCount    int // number of retries for tranport error.
-	RetryInterval int // retry interval in second for tranport error. The default is 10 seconds.
+	NewHTTPClient   func(overrideTimeoutS *uint) *http.Client
+	RetryCount      int  // number of retries for tranport error.
+	RetryInterval   int  // retry interval in second for tranport error. The default is 10 seconds.
+	DefaultTimeoutS uint // the timeout of the clients that do not override it, updated when the config is reloaded.
+	ctx             context.Context
+}
+
+// Returns a copy of the factory whose exchange requests, and the waits between their retries, stop when ctx is done,
+// e.g. when the API request that made them is given up on.
+func (h *HTTPClientFactory) WithContext(ctx context.Context) *HTTPClientFactory {
+	liveLock.RLock()
+	defer liveLock.RUnlock()
+	f := *h
+	f.ctx = ctx
+	return &f
+}
+
+// The context of the exchange requests made with the factory, the background context unless it was set.
+func (h *HTTPClientFactory) Context() context.Context {
+	if h.ctx == nil {
+		return context.Background()
+	}
+	return h.ctx
+}
+
+// The timeout of the clients that do not override it, it changes when the config is reloaded.
+func (h *HTTPClientFactory) defaultTimeoutS() uint {
+	liveLock.RLock()
+	defer liveLock.RUnlock()
+	return h.DefaultTimeoutS
 }
 
 // default retry interval is 10 seconds
@@ -152,13 +180,19 @@ func newHTTPClientFactory(hConfig HorizonConfig) (*HTTPClientFactory, error) {
 
 	tlsConf.BuildNameToCertificate()
 
-	clientFunc := func(overrideTimeoutS *uint) *http.Client {
+	factory := &HTTPClientFactory{
+		RetryCount:      0,
+		RetryInterval:   10,
+		DefaultTimeoutS: hConfig.Edge.DefaultHTTPClientTimeoutS,
+	}
+
+	factory.NewHTTPClient = func(overrideTimeoutS *uint) *http.Client {
 		var timeoutS uint
 
 		if overrideTimeoutS != nil {
 			timeoutS = *overrideTimeoutS
 		} else {
-			timeoutS = hConfig.Edge.DefaultHTTPClientTimeoutS
+			timeoutS = factory.defaultTimeoutS()
 		}
 
 		return &http.Client{
@@ -181,11 +215,7 @@ func newHTTPClientFactory(hConfig HorizonConfig) (*HTTPClientFactory, error) {
 		}
 	}
 
-	return &HTTPClientFactory{
-		NewHTTPClient: clientFunc,
-		RetryCount:    0,
-		RetryInterval: 10,
-	}, nil
+	return factory, nil
 }
 
 func newKeyFileNamesFetcher(hConfig HorizonConfig) (*KeyFileNamesFetcher, error) {
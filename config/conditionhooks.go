@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// The critical conditions of the node that run the condition hooks.
+const CONDITION_AGENT_UPDATE_AVAILABLE = "agent_update_available" // a newer version of the agent is available
+
+// The webhooks and the executables that the agent runs when it detects a critical condition of the node, e.g. that a
+// newer version of the agent is available, so that a fleet manager can act on it. They run in the background, and
+// their failures are logged.
+type ConditionHooksConfig struct {
+	URLs     []string `doc:"The http or https URLs that a JSON document with the condition, the node id and org, and the details of the condition is POSTed to when a critical condition of the node is detected, e.g. agent_update_available. Any 2xx status is a success."`
+	Commands []string `doc:"The absolute paths of the executables that are run with the same JSON document on their standard input when a critical condition of the node is detected. An exit status of 0 is a success."`
+	TimeoutS uint64   `reload:"live" unit:"s" doc:"The number of seconds that each attempt of a hook can take before it is stopped. The default is 30 seconds."`
+	Retries  int      `reload:"live" doc:"The number of times that a failed hook is retried, with a wait that doubles on each retry. The default is 2, -1 means it is not retried."`
+}
+
+func (c *ConditionHooksConfig) String() string {
+	return fmt.Sprintf("URLs: %v, Commands: %v, TimeoutS: %v, Retries: %v", c.URLs, c.Commands, c.TimeoutS, c.Retries)
+}
+
+// Returns true if any hook is configured.
+func (c *ConditionHooksConfig) Enabled() bool {
+	return len(c.URLs) != 0 || len(c.Commands) != 0
+}
+
+func (c *ConditionHooksConfig) GetTimeoutS() uint64 {
+	if c.TimeoutS == 0 {
+		return ConfigstateHookTimeoutS_DEFAULT
+	}
+	return c.TimeoutS
+}
+
+func (c *ConditionHooksConfig) GetRetries() int {
+	if c.Retries == 0 {
+		return ConfigstateHookRetries_DEFAULT
+	} else if c.Retries < 0 {
+		return 0
+	}
+	return c.Retries
+}
+
+// Check the condition hook settings.
+func (e *ConfigErrors) checkConditionHooks(path string, c *ConditionHooksConfig) {
+	for i, u := range c.URLs {
+		if u == "" {
+			e.add(fmt.Sprintf("%v.URLs[%v]", path, i), "must not be empty")
+		}
+		e.checkURL(fmt.Sprintf("%v.URLs[%v]", path, i), u)
+	}
+	for i, cmd := range c.Commands {
+		if !filepath.IsAbs(cmd) {
+			e.add(fmt.Sprintf("%v.Commands[%v]", path, i), "%v must be an absolute path", cmd)
+		}
+	}
+	if c.Retries < -1 {
+		e.add(path+".Retries", "%v must be -1 or more", c.Retries)
+	}
+}
@@ -3,10 +3,12 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 const ExchangeURLEnvvarName = "HZN_EXCHANGE_URL"
@@ -17,108 +19,191 @@ const ManagementHubCertPath = "HZN_MGMT_HUB_CERT_PATH"
 const AnaxAPIPort = "HZN_AGENT_PORT"
 
 type HorizonConfig struct {
-	Edge          Config
-	AgreementBot  AGConfig
-	Collaborators Collaborators
-	ArchSynonyms  ArchSynonyms
+	SchemaVersion int               `doc:"The version of the config file schema. A config file written for an older version is migrated when it is read, one for a newer version is rejected."`
+	Edge          Config            `doc:"The configuration of the edge node side of anax."`
+	AgreementBot  AGConfig          `doc:"The configuration of the agreement bot side of anax."`
+	Collaborators Collaborators     `doc:"-"`
+	ArchSynonyms  ArchSynonyms      `doc:"Maps the machine architecture names reported by the host (e.g. x86_64) to the names used by Horizon (e.g. amd64)."`
+	Features      map[string]bool   `doc:"Turns the experimental features of anax on or off by name, e.g. podman_runtime. A feature that is not listed has its default."`
+	file          string            // the config file this config was read from, used to reload it
+	secretRefs    map[string]string // the references that the secret fields were resolved from, by field path
+	sources       map[string]string // where the value of each field came from, by field path
+	secretErrors  ConfigErrors      // the secret references that could not be resolved, reported by Validate
 }
 
 // This is the configuration options for Edge component flavor of Anax
 type Config struct {
-	ServiceStorage                   string // The base storage directory where the service can write or get the data.
-	APIListen                        string
-	DBPath                           string
-	DockerEndpoint                   string
-	DockerCredFilePath               string
-	DefaultCPUSet                    string
-	DefaultServiceRegistrationRAM    int64
-	StaticWebContent                 string
-	PublicKeyPath                    string
-	TrustSystemCACerts               bool   // If equal to true, the HTTP client factory will set up clients that trust CA certs provided by a Linux distribution (see https://golang.org/pkg/crypto/x509/#SystemCertPool and https://golang.org/src/crypto/x509/root_linux.go)
-	CACertsPath                      string // Path to a file containing PEM-encoded x509 certs HTTP clients in Anax will trust (additive to the configuration option "TrustSystemCACerts")
-	ExchangeURL                      string
-	DefaultHTTPClientTimeoutS        uint
-	PolicyPath                       string
-	ExchangeHeartbeat                int       // Seconds between heartbeats
-	ExchangeVersionCheckIntervalM    int64     // Exchange version check interval in minutes. The default is 720. This is now deprecated with the usage of /changes API which returns exchange version on every call.
-	AgreementTimeoutS                uint64    // Number of seconds to wait before declaring agreement not finalized in blockchain
-	AgreementTimeoutScaleFactor      float64   // Time to wait before declaring an agreement did not finalize. Expressed as a scaling factor of the max heartbeat interval for this node
-	DVPrefix                         string    // When passing agreement ids into a workload container, add this prefix to the agreement id
-	RegistrationDelayS               uint64    // The number of seconds to wait after blockchain init before registering with the exchange. This is for testing initialization ONLY.
-	ExchangeMessageTTL               int       // The number of seconds the exchange will keep this message before automatically deleting it
-	ExchangeMessageDynamicPoll       bool      // Will the runtime dynamically increase the message poll interval? Default is true. Set to false to turn off dynamic message poll interval adjustments.
-	ExchangeMessagePollInterval      int       // The number of seconds the node will wait between polls to the exchange. This is the starting value, but at runtime this interval will increase if there is no message activity to reduce load on the exchange. If ExchangeMessageDynamicPoll is false, then the value of this field will never be changed by the runtime.
-	ExchangeMessagePollMaxInterval   int       // As the runtime increases the ExchangeMessagePollInterval, this value is the maximum that value can attain.
-	ExchangeMessagePollIncrement     int       // The number of seconds to increment the ExchangeMessagePollInterval when its time to increase the poll interval.
-	UserPublicKeyPath                string    // The location to store user keys uploaded through the REST API
-	ReportDeviceStatus               bool      // whether to report the device status to the exchange or not.
-	TrustCertUpdatesFromOrg          bool      // whether to trust the certs provided by the organization on the exchange or not.
-	TrustDockerAuthFromOrg           bool      // whether to turst the docker auths provided by the organization on the exchange or not.
-	ServiceUpgradeCheckIntervalS     int64     // service upgrade check interval in seconds. The default is 300 seconds.
-	MultipleAnaxInstances            bool      // multiple anax instances running on the same machine
-	DefaultServiceRetryCount         int       // the default service retry count if retries are not specified by the policy file. The default value is 2.
-	DefaultServiceRetryDuration      uint64    // the default retry duration in seconds. The next retry cycle occurs after the duration. The default value is 600
-	DefaultNodePolicyFile            string    // the default node policy file name.
-	NodeCheckIntervalS               int       // the node check interval. The default is 15 seconds.
-	NodePolicyCheckIntervalS         int       // the node policy check interval. The default is 15 seconds.
-	FileSyncService                  FSSConfig // The config for the embedded ESS sync service.
-	SurfaceErrorTimeoutS             int       // How long surfaced errors will remain active after they're created. Default is no timeout
-	SurfaceErrorCheckIntervalS       int       // Deprecated. Used to be how often the node will check for errors that are no longer active and update the exchange. Default is 15 seconds
-	SurfaceErrorAgreementPersistentS int       // How long an agreement needs to persist before it is considered persistent and the related errors are dismisse. Default is 90 seconds
-	InitialPollingBuffer             int       // the number of seconds to wait before increasing the polling interval while there is no agreement on the node.
-	MaxAgreementPrelaunchTimeM       int64     // The maximum numbers of minutes to wait for workload to start in an agreement
+	ServiceStorage                   string    `doc:"The base storage directory where the service can write or get the data."`
+	APIListen                        string    `doc:"The host and port for the agent API to listen on, an IPv6 host is bracketed, e.g. [::1]:8510, and [::] listens on all the IPv4 and IPv6 addresses. The default is 127.0.0.1:8510, the HZN_AGENT_PORT env var changes the port."`
+	DBPath                           string    `doc:"The directory where the agent database is kept. The edge node side of anax only runs when it is set."`
+	DockerEndpoint                   string    `doc:"The endpoint of the container runtime API, e.g. unix:///var/run/docker.sock. Containers are not run on this node when it is not set."`
+	DockerCredFilePath               string    `doc:"The path to a docker credentials file with the registry auths used to pull service images."`
+	DefaultCPUSet                    string    `doc:"The cpus that service containers run on when their deployment config does not pin them."`
+	DefaultServiceRegistrationRAM    int64     `doc:"The MB of memory given to a service when its attributes do not set it."`
+	StaticWebContent                 string    `doc:"The directory of static web content served by the agent API. Nothing is served when it is not set."`
+	PublicKeyPath                    string    `doc:"The public key file, or the directory of public key files, used to verify the signatures of service deployment configs."`
+	TrustSystemCACerts               bool      `doc:"If equal to true, the HTTP client factory will set up clients that trust CA certs provided by a Linux distribution (see https://golang.org/pkg/crypto/x509/#SystemCertPool and https://golang.org/src/crypto/x509/root_linux.go)"`
+	CACertsPath                      string    `doc:"Path to a file containing PEM-encoded x509 certs HTTP clients in Anax will trust (additive to the configuration option \"TrustSystemCACerts\")"`
+	ExchangeURL                      string    `doc:"The URL of the Horizon exchange. The HZN_EXCHANGE_URL env var overrides it."`
+	DefaultHTTPClientTimeoutS        uint      `reload:"live" unit:"s" doc:"The number of seconds an HTTP request of the agent can take when the request does not set its own timeout."`
+	PolicyPath                       string    `doc:"The directory where the node policy files are kept."`
+	ExchangeHeartbeat                int       `unit:"s" doc:"Seconds between heartbeats"`
+	ExchangeVersionCheckIntervalM    int64     `unit:"m" doc:"Exchange version check interval in minutes. The default is 720. This is now deprecated with the usage of /changes API which returns exchange version on every call."`
+	AgreementTimeoutS                uint64    `unit:"s" doc:"Number of seconds to wait before declaring agreement not finalized in blockchain"`
+	AgreementTimeoutScaleFactor      float64   `doc:"Time to wait before declaring an agreement did not finalize. Expressed as a scaling factor of the max heartbeat interval for this node"`
+	DVPrefix                         string    `doc:"When passing agreement ids into a workload container, add this prefix to the agreement id"`
+	RegistrationDelayS               uint64    `unit:"s" doc:"The number of seconds to wait after blockchain init before registering with the exchange. This is for testing initialization ONLY."`
+	ExchangeMessageTTL               int       `unit:"s" doc:"The number of seconds the exchange will keep this message before automatically deleting it"`
+	ExchangeMessageDynamicPoll       bool      `doc:"Will the runtime dynamically increase the message poll interval? Default is true. Set to false to turn off dynamic message poll interval adjustments."`
+	ExchangeMessagePollInterval      int       `unit:"s" doc:"The number of seconds the node will wait between polls to the exchange. This is the starting value, but at runtime this interval will increase if there is no message activity to reduce load on the exchange. If ExchangeMessageDynamicPoll is false, then the value of this field will never be changed by the runtime."`
+	ExchangeMessagePollMaxInterval   int       `unit:"s" doc:"As the runtime increases the ExchangeMessagePollInterval, this value is the maximum that value can attain."`
+	ExchangeMessagePollIncrement     int       `unit:"s" doc:"The number of seconds to increment the ExchangeMessagePollInterval when its time to increase the poll interval."`
+	UserPublicKeyPath                string    `doc:"The location to store user keys uploaded through the REST API"`
+	ReportDeviceStatus               bool      `doc:"whether to report the device status to the exchange or not."`
+	TrustCertUpdatesFromOrg          bool      `reload:"live" doc:"whether to trust the certs provided by the organization on the exchange or not."`
+	TrustDockerAuthFromOrg           bool      `reload:"live" doc:"whether to turst the docker auths provided by the organization on the exchange or not."`
+	ServiceUpgradeCheckIntervalS     int64     `unit:"s" doc:"service upgrade check interval in seconds. The default is 300 seconds."`
+	MultipleAnaxInstances            bool      `doc:"multiple anax instances running on the same machine"`
+	DefaultServiceRetryCount         int       `reload:"live" doc:"the default service retry count if retries are not specified by the policy file. The default value is 2."`
+	DefaultServiceRetryDuration      uint64    `reload:"live" unit:"s" doc:"the default retry duration in seconds. The next retry cycle occurs after the duration. The default value is 600"`
+	DefaultNodePolicyFile            string    `doc:"the default node policy file name."`
+	NodeCheckIntervalS               int       `unit:"s" doc:"the node check interval. The default is 15 seconds."`
+	NodePolicyCheckIntervalS         int       `unit:"s" doc:"the node policy check interval. The default is 15 seconds."`
+	FileSyncService                  FSSConfig `doc:"The config for the embedded ESS sync service."`
+	SurfaceErrorTimeoutS             int       `reload:"live" unit:"s" doc:"How long surfaced errors will remain active after they're created. Default is no timeout"`
+	SurfaceErrorCheckIntervalS       int       `unit:"s" doc:"Deprecated. Used to be how often the node will check for errors that are no longer active and update the exchange. Default is 15 seconds"`
+	SurfaceErrorAgreementPersistentS int       `reload:"live" unit:"s" doc:"How long an agreement needs to persist before it is considered persistent and the related errors are dismisse. Default is 90 seconds"`
+	InitialPollingBuffer             int       `unit:"s" doc:"the number of seconds to wait before increasing the polling interval while there is no agreement on the node."`
+	MaxAgreementPrelaunchTimeM       int64     `reload:"live" unit:"m" doc:"The maximum numbers of minutes to wait for workload to start in an agreement"`
+	DeviceAllowList                  []string  `reload:"live" doc:"Host device path patterns (e.g. /dev/nvidia*) that a deployment config is allowed to map into a container. Empty means no restriction."`
+	HostPathAllowList                []string  `reload:"live" doc:"Host path patterns (e.g. /var/lib/sensor-*) that a deployment config is allowed to bind mount into a container, read-only unless the pattern ends with :rw. Empty means no restriction."`
+	RuntimeAllowList                 []string  `reload:"live" doc:"The container runtimes (e.g. nvidia) that a deployment config is allowed to select for its containers. Empty means the containers always use the docker default runtime."`
+	ImagePullRetries                 int       `reload:"live" doc:"The number of times a failed container image pull is retried before giving up. The default is 3."`
+	ImagePullBackoffS                int       `reload:"live" unit:"s" doc:"The number of seconds to wait before the first image pull retry. The wait doubles on each subsequent retry. The default is 15 seconds."`
+	ContainerRuntime                 string    `doc:"The container runtime that runs service containers, \"docker\" (the default) or \"podman\". Podman is reached through its Docker compatible API at the DockerEndpoint."`
+	ServiceRestartPolicy             string    `reload:"live" doc:"The default restart policy of dependent service containers: \"no\", \"on-failure\" (the default) or \"always\". A RestartPolicyAttributes attribute overrides it for a service."`
+	ServiceRestartBackoffS           int       `reload:"live" unit:"s" doc:"The number of seconds to wait before restarting a failed service the first time. The wait doubles on each subsequent restart. The default is 10 seconds."`
+	ServiceRestartMaxBackoffS        int       `reload:"live" unit:"s" doc:"The maximum number of seconds to wait between two restarts of a failed service. The default is 600 seconds."`
+	ImageRetentionCount              int       `reload:"live" doc:"The number of previous versions of each service image that are kept for rollback when superseded images are pruned. The default is 1, a negative value disables pruning."`
+	CPUSetAllowList                  string    `reload:"live" doc:"The cpus (e.g. 2-7) that a deployment config is allowed to pin a container to. Empty means any online cpu."`
+	MaxCPURealtimeRuntime            int64     `reload:"live" unit:"us" doc:"The maximum microseconds per period of realtime scheduling that a deployment config can request for a container. 0 means realtime scheduling is not allowed."`
+	DisableNodeContextEnvvars        bool      `reload:"live" doc:"Do not inject the HZN_NODE_* node context env vars into the service containers."`
+	NodeContextEnvvarsOmit           []string  `reload:"live" doc:"The node context env vars to leave out, by name without the HZN_NODE_ prefix, e.g. AGREEMENT_ID or PROPERTY_*."`
+
+	Network NetworkConfig `doc:"The options used when creating the docker networks for agreements and services. A NetworkAttributes attribute changes them for the network of the agreements or service instances of a service."`
+
+	APIListeners             []APIListenerConfig `doc:"Additional listeners for the agent API, e.g. on a management interface. Each one can serve TLS and require client certificates for the requests that make changes, the APIListen listener serves plain HTTP."`
+	APICertExpiryWarningDays int                 `reload:"live" unit:"d" doc:"The number of days before a TLS certificate of the agent API expires that anax starts to warn about it. The certificates are reloaded on SIGHUP and when their files change."`
+	APITimezone              string              `reload:"live" doc:"The timezone, e.g. Europe/Paris, of the times that the agent API adds in the fields with the _local suffix, next to the UTC times in the fields with the _utc suffix. A request can choose another one with the timezone query parameter. Empty means no _local fields."`
+	EnableMetrics            bool                `doc:"Serve the metrics of the agent at /metrics on the agent API listeners, in the Prometheus text format: the API requests by path, their latencies and errors, the exchange calls, the database transactions, the autoconfig of the node and the health checks of the service containers."`
+	AuditLogMaxEntries       int                 `reload:"live" doc:"The number of the latest requests of the agent API that changed the node, e.g. its registration, config state, services and attributes, that are kept in the audit log of GET /node/audit. The oldest ones are removed first. The default is 1000, 0 means no audit log is kept."`
+
+	HostAddress string `reload:"live" doc:"The network interface (e.g. eth0) or CIDR (e.g. 192.168.1.0/24) of the address that the node reports as its own, for hosts with several interfaces. Empty means the first usable address."`
+
+	HostRootPath string `doc:"The directory where the root filesystem of the host is mounted when anax runs in a container, e.g. /host when the container is run with -v /:/host:ro, so that anax can read the /proc of the host and the image storage of the container runtime. Empty means anax runs on the host."`
+
+	Vault VaultConfig `reload:"live" doc:"The connection to the HashiCorp Vault that holds the secrets referred to by service variables, e.g. a variable set to vault:secret/data/edge/siteA#apiKey. The secrets are read when the service containers are started."`
+
+	ObjectSync ObjectSyncConfig `doc:"The object service that the objects referred to by the deployment configs of the services, e.g. model files, are downloaded from. The objects of an agreement are mounted read-only in its containers."`
+
+	TPM TPMConfig `doc:"The TPM 2.0 that the key material of the node is bound to, for nodes that have one. When the TPM fails at startup the agent runs in recovery mode, with only the API, until the TPM is fixed."`
+
+	AgentUpdate AgentUpdateConfig `doc:"The check for new versions of the agent. The node status shows when an update is available, and a configured update command is run in the maintenance window, after the agreements being made have finished."`
+
+	Canary CanaryConfig `doc:"The canary rollout of new workload versions. A new version first replaces a part of the agreements for the workload, and only replaces the others when those stayed healthy for the soak time."`
+
+	KubeConfigFile      string `doc:"The kubeconfig file of the cluster that the services of a cluster node are deployed to. Empty means the cluster that anax runs in."`
+	KubeRolloutTimeoutS uint64 `unit:"s" doc:"The number of seconds that the Kubernetes Deployments of a service can take to roll out before the service fails to start. The default is 300 seconds."`
+
+	KubeScope KubeScopeConfig `doc:"The namespaces, the default resources and the image pull secrets of the workloads of a cluster node, and how much of the cluster a workload can use. When it is set, anax checks at startup that the namespaces exist and that it may manage the workloads in them."`
+
+	OfflineBundlePath string `doc:"The directory of a signed offline bundle, for nodes that cannot reach the image registries or the exchange when they are installed. At startup anax verifies the signature of the bundle with the PublicKeyPath keys, loads its container images, stores its service definitions and node user input, and then only the registration of the node is left. A bundle is installed once, it is installed again when it changes. Its definitions subdirectory holds the pattern and service definitions of the exchange, each .json file is a response of the exchange, e.g. of GET /orgs/{org}/patterns/{pattern} with the patterns or of GET /orgs/{org}/services with the services. The patterns and services of the manifest of the bundle are used along with them, the ones of the subdirectory take precedence. POST /node registers the node and PUT /node/configstate resolves its pattern with them when the exchange cannot be reached, or when it sets offline. Once the exchange can be reached, the node is set up with it, and the definitions that were used are compared with the ones of the exchange, the ones that differ are logged in the event log."`
+
+	ProvisioningFile string `doc:"The provisioning file that cloud-init, or the vendor data of the device, writes for the first boot. When the node is not registered and the file exists, anax registers the node with the exchange, configures the services and sets the configstate from it, as hzn register does. The outcome is written next to it with a .result suffix, and the file is renamed with a .done suffix once it succeeded so that it is not used again. Its exchange_url must be Edge.ExchangeURL."`
+
+	AutoconfigManifest string `reload:"live" doc:"The autoconfig manifest of a node without a pattern, a json array of the services to configure when the node is changed to configured, each with its url, org, versionRange, arch and the values of its variables. The services and the services they require are configured as they are for the services of a pattern. Nothing is configured when the file does not exist."`
+
+	Journal JournalConfig `doc:"The events of the event log that are forwarded to the local journal, or to the local syslog when journald is not running, with their agreement, service and node ids as fields that journalctl can filter on. The events are dropped rather than delay the agent when the journal cannot keep up."`
+
+	Download DownloadConfig `doc:"The download rate limits of the agent, e.g. so that the downloads do not saturate a link that the node shares with other devices. They can be changed while anax is running with PATCH /config/download."`
+
+	Disk DiskConfig `doc:"The free space that the agent needs on the partitions of the container images and of its database, and when it alerts that the space is running out."`
+
+	ClockSkew ClockSkewConfig `doc:"How far the clock of the node can be off the clock of the exchange, as measured on the exchange responses."`
+
+	PatternCacheTTLS uint64 `reload:"live" unit:"s" doc:"The number of seconds that the patterns and the resolved services read from the exchange are kept in memory when the node is configured, so that a pattern with many services does not read the same ones again. The default is 60 seconds, 0 means they are not kept."`
+
+	ServiceResolutionConcurrency int `reload:"live" doc:"The maximum number of the services of the node's pattern that are resolved in the exchange at the same time when the node is configured. The default is 5."`
+
+	ConfigstateTimeoutS uint64 `reload:"live" unit:"s" doc:"The number of seconds that a change of the config state of the node can take, e.g. while the exchange does not respond. The change then fails and what it configured is removed, rather than completing after the client gave up. The default is 240 seconds, less than the timeout of the agent API client, 0 means there is no timeout."`
+
+	ShutdownGracePeriodS uint64 `reload:"live" unit:"s" doc:"The number of seconds that the agent waits, when it is stopped with SIGTERM or POST /node/shutdown, for the changes of the node configuration that are running to complete. The ones that take longer are cancelled and what they configured is removed. The default is 30 seconds, 0 cancels them right away."`
+
+	ServiceReconcileIntervalS uint64 `reload:"live" unit:"s" doc:"The number of seconds between the comparisons of the services registered on the configured node with the ones its pattern requires. The services that are missing or superfluous are logged in the event log, and the missing ones that need no user input are created when the node has an AutoReconcileAttributes attribute that is true. The comparisons are further apart while the exchange cannot be reached. The default is 600 seconds, 0 turns them off."`
+
+	ConfigstateHooks ConfigstateHooksConfig `doc:"The webhooks and the executables that are run in the background when the config state of the node is changed."`
+
+	ConditionHooks ConditionHooksConfig `doc:"The webhooks and the executables that are run in the background when a critical condition of the node is detected, e.g. that a newer version of the agent is available."`
+
+	ConfigRateLimit ConfigRateLimitConfig `doc:"The limit of the rate of the changes of the node configuration through the agent API, and how the same change requested again is answered with the result of the first one."`
+
+	ExchangeRetry ExchangeRetryConfig `doc:"How the exchange calls that read the node's pattern and resolve its services are retried when they fail with an error that may go away, e.g. a 502 or a timeout, while the config state of the node is changed."`
+
+	ImageRegistryURL string `reload:"live" doc:"The URL of the image registry of the services, e.g. https://registry.example.com, whose reachability GET /node/connectivity checks along with the exchange. It is not checked when not set."`
+
+	AdditionalArchs []string `doc:"The architectures, other than the one of the node, whose services the node can run, e.g. arm64 on an amd64 node that runs arm64 containers through emulation. The services of these architectures in the node's pattern are configured as well when the node is configured."`
 
 	// these Ids could be provided in config or discovered after startup by the system
-	BlockchainAccountId        string
-	BlockchainDirectoryAddress string
+	BlockchainAccountId        string `doc:"Deprecated. The blockchain account id of the node, no longer used."`
+	BlockchainDirectoryAddress string `doc:"Deprecated. The blockchain directory address, no longer used."`
 }
 
 // This is the configuration options for Agreement bot flavor of Anax
 type AGConfig struct {
-	TxLostDelayTolerationSeconds  int
-	AgreementWorkers              int
-	DBPath                        string
-	Postgresql                    PostgresqlConfig // The Postgresql config if it is being used
-	PartitionStale                uint64           // Number of seconds to wait before declaring a partition to be stale (i.e. the previous owner has unexpectedly terminated).
-	ProtocolTimeoutS              uint64           // Number of seconds to wait before declaring proposal response is lost
-	AgreementTimeoutS             uint64           // Number of seconds to wait before declaring agreement not finalized in blockchain
-	ProtocolTimeoutScaleFactor    float64          // Time to wait before declaring a proposal response is lost. Expressed as a scaling factor of the max heartbeat interval for a given node
-	AgreementTimeoutScaleFactor   float64          // Time to wait before declaring an agreement did not finalize. Expressed as a scaling factor of the max heartbeat interval for a given node
-	NoDataIntervalS               uint64           // default should be 15 mins == 15*60 == 900. Ignored if the policy has data verification disabled.
-	ActiveAgreementsURL           string           // This field is used when policy files indicate they want data verification but they dont specify a URL
-	ActiveAgreementsUser          string           // This is the userid the agbot uses to authenticate to the data verifivcation API
-	ActiveAgreementsPW            string           // This is the password for the ActiveAgreementsUser
-	PolicyPath                    string           // The directory where policy files are kept, default /etc/provider-tremor/policy/
-	NewContractIntervalS          uint64           // default should be 1
-	ProcessGovernanceIntervalS    uint64           // How long the gov sleeps before general gov checks (new payloads, interval payments, etc).
-	IgnoreContractWithAttribs     string           // A comma seperated list of contract attributes. If set, the contracts that contain one or more of the attributes will be ignored. The default is "ethereum_account".
-	ExchangeURL                   string           // The URL of the Horizon exchange. If not configured, the exchange will not be used.
-	ExchangeHeartbeat             int              // Seconds between heartbeats to the exchange
-	ExchangeId                    string           // The id of the agbot, not the userid of the exchange user. Must be org qualified.
-	ExchangeToken                 string           // The agbot's authentication token
-	DVPrefix                      string           // When looking for agreement ids in the data verification API response, look for agreement ids with this prefix.
-	ActiveDeviceTimeoutS          int              // The amount of time a device can go without heartbeating and still be considered active for the purposes of search
-	ExchangeMessageTTL            int              // The number of seconds the exchange will keep this message before automatically deleting it
-	ExchangeMessageTTLScaleFactor float64          // Scale factor for thee time the exchange will keep this ,essage before automatically deleting it. Scaled relativee to the max heeartbeat interval
-	MessageKeyPath                string           // The path to the location of messaging keys
-	MessageKeyCheck               int              // The interval (in seconds) indicating how often the agbot checks its own object in the exchange to ensure that the message key is still available.
-	DefaultWorkloadPW             string           // The default workload password if none is specified in the policy file
-	APIListen                     string           // Host and port for the API to listen on
-	SecureAPIListenHost           string           // The host for the secure API to listen on
-	SecureAPIListenPort           string           // The port for the secure API to listen on
-	SecureAPIServerCert           string           // The path to the certificate file for the secure api
-	SecureAPIServerKey            string           // The path to the server key file for the secure api
-	PurgeArchivedAgreementHours   int              // Number of hours to leave an archived agreement in the database before automatically deleting it
-	CheckUpdatedPolicyS           int              // The number of seconds to wait between checks for an updated policy file. Zero means auto checking is turned off.
-	CSSURL                        string           // The URL used to access the CSS.
-	CSSSSLCert                    string           // The path to the client side SSL certificate for the CSS.
-	MMSGarbageCollectionInterval  int64            // The amount of time to wait between MMS object cache garbage collection scans.
-	AgreementBatchSize            uint64           // The number of nodes that the agbot will process in a batch.
-	AgreementQueueSize            uint64           // The agreement bot work queue max size.
-	FullRescanS                   uint64           // The number of seconds between policy scans when there have been no changes reported by the exchange.
-	MaxExchangeChanges            int              // The maximum number of exchange changes to request on a given call the exchange /changes API.
-	RetryLookBackWindow           uint64           // The time window (in seconds) used by the agbot to look backward in time for node changes when node agreements are retried.
-	PolicySearchOrder             bool             // When true, search policies from most recently changed to least recently changed.
+	TxLostDelayTolerationSeconds  int              `unit:"s" doc:"Deprecated. The number of seconds to wait for a lost blockchain transaction, no longer used."`
+	AgreementWorkers              int              `doc:"The number of workers that process agreement protocol messages in parallel for each agreement protocol."`
+	DBPath                        string           `doc:"The directory where the agreement bot bolt database is kept. Either this or Postgresql configures the agreement bot database."`
+	Postgresql                    PostgresqlConfig `doc:"The Postgresql config if it is being used"`
+	PartitionStale                uint64           `unit:"s" doc:"Number of seconds to wait before declaring a partition to be stale (i.e. the previous owner has unexpectedly terminated)."`
+	ProtocolTimeoutS              uint64           `unit:"s" doc:"Number of seconds to wait before declaring proposal response is lost"`
+	AgreementTimeoutS             uint64           `unit:"s" doc:"Number of seconds to wait before declaring agreement not finalized in blockchain"`
+	ProtocolTimeoutScaleFactor    float64          `doc:"Time to wait before declaring a proposal response is lost. Expressed as a scaling factor of the max heartbeat interval for a given node"`
+	AgreementTimeoutScaleFactor   float64          `doc:"Time to wait before declaring an agreement did not finalize. Expressed as a scaling factor of the max heartbeat interval for a given node"`
+	NoDataIntervalS               uint64           `unit:"s" doc:"default should be 15 mins == 15*60 == 900. Ignored if the policy has data verification disabled."`
+	ActiveAgreementsURL           string           `doc:"This field is used when policy files indicate they want data verification but they dont specify a URL"`
+	ActiveAgreementsUser          string           `doc:"This is the userid the agbot uses to authenticate to the data verifivcation API"`
+	ActiveAgreementsPW            string           `secret:"true" doc:"This is the password for the ActiveAgreementsUser. It can be a file:// or env:// reference to the password."`
+	PolicyPath                    string           `doc:"The directory where policy files are kept, default /etc/provider-tremor/policy/"`
+	NewContractIntervalS          uint64           `unit:"s" doc:"default should be 1"`
+	ProcessGovernanceIntervalS    uint64           `unit:"s" doc:"How long the gov sleeps before general gov checks (new payloads, interval payments, etc)."`
+	IgnoreContractWithAttribs     string           `doc:"A comma seperated list of contract attributes. If set, the contracts that contain one or more of the attributes will be ignored. The default is \"ethereum_account\"."`
+	ExchangeURL                   string           `doc:"The URL of the Horizon exchange. If not configured, the exchange will not be used."`
+	ExchangeHeartbeat             int              `unit:"s" doc:"Seconds between heartbeats to the exchange"`
+	ExchangeId                    string           `doc:"The id of the agbot, not the userid of the exchange user. Must be org qualified."`
+	ExchangeToken                 string           `secret:"true" doc:"The agbot's authentication token. It can be a file:// or env:// reference to the token."`
+	DVPrefix                      string           `doc:"When looking for agreement ids in the data verification API response, look for agreement ids with this prefix."`
+	ActiveDeviceTimeoutS          int              `unit:"s" doc:"The amount of time a device can go without heartbeating and still be considered active for the purposes of search"`
+	ExchangeMessageTTL            int              `unit:"s" doc:"The number of seconds the exchange will keep this message before automatically deleting it"`
+	ExchangeMessageTTLScaleFactor float64          `doc:"Scale factor for thee time the exchange will keep this ,essage before automatically deleting it. Scaled relativee to the max heeartbeat interval"`
+	MessageKeyPath                string           `doc:"The path to the location of messaging keys"`
+	MessageKeyCheck               int              `unit:"s" doc:"The interval (in seconds) indicating how often the agbot checks its own object in the exchange to ensure that the message key is still available."`
+	DefaultWorkloadPW             string           `secret:"true" doc:"The default workload password if none is specified in the policy file. It can be a file:// or env:// reference to the password."`
+	APIListen                     string           `doc:"Host and port for the API to listen on"`
+	SecureAPIListenHost           string           `doc:"The host for the secure API to listen on"`
+	SecureAPIListenPort           string           `doc:"The port for the secure API to listen on"`
+	SecureAPIServerCert           string           `doc:"The path to the certificate file for the secure api"`
+	SecureAPIServerKey            string           `doc:"The path to the server key file for the secure api"`
+	PurgeArchivedAgreementHours   int              `unit:"h" doc:"Number of hours to leave an archived agreement in the database before automatically deleting it"`
+	CheckUpdatedPolicyS           int              `unit:"s" doc:"The number of seconds to wait between checks for an updated policy file. Zero means auto checking is turned off."`
+	CSSURL                        string           `doc:"The URL used to access the CSS."`
+	CSSSSLCert                    string           `doc:"The path to the client side SSL certificate for the CSS."`
+	MMSGarbageCollectionInterval  int64            `unit:"s" doc:"The amount of time to wait between MMS object cache garbage collection scans."`
+	AgreementBatchSize            uint64           `doc:"The number of nodes that the agbot will process in a batch."`
+	AgreementQueueSize            uint64           `doc:"The agreement bot work queue max size."`
+	FullRescanS                   uint64           `unit:"s" doc:"The number of seconds between policy scans when there have been no changes reported by the exchange."`
+	MaxExchangeChanges            int              `doc:"The maximum number of exchange changes to request on a given call the exchange /changes API."`
+	RetryLookBackWindow           uint64           `unit:"s" doc:"The time window (in seconds) used by the agbot to look backward in time for node changes when node agreements are retried."`
+	PolicySearchOrder             bool             `doc:"When true, search policies from most recently changed to least recently changed."`
 }
 
 func (c *HorizonConfig) UserPublicKeyPath() string {
@@ -141,6 +226,41 @@ func (c *HorizonConfig) IsPostgresqlConfigured() bool {
 	return (c.AgreementBot.Postgresql != (PostgresqlConfig{})) && (c.GetPartitionStale() != 0)
 }
 
+func (c *HorizonConfig) GetContainerRuntime() string {
+	if c.Edge.ContainerRuntime == "" {
+		return CONTAINER_RUNTIME_DOCKER
+	}
+	return c.Edge.ContainerRuntime
+}
+
+func (c *HorizonConfig) GetServiceRestartPolicy() string {
+	if policy := c.LiveEdge().ServiceRestartPolicy; policy != "" {
+		return policy
+	}
+	return SERVICE_RESTART_POLICY_ON_FAILURE
+}
+
+// Returns the time that a change of the config state of the node can take, 0 when there is no limit.
+func (c *HorizonConfig) GetConfigstateTimeout() time.Duration {
+	return time.Duration(c.LiveEdge().ConfigstateTimeoutS) * time.Second
+}
+
+func (c *HorizonConfig) GetShutdownGracePeriod() time.Duration {
+	return time.Duration(c.LiveEdge().ShutdownGracePeriodS) * time.Second
+}
+
+func (c *HorizonConfig) GetServiceResolutionConcurrency() int {
+	if concurrency := c.LiveEdge().ServiceResolutionConcurrency; concurrency > 0 {
+		return concurrency
+	}
+	return ServiceResolutionConcurrency_DEFAULT
+}
+
+// Returns true if the given string is one of the supported service restart policies.
+func IsValidServiceRestartPolicy(policy string) bool {
+	return policy == SERVICE_RESTART_POLICY_NO || policy == SERVICE_RESTART_POLICY_ON_FAILURE || policy == SERVICE_RESTART_POLICY_ALWAYS
+}
+
 func (c *HorizonConfig) GetPartitionStale() uint64 {
 	if c.AgreementBot.PartitionStale == 0 {
 		return 60
@@ -207,6 +327,14 @@ func (a *AGConfig) GetAgreementTimeout(maxHeartbeatInterval int) uint64 {
 	return uint64(float64(hbInterval) * scaleFactor)
 }
 
+// Returns the path of a file of the host, under the HostRootPath when anax runs in a container.
+func (c *Config) HostPath(p string) string {
+	if c.HostRootPath == "" {
+		return p
+	}
+	return path.Join(c.HostRootPath, p)
+}
+
 func (c *Config) GetAgreementTimeout(maxHeartbeatInterval int) uint64 {
 	if c.AgreementTimeoutS != 0 {
 		return c.AgreementTimeoutS
@@ -262,16 +390,7 @@ func enrichFromEnvvars(config *HorizonConfig) error {
 	}
 
 	if apiPort := os.Getenv(AnaxAPIPort); apiPort != "" {
-		if config.Edge.APIListen != "" {
-			listen := strings.Split(config.Edge.APIListen, ":")
-			if len(listen) == 2 {
-				config.Edge.APIListen = fmt.Sprintf("%v:%v", listen[0], apiPort)
-			} else {
-				config.Edge.APIListen = fmt.Sprintf("127.0.0.1:%v", apiPort)
-			}
-		} else {
-			config.Edge.APIListen = fmt.Sprintf("127.0.0.1:%v", apiPort)
-		}
+		config.Edge.APIListen = ListenAddressWithPort(config.Edge.APIListen, apiPort)
 	} else {
 		if config.Edge.APIListen == "" {
 			config.Edge.APIListen = fmt.Sprintf("127.0.0.1:%v", AnaxAPIPortDefault)
@@ -281,6 +400,86 @@ func enrichFromEnvvars(config *HorizonConfig) error {
 	return nil
 }
 
+// Returns a config with the defaults of the fields that can be overridden by the config file.
+func newDefaultConfig() HorizonConfig {
+	return HorizonConfig{
+		SchemaVersion: CONFIG_SCHEMA_VERSION,
+		Edge: Config{
+			DefaultHTTPClientTimeoutS:      HTTPRequestTimeoutS,
+			ExchangeMessageDynamicPoll:     true,
+			ExchangeMessagePollInterval:    ExchangeMessagePollInterval_DEFAULT,
+			ExchangeMessagePollMaxInterval: ExchangeMessagePollMaxInterval_DEFAULT,
+			ExchangeMessagePollIncrement:   ExchangeMessagePollIncrement_DEFAULT,
+			MaxAgreementPrelaunchTimeM:     EdgeMaxAgreementPrelaunchTimeM_DEFAULT,
+			ImagePullRetries:               ImagePullRetries_DEFAULT,
+			APICertExpiryWarningDays:       APICertExpiryWarningDays_DEFAULT,
+			ImagePullBackoffS:              ImagePullBackoffS_DEFAULT,
+			ServiceRestartBackoffS:         ServiceRestartBackoffS_DEFAULT,
+			ServiceRestartMaxBackoffS:      ServiceRestartMaxBackoffS_DEFAULT,
+			ImageRetentionCount:            ImageRetentionCount_DEFAULT,
+			KubeRolloutTimeoutS:            KubeRolloutTimeoutS_DEFAULT,
+			PatternCacheTTLS:               PatternCacheTTLS_DEFAULT,
+			ServiceResolutionConcurrency:   ServiceResolutionConcurrency_DEFAULT,
+			ConfigstateTimeoutS:            ConfigstateTimeoutS_DEFAULT,
+			ShutdownGracePeriodS:           ShutdownGracePeriodS_DEFAULT,
+			ServiceReconcileIntervalS:      ServiceReconcileIntervalS_DEFAULT,
+			ConfigRateLimit:                ConfigRateLimitConfig{PerMinute: ConfigRateLimitPerMinute_DEFAULT, DuplicateWindowS: ConfigRateLimitDuplicateWindowS_DEFAULT},
+			AuditLogMaxEntries:             AuditLogMaxEntries_DEFAULT,
+		},
+		AgreementBot: AGConfig{
+			MessageKeyCheck:     AgbotMessageKeyCheck_DEFAULT,
+			AgreementBatchSize:  AgbotAgreementBatchSize_DEFAULT,
+			AgreementQueueSize:  AgbotAgreementQueueSize_DEFAULT,
+			FullRescanS:         AgbotFullRescan_DEFAULT,
+			MaxExchangeChanges:  AgbotMaxChanges_DEFAULT,
+			RetryLookBackWindow: AgbotRetryLookBackWindow_DEFAULT,
+			PolicySearchOrder:   AgbotPolicySearchOrder_DEFAULT,
+		},
+	}
+}
+
+// Set the defaults of the fields that are not set, or set to 0, in the config file.
+func (c *HorizonConfig) setZeroDefaults() {
+	if c.Edge.ServiceUpgradeCheckIntervalS == 0 {
+		c.Edge.ServiceUpgradeCheckIntervalS = 300
+	}
+
+	if c.Edge.NodeCheckIntervalS == 0 {
+		c.Edge.NodeCheckIntervalS = 15
+	}
+
+	if c.Edge.NodePolicyCheckIntervalS == 0 {
+		c.Edge.NodePolicyCheckIntervalS = 15
+	}
+
+	if c.Edge.SurfaceErrorCheckIntervalS == 0 {
+		c.Edge.SurfaceErrorCheckIntervalS = 15
+	}
+
+	if c.Edge.SurfaceErrorAgreementPersistentS == 0 {
+		c.Edge.SurfaceErrorAgreementPersistentS = 90
+	}
+
+	// set default retry parameters
+	// the default DefaultServiceRetryCount is 2. It means 2 tries including the original one.
+	// so it is actually 1 retry.
+	if c.Edge.DefaultServiceRetryCount == 0 {
+		c.Edge.DefaultServiceRetryCount = 2
+	}
+	if c.Edge.DefaultServiceRetryDuration == 0 {
+		c.Edge.DefaultServiceRetryDuration = 600
+	}
+
+	// default InitialPollingBuffer
+	if c.Edge.InitialPollingBuffer == 0 {
+		c.Edge.InitialPollingBuffer = 120
+	}
+
+	if c.AgreementBot.MMSGarbageCollectionInterval == 0 {
+		c.AgreementBot.MMSGarbageCollectionInterval = 300
+	}
+}
+
 func Read(file string) (*HorizonConfig, error) {
 
 	if _, err := os.Stat(file); err != nil {
@@ -293,72 +492,55 @@ func Read(file string) (*HorizonConfig, error) {
 		return nil, fmt.Errorf("Unable to read config file: %s. Error: %v", file, err)
 	} else {
 		// instantiate mostly empty which will be filled. Values here are defaults that can be overridden by the user
-		config := HorizonConfig{
-			Edge: Config{
-				DefaultHTTPClientTimeoutS:      HTTPRequestTimeoutS,
-				ExchangeMessageDynamicPoll:     true,
-				ExchangeMessagePollInterval:    ExchangeMessagePollInterval_DEFAULT,
-				ExchangeMessagePollMaxInterval: ExchangeMessagePollMaxInterval_DEFAULT,
-				ExchangeMessagePollIncrement:   ExchangeMessagePollIncrement_DEFAULT,
-				MaxAgreementPrelaunchTimeM:     EdgeMaxAgreementPrelaunchTimeM_DEFAULT,
-			},
-			AgreementBot: AGConfig{
-				MessageKeyCheck:     AgbotMessageKeyCheck_DEFAULT,
-				AgreementBatchSize:  AgbotAgreementBatchSize_DEFAULT,
-				AgreementQueueSize:  AgbotAgreementQueueSize_DEFAULT,
-				FullRescanS:         AgbotFullRescan_DEFAULT,
-				MaxExchangeChanges:  AgbotMaxChanges_DEFAULT,
-				RetryLookBackWindow: AgbotRetryLookBackWindow_DEFAULT,
-				PolicySearchOrder:   AgbotPolicySearchOrder_DEFAULT,
-			},
-		}
+		config := newDefaultConfig()
 
-		err := json.NewDecoder(path).Decode(&config)
+		defer path.Close()
+		content, err := ioutil.ReadAll(path)
 		if err != nil {
-			return nil, fmt.Errorf("Unable to decode content of config file: %v", err)
+			return nil, fmt.Errorf("Unable to read config file: %s. Error: %v", file, err)
 		}
 
-		err = enrichFromEnvvars(&config)
-
+		// bring a config file written for an older version of anax up to date.
+		content, _, err = migrateConfig(content)
 		if err != nil {
-			return nil, fmt.Errorf("Unable to enrich content of config file with envvars: %v", err)
+			return nil, fmt.Errorf("Unable to migrate content of config file: %v", err)
 		}
 
-		// set the defaults here in case the attributes are not setup by the user.
-		if config.Edge.ServiceUpgradeCheckIntervalS == 0 {
-			config.Edge.ServiceUpgradeCheckIntervalS = 300
+		// the time valued fields can be duration strings, they are converted to the number of the unit of the field.
+		content, durationProblems, err := convertDurations(content)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode content of config file: %v", err)
 		}
 
-		if config.Edge.NodeCheckIntervalS == 0 {
-			config.Edge.NodeCheckIntervalS = 15
+		err = json.Unmarshal(content, &config)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode content of config file: %v", err)
 		}
 
-		if config.Edge.NodePolicyCheckIntervalS == 0 {
-			config.Edge.NodePolicyCheckIntervalS = 15
-		}
+		err = enrichFromEnvvars(&config)
 
-		if config.Edge.SurfaceErrorCheckIntervalS == 0 {
-			config.Edge.SurfaceErrorCheckIntervalS = 15
+		if err != nil {
+			return nil, fmt.Errorf("Unable to enrich content of config file with envvars: %v", err)
 		}
 
-		if config.Edge.SurfaceErrorAgreementPersistentS == 0 {
-			config.Edge.SurfaceErrorAgreementPersistentS = 90
+		// the HZN_CONFIG_ env vars take precedence over the config file and the defaults.
+		overrides, err := applyEnvOverrides(&config)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to override content of config file with envvars: %v", err)
 		}
+		logEnvOverrides(overrides)
 
-		// set default retry parameters
-		// the default DefaultServiceRetryCount is 2. It means 2 tries including the original one.
-		// so it is actually 1 retry.
-		if config.Edge.DefaultServiceRetryCount == 0 {
-			config.Edge.DefaultServiceRetryCount = 2
-		}
-		if config.Edge.DefaultServiceRetryDuration == 0 {
-			config.Edge.DefaultServiceRetryDuration = 600
+		// remember where the value of each field came from, to show it with the effective config.
+		config.sources, err = configSources(content, overrides)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode content of config file: %v", err)
 		}
 
-		// default InitialPollingBuffer
-		if config.Edge.InitialPollingBuffer == 0 {
-			config.Edge.InitialPollingBuffer = 120
-		}
+		// replace the secret references by the secrets, the problems are reported with the other config problems.
+		secretProblems := resolveSecrets(&config)
+
+		// set the defaults here in case the attributes are not setup by the user.
+		config.setZeroDefaults()
 
 		// add a slash at the back of the ExchangeUrl
 		if config.Edge.ExchangeURL != "" {
@@ -389,9 +571,20 @@ func Read(file string) (*HorizonConfig, error) {
 			config.ArchSynonyms = NewArchSynonyms()
 		}
 
-		if config.AgreementBot.MMSGarbageCollectionInterval == 0 {
-			config.AgreementBot.MMSGarbageCollectionInterval = 300
+		// reject the config if a duration cannot be decoded, reporting all of them at once. The values are checked by
+		// Validate at startup, so that the config can still be read to be shown.
+		if len(durationProblems) != 0 {
+			return nil, durationProblems
 		}
+		config.secretErrors = secretProblems
+
+		// an unknown feature is not a problem, it could be meant for a newer anax.
+		config.warnUnknownFeatures()
+
+		// a value that is much too long for its field is most likely in the wrong unit.
+		config.warnSuspiciousDurations()
+
+		config.file = file
 
 		// success at last!
 		return &config, nil
@@ -399,12 +592,45 @@ func Read(file string) (*HorizonConfig, error) {
 }
 
 func (c *HorizonConfig) String() string {
-	return fmt.Sprintf("Edge: {%v}, AgreementBot: {%v}, Collaborators: {%v}, ArchSynonyms: {%v}", c.Edge.String(), c.AgreementBot.String(), c.Collaborators.String(), c.ArchSynonyms)
+	return fmt.Sprintf("SchemaVersion: %v, Edge: {%v}, AgreementBot: {%v}, Collaborators: {%v}, ArchSynonyms: {%v}, Features: %v", c.SchemaVersion, c.Edge.String(), c.AgreementBot.String(), c.Collaborators.String(), c.ArchSynonyms, c.Features)
 }
 
 func (con *Config) String() string {
 	return fmt.Sprintf("ServiceStorage %v"+
 		", APIListen %v"+
+		", APIListeners %v"+
+		", APICertExpiryWarningDays %v"+
+		", APITimezone %v"+
+		", EnableMetrics %v"+
+		", AuditLogMaxEntries %v"+
+		", HostAddress %v"+
+		", HostRootPath %v"+
+		", Vault: {%v}"+
+		", ObjectSync: {%v}"+
+		", TPM: {%v}"+
+		", AgentUpdate: {%v}"+
+		", Canary: {%v}"+
+		", KubeConfigFile: %v"+
+		", KubeRolloutTimeoutS: %v"+
+		", KubeScope: {%v}"+
+		", OfflineBundlePath: %v"+
+		", ProvisioningFile: %v"+
+		", AutoconfigManifest: %v"+
+		", Journal: {%v}"+
+		", Download: {%v}"+
+		", Disk: {%v}"+
+		", ClockSkew: {%v}"+
+		", PatternCacheTTLS: %v"+
+		", ServiceResolutionConcurrency: %v"+
+		", ConfigstateTimeoutS: %v"+
+		", ShutdownGracePeriodS: %v"+
+		", ServiceReconcileIntervalS: %v"+
+		", ConfigstateHooks: {%v}"+
+		", ConditionHooks: {%v}"+
+		", ConfigRateLimit: {%v}"+
+		", ExchangeRetry: {%v}"+
+		", ImageRegistryURL: %v"+
+		", AdditionalArchs: %v"+
 		", DBPath %v"+
 		", DockerEndpoint %v"+
 		", DockerCredFilePath %v"+
@@ -437,16 +663,29 @@ func (con *Config) String() string {
 		", NodeCheckIntervalS: %v"+
 		", FileSyncService: {%v}"+
 		", InitialPollingBuffer: {%v}"+
+		", ImagePullRetries: %v"+
+		", ImagePullBackoffS: %v"+
+		", ContainerRuntime: %v"+
+		", ServiceRestartPolicy: %v"+
+		", ServiceRestartBackoffS: %v"+
+		", ServiceRestartMaxBackoffS: %v"+
+		", ImageRetentionCount: %v"+
+		", CPUSetAllowList: %v"+
+		", MaxCPURealtimeRuntime: %v"+
+		", DisableNodeContextEnvvars: %v"+
+		", NodeContextEnvvarsOmit: %v"+
 		", BlockchainAccountId: %v"+
 		", BlockchainDirectoryAddress %v",
-		con.ServiceStorage, con.APIListen, con.DBPath, con.DockerEndpoint, con.DockerCredFilePath, con.DefaultCPUSet,
+		con.ServiceStorage, con.APIListen, con.APIListeners, con.APICertExpiryWarningDays, con.APITimezone, con.EnableMetrics, con.AuditLogMaxEntries, con.HostAddress, con.HostRootPath, con.Vault.String(), con.ObjectSync.String(), con.TPM.String(), con.AgentUpdate.String(), con.Canary.String(), con.KubeConfigFile, con.KubeRolloutTimeoutS, con.KubeScope.String(), con.OfflineBundlePath, con.ProvisioningFile, con.AutoconfigManifest, con.Journal.String(), con.Download.String(), con.Disk.String(), con.ClockSkew.String(), con.PatternCacheTTLS, con.ServiceResolutionConcurrency, con.ConfigstateTimeoutS, con.ShutdownGracePeriodS, con.ServiceReconcileIntervalS, con.ConfigstateHooks.String(), con.ConditionHooks.String(), con.ConfigRateLimit.String(), con.ExchangeRetry.String(), con.ImageRegistryURL, con.AdditionalArchs, con.DBPath, con.DockerEndpoint, con.DockerCredFilePath, con.DefaultCPUSet,
 		con.DefaultServiceRegistrationRAM, con.StaticWebContent, con.PublicKeyPath, con.TrustSystemCACerts, con.CACertsPath, con.ExchangeURL,
 		con.DefaultHTTPClientTimeoutS, con.PolicyPath, con.ExchangeHeartbeat, con.AgreementTimeoutS,
 		con.DVPrefix, con.RegistrationDelayS, con.ExchangeMessageTTL, con.ExchangeMessageDynamicPoll, con.ExchangeMessagePollInterval,
 		con.ExchangeMessagePollMaxInterval, con.ExchangeMessagePollIncrement, con.UserPublicKeyPath, con.ReportDeviceStatus,
 		con.TrustCertUpdatesFromOrg, con.TrustDockerAuthFromOrg, con.ServiceUpgradeCheckIntervalS, con.MultipleAnaxInstances,
 		con.DefaultServiceRetryCount, con.DefaultServiceRetryDuration, con.NodeCheckIntervalS, con.FileSyncService.String(),
-		con.InitialPollingBuffer, con.BlockchainAccountId, con.BlockchainDirectoryAddress)
+		con.InitialPollingBuffer, con.ImagePullRetries, con.ImagePullBackoffS, con.ContainerRuntime,
+		con.ServiceRestartPolicy, con.ServiceRestartBackoffS, con.ServiceRestartMaxBackoffS, con.ImageRetentionCount,
+		con.CPUSetAllowList, con.MaxCPURealtimeRuntime, con.DisableNodeContextEnvvars, con.NodeContextEnvvarsOmit, con.BlockchainAccountId, con.BlockchainDirectoryAddress)
 }
 
 func (agc *AGConfig) String() string {
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+)
+
+// The default number of changes of the node configuration that the agent API accepts per minute.
+const ConfigRateLimitPerMinute_DEFAULT = 12
+
+// The default number of changes of the node configuration that the agent API accepts in a row, before the rate applies.
+const ConfigRateLimitBurst_DEFAULT = 5
+
+// The default number of seconds that the result of a change of the node configuration is returned again for the same
+// request.
+const ConfigRateLimitDuplicateWindowS_DEFAULT = 10
+
+// The limit of the rate of the changes of the node configuration through the agent API, e.g. PUT /node/configstate, so
+// that a client that retries them in a loop does not make the agent resolve the node's pattern in the exchange again
+// and again. The changes are accepted at PerMinute on average, up to Burst in a row.
+type ConfigRateLimitConfig struct {
+	PerMinute        int    `reload:"live" doc:"The number of changes of the node configuration that the agent API accepts per minute, on average. The changes above it are refused with a 429 and the number of seconds to wait in the Retry-After header. The default is 12, 0 means there is no limit."`
+	Burst            int    `reload:"live" doc:"The number of changes of the node configuration that the agent API accepts in a row, before PerMinute applies. The default is 5."`
+	PerClient        bool   `reload:"live" doc:"Limit each client address on its own, rather than all the clients together."`
+	DuplicateWindowS uint64 `reload:"live" unit:"s" doc:"The number of seconds that the result of a change of the node configuration is returned again, without running the change, for a request of the same client with the same method, path, If-Match and body. A request made while the same one is running waits for its result. A failed change is not returned again, nor one made before another change of the node completed. The default is 10 seconds, 0 means the requests are always run."`
+}
+
+func (c *ConfigRateLimitConfig) String() string {
+	return fmt.Sprintf("PerMinute: %v, Burst: %v, PerClient: %v, DuplicateWindowS: %v", c.PerMinute, c.Burst, c.PerClient, c.DuplicateWindowS)
+}
+
+func (c *ConfigRateLimitConfig) GetBurst() int {
+	if c.Burst == 0 {
+		return ConfigRateLimitBurst_DEFAULT
+	}
+	return c.Burst
+}
+
+// Check the config rate limit settings.
+func (e *ConfigErrors) checkConfigRateLimit(path string, c *ConfigRateLimitConfig) {
+	e.nonNegative(path+".PerMinute", int64(c.PerMinute))
+	e.nonNegative(path+".Burst", int64(c.Burst))
+}
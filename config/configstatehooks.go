@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// The default number of seconds that a configstate hook can run.
+const ConfigstateHookTimeoutS_DEFAULT = 30
+
+// The default number of times a failed configstate hook is retried.
+const ConfigstateHookRetries_DEFAULT = 2
+
+// The config states that a node goes through, the transitions to the configured and unconfigured states run the
+// configstate hooks unless the States are set.
+var configstateHookStates = []string{"configuring", "configured", "configured_pending", "unconfiguring", "unconfigured"}
+
+// The webhooks and the executables that the agent runs when the config state of the node is changed, e.g. to mount
+// volumes or to notify a fleet manager when the node is configured. They run in the background, after the new state is
+// saved, and their failures are logged but do not undo the change.
+type ConfigstateHooksConfig struct {
+	URLs     []string `doc:"The http or https URLs that a JSON document with the old and new states, the node id, org and pattern is POSTed to when the config state of the node is changed. Any 2xx status is a success."`
+	Commands []string `doc:"The absolute paths of the executables that are run with the same JSON document on their standard input when the config state of the node is changed. An exit status of 0 is a success."`
+	States   []string `reload:"live" doc:"The new states whose transitions run the hooks, any of configuring, configured, configured_pending, unconfiguring and unconfigured. The default is configured and unconfigured."`
+	TimeoutS uint64   `reload:"live" unit:"s" doc:"The number of seconds that each attempt of a hook can take before it is stopped. The default is 30 seconds."`
+	Retries  int      `reload:"live" doc:"The number of times that a failed hook is retried, with a wait that doubles on each retry. The default is 2, -1 means it is not retried."`
+}
+
+func (c *ConfigstateHooksConfig) String() string {
+	return fmt.Sprintf("URLs: %v, Commands: %v, States: %v, TimeoutS: %v, Retries: %v", c.URLs, c.Commands, c.States, c.TimeoutS, c.Retries)
+}
+
+// Returns true if any hook is configured.
+func (c *ConfigstateHooksConfig) Enabled() bool {
+	return len(c.URLs) != 0 || len(c.Commands) != 0
+}
+
+// Returns true if the transitions to the given state run the hooks.
+func (c *ConfigstateHooksConfig) RunsOn(state string) bool {
+	states := c.States
+	if len(states) == 0 {
+		states = []string{"configured", "unconfigured"}
+	}
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ConfigstateHooksConfig) GetTimeoutS() uint64 {
+	if c.TimeoutS == 0 {
+		return ConfigstateHookTimeoutS_DEFAULT
+	}
+	return c.TimeoutS
+}
+
+func (c *ConfigstateHooksConfig) GetRetries() int {
+	if c.Retries == 0 {
+		return ConfigstateHookRetries_DEFAULT
+	} else if c.Retries < 0 {
+		return 0
+	}
+	return c.Retries
+}
+
+// Check the configstate hook settings.
+func (e *ConfigErrors) checkConfigstateHooks(path string, c *ConfigstateHooksConfig) {
+	for i, u := range c.URLs {
+		if u == "" {
+			e.add(fmt.Sprintf("%v.URLs[%v]", path, i), "must not be empty")
+		}
+		e.checkURL(fmt.Sprintf("%v.URLs[%v]", path, i), u)
+	}
+	for i, cmd := range c.Commands {
+		if !filepath.IsAbs(cmd) {
+			e.add(fmt.Sprintf("%v.Commands[%v]", path, i), "%v must be an absolute path", cmd)
+		}
+	}
+	for i, s := range c.States {
+		valid := false
+		for _, state := range configstateHookStates {
+			valid = valid || s == state
+		}
+		if !valid {
+			e.add(fmt.Sprintf("%v.States[%v]", path, i), "%v is not a config state, it must be one of %v", s, configstateHookStates)
+		}
+	}
+	if c.Retries < -1 {
+		e.add(path+".Retries", "%v must be -1 or more", c.Retries)
+	}
+}
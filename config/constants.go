@@ -69,6 +69,65 @@ const ExchangeMessagePollIncrement_DEFAULT = 20
 // The maximum numbers of minutes to wait for workload to start in an agreement
 const EdgeMaxAgreementPrelaunchTimeM_DEFAULT = 10
 
+// The default number of times a failed container image pull is retried.
+const ImagePullRetries_DEFAULT = 3
+
+// The default number of seconds to wait before the first container image pull retry.
+const ImagePullBackoffS_DEFAULT = 15
+
+// The container runtimes that can run service containers.
+const CONTAINER_RUNTIME_DOCKER = "docker"
+const CONTAINER_RUNTIME_PODMAN = "podman"
+
+// The restart policies of dependent service containers.
+const SERVICE_RESTART_POLICY_NO = "no"
+const SERVICE_RESTART_POLICY_ON_FAILURE = "on-failure"
+const SERVICE_RESTART_POLICY_ALWAYS = "always"
+
+// The default number of seconds to wait before restarting a failed service the first time.
+const ServiceRestartBackoffS_DEFAULT = 10
+
+// The default maximum number of seconds to wait between two restarts of a failed service.
+const ServiceRestartMaxBackoffS_DEFAULT = 600
+
+// The default number of previous versions of each service image that are kept when superseded images are pruned.
+const ImageRetentionCount_DEFAULT = 1
+
+// The default prefix length of the subnets allocated to agreement networks from the configured subnet pool.
+const NetworkSubnetPrefixLen_DEFAULT = 24
+
+// The default prefix length of the IPv6 subnets allocated to agreement networks from the configured IPv6 subnet pool.
+const NetworkIPv6SubnetPrefixLen_DEFAULT = 64
+
+// The default number of days before a TLS certificate of the agent API expires that anax starts to warn about it.
+const APICertExpiryWarningDays_DEFAULT = 30
+
+// The default number of the latest requests of the agent API that changed the node that are kept in the audit log.
+const AuditLogMaxEntries_DEFAULT = 1000
+
+// The default number of seconds that the Kubernetes Deployments of a service can take to roll out.
+const KubeRolloutTimeoutS_DEFAULT = 300
+
+// The default number of seconds that the patterns and the resolved services read from the exchange are kept while
+// the node is configured.
+const PatternCacheTTLS_DEFAULT = 60
+
+// The default number of the services of the pattern that are resolved in the exchange at the same time when the node
+// is configured.
+const ServiceResolutionConcurrency_DEFAULT = 5
+
+// The default number of seconds that a change of the config state of the node can take. It is less than the timeout of
+// the agent API client, so that the agent gives up before its caller does.
+const ConfigstateTimeoutS_DEFAULT = 240
+
+// The default number of seconds that the agent waits for the changes of the node configuration that are running when
+// it is stopped.
+const ShutdownGracePeriodS_DEFAULT = 30
+
+// The default number of seconds between the comparisons of the services of the configured node with the ones its
+// pattern requires.
+const ServiceReconcileIntervalS_DEFAULT = 600
+
 // The Default interval at which the agbot verifies that its message key is present in the exchange.
 const AgbotMessageKeyCheck_DEFAULT = 60
 
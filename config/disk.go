@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+)
+
+// The default directory where the container runtime stores its images.
+const ImageStoragePath_DEFAULT = "/var/lib/docker"
+
+// The free space that the agent needs on the partitions of the image storage and of its database. Below MinFreeMB the
+// operations that fill them are refused: image pulls, proposals for new agreements and the autoconfig of the services
+// when the node is configured. Below WarnFreeMB an alert is logged in the event log.
+type DiskConfig struct {
+	ImageStoragePath string `doc:"The directory where the container runtime stores its images, whose partition is checked before images are pulled. The default is /var/lib/docker."`
+	MinFreeMB        uint64 `reload:"live" doc:"The free megabytes below which image pulls, new agreements and the autoconfig of the services are refused. 0 means they are never refused."`
+	WarnFreeMB       uint64 `reload:"live" doc:"The free megabytes below which a critical alert is logged in the event log. 0 means no alert is logged."`
+}
+
+func (d *DiskConfig) String() string {
+	return fmt.Sprintf("ImageStoragePath: %v, MinFreeMB: %v, WarnFreeMB: %v", d.ImageStoragePath, d.MinFreeMB, d.WarnFreeMB)
+}
+
+func (d *DiskConfig) GetImageStoragePath() string {
+	if d.ImageStoragePath == "" {
+		return ImageStoragePath_DEFAULT
+	}
+	return d.ImageStoragePath
+}
+
+// Check the disk settings.
+func (e *ConfigErrors) checkDisk(path string, d *DiskConfig) {
+	if d.WarnFreeMB != 0 && d.WarnFreeMB < d.MinFreeMB {
+		e.add(path+".WarnFreeMB", "must be at least MinFreeMB %v, so that the alert comes before the operations are refused", d.MinFreeMB)
+	}
+}
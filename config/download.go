@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+)
+
+// The limits of the download rate of the agent, shared by all the downloads at a time: the images that are loaded
+// into the container runtime and the objects that the services refer to.
+type DownloadConfig struct {
+	RateLimitKBps       uint64 `reload:"live" doc:"The most kilobytes per second that the downloads of the agent use together. 0 means no limit."`
+	WindowRateLimitKBps uint64 `reload:"live" doc:"The rate limit in kilobytes per second while a maintenance window of the node is open, e.g. to download faster at night. 0 means RateLimitKBps is used in the windows too."`
+}
+
+func (d *DownloadConfig) String() string {
+	return fmt.Sprintf("RateLimitKBps: %v, WindowRateLimitKBps: %v", d.RateLimitKBps, d.WindowRateLimitKBps)
+}
+
+// Returns the download rate limit in bytes per second, 0 for no limit. The limits change while anax is running, they
+// are read from the LiveEdge of the config.
+func (d *DownloadConfig) RateLimit(inWindow bool) int64 {
+	if inWindow && d.WindowRateLimitKBps != 0 {
+		return int64(d.WindowRateLimitKBps) * 1024
+	}
+	return int64(d.RateLimitKBps) * 1024
+}
+
+// Check the download settings.
+func (e *ConfigErrors) checkDownload(path string, d *DownloadConfig) {
+	if d.WindowRateLimitKBps != 0 && d.RateLimitKBps == 0 {
+		e.add(path+".WindowRateLimitKBps", "only applies when RateLimitKBps is set, the downloads are not limited outside of the maintenance windows")
+	}
+}
+
+// Change the download rate limits while anax is running, a nil limit is not changed. The change lasts until anax is
+// restarted or its config is reloaded.
+func (c *HorizonConfig) SetDownloadRateLimits(rate *uint64, windowRate *uint64) error {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	changed := c.LiveEdge().Download
+	if rate != nil {
+		changed.RateLimitKBps = *rate
+	}
+	if windowRate != nil {
+		changed.WindowRateLimitKBps = *windowRate
+	}
+	problems := ConfigErrors{}
+	problems.checkDownload("Edge.Download", &changed)
+	if len(problems) != 0 {
+		return problems
+	}
+
+	liveLock.Lock()
+	c.Edge.Download = changed
+	liveLock.Unlock()
+
+	if c.sources != nil {
+		if rate != nil {
+			c.sources["Edge.Download.RateLimitKBps"] = CONFIG_SOURCE_API
+		}
+		if windowRate != nil {
+			c.sources["Edge.Download.WindowRateLimitKBps"] = CONFIG_SOURCE_API
+		}
+	}
+	glog.Infof("Download rate limits are now %v", changed.String())
+	return nil
+}
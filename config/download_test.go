@@ -0,0 +1,49 @@
+// +build unit
+
+package config
+
+import (
+	"testing"
+)
+
+func Test_DownloadRateLimit(t *testing.T) {
+	d := DownloadConfig{}
+	if r := d.RateLimit(false); r != 0 {
+		t.Errorf("no limit expected, got %v", r)
+	}
+
+	d.RateLimitKBps = 100
+	if r := d.RateLimit(true); r != 100*1024 {
+		t.Errorf("the rate limit applies in the windows without a window limit, got %v", r)
+	}
+
+	d.WindowRateLimitKBps = 1000
+	if r := d.RateLimit(false); r != 100*1024 {
+		t.Errorf("expected %v outside of the windows, got %v", 100*1024, r)
+	} else if r := d.RateLimit(true); r != 1000*1024 {
+		t.Errorf("expected %v in the windows, got %v", 1000*1024, r)
+	}
+}
+
+func Test_SetDownloadRateLimits(t *testing.T) {
+	c := &HorizonConfig{}
+
+	rate, windowRate, zero := uint64(64), uint64(512), uint64(0)
+	if err := c.SetDownloadRateLimits(nil, &windowRate); err == nil {
+		t.Errorf("a window limit without a rate limit should be rejected")
+	} else if c.Edge.Download.WindowRateLimitKBps != 0 {
+		t.Errorf("a rejected change should not be applied, got %v", c.Edge.Download.String())
+	}
+
+	if err := c.SetDownloadRateLimits(&rate, &windowRate); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if c.Edge.Download.RateLimitKBps != rate || c.Edge.Download.WindowRateLimitKBps != windowRate {
+		t.Errorf("the limits were not changed, got %v", c.Edge.Download.String())
+	}
+
+	if err := c.SetDownloadRateLimits(nil, &zero); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if c.Edge.Download.RateLimitKBps != rate || c.Edge.Download.WindowRateLimitKBps != 0 {
+		t.Errorf("only the window limit should have changed, got %v", c.Edge.Download.String())
+	}
+}
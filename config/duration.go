@@ -0,0 +1,196 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The struct tag that gives the time unit of an integer config field that holds a duration, e.g. unit:"s". Such a
+// field can be set to a duration string, e.g. "90s" or "1h30m", or to a bare number in its unit, as before.
+const UNIT_TAG = "unit"
+
+// The time units of the config fields, by the value of their unit tag.
+var durationUnits = map[string]time.Duration{
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+}
+
+var unitNames = map[time.Duration]string{
+	time.Microsecond: "microseconds",
+	time.Millisecond: "milliseconds",
+	time.Second:      "seconds",
+	time.Minute:      "minutes",
+	time.Hour:        "hours",
+	24 * time.Hour:   "days",
+}
+
+// The longest plausible value of the intervals that are used often. A longer value is most likely a number written
+// in the wrong unit, e.g. minutes in a field in seconds. It is not rejected, but it is logged when the config is read.
+var suspiciousDurationLimits = map[string]time.Duration{
+	"Edge.ExchangeHeartbeat":                  time.Hour,
+	"Edge.ExchangeMessagePollInterval":        time.Hour,
+	"Edge.ExchangeMessagePollIncrement":       time.Hour,
+	"Edge.NodeCheckIntervalS":                 time.Hour,
+	"Edge.NodePolicyCheckIntervalS":           time.Hour,
+	"Edge.DefaultHTTPClientTimeoutS":          time.Hour,
+	"Edge.ImagePullBackoffS":                  time.Hour,
+	"Edge.FileSyncService.PollingRate":        time.Hour,
+	"AgreementBot.ExchangeHeartbeat":          time.Hour,
+	"AgreementBot.NewContractIntervalS":       time.Hour,
+	"AgreementBot.ProcessGovernanceIntervalS": time.Hour,
+}
+
+// The shortest plausible value of the intervals that drive a loop. A shorter value, e.g. a heartbeat of 0 seconds, makes
+// the loop run continuously. It is not rejected, but it is logged when the config is read.
+var suspiciousDurationMinimums = map[string]time.Duration{
+	"Edge.ExchangeHeartbeat":           time.Second,
+	"Edge.ExchangeMessagePollInterval": time.Second,
+	"AgreementBot.ExchangeHeartbeat":   time.Second,
+}
+
+// Returns the time unit of a config field, 0 if the field does not hold a duration.
+func fieldUnit(field reflect.StructField) time.Duration {
+	return durationUnits[field.Tag.Get(UNIT_TAG)]
+}
+
+// Convert a duration string to a whole number of the unit. A duration that is not a whole number of the unit is an
+// error rather than rounded, e.g. 500ms for a field in seconds.
+func durationToUnits(value string, unit time.Duration) (int64, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%v is not a duration or a number of %v", value, unitNames[unit])
+	} else if d != 0 && d > -unit && d < unit {
+		return 0, fmt.Errorf("%v is less than %v, the smallest value in %v", value, formatDuration(unit), unitNames[unit])
+	} else if d%unit != 0 {
+		return 0, fmt.Errorf("%v is not a whole number of %v", value, unitNames[unit])
+	}
+	return int64(d / unit), nil
+}
+
+// Returns the duration as a string without the trailing zero units, e.g. 5m rather than 5m0s.
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// Returns the duration held by an integer field in the unit.
+func fieldDuration(v reflect.Value, unit time.Duration) time.Duration {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return time.Duration(v.Uint()) * unit
+	default:
+		return time.Duration(v.Int()) * unit
+	}
+}
+
+// Replace the duration strings in the content of a config file by the number of the unit of their field, so that the
+// content decodes into the integer fields. A duration string that cannot be converted is a problem of the config, it
+// is removed from the content so that the other problems can be reported along with it.
+func convertDurations(content []byte) ([]byte, ConfigErrors, error) {
+	raw := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, nil, err
+	}
+
+	problems := ConfigErrors{}
+	edge := convertSectionDurations("Edge", reflect.TypeOf(Config{}), sectionOf(raw, "Edge"), &problems)
+	agbot := convertSectionDurations("AgreementBot", reflect.TypeOf(AGConfig{}), sectionOf(raw, "AgreementBot"), &problems)
+	if !edge && !agbot {
+		return content, problems, nil
+	}
+
+	content, err := json.Marshal(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, problems, nil
+}
+
+// Returns true if a field of the section was changed.
+func convertSectionDurations(path string, t reflect.Type, raw map[string]interface{}, problems *ConfigErrors) bool {
+	changed := false
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldPath := fmt.Sprintf("%v.%v", path, field.Name)
+
+		if field.Type.Kind() == reflect.Struct {
+			changed = convertSectionDurations(fieldPath, field.Type, sectionOf(raw, field.Name), problems) || changed
+			continue
+		}
+
+		unit := fieldUnit(field)
+		if unit == 0 {
+			continue
+		}
+		value, ok := lookupKey(raw, field.Name)
+		if s, isString := value.(string); ok && isString {
+			if n, err := durationToUnits(s, unit); err != nil {
+				problems.add(fieldPath, "%v", err)
+				deleteKey(raw, field.Name)
+			} else {
+				setKey(raw, field.Name, json.Number(strconv.FormatInt(n, 10)))
+			}
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Log a warning for each interval that is set to an implausibly long or short value. Returns the warnings. The
+// AgreementBot intervals are only checked when the agbot is configured.
+func (c *HorizonConfig) warnSuspiciousDurations() []ConfigProblem {
+	suspicious := ConfigErrors{}
+	check := func(path string, v reflect.Value) {
+		walkDurationFields(path, v, func(fieldPath string, d time.Duration) {
+			if limit, ok := suspiciousDurationLimits[fieldPath]; ok && d > limit {
+				suspicious.add(fieldPath, "%v is longer than %v, check that the value is in the unit of the field or use a duration string, e.g. \"30s\"", formatDuration(d), formatDuration(limit))
+			} else if limit, ok := suspiciousDurationMinimums[fieldPath]; ok && d < limit {
+				suspicious.add(fieldPath, "%v is shorter than %v, the interval would run continuously, set it to at least %v", formatDuration(d), formatDuration(limit), formatDuration(limit))
+			}
+		})
+	}
+	check("Edge", reflect.ValueOf(c.Edge))
+	if c.AgreementBot.ExchangeURL != "" {
+		check("AgreementBot", reflect.ValueOf(c.AgreementBot))
+	}
+
+	sort.Slice(suspicious, func(i, j int) bool { return suspicious[i].Path < suspicious[j].Path })
+	for _, p := range suspicious {
+		glog.Warningf("Config field %v", p)
+	}
+	return suspicious
+}
+
+// Call fn with the duration of each duration field of a config struct, recursing into the nested structs.
+func walkDurationFields(path string, v reflect.Value, fn func(path string, d time.Duration)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldPath := fmt.Sprintf("%v.%v", path, field.Name)
+
+		if field.Type.Kind() == reflect.Struct {
+			walkDurationFields(fieldPath, v.Field(i), fn)
+		} else if unit := fieldUnit(field); unit != 0 {
+			fn(fieldPath, fieldDuration(v.Field(i), unit))
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// +build unit
+
+package config
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func Test_durationToUnits(t *testing.T) {
+
+	tests := []struct {
+		value string
+		unit  time.Duration
+		want  int64
+		ok    bool
+	}{
+		{"30s", time.Second, 30, true},
+		{"5m", time.Second, 300, true},
+		{"1h30m", time.Minute, 90, true},
+		{"0", time.Second, 0, true},
+		{"-10s", time.Second, -10, true},
+		{"720h", 24 * time.Hour, 30, true},
+		{"1500ms", time.Second, 0, false},
+		{"500ms", time.Second, 0, false},
+		{"90s", time.Minute, 0, false},
+		{"often", time.Second, 0, false},
+		{"30", time.Second, 0, false},
+	}
+
+	for _, test := range tests {
+		n, err := durationToUnits(test.value, test.unit)
+		if test.ok && (err != nil || n != test.want) {
+			t.Errorf("%v in %v: expected %v, got %v and error %v", test.value, test.unit, test.want, n, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%v in %v: expected an error, got %v", test.value, test.unit, n)
+		}
+	}
+}
+
+func Test_formatDuration(t *testing.T) {
+
+	tests := map[time.Duration]string{
+		0:                                "0s",
+		90 * time.Second:                 "1m30s",
+		5 * time.Minute:                  "5m",
+		time.Hour:                        "1h",
+		time.Hour + 30*time.Minute:       "1h30m",
+		time.Hour + 30*time.Second:       "1h0m30s",
+		720 * time.Hour:                  "720h",
+		500 * time.Microsecond:           "500µs",
+		2*time.Hour + 5*time.Millisecond: "2h0m0.005s",
+	}
+
+	for d, want := range tests {
+		if got := formatDuration(d); got != want {
+			t.Errorf("%v: expected %v, got %v", int64(d), want, got)
+		} else if parsed, err := time.ParseDuration(got); err != nil || parsed != d {
+			t.Errorf("%v: %v does not parse back, got %v and error %v", int64(d), got, parsed, err)
+		}
+	}
+}
+
+func Test_convertDurations(t *testing.T) {
+
+	content := []byte(`{
+		"Edge": {
+			"ExchangeHeartbeat": "1m",
+			"exchangeversioncheckintervalm": "12h",
+			"ServiceUpgradeCheckIntervalS": 300,
+			"ImagePullBackoffS": "1500ms",
+			"ExchangeURL": "https://exchange/v1/",
+			"FileSyncService": {"PollingRate": "10s"}
+		},
+		"AgreementBot": {
+			"PurgeArchivedAgreementHours": "168h",
+			"NewContractIntervalS": "soon"
+		}
+	}`)
+
+	converted, problems, err := convertDurations(content)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	cfg := HorizonConfig{}
+	if err := json.Unmarshal(converted, &cfg); err != nil {
+		t.Fatalf("the converted content does not decode, error %v\n%s", err, converted)
+	}
+
+	if cfg.Edge.ExchangeHeartbeat != 60 {
+		t.Errorf("ExchangeHeartbeat: expected 60, got %v", cfg.Edge.ExchangeHeartbeat)
+	} else if cfg.Edge.ExchangeVersionCheckIntervalM != 720 {
+		t.Errorf("ExchangeVersionCheckIntervalM: expected 720, got %v", cfg.Edge.ExchangeVersionCheckIntervalM)
+	} else if cfg.Edge.ServiceUpgradeCheckIntervalS != 300 {
+		t.Errorf("ServiceUpgradeCheckIntervalS: expected 300, got %v", cfg.Edge.ServiceUpgradeCheckIntervalS)
+	} else if cfg.Edge.ExchangeURL != "https://exchange/v1/" {
+		t.Errorf("ExchangeURL: expected it unchanged, got %v", cfg.Edge.ExchangeURL)
+	} else if cfg.Edge.FileSyncService.PollingRate != 10 {
+		t.Errorf("FileSyncService.PollingRate: expected 10, got %v", cfg.Edge.FileSyncService.PollingRate)
+	} else if cfg.AgreementBot.PurgeArchivedAgreementHours != 168 {
+		t.Errorf("PurgeArchivedAgreementHours: expected 168, got %v", cfg.AgreementBot.PurgeArchivedAgreementHours)
+	}
+
+	if len(problems) != 2 || problems[0].Path != "Edge.ImagePullBackoffS" || problems[1].Path != "AgreementBot.NewContractIntervalS" {
+		t.Errorf("expected problems with Edge.ImagePullBackoffS and AgreementBot.NewContractIntervalS, got %v", problems)
+	}
+
+	// a config without duration strings is left as it is
+	plain := []byte(`{"Edge": {"ExchangeHeartbeat": 10}}`)
+	if converted, problems, err := convertDurations(plain); err != nil || len(problems) != 0 || string(converted) != string(plain) {
+		t.Errorf("expected the content unchanged, got %s, problems %v and error %v", converted, problems, err)
+	}
+}
+
+func Test_warnSuspiciousDurations(t *testing.T) {
+
+	cfg := HorizonConfig{
+		Edge: Config{
+			ExchangeHeartbeat:            18000,
+			ExchangeMessagePollInterval:  20,
+			ServiceUpgradeCheckIntervalS: 86400,
+		},
+		AgreementBot: AGConfig{
+			ExchangeURL:          "https://exchange.example.com/v1/",
+			ExchangeHeartbeat:    60,
+			NewContractIntervalS: 7200,
+		},
+	}
+
+	suspicious := cfg.warnSuspiciousDurations()
+	if len(suspicious) != 2 || suspicious[0].Path != "AgreementBot.NewContractIntervalS" || suspicious[1].Path != "Edge.ExchangeHeartbeat" {
+		t.Errorf("expected AgreementBot.NewContractIntervalS and Edge.ExchangeHeartbeat to be suspicious, got %v", suspicious)
+	}
+}
+
+func Test_warnSuspiciousDurations_short(t *testing.T) {
+
+	cfg := HorizonConfig{
+		Edge: Config{
+			ExchangeHeartbeat:           0,
+			ExchangeMessagePollInterval: 20,
+		},
+	}
+
+	// the agbot intervals are not checked when the agbot is not configured
+	suspicious := cfg.warnSuspiciousDurations()
+	if len(suspicious) != 1 || suspicious[0].Path != "Edge.ExchangeHeartbeat" {
+		t.Errorf("expected Edge.ExchangeHeartbeat to be suspicious, got %v", suspicious)
+	}
+
+	cfg.Edge.ExchangeHeartbeat = 60
+	cfg.AgreementBot.ExchangeURL = "https://exchange.example.com/v1/"
+	suspicious = cfg.warnSuspiciousDurations()
+	if len(suspicious) != 1 || suspicious[0].Path != "AgreementBot.ExchangeHeartbeat" {
+		t.Errorf("expected AgreementBot.ExchangeHeartbeat to be suspicious, got %v", suspicious)
+	}
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Where the value of a config field came from.
+const CONFIG_SOURCE_DEFAULT = "default"
+const CONFIG_SOURCE_FILE = "file"
+const CONFIG_SOURCE_ENV = "env"
+const CONFIG_SOURCE_API = "api"
+
+// The effective config sections, with the secret fields redacted.
+type EffectiveConfigSections struct {
+	Edge         Config          `json:"Edge"`
+	AgreementBot AGConfig        `json:"AgreementBot"`
+	ArchSynonyms ArchSynonyms    `json:"ArchSynonyms"`
+	Features     map[string]bool `json:"Features"` // all the known features, enabled or not
+}
+
+// The config that anax is running with, which might differ from the config file because of env var overrides and
+// reloads.
+type EffectiveConfig struct {
+	File    string                  `json:"file"`    // the config file that the config was read from
+	Digest  string                  `json:"digest"`  // the sha256 digest of the redacted config, equal on nodes that run the same config
+	Config  EffectiveConfigSections `json:"config"`  // the config, with the secret fields redacted
+	Sources map[string]string       `json:"sources"` // where the value of each field came from, default, file, env or api, by field path
+}
+
+// Returns the effective config. A secret field read from a reference shows the reference, any other secret field
+// that is set is redacted.
+func (c *HorizonConfig) Effective() (*EffectiveConfig, error) {
+
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	shown := c.WithSecretReferences()
+	sections := EffectiveConfigSections{
+		Edge:         shown.Edge,
+		AgreementBot: shown.AgreementBot,
+		ArchSynonyms: shown.ArchSynonyms,
+		Features:     c.EffectiveFeatures(),
+	}
+	redactSecretFields("Edge", reflect.ValueOf(&sections.Edge).Elem(), c.secretRefs)
+	redactSecretFields("AgreementBot", reflect.ValueOf(&sections.AgreementBot).Elem(), c.secretRefs)
+
+	content, err := json.Marshal(sections)
+	if err != nil {
+		return nil, fmt.Errorf("unable to serialize the config, %v", err)
+	}
+
+	sources := make(map[string]string, len(c.sources))
+	for path, source := range c.sources {
+		sources[path] = source
+	}
+
+	return &EffectiveConfig{
+		File:    c.file,
+		Digest:  fmt.Sprintf("sha256:%x", sha256.Sum256(content)),
+		Config:  sections,
+		Sources: sources,
+	}, nil
+}
+
+// Redact the secret fields that are set and were not read from a reference.
+func redactSecretFields(path string, v reflect.Value, refs map[string]string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldPath := fmt.Sprintf("%v.%v", path, field.Name)
+
+		if field.Type.Kind() == reflect.Struct {
+			redactSecretFields(fieldPath, v.Field(i), refs)
+		} else if _, isRef := refs[fieldPath]; isSecretField(field) && !isRef && v.Field(i).String() != "" {
+			v.Field(i).SetString(REDACTED)
+		}
+	}
+}
+
+// Returns where the value of each field of the config came from. A field set by an env var came from env, a field
+// set in the config file came from file, the other fields have their default.
+func configSources(content []byte, overrides []EnvOverride) (map[string]string, error) {
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, err
+	}
+
+	sources := make(map[string]string)
+	fieldSources("Edge", reflect.TypeOf(Config{}), sectionOf(raw, "Edge"), sources)
+	fieldSources("AgreementBot", reflect.TypeOf(AGConfig{}), sectionOf(raw, "AgreementBot"), sources)
+
+	// the feature names are map keys, they are matched exactly.
+	inFile := sectionOf(raw, "Features")
+	for _, f := range KnownFeatures() {
+		if _, ok := inFile[f.Name]; ok {
+			sources[featurePath(f.Name)] = CONFIG_SOURCE_FILE
+		} else {
+			sources[featurePath(f.Name)] = CONFIG_SOURCE_DEFAULT
+		}
+	}
+
+	for _, path := range legacyEnvOverrides() {
+		sources[path] = CONFIG_SOURCE_ENV
+	}
+	for _, o := range overrides {
+		sources[o.Field] = CONFIG_SOURCE_ENV
+	}
+	return sources, nil
+}
+
+func fieldSources(path string, t reflect.Type, raw map[string]interface{}, sources map[string]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldPath := fmt.Sprintf("%v.%v", path, field.Name)
+
+		if field.Type.Kind() == reflect.Struct {
+			fieldSources(fieldPath, field.Type, sectionOf(raw, field.Name), sources)
+		} else if _, inFile := lookupKey(raw, field.Name); inFile {
+			sources[fieldPath] = CONFIG_SOURCE_FILE
+		} else {
+			sources[fieldPath] = CONFIG_SOURCE_DEFAULT
+		}
+	}
+}
+
+// The JSON decoder matches the keys of the config file to the field names without regard to case, so does this.
+func lookupKey(raw map[string]interface{}, name string) (interface{}, bool) {
+	if value, ok := raw[name]; ok {
+		return value, true
+	}
+	for key, value := range raw {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+func sectionOf(raw map[string]interface{}, name string) map[string]interface{} {
+	if value, ok := lookupKey(raw, name); ok {
+		if section, ok := value.(map[string]interface{}); ok {
+			return section
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// Returns the fields that are set by the env vars that predate the HZN_CONFIG_ env vars.
+func legacyEnvOverrides() []string {
+	paths := make([]string, 0)
+	if os.Getenv(ExchangeURLEnvvarName) != "" {
+		paths = append(paths, "Edge.ExchangeURL", "AgreementBot.ExchangeURL")
+	}
+	if os.Getenv(FileSyncServiceCSSURLEnvvarName) != "" {
+		paths = append(paths, "Edge.FileSyncService.CSSURL")
+	}
+	if os.Getenv(ExchangeMessageNoDynamicPollEnvvarName) != "" {
+		paths = append(paths, "Edge.ExchangeMessageDynamicPoll")
+	}
+	if os.Getenv(AnaxAPIPort) != "" {
+		paths = append(paths, "Edge.APIListen")
+	}
+	return paths
+}
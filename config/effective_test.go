@@ -0,0 +1,77 @@
+// +build unit
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_Effective(t *testing.T) {
+
+	f, err := ioutil.TempFile("", "anax-config-")
+	if err != nil {
+		t.Fatalf("Failed to create config file, error %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	content := `{"Edge": {"exchangeurl": "http://exchange/v1/", "Network": {"MTU": 1400}}, "AgreementBot": {"ExchangeToken": "mytoken", "DefaultWorkloadPW": "env://TEST_WORKLOAD_PW"}}`
+	if err := ioutil.WriteFile(f.Name(), []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file, error %v", err)
+	}
+
+	os.Setenv("TEST_WORKLOAD_PW", "workloadpw")
+	defer os.Unsetenv("TEST_WORKLOAD_PW")
+	os.Setenv("HZN_CONFIG_EDGE_IMAGEPULLRETRIES", "7")
+	defer os.Unsetenv("HZN_CONFIG_EDGE_IMAGEPULLRETRIES")
+
+	cfg, err := Read(f.Name())
+	if err != nil {
+		t.Fatalf("Failed to read config file, error %v", err)
+	}
+
+	effective, err := cfg.Effective()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if effective.File != f.Name() {
+		t.Errorf("wrong file %v", effective.File)
+	} else if effective.Config.Edge.ImagePullRetries != 7 || effective.Config.Edge.Network.MTU != 1400 {
+		t.Errorf("wrong effective config %v", effective.Config.Edge)
+	} else if effective.Config.AgreementBot.ExchangeToken != REDACTED {
+		t.Errorf("the secret is not redacted, got %v", effective.Config.AgreementBot.ExchangeToken)
+	} else if effective.Config.AgreementBot.DefaultWorkloadPW != "env://TEST_WORKLOAD_PW" {
+		t.Errorf("the secret reference is not shown, got %v", effective.Config.AgreementBot.DefaultWorkloadPW)
+	} else if cfg.AgreementBot.ExchangeToken != "mytoken" {
+		t.Errorf("the running config was redacted")
+	} else if !strings.HasPrefix(effective.Digest, "sha256:") {
+		t.Errorf("wrong digest %v", effective.Digest)
+	}
+
+	expectedSources := map[string]string{
+		"Edge.ExchangeURL":               CONFIG_SOURCE_FILE,
+		"Edge.Network.MTU":               CONFIG_SOURCE_FILE,
+		"Edge.Network.SubnetPool":        CONFIG_SOURCE_DEFAULT,
+		"Edge.ImagePullRetries":          CONFIG_SOURCE_ENV,
+		"Edge.DBPath":                    CONFIG_SOURCE_DEFAULT,
+		"AgreementBot.ExchangeToken":     CONFIG_SOURCE_FILE,
+		"AgreementBot.DefaultWorkloadPW": CONFIG_SOURCE_FILE,
+	}
+	for path, source := range expectedSources {
+		if effective.Sources[path] != source {
+			t.Errorf("expected source %v for %v, got %v", source, path, effective.Sources[path])
+		}
+	}
+
+	// the same config has the same digest, a different one does not
+	if again, err := cfg.Effective(); err != nil || again.Digest != effective.Digest {
+		t.Errorf("the digest changed without a config change: %v %v", again, err)
+	}
+	cfg.Edge.ImagePullRetries = 8
+	if changed, err := cfg.Effective(); err != nil || changed.Digest == effective.Digest {
+		t.Errorf("the digest did not change with the config: %v %v", changed, err)
+	}
+}
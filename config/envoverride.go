@@ -0,0 +1,182 @@
+package config
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The prefix of the env vars that override the fields of the config file. The name of the env var is the prefix
+// followed by the section and the field name in upper case, separated by underscores, e.g. HZN_CONFIG_EDGE_EXCHANGEURL
+// for Edge.ExchangeURL or HZN_CONFIG_EDGE_FILESYNCSERVICE_CSSURL for Edge.FileSyncService.CSSURL.
+const ConfigOverrideEnvvarPrefix = "HZN_CONFIG_"
+
+// The value that is logged in place of the value of a secret field.
+const REDACTED = "********"
+
+// A config field that was overridden by an env var.
+type EnvOverride struct {
+	Envvar string // the name of the env var
+	Field  string // the path of the field, e.g. Edge.ExchangeURL
+	Value  string // the value of the env var, redacted for a secret field
+}
+
+func (e EnvOverride) String() string {
+	return fmt.Sprintf("%v=%v (%v)", e.Field, e.Value, e.Envvar)
+}
+
+// Set the config fields that have a HZN_CONFIG_ env var to the value of the env var. The env vars are applied after
+// the config file is read, so they take precedence over the file and the defaults. A value that cannot be converted
+// to the type of the field is an error that names the env var.
+func applyEnvOverrides(config *HorizonConfig) ([]EnvOverride, error) {
+
+	overrides := make([]EnvOverride, 0)
+	known := make(map[string]bool)
+
+	sections := []struct {
+		name  string
+		value reflect.Value
+	}{
+		{"Edge", reflect.ValueOf(&config.Edge).Elem()},
+		{"AgreementBot", reflect.ValueOf(&config.AgreementBot).Elem()},
+	}
+
+	for _, s := range sections {
+		if err := overrideFields(s.name, ConfigOverrideEnvvarPrefix+strings.ToUpper(s.name), s.value, known, &overrides); err != nil {
+			return nil, err
+		}
+	}
+
+	// A misspelled env var would otherwise be silently ignored.
+	for _, env := range os.Environ() {
+		name := strings.SplitN(env, "=", 2)[0]
+		if strings.HasPrefix(name, ConfigOverrideEnvvarPrefix) && !known[name] {
+			glog.Warningf("Env var %v does not match any config field, it is ignored.", name)
+		}
+	}
+
+	return overrides, nil
+}
+
+// Override the fields of a config struct, recursing into the nested structs.
+func overrideFields(path string, envPrefix string, v reflect.Value, known map[string]bool, overrides *[]EnvOverride) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		fieldPath := fmt.Sprintf("%v.%v", path, field.Name)
+		envvar := fmt.Sprintf("%v_%v", envPrefix, strings.ToUpper(field.Name))
+
+		if field.Type.Kind() == reflect.Struct {
+			if err := overrideFields(fieldPath, envvar, v.Field(i), known, overrides); err != nil {
+				return err
+			}
+			continue
+		}
+
+		known[envvar] = true
+		value, ok := os.LookupEnv(envvar)
+		if !ok {
+			continue
+		}
+
+		if err := setField(v.Field(i), fieldUnit(field), value); err != nil {
+			return fmt.Errorf("env var %v: unable to set %v, %v", envvar, fieldPath, err)
+		}
+
+		logged := value
+		if isSecretField(field) {
+			logged = REDACTED
+		}
+		*overrides = append(*overrides, EnvOverride{Envvar: envvar, Field: fieldPath, Value: logged})
+	}
+	return nil
+}
+
+// Convert the env var value to the type of the field. A list is a comma separated value. An integer field with a time
+// unit (see UNIT_TAG) also accepts a duration, e.g. 90s or 10m.
+func setField(f reflect.Value, unit time.Duration, value string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%v is not a boolean", value)
+		}
+		f.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := parseInteger(unit, value)
+		if err != nil {
+			return err
+		} else if f.OverflowInt(n) {
+			return fmt.Errorf("%v is out of range", value)
+		}
+		f.SetInt(n)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := parseInteger(unit, value)
+		if err != nil {
+			return err
+		} else if n < 0 || f.OverflowUint(uint64(n)) {
+			return fmt.Errorf("%v is out of range", value)
+		}
+		f.SetUint(uint64(n))
+
+	case reflect.Float32, reflect.Float64:
+		x, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%v is not a number", value)
+		}
+		f.SetFloat(x)
+
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("fields of type %v cannot be set from an env var", f.Type())
+		}
+		list := make([]string, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		f.Set(reflect.ValueOf(list))
+
+	default:
+		return fmt.Errorf("fields of type %v cannot be set from an env var", f.Type())
+	}
+	return nil
+}
+
+// Parse an integer, or a duration converted to the time unit of the field.
+func parseInteger(unit time.Duration, value string) (int64, error) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return n, nil
+	} else if unit == 0 {
+		return 0, fmt.Errorf("%v is not an integer", value)
+	}
+	return durationToUnits(value, unit)
+}
+
+// Log the config fields that were overridden by env vars.
+func logEnvOverrides(overrides []EnvOverride) {
+	if len(overrides) == 0 {
+		return
+	}
+	fields := make([]string, 0, len(overrides))
+	for _, o := range overrides {
+		fields = append(fields, o.String())
+	}
+	sort.Strings(fields)
+	glog.Infof("Config fields overridden by env vars: %v", strings.Join(fields, ", "))
+}
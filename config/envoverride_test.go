@@ -0,0 +1,100 @@
+// +build unit
+
+package config
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_applyEnvOverrides(t *testing.T) {
+
+	tests := []struct {
+		envvar   string
+		value    string
+		expected func(c *HorizonConfig) interface{}
+		want     interface{}
+	}{
+		{"HZN_CONFIG_EDGE_EXCHANGEURL", "http://exchange/v1/", func(c *HorizonConfig) interface{} { return c.Edge.ExchangeURL }, "http://exchange/v1/"},
+		{"HZN_CONFIG_EDGE_DBPATH", "", func(c *HorizonConfig) interface{} { return c.Edge.DBPath }, ""},
+		{"HZN_CONFIG_EDGE_EXCHANGEHEARTBEAT", "45", func(c *HorizonConfig) interface{} { return c.Edge.ExchangeHeartbeat }, 45},
+		{"HZN_CONFIG_EDGE_EXCHANGEHEARTBEAT", "2m", func(c *HorizonConfig) interface{} { return c.Edge.ExchangeHeartbeat }, 120},
+		{"HZN_CONFIG_EDGE_APICERTEXPIRYWARNINGDAYS", "336h", func(c *HorizonConfig) interface{} { return c.Edge.APICertExpiryWarningDays }, 14},
+		{"HZN_CONFIG_EDGE_DEFAULTHTTPCLIENTTIMEOUTS", "30", func(c *HorizonConfig) interface{} { return c.Edge.DefaultHTTPClientTimeoutS }, uint(30)},
+		{"HZN_CONFIG_EDGE_FILESYNCSERVICE_POLLINGRATE", "5", func(c *HorizonConfig) interface{} { return c.Edge.FileSyncService.PollingRate }, uint16(5)},
+		{"HZN_CONFIG_EDGE_REPORTDEVICESTATUS", "true", func(c *HorizonConfig) interface{} { return c.Edge.ReportDeviceStatus }, true},
+		{"HZN_CONFIG_EDGE_EXCHANGEMESSAGEDYNAMICPOLL", "0", func(c *HorizonConfig) interface{} { return c.Edge.ExchangeMessageDynamicPoll }, false},
+		{"HZN_CONFIG_EDGE_AGREEMENTTIMEOUTSCALEFACTOR", "1.5", func(c *HorizonConfig) interface{} { return c.Edge.AgreementTimeoutScaleFactor }, 1.5},
+		{"HZN_CONFIG_EDGE_SERVICEUPGRADECHECKINTERVALS", "5m", func(c *HorizonConfig) interface{} { return c.Edge.ServiceUpgradeCheckIntervalS }, int64(300)},
+		{"HZN_CONFIG_EDGE_MAXAGREEMENTPRELAUNCHTIMEM", "2h", func(c *HorizonConfig) interface{} { return c.Edge.MaxAgreementPrelaunchTimeM }, int64(120)},
+		{"HZN_CONFIG_AGREEMENTBOT_PURGEARCHIVEDAGREEMENTHOURS", "48h", func(c *HorizonConfig) interface{} { return c.AgreementBot.PurgeArchivedAgreementHours }, 48},
+		{"HZN_CONFIG_EDGE_DEVICEALLOWLIST", "/dev/ttyUSB*, /dev/gpiomem", func(c *HorizonConfig) interface{} { return c.Edge.DeviceAllowList }, []string{"/dev/ttyUSB*", "/dev/gpiomem"}},
+		{"HZN_CONFIG_EDGE_NODECONTEXTENVVARSOMIT", "", func(c *HorizonConfig) interface{} { return c.Edge.NodeContextEnvvarsOmit }, []string{}},
+		{"HZN_CONFIG_AGREEMENTBOT_POSTGRESQL_PASSWORD", "abc", func(c *HorizonConfig) interface{} { return c.AgreementBot.Postgresql.Password }, "abc"},
+	}
+
+	for _, test := range tests {
+		config := HorizonConfig{Edge: Config{DBPath: "/var/horizon", DeviceAllowList: []string{"/dev/video0"}, ExchangeMessageDynamicPoll: true}}
+
+		os.Setenv(test.envvar, test.value)
+		overrides, err := applyEnvOverrides(&config)
+		os.Unsetenv(test.envvar)
+
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", test.envvar, err)
+		} else if got := test.expected(&config); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: expected %v (%T), got %v (%T)", test.envvar, test.want, test.want, got, got)
+		} else if len(overrides) != 1 || overrides[0].Envvar != test.envvar {
+			t.Errorf("%v: wrong overrides %v", test.envvar, overrides)
+		}
+	}
+}
+
+func Test_applyEnvOverrides_redacted(t *testing.T) {
+
+	config := HorizonConfig{}
+
+	os.Setenv("HZN_CONFIG_AGREEMENTBOT_EXCHANGETOKEN", "mytoken")
+	defer os.Unsetenv("HZN_CONFIG_AGREEMENTBOT_EXCHANGETOKEN")
+
+	if overrides, err := applyEnvOverrides(&config); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if config.AgreementBot.ExchangeToken != "mytoken" {
+		t.Errorf("the token was not overridden")
+	} else if len(overrides) != 1 || overrides[0].Value != REDACTED || overrides[0].Field != "AgreementBot.ExchangeToken" {
+		t.Errorf("the token was not redacted %v", overrides)
+	}
+}
+
+func Test_applyEnvOverrides_errors(t *testing.T) {
+
+	tests := []struct {
+		envvar string
+		value  string
+	}{
+		{"HZN_CONFIG_EDGE_EXCHANGEHEARTBEAT", "often"},
+		{"HZN_CONFIG_EDGE_EXCHANGEHEARTBEAT", "500ms"},
+		{"HZN_CONFIG_EDGE_REPORTDEVICESTATUS", "maybe"},
+		{"HZN_CONFIG_EDGE_DEFAULTHTTPCLIENTTIMEOUTS", "-1"},
+		{"HZN_CONFIG_EDGE_FILESYNCSERVICE_POLLINGRATE", "70000"},
+		{"HZN_CONFIG_EDGE_SERVICEUPGRADECHECKINTERVALS", "1500ms"},
+		{"HZN_CONFIG_EDGE_DEFAULTSERVICEREGISTRATIONRAM", "10m"},
+		{"HZN_CONFIG_EDGE_AGREEMENTTIMEOUTSCALEFACTOR", "high"},
+	}
+
+	for _, test := range tests {
+		config := HorizonConfig{}
+
+		os.Setenv(test.envvar, test.value)
+		_, err := applyEnvOverrides(&config)
+		os.Unsetenv(test.envvar)
+
+		if err == nil {
+			t.Errorf("%v=%v: expected an error", test.envvar, test.value)
+		} else if !strings.Contains(err.Error(), test.envvar) {
+			t.Errorf("%v=%v: the error does not name the env var: %v", test.envvar, test.value, err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+)
+
+// The default number of attempts of an exchange call that fails with an error that may go away.
+const ExchangeRetryAttempts_DEFAULT = 4
+
+// The default number of seconds after which a failing exchange call is not retried anymore.
+const ExchangeRetryMaxElapsedS_DEFAULT = 30
+
+// How the exchange calls made while the config state of the node is changed are retried when they fail with an error
+// that may go away, e.g. a 502 from a proxy or a timeout. The wait between the attempts doubles on each retry.
+type ExchangeRetryConfig struct {
+	Attempts    int    `reload:"live" doc:"The number of attempts of an exchange call, including the first one. The default is 4, 1 means the calls are not retried."`
+	MaxElapsedS uint64 `reload:"live" unit:"s" doc:"The number of seconds after which a failing exchange call is not retried anymore, whatever the number of attempts left. The default is 30 seconds."`
+}
+
+func (c *ExchangeRetryConfig) String() string {
+	return fmt.Sprintf("Attempts: %v, MaxElapsedS: %v", c.Attempts, c.MaxElapsedS)
+}
+
+func (c *ExchangeRetryConfig) GetAttempts() int {
+	if c.Attempts == 0 {
+		return ExchangeRetryAttempts_DEFAULT
+	}
+	return c.Attempts
+}
+
+func (c *ExchangeRetryConfig) GetMaxElapsedS() uint64 {
+	if c.MaxElapsedS == 0 {
+		return ExchangeRetryMaxElapsedS_DEFAULT
+	}
+	return c.MaxElapsedS
+}
+
+// Check the exchange retry settings.
+func (e *ConfigErrors) checkExchangeRetry(path string, c *ExchangeRetryConfig) {
+	e.nonNegative(path+".Attempts", int64(c.Attempts))
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"sort"
+	"sync"
+)
+
+// The experimental behaviors of anax that are enabled per node in the Features section of the config. A code path
+// that is gated by a feature checks it with FeatureEnabled.
+const FEATURE_PODMAN_RUNTIME = "podman_runtime"
+
+// A feature known to anax. A dynamic feature can be turned on and off while anax is running, the others are read at
+// startup and take effect on restart.
+type Feature struct {
+	Name        string `json:"name"`
+	Default     bool   `json:"default"`
+	Dynamic     bool   `json:"dynamic"`
+	Description string `json:"description"`
+}
+
+// The registry of the known features.
+var features = map[string]Feature{
+	FEATURE_PODMAN_RUNTIME: Feature{
+		Name:        FEATURE_PODMAN_RUNTIME,
+		Default:     false,
+		Dynamic:     false,
+		Description: "Allows Edge.ContainerRuntime to be set to podman, to run the service containers through the Podman Docker compatible API.",
+	},
+}
+
+// The state of a known feature on this node.
+type FeatureState struct {
+	Feature
+	Enabled bool `json:"enabled"`
+}
+
+// Serializes the changes to the features made while anax is running with the code paths that check them.
+var featureLock sync.RWMutex
+
+// Returns the known features, sorted by name.
+func KnownFeatures() []Feature {
+	known := make([]Feature, 0, len(features))
+	for _, f := range features {
+		known = append(known, f)
+	}
+	sort.Slice(known, func(i, j int) bool { return known[i].Name < known[j].Name })
+	return known
+}
+
+// Returns true if the feature is enabled on this node, either in the config or by default. An unknown feature is
+// never enabled.
+func (c *HorizonConfig) FeatureEnabled(name string) bool {
+	f, ok := features[name]
+	if !ok {
+		glog.Errorf("Feature %v is not known, it is not enabled.", name)
+		return false
+	}
+
+	featureLock.RLock()
+	defer featureLock.RUnlock()
+	if enabled, ok := c.Features[name]; ok {
+		return enabled
+	}
+	return f.Default
+}
+
+// Returns the state of all the known features on this node.
+func (c *HorizonConfig) FeatureStates() []FeatureState {
+	states := make([]FeatureState, 0, len(features))
+	for _, f := range KnownFeatures() {
+		states = append(states, FeatureState{Feature: f, Enabled: c.FeatureEnabled(f.Name)})
+	}
+	return states
+}
+
+// Returns whether each known feature is enabled on this node, by name.
+func (c *HorizonConfig) EffectiveFeatures() map[string]bool {
+	effective := make(map[string]bool, len(features))
+	for _, f := range KnownFeatures() {
+		effective[f.Name] = c.FeatureEnabled(f.Name)
+	}
+	return effective
+}
+
+// Turn dynamic features on or off while anax is running. The changes last until anax is restarted or the config is
+// reloaded. Nothing is changed if any of the features is unknown or not dynamic.
+func (c *HorizonConfig) SetFeatures(changes map[string]bool) error {
+	for name := range changes {
+		if f, ok := features[name]; !ok {
+			return fmt.Errorf("feature %v is not known", name)
+		} else if !f.Dynamic {
+			return fmt.Errorf("feature %v cannot be changed while anax is running, set it in the config file and restart anax", name)
+		}
+	}
+
+	featureLock.Lock()
+	defer featureLock.Unlock()
+	if c.Features == nil {
+		c.Features = make(map[string]bool)
+	}
+	for name, enabled := range changes {
+		c.Features[name] = enabled
+		if c.sources != nil {
+			c.sources[featurePath(name)] = CONFIG_SOURCE_API
+		}
+		glog.Infof("Feature %v is now %v", name, featureStateString(enabled))
+	}
+	return nil
+}
+
+// Log a warning for each feature in the config that anax does not know, a misspelled feature would otherwise be
+// silently ignored. Returns the unknown features.
+func (c *HorizonConfig) warnUnknownFeatures() []string {
+	unknown := make([]string, 0)
+	for name := range c.Features {
+		if _, ok := features[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+
+	for _, name := range unknown {
+		glog.Warningf("Feature %v in the config is not known, it is ignored.", name)
+	}
+	return unknown
+}
+
+// Apply the changes to the dynamic features from the latest config, the changes to the other features are only
+// reported.
+func (c *HorizonConfig) reloadFeatures(latest map[string]bool, result *ReloadResult) {
+	featureLock.Lock()
+	defer featureLock.Unlock()
+
+	for _, f := range KnownFeatures() {
+		current, inCurrent := c.Features[f.Name]
+		next, inLatest := latest[f.Name]
+		if inCurrent == inLatest && current == next {
+			continue
+		}
+
+		if f.Dynamic {
+			if c.Features == nil {
+				c.Features = make(map[string]bool)
+			}
+			if inLatest {
+				c.Features[f.Name] = next
+			} else {
+				delete(c.Features, f.Name)
+			}
+			result.Applied = append(result.Applied, featurePath(f.Name))
+		} else {
+			result.RestartRequired = append(result.RestartRequired, featurePath(f.Name))
+		}
+	}
+}
+
+// Returns the path of a feature in the config, e.g. Features.podman_runtime.
+func featurePath(name string) string {
+	return fmt.Sprintf("Features.%v", name)
+}
+
+func featureStateString(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+	return "disabled"
+}
@@ -0,0 +1,94 @@
+// +build unit
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_Features(t *testing.T) {
+
+	// a dynamic feature for the test, none of the known features is dynamic yet
+	features["test_dynamic"] = Feature{Name: "test_dynamic", Default: true, Dynamic: true, Description: "test"}
+	defer delete(features, "test_dynamic")
+
+	f, err := ioutil.TempFile("", "anax-config-")
+	if err != nil {
+		t.Fatalf("Failed to create config file, error %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	writeConfig := func(content string) {
+		if err := ioutil.WriteFile(f.Name(), []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write config file, error %v", err)
+		}
+	}
+
+	writeConfig(`{"Edge": {"ExchangeURL": "http://exchange/v1/"}, "Features": {"podman_runtime": true, "not_a_feature": true}}`)
+	cfg, err := Read(f.Name())
+	if err != nil {
+		t.Fatalf("Failed to read config file, error %v", err)
+	}
+
+	if !cfg.FeatureEnabled(FEATURE_PODMAN_RUNTIME) || !cfg.FeatureEnabled("test_dynamic") {
+		t.Errorf("expected the features to be enabled, got %v", cfg.EffectiveFeatures())
+	} else if cfg.FeatureEnabled("not_a_feature") {
+		t.Errorf("an unknown feature is enabled")
+	} else if unknown := cfg.warnUnknownFeatures(); len(unknown) != 1 || unknown[0] != "not_a_feature" {
+		t.Errorf("wrong unknown features %v", unknown)
+	}
+
+	if effective, err := cfg.Effective(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if len(effective.Config.Features) != 2 || !effective.Config.Features[FEATURE_PODMAN_RUNTIME] {
+		t.Errorf("wrong effective features %v", effective.Config.Features)
+	} else if effective.Sources["Features.podman_runtime"] != CONFIG_SOURCE_FILE || effective.Sources["Features.test_dynamic"] != CONFIG_SOURCE_DEFAULT {
+		t.Errorf("wrong feature sources %v", effective.Sources)
+	}
+
+	// only the dynamic features can be changed while anax is running
+	if err := cfg.SetFeatures(map[string]bool{"test_dynamic": false, FEATURE_PODMAN_RUNTIME: false}); err == nil {
+		t.Errorf("expected an error for a feature that is not dynamic")
+	} else if !cfg.FeatureEnabled(FEATURE_PODMAN_RUNTIME) || !cfg.FeatureEnabled("test_dynamic") {
+		t.Errorf("the features were changed by a rejected request")
+	}
+	if err := cfg.SetFeatures(map[string]bool{"not_a_feature": false}); err == nil {
+		t.Errorf("expected an error for an unknown feature")
+	}
+	if err := cfg.SetFeatures(map[string]bool{"test_dynamic": false}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if cfg.FeatureEnabled("test_dynamic") {
+		t.Errorf("the dynamic feature was not turned off")
+	} else if effective, _ := cfg.Effective(); effective.Sources["Features.test_dynamic"] != CONFIG_SOURCE_API {
+		t.Errorf("wrong feature source %v", effective.Sources["Features.test_dynamic"])
+	}
+
+	// a reload applies the dynamic features and reports the others
+	writeConfig(`{"Edge": {"ExchangeURL": "http://exchange/v1/"}, "Features": {"test_dynamic": true}}`)
+	if result, err := cfg.Reload(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if len(result.Applied) != 1 || result.Applied[0] != "Features.test_dynamic" {
+		t.Errorf("wrong applied fields %v", result.Applied)
+	} else if len(result.RestartRequired) != 1 || result.RestartRequired[0] != "Features.podman_runtime" {
+		t.Errorf("wrong restart required fields %v", result.RestartRequired)
+	} else if !cfg.FeatureEnabled("test_dynamic") || !cfg.FeatureEnabled(FEATURE_PODMAN_RUNTIME) {
+		t.Errorf("wrong features after reload %v", cfg.EffectiveFeatures())
+	}
+}
+
+func Test_PodmanRequiresFeature(t *testing.T) {
+	cfg := newDefaultConfig()
+	cfg.setZeroDefaults()
+	cfg.Edge.ContainerRuntime = CONTAINER_RUNTIME_PODMAN
+
+	if problems := cfg.checkValues(); len(problems) != 1 || problems[0].Path != "Edge.ContainerRuntime" {
+		t.Errorf("expected a problem with Edge.ContainerRuntime, got %v", problems)
+	}
+
+	cfg.Features = map[string]bool{FEATURE_PODMAN_RUNTIME: true}
+	if problems := cfg.checkValues(); len(problems) != 0 {
+		t.Errorf("unexpected problems %v", problems)
+	}
+}
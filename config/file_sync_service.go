@@ -10,14 +10,14 @@ import (
 
 // Configuration for the File Sync Service, which is implemented by the embedded ESS.
 type FSSConfig struct {
-	APIListen          string // The address on which the ESS will listen. The default is in the code below. For a unix domain socket path, it must be the full path name including the file name.
-	APIPort            uint16 // The port on which the ESS will listen. For a unix domain socket, this will always be "0".
-	APIProtocol        string // Can be 'unix' or 'https'. Default is unix. The value of this field determines the Listen and Port values.
-	PersistencePath    string // The absolute location in the host filesystem where anax stores files retrieved by the file sync service.
-	AuthenticationPath string // The absolute location in the host filesystem where anax stores authentication credentials for services so that the service can authenticate to the FSS (ESS) API.
-	CSSURL             string // The URL used to access the CSS.
-	CSSSSLCert         string // The path to the client side SSL certificate for the CSS.
-	PollingRate        uint16 // The number of seconds between polls to the CSS for notification updates.
+	APIListen          string `doc:"The address on which the ESS will listen. The default is in the code below. For a unix domain socket path, it must be the full path name including the file name."`
+	APIPort            uint16 `doc:"The port on which the ESS will listen. For a unix domain socket, this will always be \"0\"."`
+	APIProtocol        string `doc:"Can be 'unix' or 'https'. Default is unix. The value of this field determines the Listen and Port values."`
+	PersistencePath    string `doc:"The absolute location in the host filesystem where anax stores files retrieved by the file sync service."`
+	AuthenticationPath string `doc:"The absolute location in the host filesystem where anax stores authentication credentials for services so that the service can authenticate to the FSS (ESS) API."`
+	CSSURL             string `doc:"The URL used to access the CSS."`
+	CSSSSLCert         string `doc:"The path to the client side SSL certificate for the CSS."`
+	PollingRate        uint16 `unit:"s" doc:"The number of seconds between polls to the CSS for notification updates."`
 }
 
 func (f *FSSConfig) String() string {
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// The struct tag that describes a config field. Every exported field of the config structs has one, "-" leaves the
+// field out of the generated config.
+const DOC_TAG = "doc"
+
+// The formats of the generated config.
+const GENERATE_FORMAT_JSON = "json"
+const GENERATE_FORMAT_YAML = "yaml"
+
+// The prefix of the keys that hold the field descriptions in the generated JSON config. Anax ignores these keys, so
+// the generated file can be used as is.
+const JSON_DOC_KEY_PREFIX = "#"
+
+// Returns a config document with all the fields set to their defaults and described by their doc tags, in JSON or
+// YAML. The document is built by reflection over the config structs, so a new field shows up in it as soon as it is
+// added.
+func GenerateDefaultConfig(format string) ([]byte, error) {
+	cfg := newDefaultConfig()
+	cfg.setZeroDefaults()
+	cfg.ArchSynonyms = NewArchSynonyms()
+	cfg.Features = cfg.EffectiveFeatures()
+
+	var buf bytes.Buffer
+	switch format {
+	case GENERATE_FORMAT_JSON:
+		if err := generateJSON(&buf, reflect.ValueOf(cfg), 1); err != nil {
+			return nil, err
+		}
+	case GENERATE_FORMAT_YAML:
+		if err := generateYAML(&buf, reflect.ValueOf(cfg), 0); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("config format %v is not supported, it must be %v or %v", format, GENERATE_FORMAT_JSON, GENERATE_FORMAT_YAML)
+	}
+	return buf.Bytes(), nil
+}
+
+// Returns the fields of a config struct that are part of the generated config.
+func generatedFields(t reflect.Type) []reflect.StructField {
+	fields := make([]reflect.StructField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.PkgPath == "" && f.Tag.Get(DOC_TAG) != "-" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func generateJSON(buf *bytes.Buffer, v reflect.Value, depth int) error {
+	indent := strings.Repeat("  ", depth)
+	fields := generatedFields(v.Type())
+
+	buf.WriteString("{\n")
+	for i, f := range fields {
+		doc, err := json.Marshal(f.Tag.Get(DOC_TAG))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(buf, "%v\"%v%v\": %s,\n%v\"%v\": ", indent, JSON_DOC_KEY_PREFIX, f.Name, doc, indent, f.Name)
+
+		if f.Type.Kind() == reflect.Struct {
+			if err := generateJSON(buf, v.FieldByIndex(f.Index), depth+1); err != nil {
+				return err
+			}
+		} else if value, err := marshalField(f, v.FieldByIndex(f.Index)); err != nil {
+			return fmt.Errorf("unable to generate the value of %v, %v", f.Name, err)
+		} else {
+			buf.Write(value)
+		}
+
+		if i < len(fields)-1 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n")
+	}
+	fmt.Fprintf(buf, "%v}", strings.Repeat("  ", depth-1))
+	if depth == 1 {
+		buf.WriteString("\n")
+	}
+	return nil
+}
+
+// A JSON value is also a YAML flow value, so the values are written as JSON.
+func generateYAML(buf *bytes.Buffer, v reflect.Value, depth int) error {
+	indent := strings.Repeat("  ", depth)
+
+	for _, f := range generatedFields(v.Type()) {
+		fmt.Fprintf(buf, "%v# %v\n", indent, f.Tag.Get(DOC_TAG))
+
+		if f.Type.Kind() == reflect.Struct {
+			fmt.Fprintf(buf, "%v%v:\n", indent, f.Name)
+			if err := generateYAML(buf, v.FieldByIndex(f.Index), depth+1); err != nil {
+				return err
+			}
+		} else if value, err := marshalField(f, v.FieldByIndex(f.Index)); err != nil {
+			return fmt.Errorf("unable to generate the value of %v, %v", f.Name, err)
+		} else {
+			fmt.Fprintf(buf, "%v%v: %s\n", indent, f.Name, value)
+		}
+	}
+	return nil
+}
+
+// A field with a time unit is written as a duration string, e.g. "5m" rather than 300.
+func marshalField(f reflect.StructField, v reflect.Value) ([]byte, error) {
+	if unit := fieldUnit(f); unit != 0 {
+		return json.Marshal(formatDuration(fieldDuration(v, unit)))
+	}
+	return marshalValue(v)
+}
+
+// Lists and maps that are not set are written as empty rather than null, to show their type.
+func marshalValue(v reflect.Value) ([]byte, error) {
+	if v.Kind() == reflect.Slice && v.IsNil() {
+		return []byte("[]"), nil
+	} else if v.Kind() == reflect.Map && v.IsNil() {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(v.Interface())
+}
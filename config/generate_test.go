@@ -0,0 +1,126 @@
+// +build unit
+
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Every field of the config structs must be described, so that it is documented in the generated config.
+func Test_DocTags(t *testing.T) {
+
+	var check func(path string, typ reflect.Type)
+	check = func(path string, typ reflect.Type) {
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			doc, ok := f.Tag.Lookup(DOC_TAG)
+			if !ok || doc == "" {
+				t.Errorf("config field %v.%v has no %v tag", path, f.Name, DOC_TAG)
+			} else if doc != "-" && f.Type.Kind() == reflect.Struct {
+				check(path+"."+f.Name, f.Type)
+			}
+		}
+	}
+
+	check("HorizonConfig", reflect.TypeOf(HorizonConfig{}))
+}
+
+func Test_GenerateDefaultConfig_json(t *testing.T) {
+
+	out, err := GenerateDefaultConfig(GENERATE_FORMAT_JSON)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// the generated config is a usable config file with the defaults, the durations are converted as by Read
+	converted, problems, err := convertDurations(out)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("the durations of the generated config do not convert, error %v, problems %v\n%s", err, problems, out)
+	}
+	generated := HorizonConfig{}
+	if err := json.Unmarshal(converted, &generated); err != nil {
+		t.Fatalf("the generated config is not valid JSON, error %v\n%s", err, out)
+	}
+
+	expected := newDefaultConfig()
+	expected.setZeroDefaults()
+	expected.ArchSynonyms = NewArchSynonyms()
+	if !reflect.DeepEqual(generated.Edge.FileSyncService, expected.Edge.FileSyncService) ||
+		generated.Edge.ImagePullRetries != expected.Edge.ImagePullRetries ||
+		generated.Edge.ServiceUpgradeCheckIntervalS != expected.Edge.ServiceUpgradeCheckIntervalS ||
+		generated.AgreementBot.MaxExchangeChanges != expected.AgreementBot.MaxExchangeChanges ||
+		!reflect.DeepEqual(generated.ArchSynonyms, expected.ArchSynonyms) {
+		t.Errorf("the generated config does not have the defaults\n%s", out)
+	}
+
+	// every field is in the generated config, with its description
+	doc := make(map[string]interface{})
+	if err := json.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("unable to decode the generated config, error %v", err)
+	}
+
+	var check func(path string, typ reflect.Type, section map[string]interface{})
+	check = func(path string, typ reflect.Type, section map[string]interface{}) {
+		for _, f := range generatedFields(typ) {
+			if section[JSON_DOC_KEY_PREFIX+f.Name] != f.Tag.Get(DOC_TAG) {
+				t.Errorf("the generated config has no description of %v.%v", path, f.Name)
+			}
+			value, ok := section[f.Name]
+			if !ok {
+				t.Errorf("the generated config has no %v.%v", path, f.Name)
+			} else if f.Type.Kind() == reflect.Struct {
+				check(path+"."+f.Name, f.Type, value.(map[string]interface{}))
+			}
+		}
+	}
+
+	check("HorizonConfig", reflect.TypeOf(HorizonConfig{}), doc)
+
+	// the durations are written as duration strings
+	if interval := doc["Edge"].(map[string]interface{})["ServiceUpgradeCheckIntervalS"]; interval != "5m" {
+		t.Errorf("expected Edge.ServiceUpgradeCheckIntervalS to be 5m, got %v", interval)
+	}
+
+	if _, ok := doc["Collaborators"]; ok {
+		t.Errorf("the generated config must not have the Collaborators")
+	}
+}
+
+func Test_GenerateDefaultConfig_yaml(t *testing.T) {
+
+	out, err := GenerateDefaultConfig(GENERATE_FORMAT_YAML)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	lines := strings.Split(string(out), "\n")
+	expected := []string{
+		"# The configuration of the edge node side of anax.",
+		"Edge:",
+		"  ImagePullRetries: 3",
+		"  DeviceAllowList: []",
+		"  FileSyncService:",
+		"    CSSURL: \"\"",
+		"AgreementBot:",
+		"  PolicySearchOrder: true",
+	}
+	for _, e := range expected {
+		found := false
+		for _, l := range lines {
+			found = found || l == e
+		}
+		if !found {
+			t.Errorf("the generated YAML config has no line %q\n%s", e, out)
+		}
+	}
+
+	if _, err := GenerateDefaultConfig("xml"); err == nil {
+		t.Errorf("expected an error for an unsupported format")
+	}
+}
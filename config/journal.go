@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+const JournalIdentifier_DEFAULT = "anax"
+const JournalSocket_DEFAULT = "/run/systemd/journal/socket"
+
+// The syslog priorities of the event log severities, unless they are configured.
+var JournalPriorities_DEFAULT = map[string]string{
+	"info":    "info",
+	"warning": "warning",
+	"error":   "err",
+	"fatal":   "crit",
+}
+
+// The syslog priorities by name, as used by journald and syslog.
+var syslogPriorities = map[string]int{
+	"emerg":   0,
+	"alert":   1,
+	"crit":    2,
+	"err":     3,
+	"warning": 4,
+	"notice":  5,
+	"info":    6,
+	"debug":   7,
+}
+
+// The severities of the event log.
+var eventSeverities = []string{"info", "warning", "error", "fatal"}
+
+// The events of the event log that are forwarded to the local journal, for the operators that read the logs of the
+// host with journalctl rather than the agent's own logs.
+type JournalConfig struct {
+	EventCodes []string          `reload:"live" doc:"The codes of the events that are forwarded, e.g. agreement_reached. A code that ends with * matches the codes that start with it, e.g. error_*. Empty means the events are only forwarded by severity."`
+	Severities []string          `reload:"live" doc:"The severities (info, warning, error or fatal) of the events that are forwarded whatever their code, e.g. fatal for the critical conditions."`
+	Priorities map[string]string `reload:"live" doc:"The syslog priority (emerg, alert, crit, err, warning, notice, info or debug) of the events of each severity. The default is info, warning, err and crit for info, warning, error and fatal."`
+	Identifier string            `doc:"The SYSLOG_IDENTIFIER of the journal entries. The default is anax."`
+	Socket     string            `doc:"The journald socket. When journald is not running, the events are written to the local syslog instead, with their fields in the message. The default is /run/systemd/journal/socket."`
+}
+
+func (j *JournalConfig) String() string {
+	return fmt.Sprintf("EventCodes: %v, Severities: %v, Priorities: %v, Identifier: %v, Socket: %v",
+		j.EventCodes, j.Severities, j.Priorities, j.Identifier, j.Socket)
+}
+
+// Returns true if some events are forwarded.
+func (j *JournalConfig) Enabled() bool {
+	return len(j.EventCodes) != 0 || len(j.Severities) != 0
+}
+
+// Returns true if an event with the code and severity is forwarded.
+func (j *JournalConfig) Forwards(code string, severity string) bool {
+	for _, s := range j.Severities {
+		if s == severity {
+			return true
+		}
+	}
+	for _, c := range j.EventCodes {
+		if c == code || (strings.HasSuffix(c, "*") && strings.HasPrefix(code, strings.TrimSuffix(c, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the syslog priority of the events of the severity, info for an unknown severity.
+func (j *JournalConfig) Priority(severity string) int {
+	name, ok := j.Priorities[severity]
+	if !ok {
+		name = JournalPriorities_DEFAULT[severity]
+	}
+	if p, ok := syslogPriorities[name]; ok {
+		return p
+	}
+	return syslogPriorities["info"]
+}
+
+func (j *JournalConfig) GetIdentifier() string {
+	if j.Identifier == "" {
+		return JournalIdentifier_DEFAULT
+	}
+	return j.Identifier
+}
+
+func (j *JournalConfig) GetSocket() string {
+	if j.Socket == "" {
+		return JournalSocket_DEFAULT
+	}
+	return j.Socket
+}
+
+func isEventSeverity(severity string) bool {
+	for _, s := range eventSeverities {
+		if s == severity {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the journal settings.
+func (e *ConfigErrors) checkJournal(path string, j *JournalConfig) {
+	for _, c := range j.EventCodes {
+		if c == "" || strings.Contains(strings.TrimSuffix(c, "*"), "*") {
+			e.add(path+".EventCodes", "%v is not an event code, only a trailing * is allowed", c)
+		}
+	}
+	for _, s := range j.Severities {
+		if !isEventSeverity(s) {
+			e.add(path+".Severities", "%v is not a severity, it must be one of %v", s, strings.Join(eventSeverities, ", "))
+		}
+	}
+	for s, p := range j.Priorities {
+		if !isEventSeverity(s) {
+			e.add(path+".Priorities", "%v is not a severity, it must be one of %v", s, strings.Join(eventSeverities, ", "))
+		} else if _, ok := syslogPriorities[p]; !ok {
+			e.add(path+".Priorities", "%v is not a syslog priority, it must be emerg, alert, crit, err, warning, notice, info or debug", p)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// +build unit
+
+package config
+
+import (
+	"testing"
+)
+
+func Test_JournalForwards(t *testing.T) {
+
+	j := JournalConfig{EventCodes: []string{"agreement_reached", "error_*"}, Severities: []string{"fatal"}}
+
+	tests := []struct {
+		code     string
+		severity string
+		expected bool
+	}{
+		{"agreement_reached", "info", true},
+		{"agreement_canceled", "info", false},
+		{"error_start_container", "error", true},
+		{"database_error", "error", false},
+		{"database_error", "fatal", true},
+	}
+
+	for _, test := range tests {
+		if f := j.Forwards(test.code, test.severity); f != test.expected {
+			t.Errorf("event %v with severity %v should be forwarded %v, got %v", test.code, test.severity, test.expected, f)
+		}
+	}
+
+	if (&JournalConfig{}).Enabled() {
+		t.Errorf("the journal should be disabled when nothing is forwarded")
+	}
+}
+
+func Test_JournalPriority(t *testing.T) {
+
+	j := JournalConfig{Priorities: map[string]string{"warning": "notice"}}
+
+	if p := j.Priority("warning"); p != 5 {
+		t.Errorf("expected the configured priority 5 for warning, got %v", p)
+	} else if p := j.Priority("fatal"); p != 2 {
+		t.Errorf("expected the default priority 2 for fatal, got %v", p)
+	} else if p := j.Priority("unknown"); p != 6 {
+		t.Errorf("expected priority 6 for an unknown severity, got %v", p)
+	}
+}
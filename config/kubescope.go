@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// An amount of cpu and memory, in the units of the cluster deployment config of a service.
+type KubeResources struct {
+	CPUs     float64 `doc:"The number of cpus, e.g. 0.5. 0 means no cpu is set."`
+	MemoryMb int64   `doc:"The memory in MB. 0 means no memory is set."`
+}
+
+func (k *KubeResources) String() string {
+	return fmt.Sprintf("CPUs: %v, MemoryMb: %v", k.CPUs, k.MemoryMb)
+}
+
+// Where the workloads of a cluster node are deployed and how much of the cluster they can use. The cluster admin sets
+// it, the deployment configs of the services cannot go beyond it.
+type KubeScopeConfig struct {
+	Namespaces       []string      `doc:"The namespaces that the workloads can be deployed to. They must exist, anax does not create them. Workloads that do not choose a namespace are deployed to the first one. Empty means any namespace, and the namespace of anax for the workloads that do not choose one."`
+	DefaultRequests  KubeResources `doc:"The resource requests of the workload containers. A request is lowered to the limit of the container when it is higher."`
+	DefaultLimits    KubeResources `doc:"The resource limits of the workload containers whose deployment config does not set them."`
+	ImagePullSecrets []string      `doc:"The image pull secrets attached to the pods of the workloads. They must exist in the namespaces of the workloads."`
+	Quota            KubeResources `doc:"The most cpus and memory that the limits of all the replicas of a workload can add up to. The agreements for workloads that need more, or that have a service without a limit when there is no default limit, are rejected. 0 means no quota."`
+}
+
+func (k *KubeScopeConfig) String() string {
+	return fmt.Sprintf("Namespaces: %v, DefaultRequests: {%v}, DefaultLimits: {%v}, ImagePullSecrets: %v, Quota: {%v}",
+		k.Namespaces, k.DefaultRequests.String(), k.DefaultLimits.String(), k.ImagePullSecrets, k.Quota.String())
+}
+
+// Returns the namespace of a workload that does not choose one, empty for the namespace of anax.
+func (k *KubeScopeConfig) DefaultNamespace() string {
+	if len(k.Namespaces) == 0 {
+		return ""
+	}
+	return k.Namespaces[0]
+}
+
+// Returns true if workloads can be deployed to the namespace.
+func (k *KubeScopeConfig) AllowsNamespace(namespace string) bool {
+	if len(k.Namespaces) == 0 {
+		return true
+	}
+	for _, ns := range k.Namespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the resources that are above the quota, empty if none is.
+func (k *KubeScopeConfig) ExceedsQuota(cpus float64, memoryMb int64) string {
+	if k.Quota.CPUs != 0 && cpus > k.Quota.CPUs {
+		return fmt.Sprintf("%v cpus are above the quota of %v cpus", cpus, k.Quota.CPUs)
+	} else if k.Quota.MemoryMb != 0 && memoryMb > k.Quota.MemoryMb {
+		return fmt.Sprintf("%v MB of memory are above the quota of %v MB", memoryMb, k.Quota.MemoryMb)
+	}
+	return ""
+}
+
+// Namespaces and secrets are named by DNS labels and subdomains.
+var kubeNamespaceRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+var kubeSecretRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+// Check the Kubernetes scope settings.
+func (e *ConfigErrors) checkKubeScope(path string, k *KubeScopeConfig) {
+	for _, ns := range k.Namespaces {
+		if len(ns) > 63 || !kubeNamespaceRegex.MatchString(ns) {
+			e.add(path+".Namespaces", "%v is not a Kubernetes namespace, it must be lower case letters, digits and '-'", ns)
+		}
+	}
+	for _, secret := range k.ImagePullSecrets {
+		if len(secret) > 253 || !kubeSecretRegex.MatchString(secret) {
+			e.add(path+".ImagePullSecrets", "%v is not a Kubernetes secret name", secret)
+		}
+	}
+
+	e.checkKubeResources(path+".DefaultRequests", &k.DefaultRequests)
+	e.checkKubeResources(path+".DefaultLimits", &k.DefaultLimits)
+	e.checkKubeResources(path+".Quota", &k.Quota)
+	if k.Quota.CPUs != 0 && k.DefaultLimits.CPUs > k.Quota.CPUs {
+		e.add(path+".DefaultLimits.CPUs", "%v is above the quota of %v cpus, no workload could use it", k.DefaultLimits.CPUs, k.Quota.CPUs)
+	}
+	if k.Quota.MemoryMb != 0 && k.DefaultLimits.MemoryMb > k.Quota.MemoryMb {
+		e.add(path+".DefaultLimits.MemoryMb", "%v is above the quota of %v MB, no workload could use it", k.DefaultLimits.MemoryMb, k.Quota.MemoryMb)
+	}
+}
+
+func (e *ConfigErrors) checkKubeResources(path string, k *KubeResources) {
+	if k.CPUs < 0 {
+		e.add(path+".CPUs", "must not be negative")
+	}
+	if k.MemoryMb < 0 {
+		e.add(path+".MemoryMb", "must not be negative")
+	}
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"net"
+)
+
+// Configuration for the docker networks that anax creates for agreements and services.
+type NetworkConfig struct {
+	SubnetPool      string `doc:"The CIDR (e.g. 172.30.0.0/16) from which a subnet is allocated for each new network. Empty means docker chooses the subnet."`
+	SubnetPrefixLen int    `doc:"The prefix length of each subnet allocated from the SubnetPool. The default is 24."`
+	MTU             int    `doc:"The MTU of the network. 0 means the docker default."`
+	DisableICC      bool   `doc:"If true, inter-container communication on the network is turned off. The default is to enable it."`
+	EnableIPv6      bool   `doc:"If true, IPv6 is enabled on the network."`
+
+	IPv6SubnetPool      string `doc:"The IPv6 CIDR, e.g. a unique local prefix like fd00:6a78::/48, from which an IPv6 subnet is allocated for each new network when EnableIPv6 is set. Empty means the docker daemon must provide the IPv6 subnets."`
+	IPv6SubnetPrefixLen int    `doc:"The prefix length of each subnet allocated from the IPv6SubnetPool. The default is 64."`
+}
+
+func (n *NetworkConfig) String() string {
+	return fmt.Sprintf("SubnetPool: %v, SubnetPrefixLen: %v, MTU: %v, DisableICC: %v, EnableIPv6: %v, IPv6SubnetPool: %v, IPv6SubnetPrefixLen: %v", n.SubnetPool, n.SubnetPrefixLen, n.MTU, n.DisableICC, n.EnableIPv6, n.IPv6SubnetPool, n.IPv6SubnetPrefixLen)
+}
+
+// Returns the prefix length of the subnets allocated from the IPv6SubnetPool.
+func (n *NetworkConfig) GetIPv6SubnetPrefixLen() int {
+	if n.IPv6SubnetPrefixLen == 0 {
+		return NetworkIPv6SubnetPrefixLen_DEFAULT
+	}
+	return n.IPv6SubnetPrefixLen
+}
+
+func (c *HorizonConfig) GetNetworkSubnetPrefixLen() int {
+	if c.Edge.Network.SubnetPrefixLen == 0 {
+		return NetworkSubnetPrefixLen_DEFAULT
+	}
+	return c.Edge.Network.SubnetPrefixLen
+}
+
+// Verify that the network configuration is usable.
+func (n *NetworkConfig) Validate() error {
+	if n.SubnetPool != "" {
+		_, pool, err := net.ParseCIDR(n.SubnetPool)
+		if err != nil {
+			return fmt.Errorf("Network SubnetPool %v is not a valid CIDR: %v", n.SubnetPool, err)
+		} else if pool.IP.To4() == nil {
+			return fmt.Errorf("Network SubnetPool %v is not an IPv4 CIDR, use IPv6SubnetPool for IPv6", n.SubnetPool)
+		}
+		poolLen, bits := pool.Mask.Size()
+		prefixLen := n.SubnetPrefixLen
+		if prefixLen == 0 {
+			prefixLen = NetworkSubnetPrefixLen_DEFAULT
+		}
+		if prefixLen < poolLen || prefixLen > bits {
+			return fmt.Errorf("Network SubnetPrefixLen %v must be between %v and %v for SubnetPool %v", prefixLen, poolLen, bits, n.SubnetPool)
+		}
+	}
+	if n.IPv6SubnetPool != "" {
+		_, pool, err := net.ParseCIDR(n.IPv6SubnetPool)
+		if err != nil {
+			return fmt.Errorf("Network IPv6SubnetPool %v is not a valid CIDR: %v", n.IPv6SubnetPool, err)
+		} else if pool.IP.To4() != nil {
+			return fmt.Errorf("Network IPv6SubnetPool %v is not an IPv6 CIDR", n.IPv6SubnetPool)
+		} else if !n.EnableIPv6 {
+			return fmt.Errorf("Network IPv6SubnetPool %v requires EnableIPv6", n.IPv6SubnetPool)
+		}
+		poolLen, bits := pool.Mask.Size()
+		if prefixLen := n.GetIPv6SubnetPrefixLen(); prefixLen < poolLen || prefixLen > bits {
+			return fmt.Errorf("Network IPv6SubnetPrefixLen %v must be between %v and %v for IPv6SubnetPool %v", prefixLen, poolLen, bits, n.IPv6SubnetPool)
+		}
+	}
+	if n.MTU < 0 {
+		return fmt.Errorf("Network MTU %v must not be negative", n.MTU)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+// +build unit
+
+package config
+
+import (
+	"testing"
+)
+
+func Test_NetworkConfig_Validate(t *testing.T) {
+
+	nc := NetworkConfig{}
+	if err := nc.Validate(); err != nil {
+		t.Errorf("empty network config should be valid, error %v", err)
+	}
+
+	nc = NetworkConfig{SubnetPool: "172.30.0.0/16", MTU: 1400}
+	if err := nc.Validate(); err != nil {
+		t.Errorf("network config %v should be valid, error %v", nc.String(), err)
+	}
+
+	nc = NetworkConfig{SubnetPool: "172.30.0.0/16", SubnetPrefixLen: 12}
+	if err := nc.Validate(); err == nil {
+		t.Errorf("network config %v should be invalid, prefix length is shorter than the pool", nc.String())
+	}
+
+	nc = NetworkConfig{SubnetPool: "not-a-cidr"}
+	if err := nc.Validate(); err == nil {
+		t.Errorf("network config %v should be invalid, pool is not a CIDR", nc.String())
+	}
+
+	nc = NetworkConfig{SubnetPool: "fd00:6a78::/48"}
+	if err := nc.Validate(); err == nil {
+		t.Errorf("network config %v should be invalid, the pool is IPv6", nc.String())
+	}
+
+	nc = NetworkConfig{EnableIPv6: true, IPv6SubnetPool: "fd00:6a78::/48"}
+	if err := nc.Validate(); err != nil {
+		t.Errorf("network config %v should be valid, error %v", nc.String(), err)
+	}
+
+	nc = NetworkConfig{IPv6SubnetPool: "fd00:6a78::/48"}
+	if err := nc.Validate(); err == nil {
+		t.Errorf("network config %v should be invalid, IPv6 is not enabled", nc.String())
+	}
+
+	nc = NetworkConfig{EnableIPv6: true, IPv6SubnetPool: "172.30.0.0/16"}
+	if err := nc.Validate(); err == nil {
+		t.Errorf("network config %v should be invalid, the IPv6 pool is IPv4", nc.String())
+	}
+
+	nc = NetworkConfig{EnableIPv6: true, IPv6SubnetPool: "fd00:6a78::/64", IPv6SubnetPrefixLen: 56}
+	if err := nc.Validate(); err == nil {
+		t.Errorf("network config %v should be invalid, prefix length is shorter than the IPv6 pool", nc.String())
+	}
+
+	nc = NetworkConfig{MTU: -1}
+	if err := nc.Validate(); err == nil {
+		t.Errorf("network config %v should be invalid, MTU is negative", nc.String())
+	}
+}
+
+func Test_GetNetworkSubnetPrefixLen(t *testing.T) {
+
+	hc := HorizonConfig{}
+	if hc.GetNetworkSubnetPrefixLen() != NetworkSubnetPrefixLen_DEFAULT {
+		t.Errorf("expected default prefix length, got %v", hc.GetNetworkSubnetPrefixLen())
+	}
+
+	hc.Edge.Network.SubnetPrefixLen = 26
+	if hc.GetNetworkSubnetPrefixLen() != 26 {
+		t.Errorf("expected prefix length 26, got %v", hc.GetNetworkSubnetPrefixLen())
+	}
+}
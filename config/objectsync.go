@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+// The defaults of the object sync settings.
+const ObjectSyncMaxCacheMb_DEFAULT = 1024
+const ObjectSyncPollIntervalS_DEFAULT = 300
+const ObjectSyncDownloadTimeoutS_DEFAULT = 600
+
+// The relative path of the object cache. This path should be combined with the HZN_VAR_BASE_DEFAULT.
+const HZN_OBJECTS_PATH = "objects"
+
+// The directory that the objects of an agreement are mounted on in its containers.
+const HZN_OBJECTS_MOUNT = "/horizon/objects"
+
+// The object service that the objects referred to by the deployment configs of the services are downloaded from,
+// e.g. model files that are updated independently of the container images.
+type ObjectSyncConfig struct {
+	URL              string `doc:"The URL of the object service, e.g. https://objects.example.com/api/v1. Services that refer to objects can only be started when it is set."`
+	CacheDir         string `doc:"The directory that the downloaded objects are cached in. The default is the objects directory in HZN_VAR_BASE."`
+	MaxCacheMb       uint64 `reload:"live" doc:"The number of megabytes that the cached objects can use. The objects that no agreement uses are removed, oldest first, when the cache is bigger. The default is 1024."`
+	PollIntervalS    uint64 `unit:"s" doc:"The number of seconds between checks for new versions of the objects that have no fixed version. The default is 300 seconds."`
+	DownloadTimeoutS uint   `unit:"s" doc:"The number of seconds a download of an object can take. The default is 600 seconds."`
+	RequireSignature bool   `reload:"live" doc:"Only use objects that are signed by one of the keys that deployment signatures are verified with."`
+}
+
+func (o *ObjectSyncConfig) String() string {
+	return fmt.Sprintf("URL: %v, CacheDir: %v, MaxCacheMb: %v, PollIntervalS: %v, DownloadTimeoutS: %v, RequireSignature: %v",
+		o.URL, o.CacheDir, o.MaxCacheMb, o.PollIntervalS, o.DownloadTimeoutS, o.RequireSignature)
+}
+
+func (o *ObjectSyncConfig) GetCacheDir() string {
+	if o.CacheDir == "" {
+		return path.Join(getDefaultBase(), HZN_OBJECTS_PATH)
+	}
+	return o.CacheDir
+}
+
+func (o *ObjectSyncConfig) GetMaxCacheBytes() int64 {
+	if o.MaxCacheMb == 0 {
+		return ObjectSyncMaxCacheMb_DEFAULT * 1024 * 1024
+	}
+	return int64(o.MaxCacheMb) * 1024 * 1024
+}
+
+func (o *ObjectSyncConfig) GetPollInterval() time.Duration {
+	if o.PollIntervalS == 0 {
+		return ObjectSyncPollIntervalS_DEFAULT * time.Second
+	}
+	return time.Duration(o.PollIntervalS) * time.Second
+}
+
+func (o *ObjectSyncConfig) GetDownloadTimeoutS() uint {
+	if o.DownloadTimeoutS == 0 {
+		return ObjectSyncDownloadTimeoutS_DEFAULT
+	}
+	return o.DownloadTimeoutS
+}
+
+// Check the object sync settings, they are only checked when the object service URL is set.
+func (e *ConfigErrors) checkObjectSync(path string, o *ObjectSyncConfig) {
+	if o.URL == "" {
+		return
+	}
+	e.checkURL(path+".URL", o.URL)
+}
@@ -5,13 +5,13 @@ import (
 )
 
 type PostgresqlConfig struct {
-	Host               string
-	Port               string
-	User               string
-	Password           string
-	DBName             string
-	SSLMode            string
-	MaxOpenConnections int
+	Host               string `doc:"The host of the Postgresql server."`
+	Port               string `doc:"The port of the Postgresql server."`
+	User               string `doc:"The user that the agreement bot connects to Postgresql as."`
+	Password           string `secret:"true" doc:"The password of the Postgresql user. It can be a file:// or env:// reference to the password."`
+	DBName             string `doc:"The name of the Postgresql database."`
+	SSLMode            string `doc:"The Postgresql sslmode of the connection. The default is to require SSL."`
+	MaxOpenConnections int    `doc:"The maximum number of open connections to Postgresql."`
 }
 
 func (p PostgresqlConfig) MakeConnectionString() (string, string) {
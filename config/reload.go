@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"reflect"
+	"sync"
+)
+
+// The struct tag that marks a configuration field as safe to change while anax is running, i.e. the field is read
+// through LiveEdge each time it is used rather than captured at startup. A change to any other field only takes effect
+// on restart. A section without the tag, e.g. Edge.Download, has its own live fields.
+const RELOAD_TAG = "reload"
+const RELOAD_LIVE = "live"
+
+// The outcome of a configuration reload. The changed fields are listed by their path in the config file,
+// e.g. Edge.ImagePullRetries.
+type ReloadResult struct {
+	Applied         []string `json:"applied"`          // the changed fields that are now in effect
+	RestartRequired []string `json:"restart_required"` // the changed fields that take effect when anax is restarted
+}
+
+func (r ReloadResult) String() string {
+	return fmt.Sprintf("Applied: %v, RestartRequired: %v", r.Applied, r.RestartRequired)
+}
+
+// Serializes the reloads, SIGHUP and the API can trigger one at the same time.
+var reloadLock sync.Mutex
+
+// Guards the live fields of the config. They are only written with it held, by a reload or a change through the API,
+// the code that runs while they can change reads them through LiveEdge.
+var liveLock sync.RWMutex
+
+// Returns a copy of the Edge section of the config as it is now, with the live fields of the last reload. The slices
+// and maps of the copy are never changed, a reload replaces them.
+func (c *HorizonConfig) LiveEdge() Config {
+	liveLock.RLock()
+	defer liveLock.RUnlock()
+	return c.Edge
+}
+
+// Re-read the config file that this config was read from and apply the changed fields that are safe to change
+// while anax is running. The new config file is validated as a whole, if it is invalid nothing is applied and the
+// current config stays in effect.
+func (c *HorizonConfig) Reload() (*ReloadResult, error) {
+
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	if c.file == "" {
+		return nil, fmt.Errorf("the config was not read from a file, there is nothing to reload")
+	}
+
+	newConfig, err := Read(c.file)
+	if err == nil {
+		if problems := newConfig.checkValues(); len(problems) != 0 {
+			err = problems
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("the config file %v is invalid, the current config stays in effect. %v", c.file, err)
+	}
+
+	result := &ReloadResult{Applied: []string{}, RestartRequired: []string{}}
+	liveLock.Lock()
+	reloadFields("Edge", reflect.ValueOf(&c.Edge).Elem(), reflect.ValueOf(&newConfig.Edge).Elem(), result)
+	reloadFields("AgreementBot", reflect.ValueOf(&c.AgreementBot).Elem(), reflect.ValueOf(&newConfig.AgreementBot).Elem(), result)
+
+	// The HTTP clients are created by a factory that was built at startup, the factory is told about the new timeout.
+	if c.Collaborators.HTTPClientFactory != nil {
+		c.Collaborators.HTTPClientFactory.DefaultTimeoutS = c.Edge.DefaultHTTPClientTimeoutS
+	}
+	liveLock.Unlock()
+	c.reloadFeatures(newConfig.Features, result)
+
+	// The applied fields now have the value from the new config file or env vars.
+	for _, f := range result.Applied {
+		if c.sources != nil {
+			c.sources[f] = newConfig.sources[f]
+		}
+		glog.Infof("Config reload: applied the new value of %v", f)
+	}
+	for _, f := range result.RestartRequired {
+		glog.Warningf("Config reload: the new value of %v takes effect when anax is restarted", f)
+	}
+	if len(result.Applied) == 0 && len(result.RestartRequired) == 0 {
+		glog.Infof("Config reload: no changes in %v", c.file)
+	}
+
+	return result, nil
+}
+
+// Compare the fields of the current and the new config section. The changed fields tagged as live are copied into
+// the current config, the other changed fields are only reported. The sections within the section that have live
+// fields are compared field by field. Called with liveLock held.
+func reloadFields(section string, current reflect.Value, latest reflect.Value, result *ReloadResult) {
+	t := current.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if reflect.DeepEqual(current.Field(i).Interface(), latest.Field(i).Interface()) {
+			continue
+		}
+
+		name := fmt.Sprintf("%v.%v", section, field.Name)
+		if field.Tag.Get(RELOAD_TAG) == "" && field.Type.Kind() == reflect.Struct && hasLiveFields(field.Type) {
+			reloadFields(name, current.Field(i), latest.Field(i), result)
+		} else if field.Tag.Get(RELOAD_TAG) == RELOAD_LIVE {
+			current.Field(i).Set(latest.Field(i))
+			result.Applied = append(result.Applied, name)
+		} else {
+			result.RestartRequired = append(result.RestartRequired, name)
+		}
+	}
+}
+
+func hasLiveFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get(RELOAD_TAG) == RELOAD_LIVE {
+			return true
+		}
+	}
+	return false
+}
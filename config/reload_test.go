@@ -0,0 +1,84 @@
+// +build unit
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_Reload(t *testing.T) {
+
+	f, err := ioutil.TempFile("", "anax-config-")
+	if err != nil {
+		t.Fatalf("Failed to create config file, error %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	writeConfig := func(content string) {
+		if err := ioutil.WriteFile(f.Name(), []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write config file, error %v", err)
+		}
+	}
+
+	writeConfig(`{"Edge": {"ExchangeURL": "http://exchange/v1/", "ImagePullRetries": 3, "DefaultHTTPClientTimeoutS": 20}}`)
+	cfg, err := Read(f.Name())
+	if err != nil {
+		t.Fatalf("Failed to read config file, error %v", err)
+	}
+
+	// nothing changed
+	if result, err := cfg.Reload(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if len(result.Applied) != 0 || len(result.RestartRequired) != 0 {
+		t.Errorf("expected no changes, got %v", result)
+	}
+
+	// a live field is applied, the other one is only reported
+	writeConfig(`{"Edge": {"ExchangeURL": "http://other/v1/", "ImagePullRetries": 5, "DefaultHTTPClientTimeoutS": 40}}`)
+	if result, err := cfg.Reload(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if len(result.Applied) != 2 || result.Applied[0] != "Edge.DefaultHTTPClientTimeoutS" || result.Applied[1] != "Edge.ImagePullRetries" {
+		t.Errorf("wrong applied fields %v", result.Applied)
+	} else if len(result.RestartRequired) != 1 || result.RestartRequired[0] != "Edge.ExchangeURL" {
+		t.Errorf("wrong restart required fields %v", result.RestartRequired)
+	} else if cfg.Edge.ImagePullRetries != 5 || cfg.Edge.ExchangeURL != "http://exchange/v1/" {
+		t.Errorf("wrong config after reload %v", cfg.Edge)
+	} else if cfg.Collaborators.HTTPClientFactory.DefaultTimeoutS != 40 {
+		t.Errorf("the HTTP client timeout was not updated, it is %v", cfg.Collaborators.HTTPClientFactory.DefaultTimeoutS)
+	}
+
+	// the live fields of a section are applied one by one, while the config is read
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			_ = cfg.LiveEdge().Download.RateLimitKBps
+		}
+		done <- true
+	}()
+	writeConfig(`{"Edge": {"ExchangeURL": "http://other/v1/", "ImagePullRetries": 5, "DefaultHTTPClientTimeoutS": 40, "ObjectSync": {"URL": "http://objects/v1", "MaxCacheMb": 10}, "Download": {"RateLimitKBps": 100}}}`)
+	if result, err := cfg.Reload(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if len(result.Applied) != 2 || result.Applied[0] != "Edge.ObjectSync.MaxCacheMb" || result.Applied[1] != "Edge.Download.RateLimitKBps" {
+		t.Errorf("wrong applied fields %v", result.Applied)
+	} else if len(result.RestartRequired) != 2 || result.RestartRequired[0] != "Edge.ExchangeURL" || result.RestartRequired[1] != "Edge.ObjectSync.URL" {
+		t.Errorf("wrong restart required fields %v", result.RestartRequired)
+	} else if live := cfg.LiveEdge(); live.Download.RateLimitKBps != 100 || live.ObjectSync.MaxCacheMb != 10 || live.ObjectSync.URL != "" {
+		t.Errorf("wrong config after reload %v", live)
+	}
+	<-done
+
+	// an invalid config is rejected as a whole
+	writeConfig(`{"Edge": {"ExchangeURL": "http://exchange/v1/", "ImagePullRetries": 7, "ServiceRestartPolicy": "sometimes"}}`)
+	if _, err := cfg.Reload(); err == nil {
+		t.Errorf("expected an error for an invalid config")
+	} else if cfg.Edge.ImagePullRetries != 5 {
+		t.Errorf("the invalid config was applied, ImagePullRetries is %v", cfg.Edge.ImagePullRetries)
+	}
+
+	// a config that was not read from a file cannot be reloaded
+	if _, err := (&HorizonConfig{}).Reload(); err == nil {
+		t.Errorf("expected an error for a config without a file")
+	}
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The version of the config file schema that this anax understands. A config file without a SchemaVersion is
+// version 1. The version is incremented when a field is renamed or moved, and a migration of the old field is added
+// to configMigrations.
+const CONFIG_SCHEMA_VERSION = 2
+
+// A config field that was renamed or moved, by its path in the config file, e.g. Edge.WorkloadROStorage.
+type configRename struct {
+	From string
+	To   string
+}
+
+// The renamed and moved fields of each schema version, i.e. the migration from that version to the next one.
+var configMigrations = map[int][]configRename{
+	// version 1 predates the rename of microservices to services.
+	1: {
+		{From: "Edge.WorkloadROStorage", To: "Edge.ServiceStorage"},
+		{From: "Edge.MicroserviceUpgradeCheckIntervalS", To: "Edge.ServiceUpgradeCheckIntervalS"},
+		{From: "Edge.DefaultMicroserviceRetryCount", To: "Edge.DefaultServiceRetryCount"},
+		{From: "Edge.DefaultMicroserviceRetryDuration", To: "Edge.DefaultServiceRetryDuration"},
+	},
+}
+
+// Migrate the content of a config file from its schema version to the current one. The migrations are logged and
+// returned as "from -> to". A config file with a schema version newer than this anax understands is rejected, since
+// its fields could be silently ignored.
+func migrateConfig(content []byte) ([]byte, []string, error) {
+	// the numbers are kept as they are written, so that the migrated content decodes to the same values.
+	raw := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, nil, err
+	}
+
+	version := 1
+	if value, ok := lookupKey(raw, "SchemaVersion"); ok {
+		n, isNumber := value.(json.Number)
+		v, err := strconv.Atoi(n.String())
+		if !isNumber || err != nil || v < 1 {
+			return nil, nil, fmt.Errorf("SchemaVersion %v is not a version number", value)
+		}
+		version = v
+	}
+
+	if version > CONFIG_SCHEMA_VERSION {
+		return nil, nil, fmt.Errorf("SchemaVersion %v is newer than the version %v understood by this anax, upgrade anax to use this config file", version, CONFIG_SCHEMA_VERSION)
+	}
+
+	warnUnknownTopLevelFields(raw)
+
+	if version == CONFIG_SCHEMA_VERSION {
+		return content, []string{}, nil
+	}
+
+	migrated := make([]string, 0)
+	for v := version; v < CONFIG_SCHEMA_VERSION; v++ {
+		for _, rename := range configMigrations[v] {
+			if applied := applyRename(raw, rename); applied {
+				migrated = append(migrated, fmt.Sprintf("%v -> %v", rename.From, rename.To))
+				glog.Infof("Config migration from schema version %v: %v is now %v", v, rename.From, rename.To)
+			}
+		}
+	}
+	setKey(raw, "SchemaVersion", CONFIG_SCHEMA_VERSION)
+
+	content, err := json.Marshal(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, migrated, nil
+}
+
+// Move the value of the old field to the new one. Returns true if the old field was in the config. When both are
+// in the config the new one wins.
+func applyRename(raw map[string]interface{}, rename configRename) bool {
+	from := strings.Split(rename.From, ".")
+	parent := raw
+	for _, name := range from[:len(from)-1] {
+		if parent = sectionOf(parent, name); len(parent) == 0 {
+			return false
+		}
+	}
+	value, ok := lookupKey(parent, from[len(from)-1])
+	if !ok {
+		return false
+	}
+	deleteKey(parent, from[len(from)-1])
+
+	to := strings.Split(rename.To, ".")
+	target := raw
+	for _, name := range to[:len(to)-1] {
+		section, ok := lookupKey(target, name)
+		if m, isMap := section.(map[string]interface{}); ok && isMap {
+			target = m
+		} else {
+			m = make(map[string]interface{})
+			setKey(target, name, m)
+			target = m
+		}
+	}
+
+	if _, ok := lookupKey(target, to[len(to)-1]); ok {
+		glog.Warningf("Config migration: %v and %v are both set, the value of %v is ignored", rename.From, rename.To, rename.From)
+	} else {
+		setKey(target, to[len(to)-1], value)
+	}
+	return true
+}
+
+// Set the value of a key, replacing the key that matches it without regard to case.
+func setKey(raw map[string]interface{}, name string, value interface{}) {
+	deleteKey(raw, name)
+	raw[name] = value
+}
+
+func deleteKey(raw map[string]interface{}, name string) {
+	for key := range raw {
+		if strings.EqualFold(key, name) {
+			delete(raw, key)
+		}
+	}
+}
+
+// Log a warning for each top level field of the config file that is not a config section, it would otherwise be
+// silently dropped. Returns the unknown fields.
+func warnUnknownTopLevelFields(raw map[string]interface{}) []string {
+	t := reflect.TypeOf(HorizonConfig{})
+	unknown := make([]string, 0)
+	for key := range raw {
+		if strings.HasPrefix(key, JSON_DOC_KEY_PREFIX) {
+			continue
+		} else if f, ok := t.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, key) }); ok && f.PkgPath == "" {
+			continue
+		}
+		unknown = append(unknown, key)
+	}
+	sort.Strings(unknown)
+
+	for _, key := range unknown {
+		glog.Warningf("Config field %v is not known, it is ignored.", key)
+	}
+	return unknown
+}
@@ -0,0 +1,86 @@
+// +build unit
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Every historical schema version has a fixture in testdata, they all must read to the same config.
+func Test_SchemaVersionFixtures(t *testing.T) {
+
+	for version := 1; version <= CONFIG_SCHEMA_VERSION; version++ {
+		file := fmt.Sprintf("testdata/schema_v%v.json", version)
+		cfg, err := Read(file)
+		if err != nil {
+			t.Errorf("unable to read %v, error %v", file, err)
+			continue
+		}
+
+		if cfg.SchemaVersion != CONFIG_SCHEMA_VERSION {
+			t.Errorf("%v: wrong schema version %v", file, cfg.SchemaVersion)
+		} else if cfg.Edge.ServiceStorage != "/var/horizon/service_storage/" {
+			t.Errorf("%v: wrong ServiceStorage %v", file, cfg.Edge.ServiceStorage)
+		} else if cfg.Edge.ServiceUpgradeCheckIntervalS != 600 {
+			t.Errorf("%v: wrong ServiceUpgradeCheckIntervalS %v", file, cfg.Edge.ServiceUpgradeCheckIntervalS)
+		} else if cfg.Edge.DefaultServiceRetryCount != 4 || cfg.Edge.DefaultServiceRetryDuration != 1200 {
+			t.Errorf("%v: wrong service retry %v %v", file, cfg.Edge.DefaultServiceRetryCount, cfg.Edge.DefaultServiceRetryDuration)
+		} else if cfg.Edge.ExchangeHeartbeat != 60 {
+			t.Errorf("%v: wrong ExchangeHeartbeat %v", file, cfg.Edge.ExchangeHeartbeat)
+		}
+	}
+}
+
+func Test_migrateConfig(t *testing.T) {
+
+	// a version 1 config is migrated, a field set under both names keeps the new value
+	content, migrated, err := migrateConfig([]byte(`{"Edge": {"workloadROStorage": "/old", "ServiceStorage": "/new", "DefaultMicroserviceRetryCount": 3}}`))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if len(migrated) != 2 || migrated[0] != "Edge.WorkloadROStorage -> Edge.ServiceStorage" {
+		t.Errorf("wrong migrations %v", migrated)
+	} else if c := string(content); !strings.Contains(c, `"ServiceStorage":"/new"`) || strings.Contains(c, "/old") || !strings.Contains(c, `"SchemaVersion":2`) {
+		t.Errorf("wrong migrated content %v", c)
+	}
+
+	// a current config is left as it is
+	current := `{"SchemaVersion": 2, "Edge": {"ServiceStorage": "/new"}}`
+	if content, migrated, err := migrateConfig([]byte(current)); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if len(migrated) != 0 || string(content) != current {
+		t.Errorf("the current config was changed to %v, %v", string(content), migrated)
+	}
+
+	// a config for a newer anax, or without a valid version, is rejected
+	for _, c := range []string{`{"SchemaVersion": 3}`, `{"SchemaVersion": "two"}`, `{"SchemaVersion": 1.5}`, `{"SchemaVersion": 0}`} {
+		if _, _, err := migrateConfig([]byte(c)); err == nil {
+			t.Errorf("expected an error for %v", c)
+		}
+	}
+}
+
+func Test_UnknownTopLevelFields(t *testing.T) {
+	raw := map[string]interface{}{"Edge": nil, "agreementbot": nil, "#Edge": "doc", "Edgee": nil, "sources": nil}
+	if unknown := warnUnknownTopLevelFields(raw); len(unknown) != 2 || unknown[0] != "Edgee" || unknown[1] != "sources" {
+		t.Errorf("wrong unknown fields %v", unknown)
+	}
+}
+
+func Test_ReadNewerSchema(t *testing.T) {
+	f, err := ioutil.TempFile("", "anax-config-")
+	if err != nil {
+		t.Fatalf("Failed to create config file, error %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	if err := ioutil.WriteFile(f.Name(), []byte(`{"SchemaVersion": 99, "Edge": {}}`), 0600); err != nil {
+		t.Fatalf("Failed to write config file, error %v", err)
+	}
+	if _, err := Read(f.Name()); err == nil || !strings.Contains(err.Error(), "upgrade anax") {
+		t.Errorf("expected an error for a newer schema version, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// The struct tag that marks a config field as a secret. The value of a secret field is never logged, and it can be a
+// reference to the secret instead of the secret itself.
+const SECRET_TAG = "secret"
+
+// The references to a secret. A file reference is replaced by the content of the file, without the trailing new line,
+// when the config is read. An env reference is replaced by the value of the env var.
+const SECRET_REF_FILE = "file://"
+const SECRET_REF_ENV = "env://"
+
+// Returns true if the config field is a secret.
+func isSecretField(field reflect.StructField) bool {
+	return field.Tag.Get(SECRET_TAG) == "true"
+}
+
+// Replace the secret references in the config by the secrets. The references are kept so that they can be shown
+// instead of the secrets. A reference that cannot be resolved is a problem of the config.
+func resolveSecrets(config *HorizonConfig) ConfigErrors {
+	problems := ConfigErrors{}
+	config.secretRefs = make(map[string]string)
+
+	resolveSecretFields("Edge", reflect.ValueOf(&config.Edge).Elem(), config.secretRefs, &problems)
+	resolveSecretFields("AgreementBot", reflect.ValueOf(&config.AgreementBot).Elem(), config.secretRefs, &problems)
+
+	return problems
+}
+
+func resolveSecretFields(path string, v reflect.Value, refs map[string]string, problems *ConfigErrors) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldPath := fmt.Sprintf("%v.%v", path, field.Name)
+
+		if field.Type.Kind() == reflect.Struct {
+			resolveSecretFields(fieldPath, v.Field(i), refs, problems)
+			continue
+		} else if !isSecretField(field) || field.Type.Kind() != reflect.String {
+			continue
+		}
+
+		ref := v.Field(i).String()
+		if secret, isRef, err := resolveSecret(ref); err != nil {
+			problems.add(fieldPath, "unable to resolve the secret from %v, %v", ref, err)
+		} else if isRef {
+			v.Field(i).SetString(secret)
+			refs[fieldPath] = ref
+		}
+	}
+}
+
+// Returns the secret that the value refers to, and true if the value is a reference.
+func resolveSecret(value string) (string, bool, error) {
+	if strings.HasPrefix(value, SECRET_REF_FILE) {
+		content, err := ioutil.ReadFile(strings.TrimPrefix(value, SECRET_REF_FILE))
+		if err != nil {
+			return "", true, err
+		}
+		return strings.TrimRight(string(content), "\r\n"), true, nil
+
+	} else if strings.HasPrefix(value, SECRET_REF_ENV) {
+		name := strings.TrimPrefix(value, SECRET_REF_ENV)
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", true, fmt.Errorf("env var %v is not set", name)
+		}
+		return secret, true, nil
+	}
+	return value, false, nil
+}
+
+// Returns a copy of the config in which the secrets read from a reference are replaced by the reference, so that the
+// config can be shown without revealing them.
+func (c *HorizonConfig) WithSecretReferences() HorizonConfig {
+	cfg := *c
+	v := reflect.ValueOf(&cfg).Elem()
+	for path, ref := range c.secretRefs {
+		f := v
+		for _, name := range strings.Split(path, ".") {
+			f = f.FieldByName(name)
+		}
+		if f.IsValid() && f.Kind() == reflect.String {
+			f.SetString(ref)
+		}
+	}
+	return cfg
+}
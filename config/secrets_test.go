@@ -0,0 +1,88 @@
+// +build unit
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func Test_resolveSecrets(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "anax-secrets-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir, error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := path.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("mytoken\n"), 0600); err != nil {
+		t.Fatalf("Failed to write token file, error %v", err)
+	}
+
+	os.Setenv("TEST_PG_PASSWORD", "pgpw")
+	defer os.Unsetenv("TEST_PG_PASSWORD")
+
+	cfg := HorizonConfig{
+		AgreementBot: AGConfig{
+			ExchangeToken:      "file://" + tokenFile,
+			DefaultWorkloadPW:  "plainpw",
+			ActiveAgreementsPW: "",
+			Postgresql:         PostgresqlConfig{Password: "env://TEST_PG_PASSWORD"},
+			ExchangeURL:        "file://not-a-secret",
+		},
+	}
+
+	if problems := resolveSecrets(&cfg); len(problems) != 0 {
+		t.Fatalf("unexpected problems %v", problems)
+	}
+
+	if cfg.AgreementBot.ExchangeToken != "mytoken" {
+		t.Errorf("wrong token %v", cfg.AgreementBot.ExchangeToken)
+	} else if cfg.AgreementBot.Postgresql.Password != "pgpw" {
+		t.Errorf("wrong postgresql password %v", cfg.AgreementBot.Postgresql.Password)
+	} else if cfg.AgreementBot.DefaultWorkloadPW != "plainpw" {
+		t.Errorf("a literal secret was changed to %v", cfg.AgreementBot.DefaultWorkloadPW)
+	} else if cfg.AgreementBot.ExchangeURL != "file://not-a-secret" {
+		t.Errorf("a field that is not a secret was resolved to %v", cfg.AgreementBot.ExchangeURL)
+	}
+
+	// the references are shown instead of the secrets
+	shown := cfg.WithSecretReferences()
+	if shown.AgreementBot.ExchangeToken != "file://"+tokenFile {
+		t.Errorf("the token reference is not shown, got %v", shown.AgreementBot.ExchangeToken)
+	} else if shown.AgreementBot.Postgresql.Password != "env://TEST_PG_PASSWORD" {
+		t.Errorf("the password reference is not shown, got %v", shown.AgreementBot.Postgresql.Password)
+	} else if cfg.AgreementBot.ExchangeToken != "mytoken" {
+		t.Errorf("showing the references changed the config")
+	}
+}
+
+func Test_resolveSecrets_problems(t *testing.T) {
+
+	os.Unsetenv("TEST_MISSING_SECRET")
+
+	cfg := HorizonConfig{
+		AgreementBot: AGConfig{
+			ExchangeToken:     "file:///does/not/exist",
+			DefaultWorkloadPW: "env://TEST_MISSING_SECRET",
+		},
+	}
+
+	problems := resolveSecrets(&cfg)
+	if len(problems) != 2 {
+		t.Fatalf("expected 2 problems, got %v", problems)
+	}
+	for _, p := range problems {
+		if p.Path == "AgreementBot.ExchangeToken" && !strings.Contains(p.Problem, "file:///does/not/exist") {
+			t.Errorf("the problem does not name the source: %v", p)
+		} else if p.Path == "AgreementBot.DefaultWorkloadPW" && !strings.Contains(p.Problem, "TEST_MISSING_SECRET") {
+			t.Errorf("the problem does not name the source: %v", p)
+		} else if p.Path != "AgreementBot.ExchangeToken" && p.Path != "AgreementBot.DefaultWorkloadPW" {
+			t.Errorf("unexpected problem %v", p)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"fmt"
+)
+
+// The PCRs that attestation quotes cover by default, the firmware and boot loader measurements.
+var TPMPCRs_DEFAULT = []int{0, 1, 2, 3, 4, 5, 6, 7}
+
+// The TPM 2.0 that the node's key material is bound to. Nodes without a TPM leave the device empty.
+type TPMConfig struct {
+	Device string `doc:"The TPM 2.0 device, e.g. /dev/tpmrm0. When it is set, the key that encrypts the secrets in the local database, including the node's exchange token, is sealed by the TPM and only this TPM can unseal it. Empty means the node does not use a TPM."`
+	PCRs   []int  `doc:"The PCRs that the attestation quotes of the node cover. The default is PCRs 0 to 7."`
+}
+
+func (t *TPMConfig) String() string {
+	return fmt.Sprintf("Device: %v, PCRs: %v", t.Device, t.PCRs)
+}
+
+func (t *TPMConfig) GetPCRs() []int {
+	if len(t.PCRs) == 0 {
+		return TPMPCRs_DEFAULT
+	}
+	return t.PCRs
+}
+
+// Check the TPM settings, they are only checked when a TPM device is set.
+func (e *ConfigErrors) checkTPM(path string, t *TPMConfig) {
+	if t.Device == "" {
+		return
+	}
+	for _, pcr := range t.PCRs {
+		if pcr < 0 || pcr > 23 {
+			e.add(path+".PCRs", "%v is not a PCR, they are numbered 0 to 23", pcr)
+		}
+	}
+}
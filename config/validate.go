@@ -0,0 +1,298 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A problem found in the config, with the JSON path of the offending field in the config file, e.g. Edge.ExchangeURL.
+type ConfigProblem struct {
+	Path    string
+	Problem string
+}
+
+func (p ConfigProblem) String() string {
+	return fmt.Sprintf("%v: %v", p.Path, p.Problem)
+}
+
+// All the problems found in the config. It is returned as the error of a failed validation so that the config can be
+// fixed in one go.
+type ConfigErrors []ConfigProblem
+
+func (e ConfigErrors) Error() string {
+	lines := make([]string, 0, len(e))
+	for _, p := range e {
+		lines = append(lines, p.String())
+	}
+	return fmt.Sprintf("%v problem(s) in the config: %v", len(e), strings.Join(lines, "; "))
+}
+
+func (e *ConfigErrors) add(path string, format string, args ...interface{}) {
+	*e = append(*e, ConfigProblem{Path: path, Problem: fmt.Sprintf(format, args...)})
+}
+
+func (e *ConfigErrors) nonNegative(path string, value int64) {
+	if value < 0 {
+		e.add(path, "%v must not be negative", value)
+	}
+}
+
+// The URL must be an absolute http or https URL.
+func (e *ConfigErrors) checkURL(path string, value string) {
+	if value == "" {
+		return
+	} else if u, err := url.Parse(value); err != nil {
+		e.add(path, "%v is not a valid URL, %v", value, err)
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		e.add(path, "%v must be an http or https URL with a host", value)
+	}
+}
+
+// The directory must exist and be writable, or be creatable in a writable parent directory.
+func (e *ConfigErrors) checkWritableDir(path string, dir string) {
+	if dir == "" {
+		return
+	}
+
+	existing := filepath.Clean(dir)
+	for {
+		if info, err := os.Stat(existing); err == nil {
+			if !info.IsDir() {
+				e.add(path, "%v is not a directory", existing)
+				return
+			}
+			break
+		} else if !os.IsNotExist(err) {
+			e.add(path, "unable to access %v, %v", existing, err)
+			return
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		existing = parent
+	}
+
+	if f, err := ioutil.TempFile(existing, ".anax-check-"); err != nil {
+		e.add(path, "%v is not writable, %v", existing, err)
+	} else {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+// The file must exist.
+func (e *ConfigErrors) checkFile(path string, file string) {
+	if file == "" {
+		return
+	} else if info, err := os.Stat(file); err != nil {
+		e.add(path, "unable to access %v, %v", file, err)
+	} else if info.IsDir() {
+		e.add(path, "%v is a directory, not a file", file)
+	}
+}
+
+// Check the values of the config fields, the syntax of the URLs, the ranges of the numbers and the combinations of
+// options. These checks do not depend on the host, anax does not start and a reload is rejected when one of them
+// fails. The secret references that could not be resolved when the config was read are reported with them.
+func (c *HorizonConfig) checkValues() ConfigErrors {
+	problems := append(ConfigErrors{}, c.secretErrors...)
+
+	// Edge
+	problems.checkURL("Edge.ExchangeURL", c.Edge.ExchangeURL)
+	problems.checkURL("Edge.FileSyncService.CSSURL", c.Edge.FileSyncService.CSSURL)
+	problems.checkURL("Edge.ImageRegistryURL", c.Edge.ImageRegistryURL)
+
+	problems.nonNegative("Edge.ExchangeHeartbeat", int64(c.Edge.ExchangeHeartbeat))
+	problems.nonNegative("Edge.ExchangeVersionCheckIntervalM", c.Edge.ExchangeVersionCheckIntervalM)
+	problems.nonNegative("Edge.ExchangeMessageTTL", int64(c.Edge.ExchangeMessageTTL))
+	problems.nonNegative("Edge.ExchangeMessagePollInterval", int64(c.Edge.ExchangeMessagePollInterval))
+	problems.nonNegative("Edge.ExchangeMessagePollIncrement", int64(c.Edge.ExchangeMessagePollIncrement))
+	if c.Edge.ExchangeMessagePollMaxInterval < c.Edge.ExchangeMessagePollInterval {
+		problems.add("Edge.ExchangeMessagePollMaxInterval", "%v must not be less than ExchangeMessagePollInterval %v", c.Edge.ExchangeMessagePollMaxInterval, c.Edge.ExchangeMessagePollInterval)
+	}
+	problems.nonNegative("Edge.ServiceUpgradeCheckIntervalS", c.Edge.ServiceUpgradeCheckIntervalS)
+	problems.nonNegative("Edge.DefaultServiceRetryCount", int64(c.Edge.DefaultServiceRetryCount))
+	problems.nonNegative("Edge.NodeCheckIntervalS", int64(c.Edge.NodeCheckIntervalS))
+	problems.nonNegative("Edge.NodePolicyCheckIntervalS", int64(c.Edge.NodePolicyCheckIntervalS))
+	problems.nonNegative("Edge.SurfaceErrorTimeoutS", int64(c.Edge.SurfaceErrorTimeoutS))
+	problems.nonNegative("Edge.SurfaceErrorAgreementPersistentS", int64(c.Edge.SurfaceErrorAgreementPersistentS))
+	problems.nonNegative("Edge.InitialPollingBuffer", int64(c.Edge.InitialPollingBuffer))
+	problems.nonNegative("Edge.MaxAgreementPrelaunchTimeM", c.Edge.MaxAgreementPrelaunchTimeM)
+	problems.nonNegative("Edge.ImagePullRetries", int64(c.Edge.ImagePullRetries))
+	problems.nonNegative("Edge.ImagePullBackoffS", int64(c.Edge.ImagePullBackoffS))
+	problems.nonNegative("Edge.MaxCPURealtimeRuntime", c.Edge.MaxCPURealtimeRuntime)
+	if c.Edge.AgreementTimeoutScaleFactor < 0 {
+		problems.add("Edge.AgreementTimeoutScaleFactor", "%v must not be negative", c.Edge.AgreementTimeoutScaleFactor)
+	}
+
+	if rt := c.GetContainerRuntime(); rt != CONTAINER_RUNTIME_DOCKER && rt != CONTAINER_RUNTIME_PODMAN {
+		problems.add("Edge.ContainerRuntime", "%v is not supported, it must be %v or %v", rt, CONTAINER_RUNTIME_DOCKER, CONTAINER_RUNTIME_PODMAN)
+	} else if rt == CONTAINER_RUNTIME_PODMAN && !c.FeatureEnabled(FEATURE_PODMAN_RUNTIME) {
+		problems.add("Edge.ContainerRuntime", "%v is experimental, it requires the %v feature", rt, FEATURE_PODMAN_RUNTIME)
+	}
+
+	if rp := c.GetServiceRestartPolicy(); !IsValidServiceRestartPolicy(rp) {
+		problems.add("Edge.ServiceRestartPolicy", "%v is not supported, it must be %v, %v or %v", rp, SERVICE_RESTART_POLICY_NO, SERVICE_RESTART_POLICY_ON_FAILURE, SERVICE_RESTART_POLICY_ALWAYS)
+	}
+	if c.Edge.ServiceRestartBackoffS < 0 {
+		problems.add("Edge.ServiceRestartBackoffS", "%v must not be negative", c.Edge.ServiceRestartBackoffS)
+	} else if c.Edge.ServiceRestartMaxBackoffS < c.Edge.ServiceRestartBackoffS {
+		problems.add("Edge.ServiceRestartMaxBackoffS", "%v must not be less than ServiceRestartBackoffS %v", c.Edge.ServiceRestartMaxBackoffS, c.Edge.ServiceRestartBackoffS)
+	}
+
+	if err := c.Edge.Network.Validate(); err != nil {
+		problems.add("Edge.Network", "%v", err)
+	}
+
+	problems.nonNegative("Edge.APICertExpiryWarningDays", int64(c.Edge.APICertExpiryWarningDays))
+	problems.nonNegative("Edge.AuditLogMaxEntries", int64(c.Edge.AuditLogMaxEntries))
+	if c.Edge.APIListen != "" {
+		if err := (&APIListenerConfig{Address: c.Edge.APIListen}).Validate(); err != nil {
+			problems.add("Edge.APIListen", "%v", err)
+		}
+	}
+	addresses := map[string]bool{c.Edge.APIListen: true}
+	for i, l := range c.Edge.APIListeners {
+		path := fmt.Sprintf("Edge.APIListeners[%v]", i)
+		if err := l.Validate(); err != nil {
+			problems.add(path, "%v", err)
+		} else if addresses[l.Address] {
+			problems.add(path, "%v is already listened on", l.Address)
+		}
+		addresses[l.Address] = true
+	}
+
+	if c.Edge.APITimezone != "" {
+		if _, err := time.LoadLocation(c.Edge.APITimezone); err != nil {
+			problems.add("Edge.APITimezone", "%v is not a known timezone: %v", c.Edge.APITimezone, err)
+		}
+	}
+
+	// an interface name cannot contain a slash, so a HostAddress with one is a CIDR
+	if strings.Contains(c.Edge.HostAddress, "/") {
+		if _, _, err := net.ParseCIDR(c.Edge.HostAddress); err != nil {
+			problems.add("Edge.HostAddress", "%v is not a valid CIDR: %v", c.Edge.HostAddress, err)
+		}
+	}
+
+	problems.checkVault("Edge.Vault", &c.Edge.Vault)
+	problems.checkObjectSync("Edge.ObjectSync", &c.Edge.ObjectSync)
+	problems.checkTPM("Edge.TPM", &c.Edge.TPM)
+	problems.checkAgentUpdate("Edge.AgentUpdate", &c.Edge.AgentUpdate)
+	problems.checkCanary("Edge.Canary", &c.Edge.Canary)
+	problems.checkKubeScope("Edge.KubeScope", &c.Edge.KubeScope)
+	problems.checkJournal("Edge.Journal", &c.Edge.Journal)
+	problems.checkDownload("Edge.Download", &c.Edge.Download)
+	problems.checkDisk("Edge.Disk", &c.Edge.Disk)
+	problems.checkClockSkew("Edge.ClockSkew", &c.Edge.ClockSkew)
+	problems.checkExchangeRetry("Edge.ExchangeRetry", &c.Edge.ExchangeRetry)
+	problems.checkConfigRateLimit("Edge.ConfigRateLimit", &c.Edge.ConfigRateLimit)
+	problems.checkConfigstateHooks("Edge.ConfigstateHooks", &c.Edge.ConfigstateHooks)
+	problems.checkConditionHooks("Edge.ConditionHooks", &c.Edge.ConditionHooks)
+	if p := c.Edge.OfflineBundlePath; p != "" && !filepath.IsAbs(p) {
+		problems.add("Edge.OfflineBundlePath", "%v must be an absolute path", p)
+	}
+	if p := c.Edge.ProvisioningFile; p != "" && !filepath.IsAbs(p) {
+		problems.add("Edge.ProvisioningFile", "%v must be an absolute path", p)
+	}
+
+	if p := c.Edge.FileSyncService.APIProtocol; p != "" && p != "unix" && p != "https" && p != "http" {
+		problems.add("Edge.FileSyncService.APIProtocol", "%v is not supported, it must be unix, https or http", p)
+	} else if c.FSSIsUnixProtocol() && c.Edge.FileSyncService.APIPort != 0 {
+		problems.add("Edge.FileSyncService.APIPort", "must not be set when APIProtocol is unix, the ESS listens on the unix domain socket")
+	}
+
+	// Agreement bot
+	problems.checkURL("AgreementBot.ExchangeURL", c.AgreementBot.ExchangeURL)
+	problems.checkURL("AgreementBot.CSSURL", c.AgreementBot.CSSURL)
+	problems.checkURL("AgreementBot.ActiveAgreementsURL", c.AgreementBot.ActiveAgreementsURL)
+
+	problems.nonNegative("AgreementBot.TxLostDelayTolerationSeconds", int64(c.AgreementBot.TxLostDelayTolerationSeconds))
+	problems.nonNegative("AgreementBot.AgreementWorkers", int64(c.AgreementBot.AgreementWorkers))
+	problems.nonNegative("AgreementBot.ExchangeHeartbeat", int64(c.AgreementBot.ExchangeHeartbeat))
+	problems.nonNegative("AgreementBot.ActiveDeviceTimeoutS", int64(c.AgreementBot.ActiveDeviceTimeoutS))
+	problems.nonNegative("AgreementBot.ExchangeMessageTTL", int64(c.AgreementBot.ExchangeMessageTTL))
+	problems.nonNegative("AgreementBot.MessageKeyCheck", int64(c.AgreementBot.MessageKeyCheck))
+	problems.nonNegative("AgreementBot.PurgeArchivedAgreementHours", int64(c.AgreementBot.PurgeArchivedAgreementHours))
+	problems.nonNegative("AgreementBot.CheckUpdatedPolicyS", int64(c.AgreementBot.CheckUpdatedPolicyS))
+	problems.nonNegative("AgreementBot.MMSGarbageCollectionInterval", c.AgreementBot.MMSGarbageCollectionInterval)
+	problems.nonNegative("AgreementBot.MaxExchangeChanges", int64(c.AgreementBot.MaxExchangeChanges))
+	problems.nonNegative("AgreementBot.Postgresql.MaxOpenConnections", int64(c.AgreementBot.Postgresql.MaxOpenConnections))
+	if c.AgreementBot.ProtocolTimeoutScaleFactor < 0 {
+		problems.add("AgreementBot.ProtocolTimeoutScaleFactor", "%v must not be negative", c.AgreementBot.ProtocolTimeoutScaleFactor)
+	}
+	if c.AgreementBot.AgreementTimeoutScaleFactor < 0 {
+		problems.add("AgreementBot.AgreementTimeoutScaleFactor", "%v must not be negative", c.AgreementBot.AgreementTimeoutScaleFactor)
+	}
+	if c.AgreementBot.ExchangeMessageTTLScaleFactor < 0 {
+		problems.add("AgreementBot.ExchangeMessageTTLScaleFactor", "%v must not be negative", c.AgreementBot.ExchangeMessageTTLScaleFactor)
+	}
+
+	if c.IsBoltDBConfigured() && c.AgreementBot.Postgresql != (PostgresqlConfig{}) {
+		problems.add("AgreementBot.Postgresql", "must not be set together with AgreementBot.DBPath, the agreement bot uses one database")
+	}
+
+	return problems
+}
+
+// Validate the config before any worker is started. In addition to the checks of the values, the required fields must
+// be set and the files and directories that the config refers to must be usable on
+// this host. All the problems are returned at once as ConfigErrors, nil if there are none.
+func (c *HorizonConfig) Validate() error {
+	problems := c.checkValues()
+
+	if c.Edge.DBPath == "" && !c.IsBoltDBConfigured() && !c.IsPostgresqlConfigured() {
+		problems.add("Edge.DBPath", "is required unless the agreement bot database is configured in AgreementBot.DBPath or AgreementBot.Postgresql")
+	}
+
+	if c.AgreementBot.ExchangeURL != "" {
+		if c.AgreementBot.ExchangeId == "" {
+			problems.add("AgreementBot.ExchangeId", "is required when AgreementBot.ExchangeURL is set")
+		} else if parts := strings.Split(c.AgreementBot.ExchangeId, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			problems.add("AgreementBot.ExchangeId", "%v must be org qualified, e.g. myorg/myagbot", c.AgreementBot.ExchangeId)
+		}
+		if c.AgreementBot.ExchangeToken == "" {
+			problems.add("AgreementBot.ExchangeToken", "is required when AgreementBot.ExchangeURL is set")
+		}
+	}
+
+	if c.AgreementBot.SecureAPIListenHost != "" {
+		if c.AgreementBot.SecureAPIServerCert == "" {
+			problems.add("AgreementBot.SecureAPIServerCert", "is required when AgreementBot.SecureAPIListenHost is set")
+		}
+		if c.AgreementBot.SecureAPIServerKey == "" {
+			problems.add("AgreementBot.SecureAPIServerKey", "is required when AgreementBot.SecureAPIListenHost is set")
+		}
+	}
+
+	problems.checkWritableDir("Edge.DBPath", c.Edge.DBPath)
+	problems.checkWritableDir("Edge.PolicyPath", c.Edge.PolicyPath)
+	problems.checkWritableDir("Edge.ObjectSync.CacheDir", c.Edge.ObjectSync.CacheDir)
+	problems.checkWritableDir("AgreementBot.DBPath", c.AgreementBot.DBPath)
+	problems.checkWritableDir("AgreementBot.PolicyPath", c.AgreementBot.PolicyPath)
+
+	problems.checkFile("Edge.CACertsPath", c.Edge.CACertsPath)
+	problems.checkFile("Edge.FileSyncService.CSSSSLCert", c.Edge.FileSyncService.CSSSSLCert)
+	problems.checkFile("Edge.Vault.CACertFile", c.Edge.Vault.CACertFile)
+	problems.checkFile("Edge.KubeConfigFile", c.Edge.KubeConfigFile)
+	problems.checkFile("AgreementBot.CSSSSLCert", c.AgreementBot.CSSSSLCert)
+	problems.checkFile("AgreementBot.SecureAPIServerCert", c.AgreementBot.SecureAPIServerCert)
+	problems.checkFile("AgreementBot.SecureAPIServerKey", c.AgreementBot.SecureAPIServerKey)
+	for i, l := range c.Edge.APIListeners {
+		problems.checkFile(fmt.Sprintf("Edge.APIListeners[%v].TLSCertFile", i), l.TLSCertFile)
+		problems.checkFile(fmt.Sprintf("Edge.APIListeners[%v].TLSKeyFile", i), l.TLSKeyFile)
+		problems.checkFile(fmt.Sprintf("Edge.APIListeners[%v].ClientCAFile", i), l.ClientCAFile)
+	}
+
+	if len(problems) != 0 {
+		return problems
+	}
+	return nil
+}
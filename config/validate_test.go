@@ -0,0 +1,179 @@
+// +build unit
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+)
+
+func Test_Validate_success(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "anax-validate-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir, error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := HorizonConfig{
+		Edge: Config{
+			DBPath:                         path.Join(dir, "db"),
+			PolicyPath:                     path.Join(dir, "policy/sub"),
+			ExchangeURL:                    "https://exchange:8080/v1/",
+			ExchangeMessagePollInterval:    20,
+			ExchangeMessagePollMaxInterval: 120,
+			ServiceRestartBackoffS:         10,
+			ServiceRestartMaxBackoffS:      600,
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func Test_Validate_problems(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "anax-validate-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir, error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "afile")
+	if err := ioutil.WriteFile(file, []byte("x"), 0600); err != nil {
+		t.Fatalf("Failed to create file, error %v", err)
+	}
+
+	cfg := HorizonConfig{
+		Edge: Config{
+			DBPath:                         file,
+			ExchangeURL:                    "exchange:8080/v1",
+			CACertsPath:                    path.Join(dir, "missing.pem"),
+			ExchangeMessagePollInterval:    60,
+			ExchangeMessagePollMaxInterval: 30,
+			ImagePullRetries:               -1,
+			ServiceRestartPolicy:           "sometimes",
+			ServiceRestartBackoffS:         10,
+			ServiceRestartMaxBackoffS:      600,
+			FileSyncService:                FSSConfig{APIPort: 8443},
+			HostAddress:                    "192.168.1.0/33",
+			APITimezone:                    "Nowhere/Atlantis",
+			Vault:                          VaultConfig{Address: "https://vault:8200", AuthMethod: VAULT_AUTH_APPROLE, RoleId: "edge"},
+			ObjectSync:                     ObjectSyncConfig{URL: "objects.example.com"},
+			TPM:                            TPMConfig{Device: "/dev/tpmrm0", PCRs: []int{7, 24}},
+			Canary:                         CanaryConfig{Percent: 150},
+			KubeScope:                      KubeScopeConfig{Namespaces: []string{"edge", "Edge_2"}, DefaultLimits: KubeResources{CPUs: 2}, Quota: KubeResources{CPUs: 1}},
+			OfflineBundlePath:              "bundle",
+			ProvisioningFile:               "provision.json",
+			Journal:                        JournalConfig{Severities: []string{"critical"}},
+			Download:                       DownloadConfig{WindowRateLimitKBps: 2048},
+			Disk:                           DiskConfig{MinFreeMB: 1000, WarnFreeMB: 500},
+			ClockSkew:                      ClockSkewConfig{MaxS: 30},
+			ExchangeRetry:                  ExchangeRetryConfig{Attempts: -1},
+			ConfigRateLimit:                ConfigRateLimitConfig{PerMinute: -1},
+			ImageRegistryURL:               "registry.example.com",
+			ConfigstateHooks:               ConfigstateHooksConfig{URLs: []string{"https://fleet.example.com/hooks"}, Commands: []string{"hooks/mount.sh"}, States: []string{"configured", "registered"}},
+		},
+		AgreementBot: AGConfig{
+			DBPath:              dir,
+			Postgresql:          PostgresqlConfig{Host: "db"},
+			ExchangeURL:         "http://exchange/v1/",
+			ExchangeId:          "myagbot",
+			SecureAPIListenHost: "0.0.0.0",
+		},
+	}
+
+	err = cfg.Validate()
+	problems, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("expected ConfigErrors, got %v", err)
+	}
+
+	paths := make([]string, 0, len(problems))
+	for _, p := range problems {
+		paths = append(paths, p.Path)
+	}
+	sort.Strings(paths)
+
+	expected := []string{
+		"AgreementBot.ExchangeId",
+		"AgreementBot.ExchangeToken",
+		"AgreementBot.Postgresql",
+		"AgreementBot.SecureAPIServerCert",
+		"AgreementBot.SecureAPIServerKey",
+		"Edge.APITimezone",
+		"Edge.CACertsPath",
+		"Edge.Canary.Percent",
+		"Edge.ClockSkew.MaxS",
+		"Edge.ConfigRateLimit.PerMinute",
+		"Edge.ConfigstateHooks.Commands[0]",
+		"Edge.ConfigstateHooks.States[1]",
+		"Edge.DBPath",
+		"Edge.Disk.WarnFreeMB",
+		"Edge.Download.WindowRateLimitKBps",
+		"Edge.ExchangeMessagePollMaxInterval",
+		"Edge.ExchangeRetry.Attempts",
+		"Edge.ExchangeURL",
+		"Edge.FileSyncService.APIPort",
+		"Edge.HostAddress",
+		"Edge.ImagePullRetries",
+		"Edge.ImageRegistryURL",
+		"Edge.Journal.Severities",
+		"Edge.KubeScope.DefaultLimits.CPUs",
+		"Edge.KubeScope.Namespaces",
+		"Edge.ObjectSync.URL",
+		"Edge.OfflineBundlePath",
+		"Edge.ProvisioningFile",
+		"Edge.ServiceRestartPolicy",
+		"Edge.TPM.PCRs",
+		"Edge.Vault.SecretId",
+	}
+
+	if len(paths) != len(expected) {
+		t.Fatalf("expected problems in %v, got %v", expected, problems)
+	}
+	for i := range expected {
+		if paths[i] != expected[i] {
+			t.Errorf("expected problems in %v, got %v", expected, problems)
+			break
+		}
+	}
+}
+
+// A config with invalid values can be read, e.g. to be shown, it is rejected by Validate at startup.
+func Test_Read_invalid_values(t *testing.T) {
+
+	f, err := ioutil.TempFile("", "anax-config-")
+	if err != nil {
+		t.Fatalf("Failed to create config file, error %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	content := `{"Edge": {"ExchangeURL": "exchange:8080/v1", "ServiceRestartPolicy": "sometimes"}, "AgreementBot": {"ExchangeToken": "file:///does/not/exist"}}`
+	if err := ioutil.WriteFile(f.Name(), []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file, error %v", err)
+	}
+
+	cfg, err := Read(f.Name())
+	if err != nil {
+		t.Fatalf("the config should be read, error %v", err)
+	}
+
+	problems, ok := cfg.Validate().(ConfigErrors)
+	if !ok {
+		t.Fatalf("the config should not be valid, got %v", problems)
+	}
+	paths := map[string]bool{}
+	for _, p := range problems {
+		paths[p.Path] = true
+	}
+	for _, path := range []string{"Edge.ExchangeURL", "Edge.ServiceRestartPolicy", "AgreementBot.ExchangeToken"} {
+		if !paths[path] {
+			t.Errorf("%v should be a problem, the problems are %v", path, problems)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// The ways that anax can log in to Vault.
+const VAULT_AUTH_TOKEN = "token"
+const VAULT_AUTH_APPROLE = "approle"
+
+// The default time a request to Vault can take.
+const VaultTimeoutS_DEFAULT = 10
+
+// The connection to the HashiCorp Vault that holds the secrets referred to by service variables, e.g. a variable set
+// to vault:secret/data/edge/siteA#apiKey.
+type VaultConfig struct {
+	Address    string `doc:"The URL of the Vault server, e.g. https://vault.example.com:8200. Service variables that refer to Vault can only be resolved when it is set."`
+	AuthMethod string `doc:"How anax logs in to Vault, \"token\" (the default) or \"approle\"."`
+	AuthPath   string `doc:"The path that the auth method is mounted on in Vault. The default is the name of the auth method."`
+	Token      string `secret:"true" doc:"The Vault token used by the token auth method. It can be a file:// or env:// reference to the token."`
+	RoleId     string `doc:"The role id used by the approle auth method."`
+	SecretId   string `secret:"true" doc:"The secret id used by the approle auth method. It can be a file:// or env:// reference to the secret id."`
+	Namespace  string `doc:"The Vault Enterprise namespace of the secrets. Empty means the root namespace."`
+	CACertFile string `doc:"The PEM file of the CA certificates that the Vault server certificate is verified with, in addition to the system CA certificates."`
+	TimeoutS   uint   `unit:"s" doc:"The number of seconds a request to Vault can take. The default is 10 seconds."`
+}
+
+func (v *VaultConfig) String() string {
+	mask := "******"
+	token, secretId := "", ""
+	if v.Token != "" {
+		token = mask
+	}
+	if v.SecretId != "" {
+		secretId = mask
+	}
+	return fmt.Sprintf("Address: %v, AuthMethod: %v, AuthPath: %v, Token: %v, RoleId: %v, SecretId: %v, Namespace: %v, CACertFile: %v, TimeoutS: %v",
+		v.Address, v.AuthMethod, v.AuthPath, token, v.RoleId, secretId, v.Namespace, v.CACertFile, v.TimeoutS)
+}
+
+func (v *VaultConfig) GetAuthMethod() string {
+	if v.AuthMethod == "" {
+		return VAULT_AUTH_TOKEN
+	}
+	return v.AuthMethod
+}
+
+func (v *VaultConfig) GetAuthPath() string {
+	if v.AuthPath == "" {
+		return v.GetAuthMethod()
+	}
+	return v.AuthPath
+}
+
+func (v *VaultConfig) GetTimeout() time.Duration {
+	if v.TimeoutS == 0 {
+		return VaultTimeoutS_DEFAULT * time.Second
+	}
+	return time.Duration(v.TimeoutS) * time.Second
+}
+
+// Check the Vault settings, they are only checked when the Vault address is set.
+func (e *ConfigErrors) checkVault(path string, v *VaultConfig) {
+	if v.Address == "" {
+		return
+	}
+	e.checkURL(path+".Address", v.Address)
+
+	switch v.GetAuthMethod() {
+	case VAULT_AUTH_TOKEN:
+		if v.Token == "" {
+			e.add(path+".Token", "is required when the auth method is %v", VAULT_AUTH_TOKEN)
+		}
+	case VAULT_AUTH_APPROLE:
+		if v.RoleId == "" {
+			e.add(path+".RoleId", "is required when the auth method is %v", VAULT_AUTH_APPROLE)
+		}
+		if v.SecretId == "" {
+			e.add(path+".SecretId", "is required when the auth method is %v", VAULT_AUTH_APPROLE)
+		}
+	default:
+		e.add(path+".AuthMethod", "%v is not supported, it must be %v or %v", v.AuthMethod, VAULT_AUTH_TOKEN, VAULT_AUTH_APPROLE)
+	}
+}
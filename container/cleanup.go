@@ -0,0 +1,231 @@
+package container
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"strconv"
+	"time"
+)
+
+const (
+	RESOURCE_TYPE_NETWORK = "network"
+	RESOURCE_TYPE_VOLUME  = "volume"
+)
+
+// The age a network or volume must have before it can be removed as orphaned. A resource that was just created can
+// belong to an agreement or service instance that is being set up.
+const ORPHAN_MIN_AGE = 10 * time.Minute
+
+// A docker network or volume that was created by anax for an agreement or service instance which no longer exists.
+type OrphanedResource struct {
+	Type  string `json:"type"`  // network or volume
+	Name  string `json:"name"`  // The docker name of the network or volume.
+	Owner string `json:"owner"` // The agreement id or service instance key that the resource was created for.
+}
+
+func (o OrphanedResource) String() string {
+	return fmt.Sprintf("Type: %v, Name: %v, Owner: %v", o.Type, o.Name, o.Owner)
+}
+
+// Create the docker volume used as the workload storage of an agreement or service instance, labelled so that it can
+// be identified as owned by anax.
+func (b *ContainerWorker) createWorkloadStorageVolume(name string, agreementId string) error {
+	vOption := docker.CreateVolumeOptions{
+		Name:   name,
+		Driver: "local",
+		Labels: map[string]string{
+			LABEL_PREFIX + ".agreement_id":     agreementId,
+			LABEL_PREFIX + ".owner":            LABEL_OWNER,
+			LABEL_PREFIX + ".workload_storage": ""},
+	}
+
+	if _, err := b.client.CreateVolume(vOption); err != nil {
+		return fmt.Errorf("Failed to create the workload storage volume %v. %v", name, err)
+	}
+	return nil
+}
+
+// Returns the agreement ids and service instance keys that are still in use on this node. The resources that anax
+// creates are labelled with one of these.
+func activeResourceOwners(db *bolt.DB) (map[string]bool, error) {
+	owners := make(map[string]bool)
+
+	agreements, err := persistence.FindEstablishedAgreementsAllProtocols(db, policy.AllAgreementProtocols(), []persistence.EAFilter{persistence.UnarchivedEAFilter()})
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve agreements from database, error %v", err)
+	}
+	for _, ag := range agreements {
+		owners[ag.CurrentAgreementId] = true
+	}
+
+	msInsts, err := persistence.FindMicroserviceInstances(db, []persistence.MIFilter{persistence.UnarchivedMIFilter()})
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve service instances from database, error %v", err)
+	}
+	for _, msi := range msInsts {
+		owners[msi.GetKey()] = true
+	}
+
+	return owners, nil
+}
+
+// Find the networks and volumes owned by anax that do not belong to any current agreement or service instance, and that
+// are not used by any container. Volumes are only included if includeVolumes is true.
+func FindOrphanedResources(db *bolt.DB, client ContainerRuntime, includeVolumes bool) ([]OrphanedResource, error) {
+
+	// The owners are read after the resources are listed, so that the owner of a resource created in between is known.
+	containers, err := client.ListContainers(docker.ListContainersOptions{All: true})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list containers, error %v", err)
+	}
+
+	networks, err := client.ListNetworks()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list networks, error %v", err)
+	}
+
+	var volumes []docker.Volume
+	if includeVolumes {
+		if volumes, err = client.ListVolumes(docker.ListVolumesOptions{}); err != nil {
+			return nil, fmt.Errorf("unable to list volumes, error %v", err)
+		}
+	}
+
+	owners, err := activeResourceOwners(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return orphanedResources(owners, containers, networks, volumes, time.Now()), nil
+}
+
+// Select the anax networks and volumes whose owner is not in the owners map and that are not used by any of the
+// containers, anax managed or not. Shared networks are not included, they are removed with the last container that
+// uses them. Resources created less than ORPHAN_MIN_AGE before now are not included either.
+func orphanedResources(owners map[string]bool, containers []docker.APIContainers, networks []docker.Network, volumes []docker.Volume, now time.Time) []OrphanedResource {
+
+	// Networks created before the created label was added have no age, they are old enough.
+	recent := func(created time.Time) bool {
+		return !created.IsZero() && now.Sub(created) < ORPHAN_MIN_AGE
+	}
+
+	usedNetworks := make(map[string]bool)
+	usedVolumes := make(map[string]bool)
+	for _, c := range containers {
+		for name := range c.Networks.Networks {
+			usedNetworks[name] = true
+		}
+		for _, m := range c.Mounts {
+			if m.Name != "" {
+				usedVolumes[m.Name] = true
+			}
+		}
+	}
+
+	orphans := make([]OrphanedResource, 0, 5)
+
+	for _, nw := range networks {
+		if _, anaxNet := nw.Labels[LABEL_PREFIX+".network"]; !anaxNet {
+			continue
+		} else if _, shared := nw.Labels[LABEL_PREFIX+".service_pattern.shared"]; shared {
+			continue
+		}
+
+		// Networks created before the owner labels were added are named after their owner.
+		owner, ok := nw.Labels[LABEL_PREFIX+".agreement_id"]
+		if !ok {
+			owner = nw.Name
+		}
+
+		var created time.Time
+		if secs, err := strconv.ParseInt(nw.Labels[LABEL_PREFIX+".created"], 10, 64); err == nil {
+			created = time.Unix(secs, 0)
+		}
+
+		if !owners[owner] && !usedNetworks[nw.Name] && !recent(created) {
+			orphans = append(orphans, OrphanedResource{Type: RESOURCE_TYPE_NETWORK, Name: nw.Name, Owner: owner})
+		}
+	}
+
+	for _, v := range volumes {
+		if v.Labels == nil || v.Labels[LABEL_PREFIX+".owner"] != LABEL_OWNER {
+			continue
+		}
+
+		owner := v.Labels[LABEL_PREFIX+".agreement_id"]
+		if !owners[owner] && !usedVolumes[v.Name] && !recent(v.CreatedAt) {
+			orphans = append(orphans, OrphanedResource{Type: RESOURCE_TYPE_VOLUME, Name: v.Name, Owner: owner})
+		}
+	}
+
+	return orphans
+}
+
+// Remove the networks and volumes owned by anax that no longer belong to any agreement or service instance. When dryRun
+// is true nothing is removed. Returns the resources that were (or would be) removed. Named volumes hold the data of the
+// node's services so they are only included when includeVolumes is true, which is the case when the node is not
+// registered. When multiple anax instances share the host, the resources might belong to another instance, so
+// nothing is removed.
+func RemoveOrphanedResources(db *bolt.DB, cfg *config.HorizonConfig, includeVolumes bool, dryRun bool) ([]OrphanedResource, error) {
+
+	if cfg.Edge.DockerEndpoint == "" {
+		return nil, fmt.Errorf("Docker client cannot be initialized. Please make sure DockerEndpoint is set in the configuration file.")
+	} else if cfg.Edge.MultipleAnaxInstances {
+		glog.V(3).Infof("Multiple anax instances enabled, will not look for orphaned networks and volumes.")
+		return []OrphanedResource{}, nil
+	}
+
+	client, err := NewContainerRuntime(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to instantiate %v Client: %v", cfg.GetContainerRuntime(), err)
+	}
+
+	orphans, err := FindOrphanedResources(db, client, includeVolumes)
+	if err != nil || dryRun {
+		return orphans, err
+	}
+
+	removed := make([]OrphanedResource, 0, len(orphans))
+	for _, o := range orphans {
+		switch o.Type {
+		case RESOURCE_TYPE_NETWORK:
+			if err := client.RemoveNetwork(o.Name); err != nil {
+				// failure to delete one resource should not prevent the process from going on
+				glog.Errorf("Failed to remove orphaned network %v. %v", o.Name, err)
+				continue
+			}
+		case RESOURCE_TYPE_VOLUME:
+			if err := client.RemoveVolume(o.Name); err != nil {
+				glog.Errorf("Failed to remove orphaned volume %v. %v", o.Name, err)
+				continue
+			} else if cvs, err := persistence.FindContainerVolumes(db, []persistence.ContainerVolumeFilter{persistence.UnarchivedCVFilter(), persistence.NameCVFilter(o.Name)}); err != nil {
+				glog.Errorf("Error retrieving container volume %v from local db. %v", o.Name, err)
+			} else {
+				for _, cv := range cvs {
+					if err := persistence.ArchiveContainerVolumes(db, &cv); err != nil {
+						glog.Errorf("Failed to archive container volume %v in local db. %v", o.Name, err)
+					}
+				}
+			}
+		}
+		glog.V(3).Infof("Removed orphaned %v", o)
+		removed = append(removed, o)
+	}
+
+	return removed, nil
+}
+
+// Remove the networks, and if includeVolumes is true the volumes, that anax created for agreements and services which
+// no longer exist. Errors are logged, they do not stop the caller.
+func (b *ContainerWorker) removeOrphanedResources(includeVolumes bool) {
+	if removed, err := RemoveOrphanedResources(b.db, b.Config, includeVolumes, false); err != nil {
+		glog.Errorf("ContainerWorker unable to remove orphaned networks and volumes, error: %v", err)
+	} else if len(removed) != 0 {
+		glog.V(3).Infof("ContainerWorker removed orphaned networks and volumes: %v", removed)
+	}
+}
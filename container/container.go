@@ -18,9 +18,11 @@ import (
 	"github.com/open-horizon/anax/eventlog"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/i18n"
+	"github.com/open-horizon/anax/objectsync"
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/policy"
 	"github.com/open-horizon/anax/resource"
+	"github.com/open-horizon/anax/vault"
 	"github.com/open-horizon/anax/worker"
 	"golang.org/x/sys/unix"
 	"io"
@@ -31,9 +33,13 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const LABEL_PREFIX = "openhorizon.anax"
+
+// The value of the owner label on the docker networks and volumes that anax creates.
+const LABEL_OWNER = "openhorizon"
 const IPT_COLONUS_ISOLATED_CHAIN = "OPENHORIZON-ANAX-ISOLATION"
 
 // messages for event logs
@@ -53,6 +59,10 @@ const (
 	EL_CONT_TERM_UNABLE_ACCESS_STORAGE_DIR    = "anax terminating. Unable to access service storage direcotry specified in config: %v. %v"
 	EL_CONT_TERM_UNABLE_INIT_IPTABLE_CLIENT   = "anax terminating. Failed to instantiate iptables client. %v"
 	EL_CONT_TERM_UNABLE_INIT_DOCKER_CLIENT    = "anax terminating. Failed to instantiate docker client. %v"
+	EL_CONT_CONTAINER_UNHEALTHY               = "Container %v of service %v is unhealthy after %v failed health checks: %v"
+	EL_CONT_CONTAINER_HEALTHY                 = "Container %v of service %v is healthy again"
+	EL_CONT_HEALTH_CHECK_RESTART_ERROR        = "Error restarting unhealthy container %v: %v"
+	EL_CONT_OBJECT_UPDATED                    = "Object %v of %v is now version %v of %v"
 )
 
 // This is does nothing useful at run time.
@@ -78,6 +88,10 @@ func MarkI18nMessages() {
 	msgPrinter.Sprintf(EL_CONT_TERM_UNABLE_ACCESS_STORAGE_DIR)
 	msgPrinter.Sprintf(EL_CONT_TERM_UNABLE_INIT_IPTABLE_CLIENT)
 	msgPrinter.Sprintf(EL_CONT_TERM_UNABLE_INIT_DOCKER_CLIENT)
+	msgPrinter.Sprintf(EL_CONT_CONTAINER_UNHEALTHY)
+	msgPrinter.Sprintf(EL_CONT_CONTAINER_HEALTHY)
+	msgPrinter.Sprintf(EL_CONT_HEALTH_CHECK_RESTART_ERROR)
+	msgPrinter.Sprintf(EL_CONT_OBJECT_UPDATED)
 }
 
 /*
@@ -206,7 +220,7 @@ func removeDuplicateVariable(existingArray *[]string, newVar string) {
 
 }
 
-func (w *ContainerWorker) finalizeDeployment(agreementId string, deployment *containermessage.DeploymentDescription, environmentAdditions map[string]string, workloadRWStorageDir string, cpuSet string, uds string) (map[string]servicePair, error) {
+func (w *ContainerWorker) finalizeDeployment(agreementId string, deployment *containermessage.DeploymentDescription, environmentAdditions map[string]string, workloadRWStorageDir string, cpuSet string, uds string, restartPolicy docker.RestartPolicy) (map[string]servicePair, error) {
 
 	// final structure
 	services := make(map[string]servicePair, 0)
@@ -228,6 +242,8 @@ func (w *ContainerWorker) finalizeDeployment(agreementId string, deployment *con
 		return nil, fmt.Errorf("No services specified in pattern: %v", deployment)
 	}
 
+	allowList := w.hostAccessAllowList()
+
 	for serviceName, service := range deployment.Services {
 		deploymentHash, err := hashService(service)
 		if err != nil {
@@ -273,6 +289,15 @@ func (w *ContainerWorker) finalizeDeployment(agreementId string, deployment *con
 		if w.IsDevInstance() {
 			labels[LABEL_PREFIX+".dev_service"] = "true"
 		}
+		if service.HealthCheck != nil {
+			if err := service.HealthCheck.Validate(); err != nil {
+				return nil, fmt.Errorf("invalid health check for service %v: %v", serviceName, err)
+			} else if hc, err := json.Marshal(service.HealthCheck); err != nil {
+				return nil, fmt.Errorf("unable to serialize health check for service %v: %v", serviceName, err)
+			} else {
+				labels[LABEL_HEALTH_CHECK] = string(hc)
+			}
+		}
 
 		var logConfig docker.LogConfig
 
@@ -326,7 +351,7 @@ func (w *ContainerWorker) finalizeDeployment(agreementId string, deployment *con
 				PublishAllPorts: false,
 				PortBindings:    map[docker.Port][]docker.PortBinding{},
 				Links:           nil, // do not allow any
-				RestartPolicy:   docker.AlwaysRestart(),
+				RestartPolicy:   restartPolicy,
 				Memory:          ramBytes,
 				MemorySwap:      0,
 				Devices:         []docker.Device{},
@@ -345,6 +370,9 @@ func (w *ContainerWorker) finalizeDeployment(agreementId string, deployment *con
 			serviceConfig.HostConfig.NanoCPUs = int64(service.MaxCPUs * 1000000000)
 		}
 
+		// Pin the container to the cpus requested by the service, in place of the default cpuset.
+		applyCPUPinning(agreementId, service, serviceConfig)
+
 		// Mark each container as infrastructure if the deployment description indicates infrastructure
 		if deployment.Infrastructure {
 			serviceConfig.Config.Labels[LABEL_PREFIX+".infrastructure"] = ""
@@ -446,24 +474,31 @@ func (w *ContainerWorker) finalizeDeployment(agreementId string, deployment *con
 		}
 
 		// The format of device mapping is: <host device name>:<contianer device name>:<cgroup permission>
-		// the cgoup permission can be omitted. It defaults to "rwm" when omitted.
+		// the cgoup permission can be omitted. It defaults to "rwm" when omitted. Only host devices that match
+		// the node's device allow list can be mapped into the container.
 		for _, givenDevice := range service.Devices {
-			cgp := "rwm"
-			sp := strings.Split(givenDevice, ":")
-			if len(sp) == 3 {
-				// the cgroup permission
-				cgp = sp[2]
-			} else if len(sp) < 2 || len(sp) > 3 {
-				return nil, fmt.Errorf("Illegal device specified in deployment description: %v", givenDevice)
+			dm, err := containermessage.ParseDeviceMapping(givenDevice)
+			if err != nil {
+				return nil, err
+			} else if !containermessage.DeviceAllowed(dm.PathOnHost, allowList.Devices) {
+				return nil, fmt.Errorf("Device %v specified in deployment description is not in the node's device allow list %v", dm.PathOnHost, allowList.Devices)
 			}
 
 			serviceConfig.HostConfig.Devices = append(serviceConfig.HostConfig.Devices, docker.Device{
-				PathOnHost:        sp[0],
-				PathInContainer:   sp[1],
-				CgroupPermissions: cgp,
+				PathOnHost:        dm.PathOnHost,
+				PathInContainer:   dm.PathInContainer,
+				CgroupPermissions: dm.CgroupPermissions,
 			})
 		}
 
+		// Select an alternate container runtime (e.g. nvidia) if the service asks for one. The runtime was checked
+		// against the allow list when the agreement was made, but the allow list might have changed since.
+		if err := service.CheckRuntime(w.Config.LiveEdge().RuntimeAllowList); err != nil {
+			return nil, fmt.Errorf("service %v: %v", serviceName, err)
+		} else if service.Runtime != "" {
+			serviceConfig.HostConfig.Runtime = service.Runtime
+		}
+
 		services[serviceName] = servicePair{
 			serviceConfig: serviceConfig,
 			service:       service,
@@ -476,14 +511,19 @@ func (w *ContainerWorker) finalizeDeployment(agreementId string, deployment *con
 type ContainerWorker struct {
 	worker.BaseWorker // embedded field
 	db                *bolt.DB
-	client            *docker.Client
+	client            ContainerRuntime
 	iptables          *iptables.IPTables
 	authMgr           *resource.AuthenticationManager
 	pattern           string
 	isDevInstance     bool
+	healthStates      map[string]*healthCheckState // The health check state of the running containers, by container name.
+	vault             *vault.Client                // Created when a service variable first refers to Vault.
+	vaultConfig       config.VaultConfig           // The Vault config that the Vault client was created with.
+	objects           *objectsync.Syncer           // The objects that the services refer to, nil when no object service is configured.
+	pruning           int32                        // Set while the superseded images are pruned in the background.
 }
 
-func (cw *ContainerWorker) GetClient() *docker.Client {
+func (cw *ContainerWorker) GetClient() ContainerRuntime {
 	return cw.client
 }
 
@@ -537,7 +577,7 @@ func NewContainerWorker(name string, config *config.HorizonConfig, db *bolt.DB,
 
 	var err error
 	var ipt *iptables.IPTables
-	var client *docker.Client
+	var client ContainerRuntime
 
 	ipt, err = iptables.New()
 	if err != nil {
@@ -550,9 +590,9 @@ func NewContainerWorker(name string, config *config.HorizonConfig, db *bolt.DB,
 	}
 
 	if config.Edge.DockerEndpoint != "" {
-		client, err = docker.NewClient(config.Edge.DockerEndpoint)
+		client, err = NewContainerRuntime(config)
 		if err != nil {
-			glog.Errorf("Failed to instantiate docker Client: %v", err)
+			glog.Errorf("Failed to instantiate %v Client: %v", config.GetContainerRuntime(), err)
 			eventlog.LogNodeEvent(db, persistence.SEVERITY_FATAL,
 				persistence.NewMessageMeta(EL_CONT_TERM_UNABLE_INIT_DOCKER_CLIENT, err.Error()),
 				persistence.EC_ERROR_CREATE_DOCKER_CLIENT,
@@ -566,13 +606,21 @@ func NewContainerWorker(name string, config *config.HorizonConfig, db *bolt.DB,
 		pattern = dev.Pattern
 	}
 
+	// The services that refer to objects fail to start when the object cache cannot be used.
+	objects, err := newObjectSyncer(config)
+	if err != nil {
+		glog.Errorf("Failed to set up the object cache, error %v", err)
+	}
+
 	worker := &ContainerWorker{
-		BaseWorker: worker.NewBaseWorker(name, config, nil),
-		db:         db,
-		client:     client,
-		iptables:   ipt,
-		authMgr:    am,
-		pattern:    pattern,
+		BaseWorker:   worker.NewBaseWorker(name, config, nil),
+		db:           db,
+		client:       client,
+		iptables:     ipt,
+		authMgr:      am,
+		pattern:      pattern,
+		healthStates: make(map[string]*healthCheckState),
+		objects:      objects,
 	}
 	worker.SetDeferredDelay(15)
 
@@ -676,34 +724,40 @@ func (w *ContainerWorker) NewEvent(incoming events.Message) {
 	return
 }
 
-func MakeBridge(client *docker.Client, name string, infrastructure bool, sharedPattern bool) (*docker.Network, error) {
+// Create a bridge network for a set of containers. The network options (subnet, MTU, ICC, IPv6) come from the node's network
+// configuration, or for the network of an agreement or service instance from the one its service's network attribute
+// changes. A nil network configuration uses the docker defaults.
+func MakeBridge(client ContainerRuntime, name string, infrastructure bool, sharedPattern bool, netConfig *config.NetworkConfig) (*docker.Network, error) {
 
 	// Labels on the docker network indicate attributes about the network.
 	labels := make(map[string]string)
 	labels[LABEL_PREFIX+".network"] = ""
+	labels[LABEL_PREFIX+".owner"] = LABEL_OWNER
+	labels[LABEL_PREFIX+".created"] = strconv.FormatInt(time.Now().Unix(), 10)
 	if infrastructure {
 		labels[LABEL_PREFIX+".infrastructure"] = ""
 	}
 	if sharedPattern {
 		labels[LABEL_PREFIX+".service_pattern.shared"] = "singleton"
+	} else {
+		// the agreement or service instance that the network belongs to
+		labels[LABEL_PREFIX+".agreement_id"] = name
+	}
+
+	ipam, options, enableIPv6, err := bridgeOptions(client, netConfig)
+	if err != nil {
+		return nil, err
 	}
 
 	bridgeOpts := docker.CreateNetworkOptions{
 		Name:           name,
-		EnableIPv6:     false,
+		EnableIPv6:     enableIPv6,
 		Internal:       false,
 		Driver:         "bridge",
 		CheckDuplicate: true,
-		IPAM: &docker.IPAMOptions{
-			Driver: "default",
-			Config: []docker.IPAMConfig{},
-		},
-		Options: map[string]interface{}{
-			"com.docker.network.bridge.enable_icc":           "true",
-			"com.docker.network.bridge.enable_ip_masquerade": "true",
-			"com.docker.network.bridge.default_bridge":       "false",
-		},
-		Labels: labels,
+		IPAM:           ipam,
+		Options:        options,
+		Labels:         labels,
 	}
 
 	bridge, err := client.CreateNetwork(bridgeOpts)
@@ -713,7 +767,7 @@ func MakeBridge(client *docker.Client, name string, infrastructure bool, sharedP
 	return bridge, nil
 }
 
-func serviceStart(client *docker.Client,
+func serviceStart(client ContainerRuntime,
 	agreementId string,
 	serviceName string,
 	shareLabel string,
@@ -745,7 +799,8 @@ func serviceStart(client *docker.Client,
 		containerOpts.HostConfig.LogConfig = docker.LogConfig{}
 	}
 
-	glog.V(5).Infof("CreateContainer options: Config: %v, HostConfig: %v, EndpointsConfig: %v", serviceConfig.Config, serviceConfig.HostConfig, endpointsConfig)
+	logged := withoutSecrets(serviceConfig, nil)
+	glog.V(5).Infof("CreateContainer options: Config: %v, HostConfig: %v, EndpointsConfig: %v", logged.Config, logged.HostConfig, endpointsConfig)
 
 	container, cErr := client.CreateContainer(containerOpts)
 	if cErr != nil {
@@ -798,7 +853,7 @@ func serviceStart(client *docker.Client,
 	return nil
 }
 
-func serviceDestroy(client *docker.Client, agreementId string, containerId string) (bool, error) {
+func serviceDestroy(client ContainerRuntime, agreementId string, containerId string) (bool, error) {
 	glog.V(3).Infof("Attempting to stop container %v from agreement: %v.", containerId, agreementId)
 	err := client.KillContainer(docker.KillContainerOptions{ID: containerId})
 
@@ -814,7 +869,7 @@ func serviceDestroy(client *docker.Client, agreementId string, containerId strin
 	return true, client.RemoveContainer(docker.RemoveContainerOptions{ID: containerId, RemoveVolumes: true, Force: true})
 }
 
-func existingShared(client *docker.Client, serviceName string, servicePair *servicePair, bridgeName string, shareLabel string) (*docker.Network, *docker.APIContainers, error) {
+func existingShared(client ContainerRuntime, serviceName string, servicePair *servicePair, bridgeName string, shareLabel string) (*docker.Network, *docker.APIContainers, error) {
 
 	var sBridge docker.Network
 	networks, err := client.ListNetworks()
@@ -943,7 +998,7 @@ func generatePermittedString(isolation *containermessage.NetworkIsolation, netwo
 	return fmt.Sprintf("%v%v/%v", permittedString, network.IPAddress, network.IPPrefixLen), nil
 }
 
-func processPostCreate(ipt *iptables.IPTables, client *docker.Client, agreementId string, deployment containermessage.DeploymentDescription, configureRaw []byte, hasSpecifiedEthAccount bool, containers []interface{}, fail func(container *docker.Container, name string, err error) error) error {
+func processPostCreate(ipt *iptables.IPTables, client ContainerRuntime, agreementId string, deployment containermessage.DeploymentDescription, configureRaw []byte, hasSpecifiedEthAccount bool, containers []interface{}, fail func(container *docker.Container, name string, err error) error) error {
 	// check if any of the service containers require iptables manipulation to limit outbound traffic. If not, skip this step
 	requiresProcessPostCreate := false
 	for _, con := range containers {
@@ -1119,6 +1174,110 @@ func (b *ContainerWorker) workloadStorageDir(agreementId string) (string, bool)
 	}
 }
 
+// Return the host devices and host paths that the deployment configs are allowed to use. The allow list set through
+// the node API takes precedence over the one in the anax configuration file.
+func (b *ContainerWorker) hostAccessAllowList() *persistence.HostAccessAllowList {
+	live := b.Config.LiveEdge()
+	allowList, err := persistence.GetHostAccessAllowList(b.db, live.DeviceAllowList, live.HostPathAllowList)
+	if err != nil {
+		glog.Errorf("ContainerWorker unable to read the host access allow list, using the configured allow lists. Error: %v", err)
+		return &persistence.HostAccessAllowList{Devices: live.DeviceAllowList, HostPaths: live.HostPathAllowList}
+	}
+	return allowList
+}
+
+// Returns the docker restart policy for the containers of the given service instance. Docker restarts the containers
+// when the service's restart policy is "always". Otherwise docker leaves failed containers alone, the governance worker
+// finds the failed service and restarts it with backoff, up to the retry limit of the "on-failure" policy.
+func (b *ContainerWorker) serviceRestartPolicy(msInstKey string) docker.RestartPolicy {
+	if b.db == nil {
+		return docker.AlwaysRestart()
+	}
+
+	if msinst, err := persistence.FindMicroserviceInstanceWithKey(b.db, msInstKey); err != nil || msinst == nil {
+		glog.Warningf("Unable to find service instance %v to get its restart policy, the containers will always be restarted. %v", msInstKey, err)
+	} else if policy, _, err := persistence.GetServiceRestartPolicy(b.db, msinst.SpecRef, msinst.Org, b.Config.GetServiceRestartPolicy()); err != nil {
+		glog.Warningf("Unable to get the restart policy of service instance %v, the containers will always be restarted. %v", msInstKey, err)
+	} else if policy != config.SERVICE_RESTART_POLICY_ALWAYS {
+		return docker.NeverRestart()
+	}
+	return docker.AlwaysRestart()
+}
+
+// Returns the service url and org of the given agreement's workload or of the given service instance, so that the
+// attributes that apply to the service can be found. The last return value is false if the service is not found.
+func (b *ContainerWorker) serviceOf(agreementId string, agreementProtocol string) (string, string, bool) {
+	if agreementProtocol != "" {
+		if ags, err := persistence.FindEstablishedAgreements(b.db, agreementProtocol, []persistence.EAFilter{persistence.UnarchivedEAFilter(), persistence.IdEAFilter(agreementId)}); err != nil || len(ags) != 1 {
+			glog.Warningf("Unable to find agreement %v to get its service attributes. %v", agreementId, err)
+			return "", "", false
+		} else {
+			return ags[0].RunningWorkload.URL, ags[0].RunningWorkload.Org, true
+		}
+	} else if msinst, err := persistence.FindMicroserviceInstanceWithKey(b.db, agreementId); err != nil || msinst == nil {
+		glog.Warningf("Unable to find service instance %v to get its service attributes. %v", agreementId, err)
+		return "", "", false
+	} else {
+		return msinst.SpecRef, msinst.Org, true
+	}
+}
+
+// Returns the env vars with the Vault references replaced by the secrets they refer to. The secrets are only in the
+// env vars given to the container, they are never stored or logged. The Vault client is created again when the Vault
+// config has been reloaded since it was created.
+func (b *ContainerWorker) resolveVaultReferences(env map[string]string) (map[string]string, error) {
+	names := vault.ReferencingEnvvars(env)
+	vaultConfig := b.Config.LiveEdge().Vault
+	if len(names) == 0 {
+		return env, nil
+	} else if vaultConfig.Address == "" {
+		return nil, fmt.Errorf("the service variables %v refer to Vault but Vault is not configured, set Edge.Vault in the anax config", strings.Join(names, ", "))
+	}
+
+	if b.vault == nil || b.vaultConfig != vaultConfig {
+		client, err := vault.NewClient(vaultConfig)
+		if err != nil {
+			return nil, err
+		}
+		b.vault = client
+		b.vaultConfig = vaultConfig
+	}
+
+	glog.V(3).Infof("Resolving the Vault references of the service variables %v", names)
+	resolved, err := b.vault.ResolveEnv(env)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve the Vault references of the service variables, %v", err)
+	}
+	return resolved, nil
+}
+
+// Returns a copy of the service config in which the env vars that hold secrets read from Vault have their Vault
+// reference as value, or a mask when the reference is not known. The copy is what is logged and saved in the database.
+func withoutSecrets(serviceConfig *persistence.ServiceConfig, references map[string]string) persistence.ServiceConfig {
+	safe := *serviceConfig
+	names, ok := serviceConfig.Config.Labels[LABEL_PREFIX+".vault_envvars"]
+	if !ok {
+		return safe
+	}
+
+	secrets := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		secrets[name] = true
+	}
+	safe.Config.Env = make([]string, 0, len(serviceConfig.Config.Env))
+	for _, v := range serviceConfig.Config.Env {
+		name := strings.SplitN(v, "=", 2)[0]
+		if !secrets[name] {
+			safe.Config.Env = append(safe.Config.Env, v)
+		} else if ref, ok := references[name]; ok {
+			safe.Config.Env = append(safe.Config.Env, fmt.Sprintf("%s=%v", name, ref))
+		} else {
+			safe.Config.Env = append(safe.Config.Env, fmt.Sprintf("%s=******", name))
+		}
+	}
+	return safe
+}
+
 // This function creates the containers, volumes, networks for the given agreement or service.
 func (b *ContainerWorker) ResourcesCreate(agreementId string, agreementProtocol string, deployment *containermessage.DeploymentDescription, configureRaw []byte, environmentAdditions map[string]string, ms_networks map[string]string, serviceURL string, sVer string) (persistence.DeploymentConfig, error) {
 
@@ -1167,6 +1326,21 @@ func (b *ContainerWorker) ResourcesCreate(agreementId string, agreementProtocol
 		return endpoints
 	}
 
+	// Resolve the service variables that refer to Vault before anything is created, a secret that cannot be read
+	// fails the container start. The references are kept for the deployment config that is saved.
+	references := environmentAdditions
+	secretEnvvars := vault.ReferencingEnvvars(environmentAdditions)
+	if resolved, err := b.resolveVaultReferences(environmentAdditions); err != nil {
+		return nil, err
+	} else {
+		environmentAdditions = resolved
+	}
+
+	// Get the objects that the services refer to before their containers are created, they see them when they start.
+	if err := b.syncObjects(agreementId, deployment); err != nil {
+		return nil, err
+	}
+
 	workloadRWStorageDir, useVolume := b.workloadStorageDir(agreementId)
 
 	if !useVolume {
@@ -1188,8 +1362,11 @@ func (b *ContainerWorker) ResourcesCreate(agreementId string, agreementProtocol
 		}
 	} else {
 		// The volume has been specified in the binds section of the deployment config in the WorkloadConfigureCommand and
-		// ContainerConfigureCommand command handler section.
-		// The volume will be created later if it does not exist.
+		// ContainerConfigureCommand command handler section. Create it here so that it carries the labels that identify
+		// it as owned by anax, otherwise docker would create it without labels when the container is created.
+		if err := b.createWorkloadStorageVolume(workloadRWStorageDir, agreementId); err != nil {
+			return nil, err
+		}
 	}
 
 	// Create the MMS authentication credentials for this container. The only time we need the service version to be
@@ -1202,11 +1379,62 @@ func (b *ContainerWorker) ResourcesCreate(agreementId string, agreementProtocol
 		glog.Errorf("Failed to create MMS Authentication credential file for %v, error %v", agreementId, err)
 	}
 
-	servicePairs, err := b.finalizeDeployment(agreementId, deployment, environmentAdditions, workloadRWStorageDir, b.Config.Edge.DefaultCPUSet, b.Config.GetFileSyncServiceAPIUnixDomainSocketPath())
+	// Agreement workloads are always restarted by docker, dependent services follow their restart policy.
+	restartPolicy := docker.AlwaysRestart()
+	if agreementProtocol == "" {
+		restartPolicy = b.serviceRestartPolicy(agreementId)
+	}
+
+	// A health check attribute applies to all the containers of the service, in place of their deployment config health checks.
+	if hc := b.healthCheckOverride(agreementId, agreementProtocol); hc != nil {
+		for _, service := range deployment.Services {
+			check := *hc
+			service.HealthCheck = &check
+		}
+	}
+
+	// A cpu pinning attribute applies to all the containers of the service, in place of their deployment config cpu pinning.
+	if cp := b.cpuPinningOverride(agreementId, agreementProtocol); cp != nil {
+		for _, service := range deployment.Services {
+			service.CPUSet = cp.CPUSet
+			service.CPURealtimeRuntime = cp.CPURealtimeRuntime
+		}
+	}
+
+	if err := b.checkCPUPinning(agreementId, deployment); err != nil {
+		return nil, err
+	}
+
+	if err := b.checkPorts(agreementId, deployment); err != nil {
+		return nil, err
+	}
+
+	// Record the cpu pinning of the service containers, so that it is visible in the service status.
+	if agreementProtocol == "" && b.db != nil {
+		pinning := make(map[string]containermessage.CPUPinning)
+		for serviceName, service := range deployment.Services {
+			if service.CPUSet != "" || service.CPURealtimeRuntime != 0 {
+				pinning[serviceName] = containermessage.CPUPinning{CPUSet: service.CPUSet, CPURealtimeRuntime: service.CPURealtimeRuntime}
+			}
+		}
+		if _, err := persistence.UpdateMSInstanceCPUPinning(b.db, agreementId, pinning); err != nil {
+			glog.Errorf("Failed to record the cpu pinning of service instance %v, error %v", agreementId, err)
+		}
+	}
+
+	servicePairs, err := b.finalizeDeployment(agreementId, deployment, environmentAdditions, workloadRWStorageDir, b.Config.Edge.DefaultCPUSet, b.Config.GetFileSyncServiceAPIUnixDomainSocketPath(), restartPolicy)
 	if err != nil {
 		return nil, err
 	}
 
+	// Label the containers with the env vars that hold secrets, so that the secrets are kept out of the logs and
+	// the saved deployment config.
+	if len(secretEnvvars) != 0 {
+		for _, servicePair := range servicePairs {
+			servicePair.serviceConfig.Config.Labels[LABEL_PREFIX+".vault_envvars"] = strings.Join(secretEnvvars, ",")
+		}
+	}
+
 	// process services that are "shared" first, then others
 	shared := make(map[string]servicePair, 0)
 	private := make(map[string]servicePair, 0)
@@ -1236,7 +1464,7 @@ func (b *ContainerWorker) ResourcesCreate(agreementId string, agreementProtocol
 			}
 		}
 
-		ret.Services[serviceName] = *servicePair.serviceConfig
+		ret.Services[serviceName] = withoutSecrets(servicePair.serviceConfig, references)
 	}
 
 	// Now that we know we are going to process this deployment, save the deployment config before we create any docker resources.
@@ -1297,7 +1525,7 @@ func (b *ContainerWorker) ResourcesCreate(agreementId string, agreementProtocol
 		}
 
 		if existingNetwork == nil {
-			existingNetwork, err = MakeBridge(b.client, bridgeName, deployment.Infrastructure, true)
+			existingNetwork, err = MakeBridge(b.client, bridgeName, deployment.Infrastructure, true, &b.Config.Edge.Network)
 			glog.V(2).Infof("Created new network for shared container: %v. Network: %v", containerName, existingNetwork)
 			if err != nil {
 				return nil, fail(nil, containerName, fmt.Errorf("Unable to create bridge for shared container. Original error: %v", err))
@@ -1340,13 +1568,26 @@ func (b *ContainerWorker) ResourcesCreate(agreementId string, agreementProtocol
 			}
 			if agBridge == nil {
 				glog.V(5).Infof("Making network %v", agreementId)
-				newBridge, err := MakeBridge(b.client, agreementId, deployment.Infrastructure, false)
+				netConfig, err := b.networkConfig(agreementId, agreementProtocol)
+				if err != nil {
+					return nil, err
+				}
+				newBridge, err := MakeBridge(b.client, agreementId, deployment.Infrastructure, false, netConfig)
 				if err != nil {
 					return nil, err
 				}
 				agBridge = newBridge
 			}
 		}
+
+		// Record the subnet of the agreement network so that it is visible in the agreement status.
+		if agreementProtocol != "" {
+			if subnet, subnetIPv6 := NetworkSubnets(b.client, agBridge); subnet != "" || subnetIPv6 != "" {
+				if _, err := persistence.AgreementNetworkSubnetUpdate(b.db, agreementId, agreementProtocol, subnet, subnetIPv6); err != nil {
+					glog.Errorf("Unable to record network subnets %v %v for agreement %v, error %v", subnet, subnetIPv6, agreementId, err)
+				}
+			}
+		}
 	}
 
 	// add ms endpoints to the sharedEndpoints
@@ -1377,6 +1618,8 @@ func (b *ContainerWorker) ResourcesCreate(agreementId string, agreementProtocol
 		return nil, err
 	}
 
+	b.recordPublishedPorts(agreementId, agreementProtocol)
+
 	for name, _ := range ret.Services {
 		glog.V(1).Infof("Created service %v in agreement %v", name, agreementId)
 	}
@@ -1385,6 +1628,14 @@ func (b *ContainerWorker) ResourcesCreate(agreementId string, agreementProtocol
 
 func (b *ContainerWorker) Initialize() bool {
 	b.syncupResources()
+
+	// run the health checks of the service containers
+	b.DispatchSubworker(HEALTH_CHECK, b.checkContainerHealth, HEALTH_CHECK_TICK_S, true)
+
+	// keep the objects without a version at their latest version
+	if b.objects != nil {
+		b.DispatchSubworker(OBJECT_SYNC, b.checkObjectUpdates, int(b.Config.Edge.ObjectSync.GetPollInterval().Seconds()), false)
+	}
 	return true
 }
 
@@ -1444,9 +1695,27 @@ func (b *ContainerWorker) CommandHandler(command worker.Command) bool {
 				}
 			}
 
+			allowList := b.hostAccessAllowList()
+			mounts := make(map[string][]string)
+
 			// Dynamically add in a filesystem mapping so that the workload container has a RO filesystem.
 			for serviceName, service := range deploymentDesc.Services {
 
+				// The host paths were checked against the allow list when the agreement was made, but the allow list might
+				// have changed since.
+				if err := service.CheckBinds(allowList.HostPaths); err != nil {
+					eventlog.LogAgreementEvent(b.db, persistence.SEVERITY_ERROR,
+						persistence.NewMessageMeta(EL_CONT_DEPLOYCONF_UNSUPPORT_BIND, cmd.AgreementLaunchContext.Configure.Deployment, err.Error()),
+						persistence.EC_ERROR_IN_DEPLOYMENT_CONFIG, ags[0])
+					glog.Errorf("Deployment config for service %v contains unsupported bind, %v", serviceName, err)
+					b.Messages() <- events.NewWorkloadMessage(events.EXECUTION_FAILED, cmd.AgreementLaunchContext.AgreementProtocol, agreementId, nil)
+					return true
+				}
+				service.RestrictBinds(allowList.HostPaths)
+				if len(service.Binds) != 0 {
+					mounts[serviceName] = append([]string{}, service.Binds...)
+				}
+
 				if !service.Privileged {
 					glog.V(5).Infof("Checking bind permissions for service %v", serviceName)
 					if err := hasValidBindPermissions(service.Binds); err != nil {
@@ -1485,8 +1754,18 @@ func (b *ContainerWorker) CommandHandler(command worker.Command) bool {
 			} else {
 				glog.Infof("Success starting pattern for agreement: %v, protocol: %v, serviceNames: %v", agreementId, cmd.AgreementLaunchContext.AgreementProtocol, deploymentConfig.ToString())
 
+				// Record the host paths that the workload containers are given, so that they are visible in the agreement.
+				if len(mounts) != 0 {
+					if _, err := persistence.AgreementMountsUpdate(b.db, agreementId, cmd.AgreementLaunchContext.AgreementProtocol, mounts); err != nil {
+						glog.Errorf("Failed to record the mounts of agreement %v, error %v", agreementId, err)
+					}
+				}
+
 				// perhaps add the tc info to the container message so it can be enforced
 				b.Messages() <- events.NewWorkloadMessage(events.EXECUTION_BEGUN, cmd.AgreementLaunchContext.AgreementProtocol, agreementId, deploymentConfig)
+
+				// the images of the previous version of the workload might now be superseded
+				b.pruneImages()
 			}
 		}
 
@@ -1573,8 +1852,25 @@ func (b *ContainerWorker) CommandHandler(command worker.Command) bool {
 
 		serviceNames := deploymentDesc.ServiceNames()
 
+		allowList := b.hostAccessAllowList()
+		mounts := make(map[string][]string)
+
 		for serviceName, service := range deploymentDesc.Services {
 
+			if err := service.CheckBinds(allowList.HostPaths); err != nil {
+				eventlog.LogServiceEvent2(b.db, persistence.SEVERITY_ERROR,
+					persistence.NewMessageMeta(EL_CONT_DEPLOYCONF_UNSUPPORT_BIND_FOR, lc.Configure.Deployment, serviceName, err.Error()),
+					persistence.EC_ERROR_IN_DEPLOYMENT_CONFIG,
+					"", serviceInfo.URL, serviceInfo.Org, serviceInfo.Version, "", lc.AgreementIds)
+				glog.Errorf("Deployment config for service %v contains unsupported bind, %v", serviceName, err)
+				b.Messages() <- events.NewContainerMessage(events.EXECUTION_FAILED, *cmd.ContainerLaunchContext, "", "")
+				return true
+			}
+			service.RestrictBinds(allowList.HostPaths)
+			if len(service.Binds) != 0 {
+				mounts[serviceName] = append([]string{}, service.Binds...)
+			}
+
 			if !service.Privileged {
 				glog.V(5).Infof("Checking bind permissions for service %v", serviceName)
 				if err := hasValidBindPermissions(service.Binds); err != nil {
@@ -1619,6 +1915,13 @@ func (b *ContainerWorker) CommandHandler(command worker.Command) bool {
 			}
 		}
 
+		// Record the host paths that the service containers are given, so that they are visible in the service status.
+		if b.db != nil && lc.Blockchain.Name == "" {
+			if _, err := persistence.UpdateMSInstanceMounts(b.db, lc.Name, mounts); err != nil {
+				glog.Errorf("Failed to record the mounts of service instance %v, error %v", lc.Name, err)
+			}
+		}
+
 		// Indicate that this deployment description is part of the infrastructure
 		deploymentDesc.Infrastructure = true
 
@@ -1670,6 +1973,9 @@ func (b *ContainerWorker) CommandHandler(command worker.Command) bool {
 			} else {
 				b.Messages() <- events.NewContainerMessage(events.EXECUTION_BEGUN, *cmd.ContainerLaunchContext, deploymentDesc.Services[serviceNames[0]].GetSpecificHostBinding(), deploymentDesc.Services[serviceNames[0]].GetSpecificHostPortBinding())
 			}
+
+			// the images of the previous version of the service might now be superseded
+			b.pruneImages()
 		}
 
 	case *ContainerMaintenanceCommand:
@@ -1726,6 +2032,7 @@ func (b *ContainerWorker) CommandHandler(command worker.Command) bool {
 		if err := b.ResourcesRemove(agreements); err != nil {
 			glog.Errorf("Error removing resources: %v", err)
 		}
+		b.removeOrphanedResources(false)
 
 		// send the event to let others know that the workload clean up has been processed
 		b.Messages() <- events.NewWorkloadMessage(events.WORKLOAD_DESTROYED, cmd.AgreementProtocol, cmd.CurrentAgreementId, nil)
@@ -1795,6 +2102,7 @@ func (b *ContainerWorker) CommandHandler(command worker.Command) bool {
 		if err := b.ResourcesRemove(agreements); err != nil {
 			glog.Errorf("Error removing resources: %v", err)
 		}
+		b.removeOrphanedResources(false)
 
 		// send the event to let others know that the microservice clean up has been processed
 		b.Messages() <- events.NewMicroserviceContainersDestroyedMessage(events.CONTAINER_DESTROYED, cmd.MsInstKey)
@@ -2043,6 +2351,9 @@ func (b *ContainerWorker) syncupResources() {
 		}
 	}
 
+	// remove the networks and volumes left behind by agreements and services that no longer exist
+	b.removeOrphanedResources(b.GetExchangeToken() == "")
+
 	glog.V(3).Infof("ContainerWorker done syncing docker resources, successful: %v.", outcome)
 	// Finally issue an event to tell everyone else that we are done with the sync up, and the final status of it.
 	b.Messages() <- events.NewDeviceContainersSyncedMessage(events.DEVICE_CONTAINERS_SYNCED, outcome)
@@ -2099,7 +2410,7 @@ func (b *ContainerWorker) GatherAndCreateDependencyNetworks(dependencyContainers
 			glog.Errorf("failure listing network %v, error %v", nwForParentSvc, err)
 			continue
 		} else if len(nws) == 0 {
-			if newNetwork, err := MakeBridge(b.client, nwForParentSvc, true, false); err != nil {
+			if newNetwork, err := MakeBridge(b.client, nwForParentSvc, true, false, &b.Config.Edge.Network); err != nil {
 				glog.Errorf("Could not create parent specific network %v for service: %v", nwForParentSvc, err)
 				continue
 			} else {
@@ -2206,7 +2517,7 @@ func (b *ContainerWorker) restoreDependencyServiceNetworks(networkName string, p
 		if nws, err := b.client.FilteredListNetworks(docker.NetworkFilterOpts{"name": {nwForParentSvc: true}}); err != nil {
 			return fmt.Errorf("failure listing network %v, error %v", nwForParentSvc, err)
 		} else if len(nws) == 0 {
-			if newNetwork, err := MakeBridge(b.client, nwForParentSvc, true, false); err != nil {
+			if newNetwork, err := MakeBridge(b.client, nwForParentSvc, true, false, &b.Config.Edge.Network); err != nil {
 				return fmt.Errorf("Could not create parent specific network %v for service: %v", nwForParentSvc, err)
 			} else {
 				parentSpecificNetwork = newNetwork
@@ -2370,6 +2681,13 @@ func (b *ContainerWorker) ResourcesRemove(agreements []string) error {
 			glog.Errorf("Failed to remove FSS Authentication credential file for %v, error %v", agreementId, err)
 		}
 
+		// Remove the object directory, the objects stay in the cache for other agreements until they are evicted.
+		if b.objects != nil {
+			if err := b.objects.Remove(agreementId); err != nil {
+				glog.Errorf("Failed to remove the objects of %v, error %v", agreementId, err)
+			}
+		}
+
 	}
 
 	// gather agreement networks to free
@@ -2640,7 +2958,7 @@ func (b *ContainerWorker) findParentContainersForService(msinst_key string) ([]d
 		// Add top level services to the list of potential parent microservice instances. A container can be belong to a dependent service
 		// and top level service at the same time.
 		for _, tmsi := range top_level_msinsts {
-			if tmsi.SpecRef == api_spec.URL && tmsi.Org == api_spec.Org && tmsi.Version == api_spec.Version {
+			if cutil.SameSpecURL(tmsi.SpecRef, api_spec.URL) && tmsi.Org == api_spec.Org && tmsi.Version == api_spec.Version {
 				msinsts = append(msinsts, tmsi)
 			}
 		}
@@ -2806,7 +3124,7 @@ func (b *ContainerWorker) createDockerVolumesForContainer(serviceName string, ag
 					Labels: map[string]string{
 						LABEL_PREFIX + ".service_name": serviceName,
 						LABEL_PREFIX + ".agreement_id": agreementId,
-						LABEL_PREFIX + ".owner":        "openhorizon"},
+						LABEL_PREFIX + ".owner":        LABEL_OWNER},
 				}
 
 				if _, err := b.client.CreateVolume(vOption); err != nil {
@@ -2864,7 +3182,7 @@ func DeleteLeftoverDockerVolumes(db *bolt.DB, config *config.HorizonConfig) erro
 		return nil
 	}
 
-	if client, err := docker.NewClient(config.Edge.DockerEndpoint); err != nil {
+	if client, err := NewContainerRuntime(config); err != nil {
 		return fmt.Errorf("Failed to instantiate docker Client: %v", err)
 	} else {
 		// check existing docker volumes
@@ -2883,7 +3201,7 @@ func DeleteLeftoverDockerVolumes(db *bolt.DB, config *config.HorizonConfig) erro
 			found := false
 			for _, dv := range volumes_docker {
 				if cv.Name == dv.Name {
-					if dv.Labels != nil && dv.Labels[LABEL_PREFIX+".owner"] == "openhorizon" {
+					if dv.Labels != nil && dv.Labels[LABEL_PREFIX+".owner"] == LABEL_OWNER {
 						found = true
 						break
 					}
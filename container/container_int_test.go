@@ -63,11 +63,18 @@ func tConfig() *config.HorizonConfig {
 		panic(err)
 	}
 
+	// The tests run against docker unless the CI system selects another container runtime and endpoint.
+	endpoint := "unix:///var/run/docker.sock"
+	if ep := os.Getenv("HORIZON_TEST_CONTAINER_ENDPOINT"); ep != "" {
+		endpoint = ep
+	}
+
 	return &config.HorizonConfig{
 		Edge: config.Config{
-			DockerEndpoint: "unix:///var/run/docker.sock",
-			DefaultCPUSet:  "0-1",
-			ServiceStorage: workloadStorageDir,
+			DockerEndpoint:   endpoint,
+			ContainerRuntime: os.Getenv("HORIZON_TEST_CONTAINER_RUNTIME"),
+			DefaultCPUSet:    "0-1",
+			ServiceStorage:   workloadStorageDir,
 		},
 	}
 }
@@ -5,8 +5,20 @@ package container
 import (
 	"encoding/json"
 	docker "github.com/fsouza/go-dockerclient"
+	"github.com/open-horizon/anax/config"
 	"github.com/open-horizon/anax/containermessage"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/worker"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func Test_UnmarshalNetworkIsolation(t *testing.T) {
@@ -271,3 +283,302 @@ func Test_CheckPermissions_0(t *testing.T) {
 	}
 
 }
+
+func Test_allocateSubnet(t *testing.T) {
+	_, used1, _ := net.ParseCIDR("172.30.0.0/24")
+	_, used2, _ := net.ParseCIDR("172.30.1.128/25")
+
+	if subnet, err := allocateSubnet("172.30.0.0/16", 24, []*net.IPNet{used1, used2}); err != nil {
+		t.Errorf("unexpected error allocating subnet: %v", err)
+	} else if subnet.String() != "172.30.2.0/24" {
+		t.Errorf("expected subnet 172.30.2.0/24, got %v", subnet)
+	}
+
+	_, all, _ := net.ParseCIDR("10.0.0.0/8")
+	if subnet, err := allocateSubnet("10.1.0.0/16", 24, []*net.IPNet{all}); err == nil {
+		t.Errorf("expected an error because the pool is fully in use, got %v", subnet)
+	}
+
+	if _, err := allocateSubnet("10.1.0.0/16", 8, []*net.IPNet{}); err == nil {
+		t.Errorf("expected an error because the prefix length does not fit in the pool")
+	}
+
+	_, used6, _ := net.ParseCIDR("fd00:6a78::/64")
+	if subnet, err := allocateSubnet("fd00:6a78::/48", 64, []*net.IPNet{used1, used6}); err != nil {
+		t.Errorf("unexpected error allocating IPv6 subnet: %v", err)
+	} else if subnet.String() != "fd00:6a78:0:1::/64" {
+		t.Errorf("expected subnet fd00:6a78:0:1::/64, got %v", subnet)
+	}
+}
+
+func Test_ContainerRuntime_docker(t *testing.T) {
+	var rt ContainerRuntime = &docker.Client{}
+	if _, ok := rt.(*docker.Client); !ok {
+		t.Errorf("expected the docker client to be a container runtime")
+	}
+}
+
+func Test_qualifyImageName(t *testing.T) {
+	tests := map[string]string{
+		"busybox":                             "docker.io/busybox",
+		"openhorizon/amd64_cpu:1.2.2":         "docker.io/openhorizon/amd64_cpu:1.2.2",
+		"docker.io/openhorizon/amd64_cpu":     "docker.io/openhorizon/amd64_cpu",
+		"quay.io/org/image:1.0":               "quay.io/org/image:1.0",
+		"myrepo:5000/image":                   "myrepo:5000/image",
+		"localhost/image:dev":                 "localhost/image:dev",
+		"sha256:0123456789abcdef0123456789ab": "sha256:0123456789abcdef0123456789ab",
+		"":                                    "",
+	}
+
+	for image, expected := range tests {
+		if actual := qualifyImageName(image); actual != expected {
+			t.Errorf("image %v was qualified as %v, expected %v", image, actual, expected)
+		}
+	}
+}
+
+func Test_podmanNetworkOptions(t *testing.T) {
+	options := map[string]interface{}{
+		"com.docker.network.bridge.enable_ip_masquerade": "true",
+		"com.docker.network.bridge.default_bridge":       "false",
+		"com.docker.network.bridge.enable_icc":           "false",
+		"com.docker.network.driver.mtu":                  "1400",
+	}
+
+	pOptions := podmanNetworkOptions(options)
+	if len(pOptions) != 1 || pOptions["com.docker.network.driver.mtu"] != "1400" {
+		t.Errorf("expected only the MTU option to be kept, got %v", pOptions)
+	}
+
+	if len(podmanNetworkOptions(nil)) != 0 {
+		t.Errorf("expected no options")
+	}
+}
+
+func Test_orphanedResources(t *testing.T) {
+	owners := map[string]bool{"ag1": true}
+
+	anaxNetwork := func(name string, owner string) docker.Network {
+		labels := map[string]string{LABEL_PREFIX + ".network": "", LABEL_PREFIX + ".owner": LABEL_OWNER}
+		if owner != "" {
+			labels[LABEL_PREFIX+".agreement_id"] = owner
+		}
+		return docker.Network{Name: name, Labels: labels}
+	}
+
+	networks := []docker.Network{
+		anaxNetwork("ag1", "ag1"),
+		anaxNetwork("ag2", "ag2"),
+		anaxNetwork("ag3", ""),
+		anaxNetwork("ms1_ag4", "ms1_ag4"),
+		{Name: "shared", Labels: map[string]string{LABEL_PREFIX + ".network": "", LABEL_PREFIX + ".service_pattern.shared": "singleton"}},
+		{Name: "bridge", Labels: map[string]string{}},
+	}
+
+	volumes := []docker.Volume{
+		{Name: "v1", Labels: map[string]string{LABEL_PREFIX + ".owner": LABEL_OWNER, LABEL_PREFIX + ".agreement_id": "ag1"}},
+		{Name: "v2", Labels: map[string]string{LABEL_PREFIX + ".owner": LABEL_OWNER, LABEL_PREFIX + ".agreement_id": "ag2"}},
+		{Name: "v3", Labels: map[string]string{LABEL_PREFIX + ".owner": LABEL_OWNER, LABEL_PREFIX + ".agreement_id": "ag5"}},
+		{Name: "v4"},
+	}
+
+	// a container outside of any agreement still uses network ms1_ag4 and volume v3
+	containers := []docker.APIContainers{
+		{
+			Networks: docker.NetworkList{Networks: map[string]docker.ContainerNetwork{"ms1_ag4": {}}},
+			Mounts:   []docker.APIMount{{Name: "v3"}, {Source: "/tmp"}},
+		},
+	}
+
+	expected := map[string]OrphanedResource{
+		"ag2": {Type: RESOURCE_TYPE_NETWORK, Name: "ag2", Owner: "ag2"},
+		"ag3": {Type: RESOURCE_TYPE_NETWORK, Name: "ag3", Owner: "ag3"},
+		"v2":  {Type: RESOURCE_TYPE_VOLUME, Name: "v2", Owner: "ag2"},
+	}
+
+	orphans := orphanedResources(owners, containers, networks, volumes, time.Now())
+	if len(orphans) != len(expected) {
+		t.Errorf("expected %v orphans, got %v", len(expected), orphans)
+	}
+	for _, o := range orphans {
+		if e, ok := expected[o.Name]; !ok || e != o {
+			t.Errorf("unexpected orphan %v", o)
+		}
+	}
+
+	// volumes are not looked at when they are not listed
+	if orphans := orphanedResources(owners, containers, networks, nil, time.Now()); len(orphans) != 2 {
+		t.Errorf("expected 2 orphaned networks, got %v", orphans)
+	}
+}
+
+func Test_orphanedResources_recent(t *testing.T) {
+	now := time.Now()
+	network := func(name string, created time.Time) docker.Network {
+		return docker.Network{Name: name, Labels: map[string]string{
+			LABEL_PREFIX + ".network":      "",
+			LABEL_PREFIX + ".owner":        LABEL_OWNER,
+			LABEL_PREFIX + ".agreement_id": name,
+			LABEL_PREFIX + ".created":      strconv.FormatInt(created.Unix(), 10),
+		}}
+	}
+	volume := func(name string, created time.Time) docker.Volume {
+		return docker.Volume{Name: name, CreatedAt: created, Labels: map[string]string{LABEL_PREFIX + ".owner": LABEL_OWNER, LABEL_PREFIX + ".agreement_id": name}}
+	}
+
+	// the new agreement's network and volume were created after the owners were read
+	networks := []docker.Network{network("old", now.Add(-time.Hour)), network("new", now.Add(-time.Minute))}
+	volumes := []docker.Volume{volume("old", now.Add(-time.Hour)), volume("new", now.Add(-time.Minute))}
+
+	orphans := orphanedResources(map[string]bool{}, nil, networks, volumes, now)
+	if len(orphans) != 2 {
+		t.Errorf("expected only the old network and volume to be orphaned, got %v", orphans)
+	}
+	for _, o := range orphans {
+		if o.Name != "old" {
+			t.Errorf("the recently created %v should not be orphaned", o)
+		}
+	}
+}
+
+func Test_prunableImages(t *testing.T) {
+	pulled := []persistence.PulledImage{
+		{Image: "gps:1.0.0", Repository: "gps", ImageID: "id1", PullTime: 100},
+		{Image: "gps:1.1.0", Repository: "gps", ImageID: "id2", PullTime: 200},
+		{Image: "gps:1.2.0", Repository: "gps", ImageID: "id3", PullTime: 300},
+		{Image: "gps:1.3.0", Repository: "gps", ImageID: "id4", PullTime: 400},
+		{Image: "cpu:1.0.0", Repository: "cpu", ImageID: "id5", PullTime: 100},
+		{Image: "cpu:2.0.0", Repository: "cpu", ImageID: "id6", PullTime: 2000},
+		{Image: "cpu@sha256:abc", Repository: "cpu", ImageID: "id1", PullTime: 50},
+	}
+
+	// gps 1.3.0 is running and 1.2.0 is kept for rollback. cpu 2.0.0 was pulled within the grace time and cpu 1.0.0
+	// is kept for rollback.
+	referenced := map[string]bool{"id4": true}
+	prunable := prunableImages(pulled, referenced, 1, 1000)
+
+	expected := []string{"cpu@sha256:abc", "gps:1.0.0", "gps:1.1.0"}
+	if len(prunable) != len(expected) {
+		t.Fatalf("expected %v prunable images, got %v", expected, prunable)
+	}
+	for i, pi := range prunable {
+		if pi.Image != expected[i] {
+			t.Errorf("expected prunable image %v, got %v", expected[i], pi.Image)
+		}
+	}
+
+	// nothing is kept for rollback
+	if prunable := prunableImages(pulled, referenced, 0, 1000); len(prunable) != 5 {
+		t.Errorf("expected 5 prunable images, got %v", prunable)
+	}
+
+	// an image id that is kept through one of its names is never pruned
+	referenced["id1"] = true
+	if prunable := prunableImages(pulled, referenced, 1, 1000); len(prunable) != 1 || prunable[0].Image != "gps:1.1.0" {
+		t.Errorf("expected only gps:1.1.0 to be prunable, got %v", prunable)
+	}
+}
+
+func Test_hostPortAvailable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "procnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 127.0.0.1:8080 listens, 0.0.0.0:9090 is connected and [::]:5353 is an unconnected udp socket.
+	tcp := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1 1 0 100 0 0 10 0
+   1: 00000000:2382 0100007F:A1B2 01 00000000:00000000 00:00000000 00000000     0        0 2 1 0 100 0 0 10 0
+`
+	udp6 := `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  0: 00000000000000000000000000000000:14E9 00000000000000000000000000000000:0000 07 00000000:00000000 00:00000000 00000000   0        0 3 2 0 0
+`
+	if err := ioutil.WriteFile(path.Join(dir, "tcp"), []byte(tcp), 0644); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(path.Join(dir, "udp6"), []byte(udp6), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		port  containermessage.PublishedPort
+		inUse bool
+	}{
+		{containermessage.PublishedPort{HostPort: 8080, Protocol: "tcp", HostIP: "127.0.0.1"}, true},
+		{containermessage.PublishedPort{HostPort: 8080, Protocol: "tcp"}, true},
+		{containermessage.PublishedPort{HostPort: 8080, Protocol: "tcp", HostIP: "10.0.0.1"}, false},
+		{containermessage.PublishedPort{HostPort: 9090, Protocol: "tcp"}, false},
+		{containermessage.PublishedPort{HostPort: 5353, Protocol: "udp", HostIP: "10.0.0.1"}, true},
+		{containermessage.PublishedPort{HostPort: 5353, Protocol: "tcp"}, false},
+	}
+	for _, test := range tests {
+		if err := hostPortAvailable(dir, test.port); (err != nil) != test.inUse {
+			t.Errorf("port %v: expected in use %v, got error %v", test.port, test.inUse, err)
+		}
+	}
+}
+
+func Test_parseProcNetAddress(t *testing.T) {
+	if ip, port, err := parseProcNetAddress("0100007F:1F90"); err != nil || ip != "127.0.0.1" || port != 8080 {
+		t.Errorf("expected 127.0.0.1:8080, got %v:%v, error %v", ip, port, err)
+	} else if ip, port, err := parseProcNetAddress("00000000000000000000000001000000:0016"); err != nil || ip != "::1" || port != 22 {
+		t.Errorf("expected [::1]:22, got %v:%v, error %v", ip, port, err)
+	} else if _, _, err := parseProcNetAddress("0100007F"); err == nil {
+		t.Errorf("an address without a port should not be parsed")
+	}
+}
+
+func Test_withoutSecrets(t *testing.T) {
+	serviceConfig := &persistence.ServiceConfig{
+		Config: docker.Config{
+			Env:    []string{"HZN_ORG_ID=myorg", "apiKey=s3cret", "dbPassword=pa55"},
+			Labels: map[string]string{LABEL_PREFIX + ".vault_envvars": "apiKey,dbPassword"},
+		},
+	}
+
+	saved := withoutSecrets(serviceConfig, map[string]string{"apiKey": "vault:secret/data/edge#apiKey"})
+	if expected := []string{"HZN_ORG_ID=myorg", "apiKey=vault:secret/data/edge#apiKey", "dbPassword=******"}; !reflect.DeepEqual(saved.Config.Env, expected) {
+		t.Errorf("expected env %v, got %v", expected, saved.Config.Env)
+	} else if serviceConfig.Config.Env[1] != "apiKey=s3cret" {
+		t.Errorf("the env of the container should not be changed, got %v", serviceConfig.Config.Env)
+	}
+
+	delete(serviceConfig.Config.Labels, LABEL_PREFIX+".vault_envvars")
+	if unchanged := withoutSecrets(serviceConfig, nil); !reflect.DeepEqual(unchanged.Config.Env, serviceConfig.Config.Env) {
+		t.Errorf("expected the env of a container without secrets unchanged, got %v", unchanged.Config.Env)
+	}
+}
+
+func Test_resolveVaultReferences_reload(t *testing.T) {
+	newVault := func(secret string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/v1/auth/token/lookup-self" {
+				w.Write([]byte(`{"data": {"ttl": 0}}`))
+			} else {
+				w.Write([]byte(`{"data": {"apiKey": "` + secret + `"}}`))
+			}
+		}))
+	}
+	first := newVault("first")
+	defer first.Close()
+	second := newVault("second")
+	defer second.Close()
+
+	cfg := &config.HorizonConfig{Edge: config.Config{Vault: config.VaultConfig{Address: first.URL, Token: "token"}}}
+	w := &ContainerWorker{BaseWorker: worker.NewBaseWorker("test", cfg, nil)}
+	env := map[string]string{"apiKey": "vault:secret/edge#apiKey"}
+
+	if resolved, err := w.resolveVaultReferences(env); err != nil || resolved["apiKey"] != "first" {
+		t.Errorf("expected the secret from the first Vault, got %v, error %v", resolved, err)
+	}
+
+	cfg.Edge.Vault.Address = second.URL
+	if resolved, err := w.resolveVaultReferences(env); err != nil || resolved["apiKey"] != "second" {
+		t.Errorf("expected the secret from the reloaded Vault config, got %v, error %v", resolved, err)
+	}
+
+	cfg.Edge.Vault.Address = ""
+	if _, err := w.resolveVaultReferences(env); err == nil {
+		t.Errorf("expected an error when Vault is no longer configured")
+	}
+}
@@ -0,0 +1,103 @@
+package container
+
+import (
+	"fmt"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/containermessage"
+	"github.com/open-horizon/anax/persistence"
+)
+
+// The labels that hold the cpus a container is pinned to, and the agreement or service instance that owns the pinning.
+const LABEL_CPUSET = LABEL_PREFIX + ".cpuset"
+const LABEL_CPUSET_OWNER = LABEL_PREFIX + ".cpuset_owner"
+
+// The capability and the realtime priority limit that a container needs to use realtime scheduling.
+const CAP_SYS_NICE = "SYS_NICE"
+const RTPRIO_MAX = 99
+
+// Returns the cpu pinning attribute that overrides the cpu pinning in the deployment config of the given agreement or
+// service instance, nil if there is none.
+func (b *ContainerWorker) cpuPinningOverride(agreementId string, agreementProtocol string) *containermessage.CPUPinning {
+	if b.db == nil {
+		return nil
+	}
+
+	url, org, found := b.serviceOf(agreementId, agreementProtocol)
+	if !found {
+		return nil
+	}
+
+	if attr, err := persistence.FindCPUPinningAttribute(b.db, url, org); err != nil {
+		glog.Warningf("Unable to get the cpu pinning attribute of service %v/%v. %v", org, url, err)
+	} else if attr != nil {
+		return &attr.CPUPinning
+	}
+	return nil
+}
+
+// Verify that the cpu pinning of the deployment is allowed on this node, and that the cpus are not already pinned by
+// the containers of another agreement or service instance. The pinning was checked when the agreement was made, but
+// the node or the other agreements might have changed since.
+func (b *ContainerWorker) checkCPUPinning(owner string, deployment *containermessage.DeploymentDescription) error {
+	pinned := false
+	for _, service := range deployment.Services {
+		pinned = pinned || service.CPUSet != "" || service.CPURealtimeRuntime != 0
+	}
+	if !pinned {
+		return nil
+	}
+
+	live := b.Config.LiveEdge()
+	online, err := containermessage.OnlineCPUs("")
+	if err != nil {
+		return err
+	} else if err := deployment.CheckCPUPinning(online, live.CPUSetAllowList, live.MaxCPURealtimeRuntime); err != nil {
+		return err
+	}
+
+	// Only the running containers use their cpus, the containers of a replaced agreement might not be removed yet.
+	containers, err := b.client.ListContainers(docker.ListContainersOptions{
+		Filters: map[string][]string{"label": []string{LABEL_CPUSET}},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list the pinned containers, error %v", err)
+	}
+
+	for serviceName, service := range deployment.Services {
+		if service.CPUSet == "" {
+			continue
+		}
+		for _, c := range containers {
+			if c.Labels[LABEL_CPUSET_OWNER] == owner {
+				continue
+			} else if overlap := containermessage.CPUSetOverlap(service.CPUSet, c.Labels[LABEL_CPUSET]); len(overlap) != 0 {
+				return fmt.Errorf("service %v: cpus %v of cpuset %v are already pinned by container %v of %v", serviceName, overlap, service.CPUSet, c.Names, c.Labels[LABEL_CPUSET_OWNER])
+			}
+		}
+	}
+	return nil
+}
+
+// Apply the cpu pinning of the service to the container configuration.
+func applyCPUPinning(owner string, service *containermessage.Service, serviceConfig *persistence.ServiceConfig) {
+	if service.CPUSet != "" {
+		serviceConfig.HostConfig.CPUSetCPUs = service.CPUSet
+		serviceConfig.Config.CPUSet = service.CPUSet
+		serviceConfig.Config.Labels[LABEL_CPUSET] = service.CPUSet
+		serviceConfig.Config.Labels[LABEL_CPUSET_OWNER] = owner
+	}
+
+	if service.CPURealtimeRuntime != 0 {
+		serviceConfig.HostConfig.CPURealtimeRuntime = service.CPURealtimeRuntime
+		serviceConfig.HostConfig.Ulimits = append(serviceConfig.HostConfig.Ulimits, docker.ULimit{Name: "rtprio", Soft: RTPRIO_MAX, Hard: RTPRIO_MAX})
+
+		hasCap := false
+		for _, c := range serviceConfig.HostConfig.CapAdd {
+			hasCap = hasCap || c == CAP_SYS_NICE
+		}
+		if !hasCap {
+			serviceConfig.HostConfig.CapAdd = append(serviceConfig.HostConfig.CapAdd, CAP_SYS_NICE)
+		}
+	}
+}
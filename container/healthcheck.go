@@ -0,0 +1,313 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/containermessage"
+	"github.com/open-horizon/anax/eventlog"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const HEALTH_CHECK = "HealthCheck"
+
+// How often the health check subworker looks for containers whose health check is due.
+const HEALTH_CHECK_TICK_S = 5
+
+// The label that holds the health check of a container, in JSON.
+const LABEL_HEALTH_CHECK = LABEL_PREFIX + ".health_check"
+
+// The in memory state of the health check of a running container.
+type healthCheckState struct {
+	serviceName string
+	nextCheck   time.Time
+	failures    int
+	unhealthy   bool
+	actionTaken bool // The cancel action has been requested, the container is about to be removed.
+}
+
+// Returns the health check attribute that overrides the health checks in the deployment config of the given agreement
+// or service instance, nil if there is none.
+func (b *ContainerWorker) healthCheckOverride(agreementId string, agreementProtocol string) *containermessage.HealthCheck {
+	if b.db == nil {
+		return nil
+	}
+
+	url, org, found := b.serviceOf(agreementId, agreementProtocol)
+	if !found {
+		return nil
+	}
+
+	if attr, err := persistence.FindHealthCheckAttribute(b.db, url, org); err != nil {
+		glog.Warningf("Unable to get the health check attribute of service %v/%v. %v", org, url, err)
+	} else if attr != nil {
+		return &attr.HealthCheck
+	}
+	return nil
+}
+
+// The health check subworker. Runs the health checks that are due, concurrently, then acts on the results.
+func (b *ContainerWorker) checkContainerHealth() int {
+	if b.db == nil || b.client == nil {
+		return 0
+	}
+
+	containers, err := b.client.ListContainers(docker.ListContainersOptions{
+		Filters: map[string][]string{"label": []string{LABEL_HEALTH_CHECK}},
+	})
+	if err != nil {
+		glog.Errorf("ContainerWorker unable to list containers for health checks, error %v", err)
+		return 0
+	}
+
+	now := time.Now()
+	current := make(map[string]bool)
+	type result struct {
+		container docker.APIContainers
+		check     containermessage.HealthCheck
+		err       error
+	}
+	results := make(chan result, len(containers))
+	var wg sync.WaitGroup
+
+	for _, c := range containers {
+		name := containerName(c)
+		current[name] = true
+
+		var hc containermessage.HealthCheck
+		if err := json.Unmarshal([]byte(c.Labels[LABEL_HEALTH_CHECK]), &hc); err != nil {
+			glog.Errorf("ContainerWorker unable to read the health check of container %v, error %v", name, err)
+			continue
+		}
+
+		state, ok := b.healthStates[name]
+		if !ok {
+			// Give a new container one interval to start up before checking it.
+			state = &healthCheckState{serviceName: c.Labels[LABEL_PREFIX+".service_name"], nextCheck: now.Add(time.Duration(hc.GetInterval()) * time.Second)}
+			b.healthStates[name] = state
+		}
+		if state.actionTaken || now.Before(state.nextCheck) {
+			continue
+		}
+		state.nextCheck = now.Add(time.Duration(hc.GetInterval()) * time.Second)
+
+		wg.Add(1)
+		go func(c docker.APIContainers, hc containermessage.HealthCheck) {
+			defer wg.Done()
+			results <- result{container: c, check: hc, err: b.runHealthCheck(c, &hc)}
+		}(c, hc)
+	}
+
+	wg.Wait()
+	close(results)
+
+	for r := range results {
+		b.recordHealthCheckResult(r.container, &r.check, r.err)
+	}
+
+	// Forget the containers that are gone.
+	for name, state := range b.healthStates {
+		if !current[name] {
+			containerUnhealthy.Delete(name, state.serviceName)
+			delete(b.healthStates, name)
+		}
+	}
+	if records, err := persistence.FindContainerHealth(b.db); err != nil {
+		glog.Errorf("ContainerWorker unable to retrieve container health from database, error %v", err)
+	} else {
+		for _, r := range records {
+			if !current[r.ContainerName] {
+				if err := persistence.DeleteContainerHealth(b.db, r.ContainerName); err != nil {
+					glog.Errorf("ContainerWorker unable to delete health of container %v from database, error %v", r.ContainerName, err)
+				}
+			}
+		}
+	}
+
+	return 0
+}
+
+// Returns the name of the container without the leading slash.
+func containerName(c docker.APIContainers) string {
+	if len(c.Names) == 0 {
+		return c.ID
+	}
+	name := c.Names[0]
+	if len(name) != 0 && name[0] == '/' {
+		name = name[1:]
+	}
+	return name
+}
+
+// Returns the address that the container can be reached on from anax. Containers on the host network are reached on the
+// loopback address.
+func containerAddress(c docker.APIContainers) string {
+	for _, nw := range c.Networks.Networks {
+		if nw.IPAddress != "" {
+			return nw.IPAddress
+		}
+	}
+	return "127.0.0.1"
+}
+
+// Run the health check against the container, returns an error describing the failure if the check fails.
+func (b *ContainerWorker) runHealthCheck(c docker.APIContainers, hc *containermessage.HealthCheck) error {
+	timeout := time.Duration(hc.GetTimeout()) * time.Second
+
+	if len(hc.Exec) != 0 {
+		exec, err := b.client.CreateExec(docker.CreateExecOptions{Container: c.ID, Cmd: hc.Exec})
+		if err != nil {
+			return fmt.Errorf("unable to create exec, error %v", err)
+		} else if err := b.client.StartExec(exec.ID, docker.StartExecOptions{Detach: true}); err != nil {
+			return fmt.Errorf("unable to start exec, error %v", err)
+		}
+		for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(500 * time.Millisecond) {
+			if inspect, err := b.client.InspectExec(exec.ID); err != nil {
+				return fmt.Errorf("unable to inspect exec, error %v", err)
+			} else if !inspect.Running {
+				if inspect.ExitCode != 0 {
+					return fmt.Errorf("command %v exited with %v", hc.Exec, inspect.ExitCode)
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("command %v timed out after %v", hc.Exec, timeout)
+
+	} else if hc.TCPPort != 0 {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(containerAddress(c), strconv.Itoa(hc.TCPPort)), timeout)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+		return nil
+
+	} else {
+		client := http.Client{Timeout: timeout}
+		resp, err := client.Get(fmt.Sprintf("http://%v%v", net.JoinHostPort(containerAddress(c), strconv.Itoa(hc.HTTPPort)), hc.GetHTTPPath()))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("GET %v returned status %v", hc.GetHTTPPath(), resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// Update the health of the container with the result of a check, and take the health check action when the container
+// becomes unhealthy. The database is only written when the health changes.
+func (b *ContainerWorker) recordHealthCheckResult(c docker.APIContainers, hc *containermessage.HealthCheck, checkErr error) {
+	name := containerName(c)
+	state := b.healthStates[name]
+	serviceName := c.Labels[LABEL_PREFIX+".service_name"]
+	owner := c.Labels[LABEL_PREFIX+".agreement_id"]
+
+	health, err := persistence.FindContainerHealthWithName(b.db, name)
+	if err != nil {
+		glog.Errorf("ContainerWorker unable to retrieve health of container %v from database, error %v", name, err)
+	}
+	if health == nil {
+		health = persistence.NewContainerHealth(name, owner, serviceName)
+	}
+
+	if checkErr == nil {
+		state.failures = 0
+		recordHealthCheck(name, serviceName, nil, false)
+		if !state.unhealthy && health.ConsecutiveFailures == 0 {
+			return
+		}
+		glog.V(3).Infof("ContainerWorker container %v passed its health check", name)
+		if state.unhealthy {
+			b.logOwnerEvent(owner, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_CONT_CONTAINER_HEALTHY, name, serviceName), persistence.EC_CONTAINER_HEALTHY)
+			health.LastChangeTime = uint64(time.Now().Unix())
+		}
+		state.unhealthy = false
+		health.Status = persistence.CONTAINER_HEALTHY
+		health.ConsecutiveFailures = 0
+		b.saveContainerHealth(health)
+		return
+	}
+
+	state.failures++
+	glog.Warningf("ContainerWorker container %v failed its health check (%v of %v), error %v", name, state.failures, hc.GetFailureThreshold(), checkErr)
+	health.ConsecutiveFailures = state.failures
+	health.LastFailure = checkErr.Error()
+
+	if state.failures < hc.GetFailureThreshold() {
+		recordHealthCheck(name, serviceName, checkErr, state.unhealthy)
+		b.saveContainerHealth(health)
+		return
+	}
+
+	if !state.unhealthy {
+		health.LastChangeTime = uint64(time.Now().Unix())
+	}
+	state.unhealthy = true
+	state.failures = 0
+	health.Status = persistence.CONTAINER_UNHEALTHY
+	recordHealthCheck(name, serviceName, checkErr, true)
+	b.saveContainerHealth(health)
+
+	b.logOwnerEvent(owner, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_CONT_CONTAINER_UNHEALTHY, name, serviceName, hc.GetFailureThreshold(), checkErr.Error()), persistence.EC_CONTAINER_UNHEALTHY)
+
+	// Shared containers have no owner, the agreements that use them cannot be cancelled for them so they are restarted.
+	if hc.GetAction() == containermessage.HEALTH_CHECK_ACTION_CANCEL && owner != "" {
+		state.actionTaken = true
+		healthCheckActions.Inc(serviceName, containermessage.HEALTH_CHECK_ACTION_CANCEL)
+		if ag := b.findHealthCheckAgreement(owner); ag != nil {
+			glog.Infof("ContainerWorker cancelling agreement %v, container %v is unhealthy", owner, name)
+			b.Messages() <- events.NewWorkloadMessage(events.HEALTH_CHECK_FAILED, ag.AgreementProtocol, owner, nil)
+		} else {
+			glog.Infof("ContainerWorker stopping service instance %v, container %v is unhealthy", owner, name)
+			b.Messages() <- events.NewContainerMessage(events.HEALTH_CHECK_FAILED, events.ContainerLaunchContext{Name: owner}, "", "")
+		}
+		return
+	}
+
+	glog.Infof("ContainerWorker restarting unhealthy container %v", name)
+	healthCheckActions.Inc(serviceName, containermessage.HEALTH_CHECK_ACTION_RESTART)
+	if err := b.client.RestartContainer(c.ID, 10); err != nil {
+		glog.Errorf("ContainerWorker unable to restart unhealthy container %v, error %v", name, err)
+		b.logOwnerEvent(owner, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_CONT_HEALTH_CHECK_RESTART_ERROR, name, err.Error()), persistence.EC_CONTAINER_UNHEALTHY)
+	}
+	// Give the restarted container one interval to start up.
+	state.nextCheck = time.Now().Add(time.Duration(hc.GetInterval()) * time.Second)
+}
+
+func (b *ContainerWorker) saveContainerHealth(health *persistence.ContainerHealth) {
+	if err := persistence.SaveContainerHealth(b.db, health); err != nil {
+		glog.Errorf("ContainerWorker unable to save health of container %v, error %v", health.ContainerName, err)
+	}
+}
+
+// Returns the agreement with the given id, nil if the owner of the container is a service instance.
+func (b *ContainerWorker) findHealthCheckAgreement(owner string) *persistence.EstablishedAgreement {
+	if ags, err := persistence.FindEstablishedAgreementsAllProtocols(b.db, policy.AllAgreementProtocols(), []persistence.EAFilter{persistence.UnarchivedEAFilter(), persistence.IdEAFilter(owner)}); err != nil {
+		glog.Errorf("ContainerWorker unable to retrieve agreement %v from database, error %v", owner, err)
+	} else if len(ags) == 1 {
+		return &ags[0]
+	}
+	return nil
+}
+
+// Log an event against the agreement or service instance that owns the container or object.
+func (b *ContainerWorker) logOwnerEvent(owner string, severity string, meta *persistence.MessageMeta, code string) {
+	if owner == "" {
+		eventlog.LogNodeEvent(b.db, severity, meta, code, "", "", "", "")
+	} else if ag := b.findHealthCheckAgreement(owner); ag != nil {
+		eventlog.LogAgreementEvent(b.db, severity, meta, code, *ag)
+	} else if msinst, err := persistence.FindMicroserviceInstanceWithKey(b.db, owner); err == nil && msinst != nil {
+		eventlog.LogServiceEvent(b.db, severity, meta, code, *msinst)
+	} else {
+		eventlog.LogNodeEvent(b.db, severity, meta, code, "", "", "", "")
+	}
+}
@@ -0,0 +1,242 @@
+package container
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/containermessage"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Serializes the prunes of the images.
+var pruneLock sync.Mutex
+
+// Images pulled within this many seconds are never pruned, their containers might not have been created yet.
+const IMAGE_PRUNE_GRACE_S = 3600
+
+// A superseded image that was (or would be) removed by pruning.
+type PrunedImage struct {
+	Image   string `json:"image"`
+	ImageID string `json:"image_id"`
+	Size    int64  `json:"size"` // The bytes reclaimed by removing the image, 0 if another pruned image already accounted for them.
+}
+
+func (p PrunedImage) String() string {
+	return fmt.Sprintf("Image: %v, ImageID: %v, Size: %v", p.Image, p.ImageID, p.Size)
+}
+
+// The result of pruning the superseded images.
+type ImagePruneResult struct {
+	Images         []PrunedImage `json:"images"`
+	BytesReclaimed int64         `json:"bytes_reclaimed"`
+}
+
+// Returns the image name without its tag and digest. The versions of a service image share the repository.
+func ImageRepository(image string) string {
+	domain, path, _, _ := cutil.ParseDockerImagePath(image)
+	if domain == "" {
+		return path
+	}
+	return domain + "/" + path
+}
+
+// Returns the ids of the images that are still needed on this node: the images of all the containers, of the current
+// deployment of the active agreements, and of the registered services.
+func referencedImages(db *bolt.DB, client ContainerRuntime) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+
+	containers, err := client.ListContainers(docker.ListContainersOptions{All: true})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list containers, error %v", err)
+	}
+	// The image of a container is listed by name, or by id when the name has since been moved to another image. Both
+	// are resolved to the image id below.
+	names := make([]string, 0, 10)
+	for _, c := range containers {
+		names = append(names, c.Image)
+	}
+
+	agreements, err := persistence.FindEstablishedAgreementsAllProtocols(db, policy.AllAgreementProtocols(), []persistence.EAFilter{persistence.UnarchivedEAFilter()})
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve agreements from database, error %v", err)
+	}
+	for _, ag := range agreements {
+		for _, sc := range ag.CurrentDeployment {
+			names = append(names, sc.Config.Image)
+		}
+	}
+
+	msdefs, err := persistence.FindMicroserviceDefs(db, []persistence.MSFilter{persistence.UnarchivedMSFilter()})
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve service definitions from database, error %v", err)
+	}
+	for _, msdef := range msdefs {
+		if dd, err := containermessage.GetNativeDeployment(msdef.Deployment); err == nil {
+			for _, service := range dd.Services {
+				names = append(names, service.Image)
+			}
+		}
+	}
+
+	// Images that are not on the node cannot be pruned, so they need not be resolved.
+	for _, name := range names {
+		if image, err := client.InspectImage(name); err == nil && image != nil {
+			referenced[image.ID] = true
+		}
+	}
+
+	return referenced, nil
+}
+
+// Select the pulled images that can be pruned. The unreferenced images of each repository are ordered from the most
+// recently pulled, the first retain of them are kept for rollback and the rest are pruned. Images pulled since the
+// grace time are always kept. An image id that is kept through any of its names is not pruned.
+func prunableImages(pulled []persistence.PulledImage, referenced map[string]bool, retain int, graceTime uint64) []persistence.PulledImage {
+
+	byRepo := make(map[string][]persistence.PulledImage)
+	for _, pi := range pulled {
+		byRepo[pi.Repository] = append(byRepo[pi.Repository], pi)
+	}
+
+	kept := make(map[string]bool)
+	candidates := make([]persistence.PulledImage, 0)
+	for _, pis := range byRepo {
+		sort.Slice(pis, func(i, j int) bool { return pis[i].PullTime > pis[j].PullTime })
+
+		retained := 0
+		for _, pi := range pis {
+			if referenced[pi.ImageID] || pi.PullTime >= graceTime {
+				kept[pi.ImageID] = true
+			} else if retained < retain {
+				retained++
+				kept[pi.ImageID] = true
+			} else {
+				candidates = append(candidates, pi)
+			}
+		}
+	}
+
+	prunable := make([]persistence.PulledImage, 0, len(candidates))
+	for _, pi := range candidates {
+		if !kept[pi.ImageID] {
+			prunable = append(prunable, pi)
+		}
+	}
+	sort.Slice(prunable, func(i, j int) bool { return prunable[i].Image < prunable[j].Image })
+	return prunable
+}
+
+// Remove the images pulled by anax that have been superseded by newer versions and are no longer used, keeping the
+// configured number of previous versions of each image. When dryRun is true nothing is removed. When multiple anax
+// instances share the host, the images might be used by another instance, so nothing is removed. The prunes of the
+// container worker and of the API are run one at a time.
+func PruneImages(db *bolt.DB, cfg *config.HorizonConfig, dryRun bool) (*ImagePruneResult, error) {
+
+	pruneLock.Lock()
+	defer pruneLock.Unlock()
+
+	result := &ImagePruneResult{Images: []PrunedImage{}}
+
+	if cfg.Edge.DockerEndpoint == "" {
+		return nil, fmt.Errorf("Docker client cannot be initialized. Please make sure DockerEndpoint is set in the configuration file.")
+	} else if cfg.Edge.MultipleAnaxInstances {
+		glog.V(3).Infof("Multiple anax instances enabled, will not prune images.")
+		return result, nil
+	} else if cfg.LiveEdge().ImageRetentionCount < 0 {
+		glog.V(3).Infof("Image pruning is disabled.")
+		return result, nil
+	}
+
+	client, err := NewContainerRuntime(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to instantiate %v Client: %v", cfg.GetContainerRuntime(), err)
+	}
+
+	pulled, err := persistence.FindPulledImages(db)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve pulled images from database, error %v", err)
+	}
+
+	images, err := client.ListImages(docker.ListImagesOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list images, error %v", err)
+	}
+	sizes := make(map[string]int64)
+	for _, image := range images {
+		sizes[image.ID] = image.Size
+	}
+
+	// Forget the images that were removed from the node by other means.
+	present := make([]persistence.PulledImage, 0, len(pulled))
+	for _, pi := range pulled {
+		if _, ok := sizes[pi.ImageID]; ok {
+			present = append(present, pi)
+		} else if !dryRun {
+			if err := persistence.DeletePulledImage(db, pi.Image); err != nil {
+				glog.Errorf("Failed to delete pulled image %v from local db. %v", pi.Image, err)
+			}
+		}
+	}
+
+	referenced, err := referencedImages(db, client)
+	if err != nil {
+		return nil, err
+	}
+
+	graceTime := uint64(time.Now().Unix()) - IMAGE_PRUNE_GRACE_S
+	removedIDs := make(map[string]bool)
+	for _, pi := range prunableImages(present, referenced, cfg.LiveEdge().ImageRetentionCount, graceTime) {
+		if !dryRun && !removedIDs[pi.ImageID] {
+			if err := client.RemoveImage(pi.ImageID); err != nil {
+				// failure to remove one image should not prevent the process from going on
+				glog.Errorf("Failed to remove superseded image %v. %v", pi.Image, err)
+				continue
+			}
+			glog.V(3).Infof("Removed superseded image %v", pi)
+		}
+		if !dryRun {
+			if err := persistence.DeletePulledImage(db, pi.Image); err != nil {
+				glog.Errorf("Failed to delete pulled image %v from local db. %v", pi.Image, err)
+			}
+		}
+
+		pruned := PrunedImage{Image: pi.Image, ImageID: pi.ImageID}
+		if !removedIDs[pi.ImageID] {
+			pruned.Size = sizes[pi.ImageID]
+			removedIDs[pi.ImageID] = true
+		}
+		result.Images = append(result.Images, pruned)
+		result.BytesReclaimed += pruned.Size
+	}
+
+	return result, nil
+}
+
+// Prune the superseded images in the background, removing the images can take a while and the worker has to go on
+// with its commands. A prune that is requested while one is running is skipped, the running one sees the same images.
+// Errors are logged, they do not stop the caller.
+func (b *ContainerWorker) pruneImages() {
+	if b.db == nil {
+		return
+	} else if !atomic.CompareAndSwapInt32(&b.pruning, 0, 1) {
+		glog.V(5).Infof("ContainerWorker is already pruning superseded images")
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&b.pruning, 0)
+		if result, err := PruneImages(b.db, b.Config, false); err != nil {
+			glog.Errorf("ContainerWorker unable to prune superseded images, error: %v", err)
+		} else if len(result.Images) != 0 {
+			glog.Infof("ContainerWorker pruned superseded images %v, reclaimed %v bytes", result.Images, result.BytesReclaimed)
+		}
+	}()
+}
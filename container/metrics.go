@@ -0,0 +1,33 @@
+package container
+
+import (
+	"github.com/open-horizon/anax/metrics"
+)
+
+// The metrics of the health checks of the service containers, by the name of the service in the deployment config.
+var healthChecks = metrics.NewCounterVec("anax_container_health_checks_total",
+	"The health checks of the service containers, by service and result.", "service", "result")
+
+var healthCheckActions = metrics.NewCounterVec("anax_container_health_actions_total",
+	"The actions taken on the service containers that became unhealthy, by service and action (restart or cancel).",
+	"service", "action")
+
+var containerUnhealthy = metrics.NewGaugeVec("anax_container_unhealthy",
+	"Whether the service container is unhealthy (1) or healthy (0), by container and service.", "container", "service")
+
+// Record the result of a health check of the container, and whether the container is unhealthy after it.
+func recordHealthCheck(containerName string, serviceName string, checkErr error, unhealthy bool) {
+	if !metrics.Enabled() {
+		return
+	}
+	result := "success"
+	if checkErr != nil {
+		result = "failure"
+	}
+	healthChecks.Inc(serviceName, result)
+	if unhealthy {
+		containerUnhealthy.Set(1, containerName, serviceName)
+	} else {
+		containerUnhealthy.Set(0, containerName, serviceName)
+	}
+}
@@ -0,0 +1,222 @@
+package container
+
+import (
+	"fmt"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/persistence"
+	"math/big"
+	"net"
+	"strconv"
+)
+
+// Returns the subnets that are already in use on this host, both by existing docker networks and by the
+// addresses assigned to the host's network interfaces.
+func hostSubnetsInUse(client ContainerRuntime) ([]*net.IPNet, error) {
+	inUse := make([]*net.IPNet, 0, 10)
+
+	networks, err := client.ListNetworks()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list docker networks, error %v", err)
+	}
+	for _, nw := range networks {
+		for _, ipamCfg := range nw.IPAM.Config {
+			if _, subnet, err := net.ParseCIDR(ipamCfg.Subnet); err == nil {
+				inUse = append(inUse, subnet)
+			}
+		}
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list host interface addresses, error %v", err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			ip := ipNet.IP.To4()
+			if ip == nil {
+				ip = ipNet.IP
+			}
+			inUse = append(inUse, &net.IPNet{IP: ip.Mask(ipNet.Mask), Mask: ipNet.Mask})
+		}
+	}
+
+	return inUse, nil
+}
+
+func subnetsOverlap(a *net.IPNet, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// Find the first subnet of the given prefix length within the pool that does not overlap any of the subnets in use.
+// The pool can be IPv4 or IPv6.
+func allocateSubnet(pool string, prefixLen int, inUse []*net.IPNet) (*net.IPNet, error) {
+	_, poolNet, err := net.ParseCIDR(pool)
+	if err != nil {
+		return nil, fmt.Errorf("subnet pool %v is not a valid CIDR, error %v", pool, err)
+	}
+
+	poolIP := poolNet.IP.To4()
+	if poolIP == nil {
+		poolIP = poolNet.IP.To16()
+	}
+
+	poolLen, bits := poolNet.Mask.Size()
+	if prefixLen < poolLen || prefixLen > bits {
+		return nil, fmt.Errorf("subnet prefix length %v does not fit in subnet pool %v", prefixLen, pool)
+	}
+
+	start := new(big.Int).SetBytes(poolIP)
+	step := new(big.Int).Lsh(big.NewInt(1), uint(bits-prefixLen))
+
+	// a large IPv6 pool has more subnets than can be tried, the ones in use are few and at its start
+	count := uint64(1) << 16
+	if prefixLen-poolLen < 16 {
+		count = uint64(1) << uint(prefixLen-poolLen)
+	}
+
+	candidateIP := new(big.Int).Set(start)
+	for i := uint64(0); i < count; i++ {
+		// the big endian bytes of the address, left padded to the length of the pool address
+		ip := make(net.IP, len(poolIP))
+		b := candidateIP.Bytes()
+		copy(ip[len(ip)-len(b):], b)
+		candidate := &net.IPNet{IP: ip, Mask: net.CIDRMask(prefixLen, bits)}
+
+		free := true
+		for _, used := range inUse {
+			if subnetsOverlap(candidate, used) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return candidate, nil
+		}
+		candidateIP.Add(candidateIP, step)
+	}
+
+	return nil, fmt.Errorf("no free /%v subnet left in subnet pool %v", prefixLen, pool)
+}
+
+// Returns the network configuration of the network of the given agreement or service instance, the node's network
+// configuration with the options that the NetworkAttributes attribute of its service sets, if it has one.
+func (b *ContainerWorker) networkConfig(agreementId string, agreementProtocol string) (*config.NetworkConfig, error) {
+	netConfig := b.Config.Edge.Network
+	if b.db == nil {
+		return &netConfig, nil
+	}
+
+	url, org, found := b.serviceOf(agreementId, agreementProtocol)
+	if !found {
+		return &netConfig, nil
+	}
+
+	if attr, err := persistence.FindNetworkAttribute(b.db, url, org); err != nil {
+		glog.Warningf("Unable to get the network attribute of service %v/%v. %v", org, url, err)
+	} else if attr != nil {
+		netConfig = attr.Apply(netConfig)
+		if err := netConfig.Validate(); err != nil {
+			return nil, fmt.Errorf("the network attribute of service %v/%v does not fit the network configuration of the node, error %v", org, url, err)
+		}
+		glog.V(5).Infof("Using network configuration %v for %v, from the network attribute of service %v/%v", netConfig.String(), agreementId, org, url)
+	}
+	return &netConfig, nil
+}
+
+// Build the docker network creation options from the node's network configuration. A nil configuration results in the
+// docker defaults.
+func bridgeOptions(client ContainerRuntime, netConfig *config.NetworkConfig) (*docker.IPAMOptions, map[string]interface{}, bool, error) {
+
+	ipam := &docker.IPAMOptions{
+		Driver: "default",
+		Config: []docker.IPAMConfig{},
+	}
+
+	enableICC := true
+	enableIPv6 := false
+
+	options := map[string]interface{}{
+		"com.docker.network.bridge.enable_ip_masquerade": "true",
+		"com.docker.network.bridge.default_bridge":       "false",
+	}
+
+	if netConfig != nil {
+		enableICC = !netConfig.DisableICC
+		enableIPv6 = netConfig.EnableIPv6
+
+		if netConfig.MTU != 0 {
+			options["com.docker.network.driver.mtu"] = strconv.Itoa(netConfig.MTU)
+		}
+
+		// the subnets in use are only needed to allocate subnets from the pools
+		var inUse []*net.IPNet
+		if netConfig.SubnetPool != "" || (enableIPv6 && netConfig.IPv6SubnetPool != "") {
+			var err error
+			if inUse, err = hostSubnetsInUse(client); err != nil {
+				return nil, nil, false, err
+			}
+		}
+
+		if netConfig.SubnetPool != "" {
+			prefixLen := netConfig.SubnetPrefixLen
+			if prefixLen == 0 {
+				prefixLen = config.NetworkSubnetPrefixLen_DEFAULT
+			}
+
+			subnet, err := allocateSubnet(netConfig.SubnetPool, prefixLen, inUse)
+			if err != nil {
+				return nil, nil, false, err
+			}
+
+			glog.V(5).Infof("Allocated subnet %v from subnet pool %v", subnet, netConfig.SubnetPool)
+			ipam.Config = append(ipam.Config, docker.IPAMConfig{Subnet: subnet.String()})
+		}
+
+		// docker only enables IPv6 on a network that has an IPv6 subnet, from the pool or from the daemon config
+		if enableIPv6 && netConfig.IPv6SubnetPool != "" {
+			subnet, err := allocateSubnet(netConfig.IPv6SubnetPool, netConfig.GetIPv6SubnetPrefixLen(), inUse)
+			if err != nil {
+				return nil, nil, false, err
+			}
+
+			glog.V(5).Infof("Allocated IPv6 subnet %v from subnet pool %v", subnet, netConfig.IPv6SubnetPool)
+			ipam.Config = append(ipam.Config, docker.IPAMConfig{Subnet: subnet.String()})
+		}
+	}
+
+	options["com.docker.network.bridge.enable_icc"] = strconv.FormatBool(enableICC)
+
+	return ipam, options, enableIPv6, nil
+}
+
+// Returns the IPv4 and IPv6 subnets that docker assigned to the network, or an empty string for a subnet that the
+// network does not have or that cannot be determined.
+func NetworkSubnets(client ContainerRuntime, network *docker.Network) (string, string) {
+	if network == nil {
+		return "", ""
+	}
+
+	ipamConfig := network.IPAM.Config
+	if len(ipamConfig) == 0 {
+		if nw, err := client.NetworkInfo(network.ID); err != nil {
+			glog.Warningf("Unable to inspect network %v, error %v", network.Name, err)
+			return "", ""
+		} else {
+			ipamConfig = nw.IPAM.Config
+		}
+	}
+
+	ipv4, ipv6 := "", ""
+	for _, cfg := range ipamConfig {
+		if _, subnet, err := net.ParseCIDR(cfg.Subnet); err != nil {
+			continue
+		} else if subnet.IP.To4() != nil && ipv4 == "" {
+			ipv4 = cfg.Subnet
+		} else if subnet.IP.To4() == nil && ipv6 == "" {
+			ipv6 = cfg.Subnet
+		}
+	}
+	return ipv4, ipv6
+}
@@ -0,0 +1,108 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/containermessage"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/objectsync"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/rsapss-tool/verify"
+)
+
+// The name of the subworker that checks for new versions of the objects.
+const OBJECT_SYNC = "ObjectSync"
+
+// Creates the syncer of the objects that the services refer to, nil when no object service is configured.
+func newObjectSyncer(cfg *config.HorizonConfig) (*objectsync.Syncer, error) {
+	syncConfig := cfg.LiveEdge().ObjectSync
+	if syncConfig.URL == "" {
+		return nil, nil
+	}
+
+	timeout := syncConfig.GetDownloadTimeoutS()
+	syncer, err := objectsync.NewSyncer(&syncConfig, cfg.Collaborators.HTTPClientFactory.NewHTTPClient(&timeout), func(signature string, data []byte) error {
+		keyFileNames, err := cfg.Collaborators.KeyFileNamesFetcher.GetKeyFileNames(cfg.Edge.PublicKeyPath, cfg.UserPublicKeyPath())
+		if err != nil {
+			return fmt.Errorf("unable to get the public key files, %v", err)
+		}
+		if verified, _, failed := verify.InputVerifiedByAnyKey(keyFileNames, signature, data); !verified {
+			glog.Errorf("Unable to verify the object signature: %v", failed)
+			return errors.New("there is no public key that verifies the signature")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	syncer.SetLiveConfig(func() config.ObjectSyncConfig { return cfg.LiveEdge().ObjectSync })
+	return syncer, nil
+}
+
+// Puts the objects that the services of the deployment refer to in the object directory of the agreement or service
+// instance, and mounts the directory read-only in the containers of those services.
+func (b *ContainerWorker) syncObjects(agreementId string, deployment *containermessage.DeploymentDescription) error {
+	refs := make([]containermessage.ObjectReference, 0)
+	names := make(map[string]containermessage.ObjectReference)
+	for serviceName, service := range deployment.Services {
+		if err := service.ValidateObjects(); err != nil {
+			return fmt.Errorf("invalid objects for service %v: %v", serviceName, err)
+		}
+		// the services share the object directory, a name must refer to the same object in all of them
+		for _, ref := range service.Objects {
+			if other, ok := names[ref.Name]; !ok {
+				names[ref.Name] = ref
+				refs = append(refs, ref)
+			} else if other != ref {
+				return fmt.Errorf("object name %v of service %v refers to a different object in another service", ref.Name, serviceName)
+			}
+		}
+	}
+
+	if len(refs) == 0 {
+		return nil
+	} else if b.objects == nil {
+		return errors.New("the services refer to objects but the object service is not configured, set Edge.ObjectSync in the anax config")
+	}
+
+	if updated, err := b.objects.Sync(agreementId, refs); err != nil {
+		return fmt.Errorf("unable to get the objects of the services, %v", err)
+	} else {
+		glog.V(3).Infof("ContainerWorker synced objects for %v, updated %v", agreementId, updated)
+	}
+
+	for _, service := range deployment.Services {
+		if len(service.Objects) != 0 {
+			service.Binds = append(service.Binds, fmt.Sprintf("%v:%v:ro", b.objects.OwnerDir(agreementId), config.HZN_OBJECTS_MOUNT))
+			service.Environment = append(service.Environment, fmt.Sprintf("%vOBJECTS_DIR=%v", config.ENVVAR_PREFIX, config.HZN_OBJECTS_MOUNT))
+		}
+	}
+	return nil
+}
+
+// Checks the objects of all the agreements and service instances for new versions. The containers see a new version
+// in place of the old one, an event is logged and sent for each.
+func (b *ContainerWorker) checkObjectUpdates() int {
+	interval := int(b.Config.Edge.ObjectSync.GetPollInterval().Seconds())
+
+	owners, err := b.objects.Owners()
+	if err != nil {
+		glog.Errorf("ContainerWorker unable to list the object owners, error %v", err)
+		return interval
+	}
+
+	for _, owner := range owners {
+		updated, err := b.objects.Resync(owner)
+		if err != nil {
+			glog.Warningf("ContainerWorker unable to check the objects of %v for new versions, error %v", owner, err)
+		}
+		for _, obj := range updated {
+			glog.Infof("ContainerWorker object %v of %v is now %v version %v", obj.Name, owner, obj.Object, obj.Version)
+			b.logOwnerEvent(owner, persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_CONT_OBJECT_UPDATED, obj.Name, owner, obj.Version, obj.Object), persistence.EC_OBJECT_UPDATED)
+			b.Messages() <- events.NewObjectSyncMessage(events.OBJECT_UPDATED, owner, obj.Name, obj.Object, obj.Version, obj.Digest)
+		}
+	}
+	return interval
+}
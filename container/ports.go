@@ -0,0 +1,229 @@
+package container
+
+import (
+	"encoding/hex"
+	"fmt"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/containermessage"
+	"github.com/open-horizon/anax/persistence"
+	"io/ioutil"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Returns the port policy of the node, the deny all policy when none is set. Without a database, e.g. in the tests,
+// there is no policy and the requested ports are published as they are.
+func (b *ContainerWorker) portPolicy() (*persistence.PortPolicy, error) {
+	if b.db == nil {
+		return nil, nil
+	}
+	pp, err := persistence.FindEffectivePortPolicy(b.db)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the port policy, %v", err)
+	}
+	return pp, nil
+}
+
+// Verify that the host ports published by the deployment are allowed by the node's port policy and are not used by
+// other containers or processes. The ports were checked against the policy when the agreement was made, but the policy
+// might have changed since. The containers of shared services are reused when they exist, so their ports are not
+// checked for conflicts.
+func (b *ContainerWorker) checkPorts(agreementId string, deployment *containermessage.DeploymentDescription) error {
+	if pp, err := b.portPolicy(); err != nil {
+		return err
+	} else if pp != nil {
+		if err := pp.CheckDeployment(deployment); err != nil {
+			return err
+		}
+	}
+
+	type request struct {
+		serviceName string
+		port        containermessage.PublishedPort
+	}
+	requests := make([]request, 0)
+	for serviceName, service := range deployment.Services {
+		if deployment.ServicePattern.IsShared("singleton", serviceName) {
+			continue
+		}
+		published, err := service.PublishedPorts()
+		if err != nil {
+			return fmt.Errorf("service %v: %v", serviceName, err)
+		}
+		for _, p := range published {
+			if p.HostPort != 0 {
+				requests = append(requests, request{serviceName: serviceName, port: p})
+			}
+		}
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+
+	containers, err := b.client.ListContainers(docker.ListContainersOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to list the containers to check the published ports, %v", err)
+	}
+
+	for i, r := range requests {
+		for _, other := range requests[:i] {
+			if other.port.HostPort == r.port.HostPort && other.port.Protocol == r.port.Protocol && hostIPsOverlap(other.port.GetHostIP(), r.port.GetHostIP()) {
+				return fmt.Errorf("host port %v/%v is published by both service %v and service %v", r.port.HostPort, r.port.Protocol, other.serviceName, r.serviceName)
+			}
+		}
+
+		// the containers of the agreement that are still around are being replaced, the host sockets of their
+		// published ports are theirs
+		replaced := false
+		for _, c := range containers {
+			for _, cp := range c.Ports {
+				if int(cp.PublicPort) == r.port.HostPort && cp.Type == r.port.Protocol && hostIPsOverlap(cp.IP, r.port.GetHostIP()) {
+					if c.Labels[LABEL_PREFIX+".agreement_id"] == agreementId {
+						replaced = true
+					} else {
+						return fmt.Errorf("service %v: host port %v/%v on %v is already published by container %v", r.serviceName, r.port.HostPort, r.port.Protocol, r.port.GetHostIP(), c.Names)
+					}
+				}
+			}
+		}
+
+		if replaced {
+			continue
+		} else if err := hostPortAvailable(b.Config.Edge.HostPath(HOST_PROC_NET), r.port); err != nil {
+			return fmt.Errorf("service %v: host port %v/%v on %v is already in use on the host, %v", r.serviceName, r.port.HostPort, r.port.Protocol, r.port.GetHostIP(), err)
+		}
+	}
+	return nil
+}
+
+// Returns true if ports published on the two host addresses would collide, the unspecified address is all of them.
+func hostIPsOverlap(a string, b string) bool {
+	unspecified := func(ip string) bool {
+		return ip == "" || ip == "0.0.0.0" || ip == "::"
+	}
+	return a == b || unspecified(a) || unspecified(b)
+}
+
+// The directory of the socket tables of the network namespace of the host. The init process of the host is in it,
+// while anax might not be, e.g. when it runs in a container with its own network.
+const HOST_PROC_NET = "/proc/1/net"
+
+// Returns an error if a process on the host already listens on the port, as the socket tables of the host's network
+// namespace in procNetDir show. Tables that cannot be read, e.g. on a host without procfs, are left for docker to
+// report the conflict when the container starts.
+func hostPortAvailable(procNetDir string, p containermessage.PublishedPort) error {
+	for _, table := range []string{p.Protocol, p.Protocol + "6"} {
+		sockets, err := readProcNetSockets(path.Join(procNetDir, table), p.Protocol == "tcp")
+		if err != nil {
+			glog.V(5).Infof("ContainerWorker unable to check host port %v/%v, error %v", p.HostPort, p.Protocol, err)
+			continue
+		}
+		for _, s := range sockets {
+			if s.port == p.HostPort && hostIPsOverlap(s.ip, p.GetHostIP()) {
+				return fmt.Errorf("a process listens on %v", net.JoinHostPort(s.ip, strconv.Itoa(s.port)))
+			}
+		}
+	}
+	return nil
+}
+
+// A socket of a /proc/net table that holds a local port.
+type procNetSocket struct {
+	ip   string
+	port int
+}
+
+// The state of the tcp sockets that accept connections, in the st column of /proc/net/tcp.
+const TCP_LISTEN = "0A"
+
+// Reads the sockets of a /proc/net table, e.g. /proc/net/tcp or /proc/net/udp6, that hold a local port. For tcp, only
+// the listening sockets do, the connected ones share the port of a listening socket or have an ephemeral one.
+func readProcNetSockets(file string, listenOnly bool) ([]procNetSocket, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	sockets := make([]procNetSocket, 0)
+	lines := strings.Split(string(content), "\n")
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || (listenOnly && fields[3] != TCP_LISTEN) {
+			continue
+		}
+		ip, port, err := parseProcNetAddress(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", file, err)
+		}
+		sockets = append(sockets, procNetSocket{ip: ip, port: port})
+	}
+	return sockets, nil
+}
+
+// Parses a local address of a /proc/net table, e.g. 0100007F:1F90 for 127.0.0.1:8080. The address is in hex, as 32 bit
+// words in the byte order of the host, which is little endian on the architectures that anax runs on.
+func parseProcNetAddress(addr string) (string, int, error) {
+	parts := strings.Split(addr, ":")
+	if len(parts) != 2 {
+		return "", 0, fmt.Errorf("address %v is not an ip and a port", addr)
+	}
+	raw, err := hex.DecodeString(parts[0])
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", 0, fmt.Errorf("address %v does not have a valid ip", addr)
+	}
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("address %v does not have a valid port", addr)
+	}
+
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+	return ip.String(), int(port), nil
+}
+
+// Record the host ports that the containers of the agreement or service instance publish, including the ephemeral
+// ports that docker chose, so that they are visible in the agreement or service instance status.
+func (b *ContainerWorker) recordPublishedPorts(agreementId string, agreementProtocol string) {
+	if b.db == nil {
+		return
+	}
+
+	containers, err := b.client.ListContainers(docker.ListContainersOptions{
+		Filters: map[string][]string{"label": []string{fmt.Sprintf("%v.agreement_id=%v", LABEL_PREFIX, agreementId)}},
+	})
+	if err != nil {
+		glog.Errorf("ContainerWorker unable to list the containers of %v to record their published ports, error %v", agreementId, err)
+		return
+	}
+
+	ports := make(map[string][]string)
+	for _, c := range containers {
+		for _, cp := range c.Ports {
+			if cp.PublicPort != 0 {
+				serviceName := c.Labels[LABEL_PREFIX+".service_name"]
+				ports[serviceName] = append(ports[serviceName], fmt.Sprintf("%v:%v->%v/%v", cp.IP, cp.PublicPort, cp.PrivatePort, cp.Type))
+			}
+		}
+	}
+	if len(ports) == 0 {
+		return
+	}
+	for _, p := range ports {
+		sort.Strings(p)
+	}
+
+	if agreementProtocol != "" {
+		_, err = persistence.AgreementPublishedPortsUpdate(b.db, agreementId, agreementProtocol, ports)
+	} else {
+		_, err = persistence.UpdateMSInstancePublishedPorts(b.db, agreementId, ports)
+	}
+	if err != nil {
+		glog.Errorf("ContainerWorker unable to record the published ports %v of %v, error %v", ports, agreementId, err)
+	}
+}
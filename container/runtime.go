@@ -0,0 +1,135 @@
+package container
+
+import (
+	"fmt"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
+	"strings"
+)
+
+// The container runtime operations that anax uses to run service containers. The docker client implements this interface
+// directly. The Podman runtime uses the same client against Podman's Docker compatible API, and adjusts the requests that
+// Podman handles differently.
+type ContainerRuntime interface {
+	CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error)
+	StartContainer(id string, hostConfig *docker.HostConfig) error
+	StopContainer(id string, timeout uint) error
+	KillContainer(opts docker.KillContainerOptions) error
+	RemoveContainer(opts docker.RemoveContainerOptions) error
+	InspectContainer(id string) (*docker.Container, error)
+	ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error)
+	Stats(opts docker.StatsOptions) error
+	Logs(opts docker.LogsOptions) error
+	RestartContainer(id string, timeout uint) error
+	CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error)
+	StartExec(id string, opts docker.StartExecOptions) error
+	InspectExec(id string) (*docker.ExecInspect, error)
+
+	CreateNetwork(opts docker.CreateNetworkOptions) (*docker.Network, error)
+	RemoveNetwork(id string) error
+	ListNetworks() ([]docker.Network, error)
+	FilteredListNetworks(opts docker.NetworkFilterOpts) ([]docker.Network, error)
+	NetworkInfo(id string) (*docker.Network, error)
+	ConnectNetwork(id string, opts docker.NetworkConnectionOptions) error
+	DisconnectNetwork(id string, opts docker.NetworkConnectionOptions) error
+
+	CreateVolume(opts docker.CreateVolumeOptions) (*docker.Volume, error)
+	RemoveVolume(name string) error
+	ListVolumes(opts docker.ListVolumesOptions) ([]docker.Volume, error)
+
+	PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error
+	LoadImage(opts docker.LoadImageOptions) error
+	InspectImage(name string) (*docker.Image, error)
+	ListImages(opts docker.ListImagesOptions) ([]docker.APIImages, error)
+	RemoveImage(name string) error
+	AuthCheck(conf *docker.AuthConfiguration) (docker.AuthStatus, error)
+
+	Version() (*docker.Env, error)
+}
+
+// Create a client for the container runtime selected in the node's configuration.
+func NewContainerRuntime(cfg *config.HorizonConfig) (ContainerRuntime, error) {
+	client, err := docker.NewClient(cfg.Edge.DockerEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.GetContainerRuntime() {
+	case config.CONTAINER_RUNTIME_DOCKER:
+		return client, nil
+	case config.CONTAINER_RUNTIME_PODMAN:
+		if !cfg.FeatureEnabled(config.FEATURE_PODMAN_RUNTIME) {
+			return nil, fmt.Errorf("container runtime %v requires the %v feature", config.CONTAINER_RUNTIME_PODMAN, config.FEATURE_PODMAN_RUNTIME)
+		}
+		return &podmanRuntime{Client: client}, nil
+	default:
+		return nil, fmt.Errorf("unsupported container runtime %v", cfg.GetContainerRuntime())
+	}
+}
+
+// Returns the version reported by the container runtime, or an empty string if it cannot be determined.
+func RuntimeVersion(rt ContainerRuntime) string {
+	if env, err := rt.Version(); err != nil {
+		glog.Warningf("Unable to get the container runtime version, error %v", err)
+		return ""
+	} else {
+		return env.Get("Version")
+	}
+}
+
+// The Podman runtime, reached through the Podman Docker compatible API socket.
+type podmanRuntime struct {
+	*docker.Client
+}
+
+// Podman resolves unqualified image names through its short name configuration, which fails when the name is
+// ambiguous and there is no terminal to prompt on. Images without a registry are qualified with docker.io, which is
+// what docker assumes for them.
+func qualifyImageName(image string) string {
+	if image == "" || strings.HasPrefix(image, "sha256:") || strings.HasPrefix(image, "localhost/") {
+		return image
+	}
+
+	if domain, path, _, _ := cutil.ParseDockerImagePath(image); path == "" || domain != "" {
+		return image
+	}
+	return "docker.io/" + image
+}
+
+// Podman bridge networks support the MTU option but none of the docker bridge driver options, which Podman rejects.
+func podmanNetworkOptions(options map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{})
+	for k, v := range options {
+		if k == "com.docker.network.driver.mtu" {
+			ret[k] = v
+		} else if k == "com.docker.network.bridge.enable_icc" && v == "false" {
+			glog.Warningf("Inter-container communication cannot be disabled on Podman networks, ignoring network option %v", k)
+		}
+	}
+	return ret
+}
+
+func (p *podmanRuntime) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	if opts.Config != nil {
+		cfg := *opts.Config
+		cfg.Image = qualifyImageName(cfg.Image)
+		opts.Config = &cfg
+	}
+	return p.Client.CreateContainer(opts)
+}
+
+func (p *podmanRuntime) CreateNetwork(opts docker.CreateNetworkOptions) (*docker.Network, error) {
+	opts.Options = podmanNetworkOptions(opts.Options)
+	return p.Client.CreateNetwork(opts)
+}
+
+func (p *podmanRuntime) PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+	opts.Repository = qualifyImageName(opts.Repository)
+	return p.Client.PullImage(opts, auth)
+}
+
+func (p *podmanRuntime) InspectImage(name string) (*docker.Image, error) {
+	return p.Client.InspectImage(qualifyImageName(name))
+}
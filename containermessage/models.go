@@ -6,7 +6,12 @@ import (
 	"errors"
 	"fmt"
 	docker "github.com/fsouza/go-dockerclient"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -26,6 +31,22 @@ import (
  *       "devices": [
  *         "/dev/bus/usb/001/001:/dev/bus/usb/001/001"
  *       ],
+ *       "runtime": "nvidia",
+ *       "healthcheck": {
+ *         "http_port": 8080,
+ *         "http_path": "/health",
+ *         "interval": 30,
+ *         "timeout": 10,
+ *         "failure_threshold": 3,
+ *         "action": "restart"
+ *       },
+ *       "objects": [
+ *         {
+ *           "name": "model.tflite",
+ *           "object": "detector-model",
+ *           "version": "2.1.0"
+ *         }
+ *       ],
  *       "binds": [
  *         "/tmp/testdata:/tmp/mydata:ro",
  *         "myvolume1:/tmp/mydata2"
@@ -137,24 +158,641 @@ func (p *Pattern) IsShared(tp string, serviceName string) bool {
 
 // Service Only those marked "omitempty" may be omitted
 type Service struct {
-	Image            string               `json:"image"`
-	VariationLabel   string               `json:"variation_label,omitempty"`
-	Privileged       bool                 `json:"privileged"`
-	Network          string               `json:"network"`
-	Environment      []string             `json:"environment,omitempty"`
-	CapAdd           []string             `json:"cap_add,omitempty"`
-	Command          []string             `json:"command,omitempty"`
-	Devices          []string             `json:"devices,omitempty"`
-	NetworkIsolation *NetworkIsolation    `json:"network_isolation,omitempty"` // Changed to pointer so that the hzn dev CLI doesnt generate this struct into the deployment config skeleton
-	Binds            []string             `json:"binds,omitempty"`
-	Tmpfs            map[string]string    `json:"tmpfs,omitempty"`
-	Ports            []docker.PortBinding `json:"ports,omitempty"`
-	EphemeralPorts   []Port               `json:"ephemeral_ports,omitempty"`
-	SpecificPorts    []docker.PortBinding `json:"specific_ports,omitempty"` // obselete. for backward compatibility only, new way should use ports instead.
-	Entrypoint       []string             `json:"entrypoint,omitempty"`
-	MaxMemoryMb      int64                `json:"max_memory_mb,omitempty"`
-	MaxCPUs          float32              `json:"max_cpus,omitempty"`
-	LogDriver        string               `json:"log_driver,omitempty"` // Docker's log-driver. Syslog will be used as default driver
+	Image              string               `json:"image"`
+	VariationLabel     string               `json:"variation_label,omitempty"`
+	Privileged         bool                 `json:"privileged"`
+	Network            string               `json:"network"`
+	Environment        []string             `json:"environment,omitempty"`
+	CapAdd             []string             `json:"cap_add,omitempty"`
+	Command            []string             `json:"command,omitempty"`
+	Devices            []string             `json:"devices,omitempty"`
+	NetworkIsolation   *NetworkIsolation    `json:"network_isolation,omitempty"` // Changed to pointer so that the hzn dev CLI doesnt generate this struct into the deployment config skeleton
+	Binds              []string             `json:"binds,omitempty"`
+	Tmpfs              map[string]string    `json:"tmpfs,omitempty"`
+	Ports              []docker.PortBinding `json:"ports,omitempty"`
+	EphemeralPorts     []Port               `json:"ephemeral_ports,omitempty"`
+	SpecificPorts      []docker.PortBinding `json:"specific_ports,omitempty"` // obselete. for backward compatibility only, new way should use ports instead.
+	Entrypoint         []string             `json:"entrypoint,omitempty"`
+	MaxMemoryMb        int64                `json:"max_memory_mb,omitempty"`
+	MaxCPUs            float32              `json:"max_cpus,omitempty"`
+	LogDriver          string               `json:"log_driver,omitempty"`     // Docker's log-driver. Syslog will be used as default driver
+	Runtime            string               `json:"runtime,omitempty"`        // The container runtime to use, e.g. "nvidia" for GPU workloads. Empty means the docker default.
+	HealthCheck        *HealthCheck         `json:"healthcheck,omitempty"`    // Pointer so that the hzn dev CLI doesnt generate this struct into the deployment config skeleton
+	CPUSet             string               `json:"cpuset,omitempty"`         // The cpus the container is pinned to, e.g. "2-3,6". The cpus are not shared with containers of other agreements or services.
+	CPURealtimeRuntime int64                `json:"cpu_rt_runtime,omitempty"` // The microseconds per period that the container can run with realtime scheduling.
+	Objects            []ObjectReference    `json:"objects,omitempty"`        // Objects from the object service, mounted read-only in the container.
+}
+
+// An object in the object service that the agent downloads for the service. It appears in the container as a file with
+// the given name in the objects directory. An object without a version is kept at the latest version.
+type ObjectReference struct {
+	Name    string `json:"name"`              // The file name of the object in the container.
+	Object  string `json:"object"`            // The id of the object in the object service.
+	Version string `json:"version,omitempty"` // The version of the object, empty means the latest version.
+}
+
+func (o ObjectReference) String() string {
+	return fmt.Sprintf("Name: %v, Object: %v, Version: %v", o.Name, o.Object, o.Version)
+}
+
+// Verify that the object references have an object id and distinct names that can be used as file names.
+func (s *Service) ValidateObjects() error {
+	names := make(map[string]bool)
+	for _, o := range s.Objects {
+		if o.Object == "" {
+			return fmt.Errorf("object %v has no object id", o.Name)
+		} else if o.Name == "" || o.Name == "." || o.Name == ".." || strings.ContainsAny(o.Name, "/\\\x00") {
+			return fmt.Errorf("object name %v must be a file name without a path", o.Name)
+		} else if names[o.Name] {
+			return fmt.Errorf("object name %v is used more than once", o.Name)
+		}
+		names[o.Name] = true
+	}
+	return nil
+}
+
+// The actions taken when a container fails its health check.
+const (
+	HEALTH_CHECK_ACTION_RESTART = "restart" // restart the container
+	HEALTH_CHECK_ACTION_CANCEL  = "cancel"  // cancel the agreement, or fail the dependent service
+)
+
+// The health check defaults.
+const (
+	HEALTH_CHECK_INTERVAL_DEFAULT          = 30
+	HEALTH_CHECK_TIMEOUT_DEFAULT           = 10
+	HEALTH_CHECK_FAILURE_THRESHOLD_DEFAULT = 3
+)
+
+// A health check that the agent runs periodically against a running container. Exactly one of the exec command,
+// the TCP port or the HTTP port is checked.
+type HealthCheck struct {
+	Exec             []string `json:"exec,omitempty"`              // A command run inside the container, it is healthy if the command exits with 0.
+	TCPPort          int      `json:"tcp_port,omitempty"`          // A container port that must accept TCP connections.
+	HTTPPort         int      `json:"http_port,omitempty"`         // A container port that must answer an HTTP GET of the HTTPPath with a 2xx or 3xx status.
+	HTTPPath         string   `json:"http_path,omitempty"`         // The path of the HTTP check, the default is "/".
+	IntervalS        int      `json:"interval,omitempty"`          // The number of seconds between checks, the default is 30.
+	TimeoutS         int      `json:"timeout,omitempty"`           // The number of seconds before a check is considered failed, the default is 10.
+	FailureThreshold int      `json:"failure_threshold,omitempty"` // The number of consecutive failed checks after which the container is unhealthy, the default is 3.
+	Action           string   `json:"action,omitempty"`            // What to do when the container is unhealthy, "restart" (the default) or "cancel".
+}
+
+func (h HealthCheck) String() string {
+	return fmt.Sprintf("Exec: %v, TCPPort: %v, HTTPPort: %v, HTTPPath: %v, IntervalS: %v, TimeoutS: %v, FailureThreshold: %v, Action: %v",
+		h.Exec, h.TCPPort, h.HTTPPort, h.HTTPPath, h.IntervalS, h.TimeoutS, h.FailureThreshold, h.Action)
+}
+
+// Verify that the health check has exactly one kind of check and sensible settings.
+func (h *HealthCheck) Validate() error {
+	checks := 0
+	if len(h.Exec) != 0 {
+		checks++
+	}
+	if h.TCPPort != 0 {
+		checks++
+	}
+	if h.HTTPPort != 0 {
+		checks++
+	}
+
+	if checks != 1 {
+		return fmt.Errorf("exactly one of exec, tcp_port or http_port must be specified")
+	} else if h.TCPPort < 0 || h.TCPPort > 65535 || h.HTTPPort < 0 || h.HTTPPort > 65535 {
+		return fmt.Errorf("the port must be between 1 and 65535")
+	} else if h.HTTPPath != "" && h.HTTPPort == 0 {
+		return fmt.Errorf("http_path is only supported with http_port")
+	} else if h.HTTPPath != "" && !strings.HasPrefix(h.HTTPPath, "/") {
+		return fmt.Errorf("http_path %v must start with /", h.HTTPPath)
+	} else if h.IntervalS < 0 || h.TimeoutS < 0 || h.FailureThreshold < 0 {
+		return fmt.Errorf("interval, timeout and failure_threshold must not be negative")
+	} else if h.GetTimeout() > h.GetInterval() {
+		return fmt.Errorf("timeout %v must not be greater than interval %v", h.GetTimeout(), h.GetInterval())
+	} else if h.Action != "" && h.Action != HEALTH_CHECK_ACTION_RESTART && h.Action != HEALTH_CHECK_ACTION_CANCEL {
+		return fmt.Errorf("action %v is not supported, it must be %v or %v", h.Action, HEALTH_CHECK_ACTION_RESTART, HEALTH_CHECK_ACTION_CANCEL)
+	}
+	return nil
+}
+
+func (h *HealthCheck) GetInterval() int {
+	if h.IntervalS == 0 {
+		return HEALTH_CHECK_INTERVAL_DEFAULT
+	}
+	return h.IntervalS
+}
+
+func (h *HealthCheck) GetTimeout() int {
+	if h.TimeoutS == 0 {
+		if h.GetInterval() < HEALTH_CHECK_TIMEOUT_DEFAULT {
+			return h.GetInterval()
+		}
+		return HEALTH_CHECK_TIMEOUT_DEFAULT
+	}
+	return h.TimeoutS
+}
+
+func (h *HealthCheck) GetFailureThreshold() int {
+	if h.FailureThreshold == 0 {
+		return HEALTH_CHECK_FAILURE_THRESHOLD_DEFAULT
+	}
+	return h.FailureThreshold
+}
+
+func (h *HealthCheck) GetHTTPPath() string {
+	if h.HTTPPath == "" {
+		return "/"
+	}
+	return h.HTTPPath
+}
+
+func (h *HealthCheck) GetAction() string {
+	if h.Action == "" {
+		return HEALTH_CHECK_ACTION_RESTART
+	}
+	return h.Action
+}
+
+// DeviceMapping is the parsed form of a device string in the deployment config.
+// The format of device mapping is: <host device name>:<contianer device name>:<cgroup permission>
+// the cgoup permission can be omitted. It defaults to "rwm" when omitted.
+type DeviceMapping struct {
+	PathOnHost        string
+	PathInContainer   string
+	CgroupPermissions string
+}
+
+func ParseDeviceMapping(device string) (*DeviceMapping, error) {
+	cgp := "rwm"
+	sp := strings.Split(device, ":")
+	if len(sp) == 3 {
+		// the cgroup permission
+		cgp = sp[2]
+	} else if len(sp) != 2 {
+		return nil, fmt.Errorf("Illegal device specified in deployment description: %v", device)
+	}
+
+	if sp[0] == "" || sp[1] == "" {
+		return nil, fmt.Errorf("Illegal device specified in deployment description: %v", device)
+	}
+
+	return &DeviceMapping{
+		PathOnHost:        sp[0],
+		PathInContainer:   sp[1],
+		CgroupPermissions: cgp,
+	}, nil
+}
+
+// Returns true if the host device path matches one of the patterns in the allow list. The patterns use
+// shell file name matching, e.g. /dev/nvidia*. An empty allow list permits any device.
+func DeviceAllowed(hostPath string, allowList []string) bool {
+	if len(allowList) == 0 {
+		return true
+	}
+	for _, pattern := range allowList {
+		if matched, err := filepath.Match(pattern, filepath.Clean(hostPath)); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify that every device requested by the service is permitted by the allow list and is present on this host.
+func (s *Service) CheckDevices(allowList []string) error {
+	for _, device := range s.Devices {
+		dm, err := ParseDeviceMapping(device)
+		if err != nil {
+			return err
+		}
+		if !DeviceAllowed(dm.PathOnHost, allowList) {
+			return fmt.Errorf("device %v is not in the list of host devices allowed by the node configuration", dm.PathOnHost)
+		}
+		if _, err := os.Stat(dm.PathOnHost); err != nil {
+			return fmt.Errorf("device %v is not available on this node: %v", dm.PathOnHost, err)
+		}
+	}
+	return nil
+}
+
+// Verify the device requests of all the services in the deployment description.
+func (d DeploymentDescription) CheckDevices(allowList []string) error {
+	for serviceName, service := range d.Services {
+		if err := service.CheckDevices(allowList); err != nil {
+			return fmt.Errorf("service %v: %v", serviceName, err)
+		}
+	}
+	return nil
+}
+
+// Verify that the container runtime selected by the service, if any, is in the allow list. The docker default runtime
+// is always allowed.
+func (s *Service) CheckRuntime(allowList []string) error {
+	if s.Runtime == "" {
+		return nil
+	}
+	for _, runtime := range allowList {
+		if runtime == s.Runtime {
+			return nil
+		}
+	}
+	return fmt.Errorf("container runtime %v is not in the list of runtimes allowed by the node configuration", s.Runtime)
+}
+
+// Verify the container runtimes selected by all the services in the deployment description.
+func (d DeploymentDescription) CheckRuntime(allowList []string) error {
+	for serviceName, service := range d.Services {
+		if err := service.CheckRuntime(allowList); err != nil {
+			return fmt.Errorf("service %v: %v", serviceName, err)
+		}
+	}
+	return nil
+}
+
+// The suffix of a host path allow list pattern that permits the matching host paths to be mounted read-write.
+const HOST_PATH_ALLOW_RW = ":rw"
+
+// Returns the host path of a bind, or an empty string if the bind mounts a docker volume.
+func BindHostPath(bind string) string {
+	if hp := strings.SplitN(bind, ":", 2)[0]; strings.HasPrefix(hp, "/") {
+		return hp
+	}
+	return ""
+}
+
+// Returns whether the host path, or one of the directories that contain it, matches one of the patterns in the allow
+// list, and whether the matching pattern permits the path to be mounted read-write. The patterns use shell file name
+// matching, e.g. /var/lib/sensor-*, and end with :rw to permit read-write mounts. An empty allow list permits any host
+// path read-write. The symbolic links in the path and in the patterns are resolved, so that a link in an allowed
+// directory does not give access to a path outside of it.
+func HostPathAllowed(hostPath string, allowList []string) (bool, bool) {
+	if len(allowList) == 0 {
+		return true, true
+	}
+
+	resolved := resolveHostPath(hostPath)
+	allowed, rw := false, false
+	for _, entry := range allowList {
+		pattern := resolveHostPathPattern(strings.TrimSuffix(entry, HOST_PATH_ALLOW_RW))
+		for p := resolved; ; p = filepath.Dir(p) {
+			if matched, err := filepath.Match(pattern, p); err == nil && matched {
+				allowed = true
+				rw = rw || strings.HasSuffix(entry, HOST_PATH_ALLOW_RW)
+				break
+			}
+			if p == "/" || p == "." {
+				break
+			}
+		}
+	}
+	return allowed, rw
+}
+
+// Returns the host path with its symbolic links resolved. The part of the path that does not exist yet, which docker
+// creates when it binds the path, is kept as it is.
+func resolveHostPath(hostPath string) string {
+	p, rest := filepath.Clean(hostPath), ""
+	for {
+		if resolved, err := filepath.EvalSymlinks(p); err == nil {
+			return filepath.Join(resolved, rest)
+		} else if p == "/" || p == "." {
+			return filepath.Clean(hostPath)
+		}
+		rest = filepath.Join(filepath.Base(p), rest)
+		p = filepath.Dir(p)
+	}
+}
+
+// Returns the allow list pattern with the symbolic links resolved in the directories that precede its first wildcard,
+// so that it matches the resolved host paths.
+func resolveHostPathPattern(pattern string) string {
+	elems := strings.Split(filepath.Clean(pattern), string(filepath.Separator))
+	for i, elem := range elems {
+		if strings.ContainsAny(elem, `*?[\`) {
+			if i <= 1 {
+				return filepath.Clean(pattern)
+			}
+			return filepath.Join(resolveHostPath(strings.Join(elems[:i], string(filepath.Separator))), filepath.Join(elems[i:]...))
+		}
+	}
+	return resolveHostPath(pattern)
+}
+
+// Verify that every host path that the service binds is permitted by the allow list. Binds of docker volumes are not
+// checked.
+func (s *Service) CheckBinds(allowList []string) error {
+	for _, bind := range s.Binds {
+		if hp := BindHostPath(bind); hp != "" {
+			if allowed, _ := HostPathAllowed(hp, allowList); !allowed {
+				return fmt.Errorf("host path %v is not in the list of host paths allowed by the node configuration", hp)
+			}
+		}
+	}
+	return nil
+}
+
+// Mount the host paths that the allow list does not permit read-write as read-only. An empty allow list leaves the
+// binds as they are.
+func (s *Service) RestrictBinds(allowList []string) {
+	for i, bind := range s.Binds {
+		hp := BindHostPath(bind)
+		if hp == "" {
+			continue
+		} else if _, rw := HostPathAllowed(hp, allowList); rw {
+			continue
+		}
+
+		parts := strings.SplitN(bind, ":", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		options := []string{"ro"}
+		if len(parts) == 3 {
+			for _, o := range strings.Split(parts[2], ",") {
+				if o != "ro" && o != "rw" && o != "" {
+					options = append(options, o)
+				}
+			}
+		}
+		s.Binds[i] = fmt.Sprintf("%v:%v:%v", parts[0], parts[1], strings.Join(options, ","))
+	}
+}
+
+// Verify the host path binds of all the services in the deployment description.
+func (d DeploymentDescription) CheckBinds(allowList []string) error {
+	for serviceName, service := range d.Services {
+		if err := service.CheckBinds(allowList); err != nil {
+			return fmt.Errorf("service %v: %v", serviceName, err)
+		}
+	}
+	return nil
+}
+
+// A host port that a container publishes. The host port of an ephemeral port is chosen by docker when the container
+// starts, it is 0 here.
+type PublishedPort struct {
+	HostIP        string
+	HostPort      int
+	ContainerPort string
+	Protocol      string
+}
+
+func (p PublishedPort) String() string {
+	hostPort := "ephemeral"
+	if p.HostPort != 0 {
+		hostPort = strconv.Itoa(p.HostPort)
+	}
+	return fmt.Sprintf("%v:%v->%v/%v", p.GetHostIP(), hostPort, p.ContainerPort, p.Protocol)
+}
+
+// Returns the host address the port is published on, docker publishes on all the interfaces when none is given.
+func (p PublishedPort) GetHostIP() string {
+	if p.HostIP == "" {
+		return "0.0.0.0"
+	}
+	return p.HostIP
+}
+
+// Returns the host ports that the service publishes, from its ports, specific_ports and ephemeral_ports.
+func (s *Service) PublishedPorts() ([]PublishedPort, error) {
+	published := make([]PublishedPort, 0)
+
+	// HostPort schema: <host_port>:<container_port>/<protocol>, where the host port defaults to the container port
+	// and the protocol to tcp.
+	for _, pb := range append(append([]docker.PortBinding{}, s.SpecificPorts...), s.Ports...) {
+		pieces := strings.Split(pb.HostPort, ":")
+		if len(pieces) > 2 {
+			return nil, fmt.Errorf("port %v must be <host port>:<container port>/<protocol>", pb.HostPort)
+		}
+		cPort, protocol := splitPortProtocol(pieces[len(pieces)-1])
+		hPort, _ := splitPortProtocol(pieces[0])
+		hostPort, err := strconv.Atoi(hPort)
+		if err != nil || hostPort < 1 || hostPort > 65535 {
+			return nil, fmt.Errorf("port %v has an invalid host port", pb.HostPort)
+		}
+		published = append(published, PublishedPort{HostIP: pb.HostIP, HostPort: hostPort, ContainerPort: cPort, Protocol: protocol})
+	}
+
+	for _, p := range s.EphemeralPorts {
+		cPort, protocol := splitPortProtocol(p.PortAndProtocol)
+		hostIP := "0.0.0.0"
+		if p.LocalhostOnly {
+			hostIP = "127.0.0.1"
+		}
+		published = append(published, PublishedPort{HostIP: hostIP, ContainerPort: cPort, Protocol: protocol})
+	}
+	return published, nil
+}
+
+func splitPortProtocol(pp string) (string, string) {
+	pieces := strings.SplitN(pp, "/", 2)
+	if len(pieces) == 2 && pieces[1] != "" {
+		return pieces[0], strings.ToLower(pieces[1])
+	}
+	return pieces[0], "tcp"
+}
+
+// Parses a port range of a port policy, e.g. "8000-8999", "8080" or "5000-5010/udp". A range without a protocol
+// applies to all the protocols, the returned protocol is empty.
+func ParsePortRange(portRange string) (int, int, string, error) {
+	ports, protocol := portRange, ""
+	if i := strings.Index(portRange, "/"); i != -1 {
+		ports, protocol = portRange[:i], strings.ToLower(portRange[i+1:])
+		if protocol != "tcp" && protocol != "udp" && protocol != "sctp" {
+			return 0, 0, "", fmt.Errorf("port range %v has protocol %v, it must be tcp, udp or sctp", portRange, protocol)
+		}
+	}
+
+	bounds := strings.SplitN(ports, "-", 2)
+	low, err := strconv.Atoi(bounds[0])
+	high := low
+	if err == nil && len(bounds) == 2 {
+		high, err = strconv.Atoi(bounds[1])
+	}
+	if err != nil || low < 1 || high > 65535 || low > high {
+		return 0, 0, "", fmt.Errorf("port range %v must be <port>[-<port>][/<protocol>] with ports between 1 and 65535", portRange)
+	}
+	return low, high, protocol, nil
+}
+
+// Verify that the published port is allowed by a node port policy: the host port is in one of the ranges and the host
+// address is one of the interfaces, where an empty interface list allows any address. An ephemeral port only has to
+// be allowed by the interfaces and the ephemeral flag.
+func CheckPortPolicy(p PublishedPort, ranges []string, interfaces []string, allowEphemeral bool) error {
+	if len(interfaces) != 0 {
+		allowed := false
+		for _, iface := range interfaces {
+			if iface == p.GetHostIP() || iface == "0.0.0.0" {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("port %v is published on host address %v, which the node's port policy does not allow", p, p.GetHostIP())
+		}
+	}
+
+	if p.HostPort == 0 {
+		if !allowEphemeral {
+			return fmt.Errorf("ephemeral host port for container port %v/%v is not allowed by the node's port policy", p.ContainerPort, p.Protocol)
+		}
+		return nil
+	}
+
+	for _, r := range ranges {
+		if low, high, protocol, err := ParsePortRange(r); err == nil && p.HostPort >= low && p.HostPort <= high && (protocol == "" || protocol == p.Protocol) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host port %v/%v is not in the port ranges allowed by the node's port policy", p.HostPort, p.Protocol)
+}
+
+// Verify that every host port that the service publishes is allowed by the node port policy.
+func (s *Service) CheckPorts(ranges []string, interfaces []string, allowEphemeral bool) error {
+	published, err := s.PublishedPorts()
+	if err != nil {
+		return err
+	}
+	for _, p := range published {
+		if err := CheckPortPolicy(p, ranges, interfaces, allowEphemeral); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify the published ports of all the services in the deployment description.
+func (d DeploymentDescription) CheckPorts(ranges []string, interfaces []string, allowEphemeral bool) error {
+	for serviceName, service := range d.Services {
+		if err := service.CheckPorts(ranges, interfaces, allowEphemeral); err != nil {
+			return fmt.Errorf("service %v: %v", serviceName, err)
+		}
+	}
+	return nil
+}
+
+// The file listing the cpus that are online on a Linux host.
+const CPU_ONLINE_FILE = "/sys/devices/system/cpu/online"
+
+// The cpu pinning that was applied to a container.
+type CPUPinning struct {
+	CPUSet             string `json:"cpuset"`
+	CPURealtimeRuntime int64  `json:"cpu_rt_runtime,omitempty"`
+}
+
+func (c CPUPinning) String() string {
+	return fmt.Sprintf("CPUSet: %v, CPURealtimeRuntime: %v", c.CPUSet, c.CPURealtimeRuntime)
+}
+
+// Parses a cpuset specification, a comma separated list of cpu numbers and ranges like "0-2,5", into the sorted list
+// of the cpus it contains.
+func ParseCPUSet(spec string) ([]int, error) {
+	cpus := make(map[int]bool)
+	for _, part := range strings.Split(strings.TrimSpace(spec), ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid cpuset %v, %v is not a cpu number", spec, bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil || last < first {
+				return nil, fmt.Errorf("invalid cpuset %v, %v is not a cpu range", spec, part)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus[cpu] = true
+		}
+	}
+
+	res := make([]int, 0, len(cpus))
+	for cpu := range cpus {
+		res = append(res, cpu)
+	}
+	sort.Ints(res)
+	return res, nil
+}
+
+// Returns the cpus that are online on this host. If onlineFile is an empty string, CPU_ONLINE_FILE is read.
+func OnlineCPUs(onlineFile string) ([]int, error) {
+	if onlineFile == "" {
+		onlineFile = CPU_ONLINE_FILE
+	}
+	if content, err := ioutil.ReadFile(onlineFile); err != nil {
+		return nil, fmt.Errorf("unable to read the online cpus of the node, %v", err)
+	} else {
+		return ParseCPUSet(string(content))
+	}
+}
+
+// Returns the cpus that the two cpuset specifications have in common. Invalid specifications have no cpus.
+func CPUSetOverlap(a string, b string) []int {
+	overlap := make([]int, 0)
+	cpusA, errA := ParseCPUSet(a)
+	cpusB, errB := ParseCPUSet(b)
+	if errA != nil || errB != nil {
+		return overlap
+	}
+
+	inB := make(map[int]bool)
+	for _, cpu := range cpusB {
+		inB[cpu] = true
+	}
+	for _, cpu := range cpusA {
+		if inB[cpu] {
+			overlap = append(overlap, cpu)
+		}
+	}
+	return overlap
+}
+
+// Verify that the cpus the service is pinned to are online and in the allow list, and that the realtime runtime it
+// requests does not exceed the maximum. An empty allow list permits any online cpu, a zero maximum means realtime
+// scheduling is not permitted.
+func (s *Service) CheckCPUPinning(online []int, allowList string, maxRealtimeRuntime int64) error {
+	if s.CPURealtimeRuntime < 0 {
+		return fmt.Errorf("cpu realtime runtime %v must not be negative", s.CPURealtimeRuntime)
+	} else if s.CPURealtimeRuntime > maxRealtimeRuntime {
+		return fmt.Errorf("cpu realtime runtime %v exceeds the maximum %v allowed by the node configuration", s.CPURealtimeRuntime, maxRealtimeRuntime)
+	} else if s.CPUSet == "" {
+		return nil
+	}
+
+	cpus, err := ParseCPUSet(s.CPUSet)
+	if err != nil {
+		return err
+	}
+
+	isOnline := make(map[int]bool)
+	for _, cpu := range online {
+		isOnline[cpu] = true
+	}
+	var allowed []int
+	if allowList != "" {
+		if allowed, err = ParseCPUSet(allowList); err != nil {
+			return fmt.Errorf("the cpu allow list of the node configuration is not valid, %v", err)
+		}
+	}
+	isAllowed := make(map[int]bool)
+	for _, cpu := range allowed {
+		isAllowed[cpu] = true
+	}
+
+	for _, cpu := range cpus {
+		if !isOnline[cpu] {
+			return fmt.Errorf("cpu %v of cpuset %v is not online on this node", cpu, s.CPUSet)
+		} else if allowList != "" && !isAllowed[cpu] {
+			return fmt.Errorf("cpu %v of cpuset %v is not in the list of cpus allowed by the node configuration", cpu, s.CPUSet)
+		}
+	}
+	return nil
+}
+
+// Verify the cpu pinning of all the services in the deployment description.
+func (d DeploymentDescription) CheckCPUPinning(online []int, allowList string, maxRealtimeRuntime int64) error {
+	for serviceName, service := range d.Services {
+		if err := service.CheckCPUPinning(online, allowList, maxRealtimeRuntime); err != nil {
+			return fmt.Errorf("service %v: %v", serviceName, err)
+		}
+	}
+	return nil
 }
 
 func (s *Service) AddFilesystemBinding(bind string) {
@@ -4,6 +4,11 @@ package containermessage
 
 import (
 	docker "github.com/fsouza/go-dockerclient"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -178,3 +183,323 @@ func Test_AddSpecificPortBinding(t *testing.T) {
 		t.Errorf("Service should have 2 specific port bindings but not.")
 	}
 }
+
+func Test_ParseDeviceMapping(t *testing.T) {
+	if dm, err := ParseDeviceMapping("/dev/video0:/dev/video0"); err != nil {
+		t.Errorf("unexpected error parsing device: %v", err)
+	} else if dm.PathOnHost != "/dev/video0" || dm.PathInContainer != "/dev/video0" || dm.CgroupPermissions != "rwm" {
+		t.Errorf("wrong device mapping returned: %v", dm)
+	}
+
+	if dm, err := ParseDeviceMapping("/dev/nvidia0:/dev/gpu:r"); err != nil {
+		t.Errorf("unexpected error parsing device: %v", err)
+	} else if dm.PathOnHost != "/dev/nvidia0" || dm.PathInContainer != "/dev/gpu" || dm.CgroupPermissions != "r" {
+		t.Errorf("wrong device mapping returned: %v", dm)
+	}
+
+	for _, bad := range []string{"/dev/video0", "/dev/a:/dev/b:rw:x", ":/dev/b"} {
+		if _, err := ParseDeviceMapping(bad); err == nil {
+			t.Errorf("device %v should have been rejected", bad)
+		}
+	}
+}
+
+func Test_DeviceAllowed(t *testing.T) {
+	if !DeviceAllowed("/dev/video0", []string{}) {
+		t.Errorf("an empty allow list should allow any device")
+	}
+
+	allowList := []string{"/dev/nvidia*", "/dev/video0"}
+	if !DeviceAllowed("/dev/nvidia0", allowList) {
+		t.Errorf("/dev/nvidia0 should be allowed by %v", allowList)
+	}
+	if !DeviceAllowed("/dev/video0", allowList) {
+		t.Errorf("/dev/video0 should be allowed by %v", allowList)
+	}
+	if DeviceAllowed("/dev/video1", allowList) {
+		t.Errorf("/dev/video1 should not be allowed by %v", allowList)
+	}
+	if DeviceAllowed("/dev/../etc/passwd", allowList) {
+		t.Errorf("/etc/passwd should not be allowed by %v", allowList)
+	}
+}
+
+func Test_CheckDevices(t *testing.T) {
+	serv := Service{
+		Image:   "an image",
+		Devices: []string{"/dev/null:/dev/null"},
+	}
+
+	if err := serv.CheckDevices([]string{"/dev/null"}); err != nil {
+		t.Errorf("unexpected error checking devices: %v", err)
+	}
+	if err := serv.CheckDevices([]string{"/dev/nvidia*"}); err == nil {
+		t.Errorf("device /dev/null should not be allowed")
+	}
+
+	serv.Devices = []string{"/dev/notarealdevice:/dev/notarealdevice"}
+	if err := serv.CheckDevices([]string{}); err == nil {
+		t.Errorf("missing device should have been detected")
+	}
+}
+
+func Test_CheckRuntime(t *testing.T) {
+	serv := Service{Image: "an image"}
+	if err := serv.CheckRuntime([]string{}); err != nil {
+		t.Errorf("the default runtime should always be allowed: %v", err)
+	}
+
+	serv.Runtime = "nvidia"
+	if err := serv.CheckRuntime([]string{}); err == nil {
+		t.Errorf("runtime nvidia should not be allowed by an empty allow list")
+	}
+	if err := serv.CheckRuntime([]string{"kata", "nvidia"}); err != nil {
+		t.Errorf("unexpected error checking runtime: %v", err)
+	}
+
+	dd := DeploymentDescription{Services: map[string]*Service{"gpu": &serv}}
+	if err := dd.CheckRuntime([]string{"kata"}); err == nil {
+		t.Errorf("runtime nvidia of service gpu should not be allowed")
+	}
+}
+
+func Test_HostPathAllowed(t *testing.T) {
+	if allowed, rw := HostPathAllowed("/etc", []string{}); !allowed || !rw {
+		t.Errorf("an empty allow list should allow any host path read-write")
+	}
+
+	allowList := []string{"/var/lib/sensor-*", "/opt/data:rw"}
+	if allowed, rw := HostPathAllowed("/var/lib/sensor-data", allowList); !allowed || rw {
+		t.Errorf("/var/lib/sensor-data should be allowed read-only by %v", allowList)
+	}
+	if allowed, rw := HostPathAllowed("/opt/data/logs/", allowList); !allowed || !rw {
+		t.Errorf("/opt/data/logs should be allowed read-write by %v", allowList)
+	}
+	if allowed, _ := HostPathAllowed("/var/lib/sensor-data/../../../etc", allowList); allowed {
+		t.Errorf("/etc should not be allowed by %v", allowList)
+	}
+	if allowed, _ := HostPathAllowed("/opt", allowList); allowed {
+		t.Errorf("/opt should not be allowed by %v", allowList)
+	}
+}
+
+// A link in an allowed directory to a path outside of it is not allowed, a link to an allowed directory is.
+func Test_HostPathAllowed_symlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostpath-")
+	if err != nil {
+		t.Fatalf("unable to create the test directory, error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, d := range []string{"allowed", "denied"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatalf("unable to create the test directory, error %v", err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "denied"), filepath.Join(dir, "allowed", "escape")); err != nil {
+		t.Fatalf("unable to create the test link, error %v", err)
+	} else if err := os.Symlink(filepath.Join(dir, "allowed"), filepath.Join(dir, "link")); err != nil {
+		t.Fatalf("unable to create the test link, error %v", err)
+	}
+
+	allowList := []string{filepath.Join(dir, "allowed")}
+	if allowed, _ := HostPathAllowed(filepath.Join(dir, "allowed", "escape", "data"), allowList); allowed {
+		t.Errorf("a link out of the allowed directory should not be allowed by %v", allowList)
+	}
+	if allowed, _ := HostPathAllowed(filepath.Join(dir, "link", "new"), allowList); !allowed {
+		t.Errorf("a path that does not exist yet, through a link to the allowed directory, should be allowed by %v", allowList)
+	}
+	if allowed, _ := HostPathAllowed(filepath.Join(dir, "allowed", "data"), []string{filepath.Join(dir, "link")}); !allowed {
+		t.Errorf("a path in the directory that an allowed link points to should be allowed")
+	}
+}
+
+func Test_CheckAndRestrictBinds(t *testing.T) {
+	serv := Service{
+		Image: "an image",
+		Binds: []string{"/var/lib/sensor-data:/data", "/opt/data:/out:rw", "/var/lib/sensor-a:/a:rw,z", "myvolume:/vol"},
+	}
+
+	allowList := []string{"/var/lib/sensor-*", "/opt/data:rw"}
+	if err := serv.CheckBinds(allowList); err != nil {
+		t.Errorf("unexpected error checking binds: %v", err)
+	}
+	if err := serv.CheckBinds([]string{"/opt/data"}); err == nil || !strings.Contains(err.Error(), "/var/lib/sensor-data") {
+		t.Errorf("the error should name the denied host path /var/lib/sensor-data, got %v", err)
+	}
+
+	serv.RestrictBinds(allowList)
+	expected := []string{"/var/lib/sensor-data:/data:ro", "/opt/data:/out:rw", "/var/lib/sensor-a:/a:ro,z", "myvolume:/vol"}
+	for i, bind := range serv.Binds {
+		if bind != expected[i] {
+			t.Errorf("expected bind %v, got %v", expected[i], bind)
+		}
+	}
+}
+
+func Test_HealthCheckValidate(t *testing.T) {
+	hc := HealthCheck{HTTPPort: 8080}
+	if err := hc.Validate(); err != nil {
+		t.Errorf("unexpected error validating %v: %v", hc, err)
+	} else if hc.GetInterval() != HEALTH_CHECK_INTERVAL_DEFAULT || hc.GetTimeout() != HEALTH_CHECK_TIMEOUT_DEFAULT || hc.GetFailureThreshold() != HEALTH_CHECK_FAILURE_THRESHOLD_DEFAULT {
+		t.Errorf("the defaults were not applied to %v", hc)
+	} else if hc.GetHTTPPath() != "/" || hc.GetAction() != HEALTH_CHECK_ACTION_RESTART {
+		t.Errorf("the defaults were not applied to %v", hc)
+	}
+
+	hc = HealthCheck{Exec: []string{"/bin/true"}, IntervalS: 5}
+	if err := hc.Validate(); err != nil {
+		t.Errorf("unexpected error validating %v: %v", hc, err)
+	} else if hc.GetTimeout() != 5 {
+		t.Errorf("the default timeout should not be greater than the interval, got %v", hc.GetTimeout())
+	}
+
+	invalid := []HealthCheck{
+		HealthCheck{},
+		HealthCheck{Exec: []string{"/bin/true"}, TCPPort: 80},
+		HealthCheck{TCPPort: 70000},
+		HealthCheck{TCPPort: 80, HTTPPath: "/health"},
+		HealthCheck{HTTPPort: 80, HTTPPath: "health"},
+		HealthCheck{TCPPort: 80, IntervalS: 5, TimeoutS: 10},
+		HealthCheck{TCPPort: 80, FailureThreshold: -1},
+		HealthCheck{TCPPort: 80, Action: "reboot"},
+	}
+	for _, hc := range invalid {
+		if err := hc.Validate(); err == nil {
+			t.Errorf("health check %v should not be valid", hc)
+		}
+	}
+}
+
+func Test_ParseCPUSet(t *testing.T) {
+	if cpus, err := ParseCPUSet("5,0-2, 2"); err != nil {
+		t.Errorf("unexpected error parsing cpuset: %v", err)
+	} else if !reflect.DeepEqual(cpus, []int{0, 1, 2, 5}) {
+		t.Errorf("expected cpus [0 1 2 5], got %v", cpus)
+	}
+
+	for _, spec := range []string{"", "a", "3-1", "-1", "1,,2"} {
+		if _, err := ParseCPUSet(spec); err == nil {
+			t.Errorf("cpuset %v should not be valid", spec)
+		}
+	}
+
+	if overlap := CPUSetOverlap("0-3", "3-5"); !reflect.DeepEqual(overlap, []int{3}) {
+		t.Errorf("expected overlap [3], got %v", overlap)
+	} else if overlap := CPUSetOverlap("0-1", "2"); len(overlap) != 0 {
+		t.Errorf("expected no overlap, got %v", overlap)
+	}
+}
+
+func Test_CheckCPUPinning(t *testing.T) {
+	online := []int{0, 1, 2, 3}
+
+	serv := Service{Image: "an image", CPUSet: "2-3"}
+	if err := serv.CheckCPUPinning(online, "", 0); err != nil {
+		t.Errorf("unexpected error checking cpu pinning: %v", err)
+	} else if err := serv.CheckCPUPinning(online, "1-2", 0); err == nil || !strings.Contains(err.Error(), "cpu 3") {
+		t.Errorf("the error should name the cpu 3 that is not allowed, got %v", err)
+	}
+
+	serv.CPUSet = "3-4"
+	if err := serv.CheckCPUPinning(online, "", 0); err == nil || !strings.Contains(err.Error(), "not online") {
+		t.Errorf("the error should name the cpu 4 that is not online, got %v", err)
+	}
+
+	serv.CPUSet = ""
+	serv.CPURealtimeRuntime = 950000
+	if err := serv.CheckCPUPinning(online, "", 0); err == nil {
+		t.Errorf("realtime scheduling should not be permitted")
+	} else if err := serv.CheckCPUPinning(online, "", 950000); err != nil {
+		t.Errorf("unexpected error checking cpu pinning: %v", err)
+	}
+}
+
+func Test_ValidateObjects(t *testing.T) {
+	s := Service{Objects: []ObjectReference{{Name: "model.tflite", Object: "detector-model", Version: "2.1.0"}, {Name: "labels.txt", Object: "detector-labels"}}}
+	if err := s.ValidateObjects(); err != nil {
+		t.Errorf("unexpected error validating %v: %v", s.Objects, err)
+	}
+
+	invalid := [][]ObjectReference{
+		{{Name: "model.tflite"}},
+		{{Name: "", Object: "detector-model"}},
+		{{Name: "../model.tflite", Object: "detector-model"}},
+		{{Name: "..", Object: "detector-model"}},
+		{{Name: "model.tflite", Object: "detector-model"}, {Name: "model.tflite", Object: "other-model"}},
+	}
+	for _, objects := range invalid {
+		s := Service{Objects: objects}
+		if err := s.ValidateObjects(); err == nil {
+			t.Errorf("objects %v should not be valid", objects)
+		}
+	}
+}
+
+func Test_PublishedPorts(t *testing.T) {
+	s := Service{
+		Ports:          []docker.PortBinding{{HostPort: "5200:6414/udp", HostIP: "192.168.1.10"}, {HostPort: "8080"}},
+		EphemeralPorts: []Port{{LocalhostOnly: true, PortAndProtocol: "7777"}},
+	}
+
+	published, err := s.PublishedPorts()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := []string{"192.168.1.10:5200->6414/udp", "0.0.0.0:8080->8080/tcp", "127.0.0.1:ephemeral->7777/tcp"}
+	if len(published) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, published)
+	}
+	for i := range expected {
+		if published[i].String() != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], published[i])
+		}
+	}
+
+	bad := Service{Ports: []docker.PortBinding{{HostPort: "http:80"}}}
+	if _, err := bad.PublishedPorts(); err == nil {
+		t.Errorf("expected an error for %v", bad.Ports)
+	}
+}
+
+func Test_CheckPorts(t *testing.T) {
+	ranges := []string{"8000-8999", "5200/udp"}
+	interfaces := []string{"127.0.0.1", "192.168.1.10"}
+
+	allowed := []Service{
+		Service{Ports: []docker.PortBinding{{HostPort: "8080:80", HostIP: "127.0.0.1"}}},
+		Service{Ports: []docker.PortBinding{{HostPort: "5200:6414/udp", HostIP: "192.168.1.10"}}},
+		Service{EphemeralPorts: []Port{{LocalhostOnly: true, PortAndProtocol: "7777"}}},
+	}
+	for _, s := range allowed {
+		if err := s.CheckPorts(ranges, interfaces, true); err != nil {
+			t.Errorf("unexpected error for %v %v: %v", s.Ports, s.EphemeralPorts, err)
+		}
+	}
+
+	denied := map[string]Service{
+		"9000/tcp":     Service{Ports: []docker.PortBinding{{HostPort: "9000:80", HostIP: "127.0.0.1"}}},
+		"5200/tcp":     Service{Ports: []docker.PortBinding{{HostPort: "5200", HostIP: "127.0.0.1"}}},
+		"0.0.0.0":      Service{Ports: []docker.PortBinding{{HostPort: "8080:80"}}},
+		"ephemeral":    Service{EphemeralPorts: []Port{{LocalhostOnly: true, PortAndProtocol: "7777"}}},
+		"not in range": Service{Ports: []docker.PortBinding{{HostPort: "8080:80", HostIP: "127.0.0.1"}}},
+	}
+	for name, s := range denied {
+		r := ranges
+		if name == "not in range" {
+			r = []string{}
+		}
+		err := s.CheckPorts(r, interfaces, false)
+		if err == nil {
+			t.Errorf("%v: expected an error for %v %v", name, s.Ports, s.EphemeralPorts)
+		} else if name != "not in range" && !strings.Contains(err.Error(), name) {
+			t.Errorf("%v: expected the error to name the port, got %v", name, err)
+		}
+	}
+
+	for _, r := range []string{"0", "70000", "9000-8000", "80/http", "a-b"} {
+		if _, _, _, err := ParsePortRange(r); err == nil {
+			t.Errorf("expected an error for port range %v", r)
+		}
+	}
+}
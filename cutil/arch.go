@@ -0,0 +1,102 @@
+package cutil
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/open-horizon/anax/config"
+)
+
+// The architecture names used in exchange content that are not GOARCH names, mapped to the GOARCH name. The GOARCH
+// name, as returned by ArchString, is the canonical name of an architecture.
+var builtinArchSynonyms = map[string]string{
+	"x86_64":      "amd64",
+	"x86-64":      "amd64",
+	"x64":         "amd64",
+	"i386":        "386",
+	"i686":        "386",
+	"x86":         "386",
+	"aarch64":     "arm64",
+	"arm64v8":     "arm64",
+	"armv8":       "arm64",
+	"armhf":       "arm",
+	"armel":       "arm",
+	"armv6l":      "arm",
+	"armv7":       "arm",
+	"armv7l":      "arm",
+	"arm32v7":     "arm",
+	"ppc64el":     "ppc64le",
+	"powerpc64le": "ppc64le",
+}
+
+// The synonyms added by the ArchSynonyms section of the config, for platforms that the builtin table does not know.
+var configArchSynonyms = map[string]string{}
+var archSynonymsLock sync.RWMutex
+
+// Add the synonyms from the config to the builtin ones. A config synonym takes precedence over a builtin one.
+func SetArchSynonyms(synonyms config.ArchSynonyms) {
+	archSynonymsLock.Lock()
+	defer archSynonymsLock.Unlock()
+
+	configArchSynonyms = make(map[string]string, len(synonyms))
+	for arch, canonical := range synonyms {
+		configArchSynonyms[strings.ToLower(arch)] = canonical
+	}
+}
+
+// Returns the canonical (GOARCH) name of an architecture. The synonyms are matched without regard to case, an
+// architecture that is not a synonym is returned as it is.
+func CanonicalArch(arch string) string {
+	key := strings.ToLower(strings.TrimSpace(arch))
+
+	archSynonymsLock.RLock()
+	defer archSynonymsLock.RUnlock()
+	if canonical, ok := configArchSynonyms[key]; ok {
+		return canonical
+	} else if canonical, ok := builtinArchSynonyms[key]; ok {
+		return canonical
+	}
+	return arch
+}
+
+// Returns true if the two architecture names are the same architecture.
+func ArchEquivalent(a string, b string) bool {
+	return strings.EqualFold(CanonicalArch(a), CanonicalArch(b))
+}
+
+// Returns the architectures of the services that the node can run, the architecture of the node first and then the
+// AdditionalArchs of the config. The names are canonical and each architecture is listed once.
+func SupportedArchs(cfg *config.HorizonConfig) []string {
+	archs := []string{ArchString()}
+	if cfg == nil {
+		return archs
+	}
+
+	for _, arch := range cfg.Edge.AdditionalArchs {
+		if strings.TrimSpace(arch) == "" {
+			continue
+		}
+		canonical := CanonicalArch(arch)
+		found := false
+		for _, a := range archs {
+			if ArchEquivalent(a, canonical) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			archs = append(archs, canonical)
+		}
+	}
+	return archs
+}
+
+// Returns true if the node can run the services of the given architecture.
+func ArchSupported(cfg *config.HorizonConfig, arch string) bool {
+	for _, a := range SupportedArchs(cfg) {
+		if ArchEquivalent(a, arch) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+// +build unit
+
+package cutil
+
+import (
+	"testing"
+
+	"github.com/open-horizon/anax/config"
+)
+
+func Test_CanonicalArch(t *testing.T) {
+
+	tests := map[string]string{
+		"x86_64":  "amd64",
+		"X86_64":  "amd64",
+		"aarch64": "arm64",
+		"armhf":   "arm",
+		"armv7l":  "arm",
+		"i686":    "386",
+		"ppc64el": "ppc64le",
+		"amd64":   "amd64",
+		"riscv64": "riscv64",
+		"":        "",
+	}
+	for arch, canonical := range tests {
+		if c := CanonicalArch(arch); c != canonical {
+			t.Errorf("expected %v to be %v, got %v", arch, canonical, c)
+		}
+	}
+}
+
+func Test_ArchEquivalent(t *testing.T) {
+
+	if !ArchEquivalent("x86_64", "amd64") || !ArchEquivalent("aarch64", "arm64v8") || !ArchEquivalent("AMD64", "amd64") {
+		t.Errorf("expected the synonyms to be equivalent")
+	} else if ArchEquivalent("amd64", "arm64") || ArchEquivalent("armhf", "aarch64") || ArchEquivalent("amd64", "") {
+		t.Errorf("expected the architectures to differ")
+	}
+}
+
+func Test_SetArchSynonyms(t *testing.T) {
+
+	SetArchSynonyms(map[string]string{"Loong64x": "loong64", "armhf": "armv7"})
+	defer SetArchSynonyms(nil)
+
+	if !ArchEquivalent("loong64x", "loong64") {
+		t.Errorf("expected the config synonym to be used")
+	} else if CanonicalArch("armhf") != "armv7" {
+		t.Errorf("expected the config synonym to take precedence, got %v", CanonicalArch("armhf"))
+	}
+
+	SetArchSynonyms(nil)
+	if CanonicalArch("loong64x") != "loong64x" || CanonicalArch("armhf") != "arm" {
+		t.Errorf("expected the config synonyms to be removed")
+	}
+}
+
+func Test_SupportedArchs(t *testing.T) {
+
+	if archs := SupportedArchs(nil); len(archs) != 1 || archs[0] != ArchString() {
+		t.Errorf("expected only the node arch, got %v", archs)
+	}
+
+	other := "arm64"
+	if ArchString() == "arm64" {
+		other = "amd64"
+	}
+
+	cfg := &config.HorizonConfig{Edge: config.Config{AdditionalArchs: []string{other, "", ArchString(), "riscv64", other}}}
+	archs := SupportedArchs(cfg)
+	if len(archs) != 3 || archs[0] != ArchString() || archs[1] != other || archs[2] != "riscv64" {
+		t.Errorf("expected the node arch and the additional archs once each, got %v", archs)
+	}
+
+	if !ArchSupported(cfg, other) || !ArchSupported(cfg, ArchString()) {
+		t.Errorf("expected %v and %v to be supported", other, ArchString())
+	} else if ArchSupported(cfg, "ppc64le") || ArchSupported(nil, other) {
+		t.Errorf("expected ppc64le and %v without config to be unsupported", other)
+	}
+}
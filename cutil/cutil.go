@@ -15,6 +15,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 	"math"
 	"net"
 	"os"
@@ -281,6 +282,89 @@ func RemoveESSEnvVars(envAdds map[string]string, prefix string) map[string]strin
 	return envAdds
 }
 
+// The node context env vars have this prefix after the env var prefix, e.g. HZN_NODE_ID.
+const NODE_CONTEXT_PREFIX = "NODE_"
+
+// The names of the node context env vars, after the env var prefix and NODE_CONTEXT_PREFIX.
+const (
+	NODE_CONTEXT_ID              = "ID"
+	NODE_CONTEXT_ORG             = "ORG"
+	NODE_CONTEXT_PATTERN         = "PATTERN"
+	NODE_CONTEXT_AGREEMENT_ID    = "AGREEMENT_ID"
+	NODE_CONTEXT_SERVICE_VERSION = "SERVICE_VERSION"
+	NODE_CONTEXT_ARCH            = "ARCH"
+	NODE_CONTEXT_PROPERTY_PREFIX = "PROPERTY_"
+)
+
+// The node and the agreement that a service container runs under.
+type NodeContext struct {
+	NodeId         string
+	Org            string
+	Pattern        string
+	AgreementId    string
+	ServiceVersion string
+	Properties     map[string]string // The node properties, by property name.
+}
+
+// Names that suggest the value is a secret. Node properties with such names are never exposed to the containers.
+var secretNameParts = []string{"PASSWORD", "PASSWD", "SECRET", "TOKEN", "CREDENTIAL", "APIKEY", "API_KEY", "PRIVATE"}
+
+// Returns true if the name suggests that its value is a secret.
+func IsSecretName(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, part := range secretNameParts {
+		if strings.Contains(upper, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the name converted to an env var name, upper case with the characters other than letters, digits and
+// underscores replaced by underscores.
+func EnvvarName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(name))
+}
+
+// Set the node context env vars of a service container. The names in omit, without the prefixes, are left out, they
+// can be patterns like PROPERTY_*. The empty values and the node properties that might hold secrets are left out too.
+// Returns the env vars that were set.
+func SetNodeContextEnvvars(envAdds map[string]string, prefix string, ctx NodeContext, omit []string) map[string]string {
+	vars := map[string]string{
+		NODE_CONTEXT_ID:              ctx.NodeId,
+		NODE_CONTEXT_ORG:             ctx.Org,
+		NODE_CONTEXT_PATTERN:         ctx.Pattern,
+		NODE_CONTEXT_AGREEMENT_ID:    ctx.AgreementId,
+		NODE_CONTEXT_SERVICE_VERSION: ctx.ServiceVersion,
+		NODE_CONTEXT_ARCH:            ArchString(),
+	}
+	for name, value := range ctx.Properties {
+		if !IsSecretName(name) {
+			vars[NODE_CONTEXT_PROPERTY_PREFIX+EnvvarName(name)] = value
+		}
+	}
+
+	set := make(map[string]string)
+	for name, value := range vars {
+		omitted := value == ""
+		for _, pattern := range omit {
+			if matched, err := path.Match(strings.ToUpper(pattern), name); err == nil && matched {
+				omitted = true
+			}
+		}
+		if !omitted {
+			envAdds[prefix+NODE_CONTEXT_PREFIX+name] = value
+			set[prefix+NODE_CONTEXT_PREFIX+name] = value
+		}
+	}
+	return set
+}
+
 // This function is similar to the above, for env vars that are system related. It is only used by workloads.
 func SetSystemEnvvars(envAdds map[string]string, prefix string, lat string, lon string, cpus string, ram string, arch string) {
 
@@ -306,6 +390,13 @@ func SetSystemEnvvars(envAdds map[string]string, prefix string, lat string, lon
 		envAdds[prefix+"HOST_IPS"] = strings.Join(ips, ",")
 	}
 
+	// Set the Host IPv6 addresses, omit interfaces that are down.
+	if ips, err := GetAllHostIPv6Addresses([]NetFilter{OmitDown}); err != nil {
+		glog.Errorf("Error obtaining host IPv6 addresses: %v", err)
+	} else {
+		envAdds[prefix+"HOST_IPV6S"] = strings.Join(ips, ",")
+	}
+
 }
 
 // This is also used as the container name.
@@ -470,6 +561,17 @@ func OmitDown(i net.Interface) bool {
 
 // Interface filter functions return false if the interface should be filtered out.
 func GetAllHostIPv4Addresses(interfaceFilters []NetFilter) ([]string, error) {
+	return getAllHostAddresses(interfaceFilters, func(ip net.IP) bool { return IsIPv4(ip.String()) })
+}
+
+// Returns the global IPv6 addresses of the host, link local addresses are left out because they are only usable with
+// a zone. Interface filter functions return false if the interface should be filtered out.
+func GetAllHostIPv6Addresses(interfaceFilters []NetFilter) ([]string, error) {
+	return getAllHostAddresses(interfaceFilters, func(ip net.IP) bool { return !IsIPv4(ip.String()) && ip.IsGlobalUnicast() })
+}
+
+// Returns the addresses of the host interfaces that pass the filters, for which keepIP is true.
+func getAllHostAddresses(interfaceFilters []NetFilter, keepIP func(net.IP) bool) ([]string, error) {
 
 	ips := make([]string, 0, 5)
 
@@ -479,7 +581,7 @@ func GetAllHostIPv4Addresses(interfaceFilters []NetFilter) ([]string, error) {
 	}
 
 	// Run through all the host's network interaces, filtering out interfaces as per in the input filters,
-	// and then return the remaining IPv4 addresses.
+	// and then return the remaining addresses that are kept.
 	for _, i := range interfaces {
 
 		// Filter out interfaces that we don't care about.
@@ -495,7 +597,7 @@ func GetAllHostIPv4Addresses(interfaceFilters []NetFilter) ([]string, error) {
 			continue
 		}
 
-		// The interface filter didnt remove the interface, so grab it's IP address and make sure it's a v4 address.
+		// The interface filter didnt remove the interface, so grab it's IP address and make sure it's kept.
 		addrs, err := i.Addrs()
 		if err != nil {
 			glog.Warningf("Could not get IP address(es) for network interface %v, error: %v", i.Name, err)
@@ -513,8 +615,7 @@ func GetAllHostIPv4Addresses(interfaceFilters []NetFilter) ([]string, error) {
 					return ips, errors.New(fmt.Sprintf("interface %v has address object of unexpected type %T.", i.Name, addr))
 				}
 
-				// If it's a v4 address keep it.
-				if IsIPv4(ip.String()) {
+				if keepIP(ip) {
 					ips = append(ips, ip.String())
 				}
 
@@ -753,7 +854,23 @@ func RemoveArchFromServiceId(sId string) string {
 	return sId_no_arch
 }
 
+// The kubeconfig file of the cluster that services are deployed to, empty for the cluster that anax runs in.
+var kubeConfigFile string
+
+// Set the kubeconfig file that NewKubeConfig uses, from the Edge.KubeConfigFile setting of the anax config.
+func SetKubeConfigFile(file string) {
+	kubeConfigFile = file
+}
+
 func NewKubeConfig() (*rest.Config, error) {
+	if kubeConfigFile != "" {
+		config, err := clientcmd.BuildConfigFromFlags("", kubeConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get cluster config information from %v: %v", kubeConfigFile, err)
+		}
+		return config, nil
+	}
+
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get cluster config information: %v", err)
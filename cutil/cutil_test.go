@@ -403,3 +403,38 @@ func Test_RemoveArchFromServiceId(t *testing.T) {
 		t.Errorf("RemoveArchFromServiceId should have returned 'mycluster/hello' but got: %v", no_arch)
 	}
 }
+
+func Test_SetNodeContextEnvvars(t *testing.T) {
+	ctx := NodeContext{
+		NodeId:         "node1",
+		Org:            "myorg",
+		AgreementId:    "ag1",
+		ServiceVersion: "1.0.0",
+		Properties:     map[string]string{"site.name": "plant-3", "db_password": "pw", "line": "4"},
+	}
+
+	envAdds := map[string]string{"HZN_DEVICE_ID": "node1"}
+	set := SetNodeContextEnvvars(envAdds, "HZN_", ctx, nil)
+
+	expected := map[string]string{
+		"HZN_NODE_ID":                 "node1",
+		"HZN_NODE_ORG":                "myorg",
+		"HZN_NODE_AGREEMENT_ID":       "ag1",
+		"HZN_NODE_SERVICE_VERSION":    "1.0.0",
+		"HZN_NODE_ARCH":               ArchString(),
+		"HZN_NODE_PROPERTY_SITE_NAME": "plant-3",
+		"HZN_NODE_PROPERTY_LINE":      "4",
+	}
+	assert.Equal(t, expected, set, "the node context env vars should not include empty values or secrets")
+	assert.Equal(t, len(expected)+1, len(envAdds), "the node context env vars should be added to the existing env vars")
+
+	envAdds = make(map[string]string)
+	set = SetNodeContextEnvvars(envAdds, "HZN_", ctx, []string{"property_*", "AGREEMENT_ID"})
+	if _, ok := set["HZN_NODE_PROPERTY_LINE"]; ok {
+		t.Errorf("the node properties should be omitted, got %v", set)
+	} else if _, ok := set["HZN_NODE_AGREEMENT_ID"]; ok {
+		t.Errorf("the agreement id should be omitted, got %v", set)
+	} else if set["HZN_NODE_ID"] != "node1" {
+		t.Errorf("the node id should not be omitted, got %v", set)
+	}
+}
@@ -0,0 +1,94 @@
+package cutil
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"syscall"
+)
+
+// The states of the free space of a partition, against the thresholds of the disk configuration.
+const DISK_STATE_OK = "ok"
+const DISK_STATE_WARNING = "warning"   // below WarnFreeMB, an alert is logged
+const DISK_STATE_CRITICAL = "critical" // below MinFreeMB, the operations that fill the partition are refused
+const DISK_STATE_UNKNOWN = "unknown"   // the space of the partition cannot be read
+
+// The space of the partition that holds a directory of the agent.
+type DiskUsage struct {
+	Name    string `json:"name"` // what the agent keeps on the partition, "images" or "database"
+	Path    string `json:"path"`
+	TotalMB uint64 `json:"total_mb"`
+	FreeMB  uint64 `json:"free_mb"`
+	State   string `json:"state"`
+	Error   string `json:"error,omitempty"` // why the space cannot be read, when the state is unknown
+}
+
+func (d DiskUsage) String() string {
+	return fmt.Sprintf("Name: %v, Path: %v, TotalMB: %v, FreeMB: %v, State: %v, Error: %v", d.Name, d.Path, d.TotalMB, d.FreeMB, d.State, d.Error)
+}
+
+// Returns the space of the partition that holds the path. The free space is the space that unprivileged processes
+// can use, the space reserved for root is not counted.
+func GetDiskUsage(name string, path string) (*DiskUsage, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return nil, fmt.Errorf("unable to read the space of the partition of %v, error %v", path, err)
+	}
+	return &DiskUsage{
+		Name:    name,
+		Path:    path,
+		TotalMB: fs.Blocks * uint64(fs.Bsize) / (1024 * 1024),
+		FreeMB:  fs.Bavail * uint64(fs.Bsize) / (1024 * 1024),
+	}, nil
+}
+
+// Set the state of the free space against the thresholds.
+func (d *DiskUsage) SetState(cfg *config.DiskConfig) {
+	if cfg.MinFreeMB != 0 && d.FreeMB < cfg.MinFreeMB {
+		d.State = DISK_STATE_CRITICAL
+	} else if cfg.WarnFreeMB != 0 && d.FreeMB < cfg.WarnFreeMB {
+		d.State = DISK_STATE_WARNING
+	} else {
+		d.State = DISK_STATE_OK
+	}
+}
+
+// Returns the space of the partitions of the image storage, when the node runs containers, and of the database. The
+// image storage is a directory of the host, it is read under the HostRootPath when anax runs in a container. A
+// partition that cannot be read has the unknown state and the error.
+func GetAgentDiskUsage(cfg *config.HorizonConfig) []DiskUsage {
+	edge := cfg.LiveEdge()
+	disk := edge.Disk
+	paths := [][2]string{}
+	if cfg.Edge.DockerEndpoint != "" {
+		paths = append(paths, [2]string{"images", edge.HostPath(disk.GetImageStoragePath())})
+	}
+	paths = append(paths, [2]string{"database", cfg.Edge.DBPath})
+
+	usage := make([]DiskUsage, 0, len(paths))
+	for _, p := range paths {
+		if d, err := GetDiskUsage(p[0], p[1]); err != nil {
+			glog.Warningf("Unable to check the free space for the %v: %v", p[0], err)
+			usage = append(usage, DiskUsage{Name: p[0], Path: p[1], State: DISK_STATE_UNKNOWN, Error: err.Error()})
+		} else {
+			d.SetState(&disk)
+			usage = append(usage, *d)
+		}
+	}
+	return usage
+}
+
+// Returns an error when a partition of the agent has less free space than the MinFreeMB of the configuration, so that
+// the operation that would fill it is refused.
+func CheckDiskSpace(cfg *config.HorizonConfig) error {
+	minFreeMB := cfg.LiveEdge().Disk.MinFreeMB
+	if minFreeMB == 0 {
+		return nil
+	}
+	for _, d := range GetAgentDiskUsage(cfg) {
+		if d.State == DISK_STATE_CRITICAL {
+			return fmt.Errorf("only %v MB are free on the partition of the %v at %v, at least %v MB are required", d.FreeMB, d.Name, d.Path, minFreeMB)
+		}
+	}
+	return nil
+}
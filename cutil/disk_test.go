@@ -0,0 +1,71 @@
+// +build unit
+
+package cutil
+
+import (
+	"github.com/open-horizon/anax/config"
+	"os"
+	"testing"
+)
+
+func Test_GetDiskUsage(t *testing.T) {
+	d, err := GetDiskUsage("temp", os.TempDir())
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if d.TotalMB == 0 || d.FreeMB > d.TotalMB {
+		t.Errorf("unexpected space %v", d)
+	}
+
+	if _, err := GetDiskUsage("none", "/no/such/directory"); err == nil {
+		t.Errorf("expected an error for a directory that does not exist")
+	}
+}
+
+func Test_DiskUsageState(t *testing.T) {
+	cfg := &config.DiskConfig{MinFreeMB: 100, WarnFreeMB: 1000}
+	for free, expected := range map[uint64]string{50: DISK_STATE_CRITICAL, 500: DISK_STATE_WARNING, 5000: DISK_STATE_OK} {
+		d := &DiskUsage{FreeMB: free}
+		if d.SetState(cfg); d.State != expected {
+			t.Errorf("%v MB free: expected state %v, got %v", free, expected, d.State)
+		}
+	}
+
+	d := &DiskUsage{FreeMB: 0}
+	if d.SetState(&config.DiskConfig{}); d.State != DISK_STATE_OK {
+		t.Errorf("without thresholds the state should be ok, got %v", d.State)
+	}
+}
+
+func Test_CheckDiskSpace(t *testing.T) {
+	cfg := &config.HorizonConfig{Edge: config.Config{DBPath: os.TempDir()}}
+	if err := CheckDiskSpace(cfg); err != nil {
+		t.Errorf("no error expected without a threshold, got %v", err)
+	}
+
+	cfg.Edge.Disk.MinFreeMB = 1 << 40
+	if err := CheckDiskSpace(cfg); err == nil {
+		t.Errorf("expected an error when the threshold is above the size of the partition")
+	}
+}
+
+func Test_GetAgentDiskUsage(t *testing.T) {
+	// the image storage of the host is read under the HostRootPath
+	cfg := &config.HorizonConfig{Edge: config.Config{DBPath: os.TempDir(), DockerEndpoint: "unix:///var/run/docker.sock", HostRootPath: "/no/such/host"}}
+
+	usage := GetAgentDiskUsage(cfg)
+	if len(usage) != 2 {
+		t.Fatalf("expected the images and the database, got %v", usage)
+	} else if usage[0].Name != "images" || usage[0].Path != "/no/such/host/var/lib/docker" {
+		t.Errorf("expected the image storage under the host root, got %v", usage[0])
+	} else if usage[0].State != DISK_STATE_UNKNOWN || usage[0].Error == "" {
+		t.Errorf("expected the unknown state and the error for a partition that cannot be read, got %v", usage[0])
+	} else if usage[1].Name != "database" || usage[1].State != DISK_STATE_OK {
+		t.Errorf("expected the database partition to be ok, got %v", usage[1])
+	}
+
+	// a partition that cannot be read does not refuse the operations
+	cfg.Edge.Disk.MinFreeMB = 1
+	if err := CheckDiskSpace(cfg); err != nil {
+		t.Errorf("no error expected, got %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+package cutil
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// The name prefixes of the interfaces that docker and container networking create on the host. Their addresses are
+// not reachable from outside of the host.
+var containerInterfacePrefixes = []string{"docker", "br-", "veth", "cni", "flannel", "virbr"}
+
+// An IPv4 or IPv6 address of a host interface.
+type InterfaceAddress struct {
+	Interface string `json:"interface"`
+	Address   string `json:"address"`
+}
+
+// The address that the node reports as its own, and why it was chosen. The address is IPv4 unless the node has no
+// usable IPv4 address. On a dual-stack host, IPv6 is the IPv6 address of the same interface.
+type HostAddress struct {
+	InterfaceAddress
+	IPv6   string `json:"ipv6,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (h HostAddress) String() string {
+	return fmt.Sprintf("Interface: %v, Address: %v, IPv6: %v, Reason: %v", h.Interface, h.Address, h.IPv6, h.Reason)
+}
+
+// The address families that are functional on the node, an IPv6-only node has no IPv4. A family is functional when
+// the node has a usable address in it and can open a connection with it, the error says why it is not.
+type AddressFamilies struct {
+	IPv4      bool   `json:"ipv4"`
+	IPv6      bool   `json:"ipv6"`
+	IPv4Error string `json:"ipv4_error,omitempty"`
+	IPv6Error string `json:"ipv6_error,omitempty"`
+}
+
+func (a AddressFamilies) String() string {
+	return fmt.Sprintf("IPv4: %v, IPv6: %v, IPv4Error: %v, IPv6Error: %v", a.IPv4, a.IPv6, a.IPv4Error, a.IPv6Error)
+}
+
+// The addresses that are used to check that a family has a route off the host when the target has no address in
+// that family. Connecting a UDP socket only looks up the route, nothing is sent.
+const ipv4RouteProbe = "192.0.2.1:53"
+const ipv6RouteProbe = "[2001:db8::1]:53"
+
+// Returns whether the address families of the given host interface addresses are functional. The target is a URL,
+// usually the exchange, that is connected to over each family it has an address in. For a family that the target
+// has no address in, the family is functional when the host has a route for it.
+func CheckAddressFamilies(addrs []InterfaceAddress, target string, timeout time.Duration) AddressFamilies {
+
+	hasIPv4, hasIPv6 := false, false
+	for _, addr := range addrs {
+		if IsIPv4(addr.Address) {
+			hasIPv4 = true
+		} else {
+			hasIPv6 = true
+		}
+	}
+
+	// the addresses of the target, by family
+	var targetIPv4, targetIPv6 string
+	if target != "" {
+		if u, err := url.Parse(target); err != nil || u.Hostname() == "" {
+			glog.Warningf("Could not get the host of %v to check the address families, error: %v", target, err)
+		} else {
+			port := u.Port()
+			if port == "" && u.Scheme == "http" {
+				port = "80"
+			} else if port == "" {
+				port = "443"
+			}
+			ips, err := net.LookupIP(u.Hostname())
+			if err != nil {
+				glog.Warningf("Could not resolve %v to check the address families, error: %v", u.Hostname(), err)
+			}
+			for _, ip := range ips {
+				if ip.To4() != nil && targetIPv4 == "" {
+					targetIPv4 = net.JoinHostPort(ip.String(), port)
+				} else if ip.To4() == nil && targetIPv6 == "" {
+					targetIPv6 = net.JoinHostPort(ip.String(), port)
+				}
+			}
+		}
+	}
+
+	families := AddressFamilies{}
+	families.IPv4, families.IPv4Error = checkAddressFamily("IPv4", hasIPv4, "tcp4", targetIPv4, "udp4", ipv4RouteProbe, timeout)
+	families.IPv6, families.IPv6Error = checkAddressFamily("IPv6", hasIPv6, "tcp6", targetIPv6, "udp6", ipv6RouteProbe, timeout)
+	return families
+}
+
+// Returns whether one address family is functional, and why not when it is not.
+func checkAddressFamily(family string, hasAddress bool, network string, target string, routeNetwork string, routeProbe string, timeout time.Duration) (bool, string) {
+	if !hasAddress {
+		return false, fmt.Sprintf("no usable %v address", family)
+	}
+
+	if target != "" {
+		conn, err := net.DialTimeout(network, target, timeout)
+		if err != nil {
+			return false, fmt.Sprintf("could not connect to %v, error: %v", target, err)
+		}
+		conn.Close()
+		return true, ""
+	}
+
+	conn, err := net.DialTimeout(routeNetwork, routeProbe, timeout)
+	if err != nil {
+		return false, fmt.Sprintf("no %v route, error: %v", family, err)
+	}
+	conn.Close()
+	return true, ""
+}
+
+func OmitContainerBridges(i net.Interface) bool {
+	for _, prefix := range containerInterfacePrefixes {
+		if strings.HasPrefix(i.Name, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the IPv4 and IPv6 addresses of the host interfaces that the node can be reached on, in the order of the
+// interfaces. Interfaces that are down, loopback and container bridges are left out, and so are link local addresses.
+func GetHostInterfaceAddresses() ([]InterfaceAddress, error) {
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("could not get network interfaces, error: %v", err))
+	}
+
+	addrs := make([]InterfaceAddress, 0, 5)
+	for _, i := range interfaces {
+		if !OmitDown(i) || !OmitLoopback(i) || !OmitContainerBridges(i) {
+			continue
+		}
+
+		iaddrs, err := i.Addrs()
+		if err != nil {
+			glog.Warningf("Could not get IP address(es) for network interface %v, error: %v", i.Name, err)
+			continue
+		}
+		for _, addr := range iaddrs {
+			var ip net.IP
+			switch v := addr.(type) {
+			case *net.IPNet:
+				ip = v.IP
+			case *net.IPAddr:
+				ip = v.IP
+			default:
+				continue
+			}
+
+			if ip.IsGlobalUnicast() {
+				addrs = append(addrs, InterfaceAddress{Interface: i.Name, Address: ip.String()})
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// Returns the address that the node reports as its own. The preferred input is the HostAddress config, an interface
+// name or a CIDR. An empty preferred means the first address is used.
+func SelectHostAddress(preferred string) (*HostAddress, error) {
+	addrs, err := GetHostInterfaceAddresses()
+	if err != nil {
+		return nil, err
+	}
+	return selectHostAddress(addrs, preferred)
+}
+
+// Choose the address from the input addresses. An IPv4 address is preferred to an IPv6 address, unless the preferred
+// CIDR is IPv6. When no address matches the preferred interface or CIDR the first address is used, so that the node
+// still has an address, and the reason says so.
+func selectHostAddress(addrs []InterfaceAddress, preferred string) (*HostAddress, error) {
+
+	if len(addrs) == 0 {
+		return nil, errors.New("no usable IPv4 or IPv6 address found on the host interfaces")
+	}
+
+	// the IPv4 addresses first, so that the first match is IPv4 on a dual-stack host
+	ordered := make([]InterfaceAddress, 0, len(addrs))
+	for _, addr := range addrs {
+		if IsIPv4(addr.Address) {
+			ordered = append(ordered, addr)
+		}
+	}
+	for _, addr := range addrs {
+		if !IsIPv4(addr.Address) {
+			ordered = append(ordered, addr)
+		}
+	}
+
+	if preferred == "" {
+		return newHostAddress(ordered[0], ordered, "the first usable address, no preferred interface or CIDR is configured"), nil
+	}
+
+	var cidr *net.IPNet
+	if strings.Contains(preferred, "/") {
+		_, n, err := net.ParseCIDR(preferred)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("preferred host address %v is not a valid CIDR, error: %v", preferred, err))
+		}
+		cidr = n
+	}
+
+	for _, addr := range ordered {
+		if cidr != nil && cidr.Contains(net.ParseIP(addr.Address)) {
+			return newHostAddress(addr, ordered, fmt.Sprintf("the first address in the preferred CIDR %v", preferred)), nil
+		} else if cidr == nil && addr.Interface == preferred {
+			return newHostAddress(addr, ordered, fmt.Sprintf("the address of the preferred interface %v", preferred)), nil
+		}
+	}
+
+	glog.Warningf("No usable address matches the preferred host address %v, using %v of interface %v", preferred, ordered[0].Address, ordered[0].Interface)
+	return newHostAddress(ordered[0], ordered, fmt.Sprintf("the first usable address, no address matches the preferred interface or CIDR %v", preferred)), nil
+}
+
+// Returns the chosen address with the IPv6 address of its interface.
+func newHostAddress(chosen InterfaceAddress, addrs []InterfaceAddress, reason string) *HostAddress {
+	h := &HostAddress{InterfaceAddress: chosen, Reason: reason}
+	if !IsIPv4(chosen.Address) {
+		h.IPv6 = chosen.Address
+		return h
+	}
+	for _, addr := range addrs {
+		if addr.Interface == chosen.Interface && !IsIPv4(addr.Address) {
+			h.IPv6 = addr.Address
+			break
+		}
+	}
+	return h
+}
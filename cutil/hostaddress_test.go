@@ -0,0 +1,119 @@
+// +build unit
+
+package cutil
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_selectHostAddress(t *testing.T) {
+
+	addrs := []InterfaceAddress{
+		{Interface: "wlan0", Address: "192.168.1.20"},
+		{Interface: "wwan0", Address: "10.64.3.7"},
+		{Interface: "eth0", Address: "172.16.5.4"},
+		{Interface: "eth0", Address: "172.16.6.4"},
+	}
+
+	tests := []struct {
+		preferred string
+		address   string
+	}{
+		{"", "192.168.1.20"},
+		{"eth0", "172.16.5.4"},
+		{"wwan0", "10.64.3.7"},
+		{"172.16.6.0/24", "172.16.6.4"},
+		{"10.0.0.0/8", "10.64.3.7"},
+		{"eth1", "192.168.1.20"},
+		{"192.0.2.0/24", "192.168.1.20"},
+	}
+
+	for _, test := range tests {
+		if h, err := selectHostAddress(addrs, test.preferred); err != nil {
+			t.Errorf("unexpected error for %v: %v", test.preferred, err)
+		} else if h.Address != test.address {
+			t.Errorf("expected %v for %v, got %v", test.address, test.preferred, h)
+		} else if h.Reason == "" {
+			t.Errorf("no reason for %v", h)
+		}
+	}
+
+	if _, err := selectHostAddress(addrs, "172.16.6.0/33"); err == nil {
+		t.Errorf("expected an error for an invalid CIDR")
+	} else if _, err := selectHostAddress([]InterfaceAddress{}, ""); err == nil {
+		t.Errorf("expected an error for no addresses")
+	}
+}
+
+func Test_selectHostAddress_IPv6(t *testing.T) {
+
+	dualStack := []InterfaceAddress{
+		{Interface: "eth0", Address: "2001:db8:1::20"},
+		{Interface: "eth0", Address: "192.168.1.20"},
+		{Interface: "eth1", Address: "fd00:1::4"},
+	}
+
+	tests := []struct {
+		addrs     []InterfaceAddress
+		preferred string
+		address   string
+		ipv6      string
+	}{
+		{dualStack, "", "192.168.1.20", "2001:db8:1::20"},
+		{dualStack, "eth0", "192.168.1.20", "2001:db8:1::20"},
+		{dualStack, "eth1", "fd00:1::4", "fd00:1::4"},
+		{dualStack, "fd00::/8", "fd00:1::4", "fd00:1::4"},
+		{[]InterfaceAddress{{Interface: "eth0", Address: "2001:db8:1::20"}}, "", "2001:db8:1::20", "2001:db8:1::20"},
+		{[]InterfaceAddress{{Interface: "eth0", Address: "192.168.1.20"}}, "", "192.168.1.20", ""},
+	}
+
+	for _, test := range tests {
+		if h, err := selectHostAddress(test.addrs, test.preferred); err != nil {
+			t.Errorf("unexpected error for %v: %v", test.preferred, err)
+		} else if h.Address != test.address || h.IPv6 != test.ipv6 {
+			t.Errorf("expected %v and IPv6 %v for %v, got %v", test.address, test.ipv6, test.preferred, h)
+		}
+	}
+}
+
+func Test_CheckAddressFamilies(t *testing.T) {
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen, error %v", err)
+	}
+	target := "http://" + listener.Addr().String()
+	listener.Close()
+
+	ipv4 := []InterfaceAddress{{Interface: "eth0", Address: "192.168.1.20"}}
+
+	// nothing is listening on the target anymore, so IPv4 has an address but is not functional
+	if f := CheckAddressFamilies(ipv4, target, time.Second); f.IPv4 || f.IPv4Error == "" {
+		t.Errorf("expected IPv4 not to be functional, got %v", f)
+	} else if f.IPv6 || f.IPv6Error != "no usable IPv6 address" {
+		t.Errorf("expected no IPv6 address, got %v", f)
+	}
+
+	listener, err = net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen, error %v", err)
+	}
+	defer listener.Close()
+	target = "http://" + listener.Addr().String()
+
+	if f := CheckAddressFamilies(ipv4, target, time.Second); !f.IPv4 || f.IPv4Error != "" {
+		t.Errorf("expected IPv4 to be functional, got %v", f)
+	} else if f := CheckAddressFamilies([]InterfaceAddress{}, target, time.Second); f.IPv4 || f.IPv6 {
+		t.Errorf("expected no address family, got %v", f)
+	}
+}
+
+func Test_OmitContainerBridges(t *testing.T) {
+	for name, keep := range map[string]bool{"eth0": true, "wlan0": true, "docker0": false, "br-3f2a": false, "veth12ab": false, "cni0": false} {
+		if OmitContainerBridges(net.Interface{Name: name}) != keep {
+			t.Errorf("expected %v to be kept %v", name, keep)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package cutil
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// The separator of the key path of a value in a nested map, e.g. "a.b" is the key "b" in the map value of "a".
+const MergePathSeparator = "."
+
+// A set of values that is merged with other layers by MergeLayers. A layer with a higher precedence overrides a
+// layer with a lower precedence. Layers of equal precedence are applied in their input order.
+type MergeLayer struct {
+	Name       string                 // The name of the layer, it is recorded as the source of the values it sets.
+	Precedence int                    // Higher precedence layers override lower ones.
+	Values     map[string]interface{} // The values of the layer.
+}
+
+// Two layers of equal precedence that set different values for the same key path.
+type MergeConflict struct {
+	Key    string        `json:"key"`
+	Layers []string      `json:"layers"`
+	Values []interface{} `json:"values"`
+}
+
+func (c MergeConflict) String() string {
+	return fmt.Sprintf("Key: %v, Layers: %v, Values: %v", c.Key, c.Layers, c.Values)
+}
+
+// The outcome of MergeLayers.
+type MergeResult struct {
+	Values     map[string]interface{} // The effective values.
+	Provenance map[string]string      // The name of the layer that set each leaf key path of the effective values.
+	Conflicts  []MergeConflict        // The disagreements between layers of equal precedence, the later layer won.
+}
+
+// Merges the layers into one map. The layers are applied from the lowest to the highest precedence, so a value of a
+// higher precedence layer overrides the value of a lower one. Map values (map[string]interface{}) are merged key by
+// key, recursively. Every other value, including lists, is replaced as a whole, lists are not concatenated. When two
+// layers of equal precedence set different values for the same key path, a conflict is reported and the layer that
+// comes later in the input wins. The input layers are not modified.
+func MergeLayers(layers []MergeLayer) MergeResult {
+
+	ordered := make([]int, len(layers))
+	for i := range ordered {
+		ordered[i] = i
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return layers[ordered[i]].Precedence < layers[ordered[j]].Precedence
+	})
+
+	m := merger{
+		layers: layers,
+		owners: make(map[string]int),
+		result: MergeResult{
+			Values:     make(map[string]interface{}),
+			Provenance: make(map[string]string),
+			Conflicts:  []MergeConflict{},
+		},
+	}
+	for _, l := range ordered {
+		m.mergeMap(m.result.Values, layers[l].Values, "", l)
+	}
+
+	for path, l := range m.owners {
+		m.result.Provenance[path] = layers[l].Name
+	}
+	return m.result
+}
+
+// The state of a merge, owners has the index of the layer that set each leaf key path.
+type merger struct {
+	layers []MergeLayer
+	owners map[string]int
+	result MergeResult
+}
+
+func (m *merger) mergeMap(dst map[string]interface{}, src map[string]interface{}, prefix string, layer int) {
+
+	// visit the keys in order so that the conflicts are reported in a stable order
+	keys := make([]string, 0, len(src))
+	for k := range src {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		path := k
+		if prefix != "" {
+			path = prefix + MergePathSeparator + k
+		}
+
+		v := src[k]
+		existing, found := dst[k]
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := existing.(map[string]interface{})
+
+		if srcIsMap && dstIsMap {
+			// an empty map is a leaf until keys are merged into it
+			if len(srcMap) != 0 {
+				delete(m.owners, path)
+			}
+			m.mergeMap(dstMap, srcMap, path, layer)
+			continue
+		}
+
+		if found {
+			m.checkConflict(path, existing, v, layer)
+			m.disown(path)
+		}
+
+		if srcIsMap && len(srcMap) != 0 {
+			newMap := make(map[string]interface{}, len(srcMap))
+			dst[k] = newMap
+			m.mergeMap(newMap, srcMap, path, layer)
+		} else {
+			dst[k] = copyMergeValue(v)
+			m.owners[path] = layer
+		}
+	}
+}
+
+// Records a conflict if a layer of the same precedence as the input layer set the value at the key path, or a value
+// under it, and the new value is different.
+func (m *merger) checkConflict(path string, existing interface{}, value interface{}, layer int) {
+	if reflect.DeepEqual(existing, value) {
+		return
+	}
+
+	for p, owner := range m.owners {
+		if (p == path || strings.HasPrefix(p, path+MergePathSeparator)) && owner != layer && m.layers[owner].Precedence == m.layers[layer].Precedence {
+			m.result.Conflicts = append(m.result.Conflicts, MergeConflict{
+				Key:    path,
+				Layers: []string{m.layers[owner].Name, m.layers[layer].Name},
+				Values: []interface{}{copyMergeValue(existing), copyMergeValue(value)},
+			})
+			return
+		}
+	}
+}
+
+// Forgets the owners of the value at the key path and of the values under it, because it is replaced.
+func (m *merger) disown(path string) {
+	for p := range m.owners {
+		if p == path || strings.HasPrefix(p, path+MergePathSeparator) {
+			delete(m.owners, p)
+		}
+	}
+}
+
+// Copies the maps and lists of a value so that the merge result does not share them with the layers.
+func copyMergeValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			c[k] = copyMergeValue(e)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(t))
+		for i, e := range t {
+			c[i] = copyMergeValue(e)
+		}
+		return c
+	default:
+		return v
+	}
+}
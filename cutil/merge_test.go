@@ -0,0 +1,127 @@
+// +build unit
+
+package cutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_MergeLayers(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		layers     []MergeLayer
+		values     map[string]interface{}
+		provenance map[string]string
+		conflicts  []MergeConflict
+	}{
+		{
+			name:       "no layers",
+			layers:     []MergeLayer{},
+			values:     map[string]interface{}{},
+			provenance: map[string]string{},
+			conflicts:  []MergeConflict{},
+		},
+		{
+			name: "higher precedence overrides",
+			layers: []MergeLayer{
+				{Name: "node", Precedence: 2, Values: map[string]interface{}{"a": "node", "c": 3}},
+				{Name: "pattern", Precedence: 1, Values: map[string]interface{}{"a": "pattern", "b": true}},
+			},
+			values:     map[string]interface{}{"a": "node", "b": true, "c": 3},
+			provenance: map[string]string{"a": "node", "b": "pattern", "c": "node"},
+			conflicts:  []MergeConflict{},
+		},
+		{
+			name: "maps are merged key by key",
+			layers: []MergeLayer{
+				{Name: "pattern", Precedence: 1, Values: map[string]interface{}{"m": map[string]interface{}{"x": 1, "y": map[string]interface{}{"z": 1}}}},
+				{Name: "node", Precedence: 2, Values: map[string]interface{}{"m": map[string]interface{}{"y": map[string]interface{}{"w": 2}}}},
+			},
+			values:     map[string]interface{}{"m": map[string]interface{}{"x": 1, "y": map[string]interface{}{"z": 1, "w": 2}}},
+			provenance: map[string]string{"m.x": "pattern", "m.y.z": "pattern", "m.y.w": "node"},
+			conflicts:  []MergeConflict{},
+		},
+		{
+			name: "lists are replaced",
+			layers: []MergeLayer{
+				{Name: "pattern", Precedence: 1, Values: map[string]interface{}{"l": []interface{}{1, 2}}},
+				{Name: "node", Precedence: 2, Values: map[string]interface{}{"l": []interface{}{3}}},
+			},
+			values:     map[string]interface{}{"l": []interface{}{3}},
+			provenance: map[string]string{"l": "node"},
+			conflicts:  []MergeConflict{},
+		},
+		{
+			name: "a scalar replaces a map",
+			layers: []MergeLayer{
+				{Name: "pattern", Precedence: 1, Values: map[string]interface{}{"m": map[string]interface{}{"x": 1}}},
+				{Name: "node", Precedence: 2, Values: map[string]interface{}{"m": "flat"}},
+			},
+			values:     map[string]interface{}{"m": "flat"},
+			provenance: map[string]string{"m": "node"},
+			conflicts:  []MergeConflict{},
+		},
+		{
+			name: "equal precedence conflict, the later layer wins",
+			layers: []MergeLayer{
+				{Name: "node1", Precedence: 2, Values: map[string]interface{}{"a": 1, "b": "same"}},
+				{Name: "node2", Precedence: 2, Values: map[string]interface{}{"a": 2, "b": "same"}},
+			},
+			values:     map[string]interface{}{"a": 2, "b": "same"},
+			provenance: map[string]string{"a": "node2", "b": "node2"},
+			conflicts:  []MergeConflict{{Key: "a", Layers: []string{"node1", "node2"}, Values: []interface{}{1, 2}}},
+		},
+		{
+			name: "equal precedence conflict in a nested map",
+			layers: []MergeLayer{
+				{Name: "node1", Precedence: 2, Values: map[string]interface{}{"m": map[string]interface{}{"x": 1, "y": 1}}},
+				{Name: "node2", Precedence: 2, Values: map[string]interface{}{"m": map[string]interface{}{"x": 2}}},
+			},
+			values:     map[string]interface{}{"m": map[string]interface{}{"x": 2, "y": 1}},
+			provenance: map[string]string{"m.x": "node2", "m.y": "node1"},
+			conflicts:  []MergeConflict{{Key: "m.x", Layers: []string{"node1", "node2"}, Values: []interface{}{1, 2}}},
+		},
+		{
+			name: "a lower precedence layer does not conflict",
+			layers: []MergeLayer{
+				{Name: "pattern", Precedence: 1, Values: map[string]interface{}{"a": 1}},
+				{Name: "node1", Precedence: 2, Values: map[string]interface{}{"a": 2}},
+				{Name: "node2", Precedence: 2, Values: map[string]interface{}{"a": 2}},
+			},
+			values:     map[string]interface{}{"a": 2},
+			provenance: map[string]string{"a": "node2"},
+			conflicts:  []MergeConflict{},
+		},
+	}
+
+	for _, test := range tests {
+		result := MergeLayers(test.layers)
+		if !reflect.DeepEqual(result.Values, test.values) {
+			t.Errorf("%v: expected values %v, got %v", test.name, test.values, result.Values)
+		}
+		if !reflect.DeepEqual(result.Provenance, test.provenance) {
+			t.Errorf("%v: expected provenance %v, got %v", test.name, test.provenance, result.Provenance)
+		}
+		if !reflect.DeepEqual(result.Conflicts, test.conflicts) {
+			t.Errorf("%v: expected conflicts %v, got %v", test.name, test.conflicts, result.Conflicts)
+		}
+	}
+}
+
+func Test_MergeLayers_input_not_modified(t *testing.T) {
+
+	low := map[string]interface{}{"m": map[string]interface{}{"x": 1}, "l": []interface{}{1}}
+	high := map[string]interface{}{"m": map[string]interface{}{"y": 2}}
+
+	result := MergeLayers([]MergeLayer{{Name: "low", Precedence: 1, Values: low}, {Name: "high", Precedence: 2, Values: high}})
+	result.Values["m"].(map[string]interface{})["z"] = 3
+	result.Values["l"].([]interface{})[0] = 9
+
+	if !reflect.DeepEqual(low, map[string]interface{}{"m": map[string]interface{}{"x": 1}, "l": []interface{}{1}}) {
+		t.Errorf("the low layer was modified: %v", low)
+	} else if !reflect.DeepEqual(high, map[string]interface{}{"m": map[string]interface{}{"y": 2}}) {
+		t.Errorf("the high layer was modified: %v", high)
+	}
+}
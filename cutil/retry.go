@@ -0,0 +1,89 @@
+package cutil
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// How a failing operation is retried by Retry.
+type RetryPolicy struct {
+	Attempts int           // The number of attempts, including the first one. 0 means retry until the context is done.
+	Base     time.Duration // The wait before the first retry, it doubles on each retry.
+	Max      time.Duration // The longest wait between attempts. 0 means the wait is not limited.
+	Jitter   float64       // The fraction (0 to 1) of each wait that is random, so that many nodes do not retry in step.
+}
+
+func (p RetryPolicy) String() string {
+	return fmt.Sprintf("Attempts: %v, Base: %v, Max: %v, Jitter: %v", p.Attempts, p.Base, p.Max, p.Jitter)
+}
+
+// Returns how long to wait before the given retry (1 based), without the jitter. The wait starts at Base and doubles
+// on each retry, up to Max.
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	wait := p.Base
+	for i := 1; i < retry && (p.Max == 0 || wait < p.Max); i++ {
+		wait *= 2
+	}
+	if p.Max != 0 && wait > p.Max {
+		wait = p.Max
+	}
+	return wait
+}
+
+// Returns the wait before the given retry with the jitter applied, it is between (1 - Jitter) * Backoff and Backoff.
+func (p RetryPolicy) jitteredBackoff(retry int) time.Duration {
+	wait := p.Backoff(retry)
+	if p.Jitter <= 0 || wait <= 0 {
+		return wait
+	}
+	jitter := p.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+	return wait - time.Duration(jitter*retryRandom()*float64(wait))
+}
+
+// Returns true if the error can be retried, false if it is terminal. A nil classifier retries every error.
+type RetryClassifier func(err error) bool
+
+// The random source of the jitter, seeded per process so that the nodes of a fleet do not share the sequence.
+var retryRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+var retryRandLock sync.Mutex
+
+func retryRandom() float64 {
+	retryRandLock.Lock()
+	defer retryRandLock.Unlock()
+	return retryRand.Float64()
+}
+
+// Waits for the given duration, it is replaced by a fake clock in the tests.
+var retryAfter = time.After
+
+// Calls fn until it succeeds, it returns a terminal error, the attempts of the policy are used up or the context is
+// done. The error of the last attempt is returned as it is, so that callers can check its type. When the context is
+// done while waiting to retry, the returned error wraps the context error and has the last error in its message.
+func Retry(ctx context.Context, policy RetryPolicy, classify RetryClassifier, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := fn()
+		if err == nil {
+			return nil
+		} else if classify != nil && !classify(err) {
+			return err
+		} else if policy.Attempts > 0 && attempt >= policy.Attempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("retry stopped after %v attempts, last error: %v: %w", attempt, err, ctx.Err())
+		case <-retryAfter(policy.jitteredBackoff(attempt)):
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// +build unit
+
+package cutil
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// A fake clock for Retry, it records the waits and returns right away.
+func fakeRetryClock(t *testing.T, onWait func(d time.Duration) <-chan time.Time) (*[]time.Duration, func()) {
+	waits := make([]time.Duration, 0)
+	saved := retryAfter
+	retryAfter = func(d time.Duration) <-chan time.Time {
+		waits = append(waits, d)
+		if onWait != nil {
+			return onWait(d)
+		}
+		c := make(chan time.Time, 1)
+		c <- time.Time{}
+		return c
+	}
+	return &waits, func() { retryAfter = saved }
+}
+
+func Test_RetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{Base: 15 * time.Second, Max: 300 * time.Second}
+	tests := map[int]time.Duration{1: 15 * time.Second, 2: 30 * time.Second, 3: 60 * time.Second, 10: 300 * time.Second, 100: 300 * time.Second}
+	for retry, wait := range tests {
+		if w := p.Backoff(retry); w != wait {
+			t.Errorf("expected %v for retry %v, got %v", wait, retry, w)
+		}
+	}
+
+	if w := (RetryPolicy{Base: time.Second}).Backoff(5); w != 16*time.Second {
+		t.Errorf("expected an unlimited backoff of 16s, got %v", w)
+	}
+}
+
+func Test_Retry_backoffBounds(t *testing.T) {
+	waits, restore := fakeRetryClock(t, nil)
+	defer restore()
+
+	p := RetryPolicy{Attempts: 6, Base: time.Second, Max: 8 * time.Second, Jitter: 0.5}
+	calls := 0
+	failure := errors.New("failed")
+	err := Retry(context.Background(), p, nil, func() error {
+		calls++
+		return failure
+	})
+
+	if err != failure {
+		t.Errorf("expected the last error, got %v", err)
+	} else if calls != 6 || len(*waits) != 5 {
+		t.Fatalf("expected 6 calls and 5 waits, got %v and %v", calls, *waits)
+	}
+	for i, w := range *waits {
+		max := p.Backoff(i + 1)
+		if w > max || w < max/2 {
+			t.Errorf("wait %v of %v is not between %v and %v", i+1, w, max/2, max)
+		}
+	}
+}
+
+func Test_Retry_classify(t *testing.T) {
+	waits, restore := fakeRetryClock(t, nil)
+	defer restore()
+
+	terminal := errors.New("terminal")
+	retryable := errors.New("retryable")
+	classify := func(err error) bool { return err == retryable }
+
+	// a terminal error is returned right away
+	calls := 0
+	if err := Retry(context.Background(), RetryPolicy{Base: time.Second}, classify, func() error {
+		calls++
+		return terminal
+	}); err != terminal || calls != 1 || len(*waits) != 0 {
+		t.Errorf("expected one call for a terminal error, got %v calls, error %v", calls, err)
+	}
+
+	// retryable errors are retried until the call succeeds
+	calls = 0
+	if err := Retry(context.Background(), RetryPolicy{Base: time.Second}, classify, func() error {
+		if calls++; calls < 3 {
+			return retryable
+		}
+		return nil
+	}); err != nil || calls != 3 || len(*waits) != 2 {
+		t.Errorf("expected success on the 3rd call, got %v calls, error %v", calls, err)
+	}
+}
+
+func Test_Retry_cancel(t *testing.T) {
+
+	// the context is cancelled while waiting for the 3rd retry, which never comes
+	ctx, cancel := context.WithCancel(context.Background())
+	waits, restore := fakeRetryClock(t, func(d time.Duration) <-chan time.Time {
+		c := make(chan time.Time, 1)
+		if ctx.Err() == nil {
+			c <- time.Time{}
+		}
+		return c
+	})
+	defer restore()
+
+	calls := 0
+	err := Retry(ctx, RetryPolicy{Base: time.Second}, nil, func() error {
+		if calls++; calls == 3 {
+			cancel()
+		}
+		return errors.New("failed")
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancelled error, got %v", err)
+	} else if calls != 3 || len(*waits) != 3 {
+		t.Errorf("expected 3 calls and 3 waits, got %v and %v", calls, *waits)
+	}
+
+	// a cancelled context does not call the function at all
+	calls = 0
+	if err := Retry(ctx, RetryPolicy{}, nil, func() error { calls++; return nil }); err != context.Canceled || calls != 0 {
+		t.Errorf("expected no calls for a cancelled context, got %v calls, error %v", calls, err)
+	}
+}
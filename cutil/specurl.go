@@ -0,0 +1,58 @@
+package cutil
+
+import (
+	"strings"
+)
+
+// The ports that are implied by a URL scheme, a spec URL that names one is the same as the spec URL without it.
+var defaultSchemePorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Returns the normalized form of a spec URL, so that the different ways of writing the same spec URL compare equal.
+// The scheme and host are lower cased, the default port of the scheme is removed and trailing slashes are removed.
+// The case of the path is kept, it is significant. A spec URL that is not a URL, e.g. a plain service name, only has
+// its trailing slashes removed.
+func NormalizeSpecURL(specURL string) string {
+	s := strings.TrimSpace(specURL)
+
+	scheme, rest := "", s
+	if i := strings.Index(s, "://"); i > 0 {
+		scheme, rest = strings.ToLower(s[:i]), s[i+3:]
+	}
+
+	// the host ends at the start of the path, query or fragment
+	host, path := rest, ""
+	if i := strings.IndexAny(rest, "/?#"); i != -1 {
+		host, path = rest[:i], rest[i:]
+	}
+
+	if scheme != "" {
+		// the user info is kept as it is, only the host name is not case sensitive
+		userInfo := ""
+		if i := strings.LastIndex(host, "@"); i != -1 {
+			userInfo, host = host[:i+1], host[i+1:]
+		}
+		host = strings.ToLower(host)
+		if port, ok := defaultSchemePorts[scheme]; ok {
+			host = strings.TrimSuffix(host, ":"+port)
+		}
+		host = userInfo + host
+	}
+
+	// a query or fragment is kept as it is
+	if !strings.ContainsAny(path, "?#") {
+		path = strings.TrimRight(path, "/")
+	}
+
+	if scheme != "" {
+		return scheme + "://" + host + path
+	}
+	return host + path
+}
+
+// Returns true if the two spec URLs are the same spec URL once they are normalized.
+func SameSpecURL(a string, b string) bool {
+	return a == b || NormalizeSpecURL(a) == NormalizeSpecURL(b)
+}
@@ -0,0 +1,52 @@
+// +build unit
+
+package cutil
+
+import (
+	"testing"
+)
+
+func Test_NormalizeSpecURL(t *testing.T) {
+
+	tests := []struct {
+		specURL    string
+		normalized string
+	}{
+		{"https://bluehorizon.network/services/gps", "https://bluehorizon.network/services/gps"},
+		{"https://bluehorizon.network/services/gps/", "https://bluehorizon.network/services/gps"},
+		{"https://bluehorizon.network/services/gps//", "https://bluehorizon.network/services/gps"},
+		{"HTTPS://BlueHorizon.Network/services/GPS", "https://bluehorizon.network/services/GPS"},
+		{"https://bluehorizon.network:443/services/gps", "https://bluehorizon.network/services/gps"},
+		{"http://bluehorizon.network:80/services/gps", "http://bluehorizon.network/services/gps"},
+		{"http://bluehorizon.network:443/services/gps", "http://bluehorizon.network:443/services/gps"},
+		{"https://bluehorizon.network:8443/services/gps", "https://bluehorizon.network:8443/services/gps"},
+		{"https://bluehorizon.network", "https://bluehorizon.network"},
+		{"https://bluehorizon.network/", "https://bluehorizon.network"},
+		{"https://User@BlueHorizon.network/gps", "https://User@bluehorizon.network/gps"},
+		{"https://bluehorizon.network/gps?v=1/", "https://bluehorizon.network/gps?v=1/"},
+		{" https://bluehorizon.network/gps ", "https://bluehorizon.network/gps"},
+		{"bluehorizon.network-services-gps", "bluehorizon.network-services-gps"},
+		{"my.company.com.services.GPS/", "my.company.com.services.GPS"},
+		{"gps", "gps"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if n := NormalizeSpecURL(test.specURL); n != test.normalized {
+			t.Errorf("expected %v to be normalized to %v, got %v", test.specURL, test.normalized, n)
+		} else if NormalizeSpecURL(n) != n {
+			t.Errorf("normalizing %v again changed it", n)
+		}
+	}
+}
+
+func Test_SameSpecURL(t *testing.T) {
+
+	if !SameSpecURL("https://bluehorizon.network/services/gps/", "HTTPS://bluehorizon.network:443/services/gps") {
+		t.Errorf("expected the spec URLs to be the same")
+	} else if SameSpecURL("https://bluehorizon.network/services/gps", "https://bluehorizon.network/services/GPS") {
+		t.Errorf("expected spec URLs with a different path case to be different")
+	} else if SameSpecURL("https://bluehorizon.network/services/gps", "http://bluehorizon.network/services/gps") {
+		t.Errorf("expected spec URLs with a different scheme to be different")
+	}
+}
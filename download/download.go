@@ -0,0 +1,196 @@
+package download
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+ * The downloads of the agent share one rate limit. A download reads in small chunks and waits after each chunk until
+ * the limit allows it, the waits of the downloads at a time are queued one after the other so that together they
+ * stay under the limit. The limit is read from the rate function at most once a second, so that a change of the
+ * config or the start of a maintenance window applies to the downloads that are running.
+ *
+ * The container runtime cannot limit its image pulls. While a limit applies, the agent fetches the images from their
+ * registry itself, through the limit, and loads them into the runtime. Otherwise the runtime pulls them, their progress
+ * is observed but they are not limited.
+ */
+
+// How often the rate function is called.
+const RATE_CHECK_INTERVAL = time.Second
+
+// The largest chunk that a download reads at a time.
+const MAX_CHUNK = 32 * 1024
+
+// A download that is running.
+type Transfer struct {
+	Name      string `json:"name"`
+	Bytes     int64  `json:"bytes"`
+	Size      int64  `json:"size,omitempty"` // 0 when the size is not known
+	StartTime uint64 `json:"start_time"`
+	Limited   bool   `json:"limited"` // false for the image pulls of the container runtime, whose progress is only observed
+}
+
+// The rate limit that applies now and the downloads that are running.
+type Status struct {
+	RateLimit int64      `json:"rate_limit"` // bytes per second, 0 means no limit
+	Transfers []Transfer `json:"transfers"`
+}
+
+type limiter struct {
+	lock      sync.Mutex
+	rateFunc  func() int64
+	rate      int64
+	rateTime  time.Time // when the rate function was last called
+	next      time.Time // when the downloads can read again without going over the limit
+	transfers map[uint64]*Transfer
+	lastId    uint64
+}
+
+var downloads = &limiter{transfers: make(map[uint64]*Transfer)}
+
+// Set the function that returns the rate limit in bytes per second, 0 for no limit.
+func SetRateFunc(f func() int64) {
+	downloads.lock.Lock()
+	defer downloads.lock.Unlock()
+	downloads.rateFunc = f
+	downloads.rateTime = time.Time{}
+}
+
+// Returns the rate limit that applies now and the downloads that are running, oldest first.
+func GetStatus() *Status {
+	downloads.lock.Lock()
+	defer downloads.lock.Unlock()
+
+	status := &Status{RateLimit: downloads.currentRate(time.Now()), Transfers: make([]Transfer, 0, len(downloads.transfers))}
+	for _, t := range downloads.transfers {
+		status.Transfers = append(status.Transfers, *t)
+	}
+	sort.Slice(status.Transfers, func(i, j int) bool { return status.Transfers[i].StartTime < status.Transfers[j].StartTime })
+	return status
+}
+
+// Returns true if a rate limit applies now.
+func Limited() bool {
+	downloads.lock.Lock()
+	defer downloads.lock.Unlock()
+	return downloads.currentRate(time.Now()) > 0
+}
+
+// Must be called with the lock held.
+func (l *limiter) currentRate(now time.Time) int64 {
+	if l.rateFunc != nil && now.Sub(l.rateTime) >= RATE_CHECK_INTERVAL {
+		l.rate = l.rateFunc()
+		l.rateTime = now
+	}
+	return l.rate
+}
+
+func (l *limiter) start(name string, size int64, limited bool) uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.lastId++
+	l.transfers[l.lastId] = &Transfer{Name: name, Size: size, StartTime: uint64(time.Now().Unix()), Limited: limited}
+	return l.lastId
+}
+
+func (l *limiter) update(id uint64, bytes int64, size int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if t, ok := l.transfers[id]; ok {
+		t.Bytes = bytes
+		t.Size = size
+	}
+}
+
+func (l *limiter) done(id uint64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	delete(l.transfers, id)
+}
+
+// Returns how many bytes a download reads at a time, so that a slow limit is not used up by a single read.
+func (l *limiter) chunk() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	rate := l.currentRate(time.Now())
+	if rate <= 0 || rate/4 >= MAX_CHUNK {
+		return MAX_CHUNK
+	} else if rate/4 < 512 {
+		return 512
+	}
+	return int(rate / 4)
+}
+
+// Records the bytes that a download read and returns how long it must wait before it reads again.
+func (l *limiter) take(id uint64, n int) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if t, ok := l.transfers[id]; ok {
+		t.Bytes += int64(n)
+	}
+
+	now := time.Now()
+	rate := l.currentRate(now)
+	if rate <= 0 {
+		return 0
+	}
+	// the time that a download did not use is not saved up for a burst later
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(rate))
+	return l.next.Sub(now)
+}
+
+// A download whose reads are limited by the rate limit of the agent.
+type Reader struct {
+	r  io.Reader
+	id uint64
+}
+
+// Start a limited download that reads from r. The size is 0 when it is not known. Done must be called when the
+// download ends.
+func NewReader(name string, size int64, r io.Reader) *Reader {
+	return &Reader{r: r, id: downloads.start(name, size, true)}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if chunk := downloads.chunk(); len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if wait := downloads.take(r.id, n); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	return n, err
+}
+
+// End the download, it is no longer listed in the status.
+func (r *Reader) Done() {
+	downloads.done(r.id)
+}
+
+// The progress of a download that the agent does not make itself, e.g. an image pull by the container runtime.
+type Progress struct {
+	id uint64
+}
+
+// Start observing a download, Done must be called when it ends.
+func NewProgress(name string) *Progress {
+	return &Progress{id: downloads.start(name, 0, false)}
+}
+
+// Record the bytes downloaded so far, and the size when it is known.
+func (p *Progress) Set(bytes int64, size int64) {
+	downloads.update(p.id, bytes, size)
+}
+
+func (p *Progress) Done() {
+	downloads.done(p.id)
+}
@@ -0,0 +1,69 @@
+// +build unit
+
+package download
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func Test_take(t *testing.T) {
+	l := &limiter{transfers: make(map[uint64]*Transfer), rateFunc: func() int64 { return 1000 }}
+	id := l.start("object", 2000, true)
+
+	// the waits of the downloads queue one after the other
+	if w := l.take(id, 500); w < 450*time.Millisecond || w > 500*time.Millisecond {
+		t.Errorf("expected to wait about 500ms, got %v", w)
+	} else if w := l.take(id, 500); w < 950*time.Millisecond || w > time.Second {
+		t.Errorf("expected to wait about 1s, got %v", w)
+	} else if b := l.transfers[id].Bytes; b != 1000 {
+		t.Errorf("expected 1000 bytes, got %v", b)
+	}
+
+	l.done(id)
+	if len(l.transfers) != 0 {
+		t.Errorf("the transfer should be done, got %v", l.transfers)
+	}
+
+	unlimited := &limiter{transfers: make(map[uint64]*Transfer), rateFunc: func() int64 { return 0 }}
+	if w := unlimited.take(unlimited.start("object", 0, true), 1000000); w != 0 {
+		t.Errorf("expected no wait without a limit, got %v", w)
+	}
+}
+
+func Test_chunk(t *testing.T) {
+	for rate, expected := range map[int64]int{0: MAX_CHUNK, 1000: 512, 40000: 10000, 1000000: MAX_CHUNK} {
+		l := &limiter{transfers: make(map[uint64]*Transfer), rateFunc: func() int64 { return rate }}
+		if c := l.chunk(); c != expected {
+			t.Errorf("rate %v: expected chunks of %v bytes, got %v", rate, expected, c)
+		}
+	}
+}
+
+func Test_Reader(t *testing.T) {
+	SetRateFunc(func() int64 { return 64 * 1024 })
+	defer SetRateFunc(nil)
+
+	data := make([]byte, 48*1024)
+	r := NewReader("object", int64(len(data)), bytes.NewReader(data))
+
+	if s := GetStatus(); len(s.Transfers) != 1 || s.RateLimit != 64*1024 || !s.Transfers[0].Limited {
+		t.Errorf("expected a limited transfer, got %v", s)
+	}
+
+	start := time.Now()
+	if read, err := ioutil.ReadAll(r); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if len(read) != len(data) {
+		t.Errorf("expected %v bytes, got %v", len(data), len(read))
+	} else if d := time.Since(start); d < 600*time.Millisecond {
+		t.Errorf("reading 48KB at 64KB/s should take about 750ms, it took %v", d)
+	}
+
+	r.Done()
+	if s := GetStatus(); len(s.Transfers) != 0 {
+		t.Errorf("expected no transfers, got %v", s.Transfers)
+	}
+}
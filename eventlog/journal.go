@@ -0,0 +1,239 @@
+package eventlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/i18n"
+	"github.com/open-horizon/anax/persistence"
+	"log/syslog"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// The number of events that can wait to be written to the journal. When the queue is full the events are dropped, the
+// workers that log events never wait for the journal.
+const JOURNAL_QUEUE_SIZE = 256
+
+// A field of a journal entry.
+type journalField struct {
+	name  string
+	value string
+}
+
+// Writes the forwarded events to journald, or to syslog when journald is not running.
+type journalSink struct {
+	cfg     *config.HorizonConfig
+	entries chan *persistence.EventLog
+	dropped uint64 // the number of events dropped since the last one written, updated atomically
+	conn    net.Conn
+	syslog  *syslog.Writer
+	failing bool
+}
+
+var journal *journalSink
+
+// Start forwarding events to the local journal. It is called once when anax starts. The config is read for each event,
+// so the events that are forwarded can be changed when the config is reloaded.
+func StartJournal(cfg *config.HorizonConfig) {
+	journal = &journalSink{cfg: cfg, entries: make(chan *persistence.EventLog, JOURNAL_QUEUE_SIZE)}
+	go journal.run()
+}
+
+// Queue the event for the journal if it is forwarded.
+func forward(el *persistence.EventLog) {
+	if journal == nil {
+		return
+	} else if jc := journal.config(); !jc.Forwards(el.EventCode, el.Severity) {
+		return
+	}
+	select {
+	case journal.entries <- el:
+	default:
+		if atomic.AddUint64(&journal.dropped, 1) == 1 {
+			glog.Warningf("The journal cannot keep up with the events, they are dropped until it does")
+		}
+	}
+}
+
+// Returns the journal settings now in effect.
+func (j *journalSink) config() config.JournalConfig {
+	return j.cfg.LiveEdge().Journal
+}
+
+func (j *journalSink) run() {
+	for el := range j.entries {
+		jc := j.config()
+		fields := journalFields(&jc, el)
+		if dropped := atomic.SwapUint64(&j.dropped, 0); dropped != 0 {
+			fields = append(fields, journalField{"HZN_EVENTS_DROPPED", strconv.FormatUint(dropped, 10)})
+		}
+
+		if err := j.write(&jc, jc.Priority(el.Severity), fields); err != nil && !j.failing {
+			glog.Errorf("Unable to write event %v to the journal or syslog, error %v", el.EventCode, err)
+			j.failing = true
+		} else if err == nil {
+			j.failing = false
+		}
+	}
+}
+
+// Write the entry to journald, and to syslog when journald cannot be reached.
+func (j *journalSink) write(jc *config.JournalConfig, priority int, fields []journalField) error {
+	if j.conn == nil {
+		if conn, err := net.Dial("unixgram", jc.GetSocket()); err == nil {
+			j.conn = conn
+		}
+	}
+	if j.conn != nil {
+		if _, err := j.conn.Write(encodeJournalFields(fields)); err == nil {
+			return nil
+		}
+		// journald may have been restarted, the socket is dialed again for the next entry
+		j.conn.Close()
+		j.conn = nil
+	}
+
+	if j.syslog == nil {
+		w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, jc.GetIdentifier())
+		if err != nil {
+			return err
+		}
+		j.syslog = w
+	}
+	return writeSyslog(j.syslog, priority, syslogMessage(fields))
+}
+
+// The fields of the journal entry of an event. journalctl can filter on all of them, e.g. with HZN_AGREEMENT_ID=<id>.
+func journalFields(cfg *config.JournalConfig, el *persistence.EventLog) []journalField {
+	message := el.Message
+	if el.MessageMeta != nil && el.MessageMeta.MessageKey != "" {
+		message = i18n.GetMessagePrinter().Sprintf(el.MessageMeta.MessageKey, el.MessageMeta.MessageArgs...)
+	}
+
+	fields := []journalField{
+		{"MESSAGE", message},
+		{"PRIORITY", strconv.Itoa(cfg.Priority(el.Severity))},
+		{"SYSLOG_IDENTIFIER", cfg.GetIdentifier()},
+		{"HZN_EVENT_CODE", el.EventCode},
+		{"HZN_SEVERITY", el.Severity},
+		{"HZN_SOURCE_TYPE", el.SourceType},
+	}
+
+	switch src := el.Source.(type) {
+	case *persistence.AgreementEventSource:
+		fields = append(fields, agreementFields(src)...)
+	case persistence.AgreementEventSource:
+		fields = append(fields, agreementFields(&src)...)
+	case *persistence.ServiceEventSource:
+		fields = append(fields, serviceFields(src)...)
+	case persistence.ServiceEventSource:
+		fields = append(fields, serviceFields(&src)...)
+	case *persistence.NodeEventSource:
+		fields = append(fields, nodeFields(src)...)
+	case persistence.NodeEventSource:
+		fields = append(fields, nodeFields(&src)...)
+	}
+	return nonEmpty(fields)
+}
+
+func agreementFields(src *persistence.AgreementEventSource) []journalField {
+	return []journalField{
+		{"HZN_AGREEMENT_ID", src.AgreementId},
+		{"HZN_WORKLOAD_URL", src.RunningWorkload.URL},
+		{"HZN_WORKLOAD_ORG", src.RunningWorkload.Org},
+		{"HZN_WORKLOAD_VERSION", src.RunningWorkload.Version},
+		{"HZN_AGREEMENT_PROTOCOL", src.AgreementProtocol},
+	}
+}
+
+func serviceFields(src *persistence.ServiceEventSource) []journalField {
+	fields := []journalField{
+		{"HZN_SERVICE_INSTANCE_ID", src.InstanceId},
+		{"HZN_SERVICE_URL", src.ServiceUrl},
+		{"HZN_SERVICE_ORG", src.Org},
+		{"HZN_SERVICE_VERSION", src.Version},
+	}
+	// a journal field can have several values, journalctl matches any of them
+	for _, id := range src.AssociatedAgreements {
+		fields = append(fields, journalField{"HZN_AGREEMENT_ID", id})
+	}
+	return fields
+}
+
+func nodeFields(src *persistence.NodeEventSource) []journalField {
+	return []journalField{
+		{"HZN_NODE_ID", src.Id},
+		{"HZN_NODE_ORG", src.Org},
+		{"HZN_PATTERN", src.Pattern},
+		{"HZN_CONFIG_STATE", src.ConfigState},
+	}
+}
+
+func nonEmpty(fields []journalField) []journalField {
+	ret := make([]journalField, 0, len(fields))
+	for _, f := range fields {
+		if f.value != "" {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+// Encode the fields in the journald native protocol. A value with a new line is written with its length instead.
+func encodeJournalFields(fields []journalField) []byte {
+	var buf bytes.Buffer
+	for _, f := range fields {
+		if !strings.Contains(f.value, "\n") {
+			fmt.Fprintf(&buf, "%v=%v\n", f.name, f.value)
+			continue
+		}
+		buf.WriteString(f.name)
+		buf.WriteByte('\n')
+		binary.Write(&buf, binary.LittleEndian, uint64(len(f.value)))
+		buf.WriteString(f.value)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// Syslog has no fields, they are appended to the message as key=value.
+func syslogMessage(fields []journalField) string {
+	message := ""
+	extra := make([]string, 0, len(fields))
+	for _, f := range fields {
+		switch f.name {
+		case "MESSAGE":
+			message = f.value
+		case "PRIORITY", "SYSLOG_IDENTIFIER":
+		default:
+			extra = append(extra, fmt.Sprintf("%v=%q", f.name, f.value))
+		}
+	}
+	return fmt.Sprintf("%v %v", message, strings.Join(extra, " "))
+}
+
+func writeSyslog(w *syslog.Writer, priority int, message string) error {
+	switch syslog.Priority(priority) {
+	case syslog.LOG_EMERG:
+		return w.Emerg(message)
+	case syslog.LOG_ALERT:
+		return w.Alert(message)
+	case syslog.LOG_CRIT:
+		return w.Crit(message)
+	case syslog.LOG_ERR:
+		return w.Err(message)
+	case syslog.LOG_WARNING:
+		return w.Warning(message)
+	case syslog.LOG_NOTICE:
+		return w.Notice(message)
+	case syslog.LOG_DEBUG:
+		return w.Debug(message)
+	default:
+		return w.Info(message)
+	}
+}
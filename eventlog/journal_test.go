@@ -0,0 +1,49 @@
+// +build unit
+
+package eventlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/persistence"
+	"testing"
+)
+
+func Test_encodeJournalFields(t *testing.T) {
+
+	encoded := encodeJournalFields([]journalField{{"MESSAGE", "agreement reached"}, {"HZN_NOTE", "a\nb"}})
+
+	var expected bytes.Buffer
+	expected.WriteString("MESSAGE=agreement reached\nHZN_NOTE\n")
+	binary.Write(&expected, binary.LittleEndian, uint64(3))
+	expected.WriteString("a\nb\n")
+
+	if !bytes.Equal(encoded, expected.Bytes()) {
+		t.Errorf("expected %q, got %q", expected.Bytes(), encoded)
+	}
+}
+
+func Test_journalFields(t *testing.T) {
+
+	cfg := &config.JournalConfig{EventCodes: []string{"*"}}
+	source := persistence.NewServiceEventSource("inst1", "http://sensor.org", "myorg", "1.0.0", "amd64", []string{"ag1", "ag2"})
+	el := persistence.NewEventLog(persistence.SEVERITY_ERROR, persistence.NewMessageMeta("service %v failed", "sensor"), persistence.EC_ERROR_START_SERVICE, persistence.SRC_TYPE_SVC, source)
+
+	values := map[string][]string{}
+	for _, f := range journalFields(cfg, el) {
+		values[f.name] = append(values[f.name], f.value)
+	}
+
+	if m := values["MESSAGE"]; len(m) != 1 || m[0] != "service sensor failed" {
+		t.Errorf("unexpected MESSAGE %v", m)
+	} else if p := values["PRIORITY"]; len(p) != 1 || p[0] != "3" {
+		t.Errorf("expected priority 3 for an error, got %v", p)
+	} else if id := values["SYSLOG_IDENTIFIER"]; len(id) != 1 || id[0] != "anax" {
+		t.Errorf("unexpected SYSLOG_IDENTIFIER %v", id)
+	} else if ags := values["HZN_AGREEMENT_ID"]; len(ags) != 2 || ags[0] != "ag1" || ags[1] != "ag2" {
+		t.Errorf("expected both agreement ids, got %v", ags)
+	} else if _, ok := values["HZN_NODE_ID"]; ok {
+		t.Errorf("a service event should have no node fields")
+	}
+}
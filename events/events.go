@@ -52,12 +52,15 @@ const (
 	CONTAINER_MAINTAIN          EventId = "CONTAINER_MAINTAIN"
 	LOAD_CONTAINER              EventId = "LOAD_CONTAINER"
 	CANCEL_MICROSERVICE         EventId = "CANCEL_MICROSERVICE"
+	SERVICE_REPLACED            EventId = "SERVICE_REPLACED"
 	CANCEL_MICROSERVICE_NETWORK EventId = "CANCEL_MICROSERVICE_NETWORK"
 	NEW_BC_CLIENT               EventId = "NEW_BC_CONTAINER"
 	IMAGE_LOAD_FAILED           EventId = "IMAGE_LOAD_FAILED"
+	HEALTH_CHECK_FAILED         EventId = "HEALTH_CHECK_FAILED"
 
 	// policy-related
 	NEW_POLICY             EventId = "NEW_POLICY"
+	NEW_POLICIES           EventId = "NEW_POLICIES"
 	UPDATE_POLICY          EventId = "UPDATE_POLICY"
 	CHANGED_POLICY         EventId = "CHANGED_POLICY"
 	DELETED_POLICY         EventId = "DELETED_POLICY"
@@ -88,6 +91,8 @@ const (
 	UPDATE_NODE_USERINPUT        EventId = "UPDATE_USER_INPUT"
 	NODE_PATTERN_CHANGE_SHUTDOWN EventId = "NODE_PATTERN_CHANGE_SHUTDOWN"
 	NODE_PATTERN_CHANGE_REREG    EventId = "NODE_PATTERN_CHANGE_REREG"
+	NODE_PATTERN_UPDATED         EventId = "NODE_PATTERN_UPDATED" // the pattern was changed by PATCH /node, there is nothing to re-register
+	NODE_TOKEN_ROTATED           EventId = "NODE_TOKEN_ROTATED"   // the token of the node was changed by PATCH /node, the node stays registered
 	MESSAGE_STOP                 EventId = "MESSAGE_STOP"
 
 	// Service related
@@ -99,6 +104,19 @@ const (
 	OBJECT_POLICY_DELETED   EventId = "OBJECT_POLICY_DELETED"
 	OBJECT_POLICIES_CHANGED EventId = "OBJECT_POLICIES_CHANGED"
 
+	// Object sync related
+	OBJECT_UPDATED EventId = "OBJECT_UPDATED"
+
+	// Agent update related
+	AGENT_UPDATE_AVAILABLE EventId = "AGENT_UPDATE_AVAILABLE"
+
+	// Exchange sync related
+	NODE_SYNC EventId = "NODE_SYNC"
+
+	// Configstate autoconfig related
+	CONFIGSTATE_PROGRESS EventId = "CONFIGSTATE_PROGRESS"
+	CONFIGSTATE_CHANGED  EventId = "CONFIGSTATE_CHANGED"
+
 	// Exchange change related
 	CHANGE_MESSAGE_TYPE           EventId = "EXCHANGE_CHANGE_MESSAGE"
 	CHANGE_AGBOT_MESSAGE_TYPE     EventId = "EXCHANGE_CHANGE_AGBOT_MESSAGE"
@@ -410,14 +428,69 @@ func NewNodePatternMessage(id EventId, pattern string) *NodePatternMessage {
 	}
 }
 
+// This event indicates that the exchange token of the node changed, the workers that keep the credentials of the node
+// use the new one from then on.
+type NodeTokenMessage struct {
+	event    Event
+	deviceId string
+	org      string
+	token    string
+}
+
+func (e NodeTokenMessage) String() string {
+	return fmt.Sprintf("event: %v, device_id: %v, org: %v, token: %v", e.event, e.deviceId, e.org, "********")
+}
+
+func (e NodeTokenMessage) ShortString() string {
+	return e.String()
+}
+
+func (e NodeTokenMessage) Event() Event {
+	return e.event
+}
+
+func (e *NodeTokenMessage) DeviceId() string {
+	return e.deviceId
+}
+
+func (e *NodeTokenMessage) Org() string {
+	return e.org
+}
+
+func (e *NodeTokenMessage) Token() string {
+	return e.token
+}
+
+func NewNodeTokenMessage(id EventId, deviceId string, org string, token string) *NodeTokenMessage {
+
+	return &NodeTokenMessage{
+		event: Event{
+			Id: id,
+		},
+		deviceId: deviceId,
+		org:      org,
+		token:    token,
+	}
+}
+
+// Where the services whose policies are created come from.
+const (
+	POLICY_ORIGIN_AUTOCONFIG = "autoconfig" // the autoconfig of the node's pattern, or of the autoconfig manifest
+	POLICY_ORIGIN_USER       = "user"       // a service configured by the user, e.g. with POST /service/config
+	POLICY_ORIGIN_IMPORT     = "import"     // a service of an imported node configuration
+)
+
 // This event indicates that a new microservice has been created in the form of a policy file
 type PolicyCreatedMessage struct {
 	event    Event
 	fileName string
+	origin   string // one of the POLICY_ORIGIN_ values, empty when it is not known
+	pattern  string // the pattern of the node, for the autoconfig
+	created  uint64 // when the policy was created, in seconds since the epoch
 }
 
 func (e PolicyCreatedMessage) String() string {
-	return fmt.Sprintf("event: %v, file: %v", e.event, e.fileName)
+	return fmt.Sprintf("event: %v, file: %v, origin: %v, pattern: %v, created: %v", e.event, e.fileName, e.origin, e.pattern, e.created)
 }
 
 func (e PolicyCreatedMessage) ShortString() string {
@@ -432,6 +505,25 @@ func (e *PolicyCreatedMessage) PolicyFile() string {
 	return e.fileName
 }
 
+func (e *PolicyCreatedMessage) Origin() string {
+	return e.origin
+}
+
+func (e *PolicyCreatedMessage) Pattern() string {
+	return e.pattern
+}
+
+func (e *PolicyCreatedMessage) Created() uint64 {
+	return e.created
+}
+
+// Set how the service of the policy came to exist, and the pattern it was configured from when there is one.
+func (e *PolicyCreatedMessage) WithOrigin(origin string, pattern string) *PolicyCreatedMessage {
+	e.origin = origin
+	e.pattern = pattern
+	return e
+}
+
 func NewPolicyCreatedMessage(id EventId, policyFileName string) *PolicyCreatedMessage {
 
 	return &PolicyCreatedMessage{
@@ -439,6 +531,63 @@ func NewPolicyCreatedMessage(id EventId, policyFileName string) *PolicyCreatedMe
 			Id: id,
 		},
 		fileName: policyFileName,
+		created:  uint64(time.Now().Unix()),
+	}
+}
+
+// This event indicates that several microservices have been created together in the form of policy files, e.g. by the
+// autoconfig of the node's pattern, so that they can be handled in one pass.
+type PoliciesCreatedMessage struct {
+	event     Event
+	fileNames []string
+	origin    string // one of the POLICY_ORIGIN_ values, empty when it is not known
+	pattern   string // the pattern of the node, for the autoconfig
+	created   uint64 // when the policies were created, in seconds since the epoch
+}
+
+func (e PoliciesCreatedMessage) String() string {
+	return fmt.Sprintf("event: %v, files: %v, origin: %v, pattern: %v, created: %v", e.event, e.fileNames, e.origin, e.pattern, e.created)
+}
+
+func (e PoliciesCreatedMessage) ShortString() string {
+	return e.String()
+}
+
+func (e PoliciesCreatedMessage) Event() Event {
+	return e.event
+}
+
+func (e *PoliciesCreatedMessage) PolicyFiles() []string {
+	return e.fileNames
+}
+
+func (e *PoliciesCreatedMessage) Origin() string {
+	return e.origin
+}
+
+func (e *PoliciesCreatedMessage) Pattern() string {
+	return e.pattern
+}
+
+func (e *PoliciesCreatedMessage) Created() uint64 {
+	return e.created
+}
+
+// Set how the services of the policies came to exist, and the pattern they were configured from when there is one.
+func (e *PoliciesCreatedMessage) WithOrigin(origin string, pattern string) *PoliciesCreatedMessage {
+	e.origin = origin
+	e.pattern = pattern
+	return e
+}
+
+func NewPoliciesCreatedMessage(id EventId, policyFileNames []string) *PoliciesCreatedMessage {
+
+	return &PoliciesCreatedMessage{
+		event: Event{
+			Id: id,
+		},
+		fileNames: policyFileNames,
+		created:   uint64(time.Now().Unix()),
 	}
 }
 
@@ -790,6 +939,8 @@ type ImageFetchMessage struct {
 	DeploymentDescription *containermessage.DeploymentDescription
 	LaunchContext         interface{}
 	Error                 error
+	ImageDigests          map[string]string // The digest each container was pinned to, keyed by container name.
+	DigestMismatches      []string          // Containers whose recorded digest differs from the digest the registry now serves.
 }
 
 // fulfill interface of events.Message
@@ -798,7 +949,7 @@ func (b *ImageFetchMessage) Event() Event {
 }
 
 func (b *ImageFetchMessage) String() string {
-	return fmt.Sprintf("event: %v, deploymentDescription: %v, launchContext: %v", b.event, b.DeploymentDescription, b.LaunchContext)
+	return fmt.Sprintf("event: %v, deploymentDescription: %v, launchContext: %v, imageDigests: %v, digestMismatches: %v", b.event, b.DeploymentDescription, b.LaunchContext, b.ImageDigests, b.DigestMismatches)
 }
 
 func (b *ImageFetchMessage) ShortString() string {
@@ -1649,6 +1800,36 @@ func NewMicroserviceCancellationMessage(id EventId, key string) *MicroserviceCan
 	}
 }
 
+// A service definition was replaced by the one of another version of the service, outside of the service upgrade of
+// the governance worker, e.g. by the autoconfig of the node's pattern. The instances of the old version are cleaned up.
+type MicroserviceReplacedMessage struct {
+	event      Event
+	OldMsDefId string // the key of the archived service definition
+	NewMsDefId string // the key of the service definition that replaces it
+}
+
+func (m *MicroserviceReplacedMessage) Event() Event {
+	return m.event
+}
+
+func (m MicroserviceReplacedMessage) String() string {
+	return m.ShortString()
+}
+
+func (m MicroserviceReplacedMessage) ShortString() string {
+	return fmt.Sprintf("Event: %v, OldMsDefId: %v, NewMsDefId: %v", m.event, m.OldMsDefId, m.NewMsDefId)
+}
+
+func NewMicroserviceReplacedMessage(id EventId, oldKey string, newKey string) *MicroserviceReplacedMessage {
+	return &MicroserviceReplacedMessage{
+		event: Event{
+			Id: id,
+		},
+		OldMsDefId: oldKey,
+		NewMsDefId: newKey,
+	}
+}
+
 type MicroserviceContainersDestroyedMessage struct {
 	event     Event
 	MsInstKey string // the key to the microservice instance
@@ -1849,6 +2030,126 @@ func NewNodeHeartbeatStateChangeMessage(id EventId, node_org string, node_id str
 	}
 }
 
+// A newer version of the agent than the running one is available.
+type AgentUpdateMessage struct {
+	event          Event
+	CurrentVersion string
+	LatestVersion  string
+	URL            string
+	NodeId         string
+	NodeOrg        string
+}
+
+func (w *AgentUpdateMessage) Event() Event {
+	return w.event
+}
+
+func (w *AgentUpdateMessage) String() string {
+	return w.ShortString()
+}
+
+func (w *AgentUpdateMessage) ShortString() string {
+	return fmt.Sprintf("Event: %v, CurrentVersion: %v, LatestVersion: %v, URL: %v, NodeId: %v, NodeOrg: %v", w.event, w.CurrentVersion, w.LatestVersion, w.URL, w.NodeId, w.NodeOrg)
+}
+
+func NewAgentUpdateMessage(id EventId, currentVersion string, latestVersion string, url string, nodeId string, nodeOrg string) *AgentUpdateMessage {
+	return &AgentUpdateMessage{
+		event: Event{
+			Id: id,
+		},
+		CurrentVersion: currentVersion,
+		LatestVersion:  latestVersion,
+		URL:            url,
+		NodeId:         nodeId,
+		NodeOrg:        nodeOrg,
+	}
+}
+
+// The synchronizations with the exchange that the workers run for a node sync.
+const (
+	SYNC_HEARTBEAT   = "heartbeat"
+	SYNC_NODE        = "node"
+	SYNC_NODE_POLICY = "node_policy"
+
+	// checked by the API itself
+	SYNC_EXCHANGE_VERSION = "exchange_version"
+)
+
+// The outcome of a synchronization with the exchange.
+type SyncResult struct {
+	Name    string `json:"name"`
+	Changed bool   `json:"changed"`
+	Summary string `json:"summary,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (s SyncResult) String() string {
+	return fmt.Sprintf("Name: %v, Changed: %v, Summary: %v, Error: %v", s.Name, s.Changed, s.Summary, s.Error)
+}
+
+// Asks the workers to synchronize with the exchange now, rather than at their next poll. Each worker sends the outcome
+// of its synchronizations to Results, which has room for all of them so that a worker never waits on it.
+type NodeSyncMessage struct {
+	event   Event
+	Results chan SyncResult
+}
+
+func (w *NodeSyncMessage) Event() Event {
+	return w.event
+}
+
+func (w *NodeSyncMessage) String() string {
+	return w.ShortString()
+}
+
+func (w *NodeSyncMessage) ShortString() string {
+	return fmt.Sprintf("Event: %v", w.event)
+}
+
+func NewNodeSyncMessage(id EventId, syncs int) *NodeSyncMessage {
+	return &NodeSyncMessage{
+		event: Event{
+			Id: id,
+		},
+		Results: make(chan SyncResult, syncs),
+	}
+}
+
+// A new version of an object is in the object directory of an agreement or service instance.
+type ObjectSyncMessage struct {
+	event   Event
+	Owner   string
+	Name    string
+	Object  string
+	Version string
+	Digest  string
+}
+
+func (w *ObjectSyncMessage) Event() Event {
+	return w.event
+}
+
+func (w *ObjectSyncMessage) String() string {
+	return w.ShortString()
+}
+
+func (w *ObjectSyncMessage) ShortString() string {
+	return fmt.Sprintf("Event: %v, Owner: %v, Name: %v, Object: %v, Version: %v, Digest: %v", w.event, w.Owner, w.Name, w.Object, w.Version, w.Digest)
+}
+
+func NewObjectSyncMessage(id EventId, owner string, name string, object string, version string, digest string) *ObjectSyncMessage {
+	return &ObjectSyncMessage{
+		event: Event{
+			Id: id,
+		},
+		Owner:   owner,
+		Name:    name,
+		Object:  object,
+		Version: version,
+		Digest:  digest,
+	}
+}
+
 type ServiceConfigState struct {
 	Url         string `json:"url"`
 	Org         string `json:"org"`
@@ -2004,6 +2305,90 @@ func NewProposalAcceptedMessage(id EventId) *ProposalAcceptedMessage {
 	}
 }
 
+// The phases of the autoconfig of the services of the node's pattern, when the node is changed to configured.
+const (
+	CONFIGSTATE_PHASE_PATTERN_FETCHED   = "pattern_fetched"
+	CONFIGSTATE_PHASE_WORKLOAD_RESOLVED = "workload_resolved"
+	CONFIGSTATE_PHASE_SERVICE_CREATED   = "service_created"
+	CONFIGSTATE_PHASE_COMPLETE          = "complete"
+	CONFIGSTATE_PHASE_FAILED            = "failed"
+)
+
+// The progress of the autoconfig of the services of the node's pattern. The services are counted when they are done,
+// whether they succeeded or not.
+type ConfigstateProgressMessage struct {
+	event             Event
+	Phase             string
+	Pattern           string
+	ServicesResolved  int
+	ServicesToResolve int
+	ServicesCreated   int
+	ServicesToCreate  int
+	Error             string
+}
+
+func (w *ConfigstateProgressMessage) Event() Event {
+	return w.event
+}
+
+func (w *ConfigstateProgressMessage) String() string {
+	return fmt.Sprintf("Event: %v, Phase: %v, Pattern: %v, ServicesResolved: %v/%v, ServicesCreated: %v/%v, Error: %v", w.event, w.Phase, w.Pattern, w.ServicesResolved, w.ServicesToResolve, w.ServicesCreated, w.ServicesToCreate, w.Error)
+}
+
+func (w *ConfigstateProgressMessage) ShortString() string {
+	return w.String()
+}
+
+func NewConfigstateProgressMessage(id EventId, phase string, pattern string, resolved int, toResolve int, created int, toCreate int, errMsg string) *ConfigstateProgressMessage {
+	return &ConfigstateProgressMessage{
+		event: Event{
+			Id: id,
+		},
+		Phase:             phase,
+		Pattern:           pattern,
+		ServicesResolved:  resolved,
+		ServicesToResolve: toResolve,
+		ServicesCreated:   created,
+		ServicesToCreate:  toCreate,
+		Error:             errMsg,
+	}
+}
+
+// The config state of the node was changed and persisted, e.g. from configuring to configured.
+type ConfigstateChangedMessage struct {
+	event    Event
+	OldState string
+	NewState string
+	DeviceId string
+	Org      string
+	Pattern  string
+}
+
+func (w *ConfigstateChangedMessage) Event() Event {
+	return w.event
+}
+
+func (w *ConfigstateChangedMessage) String() string {
+	return fmt.Sprintf("Event: %v, OldState: %v, NewState: %v, DeviceId: %v, Org: %v, Pattern: %v", w.event, w.OldState, w.NewState, w.DeviceId, w.Org, w.Pattern)
+}
+
+func (w *ConfigstateChangedMessage) ShortString() string {
+	return w.String()
+}
+
+func NewConfigstateChangedMessage(id EventId, oldState string, newState string, deviceId string, org string, pattern string) *ConfigstateChangedMessage {
+	return &ConfigstateChangedMessage{
+		event: Event{
+			Id: id,
+		},
+		OldState: oldState,
+		NewState: newState,
+		DeviceId: deviceId,
+		Org:      org,
+		Pattern:  pattern,
+	}
+}
+
 func GetLaunchContext(launchContext interface{}) LaunchContext {
 	switch launchContext.(type) {
 	case *ContainerLaunchContext:
@@ -3,8 +3,8 @@ package exchange
 import (
 	"fmt"
 	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
 	"github.com/open-horizon/anax/cutil"
-	"time"
 )
 
 type Agbot struct {
@@ -135,25 +135,26 @@ func CreateAgbotPublicKeyPatch(keyPath string) *PatchAgbotPublicKey {
 	return pdr
 }
 
+// The agbot needs what it serves to make agreements, it retries the transport errors of these calls until they go away
+// whatever the retry count of the factory.
+func agbotRetryPolicy(httpClientFactory *config.HTTPClientFactory) cutil.RetryPolicy {
+	policy := exchangeRetryPolicy(httpClientFactory)
+	policy.Attempts = 0
+	return policy
+}
+
 func GetAgbotDeploymentPols(ec ExchangeContext) (map[string]ServedBusinessPolicy, error) {
 
 	var resp interface{}
 	resp = new(GetAgbotsBusinessPolsResponse)
 	targetURL := ec.GetExchangeURL() + "orgs/" + GetOrg(ec.GetExchangeId()) + "/agbots/" + GetId(ec.GetExchangeId()) + "/businesspols"
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
-			glog.Errorf(rpclogString(err.Error()))
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(tpErr.Error()))
-			time.Sleep(10 * time.Second)
-			continue
-		} else {
-			pols := resp.(*GetAgbotsBusinessPolsResponse).BusinessPols
-			glog.V(5).Infof(rpclogString(fmt.Sprintf("retrieved agbot serviced deployment policy names from exchange %v", pols)))
-			return pols, nil
-		}
+	if err := invokeExchangeWithRetries(ec.GetHTTPFactory(), agbotRetryPolicy(ec.GetHTTPFactory()), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
+		glog.Errorf(rpclogString(err.Error()))
+		return nil, err
 	}
+	pols := resp.(*GetAgbotsBusinessPolsResponse).BusinessPols
+	glog.V(5).Infof(rpclogString(fmt.Sprintf("retrieved agbot serviced deployment policy names from exchange %v", pols)))
+	return pols, nil
 }
 
 func GetAgbotPatterns(ec ExchangeContext) (map[string]ServedPattern, error) {
@@ -161,19 +162,12 @@ func GetAgbotPatterns(ec ExchangeContext) (map[string]ServedPattern, error) {
 	var resp interface{}
 	resp = new(GetAgbotsPatternsResponse)
 	targetURL := ec.GetExchangeURL() + "orgs/" + GetOrg(ec.GetExchangeId()) + "/agbots/" + GetId(ec.GetExchangeId()) + "/patterns"
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
-			glog.Errorf(rpclogString(err.Error()))
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(tpErr.Error()))
-			time.Sleep(10 * time.Second)
-			continue
-		} else {
-			pats := resp.(*GetAgbotsPatternsResponse).Patterns
-			glog.V(5).Infof(rpclogString(fmt.Sprintf("retrieved agbot served patterns from exchange %v", pats)))
-			return pats, nil
-		}
+	if err := invokeExchangeWithRetries(ec.GetHTTPFactory(), agbotRetryPolicy(ec.GetHTTPFactory()), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
+		glog.Errorf(rpclogString(err.Error()))
+		return nil, err
 	}
+	pats := resp.(*GetAgbotsPatternsResponse).Patterns
+	glog.V(5).Infof(rpclogString(fmt.Sprintf("retrieved agbot served patterns from exchange %v", pats)))
+	return pats, nil
 
 }
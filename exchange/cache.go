@@ -31,6 +31,8 @@ const NODE_DEF_TYPE_CACHE = "NODE_DEF_CACHE"
 const NODE_POL_TYPE_CACHE = "NODE_POLICY_CACHE"
 const EXCH_VERS_TYPE_CACHE = "EXCH_VERS_CACHE"
 const ORG_DEF_TYPE_CACHE = "ORG_DEF_CACHE"
+const PATTERN_DEF_TYPE_CACHE = "PATTERN_DEF_CACHE"
+const SVC_RESOLVED_TYPE_CACHE = "SVC_RESOLVED_CACHE"
 
 // This only applies to the exchange version.
 // All others are monitored for changes theough the changes api
@@ -57,6 +59,10 @@ func (c *CacheEntry) Copy() interface{} {
 	case ExchangePolicy:
 		exchPol := c.Resource.(ExchangePolicy)
 		resourceCopy = *(&exchPol).DeepCopy()
+	case map[string]Pattern:
+		resourceCopy = PatternMap(c.Resource.(map[string]Pattern)).Copy()
+	case ResolvedService:
+		resourceCopy = c.Resource.(ResolvedService).DeepCopy()
 	default:
 		resourceCopy = c.Resource
 	}
@@ -154,6 +160,93 @@ func (s ServiceKeys) DeepCopy() map[string]string {
 	return svcKeysCopy
 }
 
+type PatternMap map[string]Pattern
+
+// The patterns are only read by the callers, so the copy shares their services and user input.
+func (p PatternMap) Copy() map[string]Pattern {
+	patternsCopy := make(map[string]Pattern, len(p))
+	for key, val := range p {
+		patternsCopy[key] = val
+	}
+	return patternsCopy
+}
+
+// The service definitions that a top-level service resolves to, as returned by ServiceDefResolver.
+type ResolvedService struct {
+	Dependencies map[string]ServiceDefinition `json:"dependencies"`
+	Service      ServiceDefinition            `json:"service"`
+	Id           string                       `json:"id"`
+}
+
+func (r ResolvedService) DeepCopy() ResolvedService {
+	return ResolvedService{Dependencies: ServiceMap(r.Dependencies).DeepCopy(), Service: *r.Service.DeepCopy(), Id: r.Id}
+}
+
+// GetPatternsFromCache returns the patterns from the exchange cache if they are present and not older than expirationS, or nil if not
+func GetPatternsFromCache(org string, pattern string, expirationS uint64) map[string]Pattern {
+	patterns := GetResourceFromCache(PatternCacheMapKey(org, pattern), PATTERN_DEF_TYPE_CACHE, expirationS)
+
+	if typedPatterns, ok := patterns.(map[string]Pattern); ok {
+		return typedPatterns
+	}
+	return nil
+}
+
+// GetResolvedServiceFromCache returns the resolved service from the exchange cache if it is present and not older than expirationS, or nil if not
+func GetResolvedServiceFromCache(svcOrg string, svcUrl string, svcVersion string, svcArch string, expirationS uint64) *ResolvedService {
+	resolved := GetResourceFromCache(ServicePolicyCacheMapKey(svcOrg, svcUrl, svcArch, svcVersion), SVC_RESOLVED_TYPE_CACHE, expirationS)
+
+	if typedResolved, ok := resolved.(ResolvedService); ok {
+		return &typedResolved
+	}
+	return nil
+}
+
+// DeletePatternCache will delete the cached patterns and resolved services, e.g. when the pattern of the node changes
+func DeletePatternCache() {
+	DeleteCache(PATTERN_DEF_TYPE_CACHE)
+	DeleteCache(SVC_RESOLVED_TYPE_CACHE)
+}
+
+// A pattern handler that keeps the patterns it reads in the exchange cache, and uses them for ttlS seconds. The errors are
+// not kept. A ttlS of 0 turns the cache off.
+func GetCachedPatternHandler(getPatterns PatternHandler, ttlS uint64) PatternHandler {
+	if ttlS == 0 {
+		return getPatterns
+	}
+	return func(org string, pattern string) (map[string]Pattern, error) {
+		if patterns := GetPatternsFromCache(org, pattern, ttlS); patterns != nil {
+			glog.V(5).Infof(rpclogString(fmt.Sprintf("using the cached pattern definitions for %v/%v", org, pattern)))
+			return patterns, nil
+		}
+		patterns, err := getPatterns(org, pattern)
+		if err == nil && patterns != nil {
+			UpdateCache(PatternCacheMapKey(org, pattern), PATTERN_DEF_TYPE_CACHE, PatternMap(patterns).Copy())
+		}
+		return patterns, err
+	}
+}
+
+// A service resolver that keeps the services it resolves in the exchange cache, and uses them for ttlS seconds. The errors
+// are not kept. A ttlS of 0 turns the cache off.
+func GetCachedServiceDefResolverHandler(resolveService ServiceDefResolverHandler, ttlS uint64) ServiceDefResolverHandler {
+	if ttlS == 0 {
+		return resolveService
+	}
+	return func(wUrl string, wOrg string, wVersion string, wArch string) (map[string]ServiceDefinition, *ServiceDefinition, string, error) {
+		if resolved := GetResolvedServiceFromCache(wOrg, wUrl, wVersion, wArch, ttlS); resolved != nil {
+			glog.V(5).Infof(rpclogString(fmt.Sprintf("using the cached resolved service definition for %v %v %v %v", wUrl, wOrg, wVersion, wArch)))
+			return resolved.Dependencies, &resolved.Service, resolved.Id, nil
+		}
+		deps, sdef, sId, err := resolveService(wUrl, wOrg, wVersion, wArch)
+		if err == nil && sdef != nil {
+			resolved := ResolvedService{Dependencies: deps, Service: *sdef, Id: sId}
+			UpdateCache(ServicePolicyCacheMapKey(wOrg, wUrl, wArch, wVersion), SVC_RESOLVED_TYPE_CACHE, resolved.DeepCopy())
+		}
+		return deps, sdef, sId, err
+	}
+}
+
 // GetExchangeVersionFromCache returns the version of the exchange from the exchange cache if it is present or an emty string otherwise
 func GetExchangeVersionFromCache(exchangeURL string) string {
 	exchVers := GetResourceFromCache(exchangeURL, EXCH_VERS_TYPE_CACHE, CACHE_TIMEOUT_S)
@@ -418,6 +511,11 @@ func ServicePolicyCacheMapKey(svcOrg string, svcId string, svcArch string, svcVe
 	return fmt.Sprintf("%s/%s/%s/%s", svcOrg, svcId, svcArch, svcVersion)
 }
 
+// PatternCacheMapKey returns a string to use for the cache map key for the patterns with the given org and name
+func PatternCacheMapKey(org string, pattern string) string {
+	return fmt.Sprintf("%s/%s", org, pattern)
+}
+
 // NodeCacheMapKey returns a string to use for the cache map key for a node with the given org and id
 func NodeCacheMapKey(nodeOrg string, nodeId string) string {
 	return fmt.Sprintf("%s/%s", nodeOrg, nodeId)
@@ -1,6 +1,7 @@
 package exchange
 
 import (
+	"errors"
 	"github.com/open-horizon/anax/externalpolicy"
 	"reflect"
 	"testing"
@@ -191,3 +192,87 @@ func TestCopy(t *testing.T) {
 		t.Errorf("Image Docker Auth copy failed to accurately copy something \n%v\n%v", imgAuthSlice, imgAuthCopy)
 	}
 }
+
+func TestGetCachedPatternHandler(t *testing.T) {
+	DeletePatternCache()
+	defer DeletePatternCache()
+
+	calls := 0
+	fail := false
+	getPatterns := func(org string, pattern string) (map[string]Pattern, error) {
+		calls++
+		if fail {
+			return nil, errors.New("exchange not reachable")
+		}
+		return map[string]Pattern{org + "/" + pattern: Pattern{Label: "label"}}, nil
+	}
+
+	// an error is not cached
+	fail = true
+	cached := GetCachedPatternHandler(getPatterns, 60)
+	if _, err := cached("myorg", "mypattern"); err == nil {
+		t.Errorf("Error: the error should be returned.")
+	}
+	fail = false
+
+	for i := 0; i < 3; i++ {
+		if patterns, err := cached("myorg", "mypattern"); err != nil {
+			t.Errorf("Error: unexpected error %v", err)
+		} else if _, ok := patterns["myorg/mypattern"]; !ok {
+			t.Errorf("Error: wrong patterns returned %v", patterns)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Error: the pattern should be read from the exchange once after the error, it was read %v times", calls-1)
+	}
+
+	// a different pattern is not in the cache
+	if _, err := cached("myorg", "otherpattern"); err != nil || calls != 3 {
+		t.Errorf("Error: the other pattern should be read from the exchange, error %v", err)
+	}
+
+	// the cache is dropped when the pattern changes
+	DeletePatternCache()
+	if _, err := cached("myorg", "mypattern"); err != nil || calls != 4 {
+		t.Errorf("Error: the pattern should be read from the exchange again, error %v", err)
+	}
+
+	// no cache
+	uncached := GetCachedPatternHandler(getPatterns, 0)
+	uncached("myorg", "mypattern")
+	if calls != 5 {
+		t.Errorf("Error: the pattern should always be read from the exchange without a cache")
+	}
+}
+
+func TestGetCachedServiceDefResolverHandler(t *testing.T) {
+	DeletePatternCache()
+	defer DeletePatternCache()
+
+	calls := 0
+	resolveService := func(wUrl string, wOrg string, wVersion string, wArch string) (map[string]ServiceDefinition, *ServiceDefinition, string, error) {
+		calls++
+		deps := map[string]ServiceDefinition{"myorg/dep_1.0.0_amd64": ServiceDefinition{URL: "dep", Version: "1.0.0", Arch: wArch}}
+		return deps, &ServiceDefinition{URL: wUrl, Version: "1.0.0", Arch: wArch}, "myorg/svc_1.0.0_" + wArch, nil
+	}
+
+	cached := GetCachedServiceDefResolverHandler(resolveService, 60)
+	for i := 0; i < 2; i++ {
+		if deps, sdef, sId, err := cached("svc", "myorg", "1.0.0", "amd64"); err != nil {
+			t.Errorf("Error: unexpected error %v", err)
+		} else if len(deps) != 1 || sdef.URL != "svc" || sId != "myorg/svc_1.0.0_amd64" {
+			t.Errorf("Error: wrong resolved service %v %v %v", deps, sdef, sId)
+		} else {
+			// the caller changing what it got does not change the cache
+			sdef.URL = "changed"
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Error: the service should be resolved once, it was resolved %v times", calls)
+	}
+
+	// the arch is part of the key
+	if _, sdef, _, err := cached("svc", "myorg", "1.0.0", "arm64"); err != nil || sdef.Arch != "arm64" || calls != 2 {
+		t.Errorf("Error: the arm64 service should be resolved, error %v, service %v", err, sdef)
+	}
+}
@@ -3,7 +3,6 @@ package exchange
 import (
 	"fmt"
 	"github.com/golang/glog"
-	"time"
 )
 
 // The LastUpdated field is explicitly omitted due to a pending change to the datatype of the field.
@@ -208,31 +207,14 @@ func GetExchangeChangeID(ec ExchangeContext) (*ExchangeChangeIDResponse, error)
 	// Get resource changes in the exchange
 	targetURL := fmt.Sprintf("%vchanges/maxchangeid", ec.GetExchangeURL())
 
-	retryCount := ec.GetHTTPFactory().RetryCount
-	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if ec.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			changeResp := resp.(*ExchangeChangeIDResponse)
-
-			glog.V(3).Infof(rpclogString(fmt.Sprintf("found max changes ID %v", changeResp)))
-			return changeResp, nil
-		}
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return nil, err
 	}
+	changeResp := resp.(*ExchangeChangeIDResponse)
+
+	glog.V(3).Infof(rpclogString(fmt.Sprintf("found max changes ID %v", changeResp)))
+	return changeResp, nil
 }
 
 // Retrieve the latest changes from the exchange.
@@ -259,34 +241,17 @@ func GetExchangeChanges(ec ExchangeContext, changeId uint64, maxRecords int, org
 	// Get resource changes in the exchange
 	targetURL := fmt.Sprintf("%vorgs/%v/changes", ec.GetExchangeURL(), GetOrg(ec.GetExchangeId()))
 
-	retryCount := ec.GetHTTPFactory().RetryCount
-	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "POST", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), &req, &resp); err != nil {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if ec.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			changes := resp.(*ExchangeChanges)
-
-			if number_orgs > 10 {
-				glog.V(3).Infof(rpclogString(fmt.Sprintf("found %v changes since ID %v with latest change ID %v in %v orgs", len(changes.Changes), changeId, changes.MostRecentChangeID, number_orgs)))
-			} else {
-				glog.V(3).Infof(rpclogString(fmt.Sprintf("found %v changes since ID %v with latest change ID %v in orgs %v", len(changes.Changes), changeId, changes.MostRecentChangeID, orgList)))
-			}
-			glog.V(5).Infof(rpclogString(fmt.Sprintf("Raw changes response: %v", changes)))
-			return changes, nil
-		}
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "POST", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), &req, &resp); err != nil {
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return nil, err
+	}
+	changes := resp.(*ExchangeChanges)
+
+	if number_orgs > 10 {
+		glog.V(3).Infof(rpclogString(fmt.Sprintf("found %v changes since ID %v with latest change ID %v in %v orgs", len(changes.Changes), changeId, changes.MostRecentChangeID, number_orgs)))
+	} else {
+		glog.V(3).Infof(rpclogString(fmt.Sprintf("found %v changes since ID %v with latest change ID %v in orgs %v", len(changes.Changes), changeId, changes.MostRecentChangeID, orgList)))
 	}
+	glog.V(5).Infof(rpclogString(fmt.Sprintf("Raw changes response: %v", changes)))
+	return changes, nil
 }
@@ -0,0 +1,96 @@
+package exchange
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The weight of a new measurement in the estimate of the clock skew, so that a single slow response does not move it
+// much.
+const CLOCK_SKEW_WEIGHT = 0.2
+
+// The responses that took longer than this are not measured, the time that the exchange answered is too uncertain.
+const CLOCK_SKEW_MAX_ROUND_TRIP = 5 * time.Second
+
+// What to do when the clock of the node is off.
+const CLOCK_SKEW_HINT = "Set the clock of the node, e.g. turn on NTP with 'timedatectl set-ntp true'. A clock that is off after every boot usually means that the battery of the real time clock is dead."
+
+// The difference between the clock of the node and the clock of the exchange, estimated from the Date header of the
+// exchange responses.
+type ClockSkew struct {
+	SkewS        float64 `json:"skew_s"`        // positive when the clock of the node is ahead of the exchange
+	Samples      uint64  `json:"samples"`       // the number of responses measured
+	LastMeasured uint64  `json:"last_measured"` // the time of the last measurement, by the clock of the node
+	Warning      string  `json:"warning,omitempty"`
+}
+
+func (c ClockSkew) String() string {
+	return fmt.Sprintf("SkewS: %.1f, Samples: %v, LastMeasured: %v", c.SkewS, c.Samples, c.LastMeasured)
+}
+
+// Returns true when the skew is larger than the limit in seconds, either way. A limit of 0 is never exceeded.
+func (c *ClockSkew) Exceeds(limitS uint64) bool {
+	return limitS != 0 && math.Abs(c.SkewS) > float64(limitS)
+}
+
+type clockSkewTracker struct {
+	lock sync.Mutex
+	skew *ClockSkew
+}
+
+var clockSkew = &clockSkewTracker{}
+
+// Record the skew measured from an exchange response. The Date header is truncated to the second, the exchange made
+// the response half a second after it on average, at about the middle of the round trip by the clock of the node.
+func (t *clockSkewTracker) record(date string, sent time.Time, received time.Time) {
+	if date == "" || received.Sub(sent) > CLOCK_SKEW_MAX_ROUND_TRIP {
+		return
+	}
+	exchangeTime, err := http.ParseTime(date)
+	if err != nil {
+		return
+	}
+	nodeTime := sent.Add(received.Sub(sent) / 2)
+	skew := nodeTime.Sub(exchangeTime.Add(500 * time.Millisecond)).Seconds()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.skew == nil {
+		t.skew = &ClockSkew{SkewS: skew}
+	} else {
+		t.skew.SkewS += CLOCK_SKEW_WEIGHT * (skew - t.skew.SkewS)
+	}
+	t.skew.Samples++
+	t.skew.LastMeasured = uint64(received.Unix())
+}
+
+func (t *clockSkewTracker) get() *ClockSkew {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.skew == nil {
+		return nil
+	}
+	c := *t.skew
+	return &c
+}
+
+// Returns the estimate of the clock skew between the node and the exchange, nil until an exchange response was
+// measured.
+func GetClockSkew() *ClockSkew {
+	return clockSkew.get()
+}
+
+// A TLS certificate that is not valid yet or any more is often a sign that the clock of the node is off.
+func clockSkewHint(err error) string {
+	if err == nil || !strings.Contains(err.Error(), "certificate has expired or is not yet valid") {
+		return ""
+	}
+	if c := GetClockSkew(); c != nil {
+		return fmt.Sprintf(". The clock of the node was %.0f seconds off the exchange at the last measurement. %v", c.SkewS, CLOCK_SKEW_HINT)
+	}
+	return fmt.Sprintf(". The clock of the node may be off, it is %v. %v", time.Now().UTC().Format(time.RFC3339), CLOCK_SKEW_HINT)
+}
@@ -0,0 +1,50 @@
+// +build unit
+
+package exchange
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_clockSkew_record(t *testing.T) {
+	tracker := &clockSkewTracker{}
+	if tracker.get() != nil {
+		t.Errorf("no skew expected before a measurement")
+	}
+
+	// the exchange is 2 minutes behind the node
+	sent := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	received := sent.Add(time.Second)
+	tracker.record(sent.Add(-2*time.Minute).Format(http.TimeFormat), sent, received)
+	if s := tracker.get(); s == nil || s.SkewS != 120 || s.Samples != 1 || s.LastMeasured != uint64(received.Unix()) {
+		t.Errorf("expected a skew of 120 seconds, got %v", s)
+	} else if !s.Exceeds(60) || s.Exceeds(0) || s.Exceeds(300) {
+		t.Errorf("the skew of %v should only exceed 60 seconds", s)
+	}
+
+	// a later measurement only moves the estimate by its weight
+	tracker.record(sent.Format(http.TimeFormat), sent, received)
+	if s := tracker.get(); s.SkewS != 96 || s.Samples != 2 {
+		t.Errorf("expected a skew of 96 seconds, got %v", s)
+	}
+
+	// the responses without a date or with a slow round trip are not measured
+	tracker.record("", sent, received)
+	tracker.record("not a date", sent, received)
+	tracker.record(sent.Format(http.TimeFormat), sent, sent.Add(time.Minute))
+	if s := tracker.get(); s.Samples != 2 {
+		t.Errorf("expected 2 samples, got %v", s)
+	}
+}
+
+func Test_clockSkewHint(t *testing.T) {
+	if h := clockSkewHint(errors.New("connection refused")); h != "" {
+		t.Errorf("no hint expected, got %v", h)
+	} else if h := clockSkewHint(errors.New("x509: certificate has expired or is not yet valid")); !strings.Contains(h, CLOCK_SKEW_HINT) {
+		t.Errorf("expected the hint, got %v", h)
+	}
+}
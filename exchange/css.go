@@ -7,7 +7,6 @@ import (
 	"github.com/open-horizon/edge-sync-service/common"
 	"path"
 	"strconv"
-	"time"
 )
 
 // These structs are mirrors of similar structs in the edge-sync-service library. They are mirrored here
@@ -73,30 +72,13 @@ func GetObjectsByService(ec ExchangeContext, org string, serviceId string) (*Obj
 	url := path.Join("/api/v1/objects", org)
 	url = ec.GetCSSURL() + url + fmt.Sprintf("?destination_policy=true&service=%v", serviceId)
 
-	retryCount := ec.GetHTTPFactory().RetryCount
-	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "GET", url, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if ec.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			objPolicies := resp.(*ObjectDestinationPolicies)
-			glog.V(5).Infof(rpclogString(fmt.Sprintf("found object policies for objects in %v, with service %v, %v", org, serviceId, objPolicies)))
-			return objPolicies, nil
-		}
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "GET", url, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return nil, err
 	}
+	objPolicies := resp.(*ObjectDestinationPolicies)
+	glog.V(5).Infof(rpclogString(fmt.Sprintf("found object policies for objects in %v, with service %v, %v", org, serviceId, objPolicies)))
+	return objPolicies, nil
 }
 
 // Query the CSS to retrieve object policy updates that haven't been seen before.
@@ -114,30 +96,13 @@ func GetUpdatedObjects(ec ExchangeContext, org string, since int64) (*ObjectDest
 		url = url + "&since=" + strconv.FormatInt(since, 10)
 	}
 
-	retryCount := ec.GetHTTPFactory().RetryCount
-	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "GET", url, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if ec.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			objPolicies := resp.(*ObjectDestinationPolicies)
-			glog.V(5).Infof(rpclogString(fmt.Sprintf("found object policies for org %v, objpolicies %v", org, objPolicies)))
-			return objPolicies, nil
-		}
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "GET", url, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return nil, err
 	}
+	objPolicies := resp.(*ObjectDestinationPolicies)
+	glog.V(5).Infof(rpclogString(fmt.Sprintf("found object policies for org %v, objpolicies %v", org, objPolicies)))
+	return objPolicies, nil
 }
 
 // Update the destination list of the object when that object's policy enables it to be placed on the node.
@@ -149,29 +114,12 @@ func UpdateObjectDestinationList(ec ExchangeContext, org string, objPol *ObjectD
 	url := path.Join("/api/v1/objects", org, objPol.ObjectType, objPol.ObjectID, "destinations")
 	url = ec.GetCSSURL() + url
 
-	retryCount := ec.GetHTTPFactory().RetryCount
-	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "PUT", url, ec.GetExchangeId(), ec.GetExchangeToken(), dests, &resp); err != nil {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if ec.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			glog.V(5).Infof(rpclogString(fmt.Sprintf("updated destination list for object %v of type %v with %v", objPol.ObjectID, objPol.ObjectType, dests)))
-			return nil
-		}
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "PUT", url, ec.GetExchangeId(), ec.GetExchangeToken(), dests, &resp); err != nil {
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return err
 	}
+	glog.V(5).Infof(rpclogString(fmt.Sprintf("updated destination list for object %v of type %v with %v", objPol.ObjectID, objPol.ObjectType, dests)))
+	return nil
 
 }
 
@@ -184,34 +132,17 @@ func GetObject(ec ExchangeContext, org string, objID string, objType string) (*c
 	url := path.Join("/api/v1/objects", org, objType, objID)
 	url = ec.GetCSSURL() + url
 
-	retryCount := ec.GetHTTPFactory().RetryCount
-	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "GET", url, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if ec.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			objMeta := resp.(*common.MetaData)
-			if objMeta.ObjectID != "" {
-				glog.V(5).Infof(rpclogString(fmt.Sprintf("found object %v %v for org %v: %v", objID, objType, org, objMeta)))
-				return objMeta, nil
-			} else {
-				glog.V(5).Infof(rpclogString(fmt.Sprintf("object %v %v for org %v not found", objID, objType, org)))
-				return nil, nil
-			}
-		}
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "GET", url, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return nil, err
+	}
+	objMeta := resp.(*common.MetaData)
+	if objMeta.ObjectID != "" {
+		glog.V(5).Infof(rpclogString(fmt.Sprintf("found object %v %v for org %v: %v", objID, objType, org, objMeta)))
+		return objMeta, nil
+	} else {
+		glog.V(5).Infof(rpclogString(fmt.Sprintf("object %v %v for org %v not found", objID, objType, org)))
+		return nil, nil
 	}
 }
 
@@ -224,34 +155,17 @@ func GetObjectDestinations(ec ExchangeContext, org string, objID string, objType
 	url := path.Join("/api/v1/objects", org, objType, objID, "destinations")
 	url = ec.GetCSSURL() + url
 
-	retryCount := ec.GetHTTPFactory().RetryCount
-	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "GET", url, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if ec.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			dests := resp.(*ObjectDestinationStatuses)
-			if len(*dests) != 0 {
-				glog.V(5).Infof(rpclogString(fmt.Sprintf("found destinations for %v %v %v: %v", org, objID, objType, dests)))
-				return dests, nil
-			} else {
-				glog.V(5).Infof(rpclogString(fmt.Sprintf("no destinations found for %v %v %v", org, objID, objType)))
-				return nil, nil
-			}
-		}
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "GET", url, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return nil, err
+	}
+	dests := resp.(*ObjectDestinationStatuses)
+	if len(*dests) != 0 {
+		glog.V(5).Infof(rpclogString(fmt.Sprintf("found destinations for %v %v %v: %v", org, objID, objType, dests)))
+		return dests, nil
+	} else {
+		glog.V(5).Infof(rpclogString(fmt.Sprintf("no destinations found for %v %v %v", org, objID, objType)))
+		return nil, nil
 	}
 
 }
@@ -264,27 +178,10 @@ func SetPolicyReceived(ec ExchangeContext, objPol *ObjectDestinationPolicy) erro
 	url := path.Join("/api/v1/objects", objPol.OrgID, objPol.ObjectType, objPol.ObjectID, "policyreceived")
 	url = ec.GetCSSURL() + url
 
-	retryCount := ec.GetHTTPFactory().RetryCount
-	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "PUT", url, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if ec.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			glog.V(5).Infof(rpclogString(fmt.Sprintf("set policy received for object %v %v of type %v", objPol.OrgID, objPol.ObjectID, objPol.ObjectType)))
-			return nil
-		}
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "PUT", url, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return err
 	}
+	glog.V(5).Infof(rpclogString(fmt.Sprintf("set policy received for object %v %v of type %v", objPol.OrgID, objPol.ObjectID, objPol.ObjectType)))
+	return nil
 }
@@ -1,9 +1,11 @@
 package exchange
 
 import (
+	"context"
 	"github.com/open-horizon/anax/config"
 	"github.com/open-horizon/anax/policy"
 	"github.com/open-horizon/edge-sync-service/common"
+	"time"
 )
 
 // The handlers module defines replaceable functions that represent the exchange and CSS API's external dependencies. These
@@ -59,6 +61,21 @@ func NewCustomExchangeContext(userId string, passwd string, exchangeURL string,
 	}
 }
 
+// Returns an exchange context that is the same as ec, except that the exchange requests made through it, and the waits
+// between their retries, stop when ctx is done. The API uses it for the requests made on behalf of its clients.
+func WithContext(ctx context.Context, ec ExchangeContext) ExchangeContext {
+	return &boundExchangeContext{ExchangeContext: ec, ctx: ctx}
+}
+
+type boundExchangeContext struct {
+	ExchangeContext
+	ctx context.Context
+}
+
+func (c *boundExchangeContext) GetHTTPFactory() *config.HTTPClientFactory {
+	return c.ExchangeContext.GetHTTPFactory().WithContext(c.ctx)
+}
+
 // A handler for querying the exchange for an organization.
 type OrgHandler func(org string) (*Organization, error)
 
@@ -92,7 +109,10 @@ type PatternHandler func(org string, pattern string) (map[string]Pattern, error)
 
 func GetHTTPExchangePatternHandler(ec ExchangeContext) PatternHandler {
 	return func(org string, pattern string) (map[string]Pattern, error) {
-		return GetPatterns(ec.GetHTTPFactory(), org, pattern, ec.GetExchangeURL(), ec.GetExchangeId(), ec.GetExchangeToken())
+		start := time.Now()
+		pats, err := GetPatterns(ec.GetHTTPFactory(), org, pattern, ec.GetExchangeURL(), ec.GetExchangeId(), ec.GetExchangeToken())
+		recordHandlerCall("getPatterns", start, err)
+		return pats, err
 	}
 }
 
@@ -102,7 +122,10 @@ type PatternHandlerWithContext func(org string, pattern string, id string, token
 
 func GetHTTPExchangePatternHandlerWithContext(cfg *config.HorizonConfig) PatternHandlerWithContext {
 	return func(org string, pattern string, id string, token string) (map[string]Pattern, error) {
-		return GetPatterns(cfg.Collaborators.HTTPClientFactory, org, pattern, cfg.Edge.ExchangeURL, id, token)
+		start := time.Now()
+		pats, err := GetPatterns(cfg.Collaborators.HTTPClientFactory, org, pattern, cfg.Edge.ExchangeURL, id, token)
+		recordHandlerCall("getPatterns", start, err)
+		return pats, err
 	}
 }
 
@@ -174,7 +197,10 @@ type ServiceResolverHandler func(wUrl string, wOrg string, wVersion string, wArc
 
 func GetHTTPServiceResolverHandler(ec ExchangeContext) ServiceResolverHandler {
 	return func(wUrl string, wOrg string, wVersion string, wArch string) (*policy.APISpecList, *ServiceDefinition, []string, error) {
-		return ServiceResolver(wUrl, wOrg, wVersion, wArch, GetHTTPServiceHandler(ec))
+		start := time.Now()
+		apiSpecs, sdef, sIds, err := ServiceResolver(wUrl, wOrg, wVersion, wArch, GetHTTPServiceHandler(ec))
+		recordHandlerCall("resolveService", start, err)
+		return apiSpecs, sdef, sIds, err
 	}
 }
 
@@ -183,7 +209,10 @@ type ServiceDefResolverHandler func(wUrl string, wOrg string, wVersion string, w
 
 func GetHTTPServiceDefResolverHandler(ec ExchangeContext) ServiceDefResolverHandler {
 	return func(wUrl string, wOrg string, wVersion string, wArch string) (map[string]ServiceDefinition, *ServiceDefinition, string, error) {
-		return ServiceDefResolver(wUrl, wOrg, wVersion, wArch, GetHTTPServiceHandler(ec))
+		start := time.Now()
+		deps, sdef, sId, err := ServiceDefResolver(wUrl, wOrg, wVersion, wArch, GetHTTPServiceHandler(ec))
+		recordHandlerCall("resolveService", start, err)
+		return deps, sdef, sId, err
 	}
 }
 
@@ -192,7 +221,10 @@ type ServiceHandler func(wUrl string, wOrg string, wVersion string, wArch string
 
 func GetHTTPServiceHandler(ec ExchangeContext) ServiceHandler {
 	return func(wUrl string, wOrg string, wVersion string, wArch string) (*ServiceDefinition, string, error) {
-		return GetService(ec, wUrl, wOrg, wVersion, wArch)
+		start := time.Now()
+		sdef, sId, err := GetService(ec, wUrl, wOrg, wVersion, wArch)
+		recordHandlerCall("getService", start, err)
+		return sdef, sId, err
 	}
 }
 
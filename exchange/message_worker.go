@@ -11,7 +11,6 @@ import (
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/worker"
 	"strconv"
-	"time"
 )
 
 type ExchangeMessageWorker struct {
@@ -60,6 +59,10 @@ func (w *ExchangeMessageWorker) NewEvent(incoming events.Message) {
 		msg, _ := incoming.(*events.EdgeRegisteredExchangeMessage)
 		w.EC = worker.NewExchangeContext(fmt.Sprintf("%v/%v", msg.Org(), msg.DeviceId()), msg.Token(), w.Config.Edge.ExchangeURL, w.Config.GetCSSURL(), newLimitedRetryHTTPFactory(w.Config.Collaborators.HTTPClientFactory))
 
+	case *events.NodeTokenMessage:
+		msg, _ := incoming.(*events.NodeTokenMessage)
+		w.EC = worker.NewExchangeContext(fmt.Sprintf("%v/%v", msg.Org(), msg.DeviceId()), msg.Token(), w.Config.Edge.ExchangeURL, w.Config.GetCSSURL(), newLimitedRetryHTTPFactory(w.Config.Collaborators.HTTPClientFactory))
+
 	case *events.NodeShutdownCompleteMessage:
 		msg, _ := incoming.(*events.NodeShutdownCompleteMessage)
 		switch msg.Event().Id {
@@ -167,63 +170,27 @@ func (w *ExchangeMessageWorker) getMessages() ([]DeviceMessage, error) {
 	var resp interface{}
 	resp = new(GetDeviceMessageResponse)
 
-	retryCount := w.GetHTTPFactory().RetryCount
-	retryInterval := w.GetHTTPFactory().GetRetryInterval()
-
 	targetURL := w.GetExchangeURL() + "orgs/" + GetOrg(w.GetExchangeId()) + "/nodes/" + GetId(w.GetExchangeId()) + "/msgs"
-	for {
-		if err, tpErr := InvokeExchange(w.GetHTTPFactory().NewHTTPClient(nil), "GET", targetURL, w.GetExchangeId(), w.GetExchangeToken(), nil, &resp); err != nil {
-			glog.Errorf(logString(err.Error()))
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(logString(tpErr.Error()))
-			if w.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", w.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			glog.V(3).Infof(logString(fmt.Sprintf("retrieved %v messages", len(resp.(*GetDeviceMessageResponse).Messages))))
-			msgs := resp.(*GetDeviceMessageResponse).Messages
-			return msgs, nil
-		}
+	if err := InvokeExchangeRetryOnTransportError(w.GetHTTPFactory(), "GET", targetURL, w.GetExchangeId(), w.GetExchangeToken(), nil, &resp); err != nil {
+		glog.Errorf(logString(err.Error()))
+		return nil, err
 	}
+	glog.V(3).Infof(logString(fmt.Sprintf("retrieved %v messages", len(resp.(*GetDeviceMessageResponse).Messages))))
+	msgs := resp.(*GetDeviceMessageResponse).Messages
+	return msgs, nil
 }
 
 func (w *ExchangeMessageWorker) deleteMessage(msg *DeviceMessage) error {
 	var resp interface{}
 	resp = new(PostDeviceResponse)
 
-	retryCount := w.GetHTTPFactory().RetryCount
-	retryInterval := w.GetHTTPFactory().GetRetryInterval()
-
 	targetURL := w.GetExchangeURL() + "orgs/" + GetOrg(w.GetExchangeId()) + "/nodes/" + GetId(w.GetExchangeId()) + "/msgs/" + strconv.Itoa(msg.MsgId)
-	for {
-		if err, tpErr := InvokeExchange(w.GetHTTPFactory().NewHTTPClient(nil), "DELETE", targetURL, w.GetExchangeId(), w.GetExchangeToken(), nil, &resp); err != nil {
-			glog.Errorf(logString(err.Error()))
-			return err
-		} else if tpErr != nil {
-			glog.Warningf(logString(tpErr.Error()))
-			if w.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return fmt.Errorf("Exceeded %v retries for error: %v", w.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			glog.V(3).Infof(logString(fmt.Sprintf("deleted message %v because it was not usable.", msg.MsgId)))
-			return nil
-		}
+	if err := InvokeExchangeRetryOnTransportError(w.GetHTTPFactory(), "DELETE", targetURL, w.GetExchangeId(), w.GetExchangeToken(), nil, &resp); err != nil {
+		glog.Errorf(logString(err.Error()))
+		return err
 	}
+	glog.V(3).Infof(logString(fmt.Sprintf("deleted message %v because it was not usable.", msg.MsgId)))
+	return nil
 }
 
 // Indicates that there is a message for this node.
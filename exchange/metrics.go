@@ -0,0 +1,26 @@
+package exchange
+
+import (
+	"github.com/open-horizon/anax/metrics"
+	"time"
+)
+
+// The calls of the exchange handlers, by handler and result (success or error).
+var handlerCalls = metrics.NewCounterVec("anax_exchange_handler_calls_total",
+	"The calls of the exchange handlers, by handler and result.", "handler", "result")
+
+var handlerCallDuration = metrics.NewHistogramVec("anax_exchange_handler_call_duration_seconds",
+	"The duration of the calls of the exchange handlers, by handler.", metrics.DefaultDurationBuckets, "handler")
+
+// Record a call of an exchange handler that started at start and returned err.
+func recordHandlerCall(handler string, start time.Time, err error) {
+	if !metrics.Enabled() {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	handlerCalls.Inc(handler, result)
+	handlerCallDuration.ObserveSince(start, handler)
+}
@@ -7,7 +7,6 @@ import (
 	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/policy"
 	"strings"
-	"time"
 )
 
 type Pattern struct {
@@ -18,17 +17,19 @@ type Pattern struct {
 	Services           []ServiceReference  `json:"services"`
 	AgreementProtocols []AgreementProtocol `json:"agreementProtocols"`
 	UserInput          []policy.UserInput  `json:"userInput,omitempty"`
+	LastUpdated        string              `json:"lastUpdated,omitempty"`
 }
 
 func (w Pattern) String() string {
-	return fmt.Sprintf("Owner: %v, Label: %v, Description: %v, Public: %v, Services: %v, AgreementProtocols: %v, UserInput: %v",
+	return fmt.Sprintf("Owner: %v, Label: %v, Description: %v, Public: %v, Services: %v, AgreementProtocols: %v, UserInput: %v, LastUpdated: %v",
 		w.Owner,
 		w.Label,
 		w.Description,
 		w.Public,
 		w.Services,
 		w.AgreementProtocols,
-		w.UserInput)
+		w.UserInput,
+		w.LastUpdated)
 }
 
 func (w Pattern) ShortString() string {
@@ -49,7 +50,7 @@ func (w Pattern) ShortString() string {
 
 // return a pointer to a copy of Pattern
 func (w Pattern) DeepCopy() *Pattern {
-	newPattern := Pattern{Owner: w.Owner, Label: w.Label, Description: w.Description, Public: w.Public}
+	newPattern := Pattern{Owner: w.Owner, Label: w.Label, Description: w.Description, Public: w.Public, LastUpdated: w.LastUpdated}
 
 	if w.Services != nil {
 		newServices := make([]ServiceReference, len(w.Services))
@@ -90,15 +91,17 @@ type WorkloadChoice struct {
 	Upgrade                      UpgradePolicy    `json:"upgradePolicy,omitempty"`
 	DeploymentOverrides          string           `json:"deployment_overrides"`           // env var overrides for the workload
 	DeploymentOverridesSignature string           `json:"deployment_overrides_signature"` // signature of env var overrides
+	Channel                      string           `json:"channel,omitempty"`              // the track of the version, e.g. stable or beta, the nodes in another channel do not configure it
 }
 
 func (w WorkloadChoice) String() string {
-	return fmt.Sprintf("Version: %v, Priority: %v, Upgrade: %v, DeploymentOverrides: %v, DeploymentOverridesSignature: %v",
+	return fmt.Sprintf("Version: %v, Priority: %v, Upgrade: %v, DeploymentOverrides: %v, DeploymentOverridesSignature: %v, Channel: %v",
 		w.Version,
 		w.Priority,
 		w.Upgrade,
 		w.DeploymentOverrides,
-		w.DeploymentOverridesSignature)
+		w.DeploymentOverridesSignature,
+		w.Channel)
 }
 
 func (w WorkloadChoice) ShortString() string {
@@ -205,45 +208,28 @@ func GetPatterns(httpClientFactory *config.HTTPClientFactory, org string, patter
 		targetURL = fmt.Sprintf("%vorgs/%v/patterns/%v", exURL, org, pattern)
 	}
 
-	retryCount := httpClientFactory.RetryCount
-	retryInterval := httpClientFactory.GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(httpClientFactory.NewHTTPClient(nil), "GET", targetURL, id, token, nil, &resp); err != nil {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if httpClientFactory.RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", httpClientFactory.RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			var pats map[string]Pattern
-			if resp != nil {
-				pats = resp.(*GetPatternResponse).Patterns
-			}
-
-			if pattern != "" {
-				pat0 := ""
-				for _, pat := range pats {
-					// log the pat with signatures truncated
-					pat0 = pat.ShortString()
-					break
-				}
-				glog.V(3).Infof(rpclogString(fmt.Sprintf("found pattern for %v, %v", org, pat0)))
-			} else {
-				glog.V(3).Infof(rpclogString(fmt.Sprintf("found %v patterns for %v.", len(pats), org)))
-			}
+	if err := InvokeExchangeRetryOnTransportError(httpClientFactory, "GET", targetURL, id, token, nil, &resp); err != nil {
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return nil, err
+	}
+	var pats map[string]Pattern
+	if resp != nil {
+		pats = resp.(*GetPatternResponse).Patterns
+	}
 
-			return pats, nil
+	if pattern != "" {
+		pat0 := ""
+		for _, pat := range pats {
+			// log the pat with signatures truncated
+			pat0 = pat.ShortString()
+			break
 		}
+		glog.V(3).Infof(rpclogString(fmt.Sprintf("found pattern for %v, %v", org, pat0)))
+	} else {
+		glog.V(3).Infof(rpclogString(fmt.Sprintf("found %v patterns for %v.", len(pats), org)))
 	}
+
+	return pats, nil
 }
 
 // Create a name for the generated policy that should be unique within the org.
@@ -415,21 +401,14 @@ func GetPatternNodes(ec ExchangeContext, policyOrg string, patternId string, req
 	var resp interface{}
 	resp = new(SearchExchangePatternResponse)
 	targetURL := ec.GetExchangeURL() + "orgs/" + policyOrg + "/patterns/" + GetId(patternId) + "/search"
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "POST", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), *req, &resp); err != nil {
-			if !strings.Contains(err.Error(), "status: 404") {
-				return nil, err
-			} else {
-				empty := make([]SearchResultDevice, 0, 0)
-				return &empty, nil
-			}
-		} else if tpErr != nil {
-			glog.Warningf(tpErr.Error())
-			time.Sleep(10 * time.Second)
-			continue
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "POST", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), *req, &resp); err != nil {
+		if !strings.Contains(err.Error(), "status: 404") {
+			return nil, err
 		} else {
-			dev := resp.(*SearchExchangePatternResponse).Devices
-			return &dev, nil
+			empty := make([]SearchResultDevice, 0, 0)
+			return &empty, nil
 		}
 	}
+	dev := resp.(*SearchExchangePatternResponse).Devices
+	return &dev, nil
 }
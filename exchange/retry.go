@@ -0,0 +1,119 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The wait before the first retry of a handler call, it doubles on each retry up to handlerRetryMaxWait. It is a
+// variable so that the tests do not wait.
+var handlerRetryBase = time.Second
+
+// The longest wait between the attempts of a handler call.
+const handlerRetryMaxWait = 8 * time.Second
+
+// The random part of the waits, so that the nodes that failed together do not retry together.
+const handlerRetryJitter = 0.5
+
+// The HTTP status of a failed exchange call, as written in the errors of InvokeExchange.
+var exchangeStatusRE = regexp.MustCompile(`(?i)status:? (\d{3})\b`)
+
+// Returns true if the error of an exchange call may go away when the call is made again: a transport error, a timeout,
+// a 429 or a 5xx status. The other errors fail right away, e.g. a 401 or 403 because of the credentials of the node, or
+// a pattern or service that cannot be read.
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	} else if _, ok := err.(*exchangeTransportError); ok {
+		return true
+	} else if exErr, ok := AsExchangeError(err); ok {
+		return exErr.Status == http.StatusTooManyRequests || exErr.Status >= http.StatusInternalServerError
+	}
+
+	msg := err.Error()
+	if strings.HasPrefix(msg, "Unable to demarshal") {
+		return false
+	} else if m := exchangeStatusRE.FindStringSubmatch(msg); m != nil {
+		status, _ := strconv.Atoi(m[1])
+		return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+	} else if strings.Contains(msg, "Exceeded") && strings.Contains(msg, "retries for error") {
+		return true
+	}
+	return IsTransportError(nil, err)
+}
+
+// Returns the policy and the longest time for retrying the handler calls as configured.
+func HandlerRetryPolicy(retry *config.ExchangeRetryConfig) (cutil.RetryPolicy, time.Duration) {
+	policy := cutil.RetryPolicy{
+		Attempts: retry.GetAttempts(),
+		Base:     handlerRetryBase,
+		Max:      handlerRetryMaxWait,
+		Jitter:   handlerRetryJitter,
+	}
+	return policy, time.Duration(retry.GetMaxElapsedS()) * time.Second
+}
+
+// Calls fn until it succeeds, it returns an error that is not retryable, the attempts of the policy are used up or
+// maxElapsed has passed. Each retry is logged with what is being called. The error of the last attempt is returned.
+func RetryHandlerCall(policy cutil.RetryPolicy, maxElapsed time.Duration, what string, fn func() error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), maxElapsed)
+	defer cancel()
+
+	attempt := 0
+	var lastErr error
+	err := cutil.Retry(ctx, policy, IsRetryableError, func() error {
+		attempt++
+		if attempt > 1 {
+			glog.V(3).Infof(rpclogString(fmt.Sprintf("retrying %v, attempt %v of %v, after error: %v", what, attempt, policy.Attempts, lastErr)))
+		}
+		lastErr = fn()
+		return lastErr
+	})
+
+	// The error of the last attempt has the reason of the failure, not the end of the retries.
+	if err != nil && lastErr != nil && ctx.Err() != nil {
+		return lastErr
+	}
+	return err
+}
+
+// Returns a PatternHandler that retries the calls of getPatterns that fail with a retryable error, as configured.
+func GetRetryPatternHandler(getPatterns PatternHandler, retry *config.ExchangeRetryConfig) PatternHandler {
+	return func(org string, pattern string) (map[string]Pattern, error) {
+		policy, maxElapsed := HandlerRetryPolicy(retry)
+
+		var pats map[string]Pattern
+		err := RetryHandlerCall(policy, maxElapsed, fmt.Sprintf("getting pattern %v/%v", org, pattern), func() error {
+			var err error
+			pats, err = getPatterns(org, pattern)
+			return err
+		})
+		return pats, err
+	}
+}
+
+// Returns a ServiceDefResolverHandler that retries the calls of resolve that fail with a retryable error, as
+// configured.
+func GetRetryServiceDefResolverHandler(resolve ServiceDefResolverHandler, retry *config.ExchangeRetryConfig) ServiceDefResolverHandler {
+	return func(wUrl string, wOrg string, wVersion string, wArch string) (map[string]ServiceDefinition, *ServiceDefinition, string, error) {
+		policy, maxElapsed := HandlerRetryPolicy(retry)
+
+		var deps map[string]ServiceDefinition
+		var sdef *ServiceDefinition
+		var sId string
+		err := RetryHandlerCall(policy, maxElapsed, fmt.Sprintf("resolving service %v/%v %v %v", wOrg, wUrl, wVersion, wArch), func() error {
+			var err error
+			deps, sdef, sId, err = resolve(wUrl, wOrg, wVersion, wArch)
+			return err
+		})
+		return deps, sdef, sId, err
+	}
+}
@@ -0,0 +1,233 @@
+// +build unit
+
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/open-horizon/anax/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_IsRetryableError(t *testing.T) {
+
+	for _, err := range []error{
+		&exchangeTransportError{errors.New("connection refused")},
+		errors.New("Invocation of GET at http://exchange/v1/orgs/myorg/patterns/p1 failed invoking HTTP request, status: 500, response: oops"),
+		errors.New("Invocation of GET at http://exchange/v1/orgs/myorg/patterns/p1 failed invoking HTTP request, status: 429, response: slow down"),
+		errors.New("Exceeded 3 retries for error: Invocation of GET at http://exchange/v1/orgs/myorg/patterns/p1 with  failed invoking HTTP request, error: <nil>, HTTP Status: 502 Bad Gateway"),
+		errors.New("Exceeded 3 retries for error: dial tcp 10.0.0.1:443: connect: connection refused"),
+		errors.New("Get http://exchange/v1/orgs/myorg/patterns/p1: net/http: request canceled (Client.Timeout exceeded while awaiting headers)"),
+		NewExchangeError("GET", "http://exchange/v1/orgs/myorg/patterns/p1", 503, []byte("unavailable")),
+	} {
+		if !IsRetryableError(err) {
+			t.Errorf("error %v should be retryable", err)
+		}
+	}
+
+	for _, err := range []error{
+		nil,
+		errors.New("Invocation of GET at http://exchange/v1/orgs/myorg/patterns/p1 failed invoking HTTP request, status: 401, response: bad credentials"),
+		errors.New("Invocation of GET at http://exchange/v1/orgs/myorg/patterns/p1 failed invoking HTTP request, status: 403, response: access denied"),
+		errors.New("Unable to demarshal response {\"patterns\": 5, \"msg\": \"status: 503\"} from invocation of GET at http://exchange/v1/orgs/myorg/patterns/p1, error: json: cannot unmarshal number"),
+		errors.New("unable to find service http://mydomain.com/svc myorg [1.0.0,INFINITY) amd64 on the exchange."),
+		NewExchangeError("GET", "http://exchange/v1/orgs/myorg/patterns/p1", 401, []byte(`{"code":"access denied","msg":"invalid credentials"}`)),
+	} {
+		if IsRetryableError(err) {
+			t.Errorf("error %v should not be retryable", err)
+		}
+	}
+}
+
+// The exchange errors keep their status, URL and message through the wrapping of the handlers.
+func Test_ExchangeError(t *testing.T) {
+
+	url := "http://exchange/v1/orgs/myorg/patterns/p1"
+	err := fmt.Errorf("getting pattern: %w", NewExchangeError("GET", url, 401, []byte(`{"code":"access denied","msg":"invalid credentials"}`)))
+
+	if exErr, ok := AsExchangeError(err); !ok {
+		t.Errorf("the exchange error should be found in %v", err)
+	} else if exErr.Status != 401 || exErr.URL != url || exErr.Message() != "invalid credentials" {
+		t.Errorf("wrong exchange error %v", exErr)
+	}
+
+	if exErr := NewExchangeError("GET", url, 500, []byte(" oops\n")); exErr.Message() != "oops" {
+		t.Errorf("the message of a text body should be the body, got %v", exErr.Message())
+	} else if !exchangeStatusRE.MatchString(exErr.Error()) {
+		t.Errorf("the status should be in the message of the error, got %v", exErr)
+	}
+
+	if _, ok := AsExchangeError(errors.New("connection refused")); ok {
+		t.Errorf("an error without a response of the exchange should not be an exchange error")
+	}
+}
+
+func Test_GetRetryPatternHandler(t *testing.T) {
+
+	handlerRetryBase = time.Millisecond
+	defer func() { handlerRetryBase = time.Second }()
+
+	transient := errors.New("Invocation of GET at http://exchange/v1/orgs/myorg/patterns/p1 failed invoking HTTP request, status: 502, response: bad gateway")
+	pats := map[string]Pattern{"myorg/p1": Pattern{Label: "p1"}}
+
+	// the transient errors are retried until the call succeeds
+	calls := 0
+	getPatterns := func(org string, pattern string) (map[string]Pattern, error) {
+		calls++
+		if calls < 3 {
+			return nil, transient
+		}
+		return pats, nil
+	}
+	if res, err := GetRetryPatternHandler(getPatterns, &config.ExchangeRetryConfig{})("myorg", "p1"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if len(res) != 1 || calls != 3 {
+		t.Errorf("expected the pattern after 3 calls, got %v after %v calls", res, calls)
+	}
+
+	// the attempts are limited
+	calls = 0
+	failing := func(org string, pattern string) (map[string]Pattern, error) {
+		calls++
+		return nil, transient
+	}
+	if _, err := GetRetryPatternHandler(failing, &config.ExchangeRetryConfig{Attempts: 2})("myorg", "p1"); err != transient {
+		t.Errorf("expected the error of the last attempt, got %v", err)
+	} else if calls != 2 {
+		t.Errorf("expected 2 calls, got %v", calls)
+	}
+
+	// a 401 is not retried
+	calls = 0
+	unauthorized := func(org string, pattern string) (map[string]Pattern, error) {
+		calls++
+		return nil, fmt.Errorf("Invocation of GET at http://exchange/v1/orgs/%v/patterns/%v failed invoking HTTP request, status: 401, response: bad credentials", org, pattern)
+	}
+	if _, err := GetRetryPatternHandler(unauthorized, &config.ExchangeRetryConfig{})("myorg", "p1"); err == nil {
+		t.Errorf("expected an error")
+	} else if calls != 1 {
+		t.Errorf("a 401 should not be retried, got %v calls", calls)
+	}
+}
+
+func Test_GetRetryServiceDefResolverHandler_max_elapsed(t *testing.T) {
+
+	handlerRetryBase = 50 * time.Millisecond
+	defer func() { handlerRetryBase = time.Second }()
+
+	timeout := errors.New("Get http://exchange/v1/orgs/myorg/services: net/http: request canceled (Client.Timeout exceeded while awaiting headers)")
+
+	// the retries stop when the time is up, whatever the attempts left
+	calls := 0
+	resolve := func(wUrl string, wOrg string, wVersion string, wArch string) (map[string]ServiceDefinition, *ServiceDefinition, string, error) {
+		calls++
+		time.Sleep(600 * time.Millisecond)
+		return nil, nil, "", timeout
+	}
+	start := time.Now()
+	if _, _, _, err := GetRetryServiceDefResolverHandler(resolve, &config.ExchangeRetryConfig{Attempts: 100, MaxElapsedS: 1})("http://mydomain.com/svc", "myorg", "1.0.0", "amd64"); err != timeout {
+		t.Errorf("expected the error of the last attempt, got %v", err)
+	} else if calls < 2 || calls > 3 {
+		t.Errorf("expected 2 or 3 calls in a second, got %v", calls)
+	} else if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("the retries should stop after about a second, took %v", elapsed)
+	}
+}
+
+// A request made with a factory bound to a context returns as soon as the context is done, and is not retried.
+func Test_GetPatterns_cancelled(t *testing.T) {
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	factory := &config.HTTPClientFactory{
+		NewHTTPClient: func(overrideTimeoutS *uint) *http.Client { return &http.Client{} },
+		RetryCount:    3,
+		RetryInterval: 1,
+	}
+
+	start := time.Now()
+	if _, err := GetPatterns(factory.WithContext(ctx), "myorg", "p1", server.URL+"/", "myorg/myid", "mytoken"); err != context.DeadlineExceeded {
+		t.Errorf("the request should end with its context, the error is %v", err)
+	} else if time.Since(start) > time.Second {
+		t.Errorf("the request should return when its context is done, it took %v", time.Since(start))
+	} else if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("the request should not be retried, it was made %v times", n)
+	}
+}
+
+// The exchange calls retry their transport errors with the retry policy of the factory.
+func Test_GetOrganization_retried(t *testing.T) {
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"orgs": {"retriedorg": {"label": "My org"}}}`))
+	}))
+	defer server.Close()
+
+	factory := &config.HTTPClientFactory{
+		NewHTTPClient: func(overrideTimeoutS *uint) *http.Client { return &http.Client{} },
+		RetryCount:    1,
+		RetryInterval: 1,
+	}
+
+	if org, err := GetOrganization(factory, "retriedorg", server.URL+"/", "myorg/myid", "mytoken"); err != nil {
+		t.Errorf("the call should succeed when it is retried, the error is %v", err)
+	} else if org.Label != "My org" {
+		t.Errorf("wrong organization %v", org)
+	} else if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("the call should be made twice, it was made %v times", n)
+	}
+}
+
+// A heartbeat returns its last transport error as is once the retries are used up, the other errors right away.
+func Test_Heartbeat_errors(t *testing.T) {
+
+	var requests int32
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	factory := &config.HTTPClientFactory{
+		NewHTTPClient: func(overrideTimeoutS *uint) *http.Client { return &http.Client{} },
+		RetryCount:    1,
+		RetryInterval: 1,
+	}
+
+	if err := Heartbeat(factory, server.URL+"/heartbeat", "myorg/myid", "mytoken"); err == nil {
+		t.Errorf("the heartbeat should fail")
+	} else if strings.HasPrefix(err.Error(), "Exceeded") || !strings.Contains(err.Error(), "503") {
+		t.Errorf("the heartbeat should return the transport error, got %v", err)
+	} else if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("the heartbeat should be retried once, it was sent %v times", n)
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	status = http.StatusUnauthorized
+	if err := Heartbeat(factory, server.URL+"/heartbeat", "myorg/myid", "mytoken"); err == nil {
+		t.Errorf("the heartbeat should fail")
+	} else if exErr, ok := AsExchangeError(err); !ok || exErr.Status != http.StatusUnauthorized {
+		t.Errorf("the heartbeat should return the error of the exchange, got %v", err)
+	} else if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("the heartbeat should not be retried, it was sent %v times", n)
+	}
+}
@@ -2,6 +2,7 @@ package exchange
 
 import (
 	"bytes"
+	"context"
 	"crypto/rsa"
 	"encoding/base64"
 	"encoding/json"
@@ -9,6 +10,7 @@ import (
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/externalpolicy"
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/policy"
@@ -212,35 +214,19 @@ func GetExchangeDevice(httpClientFactory *config.HTTPClientFactory, deviceId str
 		return cachedResource, nil
 	}
 
-	retryCount := httpClientFactory.RetryCount
-	retryInterval := httpClientFactory.GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(httpClientFactory.NewHTTPClient(nil), "GET", targetURL, credId, credPasswd, nil, &resp); err != nil {
-			glog.Errorf(err.Error())
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if httpClientFactory.RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", httpClientFactory.RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			devs := resp.(*GetDevicesResponse).Devices
-			if dev, there := devs[deviceId]; !there {
-				return nil, errors.New(fmt.Sprintf("device %v not in GET response %v as expected", deviceId, devs))
-			} else {
-				glog.V(3).Infof(rpclogString(fmt.Sprintf("retrieved device %v from exchange %v", deviceId, dev.ShortString())))
-				glog.V(5).Infof(rpclogString(fmt.Sprintf("device details for %v: %v", deviceId, dev)))
-				UpdateCache(NodeCacheMapKey(GetOrg(deviceId), GetId(deviceId)), NODE_DEF_TYPE_CACHE, dev)
-				return &dev, nil
-			}
-		}
+	if err := InvokeExchangeRetryOnTransportError(httpClientFactory, "GET", targetURL, credId, credPasswd, nil, &resp); err != nil {
+		glog.Errorf(err.Error())
+		return nil, err
+	}
+
+	devs := resp.(*GetDevicesResponse).Devices
+	if dev, there := devs[deviceId]; !there {
+		return nil, errors.New(fmt.Sprintf("device %v not in GET response %v as expected", deviceId, devs))
+	} else {
+		glog.V(3).Infof(rpclogString(fmt.Sprintf("retrieved device %v from exchange %v", deviceId, dev.ShortString())))
+		glog.V(5).Infof(rpclogString(fmt.Sprintf("device details for %v: %v", deviceId, dev)))
+		UpdateCache(NodeCacheMapKey(GetOrg(deviceId), GetId(deviceId)), NODE_DEF_TYPE_CACHE, dev)
+		return &dev, nil
 	}
 }
 
@@ -253,31 +239,15 @@ func PutExchangeDevice(httpClientFactory *config.HTTPClientFactory, deviceId str
 
 	cachedNode := DeleteCacheNodeWriteThru(GetOrg(deviceId), GetId(deviceId))
 
-	retryCount := httpClientFactory.RetryCount
-	retryInterval := httpClientFactory.GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(httpClientFactory.NewHTTPClient(nil), "PUT", targetURL, deviceId, deviceToken, pdr, &resp); err != nil {
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if httpClientFactory.RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", httpClientFactory.RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			glog.V(3).Infof(rpclogString(fmt.Sprintf("put device %v to exchange %v", deviceId, pdr)))
-			if cachedNode != nil {
-				UpdateCacheNodePutWriteThru(GetOrg(deviceId), GetId(deviceId), cachedNode, pdr)
-			}
-			return resp.(*PutDeviceResponse), nil
-		}
+	if err := InvokeExchangeRetryOnTransportError(httpClientFactory, "PUT", targetURL, deviceId, deviceToken, pdr, &resp); err != nil {
+		return nil, err
 	}
+
+	glog.V(3).Infof(rpclogString(fmt.Sprintf("put device %v to exchange %v", deviceId, pdr)))
+	if cachedNode != nil {
+		UpdateCacheNodePutWriteThru(GetOrg(deviceId), GetId(deviceId), cachedNode, pdr)
+	}
+	return resp.(*PutDeviceResponse), nil
 }
 
 // patch the the device
@@ -289,31 +259,15 @@ func PatchExchangeDevice(httpClientFactory *config.HTTPClientFactory, deviceId s
 
 	cachedNode := DeleteCacheNodeWriteThru(GetOrg(deviceId), GetId(deviceId))
 
-	retryCount := httpClientFactory.RetryCount
-	retryInterval := httpClientFactory.GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(httpClientFactory.NewHTTPClient(nil), "PATCH", targetURL, deviceId, deviceToken, pdr, &resp); err != nil {
-			return err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if httpClientFactory.RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return fmt.Errorf("Exceeded %v retries for error: %v", httpClientFactory.RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			glog.V(3).Infof(rpclogString(fmt.Sprintf("patch device %v to exchange %v", deviceId, pdr.ShortString())))
-			if cachedNode != nil {
-				UpdateCacheNodePatchWriteThru(GetOrg(deviceId), GetId(deviceId), cachedNode, pdr)
-			}
-			return nil
-		}
+	if err := InvokeExchangeRetryOnTransportError(httpClientFactory, "PATCH", targetURL, deviceId, deviceToken, pdr, &resp); err != nil {
+		return err
 	}
+
+	glog.V(3).Infof(rpclogString(fmt.Sprintf("patch device %v to exchange %v", deviceId, pdr.ShortString())))
+	if cachedNode != nil {
+		UpdateCacheNodePatchWriteThru(GetOrg(deviceId), GetId(deviceId), cachedNode, pdr)
+	}
+	return nil
 }
 
 type NodeStatus struct {
@@ -336,30 +290,14 @@ func GetNodeStatus(ec ExchangeContext, deviceId string) (*NodeStatus, error) {
 
 	targetURL := fmt.Sprintf("%vorgs/%v/nodes/%v/status", ec.GetExchangeURL(), GetOrg(deviceId), GetId(deviceId))
 
-	retryCount := ec.GetHTTPFactory().RetryCount
-	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if ec.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			glog.V(5).Infof(rpclogString(fmt.Sprintf("returning node status %v for %v.", resp, deviceId)))
-			nodeStatus := resp.(*NodeStatus)
-			return nodeStatus, nil
-		}
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return nil, err
 	}
+
+	glog.V(5).Infof(rpclogString(fmt.Sprintf("returning node status %v for %v.", resp, deviceId)))
+	nodeStatus := resp.(*NodeStatus)
+	return nodeStatus, nil
 }
 
 type DeviceAgreement struct {
@@ -620,30 +558,15 @@ func Heartbeat(httpClientFactory *config.HTTPClientFactory, url string, id strin
 	var resp interface{}
 	resp = new(PostDeviceResponse)
 
-	retryCount := httpClientFactory.RetryCount
-	retryInterval := httpClientFactory.GetRetryInterval()
-
-	for {
-		if err, tpErr := InvokeExchange(httpClientFactory.NewHTTPClient(nil), "POST", url, id, token, nil, &resp); err != nil {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if httpClientFactory.RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return tpErr
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			glog.V(5).Infof(rpclogString(fmt.Sprintf("Sent heartbeat %v: %v", url, resp)))
-			break
+	// the last transport error is returned as is once the retries are used up, it was already logged
+	if err := invokeExchangeWithRetries(httpClientFactory, exchangeRetryPolicy(httpClientFactory), "POST", url, id, token, nil, &resp); err != nil {
+		if tpErr, ok := err.(*exchangeTransportError); ok {
+			return tpErr.error
 		}
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return err
 	}
+	glog.V(5).Infof(rpclogString(fmt.Sprintf("Sent heartbeat %v: %v", url, resp)))
 	return nil
 
 }
@@ -738,34 +661,17 @@ func GetOrganization(httpClientFactory *config.HTTPClientFactory, org string, ex
 	// Search the exchange for the organization definition
 	targetURL := fmt.Sprintf("%vorgs/%v", exURL, org)
 
-	retryCount := httpClientFactory.RetryCount
-	retryInterval := httpClientFactory.GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(httpClientFactory.NewHTTPClient(nil), "GET", targetURL, id, token, nil, &resp); err != nil {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if httpClientFactory.RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", httpClientFactory.RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			orgs := resp.(*GetOrganizationResponse).Orgs
-			if theOrg, ok := orgs[org]; !ok {
-				return nil, errors.New(fmt.Sprintf("organization %v not found", org))
-			} else {
-				glog.V(3).Infof(rpclogString(fmt.Sprintf("found organization %v definition %v", org, theOrg)))
-				UpdateCache(org, ORG_DEF_TYPE_CACHE, theOrg)
-				return &theOrg, nil
-			}
-		}
+	if err := InvokeExchangeRetryOnTransportError(httpClientFactory, "GET", targetURL, id, token, nil, &resp); err != nil {
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return nil, err
+	}
+	orgs := resp.(*GetOrganizationResponse).Orgs
+	if theOrg, ok := orgs[org]; !ok {
+		return nil, errors.New(fmt.Sprintf("organization %v not found", org))
+	} else {
+		glog.V(3).Infof(rpclogString(fmt.Sprintf("found organization %v definition %v", org, theOrg)))
+		UpdateCache(org, ORG_DEF_TYPE_CACHE, theOrg)
+		return &theOrg, nil
 	}
 
 }
@@ -832,30 +738,13 @@ func GetNodeHealthStatus(httpClientFactory *config.HTTPClientFactory, pattern st
 		targetURL = fmt.Sprintf("%vorgs/%v/patterns/%v/nodehealth", exURL, GetOrg(pattern), GetId(pattern))
 	}
 
-	retryCount := httpClientFactory.RetryCount
-	retryInterval := httpClientFactory.GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(httpClientFactory.NewHTTPClient(nil), "POST", targetURL, id, token, &params, &resp); err != nil && !strings.Contains(err.Error(), "status: 404") {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if httpClientFactory.RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", httpClientFactory.RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			status := resp.(*NodeHealthStatus)
-			glog.V(3).Infof(rpclogString(fmt.Sprintf("found nodehealth status for %v, status %v", pattern, status)))
-			return status, nil
-		}
+	if err := InvokeExchangeRetryOnTransportError(httpClientFactory, "POST", targetURL, id, token, &params, &resp); err != nil && !strings.Contains(err.Error(), "status: 404") {
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return nil, err
 	}
+	status := resp.(*NodeHealthStatus)
+	glog.V(3).Infof(rpclogString(fmt.Sprintf("found nodehealth status for %v, status %v", pattern, status)))
+	return status, nil
 
 }
 
@@ -869,31 +758,14 @@ func GetSurfaceErrors(ec ExchangeContext, deviceId string) (*ExchangeSurfaceErro
 
 	targetURL := fmt.Sprintf("%vorgs/%v/nodes/%v/errors", ec.GetExchangeURL(), GetOrg(deviceId), GetId(deviceId))
 
-	retryCount := ec.GetHTTPFactory().RetryCount
-	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if ec.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			glog.V(5).Infof(rpclogString(fmt.Sprintf("returning node surface errors %v for %v.", resp, deviceId)))
-			surfaceErrors := resp.(*ExchangeSurfaceError)
-
-			return surfaceErrors, nil
-		}
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return nil, err
 	}
+	glog.V(5).Infof(rpclogString(fmt.Sprintf("returning node surface errors %v for %v.", resp, deviceId)))
+	surfaceErrors := resp.(*ExchangeSurfaceError)
+
+	return surfaceErrors, nil
 }
 
 func PutSurfaceErrors(ec ExchangeContext, deviceId string, errorList *ExchangeSurfaceError) (*PutDeviceResponse, error) {
@@ -902,28 +774,11 @@ func PutSurfaceErrors(ec ExchangeContext, deviceId string, errorList *ExchangeSu
 
 	targetURL := fmt.Sprintf("%vorgs/%v/nodes/%v/errors", ec.GetExchangeURL(), GetOrg(deviceId), GetId(deviceId))
 
-	retryCount := ec.GetHTTPFactory().RetryCount
-	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "PUT", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), errorList, &resp); err != nil {
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if ec.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			glog.V(3).Infof(rpclogString(fmt.Sprintf("put node surface errors for %v to exchange %v", deviceId, errorList)))
-			return resp.(*PutDeviceResponse), nil
-		}
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "PUT", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), errorList, &resp); err != nil {
+		return nil, err
 	}
+	glog.V(3).Infof(rpclogString(fmt.Sprintf("put node surface errors for %v to exchange %v", deviceId, errorList)))
+	return resp.(*PutDeviceResponse), nil
 }
 
 func DeleteSurfaceErrors(ec ExchangeContext, deviceId string) error {
@@ -932,35 +787,121 @@ func DeleteSurfaceErrors(ec ExchangeContext, deviceId string) error {
 
 	targetURL := fmt.Sprintf("%vorgs/%v/nodes/%v/errors", ec.GetExchangeURL(), GetOrg(deviceId), GetId(deviceId))
 
-	retryCount := ec.GetHTTPFactory().RetryCount
-	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "DELETE", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil && !strings.Contains(err.Error(), "status: 404") {
+		return err
+	}
+	glog.V(3).Infof(rpclogString(fmt.Sprintf("deleted node surface errors for %v to exchange", deviceId)))
+	return nil
+
+}
+
+// A transport error from the exchange, it is the only kind of error that is retried.
+type exchangeTransportError struct {
+	error
+}
+
+// An error response of the exchange, with its HTTP status and body, and the URL that was invoked. The handlers return
+// it as is, so that their callers can tell e.g. a resource that does not exist from credentials that are rejected.
+type ExchangeError struct {
+	Method string
+	URL    string
+	Status int
+	Body   string
+}
+
+func NewExchangeError(method string, url string, status int, body []byte) *ExchangeError {
+	return &ExchangeError{
+		Method: method,
+		URL:    url,
+		Status: status,
+		Body:   string(body),
+	}
+}
+
+func (e *ExchangeError) Error() string {
+	return fmt.Sprintf("Invocation of %v at %v failed invoking HTTP request, status: %v, response: %v", e.Method, e.URL, e.Status, e.Body)
+}
+
+// Returns the message of the exchange, the msg field of its JSON body or the whole body.
+func (e *ExchangeError) Message() string {
+	var resp PostDeviceResponse
+	if err := json.Unmarshal([]byte(e.Body), &resp); err == nil && resp.Msg != "" {
+		return resp.Msg
+	}
+	return strings.TrimSpace(e.Body)
+}
+
+// Returns the ExchangeError that err is, or that it wraps.
+func AsExchangeError(err error) (*ExchangeError, bool) {
+	var exErr *ExchangeError
+	if errors.As(err, &exErr) {
+		return exErr, true
+	}
+	return nil, false
+}
+
+// The transport errors of many nodes are retried with a random part of the wait, so that they do not all come back
+// to the exchange at once after it was down.
+const exchangeRetryJitter = 0.5
+
+// The wait between retries of a transport error grows up to this many times the retry interval.
+const exchangeRetryMaxFactor = 6
+
+// Returns the policy for retrying the transport errors of the exchange calls. The first retry waits the retry interval
+// of the factory, the wait then doubles up to exchangeRetryMaxFactor times it. The calls are retried RetryCount times, a
+// RetryCount of 0 means the transport errors are retried until they go away.
+func exchangeRetryPolicy(httpClientFactory *config.HTTPClientFactory) cutil.RetryPolicy {
+	interval := time.Duration(httpClientFactory.GetRetryInterval()) * time.Second
+	policy := cutil.RetryPolicy{
+		Base:   interval,
+		Max:    exchangeRetryMaxFactor * interval,
+		Jitter: exchangeRetryJitter,
+	}
+	if httpClientFactory.RetryCount > 0 {
+		policy.Attempts = httpClientFactory.RetryCount + 1
+	}
+	return policy
+}
+
+// Invoke an exchange API like InvokeExchange, retrying the transport errors as configured in the HTTP client
+// factory. The other errors are returned right away.
+func InvokeExchangeRetryOnTransportError(httpClientFactory *config.HTTPClientFactory, method string, urlPath string, user string, pw string, params interface{}, resp *interface{}) error {
+	err := invokeExchangeWithRetries(httpClientFactory, exchangeRetryPolicy(httpClientFactory), method, urlPath, user, pw, params, resp)
+	if tpErr, ok := err.(*exchangeTransportError); ok {
+		return fmt.Errorf("Exceeded %v retries for error: %v", httpClientFactory.RetryCount, tpErr.error)
+	}
+	return err
+}
+
+// Invoke an exchange API like InvokeExchange, retrying the transport errors with the given policy. When the retries
+// are used up, the last transport error is returned as an *exchangeTransportError.
+func invokeExchangeWithRetries(httpClientFactory *config.HTTPClientFactory, policy cutil.RetryPolicy, method string, urlPath string, user string, pw string, params interface{}, resp *interface{}) error {
+	isTransportError := func(err error) bool {
+		_, ok := err.(*exchangeTransportError)
+		return ok
+	}
 
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "DELETE", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil && !strings.Contains(err.Error(), "status: 404") {
+	ctx := httpClientFactory.Context()
+	return cutil.Retry(ctx, policy, isTransportError, func() error {
+		if err, tpErr := InvokeExchangeWithContext(ctx, httpClientFactory.NewHTTPClient(nil), method, urlPath, user, pw, params, resp); err != nil {
 			return err
 		} else if tpErr != nil {
 			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if ec.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			glog.V(3).Infof(rpclogString(fmt.Sprintf("deleted node surface errors for %v to exchange", deviceId)))
-			return nil
+			return &exchangeTransportError{tpErr}
 		}
-	}
-
+		return nil
+	})
 }
 
 // This function is used to invoke an exchange API
 // For GET, the given resp parameter will be untouched when http returns code 404.
 func InvokeExchange(httpClient *http.Client, method string, urlPath string, user string, pw string, params interface{}, resp *interface{}) (error, error) {
+	return InvokeExchangeWithContext(context.Background(), httpClient, method, urlPath, user, pw, params, resp)
+}
+
+// Same as InvokeExchange, the request is cancelled when ctx is done. The error of ctx is then returned, it is not a
+// transport error so the call is not retried.
+func InvokeExchangeWithContext(ctx context.Context, httpClient *http.Client, method string, urlPath string, user string, pw string, params interface{}, resp *interface{}) (error, error) {
 
 	if len(method) == 0 {
 		return errors.New(fmt.Sprintf("Error invoking exchange, method name must be specified")), nil
@@ -1006,15 +947,21 @@ func InvokeExchange(httpClient *http.Client, method string, urlPath string, user
 		}
 
 		// If the exchange is down, this call will return an error.
-		httpResp, err := httpClient.Do(req)
-		if IsTransportError(httpResp, err) {
+		sent := time.Now()
+		httpResp, err := httpClient.Do(req.WithContext(ctx))
+		if err == nil && httpResp != nil {
+			clockSkew.record(httpResp.Header.Get("Date"), sent, time.Now())
+		}
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err(), nil
+		} else if IsTransportError(httpResp, err) {
 			status := ""
 			if httpResp != nil {
 				status = httpResp.Status
 			}
 			return nil, errors.New(fmt.Sprintf("Invocation of %v at %v with %v failed invoking HTTP request, error: %v, HTTP Status: %v", method, urlPath, requestBody, err, status))
 		} else if err != nil {
-			return errors.New(fmt.Sprintf("Invocation of %v at %v with %v failed invoking HTTP request, error: %v", method, urlPath, requestBody, err)), nil
+			return errors.New(fmt.Sprintf("Invocation of %v at %v with %v failed invoking HTTP request, error: %v%v", method, urlPath, requestBody, err, clockSkewHint(err))), nil
 		} else {
 			defer httpResp.Body.Close()
 
@@ -1036,12 +983,12 @@ func InvokeExchange(httpClient *http.Client, method string, urlPath string, user
 					glog.V(5).Infof(rpclogString(fmt.Sprintf("Got %v. Response to %v at %v is %v", httpResp.StatusCode, method, urlPath, string(outBytes))))
 					return nil, nil
 				} else {
-					return errors.New(fmt.Sprintf("Invocation of %v at %v failed invoking HTTP request, status: %v, response: %v", method, urlPath, httpResp.StatusCode, string(outBytes))), nil
+					return NewExchangeError(method, urlPath, httpResp.StatusCode, outBytes), nil
 				}
 			} else if (method == "PUT" || method == "POST" || method == "PATCH") && ((httpResp.StatusCode != http.StatusCreated && httpResp.StatusCode != http.StatusNoContent && httpResp.StatusCode != http.StatusConflict) || (httpResp.StatusCode == http.StatusConflict && !strings.Contains(urlPath, "business/policies/"))) {
-				return errors.New(fmt.Sprintf("Invocation of %v at %v failed invoking HTTP request, status: %v, response: %v", method, urlPath, httpResp.StatusCode, string(outBytes))), nil
+				return NewExchangeError(method, urlPath, httpResp.StatusCode, outBytes), nil
 			} else if method == "DELETE" && httpResp.StatusCode != http.StatusNoContent {
-				return errors.New(fmt.Sprintf("Invocation of %v at %v failed invoking HTTP request, status: %v, response: %v", method, urlPath, httpResp.StatusCode, string(outBytes))), nil
+				return NewExchangeError(method, urlPath, httpResp.StatusCode, outBytes), nil
 			} else if (method == "DELETE") || ((method == "PUT" || method == "POST" || method == "PATCH") && httpResp.StatusCode == http.StatusNoContent) {
 				return nil, nil
 			} else {
@@ -1201,36 +1148,19 @@ func GetExchangeVersion(httpClientFactory *config.HTTPClientFactory, exchangeUrl
 		return exchVers, nil
 	}
 
-	retryCount := httpClientFactory.RetryCount
-	retryInterval := httpClientFactory.GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(httpClientFactory.NewHTTPClient(nil), "GET", targetURL, id, token, nil, &resp); err != nil {
-			glog.Errorf(err.Error())
-			return "", err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if httpClientFactory.RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return "", fmt.Errorf("Exceeded %v retries for error: %v", httpClientFactory.RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			// remove last return charactor if any
-			v := resp.(string)
-			if strings.HasSuffix(v, "\n") {
-				v = v[:len(v)-1]
-			}
+	if err := InvokeExchangeRetryOnTransportError(httpClientFactory, "GET", targetURL, id, token, nil, &resp); err != nil {
+		glog.Errorf(err.Error())
+		return "", err
+	}
+	// remove last return charactor if any
+	v := resp.(string)
+	if strings.HasSuffix(v, "\n") {
+		v = v[:len(v)-1]
+	}
 
-			UpdateCache(cacheKey, EXCH_VERS_TYPE_CACHE, v)
+	UpdateCache(cacheKey, EXCH_VERS_TYPE_CACHE, v)
 
-			return v, nil
-		}
-	}
+	return v, nil
 }
 
 // This function gets the pattern/service signing key names and their contents. The oType is one of PATTERN, or SERVICE
@@ -1289,32 +1219,14 @@ func GetObjectSigningKeys(ec ExchangeContext, oType string, oURL string, oOrg st
 
 	key_names := make([]string, 0)
 
-	retryCount := ec.GetHTTPFactory().RetryCount
-	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp_KeyNames); err != nil {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if ec.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			if resp_KeyNames.(string) != "" {
-				glog.V(5).Infof(rpclogString(fmt.Sprintf("found object signing keys %v.", resp_KeyNames)))
-				if err := json.Unmarshal([]byte(resp_KeyNames.(string)), &key_names); err != nil {
-					return nil, errors.New(fmt.Sprintf("Unable to demarshal pattern key list %v to string array, error: %v", resp_KeyNames, err))
-				}
-			}
-			break
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp_KeyNames); err != nil {
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return nil, err
+	}
+	if resp_KeyNames.(string) != "" {
+		glog.V(5).Infof(rpclogString(fmt.Sprintf("found object signing keys %v.", resp_KeyNames)))
+		if err := json.Unmarshal([]byte(resp_KeyNames.(string)), &key_names); err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to demarshal pattern key list %v to string array, error: %v", resp_KeyNames, err))
 		}
 	}
 
@@ -1325,33 +1237,15 @@ func GetObjectSigningKeys(ec ExchangeContext, oType string, oURL string, oOrg st
 		var resp_KeyContent interface{}
 		resp_KeyContent = ""
 
-		retryCount := ec.GetHTTPFactory().RetryCount
-		retryInterval := ec.GetHTTPFactory().GetRetryInterval()
-		for {
-			if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "GET", fmt.Sprintf("%v/%v", targetURL, key), ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp_KeyContent); err != nil {
-				glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-				return nil, err
-			} else if tpErr != nil {
-				glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-				if ec.GetHTTPFactory().RetryCount == 0 {
-					time.Sleep(time.Duration(retryInterval) * time.Second)
-					continue
-				} else if retryCount == 0 {
-					return nil, fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-				} else {
-					retryCount--
-					time.Sleep(time.Duration(retryInterval) * time.Second)
-					continue
-				}
-			} else {
-				if resp_KeyContent.(string) != "" {
-					glog.V(5).Infof(rpclogString(fmt.Sprintf("found signing key content for key %v: %v.", key, resp_KeyContent)))
-					ret[key] = resp_KeyContent.(string)
-				} else {
-					glog.Warningf(rpclogString(fmt.Sprintf("could not find key content for key %v", key)))
-				}
-				break
-			}
+		if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "GET", fmt.Sprintf("%v/%v", targetURL, key), ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp_KeyContent); err != nil {
+			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+			return nil, err
+		}
+		if resp_KeyContent.(string) != "" {
+			glog.V(5).Infof(rpclogString(fmt.Sprintf("found signing key content for key %v: %v.", key, resp_KeyContent)))
+			ret[key] = resp_KeyContent.(string)
+		} else {
+			glog.Warningf(rpclogString(fmt.Sprintf("could not find key content for key %v", key)))
 		}
 	}
 
@@ -10,7 +10,6 @@ import (
 	"github.com/open-horizon/anax/policy"
 	"github.com/open-horizon/anax/semanticversion"
 	"strings"
-	"time"
 )
 
 // service types, they are node defined in the exchange.
@@ -400,31 +399,14 @@ func GetService(ec ExchangeContext, mURL string, mOrg string, mVersion string, m
 		targetURL = fmt.Sprintf("%vorgs/%v/services?url=%v&version=%v&arch=%v", ec.GetExchangeURL(), mOrg, mURL, searchVersion, mArch)
 	}
 
-	retryCount := ec.GetHTTPFactory().RetryCount
-	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return nil, "", err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if ec.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, "", fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			if len(resp.(*GetServicesResponse).Services) > 0 {
-				updateServiceDefCache(resp.(*GetServicesResponse).Services, cachedSvcDefs, mOrg, mURL, mArch)
-			}
-			return processGetServiceResponse(mURL, mOrg, mVersion, mArch, searchVersion, resp.(*GetServicesResponse))
-		}
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return nil, "", err
+	}
+	if len(resp.(*GetServicesResponse).Services) > 0 {
+		updateServiceDefCache(resp.(*GetServicesResponse).Services, cachedSvcDefs, mOrg, mURL, mArch)
 	}
+	return processGetServiceResponse(mURL, mOrg, mVersion, mArch, searchVersion, resp.(*GetServicesResponse))
 }
 
 // When we get a non-error response from the exchange, process the response to return the results based on what the caller
@@ -541,28 +523,11 @@ func GetSelectedServices(ec ExchangeContext, mURL string, mOrg string, mVersion
 		targetURL = fmt.Sprintf("%v&arch=%v", targetURL, mArch)
 	}
 
-	retryCount := ec.GetHTTPFactory().RetryCount
-	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if ec.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			return processGetSelectedServicesResponse(mURL, mOrg, mVersion, mArch, searchVersion, resp.(*GetServicesResponse))
-		}
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return nil, err
 	}
+	return processGetSelectedServicesResponse(mURL, mOrg, mVersion, mArch, searchVersion, resp.(*GetServicesResponse))
 }
 
 // When we get a non-error response from the exchange, process the response to return
@@ -656,7 +621,7 @@ func ServiceResolver(wURL string, wOrg string, wVersion string, wArch string, se
 				// Convert version to a version range expression (if it's not already an expression) so that the underlying GetService
 				// will return us something in the range required by the service.
 				var serviceDef *ServiceDefinition
-				if sDep.Arch != wArch {
+				if !cutil.ArchEquivalent(sDep.Arch, wArch) {
 					return nil, nil, nil, errors.New(fmt.Sprintf("service %v has a different architecture than the top level service.", sDep))
 				} else if vExp, err := semanticversion.Version_Expression_Factory(sDep.Version); err != nil {
 					return nil, nil, nil, errors.New(fmt.Sprintf("unable to create version expression from %v, error %v", sDep.Version, err))
@@ -724,7 +689,7 @@ func ServiceDefResolver(wURL string, wOrg string, wVersion string, wArch string,
 				// Make sure the required service has the same arch as the service.
 				// Convert version to a version range expression (if it's not already an expression) so that the underlying GetService
 				// will return us something in the range required by the service.
-				if sDep.Arch != wArch {
+				if !cutil.ArchEquivalent(sDep.Arch, wArch) {
 					return nil, nil, "", errors.New(fmt.Sprintf("service %v has a different architecture than the top level service.", sDep))
 				} else if vExp, err := semanticversion.Version_Expression_Factory(sDep.Version); err != nil {
 					return nil, nil, "", errors.New(fmt.Sprintf("unable to create version expression from %v, error %v", sDep.Version, err))
@@ -781,31 +746,13 @@ func GetServiceDockerAuthsWithId(ec ExchangeContext, service_id string) ([]Image
 
 	targetURL := fmt.Sprintf("%vorgs/%v/services/%v/dockauths", ec.GetExchangeURL(), GetOrg(service_id), GetId(service_id))
 
-	retryCount := ec.GetHTTPFactory().RetryCount
-	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp_DockAuths); err != nil {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if ec.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			if resp_DockAuths.(string) != "" {
-				if err := json.Unmarshal([]byte(resp_DockAuths.(string)), &docker_auths); err != nil {
-					return nil, errors.New(fmt.Sprintf("Unable to demarshal service docker auth response %v, error: %v", resp_DockAuths, err))
-				}
-			}
-			break
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp_DockAuths); err != nil {
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return nil, err
+	}
+	if resp_DockAuths.(string) != "" {
+		if err := json.Unmarshal([]byte(resp_DockAuths.(string)), &docker_auths); err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to demarshal service docker auth response %v, error: %v", resp_DockAuths, err))
 		}
 	}
 
@@ -869,28 +816,11 @@ func PostDeviceServicesConfigState(httpClientFactory *config.HTTPClientFactory,
 
 	DeleteCacheNodeWriteThru(GetOrg(deviceId), GetId(deviceId))
 
-	retryCount := httpClientFactory.RetryCount
-	retryInterval := httpClientFactory.GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(httpClientFactory.NewHTTPClient(nil), "POST", targetURL, deviceId, deviceToken, svcs_configstate, &resp); err != nil {
-			return err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if httpClientFactory.RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return fmt.Errorf("Exceeded %v retries for error: %v", httpClientFactory.RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			glog.V(3).Infof(rpclogString(fmt.Sprintf("post service configuration states %v for device %v to the exchange.", svcs_configstate, deviceId)))
-			return nil
-		}
+	if err := InvokeExchangeRetryOnTransportError(httpClientFactory, "POST", targetURL, deviceId, deviceToken, svcs_configstate, &resp); err != nil {
+		return err
 	}
+	glog.V(3).Infof(rpclogString(fmt.Sprintf("post service configuration states %v for device %v to the exchange.", svcs_configstate, deviceId)))
+	return nil
 }
 
 // This function gets the service policy for a service.
@@ -934,33 +864,16 @@ func GetServicePolicyWithId(ec ExchangeContext, service_id string) (*ExchangePol
 
 	targetURL := fmt.Sprintf("%vorgs/%v/services/%v/policy", ec.GetExchangeURL(), GetOrg(service_id), GetId(service_id))
 
-	retryCount := ec.GetHTTPFactory().RetryCount
-	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
-			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if ec.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			glog.V(3).Infof(rpclogString(fmt.Sprintf("returning service policy for %v.", service_id)))
-			servicePolicy := resp.(*ExchangePolicy)
-			if servicePolicy != nil {
-				UpdateCache(service_id, SVC_POL_TYPE_CACHE, *servicePolicy)
-			}
-			return servicePolicy, nil
-		}
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil {
+		glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+		return nil, err
+	}
+	glog.V(3).Infof(rpclogString(fmt.Sprintf("returning service policy for %v.", service_id)))
+	servicePolicy := resp.(*ExchangePolicy)
+	if servicePolicy != nil {
+		UpdateCache(service_id, SVC_POL_TYPE_CACHE, *servicePolicy)
 	}
+	return servicePolicy, nil
 }
 
 // This function updates the service policy for a service.
@@ -990,28 +903,11 @@ func PutServicePolicyWithId(ec ExchangeContext, service_id string, ep *ExchangeP
 	resp = new(PutDeviceResponse)
 	targetURL := fmt.Sprintf("%vorgs/%v/services/%v/policy", ec.GetExchangeURL(), GetOrg(service_id), GetId(service_id))
 
-	retryCount := ec.GetHTTPFactory().RetryCount
-	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "PUT", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), ep, &resp); err != nil {
-			return nil, err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if ec.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return nil, fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			glog.V(3).Infof(rpclogString(fmt.Sprintf("put service policy for %v to exchange %v", service_id, ep)))
-			return resp.(*PutDeviceResponse), nil
-		}
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "PUT", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), ep, &resp); err != nil {
+		return nil, err
 	}
+	glog.V(3).Infof(rpclogString(fmt.Sprintf("put service policy for %v to exchange %v", service_id, ep)))
+	return resp.(*PutDeviceResponse), nil
 }
 
 // This function deletes the service policy for a service.
@@ -1043,26 +939,9 @@ func DeleteServicePolicyWithId(ec ExchangeContext, service_id string) error {
 	resp = new(PostDeviceResponse)
 	targetURL := fmt.Sprintf("%vorgs/%v/services/%v/policy", ec.GetExchangeURL(), GetOrg(service_id), GetId(service_id))
 
-	retryCount := ec.GetHTTPFactory().RetryCount
-	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
-	for {
-		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "DELETE", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil && !strings.Contains(err.Error(), "status: 404") {
-			return err
-		} else if tpErr != nil {
-			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
-			if ec.GetHTTPFactory().RetryCount == 0 {
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			} else if retryCount == 0 {
-				return fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
-			} else {
-				retryCount--
-				time.Sleep(time.Duration(retryInterval) * time.Second)
-				continue
-			}
-		} else {
-			glog.V(3).Infof(rpclogString(fmt.Sprintf("deleted device policy for %v from the exchange.", service_id)))
-			return nil
-		}
+	if err := InvokeExchangeRetryOnTransportError(ec.GetHTTPFactory(), "DELETE", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp); err != nil && !strings.Contains(err.Error(), "status: 404") {
+		return err
 	}
+	glog.V(3).Infof(rpclogString(fmt.Sprintf("deleted device policy for %v from the exchange.", service_id)))
+	return nil
 }
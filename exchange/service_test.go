@@ -1253,3 +1253,38 @@ func Test_Support_versionrange_0(t *testing.T) {
 		t.Errorf("Error, version range was copied into version field: %v, should be %v", gsr.Services["s1"].RequiredServices[1].Version, sd2.Version)
 	}
 }
+
+// a required service published with a synonym of the top level service architecture is resolved.
+func TestServiceDefResolverArchSynonym(t *testing.T) {
+
+	top := ServiceDefinition{
+		URL:     "http://test.company.com/service1",
+		Version: "1.0.0",
+		Arch:    "amd64",
+		RequiredServices: []ServiceDependency{
+			ServiceDependency{URL: "http://my.com/ms/ms1", Org: "otherOrg", Version: "1.5.0", Arch: "x86_64"},
+		},
+	}
+	dep := ServiceDefinition{URL: "http://my.com/ms/ms1", Version: "1.5.0", Arch: "x86_64"}
+
+	serviceHandler := func(wUrl string, wOrg string, wVersion string, wArch string) (*ServiceDefinition, string, error) {
+		if wUrl == top.URL {
+			return &top, "myorg/service1_1.0.0_amd64", nil
+		}
+		return &dep, "otherOrg/ms1_1.5.0_x86_64", nil
+	}
+
+	if sMap, sDef, sId, err := ServiceDefResolver(top.URL, "myorg", "1.0.0", "amd64", serviceHandler); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if sDef.URL != top.URL || sId != "myorg/service1_1.0.0_amd64" {
+		t.Errorf("wrong top level service %v %v", sDef, sId)
+	} else if _, ok := sMap["otherOrg/ms1_1.5.0_x86_64"]; !ok || len(sMap) != 1 {
+		t.Errorf("wrong required services %v", sMap)
+	}
+
+	// a required service for another architecture is still rejected
+	top.RequiredServices[0].Arch = "arm64"
+	if _, _, _, err := ServiceDefResolver(top.URL, "myorg", "1.0.0", "amd64", serviceHandler); err == nil {
+		t.Errorf("expected an error for a required service with a different architecture")
+	}
+}
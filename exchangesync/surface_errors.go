@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/boltdb/bolt"
 	"github.com/golang/glog"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/policy"
@@ -100,7 +101,7 @@ func HasPersistentAgreement(db *bolt.DB, serviceResolverHandler exchange.Service
 	}
 
 	for _, service := range *allServices {
-		if (service.SpecRef == workload.URL || workload.URL == "") && (service.Org == workload.Org || workload.Org == "") {
+		if (workload.URL == "" || cutil.SameSpecURL(service.SpecRef, workload.URL)) && (service.Org == workload.Org || workload.Org == "") {
 			return true
 		}
 	}
@@ -19,7 +19,7 @@ require (
 	github.com/etcd-io/bbolt v1.3.3-0.20190528202153-2eb7227adea1 // indirect
 	github.com/fsouza/go-dockerclient v1.6.4
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
-	github.com/golang/protobuf v1.4.0 // indirect
+	github.com/google/go-tpm v0.3.2
 	github.com/google/uuid v1.1.2-0.20190416172445-c2e93f3ae59f
 	github.com/gorilla/mux v1.7.4
 	github.com/jgautheron/goconst v0.0.0-20200227150835-cda7ea3bf591 // indirect
@@ -44,11 +44,10 @@ require (
 	github.com/stretchr/testify v1.4.0
 	github.com/vbatts/tar-split v0.11.1 // indirect
 	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
-	golang.org/x/sys v0.0.0-20200420163511-1957bb5e6d1f
+	golang.org/x/sys v0.0.0-20201207223542-d4d67f95c62d
 	golang.org/x/text v0.3.3
 	golang.org/x/tools v0.0.0-20200823205832-c024452afbcd // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
-	google.golang.org/genproto v0.0.0-20200420144010-e5e8543f8aeb // indirect
 	google.golang.org/grpc v1.28.1 // indirect
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
 	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
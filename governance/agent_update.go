@@ -0,0 +1,224 @@
+package governance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/eventlog"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"github.com/open-horizon/anax/producer"
+	"github.com/open-horizon/anax/semanticversion"
+	"github.com/open-horizon/anax/version"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+const AGENT_UPDATE = "AgentUpdate"
+
+// While an update is waiting for the maintenance window, the window is checked this often.
+const AGENT_UPDATE_WINDOW_CHECK_INTERVAL_S = 60
+
+// The latest version of the agent, published at the configured manifest URL.
+type AgentManifest struct {
+	Version string `json:"version"`
+	URL     string `json:"url,omitempty"`
+}
+
+// Check the version manifest for a newer version of the agent. A new version is reported in the node status and
+// the event log once. When an update command is configured, it is run in the maintenance window, after new proposals
+// are paused and the agreements being made have finished. The command replaces and restarts the agent.
+func (w *GovernanceWorker) checkAgentUpdate() int {
+	live := w.Config.LiveEdge()
+	cfg := &live.AgentUpdate
+	interval := cfg.GetCheckIntervalS()
+
+	status, err := persistence.FindAgentUpdateStatus(w.db)
+	if err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to read the agent update status, error %v", err)))
+		return interval
+	} else if status == nil {
+		status = new(persistence.AgentUpdateStatus)
+	}
+
+	status.CurrentVersion = version.HORIZON_VERSION
+	status.LastCheckTime = uint64(time.Now().Unix())
+	status.CheckError = ""
+
+	// a new version is only reported once
+	reported := ""
+	if status.UpdateAvailable {
+		reported = status.LatestVersion
+	}
+
+	manifest, err := w.getAgentManifest(cfg.ManifestURL)
+	if err == nil {
+		status.UpdateAvailable, err = agentUpdateAvailable(status.CurrentVersion, manifest.Version)
+	}
+	if err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to check for agent updates, error %v", err)))
+		status.CheckError = err.Error()
+		w.saveAgentUpdateStatus(status)
+		return interval
+	}
+
+	status.LatestVersion = manifest.Version
+	status.LatestURL = manifest.URL
+	if !status.UpdateAvailable {
+		status.State = persistence.AGENT_UPDATE_STATE_NONE
+		status.UpdateError = ""
+		w.saveAgentUpdateStatus(status)
+		return interval
+	}
+
+	if reported != manifest.Version {
+		glog.Infof(logString(fmt.Sprintf("agent version %v is available, the running version is %v", manifest.Version, status.CurrentVersion)))
+		w.logAgentUpdateEvent(persistence.SEVERITY_WARN, persistence.NewMessageMeta(EL_GOV_AGENT_UPDATE_AVAILABLE, manifest.Version, status.CurrentVersion), persistence.EC_AGENT_UPDATE_AVAILABLE)
+
+		// the condition hooks report the node the update is available for
+		nodeId, nodeOrg := "", ""
+		if dev, err := persistence.FindExchangeDevice(w.db); err == nil && dev != nil {
+			nodeId, nodeOrg = dev.Id, dev.Org
+		}
+		w.Messages() <- events.NewAgentUpdateMessage(events.AGENT_UPDATE_AVAILABLE, status.CurrentVersion, manifest.Version, manifest.URL, nodeId, nodeOrg)
+	}
+
+	// the command ran but did not restart anax, it is not run again for the same version until anax is restarted
+	if cfg.Command == "" || (status.State == persistence.AGENT_UPDATE_STATE_COMPLETED && reported == manifest.Version) {
+		w.saveAgentUpdateStatus(status)
+		return interval
+	}
+
+	// the window was validated when the config was read
+	window, _ := config.ParseMaintenanceWindow(cfg.Window)
+	if !window.Contains(time.Now()) {
+		status.State = persistence.AGENT_UPDATE_STATE_WAITING
+		w.saveAgentUpdateStatus(status)
+		if interval > AGENT_UPDATE_WINDOW_CHECK_INTERVAL_S {
+			return AGENT_UPDATE_WINDOW_CHECK_INTERVAL_S
+		}
+		return interval
+	}
+
+	// a failed update is tried again after the check interval
+	w.updateAgent(cfg, status, manifest)
+	return interval
+}
+
+// Returns true if the latest version is newer than the running one. A development build has no version to compare.
+func agentUpdateAvailable(current string, latest string) (bool, error) {
+	if !semanticversion.IsVersionString(latest) {
+		return false, fmt.Errorf("the version %v in the agent manifest is not a valid version", latest)
+	} else if !semanticversion.IsVersionString(current) {
+		return false, fmt.Errorf("the running agent is a %v without a version, it cannot be compared with version %v", current, latest)
+	} else if c, err := semanticversion.CompareVersions(latest, current); err != nil {
+		return false, err
+	} else {
+		return c > 0, nil
+	}
+}
+
+func (w *GovernanceWorker) getAgentManifest(url string) (*AgentManifest, error) {
+	resp, err := w.Config.Collaborators.HTTPClientFactory.NewHTTPClient(nil).Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the agent manifest %v, %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to get the agent manifest %v, HTTP status %v", url, resp.StatusCode)
+	}
+	var manifest AgentManifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("unable to read the agent manifest %v, %v", url, err)
+	}
+	return &manifest, nil
+}
+
+// Pause new proposals, wait for the agreements being made to finish, and hand off to the update command. The
+// command normally restarts anax with the new version, so anything after it only runs when it did not.
+func (w *GovernanceWorker) updateAgent(cfg *config.AgentUpdateConfig, status *persistence.AgentUpdateStatus, manifest *AgentManifest) {
+	glog.Infof(logString(fmt.Sprintf("starting the update of the agent to version %v", manifest.Version)))
+	w.logAgentUpdateEvent(persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_GOV_START_AGENT_UPDATE, manifest.Version), persistence.EC_START_AGENT_UPDATE)
+
+	producer.PauseProposals(fmt.Sprintf("the agent is being updated to version %v", manifest.Version))
+	defer producer.ResumeProposals()
+
+	status.State = persistence.AGENT_UPDATE_STATE_DRAINING
+	w.saveAgentUpdateStatus(status)
+
+	deadline := time.Now().Add(cfg.GetDrainTimeout())
+	for {
+		n, err := w.agreementsInProgress()
+		if err != nil {
+			glog.Errorf(logString(fmt.Sprintf("unable to find the agreements being made, error %v", err)))
+		} else if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			glog.Warningf(logString(fmt.Sprintf("%v agreements are still being made after %v, updating the agent anyway", n, cfg.GetDrainTimeout())))
+			break
+		}
+		time.Sleep(10 * time.Second)
+	}
+
+	status.State = persistence.AGENT_UPDATE_STATE_UPDATING
+	status.LastUpdateTime = uint64(time.Now().Unix())
+	w.saveAgentUpdateStatus(status)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.GetCommandTimeout())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", cfg.Command)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%vAGENT_UPDATE_VERSION=%v", config.ENVVAR_PREFIX, manifest.Version),
+		fmt.Sprintf("%vAGENT_UPDATE_URL=%v", config.ENVVAR_PREFIX, manifest.URL))
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = errors.New("the update command timed out")
+	}
+
+	if err != nil {
+		glog.Errorf(logString(fmt.Sprintf("the update of the agent to version %v failed, error %v, output: %v", manifest.Version, err, string(out))))
+		w.logAgentUpdateEvent(persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_GOV_ERR_AGENT_UPDATE, manifest.Version, err.Error()), persistence.EC_ERROR_AGENT_UPDATE)
+		status.State = persistence.AGENT_UPDATE_STATE_FAILED
+		status.UpdateError = err.Error()
+	} else {
+		glog.Infof(logString(fmt.Sprintf("the update command of agent version %v completed, output: %v", manifest.Version, string(out))))
+		w.logAgentUpdateEvent(persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_GOV_AGENT_UPDATE_COMPLETE, manifest.Version), persistence.EC_AGENT_UPDATE_COMPLETE)
+		status.State = persistence.AGENT_UPDATE_STATE_COMPLETED
+		status.UpdateError = ""
+	}
+	w.saveAgentUpdateStatus(status)
+}
+
+// Returns the number of agreements that were accepted but whose services are not running yet.
+func (w *GovernanceWorker) agreementsInProgress() (int, error) {
+	inProgress := func() persistence.EAFilter {
+		return func(a persistence.EstablishedAgreement) bool {
+			return a.AgreementExecutionStartTime == 0 && a.AgreementTerminatedTime == 0
+		}
+	}
+
+	ags, err := persistence.FindEstablishedAgreementsAllProtocols(w.db, policy.AllAgreementProtocols(), []persistence.EAFilter{persistence.UnarchivedEAFilter(), inProgress()})
+	return len(ags), err
+}
+
+func (w *GovernanceWorker) saveAgentUpdateStatus(status *persistence.AgentUpdateStatus) {
+	if err := persistence.SaveAgentUpdateStatus(w.db, status); err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to save the agent update status %v, error %v", status, err)))
+	}
+}
+
+func (w *GovernanceWorker) logAgentUpdateEvent(severity string, meta *persistence.MessageMeta, code string) {
+	if dev, err := persistence.FindExchangeDevice(w.db); err != nil || dev == nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to log the agent update event, the node cannot be read: %v", err)))
+	} else {
+		eventlog.LogNodeEvent(w.db, severity, meta, code, dev.Id, dev.Org, dev.Pattern, dev.Config.State)
+	}
+}
@@ -0,0 +1,154 @@
+// +build unit
+
+package governance
+
+import (
+	"encoding/json"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/version"
+	"github.com/open-horizon/anax/worker"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func Test_agentUpdateAvailable(t *testing.T) {
+	available, err := agentUpdateAvailable("2.24.5", "2.25.0")
+	assert.Nil(t, err)
+	assert.True(t, available, "a newer version is an update")
+
+	available, err = agentUpdateAvailable("2.25.0", "2.25.0")
+	assert.Nil(t, err)
+	assert.False(t, available, "the same version is not an update")
+
+	available, err = agentUpdateAvailable("2.26.0", "2.25.0")
+	assert.Nil(t, err)
+	assert.False(t, available, "an older version is not an update")
+
+	_, err = agentUpdateAvailable("local build", "2.25.0")
+	assert.NotNil(t, err, "a development build has no version to compare")
+
+	_, err = agentUpdateAvailable("2.24.5", "latest")
+	assert.NotNil(t, err, "the manifest must have a version")
+}
+
+// Returns a worker that checks the manifest served by the handler, and the messages it sent so far.
+func agentUpdateTestWorker(t *testing.T, manifest http.HandlerFunc) (*GovernanceWorker, func() []*events.AgentUpdateMessage, func()) {
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Fatalf("unable to set up the db, error %v", err)
+	}
+	if _, err := persistence.SaveNewExchangeDevice(db, "node1", "token", "node1", persistence.DEVICE_TYPE_DEVICE, false, "myorg", "", persistence.CONFIGSTATE_CONFIGURED); err != nil {
+		t.Fatalf("unable to save the node, error %v", err)
+	}
+
+	server := httptest.NewServer(manifest)
+	cfg := &config.HorizonConfig{Edge: config.Config{AgentUpdate: config.AgentUpdateConfig{ManifestURL: server.URL}}}
+	cfg.Collaborators.HTTPClientFactory = &config.HTTPClientFactory{NewHTTPClient: func(*uint) *http.Client { return &http.Client{} }}
+	w := &GovernanceWorker{BaseWorker: worker.NewBaseWorker("test", cfg, nil), db: db}
+
+	// the messages are sent on an unbuffered channel
+	var lock sync.Mutex
+	var sent []*events.AgentUpdateMessage
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case msg := <-w.Messages():
+				if m, ok := msg.(*events.AgentUpdateMessage); ok {
+					lock.Lock()
+					sent = append(sent, m)
+					lock.Unlock()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	currentVersion := version.HORIZON_VERSION
+	version.HORIZON_VERSION = "2.24.5"
+
+	return w, func() []*events.AgentUpdateMessage {
+			lock.Lock()
+			defer lock.Unlock()
+			return append([]*events.AgentUpdateMessage{}, sent...)
+		}, func() {
+			version.HORIZON_VERSION = currentVersion
+			close(done)
+			server.Close()
+			db.Close()
+			cleanTestDir(dir)
+		}
+}
+
+func serveManifest(manifest AgentManifest) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(manifest)
+	}
+}
+
+// A newer version is saved in the status and reported once, with the node it is available for.
+func Test_checkAgentUpdate_available(t *testing.T) {
+	w, sent, cleanup := agentUpdateTestWorker(t, serveManifest(AgentManifest{Version: "2.25.0", URL: "https://mydomain.com/agent/2.25.0"}))
+	defer cleanup()
+
+	w.checkAgentUpdate()
+	w.checkAgentUpdate()
+
+	status, err := persistence.FindAgentUpdateStatus(w.db)
+	assert.Nil(t, err)
+	if assert.NotNil(t, status) {
+		assert.True(t, status.UpdateAvailable)
+		assert.Equal(t, "2.24.5", status.CurrentVersion)
+		assert.Equal(t, "2.25.0", status.LatestVersion)
+		assert.Equal(t, "https://mydomain.com/agent/2.25.0", status.LatestURL)
+		assert.Equal(t, "", status.CheckError)
+	}
+
+	msgs := sent()
+	if assert.Len(t, msgs, 1, "the new version is only reported once") {
+		assert.Equal(t, events.AGENT_UPDATE_AVAILABLE, msgs[0].Event().Id)
+		assert.Equal(t, "2.25.0", msgs[0].LatestVersion)
+		assert.Equal(t, "node1", msgs[0].NodeId)
+		assert.Equal(t, "myorg", msgs[0].NodeOrg)
+	}
+}
+
+// The running version is not an update.
+func Test_checkAgentUpdate_current(t *testing.T) {
+	w, sent, cleanup := agentUpdateTestWorker(t, serveManifest(AgentManifest{Version: "2.24.5"}))
+	defer cleanup()
+
+	w.checkAgentUpdate()
+
+	status, err := persistence.FindAgentUpdateStatus(w.db)
+	assert.Nil(t, err)
+	if assert.NotNil(t, status) {
+		assert.False(t, status.UpdateAvailable)
+		assert.Equal(t, persistence.AGENT_UPDATE_STATE_NONE, status.State)
+	}
+	assert.Len(t, sent(), 0)
+}
+
+// A manifest that cannot be read is saved as the check error.
+func Test_checkAgentUpdate_error(t *testing.T) {
+	w, sent, cleanup := agentUpdateTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	defer cleanup()
+
+	w.checkAgentUpdate()
+
+	status, err := persistence.FindAgentUpdateStatus(w.db)
+	assert.Nil(t, err)
+	if assert.NotNil(t, status) {
+		assert.False(t, status.UpdateAvailable)
+		assert.NotEqual(t, "", status.CheckError)
+	}
+	assert.Len(t, sent(), 0)
+}
@@ -0,0 +1,213 @@
+package governance
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/eventlog"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"github.com/open-horizon/anax/producer"
+	"time"
+)
+
+const WORKLOAD_CANARY = "WorkloadCanary"
+
+// The reasons that the node terminates an agreement because its services did not work. A canary that was terminated
+// for another reason, e.g. by the agbot, is no longer a canary but did not fail.
+var canaryFailureReasons = []string{
+	producer.TERM_REASON_CONTAINER_FAILURE,
+	producer.TERM_REASON_NOT_EXECUTED_TIMEOUT,
+	producer.TERM_REASON_MICROSERVICE_FAILURE,
+	producer.TERM_REASON_WL_IMAGE_LOAD_FAILURE,
+	producer.TERM_REASON_MS_IMAGE_LOAD_FAILURE,
+	producer.TERM_REASON_MS_IMAGE_FETCH_FAILURE,
+	producer.TERM_REASON_IMAGE_DATA_ERROR,
+	producer.TERM_REASON_IMAGE_FETCH_FAILURE,
+	producer.TERM_REASON_IMAGE_FETCH_AUTH_FAILURE,
+	producer.TERM_REASON_IMAGE_SIG_VERIF_FAILURE,
+	producer.TERM_REASON_HEALTH_CHECK_FAILURE,
+}
+
+// Decide on the new workload versions whose canaries are soaking. A version is promoted when all of its canaries ran
+// for the soak time and a maintenance window is open, and the producer then lets the other agreements move to it.
+// It is rolled back as soon as a canary fails or one of its containers is unhealthy, whatever the time: the remaining
+// canaries are cancelled, so that the agbot makes the agreements again, and the producer rejects the version from
+// then on.
+func (w *GovernanceWorker) governWorkloadCanaries() int {
+
+	canaries, err := persistence.FindWorkloadCanaries(w.db)
+	if err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to read the workload canaries, error %v", err)))
+		return 0
+	}
+
+	for _, canary := range canaries {
+		switch canary.State {
+		case persistence.CANARY_STATE_SOAKING:
+			// the agreements are cancelled after the lock is released, the producer may be waiting for it
+			for _, ag := range w.governWorkloadCanary(canary.Org, canary.URL, canary.Version) {
+				reason := w.producerPH[ag.AgreementProtocol].GetTerminationCode(producer.TERM_REASON_CANARY_ROLLBACK)
+				eventlog.LogAgreementEvent(w.db, persistence.SEVERITY_INFO,
+					persistence.NewMessageMeta(EL_GOV_START_TERM_AG_WITH_REASON, ag.RunningWorkload.URL, w.producerPH[ag.AgreementProtocol].GetTerminationReason(reason)),
+					persistence.EC_CANCEL_AGREEMENT, ag)
+				w.cancelGovernedAgreement(&ag, reason)
+			}
+		case persistence.CANARY_STATE_PROMOTED:
+			w.removePromotedCanary(&canary)
+		}
+	}
+	return 0
+}
+
+// Promote or roll back a soaking workload version. Returns the canary agreements to cancel when it is rolled back.
+func (w *GovernanceWorker) governWorkloadCanary(org string, url string, version string) []persistence.EstablishedAgreement {
+
+	producer.LockWorkloadCanaries()
+	defer producer.UnlockWorkloadCanaries()
+
+	// a canary may have been admitted since the canaries were read
+	canary, err := persistence.FindWorkloadCanary(w.db, org, url, version)
+	if err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to read the workload canary of version %v of %v/%v, error %v", version, org, url, err)))
+		return nil
+	} else if canary == nil || canary.State != persistence.CANARY_STATE_SOAKING {
+		return nil
+	}
+
+	glog.V(5).Infof(logString(fmt.Sprintf("governing workload canary %v", canary)))
+
+	health, err := persistence.FindContainerHealth(w.db)
+	if err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to read the container health, error %v", err)))
+		return nil
+	}
+
+	live := w.Config.LiveEdge()
+	soakTime := live.Canary.GetSoakTimeS()
+	now := uint64(time.Now().Unix())
+
+	running := make([]persistence.EstablishedAgreement, 0, len(canary.Canaries))
+	pending := []string{}
+	soaked := true
+	failed, failure := "", ""
+
+	for _, id := range canary.Canaries {
+		ags, err := persistence.FindEstablishedAgreementsAllProtocols(w.db, policy.AllAgreementProtocols(), []persistence.EAFilter{persistence.IdEAFilter(id)})
+		if err != nil {
+			glog.Errorf(logString(fmt.Sprintf("unable to read canary agreement %v, error %v", id, err)))
+			return nil
+		} else if len(ags) == 0 {
+			// the agreement of an accepted proposal is saved after its canary is admitted
+			pending = append(pending, id)
+			continue
+		}
+
+		ag := ags[0]
+		if ag.AgreementTerminatedTime != 0 {
+			if w.isCanaryFailure(&ag) && failed == "" {
+				failed, failure = id, ag.TerminatedDescription
+			}
+			continue
+		}
+
+		running = append(running, ag)
+		for _, ch := range health {
+			if ch.Owner == id && ch.Status == persistence.CONTAINER_UNHEALTHY && failed == "" {
+				failed, failure = id, fmt.Sprintf("the health check of container %v failed: %v", ch.ServiceName, ch.LastFailure)
+			}
+		}
+		if ag.AgreementExecutionStartTime == 0 || ag.AgreementExecutionStartTime+soakTime > now {
+			soaked = false
+		}
+	}
+
+	if failed != "" {
+		w.rollBackWorkloadCanary(canary, failed, failure)
+		return running
+	}
+
+	// canaries that ended without failing are replaced by the next agreements that move to the version
+	canary.Canaries = pending
+	for _, ag := range running {
+		canary.Canaries = append(canary.Canaries, ag.CurrentAgreementId)
+	}
+
+	// the other agreements move to a promoted version, which waits for a maintenance window
+	key := fmt.Sprintf("%v/%v/%v", canary.Org, canary.URL, canary.Version)
+	if soaked && len(running) != 0 && !w.inMaintenanceWindow() {
+		w.deferAction(persistence.DEFERRED_CANARY_PROMOTION, key, "", "",
+			fmt.Sprintf("the promotion of version %v of %v/%v", canary.Version, canary.Org, canary.URL))
+	} else if soaked && len(running) != 0 {
+		w.clearDeferredAction(persistence.DEFERRED_CANARY_PROMOTION, key)
+		glog.V(3).Infof(logString(fmt.Sprintf("promoting version %v of %v/%v, its canaries ran for %v seconds", canary.Version, canary.Org, canary.URL, soakTime)))
+		canary.State = persistence.CANARY_STATE_PROMOTED
+		canary.DecisionTime = now
+		w.logCanaryEvent(persistence.SEVERITY_INFO,
+			persistence.NewMessageMeta(EL_GOV_WORKLOAD_CANARY_PROMOTED, canary.Version, canary.Org, canary.URL, len(running), soakTime),
+			persistence.EC_WORKLOAD_CANARY_PROMOTED)
+	}
+
+	w.saveWorkloadCanary(canary)
+	return nil
+}
+
+// Returns true if the node terminated the agreement because its services did not work.
+func (w *GovernanceWorker) isCanaryFailure(ag *persistence.EstablishedAgreement) bool {
+	pph, ok := w.producerPH[ag.AgreementProtocol]
+	if !ok {
+		return false
+	}
+	for _, reason := range canaryFailureReasons {
+		if uint64(pph.GetTerminationCode(reason)) == ag.TerminatedReason {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *GovernanceWorker) rollBackWorkloadCanary(canary *persistence.WorkloadCanary, failed string, failure string) {
+
+	glog.Warningf(logString(fmt.Sprintf("rolling back version %v of %v/%v, canary agreement %v failed: %v", canary.Version, canary.Org, canary.URL, failed, failure)))
+
+	canary.State = persistence.CANARY_STATE_ROLLED_BACK
+	canary.DecisionTime = uint64(time.Now().Unix())
+	canary.Reason = fmt.Sprintf("canary agreement %v failed: %v", failed, failure)
+	w.saveWorkloadCanary(canary)
+	w.clearDeferredAction(persistence.DEFERRED_CANARY_PROMOTION, fmt.Sprintf("%v/%v/%v", canary.Org, canary.URL, canary.Version))
+
+	w.logCanaryEvent(persistence.SEVERITY_ERROR,
+		persistence.NewMessageMeta(EL_GOV_WORKLOAD_CANARY_FAILED, canary.Version, canary.Org, canary.URL, failed, failure),
+		persistence.EC_WORKLOAD_CANARY_FAILED)
+}
+
+// A promoted version has nothing left to decide, it is forgotten when no agreement runs it anymore.
+func (w *GovernanceWorker) removePromotedCanary(canary *persistence.WorkloadCanary) {
+	runsVersion := func() persistence.EAFilter {
+		return func(a persistence.EstablishedAgreement) bool {
+			return a.RunningWorkload.Org == canary.Org && cutil.SameSpecURL(a.RunningWorkload.URL, canary.URL) && a.RunningWorkload.Version == canary.Version
+		}
+	}
+
+	if ags, err := persistence.FindEstablishedAgreementsAllProtocols(w.db, policy.AllAgreementProtocols(), []persistence.EAFilter{persistence.UnarchivedEAFilter(), runsVersion()}); err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to retrieve agreements from database, error %v", err)))
+	} else if len(ags) == 0 {
+		if err := persistence.DeleteWorkloadCanary(w.db, canary.Org, canary.URL, canary.Version); err != nil {
+			glog.Errorf(logString(fmt.Sprintf("unable to delete workload canary %v, error %v", canary, err)))
+		}
+	}
+}
+
+func (w *GovernanceWorker) saveWorkloadCanary(canary *persistence.WorkloadCanary) {
+	if err := persistence.SaveWorkloadCanary(w.db, canary); err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to save workload canary %v, error %v", canary, err)))
+	}
+}
+
+func (w *GovernanceWorker) logCanaryEvent(severity string, meta *persistence.MessageMeta, code string) {
+	if dev, err := persistence.FindExchangeDevice(w.db); err != nil || dev == nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to log the workload canary event, the node cannot be read: %v", err)))
+	} else {
+		eventlog.LogNodeEvent(w.db, severity, meta, code, dev.Id, dev.Org, dev.Pattern, dev.Config.State)
+	}
+}
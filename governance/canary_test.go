@@ -0,0 +1,107 @@
+// +build unit
+
+package governance
+
+import (
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"github.com/open-horizon/anax/worker"
+	"testing"
+	"time"
+)
+
+const (
+	canaryOrg = "myorg"
+	canaryURL = "https://mydomain.com/services/netspeed"
+)
+
+func canaryTestWorker(t *testing.T, soakTimeS uint64) (*GovernanceWorker, func()) {
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Fatalf("unable to set up the db, error %v", err)
+	}
+	cfg := &config.HorizonConfig{Edge: config.Config{Canary: config.CanaryConfig{Count: 1, SoakTimeS: soakTimeS}}}
+	return &GovernanceWorker{BaseWorker: worker.NewBaseWorker("test", cfg, nil), db: db}, func() {
+		db.Close()
+		cleanTestDir(dir)
+	}
+}
+
+// Saves a soaking rollout with a running canary agreement.
+func newSoakingCanary(t *testing.T, w *GovernanceWorker, agreementId string) {
+	wi := &persistence.WorkloadInfo{URL: canaryURL, Org: canaryOrg, Version: "2.0.0"}
+	if _, err := persistence.NewEstablishedAgreement(w.db, "ag", agreementId, "agbot", "proposal", policy.BasicProtocol, 1, persistence.ServiceSpecs{}, "", "", "", "", "", wi, 0); err != nil {
+		t.Fatalf("unable to save agreement %v, error %v", agreementId, err)
+	} else if _, err := persistence.AgreementStateExecutionStarted(w.db, agreementId, policy.BasicProtocol); err != nil {
+		t.Fatalf("unable to start agreement %v, error %v", agreementId, err)
+	}
+
+	canary := persistence.NewWorkloadCanary(canaryOrg, canaryURL, "2.0.0", 1, 1, uint64(time.Now().Unix()))
+	canary.Canaries = []string{agreementId}
+	if err := persistence.SaveWorkloadCanary(w.db, canary); err != nil {
+		t.Fatalf("unable to save the rollout, error %v", err)
+	}
+}
+
+func findCanary(t *testing.T, w *GovernanceWorker) *persistence.WorkloadCanary {
+	canary, err := persistence.FindWorkloadCanary(w.db, canaryOrg, canaryURL, "2.0.0")
+	if err != nil || canary == nil {
+		t.Fatalf("unable to read the rollout %v, error %v", canary, err)
+	}
+	return canary
+}
+
+// The version is promoted once its canaries ran for the soak time.
+func Test_governWorkloadCanary_promote(t *testing.T) {
+
+	w, cleanup := canaryTestWorker(t, 2)
+	defer cleanup()
+	newSoakingCanary(t, w, "ag-canary")
+
+	// an accepted canary whose agreement is not saved yet is kept
+	canary := findCanary(t, w)
+	canary.Canaries = append(canary.Canaries, "ag-pending")
+	persistence.SaveWorkloadCanary(w.db, canary)
+
+	if cancel := w.governWorkloadCanary(canaryOrg, canaryURL, "2.0.0"); len(cancel) != 0 {
+		t.Errorf("no agreement should be cancelled, %v are", cancel)
+	} else if canary := findCanary(t, w); canary.State != persistence.CANARY_STATE_SOAKING {
+		t.Errorf("the version should still be soaking, it is %v", canary.State)
+	} else if !canary.IsCanary("ag-pending") || !canary.IsCanary("ag-canary") {
+		t.Errorf("the canaries should be kept, they are %v", canary.Canaries)
+	}
+
+	time.Sleep(2 * time.Second)
+	if cancel := w.governWorkloadCanary(canaryOrg, canaryURL, "2.0.0"); len(cancel) != 0 {
+		t.Errorf("no agreement should be cancelled, %v are", cancel)
+	} else if canary := findCanary(t, w); canary.State != persistence.CANARY_STATE_PROMOTED || canary.DecisionTime == 0 {
+		t.Errorf("the version should be promoted, it is %v", canary)
+	}
+}
+
+// The version is rolled back as soon as the health check of a canary fails, and the canaries are cancelled.
+func Test_governWorkloadCanary_rollback(t *testing.T) {
+
+	w, cleanup := canaryTestWorker(t, 600)
+	defer cleanup()
+	newSoakingCanary(t, w, "ag-canary")
+
+	health := persistence.NewContainerHealth("container1", "ag-canary", "netspeed")
+	health.Status = persistence.CONTAINER_UNHEALTHY
+	health.LastFailure = "exit code 1"
+	if err := persistence.SaveContainerHealth(w.db, health); err != nil {
+		t.Fatalf("unable to save the container health, error %v", err)
+	}
+
+	if cancel := w.governWorkloadCanary(canaryOrg, canaryURL, "2.0.0"); len(cancel) != 1 || cancel[0].CurrentAgreementId != "ag-canary" {
+		t.Errorf("the canary should be cancelled, %v are", cancel)
+	} else if canary := findCanary(t, w); canary.State != persistence.CANARY_STATE_ROLLED_BACK || canary.Reason == "" {
+		t.Errorf("the version should be rolled back, it is %v", canary)
+	}
+
+	// a version that was rolled back is not governed again
+	if cancel := w.governWorkloadCanary(canaryOrg, canaryURL, "2.0.0"); len(cancel) != 0 {
+		t.Errorf("no agreement should be cancelled again, %v are", cancel)
+	}
+}
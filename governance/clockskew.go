@@ -0,0 +1,32 @@
+package governance
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+)
+
+const CLOCK_SKEW = "ClockSkew"
+
+// Log a warning in the event log when the clock skew that was measured on the exchange responses goes over the
+// warning limit of the config, and when it is back within the limit.
+func (w *GovernanceWorker) checkClockSkew() int {
+	skew := exchange.GetClockSkew()
+	if skew == nil {
+		return 0
+	}
+
+	live := w.Config.LiveEdge()
+	limit := live.ClockSkew.GetWarnS()
+	if exceeded := skew.Exceeds(limit); exceeded && !w.clockSkewed {
+		glog.Warningf(logString(fmt.Sprintf("the clock of the node is off the exchange: %v", skew)))
+		w.logNodeConditionEvent(persistence.SEVERITY_WARN, persistence.NewMessageMeta(EL_GOV_CLOCK_SKEW, skew.SkewS, limit, exchange.CLOCK_SKEW_HINT), persistence.EC_CLOCK_SKEW)
+		w.clockSkewed = true
+	} else if !exceeded && w.clockSkewed {
+		glog.Infof(logString(fmt.Sprintf("the clock of the node is back in sync with the exchange: %v", skew)))
+		w.logNodeConditionEvent(persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_GOV_CLOCK_SKEW_RECOVERED, skew.SkewS, limit), persistence.EC_CLOCK_SKEW_RECOVERED)
+		w.clockSkewed = false
+	}
+	return 0
+}
@@ -133,6 +133,22 @@ func (w *GovernanceWorker) NewUpdateMicroserviceCommand(key string, started bool
 	}
 }
 
+// ==============================================================================================================
+// Restart a failed dependent service once its restart backoff has passed.
+type RetryMicroserviceCommand struct {
+	MsInstKey string
+}
+
+func (c RetryMicroserviceCommand) ShortString() string {
+	return fmt.Sprintf("RetryServiceCommand: MsInstKey %v", c.MsInstKey)
+}
+
+func (w *GovernanceWorker) NewRetryMicroserviceCommand(key string) *RetryMicroserviceCommand {
+	return &RetryMicroserviceCommand{
+		MsInstKey: key,
+	}
+}
+
 // ==============================================================================================================
 type ReportDeviceStatusCommand struct {
 }
@@ -176,6 +192,24 @@ func (w *GovernanceWorker) NewUpgradeMicroserviceCommand(msdef_id string) *Upgra
 	}
 }
 
+// ==============================================================================================================
+// Clean up the instances of a service definition that was replaced by another version
+type ReplaceMicroserviceCommand struct {
+	OldMsDefId string
+	NewMsDefId string
+}
+
+func (c ReplaceMicroserviceCommand) ShortString() string {
+	return fmt.Sprintf("ReplaceServiceCommand: OldMsDefId %v, NewMsDefId %v", c.OldMsDefId, c.NewMsDefId)
+}
+
+func (w *GovernanceWorker) NewReplaceMicroserviceCommand(old_msdef_id string, new_msdef_id string) *ReplaceMicroserviceCommand {
+	return &ReplaceMicroserviceCommand{
+		OldMsDefId: old_msdef_id,
+		NewMsDefId: new_msdef_id,
+	}
+}
+
 // ==============================================================================================================
 // Start agreement-less services
 type StartAgreementLessServicesCommand struct {
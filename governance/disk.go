@@ -0,0 +1,47 @@
+package governance
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/eventlog"
+	"github.com/open-horizon/anax/persistence"
+)
+
+const DISK_SPACE = "DiskSpace"
+
+// Check the free space of the partitions of the agent, and log an alert in the event log when a partition goes below
+// a threshold of the disk configuration or its space cannot be read. The alert is logged once when the state changes,
+// not on every check, and the recovery is logged when the space is freed again.
+func (w *GovernanceWorker) checkDiskSpace() int {
+	for _, d := range cutil.GetAgentDiskUsage(w.Config) {
+		last := w.diskStates[d.Path]
+		w.diskStates[d.Path] = d.State
+		if d.State == last {
+			continue
+		}
+
+		if d.State == cutil.DISK_STATE_UNKNOWN {
+			w.logNodeConditionEvent(persistence.SEVERITY_WARN, persistence.NewMessageMeta(EL_GOV_DISK_SPACE_UNKNOWN, d.Name, d.Path, d.Error), persistence.EC_DISK_SPACE_UNKNOWN)
+		} else if d.State == cutil.DISK_STATE_OK {
+			if last != cutil.DISK_STATE_WARNING && last != cutil.DISK_STATE_CRITICAL {
+				continue
+			}
+			glog.Infof(logString(fmt.Sprintf("free disk space recovered: %v", d)))
+			w.logNodeConditionEvent(persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_GOV_DISK_SPACE_RECOVERED, d.FreeMB, d.TotalMB, d.Name, d.Path), persistence.EC_DISK_SPACE_RECOVERED)
+		} else {
+			glog.Errorf(logString(fmt.Sprintf("free disk space is low: %v", d)))
+			w.logNodeConditionEvent(persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_GOV_DISK_SPACE_LOW, d.FreeMB, d.TotalMB, d.Name, d.Path, d.State), persistence.EC_DISK_SPACE_LOW)
+		}
+	}
+	return 0
+}
+
+// Log an event about a condition of the node. It is logged for the database when the node is not registered yet.
+func (w *GovernanceWorker) logNodeConditionEvent(severity string, meta *persistence.MessageMeta, code string) {
+	if dev, err := persistence.FindExchangeDevice(w.db); err != nil || dev == nil {
+		eventlog.LogDatabaseEvent(w.db, severity, meta, code)
+	} else {
+		eventlog.LogNodeEvent(w.db, severity, meta, code, dev.Id, dev.Org, dev.Pattern, dev.Config.State)
+	}
+}
@@ -0,0 +1,50 @@
+// +build unit
+
+package governance
+
+import (
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/worker"
+	"os"
+	"testing"
+)
+
+// Returns the event codes of the event log, in the order they were logged.
+func diskEventCodes(t *testing.T, w *GovernanceWorker) []string {
+	logs, err := persistence.FindAllEventLogs(w.db)
+	if err != nil {
+		t.Fatalf("unable to read the event log, error %v", err)
+	}
+	codes := []string{}
+	for _, l := range logs {
+		codes = append(codes, l.EventCode)
+	}
+	return codes
+}
+
+// An image storage that cannot be read is logged once, and becoming readable is not a recovery of the space.
+func Test_checkDiskSpace_unknown(t *testing.T) {
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Fatalf("unable to set up the db, error %v", err)
+	}
+	defer cleanTestDir(dir)
+	defer db.Close()
+
+	cfg := &config.HorizonConfig{Edge: config.Config{DBPath: os.TempDir(), DockerEndpoint: "unix:///var/run/docker.sock", HostRootPath: "/no/such/host"}}
+	w := &GovernanceWorker{BaseWorker: worker.NewBaseWorker("test", cfg, nil), db: db, diskStates: make(map[string]string)}
+
+	w.checkDiskSpace()
+	w.checkDiskSpace()
+	if codes := diskEventCodes(t, w); len(codes) != 1 || codes[0] != persistence.EC_DISK_SPACE_UNKNOWN {
+		t.Errorf("expected one %v event, got %v", persistence.EC_DISK_SPACE_UNKNOWN, codes)
+	}
+
+	cfg.Edge.HostRootPath = ""
+	cfg.Edge.Disk.ImageStoragePath = os.TempDir()
+	w.checkDiskSpace()
+	if codes := diskEventCodes(t, w); len(codes) != 1 {
+		t.Errorf("expected no event when the space can be read and is not low, got %v", codes)
+	}
+}
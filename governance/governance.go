@@ -53,7 +53,9 @@ type GovernanceWorker struct {
 	patternChange     ChangePattern
 	limitedRetryEC    exchange.ExchangeContext
 	exchErrors        cache.Cache
-	noworkDispatch    int64 // The last time the NoWorkHandler was dispatched.
+	noworkDispatch    int64             // The last time the NoWorkHandler was dispatched.
+	diskStates        map[string]string // The last state of the free space of each partition, keyed by path.
+	clockSkewed       bool              // Whether the clock skew was over the warning limit at the last check.
 }
 
 func NewGovernanceWorker(name string, cfg *config.HorizonConfig, db *bolt.DB, pm *policy.PolicyManager) *GovernanceWorker {
@@ -81,6 +83,7 @@ func NewGovernanceWorker(name string, cfg *config.HorizonConfig, db *bolt.DB, pm
 		limitedRetryEC:  lrec,
 		exchErrors:      cache.NewSimpleMapCache(),
 		noworkDispatch:  time.Now().Unix(),
+		diskStates:      make(map[string]string),
 	}
 
 	// Start the worker and set the no work interval to 10 seconds.
@@ -112,6 +115,11 @@ func (w *GovernanceWorker) NewEvent(incoming events.Message) {
 		w.deviceType = msg.DeviceType()
 		w.limitedRetryEC = newLimitedRetryExchangeContext(w.EC)
 
+	case *events.NodeTokenMessage:
+		msg, _ := incoming.(*events.NodeTokenMessage)
+		w.EC = worker.NewExchangeContext(fmt.Sprintf("%v/%v", msg.Org(), msg.DeviceId()), msg.Token(), w.Config.Edge.ExchangeURL, w.Config.GetCSSURL(), w.Config.Collaborators.HTTPClientFactory)
+		w.limitedRetryEC = newLimitedRetryExchangeContext(w.EC)
+
 	case *events.EdgeConfigCompleteMessage:
 		// Start any services that run without needing an agreement.
 		cmd := w.NewStartAgreementLessServicesCommand()
@@ -132,6 +140,9 @@ func (w *GovernanceWorker) NewEvent(incoming events.Message) {
 		case events.IMAGE_LOAD_FAILED:
 			cmd := w.NewCleanupExecutionCommand(msg.AgreementProtocol, msg.AgreementId, w.producerPH[msg.AgreementProtocol].GetTerminationCode(producer.TERM_REASON_WL_IMAGE_LOAD_FAILURE), msg.Deployment)
 			w.Commands <- cmd
+		case events.HEALTH_CHECK_FAILED:
+			cmd := w.NewCleanupExecutionCommand(msg.AgreementProtocol, msg.AgreementId, w.producerPH[msg.AgreementProtocol].GetTerminationCode(producer.TERM_REASON_HEALTH_CHECK_FAILURE), msg.Deployment)
+			w.Commands <- cmd
 		case events.WORKLOAD_DESTROYED:
 			cmd := w.NewCleanupStatusCommand(msg.AgreementProtocol, msg.AgreementId, STATUS_WORKLOAD_DESTROYED)
 			w.Commands <- cmd
@@ -179,6 +190,22 @@ func (w *GovernanceWorker) NewEvent(incoming events.Message) {
 						persistence.NewMessageMeta(EL_GOV_IMAGE_LOADED, ags[0].RunningWorkload.Org, ags[0].RunningWorkload.URL),
 						fmt.Sprintf(persistence.EC_IMAGE_LOADED),
 						ags[0])
+					for _, mismatch := range msg.DigestMismatches {
+						eventlog.LogAgreementEvent(
+							w.db,
+							persistence.SEVERITY_WARN,
+							persistence.NewMessageMeta(EL_GOV_IMAGE_DIGEST_MISMATCH, ags[0].RunningWorkload.Org, ags[0].RunningWorkload.URL, mismatch),
+							persistence.EC_IMAGE_DIGEST_MISMATCH,
+							ags[0])
+					}
+					if len(msg.ImageDigests) != 0 {
+						if _, err := persistence.AgreementImageDigestsUpdate(w.db, lc.AgreementId, lc.AgreementProtocol, msg.ImageDigests); err != nil {
+							glog.Errorf(logString(fmt.Sprintf("unable to record image digests for agreement %v, error %v", lc.AgreementId, err)))
+							eventlog.LogDatabaseEvent(w.db, persistence.SEVERITY_ERROR,
+								persistence.NewMessageMeta(EL_GOV_ERR_SAVE_IMAGE_DIGESTS, lc.AgreementId, err.Error()),
+								persistence.EC_DATABASE_ERROR)
+						}
+					}
 				} else {
 					var errDetails = "unknown error"
 					if msg.Error != nil {
@@ -204,6 +231,22 @@ func (w *GovernanceWorker) NewEvent(incoming events.Message) {
 					persistence.NewMessageMeta(EL_GOV_IMAGE_LOADED_FOR_SVC, serviceInfo.Org, serviceInfo.URL),
 					persistence.EC_IMAGE_LOADED,
 					"", serviceInfo.URL, "", serviceInfo.Version, "", lc.AgreementIds)
+				for _, mismatch := range msg.DigestMismatches {
+					eventlog.LogServiceEvent2(
+						w.db,
+						persistence.SEVERITY_WARN,
+						persistence.NewMessageMeta(EL_GOV_IMAGE_DIGEST_MISMATCH, serviceInfo.Org, serviceInfo.URL, mismatch),
+						persistence.EC_IMAGE_DIGEST_MISMATCH,
+						"", serviceInfo.URL, "", serviceInfo.Version, "", lc.AgreementIds)
+				}
+				if len(msg.ImageDigests) != 0 {
+					if _, err := persistence.UpdateMSInstanceImageDigests(w.db, lc.Name, msg.ImageDigests); err != nil {
+						glog.Errorf(logString(fmt.Sprintf("unable to record image digests for service instance %v, error %v", lc.Name, err)))
+						eventlog.LogDatabaseEvent(w.db, persistence.SEVERITY_ERROR,
+							persistence.NewMessageMeta(EL_GOV_ERR_SAVE_IMAGE_DIGESTS, lc.Name, err.Error()),
+							persistence.EC_DATABASE_ERROR)
+					}
+				}
 			} else {
 				eventlog.LogServiceEvent2(
 					w.db,
@@ -279,6 +322,9 @@ func (w *GovernanceWorker) NewEvent(incoming events.Message) {
 			case events.IMAGE_LOAD_FAILED:
 				cmd := w.NewUpdateMicroserviceCommand(msg.LaunchContext.Name, false, microservice.MS_IMAGE_LOAD_FAILED, microservice.DecodeReasonCode(microservice.MS_IMAGE_LOAD_FAILED))
 				w.Commands <- cmd
+			case events.HEALTH_CHECK_FAILED:
+				cmd := w.NewUpdateMicroserviceCommand(msg.LaunchContext.Name, false, microservice.MS_HEALTH_CHECK_FAILED, microservice.DecodeReasonCode(microservice.MS_HEALTH_CHECK_FAILED))
+				w.Commands <- cmd
 			}
 
 			cmd := w.NewReportDeviceStatusCommand()
@@ -296,6 +342,15 @@ func (w *GovernanceWorker) NewEvent(incoming events.Message) {
 		cmd := w.NewReportDeviceStatusCommand()
 		w.Commands <- cmd
 
+	case *events.MicroserviceReplacedMessage:
+		msg, _ := incoming.(*events.MicroserviceReplacedMessage)
+
+		switch msg.Event().Id {
+		case events.SERVICE_REPLACED:
+			cmd := w.NewReplaceMicroserviceCommand(msg.OldMsDefId, msg.NewMsDefId)
+			w.Commands <- cmd
+		}
+
 	case *events.NodeShutdownMessage:
 
 		msg, _ := incoming.(*events.NodeShutdownMessage)
@@ -366,6 +421,8 @@ func (w *GovernanceWorker) NewEvent(incoming events.Message) {
 		switch msg.Event().Id {
 		case events.NODE_PATTERN_CHANGE_SHUTDOWN, events.NODE_PATTERN_CHANGE_REREG:
 			w.Commands <- NewNodePatternChangedCommand(msg)
+		case events.NODE_PATTERN_UPDATED:
+			w.devicePattern = msg.Pattern
 		}
 
 	case *events.ExchangeChangeMessage:
@@ -465,7 +522,7 @@ func (w *GovernanceWorker) governAgreements() {
 				// For finalized agreements, make sure the workload has been started in time.
 				if ag.AgreementExecutionStartTime == 0 {
 					// workload not started yet and in an agreement ...
-					if (int64(ag.AgreementAcceptedTime) + (w.Config.Edge.MaxAgreementPrelaunchTimeM * 60)) < time.Now().Unix() {
+					if (int64(ag.AgreementAcceptedTime) + (w.Config.LiveEdge().MaxAgreementPrelaunchTimeM * 60)) < time.Now().Unix() {
 						glog.Infof(logString(fmt.Sprintf("terminating agreement %v because it hasn't been launched in max allowed time. This could be because of a workload failure.", ag.CurrentAgreementId)))
 						reason := w.producerPH[ag.AgreementProtocol].GetTerminationCode(producer.TERM_REASON_NOT_EXECUTED_TIMEOUT)
 						eventlog.LogAgreementEvent(w.db, persistence.SEVERITY_INFO,
@@ -508,14 +565,10 @@ func (w *GovernanceWorker) governAgreements() {
 						glog.V(5).Infof(logString(fmt.Sprintf("TsAndCs: %v", tcPolicy.ShortString())))
 						glog.V(5).Infof(logString(fmt.Sprintf("Merged Policy: %v", mergedPolicy.ShortString())))
 
-						// The proposal for this agreement is no longer compatible with the node's policy, so cancel the agreement.
+						// The proposal for this agreement is no longer compatible with the node's policy, so cancel the agreement
+						// in the next maintenance window.
 						glog.V(3).Infof(logString(fmt.Sprintf("current proposal for %v is out of policy: %v", ag.CurrentAgreementId, err)))
-
-						reason := w.producerPH[ag.AgreementProtocol].GetTerminationCode(producer.TERM_REASON_POLICY_CHANGED)
-						eventlog.LogAgreementEvent(w.db, persistence.SEVERITY_INFO,
-							persistence.NewMessageMeta(EL_GOV_START_TERM_AG_WITH_REASON, ag.RunningWorkload.URL, w.producerPH[ag.AgreementProtocol].GetTerminationReason(reason)),
-							persistence.EC_CANCEL_AGREEMENT_POLICY_CHANGED, ag)
-						w.cancelGovernedAgreement(&ag, reason)
+						w.renegotiateAgreement(&ag, producer.TERM_REASON_POLICY_CHANGED, persistence.EC_CANCEL_AGREEMENT_POLICY_CHANGED)
 
 					} else {
 						glog.V(5).Infof(logString(fmt.Sprintf("agreement %v is still in policy.", ag.CurrentAgreementId)))
@@ -753,11 +806,31 @@ func (w *GovernanceWorker) Initialize() bool {
 	// Fire up the microservice governor
 	w.DispatchSubworker(MICROSERVICE_GOVERNOR, w.governMicroservices, 60, false)
 
+	// check for new versions of the agent, the first time shortly after startup
+	if w.Config.Edge.AgentUpdate.ManifestURL != "" {
+		w.DispatchSubworker(AGENT_UPDATE, w.checkAgentUpdate, 60, false)
+	}
+
+	// promote or roll back the new workload versions whose canaries are soaking
+	w.DispatchSubworker(WORKLOAD_CANARY, w.governWorkloadCanaries, 60, false)
+
+	// run the disruptive actions that were deferred to a maintenance window
+	w.DispatchSubworker(MAINTENANCE, w.runDeferredActions, 60, false)
+
+	// alert when the free disk space of the agent runs low
+	w.DispatchSubworker(DISK_SPACE, w.checkDiskSpace, 60, false)
+
+	// warn when the clock of the node is off the exchange
+	w.DispatchSubworker(CLOCK_SKEW, w.checkClockSkew, 60, false)
+
 	// for the policy case update the exchange with the latest registeredServices
 	if w.devicePattern == "" {
 		w.UpdateRegisteredServicesWithAgreement()
 	}
 
+	// resume the service restarts that were scheduled before anax stopped
+	w.resumeMicroserviceRetries()
+
 	return true
 
 }
@@ -1296,6 +1369,24 @@ func (w *GovernanceWorker) CommandHandler(command worker.Command) bool {
 			w.handleMicroserviceUpgrade(cmd.MsDefId)
 		}
 
+	case *ReplaceMicroserviceCommand:
+		cmd, _ := command.(*ReplaceMicroserviceCommand)
+
+		glog.V(5).Infof(logString(fmt.Sprintf("Clean up the replaced service. %v", cmd)))
+
+		if !w.IsWorkerShuttingDown() {
+			w.handleMicroserviceReplaced(cmd.OldMsDefId, cmd.NewMsDefId)
+		}
+
+	case *RetryMicroserviceCommand:
+		cmd, _ := command.(*RetryMicroserviceCommand)
+
+		glog.V(5).Infof(logString(fmt.Sprintf("Retry service if its restart backoff has passed. %v", cmd)))
+
+		if !w.IsWorkerShuttingDown() {
+			w.handleMicroserviceRetry(cmd.MsInstKey)
+		}
+
 	case *ReportDeviceStatusCommand:
 		cmd, _ := command.(*ReportDeviceStatusCommand)
 
@@ -1468,7 +1559,7 @@ func (w *GovernanceWorker) RecordReply(proposal abstractprotocol.Proposal, proto
 		// get service image auths from the exchange
 		img_auths := make([]events.ImageDockerAuth, 0)
 		if w.deviceType == persistence.DEVICE_TYPE_DEVICE {
-			if w.Config.Edge.TrustDockerAuthFromOrg {
+			if w.Config.LiveEdge().TrustDockerAuthFromOrg {
 				if ias, err := exchange.GetHTTPServiceDockerAuthsHandler(w)(workload.WorkloadURL, workload.Org, workload.Version, workload.Arch); err != nil {
 					return errors.New(logString(fmt.Sprintf("received error querying exchange for service image auths: %v, error %v", workload, err)))
 				} else {
@@ -1526,6 +1617,13 @@ func (w *GovernanceWorker) RecordReply(proposal abstractprotocol.Proposal, proto
 			w.BaseWorker.Manager.Config.GetFileSyncServiceAPIListen(),
 			strconv.Itoa(int(w.BaseWorker.Manager.Config.GetFileSyncServiceAPIPort())))
 
+		// Tell the workload which node and agreement it is running under, and record what it was told.
+		if contextEnvvars := w.addNodeContextEnvvars(envAdds, proposal.AgreementId(), workload.Version); len(contextEnvvars) != 0 {
+			if _, err := persistence.AgreementContextEnvVarsUpdate(w.db, proposal.AgreementId(), protocol, contextEnvvars); err != nil {
+				glog.Errorf(logString(fmt.Sprintf("unable to record the node context env vars of agreement %v, error %v", proposal.AgreementId(), err)))
+			}
+		}
+
 		lc.EnvironmentAdditions = &envAdds
 
 		if w.deviceType == persistence.DEVICE_TYPE_DEVICE {
@@ -1731,6 +1829,17 @@ func (w *GovernanceWorker) GetServicePreference(url string, org string, tcPolicy
 		return nil, fmt.Errorf("Failed to convert attrributes to env map for service %v/%v. Err: %v", org, url, err)
 	}
 
+	// add the address that the node reports as its own, from the preferred interface or CIDR
+	if hostAddress, err := cutil.SelectHostAddress(w.Config.LiveEdge().HostAddress); err != nil {
+		glog.Warningf(logString(fmt.Sprintf("Unable to select the host address for service %v/%v. %v", org, url, err)))
+	} else {
+		glog.V(5).Infof(logString(fmt.Sprintf("Host address for service %v/%v is %v", org, url, hostAddress)))
+		envAdds[config.ENVVAR_PREFIX+"HOST_IP"] = hostAddress.Address
+		if hostAddress.IPv6 != "" {
+			envAdds[config.ENVVAR_PREFIX+"HOST_IPV6"] = hostAddress.IPv6
+		}
+	}
+
 	// add node user input
 	userInput, err := persistence.FindNodeUserInput(w.db)
 	if err != nil {
@@ -1752,6 +1861,41 @@ func (w *GovernanceWorker) GetServicePreference(url string, org string, tcPolicy
 	return envAdds, nil
 }
 
+// Add the node context env vars of a workload or service to its env vars, unless they are disabled by the node
+// configuration. Returns the env vars that were added.
+func (w *GovernanceWorker) addNodeContextEnvvars(envAdds map[string]string, agreementId string, version string) map[string]string {
+	if w.Config.LiveEdge().DisableNodeContextEnvvars {
+		return map[string]string{}
+	}
+
+	ctx := cutil.NodeContext{
+		NodeId:         exchange.GetId(w.GetExchangeId()),
+		Org:            exchange.GetOrg(w.GetExchangeId()),
+		Pattern:        w.devicePattern,
+		AgreementId:    agreementId,
+		ServiceVersion: version,
+		Properties:     make(map[string]string),
+	}
+
+	if nodePol, err := persistence.FindNodePolicy(w.db); err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to read the node policy, the node properties will not be passed to the service containers. %v", err)))
+	} else if nodePol != nil {
+		for _, prop := range nodePol.Properties {
+			if list, ok := prop.Value.([]interface{}); ok {
+				values := make([]string, 0, len(list))
+				for _, v := range list {
+					values = append(values, fmt.Sprintf("%v", v))
+				}
+				ctx.Properties[prop.Name] = strings.Join(values, ",")
+			} else {
+				ctx.Properties[prop.Name] = fmt.Sprintf("%v", prop.Value)
+			}
+		}
+	}
+
+	return cutil.SetNodeContextEnvvars(envAdds, config.ENVVAR_PREFIX, ctx, w.Config.LiveEdge().NodeContextEnvvarsOmit)
+}
+
 func recordProducerAgreementState(httpClient *http.Client, url string, deviceId string, token string, pattern string, agreementId string, pol *policy.Policy, state string) error {
 
 	glog.V(5).Infof(logString(fmt.Sprintf("setting agreement %v state to %v", agreementId, state)))
@@ -1921,12 +2065,12 @@ func (w *GovernanceWorker) agreementRequiresService(ag persistence.EstablishedAg
 	}
 
 	for _, sp := range svcSpecs {
-		if workload.URL == sp.Url && workload.Org == sp.Org {
+		if cutil.SameSpecURL(workload.URL, sp.Url) && workload.Org == sp.Org {
 			return true, nil
 		}
 		if asl != nil {
 			for _, s := range *asl {
-				if s.SpecRef == sp.Url && s.Org == sp.Org {
+				if cutil.SameSpecURL(s.SpecRef, sp.Url) && s.Org == sp.Org {
 					return true, nil
 				}
 
@@ -0,0 +1,128 @@
+package governance
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/eventlog"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"time"
+)
+
+const MAINTENANCE = "Maintenance"
+
+// Returns true if disruptive actions can run now, according to the maintenance schedule of the node. A node without
+// a schedule runs them at any time, and so does a node whose schedule cannot be read, so that they are not held back
+// forever.
+func (w *GovernanceWorker) inMaintenanceWindow() bool {
+	schedule, err := persistence.FindMaintenanceSchedule(w.db)
+	if err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to read the maintenance schedule, error %v", err)))
+		return true
+	}
+	return schedule.InWindow(time.Now())
+}
+
+// Record a disruptive action that waits for the next maintenance window, with the termination reason and the event code
+// of a renegotiation. An action that is already waiting keeps the time it was first deferred.
+func (w *GovernanceWorker) deferAction(kind string, key string, reason string, eventCode string, description string) {
+	if action, err := persistence.FindDeferredAction(w.db, kind, key); err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to read deferred action %v %v, error %v", kind, key, err)))
+		return
+	} else if action != nil {
+		return
+	}
+
+	glog.V(3).Infof(logString(fmt.Sprintf("deferring %v until the next maintenance window", description)))
+
+	action := &persistence.DeferredAction{
+		Kind:         kind,
+		Key:          key,
+		Description:  description,
+		Reason:       reason,
+		EventCode:    eventCode,
+		DeferredTime: uint64(time.Now().Unix()),
+	}
+	if err := persistence.SaveDeferredAction(w.db, action); err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to save deferred action %v, error %v", action, err)))
+		return
+	}
+	w.logMaintenanceEvent(persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_GOV_ACTION_DEFERRED, description), persistence.EC_ACTION_DEFERRED)
+}
+
+// Forget a deferred action, because it ran or is no longer needed.
+func (w *GovernanceWorker) clearDeferredAction(kind string, key string) {
+	if err := persistence.DeleteDeferredAction(w.db, kind, key); err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to delete deferred action %v %v, error %v", kind, key, err)))
+	}
+}
+
+// Cancel an agreement after a change of the node, so that the agbot makes it again according to the change. Outside
+// of the maintenance windows the cancellation is deferred, the agreement keeps running meanwhile.
+func (w *GovernanceWorker) renegotiateAgreement(ag *persistence.EstablishedAgreement, reasonName string, eventCode string) {
+	if !w.inMaintenanceWindow() {
+		w.deferAction(persistence.DEFERRED_RENEGOTIATION, ag.CurrentAgreementId, reasonName, eventCode,
+			fmt.Sprintf("the renegotiation of agreement %v for %v/%v (%v)", ag.CurrentAgreementId, ag.RunningWorkload.Org, ag.RunningWorkload.URL, reasonName))
+		return
+	}
+	w.clearDeferredAction(persistence.DEFERRED_RENEGOTIATION, ag.CurrentAgreementId)
+
+	reason := w.producerPH[ag.AgreementProtocol].GetTerminationCode(reasonName)
+	eventlog.LogAgreementEvent(w.db, persistence.SEVERITY_INFO,
+		persistence.NewMessageMeta(EL_GOV_START_TERM_AG_WITH_REASON, ag.RunningWorkload.URL, w.producerPH[ag.AgreementProtocol].GetTerminationReason(reason)),
+		eventCode, *ag)
+	w.cancelGovernedAgreement(ag, reason)
+}
+
+// Run the deferred actions when a maintenance window is open. The canary promotions are left to the canary governor,
+// which checks the window itself and forgets the promotion when the version is promoted or rolled back.
+func (w *GovernanceWorker) runDeferredActions() int {
+	if !w.inMaintenanceWindow() {
+		return 0
+	}
+
+	actions, err := persistence.FindDeferredActions(w.db)
+	if err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to read the deferred actions, error %v", err)))
+		return 0
+	}
+
+	for _, action := range actions {
+		switch action.Kind {
+		case persistence.DEFERRED_SERVICE_UPGRADE:
+			// the upgrade is checked again, the service may have changed since it was deferred
+			w.clearDeferredAction(action.Kind, action.Key)
+			w.logMaintenanceEvent(persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_GOV_START_DEFERRED_ACTION, action.Description), persistence.EC_START_DEFERRED_ACTION)
+			w.Commands <- w.NewUpgradeMicroserviceCommand(action.Key)
+
+		case persistence.DEFERRED_RENEGOTIATION:
+			ags, err := persistence.FindEstablishedAgreementsAllProtocols(w.db, policy.AllAgreementProtocols(), []persistence.EAFilter{persistence.UnarchivedEAFilter(), persistence.IdEAFilter(action.Key)})
+			if err != nil {
+				glog.Errorf(logString(fmt.Sprintf("unable to read agreement %v, error %v", action.Key, err)))
+				continue
+			}
+			w.clearDeferredAction(action.Kind, action.Key)
+			if len(ags) == 0 || ags[0].AgreementTerminatedTime != 0 {
+				// the agreement ended meanwhile
+				continue
+			}
+			w.logMaintenanceEvent(persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_GOV_START_DEFERRED_ACTION, action.Description), persistence.EC_START_DEFERRED_ACTION)
+
+			// the renegotiations deferred before the event code was recorded are logged as plain cancellations
+			eventCode := action.EventCode
+			if eventCode == "" {
+				eventCode = persistence.EC_CANCEL_AGREEMENT
+			}
+			w.renegotiateAgreement(&ags[0], action.Reason, eventCode)
+		}
+	}
+	return 0
+}
+
+func (w *GovernanceWorker) logMaintenanceEvent(severity string, meta *persistence.MessageMeta, code string) {
+	if dev, err := persistence.FindExchangeDevice(w.db); err != nil || dev == nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to log the maintenance event, the node cannot be read: %v", err)))
+	} else {
+		eventlog.LogNodeEvent(w.db, severity, meta, code, dev.Id, dev.Org, dev.Pattern, dev.Config.State)
+	}
+}
@@ -0,0 +1,116 @@
+// +build unit
+
+package governance
+
+import (
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"github.com/open-horizon/anax/producer"
+	"github.com/open-horizon/anax/worker"
+	"strings"
+	"testing"
+	"time"
+)
+
+func maintenanceTestWorker(t *testing.T) (*GovernanceWorker, func()) {
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Fatalf("unable to set up the db, error %v", err)
+	}
+	return &GovernanceWorker{BaseWorker: worker.NewBaseWorker("test", &config.HorizonConfig{}, nil), db: db}, func() {
+		db.Close()
+		cleanTestDir(dir)
+	}
+}
+
+// Saves a schedule whose only window is in a few days, so that it is closed now.
+func closeMaintenanceWindow(t *testing.T, w *GovernanceWorker) {
+	day := strings.ToLower(time.Now().AddDate(0, 0, 3).Weekday().String()[:3])
+	if err := persistence.SaveMaintenanceSchedule(w.db, &persistence.MaintenanceSchedule{Windows: []string{day + " 02:00-03:00"}}); err != nil {
+		t.Fatalf("unable to save the maintenance schedule, error %v", err)
+	}
+}
+
+// Saves a schedule with a window opened by an operator.
+func openMaintenanceWindow(t *testing.T, w *GovernanceWorker) {
+	closeMaintenanceWindow(t, w)
+	schedule, _ := persistence.FindMaintenanceSchedule(w.db)
+	schedule.OverrideUntil = uint64(time.Now().Unix()) + 3600
+	if err := persistence.SaveMaintenanceSchedule(w.db, schedule); err != nil {
+		t.Fatalf("unable to save the maintenance schedule, error %v", err)
+	}
+}
+
+// Outside of the maintenance windows a renegotiation is deferred with its reason and event code, and keeps the time
+// it was first deferred.
+func Test_renegotiateAgreement_deferred(t *testing.T) {
+	w, cleanup := maintenanceTestWorker(t)
+	defer cleanup()
+	closeMaintenanceWindow(t, w)
+
+	wi := &persistence.WorkloadInfo{URL: canaryURL, Org: canaryOrg, Version: "1.0.0"}
+	ag, err := persistence.NewEstablishedAgreement(w.db, "ag", "ag1", "agbot", "proposal", policy.BasicProtocol, 1, persistence.ServiceSpecs{}, "", "", "", "", "", wi, 0)
+	if err != nil {
+		t.Fatalf("unable to save the agreement, error %v", err)
+	}
+
+	w.renegotiateAgreement(ag, producer.TERM_REASON_POLICY_CHANGED, persistence.EC_CANCEL_AGREEMENT_POLICY_CHANGED)
+	action, err := persistence.FindDeferredAction(w.db, persistence.DEFERRED_RENEGOTIATION, "ag1")
+	if err != nil || action == nil {
+		t.Fatalf("expected the renegotiation to be deferred, got %v, error %v", action, err)
+	} else if action.Reason != producer.TERM_REASON_POLICY_CHANGED || action.EventCode != persistence.EC_CANCEL_AGREEMENT_POLICY_CHANGED {
+		t.Errorf("expected the reason and the event code to be recorded, got %v", action)
+	}
+
+	action.DeferredTime = 1600000000
+	if err := persistence.SaveDeferredAction(w.db, action); err != nil {
+		t.Fatalf("unable to save the deferred action, error %v", err)
+	}
+	w.renegotiateAgreement(ag, producer.TERM_REASON_POLICY_CHANGED, persistence.EC_CANCEL_AGREEMENT_POLICY_CHANGED)
+	if action, _ := persistence.FindDeferredAction(w.db, persistence.DEFERRED_RENEGOTIATION, "ag1"); action == nil || action.DeferredTime != 1600000000 {
+		t.Errorf("expected the action to keep the time it was first deferred, got %v", action)
+	}
+}
+
+// The deferred actions wait while the maintenance window is closed.
+func Test_runDeferredActions_closed(t *testing.T) {
+	w, cleanup := maintenanceTestWorker(t)
+	defer cleanup()
+	closeMaintenanceWindow(t, w)
+
+	w.deferAction(persistence.DEFERRED_SERVICE_UPGRADE, "msdef1", "", "", "the upgrade of service myorg/netspeed")
+	w.runDeferredActions()
+
+	if action, _ := persistence.FindDeferredAction(w.db, persistence.DEFERRED_SERVICE_UPGRADE, "msdef1"); action == nil {
+		t.Errorf("expected the upgrade to still be deferred")
+	} else if len(w.Commands) != 0 {
+		t.Errorf("expected no command, got %v", len(w.Commands))
+	}
+}
+
+// In a maintenance window a deferred upgrade is checked again, and the renegotiation of an agreement that ended is
+// forgotten.
+func Test_runDeferredActions_open(t *testing.T) {
+	w, cleanup := maintenanceTestWorker(t)
+	defer cleanup()
+	closeMaintenanceWindow(t, w)
+
+	w.deferAction(persistence.DEFERRED_SERVICE_UPGRADE, "msdef1", "", "", "the upgrade of service myorg/netspeed")
+	w.deferAction(persistence.DEFERRED_RENEGOTIATION, "ag-ended", producer.TERM_REASON_POLICY_CHANGED, persistence.EC_CANCEL_AGREEMENT_POLICY_CHANGED, "the renegotiation of agreement ag-ended")
+
+	openMaintenanceWindow(t, w)
+	w.runDeferredActions()
+
+	if actions, err := persistence.FindDeferredActions(w.db); err != nil || len(actions) != 0 {
+		t.Errorf("expected the deferred actions to be done, got %v, error %v", actions, err)
+	}
+	select {
+	case cmd := <-w.Commands:
+		if upgrade, ok := cmd.(*UpgradeMicroserviceCommand); !ok || upgrade.MsDefId != "msdef1" {
+			t.Errorf("expected the upgrade of msdef1, got %v", cmd)
+		}
+	default:
+		t.Errorf("expected the upgrade to be checked again")
+	}
+}
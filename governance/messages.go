@@ -33,6 +33,8 @@ const (
 	EL_GOV_IMAGE_LOADED_FOR_SVC    = "Image loaded for service %v/%v."
 	EL_GOV_ERR_LOADING_IMG         = "Error loading image for %v/%v. Reason: %v"
 	EL_GOV_ERR_LOADING_IMG_FOR_SVC = "Error loading image for service %v/%v."
+	EL_GOV_IMAGE_DIGEST_MISMATCH   = "Image digest mismatch for %v/%v, %v."
+	EL_GOV_ERR_SAVE_IMAGE_DIGESTS  = "Error saving image digests for %v in database, error %v"
 
 	// agreement
 	EL_GOV_START_TERM_AG_WITH_REASON    = "Start terminating agreement for %v. Termination reason: %v"
@@ -96,6 +98,8 @@ const (
 	EL_GOV_FAILED_SVC_RETRY           = "Failed retrying number %v for dependent service %v version %v."
 	EL_GOV_ERR_GET_SVC_RETRY_CNT      = "Failed to get the service retry count for %v version %v. %v"
 	EL_GOV_ERR_UPDATE_SVC_RETRY_STATE = "Error updating retry start state for service instance %v in dadabase. %v"
+	EL_GOV_SCHEDULE_SVC_RETRY         = "Dependent service %v version %v failed, restarting it in %v seconds."
+	EL_GOV_SVC_RESTARTS_EXHAUSTED     = "Dependent service %v version %v failed and will not be restarted. Restart policy: %v, restarts: %v."
 
 	// pattern change
 	EL_GOV_EXCH_NODE_PATTERN_CHANGED       = "Node pattern changed on the Exchange from %v to %v."
@@ -106,6 +110,29 @@ const (
 	EL_GOV_ERR_VALIDATE_NEW_PATTERN        = "Error validating new node pattern %v: %v"
 	EL_GOV_NODE_KEEP_OLD_PATTERN           = "The node will keep using the old pattern %v"
 	EL_GOV_NEW_PATTERN_VERIFIED            = "New pattern %v is verified. Will cancel agreements and re-register the node with the new pattern."
+
+	// agent update
+	EL_GOV_AGENT_UPDATE_AVAILABLE = "Agent version %v is available, the running version is %v."
+	EL_GOV_START_AGENT_UPDATE     = "Start updating the agent to version %v."
+	EL_GOV_AGENT_UPDATE_COMPLETE  = "The update command of agent version %v completed, but the agent was not restarted."
+	EL_GOV_ERR_AGENT_UPDATE       = "Error updating the agent to version %v: %v"
+
+	// workload canary rollout
+	EL_GOV_WORKLOAD_CANARY_PROMOTED = "Version %v of service %v/%v is promoted, its %v canary agreements ran for %v seconds without failing."
+	EL_GOV_WORKLOAD_CANARY_FAILED   = "Version %v of service %v/%v is rolled back, canary agreement %v failed: %v"
+
+	// maintenance windows
+	EL_GOV_ACTION_DEFERRED       = "Deferred %v until the next maintenance window."
+	EL_GOV_START_DEFERRED_ACTION = "Start %v in the maintenance window."
+
+	// disk space
+	EL_GOV_DISK_SPACE_LOW       = "Only %v MB of %v MB are free on the partition of the %v at %v, the disk space is %v."
+	EL_GOV_DISK_SPACE_RECOVERED = "%v MB of %v MB are free again on the partition of the %v at %v."
+	EL_GOV_DISK_SPACE_UNKNOWN   = "Unable to read the free space on the partition of the %v at %v, the disk space is not checked. Error: %v"
+
+	// clock skew
+	EL_GOV_CLOCK_SKEW           = "The clock of the node is %.0f seconds off the clock of the exchange, more than %v seconds. %v"
+	EL_GOV_CLOCK_SKEW_RECOVERED = "The clock of the node is %.0f seconds off the clock of the exchange, within %v seconds again."
 )
 
 // This is does nothing useful at run time.
@@ -143,6 +170,8 @@ func MarkI18nMessages() {
 	msgPrinter.Sprintf(EL_GOV_IMAGE_LOADED_FOR_SVC)
 	msgPrinter.Sprintf(EL_GOV_ERR_LOADING_IMG)
 	msgPrinter.Sprintf(EL_GOV_ERR_LOADING_IMG_FOR_SVC)
+	msgPrinter.Sprintf(EL_GOV_IMAGE_DIGEST_MISMATCH)
+	msgPrinter.Sprintf(EL_GOV_ERR_SAVE_IMAGE_DIGESTS)
 
 	// agreement
 	msgPrinter.Sprintf(EL_GOV_START_TERM_AG_WITH_REASON)
@@ -206,6 +235,8 @@ func MarkI18nMessages() {
 	msgPrinter.Sprintf(EL_GOV_FAILED_SVC_RETRY)
 	msgPrinter.Sprintf(EL_GOV_ERR_GET_SVC_RETRY_CNT)
 	msgPrinter.Sprintf(EL_GOV_ERR_UPDATE_SVC_RETRY_STATE)
+	msgPrinter.Sprintf(EL_GOV_SCHEDULE_SVC_RETRY)
+	msgPrinter.Sprintf(EL_GOV_SVC_RESTARTS_EXHAUSTED)
 
 	// pattern change
 	msgPrinter.Sprintf(EL_GOV_EXCH_NODE_PATTERN_CHANGED)
@@ -216,4 +247,17 @@ func MarkI18nMessages() {
 	msgPrinter.Sprintf(EL_GOV_ERR_VALIDATE_NEW_PATTERN)
 	msgPrinter.Sprintf(EL_GOV_NODE_KEEP_OLD_PATTERN)
 	msgPrinter.Sprintf(EL_GOV_NEW_PATTERN_VERIFIED)
+	msgPrinter.Sprintf(EL_GOV_AGENT_UPDATE_AVAILABLE)
+	msgPrinter.Sprintf(EL_GOV_START_AGENT_UPDATE)
+	msgPrinter.Sprintf(EL_GOV_AGENT_UPDATE_COMPLETE)
+	msgPrinter.Sprintf(EL_GOV_ERR_AGENT_UPDATE)
+	msgPrinter.Sprintf(EL_GOV_WORKLOAD_CANARY_PROMOTED)
+	msgPrinter.Sprintf(EL_GOV_WORKLOAD_CANARY_FAILED)
+	msgPrinter.Sprintf(EL_GOV_ACTION_DEFERRED)
+	msgPrinter.Sprintf(EL_GOV_START_DEFERRED_ACTION)
+	msgPrinter.Sprintf(EL_GOV_DISK_SPACE_LOW)
+	msgPrinter.Sprintf(EL_GOV_DISK_SPACE_RECOVERED)
+	msgPrinter.Sprintf(EL_GOV_DISK_SPACE_UNKNOWN)
+	msgPrinter.Sprintf(EL_GOV_CLOCK_SKEW)
+	msgPrinter.Sprintf(EL_GOV_CLOCK_SKEW_RECOVERED)
 }
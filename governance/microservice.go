@@ -85,7 +85,7 @@ func (w *GovernanceWorker) StartMicroservice(ms_key string, agreementId string,
 			if isRetry {
 				mi = msinst_given
 			} else {
-				mi, err1 = persistence.NewMicroserviceInstance(w.db, msdef.SpecRef, msdef.Org, msdef.Version, ms_key, dependencyPath)
+				mi, err1 = persistence.NewMicroserviceInstance(w.db, msdef.SpecRef, msdef.Org, msdef.Version, msdef.Arch, ms_key, dependencyPath)
 				if err1 != nil {
 					return nil, fmt.Errorf(logString(fmt.Sprintf("Error persisting service instance for %v/%v %v %v.", msdef.Org, msdef.SpecRef, msdef.Version, ms_key)))
 				}
@@ -110,7 +110,7 @@ func (w *GovernanceWorker) StartMicroservice(ms_key string, agreementId string,
 			ms_workload.DeploymentUserInfo = ""
 
 			// get microservice/service keys and save it to the user keys.
-			if w.Config.Edge.TrustCertUpdatesFromOrg {
+			if w.Config.LiveEdge().TrustCertUpdatesFromOrg {
 				key_map, err := exchange.GetHTTPObjectSigningKeysHandler(w)(exchange.SERVICE, msdef.SpecRef, msdef.Org, msdef.Version, msdef.Arch)
 				if err != nil {
 					return nil, fmt.Errorf(logString(fmt.Sprintf("received error getting signing keys from the exchange: %v/%v %v %v. %v", msdef.Org, msdef.SpecRef, msdef.Version, msdef.Arch, err)))
@@ -164,7 +164,7 @@ func (w *GovernanceWorker) StartMicroservice(ms_key string, agreementId string,
 			if isRetry {
 				ms_instance = msinst_given
 			} else {
-				ms_instance, err1 = persistence.NewMicroserviceInstance(w.db, msdef.SpecRef, msdef.Org, msdef.Version, ms_key, dependencyPath)
+				ms_instance, err1 = persistence.NewMicroserviceInstance(w.db, msdef.SpecRef, msdef.Org, msdef.Version, msdef.Arch, ms_key, dependencyPath)
 				if err1 != nil {
 					return nil, fmt.Errorf(logString(fmt.Sprintf("Error persisting service instance for %v/%v %v %v.", msdef.Org, msdef.SpecRef, msdef.Version, ms_key)))
 				}
@@ -172,7 +172,7 @@ func (w *GovernanceWorker) StartMicroservice(ms_key string, agreementId string,
 
 			// get the image auth for service (we have to try even for microservice because we do not know if this is ms or svc.)
 			img_auths := make([]events.ImageDockerAuth, 0)
-			if w.Config.Edge.TrustDockerAuthFromOrg {
+			if w.Config.LiveEdge().TrustDockerAuthFromOrg {
 				if ias, err := exchange.GetHTTPServiceDockerAuthsHandler(w)(msdef.SpecRef, msdef.Org, msdef.Version, msdef.Arch); err != nil {
 					glog.V(5).Infof(logString(fmt.Sprintf("received error querying exchange for service image auths: %v/%v version %v, error %v", msdef.Org, msdef.SpecRef, msdef.Version, err)))
 				} else {
@@ -261,6 +261,13 @@ func (w *GovernanceWorker) GetEnvVarsForServiceDepolyment(msdef *persistence.Mic
 	envAdds[config.ENVVAR_PREFIX+"PATTERN"] = w.devicePattern
 	envAdds[config.ENVVAR_PREFIX+"EXCHANGE_URL"] = w.Config.Edge.ExchangeURL
 
+	// Tell the service which node and agreement it is running under, and record what it was told. The record is only
+	// shown in the service status, failing to save it does not stop the service from starting.
+	contextEnvvars := w.addNodeContextEnvvars(envAdds, agreementId, msdef.Version)
+	if _, err := persistence.UpdateMSInstanceContextEnvVars(w.db, msInst.GetKey(), contextEnvvars); err != nil {
+		glog.Errorf(logString(fmt.Sprintf("unable to record the node context env vars of service instance %v, error %v", msInst.GetKey(), err)))
+	}
+
 	// Add in any default variables from the microservice userInputs that havent been overridden
 	for _, ui := range msdef.UserInputs {
 		if ui.DefaultValue != "" {
@@ -358,33 +365,12 @@ func (w *GovernanceWorker) UpgradeMicroservice(msdef *persistence.MicroserviceDe
 	}
 
 	// clean up old microservice
-	var eClearError error
-	var ms_insts []persistence.MicroserviceInstance
-	eClearError = nil
-	if ms_insts, eClearError = persistence.FindMicroserviceInstances(w.db, []persistence.MIFilter{persistence.AllInstancesMIFilter(msdef.SpecRef, msdef.Org, msdef.Version), persistence.UnarchivedMIFilter()}); eClearError != nil {
-		glog.Errorf(logString(fmt.Sprintf("Error retrieving all the service instances from db for %v/%v version %v key %v. %v", msdef.Org, msdef.SpecRef, msdef.Version, msdef.Id, eClearError)))
-	} else if ms_insts != nil && len(ms_insts) > 0 {
-		for _, msi := range ms_insts {
-			if msi.MicroserviceDefId == msdef.Id {
-				cleanup_reason := microservice.MS_DELETED_BY_UPGRADE_PROCESS
-				if !upgrade {
-					cleanup_reason = microservice.MS_DELETED_BY_DOWNGRADE_PROCESS
-				}
-				if eClearError = w.CleanupMicroservice(msdef.SpecRef, msdef.Version, msi.GetKey(), uint(cleanup_reason)); eClearError != nil {
-					glog.Errorf(logString(fmt.Sprintf("Error cleanup service instances %v. %v", msi.GetKey(), eClearError)))
-				}
-			}
-		}
+	cleanup_reason := microservice.MS_DELETED_BY_UPGRADE_PROCESS
+	if !upgrade {
+		cleanup_reason = microservice.MS_DELETED_BY_DOWNGRADE_PROCESS
 	}
-	// update msdef UpgradeAgreementsClearedTime
-	if eClearError != nil {
-		if _, err := persistence.MSDefUpgradeFailed(w.db, new_msdef.Id, microservice.MS_CLEAR_OLD_AGS_FAILED, microservice.DecodeReasonCode(microservice.MS_CLEAR_OLD_AGS_FAILED)); err != nil {
-			return fmt.Errorf(logString(fmt.Sprintf("Failed to update the UpgradeAgreementsClearedTime for service def %v/%v version %v id %v. %v", new_msdef.Org, new_msdef.SpecRef, new_msdef.Version, new_msdef.Id, err)))
-		}
-	} else {
-		if _, err := persistence.MsDefUpgradeAgreementsCleared(w.db, new_msdef.Id); err != nil {
-			return fmt.Errorf(logString(fmt.Sprintf("Failed to update the UpgradeAgreementsClearedTime for service def %v/%v version %v id %v. %v", new_msdef.Org, new_msdef.SpecRef, new_msdef.Version, new_msdef.Id, err)))
-		}
+	if err := w.cleanupMicroserviceDefInstances(msdef, new_msdef, uint(cleanup_reason)); err != nil {
+		return err
 	}
 
 	// unregister the old ms from exchange
@@ -433,6 +419,52 @@ func (w *GovernanceWorker) UpgradeMicroservice(msdef *persistence.MicroserviceDe
 	return nil
 }
 
+// Clean up the instances of the old service definition, which end their agreements, and record the outcome on the new
+// service definition that replaces it.
+func (w *GovernanceWorker) cleanupMicroserviceDefInstances(msdef *persistence.MicroserviceDefinition, new_msdef *persistence.MicroserviceDefinition, cleanup_reason uint) error {
+	var eClearError error
+	var ms_insts []persistence.MicroserviceInstance
+	if ms_insts, eClearError = persistence.FindMicroserviceInstances(w.db, []persistence.MIFilter{persistence.AllInstancesMIFilter(msdef.SpecRef, msdef.Org, msdef.Version), persistence.UnarchivedMIFilter()}); eClearError != nil {
+		glog.Errorf(logString(fmt.Sprintf("Error retrieving all the service instances from db for %v/%v version %v key %v. %v", msdef.Org, msdef.SpecRef, msdef.Version, msdef.Id, eClearError)))
+	} else if ms_insts != nil && len(ms_insts) > 0 {
+		for _, msi := range ms_insts {
+			if msi.MicroserviceDefId == msdef.Id {
+				if eClearError = w.CleanupMicroservice(msdef.SpecRef, msdef.Version, msi.GetKey(), cleanup_reason); eClearError != nil {
+					glog.Errorf(logString(fmt.Sprintf("Error cleanup service instances %v. %v", msi.GetKey(), eClearError)))
+				}
+			}
+		}
+	}
+	// update msdef UpgradeAgreementsClearedTime
+	if eClearError != nil {
+		if _, err := persistence.MSDefUpgradeFailed(w.db, new_msdef.Id, microservice.MS_CLEAR_OLD_AGS_FAILED, microservice.DecodeReasonCode(microservice.MS_CLEAR_OLD_AGS_FAILED)); err != nil {
+			return fmt.Errorf(logString(fmt.Sprintf("Failed to update the UpgradeAgreementsClearedTime for service def %v/%v version %v id %v. %v", new_msdef.Org, new_msdef.SpecRef, new_msdef.Version, new_msdef.Id, err)))
+		}
+	} else {
+		if _, err := persistence.MsDefUpgradeAgreementsCleared(w.db, new_msdef.Id); err != nil {
+			return fmt.Errorf(logString(fmt.Sprintf("Failed to update the UpgradeAgreementsClearedTime for service def %v/%v version %v id %v. %v", new_msdef.Org, new_msdef.SpecRef, new_msdef.Version, new_msdef.Id, err)))
+		}
+	}
+	return nil
+}
+
+// The service definition was replaced by another version of the service outside of the service upgrade, e.g. by the
+// autoconfig of the node's pattern, which archived it and generated the policy of the new version. The containers and
+// agreements of the old version are ended, the new version makes its own agreements.
+func (w *GovernanceWorker) handleMicroserviceReplaced(old_msdef_id string, new_msdef_id string) {
+	glog.V(3).Infof(logString(fmt.Sprintf("handling the replacement of service id %v by %v", old_msdef_id, new_msdef_id)))
+	if msdef, err := persistence.FindMicroserviceDefWithKey(w.db, old_msdef_id); err != nil || msdef == nil {
+		glog.Errorf(logString(fmt.Sprintf("error getting service definition %v from db. %v", old_msdef_id, err)))
+	} else if new_msdef, err := persistence.FindMicroserviceDefWithKey(w.db, new_msdef_id); err != nil || new_msdef == nil {
+		glog.Errorf(logString(fmt.Sprintf("error getting service definition %v from db. %v", new_msdef_id, err)))
+	} else if !msdef.Archived || new_msdef.Archived {
+		// the replacement was undone
+		glog.V(3).Infof(logString(fmt.Sprintf("service %v/%v version %v is no longer replaced by version %v", msdef.Org, msdef.SpecRef, msdef.Version, new_msdef.Version)))
+	} else if err := w.cleanupMicroserviceDefInstances(msdef, new_msdef, microservice.MS_DELETED_BY_UPGRADE_PROCESS); err != nil {
+		glog.Errorf(logString(fmt.Sprintf("Error cleaning up service %v/%v version %v replaced by version %v. %v", msdef.Org, msdef.SpecRef, msdef.Version, new_msdef.Version, err)))
+	}
+}
+
 // This function will call StartMicroservice to restart all the containers for the given service instance.
 // The process will eventually trigger image loading (just in case the imgges are gone on the node), old container cleaning and
 // new container brought up.
@@ -651,8 +683,8 @@ func (w *GovernanceWorker) handleMicroserviceInstForAgEnded(agreementId string,
 // If there are mutiple agreements associated with the depenent service, the retry count is the average of all the
 // non-zero retry counts. The default retry count is 1 if all the areements have 0 retry counts.
 func (w *GovernanceWorker) getMicroserviceRetryCount(msi *persistence.MicroserviceInstance) (uint, uint, error) {
-	retry_count := w.Config.Edge.DefaultServiceRetryCount
-	retry_duration := uint(w.Config.Edge.DefaultServiceRetryDuration)
+	retry_count := w.Config.LiveEdge().DefaultServiceRetryCount
+	retry_duration := uint(w.Config.LiveEdge().DefaultServiceRetryDuration)
 
 	if ags, err := w.FindEstablishedAgreementsWithIds(msi.AssociatedAgreements); err != nil {
 		return 0, 0, fmt.Errorf(logString(fmt.Sprintf("unable to retrieve agreements %v from database, error %v", msi.AssociatedAgreements, err)))
@@ -691,8 +723,22 @@ func (w *GovernanceWorker) getMicroserviceRetryCount(msi *persistence.Microservi
 	return uint(retry_count), retry_duration, nil
 }
 
+// Returns the number of seconds to wait before the given retry of a failed service. The wait starts at the base backoff
+// and doubles on each retry, up to the max backoff.
+func restartBackoff(retry_count uint, base_backoff uint, max_backoff uint) uint {
+	backoff := base_backoff
+	for i := uint(1); i < retry_count && backoff < max_backoff; i++ {
+		backoff = backoff * 2
+	}
+	if backoff > max_backoff {
+		backoff = max_backoff
+	}
+	return backoff
+}
+
 // This is the case where the agreement is made but the dependent service containers fail.
-// This function will retry the dependent service containers. If the retry fails it will try with a lower version.
+// This function will schedule a restart of the dependent service containers according to the service's restart policy.
+// When the restart policy does not allow any more restarts, it will try with a lower version.
 func (w *GovernanceWorker) handleMicroserviceExecFailure(msdef *persistence.MicroserviceDefinition, msinst_key string) {
 	glog.V(3).Infof(logString(fmt.Sprintf("handle dependent service execution failure for %v", msinst_key)))
 
@@ -707,57 +753,95 @@ func (w *GovernanceWorker) handleMicroserviceExecFailure(msdef *persistence.Micr
 		return
 	}
 
+	// the failure is already being handled by a scheduled restart
+	if msi.NextRetryTime != 0 {
+		glog.V(5).Infof(logString(fmt.Sprintf("restart of service instance %v is already scheduled, ignoring the failure.", msinst_key)))
+		return
+	}
+
+	restart_policy, policy_retries, err := persistence.GetServiceRestartPolicy(w.db, msi.SpecRef, msi.Org, w.Config.GetServiceRestartPolicy())
+	if err != nil {
+		glog.Errorf(logString(fmt.Sprintf("error getting the restart policy for service instance %v, using the node default. %v", msinst_key, err)))
+		restart_policy, policy_retries = w.Config.GetServiceRestartPolicy(), 0
+	}
+
 	timeNow := uint64(time.Now().Unix())
-	if msi.RetryStartTime != 0 {
-		if timeNow-msi.RetryStartTime <= uint64(msi.MaxRetryDuration) && msi.CurrentRetryCount < msi.MaxRetries {
-			need_retry = true
+	if restart_policy != config.SERVICE_RESTART_POLICY_NO {
+		if msi.RetryStartTime != 0 {
+			if restart_policy == config.SERVICE_RESTART_POLICY_ALWAYS {
+				need_retry = true
+			} else if timeNow-msi.RetryStartTime <= uint64(msi.MaxRetryDuration) && msi.CurrentRetryCount < msi.MaxRetries {
+				need_retry = true
+			}
 		}
-	}
 
-	// new retry cycle. getting the retry count again because
-	// there may be new agreements associated with this service instance after last retry cycle
-	if msi.RetryStartTime == 0 || timeNow-msi.RetryStartTime > uint64(msi.MaxRetryDuration) {
-		need_retry = true
-		retries, retry_duration, err := w.getMicroserviceRetryCount(msi)
-		if err != nil {
-			eventlog.LogServiceEvent2(w.db, persistence.SEVERITY_ERROR,
-				persistence.NewMessageMeta(EL_GOV_ERR_GET_SVC_RETRY_CNT, msdef.SpecRef, msdef.Version, err.Error()),
-				persistence.EC_START_DOWNGRADE_SERVICE,
-				msinst_key, msdef.SpecRef, msdef.Org, msdef.Version, msdef.Arch, []string{})
+		// new retry cycle. getting the retry count again because
+		// there may be new agreements associated with this service instance after last retry cycle
+		if msi.RetryStartTime == 0 || timeNow-msi.RetryStartTime > uint64(msi.MaxRetryDuration) {
+			need_retry = true
+			retries, retry_duration, err := w.getMicroserviceRetryCount(msi)
+			if err != nil {
+				eventlog.LogServiceEvent2(w.db, persistence.SEVERITY_ERROR,
+					persistence.NewMessageMeta(EL_GOV_ERR_GET_SVC_RETRY_CNT, msdef.SpecRef, msdef.Version, err.Error()),
+					persistence.EC_START_DOWNGRADE_SERVICE,
+					msinst_key, msdef.SpecRef, msdef.Org, msdef.Version, msdef.Arch, []string{})
 
-			glog.Errorf(logString(fmt.Sprintf("Failed to get the retry counts for failed dependent service instance %v. %v", msinst_key, err)))
-			return
+				glog.Errorf(logString(fmt.Sprintf("Failed to get the retry counts for failed dependent service instance %v. %v", msinst_key, err)))
+				return
+			}
+
+			// the restart policy attribute overrides the retry count of the service
+			if restart_policy == config.SERVICE_RESTART_POLICY_ON_FAILURE && policy_retries != 0 {
+				retries = policy_retries
+			}
+
+			var err1 error
+			msi, err1 = persistence.UpdateMSInstanceRetryState(w.db, msinst_key, true, retries, retry_duration)
+			if err1 != nil {
+				eventlog.LogDatabaseEvent(w.db, persistence.SEVERITY_ERROR,
+					persistence.NewMessageMeta(EL_GOV_ERR_UPDATE_SVC_RETRY_STATE, msinst_key, err1.Error()),
+					persistence.EC_DATABASE_ERROR)
+				glog.Errorf(logString(fmt.Sprintf("error updating retry start state for service instance %v in db. %v", msinst_key, err1)))
+				return
+			}
 		}
+	}
 
-		var err1 error
-		msi, err1 = persistence.UpdateMSInstanceRetryState(w.db, msinst_key, true, retries, retry_duration)
-		if err1 != nil {
+	if need_retry {
+		// schedule the retry, the wait doubles with each retry so that a crashing service does not restart in a tight loop
+		live := w.Config.LiveEdge()
+		backoff := restartBackoff(msi.CurrentRetryCount, uint(live.ServiceRestartBackoffS), uint(live.ServiceRestartMaxBackoffS))
+		if _, err := persistence.UpdateMSInstanceRestartState(w.db, msinst_key, restart_policy, backoff, timeNow+uint64(backoff)); err != nil {
 			eventlog.LogDatabaseEvent(w.db, persistence.SEVERITY_ERROR,
-				persistence.NewMessageMeta(EL_GOV_ERR_UPDATE_SVC_RETRY_STATE, msinst_key, err1.Error()),
+				persistence.NewMessageMeta(EL_GOV_ERR_UPDATE_SVC_RETRY_STATE, msinst_key, err.Error()),
 				persistence.EC_DATABASE_ERROR)
-			glog.Errorf(logString(fmt.Sprintf("error updating retry start state for service instance %v in db. %v", msinst_key, err1)))
+			glog.Errorf(logString(fmt.Sprintf("error updating restart state for service instance %v in db. %v", msinst_key, err)))
 			return
 		}
-	}
 
-	if need_retry {
-		current_retry := msi.CurrentRetryCount + 1
-		// start the retry
 		eventlog.LogServiceEvent2(w.db, persistence.SEVERITY_INFO,
-			persistence.NewMessageMeta(EL_GOV_START_SVC_RETRY, string(current_retry), msdef.SpecRef, msdef.Version),
-			persistence.EC_START_RETRY_DEPENDENT_SERVICE,
+			persistence.NewMessageMeta(EL_GOV_SCHEDULE_SVC_RETRY, msdef.SpecRef, msdef.Version, backoff),
+			persistence.EC_SCHEDULE_RETRY_DEPENDENT_SERVICE,
 			msinst_key, msdef.SpecRef, msdef.Org, msdef.Version, msdef.Arch, []string{})
 
-		if err := w.RetryMicroservice(msi); err != nil {
-			eventlog.LogServiceEvent2(w.db, persistence.SEVERITY_ERROR,
-				persistence.NewMessageMeta(EL_GOV_FAILED_SVC_RETRY, string(current_retry), msdef.SpecRef, msdef.Version),
-				persistence.EC_ERROR_START_RETRY_DEPENDENT_SERVICE,
-				msinst_key, msdef.SpecRef, msdef.Org, msdef.Version, msdef.Arch, []string{})
-			glog.Errorf(logString(fmt.Sprintf("error retrying number %v for failed dependent service %v.", msinst_key, err)))
-			// recursive call to do next retry
-			w.handleMicroserviceExecFailure(msdef, msinst_key)
-		}
+		w.AddDeferredCommand(w.NewRetryMicroserviceCommand(msinst_key))
 	} else {
+		// the restart policy does not allow more restarts, mark the service instance failed
+		if _, err := persistence.UpdateMSInstanceRestartState(w.db, msinst_key, restart_policy, msi.RetryBackoffS, 0); err != nil {
+			glog.Errorf(logString(fmt.Sprintf("error updating restart state for service instance %v in db. %v", msinst_key, err)))
+		} else if _, err := persistence.UpdateMSInstanceExecutionState(w.db, msinst_key, false, microservice.MS_RESTARTS_EXHAUSTED, microservice.DecodeReasonCode(microservice.MS_RESTARTS_EXHAUSTED)); err != nil {
+			glog.Errorf(logString(fmt.Sprintf("error updating execution state for service instance %v in db. %v", msinst_key, err)))
+		}
+
+		restarts := uint(0)
+		if msi.CurrentRetryCount > 1 {
+			restarts = msi.CurrentRetryCount - 1 // the original execution is counted as the first one.
+		}
+		eventlog.LogServiceEvent2(w.db, persistence.SEVERITY_ERROR,
+			persistence.NewMessageMeta(EL_GOV_SVC_RESTARTS_EXHAUSTED, msdef.SpecRef, msdef.Version, restart_policy, restarts),
+			persistence.EC_DEPENDENT_SERVICE_RESTARTS_EXHAUSTED,
+			msinst_key, msdef.SpecRef, msdef.Org, msdef.Version, msdef.Arch, []string{})
+
 		// rollback the microservice to lower version
 		// a new ms instance will be created if successful
 		eventlog.LogServiceEvent2(w.db, persistence.SEVERITY_INFO,
@@ -783,6 +867,67 @@ func (w *GovernanceWorker) handleMicroserviceExecFailure(msdef *persistence.Micr
 	}
 }
 
+// Restart the failed dependent service once the restart backoff has passed. If the restart fails, the failure is
+// handled again, which schedules the next restart.
+func (w *GovernanceWorker) handleMicroserviceRetry(msinst_key string) {
+	msi, err := persistence.FindMicroserviceInstanceWithKey(w.db, msinst_key)
+	if err != nil {
+		glog.Errorf(logString(fmt.Sprintf("error getting service instance %v from db. %v", msinst_key, err)))
+		return
+	} else if msi == nil || msi.Archived || msi.CleanupStartTime != 0 || msi.NextRetryTime == 0 {
+		glog.V(5).Infof(logString(fmt.Sprintf("service instance %v is no longer waiting to be restarted.", msinst_key)))
+		return
+	} else if uint64(time.Now().Unix()) < msi.NextRetryTime {
+		w.AddDeferredCommand(w.NewRetryMicroserviceCommand(msinst_key))
+		return
+	}
+
+	msdef, err := persistence.FindMicroserviceDefWithKey(w.db, msi.MicroserviceDefId)
+	if err != nil || msdef == nil {
+		glog.Errorf(logString(fmt.Sprintf("error getting service definition %v for service instance %v from db. %v", msi.MicroserviceDefId, msinst_key, err)))
+		return
+	}
+
+	if msi, err = persistence.UpdateMSInstanceRestartState(w.db, msinst_key, msi.RestartPolicy, msi.RetryBackoffS, 0); err != nil {
+		eventlog.LogDatabaseEvent(w.db, persistence.SEVERITY_ERROR,
+			persistence.NewMessageMeta(EL_GOV_ERR_UPDATE_SVC_RETRY_STATE, msinst_key, err.Error()),
+			persistence.EC_DATABASE_ERROR)
+		glog.Errorf(logString(fmt.Sprintf("error updating restart state for service instance %v in db. %v", msinst_key, err)))
+		return
+	}
+
+	current_retry := msi.CurrentRetryCount + 1
+	// start the retry
+	eventlog.LogServiceEvent2(w.db, persistence.SEVERITY_INFO,
+		persistence.NewMessageMeta(EL_GOV_START_SVC_RETRY, current_retry, msdef.SpecRef, msdef.Version),
+		persistence.EC_START_RETRY_DEPENDENT_SERVICE,
+		msinst_key, msdef.SpecRef, msdef.Org, msdef.Version, msdef.Arch, []string{})
+
+	if err := w.RetryMicroservice(msi); err != nil {
+		eventlog.LogServiceEvent2(w.db, persistence.SEVERITY_ERROR,
+			persistence.NewMessageMeta(EL_GOV_FAILED_SVC_RETRY, current_retry, msdef.SpecRef, msdef.Version),
+			persistence.EC_ERROR_START_RETRY_DEPENDENT_SERVICE,
+			msinst_key, msdef.SpecRef, msdef.Org, msdef.Version, msdef.Arch, []string{})
+		glog.Errorf(logString(fmt.Sprintf("error retrying number %v for failed dependent service %v. %v", current_retry, msinst_key, err)))
+		// schedule the next retry
+		w.handleMicroserviceExecFailure(msdef, msinst_key)
+	}
+}
+
+// Queue the restarts of the failed dependent services that were scheduled but had not started when anax stopped.
+func (w *GovernanceWorker) resumeMicroserviceRetries() {
+	if msInsts, err := persistence.FindMicroserviceInstances(w.db, []persistence.MIFilter{persistence.UnarchivedMIFilter()}); err != nil {
+		glog.Errorf(logString(fmt.Sprintf("error getting service instances from db. %v", err)))
+	} else {
+		for _, msi := range msInsts {
+			if msi.NextRetryTime != 0 {
+				glog.V(3).Infof(logString(fmt.Sprintf("resuming the scheduled restart of service instance %v", msi.GetKey())))
+				w.AddDeferredCommand(w.NewRetryMicroserviceCommand(msi.GetKey()))
+			}
+		}
+	}
+}
+
 // Given a microservice id and check if it is set for upgrade, if yes do the upgrade
 func (w *GovernanceWorker) handleMicroserviceUpgrade(msdef_id string) {
 	glog.V(3).Infof(logString(fmt.Sprintf("handling service upgrade for service id %v", msdef_id)))
@@ -797,7 +942,11 @@ func (w *GovernanceWorker) handleMicroserviceUpgrade(msdef_id string) {
 			glog.Errorf(logString(fmt.Sprintf("Error finding the new service definition to upgrade to for %v/%v version %v. %v", msdef.Org, msdef.SpecRef, msdef.Version, err)))
 		} else if new_msdef == nil {
 			glog.V(5).Infof(logString(fmt.Sprintf("No changes for service definition %v/%v, no need to upgrade.", msdef.Org, msdef.SpecRef)))
+		} else if !w.inMaintenanceWindow() {
+			w.deferAction(persistence.DEFERRED_SERVICE_UPGRADE, msdef.Id, "", "",
+				fmt.Sprintf("the upgrade of service %v/%v from version %v to %v", msdef.Org, msdef.SpecRef, msdef.Version, new_msdef.Version))
 		} else {
+			w.clearDeferredAction(persistence.DEFERRED_SERVICE_UPGRADE, msdef.Id)
 			eventlog.LogServiceEvent2(w.db, persistence.SEVERITY_INFO,
 				persistence.NewMessageMeta(EL_GOV_START_UPGRADE, msdef.Org, msdef.SpecRef, msdef.Version, new_msdef.Version),
 				persistence.EC_START_UPGRADE_SERVICE,
@@ -844,7 +993,7 @@ func (w *GovernanceWorker) handleServiceSuspended(service_cs []events.ServiceCon
 	orgUrlMIFilter := func() persistence.MIFilter {
 		return func(e persistence.MicroserviceInstance) bool {
 			for _, s := range service_cs {
-				if e.SpecRef == s.Url && e.Org == s.Org {
+				if cutil.SameSpecURL(e.SpecRef, s.Url) && e.Org == s.Org {
 					return true
 				}
 			}
@@ -868,7 +1017,7 @@ func (w *GovernanceWorker) handleServiceSuspended(service_cs []events.ServiceCon
 	} else if establishedAgreements != nil && len(establishedAgreements) > 0 {
 		for _, ag := range establishedAgreements {
 			for _, s := range service_cs {
-				if ag.RunningWorkload.URL == s.Url && ag.RunningWorkload.Org == s.Org {
+				if cutil.SameSpecURL(ag.RunningWorkload.URL, s.Url) && ag.RunningWorkload.Org == s.Org {
 					agreements_to_cancel[ag.CurrentAgreementId] = ag
 					break
 				}
@@ -73,3 +73,13 @@ func Test_composeNewRegisteredServices(t *testing.T) {
 	assert.False(t, isSame, "The elements should not be the same.")
 	assert.True(t, len(newRS) == 4, "The number of the elements should be 4")
 }
+
+func Test_restartBackoff(t *testing.T) {
+	assert.Equal(t, uint(10), restartBackoff(0, 10, 600), "the first retry waits the base backoff")
+	assert.Equal(t, uint(10), restartBackoff(1, 10, 600), "the first retry waits the base backoff")
+	assert.Equal(t, uint(20), restartBackoff(2, 10, 600), "the backoff doubles on each retry")
+	assert.Equal(t, uint(80), restartBackoff(4, 10, 600), "the backoff doubles on each retry")
+	assert.Equal(t, uint(600), restartBackoff(8, 10, 600), "the backoff is capped at the max")
+	assert.Equal(t, uint(600), restartBackoff(1000, 10, 600), "the backoff is capped at the max")
+	assert.Equal(t, uint(0), restartBackoff(5, 0, 600), "a zero base backoff retries right away")
+}
@@ -1,6 +1,7 @@
 package governance
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/boltdb/bolt"
@@ -103,22 +104,7 @@ func (w *GovernanceWorker) handleNodeUserInputUpdated(svcSpecs persistence.Servi
 
 			if bCancel {
 				glog.V(3).Infof(logString(fmt.Sprintf("ending the agreement: %v", agreementId)))
-
-				reason := w.producerPH[ag.AgreementProtocol].GetTerminationCode(producer.TERM_REASON_NODE_USERINPUT_CHANGED)
-
-				eventlog.LogAgreementEvent(
-					w.db,
-					persistence.SEVERITY_INFO,
-					persistence.NewMessageMeta(EL_GOV_START_TERM_AG_WITH_REASON, ag.RunningWorkload.URL, w.producerPH[ag.AgreementProtocol].GetTerminationReason(reason)),
-					persistence.EC_CANCEL_AGREEMENT,
-					ag)
-
-				w.cancelAgreement(agreementId, ag.AgreementProtocol, reason, w.producerPH[ag.AgreementProtocol].GetTerminationReason(reason))
-
-				// send the event to the container in case it has started the workloads.
-				w.Messages() <- events.NewGovernanceWorkloadCancelationMessage(events.AGREEMENT_ENDED, events.AG_TERMINATED, ag.AgreementProtocol, agreementId, ag.GetDeploymentConfig())
-				// clean up microservice instances if needed
-				w.handleMicroserviceInstForAgEnded(agreementId, false)
+				w.renegotiateAgreement(&ag, producer.TERM_REASON_NODE_USERINPUT_CHANGED, persistence.EC_CANCEL_AGREEMENT)
 			}
 		}
 	}
@@ -128,6 +114,9 @@ func (w *GovernanceWorker) handleNodeUserInputUpdated(svcSpecs persistence.Servi
 func (w *GovernanceWorker) handleNodeExchPatternChanged(shutdown bool, new_pattern string) {
 	glog.V(5).Infof(logString(fmt.Sprintf("handling node pattern changes")))
 
+	// The patterns and services cached while configuring the node are for the old pattern.
+	exchange.DeletePatternCache()
+
 	pDevice, err := persistence.FindExchangeDevice(w.db)
 	if err != nil {
 		glog.Errorf(logString(fmt.Sprintf("error getting device from the local database. %v", err)))
@@ -202,6 +191,7 @@ func (w *GovernanceWorker) handleNodeExchPatternChanged(shutdown bool, new_patte
 					persistence.EC_DATABASE_ERROR)
 				return
 			}
+			w.Messages() <- events.NewConfigstateChangedMessage(events.CONFIGSTATE_CHANGED, pDevice.Config.State, persistence.CONFIGSTATE_UNCONFIGURING, pDevice.Id, pDevice.Org, pDevice.Pattern)
 			// set the node shutdown message
 			w.Messages() <- events.NewNodeShutdownMessage(events.START_UNCONFIGURE, false, false)
 		}
@@ -255,17 +245,23 @@ func (w *GovernanceWorker) changeNodePattern(dev *persistence.ExchangeDevice, ne
 	if _, err := dev.SetConfigstate(w.db, dev.Id, persistence.CONFIGSTATE_CONFIGURING); err != nil {
 		return errors.New(logString(fmt.Sprintf("unable to update the config state to CONFIGSTATE_UNCONFIGURED for horizon device, error: %v", err)))
 	} else {
+		w.Messages() <- events.NewConfigstateChangedMessage(events.CONFIGSTATE_CHANGED, dev.Config.State, persistence.CONFIGSTATE_CONFIGURING, dev.Id, dev.Org, dev.Pattern)
 		dev.Config.State = persistence.CONFIGSTATE_CONFIGURING
 	}
 
 	// send out a node registered message
 	// w.Messages() <- events.NewEdgeRegisteredExchangeMessage(events.NEW_DEVICE_REG, dev.Id, dev.Token, dev.Org, new_pattern)
 
+	// The services are removed again if the exchange does not respond in time, its requests are then cancelled.
+	ctx, cancel := api.NewConfigstateContext(context.Background(), w.Config)
+	defer cancel()
+	ec := exchange.WithContext(ctx, w)
+
 	//set node config state to
-	patternHandler := exchange.GetHTTPExchangePatternHandler(w)
-	serviceResolver := exchange.GetHTTPServiceDefResolverHandler(w)
-	getService := exchange.GetHTTPServiceHandler(w)
-	getDevice := exchange.GetHTTPDeviceHandler(w)
+	patternHandler := exchange.GetHTTPExchangePatternHandler(ec)
+	serviceResolver := exchange.GetHTTPServiceDefResolverHandler(ec)
+	getService := exchange.GetHTTPServiceHandler(ec)
+	getDevice := exchange.GetHTTPDeviceHandler(ec)
 	patchDevice := exchange.GetHTTPPatchDeviceHandler(w)
 
 	error_handler := func(err error) bool {
@@ -282,7 +278,7 @@ func (w *GovernanceWorker) changeNodePattern(dev *persistence.ExchangeDevice, ne
 	configState := api.Configstate{State: &state}
 
 	// Validate and update the config state.
-	_, _, msgs := api.UpdateConfigstate(&configState, error_handler, patternHandler, serviceResolver, getService, getDevice, patchDevice, w.db, w.Config)
+	_, _, msgs := api.UpdateConfigstate(ctx, &configState, error_handler, patternHandler, serviceResolver, getService, getDevice, patchDevice, w.db, w.Config)
 
 	// Send out all messages
 	for _, msg := range msgs {
@@ -353,13 +349,13 @@ func ValidateNewPattern(nodeType string, new_pattern string,
 
 	// For each top-level service in the pattern, resolve it to a list of required services.
 	completeAPISpecList := new(policy.APISpecList)
-	thisArch := cutil.ArchString()
+	archs := cutil.SupportedArchs(config)
 
 	for _, service := range patternDef.Services {
 
-		// Ignore top-level services that don't match this node's hardware architecture.
-		if service.ServiceArch != thisArch && config.ArchSynonyms.GetCanonicalArch(service.ServiceArch) != thisArch {
-			glog.Infof(logString(fmt.Sprintf("skipping service because it is for a different hardware architecture, this node is %v. Skipped service is: %v", thisArch, service.ServiceArch)))
+		// Ignore top-level services that don't match the hardware architectures this node supports.
+		if !cutil.ArchSupported(config, service.ServiceArch) {
+			glog.Infof(logString(fmt.Sprintf("skipping service because it is for a different hardware architecture, this node supports %v. Skipped service is: %v", archs, service.ServiceArch)))
 			continue
 		}
 
@@ -369,7 +365,7 @@ func ValidateNewPattern(nodeType string, new_pattern string,
 
 			apiSpecList, serviceDef, _, err := serviceResolver(service.ServiceURL, service.ServiceOrg, serviceChoice.Version, service.ServiceArch)
 			if err != nil {
-				return fmt.Errorf("Error resolving service %v/%v %v %v, error %v", service.ServiceOrg, service.ServiceURL, serviceChoice.Version, thisArch, err)
+				return fmt.Errorf("Error resolving service %v/%v %v %v, error %v", service.ServiceOrg, service.ServiceURL, serviceChoice.Version, service.ServiceArch, err)
 			}
 
 			// ignore the services that do not match the node type
@@ -386,8 +382,8 @@ func ValidateNewPattern(nodeType string, new_pattern string,
 			// Look for inconsistencies in the hardware architecture of the list of dependencies.
 			if apiSpecList != nil {
 				for _, apiSpec := range *apiSpecList {
-					if apiSpec.Arch != thisArch && config.ArchSynonyms.GetCanonicalArch(apiSpec.Arch) != thisArch {
-						return fmt.Errorf("The referenced service %v by service %v/%v has a hardware architecture that is not supported by this node: %v.", apiSpec, service.ServiceOrg, service.ServiceURL, thisArch)
+					if !cutil.ArchSupported(config, apiSpec.Arch) {
+						return fmt.Errorf("The referenced service %v by service %v/%v has a hardware architecture that is not supported by this node: %v.", apiSpec, service.ServiceOrg, service.ServiceURL, archs)
 					}
 				}
 
@@ -404,7 +400,7 @@ func ValidateNewPattern(nodeType string, new_pattern string,
 		// for now, anax only allow one service version, so we need to get the common version range for each service.
 		common_apispec_list, err := completeAPISpecList.GetCommonVersionRanges()
 		if err != nil {
-			return fmt.Errorf("Error resolving the common version ranges for the referenced services for %v %v. %v", pat, thisArch, err)
+			return fmt.Errorf("Error resolving the common version ranges for the referenced services for %v %v. %v", pat, archs, err)
 		}
 
 		// Checking user input for dependent services
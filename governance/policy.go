@@ -3,8 +3,6 @@ package governance
 import (
 	"fmt"
 	"github.com/golang/glog"
-	"github.com/open-horizon/anax/eventlog"
-	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/microservice"
 	"github.com/open-horizon/anax/persistence"
@@ -43,7 +41,7 @@ func (w *GovernanceWorker) handleUpdatePolicy(cmd *UpdatePolicyCommand) {
 }
 
 // When node policy gets updated or deleted, all the agreements wil need
-// to be canceled so that new negotiation can start
+// to be canceled so that new negotiation can start, in the next maintenance window.
 func (w *GovernanceWorker) handleNodePolicyUpdated() {
 	glog.V(5).Infof(logString(fmt.Sprintf("handling node policy changes")))
 
@@ -60,22 +58,7 @@ func (w *GovernanceWorker) handleNodePolicyUpdated() {
 			glog.V(3).Infof(logString(fmt.Sprintf("skip agreement %v, it is already terminating", agreementId)))
 		} else {
 			glog.V(3).Infof(logString(fmt.Sprintf("ending the agreement: %v", agreementId)))
-
-			reason := w.producerPH[ag.AgreementProtocol].GetTerminationCode(producer.TERM_REASON_POLICY_CHANGED)
-
-			eventlog.LogAgreementEvent(
-				w.db,
-				persistence.SEVERITY_INFO,
-				persistence.NewMessageMeta(EL_GOV_START_TERM_AG_WITH_REASON, ag.RunningWorkload.URL, w.producerPH[ag.AgreementProtocol].GetTerminationReason(reason)),
-				persistence.EC_CANCEL_AGREEMENT,
-				ag)
-
-			w.cancelAgreement(agreementId, ag.AgreementProtocol, reason, w.producerPH[ag.AgreementProtocol].GetTerminationReason(reason))
-
-			// send the event to the container in case it has started the workloads.
-			w.Messages() <- events.NewGovernanceWorkloadCancelationMessage(events.AGREEMENT_ENDED, events.AG_TERMINATED, ag.AgreementProtocol, agreementId, ag.GetDeploymentConfig())
-			// clean up microservice instances if needed
-			w.handleMicroserviceInstForAgEnded(agreementId, false)
+			w.renegotiateAgreement(&ag, producer.TERM_REASON_POLICY_CHANGED, persistence.EC_CANCEL_AGREEMENT)
 		}
 	}
 }
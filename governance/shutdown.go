@@ -119,6 +119,7 @@ func (w *GovernanceWorker) nodeShutdown(cmd *NodeShutdownCommand) {
 		w.completedWithError(logString(err.Error()))
 		return
 	}
+	w.Messages() <- events.NewConfigstateChangedMessage(events.CONFIGSTATE_CHANGED, dev.Config.State, persistence.CONFIGSTATE_UNCONFIGURED, dev.Id, dev.Org, dev.Pattern)
 
 	// Delete node policy from local db
 	if err := persistence.DeleteNodePolicy(w.db); err != nil {
@@ -156,6 +157,11 @@ func (w *GovernanceWorker) nodeShutdown(cmd *NodeShutdownCommand) {
 			w.completedWithError(logString(err.Error()))
 			return
 		}
+
+		// remove the networks and volumes owned by anax that are not used by any container
+		if _, err := container.RemoveOrphanedResources(w.db, w.Config, true, false); err != nil {
+			w.continueWithError(logString(err.Error()))
+		}
 	}
 
 	// Tell the system that node quiesce is complete without error. The API worker might be waiting for this message.
@@ -227,6 +233,11 @@ func (w *GovernanceWorker) nodeShutDownForPattenChanged(dev *persistence.Exchang
 			w.completedWithError(logString(err.Error()))
 			return
 		}
+
+		// remove the networks and volumes owned by anax that are not used by any container
+		if _, err := container.RemoveOrphanedResources(w.db, w.Config, true, false); err != nil {
+			w.continueWithError(logString(err.Error()))
+		}
 	}
 
 	// Tell the system that node quiesce is complete without error. The API worker might be waiting for this message.
@@ -515,6 +526,7 @@ func (w *GovernanceWorker) updateHorizonDevice(device *persistence.ExchangeDevic
 	if _, err := device.SetConfigstate(w.db, device.Id, persistence.CONFIGSTATE_UNCONFIGURED); err != nil {
 		return errors.New(fmt.Sprintf("unable to update the config state for horizon device, error: %v", err))
 	} else {
+		w.Messages() <- events.NewConfigstateChangedMessage(events.CONFIGSTATE_CHANGED, device.Config.State, persistence.CONFIGSTATE_UNCONFIGURED, device.Id, device.Org, device.Pattern)
 		device.Config.State = persistence.CONFIGSTATE_UNCONFIGURED
 	}
 	if _, err := device.SetPattern(w.db, device.Id, pattern); err != nil {
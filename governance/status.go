@@ -93,8 +93,8 @@ func (w *GovernanceWorker) ReportDeviceStatus() int {
 	// get docker containers
 	containers := make([]docker.APIContainers, 0)
 	if w.deviceType == persistence.DEVICE_TYPE_DEVICE {
-		if client, err := docker.NewClient(w.Config.Edge.DockerEndpoint); err != nil {
-			glog.Errorf(logString(fmt.Sprintf("Failed to instantiate docker Client: %v", err)))
+		if client, err := container.NewContainerRuntime(w.Config); err != nil {
+			glog.Errorf(logString(fmt.Sprintf("Failed to instantiate %v Client: %v", w.Config.GetContainerRuntime(), err)))
 		} else {
 			containers, err = client.ListContainers(docker.ListContainersOptions{})
 			if err != nil {
@@ -193,7 +193,7 @@ func (w *GovernanceWorker) getMicroserviceStatus(containers []docker.APIContaine
 			msdef_status.Containers = make([]ContainerStatus, 0)
 			deployment, _ := msdef.GetDeployment()
 			if msdef.ClusterDeployment != "" {
-				opStatus, err := GetOperatorStatus(msdef.ClusterDeployment)
+				opStatus, err := GetOperatorStatus(msdef.ClusterDeployment, "")
 				if err != nil {
 					glog.Errorf(logString(fmt.Sprintf("Error getting operator status: %v", err)))
 				} else {
@@ -255,7 +255,7 @@ func (w *GovernanceWorker) getWorkloadStatus(containers []docker.APIContainers)
 						wl_status.Arch = wl.Arch
 
 						if wl.ClusterDeployment != "" {
-							opStatus, opErr := GetOperatorStatus(wl.ClusterDeployment)
+							opStatus, opErr := GetOperatorStatus(wl.ClusterDeployment, ag.CurrentAgreementId)
 							if opErr != nil {
 								glog.Errorf(logString(fmt.Sprintf("Error finding workload operator status for %v: %v.", ag, opErr)))
 							} else {
@@ -335,6 +335,24 @@ func GetContainerStatus(deployment string, key string, infrastructure bool, cont
 		if kc, err := kube_operator.NewKubeClient(); err != nil {
 			container_status.State = fmt.Sprintf("Unknown, error: %v", err)
 			status = append(status, container_status)
+		} else if kdc.IsWorkload() {
+			// the state of a workload service is the rollout state of its deployment in the agreement
+			agId := key
+			if infrastructure {
+				agId = ""
+			}
+			if workloadStatus, err := kc.WorkloadStatus(kdc.Workload, agId); err != nil {
+				container_status.State = fmt.Sprintf("Unknown, error: %v", err)
+				status = append(status, container_status)
+			} else {
+				for _, ws := range workloadStatus {
+					container_status.State = ws.State
+					container_status.Name = ws.Name
+					container_status.Created = ws.CreatedTime
+					container_status.Image = ws.Image
+					status = append(status, container_status)
+				}
+			}
 		} else {
 			if kubeStatus, err := kc.Status(kdc.OperatorYamlArchive, ""); err != nil {
 				container_status.State = fmt.Sprintf("Unknown, error: %v", err)
@@ -356,13 +374,22 @@ func GetContainerStatus(deployment string, key string, infrastructure bool, cont
 }
 
 // GetOperatorStatus will check if the given deployment is for a kube operator and return the operator defined status if it is
-// Will return nil for the interface and no error if the deployment is not for a kube operator
-func GetOperatorStatus(deployment string) (interface{}, error) {
+// Will return nil for the interface and no error if the deployment is not for a kube operator. The status of a workload
+// is the one of its deployments in the agreement agId, of all its deployments when agId is empty.
+func GetOperatorStatus(deployment string, agId string) (interface{}, error) {
 	if kd, err := persistence.GetKubeDeployment(deployment); err == nil {
 		client, err := kube_operator.NewKubeClient()
 		if err != nil {
 			return nil, fmt.Errorf(logString(fmt.Sprintf("Error retrieving operator status from cluster, error: %v", err)))
 		}
+		if kd.IsWorkload() {
+			// a workload has no operator, its status is the rollout status of its deployments
+			wlStatus, err := client.WorkloadStatus(kd.Workload, agId)
+			if err != nil {
+				return nil, fmt.Errorf(logString(fmt.Sprintf("Error retrieving workload status from cluster, error: %v", err)))
+			}
+			return wlStatus, nil
+		}
 		opStatus, err := client.OperatorStatus(kd.OperatorYamlArchive, "")
 		if err != nil {
 			return nil, fmt.Errorf(logString(fmt.Sprintf("Error retrieving operator status from cluster, error: %v", err)))
@@ -421,7 +448,8 @@ func (w *GovernanceWorker) surfaceErrors() int {
 
 	putErrorsHandler := exchange.GetHTTPPutSurfaceErrorsHandler(w.limitedRetryEC)
 	serviceResolverHandler := exchange.GetHTTPServiceResolverHandler(w.limitedRetryEC)
-	return exchangesync.UpdateSurfaceErrors(w.db, *pDevice, currentExchangeErrors.ErrorList, putErrorsHandler, serviceResolverHandler, w.BaseWorker.Manager.Config.Edge.SurfaceErrorTimeoutS, w.BaseWorker.Manager.Config.Edge.SurfaceErrorAgreementPersistentS)
+	live := w.BaseWorker.Manager.Config.LiveEdge()
+	return exchangesync.UpdateSurfaceErrors(w.db, *pDevice, currentExchangeErrors.ErrorList, putErrorsHandler, serviceResolverHandler, live.SurfaceErrorTimeoutS, live.SurfaceErrorAgreementPersistentS)
 }
 
 func changeInWorkloadStatuses(newStatuses []WorkloadStatus, oldStatuses []persistence.WorkloadStatus) bool {
@@ -0,0 +1,65 @@
+package hooks
+
+import (
+	"fmt"
+	"github.com/open-horizon/anax/events"
+)
+
+// This worker command is used to tell the worker that the config state of the node was changed, so that the hooks can run.
+type ConfigstateChangedCommand struct {
+	msg *events.ConfigstateChangedMessage
+}
+
+func (c ConfigstateChangedCommand) String() string {
+	return c.ShortString()
+}
+
+func (c ConfigstateChangedCommand) ShortString() string {
+	return fmt.Sprintf("ConfigstateChanged Command, Msg: %v", c.msg)
+}
+
+func NewConfigstateChangedCommand(msg *events.ConfigstateChangedMessage) *ConfigstateChangedCommand {
+	return &ConfigstateChangedCommand{
+		msg: msg,
+	}
+}
+
+// This worker command is used to tell the worker that a newer version of the agent is available, so that the condition
+// hooks can run.
+type AgentUpdateAvailableCommand struct {
+	msg *events.AgentUpdateMessage
+}
+
+func (c AgentUpdateAvailableCommand) String() string {
+	return c.ShortString()
+}
+
+func (c AgentUpdateAvailableCommand) ShortString() string {
+	return fmt.Sprintf("AgentUpdateAvailable Command, Msg: %v", c.msg)
+}
+
+func NewAgentUpdateAvailableCommand(msg *events.AgentUpdateMessage) *AgentUpdateAvailableCommand {
+	return &AgentUpdateAvailableCommand{
+		msg: msg,
+	}
+}
+
+// This worker command is used to tell the worker that the node is done shutting down and so it can terminate itself,
+// once the hooks that are running are done.
+type NodeUnconfigCommand struct {
+	msg *events.NodeShutdownCompleteMessage
+}
+
+func (n NodeUnconfigCommand) String() string {
+	return n.ShortString()
+}
+
+func (n NodeUnconfigCommand) ShortString() string {
+	return fmt.Sprintf("NodeUnconfig Command, Msg: %v", n.msg)
+}
+
+func NewNodeUnconfigCommand(msg *events.NodeShutdownCompleteMessage) *NodeUnconfigCommand {
+	return &NodeUnconfigCommand{
+		msg: msg,
+	}
+}
@@ -0,0 +1,185 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/cutil"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// The wait before the first retry of a failed hook, it doubles on each retry up to hookRetryMaxWait. It is a variable
+// so that the tests do not wait.
+var hookRetryBase = 2 * time.Second
+
+// The longest wait between the attempts of a hook.
+const hookRetryMaxWait = 30 * time.Second
+
+// The random part of the waits, so that the nodes of a fleet do not call a webhook in step.
+const hookRetryJitter = 0.5
+
+// The most output of a failed command that is logged.
+const maxLoggedOutput = 512
+
+// The JSON document that is given to the hooks when the config state of the node is changed.
+type ConfigstateChange struct {
+	OldState string `json:"old_state"`
+	NewState string `json:"new_state"`
+	NodeId   string `json:"node_id"`
+	Org      string `json:"org"`
+	Pattern  string `json:"pattern"`
+	Time     uint64 `json:"time"`
+}
+
+func (c ConfigstateChange) String() string {
+	return fmt.Sprintf("OldState: %v, NewState: %v, NodeId: %v, Org: %v, Pattern: %v, Time: %v", c.OldState, c.NewState, c.NodeId, c.Org, c.Pattern, c.Time)
+}
+
+// Describes the change in the logs of the hooks.
+func (c ConfigstateChange) describe() string {
+	return fmt.Sprintf("the change from %v to %v", c.OldState, c.NewState)
+}
+
+// The JSON document that is given to the condition hooks when a critical condition of the node is detected, see
+// config.ConditionHooksConfig. The details depend on the condition.
+type ConditionEvent struct {
+	Condition string            `json:"condition"`
+	NodeId    string            `json:"node_id"`
+	Org       string            `json:"org"`
+	Time      uint64            `json:"time"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+func (c ConditionEvent) String() string {
+	return fmt.Sprintf("Condition: %v, NodeId: %v, Org: %v, Time: %v, Details: %v", c.Condition, c.NodeId, c.Org, c.Time, c.Details)
+}
+
+// Describes the condition in the logs of the hooks.
+func (c ConditionEvent) describe() string {
+	return fmt.Sprintf("the condition %v", c.Condition)
+}
+
+// A hook is a webhook or a command, run is one attempt of it with the given payload.
+type hook struct {
+	name string
+	run  func(ctx context.Context, payload []byte) error
+}
+
+// Returns the hooks of the given webhooks and commands, the webhooks first.
+func configuredHooks(urls []string, commands []string, newClient func(overrideTimeoutS *uint) *http.Client) []hook {
+	hooks := make([]hook, 0, len(urls)+len(commands))
+	for _, u := range urls {
+		url := u
+		hooks = append(hooks, hook{name: fmt.Sprintf("webhook %v", url), run: func(ctx context.Context, payload []byte) error {
+			return postWebhook(ctx, newClient, url, payload)
+		}})
+	}
+	for _, c := range commands {
+		path := c
+		hooks = append(hooks, hook{name: fmt.Sprintf("command %v", path), run: func(ctx context.Context, payload []byte) error {
+			return runCommand(ctx, path, payload)
+		}})
+	}
+	return hooks
+}
+
+// POST the payload to the webhook, any 2xx status is a success. The context bounds the whole call.
+func postWebhook(ctx context.Context, newClient func(overrideTimeoutS *uint) *http.Client, url string, payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("unable to create the request, error %v", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := newClient(nil).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("the webhook returned status %v", resp.Status)
+	}
+	return nil
+}
+
+// Run the command with the payload on its standard input, an exit status of 0 is a success. The command is killed when
+// the context is done.
+func runCommand(ctx context.Context, path string, payload []byte) error {
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(payload)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		output := strings.TrimSpace(string(out))
+		if len(output) > maxLoggedOutput {
+			output = output[:maxLoggedOutput] + "..."
+		}
+		return fmt.Errorf("the command failed, error %v, output: %v", err, output)
+	}
+	return nil
+}
+
+// Run the hook until it succeeds or the retries are used up, each attempt is stopped after the timeout. What the hook
+// runs for is described in the logs. A failure is logged and returned, it does not change the state of the node.
+func runHook(h hook, what string, payload []byte, timeout time.Duration, retries int) error {
+	policy := cutil.RetryPolicy{
+		Attempts: retries + 1,
+		Base:     hookRetryBase,
+		Max:      hookRetryMaxWait,
+		Jitter:   hookRetryJitter,
+	}
+
+	attempt := 0
+	err := cutil.Retry(context.Background(), policy, nil, func() error {
+		attempt++
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		err := h.run(ctx, payload)
+		if err != nil && attempt < policy.Attempts {
+			glog.Warningf(hookLogString(fmt.Sprintf("%v failed for %v, attempt %v of %v, error: %v", h.name, what, attempt, policy.Attempts, err)))
+		}
+		return err
+	})
+
+	if err != nil {
+		glog.Errorf(hookLogString(fmt.Sprintf("%v failed for %v after %v attempts, error: %v", h.name, what, attempt, err)))
+	} else {
+		glog.V(3).Infof(hookLogString(fmt.Sprintf("%v succeeded for %v", h.name, what)))
+	}
+	return err
+}
+
+// Returns the payload of the hooks for the change.
+func marshalChange(change *ConfigstateChange) ([]byte, error) {
+	payload, err := json.Marshal(change)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal the config state change %v, error %v", change, err)
+	}
+	return payload, nil
+}
+
+// Returns the payload of the condition hooks for the condition.
+func marshalCondition(condition *ConditionEvent) ([]byte, error) {
+	payload, err := json.Marshal(condition)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal the condition %v, error %v", condition, err)
+	}
+	return payload, nil
+}
+
+var hookLogString = func(v interface{}) string {
+	return fmt.Sprintf("Hooks: %v", v)
+}
@@ -0,0 +1,173 @@
+// +build unit
+
+package hooks
+
+import (
+	"encoding/json"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/worker"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testChange() (*ConfigstateChange, []byte) {
+	change := &ConfigstateChange{OldState: "configuring", NewState: "configured", NodeId: "node1", Org: "myorg", Pattern: "myorg/netspeed", Time: 1600000000}
+	payload, _ := marshalChange(change)
+	return change, payload
+}
+
+func newTestClient(overrideTimeoutS *uint) *http.Client {
+	return &http.Client{}
+}
+
+func Test_runHook_webhook_retried(t *testing.T) {
+	hookRetryBase = time.Millisecond
+
+	var calls int32
+	var received ConfigstateChange
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("unable to decode the payload, error %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	change, payload := testChange()
+	hooks := configuredHooks([]string{server.URL}, nil, newTestClient)
+	if err := runHook(hooks[0], change.describe(), payload, time.Second, 2); err != nil {
+		t.Errorf("expected the webhook to succeed on the retry, error %v", err)
+	} else if calls != 2 {
+		t.Errorf("expected 2 calls, there were %v", calls)
+	} else if received != *change {
+		t.Errorf("expected payload %v, received %v", change, received)
+	}
+}
+
+func Test_runHook_webhook_failed(t *testing.T) {
+	hookRetryBase = time.Millisecond
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	change, payload := testChange()
+	hooks := configuredHooks([]string{server.URL}, nil, newTestClient)
+	if err := runHook(hooks[0], change.describe(), payload, time.Second, 2); err == nil {
+		t.Errorf("expected the webhook to fail")
+	} else if calls != 3 {
+		t.Errorf("expected 3 calls, there were %v", calls)
+	}
+}
+
+func Test_runHook_command(t *testing.T) {
+	hookRetryBase = time.Millisecond
+
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatalf("unable to create a temp dir, error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "payload.json")
+	script := filepath.Join(dir, "hook.sh")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\ncat > "+out+"\n"), 0755); err != nil {
+		t.Fatalf("unable to write the script, error %v", err)
+	}
+
+	change, payload := testChange()
+	hooks := configuredHooks(nil, []string{script}, newTestClient)
+	if err := runHook(hooks[0], change.describe(), payload, time.Second, 0); err != nil {
+		t.Errorf("expected the command to succeed, error %v", err)
+	} else if written, err := ioutil.ReadFile(out); err != nil {
+		t.Errorf("the command did not write the payload, error %v", err)
+	} else if string(written) != string(payload) {
+		t.Errorf("expected payload %v, received %v", string(payload), string(written))
+	}
+}
+
+func Test_runHook_command_timeout(t *testing.T) {
+	hookRetryBase = time.Millisecond
+
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatalf("unable to create a temp dir, error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "hook.sh")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\nexec sleep 10\n"), 0755); err != nil {
+		t.Fatalf("unable to write the script, error %v", err)
+	}
+
+	change, payload := testChange()
+	hooks := configuredHooks(nil, []string{script}, newTestClient)
+	start := time.Now()
+	if err := runHook(hooks[0], change.describe(), payload, 100*time.Millisecond, 1); err == nil {
+		t.Errorf("expected the command to time out")
+	} else if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the command to be stopped after the timeout, it took %v", elapsed)
+	}
+}
+
+// The condition hooks get the condition, the node and the versions when a newer version of the agent is available.
+func Test_handleAgentUpdateAvailable(t *testing.T) {
+	hookRetryBase = time.Millisecond
+
+	var received ConditionEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("unable to decode the payload, error %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := &config.HorizonConfig{Edge: config.Config{ConditionHooks: config.ConditionHooksConfig{URLs: []string{server.URL}}}}
+	w := &HooksWorker{BaseWorker: worker.NewBaseWorker("test", cfg, nil), newClient: newTestClient}
+
+	msg := events.NewAgentUpdateMessage(events.AGENT_UPDATE_AVAILABLE, "2.24.5", "2.25.0", "https://mydomain.com/agent/2.25.0", "node1", "myorg")
+	w.handleAgentUpdateAvailable(NewAgentUpdateAvailableCommand(msg))
+	w.running.Wait()
+
+	if received.Condition != config.CONDITION_AGENT_UPDATE_AVAILABLE {
+		t.Errorf("expected condition %v, received %v", config.CONDITION_AGENT_UPDATE_AVAILABLE, received)
+	} else if received.NodeId != "node1" || received.Org != "myorg" {
+		t.Errorf("expected node myorg/node1, received %v", received)
+	} else if received.Details["current_version"] != "2.24.5" || received.Details["latest_version"] != "2.25.0" || received.Details["url"] != msg.URL {
+		t.Errorf("expected the versions of the update, received %v", received.Details)
+	}
+}
+
+// No hook runs when none is configured.
+func Test_handleAgentUpdateAvailable_disabled(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+
+	cfg := &config.HorizonConfig{Edge: config.Config{ConfigstateHooks: config.ConfigstateHooksConfig{URLs: []string{server.URL}}}}
+	w := &HooksWorker{BaseWorker: worker.NewBaseWorker("test", cfg, nil), newClient: newTestClient}
+
+	w.handleAgentUpdateAvailable(NewAgentUpdateAvailableCommand(events.NewAgentUpdateMessage(events.AGENT_UPDATE_AVAILABLE, "2.24.5", "2.25.0", "", "node1", "myorg")))
+	w.running.Wait()
+
+	if calls != 0 {
+		t.Errorf("expected the configstate hooks not to run for the condition, there were %v calls", calls)
+	}
+}
@@ -0,0 +1,160 @@
+package hooks
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/worker"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The worker that runs the configstate hooks and the condition hooks, see config.ConfigstateHooksConfig and
+// config.ConditionHooksConfig. The hooks run in their own goroutines so that a slow webhook or command does not hold up
+// the event bus or the other hooks.
+type HooksWorker struct {
+	worker.BaseWorker // embedded field
+	newClient         func(overrideTimeoutS *uint) *http.Client
+	running           sync.WaitGroup
+}
+
+func NewHooksWorker(name string, cfg *config.HorizonConfig) *HooksWorker {
+
+	w := &HooksWorker{
+		BaseWorker: worker.NewBaseWorker(name, cfg, nil),
+		newClient:  cfg.Collaborators.HTTPClientFactory.NewHTTPClient,
+	}
+
+	glog.Info(hookLogString(fmt.Sprintf("Starting Hooks worker")))
+	w.Start(w, 0)
+	return w
+}
+
+func (w *HooksWorker) Messages() chan events.Message {
+	return w.BaseWorker.Manager.Messages
+}
+
+// Handle events that are propogated to this worker from the internal event bus.
+func (w *HooksWorker) NewEvent(incoming events.Message) {
+
+	switch incoming.(type) {
+
+	case *events.ConfigstateChangedMessage:
+		msg, _ := incoming.(*events.ConfigstateChangedMessage)
+		switch msg.Event().Id {
+		case events.CONFIGSTATE_CHANGED:
+			w.Commands <- NewConfigstateChangedCommand(msg)
+		}
+
+	case *events.AgentUpdateMessage:
+		msg, _ := incoming.(*events.AgentUpdateMessage)
+		switch msg.Event().Id {
+		case events.AGENT_UPDATE_AVAILABLE:
+			w.Commands <- NewAgentUpdateAvailableCommand(msg)
+		}
+
+	case *events.NodeShutdownCompleteMessage:
+		msg, _ := incoming.(*events.NodeShutdownCompleteMessage)
+		switch msg.Event().Id {
+		case events.UNCONFIGURE_COMPLETE:
+			w.Commands <- NewNodeUnconfigCommand(msg)
+		}
+
+	default: //nothing
+
+	}
+
+	return
+}
+
+// Handle commands that are placed on the command queue.
+func (w *HooksWorker) CommandHandler(command worker.Command) bool {
+
+	switch command.(type) {
+	case *ConfigstateChangedCommand:
+		cmd, _ := command.(*ConfigstateChangedCommand)
+		w.handleConfigstateChanged(cmd)
+
+	case *AgentUpdateAvailableCommand:
+		cmd, _ := command.(*AgentUpdateAvailableCommand)
+		w.handleAgentUpdateAvailable(cmd)
+
+	case *NodeUnconfigCommand:
+		// The hooks of the change to unconfigured are run before the worker terminates.
+		w.running.Wait()
+		w.Commands <- worker.NewTerminateCommand("shutdown")
+
+	default:
+		return false
+	}
+	return true
+
+}
+
+// Start the hooks that are configured for the new state. The config is read here rather than when the worker is
+// started so that the States, TimeoutS and Retries can be reloaded.
+func (w *HooksWorker) handleConfigstateChanged(cmd *ConfigstateChangedCommand) {
+	live := w.Config.LiveEdge()
+	hc := &live.ConfigstateHooks
+	if !hc.Enabled() || !hc.RunsOn(cmd.msg.NewState) {
+		return
+	}
+
+	change := &ConfigstateChange{
+		OldState: cmd.msg.OldState,
+		NewState: cmd.msg.NewState,
+		NodeId:   cmd.msg.DeviceId,
+		Org:      cmd.msg.Org,
+		Pattern:  cmd.msg.Pattern,
+		Time:     uint64(time.Now().Unix()),
+	}
+	payload, err := marshalChange(change)
+	if err != nil {
+		glog.Errorf(hookLogString(err))
+		return
+	}
+
+	w.startHooks(configuredHooks(hc.URLs, hc.Commands, w.newClient), change.describe(), payload, time.Duration(hc.GetTimeoutS())*time.Second, hc.GetRetries())
+}
+
+// Start the condition hooks for the newer version of the agent. The config is read here so that the TimeoutS and
+// Retries can be reloaded.
+func (w *HooksWorker) handleAgentUpdateAvailable(cmd *AgentUpdateAvailableCommand) {
+	live := w.Config.LiveEdge()
+	hc := &live.ConditionHooks
+	if !hc.Enabled() {
+		return
+	}
+
+	condition := &ConditionEvent{
+		Condition: config.CONDITION_AGENT_UPDATE_AVAILABLE,
+		NodeId:    cmd.msg.NodeId,
+		Org:       cmd.msg.NodeOrg,
+		Time:      uint64(time.Now().Unix()),
+		Details: map[string]string{
+			"current_version": cmd.msg.CurrentVersion,
+			"latest_version":  cmd.msg.LatestVersion,
+			"url":             cmd.msg.URL,
+		},
+	}
+	payload, err := marshalCondition(condition)
+	if err != nil {
+		glog.Errorf(hookLogString(err))
+		return
+	}
+
+	w.startHooks(configuredHooks(hc.URLs, hc.Commands, w.newClient), condition.describe(), payload, time.Duration(hc.GetTimeoutS())*time.Second, hc.GetRetries())
+}
+
+// Run each hook in its own goroutine, the worker waits for them before it terminates.
+func (w *HooksWorker) startHooks(hooks []hook, what string, payload []byte, timeout time.Duration, retries int) {
+	for _, h := range hooks {
+		w.running.Add(1)
+		go func(h hook) {
+			defer w.running.Done()
+			runHook(h, what, payload, timeout, retries)
+		}(h)
+	}
+}
@@ -7,7 +7,9 @@ import (
 	docker "github.com/fsouza/go-dockerclient"
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/container"
 	"github.com/open-horizon/anax/containermessage"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/worker"
@@ -17,7 +19,7 @@ import (
 type ImageFetchWorker struct {
 	worker.BaseWorker // embedded field
 	db                *bolt.DB
-	client            *docker.Client
+	client            container.ContainerRuntime
 }
 
 func NewImageFetchWorker(name string, config *config.HorizonConfig, db *bolt.DB) *ImageFetchWorker {
@@ -28,12 +30,12 @@ func NewImageFetchWorker(name string, config *config.HorizonConfig, db *bolt.DB)
 		return nil
 	}
 
-	var client *docker.Client
+	var client container.ContainerRuntime
 	var err error
 	if config.Edge.DockerEndpoint != "" {
-		client, err = docker.NewClient(config.Edge.DockerEndpoint)
+		client, err = container.NewContainerRuntime(config)
 		if err != nil {
-			glog.Errorf("Failed to instantiate docker Client: %v", err)
+			glog.Errorf("Failed to instantiate %v Client: %v", config.GetContainerRuntime(), err)
 			panic("Unable to instantiate docker Client")
 		}
 	}
@@ -192,15 +194,20 @@ func processDeployment(cfg *config.HorizonConfig, containerConfig events.Contain
 	return pemFiles, &deploymentDesc, nil
 }
 
-func processFetch(cfg *config.HorizonConfig, client *docker.Client, db *bolt.DB, deploymentDesc *containermessage.DeploymentDescription, imageDockerAuths []events.ImageDockerAuth) error {
+func processFetch(cfg *config.HorizonConfig, client container.ContainerRuntime, db *bolt.DB, deploymentDesc *containermessage.DeploymentDescription, imageDockerAuths []events.ImageDockerAuth, pinnedDigests map[string]string) (map[string]string, []DigestMismatch, error) {
 	if client == nil {
-		return fmt.Errorf("Docker client is nil. Please make sure DockerEndpoint is set in the configuration file.")
+		return nil, nil, fmt.Errorf("Docker client is nil. Please make sure DockerEndpoint is set in the configuration file.")
+	}
+
+	// an image pull that runs out of space fails halfway and leaves partial layers behind
+	if err := cutil.CheckDiskSpace(cfg); err != nil {
+		return nil, nil, fmt.Errorf("Not enough disk space to pull the images: %v", err)
 	}
 
 	dockerAuthConfigurations := make(map[string][]docker.AuthConfiguration, 0)
 
 	var err error
-	if cfg.Edge.TrustDockerAuthFromOrg {
+	if cfg.LiveEdge().TrustDockerAuthFromOrg {
 		err = authExchange(imageDockerAuths, dockerAuthConfigurations)
 		if err != nil {
 			glog.Errorf("Failed to add authentication facts from exchange before processing packages and / or Docker pulls: %v. Continuing anyway", err)
@@ -211,18 +218,17 @@ func processFetch(cfg *config.HorizonConfig, client *docker.Client, db *bolt.DB,
 		glog.Errorf("Failed to fetch authentication facts from the attributes before processing packages and / or Docker pulls: %v. Continuing anyway", err)
 	}
 
-	return fetchImage(cfg, client, db, deploymentDesc, dockerAuthConfigurations)
+	return fetchImage(cfg, client, db, deploymentDesc, dockerAuthConfigurations, pinnedDigests)
 }
 
-func fetchImage(cfg *config.HorizonConfig, client *docker.Client, db *bolt.DB, deploymentDesc *containermessage.DeploymentDescription, dockerAuthConfigurations map[string][]docker.AuthConfiguration) error {
+func fetchImage(cfg *config.HorizonConfig, client container.ContainerRuntime, db *bolt.DB, deploymentDesc *containermessage.DeploymentDescription, dockerAuthConfigurations map[string][]docker.AuthConfiguration, pinnedDigests map[string]string) (map[string]string, []DigestMismatch, error) {
 
 	skipCheckFn := SkipCheckFn(client)
 	// using Docker pull (newer option, uses docker client to pull images from repos in image names in deployment description)
 	// Note: we don't want to make this a fallback option, it's a potential security vector
 	glog.V(3).Infof("Using Docker pull mechanism to retrieve and load Docker images into local registry")
 
-	fetchErr := pullImageFromRepos(cfg.Edge, dockerAuthConfigurations, client, &skipCheckFn, deploymentDesc)
-	return fetchErr
+	return pullImageFromRepos(cfg.LiveEdge(), dockerAuthConfigurations, client, &skipCheckFn, deploymentDesc, pinnedDigests)
 }
 
 // This function is used by external caller such as hzn command to load the container images.
@@ -234,13 +240,13 @@ func fetchImage(cfg *config.HorizonConfig, client *docker.Client, db *bolt.DB, d
 // 2) from the dockerAuthConfigurations
 // 3) from the config.DockerCredFilePath file.
 // 4) from /root/.docker/config.json if 3) is not set.
-func ProcessImageFetch(cfg *config.HorizonConfig, client *docker.Client, containerConfig *events.ContainerConfig, dockerAuthConfigurations map[string][]docker.AuthConfiguration) error {
+func ProcessImageFetch(cfg *config.HorizonConfig, client container.ContainerRuntime, containerConfig *events.ContainerConfig, dockerAuthConfigurations map[string][]docker.AuthConfiguration) error {
 
 	dockerAuthNew := make(map[string][]docker.AuthConfiguration, 0)
 
 	//make sure that the docker auth from the image overwrites the user defined docker auth for the same repo
 	var err error
-	if cfg.Edge.TrustDockerAuthFromOrg {
+	if cfg.LiveEdge().TrustDockerAuthFromOrg {
 		err = authExchange(containerConfig.ImageDockerAuths, dockerAuthNew)
 		if err != nil {
 			glog.Errorf("Failed to add authentication facts from exchange before processing packages and / or Docker pulls: %v. Continuing anyway", err)
@@ -258,7 +264,8 @@ func ProcessImageFetch(cfg *config.HorizonConfig, client *docker.Client, contain
 		return fmt.Errorf("Error Unmarshalling deployment string %v, error: %v", containerConfig.Deployment, err)
 	}
 
-	return fetchImage(cfg, client, nil, &deploymentDesc, dockerAuthNew)
+	_, _, err = fetchImage(cfg, client, nil, &deploymentDesc, dockerAuthNew, nil)
+	return err
 }
 
 func (b *ImageFetchWorker) CommandHandler(command worker.Command) bool {
@@ -294,7 +301,12 @@ func (b *ImageFetchWorker) CommandHandler(command worker.Command) bool {
 				return true
 			}
 
-			if fetchErr := processFetch(b.Config, b.client, b.db, deploymentDesc, lc.ContainerConfig().ImageDockerAuths); fetchErr != nil {
+			pinnedDigests := b.getPinnedDigests(cmd.LaunchContext)
+
+			// the images are renamed to their pinned name by the fetch
+			present := b.presentImages(deploymentDesc)
+
+			if digests, mismatches, fetchErr := processFetch(b.Config, b.client, b.db, deploymentDesc, lc.ContainerConfig().ImageDockerAuths, pinnedDigests); fetchErr != nil {
 				var id events.EventId
 				if strings.Contains(fetchErr.Error(), "Auth error") {
 					id = events.IMAGE_FETCH_AUTH_ERROR
@@ -304,7 +316,14 @@ func (b *ImageFetchWorker) CommandHandler(command worker.Command) bool {
 				glog.Errorf("Failed to fetch image files: %v", fetchErr)
 				b.Messages() <- events.NewImageFetchMessage(id, deploymentDesc, lc, fetchErr)
 			} else {
-				b.Messages() <- events.NewImageFetchMessage(events.IMAGE_FETCHED, deploymentDesc, lc, nil)
+				b.recordPulledImages(deploymentDesc, present)
+
+				msg := events.NewImageFetchMessage(events.IMAGE_FETCHED, deploymentDesc, lc, nil)
+				msg.ImageDigests = digests
+				for _, m := range mismatches {
+					msg.DigestMismatches = append(msg.DigestMismatches, m.String())
+				}
+				b.Messages() <- msg
 			}
 
 		}
@@ -316,6 +335,68 @@ func (b *ImageFetchWorker) CommandHandler(command worker.Command) bool {
 
 }
 
+// Returns the ids of the images of the services of the deployment that are on the node before they are fetched, by
+// service name.
+func (b *ImageFetchWorker) presentImages(deploymentDesc *containermessage.DeploymentDescription) map[string]string {
+	present := make(map[string]string)
+	for name, service := range deploymentDesc.Services {
+		if image, err := b.client.InspectImage(service.Image); err == nil && image != nil {
+			present[name] = image.ID
+		}
+	}
+	return present
+}
+
+// Record the images of the deployment as pulled by anax, so that they can be pruned once they are superseded. The
+// images are named as the containers will use them, pinned images by their digest. An image that was on the node
+// before the fetch, e.g. built locally or loaded from the offline bundle, is not anax's to prune, unless anax pulled
+// it before.
+func (b *ImageFetchWorker) recordPulledImages(deploymentDesc *containermessage.DeploymentDescription, present map[string]string) {
+	pulled, err := persistence.FindPulledImages(b.db)
+	if err != nil {
+		glog.Errorf("Unable to retrieve pulled images from database, error %v", err)
+		return
+	}
+	pulledIDs := make(map[string]bool)
+	for _, pi := range pulled {
+		pulledIDs[pi.ImageID] = true
+	}
+
+	for name, service := range deploymentDesc.Services {
+		if image, err := b.client.InspectImage(service.Image); err != nil {
+			glog.Errorf("Unable to inspect image %v of service %v, error %v", service.Image, name, err)
+		} else if present[name] == image.ID && !pulledIDs[image.ID] {
+			glog.V(5).Infof("Image %v of service %v was on the node before it was fetched, it is not recorded as pulled", service.Image, name)
+		} else if err := persistence.SavePulledImage(b.db, service.Image, container.ImageRepository(service.Image), image.ID); err != nil {
+			glog.Errorf("Unable to save pulled image %v of service %v, error %v", service.Image, name, err)
+		}
+	}
+}
+
+// Returns the image digests that were recorded when the containers of the agreement or service instance were
+// first started, so that a restart pins its containers to the identical images.
+func (b *ImageFetchWorker) getPinnedDigests(launchContext interface{}) map[string]string {
+
+	switch launchContext.(type) {
+	case *events.AgreementLaunchContext:
+		lc := launchContext.(*events.AgreementLaunchContext)
+		if ags, err := persistence.FindEstablishedAgreements(b.db, lc.AgreementProtocol, []persistence.EAFilter{persistence.UnarchivedEAFilter(), persistence.IdEAFilter(lc.AgreementId)}); err != nil {
+			glog.Errorf("Unable to retrieve agreement %v from database, error %v", lc.AgreementId, err)
+		} else if len(ags) == 1 {
+			return ags[0].ImageDigests
+		}
+
+	case *events.ContainerLaunchContext:
+		lc := launchContext.(*events.ContainerLaunchContext)
+		if msi, err := persistence.FindMicroserviceInstanceWithKey(b.db, lc.Name); err != nil {
+			glog.Errorf("Unable to retrieve service instance %v from database, error %v", lc.Name, err)
+		} else if msi != nil {
+			return msi.ImageDigests
+		}
+	}
+	return nil
+}
+
 type FetchCommand struct {
 	LaunchContext interface{}
 }
@@ -130,9 +130,16 @@ func tConfig(t *testing.T, dir string) *config.HorizonConfig {
 		t.Logf("Using docker cred config file: %v (identified by envvar HORIZON_TEST_DOCKER_CREDFILE_PATH)", os.Getenv("HORIZON_TEST_DOCKER_CREDFILE_PATH"))
 	}
 
+	// The tests run against docker unless the CI system selects another container runtime and endpoint.
+	endpoint := "unix:///var/run/docker.sock"
+	if ep := os.Getenv("HORIZON_TEST_CONTAINER_ENDPOINT"); ep != "" {
+		endpoint = ep
+	}
+
 	cfg := config.HorizonConfig{
 		Edge: config.Config{
-			DockerEndpoint:     "unix:///var/run/docker.sock",
+			DockerEndpoint:     endpoint,
+			ContainerRuntime:   os.Getenv("HORIZON_TEST_CONTAINER_RUNTIME"),
 			DockerCredFilePath: dockerCredFile,
 			DefaultCPUSet:      "0-1",
 			ServiceStorage:     workloadStorageDir,
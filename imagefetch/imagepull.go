@@ -3,22 +3,68 @@ package imagefetch
 import (
 	docker "github.com/fsouza/go-dockerclient"
 
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/container"
 	"github.com/open-horizon/anax/containermessage"
 	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/download"
+	"github.com/open-horizon/anax/persistence"
 	"os"
 	"strings"
 	"time"
 )
 
 const (
-	pullAttemptDelayS = 15
+	// The upper bound on the wait between two image pull attempts, no matter how many retries have happened.
+	maxPullBackoffS = 300
 
-	maxPullAttempts = 3
+	// The fraction of each wait between two image pull attempts that is random.
+	pullBackoffJitter = 0.2
 )
 
+// A container image whose recorded digest is not the digest that the registry currently serves for the image's tag.
+type DigestMismatch struct {
+	Service  string // The name of the container in the deployment.
+	Image    string // The image reference in the deployment.
+	Recorded string // The digest that the container was originally pinned to.
+	Current  string // The digest that the registry serves now.
+}
+
+func (d DigestMismatch) String() string {
+	return fmt.Sprintf("container %v image %v is pinned to digest %v but the registry now serves digest %v", d.Service, d.Image, d.Recorded, d.Current)
+}
+
+// Check the given registry credentials by logging in to each registry through the container runtime. The tokens are
+// not included in the returned errors.
+func VerifyRegistryAuths(cfg *config.HorizonConfig, auths []persistence.Auth) error {
+	if cfg.Edge.DockerEndpoint == "" {
+		glog.V(3).Infof("DockerEndpoint is not set in the configuration, skipping the registry credential check.")
+		return nil
+	}
+
+	client, err := container.NewContainerRuntime(cfg)
+	if err != nil {
+		return fmt.Errorf("Failed to instantiate %v Client: %v", cfg.GetContainerRuntime(), err)
+	}
+
+	for _, auth := range auths {
+		username := "token" // default user name if auth.UserName is empty
+		if auth.UserName != "" {
+			username = auth.UserName
+		}
+		if _, err := client.AuthCheck(&docker.AuthConfiguration{Username: username, Password: auth.Token, ServerAddress: auth.Registry}); err != nil {
+			return fmt.Errorf("unable to log in to registry %v as %v: %v", auth.Registry, username, err)
+		}
+		glog.V(3).Infof("Verified the credentials of %v for registry %v", username, auth.Registry)
+	}
+	return nil
+}
+
 // read the given docker file and get the auths
 func dockerCredsFromConfigFile(configFilePath string) (*docker.AuthConfigurations, error) {
 
@@ -65,11 +111,19 @@ func authDockerFile(config config.Config, authConfigs map[string][]docker.AuthCo
 	return nil
 }
 
-func pullImageFromRepos(config config.Config, authConfigs map[string][]docker.AuthConfiguration, client *docker.Client, skipPartFetchFn *func(repotag string) (bool, error), deploymentDesc *containermessage.DeploymentDescription) error {
+// Pull the images of all the containers in the deployment and pin each container to the digest of the image that was pulled.
+// The pinnedDigests are the digests recorded by a previous fetch of the same deployment, keyed by container name. A container
+// with a recorded digest is always pinned to that digest, so that a restart runs the identical image. The returned map holds
+// the digest of each pinned container, and the returned mismatches list the containers whose recorded digest is no longer
+// the one that the registry serves for the image's tag.
+func pullImageFromRepos(config config.Config, authConfigs map[string][]docker.AuthConfiguration, client container.ContainerRuntime, skipPartFetchFn *func(repotag string) (bool, error), deploymentDesc *containermessage.DeploymentDescription, pinnedDigests map[string]string) (map[string]string, []DigestMismatch, error) {
 
 	// append docker auth from docker file
 	authDockerFile(config, authConfigs)
 
+	digests := make(map[string]string)
+	mismatches := make([]DigestMismatch, 0)
+
 	// TODO: can we fetch in parallel with the docker client? If so, lift pattern from https://github.com/open-horizon/horizon-pkg-fetch/blob/master/fetch.go#L350
 	for name, service := range deploymentDesc.Services {
 
@@ -80,8 +134,16 @@ func pullImageFromRepos(config config.Config, authConfigs map[string][]docker.Au
 		domain, path, tag, digest := cutil.ParseDockerImagePath(service.Image)
 		if path == "" {
 			glog.Errorf("Invalid image name format specified: %v", service.Image)
-			return fmt.Errorf("Invalid image name format specified: %v", service.Image)
+			return nil, nil, fmt.Errorf("Invalid image name format specified: %v", service.Image)
 		}
+
+		var repo string
+		if domain == "" {
+			repo = path
+		} else {
+			repo = fmt.Sprintf("%v/%v", domain, path)
+		}
+
 		// the image name format is [[repo][:port]/][somedir/]image[:tag][@digest].
 		// tag and digest do not contain '/'
 		if digest != "" {
@@ -96,18 +158,10 @@ func pullImageFromRepos(config config.Config, authConfigs map[string][]docker.Au
 			//  repo:port/a/b:tag
 			//  repo:port/a/b
 
-			var repo string
-			if domain == "" {
-				repo = path
-			} else {
-				repo = fmt.Sprintf("%v/%v", domain, path)
-			}
-
 			if tag == "" {
 				tag = "latest"
 			}
 
-			// TODO: check the on-disk image to make sure it still verifies
 			// N.B. It's possible to specify an outputstream here which means we could fetch a docker image and hash it, check the sig like we used to
 			opts = docker.PullImageOptions{
 				Repository: repo,
@@ -130,79 +184,258 @@ func pullImageFromRepos(config config.Config, authConfigs map[string][]docker.Au
 			}
 		}
 
-		// try auths one at a time
-		var err error
-		for i, auth := range auth_array {
-			err = pullSingleImageFromRepo(client, opts, auth)
-			if err == nil {
-				break
-			} else if i < len(auth_array)-1 {
-				glog.V(5).Infof("Docker image pull(s) failed for service %v docker image %v with auth name %v. Error: %v. Try next auth.", name, service.Image, auth.Username, err)
+		fetched, err := pullImageWithAuths(config, client, opts, auth_array)
+		if err != nil {
+			glog.Errorf("Docker image pull(s) failed for docker image %v. Error: %v.", service.Image, err)
+			return nil, nil, err
+		} else {
+			glog.V(3).Infof("Succeeded fetching image %v for service %v", service.Image, name)
+		}
+
+		// Find the digest of the image that was just pulled. Images that were built locally and never pushed to a
+		// registry have no digest, these cannot be pinned. The runtime has no digest for an image that the agent
+		// fetched from the registry itself, its digest is the one that the registry served.
+		if fetched != nil {
+			digest = fetched.Digest
+		} else if digest == "" {
+			if image, err := client.InspectImage(service.Image); err != nil {
+				return nil, nil, fmt.Errorf("Unable to inspect image %v after pulling it, error: %v", service.Image, err)
+			} else {
+				digest = repoDigest(image.RepoDigests, repo)
 			}
 		}
 
-		// if all auths failed or no auth specified for this domain, try without auth
-		if err != nil || len(auth_array) == 0 {
-			glog.V(5).Infof("Pulling image %v without auth.", service.Image)
-			err = pullSingleImageFromRepo(client, opts, docker.AuthConfiguration{})
+		// A container that was pinned by an earlier fetch of this deployment keeps its digest, even if the registry
+		// has since moved the tag to a different image.
+		if recorded, ok := pinnedDigests[name]; ok && recorded != "" {
+			if digest != recorded {
+				mismatch := DigestMismatch{Service: name, Image: service.Image, Recorded: recorded, Current: digest}
+				glog.Warningf("Digest mismatch: %v. Continuing with the recorded digest.", mismatch)
+				mismatches = append(mismatches, mismatch)
+
+				opts = docker.PullImageOptions{
+					Repository: fmt.Sprintf("%v@%v", repo, recorded),
+				}
+				if fetched, err = pullImageWithAuths(config, client, opts, auth_array); err != nil {
+					glog.Errorf("Docker image pull(s) failed for pinned docker image %v. Error: %v.", opts.Repository, err)
+					return nil, nil, err
+				}
+			}
+			digest = recorded
 		}
 
-		if err != nil {
-			glog.Errorf("Docker image pull(s) failed for docker image %v. Error: %v.", service.Image, err)
-			return err
+		if digest == "" {
+			glog.V(3).Infof("Image %v for service %v has no repository digest, it will not be pinned.", service.Image, name)
 		} else {
-			glog.V(3).Infof("Succeeded fetching image %v for service %v", service.Image, name)
+			digests[name] = digest
+			if fetched != nil {
+				// the runtime only knows the image by its ID, the image with a given ID is always the same image
+				service.Image = fetched.ID
+			} else {
+				service.Image = fmt.Sprintf("%v@%v", repo, digest)
+			}
+			glog.V(3).Infof("Pinned service %v to image %v", name, service.Image)
 		}
 	}
 
-	return nil
+	return digests, mismatches, nil
 }
 
-//  This function try maxPullAttempts times to pull the image from the repo. It exits out imediately if there is auth error.
-func pullSingleImageFromRepo(client *docker.Client, opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+// Try the auths one at a time to pull the image. If all of them fail or there are none, try without auth. The image
+// is returned when the agent fetched it from the registry itself, nil when the container runtime pulled it.
+func pullImageWithAuths(config config.Config, client container.ContainerRuntime, opts docker.PullImageOptions, auth_array []docker.AuthConfiguration) (*fetchedImage, error) {
+	var fetched *fetchedImage
+	var err error
+	for i, auth := range auth_array {
+		fetched, err = pullSingleImageFromRepo(config, client, opts, auth)
+		if err == nil {
+			break
+		} else if i < len(auth_array)-1 {
+			glog.V(5).Infof("Docker image pull(s) failed for docker image %v with auth name %v. Error: %v. Try next auth.", opts.Repository, auth.Username, err)
+		}
+	}
+
+	// if all auths failed or no auth specified for this domain, try without auth
+	if err != nil || len(auth_array) == 0 {
+		glog.V(5).Infof("Pulling image %v without auth.", opts.Repository)
+		fetched, err = pullSingleImageFromRepo(config, client, opts, docker.AuthConfiguration{})
+	}
+	return fetched, err
+}
+
+// Returns the digest from the repo digest (repo@digest) of the given repository. Docker shortens the names of images
+// on docker hub, so docker.io/library/busybox is reported as busybox.
+func repoDigest(repoDigests []string, repo string) string {
+	normalize := func(r string) string {
+		r = strings.TrimPrefix(r, "docker.io/")
+		return strings.TrimPrefix(r, "library/")
+	}
+
+	for _, rd := range repoDigests {
+		if parts := strings.SplitN(rd, "@", 2); len(parts) == 2 && normalize(parts[0]) == normalize(repo) {
+			return parts[1]
+		}
+	}
+	return ""
+}
+
+// Returns how long to wait before the given retry (1 based). The wait starts at the configured backoff and doubles on
+// each retry, up to maxPullBackoffS.
+func pullBackoff(backoffS int, retry int) time.Duration {
+	return pullRetryPolicy(backoffS, 0).Backoff(retry)
+}
+
+// The image pulls of a fleet of nodes are spread out by the jitter, so that they do not all hit the registry at once
+// after it comes back.
+func pullRetryPolicy(backoffS int, maxRetries int) cutil.RetryPolicy {
+	return cutil.RetryPolicy{
+		Attempts: maxRetries + 1,
+		Base:     time.Duration(backoffS) * time.Second,
+		Max:      maxPullBackoffS * time.Second,
+		Jitter:   pullBackoffJitter,
+	}
+}
+
+// Returns true if the pull failed because of the registry credentials, retrying it would fail the same way.
+func isPullAuthError(err error) bool {
+	dErr, ok := err.(*docker.Error)
+	return ok && strings.Contains(dErr.Message, "cred")
+}
+
+// This function tries to pull the image from the repo, and retries up to config.ImagePullRetries times with an increasing
+// backoff. It exits out imediately if there is auth error. While the download rate limit applies, the agent fetches the
+// image from the registry itself, the image is then returned. The container runtime pulls it, without the limit, when
+// the agent cannot fetch it, e.g. from a registry whose certificate only the runtime is configured with.
+func pullSingleImageFromRepo(config config.Config, client container.ContainerRuntime, opts docker.PullImageOptions, auth docker.AuthConfiguration) (*fetchedImage, error) {
 	glog.V(5).Infof("Pulling image %v with auth name %v.", opts, auth.Username)
 
-	var pullAttempts int
+	maxRetries := config.ImagePullRetries
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
 
-	for pullAttempts <= maxPullAttempts {
-		if err := client.PullImage(opts, auth); err == nil {
-			return nil
-		} else {
-			pullAttempts++
-
-			// no need to try more times if it is auth error
-			switch err.(type) {
-			case *docker.Error:
-				dErr := err.(*docker.Error)
-				if strings.Contains(dErr.Message, "cred") {
-					msg := fmt.Sprintf("Aborting fetch of Docker image %v.", opts.Repository)
-					return fmt.Errorf("Auth error. Msg: %v, InternalError: %v.", msg, dErr)
-				}
+	// the agent can only observe the progress of the pulls of the container runtime
+	name := opts.Repository
+	if opts.Tag != "" {
+		name = fmt.Sprintf("%v:%v", name, opts.Tag)
+	}
+	progress := newPullProgress(fmt.Sprintf("image %v", name))
+	defer progress.Done()
+	opts.OutputStream = progress
+	opts.RawJSONStream = true
+
+	// an auth error does not go away when the pull is retried
+	retryable := func(err error) bool {
+		return !isPullAuthError(err)
+	}
+
+	var fetched *fetchedImage
+	pullOnce := func() error {
+		if download.Limited() {
+			var fetchErr error
+			if fetched, fetchErr = loadImageFromRegistry(client, opts, auth); fetchErr == nil {
+				return nil
 			}
+			glog.Warningf("Unable to fetch image %v within the download rate limit, the container runtime pulls it without the limit. Error: %v", name, fetchErr)
+		}
+		return client.PullImage(opts, auth)
+	}
 
-			if pullAttempts != maxPullAttempts {
-				glog.V(5).Infof("Waiting %d seconds before retry. Error: %v", pullAttemptDelayS, err)
-				time.Sleep(pullAttemptDelayS * time.Second)
-			} else {
-				msg := fmt.Sprintf("Max pull attempts reached (%d) for fetching Docker image %v.", pullAttempts, opts.Repository)
+	attempts := 0
+	var lastErr error
+	err := cutil.Retry(context.Background(), pullRetryPolicy(config.ImagePullBackoffS, maxRetries), retryable, func() error {
+		attempts++
+		if attempts > 1 {
+			glog.V(5).Infof("Retry %v of %v of the pull of image %v after waiting up to %v. Error: %v", attempts-1, maxRetries, name, pullBackoff(config.ImagePullBackoffS, attempts-1), lastErr)
+		}
+		lastErr = pullOnce()
+		return lastErr
+	})
+
+	if err == nil {
+		return fetched, nil
+	} else if isPullAuthError(err) {
+		// no need to try more times if it is auth error
+		msg := fmt.Sprintf("Aborting fetch of Docker image %v.", opts.Repository)
+		return nil, fmt.Errorf("Auth error. Msg: %v, InternalError: %v.", msg, err)
+	}
 
-				switch err.(type) {
-				case *docker.Error:
-					glog.V(5).Infof(msg+"Docker client error occurred %v", err)
-					return err
+	msg := fmt.Sprintf("Max pull attempts reached (%d) for fetching Docker image %v.", attempts, opts.Repository)
+	switch err.(type) {
+	case *docker.Error:
+		glog.V(5).Infof(msg+"Docker client error occurred %v", err)
+	default:
+		glog.V(5).Infof(msg+"(Unknown error type, %T) Internal error of unidentifiable type: %v. Original: %v", err, msg, err)
+	}
+	return nil, err
+}
 
-				default:
-					glog.V(5).Infof(msg+"(Unknown error type, %T) Internal error of unidentifiable type: %v. Original: %v", err, msg, err)
-					return err
+// The progress of an image pull, from the JSON messages that the container runtime writes for each layer. The size
+// of the image is the sum of the sizes of the layers that are downloaded, it grows as the runtime reports them.
+type pullProgress struct {
+	*download.Progress
+	partial []byte
+	layers  map[string]*layerProgress
+}
 
-				}
-			}
+type layerProgress struct {
+	current int64
+	total   int64
+}
+
+type pullMessage struct {
+	Status         string `json:"status"`
+	ID             string `json:"id"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+}
+
+func newPullProgress(name string) *pullProgress {
+	return &pullProgress{Progress: download.NewProgress(name), layers: make(map[string]*layerProgress)}
+}
+
+// The messages are separated by new lines, a write may end in the middle of one.
+func (p *pullProgress) Write(b []byte) (int, error) {
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			break
 		}
+		p.record(bytes.TrimSpace(p.partial[:i]))
+		p.partial = p.partial[i+1:]
 	}
-	return nil
+	return len(b), nil
+}
+
+func (p *pullProgress) record(line []byte) {
+	var msg pullMessage
+	if len(line) == 0 || json.Unmarshal(line, &msg) != nil || msg.ID == "" {
+		return
+	}
+
+	switch msg.Status {
+	case "Downloading":
+		p.layers[msg.ID] = &layerProgress{current: msg.ProgressDetail.Current, total: msg.ProgressDetail.Total}
+	case "Download complete", "Pull complete":
+		if l, ok := p.layers[msg.ID]; ok {
+			l.current = l.total
+		}
+	default:
+		return
+	}
+
+	var current, total int64
+	for _, l := range p.layers {
+		current += l.current
+		total += l.total
+	}
+	p.Set(current, total)
 }
 
-func listImages(client *docker.Client) ([]docker.APIImages, error) {
+func listImages(client container.ContainerRuntime) ([]docker.APIImages, error) {
 
 	if images, err := client.ListImages(docker.ListImagesOptions{
 		All: true,
@@ -214,7 +447,7 @@ func listImages(client *docker.Client) ([]docker.APIImages, error) {
 }
 
 // TODO: user needs to use image IDs instead of repotags to avoid overwriting or otherwise mistaken handling because of name collisions
-func SkipCheckFn(client *docker.Client) func(repotag string) (bool, error) {
+func SkipCheckFn(client container.ContainerRuntime) func(repotag string) (bool, error) {
 
 	return func(repotag string) (bool, error) {
 		repotagParts := strings.Split(repotag, ":")
@@ -5,10 +5,12 @@ package imagefetch
 import (
 	docker "github.com/fsouza/go-dockerclient"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/download"
 	"github.com/open-horizon/anax/persistence"
 	"github.com/stretchr/testify/assert"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func Test_authDockerFile(t *testing.T) {
@@ -61,3 +63,57 @@ func Test_authDockerFile(t *testing.T) {
 	assert.Equal(t, 1, len(dockerAuthConfigurations["myrepo3.com"]), "The docker auth array should have 1 items.")
 
 }
+
+func Test_repoDigest(t *testing.T) {
+
+	d1 := "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	d2 := "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+
+	repoDigests := []string{"busybox@" + d1, "myrepo.com:5000/org/myimage@" + d2}
+
+	assert.Equal(t, d1, repoDigest(repoDigests, "busybox"), "docker hub image should match its short name")
+	assert.Equal(t, d1, repoDigest(repoDigests, "library/busybox"), "docker hub image should match with the library prefix")
+	assert.Equal(t, d1, repoDigest(repoDigests, "docker.io/library/busybox"), "docker hub image should match with the domain")
+	assert.Equal(t, d2, repoDigest(repoDigests, "myrepo.com:5000/org/myimage"), "private registry image should match")
+	assert.Equal(t, "", repoDigest(repoDigests, "org/myimage"), "image from a different registry should not match")
+	assert.Equal(t, "", repoDigest([]string{}, "busybox"), "locally built image has no digest")
+}
+
+func Test_pullBackoff(t *testing.T) {
+
+	assert.Equal(t, 15*time.Second, pullBackoff(15, 1))
+	assert.Equal(t, 30*time.Second, pullBackoff(15, 2))
+	assert.Equal(t, 60*time.Second, pullBackoff(15, 3))
+	assert.Equal(t, maxPullBackoffS*time.Second, pullBackoff(15, 10))
+	assert.Equal(t, maxPullBackoffS*time.Second, pullBackoff(15, 100))
+	assert.Equal(t, time.Duration(0), pullBackoff(0, 3))
+}
+
+func Test_pullProgress(t *testing.T) {
+
+	p := newPullProgress("image busybox:latest")
+	defer p.Done()
+
+	// a message may be split between writes
+	p.Write([]byte(`{"status":"Pulling fs layer","id":"a1"}` + "\r\n" + `{"status":"Downloading","progressDetail":{"current":100,"total":1000},"id":"a1"}` + "\r\n" + `{"status":"Downl`))
+	p.Write([]byte(`oading","progressDetail":{"current":50,"total":500},"id":"b2"}` + "\r\n"))
+
+	find := func() download.Transfer {
+		for _, tr := range download.GetStatus().Transfers {
+			if tr.Name == "image busybox:latest" {
+				return tr
+			}
+		}
+		t.Fatalf("the image pull is not listed in the download status")
+		return download.Transfer{}
+	}
+
+	tr := find()
+	assert.Equal(t, int64(150), tr.Bytes)
+	assert.Equal(t, int64(1500), tr.Size)
+	assert.False(t, tr.Limited, "image pulls are not limited")
+
+	p.Write([]byte(`{"status":"Download complete","id":"a1"}` + "\n" + `not json` + "\n"))
+	tr = find()
+	assert.Equal(t, int64(1050), tr.Bytes)
+}
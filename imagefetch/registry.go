@@ -0,0 +1,405 @@
+package imagefetch
+
+import (
+	docker "github.com/fsouza/go-dockerclient"
+
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/container"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/download"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+/*
+ * The container runtime pulls the images on its own, the agent cannot limit the rate of its downloads. While the
+ * download rate limit of the agent applies, the agent fetches the images from their registry itself with the
+ * distribution API of the registry, reads the blobs of the images through the limit, and streams them to the runtime
+ * as a docker archive, as for docker load. The runtime then knows the image by its ID and its tag, not by its digest.
+ */
+
+const (
+	mediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+)
+
+// The host of the registry of the images that have no domain or are on docker.io.
+const dockerHubRegistry = "registry-1.docker.io"
+
+// A manifest, or a list of manifests for several platforms, as served by a registry.
+type registryManifest struct {
+	MediaType string               `json:"mediaType"`
+	Config    registryDescriptor   `json:"config"`
+	Layers    []registryDescriptor `json:"layers"`
+	Manifests []registryDescriptor `json:"manifests"`
+}
+
+type registryDescriptor struct {
+	MediaType string            `json:"mediaType"`
+	Digest    string            `json:"digest"`
+	Size      int64             `json:"size"`
+	Platform  *registryPlatform `json:"platform,omitempty"`
+}
+
+type registryPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+// The manifest.json of a docker archive.
+type archiveManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// An image that the agent fetched from its registry and loaded into the container runtime.
+type fetchedImage struct {
+	Digest string // the digest of the image in the registry, as it is pinned
+	ID     string // the ID of the image in the container runtime, the digest of its config
+}
+
+func (f fetchedImage) String() string {
+	return fmt.Sprintf("Digest: %v, ID: %v", f.Digest, f.ID)
+}
+
+// A client of the distribution API of the registry of a repository.
+type registryClient struct {
+	client *http.Client
+	base   string // the URL of the API, e.g. https://registry-1.docker.io/v2/
+	repo   string // the repository in the registry, e.g. library/busybox
+	auth   docker.AuthConfiguration
+	token  string // the bearer token of the registry, once it asked for one
+	basic  bool   // true once the registry asked for basic auth
+}
+
+// The attributes of the challenge of a registry, e.g. Bearer realm="https://auth.docker.io/token",service="registry.docker.io".
+var challengeParamRE = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// Returns a client of the registry of the repository of an image, as the container runtime would find it.
+func newRegistryClient(domain string, path string, auth docker.AuthConfiguration) *registryClient {
+	host := domain
+	if host == "" || host == "docker.io" || host == "index.docker.io" {
+		host = dockerHubRegistry
+		if !strings.Contains(path, "/") {
+			path = "library/" + path
+		}
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			Dial: (&net.Dialer{
+				Timeout:   20 * time.Second,
+				KeepAlive: 60 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout:   20 * time.Second,
+			ResponseHeaderTimeout: 60 * time.Second,
+		},
+	}
+	return &registryClient{client: client, base: fmt.Sprintf("https://%v/v2/", host), repo: path, auth: auth}
+}
+
+// Make a request to the registry, with the credentials that it asked for. The body of the response must be closed.
+func (r *registryClient) get(path string, accept []string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest("GET", r.base+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range accept {
+			req.Header.Add("Accept", a)
+		}
+		if r.token != "" {
+			req.Header.Set("Authorization", "Bearer "+r.token)
+		} else if r.basic && r.auth.Username != "" {
+			req.SetBasicAuth(r.auth.Username, r.auth.Password)
+		}
+
+		resp, err := r.client.Do(req)
+		if err != nil {
+			return nil, err
+		} else if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			challenge := resp.Header.Get("Www-Authenticate")
+			resp.Body.Close()
+			if err := r.authorize(challenge); err != nil {
+				return nil, err
+			}
+			continue
+		} else if resp.StatusCode != http.StatusOK {
+			body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+			resp.Body.Close()
+			return nil, fmt.Errorf("GET %v%v returned %v: %v", r.base, path, resp.Status, strings.TrimSpace(string(body)))
+		}
+		return resp, nil
+	}
+}
+
+// Get the credentials that the registry asked for in its challenge, a bearer token or basic auth.
+func (r *registryClient) authorize(challenge string) error {
+	params := make(map[string]string)
+	for _, m := range challengeParamRE.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(m[1])] = m[2]
+	}
+
+	if strings.HasPrefix(strings.ToLower(challenge), "basic") {
+		if r.auth.Username == "" {
+			return fmt.Errorf("registry %v requires credentials", r.base)
+		}
+		r.basic = true
+		return nil
+	} else if !strings.HasPrefix(strings.ToLower(challenge), "bearer") || params["realm"] == "" {
+		return fmt.Errorf("registry %v returned an unsupported challenge %v", r.base, challenge)
+	}
+
+	query := url.Values{}
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%v:pull", r.repo)
+	}
+	query.Set("scope", scope)
+
+	req, err := http.NewRequest("GET", params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if r.auth.Username != "" {
+		req.SetBasicAuth(r.auth.Username, r.auth.Password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to get a token from %v, error %v", params["realm"], err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to get a token from %v, it returned %v", params["realm"], resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("unable to read the token from %v, error %v", params["realm"], err)
+	}
+	if r.token = token.Token; r.token == "" {
+		r.token = token.AccessToken
+	}
+	if r.token == "" {
+		return fmt.Errorf("%v returned no token", params["realm"])
+	}
+	return nil
+}
+
+// Returns the manifest of the image with the given tag or digest for the platform of the node, and the digest of the
+// image as the registry serves it, which is the digest of the list of manifests for an image of several platforms.
+func (r *registryClient) manifest(reference string) (*registryManifest, string, error) {
+	accept := []string{mediaTypeManifestList, mediaTypeManifest, mediaTypeOCIIndex, mediaTypeOCIManifest}
+
+	m, digest, err := r.readManifest(reference, accept)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if m.MediaType == mediaTypeManifestList || m.MediaType == mediaTypeOCIIndex || len(m.Manifests) != 0 {
+		var chosen *registryDescriptor
+		for i, d := range m.Manifests {
+			if d.Platform != nil && d.Platform.OS == "linux" && d.Platform.Architecture == runtime.GOARCH {
+				chosen = &m.Manifests[i]
+				break
+			}
+		}
+		if chosen == nil {
+			return nil, "", fmt.Errorf("image %v:%v has no manifest for linux/%v", r.repo, reference, runtime.GOARCH)
+		}
+		if m, _, err = r.readManifest(chosen.Digest, accept[1:]); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if m.Config.Digest == "" {
+		return nil, "", fmt.Errorf("image %v:%v has a manifest of type %v, it is not supported", r.repo, reference, m.MediaType)
+	}
+	return m, digest, nil
+}
+
+func (r *registryClient) readManifest(reference string, accept []string) (*registryManifest, string, error) {
+	resp, err := r.get(fmt.Sprintf("%v/manifests/%v", r.repo, reference), accept)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4*1024*1024))
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read the manifest of %v:%v, error %v", r.repo, reference, err)
+	}
+
+	sum := sha256.Sum256(body)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if strings.HasPrefix(reference, "sha256:") && reference != digest {
+		return nil, "", fmt.Errorf("the manifest of %v@%v has the digest %v", r.repo, reference, digest)
+	}
+
+	m := new(registryManifest)
+	if err := json.Unmarshal(body, m); err != nil {
+		return nil, "", fmt.Errorf("unable to demarshal the manifest of %v:%v, error %v", r.repo, reference, err)
+	}
+	if m.MediaType == "" {
+		m.MediaType = resp.Header.Get("Content-Type")
+	}
+	return m, digest, nil
+}
+
+// Write the blob with the given digest into the archive, as the file with the given name. It is read through the
+// download rate limit of the agent, and checked against its digest.
+func (r *registryClient) writeBlob(tw *tar.Writer, name string, d registryDescriptor, image string) error {
+	algorithm, hexDigest := splitDigest(d.Digest)
+	if algorithm != "sha256" {
+		return fmt.Errorf("blob %v of %v has an unsupported digest", d.Digest, image)
+	}
+
+	resp, err := r.get(fmt.Sprintf("%v/blobs/%v", r.repo, d.Digest), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: d.Size, ModTime: time.Unix(0, 0)}); err != nil {
+		return err
+	}
+
+	body := download.NewReader(fmt.Sprintf("image %v blob %v", image, d.Digest), d.Size, resp.Body)
+	defer body.Done()
+
+	hash := sha256.New()
+	if n, err := io.Copy(tw, io.TeeReader(io.LimitReader(body, d.Size), hash)); err != nil {
+		return fmt.Errorf("unable to read blob %v of %v, error %v", d.Digest, image, err)
+	} else if n != d.Size {
+		return fmt.Errorf("blob %v of %v has %v bytes, expected %v", d.Digest, image, n, d.Size)
+	} else if hex.EncodeToString(hash.Sum(nil)) != hexDigest {
+		return fmt.Errorf("blob %v of %v does not match its digest", d.Digest, image)
+	}
+	return nil
+}
+
+// Write the image with the given tag or digest as a docker archive, with its blobs read through the download rate
+// limit. The image is tagged with the tag, if there is one.
+func (r *registryClient) fetch(reference string, repoTag string, w io.Writer) (*fetchedImage, error) {
+	image := fmt.Sprintf("%v:%v", r.repo, reference)
+	m, digest, err := r.manifest(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	tw := tar.NewWriter(w)
+	_, configHex := splitDigest(m.Config.Digest)
+	out := archiveManifest{Config: configHex + ".json", Layers: make([]string, 0, len(m.Layers))}
+	if repoTag != "" {
+		out.RepoTags = []string{repoTag}
+	}
+
+	if err := r.writeBlob(tw, out.Config, m.Config, image); err != nil {
+		return nil, err
+	}
+	written := make(map[string]bool)
+	for _, layer := range m.Layers {
+		_, layerHex := splitDigest(layer.Digest)
+		name := layerHex + "/layer.tar"
+		out.Layers = append(out.Layers, name)
+		if written[name] {
+			continue
+		}
+		// the runtime decompresses the layers when it loads them
+		if err := r.writeBlob(tw, name, layer, image); err != nil {
+			return nil, err
+		}
+		written[name] = true
+	}
+
+	manifest, err := json.Marshal([]archiveManifest{out})
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0644, Size: int64(len(manifest)), ModTime: time.Unix(0, 0)}); err != nil {
+		return nil, err
+	} else if _, err := tw.Write(manifest); err != nil {
+		return nil, err
+	} else if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	return &fetchedImage{Digest: digest, ID: m.Config.Digest}, nil
+}
+
+// Returns the algorithm and the hex of a digest, e.g. sha256 and the hex for sha256:<hex>.
+func splitDigest(digest string) (string, string) {
+	if parts := strings.SplitN(digest, ":", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return "", digest
+}
+
+// Fetch the image of the pull options from its registry through the download rate limit of the agent, and load it
+// into the container runtime.
+func loadImageFromRegistry(client container.ContainerRuntime, opts docker.PullImageOptions, auth docker.AuthConfiguration) (*fetchedImage, error) {
+	domain, path, tag, digest := cutil.ParseDockerImagePath(opts.Repository)
+	if path == "" {
+		return nil, fmt.Errorf("invalid image name %v", opts.Repository)
+	}
+	reference, repoTag := digest, ""
+	if reference == "" {
+		if reference = opts.Tag; reference == "" {
+			if reference = tag; reference == "" {
+				reference = "latest"
+			}
+		}
+		repoTag = cutil.FormDockerImageName(domain, path, reference, "")
+	}
+
+	glog.V(3).Infof("Fetching image %v within the download rate limit", opts.Repository)
+	registry := newRegistryClient(domain, path, auth)
+
+	pr, pw := io.Pipe()
+	type result struct {
+		image *fetchedImage
+		err   error
+	}
+	fetched := make(chan result, 1)
+	go func() {
+		image, err := registry.fetch(reference, repoTag, pw)
+		pw.CloseWithError(err)
+		fetched <- result{image, err}
+	}()
+
+	loadErr := client.LoadImage(docker.LoadImageOptions{InputStream: pr})
+	// the fetch stops if the runtime did not read the whole archive
+	pr.CloseWithError(errors.New("the container runtime stopped reading the image"))
+	r := <-fetched
+	if r.err != nil {
+		return nil, r.err
+	} else if loadErr != nil {
+		return nil, fmt.Errorf("unable to load image %v, error %v", opts.Repository, loadErr)
+	}
+
+	glog.V(3).Infof("Fetched image %v: %v", opts.Repository, r.image)
+	return r.image, nil
+}
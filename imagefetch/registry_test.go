@@ -0,0 +1,160 @@
+// +build unit
+
+package imagefetch
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func sha256Digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// A registry that serves one image for several platforms, to the clients with a bearer token.
+func newTestRegistry(t *testing.T, blobs map[string][]byte, corrupt bool) (*httptest.Server, string) {
+	config := []byte(`{"architecture":"` + runtime.GOARCH + `"}`)
+	layer := []byte("the compressed layer")
+	blobs[sha256Digest(config)] = config
+	blobs[sha256Digest(layer)] = layer
+
+	manifest, _ := json.Marshal(registryManifest{
+		MediaType: mediaTypeManifest,
+		Config:    registryDescriptor{Digest: sha256Digest(config), Size: int64(len(config))},
+		Layers:    []registryDescriptor{{Digest: sha256Digest(layer), Size: int64(len(layer))}, {Digest: sha256Digest(layer), Size: int64(len(layer))}},
+	})
+	list, _ := json.Marshal(registryManifest{
+		MediaType: mediaTypeManifestList,
+		Manifests: []registryDescriptor{
+			{Digest: "sha256:other", Platform: &registryPlatform{Architecture: "other", OS: "linux"}},
+			{Digest: sha256Digest(manifest), Platform: &registryPlatform{Architecture: runtime.GOARCH, OS: "linux"}},
+		},
+	})
+
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if user, pw, _ := r.BasicAuth(); user != "user" || pw != "pw" || r.URL.Query().Get("scope") != "repository:myorg/myimage:pull" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"token": "mytoken"}`))
+			return
+		} else if r.Header.Get("Authorization") != "Bearer mytoken" {
+			w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="%v/token",service="registry"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.URL.Path == "/v2/myorg/myimage/manifests/1.0.0":
+			w.Header().Set("Content-Type", mediaTypeManifestList)
+			w.Write(list)
+		case r.URL.Path == "/v2/myorg/myimage/manifests/"+sha256Digest(manifest):
+			w.Header().Set("Content-Type", mediaTypeManifest)
+			w.Write(manifest)
+		case strings.HasPrefix(r.URL.Path, "/v2/myorg/myimage/blobs/"):
+			if b, ok := blobs[strings.TrimPrefix(r.URL.Path, "/v2/myorg/myimage/blobs/")]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+			} else if corrupt {
+				w.Write(bytes.ToUpper(b))
+			} else {
+				w.Write(b)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server, sha256Digest(list)
+}
+
+func testRegistryClient(server *httptest.Server) *registryClient {
+	return &registryClient{client: server.Client(), base: server.URL + "/v2/", repo: "myorg/myimage", auth: docker.AuthConfiguration{Username: "user", Password: "pw"}}
+}
+
+// The image for the platform of the node is written as a docker archive, with each blob once.
+func Test_registryClient_fetch(t *testing.T) {
+
+	blobs := make(map[string][]byte)
+	server, listDigest := newTestRegistry(t, blobs, false)
+	defer server.Close()
+
+	var archive bytes.Buffer
+	image, err := testRegistryClient(server).fetch("1.0.0", "myregistry.com/myorg/myimage:1.0.0", &archive)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if image.Digest != listDigest {
+		t.Errorf("the digest should be the one of the list of manifests %v, is %v", listDigest, image.Digest)
+	}
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(&archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("unable to read the archive, error %v", err)
+		}
+		files[hdr.Name], _ = ioutil.ReadAll(tr)
+	}
+	if len(files) != 3 {
+		t.Errorf("the archive should have the config, the layer and the manifest, has %v", len(files))
+	}
+
+	var manifest []archiveManifest
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil || len(manifest) != 1 {
+		t.Fatalf("wrong manifest %v, error %v", string(files["manifest.json"]), err)
+	} else if len(manifest[0].RepoTags) != 1 || manifest[0].RepoTags[0] != "myregistry.com/myorg/myimage:1.0.0" {
+		t.Errorf("wrong tags %v", manifest[0].RepoTags)
+	} else if len(manifest[0].Layers) != 2 || manifest[0].Layers[0] != manifest[0].Layers[1] {
+		t.Errorf("wrong layers %v", manifest[0].Layers)
+	} else if "sha256:"+strings.TrimSuffix(manifest[0].Config, ".json") != image.ID {
+		t.Errorf("the ID of the image should be the digest of its config %v, is %v", manifest[0].Config, image.ID)
+	}
+	for _, name := range append(manifest[0].Layers, manifest[0].Config) {
+		if b, ok := files[name]; !ok || sha256Digest(b) != "sha256:"+strings.TrimSuffix(strings.TrimSuffix(name, ".json"), "/layer.tar") {
+			t.Errorf("blob %v is missing or wrong", name)
+		}
+	}
+}
+
+// A blob that does not match its digest fails the fetch.
+func Test_registryClient_fetch_digest_mismatch(t *testing.T) {
+
+	server, _ := newTestRegistry(t, make(map[string][]byte), true)
+	defer server.Close()
+
+	if _, err := testRegistryClient(server).fetch("1.0.0", "", ioutil.Discard); err == nil || !strings.Contains(err.Error(), "does not match its digest") {
+		t.Errorf("the fetch should fail on the corrupted blob, the error is %v", err)
+	}
+
+	rc := testRegistryClient(server)
+	rc.auth = docker.AuthConfiguration{Username: "user", Password: "wrong"}
+	if _, err := rc.fetch("1.0.0", "", ioutil.Discard); err == nil {
+		t.Errorf("the fetch should fail without a token")
+	}
+}
+
+func Test_newRegistryClient(t *testing.T) {
+
+	if rc := newRegistryClient("", "busybox", docker.AuthConfiguration{}); rc.base != "https://registry-1.docker.io/v2/" || rc.repo != "library/busybox" {
+		t.Errorf("wrong client for docker hub %v %v", rc.base, rc.repo)
+	} else if rc := newRegistryClient("myregistry.com:5000", "myorg/myimage", docker.AuthConfiguration{}); rc.base != "https://myregistry.com:5000/v2/" || rc.repo != "myorg/myimage" {
+		t.Errorf("wrong client for a private registry %v %v", rc.base, rc.repo)
+	}
+}
@@ -55,7 +55,7 @@ type YamlFile struct {
 
 // Client to interact with all standard k8s objects
 type KubeClient struct {
-	Client *kubernetes.Clientset
+	Client kubernetes.Interface
 }
 
 // KubeStatus contains the status of operator pods and a user-defined status object
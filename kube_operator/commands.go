@@ -68,3 +68,26 @@ func NewMaintenanceCommand(protocol string, agreementId string, deployment persi
 		Deployment:        deployment,
 	}
 }
+
+// The end of the wait for the rollout of the workload of an agreement, with the last rollout statuses of its services.
+type RolloutCommand struct {
+	AgreementProtocol string
+	AgreementId       string
+	Deployment        *persistence.KubeDeploymentConfig
+	Statuses          []WorkloadServiceStatus
+	Err               error
+}
+
+func (c RolloutCommand) ShortString() string {
+	return fmt.Sprintf("AgreementProtocol: %v, AgreementId: %v, Statuses: %v, Err: %v", c.AgreementProtocol, c.AgreementId, c.Statuses, c.Err)
+}
+
+func NewRolloutCommand(protocol string, agreementId string, deployment *persistence.KubeDeploymentConfig, statuses []WorkloadServiceStatus, err error) *RolloutCommand {
+	return &RolloutCommand{
+		AgreementProtocol: protocol,
+		AgreementId:       agreementId,
+		Deployment:        deployment,
+		Statuses:          statuses,
+		Err:               err,
+	}
+}
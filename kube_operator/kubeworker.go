@@ -1,30 +1,83 @@
 package kube_operator
 
 import (
+	"context"
 	"fmt"
 	"github.com/boltdb/bolt"
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/eventlog"
 	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/i18n"
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/worker"
+	"strings"
+	"time"
 )
 
+const (
+	EL_KUBE_SCOPE_PROBLEM = "The workloads cannot be deployed to the cluster as configured by Edge.KubeScope: %v"
+)
+
+// This is does nothing useful at run time.
+// This code is only used in compileing time to make the eventlog messages gets into the catalog so that
+// they can be translated.
+// The event log messages will be saved in English. But the CLI can request them in different languages.
+func MarkI18nMessages() {
+	// get message printer. anax default language is English
+	msgPrinter := i18n.GetMessagePrinter()
+
+	msgPrinter.Sprintf(EL_KUBE_SCOPE_PROBLEM)
+}
+
 type KubeWorker struct {
 	worker.BaseWorker
-	db *bolt.DB
+	db       *bolt.DB
+	rollouts map[string]context.CancelFunc // stops the wait for the rollout of the workload of each agreement
 }
 
 func NewKubeWorker(name string, config *config.HorizonConfig, db *bolt.DB) *KubeWorker {
 	worker := &KubeWorker{
 		BaseWorker: worker.NewBaseWorker(name, config, nil),
 		db:         db,
+		rollouts:   make(map[string]context.CancelFunc),
 	}
 	glog.Info(kwlog(fmt.Sprintf("Starting Kubernetes Worker")))
 	worker.Start(worker, 0)
 	return worker
 }
 
+// Check the Kubernetes scope against the cluster, when the node deploys to one. The problems are reported, the
+// workloads that are affected fail when they are installed.
+func (w *KubeWorker) Initialize() bool {
+	scope := &w.Config.Edge.KubeScope
+	if len(scope.Namespaces) == 0 && w.Config.Edge.KubeConfigFile == "" {
+		return true
+	}
+
+	client, err := NewKubeClient()
+	if err != nil {
+		glog.Errorf(kwlog(fmt.Sprintf("unable to check the Kubernetes scope, error: %v", err)))
+		return true
+	}
+	problems, err := client.CheckScope(scope)
+	if err != nil {
+		glog.Errorf(kwlog(fmt.Sprintf("unable to check the Kubernetes scope, error: %v", err)))
+		return true
+	}
+
+	for _, problem := range problems {
+		glog.Errorf(kwlog(fmt.Sprintf("Kubernetes scope problem: %v", problem)))
+		if dev, err := persistence.FindExchangeDevice(w.db); err == nil && dev != nil {
+			eventlog.LogNodeEvent(w.db, persistence.SEVERITY_ERROR, persistence.NewMessageMeta(EL_KUBE_SCOPE_PROBLEM, problem), persistence.EC_ERROR_KUBE_SCOPE, dev.Id, dev.Org, dev.Pattern, dev.Config.State)
+		}
+	}
+	if len(problems) == 0 {
+		glog.V(3).Infof(kwlog(fmt.Sprintf("the Kubernetes scope %v is usable", scope.Namespaces)))
+	}
+	return true
+}
+
 func (w *KubeWorker) Messages() chan events.Message {
 	return w.BaseWorker.Manager.Messages
 }
@@ -84,17 +137,28 @@ func (w *KubeWorker) CommandHandler(command worker.Command) bool {
 
 			// Check the deployment to check if it is a kube deployment
 			deploymentConfig := lc.ContainerConfig().ClusterDeployment
-			if kd, err := persistence.GetKubeDeployment(deploymentConfig); err != nil {
+			kd, err := persistence.GetKubeDeployment(deploymentConfig)
+			if err != nil {
 				glog.Errorf(kwlog(fmt.Sprintf("error getting kube deployment configuration: %v", err)))
 				return true
-			} else if _, err := persistence.AgreementDeploymentStarted(w.db, lc.AgreementId, lc.AgreementProtocol, kd); err != nil {
+			}
+
+			// the namespace is saved with the deployment, so that the workload is found there when the scope changes
+			if kd.IsWorkload() && kd.Workload.Namespace == "" {
+				kd.Workload.Namespace = w.Config.Edge.KubeScope.DefaultNamespace()
+			}
+
+			if _, err := persistence.AgreementDeploymentStarted(w.db, lc.AgreementId, lc.AgreementProtocol, kd); err != nil {
 				glog.Errorf(kwlog(fmt.Sprintf("received error updating database deployment state, %v", err)))
 				w.Messages() <- events.NewWorkloadMessage(events.EXECUTION_FAILED, lc.AgreementProtocol, lc.AgreementId, kd)
 				return true
-			} else if err := w.processKubeOperator(lc, kd); err != nil {
+			} else if err := w.installKubeDeployment(lc, kd); err != nil {
 				glog.Errorf(kwlog(fmt.Sprintf("failed to process kube package after agreement negotiation: %v", err)))
 				w.Messages() <- events.NewWorkloadMessage(events.EXECUTION_FAILED, lc.AgreementProtocol, lc.AgreementId, kd)
 				return true
+			} else if kd.IsWorkload() {
+				// the workload begins once its Deployments have rolled out, see RolloutCommand
+				w.waitForRollout(lc.AgreementProtocol, lc.AgreementId, kd)
 			} else {
 				w.Messages() <- events.NewWorkloadMessage(events.EXECUTION_BEGUN, lc.AgreementProtocol, lc.AgreementId, kd)
 			}
@@ -107,11 +171,28 @@ func (w *KubeWorker) CommandHandler(command worker.Command) bool {
 		if !ok {
 			glog.Warningf(kwlog(fmt.Sprintf("ignoring non-Kube cancelation command %v", cmd)))
 			return true
-		} else if err := w.uninstallKubeOperator(kdc, cmd.CurrentAgreementId); err != nil {
+		}
+		w.stopRollout(cmd.CurrentAgreementId)
+		if err := w.uninstallKubeDeployment(kdc, cmd.CurrentAgreementId); err != nil {
 			glog.Errorf(kwlog(fmt.Sprintf("failed to uninstall kube operator %v", cmd.Deployment)))
 		}
 
 		w.Messages() <- events.NewWorkloadMessage(events.WORKLOAD_DESTROYED, cmd.AgreementProtocol, cmd.CurrentAgreementId, kdc)
+	case *RolloutCommand:
+		cmd := command.(*RolloutCommand)
+		if _, ok := w.rollouts[cmd.AgreementId]; !ok {
+			glog.V(3).Infof(kwlog(fmt.Sprintf("ignoring the rollout of the workload of agreement %v, it was uninstalled", cmd.AgreementId)))
+			return true
+		}
+		w.stopRollout(cmd.AgreementId)
+
+		w.recordWorkloadHealth(cmd.Statuses, cmd.AgreementId)
+		if cmd.Err != nil {
+			glog.Errorf(kwlog(fmt.Sprintf("the workload of agreement %v did not roll out: %v", cmd.AgreementId, cmd.Err)))
+			w.Messages() <- events.NewWorkloadMessage(events.EXECUTION_FAILED, cmd.AgreementProtocol, cmd.AgreementId, cmd.Deployment)
+		} else {
+			w.Messages() <- events.NewWorkloadMessage(events.EXECUTION_BEGUN, cmd.AgreementProtocol, cmd.AgreementId, cmd.Deployment)
+		}
 	case *MaintenanceCommand:
 		cmd := command.(*MaintenanceCommand)
 		glog.V(3).Infof(kwlog(fmt.Sprintf("recieved maintenance command %v", cmd)))
@@ -119,7 +200,7 @@ func (w *KubeWorker) CommandHandler(command worker.Command) bool {
 		kdc, ok := cmd.Deployment.(*persistence.KubeDeploymentConfig)
 		if !ok {
 			glog.Warningf(kwlog(fmt.Sprintf("ignoring non-Kube maintenence command: %v", cmd)))
-		} else if err := w.operatorStatus(kdc, "Running", cmd.AgreementId); err != nil {
+		} else if err := w.kubeDeploymentStatus(kdc, cmd.AgreementId); err != nil {
 			glog.Errorf(kwlog(fmt.Sprintf("%v", err)))
 			w.Messages() <- events.NewWorkloadMessage(events.EXECUTION_FAILED, cmd.AgreementProtocol, cmd.AgreementId, kdc)
 		}
@@ -138,6 +219,28 @@ func (w *KubeWorker) getLaunchContext(launchContext interface{}) *events.Agreeme
 	return nil
 }
 
+// Install the cluster deployment, a workload or an operator.
+func (w *KubeWorker) installKubeDeployment(lc *events.AgreementLaunchContext, kd *persistence.KubeDeploymentConfig) error {
+	if kd.IsWorkload() {
+		return w.processKubeWorkload(lc, kd.Workload)
+	}
+	return w.processKubeOperator(lc, kd)
+}
+
+func (w *KubeWorker) uninstallKubeDeployment(kd *persistence.KubeDeploymentConfig, agId string) error {
+	if kd.IsWorkload() {
+		return w.uninstallKubeWorkload(kd.Workload, agId)
+	}
+	return w.uninstallKubeOperator(kd, agId)
+}
+
+func (w *KubeWorker) kubeDeploymentStatus(kd *persistence.KubeDeploymentConfig, agId string) error {
+	if kd.IsWorkload() {
+		return w.workloadStatus(kd.Workload, agId)
+	}
+	return w.operatorStatus(kd, "Running", agId)
+}
+
 func (w *KubeWorker) processKubeOperator(lc *events.AgreementLaunchContext, kd *persistence.KubeDeploymentConfig) error {
 	glog.V(3).Infof(kwlog(fmt.Sprintf("begin install of Kube Deployment %s", lc.AgreementId)))
 	client, err := NewKubeClient()
@@ -186,6 +289,137 @@ func (w *KubeWorker) operatorStatus(kd *persistence.KubeDeploymentConfig, intend
 	return nil
 }
 
+// The longest wait for the Deployments of a workload to roll out.
+func (w *KubeWorker) rolloutTimeout() time.Duration {
+	if w.Config.Edge.KubeRolloutTimeoutS == 0 {
+		return config.KubeRolloutTimeoutS_DEFAULT * time.Second
+	}
+	return time.Duration(w.Config.Edge.KubeRolloutTimeoutS) * time.Second
+}
+
+// Create the Kubernetes objects of the workload. The agreement's workload has not begun until its Deployments have
+// rolled out, see waitForRollout.
+func (w *KubeWorker) processKubeWorkload(lc *events.AgreementLaunchContext, workload *persistence.KubeWorkload) error {
+	glog.V(3).Infof(kwlog(fmt.Sprintf("begin install of Kube workload %s", lc.AgreementId)))
+	client, err := NewKubeClient()
+	if err != nil {
+		return err
+	}
+	return client.InstallWorkload(workload, *(lc.EnvironmentAdditions), lc.AgreementId, w.rolloutTimeout(), &w.Config.Edge.KubeScope)
+}
+
+// Wait for the Deployments of the workload of the agreement to roll out, without holding up the other commands of the
+// worker. The end of the wait is sent back to the worker as a RolloutCommand. The wait stops when the workload is
+// uninstalled.
+func (w *KubeWorker) waitForRollout(protocol string, agId string, kd *persistence.KubeDeploymentConfig) {
+	w.stopRollout(agId)
+
+	client, err := NewKubeClient()
+	if err != nil {
+		w.Commands <- NewRolloutCommand(protocol, agId, kd, nil, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w.rollouts[agId] = cancel
+	timeout := w.rolloutTimeout()
+	go func() {
+		statuses, err := client.WaitForRollout(ctx, kd.Workload, agId, timeout)
+		if ctx.Err() != nil {
+			return
+		}
+		select {
+		case w.Commands <- NewRolloutCommand(protocol, agId, kd, statuses, err):
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// Stop waiting for the rollout of the workload of the agreement, if the worker is.
+func (w *KubeWorker) stopRollout(agId string) {
+	if cancel, ok := w.rollouts[agId]; ok {
+		cancel()
+		delete(w.rollouts, agId)
+	}
+}
+
+func (w *KubeWorker) uninstallKubeWorkload(workload *persistence.KubeWorkload, agId string) error {
+	glog.V(3).Infof(kwlog(fmt.Sprintf("begin uninstall of Kube workload %s", agId)))
+	client, err := NewKubeClient()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range workload.ServiceNames() {
+		if err := persistence.DeleteContainerHealth(w.db, workloadHealthName(workloadNamespace(workload), workloadObjectName(name, agId))); err != nil {
+			glog.Errorf(kwlog(fmt.Sprintf("unable to delete the health of workload service %v, error: %v", name, err)))
+		}
+	}
+	return client.UninstallWorkload(workload, agId)
+}
+
+// Check the rollout of the workload's Deployments. A Deployment that is missing or failed to progress fails the
+// workload, one that is progressing, e.g. while a pod restarts, is reported as unhealthy.
+func (w *KubeWorker) workloadStatus(workload *persistence.KubeWorkload, agId string) error {
+	client, err := NewKubeClient()
+	if err != nil {
+		return err
+	}
+	statuses, err := client.WorkloadStatus(workload, agId)
+	if err != nil {
+		return err
+	}
+	w.recordWorkloadHealth(statuses, agId)
+
+	retErrorStr := ""
+	for _, s := range statuses {
+		if s.State == ROLLOUT_FAILED || s.State == ROLLOUT_NOT_FOUND {
+			retErrorStr = fmt.Sprintf("%s %s", retErrorStr, fmt.Sprintf("Deployment %s has status %s %s.", s.Name, s.State, s.Message))
+		}
+	}
+	if retErrorStr != "" {
+		return fmt.Errorf(retErrorStr)
+	}
+	return nil
+}
+
+// The name of the health record of a workload service in an agreement, from the name of its Deployment. It is unique
+// in the cluster.
+func workloadHealthName(namespace string, deployment string) string {
+	return fmt.Sprintf("%v/%v", namespace, deployment)
+}
+
+// Save the rollout states of the workload services as their health, so that they are shown by the service health API.
+func (w *KubeWorker) recordWorkloadHealth(statuses []WorkloadServiceStatus, agId string) {
+	for _, s := range statuses {
+		healthName := workloadHealthName(s.Namespace, s.Deployment)
+		health, err := persistence.FindContainerHealthWithName(w.db, healthName)
+		if err != nil {
+			glog.Errorf(kwlog(fmt.Sprintf("unable to read the health of workload service %v, error: %v", healthName, err)))
+			continue
+		} else if health == nil {
+			health = persistence.NewContainerHealth(healthName, agId, s.Name)
+		}
+
+		status, failure := persistence.CONTAINER_HEALTHY, ""
+		if s.State != ROLLOUT_RUNNING {
+			status, failure = persistence.CONTAINER_UNHEALTHY, strings.TrimSpace(fmt.Sprintf("%v %v", s.State, s.Message))
+			health.ConsecutiveFailures++
+			health.LastFailure = failure
+		} else {
+			health.ConsecutiveFailures = 0
+		}
+		if health.Status != status {
+			health.Status = status
+			health.LastChangeTime = uint64(time.Now().Unix())
+		}
+
+		if err := persistence.SaveContainerHealth(w.db, health); err != nil {
+			glog.Errorf(kwlog(fmt.Sprintf("unable to save the health of workload service %v, error: %v", healthName, err)))
+		}
+	}
+}
+
 var kwlog = func(v interface{}) string {
 	return fmt.Sprintf("Kubernetes Worker: %v", v)
 }
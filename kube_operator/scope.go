@@ -0,0 +1,92 @@
+package kube_operator
+
+import (
+	"fmt"
+	"github.com/open-horizon/anax/config"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sort"
+	"strings"
+)
+
+// A resource that anax manages for the workloads, and the verbs it uses on it.
+type scopeResource struct {
+	group    string
+	resource string
+	verbs    []string
+}
+
+// The resources that InstallWorkload, UninstallWorkload and WorkloadStatus use in the namespace of a workload.
+var workloadResources = []scopeResource{
+	{group: "apps", resource: "deployments", verbs: []string{"create", "get", "update", "deletecollection"}},
+	{group: "", resource: "services", verbs: []string{"create", "list", "delete"}},
+	{group: "", resource: "configmaps", verbs: []string{"create", "delete"}},
+}
+
+// CheckScope checks the namespaces of the Kubernetes scope against the cluster: that they exist and that anax is
+// allowed to manage the workloads in them. It returns a problem for each namespace that cannot be used, with the
+// verbs that anax is missing on each resource.
+func (c KubeClient) CheckScope(scope *config.KubeScopeConfig) ([]string, error) {
+	namespaces := scope.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{ANAX_NAMESPACE}
+	}
+
+	problems := []string{}
+	for _, namespace := range namespaces {
+		if _, err := c.Client.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{}); err != nil && errors.IsNotFound(err) {
+			problems = append(problems, fmt.Sprintf("namespace %v does not exist", namespace))
+			continue
+		} else if err != nil && !errors.IsForbidden(err) {
+			// anax does not need to read the namespaces, only to use them
+			return nil, fmt.Errorf(kwlog(fmt.Sprintf("Error getting namespace %v: %v", namespace, err)))
+		}
+
+		missing := []string{}
+		for _, r := range workloadResources {
+			verbs, err := c.missingVerbs(namespace, r)
+			if err != nil {
+				return nil, err
+			} else if len(verbs) != 0 {
+				missing = append(missing, fmt.Sprintf("%v on %v", strings.Join(verbs, ", "), qualifiedResource(r)))
+			}
+		}
+		if len(missing) != 0 {
+			problems = append(problems, fmt.Sprintf("namespace %v: missing %v", namespace, strings.Join(missing, "; ")))
+		}
+	}
+	return problems, nil
+}
+
+// Returns the verbs on the resource that anax is not allowed to use in the namespace, sorted.
+func (c KubeClient) missingVerbs(namespace string, r scopeResource) ([]string, error) {
+	missing := []string{}
+	for _, verb := range r.verbs {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: namespace,
+					Verb:      verb,
+					Group:     r.group,
+					Resource:  r.resource,
+				},
+			},
+		}
+		result, err := c.Client.AuthorizationV1().SelfSubjectAccessReviews().Create(review)
+		if err != nil {
+			return nil, fmt.Errorf(kwlog(fmt.Sprintf("Error checking whether %v of %v is allowed in namespace %v: %v", verb, qualifiedResource(r), namespace, err)))
+		} else if !result.Status.Allowed {
+			missing = append(missing, verb)
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+func qualifiedResource(r scopeResource) string {
+	if r.group == "" {
+		return r.resource
+	}
+	return fmt.Sprintf("%v.%v", r.resource, r.group)
+}
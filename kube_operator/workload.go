@@ -0,0 +1,404 @@
+package kube_operator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/persistence"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"strings"
+	"time"
+)
+
+// The labels of the Kubernetes objects created for a workload. An agreement id is longer than a label value can be,
+// so the agreement label holds its first 63 characters.
+const (
+	HZN_WORKLOAD_SERVICE_LABEL   = "openhorizon.org/service"
+	HZN_WORKLOAD_AGREEMENT_LABEL = "openhorizon.org/agreement"
+)
+
+// The rollout states of a workload service.
+const (
+	ROLLOUT_PROGRESSING = "Progressing"
+	ROLLOUT_RUNNING     = "Running"
+	ROLLOUT_FAILED      = "Failed"
+	ROLLOUT_NOT_FOUND   = "Not Found"
+)
+
+// How often the rollout of a workload is checked while waiting for it.
+const rolloutPollInterval = 2 * time.Second
+
+// The rollout status of the Deployment of a workload service.
+type WorkloadServiceStatus struct {
+	Name            string `json:"name"`
+	Deployment      string `json:"deployment"` // the name of the Deployment of the service in the agreement
+	Namespace       string `json:"namespace"`
+	Image           string `json:"image"`
+	Replicas        int32  `json:"replicas"`
+	ReadyReplicas   int32  `json:"ready_replicas"`
+	UpdatedReplicas int32  `json:"updated_replicas"`
+	State           string `json:"state"`
+	Message         string `json:"message,omitempty"`
+	CreatedTime     int64  `json:"created_time"`
+}
+
+func workloadNamespace(w *persistence.KubeWorkload) string {
+	if w.Namespace == "" {
+		return ANAX_NAMESPACE
+	}
+	return w.Namespace
+}
+
+func agreementLabel(agId string) string {
+	if len(agId) > 63 {
+		return agId[:63]
+	}
+	return agId
+}
+
+func workloadLabels(name string, agId string) map[string]string {
+	return map[string]string{HZN_WORKLOAD_SERVICE_LABEL: name, HZN_WORKLOAD_AGREEMENT_LABEL: agreementLabel(agId)}
+}
+
+// Returns the name of the Deployment and of the Service of a workload service in an agreement. The workloads of the
+// agreements in a namespace, e.g. of an agreement that is cancelled and of the one that replaces it, are told apart by
+// a hash of the agreement id: a Service name is at most 63 characters, an agreement id alone is 64.
+func workloadObjectName(name string, agId string) string {
+	sum := sha256.Sum256([]byte(agId))
+	suffix := hex.EncodeToString(sum[:])[:10]
+	if max := 63 - len(suffix) - 1; len(name) > max {
+		name = strings.TrimRight(name[:max], "-.")
+	}
+	return fmt.Sprintf("%v-%v", name, suffix)
+}
+
+// Returns the label selector of the objects of a workload service in an agreement, of all the agreements when agId is
+// empty.
+func workloadSelector(name string, agId string) string {
+	if agId == "" {
+		return fmt.Sprintf("%v=%v", HZN_WORKLOAD_SERVICE_LABEL, name)
+	}
+	return fmt.Sprintf("%v=%v,%v=%v", HZN_WORKLOAD_SERVICE_LABEL, name, HZN_WORKLOAD_AGREEMENT_LABEL, agreementLabel(agId))
+}
+
+// InstallWorkload creates the ConfigMap of the env vars and the Deployments and Services of the workload. Objects
+// that are left from an earlier install of the agreement are replaced. The namespaces of a Kubernetes scope are
+// created by the cluster admin, other namespaces are created when they do not exist.
+func (c KubeClient) InstallWorkload(w *persistence.KubeWorkload, envVars map[string]string, agId string, rolloutTimeout time.Duration, scope *config.KubeScopeConfig) error {
+	namespace := workloadNamespace(w)
+
+	if !scope.AllowsNamespace(namespace) {
+		return fmt.Errorf(kwlog(fmt.Sprintf("Error: namespace %v of the workload is not one of the namespaces %v of Edge.KubeScope", namespace, scope.Namespaces)))
+	} else if namespace != ANAX_NAMESPACE && len(scope.Namespaces) == 0 {
+		nsObj := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}
+		if _, err := c.Client.CoreV1().Namespaces().Create(&nsObj); err != nil && !errors.IsAlreadyExists(err) {
+			return fmt.Errorf(kwlog(fmt.Sprintf("Error creating namespace %v for the workload: %v", namespace, err)))
+		}
+	}
+
+	// The ESS is not supported in edge cluster services, so for now, remove the ESS env vars.
+	envAdds := cutil.RemoveESSEnvVars(envVars, config.ENVVAR_PREFIX)
+	configMapName := fmt.Sprintf("%s-%s", HZN_ENV_VARS, agId)
+	c.Client.CoreV1().ConfigMaps(namespace).Delete(configMapName, &metav1.DeleteOptions{})
+	if _, err := c.CreateConfigMap(envAdds, agId, namespace); err != nil {
+		return err
+	}
+
+	for _, name := range w.ServiceNames() {
+		objName := workloadObjectName(name, agId)
+		deployment := workloadDeployment(name, w.Services[name], namespace, configMapName, agId, rolloutTimeout, scope)
+		glog.V(3).Infof(kwlog(fmt.Sprintf("creating workload deployment %v/%v", namespace, objName)))
+		if _, err := c.Client.AppsV1().Deployments(namespace).Create(deployment); err != nil && errors.IsAlreadyExists(err) {
+			if existing, err := c.Client.AppsV1().Deployments(namespace).Get(objName, metav1.GetOptions{}); err != nil {
+				return fmt.Errorf(kwlog(fmt.Sprintf("Error getting the existing workload deployment %v: %v", name, err)))
+			} else {
+				deployment.ObjectMeta.ResourceVersion = existing.ObjectMeta.ResourceVersion
+			}
+			if _, err := c.Client.AppsV1().Deployments(namespace).Update(deployment); err != nil {
+				return fmt.Errorf(kwlog(fmt.Sprintf("Error updating the workload deployment %v: %v", name, err)))
+			}
+		} else if err != nil {
+			return fmt.Errorf(kwlog(fmt.Sprintf("Error creating the workload deployment %v: %v", name, err)))
+		}
+
+		if service := workloadService(name, w.Services[name], namespace, agId); service != nil {
+			glog.V(3).Infof(kwlog(fmt.Sprintf("creating workload service %v/%v", namespace, objName)))
+			_, err := c.Client.CoreV1().Services(namespace).Create(service)
+			if err != nil && errors.IsAlreadyExists(err) {
+				c.Client.CoreV1().Services(namespace).Delete(objName, &metav1.DeleteOptions{})
+				_, err = c.Client.CoreV1().Services(namespace).Create(service)
+			}
+			if err != nil {
+				return fmt.Errorf(kwlog(fmt.Sprintf("Error creating the workload service %v: %v", name, err)))
+			}
+		}
+	}
+	return nil
+}
+
+// UninstallWorkload deletes the Deployments, Services and ConfigMap of the agreement. The namespace is left, other
+// workloads could be using it.
+func (c KubeClient) UninstallWorkload(w *persistence.KubeWorkload, agId string) error {
+	namespace := workloadNamespace(w)
+	selector := metav1.ListOptions{LabelSelector: fmt.Sprintf("%v=%v", HZN_WORKLOAD_AGREEMENT_LABEL, agreementLabel(agId))}
+
+	errs := []string{}
+	if services, err := c.Client.CoreV1().Services(namespace).List(selector); err != nil {
+		errs = append(errs, fmt.Sprintf("unable to list the services: %v", err))
+	} else {
+		for _, s := range services.Items {
+			glog.V(3).Infof(kwlog(fmt.Sprintf("deleting workload service %v/%v", namespace, s.ObjectMeta.Name)))
+			if err := c.Client.CoreV1().Services(namespace).Delete(s.ObjectMeta.Name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+				errs = append(errs, fmt.Sprintf("unable to delete service %v: %v", s.ObjectMeta.Name, err))
+			}
+		}
+	}
+
+	if deployments, err := c.Client.AppsV1().Deployments(namespace).List(selector); err != nil {
+		errs = append(errs, fmt.Sprintf("unable to list the deployments: %v", err))
+	} else {
+		for _, d := range deployments.Items {
+			glog.V(3).Infof(kwlog(fmt.Sprintf("deleting workload deployment %v/%v", namespace, d.ObjectMeta.Name)))
+			if err := c.Client.AppsV1().Deployments(namespace).Delete(d.ObjectMeta.Name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+				errs = append(errs, fmt.Sprintf("unable to delete deployment %v: %v", d.ObjectMeta.Name, err))
+			}
+		}
+	}
+
+	configMapName := fmt.Sprintf("%s-%s", HZN_ENV_VARS, agId)
+	if err := c.Client.CoreV1().ConfigMaps(namespace).Delete(configMapName, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		errs = append(errs, fmt.Sprintf("unable to delete config map %v: %v", configMapName, err))
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf(kwlog(fmt.Sprintf("Error removing the workload of agreement %v from namespace %v: %v", agId, namespace, strings.Join(errs, ", "))))
+	}
+	glog.V(3).Infof(kwlog(fmt.Sprintf("Completed removal of the workload of agreement %v from the cluster.", agId)))
+	return nil
+}
+
+// WorkloadStatus returns the rollout status of the Deployment of each service of the workload in the agreement, sorted
+// by service. When agId is empty, the Deployment of each service is the newest one of any agreement.
+func (c KubeClient) WorkloadStatus(w *persistence.KubeWorkload, agId string) ([]WorkloadServiceStatus, error) {
+	namespace := workloadNamespace(w)
+	statuses := make([]WorkloadServiceStatus, 0, len(w.Services))
+
+	for _, name := range w.ServiceNames() {
+		status := WorkloadServiceStatus{Name: name, Namespace: namespace, Image: w.Services[name].Image}
+		if agId != "" {
+			status.Deployment = workloadObjectName(name, agId)
+		}
+
+		deployments, err := c.Client.AppsV1().Deployments(namespace).List(metav1.ListOptions{LabelSelector: workloadSelector(name, agId)})
+		if err != nil {
+			return nil, fmt.Errorf(kwlog(fmt.Sprintf("Error getting the workload deployments of %v in %v: %v", name, namespace, err)))
+		}
+		var d *appsv1.Deployment
+		for i := range deployments.Items {
+			if d == nil || d.ObjectMeta.CreationTimestamp.Before(&deployments.Items[i].ObjectMeta.CreationTimestamp) {
+				d = &deployments.Items[i]
+			}
+		}
+
+		if d == nil {
+			status.State = ROLLOUT_NOT_FOUND
+		} else {
+			status.Deployment = d.ObjectMeta.Name
+			status.Replicas = d.Status.Replicas
+			status.ReadyReplicas = d.Status.ReadyReplicas
+			status.UpdatedReplicas = d.Status.UpdatedReplicas
+			status.CreatedTime = d.ObjectMeta.CreationTimestamp.Unix()
+			status.State, status.Message = rolloutState(d)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// WaitForRollout waits until the Deployments of all the services of the workload in the agreement are running. It
+// returns an error when one of them fails, they are not all running within the timeout or ctx is done. The last
+// statuses are always returned.
+func (c KubeClient) WaitForRollout(ctx context.Context, w *persistence.KubeWorkload, agId string, timeout time.Duration) ([]WorkloadServiceStatus, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		statuses, err := c.WorkloadStatus(w, agId)
+		if err != nil {
+			return statuses, err
+		}
+
+		pending := []string{}
+		for _, s := range statuses {
+			if s.State == ROLLOUT_FAILED || s.State == ROLLOUT_NOT_FOUND {
+				return statuses, fmt.Errorf(kwlog(fmt.Sprintf("Error: workload service %v failed to roll out: %v %v", s.Name, s.State, s.Message)))
+			} else if s.State != ROLLOUT_RUNNING {
+				pending = append(pending, fmt.Sprintf("%v (%v)", s.Name, s.Message))
+			}
+		}
+
+		if len(pending) == 0 {
+			return statuses, nil
+		} else if time.Now().After(deadline) {
+			return statuses, fmt.Errorf(kwlog(fmt.Sprintf("Error: workload services did not roll out within %v: %v", timeout, strings.Join(pending, ", "))))
+		}
+		glog.V(5).Infof(kwlog(fmt.Sprintf("waiting for the rollout of workload services %v", pending)))
+		select {
+		case <-time.After(rolloutPollInterval):
+		case <-ctx.Done():
+			return statuses, fmt.Errorf(kwlog(fmt.Sprintf("Error: stopped waiting for the rollout of workload services %v: %v", strings.Join(pending, ", "), ctx.Err())))
+		}
+	}
+}
+
+// Returns the rollout state of a Deployment and a message that explains a state other than running.
+func rolloutState(d *appsv1.Deployment) (string, string) {
+	desired := int32(1)
+	if d.Spec.Replicas != nil {
+		desired = *d.Spec.Replicas
+	}
+
+	for _, cond := range d.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing && cond.Status == corev1.ConditionFalse && cond.Reason == "ProgressDeadlineExceeded" {
+			return ROLLOUT_FAILED, cond.Message
+		}
+	}
+
+	if d.Status.ObservedGeneration < d.ObjectMeta.Generation {
+		return ROLLOUT_PROGRESSING, "the deployment update has not been observed yet"
+	} else if d.Status.UpdatedReplicas < desired {
+		return ROLLOUT_PROGRESSING, fmt.Sprintf("%v of %v replicas are updated", d.Status.UpdatedReplicas, desired)
+	} else if d.Status.Replicas > d.Status.UpdatedReplicas {
+		return ROLLOUT_PROGRESSING, fmt.Sprintf("%v old replicas are pending termination", d.Status.Replicas-d.Status.UpdatedReplicas)
+	} else if d.Status.AvailableReplicas < desired {
+		return ROLLOUT_PROGRESSING, fmt.Sprintf("%v of %v replicas are available", d.Status.AvailableReplicas, desired)
+	}
+	return ROLLOUT_RUNNING, ""
+}
+
+// Returns the Deployment of a workload service. The env vars of the agreement are in the config map, the env vars of
+// the service are set on its container. The resources and the image pull secrets that the service does not set come
+// from the Kubernetes scope.
+func workloadDeployment(name string, svc *persistence.KubeWorkloadService, namespace string, configMapName string, agId string, rolloutTimeout time.Duration, scope *config.KubeScopeConfig) *appsv1.Deployment {
+	replicas := svc.Replicas
+	if replicas == 0 {
+		replicas = 1
+	}
+	progressDeadline := int32(rolloutTimeout / time.Second)
+
+	env := make([]corev1.EnvVar, 0, len(svc.Environment))
+	for _, e := range svc.Environment {
+		parts := strings.SplitN(e, "=", 2)
+		env = append(env, corev1.EnvVar{Name: parts[0], Value: parts[1]})
+	}
+
+	ports := make([]corev1.ContainerPort, 0, len(svc.Ports))
+	for _, p := range svc.Ports {
+		ports = append(ports, corev1.ContainerPort{ContainerPort: p.Port, Protocol: portProtocol(p)})
+	}
+
+	memoryMb, cpus := svc.MaxMemoryMb, float64(svc.MaxCPUs)
+	if memoryMb == 0 {
+		memoryMb = scope.DefaultLimits.MemoryMb
+	}
+	if cpus == 0 {
+		cpus = scope.DefaultLimits.CPUs
+	}
+	limits := resourceList(cpus, memoryMb)
+
+	// a request cannot be higher than the limit
+	requestMemoryMb, requestCPUs := scope.DefaultRequests.MemoryMb, scope.DefaultRequests.CPUs
+	if memoryMb != 0 && requestMemoryMb > memoryMb {
+		requestMemoryMb = memoryMb
+	}
+	if cpus != 0 && requestCPUs > cpus {
+		requestCPUs = cpus
+	}
+	requests := resourceList(requestCPUs, requestMemoryMb)
+
+	container := corev1.Container{
+		Name:      name,
+		Image:     svc.Image,
+		Command:   svc.Command,
+		Args:      svc.Args,
+		Env:       env,
+		EnvFrom:   []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: configMapName}}}},
+		Ports:     ports,
+		Resources: corev1.ResourceRequirements{Limits: limits, Requests: requests},
+	}
+	if svc.Privileged {
+		privileged := true
+		container.SecurityContext = &corev1.SecurityContext{Privileged: &privileged}
+	}
+
+	pullSecrets := make([]corev1.LocalObjectReference, 0, len(scope.ImagePullSecrets))
+	for _, secret := range scope.ImagePullSecrets {
+		pullSecrets = append(pullSecrets, corev1.LocalObjectReference{Name: secret})
+	}
+
+	labels := workloadLabels(name, agId)
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: workloadObjectName(name, agId), Namespace: namespace, Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas:                &replicas,
+			ProgressDeadlineSeconds: &progressDeadline,
+			Selector:                &metav1.LabelSelector{MatchLabels: workloadLabels(name, agId)},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{container}, ImagePullSecrets: pullSecrets},
+			},
+		},
+	}
+}
+
+// Returns the resource list of the cpus and the MB of memory, without the ones that are 0.
+func resourceList(cpus float64, memoryMb int64) corev1.ResourceList {
+	list := corev1.ResourceList{}
+	if memoryMb != 0 {
+		list[corev1.ResourceMemory] = *resource.NewQuantity(memoryMb*1024*1024, resource.BinarySI)
+	}
+	if cpus != 0 {
+		list[corev1.ResourceCPU] = *resource.NewMilliQuantity(int64(cpus*1000), resource.DecimalSI)
+	}
+	return list
+}
+
+// Returns the Service of a workload service that has ports, nil if it has none.
+func workloadService(name string, svc *persistence.KubeWorkloadService, namespace string, agId string) *corev1.Service {
+	if len(svc.Ports) == 0 {
+		return nil
+	}
+
+	ports := make([]corev1.ServicePort, 0, len(svc.Ports))
+	for _, p := range svc.Ports {
+		protocol := portProtocol(p)
+		ports = append(ports, corev1.ServicePort{
+			Name:       fmt.Sprintf("%v-%v", strings.ToLower(string(protocol)), p.Port),
+			Port:       p.Port,
+			TargetPort: intstr.FromInt(int(p.Port)),
+			Protocol:   protocol,
+		})
+	}
+
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: workloadObjectName(name, agId), Namespace: namespace, Labels: workloadLabels(name, agId)},
+		Spec: corev1.ServiceSpec{
+			Selector: workloadLabels(name, agId),
+			Ports:    ports,
+		},
+	}
+}
+
+func portProtocol(p persistence.KubeWorkloadPort) corev1.Protocol {
+	if p.Protocol == "" {
+		return corev1.ProtocolTCP
+	}
+	return corev1.Protocol(strings.ToUpper(p.Protocol))
+}
@@ -0,0 +1,198 @@
+// +build unit
+
+package kube_operator
+
+import (
+	"context"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/persistence"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testAgId1 = "8c2d1c0cd5e34e3f8a0f5b9e1f3d2a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f"
+	testAgId2 = "1f2e3d4c5b6a7f8e9d0c1b2a3f4e5d6c7b8a9f0e1d2c3b4a5f6e7d8c9b0a1f2e"
+)
+
+func testWorkload() *persistence.KubeWorkload {
+	return &persistence.KubeWorkload{
+		Namespace: "myns",
+		Services: map[string]*persistence.KubeWorkloadService{
+			"web": {Image: "myorg/web:1.0.0", Ports: []persistence.KubeWorkloadPort{{Port: 8080}}},
+			"db":  {Image: "myorg/db:1.0.0"},
+		},
+	}
+}
+
+// Sets the status of the deployment to the one of a completed rollout.
+func rolledOut(t *testing.T, c *KubeClient, namespace string, name string) {
+	d, err := c.Client.AppsV1().Deployments(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unable to get deployment %v, error %v", name, err)
+	}
+	d.Status = appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, ReadyReplicas: 1, AvailableReplicas: 1}
+	if _, err := c.Client.AppsV1().Deployments(namespace).Update(d); err != nil {
+		t.Fatalf("unable to update deployment %v, error %v", name, err)
+	}
+}
+
+// The workloads of two agreements in a namespace have their own objects, and uninstalling one leaves the other.
+func Test_InstallWorkload_agreements(t *testing.T) {
+
+	c := &KubeClient{Client: fake.NewSimpleClientset()}
+	w := testWorkload()
+	scope := &config.KubeScopeConfig{}
+
+	for _, agId := range []string{testAgId1, testAgId2} {
+		if err := c.InstallWorkload(w, map[string]string{}, agId, time.Minute, scope); err != nil {
+			t.Fatalf("unexpected error installing the workload of %v: %v", agId, err)
+		}
+	}
+
+	if deployments, err := c.Client.AppsV1().Deployments("myns").List(metav1.ListOptions{}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if len(deployments.Items) != 4 {
+		t.Errorf("there should be a deployment for each service of each agreement, there are %v", len(deployments.Items))
+	}
+	if services, err := c.Client.CoreV1().Services("myns").List(metav1.ListOptions{}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if len(services.Items) != 2 {
+		t.Errorf("there should be a service for the service with ports of each agreement, there are %v", len(services.Items))
+	}
+
+	if err := c.UninstallWorkload(w, testAgId1); err != nil {
+		t.Fatalf("unexpected error uninstalling the workload of %v: %v", testAgId1, err)
+	}
+	if deployments, _ := c.Client.AppsV1().Deployments("myns").List(metav1.ListOptions{}); len(deployments.Items) != 2 {
+		t.Errorf("only the deployments of %v should be left, there are %v", testAgId2, len(deployments.Items))
+	} else {
+		for _, d := range deployments.Items {
+			if d.ObjectMeta.Labels[HZN_WORKLOAD_AGREEMENT_LABEL] != agreementLabel(testAgId2) {
+				t.Errorf("deployment %v of the other agreement was left", d.ObjectMeta.Name)
+			}
+		}
+	}
+	if services, _ := c.Client.CoreV1().Services("myns").List(metav1.ListOptions{}); len(services.Items) != 1 || services.Items[0].ObjectMeta.Name != workloadObjectName("web", testAgId2) {
+		t.Errorf("only the service of %v should be left, there are %v", testAgId2, services.Items)
+	}
+	if _, err := c.Client.CoreV1().ConfigMaps("myns").Get(HZN_ENV_VARS+"-"+testAgId2, metav1.GetOptions{}); err != nil {
+		t.Errorf("the config map of %v should be left, error %v", testAgId2, err)
+	}
+}
+
+// The status of each service is the one of its deployment in the agreement.
+func Test_WorkloadStatus(t *testing.T) {
+
+	c := &KubeClient{Client: fake.NewSimpleClientset()}
+	w := testWorkload()
+
+	if statuses, err := c.WorkloadStatus(w, testAgId1); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if len(statuses) != 2 || statuses[0].State != ROLLOUT_NOT_FOUND || statuses[1].State != ROLLOUT_NOT_FOUND {
+		t.Errorf("the services should not be found before the install, the statuses are %v", statuses)
+	} else if statuses[0].Deployment != workloadObjectName(statuses[0].Name, testAgId1) {
+		t.Errorf("the deployment of a service that is not found should be the one it would have, is %v", statuses[0].Deployment)
+	}
+
+	if err := c.InstallWorkload(w, map[string]string{}, testAgId1, time.Minute, &config.KubeScopeConfig{}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	rolledOut(t, c, "myns", workloadObjectName("web", testAgId1))
+
+	if statuses, err := c.WorkloadStatus(w, testAgId1); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if len(statuses) != 2 || statuses[0].Name != "db" || statuses[1].Name != "web" {
+		t.Errorf("the statuses should be sorted by service, they are %v", statuses)
+	} else if statuses[0].State != ROLLOUT_PROGRESSING || statuses[1].State != ROLLOUT_RUNNING {
+		t.Errorf("wrong states %v", statuses)
+	} else if statuses[1].Deployment != workloadObjectName("web", testAgId1) {
+		t.Errorf("wrong deployment %v", statuses[1].Deployment)
+	}
+
+	if statuses, err := c.WorkloadStatus(w, testAgId2); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if statuses[0].State != ROLLOUT_NOT_FOUND || statuses[1].State != ROLLOUT_NOT_FOUND {
+		t.Errorf("the deployments of another agreement should not be found, the statuses are %v", statuses)
+	}
+}
+
+// The wait ends when all the deployments run, when one of them fails, and when its context is done.
+func Test_WaitForRollout(t *testing.T) {
+
+	c := &KubeClient{Client: fake.NewSimpleClientset()}
+	w := &persistence.KubeWorkload{Namespace: "myns", Services: map[string]*persistence.KubeWorkloadService{"web": {Image: "myorg/web:1.0.0"}}}
+	if err := c.InstallWorkload(w, map[string]string{}, testAgId1, time.Minute, &config.KubeScopeConfig{}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := c.WaitForRollout(ctx, w, testAgId1, time.Minute)
+		done <- err
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "stopped waiting") {
+			t.Errorf("the wait should stop when its context is done, the error is %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("the wait did not stop when its context was done")
+	}
+
+	if _, err := c.WaitForRollout(context.Background(), w, testAgId2, time.Minute); err == nil {
+		t.Errorf("the wait should fail for the deployments of an agreement that are not found")
+	}
+
+	rolledOut(t, c, "myns", workloadObjectName("web", testAgId1))
+	if statuses, err := c.WaitForRollout(context.Background(), w, testAgId1, time.Minute); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if len(statuses) != 1 || statuses[0].State != ROLLOUT_RUNNING {
+		t.Errorf("wrong statuses %v", statuses)
+	}
+}
+
+func Test_rolloutState(t *testing.T) {
+
+	replicas := int32(2)
+	d := &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: &replicas}}
+
+	d.Status = appsv1.DeploymentStatus{Replicas: 2, UpdatedReplicas: 1, AvailableReplicas: 1}
+	if state, _ := rolloutState(d); state != ROLLOUT_PROGRESSING {
+		t.Errorf("a deployment with a replica to update should be progressing, is %v", state)
+	}
+
+	d.Status = appsv1.DeploymentStatus{Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2}
+	if state, _ := rolloutState(d); state != ROLLOUT_RUNNING {
+		t.Errorf("a deployment with all its replicas available should be running, is %v", state)
+	}
+
+	d.Status.Conditions = []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: "ProgressDeadlineExceeded", Message: "too slow"}}
+	if state, message := rolloutState(d); state != ROLLOUT_FAILED || message != "too slow" {
+		t.Errorf("a deployment past its progress deadline should be failed, is %v %v", state, message)
+	}
+}
+
+// The names of the objects are valid Kubernetes names and differ between the agreements.
+func Test_workloadObjectName(t *testing.T) {
+
+	long := strings.Repeat("a", 62) + "b"
+	if name := workloadObjectName(long, testAgId1); len(name) > 63 {
+		t.Errorf("the name %v is longer than 63 characters", name)
+	} else if !strings.HasPrefix(name, strings.Repeat("a", 52)+"-") {
+		t.Errorf("the name %v should start with the truncated service name", name)
+	}
+
+	if workloadObjectName("web", testAgId1) == workloadObjectName("web", testAgId2) {
+		t.Errorf("the names of the objects of two agreements should differ")
+	} else if workloadObjectName("web", testAgId1) != workloadObjectName("web", testAgId1) {
+		t.Errorf("the name of the object of an agreement should not change")
+	}
+}
@@ -14,16 +14,24 @@ import (
 	"github.com/open-horizon/anax/changes"
 	"github.com/open-horizon/anax/config"
 	"github.com/open-horizon/anax/container"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/download"
+	"github.com/open-horizon/anax/eventlog"
 	"github.com/open-horizon/anax/exchange"
 	_ "github.com/open-horizon/anax/externalpolicy/text_language"
 	"github.com/open-horizon/anax/governance"
+	"github.com/open-horizon/anax/hooks"
 	"github.com/open-horizon/anax/i18n"
 	_ "github.com/open-horizon/anax/i18n_messages"
 	"github.com/open-horizon/anax/imagefetch"
 	"github.com/open-horizon/anax/kube_operator"
+	"github.com/open-horizon/anax/metrics"
+	"github.com/open-horizon/anax/offline"
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/policy"
 	"github.com/open-horizon/anax/resource"
+	"github.com/open-horizon/anax/servicereconcile"
+	"github.com/open-horizon/anax/tpm"
 	"github.com/open-horizon/anax/worker"
 	"os"
 	"os/signal"
@@ -42,9 +50,44 @@ import (
 func main() {
 	configFile := flag.String("config", "/etc/colonus/anax.config", "Config file location")
 	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to file")
+	checkConfig := flag.Bool("check-config", false, "validate the config file and exit")
+	generateConfig := flag.String("generate-config", "", "print a config file with the defaults and the descriptions of all the fields, in json or yaml, and exit")
 
 	flag.Parse()
 
+	// This does not need a config file, it is meant to create one.
+	if *generateConfig != "" {
+		out, err := config.GenerateDefaultConfig(*generateConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to generate config: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(string(out))
+		os.Exit(0)
+	}
+
+	// Validate the config before anything is started, reporting all of its problems at once.
+	cfg, err := config.Read(*configFile)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Config file %v is invalid:\n", *configFile)
+		if problems, ok := err.(config.ConfigErrors); ok {
+			for _, p := range problems {
+				fmt.Fprintf(os.Stderr, "  %v\n", p)
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "  %v\n", err)
+		}
+		glog.Errorf("Config file %v is invalid: %v", *configFile, err)
+		glog.Flush()
+		os.Exit(1)
+	} else if *checkConfig {
+		fmt.Printf("Config file %v is valid\n", *configFile)
+		os.Exit(0)
+	}
+
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -56,18 +99,26 @@ func main() {
 		glog.V(2).Infof("Started CPU profiling. Writing to: %v", f.Name())
 	}
 
-	cfg, err := config.Read(*configFile)
-	if err != nil {
-		panic(err)
-	}
 	glog.V(2).Infof("Using config: %v", cfg.String())
 	glog.V(2).Infof("GOMAXPROCS: %v", runtime.GOMAXPROCS(-1))
 
+	// the architecture synonyms of the config extend the ones known to anax.
+	cutil.SetArchSynonyms(cfg.ArchSynonyms)
+
+	// the services of a cluster node are deployed to the cluster of the configured kubeconfig, if any.
+	cutil.SetKubeConfigFile(cfg.Edge.KubeConfigFile)
+
 	// initialize the message printer for globalization, the anax will produce English messages.
 	// However, in order to extract messages for eventlog for translation, we need to use the message printer for
 	// eventlog messages.
 	i18n.InitMessagePrinter(true)
 
+	// record the metrics served at /metrics by the agent API, if they are enabled
+	metrics.Enable(cfg.Edge.EnableMetrics)
+
+	// forward the selected events of the event log to the local journal
+	eventlog.StartJournal(cfg)
+
 	// open edge DB if necessary
 	var db *bolt.DB
 	if len(cfg.Edge.DBPath) != 0 {
@@ -75,6 +126,13 @@ func main() {
 			panic(err)
 		}
 
+		// The compaction requested through the agent API is made before the database is opened.
+		if compaction, err := persistence.CompactDatabaseFile(path.Join(cfg.Edge.DBPath, "anax.db")); err != nil {
+			glog.Errorf("Unable to compact the database, error %v", err)
+		} else if compaction != nil {
+			glog.Infof("Compacted the database, reclaimed %v bytes: %v", compaction.Reclaimed, compaction)
+		}
+
 		edgeDB, err := bolt.Open(path.Join(cfg.Edge.DBPath, "anax.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
 		if err != nil {
 			panic(err)
@@ -103,6 +161,12 @@ func main() {
 		<-control
 		glog.Infof("Closing up shop.")
 
+		// The changes of the node configuration that are running complete, or are rolled back, and the requests that
+		// the API is serving are done, before the database is closed.
+		if result := api.Shutdown(); result != nil {
+			glog.Infof("Shut down the API, waited for %v operations.", len(result.Operations))
+		}
+
 		pprof.StopCPUProfile()
 		if db != nil {
 			db.Close()
@@ -122,13 +186,61 @@ func main() {
 		os.Exit(0)
 	}()
 
+	// start the config reload signal handler. Only the config settings that are safe to change while anax is running
+	// are applied, the others are logged and take effect on restart.
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	go func() {
+		for range reload {
+			glog.Infof("Received SIGHUP, reloading config file %v.", *configFile)
+			if _, err := cfg.Reload(); err != nil {
+				glog.Errorf("Unable to reload config: %v", err)
+			}
+		}
+	}()
+
+	// Seal the key that encrypts the secrets in the database with the node's TPM, if it has one. When the TPM cannot
+	// unseal the key, anax runs in recovery mode with only the API, instead of exiting and being restarted over and over.
+	recovery := false
+	if db != nil {
+		recovery = tpm.Initialize(&cfg.Edge.TPM, db).State == tpm.STATE_RECOVERY
+	}
+
 	// The anax runtime might have been upgraded an restarted with an existing database. If so, the
 	// device object might need to be upgraded.
 	usingPattern := false
-	if usingPattern, err = persistence.MigrateExchangeDevice(db); err != nil {
+	if recovery {
+		// the secrets cannot be read, so they are not migrated
+	} else if usingPattern, err = persistence.MigrateExchangeDevice(db); err != nil {
 		panic(err)
 	}
 
+	// Registry credentials stored in plain text by older versions of anax are encrypted.
+	if recovery {
+		// nothing to migrate
+	} else if err := persistence.MigrateAttributeCredentials(db); err != nil {
+		panic(err)
+	}
+
+	// Install the offline bundle of the node, so that its images are loaded before any agreement needs them.
+	if db != nil && !recovery {
+		offline.Install(cfg, db)
+	}
+
+	// The downloads of the agent share the rate limit of the config, or its window rate limit while a maintenance
+	// window of the node is open.
+	if db != nil {
+		download.SetRateFunc(func() int64 {
+			schedule, err := persistence.FindMaintenanceSchedule(db)
+			if err != nil {
+				glog.Errorf("Unable to read the maintenance schedule, error %v", err)
+			}
+			live := cfg.LiveEdge()
+			return live.Download.RateLimit(schedule != nil && schedule.InWindow(time.Now()))
+		})
+	}
+
 	// Get the device side policy manager started early so that all the workers can use it.
 	// Make sure the policy directory is in place.
 	var pm *policy.PolicyManager
@@ -161,7 +273,9 @@ func main() {
 		workers.Add(agreementbot.NewChangesWorker("AgBot ExchangeChanges", cfg))
 	}
 
-	if db != nil {
+	if db != nil && recovery {
+		workers.Add(api.NewAPIListener("API", cfg, db, pm))
+	} else if db != nil {
 		workers.Add(api.NewAPIListener("API", cfg, db, pm))
 		workers.Add(agreement.NewAgreementWorker("Agreement", cfg, db, pm))
 		workers.Add(governance.NewGovernanceWorker("Governance", cfg, db, pm))
@@ -175,6 +289,9 @@ func main() {
 		workers.Add(kube_operator.NewKubeWorker("Kube", cfg, db))
 		workers.Add(resource.NewResourceWorker("Resource", cfg, db, authm))
 		workers.Add(changes.NewChangesWorker("ExchangeChanges", cfg, db))
+		workers.Add(hooks.NewHooksWorker("Hooks", cfg))
+		workers.Add(offline.NewReconcileWorker("OfflineReconcile", cfg, db))
+		workers.Add(servicereconcile.NewServiceReconcileWorker("ServiceReconcile", cfg, db))
 	}
 
 	// Get into the event processing loop until anax shuts itself down.
@@ -0,0 +1,363 @@
+// Package metrics holds the counters and histograms of the agent, and writes them in the Prometheus text format. Nothing
+// is recorded until Enable is called, so that the agents that do not serve the metrics do not pay for them.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The buckets, in seconds, of the histograms of the durations of the API requests, exchange calls and database
+// transactions.
+var DefaultDurationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+var enabled int32
+
+// Turn the recording of the metrics on or off.
+func Enable(on bool) {
+	if on {
+		atomic.StoreInt32(&enabled, 1)
+	} else {
+		atomic.StoreInt32(&enabled, 0)
+	}
+}
+
+// Returns true if the metrics are being recorded.
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+// A set of metrics that are written together.
+type Registry struct {
+	lock    sync.Mutex
+	metrics map[string]metric
+}
+
+// The registry of the metrics of the agent.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic(fmt.Sprintf("metric %v is already registered", name))
+	}
+	r.metrics[name] = m
+}
+
+// Write all the metrics of the registry in the Prometheus text format, sorted by name.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.lock.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, r.metrics[name])
+	}
+	r.lock.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// The values of the labels of a series, joined so that they can be used as a map key.
+func seriesKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+func checkLabels(name string, labels []string, labelValues []string) {
+	if len(labels) != len(labelValues) {
+		panic(fmt.Sprintf("metric %v has labels %v, got values %v", name, labels, labelValues))
+	}
+}
+
+// Returns the labels of a series as written in the text format, e.g. {path="/node",method="GET"}. The extra label is
+// added last when it is not empty, as the le label of the histogram buckets.
+func formatLabels(labels []string, labelValues []string, extraName string, extraValue string) string {
+	if len(labels) == 0 && extraName == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)+1)
+	for i, label := range labels {
+		pairs = append(pairs, fmt.Sprintf("%v=\"%v\"", label, escapeLabelValue(labelValues[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf("%v=\"%v\"", extraName, extraValue))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+// A counter with a series for each combination of the values of its labels.
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	lock   sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labelValues []string
+	value       float64
+}
+
+// Returns a new counter registered in the Default registry.
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, series: make(map[string]*counterSeries)}
+	r.register(name, c)
+	return c
+}
+
+// Add 1 to the series with the given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add v to the series with the given label values.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if !Enabled() {
+		return
+	}
+	checkLabels(c.name, c.labels, labelValues)
+
+	key := seriesKey(labelValues)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{labelValues: append([]string(nil), labelValues...)}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+// Returns the value of the series with the given label values, 0 if there is none.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if s, ok := c.series[seriesKey(labelValues)]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := c.series[key]
+		fmt.Fprintf(w, "%v%v %v\n", c.name, formatLabels(c.labels, s.labelValues, "", ""), formatFloat(s.value))
+	}
+}
+
+// A gauge with a series for each combination of the values of its labels.
+type GaugeVec struct {
+	name   string
+	help   string
+	labels []string
+	lock   sync.Mutex
+	series map[string]*counterSeries
+}
+
+// Returns a new gauge registered in the Default registry.
+func NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+func (r *Registry) NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{name: name, help: help, labels: labels, series: make(map[string]*counterSeries)}
+	r.register(name, g)
+	return g
+}
+
+// Set the series with the given label values to v.
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	if !Enabled() {
+		return
+	}
+	checkLabels(g.name, g.labels, labelValues)
+
+	key := seriesKey(labelValues)
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	s, ok := g.series[key]
+	if !ok {
+		s = &counterSeries{labelValues: append([]string(nil), labelValues...)}
+		g.series[key] = s
+	}
+	s.value = v
+}
+
+// Remove the series with the given label values, e.g. when the thing it measures is gone.
+func (g *GaugeVec) Delete(labelValues ...string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	delete(g.series, seriesKey(labelValues))
+}
+
+// Returns the value of the series with the given label values, 0 if there is none.
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if s, ok := g.series[seriesKey(labelValues)]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v gauge\n", g.name, g.help, g.name)
+	keys := make([]string, 0, len(g.series))
+	for key := range g.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := g.series[key]
+		fmt.Fprintf(w, "%v%v %v\n", g.name, formatLabels(g.labels, s.labelValues, "", ""), formatFloat(s.value))
+	}
+}
+
+// A histogram with a series for each combination of the values of its labels.
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	lock    sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64 // the number of observations in each bucket, not cumulated
+	count       uint64
+	sum         float64
+}
+
+// Returns a new histogram registered in the Default registry. The buckets are the upper bounds, in increasing order.
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("the buckets %v of metric %v are not sorted", buckets, name))
+	}
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+	r.register(name, h)
+	return h
+}
+
+// Record v in the series with the given label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	if !Enabled() {
+		return
+	}
+	checkLabels(h.name, h.labels, labelValues)
+
+	key := seriesKey(labelValues)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labelValues: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+// Record the seconds since start in the series with the given label values.
+func (h *HistogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+// Returns the number of observations of the series with the given label values, and their sum.
+func (h *HistogramVec) Count(labelValues ...string) (uint64, float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if s, ok := h.series[seriesKey(labelValues)]; ok {
+		return s.count, s.sum
+	}
+	return 0, 0
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		cumulative := uint64(0)
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%v_bucket%v %v\n", h.name, formatLabels(h.labels, s.labelValues, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%v_bucket%v %v\n", h.name, formatLabels(h.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%v_sum%v %v\n", h.name, formatLabels(h.labels, s.labelValues, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%v_count%v %v\n", h.name, formatLabels(h.labels, s.labelValues, "", ""), s.count)
+	}
+}
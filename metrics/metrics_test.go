@@ -0,0 +1,113 @@
+// +build unit
+
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_disabled_records_nothing(t *testing.T) {
+	Enable(false)
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", "A test counter.", "path")
+	h := r.NewHistogramVec("test_seconds", "A test histogram.", []float64{1}, "path")
+
+	c.Inc("/node")
+	h.Observe(0.5, "/node")
+
+	if v := c.Value("/node"); v != 0 {
+		t.Errorf("counter should not record when disabled, got %v", v)
+	} else if n, _ := h.Count("/node"); n != 0 {
+		t.Errorf("histogram should not record when disabled, got %v", n)
+	}
+}
+
+func Test_WriteText(t *testing.T) {
+	Enable(true)
+	defer Enable(false)
+
+	r := NewRegistry()
+	c := r.NewCounterVec("test_requests_total", "The requests.", "path", "status")
+	h := r.NewHistogramVec("test_duration_seconds", "The durations.", []float64{0.1, 1}, "path")
+
+	c.Inc("/node", "200")
+	c.Inc("/node", "200")
+	c.Add(3, "/a\"b", "500")
+	h.Observe(0.05, "/node")
+	h.Observe(0.5, "/node")
+	h.Observe(5, "/node")
+
+	if v := c.Value("/node", "200"); v != 2 {
+		t.Errorf("counter should be 2, got %v", v)
+	} else if n, sum := h.Count("/node"); n != 3 || sum != 5.55 {
+		t.Errorf("histogram should have 3 observations summing to 5.55, got %v and %v", n, sum)
+	}
+
+	var out bytes.Buffer
+	if err := r.WriteText(&out); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	expected := strings.Join([]string{
+		"# HELP test_duration_seconds The durations.",
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{path="/node",le="0.1"} 1`,
+		`test_duration_seconds_bucket{path="/node",le="1"} 2`,
+		`test_duration_seconds_bucket{path="/node",le="+Inf"} 3`,
+		`test_duration_seconds_sum{path="/node"} 5.55`,
+		`test_duration_seconds_count{path="/node"} 3`,
+		"# HELP test_requests_total The requests.",
+		"# TYPE test_requests_total counter",
+		`test_requests_total{path="/a\"b",status="500"} 3`,
+		`test_requests_total{path="/node",status="200"} 2`,
+		"",
+	}, "\n")
+	if out.String() != expected {
+		t.Errorf("expected\n%v\ngot\n%v", expected, out.String())
+	}
+}
+
+func Test_GaugeVec(t *testing.T) {
+	Enable(true)
+	defer Enable(false)
+
+	r := NewRegistry()
+	g := r.NewGaugeVec("test_unhealthy", "The unhealthy things.", "name")
+
+	g.Set(1, "a")
+	g.Set(1, "b")
+	g.Set(0, "a")
+	if v := g.Value("a"); v != 0 {
+		t.Errorf("gauge a should be 0, got %v", v)
+	}
+	g.Delete("b")
+
+	var out bytes.Buffer
+	if err := r.WriteText(&out); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	expected := strings.Join([]string{
+		"# HELP test_unhealthy The unhealthy things.",
+		"# TYPE test_unhealthy gauge",
+		`test_unhealthy{name="a"} 0`,
+		"",
+	}, "\n")
+	if out.String() != expected {
+		t.Errorf("expected\n%v\ngot\n%v", expected, out.String())
+	}
+}
+
+func Test_register_twice(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_total", "A test counter.")
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering a metric twice should panic")
+		}
+	}()
+	r.NewCounterVec("test_total", "A test counter.")
+}
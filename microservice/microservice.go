@@ -32,6 +32,8 @@ const MS_DELETED_BY_UPGRADE_PROCESS = 205
 const MS_DELETED_FOR_AG_ENDED = 206
 const MS_IMAGE_FETCH_FAILED = 207
 const MS_DELETED_BY_DOWNGRADE_PROCESS = 208
+const MS_RESTARTS_EXHAUSTED = 209
+const MS_HEALTH_CHECK_FAILED = 210
 
 func DecodeReasonCode(code uint64) string {
 	// microservice termiated deccription
@@ -45,6 +47,8 @@ func DecodeReasonCode(code uint64) string {
 		MS_DELETED_BY_DOWNGRADE_PROCESS: "Deleted by downgrading process",
 		MS_DELETED_FOR_AG_ENDED:         "Deleted for agreement ended",
 		MS_IMAGE_FETCH_FAILED:           "Image fetching failed",
+		MS_RESTARTS_EXHAUSTED:           "Restart retries exhausted",
+		MS_HEALTH_CHECK_FAILED:          "Health check failed",
 	}
 
 	if reasonString, ok := codeMeanings[code]; !ok {
@@ -224,6 +228,8 @@ func GetUpgradeMicroserviceDef(getService exchange.ServiceResolverHandler, msdef
 		new_msdef.AutoUpgrade = msdef.AutoUpgrade
 		new_msdef.ActiveUpgrade = msdef.ActiveUpgrade
 		new_msdef.RequestedArch = msdef.RequestedArch
+		new_msdef.Origin = msdef.Origin
+		new_msdef.OriginPattern = msdef.OriginPattern
 
 		glog.V(5).Infof("New upgrade msdef is %v", new_msdef.ShortString())
 		return new_msdef, nil
@@ -253,6 +259,8 @@ func GetRollbackMicroserviceDef(getService exchange.ServiceResolverHandler, msde
 		new_msdef.AutoUpgrade = msdef.AutoUpgrade
 		new_msdef.ActiveUpgrade = msdef.ActiveUpgrade
 		new_msdef.RequestedArch = msdef.RequestedArch
+		new_msdef.Origin = msdef.Origin
+		new_msdef.OriginPattern = msdef.OriginPattern
 
 		glog.V(5).Infof("New rollback msdef is %v", new_msdef.ShortString())
 		return new_msdef, nil
@@ -272,7 +280,7 @@ func RemoveMicroservicePolicy(spec_ref string, org string, version string, msdef
 				continue
 			}
 			apiSpec := pol.APISpecs[0]
-			if apiSpec.SpecRef == spec_ref && apiSpec.Org == org && apiSpec.Version == version {
+			if cutil.SameSpecURL(apiSpec.SpecRef, spec_ref) && apiSpec.Org == org && apiSpec.Version == version {
 				pm.DeletePolicy(org, &pol)
 
 				// get the policy file name
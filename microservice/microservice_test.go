@@ -63,7 +63,7 @@ func TestMicroserviceReadyForUpgrade(t *testing.T) {
 	pms.UpgradeStartTime = uint64(0)
 
 	pms.Id = "1"
-	msi, err := persistence.NewMicroserviceInstance(db, pms.SpecRef, pms.Org, pms.Version, pms.Id, []persistence.ServiceInstancePathElement{})
+	msi, err := persistence.NewMicroserviceInstance(db, pms.SpecRef, pms.Org, pms.Version, "", pms.Id, []persistence.ServiceInstancePathElement{})
 	assert.Nil(t, err, fmt.Sprintf("should not return error, but got this: %v", err))
 
 	pms.AutoUpgrade = true
@@ -86,6 +86,8 @@ func TestGetUpgradeMicroserviceDef(t *testing.T) {
 	assert.Nil(t, err, fmt.Sprintf("should not return error, but got this: %v", err))
 
 	pms := createService(t)
+	pms.Origin = "autoconfig"
+	pms.OriginPattern = "mypattern"
 
 	// invalide verision range
 	saved_vr := pms.UpgradeVersionRange
@@ -103,6 +105,8 @@ func TestGetUpgradeMicroserviceDef(t *testing.T) {
 	assert.Equal(t, pms.ActiveUpgrade, new_ms.ActiveUpgrade, "")
 	assert.Equal(t, pms.Name, new_ms.Name, "")
 	assert.Equal(t, pms.UpgradeVersionRange, new_ms.UpgradeVersionRange, "")
+	assert.Equal(t, "autoconfig", new_ms.Origin, "the new version should keep where the service came from")
+	assert.Equal(t, "mypattern", new_ms.OriginPattern, "")
 
 	// lower version
 	new_ms, err = GetUpgradeMicroserviceDef(getVariableExchangeDefinitionHandler("0.5"), pms, db)
@@ -124,6 +128,8 @@ func TestGetRollbackMicroserviceDef(t *testing.T) {
 	assert.Nil(t, err, fmt.Sprintf("should not return error, but got this: %v", err))
 
 	pms := createService(t)
+	pms.Origin = "autoconfig"
+	pms.OriginPattern = "mypattern"
 
 	// invalide verision range
 	saved_vr := pms.UpgradeVersionRange
@@ -141,6 +147,8 @@ func TestGetRollbackMicroserviceDef(t *testing.T) {
 	assert.Equal(t, pms.ActiveUpgrade, new_ms.ActiveUpgrade, "")
 	assert.Equal(t, pms.Name, new_ms.Name, "")
 	assert.Equal(t, pms.UpgradeVersionRange, new_ms.UpgradeVersionRange, "")
+	assert.Equal(t, "autoconfig", new_ms.Origin, "the new version should keep where the service came from")
+	assert.Equal(t, "mypattern", new_ms.OriginPattern, "")
 
 	err = cleanupDB(dir)
 	assert.Nil(t, err, fmt.Sprintf("should not return error, but got this: %v", err))
@@ -0,0 +1,608 @@
+package objectsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/containermessage"
+	"github.com/open-horizon/anax/download"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * The objects that the services refer to in their deployment configs are downloaded from the object service into a
+ * cache that is shared by all the agreements and services on the node, their owners:
+ *
+ *   <cache dir>/blobs/<sha256>           the content of each downloaded object version, named by its digest
+ *   <cache dir>/owners/<owner>/<name>    hard links to the blobs, this directory is mounted in the owner's containers
+ *   <cache dir>/manifests/<owner>.json   the object references of the owner and the versions in its directory
+ *
+ * The object service provides the metadata of an object version, the latest version when none is given:
+ *
+ *   GET <url>/objects/<object>[?version=<version>]
+ *   {
+ *     "object": "detector-model",
+ *     "version": "2.1.0",
+ *     "digest": "sha256:9f86d0...",
+ *     "size": 104857600,
+ *     "signature": "<base64 RSA-PSS signature of the digest string>",
+ *     "data_url": "https://..."
+ *   }
+ *
+ * The content is downloaded from the data_url, or from <url>/objects/<object>/data?version=<version> when the
+ * metadata has none.
+ */
+
+const BLOBS_DIR = "blobs"
+const OWNERS_DIR = "owners"
+const MANIFESTS_DIR = "manifests"
+
+const DIGEST_PREFIX = "sha256:"
+
+// The metadata of an object version in the object service.
+type ObjectMetadata struct {
+	Object    string `json:"object"`
+	Version   string `json:"version"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	Signature string `json:"signature,omitempty"`
+	DataURL   string `json:"data_url,omitempty"`
+}
+
+// An object in the directory of an owner.
+type Object struct {
+	Name    string `json:"name"`
+	Object  string `json:"object"`
+	Version string `json:"version"`
+	Digest  string `json:"digest"`
+	Size    int64  `json:"size"`
+	Updated int64  `json:"updated"` // the time the version was put in the directory, seconds since the epoch
+}
+
+func (o Object) String() string {
+	return fmt.Sprintf("Name: %v, Object: %v, Version: %v, Digest: %v, Size: %v, Updated: %v", o.Name, o.Object, o.Version, o.Digest, o.Size, o.Updated)
+}
+
+// The object references of an owner and the objects in its directory, by name.
+type manifest struct {
+	Refs    []containermessage.ObjectReference `json:"refs"`
+	Objects map[string]Object                  `json:"objects"`
+}
+
+// Verifies the signature of the data, returns an error if none of the trusted keys verifies it.
+type VerifyFunc func(signature string, data []byte) error
+
+// Downloads the objects of the owners into the cache, keeps the objects without a version at their latest version and
+// keeps the cache under its size limit. It is safe for concurrent use. The syncs of an owner run one at a time, the
+// syncs of different owners download their objects concurrently.
+type Syncer struct {
+	config     *config.ObjectSyncConfig
+	baseURL    string
+	httpClient *http.Client
+	verify     VerifyFunc
+	now        func() time.Time
+	live       func() config.ObjectSyncConfig
+	liveLock   sync.RWMutex           // guards live
+	lock       sync.Mutex             // guards ownerLocks and pinned, and the blobs while they are evicted
+	ownerLocks map[string]*sync.Mutex // held while the directory and the manifest of an owner are changed
+	pinned     map[string]int         // the blobs that the running syncs are putting in place, they are not evicted
+}
+
+func NewSyncer(cfg *config.ObjectSyncConfig, httpClient *http.Client, verify VerifyFunc) (*Syncer, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("the object service URL is not configured")
+	}
+
+	for _, dir := range []string{BLOBS_DIR, OWNERS_DIR, MANIFESTS_DIR} {
+		if err := os.MkdirAll(path.Join(cfg.GetCacheDir(), dir), 0755); err != nil {
+			return nil, fmt.Errorf("unable to create the object cache directory %v, %v", dir, err)
+		}
+	}
+
+	return &Syncer{
+		config:     cfg,
+		baseURL:    strings.TrimRight(cfg.URL, "/"),
+		httpClient: httpClient,
+		verify:     verify,
+		now:        time.Now,
+		ownerLocks: make(map[string]*sync.Mutex),
+		pinned:     make(map[string]int),
+	}, nil
+}
+
+// Read the settings that can change while anax is running, MaxCacheMb and RequireSignature, from live each time they
+// are used rather than from the config that the syncer was created with.
+func (s *Syncer) SetLiveConfig(live func() config.ObjectSyncConfig) {
+	s.liveLock.Lock()
+	defer s.liveLock.Unlock()
+	s.live = live
+}
+
+// Returns the settings now in effect.
+func (s *Syncer) settings() config.ObjectSyncConfig {
+	s.liveLock.RLock()
+	defer s.liveLock.RUnlock()
+	if s.live != nil {
+		return s.live()
+	}
+	return *s.config
+}
+
+// Locks the directory and the manifest of the owner, returns the function that unlocks them. The lock of an owner is
+// kept once it is created, there are few owners.
+func (s *Syncer) lockOwner(owner string) func() {
+	s.lock.Lock()
+	ownerLock, ok := s.ownerLocks[owner]
+	if !ok {
+		ownerLock = new(sync.Mutex)
+		s.ownerLocks[owner] = ownerLock
+	}
+	s.lock.Unlock()
+
+	ownerLock.Lock()
+	return ownerLock.Unlock
+}
+
+// Keeps the blob with the digest from being evicted until it is unpinned, it is not in a manifest yet.
+func (s *Syncer) pin(digest string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pinned[strings.TrimPrefix(digest, DIGEST_PREFIX)]++
+}
+
+func (s *Syncer) unpin(digests []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, digest := range digests {
+		key := strings.TrimPrefix(digest, DIGEST_PREFIX)
+		if s.pinned[key]--; s.pinned[key] <= 0 {
+			delete(s.pinned, key)
+		}
+	}
+}
+
+// Evicts the blobs that are not used, see evict.
+func (s *Syncer) evictUnused() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.evict()
+}
+
+// Returns the directory of the owner's objects, the directory that is mounted in its containers.
+func (s *Syncer) OwnerDir(owner string) string {
+	return path.Join(s.config.GetCacheDir(), OWNERS_DIR, owner)
+}
+
+// Puts the referenced objects in the owner's directory and removes the objects that are no longer referenced. Returns
+// the objects whose version changed, including the ones that are new in the directory. When an object cannot be
+// synced the objects that were synced are kept and the error is returned.
+func (s *Syncer) Sync(owner string, refs []containermessage.ObjectReference) ([]Object, error) {
+	if err := checkOwner(owner); err != nil {
+		return nil, err
+	}
+
+	unlock := s.lockOwner(owner)
+	defer unlock()
+	return s.sync(owner, refs)
+}
+
+// Checks the objects of the owner for new versions, see Sync.
+func (s *Syncer) Resync(owner string) ([]Object, error) {
+	if err := checkOwner(owner); err != nil {
+		return nil, err
+	}
+
+	unlock := s.lockOwner(owner)
+	defer unlock()
+
+	m, err := readManifest(s.config.GetCacheDir(), owner)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	return s.sync(owner, m.Refs)
+}
+
+// See Sync, the caller holds the lock of the owner. The objects are downloaded without holding the lock of the syncer,
+// their blobs are pinned until the manifest refers to them.
+func (s *Syncer) sync(owner string, refs []containermessage.ObjectReference) ([]Object, error) {
+	pinned := make([]string, 0, len(refs))
+	defer func() { s.unpin(pinned) }()
+
+	m, err := readManifest(s.config.GetCacheDir(), owner)
+	if err != nil {
+		return nil, err
+	} else if m == nil {
+		m = &manifest{Objects: make(map[string]Object)}
+	}
+	m.Refs = refs
+
+	dir := s.OwnerDir(owner)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create the object directory of %v, %v", owner, err)
+	}
+
+	updated := make([]Object, 0)
+	var syncErr error
+	for _, ref := range refs {
+		current, haveCurrent := m.Objects[ref.Name]
+		if obj, changed, err := s.syncObject(dir, ref, current, haveCurrent, &pinned); err != nil {
+			syncErr = fmt.Errorf("object %v (%v): %v", ref.Name, ref.Object, err)
+			break
+		} else if changed {
+			m.Objects[ref.Name] = *obj
+			updated = append(updated, *obj)
+		}
+	}
+
+	// Remove the objects that are no longer referenced, unless the sync failed part way.
+	if syncErr == nil {
+		referenced := make(map[string]bool)
+		for _, ref := range refs {
+			referenced[ref.Name] = true
+		}
+		for name := range m.Objects {
+			if !referenced[name] {
+				delete(m.Objects, name)
+				if err := os.Remove(path.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+					glog.Warningf("Unable to remove object %v of %v, %v", name, owner, err)
+				}
+			}
+		}
+	}
+
+	if err := writeManifest(s.config.GetCacheDir(), owner, m); err != nil {
+		return updated, err
+	}
+	s.evictUnused()
+	return updated, syncErr
+}
+
+// Removes the directory and the manifest of the owner. The blobs stay in the cache until they are evicted.
+func (s *Syncer) Remove(owner string) error {
+	if err := checkOwner(owner); err != nil {
+		return err
+	}
+
+	unlock := s.lockOwner(owner)
+	defer unlock()
+
+	if err := os.RemoveAll(s.OwnerDir(owner)); err != nil {
+		return fmt.Errorf("unable to remove the object directory of %v, %v", owner, err)
+	} else if err := os.Remove(manifestPath(s.config.GetCacheDir(), owner)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove the object manifest of %v, %v", owner, err)
+	}
+	s.evictUnused()
+	return nil
+}
+
+// Returns the owners that have objects in the cache, sorted.
+func (s *Syncer) Owners() ([]string, error) {
+	return owners(s.config.GetCacheDir())
+}
+
+// Returns the object with its content in the cache and a changed flag. The current object is kept when it is still the
+// referenced version, only the objects without a version are looked up in the object service every time. The digest of
+// the blob is added to pinned before the blob is looked up in the cache, the caller unpins it.
+func (s *Syncer) syncObject(dir string, ref containermessage.ObjectReference, current Object, haveCurrent bool, pinned *[]string) (*Object, bool, error) {
+	file := path.Join(dir, ref.Name)
+	if haveCurrent && current.Object == ref.Object && ref.Version != "" && current.Version == ref.Version {
+		if _, err := os.Stat(file); err == nil {
+			return &current, false, nil
+		}
+	}
+
+	meta, err := s.getMetadata(ref)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if haveCurrent && current.Object == ref.Object && current.Digest == meta.Digest {
+		if _, err := os.Stat(file); err == nil {
+			return &current, false, nil
+		}
+	}
+
+	s.pin(meta.Digest)
+	*pinned = append(*pinned, meta.Digest)
+
+	blob, err := s.getBlob(meta)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Replace the file in one step, so that a container never sees a partial object.
+	tmp := path.Join(dir, "."+ref.Name+".tmp")
+	os.Remove(tmp)
+	if err := os.Link(blob, tmp); err != nil {
+		return nil, false, fmt.Errorf("unable to link the cached object, %v", err)
+	} else if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return nil, false, fmt.Errorf("unable to put the object in place, %v", err)
+	}
+
+	glog.V(3).Infof("Object %v is now %v version %v", file, meta.Object, meta.Version)
+	return &Object{
+		Name:    ref.Name,
+		Object:  ref.Object,
+		Version: meta.Version,
+		Digest:  meta.Digest,
+		Size:    meta.Size,
+		Updated: s.now().Unix(),
+	}, true, nil
+}
+
+// Returns the metadata of the referenced object version, the latest version when the reference has none.
+func (s *Syncer) getMetadata(ref containermessage.ObjectReference) (*ObjectMetadata, error) {
+	u := fmt.Sprintf("%v/objects/%v", s.baseURL, url.PathEscape(ref.Object))
+	if ref.Version != "" {
+		u += "?version=" + url.QueryEscape(ref.Version)
+	}
+
+	resp, err := s.get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	meta := new(ObjectMetadata)
+	if err := json.NewDecoder(resp.Body).Decode(meta); err != nil {
+		return nil, fmt.Errorf("unable to decode the object metadata from %v, %v", u, err)
+	} else if !strings.HasPrefix(meta.Digest, DIGEST_PREFIX) || len(meta.Digest) != len(DIGEST_PREFIX)+2*sha256.Size {
+		return nil, fmt.Errorf("the object metadata from %v has digest %v, it must be %v<hex>", u, meta.Digest, DIGEST_PREFIX)
+	} else if ref.Version != "" && meta.Version != ref.Version {
+		return nil, fmt.Errorf("the object service returned version %v for version %v", meta.Version, ref.Version)
+	}
+	meta.Digest = strings.ToLower(meta.Digest)
+	if meta.Object == "" {
+		meta.Object = ref.Object
+	}
+	return meta, nil
+}
+
+// Returns the blob file of the object version, downloading it when it is not in the cache. The signature of the digest
+// is verified before anything is downloaded, and the digest of the content before it is used.
+func (s *Syncer) getBlob(meta *ObjectMetadata) (string, error) {
+	if meta.Signature != "" {
+		if err := s.verify(meta.Signature, []byte(meta.Digest)); err != nil {
+			return "", fmt.Errorf("the signature of version %v is not valid, %v", meta.Version, err)
+		}
+	} else if s.settings().RequireSignature {
+		return "", fmt.Errorf("version %v is not signed and the node requires signed objects", meta.Version)
+	}
+
+	blob := path.Join(s.config.GetCacheDir(), BLOBS_DIR, strings.TrimPrefix(meta.Digest, DIGEST_PREFIX))
+	if _, err := os.Stat(blob); err == nil {
+		// the modification time of a blob is when it was last used, the least recently used blobs are evicted first
+		now := s.now()
+		os.Chtimes(blob, now, now)
+		return blob, nil
+	}
+
+	dataURL := meta.DataURL
+	if dataURL == "" {
+		dataURL = fmt.Sprintf("%v/objects/%v/data?version=%v", s.baseURL, url.PathEscape(meta.Object), url.QueryEscape(meta.Version))
+	}
+
+	glog.V(3).Infof("Downloading %v version %v from %v", meta.Object, meta.Version, dataURL)
+	resp, err := s.get(dataURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	tmp, err := ioutil.TempFile(path.Join(s.config.GetCacheDir(), BLOBS_DIR), ".download-")
+	if err != nil {
+		return "", fmt.Errorf("unable to create a file in the object cache, %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	// the download shares the download rate limit of the agent
+	body := download.NewReader(fmt.Sprintf("object %v version %v", meta.Object, meta.Version), meta.Size, resp.Body)
+	defer body.Done()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), body)
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to download version %v, %v", meta.Version, err)
+	} else if digest := DIGEST_PREFIX + hex.EncodeToString(hash.Sum(nil)); digest != meta.Digest {
+		return "", fmt.Errorf("the content of version %v has digest %v, expected %v", meta.Version, digest, meta.Digest)
+	} else if meta.Size != 0 && size != meta.Size {
+		return "", fmt.Errorf("the content of version %v has %v bytes, expected %v", meta.Version, size, meta.Size)
+	}
+	meta.Size = size
+
+	if err := os.Chmod(tmp.Name(), 0444); err != nil {
+		return "", err
+	} else if err := os.Rename(tmp.Name(), blob); err != nil {
+		return "", fmt.Errorf("unable to put the downloaded object in the cache, %v", err)
+	}
+	return blob, nil
+}
+
+func (s *Syncer) get(u string) (*http.Response, error) {
+	resp, err := s.httpClient.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach the object service at %v, %v", u, err)
+	} else if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%v not found in the object service", u)
+		}
+		return nil, fmt.Errorf("the object service returned HTTP status %v for %v", resp.StatusCode, u)
+	}
+	return resp, nil
+}
+
+// Removes the blobs that no owner uses, least recently used first, until the cache is within its size limit. The blobs
+// in use and the pinned ones are never removed, the cache is bigger than the limit when they need more space. The
+// caller holds the lock.
+func (s *Syncer) evict() {
+	cacheDir := s.config.GetCacheDir()
+	settings := s.settings()
+	maxBytes := settings.GetMaxCacheBytes()
+
+	inUse := make(map[string]bool)
+	if ownerList, err := owners(cacheDir); err == nil {
+		for _, owner := range ownerList {
+			if m, err := readManifest(cacheDir, owner); err == nil && m != nil {
+				for _, obj := range m.Objects {
+					inUse[strings.TrimPrefix(obj.Digest, DIGEST_PREFIX)] = true
+				}
+			}
+		}
+	} else {
+		glog.Warningf("Unable to read the object cache owners, not evicting. %v", err)
+		return
+	}
+
+	blobs, err := ioutil.ReadDir(path.Join(cacheDir, BLOBS_DIR))
+	if err != nil {
+		glog.Warningf("Unable to read the object cache, not evicting. %v", err)
+		return
+	}
+
+	total := int64(0)
+	unused := make([]os.FileInfo, 0)
+	for _, blob := range blobs {
+		if strings.HasPrefix(blob.Name(), ".") {
+			continue
+		}
+		total += blob.Size()
+		if !inUse[blob.Name()] && s.pinned[blob.Name()] == 0 {
+			unused = append(unused, blob)
+		}
+	}
+
+	sort.Slice(unused, func(i, j int) bool { return unused[i].ModTime().Before(unused[j].ModTime()) })
+	for _, blob := range unused {
+		if total <= maxBytes {
+			break
+		}
+		if err := os.Remove(path.Join(cacheDir, BLOBS_DIR, blob.Name())); err != nil {
+			glog.Warningf("Unable to evict %v from the object cache, %v", blob.Name(), err)
+			continue
+		}
+		glog.V(3).Infof("Evicted %v from the object cache", blob.Name())
+		total -= blob.Size()
+	}
+
+	if total > maxBytes {
+		glog.Warningf("The objects in use need %v bytes, more than the object cache limit of %v bytes", total, maxBytes)
+	}
+}
+
+// Returns the objects in the directory of the owner, sorted by name. Returns nil when the owner has no objects.
+func ListObjects(cacheDir string, owner string) ([]Object, error) {
+	if err := checkOwner(owner); err != nil {
+		return nil, err
+	}
+
+	m, err := readManifest(cacheDir, owner)
+	if err != nil || m == nil {
+		return nil, err
+	}
+
+	objects := make([]Object, 0, len(m.Objects))
+	for _, obj := range m.Objects {
+		objects = append(objects, obj)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return objects, nil
+}
+
+// Returns the object with the name in the directory of the owner and the path of its content, nil when there is none.
+func FindObject(cacheDir string, owner string, name string) (*Object, string, error) {
+	objects, err := ListObjects(cacheDir, owner)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, obj := range objects {
+		if obj.Name == name {
+			return &obj, path.Join(cacheDir, OWNERS_DIR, owner, name), nil
+		}
+	}
+	return nil, "", nil
+}
+
+// The owner is used as a directory name.
+func checkOwner(owner string) error {
+	if owner == "" || owner == "." || owner == ".." || filepath.Base(owner) != owner {
+		return fmt.Errorf("%v cannot own objects", owner)
+	}
+	return nil
+}
+
+func owners(cacheDir string) ([]string, error) {
+	files, err := ioutil.ReadDir(path.Join(cacheDir, MANIFESTS_DIR))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".json") && !strings.HasPrefix(f.Name(), ".") {
+			names = append(names, strings.TrimSuffix(f.Name(), ".json"))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func manifestPath(cacheDir string, owner string) string {
+	return path.Join(cacheDir, MANIFESTS_DIR, owner+".json")
+}
+
+// Returns nil when the owner has no manifest.
+func readManifest(cacheDir string, owner string) (*manifest, error) {
+	b, err := ioutil.ReadFile(manifestPath(cacheDir, owner))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read the object manifest of %v, %v", owner, err)
+	}
+
+	m := new(manifest)
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("unable to decode the object manifest of %v, %v", owner, err)
+	} else if m.Objects == nil {
+		m.Objects = make(map[string]Object)
+	}
+	return m, nil
+}
+
+// Write the manifest in one step, so that the readers never see a partial manifest.
+func writeManifest(cacheDir string, owner string, m *manifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("unable to encode the object manifest of %v, %v", owner, err)
+	}
+
+	file := manifestPath(cacheDir, owner)
+	tmp := path.Join(path.Dir(file), "."+owner+".json.tmp")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("unable to write the object manifest of %v, %v", owner, err)
+	} else if err := os.Rename(tmp, file); err != nil {
+		return fmt.Errorf("unable to write the object manifest of %v, %v", owner, err)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+// +build unit
+
+package objectsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/containermessage"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// A fake object service with versions of objects, the last version of an object is the latest.
+type fakeObjectService struct {
+	versions  map[string][]string // object id to its versions, oldest first
+	content   map[string]string   // object id and version to content
+	signature string
+	tamper    bool // serve content that does not match the digest
+	downloads int
+}
+
+func (f *fakeObjectService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/objects/"), "/")
+	versions, ok := f.versions[parts[0]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	version := r.URL.Query().Get("version")
+	if version == "" {
+		version = versions[len(versions)-1]
+	}
+	content, ok := f.content[parts[0]+"/"+version]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "data" {
+		f.downloads++
+		if f.tamper {
+			content += "x"
+		}
+		w.Write([]byte(content))
+		return
+	}
+
+	json.NewEncoder(w).Encode(ObjectMetadata{Object: parts[0], Version: version, Digest: digest(content), Size: int64(len(content)), Signature: f.signature})
+}
+
+func digest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return DIGEST_PREFIX + hex.EncodeToString(sum[:])
+}
+
+func newTestSyncer(t *testing.T, cfg *config.ObjectSyncConfig) (*fakeObjectService, *httptest.Server, *Syncer) {
+	fake := &fakeObjectService{
+		versions: map[string][]string{"model": {"1.0.0"}, "labels": {"1"}},
+		content:  map[string]string{"model/1.0.0": "model one", "model/2.0.0": "model two", "labels/1": "cat,dog"},
+	}
+	server := httptest.NewServer(fake)
+
+	dir, err := ioutil.TempDir("", "objectsync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.URL = server.URL
+	cfg.CacheDir = dir
+
+	verify := func(signature string, data []byte) error {
+		if signature != "signed:"+string(data) {
+			return errors.New("bad signature")
+		}
+		return nil
+	}
+
+	s, err := NewSyncer(cfg, server.Client(), verify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, server, s
+}
+
+func readObject(t *testing.T, s *Syncer, owner string, name string) string {
+	b, err := ioutil.ReadFile(path.Join(s.OwnerDir(owner), name))
+	if err != nil {
+		t.Errorf("unable to read object %v of %v, %v", name, owner, err)
+	}
+	return string(b)
+}
+
+func Test_Sync(t *testing.T) {
+	fake, server, s := newTestSyncer(t, &config.ObjectSyncConfig{})
+	defer server.Close()
+	defer os.RemoveAll(s.config.CacheDir)
+
+	refs := []containermessage.ObjectReference{{Name: "model.bin", Object: "model"}, {Name: "labels.txt", Object: "labels", Version: "1"}}
+	if updated, err := s.Sync("ag1", refs); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if len(updated) != 2 {
+		t.Errorf("expected 2 updated objects, got %v", updated)
+	}
+	if c := readObject(t, s, "ag1", "model.bin"); c != "model one" {
+		t.Errorf("unexpected content %v", c)
+	}
+
+	// nothing changed
+	if updated, err := s.Resync("ag1"); err != nil || len(updated) != 0 {
+		t.Errorf("expected no updates, got %v and error %v", updated, err)
+	}
+
+	// a new version of the object without a version is picked up, the pinned one stays
+	fake.versions["model"] = append(fake.versions["model"], "2.0.0")
+	if updated, err := s.Resync("ag1"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if len(updated) != 1 || updated[0].Name != "model.bin" || updated[0].Version != "2.0.0" {
+		t.Errorf("expected model.bin to be updated to 2.0.0, got %v", updated)
+	}
+	if c := readObject(t, s, "ag1", "model.bin"); c != "model two" {
+		t.Errorf("unexpected content %v", c)
+	}
+
+	// another owner of the same version shares the blob
+	downloads := fake.downloads
+	if _, err := s.Sync("ag2", []containermessage.ObjectReference{{Name: "m", Object: "model", Version: "2.0.0"}}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if fake.downloads != downloads {
+		t.Errorf("expected the cached blob to be used, got %v downloads", fake.downloads-downloads)
+	}
+
+	if objects, err := ListObjects(s.config.CacheDir, "ag1"); err != nil || len(objects) != 2 || objects[0].Name != "labels.txt" {
+		t.Errorf("unexpected objects %v, error %v", objects, err)
+	}
+	if obj, file, err := FindObject(s.config.CacheDir, "ag1", "labels.txt"); err != nil || obj == nil || obj.Version != "1" || file != path.Join(s.OwnerDir("ag1"), "labels.txt") {
+		t.Errorf("unexpected object %v at %v, error %v", obj, file, err)
+	}
+
+	// an object that is no longer referenced is removed from the directory
+	if _, err := s.Sync("ag1", refs[:1]); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if _, err := os.Stat(path.Join(s.OwnerDir("ag1"), "labels.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected labels.txt to be removed, got %v", err)
+	}
+
+	if err := s.Remove("ag1"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if owners, _ := s.Owners(); len(owners) != 1 || owners[0] != "ag2" {
+		t.Errorf("expected only ag2 to own objects, got %v", owners)
+	} else if objects, err := ListObjects(s.config.CacheDir, "ag1"); err != nil || objects != nil {
+		t.Errorf("expected no objects for ag1, got %v and error %v", objects, err)
+	}
+}
+
+func Test_Sync_verification(t *testing.T) {
+	fake, server, s := newTestSyncer(t, &config.ObjectSyncConfig{RequireSignature: true})
+	defer server.Close()
+	defer os.RemoveAll(s.config.CacheDir)
+	refs := []containermessage.ObjectReference{{Name: "model.bin", Object: "model"}}
+
+	if _, err := s.Sync("ag1", refs); err == nil {
+		t.Errorf("expected an error for an unsigned object")
+	}
+
+	fake.signature = "forged"
+	if _, err := s.Sync("ag1", refs); err == nil {
+		t.Errorf("expected an error for a bad signature")
+	}
+
+	fake.signature = "signed:" + digest("model one")
+	fake.tamper = true
+	if _, err := s.Sync("ag1", refs); err == nil {
+		t.Errorf("expected an error for content that does not match the digest")
+	} else if _, err := os.Stat(path.Join(s.OwnerDir("ag1"), "model.bin")); !os.IsNotExist(err) {
+		t.Errorf("the tampered object should not be in the directory, got %v", err)
+	}
+
+	fake.tamper = false
+	if _, err := s.Sync("ag1", refs); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if _, err := s.Sync("../ag1", refs); err == nil {
+		t.Errorf("expected an error for an owner that is not a file name")
+	}
+}
+
+func Test_evict(t *testing.T) {
+	fake, server, s := newTestSyncer(t, &config.ObjectSyncConfig{})
+	defer server.Close()
+	defer os.RemoveAll(s.config.CacheDir)
+
+	// a limit of one megabyte, the objects in use are bigger
+	s.config.MaxCacheMb = 1
+	fake.content["model/1.0.0"] = strings.Repeat("a", 1024*1024)
+	if _, err := s.Sync("ag1", []containermessage.ObjectReference{{Name: "model.bin", Object: "model", Version: "1.0.0"}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := s.Sync("ag2", []containermessage.ObjectReference{{Name: "labels.txt", Object: "labels"}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	blob := path.Join(s.config.CacheDir, BLOBS_DIR, strings.TrimPrefix(digest(fake.content["model/1.0.0"]), DIGEST_PREFIX))
+	if _, err := os.Stat(blob); err != nil {
+		t.Errorf("a blob in use was evicted, %v", err)
+	}
+
+	// once ag1 is gone its blob is the oldest unused one and the cache is over the limit
+	if err := s.Remove("ag1"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if _, err := os.Stat(blob); !os.IsNotExist(err) {
+		t.Errorf("expected the unused blob to be evicted, got %v", err)
+	} else if c := readObject(t, s, "ag2", "labels.txt"); c != "cat,dog" {
+		t.Errorf("unexpected content %v", c)
+	}
+}
+
+// A blob that a sync is putting in place is not evicted before the manifest of its owner refers to it.
+func Test_evict_pinned(t *testing.T) {
+	_, server, s := newTestSyncer(t, &config.ObjectSyncConfig{})
+	defer server.Close()
+	defer os.RemoveAll(s.config.CacheDir)
+
+	s.config.MaxCacheMb = 1
+	content := strings.Repeat("a", 2*1024*1024)
+	blob := path.Join(s.config.CacheDir, BLOBS_DIR, strings.TrimPrefix(digest(content), DIGEST_PREFIX))
+	if err := ioutil.WriteFile(blob, []byte(content), 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	s.pin(digest(content))
+	s.evictUnused()
+	if _, err := os.Stat(blob); err != nil {
+		t.Errorf("a pinned blob was evicted, %v", err)
+	}
+
+	s.unpin([]string{digest(content)})
+	s.evictUnused()
+	if _, err := os.Stat(blob); !os.IsNotExist(err) {
+		t.Errorf("expected the unpinned blob to be evicted, got %v", err)
+	} else if len(s.pinned) != 0 {
+		t.Errorf("the blob should no longer be pinned, the pinned blobs are %v", s.pinned)
+	}
+}
@@ -0,0 +1,277 @@
+// Package offline installs a signed bundle of services from local disk, for nodes that cannot reach the image
+// registries or the exchange when they are installed, e.g. on a factory floor. The bundle is a directory with a
+// manifest, bundle.json, its signature, bundle.json.sig, and the docker image tarballs that the manifest lists.
+//
+// The manifest holds the service definitions and patterns in the format that hzn publishes them with, the node user
+// input in the format that hzn register takes, and the sha256 of each image tarball, so that the signature of the
+// manifest covers the whole bundle. The images are loaded into the container runtime before any agreement needs them,
+// the image fetch worker then finds them locally instead of pulling them.
+//
+// The package also reads the offline definitions, a directory of pattern and service definitions as the exchange
+// returns them, that resolve the pattern of a node that is configured before it can reach the exchange. The reconcile
+// worker compares the ones that were used with the exchange once the node reaches it.
+package offline
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/common"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/container"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"github.com/open-horizon/rsapss-tool/verify"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The files of a bundle directory.
+const MANIFEST_FILE = "bundle.json"
+const SIGNATURE_FILE = "bundle.json.sig"
+
+// The content of a bundle.
+type Manifest struct {
+	Services  []common.ServiceFile `json:"services"`
+	Patterns  []common.PatternFile `json:"patterns"`
+	UserInput []policy.UserInput   `json:"userInput"`
+	Images    []Image              `json:"images"`
+}
+
+// A docker image tarball of the bundle, as written by docker save.
+type Image struct {
+	File   string   `json:"file"`   // relative to the bundle directory
+	SHA256 string   `json:"sha256"` // hex encoded
+	Tags   []string `json:"tags"`   // the images in the tarball, the tarball is not loaded when they are all present
+}
+
+// Read the manifest of the bundle and verify its signature with the keys that verify the deployment configs. Returns
+// the manifest and its sha256.
+func ReadManifest(cfg *config.HorizonConfig, dir string) (*Manifest, string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, MANIFEST_FILE))
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read the bundle manifest, %v", err)
+	}
+	signature, err := ioutil.ReadFile(filepath.Join(dir, SIGNATURE_FILE))
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read the bundle signature, %v", err)
+	}
+
+	keyFileNames, err := cfg.Collaborators.KeyFileNamesFetcher.GetKeyFileNames(cfg.Edge.PublicKeyPath, cfg.UserPublicKeyPath())
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to get the public key files, %v", err)
+	}
+	if verified, _, failed := verify.InputVerifiedByAnyKey(keyFileNames, strings.TrimSpace(string(signature)), data); !verified {
+		glog.Errorf("Unable to verify the bundle signature: %v", failed)
+		return nil, "", errors.New("there is no public key that verifies the bundle signature")
+	}
+
+	manifest := new(Manifest)
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, "", fmt.Errorf("unable to parse the bundle manifest, %v", err)
+	} else if err := manifest.Validate(); err != nil {
+		return nil, "", err
+	}
+	return manifest, fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// Check that the manifest identifies all of its content.
+func (m *Manifest) Validate() error {
+	for i, s := range m.Services {
+		if s.Org == "" || s.URL == "" || s.Version == "" || s.Arch == "" {
+			return fmt.Errorf("service %v of the bundle must have an org, url, version and arch", i)
+		}
+	}
+	for i, p := range m.Patterns {
+		if p.Org == "" || p.Name == "" {
+			return fmt.Errorf("pattern %v of the bundle must have an org and name", i)
+		}
+	}
+	for i, img := range m.Images {
+		if img.File == "" || img.SHA256 == "" {
+			return fmt.Errorf("image %v of the bundle must have a file and sha256", i)
+		} else if filepath.IsAbs(img.File) || strings.HasPrefix(filepath.Clean(img.File), "..") {
+			return fmt.Errorf("image file %v must be in the bundle directory", img.File)
+		}
+	}
+	return nil
+}
+
+// Install the bundle of the config, if there is one. It is called once when anax starts, before the workers. A
+// bundle that is already installed is skipped, one that failed is installed again, and each step is idempotent, so
+// that an installation that was interrupted is completed on the next start. A failure is logged and recorded in the
+// node status, the node still works with the registries and the exchange it can reach.
+func Install(cfg *config.HorizonConfig, db *bolt.DB) {
+	dir := cfg.Edge.OfflineBundlePath
+	if dir == "" {
+		return
+	} else if _, err := os.Stat(filepath.Join(dir, MANIFEST_FILE)); os.IsNotExist(err) {
+		glog.V(3).Infof("There is no offline bundle in %v", dir)
+		return
+	}
+
+	manifest, digest, err := ReadManifest(cfg, dir)
+	if err != nil {
+		recordFailure(db, &persistence.OfflineBundleStatus{Path: dir, Digest: digest}, err)
+		return
+	}
+
+	if status, err := persistence.FindOfflineBundleStatus(db); err != nil {
+		glog.Errorf("Unable to read the offline bundle status, error %v", err)
+		return
+	} else if status != nil && status.Digest == digest && status.State == persistence.OFFLINE_BUNDLE_STATE_INSTALLED {
+		glog.V(3).Infof("Offline bundle %v is already installed", digest)
+		return
+	}
+
+	glog.Infof("Installing offline bundle %v from %v", digest, dir)
+	status := &persistence.OfflineBundleStatus{Path: dir, Digest: digest}
+
+	if err := loadImages(cfg, dir, manifest.Images, status); err != nil {
+		recordFailure(db, status, err)
+		return
+	} else if err := saveDefinitions(db, manifest, status); err != nil {
+		recordFailure(db, status, err)
+		return
+	} else if err := saveUserInput(db, manifest.UserInput, status); err != nil {
+		recordFailure(db, status, err)
+		return
+	}
+
+	status.State = persistence.OFFLINE_BUNDLE_STATE_INSTALLED
+	status.InstallTime = uint64(time.Now().Unix())
+	if err := persistence.SaveOfflineBundleStatus(db, status); err != nil {
+		glog.Errorf("Unable to save the offline bundle status %v, error %v", status, err)
+	} else {
+		glog.Infof("Installed offline bundle %v, the node is ready to be registered", status)
+	}
+}
+
+func recordFailure(db *bolt.DB, status *persistence.OfflineBundleStatus, err error) {
+	glog.Errorf("Unable to install the offline bundle in %v: %v", status.Path, err)
+	status.State = persistence.OFFLINE_BUNDLE_STATE_FAILED
+	status.Error = err.Error()
+	if err := persistence.SaveOfflineBundleStatus(db, status); err != nil {
+		glog.Errorf("Unable to save the offline bundle status %v, error %v", status, err)
+	}
+}
+
+// Load the image tarballs into the container runtime. A tarball whose images are all present is not loaded again.
+func loadImages(cfg *config.HorizonConfig, dir string, images []Image, status *persistence.OfflineBundleStatus) error {
+	if len(images) == 0 {
+		return nil
+	}
+
+	rt, err := container.NewContainerRuntime(cfg)
+	if err != nil {
+		return fmt.Errorf("unable to create the container runtime client, %v", err)
+	}
+
+	for _, img := range images {
+		if present(rt, img.Tags) {
+			glog.V(3).Infof("Images %v of %v are already present", img.Tags, img.File)
+			continue
+		}
+
+		file := filepath.Join(dir, img.File)
+		if err := checkDigest(file, img.SHA256); err != nil {
+			return err
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return fmt.Errorf("unable to open image file %v, %v", file, err)
+		}
+		err = rt.LoadImage(docker.LoadImageOptions{InputStream: f})
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("unable to load image file %v, %v", file, err)
+		}
+
+		glog.V(3).Infof("Loaded image file %v", file)
+		status.Images = append(status.Images, img.File)
+	}
+	return nil
+}
+
+func present(rt container.ContainerRuntime, tags []string) bool {
+	if len(tags) == 0 {
+		return false
+	}
+	for _, tag := range tags {
+		if _, err := rt.InspectImage(tag); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// The manifest is signed, the tarballs are checked against it before they are loaded.
+func checkDigest(file string, expected string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("unable to open image file %v, %v", file, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("unable to read image file %v, %v", file, err)
+	} else if actual := fmt.Sprintf("%x", h.Sum(nil)); !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("image file %v has sha256 %v, the bundle manifest expects %v", file, actual, expected)
+	}
+	return nil
+}
+
+// Store the service definitions and patterns, for the node to resolve its services without the exchange.
+func saveDefinitions(db *bolt.DB, manifest *Manifest, status *persistence.OfflineBundleStatus) error {
+	for _, s := range manifest.Services {
+		key := fmt.Sprintf("%v/%v/%v/%v", s.Org, s.URL, s.Version, s.Arch)
+		if err := saveDefinition(db, persistence.OFFLINE_DEFINITION_SERVICE, key, s); err != nil {
+			return err
+		}
+		status.Services = append(status.Services, key)
+	}
+	for _, p := range manifest.Patterns {
+		key := fmt.Sprintf("%v/%v", p.Org, p.Name)
+		if err := saveDefinition(db, persistence.OFFLINE_DEFINITION_PATTERN, key, p); err != nil {
+			return err
+		}
+		status.Patterns = append(status.Patterns, key)
+	}
+	return nil
+}
+
+func saveDefinition(db *bolt.DB, kind string, key string, def interface{}) error {
+	if serial, err := json.Marshal(def); err != nil {
+		return fmt.Errorf("unable to serialize %v %v, %v", kind, key, err)
+	} else if err := persistence.SaveOfflineDefinition(db, &persistence.OfflineDefinition{Kind: kind, Key: key, Definition: serial}); err != nil {
+		return fmt.Errorf("unable to save %v %v, %v", kind, key, err)
+	}
+	return nil
+}
+
+// The user input of the bundle is the node user input until the node has its own, it does not replace user input
+// that was set through the API.
+func saveUserInput(db *bolt.DB, userInput []policy.UserInput, status *persistence.OfflineBundleStatus) error {
+	if len(userInput) == 0 {
+		return nil
+	} else if existing, err := persistence.FindNodeUserInput(db); err != nil {
+		return fmt.Errorf("unable to read the node user input, %v", err)
+	} else if len(existing) != 0 {
+		glog.V(3).Infof("The node has user input, the user input of the offline bundle is not used")
+		return nil
+	} else if err := persistence.SaveNodeUserInput(db, userInput); err != nil {
+		return fmt.Errorf("unable to save the node user input, %v", err)
+	}
+	status.UserInput = true
+	return nil
+}
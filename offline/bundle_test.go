@@ -0,0 +1,58 @@
+// +build unit
+
+package offline
+
+import (
+	"github.com/open-horizon/anax/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ManifestValidate(t *testing.T) {
+
+	tests := []struct {
+		manifest Manifest
+		valid    bool
+	}{
+		{Manifest{}, true},
+		{Manifest{Services: []common.ServiceFile{{Org: "e2edev", URL: "my.company.com.services.gps", Version: "1.0.0", Arch: "amd64"}}}, true},
+		{Manifest{Services: []common.ServiceFile{{Org: "e2edev", URL: "my.company.com.services.gps", Version: "1.0.0"}}}, false},
+		{Manifest{Patterns: []common.PatternFile{{Org: "e2edev", Name: "sns"}}}, true},
+		{Manifest{Patterns: []common.PatternFile{{Name: "sns"}}}, false},
+		{Manifest{Images: []Image{{File: "images/gps.tar", SHA256: "abcd"}}}, true},
+		{Manifest{Images: []Image{{File: "images/gps.tar"}}}, false},
+		{Manifest{Images: []Image{{File: "../gps.tar", SHA256: "abcd"}}}, false},
+		{Manifest{Images: []Image{{File: "/tmp/gps.tar", SHA256: "abcd"}}}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.manifest.Validate(); (err == nil) != test.valid {
+			t.Errorf("manifest %v should be valid %v, got error %v", test.manifest, test.valid, err)
+		}
+	}
+}
+
+func Test_checkDigest(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "anax-offline-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir, error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "image.tar")
+	if err := ioutil.WriteFile(file, []byte("image"), 0600); err != nil {
+		t.Fatalf("Failed to create file, error %v", err)
+	}
+
+	// sha256 of "image"
+	if err := checkDigest(file, "6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d"); err != nil {
+		t.Errorf("expected the digest to match, got %v", err)
+	} else if err := checkDigest(file, "0000"); err == nil {
+		t.Errorf("expected the digest not to match")
+	} else if err := checkDigest(filepath.Join(dir, "missing.tar"), "0000"); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
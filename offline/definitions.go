@@ -0,0 +1,382 @@
+package offline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/common"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/semanticversion"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The subdirectory of the offline bundle with the offline definitions.
+const DEFINITIONS_DIR = "definitions"
+
+// The offline definitions directory of the offline bundle of the config, empty when there is no bundle, see the
+// Edge.OfflineBundlePath of the config.
+func DefinitionsDir(cfg *config.HorizonConfig) string {
+	if cfg.Edge.OfflineBundlePath == "" {
+		return ""
+	}
+	return filepath.Join(cfg.Edge.OfflineBundlePath, DEFINITIONS_DIR)
+}
+
+// The content of a file of the offline definitions directory, see DefinitionsDir. It is a response of the exchange:
+// the patterns by org/name, and the services by org/id.
+type definitionsFile struct {
+	Patterns  map[string]exchange.Pattern           `json:"patterns"`
+	Services  map[string]exchange.ServiceDefinition `json:"services"`
+	LastIndex int                                   `json:"lastIndex"`
+}
+
+// The pattern and service definitions of the offline definitions directory, to resolve the pattern of a node that
+// cannot reach the exchange. The handlers record the definitions they return, so that they can be compared with the
+// ones of the exchange once the node reaches it.
+type Definitions struct {
+	Dir      string
+	Patterns map[string]exchange.Pattern           // by org/name
+	Services map[string]exchange.ServiceDefinition // by org/id
+	files    map[string]string                     // the file of each definition, by pattern or service key
+	lock     sync.Mutex
+	used     map[string]bool
+}
+
+// Read the definitions of the .json files of dir. An error that names the file is returned when a file cannot be read,
+// is not a response of the exchange with patterns or services, or has a definition that another file also has.
+func LoadDefinitions(dir string) (*Definitions, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the offline definitions directory %v, %v", dir, err)
+	}
+
+	defs := NewDefinitions(dir)
+	for _, info := range infos {
+		if info.IsDir() || filepath.Ext(info.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(dir, info.Name())
+		if err := defs.load(path); err != nil {
+			return nil, fmt.Errorf("offline definitions file %v is not valid: %v", path, err)
+		}
+	}
+
+	glog.V(3).Infof("Read %v patterns and %v services from the offline definitions in %v", len(defs.Patterns), len(defs.Services), dir)
+	return defs, nil
+}
+
+// Returns the empty definitions of dir, to add the stored definitions to when dir does not exist.
+func NewDefinitions(dir string) *Definitions {
+	return &Definitions{
+		Dir:      dir,
+		Patterns: map[string]exchange.Pattern{},
+		Services: map[string]exchange.ServiceDefinition{},
+		files:    map[string]string{},
+		used:     map[string]bool{},
+	}
+}
+
+// Add the pattern and service definitions that were stored in the database when the offline bundle was installed,
+// see saveDefinitions. The definitions of the directory take precedence over the stored ones with the same key. The
+// stored definitions are reported as read from the manifest of the bundle. Returns the number of definitions added.
+func (d *Definitions) AddStored(db *bolt.DB) (int, error) {
+	stored, err := persistence.FindOfflineDefinitions(db, "")
+	if err != nil {
+		return 0, fmt.Errorf("unable to read the offline definitions of the bundle, %v", err)
+	}
+
+	manifest := filepath.Join(filepath.Dir(d.Dir), MANIFEST_FILE)
+	added := 0
+	for _, sd := range stored {
+		var key string
+		switch sd.Kind {
+		case persistence.OFFLINE_DEFINITION_PATTERN:
+			var pf common.PatternFile
+			if err := json.Unmarshal(sd.Definition, &pf); err != nil {
+				return added, fmt.Errorf("stored pattern %v is not valid: %v", sd.Key, err)
+			}
+			key = fmt.Sprintf("%v/%v", pf.Org, pf.Name)
+			if _, ok := d.files[key]; !ok {
+				d.Patterns[key] = exchange.Pattern{
+					Label:              pf.Label,
+					Description:        pf.Description,
+					Public:             pf.Public,
+					Services:           pf.GetServices(),
+					AgreementProtocols: pf.AgreementProtocols,
+					UserInput:          pf.UserInput,
+				}
+			}
+
+		case persistence.OFFLINE_DEFINITION_SERVICE:
+			var sf common.ServiceFile
+			if err := json.Unmarshal(sd.Definition, &sf); err != nil {
+				return added, fmt.Errorf("stored service %v is not valid: %v", sd.Key, err)
+			}
+			key = fmt.Sprintf("%v/%v", sf.Org, cutil.FormExchangeIdForService(sf.URL, sf.Version, sf.Arch))
+			if _, ok := d.files[key]; !ok {
+				deployment, err := deploymentString(sf.Deployment)
+				if err != nil {
+					return added, fmt.Errorf("stored service %v has a deployment that is not valid: %v", sd.Key, err)
+				}
+				clusterDeployment, err := deploymentString(sf.ClusterDeployment)
+				if err != nil {
+					return added, fmt.Errorf("stored service %v has a cluster deployment that is not valid: %v", sd.Key, err)
+				}
+				d.Services[key] = exchange.ServiceDefinition{
+					Label:                      sf.Label,
+					Description:                sf.Description,
+					Documentation:              sf.Documentation,
+					Public:                     sf.Public,
+					URL:                        sf.URL,
+					Version:                    sf.Version,
+					Arch:                       sf.Arch,
+					Sharable:                   sf.Sharable,
+					MatchHardware:              sf.MatchHardware,
+					RequiredServices:           sf.RequiredServices,
+					UserInputs:                 sf.UserInputs,
+					Deployment:                 deployment,
+					DeploymentSignature:        sf.DeploymentSignature,
+					ClusterDeployment:          clusterDeployment,
+					ClusterDeploymentSignature: sf.ClusterDeploymentSignature,
+				}
+			}
+
+		default:
+			continue
+		}
+
+		if _, ok := d.files[key]; !ok {
+			d.files[key] = manifest
+			added++
+		}
+	}
+
+	glog.V(3).Infof("Added %v stored definitions of the offline bundle to the offline definitions", added)
+	return added, nil
+}
+
+// The deployments of the services of the bundle are json objects, or the strings that were signed.
+func deploymentString(deployment interface{}) (string, error) {
+	if deployment == nil {
+		return "", nil
+	} else if s, ok := deployment.(string); ok {
+		return s, nil
+	} else if serial, err := json.Marshal(deployment); err != nil {
+		return "", err
+	} else {
+		return string(serial), nil
+	}
+}
+
+func (d *Definitions) load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var f definitionsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	} else if len(f.Patterns) == 0 && len(f.Services) == 0 {
+		return errors.New("it has no patterns or services, it must be a response of the exchange")
+	}
+
+	for key, pattern := range f.Patterns {
+		if org, name := splitKey(key); org == "" || name == "" {
+			return fmt.Errorf("pattern %v must be given as org/name", key)
+		} else if other, ok := d.files[key]; ok {
+			return fmt.Errorf("pattern %v is also in %v", key, other)
+		}
+		for _, service := range pattern.Services {
+			if service.ServiceURL == "" || service.ServiceOrg == "" || len(service.ServiceVersions) == 0 {
+				return fmt.Errorf("the services of pattern %v must have a serviceUrl, a serviceOrgid and serviceVersions", key)
+			}
+		}
+		d.Patterns[key] = pattern
+		d.files[key] = path
+	}
+
+	for key, service := range f.Services {
+		if org, id := splitKey(key); org == "" || id == "" {
+			return fmt.Errorf("service %v must be given as org/id", key)
+		} else if service.URL == "" || service.Arch == "" || !semanticversion.IsVersionString(service.Version) {
+			return fmt.Errorf("service %v must have a url, an arch and a version", key)
+		} else if other, ok := d.files[key]; ok {
+			return fmt.Errorf("service %v is also in %v", key, other)
+		}
+		d.Services[key] = service
+		d.files[key] = path
+	}
+	return nil
+}
+
+func splitKey(key string) (string, string) {
+	if parts := strings.SplitN(key, "/", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return "", ""
+}
+
+// The file that a definition was read from.
+func (d *Definitions) File(key string) string {
+	return d.files[key]
+}
+
+// The keys of the patterns and services that the handlers returned, sorted.
+func (d *Definitions) Used() (patterns []string, services []string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for key := range d.used {
+		if _, ok := d.Patterns[key]; ok {
+			patterns = append(patterns, key)
+		} else {
+			services = append(services, key)
+		}
+	}
+	sort.Strings(patterns)
+	sort.Strings(services)
+	return patterns, services
+}
+
+// The record of the definitions that the handlers returned, to compare them with the exchange later, nil when none were.
+func (d *Definitions) UsedRecord(pattern string) (*persistence.OfflineConfigstate, error) {
+	patterns, services := d.Used()
+	if len(patterns) == 0 && len(services) == 0 {
+		return nil, nil
+	}
+
+	record := &persistence.OfflineConfigstate{
+		Dir:           d.Dir,
+		Pattern:       pattern,
+		ConfigureTime: uint64(time.Now().Unix()),
+		Patterns:      make(map[string]json.RawMessage, len(patterns)),
+		Services:      make(map[string]json.RawMessage, len(services)),
+	}
+	for _, key := range patterns {
+		if serial, err := json.Marshal(d.Patterns[key]); err != nil {
+			return nil, fmt.Errorf("unable to serialize pattern %v, %v", key, err)
+		} else {
+			record.Patterns[key] = serial
+		}
+	}
+	for _, key := range services {
+		if serial, err := json.Marshal(d.Services[key]); err != nil {
+			return nil, fmt.Errorf("unable to serialize service %v, %v", key, err)
+		} else {
+			record.Services[key] = serial
+		}
+	}
+	return record, nil
+}
+
+func (d *Definitions) use(key string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.used[key] = true
+}
+
+// A pattern handler that reads the patterns of the definitions, as the exchange returns them.
+func (d *Definitions) PatternHandler() exchange.PatternHandler {
+	return func(org string, pattern string) (map[string]exchange.Pattern, error) {
+		patterns := map[string]exchange.Pattern{}
+		for key, p := range d.Patterns {
+			if patOrg, patName := splitKey(key); patOrg == org && (pattern == "" || patName == pattern) {
+				patterns[key] = p
+				d.use(key)
+			}
+		}
+		glog.V(3).Infof("Using the offline definitions of the patterns of %v/%v: %v", org, pattern, len(patterns))
+		return patterns, nil
+	}
+}
+
+// A service handler that returns the highest version of the service in the version range of the definitions, or the
+// version itself when it is not a range, as the exchange does.
+func (d *Definitions) ServiceHandler() exchange.ServiceHandler {
+	return func(wUrl string, wOrg string, wVersion string, wArch string) (*exchange.ServiceDefinition, string, error) {
+		candidates := map[string]exchange.ServiceDefinition{}
+		for key, s := range d.Services {
+			if org, _ := splitKey(key); org != wOrg || s.URL != wUrl || (wArch != "" && s.Arch != wArch) {
+				continue
+			} else if semanticversion.IsVersionString(wVersion) && s.Version != wVersion {
+				continue
+			}
+			candidates[key] = s
+		}
+
+		vRange, err := semanticversion.Version_Expression_Factory("0.0.0")
+		if wVersion != "" && !semanticversion.IsVersionString(wVersion) {
+			vRange, err = semanticversion.Version_Expression_Factory(wVersion)
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("version range %v in error: %v", wVersion, err)
+		}
+
+		if highest, sdef, key, err := exchange.GetHighestVersion(candidates, vRange); err != nil {
+			return nil, "", err
+		} else if highest == "" {
+			return nil, "", fmt.Errorf("service %v/%v %v %v is not in the offline definitions in %v", wOrg, wUrl, wVersion, wArch, d.Dir)
+		} else {
+			d.use(key)
+			return &sdef, key, nil
+		}
+	}
+}
+
+// A service resolver that resolves the services and the services they require from the definitions.
+func (d *Definitions) ServiceDefResolverHandler() exchange.ServiceDefResolverHandler {
+	getService := d.ServiceHandler()
+	return func(wUrl string, wOrg string, wVersion string, wArch string) (map[string]exchange.ServiceDefinition, *exchange.ServiceDefinition, string, error) {
+		return exchange.ServiceDefResolver(wUrl, wOrg, wVersion, wArch, getService)
+	}
+}
+
+// The handlers below call the exchange, and use the definitions when the exchange cannot be reached, i.e. the call
+// failed with an error that retrying could fix. The other errors of the exchange are returned.
+
+func (d *Definitions) FallbackPatternHandler(getPatterns exchange.PatternHandler) exchange.PatternHandler {
+	offline := d.PatternHandler()
+	return func(org string, pattern string) (map[string]exchange.Pattern, error) {
+		patterns, err := getPatterns(org, pattern)
+		if exchange.IsRetryableError(err) {
+			glog.Warningf("Unable to read pattern %v/%v from the exchange, using the offline definitions: %v", org, pattern, err)
+			return offline(org, pattern)
+		}
+		return patterns, err
+	}
+}
+
+func (d *Definitions) FallbackServiceHandler(getService exchange.ServiceHandler) exchange.ServiceHandler {
+	offline := d.ServiceHandler()
+	return func(wUrl string, wOrg string, wVersion string, wArch string) (*exchange.ServiceDefinition, string, error) {
+		sdef, id, err := getService(wUrl, wOrg, wVersion, wArch)
+		if exchange.IsRetryableError(err) {
+			glog.Warningf("Unable to read service %v/%v %v %v from the exchange, using the offline definitions: %v", wOrg, wUrl, wVersion, wArch, err)
+			return offline(wUrl, wOrg, wVersion, wArch)
+		}
+		return sdef, id, err
+	}
+}
+
+func (d *Definitions) FallbackServiceDefResolverHandler(resolveService exchange.ServiceDefResolverHandler) exchange.ServiceDefResolverHandler {
+	offline := d.ServiceDefResolverHandler()
+	return func(wUrl string, wOrg string, wVersion string, wArch string) (map[string]exchange.ServiceDefinition, *exchange.ServiceDefinition, string, error) {
+		deps, sdef, id, err := resolveService(wUrl, wOrg, wVersion, wArch)
+		if exchange.IsRetryableError(err) {
+			glog.Warningf("Unable to resolve service %v/%v %v %v in the exchange, using the offline definitions: %v", wOrg, wUrl, wVersion, wArch, err)
+			return offline(wUrl, wOrg, wVersion, wArch)
+		}
+		return deps, sdef, id, err
+	}
+}
@@ -0,0 +1,172 @@
+// +build unit
+
+package offline
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const patternsFile = `{
+  "patterns": {
+    "e2edev/sns": {
+      "owner": "e2edev/admin",
+      "label": "sns",
+      "services": [{"serviceUrl": "my.company.com.services.gps", "serviceOrgid": "e2edev", "serviceArch": "amd64", "serviceVersions": [{"version": "[1.0.0,2.0.0)"}]}],
+      "lastUpdated": "2020-05-01T10:00:00.000Z"
+    }
+  },
+  "lastIndex": 0
+}`
+
+const servicesFile = `{
+  "services": {
+    "e2edev/gps_1.0.0_amd64": {"url": "my.company.com.services.gps", "version": "1.0.0", "arch": "amd64", "lastUpdated": "2020-05-01T10:00:00.000Z"},
+    "e2edev/gps_1.5.0_amd64": {"url": "my.company.com.services.gps", "version": "1.5.0", "arch": "amd64", "lastUpdated": "2020-05-01T10:00:00.000Z"},
+    "e2edev/gps_2.0.0_amd64": {"url": "my.company.com.services.gps", "version": "2.0.0", "arch": "amd64", "lastUpdated": "2020-05-01T10:00:00.000Z"}
+  },
+  "lastIndex": 0
+}`
+
+func writeDefinitions(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "anax-offline-definitions-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func Test_LoadDefinitions(t *testing.T) {
+
+	dir := writeDefinitions(t, map[string]string{"patterns.json": patternsFile, "services.json": servicesFile, "README": "not a definition"})
+	defer os.RemoveAll(dir)
+
+	defs, err := LoadDefinitions(dir)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if len(defs.Patterns) != 1 || len(defs.Services) != 3 {
+		t.Fatalf("the pattern and the 3 services should be read, got %v %v", defs.Patterns, defs.Services)
+	} else if defs.File("e2edev/sns") != filepath.Join(dir, "patterns.json") {
+		t.Errorf("the file of the pattern should be recorded, got %v", defs.File("e2edev/sns"))
+	}
+
+	// the errors name the file
+	tests := map[string]string{
+		"bad.json":     `{"patterns": `,
+		"empty.json":   `{"lastIndex": 0}`,
+		"key.json":     `{"patterns": {"sns": {"services": []}}}`,
+		"service.json": `{"services": {"e2edev/gps": {"url": "my.company.com.services.gps", "version": "1.x", "arch": "amd64"}}}`,
+		"dup.json":     `{"services": {"e2edev/gps_1.0.0_amd64": {"url": "my.company.com.services.gps", "version": "1.0.0", "arch": "amd64"}}}`,
+	}
+	for name, content := range tests {
+		files := map[string]string{"services.json": servicesFile, name: content}
+		badDir := writeDefinitions(t, files)
+		if _, err := LoadDefinitions(badDir); err == nil {
+			t.Errorf("file %v should be rejected", name)
+		} else if !strings.Contains(err.Error(), filepath.Join(badDir, name)) {
+			t.Errorf("the error should name the file %v, got %v", name, err)
+		}
+		os.RemoveAll(badDir)
+	}
+
+	if _, err := LoadDefinitions(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("a missing directory should be rejected")
+	}
+}
+
+func Test_Definitions_handlers(t *testing.T) {
+
+	dir := writeDefinitions(t, map[string]string{"patterns.json": patternsFile, "services.json": servicesFile})
+	defer os.RemoveAll(dir)
+
+	defs, err := LoadDefinitions(dir)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if patterns, err := defs.PatternHandler()("e2edev", "sns"); err != nil || len(patterns) != 1 {
+		t.Errorf("the pattern should be found, got %v, error %v", patterns, err)
+	} else if patterns, _ := defs.PatternHandler()("otherorg", "sns"); len(patterns) != 0 {
+		t.Errorf("the pattern of another org should not be found, got %v", patterns)
+	}
+
+	// the highest version in the range, or the version itself
+	if sdef, id, err := defs.ServiceHandler()("my.company.com.services.gps", "e2edev", "[1.0.0,2.0.0)", "amd64"); err != nil || sdef.Version != "1.5.0" || id != "e2edev/gps_1.5.0_amd64" {
+		t.Errorf("the highest version in the range should be returned, got %v %v, error %v", sdef, id, err)
+	}
+	if sdef, _, err := defs.ServiceHandler()("my.company.com.services.gps", "e2edev", "1.0.0", "amd64"); err != nil || sdef.Version != "1.0.0" {
+		t.Errorf("the version should be returned, got %v, error %v", sdef, err)
+	}
+	if _, _, err := defs.ServiceHandler()("my.company.com.services.gps", "e2edev", "3.0.0", "amd64"); err == nil {
+		t.Errorf("a version that is not in the definitions should not be found")
+	}
+
+	if patterns, services := defs.Used(); len(patterns) != 1 || len(services) != 2 {
+		t.Errorf("the pattern and the 2 services that were returned should be used, got %v %v", patterns, services)
+	}
+
+	// the exchange is only replaced when it cannot be reached
+	unreachable := func(org string, pattern string) (map[string]exchange.Pattern, error) {
+		return nil, errors.New("Error getting patterns, HTTP Status: 503")
+	}
+	if patterns, err := defs.FallbackPatternHandler(unreachable)("e2edev", "sns"); err != nil || len(patterns) != 1 {
+		t.Errorf("the offline pattern should be used, got %v, error %v", patterns, err)
+	}
+	notFound := func(org string, pattern string) (map[string]exchange.Pattern, error) {
+		return nil, errors.New("pattern not found")
+	}
+	if _, err := defs.FallbackPatternHandler(notFound)("e2edev", "sns"); err == nil {
+		t.Errorf("the error of the exchange should be returned")
+	}
+
+	record, err := defs.UsedRecord("e2edev/sns")
+	if err != nil || record == nil || len(record.Patterns) != 1 || len(record.Services) != 2 || record.Dir != dir {
+		t.Errorf("the record should have the used definitions, got %v, error %v", record, err)
+	}
+}
+
+func Test_Reconcile(t *testing.T) {
+
+	pattern := exchange.Pattern{Label: "sns", LastUpdated: "1"}
+	sdef := exchange.ServiceDefinition{URL: "gps", Version: "1.0.0", Arch: "amd64", LastUpdated: "1"}
+	serialPattern, _ := json.Marshal(pattern)
+	serialService, _ := json.Marshal(sdef)
+	record := &persistence.OfflineConfigstate{
+		Patterns: map[string]json.RawMessage{"e2edev/sns": serialPattern},
+		Services: map[string]json.RawMessage{"e2edev/gps_1.0.0_amd64": serialService},
+	}
+
+	getPatterns := func(org string, name string) (map[string]exchange.Pattern, error) {
+		return map[string]exchange.Pattern{"e2edev/sns": pattern}, nil
+	}
+	getService := func(wUrl string, wOrg string, wVersion string, wArch string) (*exchange.ServiceDefinition, string, error) {
+		changed := sdef
+		changed.LastUpdated = "2"
+		return &changed, "e2edev/gps_1.0.0_amd64", nil
+	}
+
+	if drift, err := Reconcile(record, getPatterns, getService); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if len(drift) != 1 || drift[0].Key != "e2edev/gps_1.0.0_amd64" || drift[0].Kind != persistence.OFFLINE_DEFINITION_SERVICE {
+		t.Errorf("only the service should drift, got %v", drift)
+	}
+
+	unreachable := func(org string, name string) (map[string]exchange.Pattern, error) {
+		return nil, errors.New("dial tcp: connection refused")
+	}
+	if _, err := Reconcile(record, unreachable, getService); err == nil {
+		t.Errorf("the definitions should not be compared when the exchange cannot be reached")
+	}
+}
@@ -0,0 +1,92 @@
+package offline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+	"sort"
+)
+
+// A definition that configured the node offline and that is not the same in the exchange.
+type Drift struct {
+	Key    string // org/name of a pattern, org/id of a service
+	Kind   string // persistence.OFFLINE_DEFINITION_PATTERN or persistence.OFFLINE_DEFINITION_SERVICE
+	Reason string
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%v %v %v", d.Kind, d.Key, d.Reason)
+}
+
+// Compare the definitions that configured the node offline with the ones of the exchange. A definition drifted when the
+// exchange does not have it anymore, or has a different one. An error is returned when the exchange cannot be reached,
+// they are compared again later then.
+func Reconcile(record *persistence.OfflineConfigstate, getPatterns exchange.PatternHandler, getService exchange.ServiceHandler) ([]Drift, error) {
+	drift := make([]Drift, 0)
+
+	for _, key := range sortedKeys(record.Patterns) {
+		var cached exchange.Pattern
+		if err := json.Unmarshal(record.Patterns[key], &cached); err != nil {
+			return nil, fmt.Errorf("unable to demarshal the offline definition of pattern %v, %v", key, err)
+		}
+
+		org, name := splitKey(key)
+		patterns, err := getPatterns(org, name)
+		if exchange.IsRetryableError(err) || exchange.IsTransportError(nil, err) {
+			return nil, err
+		} else if pattern, ok := patterns[key]; err != nil || !ok {
+			drift = append(drift, Drift{Key: key, Kind: persistence.OFFLINE_DEFINITION_PATTERN, Reason: notFoundReason(err)})
+		} else if !sameDefinition(cached, pattern) {
+			drift = append(drift, Drift{Key: key, Kind: persistence.OFFLINE_DEFINITION_PATTERN, Reason: changedReason(cached.LastUpdated, pattern.LastUpdated)})
+		}
+	}
+
+	for _, key := range sortedKeys(record.Services) {
+		var cached exchange.ServiceDefinition
+		if err := json.Unmarshal(record.Services[key], &cached); err != nil {
+			return nil, fmt.Errorf("unable to demarshal the offline definition of service %v, %v", key, err)
+		}
+
+		org, _ := splitKey(key)
+		sdef, _, err := getService(cached.URL, org, cached.Version, cached.Arch)
+		if exchange.IsRetryableError(err) || exchange.IsTransportError(nil, err) {
+			return nil, err
+		} else if err != nil || sdef == nil {
+			drift = append(drift, Drift{Key: key, Kind: persistence.OFFLINE_DEFINITION_SERVICE, Reason: notFoundReason(err)})
+		} else if !sameDefinition(cached, *sdef) {
+			drift = append(drift, Drift{Key: key, Kind: persistence.OFFLINE_DEFINITION_SERVICE, Reason: changedReason(cached.LastUpdated, sdef.LastUpdated)})
+		}
+	}
+
+	return drift, nil
+}
+
+func sortedKeys(defs map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(defs))
+	for key := range defs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// The definitions are the same when they serialize the same, so that the fields that the agent does not know about are
+// not compared.
+func sameDefinition(cached interface{}, current interface{}) bool {
+	a, errA := json.Marshal(cached)
+	b, errB := json.Marshal(current)
+	return errA == nil && errB == nil && bytes.Equal(a, b)
+}
+
+func notFoundReason(err error) string {
+	if err != nil {
+		return fmt.Sprintf("is not in the exchange: %v", err)
+	}
+	return "is not in the exchange"
+}
+
+func changedReason(cached string, current string) string {
+	return fmt.Sprintf("is different in the exchange, last updated %v instead of %v", current, cached)
+}
@@ -0,0 +1,204 @@
+package offline
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/eventlog"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/exchangesync"
+	"github.com/open-horizon/anax/i18n"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/worker"
+	"time"
+)
+
+// How often the worker checks whether the definitions that configured the node offline can be compared with the exchange.
+const RECONCILE_INTERVAL_S = 60
+
+// The worker that compares the offline definitions that configured the node with the ones of the exchange, once the
+// node can reach it, see DefinitionsDir. Each definition that differs is logged in the event log. They are compared
+// once, the record of the comparison is kept with the definitions. A node that was registered offline is first set up
+// with the exchange, as POST /node does when the exchange can be reached.
+type ReconcileWorker struct {
+	worker.BaseWorker // embedded field
+	db                *bolt.DB
+}
+
+func NewReconcileWorker(name string, cfg *config.HorizonConfig, db *bolt.DB) *ReconcileWorker {
+
+	var ec *worker.BaseExchangeContext
+	if dev, _ := persistence.FindExchangeDevice(db); dev != nil {
+		ec = worker.NewExchangeContext(fmt.Sprintf("%v/%v", dev.Org, dev.Id), dev.Token, cfg.Edge.ExchangeURL, cfg.GetCSSURL(), newLimitedRetryHTTPFactory(cfg.Collaborators.HTTPClientFactory))
+	}
+
+	w := &ReconcileWorker{
+		BaseWorker: worker.NewBaseWorker(name, cfg, ec),
+		db:         db,
+	}
+
+	glog.Info(reclog(fmt.Sprintf("Starting Offline Reconcile worker")))
+	w.Start(w, RECONCILE_INTERVAL_S)
+	return w
+}
+
+// The exchange is called from the NoWorkHandler, a transport error is only retried a few times so that the worker is
+// not held up while the exchange cannot be reached. The definitions are compared later then.
+func newLimitedRetryHTTPFactory(base *config.HTTPClientFactory) *config.HTTPClientFactory {
+	return &config.HTTPClientFactory{
+		NewHTTPClient: base.NewHTTPClient,
+		RetryCount:    2,
+		RetryInterval: 3,
+	}
+}
+
+func (w *ReconcileWorker) Messages() chan events.Message {
+	return w.BaseWorker.Manager.Messages
+}
+
+// Handle events that are propogated to this worker from the internal event bus.
+func (w *ReconcileWorker) NewEvent(incoming events.Message) {
+
+	switch incoming.(type) {
+	case *events.EdgeRegisteredExchangeMessage:
+		msg, _ := incoming.(*events.EdgeRegisteredExchangeMessage)
+		w.EC = worker.NewExchangeContext(fmt.Sprintf("%v/%v", msg.Org(), msg.DeviceId()), msg.Token(), w.Config.Edge.ExchangeURL, w.Config.GetCSSURL(), newLimitedRetryHTTPFactory(w.Config.Collaborators.HTTPClientFactory))
+
+	case *events.NodeTokenMessage:
+		msg, _ := incoming.(*events.NodeTokenMessage)
+		w.EC = worker.NewExchangeContext(fmt.Sprintf("%v/%v", msg.Org(), msg.DeviceId()), msg.Token(), w.Config.Edge.ExchangeURL, w.Config.GetCSSURL(), newLimitedRetryHTTPFactory(w.Config.Collaborators.HTTPClientFactory))
+
+	case *events.NodeShutdownCompleteMessage:
+		msg, _ := incoming.(*events.NodeShutdownCompleteMessage)
+		switch msg.Event().Id {
+		case events.UNCONFIGURE_COMPLETE:
+			w.Commands <- worker.NewTerminateCommand("shutdown")
+		}
+
+	default: //nothing
+
+	}
+
+	return
+}
+
+// There are no commands, the comparison runs from the NoWorkHandler.
+func (w *ReconcileWorker) CommandHandler(command worker.Command) bool {
+	return false
+}
+
+// Set up the node with the exchange when it was registered offline, and then compare the definitions with the exchange
+// when the node was configured offline and they were not compared yet.
+func (w *ReconcileWorker) NoWorkHandler() {
+	if w.GetExchangeToken() == "" {
+		return
+	}
+
+	if reg, err := persistence.FindOfflineRegistration(w.db); err != nil {
+		glog.Errorf(reclog(fmt.Sprintf("unable to read the offline registration, error %v", err)))
+		return
+	} else if reg != nil && reg.SetupTime == 0 {
+		if err := w.setupNode(reg); exchange.IsRetryableError(err) {
+			glog.V(3).Infof(reclog(fmt.Sprintf("the node cannot be set up with the exchange yet, %v", err)))
+			return
+		} else if err != nil {
+			glog.Errorf(reclog(fmt.Sprintf("unable to set up the node with the exchange, it is tried again later, %v", err)))
+			return
+		}
+	}
+
+	record, err := persistence.FindOfflineConfigstate(w.db)
+	if err != nil {
+		glog.Errorf(reclog(fmt.Sprintf("unable to read the offline configstate, error %v", err)))
+		return
+	} else if record == nil || record.ReconcileTime != 0 {
+		return
+	}
+
+	drift, err := Reconcile(record, exchange.GetHTTPExchangePatternHandler(w), exchange.GetHTTPServiceHandler(w))
+	if err != nil {
+		glog.V(3).Infof(reclog(fmt.Sprintf("the offline definitions cannot be compared with the exchange yet, %v", err)))
+		return
+	}
+
+	dev, err := persistence.FindExchangeDevice(w.db)
+	if err != nil || dev == nil {
+		glog.Errorf(reclog(fmt.Sprintf("unable to read the node, error %v", err)))
+		return
+	}
+
+	record.ReconcileTime = uint64(time.Now().Unix())
+	record.Drift = make([]string, 0, len(drift))
+	for _, d := range drift {
+		record.Drift = append(record.Drift, d.Key)
+		eventlog.LogNodeEvent(w.db, persistence.SEVERITY_WARN,
+			persistence.NewMessageMeta(EL_OFFLINE_DEFINITION_DRIFT, d.Kind, d.Key, record.Dir, d.Reason),
+			persistence.EC_OFFLINE_DEFINITIONS_DRIFT, dev.Id, dev.Org, dev.Pattern, dev.Config.State)
+	}
+	if err := persistence.SaveOfflineConfigstate(w.db, record); err != nil {
+		glog.Errorf(reclog(fmt.Sprintf("unable to save the offline configstate, error %v", err)))
+		return
+	}
+
+	eventlog.LogNodeEvent(w.db, persistence.SEVERITY_INFO,
+		persistence.NewMessageMeta(EL_OFFLINE_DEFINITIONS_RECONCILED, len(record.Patterns), len(record.Services), record.Dir, len(drift)),
+		persistence.EC_OFFLINE_DEFINITIONS_RECONCILED, dev.Id, dev.Org, dev.Pattern, dev.Config.State)
+}
+
+// Set up the node that was registered offline with the exchange, as POST /node does when the exchange can be reached:
+// the arch of the node is set in the exchange, and the node policy and user input are synced with it.
+func (w *ReconcileWorker) setupNode(reg *persistence.OfflineRegistration) error {
+	dev, err := persistence.FindExchangeDevice(w.db)
+	if err != nil || dev == nil {
+		return fmt.Errorf("unable to read the node, error %v", err)
+	}
+
+	arch := cutil.ArchString()
+	if err := exchange.GetHTTPPatchDeviceHandler(w)(w.GetExchangeId(), w.GetExchangeToken(), &exchange.PatchDeviceRequest{Arch: &arch}); err != nil {
+		return err
+	} else if err := exchangesync.NodeInitalSetup(w.db, exchange.GetHTTPDeviceHandler(w)); err != nil {
+		return err
+	} else if _, err := exchangesync.NodePolicyInitalSetup(w.db, w.Config, exchange.GetHTTPNodePolicyHandler(w), exchange.GetHTTPPutNodePolicyHandler(w)); err != nil {
+		return err
+	} else if err := exchangesync.NodeUserInputInitalSetup(w.db, exchange.GetHTTPPatchDeviceHandler(w)); err != nil {
+		return err
+	}
+
+	reg.SetupTime = uint64(time.Now().Unix())
+	if err := persistence.SaveOfflineRegistration(w.db, reg); err != nil {
+		return fmt.Errorf("unable to save the offline registration, error %v", err)
+	}
+
+	eventlog.LogNodeEvent(w.db, persistence.SEVERITY_INFO,
+		persistence.NewMessageMeta(EL_OFFLINE_NODE_SETUP, dev.Id, reg.Dir),
+		persistence.EC_NODE_CONFIG_OFFLINE, dev.Id, dev.Org, dev.Pattern, dev.Config.State)
+	return nil
+}
+
+// Utility logging function
+var reclog = func(v interface{}) string {
+	return fmt.Sprintf("Offline Reconcile Worker: %v", v)
+}
+
+// messages for eventlog
+const (
+	EL_OFFLINE_DEFINITION_DRIFT       = "The offline definition of %v %v in %v that configured the node %v."
+	EL_OFFLINE_DEFINITIONS_RECONCILED = "Compared the %v patterns and %v services of the offline definitions in %v that configured the node with the exchange, %v of them differ."
+	EL_OFFLINE_NODE_SETUP             = "Set up node %v, that was registered with the offline definitions in %v, with the exchange."
+)
+
+// This is does nothing useful at run time.
+// This code is only used at compile time to make the eventlog messages get into the catalog so that
+// they can be translated.
+// The event log messages will be saved in English. But the CLI can request them in different languages.
+func MarkI18nMessages() {
+	// get message printer. anax default language is English
+	msgPrinter := i18n.GetMessagePrinter()
+
+	msgPrinter.Sprintf(EL_OFFLINE_DEFINITION_DRIFT)
+	msgPrinter.Sprintf(EL_OFFLINE_DEFINITIONS_RECONCILED)
+	msgPrinter.Sprintf(EL_OFFLINE_NODE_SETUP)
+}
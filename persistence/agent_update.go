@@ -0,0 +1,69 @@
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// The bucket name in the bolt DB.
+const AGENT_UPDATE = "agent_update"
+
+// The states of an agent update.
+const AGENT_UPDATE_STATE_NONE = ""               // no update is available or it is only reported
+const AGENT_UPDATE_STATE_WAITING = "waiting"     // waiting for the maintenance window
+const AGENT_UPDATE_STATE_DRAINING = "draining"   // waiting for the agreements being made to finish
+const AGENT_UPDATE_STATE_UPDATING = "updating"   // the update command is running
+const AGENT_UPDATE_STATE_FAILED = "failed"       // the update command failed, it is run again later
+const AGENT_UPDATE_STATE_COMPLETED = "completed" // the update command succeeded but anax was not restarted
+
+// The outcome of the last check for a new version of the agent, and of the last update.
+type AgentUpdateStatus struct {
+	CurrentVersion  string `json:"current_version"`
+	LatestVersion   string `json:"latest_version,omitempty"`
+	LatestURL       string `json:"latest_url,omitempty"`
+	UpdateAvailable bool   `json:"update_available"`
+	LastCheckTime   uint64 `json:"last_check_time"`
+	CheckError      string `json:"check_error,omitempty"`
+	State           string `json:"state,omitempty"`
+	LastUpdateTime  uint64 `json:"last_update_time,omitempty"`
+	UpdateError     string `json:"update_error,omitempty"`
+}
+
+func (s AgentUpdateStatus) String() string {
+	return fmt.Sprintf("CurrentVersion: %v, LatestVersion: %v, LatestURL: %v, UpdateAvailable: %v, LastCheckTime: %v, CheckError: %v, State: %v, LastUpdateTime: %v, UpdateError: %v",
+		s.CurrentVersion, s.LatestVersion, s.LatestURL, s.UpdateAvailable, s.LastCheckTime, s.CheckError, s.State, s.LastUpdateTime, s.UpdateError)
+}
+
+// Retrieve the agent update status from the database, nil if the agent has not checked for updates.
+func FindAgentUpdateStatus(db *bolt.DB) (*AgentUpdateStatus, error) {
+	var status *AgentUpdateStatus
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(AGENT_UPDATE)); b != nil {
+			if v := b.Get([]byte(AGENT_UPDATE)); v != nil {
+				var s AgentUpdateStatus
+				if err := json.Unmarshal(v, &s); err != nil {
+					return fmt.Errorf("Unable to deserialize agent update status record: %v", v)
+				}
+				status = &s
+			}
+		}
+		return nil // end transaction
+	})
+
+	return status, readErr
+}
+
+// There is only 1 object in the bucket so we can use the bucket name as the object key.
+func SaveAgentUpdateStatus(db *bolt.DB, status *AgentUpdateStatus) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(AGENT_UPDATE)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(status); err != nil {
+			return fmt.Errorf("Failed to serialize agent update status: %v. Error: %v", status, err)
+		} else {
+			return b.Put([]byte(AGENT_UPDATE), serial)
+		}
+	})
+}
@@ -2,6 +2,8 @@ package persistence
 
 import (
 	"fmt"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/containermessage"
 )
 
 type HAAttributes struct {
@@ -77,6 +79,236 @@ func (a MeteringAttributes) GetServiceSpecs() *ServiceSpecs {
 	return a.ServiceSpecs
 }
 
+// The restart policy of a dependent service's containers, overriding the node's default restart policy. MaxRetries
+// only applies to the "on-failure" policy, zero means use the service's own retry count.
+type RestartPolicyAttributes struct {
+	Meta         *AttributeMeta `json:"meta"`
+	ServiceSpecs *ServiceSpecs  `json:"service_specs"`
+	Policy       string         `json:"policy"`
+	MaxRetries   uint           `json:"max_retries"`
+}
+
+func (a RestartPolicyAttributes) GetMeta() *AttributeMeta {
+	return a.Meta
+}
+
+func (a RestartPolicyAttributes) GetGenericMappings() map[string]interface{} {
+	return map[string]interface{}{
+		"policy":     a.Policy,
+		"maxRetries": a.MaxRetries,
+	}
+}
+
+func (a RestartPolicyAttributes) Update(other Attribute) error {
+	return fmt.Errorf("Update not implemented for type: %T", a)
+}
+
+func (a RestartPolicyAttributes) String() string {
+	if a.ServiceSpecs == nil {
+		return fmt.Sprintf("Meta: %v, ServiceSpecs: %v, Policy: %v, MaxRetries: %v", a.Meta, nil, a.Policy, a.MaxRetries)
+	} else {
+		return fmt.Sprintf("Meta: %v, ServiceSpecs: %v, Policy: %v, MaxRetries: %v", a.Meta, *(a.ServiceSpecs), a.Policy, a.MaxRetries)
+	}
+}
+
+func (a RestartPolicyAttributes) GetServiceSpecs() *ServiceSpecs {
+	if a.ServiceSpecs == nil {
+		a.ServiceSpecs = new(ServiceSpecs)
+	}
+	return a.ServiceSpecs
+}
+
+// The health check of a service's containers, overriding the health checks in the service's deployment config.
+type HealthCheckAttributes struct {
+	Meta         *AttributeMeta               `json:"meta"`
+	ServiceSpecs *ServiceSpecs                `json:"service_specs"`
+	HealthCheck  containermessage.HealthCheck `json:"health_check"`
+}
+
+func (a HealthCheckAttributes) GetMeta() *AttributeMeta {
+	return a.Meta
+}
+
+func (a HealthCheckAttributes) GetGenericMappings() map[string]interface{} {
+	return map[string]interface{}{
+		"exec":             a.HealthCheck.Exec,
+		"tcpPort":          a.HealthCheck.TCPPort,
+		"httpPort":         a.HealthCheck.HTTPPort,
+		"httpPath":         a.HealthCheck.HTTPPath,
+		"interval":         a.HealthCheck.IntervalS,
+		"timeout":          a.HealthCheck.TimeoutS,
+		"failureThreshold": a.HealthCheck.FailureThreshold,
+		"action":           a.HealthCheck.Action,
+	}
+}
+
+func (a HealthCheckAttributes) Update(other Attribute) error {
+	return fmt.Errorf("Update not implemented for type: %T", a)
+}
+
+func (a HealthCheckAttributes) String() string {
+	if a.ServiceSpecs == nil {
+		return fmt.Sprintf("Meta: %v, ServiceSpecs: %v, HealthCheck: %v", a.Meta, nil, a.HealthCheck)
+	} else {
+		return fmt.Sprintf("Meta: %v, ServiceSpecs: %v, HealthCheck: %v", a.Meta, *(a.ServiceSpecs), a.HealthCheck)
+	}
+}
+
+func (a HealthCheckAttributes) GetServiceSpecs() *ServiceSpecs {
+	if a.ServiceSpecs == nil {
+		a.ServiceSpecs = new(ServiceSpecs)
+	}
+	return a.ServiceSpecs
+}
+
+// The cpu pinning of a service's containers, overriding the cpu pinning in the service's deployment config.
+type CPUPinningAttributes struct {
+	Meta         *AttributeMeta              `json:"meta"`
+	ServiceSpecs *ServiceSpecs               `json:"service_specs"`
+	CPUPinning   containermessage.CPUPinning `json:"cpu_pinning"`
+}
+
+func (a CPUPinningAttributes) GetMeta() *AttributeMeta {
+	return a.Meta
+}
+
+func (a CPUPinningAttributes) GetGenericMappings() map[string]interface{} {
+	return map[string]interface{}{
+		"cpuset":             a.CPUPinning.CPUSet,
+		"cpuRealtimeRuntime": a.CPUPinning.CPURealtimeRuntime,
+	}
+}
+
+func (a CPUPinningAttributes) Update(other Attribute) error {
+	return fmt.Errorf("Update not implemented for type: %T", a)
+}
+
+func (a CPUPinningAttributes) String() string {
+	if a.ServiceSpecs == nil {
+		return fmt.Sprintf("Meta: %v, ServiceSpecs: %v, CPUPinning: %v", a.Meta, nil, a.CPUPinning)
+	} else {
+		return fmt.Sprintf("Meta: %v, ServiceSpecs: %v, CPUPinning: %v", a.Meta, *(a.ServiceSpecs), a.CPUPinning)
+	}
+}
+
+func (a CPUPinningAttributes) GetServiceSpecs() *ServiceSpecs {
+	if a.ServiceSpecs == nil {
+		a.ServiceSpecs = new(ServiceSpecs)
+	}
+	return a.ServiceSpecs
+}
+
+// The options of the docker network of a service's agreement or service instance, overriding the ones in the node's
+// network configuration. The fields that are not set keep the value of the node's network configuration.
+type NetworkAttributes struct {
+	Meta            *AttributeMeta `json:"meta"`
+	ServiceSpecs    *ServiceSpecs  `json:"service_specs"`
+	SubnetPool      string         `json:"subnet_pool,omitempty"`
+	SubnetPrefixLen int            `json:"subnet_prefix_len,omitempty"`
+	MTU             int            `json:"mtu,omitempty"`
+	ICC             *bool          `json:"icc,omitempty"`
+	IPv6            *bool          `json:"ipv6,omitempty"`
+}
+
+func (a NetworkAttributes) GetMeta() *AttributeMeta {
+	return a.Meta
+}
+
+func (a NetworkAttributes) GetGenericMappings() map[string]interface{} {
+	mappings := map[string]interface{}{}
+	if a.SubnetPool != "" {
+		mappings["subnetPool"] = a.SubnetPool
+	}
+	if a.SubnetPrefixLen != 0 {
+		mappings["subnetPrefixLen"] = a.SubnetPrefixLen
+	}
+	if a.MTU != 0 {
+		mappings["mtu"] = a.MTU
+	}
+	if a.ICC != nil {
+		mappings["icc"] = *a.ICC
+	}
+	if a.IPv6 != nil {
+		mappings["ipv6"] = *a.IPv6
+	}
+	return mappings
+}
+
+func (a NetworkAttributes) Update(other Attribute) error {
+	return fmt.Errorf("Update not implemented for type: %T", a)
+}
+
+func (a NetworkAttributes) String() string {
+	if a.ServiceSpecs == nil {
+		return fmt.Sprintf("Meta: %v, ServiceSpecs: %v, Mappings: %v", a.Meta, nil, a.GetGenericMappings())
+	} else {
+		return fmt.Sprintf("Meta: %v, ServiceSpecs: %v, Mappings: %v", a.Meta, *(a.ServiceSpecs), a.GetGenericMappings())
+	}
+}
+
+func (a NetworkAttributes) GetServiceSpecs() *ServiceSpecs {
+	if a.ServiceSpecs == nil {
+		a.ServiceSpecs = new(ServiceSpecs)
+	}
+	return a.ServiceSpecs
+}
+
+// Returns the node's network configuration with the options that the attribute sets replaced.
+func (a NetworkAttributes) Apply(netConfig config.NetworkConfig) config.NetworkConfig {
+	if a.SubnetPool != "" {
+		netConfig.SubnetPool = a.SubnetPool
+		netConfig.SubnetPrefixLen = a.SubnetPrefixLen
+	} else if a.SubnetPrefixLen != 0 {
+		netConfig.SubnetPrefixLen = a.SubnetPrefixLen
+	}
+	if a.MTU != 0 {
+		netConfig.MTU = a.MTU
+	}
+	if a.ICC != nil {
+		netConfig.DisableICC = !*a.ICC
+	}
+	if a.IPv6 != nil {
+		netConfig.EnableIPv6 = *a.IPv6
+		if !netConfig.EnableIPv6 {
+			netConfig.IPv6SubnetPool = ""
+		}
+	}
+	return netConfig
+}
+
+// Makes the service reconciliation create the missing services of the node's pattern that need no user input. It
+// applies to the node, not to a service.
+type AutoReconcileAttributes struct {
+	Meta          *AttributeMeta `json:"meta"`
+	ServiceSpecs  *ServiceSpecs  `json:"service_specs"`
+	AutoReconcile bool           `json:"auto_reconcile"`
+}
+
+func (a AutoReconcileAttributes) GetMeta() *AttributeMeta {
+	return a.Meta
+}
+
+func (a AutoReconcileAttributes) GetGenericMappings() map[string]interface{} {
+	return map[string]interface{}{
+		"auto_reconcile": a.AutoReconcile,
+	}
+}
+
+func (a AutoReconcileAttributes) Update(other Attribute) error {
+	return fmt.Errorf("Update not implemented for type: %T", a)
+}
+
+func (a AutoReconcileAttributes) String() string {
+	return fmt.Sprintf("Meta: %v, AutoReconcile: %v", a.Meta, a.AutoReconcile)
+}
+
+func (a AutoReconcileAttributes) GetServiceSpecs() *ServiceSpecs {
+	if a.ServiceSpecs == nil {
+		a.ServiceSpecs = new(ServiceSpecs)
+	}
+	return a.ServiceSpecs
+}
+
 type UserInputAttributes struct {
 	Meta         *AttributeMeta         `json:"meta"`
 	ServiceSpecs *ServiceSpecs          `json:"service_specs"`
@@ -238,7 +470,7 @@ func (a DockerRegistryAuthAttributes) GetGenericMappings() map[string]interface{
 
 	auths_show := make([]Auth, 0)
 	for _, au := range a.Auths {
-		auths_show = append(auths_show, Auth{UserName: au.UserName, Token: "********"})
+		auths_show = append(auths_show, Auth{Registry: au.Registry, UserName: au.UserName, Token: "********"})
 	}
 
 	return map[string]interface{}{
@@ -0,0 +1,53 @@
+// +build unit
+
+package persistence
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/open-horizon/anax/config"
+)
+
+// Verify that a network attribute only replaces the options it sets.
+func Test_NetworkAttributes_Apply(t *testing.T) {
+
+	node := config.NetworkConfig{SubnetPool: "172.30.0.0/16", SubnetPrefixLen: 24, MTU: 1500, EnableIPv6: true, IPv6SubnetPool: "fd00:6a78::/48"}
+
+	if netConfig := (NetworkAttributes{}).Apply(node); netConfig != node {
+		t.Errorf("an empty attribute should not change the network configuration, got %v", netConfig.String())
+	}
+
+	f := false
+	na := NetworkAttributes{SubnetPool: "172.31.0.0/16", MTU: 1400, ICC: &f, IPv6: &f}
+	if netConfig := na.Apply(node); netConfig.SubnetPool != "172.31.0.0/16" || netConfig.SubnetPrefixLen != 0 || netConfig.MTU != 1400 {
+		t.Errorf("the subnet pool and the MTU should be replaced, got %v", netConfig.String())
+	} else if !netConfig.DisableICC || netConfig.EnableIPv6 || netConfig.IPv6SubnetPool != "" {
+		t.Errorf("ICC and IPv6 should be turned off, got %v", netConfig.String())
+	} else if err := netConfig.Validate(); err != nil {
+		t.Errorf("the network configuration should be valid, error %v", err)
+	}
+
+	if netConfig := (NetworkAttributes{SubnetPrefixLen: 26}).Apply(node); netConfig.SubnetPool != node.SubnetPool || netConfig.SubnetPrefixLen != 26 {
+		t.Errorf("only the prefix length should be replaced, got %v", netConfig.String())
+	}
+}
+
+// Verify that a network attribute is read back from its serialized form.
+func Test_NetworkAttributes_Hydrate(t *testing.T) {
+
+	tr := true
+	na := NetworkAttributes{Meta: &AttributeMeta{Id: "net", Type: "NetworkAttributes"}, ServiceSpecs: new(ServiceSpecs), MTU: 1400, ICC: &tr}
+	serial, err := json.Marshal(na)
+	if err != nil {
+		t.Fatalf("unable to serialize %v, error %v", na, err)
+	}
+
+	if attr, err := HydrateConcreteAttribute(serial); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if hydrated, ok := attr.(NetworkAttributes); !ok {
+		t.Errorf("wrong attribute type %T", attr)
+	} else if hydrated.MTU != 1400 || hydrated.ICC == nil || !*hydrated.ICC || hydrated.IPv6 != nil {
+		t.Errorf("wrong attribute %v", hydrated)
+	}
+}
@@ -22,6 +22,9 @@ type AttributeMeta struct {
 	Label       string `json:"label"`       // for humans only, never computable
 	HostOnly    *bool  `json:"host_only"`   // determines whether or not the attribute will be published inside workload containers or exists only for Host use
 	Publishable *bool  `json:"publishable"` // means sent to exchange or otherwise published; whether or not an attr ends up in a workload depends on the value of HostOnly
+
+	// incremented each time the record is updated, for the ETag of the API
+	Revision uint64 `json:"revision,omitempty"`
 }
 
 func (a AttributeMeta) String() string {
@@ -33,7 +36,7 @@ func (a AttributeMeta) String() string {
 	if a.Publishable == nil || !*a.Publishable {
 		pub = "false"
 	}
-	return fmt.Sprintf("Id: %v, Type: %v, Label: %v, HostOnly: %v, Publishable: %v", a.Id, a.Type, a.Label, ho, pub)
+	return fmt.Sprintf("Id: %v, Type: %v, Label: %v, HostOnly: %v, Publishable: %v, Revision: %v", a.Id, a.Type, a.Label, ho, pub, a.Revision)
 }
 
 // Update *selectively* updates the content of this AttributeMeta (m) with non-empty values in the given meta.
@@ -207,6 +210,41 @@ func HydrateConcreteAttribute(v []byte) (Attribute, error) {
 		}
 		attr = ma
 
+	case "RestartPolicyAttributes":
+		var rpa RestartPolicyAttributes
+		if err := json.Unmarshal(v, &rpa); err != nil {
+			return nil, err
+		}
+		attr = rpa
+
+	case "HealthCheckAttributes":
+		var hca HealthCheckAttributes
+		if err := json.Unmarshal(v, &hca); err != nil {
+			return nil, err
+		}
+		attr = hca
+
+	case "CPUPinningAttributes":
+		var cpa CPUPinningAttributes
+		if err := json.Unmarshal(v, &cpa); err != nil {
+			return nil, err
+		}
+		attr = cpa
+
+	case "NetworkAttributes":
+		var na NetworkAttributes
+		if err := json.Unmarshal(v, &na); err != nil {
+			return nil, err
+		}
+		attr = na
+
+	case "AutoReconcileAttributes":
+		var ara AutoReconcileAttributes
+		if err := json.Unmarshal(v, &ara); err != nil {
+			return nil, err
+		}
+		attr = ara
+
 	case "AgreementProtocolAttributes":
 		var agp AgreementProtocolAttributes
 		if err := json.Unmarshal(v, &agp); err != nil {
@@ -245,7 +283,7 @@ func FindAttributeByKey(db *bolt.DB, id string) (*Attribute, error) {
 	var attr Attribute
 	var bucket *bolt.Bucket
 
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 		bucket = tx.Bucket([]byte(ATTRIBUTES))
 		if bucket != nil {
 
@@ -257,6 +295,8 @@ func FindAttributeByKey(db *bolt.DB, id string) (*Attribute, error) {
 					return err
 				} else if attr == nil {
 					return nil
+				} else if attr, err = decryptAttributeCredentials(db, attr); err != nil {
+					return err
 				}
 			}
 		}
@@ -285,7 +325,7 @@ func FindApplicableAttributes(db *bolt.DB, serviceUrl string, org string) ([]Att
 
 	filteredAttrs := []Attribute{}
 
-	return filteredAttrs, db.View(func(tx *bolt.Tx) error {
+	return filteredAttrs, timedView(db, func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(ATTRIBUTES))
 
 		if bucket == nil {
@@ -297,6 +337,9 @@ func FindApplicableAttributes(db *bolt.DB, serviceUrl string, org string) ([]Att
 			if err != nil {
 				return err
 			} else if attr != nil {
+				if attr, err = decryptAttributeCredentials(db, attr); err != nil {
+					return err
+				}
 				serviceSpecs := GetAttributeServiceSpecs(&attr)
 				if serviceSpecs == nil {
 					filteredAttrs = append(filteredAttrs, attr)
@@ -309,6 +352,95 @@ func FindApplicableAttributes(db *bolt.DB, serviceUrl string, org string) ([]Att
 	})
 }
 
+// Returns the attribute of the given type that applies to the given service, nil if there is none. An attribute that
+// names the service takes precedence over one that applies to all services.
+func findServiceAttribute(db *bolt.DB, serviceUrl string, org string, attrType string) (Attribute, error) {
+	attrs, err := FindApplicableAttributes(db, serviceUrl, org)
+	if err != nil {
+		return nil, err
+	}
+
+	var found Attribute
+	for _, attr := range attrs {
+		if attr.GetMeta().Type != attrType {
+			continue
+		}
+		if sps := GetAttributeServiceSpecs(&attr); sps != nil {
+			for _, sp := range *sps {
+				if sp.Url != "" {
+					return attr, nil
+				}
+			}
+		}
+		if found == nil {
+			found = attr
+		}
+	}
+	return found, nil
+}
+
+// Returns the restart policy attribute that applies to the given service, nil if there is none.
+func FindRestartPolicyAttribute(db *bolt.DB, serviceUrl string, org string) (*RestartPolicyAttributes, error) {
+	if attr, err := findServiceAttribute(db, serviceUrl, org, "RestartPolicyAttributes"); err != nil || attr == nil {
+		return nil, err
+	} else if rpa, ok := attr.(RestartPolicyAttributes); ok {
+		return &rpa, nil
+	}
+	return nil, nil
+}
+
+// Returns the health check attribute that applies to the given service, nil if there is none.
+func FindHealthCheckAttribute(db *bolt.DB, serviceUrl string, org string) (*HealthCheckAttributes, error) {
+	if attr, err := findServiceAttribute(db, serviceUrl, org, "HealthCheckAttributes"); err != nil || attr == nil {
+		return nil, err
+	} else if hca, ok := attr.(HealthCheckAttributes); ok {
+		return &hca, nil
+	}
+	return nil, nil
+}
+
+// Returns the cpu pinning attribute that applies to the given service, nil if there is none.
+func FindCPUPinningAttribute(db *bolt.DB, serviceUrl string, org string) (*CPUPinningAttributes, error) {
+	if attr, err := findServiceAttribute(db, serviceUrl, org, "CPUPinningAttributes"); err != nil || attr == nil {
+		return nil, err
+	} else if cpa, ok := attr.(CPUPinningAttributes); ok {
+		return &cpa, nil
+	}
+	return nil, nil
+}
+
+// Returns the network attribute that applies to the given service, nil if there is none.
+func FindNetworkAttribute(db *bolt.DB, serviceUrl string, org string) (*NetworkAttributes, error) {
+	if attr, err := findServiceAttribute(db, serviceUrl, org, "NetworkAttributes"); err != nil || attr == nil {
+		return nil, err
+	} else if na, ok := attr.(NetworkAttributes); ok {
+		return &na, nil
+	}
+	return nil, nil
+}
+
+// Returns true when the node has an AutoReconcileAttributes attribute that turns the creation of the missing services
+// of its pattern on.
+func FindAutoReconcile(db *bolt.DB) (bool, error) {
+	if attr, err := findServiceAttribute(db, "", "", "AutoReconcileAttributes"); err != nil || attr == nil {
+		return false, err
+	} else if ara, ok := attr.(AutoReconcileAttributes); ok {
+		return ara.AutoReconcile, nil
+	}
+	return false, nil
+}
+
+// Returns the restart policy of the given service and the maximum number of restarts that its restart policy allows,
+// zero if the policy does not set a limit. The defaultPolicy is used when no restart policy attribute applies.
+func GetServiceRestartPolicy(db *bolt.DB, serviceUrl string, org string, defaultPolicy string) (string, uint, error) {
+	if rpa, err := FindRestartPolicyAttribute(db, serviceUrl, org); err != nil {
+		return "", 0, err
+	} else if rpa != nil {
+		return rpa.Policy, rpa.MaxRetries, nil
+	}
+	return defaultPolicy, 0, nil
+}
+
 // This function is used to convert the persistent attributes for a service to an env var map.
 // This will include *all* values for which HostOnly is false, include those marked to not publish.
 func AttributesToEnvvarMap(attributes []Attribute, envvars map[string]string, prefix string, defaultRAM int64, nodePol *externalpolicy.ExternalPolicy, isCluster bool) (map[string]string, error) {
@@ -365,6 +497,13 @@ func AttributesToEnvvarMap(attributes []Attribute, envvars map[string]string, pr
 		writePrefix("HOST_IPS", strings.Join(ips, ","))
 	}
 
+	// Set the Host IPv6 addresses, omit interfaces that are down.
+	if ips, err := cutil.GetAllHostIPv6Addresses([]cutil.NetFilter{cutil.OmitDown}); err != nil {
+		return nil, fmt.Errorf("error obtaining host IPv6 addresses: %v", err)
+	} else {
+		writePrefix("HOST_IPV6S", strings.Join(ips, ","))
+	}
+
 	// TODO: consider extracting this type-processing out for generalization
 	for _, serv := range attributes {
 		meta := serv.GetMeta()
@@ -387,6 +526,21 @@ func AttributesToEnvvarMap(attributes []Attribute, envvars map[string]string, pr
 		case MeteringAttributes:
 			// Nothing to do
 
+		case RestartPolicyAttributes:
+			// Nothing to do
+
+		case HealthCheckAttributes:
+			// Nothing to do
+
+		case CPUPinningAttributes:
+			// Nothing to do
+
+		case NetworkAttributes:
+			// Nothing to do
+
+		case AutoReconcileAttributes:
+			// Nothing to do
+
 		case AgreementProtocolAttributes:
 			// Nothing to do
 
@@ -448,6 +602,7 @@ func (e ConflictingAttributeFound) Error() string { return e.msg }
 // N.B. It's the caller's responsibility to ensure the attr.ServiceSpecs are deduplicated; use the ServiceSpecs.AddServiceSpec() function to keep the slice clean
 func SaveOrUpdateAttribute(db *bolt.DB, attr Attribute, id string, permitPartialOverwrite bool) (*Attribute, error) {
 	var ret *Attribute
+	revision := uint64(1)
 
 	if id == "" {
 		// an empty id means this is a new record and we'll generate a unique id before saving
@@ -476,6 +631,7 @@ func SaveOrUpdateAttribute(db *bolt.DB, attr Attribute, id string, permitPartial
 		if *existing == nil {
 			return nil, &OverwriteCandidateNotFound{}
 		} else {
+			revision = (*existing).GetMeta().Revision + 1
 
 			if permitPartialOverwrite {
 				err := (*existing).Update(attr)
@@ -493,6 +649,7 @@ func SaveOrUpdateAttribute(db *bolt.DB, attr Attribute, id string, permitPartial
 
 	// make sure we set the id in-doc
 	(*ret).GetMeta().Id = id
+	(*ret).GetMeta().Revision = revision
 
 	// make sure nil-able fields are set to conservative defaults
 	pF := false
@@ -505,12 +662,18 @@ func SaveOrUpdateAttribute(db *bolt.DB, attr Attribute, id string, permitPartial
 		(*ret).GetMeta().Publishable = &pT
 	}
 
-	writeErr := db.Update(func(tx *bolt.Tx) error {
+	// credentials are stored encrypted, the caller gets them back in plain text
+	stored, err := encryptAttributeCredentials(db, *ret)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encrypt the credentials in attribute %v. Error: %v", id, err)
+	}
+
+	writeErr := timedUpdate(db, func(tx *bolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists([]byte(ATTRIBUTES))
 		if err != nil {
 			return err
 		}
-		serial, err := json.Marshal(ret)
+		serial, err := json.Marshal(stored)
 		if err != nil {
 			return fmt.Errorf("Failed to serialize attribute: %v. Error: %v", ret, err)
 		}
@@ -531,7 +694,7 @@ func DeleteAttribute(db *bolt.DB, id string) (*Attribute, error) {
 		return nil, nil
 	}
 
-	delError := db.Update(func(tx *bolt.Tx) error {
+	delError := timedUpdate(db, func(tx *bolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists([]byte(ATTRIBUTES))
 		if err != nil {
 			return err
@@ -0,0 +1,96 @@
+package persistence
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// The bucket name in the bolt DB.
+const AUDIT_LOG = "audit_log"
+
+// The outcomes of an audited request.
+const (
+	AUDIT_OUTCOME_SUCCESS = "success"
+	AUDIT_OUTCOME_FAILURE = "failure"
+)
+
+// A request of the agent API that changed, or tried to change, the node.
+type AuditEntry struct {
+	Timestamp uint64 `json:"timestamp"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Client    string `json:"client"`  // the address of the client, and the subject of its certificate when it sent one
+	Summary   string `json:"summary"` // the query and the top-level fields of the body, without the secrets
+	Status    int    `json:"status"`
+	Outcome   string `json:"outcome"`
+	Error     string `json:"error,omitempty"`
+	Input     string `json:"input,omitempty"` // the input that the error of a request with invalid input is about
+}
+
+func (a AuditEntry) String() string {
+	return fmt.Sprintf("Timestamp: %v, Method: %v, Path: %v, Client: %v, Summary: %v, Status: %v, Outcome: %v, Error: %v, Input: %v",
+		a.Timestamp, a.Method, a.Path, a.Client, a.Summary, a.Status, a.Outcome, a.Error, a.Input)
+}
+
+// Save an audit entry. The entries are keyed by a sequence number, in the order they are saved, and the oldest ones
+// are removed so that at most maxEntries are kept.
+func SaveAuditEntry(db *bolt.DB, entry *AuditEntry, maxEntries int) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(AUDIT_LOG))
+		if err != nil {
+			return err
+		} else if seq, err := b.NextSequence(); err != nil {
+			return fmt.Errorf("Unable to get the next key of the audit log, error %v", err)
+		} else if serial, err := json.Marshal(entry); err != nil {
+			return fmt.Errorf("Failed to serialize audit entry: %v. Error: %v", entry, err)
+		} else if err := b.Put(auditKey(seq), serial); err != nil {
+			return err
+		}
+
+		// The keys sort in the order of the sequence, so the oldest entries come first.
+		c := b.Cursor()
+		excess := -maxEntries
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			excess++
+		}
+		for k, _ := c.First(); k != nil && excess > 0; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			excess--
+		}
+		return nil
+	})
+}
+
+// Retrieve the audit entries, newest first. Only the entries at or after since, a unix timestamp, are returned when it
+// is not 0, and at most limit entries when it is not 0.
+func FindAuditEntries(db *bolt.DB, since uint64, limit int) ([]AuditEntry, error) {
+	entries := make([]AuditEntry, 0, 10)
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(AUDIT_LOG)); b != nil {
+			c := b.Cursor()
+			for k, v := c.Last(); k != nil && (limit == 0 || len(entries) < limit); k, v = c.Prev() {
+				var e AuditEntry
+				if err := json.Unmarshal(v, &e); err != nil {
+					return fmt.Errorf("Unable to deserialize audit entry record: %v", v)
+				} else if e.Timestamp >= since {
+					entries = append(entries, e)
+				}
+			}
+		}
+		return nil // end transaction
+	})
+
+	return entries, readErr
+}
+
+// The key of an audit entry, big endian so that the keys sort in the order of their sequence.
+func auditKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
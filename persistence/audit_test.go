@@ -0,0 +1,53 @@
+// +build unit
+
+package persistence
+
+import (
+	"testing"
+)
+
+func Test_AuditEntries(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	if entries, err := FindAuditEntries(db, 0, 0); err != nil {
+		t.Errorf("failed to read the audit log, error %v", err)
+	} else if len(entries) != 0 {
+		t.Errorf("the audit log should be empty, got %v", entries)
+	}
+
+	// 5 entries are saved with room for 3, the 2 oldest are removed.
+	for i := 1; i <= 5; i++ {
+		entry := &AuditEntry{Timestamp: uint64(1600000000 + i), Method: "PUT", Path: "/node/configstate", Status: 201, Outcome: AUDIT_OUTCOME_SUCCESS}
+		if i == 5 {
+			entry.Status, entry.Outcome, entry.Error = 400, AUDIT_OUTCOME_FAILURE, "the state is not valid"
+		}
+		if err := SaveAuditEntry(db, entry, 3); err != nil {
+			t.Errorf("failed to save audit entry %v, error %v", i, err)
+		}
+	}
+
+	if entries, err := FindAuditEntries(db, 0, 0); err != nil {
+		t.Errorf("failed to read the audit log, error %v", err)
+	} else if len(entries) != 3 {
+		t.Errorf("the audit log should have 3 entries, got %v", entries)
+	} else if entries[0].Timestamp != 1600000005 || entries[0].Error != "the state is not valid" || entries[2].Timestamp != 1600000003 {
+		t.Errorf("the audit log should have the 3 newest entries, newest first, got %v", entries)
+	}
+
+	if entries, err := FindAuditEntries(db, 1600000004, 0); err != nil {
+		t.Errorf("failed to read the audit log, error %v", err)
+	} else if len(entries) != 2 || entries[1].Timestamp != 1600000004 {
+		t.Errorf("the audit log should have 2 entries since 1600000004, got %v", entries)
+	}
+
+	if entries, err := FindAuditEntries(db, 0, 1); err != nil {
+		t.Errorf("failed to read the audit log, error %v", err)
+	} else if len(entries) != 1 || entries[0].Timestamp != 1600000005 {
+		t.Errorf("the audit log should have the newest entry, got %v", entries)
+	}
+}
@@ -0,0 +1,75 @@
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// The bucket name in the bolt DB.
+const CONFIGSTATE_ATTEMPT = "configstate_attempt"
+
+// The categories of the failures of a config state change.
+const (
+	CONFIGSTATE_FAILURE_INPUT          = "input"          // the request is not valid, e.g. a state that cannot be set
+	CONFIGSTATE_FAILURE_NOT_FOUND      = "not_found"      // the node, its pattern or one of its services was not found
+	CONFIGSTATE_FAILURE_SERVICE_CONFIG = "service_config" // some of the services of the pattern cannot be configured
+	CONFIGSTATE_FAILURE_UNAVAILABLE    = "unavailable"    // the node cannot be configured for now, e.g. its disk is full
+	CONFIGSTATE_FAILURE_SYSTEM         = "system"         // any other error, e.g. the database or the exchange failed
+)
+
+// The last change of the config state that failed, kept until the config state is changed successfully, so that the
+// reason can be seen after the response of the request is gone.
+type ConfigstateAttempt struct {
+	Timestamp      uint64 `json:"timestamp"`
+	RequestedState string `json:"requested_state"`
+	Category       string `json:"category"`
+	Message        string `json:"message"`
+}
+
+func (c ConfigstateAttempt) String() string {
+	return fmt.Sprintf("Timestamp: %v, RequestedState: %v, Category: %v, Message: %v", c.Timestamp, c.RequestedState, c.Category, c.Message)
+}
+
+// Retrieve the last failed config state change from the database, nil if there is none.
+func FindConfigstateAttempt(db *bolt.DB) (*ConfigstateAttempt, error) {
+	var attempt *ConfigstateAttempt
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(CONFIGSTATE_ATTEMPT)); b != nil {
+			if v := b.Get([]byte(CONFIGSTATE_ATTEMPT)); v != nil {
+				var ca ConfigstateAttempt
+				if err := json.Unmarshal(v, &ca); err != nil {
+					return fmt.Errorf("Unable to deserialize configstate attempt record: %v", v)
+				}
+				attempt = &ca
+			}
+		}
+		return nil // end transaction
+	})
+
+	return attempt, readErr
+}
+
+// There is only 1 object in the bucket so we can use the bucket name as the object key.
+func SaveConfigstateAttempt(db *bolt.DB, attempt *ConfigstateAttempt) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(CONFIGSTATE_ATTEMPT)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(attempt); err != nil {
+			return fmt.Errorf("Failed to serialize configstate attempt: %v. Error: %v", attempt, err)
+		} else {
+			return b.Put([]byte(CONFIGSTATE_ATTEMPT), serial)
+		}
+	})
+}
+
+// Remove the last failed config state change from the database, once the config state was changed successfully.
+func DeleteConfigstateAttempt(db *bolt.DB) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(CONFIGSTATE_ATTEMPT)); b != nil {
+			return b.Delete([]byte(CONFIGSTATE_ATTEMPT))
+		}
+		return nil
+	})
+}
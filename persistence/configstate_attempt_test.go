@@ -0,0 +1,41 @@
+// +build unit
+
+package persistence
+
+import (
+	"testing"
+)
+
+func Test_ConfigstateAttempt(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	if attempt, err := FindConfigstateAttempt(db); err != nil {
+		t.Errorf("failed to read the configstate attempt, error %v", err)
+	} else if attempt != nil {
+		t.Errorf("there should be no configstate attempt, got %v", attempt)
+	}
+
+	saved := &ConfigstateAttempt{Timestamp: 1600000000, RequestedState: CONFIGSTATE_CONFIGURED, Category: CONFIGSTATE_FAILURE_SERVICE_CONFIG, Message: "service myorg/myservice cannot be configured"}
+	if err := SaveConfigstateAttempt(db, saved); err != nil {
+		t.Errorf("failed to save the configstate attempt, error %v", err)
+	}
+
+	if attempt, err := FindConfigstateAttempt(db); err != nil {
+		t.Errorf("failed to read the configstate attempt, error %v", err)
+	} else if attempt == nil || *attempt != *saved {
+		t.Errorf("the configstate attempt should be %v, got %v", saved, attempt)
+	}
+
+	if err := DeleteConfigstateAttempt(db); err != nil {
+		t.Errorf("failed to delete the configstate attempt, error %v", err)
+	} else if attempt, err := FindConfigstateAttempt(db); err != nil {
+		t.Errorf("failed to read the configstate attempt, error %v", err)
+	} else if attempt != nil {
+		t.Errorf("the configstate attempt should be deleted, got %v", attempt)
+	}
+}
@@ -0,0 +1,71 @@
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/policy"
+)
+
+// The bucket name in the bolt DB.
+const CONFIGSTATE_RESOLUTION = "configstate_resolution"
+
+// The services that the autoconfig resolved from the node's pattern the last time the node was changed to configured,
+// and the version of the pattern they were resolved from, so that it can be seen later why a service is on the node.
+// It is kept until the node is changed back to configuring.
+type ConfigstateResolution struct {
+	Timestamp          uint64             `json:"timestamp"`
+	Pattern            string             `json:"pattern"`                        // org/name of the pattern
+	PatternLabel       string             `json:"pattern_label,omitempty"`        // the label of the pattern in the exchange
+	PatternLastUpdated string             `json:"pattern_last_updated,omitempty"` // the lastUpdated of the pattern in the exchange
+	FromManifest       bool               `json:"from_manifest,omitempty"`        // the pattern is the one of the autoconfig manifest
+	APISpecs           policy.APISpecList `json:"api_specs"`                      // the services that were registered
+	Excluded           ServiceSpecs       `json:"excluded_services,omitempty"`    // the services that the autoconfig skipped
+}
+
+func (c ConfigstateResolution) String() string {
+	return fmt.Sprintf("Timestamp: %v, Pattern: %v, PatternLabel: %v, PatternLastUpdated: %v, FromManifest: %v, APISpecs: %v, Excluded: %v", c.Timestamp, c.Pattern, c.PatternLabel, c.PatternLastUpdated, c.FromManifest, c.APISpecs, c.Excluded)
+}
+
+// Retrieve the last resolution of the node's pattern from the database, nil if there is none.
+func FindConfigstateResolution(db *bolt.DB) (*ConfigstateResolution, error) {
+	var resolution *ConfigstateResolution
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(CONFIGSTATE_RESOLUTION)); b != nil {
+			if v := b.Get([]byte(CONFIGSTATE_RESOLUTION)); v != nil {
+				var cr ConfigstateResolution
+				if err := json.Unmarshal(v, &cr); err != nil {
+					return fmt.Errorf("Unable to deserialize configstate resolution record: %v", v)
+				}
+				resolution = &cr
+			}
+		}
+		return nil // end transaction
+	})
+
+	return resolution, readErr
+}
+
+// There is only 1 object in the bucket so we can use the bucket name as the object key.
+func SaveConfigstateResolution(db *bolt.DB, resolution *ConfigstateResolution) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(CONFIGSTATE_RESOLUTION)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(resolution); err != nil {
+			return fmt.Errorf("Failed to serialize configstate resolution: %v. Error: %v", resolution, err)
+		} else {
+			return b.Put([]byte(CONFIGSTATE_RESOLUTION), serial)
+		}
+	})
+}
+
+// Remove the resolution of the node's pattern from the database, once the services it registered are removed.
+func DeleteConfigstateResolution(db *bolt.DB) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(CONFIGSTATE_RESOLUTION)); b != nil {
+			return b.Delete([]byte(CONFIGSTATE_RESOLUTION))
+		}
+		return nil
+	})
+}
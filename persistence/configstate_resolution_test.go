@@ -0,0 +1,50 @@
+// +build unit
+
+package persistence
+
+import (
+	"github.com/open-horizon/anax/policy"
+	"reflect"
+	"testing"
+)
+
+func Test_ConfigstateResolution(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	if resolution, err := FindConfigstateResolution(db); err != nil {
+		t.Errorf("failed to read the configstate resolution, error %v", err)
+	} else if resolution != nil {
+		t.Errorf("there should be no configstate resolution, got %v", resolution)
+	}
+
+	saved := &ConfigstateResolution{
+		Timestamp:          1600000000,
+		Pattern:            "myorg/mypattern",
+		PatternLabel:       "my pattern",
+		PatternLastUpdated: "2020-09-13T12:26:40.000Z[UTC]",
+		APISpecs:           policy.APISpecList{*policy.APISpecification_Factory("myservice", "myorg", "[1.0.0,2.0.0)", "amd64")},
+		Excluded:           ServiceSpecs{ServiceSpec{Url: "gps", Org: "myorg"}},
+	}
+	if err := SaveConfigstateResolution(db, saved); err != nil {
+		t.Errorf("failed to save the configstate resolution, error %v", err)
+	}
+
+	if resolution, err := FindConfigstateResolution(db); err != nil {
+		t.Errorf("failed to read the configstate resolution, error %v", err)
+	} else if !reflect.DeepEqual(resolution, saved) {
+		t.Errorf("the configstate resolution should be %v, got %v", saved, resolution)
+	}
+
+	if err := DeleteConfigstateResolution(db); err != nil {
+		t.Errorf("failed to delete the configstate resolution, error %v", err)
+	} else if resolution, err := FindConfigstateResolution(db); err != nil {
+		t.Errorf("failed to read the configstate resolution, error %v", err)
+	} else if resolution != nil {
+		t.Errorf("the configstate resolution should be deleted, got %v", resolution)
+	}
+}
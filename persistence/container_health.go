@@ -0,0 +1,122 @@
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"time"
+)
+
+// container health table name
+const CONTAINER_HEALTH = "container_health"
+
+const (
+	CONTAINER_HEALTHY   = "healthy"
+	CONTAINER_UNHEALTHY = "unhealthy"
+)
+
+// The health of a service container that has a health check, keyed by the container name.
+type ContainerHealth struct {
+	ContainerName       string `json:"container_name"`
+	Owner               string `json:"owner"`        // The agreement id or service instance key that the container runs for.
+	ServiceName         string `json:"service_name"` // The name of the service in the deployment config.
+	Status              string `json:"status"`       // healthy or unhealthy
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	LastFailure         string `json:"last_failure"`     // The reason the most recent failed check failed.
+	LastChangeTime      uint64 `json:"last_change_time"` // The time the status last changed.
+}
+
+func NewContainerHealth(containerName string, owner string, serviceName string) *ContainerHealth {
+	return &ContainerHealth{
+		ContainerName:  containerName,
+		Owner:          owner,
+		ServiceName:    serviceName,
+		Status:         CONTAINER_HEALTHY,
+		LastChangeTime: uint64(time.Now().Unix()),
+	}
+}
+
+func (w ContainerHealth) String() string {
+	return fmt.Sprintf("ContainerName: %v, "+
+		"Owner: %v, "+
+		"ServiceName: %v, "+
+		"Status: %v, "+
+		"ConsecutiveFailures: %v, "+
+		"LastFailure: %v, "+
+		"LastChangeTime: %v",
+		w.ContainerName, w.Owner, w.ServiceName, w.Status, w.ConsecutiveFailures, w.LastFailure, w.LastChangeTime)
+}
+
+// save the ContainerHealth record into db.
+func SaveContainerHealth(db *bolt.DB, health *ContainerHealth) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if bucket, err := tx.CreateBucketIfNotExists([]byte(CONTAINER_HEALTH)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(*health); err != nil {
+			return fmt.Errorf("Failed to serialize the container health object: %v. Error: %v", *health, err)
+		} else {
+			return bucket.Put([]byte(health.ContainerName), serial)
+		}
+	})
+}
+
+// delete the ContainerHealth record of the given container from the db.
+func DeleteContainerHealth(db *bolt.DB, containerName string) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(CONTAINER_HEALTH)); b != nil {
+			return b.Delete([]byte(containerName))
+		}
+		return nil
+	})
+}
+
+// find the container health record of the given container, nil if there is none.
+func FindContainerHealthWithName(db *bolt.DB, containerName string) (*ContainerHealth, error) {
+	var health *ContainerHealth
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(CONTAINER_HEALTH)); b != nil {
+			if v := b.Get([]byte(containerName)); v != nil {
+				var ch ContainerHealth
+				if err := json.Unmarshal(v, &ch); err != nil {
+					return fmt.Errorf("Unable to deserialize ContainerHealth db record: %v. Error: %v", v, err)
+				}
+				health = &ch
+			}
+		}
+		return nil
+	})
+
+	return health, readErr
+}
+
+// find all the container health records in the db.
+func FindContainerHealth(db *bolt.DB) ([]ContainerHealth, error) {
+	chs := make([]ContainerHealth, 0)
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+
+		if b := tx.Bucket([]byte(CONTAINER_HEALTH)); b != nil {
+			b.ForEach(func(k, v []byte) error {
+
+				var ch ContainerHealth
+
+				if err := json.Unmarshal(v, &ch); err != nil {
+					glog.Errorf("Unable to deserialize ContainerHealth db record: %v. Error: %v", v, err)
+				} else {
+					chs = append(chs, ch)
+				}
+				return nil
+			})
+		}
+
+		return nil // end the transaction
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	} else {
+		return chs, nil
+	}
+}
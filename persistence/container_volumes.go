@@ -41,7 +41,7 @@ func (w ContainerVolume) ShortString() string {
 
 // save the ContainerVolume record into db.
 func SaveContainerVolume(db *bolt.DB, container_volume *ContainerVolume) error {
-	writeErr := db.Update(func(tx *bolt.Tx) error {
+	writeErr := timedUpdate(db, func(tx *bolt.Tx) error {
 		if bucket, err := tx.CreateBucketIfNotExists([]byte(CONTAINER_VOLUMES)); err != nil {
 			return err
 		} else {
@@ -108,7 +108,7 @@ func FindContainerVolumes(db *bolt.DB, filters []ContainerVolumeFilter) ([]Conta
 	cvs := make([]ContainerVolume, 0)
 
 	// fetch container volumes
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 
 		if b := tx.Bucket([]byte(CONTAINER_VOLUMES)); b != nil {
 			b.ForEach(func(k, v []byte) error {
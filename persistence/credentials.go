@@ -0,0 +1,355 @@
+package persistence
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// The file, in the same directory as the local database, that holds the key used to encrypt the credentials
+// stored in the database.
+const CREDENTIAL_KEY_FILE = "credential.key"
+
+// The file that holds the credential key sealed by the node's TPM, it replaces the plain key file.
+const SEALED_CREDENTIAL_KEY_FILE = "credential.key.sealed"
+
+// Encrypted credential values are stored with this prefix so that they can be told apart from the values
+// that were stored in plain text by older versions of anax.
+const ENCRYPTED_CREDENTIAL_PREFIX = "enc:"
+
+const credentialKeySize = 32
+
+// The credential keys, cached by database file.
+var credentialKeys = make(map[string][]byte)
+var credentialKeysLock sync.Mutex
+
+// Seals the credential key so that it can only be unsealed on this node, e.g. by the node's TPM.
+type KeySealer interface {
+	GenerateKey(size int) ([]byte, error)
+	Seal(data []byte) ([]byte, error)
+	Unseal(sealed []byte) ([]byte, error)
+}
+
+var credentialKeySealer KeySealer
+
+// Seal the credential key with the given sealer. It must be set before the database is used.
+func SetCredentialKeySealer(sealer KeySealer) {
+	credentialKeysLock.Lock()
+	defer credentialKeysLock.Unlock()
+	credentialKeySealer = sealer
+}
+
+// Returns true if the credential key of the database is sealed, so the secrets can only be read on this node.
+func CredentialKeySealed(db *bolt.DB) bool {
+	_, err := os.Stat(filepath.Join(filepath.Dir(db.Path()), SEALED_CREDENTIAL_KEY_FILE))
+	return err == nil
+}
+
+// Verify that the credential key of the database can be used, a sealed key must unseal.
+func CheckCredentialKey(db *bolt.DB) error {
+	_, err := credentialKey(db)
+	return err
+}
+
+// Returns the credential key for the given database, creating it the first time it is needed.
+func credentialKey(db *bolt.DB) ([]byte, error) {
+	credentialKeysLock.Lock()
+	defer credentialKeysLock.Unlock()
+
+	if key, ok := credentialKeys[db.Path()]; ok {
+		return key, nil
+	}
+
+	keyFile := filepath.Join(filepath.Dir(db.Path()), CREDENTIAL_KEY_FILE)
+	sealedKeyFile := filepath.Join(filepath.Dir(db.Path()), SEALED_CREDENTIAL_KEY_FILE)
+	if credentialKeySealer != nil {
+		key, err := sealedCredentialKey(keyFile, sealedKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		credentialKeys[db.Path()] = key
+		return key, nil
+	} else if _, err := os.Stat(sealedKeyFile); err == nil {
+		// Creating a new key would make the secrets in the database unreadable.
+		return nil, fmt.Errorf("credential key file %v is sealed by a TPM, but the TPM is not configured", sealedKeyFile)
+	}
+
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read credential key file %v, error %v", keyFile, err)
+	} else if os.IsNotExist(err) {
+		key = make([]byte, credentialKeySize)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, fmt.Errorf("unable to generate credential key, error %v", err)
+		} else if err := ioutil.WriteFile(keyFile, key, 0600); err != nil {
+			return nil, fmt.Errorf("unable to write credential key file %v, error %v", keyFile, err)
+		}
+		glog.V(3).Infof("Created credential key file %v", keyFile)
+	} else if len(key) != credentialKeySize {
+		return nil, fmt.Errorf("credential key file %v is corrupted, expected %v bytes but found %v", keyFile, credentialKeySize, len(key))
+	}
+
+	credentialKeys[db.Path()] = key
+	return key, nil
+}
+
+// Returns the credential key unsealed from the sealed key file. The first time, the plain key file of a node that
+// did not use a TPM before is sealed, or a new key is generated and sealed, and only the sealed key file is kept.
+func sealedCredentialKey(keyFile string, sealedKeyFile string) ([]byte, error) {
+	sealed, err := ioutil.ReadFile(sealedKeyFile)
+	if err == nil {
+		key, err := credentialKeySealer.Unseal(sealed)
+		if err != nil {
+			return nil, fmt.Errorf("unable to unseal credential key file %v, error %v", sealedKeyFile, err)
+		} else if len(key) != credentialKeySize {
+			return nil, fmt.Errorf("sealed credential key file %v is corrupted, expected %v bytes but found %v", sealedKeyFile, credentialKeySize, len(key))
+		}
+		return key, nil
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read sealed credential key file %v, error %v", sealedKeyFile, err)
+	}
+
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read credential key file %v, error %v", keyFile, err)
+	} else if os.IsNotExist(err) {
+		if key, err = credentialKeySealer.GenerateKey(credentialKeySize); err != nil {
+			return nil, fmt.Errorf("unable to generate credential key, error %v", err)
+		}
+	} else if len(key) != credentialKeySize {
+		return nil, fmt.Errorf("credential key file %v is corrupted, expected %v bytes but found %v", keyFile, credentialKeySize, len(key))
+	}
+
+	if sealed, err = credentialKeySealer.Seal(key); err != nil {
+		return nil, fmt.Errorf("unable to seal credential key, error %v", err)
+	} else if err := ioutil.WriteFile(sealedKeyFile, sealed, 0600); err != nil {
+		return nil, fmt.Errorf("unable to write sealed credential key file %v, error %v", sealedKeyFile, err)
+	} else if err := os.Remove(keyFile); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to remove credential key file %v after sealing it, error %v", keyFile, err)
+	}
+	glog.V(3).Infof("Created sealed credential key file %v", sealedKeyFile)
+	return key, nil
+}
+
+// The node's exchange token is encrypted in the database when the credential key is sealed, so that the token can
+// only be used on this node. Otherwise it is stored as before.
+func encryptDeviceToken(db *bolt.DB, token string) (string, error) {
+	credentialKeysLock.Lock()
+	sealed := credentialKeySealer != nil
+	credentialKeysLock.Unlock()
+
+	if token == "" || !sealed || strings.HasPrefix(token, ENCRYPTED_CREDENTIAL_PREFIX) {
+		return token, nil
+	}
+	key, err := credentialKey(db)
+	if err != nil {
+		return "", err
+	}
+	return encryptCredential(key, token)
+}
+
+func decryptDeviceToken(db *bolt.DB, token string) (string, error) {
+	if !strings.HasPrefix(token, ENCRYPTED_CREDENTIAL_PREFIX) {
+		return token, nil
+	}
+	key, err := credentialKey(db)
+	if err != nil {
+		return "", err
+	}
+	return decryptCredential(key, token)
+}
+
+// Encrypt the credential with AES-GCM. The result is the prefixed base64 encoding of the nonce followed by the
+// cipher text.
+func encryptCredential(key []byte, plain string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return ENCRYPTED_CREDENTIAL_PREFIX + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt a credential produced by encryptCredential. Values without the encryption prefix were stored in plain
+// text and are returned as is.
+func decryptCredential(key []byte, value string) (string, error) {
+	if !strings.HasPrefix(value, ENCRYPTED_CREDENTIAL_PREFIX) {
+		return value, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, ENCRYPTED_CREDENTIAL_PREFIX))
+	if err != nil {
+		return "", fmt.Errorf("unable to decode credential, error %v", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("unable to decrypt credential, the value is too short")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt credential, error %v", err)
+	}
+	return string(plain), nil
+}
+
+// Apply the given function to the credentials in the attribute. Returns a copy of the attribute with the
+// converted credentials, the given attribute is not modified. Attributes without credentials are returned as is.
+func convertAttributeCredentials(attr Attribute, convert func(string) (string, error)) (Attribute, error) {
+
+	convertAuths := func(auths []Auth) ([]Auth, error) {
+		converted := make([]Auth, 0, len(auths))
+		for _, auth := range auths {
+			token, err := convert(auth.Token)
+			if err != nil {
+				return nil, fmt.Errorf("registry %v: %v", auth.Registry, err)
+			}
+			converted = append(converted, Auth{Registry: auth.Registry, UserName: auth.UserName, Token: token})
+		}
+		return converted, nil
+	}
+
+	switch a := attr.(type) {
+	case DockerRegistryAuthAttributes:
+		auths, err := convertAuths(a.Auths)
+		if err != nil {
+			return nil, err
+		}
+		return DockerRegistryAuthAttributes{Meta: a.Meta, Auths: auths}, nil
+	case *DockerRegistryAuthAttributes:
+		auths, err := convertAuths(a.Auths)
+		if err != nil {
+			return nil, err
+		}
+		return &DockerRegistryAuthAttributes{Meta: a.Meta, Auths: auths}, nil
+	default:
+		return attr, nil
+	}
+}
+
+// Returns a copy of the attribute with its credentials encrypted, for storing in the database.
+func encryptAttributeCredentials(db *bolt.DB, attr Attribute) (Attribute, error) {
+	if !hasCredentials(attr) {
+		return attr, nil
+	}
+
+	key, err := credentialKey(db)
+	if err != nil {
+		return nil, err
+	}
+	return convertAttributeCredentials(attr, func(value string) (string, error) {
+		return encryptCredential(key, value)
+	})
+}
+
+// Returns a copy of the attribute read from the database with its credentials decrypted.
+func decryptAttributeCredentials(db *bolt.DB, attr Attribute) (Attribute, error) {
+	if !hasCredentials(attr) {
+		return attr, nil
+	}
+
+	key, err := credentialKey(db)
+	if err != nil {
+		return nil, err
+	}
+	return convertAttributeCredentials(attr, func(value string) (string, error) {
+		return decryptCredential(key, value)
+	})
+}
+
+func hasCredentials(attr Attribute) bool {
+	switch attr.(type) {
+	case DockerRegistryAuthAttributes, *DockerRegistryAuthAttributes:
+		return true
+	default:
+		return false
+	}
+}
+
+// Encrypt the credentials that older versions of anax stored in plain text.
+func MigrateAttributeCredentials(db *bolt.DB) error {
+	if db == nil {
+		return nil
+	}
+
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(ATTRIBUTES))
+		if bucket == nil {
+			return nil
+		}
+
+		migrated := make(map[string][]byte)
+		err := bucket.ForEach(func(k, v []byte) error {
+			attr, err := HydrateConcreteAttribute(v)
+			if err != nil || attr == nil || !hasCredentials(attr) {
+				return err
+			}
+
+			key, err := credentialKey(db)
+			if err != nil {
+				return err
+			}
+
+			plainText := false
+			encAttr, err := convertAttributeCredentials(attr, func(value string) (string, error) {
+				if strings.HasPrefix(value, ENCRYPTED_CREDENTIAL_PREFIX) {
+					return value, nil
+				}
+				plainText = true
+				return encryptCredential(key, value)
+			})
+			if err != nil {
+				return err
+			} else if !plainText {
+				return nil
+			}
+
+			if serial, err := json.Marshal(encAttr); err != nil {
+				return fmt.Errorf("Failed to serialize attribute %v. Error: %v", attr.GetMeta().Id, err)
+			} else {
+				migrated[string(k)] = serial
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for k, v := range migrated {
+			glog.V(3).Infof("Encrypting the stored credentials of attribute %v", k)
+			if err := bucket.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,133 @@
+// +build unit
+
+package persistence
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_encryptCredential(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	enc, err := encryptCredential(key, "myToken")
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if !strings.HasPrefix(enc, ENCRYPTED_CREDENTIAL_PREFIX) || strings.Contains(enc, "myToken") {
+		t.Errorf("credential was not encrypted: %v", enc)
+	}
+
+	if plain, err := decryptCredential(key, enc); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if plain != "myToken" {
+		t.Errorf("expected myToken, got %v", plain)
+	}
+
+	// values stored in plain text by older versions are returned as is
+	if plain, err := decryptCredential(key, "oldToken"); err != nil || plain != "oldToken" {
+		t.Errorf("expected oldToken, got %v, error %v", plain, err)
+	}
+
+	if _, err := decryptCredential([]byte("fedcba9876543210fedcba9876543210"), enc); err == nil {
+		t.Errorf("expected an error decrypting with the wrong key")
+	}
+}
+
+func Test_convertAttributeCredentials(t *testing.T) {
+	attr := DockerRegistryAuthAttributes{
+		Meta:  &AttributeMeta{Type: "DockerRegistryAuthAttributes"},
+		Auths: []Auth{{Registry: "myrepo", UserName: "user1", Token: "token1"}},
+	}
+
+	upper := func(s string) (string, error) { return strings.ToUpper(s), nil }
+
+	if converted, err := convertAttributeCredentials(attr, upper); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if c := converted.(DockerRegistryAuthAttributes); c.Auths[0].Token != "TOKEN1" || c.Auths[0].Registry != "myrepo" || c.Auths[0].UserName != "user1" {
+		t.Errorf("unexpected conversion %v", c.Auths)
+	} else if attr.Auths[0].Token != "token1" {
+		t.Errorf("the given attribute was modified")
+	}
+
+	if converted, err := convertAttributeCredentials(&attr, upper); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if c := converted.(*DockerRegistryAuthAttributes); c.Auths[0].Token != "TOKEN1" {
+		t.Errorf("unexpected conversion %v", c.Auths)
+	}
+
+	ha := HAAttributes{Meta: &AttributeMeta{Type: "HAAttributes"}, Partners: []string{"p1"}}
+	if converted, err := convertAttributeCredentials(ha, upper); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if converted.(HAAttributes).Partners[0] != "p1" {
+		t.Errorf("attribute without credentials was changed")
+	}
+}
+
+// A sealer that only unseals what it sealed itself.
+type testSealer struct {
+	id byte
+}
+
+func (s testSealer) GenerateKey(size int) ([]byte, error) {
+	return bytes.Repeat([]byte{'k'}, size), nil
+}
+
+func (s testSealer) Seal(data []byte) ([]byte, error) {
+	return append([]byte{s.id}, data...), nil
+}
+
+func (s testSealer) Unseal(sealed []byte) ([]byte, error) {
+	if len(sealed) == 0 || sealed[0] != s.id {
+		return nil, errors.New("sealed by another TPM")
+	}
+	return sealed[1:], nil
+}
+
+func Test_sealedCredentialKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, CREDENTIAL_KEY_FILE)
+	sealedKeyFile := filepath.Join(dir, SEALED_CREDENTIAL_KEY_FILE)
+	plainKey := []byte("0123456789abcdef0123456789abcdef")
+	if err := ioutil.WriteFile(keyFile, plainKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	defer SetCredentialKeySealer(nil)
+	SetCredentialKeySealer(testSealer{id: 1})
+
+	// the key of a node that did not use a TPM before is kept, sealed
+	if key, err := sealedCredentialKey(keyFile, sealedKeyFile); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if !bytes.Equal(key, plainKey) {
+		t.Errorf("expected the existing key, got %v", key)
+	}
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Errorf("expected the plain key file to be removed, got %v", err)
+	}
+
+	if key, err := sealedCredentialKey(keyFile, sealedKeyFile); err != nil || !bytes.Equal(key, plainKey) {
+		t.Errorf("expected the unsealed key, got %v and error %v", key, err)
+	}
+
+	// another TPM cannot unseal it, and no new key replaces it
+	SetCredentialKeySealer(testSealer{id: 2})
+	if _, err := sealedCredentialKey(keyFile, sealedKeyFile); err == nil {
+		t.Errorf("expected an error unsealing with another TPM")
+	}
+
+	// a new key is generated by the sealer
+	os.Remove(sealedKeyFile)
+	if key, err := sealedCredentialKey(keyFile, sealedKeyFile); err != nil || len(key) != credentialKeySize || key[0] != 'k' {
+		t.Errorf("expected a generated key, got %v and error %v", key, err)
+	}
+}
@@ -0,0 +1,253 @@
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"os"
+	"sort"
+	"time"
+)
+
+// The bucket name in the bolt DB.
+const DB_MAINTENANCE = "db_maintenance"
+
+// The keys in the DB_MAINTENANCE bucket.
+const (
+	DB_COMPACTION_REQUESTED = "compaction_requested"
+	DB_LAST_COMPACTION      = "last_compaction"
+)
+
+// The size of the database file, the number of keys of its buckets and the state of its freelist.
+type DatabaseStats struct {
+	Path          string        `json:"path"`
+	Size          int64         `json:"size"` // in bytes
+	Buckets       []BucketStats `json:"buckets"`
+	FreePages     int           `json:"free_pages"`     // the pages that can be reused
+	PendingPages  int           `json:"pending_pages"`  // the pages that can be reused once the open read transactions end
+	FreeAlloc     int           `json:"free_alloc"`     // the bytes allocated in the free pages
+	FreelistInuse int           `json:"freelist_inuse"` // the bytes used by the freelist
+}
+
+func (d DatabaseStats) String() string {
+	return fmt.Sprintf("Path: %v, Size: %v, Buckets: %v, FreePages: %v, PendingPages: %v, FreeAlloc: %v, FreelistInuse: %v",
+		d.Path, d.Size, d.Buckets, d.FreePages, d.PendingPages, d.FreeAlloc, d.FreelistInuse)
+}
+
+// A top-level bucket of the database and the number of keys in it, including those of its nested buckets.
+type BucketStats struct {
+	Name string `json:"name"`
+	Keys int    `json:"keys"`
+}
+
+func (b BucketStats) String() string {
+	return fmt.Sprintf("%v: %v", b.Name, b.Keys)
+}
+
+// A compaction of the database file, the space reclaimed is SizeBefore - SizeAfter.
+type DatabaseCompaction struct {
+	Timestamp  uint64 `json:"timestamp"`
+	SizeBefore int64  `json:"size_before"`
+	SizeAfter  int64  `json:"size_after"`
+	Reclaimed  int64  `json:"reclaimed"`
+}
+
+func (d DatabaseCompaction) String() string {
+	return fmt.Sprintf("Timestamp: %v, SizeBefore: %v, SizeAfter: %v, Reclaimed: %v", d.Timestamp, d.SizeBefore, d.SizeAfter, d.Reclaimed)
+}
+
+// Returns the stats of the database, the buckets are sorted by name.
+func GetDatabaseStats(db *bolt.DB) (*DatabaseStats, error) {
+	stats := &DatabaseStats{Path: db.Path(), Buckets: make([]BucketStats, 0, 50)}
+
+	dbStats := db.Stats()
+	stats.FreePages = dbStats.FreePageN
+	stats.PendingPages = dbStats.PendingPageN
+	stats.FreeAlloc = dbStats.FreeAlloc
+	stats.FreelistInuse = dbStats.FreelistInuse
+
+	if info, err := os.Stat(db.Path()); err != nil {
+		return nil, fmt.Errorf("unable to read the size of %v, error %v", db.Path(), err)
+	} else {
+		stats.Size = info.Size()
+	}
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			stats.Buckets = append(stats.Buckets, BucketStats{Name: string(name), Keys: b.Stats().KeyN})
+			return nil
+		})
+	})
+	if readErr != nil {
+		return nil, fmt.Errorf("unable to read the stats of the database, error %v", readErr)
+	}
+
+	sort.Slice(stats.Buckets, func(i, j int) bool { return stats.Buckets[i].Name < stats.Buckets[j].Name })
+	return stats, nil
+}
+
+// Copy the content of the database into a new database file at dstPath, with its pages filled up, and return the size
+// of the new file. The copy is made in a single read transaction, the database can still be written meanwhile. The
+// file at dstPath is replaced if there is one.
+func CompactDatabase(db *bolt.DB, dstPath string) (int64, error) {
+	if err := os.Remove(dstPath); err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("unable to remove %v, error %v", dstPath, err)
+	}
+
+	dst, err := bolt.Open(dstPath, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return 0, fmt.Errorf("unable to create %v, error %v", dstPath, err)
+	}
+
+	copyErr := timedView(db, func(srcTx *bolt.Tx) error {
+		return dst.Update(func(dstTx *bolt.Tx) error {
+			return srcTx.ForEach(func(name []byte, src *bolt.Bucket) error {
+				b, err := dstTx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(src, b)
+			})
+		})
+	})
+	if err := dst.Close(); copyErr == nil && err != nil {
+		copyErr = err
+	}
+	if copyErr != nil {
+		os.Remove(dstPath)
+		return 0, fmt.Errorf("unable to copy the database into %v, error %v", dstPath, copyErr)
+	}
+
+	info, err := os.Stat(dstPath)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read the size of %v, error %v", dstPath, err)
+	}
+	return info.Size(), nil
+}
+
+// Copy the keys and the nested buckets of src into dst.
+func copyBucket(src *bolt.Bucket, dst *bolt.Bucket) error {
+	dst.FillPercent = 1.0
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		nested, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(src.Bucket(k), nested)
+	})
+}
+
+// Compact the database file at path when a compaction was requested, replacing the file with the compacted copy. It
+// must be called before the database is opened, nil is returned when no compaction was requested. The request is
+// cleared even when the compaction fails, so that a database that cannot be compacted does not fail every start.
+func CompactDatabaseFile(path string) (*DatabaseCompaction, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %v, error %v", path, err)
+	}
+
+	requested, err := DatabaseCompactionRequested(db)
+	if err != nil || !requested {
+		db.Close()
+		return nil, err
+	}
+
+	if err := clearDatabaseCompactionRequest(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	compaction := &DatabaseCompaction{Timestamp: uint64(time.Now().Unix())}
+	if info, err := os.Stat(path); err == nil {
+		compaction.SizeBefore = info.Size()
+	}
+
+	// The compacted copy is written next to the database, so that it can be renamed over it.
+	tmpPath := path + ".compact"
+	_, err = CompactDatabase(db, tmpPath)
+	db.Close()
+	if err != nil {
+		return nil, err
+	} else if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("unable to replace %v with the compacted copy, error %v", path, err)
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		compaction.SizeAfter = info.Size()
+	}
+	compaction.Reclaimed = compaction.SizeBefore - compaction.SizeAfter
+
+	db, err = bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the compacted %v, error %v", path, err)
+	}
+	defer db.Close()
+	if err := saveDatabaseMaintenance(db, DB_LAST_COMPACTION, compaction); err != nil {
+		return nil, err
+	}
+	return compaction, nil
+}
+
+// Request the compaction of the database file, the next time the agent starts.
+func RequestDatabaseCompaction(db *bolt.DB) error {
+	return saveDatabaseMaintenance(db, DB_COMPACTION_REQUESTED, true)
+}
+
+// Returns true if a compaction of the database file was requested.
+func DatabaseCompactionRequested(db *bolt.DB) (bool, error) {
+	var requested bool
+	err := findDatabaseMaintenance(db, DB_COMPACTION_REQUESTED, &requested)
+	return requested, err
+}
+
+// Retrieve the last compaction of the database file, nil if it was never compacted.
+func FindLastDatabaseCompaction(db *bolt.DB) (*DatabaseCompaction, error) {
+	var compaction *DatabaseCompaction
+	err := findDatabaseMaintenance(db, DB_LAST_COMPACTION, &compaction)
+	return compaction, err
+}
+
+func clearDatabaseCompactionRequest(db *bolt.DB) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(DB_MAINTENANCE)); b != nil {
+			return b.Delete([]byte(DB_COMPACTION_REQUESTED))
+		}
+		return nil
+	})
+}
+
+func saveDatabaseMaintenance(db *bolt.DB, key string, value interface{}) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(DB_MAINTENANCE)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(value); err != nil {
+			return fmt.Errorf("Failed to serialize %v %v, error %v", key, value, err)
+		} else {
+			return b.Put([]byte(key), serial)
+		}
+	})
+}
+
+func findDatabaseMaintenance(db *bolt.DB, key string, value interface{}) error {
+	return timedView(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(DB_MAINTENANCE)); b != nil {
+			if v := b.Get([]byte(key)); v != nil {
+				if err := json.Unmarshal(v, value); err != nil {
+					return fmt.Errorf("Failed to unmarshal %v %v, error %v", key, string(v), err)
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,108 @@
+// +build unit
+
+package persistence
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_DatabaseStats_and_compaction(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanTestDir(dir)
+
+	// Most of the keys are removed again, their pages are left free in the file.
+	value := []byte(strings.Repeat("x", 1000))
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("history"))
+		if err != nil {
+			return err
+		}
+		nested, err := b.CreateBucket([]byte("nested"))
+		if err != nil {
+			return err
+		}
+		if err := nested.Put([]byte("key"), value); err != nil {
+			return err
+		}
+		for i := 0; i < 2000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("key%04d", i)), value); err != nil {
+				return err
+			}
+		}
+		return b.SetSequence(42)
+	}); err != nil {
+		t.Fatalf("failed to fill the database, error %v", err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("history"))
+		for i := 10; i < 2000; i++ {
+			if err := b.Delete([]byte(fmt.Sprintf("key%04d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to remove the keys, error %v", err)
+	}
+
+	stats, err := GetDatabaseStats(db)
+	if err != nil {
+		t.Fatalf("failed to read the stats, error %v", err)
+	} else if len(stats.Buckets) != 1 || stats.Buckets[0].Name != "history" || stats.Buckets[0].Keys != 12 {
+		t.Errorf("the bucket should have its 10 keys, the nested bucket and its key, got %v", stats.Buckets)
+	} else if stats.Size == 0 || stats.FreeAlloc == 0 {
+		t.Errorf("the file should have free pages, got %v", stats)
+	}
+
+	// The compaction is only made when it was requested, when the database is not open.
+	if err := RequestDatabaseCompaction(db); err != nil {
+		t.Fatalf("failed to request the compaction, error %v", err)
+	}
+	dbPath := db.Path()
+	db.Close()
+
+	compaction, err := CompactDatabaseFile(dbPath)
+	if err != nil {
+		t.Fatalf("failed to compact the database, error %v", err)
+	} else if compaction == nil || compaction.Reclaimed <= 0 || compaction.SizeAfter != compaction.SizeBefore-compaction.Reclaimed {
+		t.Fatalf("the compaction should reclaim the free pages, got %v", compaction)
+	}
+
+	if again, err := CompactDatabaseFile(dbPath); err != nil || again != nil {
+		t.Errorf("the request should be cleared, got %v, error %v", again, err)
+	}
+	if none, err := CompactDatabaseFile(path.Join(dir, "missing.db")); err != nil || none != nil {
+		t.Errorf("there is nothing to compact without a database, got %v, error %v", none, err)
+	}
+
+	db, err = bolt.Open(dbPath, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("failed to open the compacted database, error %v", err)
+	}
+	defer db.Close()
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("history"))
+		if b == nil || b.Sequence() != 42 || string(b.Get([]byte("key0009"))) != string(value) || b.Get([]byte("key0010")) != nil {
+			return fmt.Errorf("the keys and the sequence of the bucket should be kept")
+		} else if nested := b.Bucket([]byte("nested")); nested == nil || nested.Get([]byte("key")) == nil {
+			return fmt.Errorf("the nested bucket should be kept")
+		}
+		return nil
+	}); err != nil {
+		t.Error(err)
+	}
+
+	if last, err := FindLastDatabaseCompaction(db); err != nil || last == nil || last.Reclaimed != compaction.Reclaimed {
+		t.Errorf("the last compaction should be recorded, got %v, error %v", last, err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/boltdb/bolt"
 	"github.com/golang/glog"
+	"reflect"
 	"strings"
 	"time"
 )
@@ -20,14 +21,21 @@ const CONFIGSTATE_UNCONFIGURING = "unconfiguring"
 const CONFIGSTATE_UNCONFIGURED = "unconfigured"
 const CONFIGSTATE_CONFIGURING = "configuring"
 const CONFIGSTATE_CONFIGURED = "configured"
+const CONFIGSTATE_CONFIGURED_PENDING = "configured_pending" // the services are configured, the node is configured at the effective time
 
 type Configstate struct {
-	State          string `json:"state"`
-	LastUpdateTime uint64 `json:"last_update_time"`
+	State           string            `json:"state"`
+	LastUpdateTime  uint64            `json:"last_update_time"`
+	Versions        map[string]string `json:"versions,omitempty"`          // the version ranges the services were pinned to when the node was configured, by org/url
+	EffectiveTime   uint64            `json:"effective_time,omitempty"`    // when a configured_pending node is changed to configured
+	PendingPolicies []string          `json:"pending_policies,omitempty"`  // the policy files of the services of a configured_pending node, advertised when it is configured
+	Excluded        ServiceSpecs      `json:"excluded_services,omitempty"` // the services that the autoconfig of the pattern skips, e.g. for hardware the node does not have
+	Channel         string            `json:"channel,omitempty"`           // the version choices of the pattern that the autoconfig resolves, e.g. stable, all of them when empty
+	Optional        ServiceSpecs      `json:"optional_services,omitempty"` // the top-level services of the pattern that the autoconfig skips when they cannot be resolved
 }
 
 func (c Configstate) String() string {
-	return fmt.Sprintf("State: %v, Time: %v", c.State, c.LastUpdateTime)
+	return fmt.Sprintf("State: %v, Time: %v, Versions: %v, EffectiveTime: %v, PendingPolicies: %v, Excluded: %v, Channel: %v, Optional: %v", c.State, c.LastUpdateTime, c.Versions, c.EffectiveTime, c.PendingPolicies, c.Excluded, c.Channel, c.Optional)
 }
 
 // This function returns the pattern org, pattern name and formatted pattern string 'pattern org/pattern name'.
@@ -59,6 +67,7 @@ type ExchangeDevice struct {
 	TokenValid         bool        `json:"token_valid"`
 	HA                 bool        `json:"ha"`
 	Config             Configstate `json:"configstate"`
+	Revision           uint64      `json:"revision"` // incremented each time the record is updated, for the ETag of the API
 }
 
 func (e ExchangeDevice) String() string {
@@ -69,7 +78,7 @@ func (e ExchangeDevice) String() string {
 		tokenShadow = "unset"
 	}
 
-	return fmt.Sprintf("Org: %v, Token: <%s>, Name: %v, NodeType: %v, TokenLastValidTime: %v, TokenValid: %v, Pattern: %v, %v", e.Org, tokenShadow, e.Name, e.NodeType, e.TokenLastValidTime, e.TokenValid, e.Pattern, e.Config)
+	return fmt.Sprintf("Org: %v, Token: <%s>, Name: %v, NodeType: %v, TokenLastValidTime: %v, TokenValid: %v, Pattern: %v, Revision: %v, %v", e.Org, tokenShadow, e.Name, e.NodeType, e.TokenLastValidTime, e.TokenValid, e.Pattern, e.Revision, e.Config)
 }
 
 func (e ExchangeDevice) GetId() string {
@@ -103,6 +112,7 @@ func newExchangeDevice(id string, token string, name string, nodeType string, to
 		Org:                org,
 		Pattern:            pattern,
 		Config:             cfg,
+		Revision:           1,
 	}, nil
 }
 
@@ -150,6 +160,80 @@ func (e *ExchangeDevice) SetConfigstate(db *bolt.DB, deviceId string, state stri
 	return updateExchangeDevice(db, e, deviceId, false, func(d ExchangeDevice) *ExchangeDevice {
 		d.Config.State = state
 		d.Config.LastUpdateTime = uint64(time.Now().Unix())
+		d.Config.EffectiveTime = 0
+		d.Config.PendingPolicies = nil
+		return &d
+	})
+}
+
+// Set the config state together with the version ranges that the services were pinned to, nil when none were.
+func (e *ExchangeDevice) SetConfigstateVersions(db *bolt.DB, deviceId string, state string, versions map[string]string) (*ExchangeDevice, error) {
+	if deviceId == "" || state == "" {
+		return nil, errors.New("Argument null and mustn't be")
+	}
+
+	return updateExchangeDevice(db, e, deviceId, false, func(d ExchangeDevice) *ExchangeDevice {
+		d.Config.State = state
+		d.Config.LastUpdateTime = uint64(time.Now().Unix())
+		d.Config.Versions = versions
+		d.Config.EffectiveTime = 0
+		d.Config.PendingPolicies = nil
+		return &d
+	})
+}
+
+// Set the config state to configured_pending, the node is changed to configured at the effective time, when the given
+// policy files are advertised.
+func (e *ExchangeDevice) SetConfigstatePending(db *bolt.DB, deviceId string, versions map[string]string, effectiveTime uint64, policies []string) (*ExchangeDevice, error) {
+	if deviceId == "" || effectiveTime == 0 {
+		return nil, errors.New("Argument null and mustn't be")
+	}
+
+	return updateExchangeDevice(db, e, deviceId, false, func(d ExchangeDevice) *ExchangeDevice {
+		d.Config.State = CONFIGSTATE_CONFIGURED_PENDING
+		d.Config.LastUpdateTime = uint64(time.Now().Unix())
+		d.Config.Versions = versions
+		d.Config.EffectiveTime = effectiveTime
+		d.Config.PendingPolicies = policies
+		return &d
+	})
+}
+
+// Set the services that the autoconfig of the pattern skips, nil when none are. They are kept when the config state
+// changes, until the node is unregistered.
+func (e *ExchangeDevice) SetExcludedServices(db *bolt.DB, deviceId string, excluded ServiceSpecs) (*ExchangeDevice, error) {
+	if deviceId == "" {
+		return nil, errors.New("Argument null and mustn't be")
+	}
+
+	return updateExchangeDevice(db, e, deviceId, false, func(d ExchangeDevice) *ExchangeDevice {
+		d.Config.Excluded = excluded
+		return &d
+	})
+}
+
+// Set the top-level services of the pattern that the autoconfig skips when they cannot be resolved, nil when none are.
+// They are kept when the config state changes, until the node is unregistered.
+func (e *ExchangeDevice) SetOptionalServices(db *bolt.DB, deviceId string, optional ServiceSpecs) (*ExchangeDevice, error) {
+	if deviceId == "" {
+		return nil, errors.New("Argument null and mustn't be")
+	}
+
+	return updateExchangeDevice(db, e, deviceId, false, func(d ExchangeDevice) *ExchangeDevice {
+		d.Config.Optional = optional
+		return &d
+	})
+}
+
+// Set the channel of the version choices of the pattern that the autoconfig resolves, empty for all of them. It is kept
+// when the config state changes, until the node is unregistered.
+func (e *ExchangeDevice) SetChannel(db *bolt.DB, deviceId string, channel string) (*ExchangeDevice, error) {
+	if deviceId == "" {
+		return nil, errors.New("Argument null and mustn't be")
+	}
+
+	return updateExchangeDevice(db, e, deviceId, false, func(d ExchangeDevice) *ExchangeDevice {
+		d.Config.Channel = channel
 		return &d
 	})
 }
@@ -189,7 +273,7 @@ func updateExchangeDevice(db *bolt.DB, self *ExchangeDevice, deviceId string, in
 
 	var mod ExchangeDevice
 
-	return &mod, db.Update(func(tx *bolt.Tx) error {
+	return &mod, timedUpdate(db, func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte(DEVICES))
 		if err != nil {
 			return err
@@ -202,12 +286,15 @@ func updateExchangeDevice(db *bolt.DB, self *ExchangeDevice, deviceId string, in
 			return fmt.Errorf("No device with given device id to update: %v", deviceId)
 		} else if err := json.Unmarshal(current, &mod); err != nil {
 			return fmt.Errorf("Failed to unmarshal device data: %v. Error: %v", string(current), err)
+		} else if mod.Token, err = decryptDeviceToken(db, mod.Token); err != nil {
+			return fmt.Errorf("Failed to decrypt device token. Error: %v", err)
 		} else {
 
 			// Even though there is only one key in the bucket, make sure the update is for the right device
 			if mod.Id != deviceId {
 				return fmt.Errorf("No device with given device id to update: %v", deviceId)
 			}
+			before := mod
 
 			// Differentiate token invalidation from updating a token.
 			if invalidateToken {
@@ -221,9 +308,27 @@ func updateExchangeDevice(db *bolt.DB, self *ExchangeDevice, deviceId string, in
 			}
 
 			// Write updates only to the fields we expect should be updateable
-			if mod.Config.State != update.Config.State {
+			if mod.Config.State != update.Config.State || mod.Config.EffectiveTime != update.Config.EffectiveTime {
 				mod.Config.State = update.Config.State
 				mod.Config.LastUpdateTime = update.Config.LastUpdateTime
+				mod.Config.Versions = update.Config.Versions
+				mod.Config.EffectiveTime = update.Config.EffectiveTime
+				mod.Config.PendingPolicies = update.Config.PendingPolicies
+			}
+
+			// Update the services excluded from the autoconfig
+			if !mod.Config.Excluded.IsSame(update.Config.Excluded) {
+				mod.Config.Excluded = update.Config.Excluded
+			}
+
+			// Update the optional services of the autoconfig
+			if !mod.Config.Optional.IsSame(update.Config.Optional) {
+				mod.Config.Optional = update.Config.Optional
+			}
+
+			// Update the channel of the autoconfig
+			if mod.Config.Channel != update.Config.Channel {
+				mod.Config.Channel = update.Config.Channel
 			}
 
 			// Update the node type
@@ -236,9 +341,18 @@ func updateExchangeDevice(db *bolt.DB, self *ExchangeDevice, deviceId string, in
 				mod.Pattern = update.Pattern
 			}
 
+			// A concurrent client can tell that the node changed since it read it. The changes that the API does not
+			// show, e.g. of the token, do not fail the conditional requests of the clients.
+			if !sameAsShown(before, mod) {
+				mod.Revision++
+			}
+
 			// note: DEVICES is used as the key b/c we only want to store one value in this bucket
 
-			if serialized, err := json.Marshal(mod); err != nil {
+			stored := mod
+			if stored.Token, err = encryptDeviceToken(db, mod.Token); err != nil {
+				return fmt.Errorf("Failed to encrypt device token. Error: %v", err)
+			} else if serialized, err := json.Marshal(stored); err != nil {
 				return fmt.Errorf("Failed to serialize device record: %v. Error: %v", mod, err)
 			} else if err := b.Put([]byte(DEVICES), serialized); err != nil {
 				return fmt.Errorf("Failed to write device record with key: %v. Error: %v", DEVICES, err)
@@ -251,6 +365,14 @@ func updateExchangeDevice(db *bolt.DB, self *ExchangeDevice, deviceId string, in
 
 }
 
+// Returns true when the agent API shows the two records of the node the same way. The token and the pending policies
+// of the config state are not shown.
+func sameAsShown(a ExchangeDevice, b ExchangeDevice) bool {
+	a.Token, b.Token = "", ""
+	a.Config.PendingPolicies, b.Config.PendingPolicies = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
 // always assumed the given token is valid at the time of call
 func SaveNewExchangeDevice(db *bolt.DB, id string, token string, name string, nodeType string, ha bool, organization string, pattern string, configstate string) (*ExchangeDevice, error) {
 
@@ -260,7 +382,7 @@ func SaveNewExchangeDevice(db *bolt.DB, id string, token string, name string, no
 
 	duplicate := false
 
-	dErr := db.View(func(tx *bolt.Tx) error {
+	dErr := timedView(db, func(tx *bolt.Tx) error {
 		bd := tx.Bucket([]byte(DEVICES))
 		if bd != nil {
 			duplicate = (bd.Get([]byte(name)) != nil)
@@ -282,7 +404,7 @@ func SaveNewExchangeDevice(db *bolt.DB, id string, token string, name string, no
 		return nil, err
 	}
 
-	writeErr := db.Update(func(tx *bolt.Tx) error {
+	writeErr := timedUpdate(db, func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte(DEVICES))
 		if err != nil {
 			return err
@@ -290,7 +412,10 @@ func SaveNewExchangeDevice(db *bolt.DB, id string, token string, name string, no
 
 		// note: DEVICES is used as the key b/c we only want to store one value in this bucket
 
-		if serial, err := json.Marshal(&exDevice); err != nil {
+		stored := *exDevice
+		if stored.Token, err = encryptDeviceToken(db, exDevice.Token); err != nil {
+			return fmt.Errorf("Failed to encrypt device token. Error: %v", err)
+		} else if serial, err := json.Marshal(&stored); err != nil {
 			return fmt.Errorf("Failed to serialize device: %v. Error: %v", exDevice, err)
 		} else {
 			return b.Put([]byte(DEVICES), serial)
@@ -304,7 +429,7 @@ func FindExchangeDevice(db *bolt.DB) (*ExchangeDevice, error) {
 
 	devices := make([]ExchangeDevice, 0)
 
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 		if b := tx.Bucket([]byte(DEVICES)); b != nil {
 			return b.ForEach(func(k, v []byte) error {
 				var dev ExchangeDevice
@@ -337,6 +462,15 @@ func FindExchangeDevice(db *bolt.DB) (*ExchangeDevice, error) {
 		if devices[0].NodeType == "" {
 			devices[0].NodeType = DEVICE_TYPE_DEVICE
 		}
+
+		// Without the credential key, e.g. when the TPM that sealed it fails, the node has no usable token.
+		if token, err := decryptDeviceToken(db, devices[0].Token); err != nil {
+			glog.Errorf("Unable to decrypt the exchange token of the node, error %v", err)
+			devices[0].Token = ""
+			devices[0].TokenValid = false
+		} else {
+			devices[0].Token = token
+		}
 		return &devices[0], nil
 	} else {
 		return nil, nil
@@ -351,7 +485,7 @@ func DeleteExchangeDevice(db *bolt.DB) error {
 		return fmt.Errorf("could not find record for device")
 	} else {
 
-		return db.Update(func(tx *bolt.Tx) error {
+		return timedUpdate(db, func(tx *bolt.Tx) error {
 
 			if b, err := tx.CreateBucketIfNotExists([]byte(DEVICES)); err != nil {
 				return err
@@ -377,7 +511,45 @@ func MigrateExchangeDevice(db *bolt.DB) (bool, error) {
 			if dev.Pattern != "" {
 				usingPattern = true
 			}
+
+			// The token of a node that started using a TPM is encrypted.
+			if err := encryptStoredDeviceToken(db); err != nil {
+				return usingPattern, err
+			}
 		}
 	}
 	return usingPattern, nil
 }
+
+// Encrypt the exchange token stored in the database, if it should be encrypted and is not yet.
+func encryptStoredDeviceToken(db *bolt.DB) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DEVICES))
+		if b == nil {
+			return nil
+		}
+		current := b.Get([]byte(DEVICES))
+		if current == nil {
+			return nil
+		}
+
+		var dev ExchangeDevice
+		if err := json.Unmarshal(current, &dev); err != nil {
+			return fmt.Errorf("Failed to unmarshal device data: %v. Error: %v", string(current), err)
+		}
+		token, err := encryptDeviceToken(db, dev.Token)
+		if err != nil {
+			return fmt.Errorf("Failed to encrypt device token. Error: %v", err)
+		} else if token == dev.Token {
+			return nil
+		}
+
+		dev.Token = token
+		if serialized, err := json.Marshal(dev); err != nil {
+			return fmt.Errorf("Failed to serialize device record: %v. Error: %v", dev, err)
+		} else {
+			glog.V(3).Infof("Encrypted the exchange token of the node")
+			return b.Put([]byte(DEVICES), serialized)
+		}
+	})
+}
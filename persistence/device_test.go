@@ -36,3 +36,21 @@ func Test_GetFormatedPatternString(t *testing.T) {
 	assert.Equal(t, "pattern1", name, "No org string found")
 	assert.Equal(t, "pattern1", pattern, "No org string found")
 }
+
+func Test_sameAsShown(t *testing.T) {
+
+	node := ExchangeDevice{Id: "node1", Org: "org1", Token: "token1", Pattern: "org1/pattern1", Revision: 3, Config: Configstate{State: CONFIGSTATE_CONFIGURED, PendingPolicies: []string{"a.policy"}}}
+
+	other := node
+	other.Token = "token2"
+	other.Config.PendingPolicies = nil
+	assert.True(t, sameAsShown(node, other), "the token and the pending policies are not shown")
+
+	other = node
+	other.Pattern = "org1/pattern2"
+	assert.False(t, sameAsShown(node, other), "the pattern is shown")
+
+	other = node
+	other.Config.Channel = "stable"
+	assert.False(t, sameAsShown(node, other), "the config state is shown")
+}
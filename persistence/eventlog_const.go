@@ -37,12 +37,68 @@ const (
 	EC_START_NODE_CONFIG_REG    = "start_node_configuration_registration"
 	EC_NODE_CONFIG_REG_COMPLETE = "node_configuration_registration_complete"
 	EC_ERROR_NODE_CONFIG_REG    = "error_node_configuration_registration"
+	EC_NODE_CONFIG_ROLLBACK     = "node_configuration_rollback"
+
+	// node configured from the offline definitions, and their comparison with the exchange
+	EC_NODE_CONFIG_OFFLINE            = "node_configuration_offline"
+	EC_OFFLINE_DEFINITIONS_DRIFT      = "offline_definitions_drift"
+	EC_OFFLINE_DEFINITIONS_RECONCILED = "offline_definitions_reconciled"
+
+	// services of the node compared with the ones its pattern requires
+	EC_SERVICE_DRIFT           = "service_drift"
+	EC_SERVICE_RECONCILED      = "service_reconciled"
+	EC_ERROR_SERVICE_RECONCILE = "error_service_reconcile"
+
+	// node returned from configured to configuring
+	EC_START_NODE_UNCONFIG    = "start_node_unconfiguration"
+	EC_NODE_UNCONFIG_COMPLETE = "node_unconfiguration_complete"
+	EC_ERROR_NODE_UNCONFIG    = "error_node_unconfiguration"
+
+	// node provisioning on first boot
+	EC_START_NODE_PROVISIONING    = "start_node_provisioning"
+	EC_NODE_PROVISIONING_COMPLETE = "node_provisioning_complete"
+	EC_ERROR_NODE_PROVISIONING    = "error_node_provisioning"
+
+	// node configuration imported from another node
+	EC_START_NODE_IMPORT    = "start_node_import"
+	EC_NODE_IMPORT_COMPLETE = "node_import_complete"
+	EC_ERROR_NODE_IMPORT    = "error_node_import"
 
 	// node update
 	EC_START_NODE_UPDATE    = "start_node_update"
 	EC_NODE_UPDATE_COMPLETE = "node_update_complete"
 	EC_ERROR_NODE_UPDATE    = "error_node_update"
 
+	// agent update
+	EC_AGENT_UPDATE_AVAILABLE = "agent_update_available"
+	EC_START_AGENT_UPDATE     = "start_agent_update"
+	EC_AGENT_UPDATE_COMPLETE  = "agent_update_complete"
+	EC_ERROR_AGENT_UPDATE     = "error_agent_update"
+
+	// kubernetes scope
+	EC_ERROR_KUBE_SCOPE = "error_kube_scope"
+
+	// workload canary rollout
+	EC_START_WORKLOAD_CANARY    = "start_workload_canary"
+	EC_WORKLOAD_CANARY_ADMITTED = "workload_canary_admitted"
+	EC_WORKLOAD_CANARY_PROMOTED = "workload_canary_promoted"
+	EC_WORKLOAD_CANARY_FAILED   = "workload_canary_failed"
+
+	// maintenance windows
+	EC_ACTION_DEFERRED           = "action_deferred"
+	EC_START_DEFERRED_ACTION     = "start_deferred_action"
+	EC_MAINTENANCE_WINDOW_OPENED = "maintenance_window_opened"
+	EC_MAINTENANCE_WINDOW_CLOSED = "maintenance_window_closed"
+
+	// disk space
+	EC_DISK_SPACE_LOW       = "disk_space_low"
+	EC_DISK_SPACE_RECOVERED = "disk_space_recovered"
+	EC_DISK_SPACE_UNKNOWN   = "disk_space_unknown"
+
+	// clock skew
+	EC_CLOCK_SKEW           = "clock_skew"
+	EC_CLOCK_SKEW_RECOVERED = "clock_skew_recovered"
+
 	// node pattern
 	EC_NODE_PATTERN_CHANGED            = "node_pattern_changed"
 	EC_NODE_PATTERN_CHANGED_AGAIN      = "node_pattern_changed_again"
@@ -121,9 +177,13 @@ const (
 	EC_CONTAINER_STOPPED          = "container_stopped"
 	EC_ERROR_IN_DEPLOYMENT_CONFIG = "error_in_deployment_configuration"
 	EC_ERROR_START_CONTAINER      = "error_start_container"
+	EC_CONTAINER_UNHEALTHY        = "container_unhealthy"
+	EC_CONTAINER_HEALTHY          = "container_healthy"
+	EC_OBJECT_UPDATED             = "object_updated"
 
 	EC_IMAGE_LOADED                       = "image_loaded"
 	EC_ERROR_IMAGE_LOADE                  = "error_image_load"
+	EC_IMAGE_DIGEST_MISMATCH              = "image_digest_mismatch"
 	EC_ERROR_AGREEMENT_VERIFICATION       = "error_in_agreement_verification"
 	EC_ERROR_DELETE_AGREEMENT_IN_EXCHANGE = "error_delete_agreement_in_exchange"
 
@@ -138,10 +198,12 @@ const (
 	EC_COMPLETE_DEPENDENT_SERVICE          = "complete_dependent_service"
 	EC_REMOVE_OLD_DEPENDENT_SERVICE_FAILED = "remove_old_dependent_service_failed"
 
-	EC_START_RETRY_DEPENDENT_SERVICE       = "start_retry_dependent_service"
-	EC_ERROR_START_RETRY_DEPENDENT_SERVICE = "error_start_retry_dependent_service"
-	EC_DEPENDENT_SERVICE_RETRY_FAILED      = "dependent_service_retry_failed"
-	EC_COMPLETE_RETRY_DEPENDENT_SERVICE    = "complete_retry_dependent_service"
+	EC_START_RETRY_DEPENDENT_SERVICE        = "start_retry_dependent_service"
+	EC_ERROR_START_RETRY_DEPENDENT_SERVICE  = "error_start_retry_dependent_service"
+	EC_DEPENDENT_SERVICE_RETRY_FAILED       = "dependent_service_retry_failed"
+	EC_COMPLETE_RETRY_DEPENDENT_SERVICE     = "complete_retry_dependent_service"
+	EC_SCHEDULE_RETRY_DEPENDENT_SERVICE     = "schedule_retry_dependent_service"
+	EC_DEPENDENT_SERVICE_RESTARTS_EXHAUSTED = "dependent_service_restarts_exhausted"
 
 	EC_START_AGREEMENTLESS_SERVICE            = "start_agreementless_service"
 	EC_ERROR_START_AGREEMENTLESS_SERVICE      = "error_start_agreementless_service"
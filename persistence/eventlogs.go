@@ -192,7 +192,7 @@ func NewMessageMeta(msg_key string, message_args ...interface{}) *MessageMeta {
 
 // save the timestamp for the last unregistration into db.
 func SaveLastUnregistrationTime(db *bolt.DB, last_unreg_time uint64) error {
-	writeErr := db.Update(func(tx *bolt.Tx) error {
+	writeErr := timedUpdate(db, func(tx *bolt.Tx) error {
 		if bucket, err := tx.CreateBucketIfNotExists([]byte(LAST_UNREG)); err != nil {
 			return err
 		} else {
@@ -209,7 +209,7 @@ func GetLastUnregistrationTime(db *bolt.DB) (uint64, error) {
 	last_unreg = 0
 
 	// fetch event logs
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 
 		if b := tx.Bucket([]byte(LAST_UNREG)); b != nil {
 			v := b.Get([]byte("lastunreg"))
@@ -232,7 +232,7 @@ func GetLastUnregistrationTime(db *bolt.DB) (uint64, error) {
 
 // save the event log record into db.
 func SaveEventLog(db *bolt.DB, event_log *EventLog) error {
-	writeErr := db.Update(func(tx *bolt.Tx) error {
+	writeErr := timedUpdate(db, func(tx *bolt.Tx) error {
 		if bucket, err := tx.CreateBucketIfNotExists([]byte(EVENT_LOGS)); err != nil {
 			return err
 		} else if nextKey, err := bucket.NextSequence(); err != nil {
@@ -308,7 +308,7 @@ func FindEventLogWithKey(db *bolt.DB, key string) (*EventLog, error) {
 	pel = nil
 
 	// fetch event logs
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 
 		if b := tx.Bucket([]byte(EVENT_LOGS)); b != nil {
 			v := b.Get([]byte(key))
@@ -364,7 +364,7 @@ func FindEventLogs(db *bolt.DB, filters []EventLogFilter) ([]EventLog, error) {
 	evlogs := make([]EventLog, 0)
 
 	// fetch logs
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 
 		if b := tx.Bucket([]byte(EVENT_LOGS)); b != nil {
 			b.ForEach(func(k, v []byte) error {
@@ -428,7 +428,7 @@ func FindEventLogsWithSelectors(db *bolt.DB, all_logs bool, selectors map[string
 	}
 
 	// fetch logs
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 
 		if b := tx.Bucket([]byte(EVENT_LOGS)); b != nil {
 			b.ForEach(func(k, v []byte) error {
@@ -475,7 +475,7 @@ func FindAllEventLogs(db *bolt.DB) ([]EventLog, error) {
 	evlogs := make([]EventLog, 0)
 
 	// fetch logs
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 
 		if b := tx.Bucket([]byte(EVENT_LOGS)); b != nil {
 			b.ForEach(func(k, v []byte) error {
@@ -28,7 +28,7 @@ func FindExchangeChangeState(db *bolt.DB) (*ChangeState, error) {
 
 	chg := make([]ChangeState, 0)
 
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 		if b := tx.Bucket([]byte(EXCHANGE_CHANGES)); b != nil {
 			return b.ForEach(func(k, v []byte) error {
 				var c ChangeState
@@ -63,7 +63,7 @@ func FindExchangeChangeState(db *bolt.DB) (*ChangeState, error) {
 // There is only 1 object in the bucket so we can use the bucket name as the object key.
 func SaveExchangeChangeState(db *bolt.DB, changeID uint64) error {
 
-	writeErr := db.Update(func(tx *bolt.Tx) error {
+	writeErr := timedUpdate(db, func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte(EXCHANGE_CHANGES))
 		if err != nil {
 			return err
@@ -96,7 +96,7 @@ func DeleteExchangeChangeState(db *bolt.DB) error {
 		return nil
 	} else {
 
-		return db.Update(func(tx *bolt.Tx) error {
+		return timedUpdate(db, func(tx *bolt.Tx) error {
 
 			if b, err := tx.CreateBucketIfNotExists([]byte(EXCHANGE_CHANGES)); err != nil {
 				return err
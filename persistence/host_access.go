@@ -0,0 +1,77 @@
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// The bucket name in the bolt DB.
+const HOST_ACCESS = "host_access"
+
+// The host devices and host paths that deployment configs are allowed to map into containers. When it is set through
+// the node API, it replaces the allow lists in the anax configuration file.
+type HostAccessAllowList struct {
+	Devices   []string `json:"devices"`    // Host device path patterns, e.g. /dev/nvidia*.
+	HostPaths []string `json:"host_paths"` // Host path patterns, ending with :rw if the paths can be mounted read-write.
+}
+
+func (h HostAccessAllowList) String() string {
+	return fmt.Sprintf("Devices: %v, HostPaths: %v", h.Devices, h.HostPaths)
+}
+
+// Retrieve the host access allow list from the database, nil if it has not been set.
+func FindHostAccessAllowList(db *bolt.DB) (*HostAccessAllowList, error) {
+	var allowList *HostAccessAllowList
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(HOST_ACCESS)); b != nil {
+			if v := b.Get([]byte(HOST_ACCESS)); v != nil {
+				var al HostAccessAllowList
+				if err := json.Unmarshal(v, &al); err != nil {
+					return fmt.Errorf("Unable to deserialize host access allow list record: %v", v)
+				}
+				allowList = &al
+			}
+		}
+		return nil // end transaction
+	})
+
+	return allowList, readErr
+}
+
+// There is only 1 object in the bucket so we can use the bucket name as the object key.
+func SaveHostAccessAllowList(db *bolt.DB, allowList *HostAccessAllowList) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(HOST_ACCESS)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(allowList); err != nil {
+			return fmt.Errorf("Failed to serialize host access allow list: %v. Error: %v", allowList, err)
+		} else {
+			return b.Put([]byte(HOST_ACCESS), serial)
+		}
+	})
+}
+
+// Remove the host access allow list from the local database, the allow lists of the anax configuration file apply again.
+func DeleteHostAccessAllowList(db *bolt.DB) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(HOST_ACCESS)); b != nil {
+			return b.Delete([]byte(HOST_ACCESS))
+		}
+		return nil
+	})
+}
+
+// Returns the host access allow list that is in effect: the one set through the node API if there is one, otherwise
+// the given allow lists from the anax configuration file.
+func GetHostAccessAllowList(db *bolt.DB, configDevices []string, configHostPaths []string) (*HostAccessAllowList, error) {
+	if db != nil {
+		if al, err := FindHostAccessAllowList(db); err != nil {
+			return nil, err
+		} else if al != nil {
+			return al, nil
+		}
+	}
+	return &HostAccessAllowList{Devices: configDevices, HostPaths: configHostPaths}, nil
+}
@@ -4,26 +4,168 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/open-horizon/anax/cutil"
+	"regexp"
+	"sort"
+	"strings"
 )
 
+// The cluster deployment of a service. It is either an operator, whose yaml files are in the archive, or a workload
+// that anax renders into Kubernetes Deployments, Services and a ConfigMap itself.
 type KubeDeploymentConfig struct {
-	OperatorYamlArchive string `json:"operatorYamlArchive"`
+	OperatorYamlArchive string        `json:"operatorYamlArchive,omitempty"`
+	Workload            *KubeWorkload `json:"workload,omitempty"`
+}
+
+/*
+ * A workload deployed to the cluster without an operator. Each service is a Deployment, and a Service when it has
+ * ports, in the namespace of the workload.
+ *
+ * ex:
+ * {
+ *   "workload": {
+ *     "namespace": "weather",
+ *     "services": {
+ *       "collector": {
+ *         "image": "myorg/collector:1.2.0",
+ *         "replicas": 2,
+ *         "environment": ["LOG_LEVEL=info"],
+ *         "ports": [{"port": 8080}]
+ *       }
+ *     }
+ *   }
+ * }
+ */
+type KubeWorkload struct {
+	Namespace string                          `json:"namespace,omitempty"` // the anax namespace when empty
+	Services  map[string]*KubeWorkloadService `json:"services"`
+}
+
+type KubeWorkloadService struct {
+	Image       string             `json:"image"`
+	Replicas    int32              `json:"replicas,omitempty"` // 1 when not set
+	Command     []string           `json:"command,omitempty"`
+	Args        []string           `json:"args,omitempty"`
+	Environment []string           `json:"environment,omitempty"` // NAME=value
+	Ports       []KubeWorkloadPort `json:"ports,omitempty"`
+	Privileged  bool               `json:"privileged,omitempty"`
+	MaxMemoryMb int64              `json:"max_memory_mb,omitempty"`
+	MaxCPUs     float32            `json:"max_cpus,omitempty"`
+}
+
+type KubeWorkloadPort struct {
+	Port     int32  `json:"port"`
+	Protocol string `json:"protocol,omitempty"` // TCP when not set
+}
+
+// The names of the workload and its services become the names of Kubernetes objects, so they must be DNS labels.
+var kubeNameRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+func (w *KubeWorkload) Validate() error {
+	if w.Namespace != "" && (len(w.Namespace) > 63 || !kubeNameRegex.MatchString(w.Namespace)) {
+		return fmt.Errorf("workload namespace %v is not a valid Kubernetes name, it must be lower case letters, digits and '-'", w.Namespace)
+	} else if len(w.Services) == 0 {
+		return fmt.Errorf("workload has no services")
+	}
+
+	for name, svc := range w.Services {
+		if len(name) > 63 || !kubeNameRegex.MatchString(name) {
+			return fmt.Errorf("workload service name %v is not a valid Kubernetes name, it must be lower case letters, digits and '-'", name)
+		} else if svc == nil || svc.Image == "" {
+			return fmt.Errorf("workload service %v has no image", name)
+		} else if svc.Replicas < 0 {
+			return fmt.Errorf("workload service %v has a negative number of replicas", name)
+		}
+		for _, env := range svc.Environment {
+			if !strings.Contains(env, "=") {
+				return fmt.Errorf("workload service %v has environment variable %v that is not NAME=value", name, env)
+			}
+		}
+		for _, port := range svc.Ports {
+			if port.Port < 1 || port.Port > 65535 {
+				return fmt.Errorf("workload service %v has port %v that is out of range", name, port.Port)
+			} else if p := strings.ToUpper(port.Protocol); p != "" && p != "TCP" && p != "UDP" && p != "SCTP" {
+				return fmt.Errorf("workload service %v has port %v with unsupported protocol %v", name, port.Port, port.Protocol)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the names of the services of the workload, sorted.
+func (w *KubeWorkload) ServiceNames() []string {
+	names := make([]string, 0, len(w.Services))
+	for name := range w.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the cpus and the MB of memory that the limits of all the replicas of the workload add up to. The services
+// that do not set a limit have the default limit.
+func (w *KubeWorkload) ResourceLimits(defaultCPUs float64, defaultMemoryMb int64) (float64, int64) {
+	cpus, memoryMb := float64(0), int64(0)
+	for _, svc := range w.Services {
+		replicas := svc.Replicas
+		if replicas == 0 {
+			replicas = 1
+		}
+		svcCPUs, svcMemoryMb := float64(svc.MaxCPUs), svc.MaxMemoryMb
+		if svcCPUs == 0 {
+			svcCPUs = defaultCPUs
+		}
+		if svcMemoryMb == 0 {
+			svcMemoryMb = defaultMemoryMb
+		}
+		cpus += float64(replicas) * svcCPUs
+		memoryMb += int64(replicas) * svcMemoryMb
+	}
+	return cpus, memoryMb
+}
+
+// Returns the services, sorted, that have no cpu limit and no memory limit when there is no default limit. Their
+// containers can use as much as the node has, so they cannot be counted against a quota.
+func (w *KubeWorkload) UnboundedServices(defaultCPUs float64, defaultMemoryMb int64) (cpus []string, memory []string) {
+	for _, name := range w.ServiceNames() {
+		svc := w.Services[name]
+		if svc.MaxCPUs == 0 && defaultCPUs == 0 {
+			cpus = append(cpus, name)
+		}
+		if svc.MaxMemoryMb == 0 && defaultMemoryMb == 0 {
+			memory = append(memory, name)
+		}
+	}
+	return cpus, memory
 }
 
 func (k *KubeDeploymentConfig) ToString() string {
 	if k != nil {
+		if k.Workload != nil {
+			return fmt.Sprintf("Workload: Namespace: %v, Services: %v", k.Workload.Namespace, k.Workload.ServiceNames())
+		}
 		return fmt.Sprintf("OperatorYamlArchive: %v", cutil.TruncateDisplayString(k.OperatorYamlArchive, 20))
 	}
 	return ""
 }
 
+// Returns true if the deployment is a workload rather than an operator.
+func (k *KubeDeploymentConfig) IsWorkload() bool {
+	return k != nil && k.Workload != nil
+}
+
 func GetKubeDeployment(deployStr string) (*KubeDeploymentConfig, error) {
 	kd := new(KubeDeploymentConfig)
 	err := json.Unmarshal([]byte(deployStr), kd)
 	if err != nil {
 		return nil, fmt.Errorf("error unmarshaling deployment config as KubeDeployment: %v", err)
-	} else if kd.OperatorYamlArchive == "" {
-		return nil, fmt.Errorf("required field 'operatorYamlArchive' is missing in the deployment string.")
+	} else if kd.OperatorYamlArchive == "" && kd.Workload == nil {
+		return nil, fmt.Errorf("required field 'operatorYamlArchive' or 'workload' is missing in the deployment string.")
+	} else if kd.OperatorYamlArchive != "" && kd.Workload != nil {
+		return nil, fmt.Errorf("the deployment string can have only one of 'operatorYamlArchive' and 'workload'.")
+	} else if kd.Workload != nil {
+		if err := kd.Workload.Validate(); err != nil {
+			return nil, fmt.Errorf("error in the 'workload' of the deployment string: %v", err)
+		}
 	}
 	return kd, nil
 }
@@ -59,6 +201,8 @@ func (k *KubeDeploymentConfig) IsNative() bool {
 func IsKube(dep map[string]interface{}) bool {
 	if _, ok := dep["operatorYamlArchive"]; ok {
 		return true
+	} else if _, ok := dep["workload"]; ok {
+		return true
 	}
 	return false
 }
@@ -0,0 +1,91 @@
+// +build unit
+
+package persistence
+
+import (
+	"testing"
+)
+
+func Test_DecodeKubeWorkloadDeployment(t *testing.T) {
+
+	dep := `{"workload":{"namespace":"weather","services":{"collector":{"image":"myorg/collector:1.2.0","replicas":2,"environment":["LOG_LEVEL=info"],"ports":[{"port":8080},{"port":5353,"protocol":"udp"}]}}}}`
+
+	kd, err := GetKubeDeployment(dep)
+	if err != nil {
+		t.Fatalf("unexpected error extracting %v, error: %v", dep, err)
+	} else if !kd.IsWorkload() {
+		t.Errorf("expected a workload, got %v", kd.ToString())
+	} else if svc := kd.Workload.Services["collector"]; svc == nil || svc.Replicas != 2 || len(svc.Ports) != 2 || svc.Ports[1].Protocol != "udp" {
+		t.Errorf("workload not as expected: %v", kd.Workload)
+	}
+
+	// the workload survives the persistent form
+	if pf, err := kd.ToPersistentForm(); err != nil {
+		t.Errorf("unexpected error changing to persistent form: %v", err)
+	} else if !IsKube(pf) {
+		t.Errorf("persistent form %v is not recognized as a kube deployment", pf)
+	} else if _, hasArchive := pf["operatorYamlArchive"]; hasArchive {
+		t.Errorf("persistent form %v should not have an operator archive", pf)
+	} else {
+		newKD := new(KubeDeploymentConfig)
+		if err := newKD.FromPersistentForm(pf); err != nil {
+			t.Errorf("unexpected error changing from persistent form: %v", err)
+		} else if !newKD.IsWorkload() || newKD.Workload.Namespace != "weather" || newKD.Workload.Services["collector"].Image != "myorg/collector:1.2.0" {
+			t.Errorf("workload %v does not match the original %v", newKD.ToString(), kd.ToString())
+		}
+	}
+}
+
+func Test_DecodeKubeWorkloadDeployment_errors(t *testing.T) {
+
+	tests := map[string]string{
+		"neither form":      `{"test":"nope"}`,
+		"both forms":        `{"operatorYamlArchive":"abc","workload":{"services":{"a":{"image":"a"}}}}`,
+		"no services":       `{"workload":{"services":{}}}`,
+		"bad service name":  `{"workload":{"services":{"My_Service":{"image":"a"}}}}`,
+		"bad namespace":     `{"workload":{"namespace":"Weather","services":{"a":{"image":"a"}}}}`,
+		"no image":          `{"workload":{"services":{"a":{"replicas":1}}}}`,
+		"negative replicas": `{"workload":{"services":{"a":{"image":"a","replicas":-1}}}}`,
+		"bad environment":   `{"workload":{"services":{"a":{"image":"a","environment":["LOG_LEVEL"]}}}}`,
+		"port out of range": `{"workload":{"services":{"a":{"image":"a","ports":[{"port":70000}]}}}}`,
+		"unknown protocol":  `{"workload":{"services":{"a":{"image":"a","ports":[{"port":80,"protocol":"http"}]}}}}`,
+	}
+
+	for name, dep := range tests {
+		if kd, err := GetKubeDeployment(dep); err == nil {
+			t.Errorf("%v: expected an error for %v, got %v", name, dep, kd.ToString())
+		}
+	}
+}
+
+func Test_DecodeKubeOperatorDeployment(t *testing.T) {
+
+	kd, err := GetKubeDeployment(`{"operatorYamlArchive":"H4sIAAAA"}`)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if kd.IsWorkload() || kd.OperatorYamlArchive != "H4sIAAAA" {
+		t.Errorf("operator deployment not as expected: %v", kd.ToString())
+	}
+}
+
+func Test_KubeWorkloadResourceLimits(t *testing.T) {
+
+	w := &KubeWorkload{Services: map[string]*KubeWorkloadService{
+		"collector": {Image: "myorg/collector:1.2.0", Replicas: 2, MaxCPUs: 0.5, MaxMemoryMb: 128},
+		"uploader":  {Image: "myorg/uploader:1.0.0"},
+	}}
+
+	if cpus, memoryMb := w.ResourceLimits(0.25, 64); cpus != 1.25 || memoryMb != 320 {
+		t.Errorf("expected 1.25 cpus and 320 MB, got %v cpus and %v MB", cpus, memoryMb)
+	}
+	if cpus, memoryMb := w.ResourceLimits(0, 0); cpus != 1 || memoryMb != 256 {
+		t.Errorf("expected 1 cpu and 256 MB without defaults, got %v cpus and %v MB", cpus, memoryMb)
+	}
+
+	if cpus, memory := w.UnboundedServices(0.25, 64); len(cpus) != 0 || len(memory) != 0 {
+		t.Errorf("no service should be unbounded with the defaults, got %v and %v", cpus, memory)
+	}
+	if cpus, memory := w.UnboundedServices(0, 64); len(cpus) != 1 || cpus[0] != "uploader" || len(memory) != 0 {
+		t.Errorf("the uploader should have no cpu limit, got %v and %v", cpus, memory)
+	}
+}
@@ -0,0 +1,216 @@
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"time"
+)
+
+// The bucket names in the bolt DB.
+const MAINTENANCE_SCHEDULE = "maintenance_schedule"
+const DEFERRED_ACTIONS = "deferred_actions"
+
+// The kinds of disruptive actions that wait for a maintenance window.
+const (
+	DEFERRED_SERVICE_UPGRADE  = "service_upgrade"  // the upgrade of a service, keyed by the id of its service definition
+	DEFERRED_RENEGOTIATION    = "renegotiation"    // the cancellation of an agreement so that it is made again, keyed by agreement id
+	DEFERRED_CANARY_PROMOTION = "canary_promotion" // the promotion of a workload version whose canaries soaked, keyed by org/url/version
+)
+
+// The windows in which the node runs disruptive actions: service upgrades, the renegotiation of agreements after a
+// change of the node and the promotion of workload canaries. Outside of them these actions are deferred until the
+// next window. Actions that repair a broken workload, e.g. restarting a crashed container, are never deferred.
+type MaintenanceSchedule struct {
+	Windows       []string `json:"windows"`                  // Weekly windows, e.g. "02:00-04:00" every day or "Sat,Sun 22:00-02:00".
+	Timezone      string   `json:"timezone,omitempty"`       // The IANA timezone of the windows, e.g. Europe/Paris. Empty means the local time of the node.
+	OverrideUntil uint64   `json:"override_until,omitempty"` // An ad-hoc window opened by an operator, open until this time.
+}
+
+func (m MaintenanceSchedule) String() string {
+	return fmt.Sprintf("Windows: %v, Timezone: %v, OverrideUntil: %v", m.Windows, m.Timezone, m.OverrideUntil)
+}
+
+// Verify that the windows and the timezone can be used.
+func (m MaintenanceSchedule) Validate() error {
+	if len(m.Windows) == 0 {
+		return fmt.Errorf("at least one maintenance window is required, delete the schedule to allow disruptive actions at any time")
+	}
+	for _, window := range m.Windows {
+		if window == "" {
+			return fmt.Errorf("a maintenance window must not be empty")
+		} else if _, err := config.ParseMaintenanceWindow(window); err != nil {
+			return err
+		}
+	}
+	if _, err := m.location(); err != nil {
+		return fmt.Errorf("timezone %v is not known, error %v", m.Timezone, err)
+	}
+	return nil
+}
+
+// Returns the timezone of the windows. LoadLocation would return UTC for an empty name.
+func (m *MaintenanceSchedule) location() (*time.Location, error) {
+	if m.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(m.Timezone)
+}
+
+// Returns true if the time is in one of the windows or in the window opened by an operator. The schedule was
+// validated when it was saved, a window that cannot be parsed anymore is skipped.
+func (m *MaintenanceSchedule) InWindow(t time.Time) bool {
+	if m == nil || uint64(t.Unix()) < m.OverrideUntil {
+		return true
+	}
+
+	loc, err := m.location()
+	if err != nil {
+		glog.Errorf("Unable to load timezone %v of the maintenance schedule, using the local time: %v", m.Timezone, err)
+		loc = time.Local
+	}
+	local := t.In(loc)
+
+	for _, window := range m.Windows {
+		if w, err := config.ParseMaintenanceWindow(window); err == nil && w != nil && w.Contains(local) {
+			return true
+		}
+	}
+	return false
+}
+
+// Retrieve the maintenance schedule from the database, nil if it has not been set.
+func FindMaintenanceSchedule(db *bolt.DB) (*MaintenanceSchedule, error) {
+	var schedule *MaintenanceSchedule
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(MAINTENANCE_SCHEDULE)); b != nil {
+			if v := b.Get([]byte(MAINTENANCE_SCHEDULE)); v != nil {
+				var ms MaintenanceSchedule
+				if err := json.Unmarshal(v, &ms); err != nil {
+					return fmt.Errorf("Unable to deserialize maintenance schedule record: %v", v)
+				}
+				schedule = &ms
+			}
+		}
+		return nil // end transaction
+	})
+
+	return schedule, readErr
+}
+
+// There is only 1 object in the bucket so we can use the bucket name as the object key.
+func SaveMaintenanceSchedule(db *bolt.DB, schedule *MaintenanceSchedule) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(MAINTENANCE_SCHEDULE)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(schedule); err != nil {
+			return fmt.Errorf("Failed to serialize maintenance schedule: %v. Error: %v", schedule, err)
+		} else {
+			return b.Put([]byte(MAINTENANCE_SCHEDULE), serial)
+		}
+	})
+}
+
+// Remove the maintenance schedule from the local database, disruptive actions are run at any time again.
+func DeleteMaintenanceSchedule(db *bolt.DB) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(MAINTENANCE_SCHEDULE)); b != nil {
+			return b.Delete([]byte(MAINTENANCE_SCHEDULE))
+		}
+		return nil
+	})
+}
+
+// A disruptive action that waits for the next maintenance window, keyed by its kind and key.
+type DeferredAction struct {
+	Kind         string `json:"kind"`
+	Key          string `json:"key"`
+	Description  string `json:"description"`
+	Reason       string `json:"reason,omitempty"`     // The termination reason of a renegotiation.
+	EventCode    string `json:"event_code,omitempty"` // The event code that the cancellation of a renegotiation is logged with.
+	DeferredTime uint64 `json:"deferred_time"`
+}
+
+func (d DeferredAction) String() string {
+	return fmt.Sprintf("Kind: %v, Key: %v, Description: %v, Reason: %v, EventCode: %v, DeferredTime: %v", d.Kind, d.Key, d.Description, d.Reason, d.EventCode, d.DeferredTime)
+}
+
+func deferredActionKey(kind string, key string) string {
+	return fmt.Sprintf("%v/%v", kind, key)
+}
+
+// save the DeferredAction record into db.
+func SaveDeferredAction(db *bolt.DB, action *DeferredAction) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if bucket, err := tx.CreateBucketIfNotExists([]byte(DEFERRED_ACTIONS)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(*action); err != nil {
+			return fmt.Errorf("Failed to serialize the deferred action object: %v. Error: %v", *action, err)
+		} else {
+			return bucket.Put([]byte(deferredActionKey(action.Kind, action.Key)), serial)
+		}
+	})
+}
+
+// delete the DeferredAction record of the given kind and key from the db.
+func DeleteDeferredAction(db *bolt.DB, kind string, key string) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(DEFERRED_ACTIONS)); b != nil {
+			return b.Delete([]byte(deferredActionKey(kind, key)))
+		}
+		return nil
+	})
+}
+
+// find the deferred action of the given kind and key, nil if there is none.
+func FindDeferredAction(db *bolt.DB, kind string, key string) (*DeferredAction, error) {
+	var action *DeferredAction
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(DEFERRED_ACTIONS)); b != nil {
+			if v := b.Get([]byte(deferredActionKey(kind, key))); v != nil {
+				var da DeferredAction
+				if err := json.Unmarshal(v, &da); err != nil {
+					return fmt.Errorf("Unable to deserialize DeferredAction db record: %v. Error: %v", v, err)
+				}
+				action = &da
+			}
+		}
+		return nil
+	})
+
+	return action, readErr
+}
+
+// find all the deferred action records in the db.
+func FindDeferredActions(db *bolt.DB) ([]DeferredAction, error) {
+	das := make([]DeferredAction, 0)
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+
+		if b := tx.Bucket([]byte(DEFERRED_ACTIONS)); b != nil {
+			b.ForEach(func(k, v []byte) error {
+
+				var da DeferredAction
+
+				if err := json.Unmarshal(v, &da); err != nil {
+					glog.Errorf("Unable to deserialize DeferredAction db record: %v. Error: %v", v, err)
+				} else {
+					das = append(das, da)
+				}
+				return nil
+			})
+		}
+
+		return nil // end the transaction
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	} else {
+		return das, nil
+	}
+}
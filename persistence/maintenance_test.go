@@ -0,0 +1,71 @@
+// +build unit
+
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+// Verify that the windows and the timezone of a schedule are checked.
+func Test_MaintenanceScheduleValidate(t *testing.T) {
+
+	for _, s := range []MaintenanceSchedule{
+		{Windows: []string{"02:00-04:00"}},
+		{Windows: []string{"Sat,Sun 22:00-02:00", "Wed 12:00-13:00"}, Timezone: "Europe/Paris"},
+		{Windows: []string{"02:00-04:00"}, Timezone: "UTC"},
+	} {
+		if err := s.Validate(); err != nil {
+			t.Errorf("schedule %v should be valid, error %v", s, err)
+		}
+	}
+
+	for _, s := range []MaintenanceSchedule{
+		{},
+		{Windows: []string{""}},
+		{Windows: []string{"02:00"}},
+		{Windows: []string{"Someday 02:00-04:00"}},
+		{Windows: []string{"02:00-04:00"}, Timezone: "Nowhere/Atlantis"},
+	} {
+		if err := s.Validate(); err == nil {
+			t.Errorf("schedule %v should not be valid", s)
+		}
+	}
+}
+
+// Verify that the windows are in the timezone of the schedule, and that an override opens a window.
+func Test_MaintenanceScheduleInWindow(t *testing.T) {
+
+	var none *MaintenanceSchedule
+	if !none.InWindow(time.Now()) {
+		t.Errorf("a node without a schedule should always be in a window")
+	}
+
+	// 2021-01-02 is a Saturday, 22:30 in New York is 03:30 the next day in UTC
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("the timezone database is not available: %v", err)
+	}
+	saturdayNight := time.Date(2021, 1, 2, 22, 30, 0, 0, ny)
+
+	s := &MaintenanceSchedule{Windows: []string{"Sat 22:00-23:00"}, Timezone: "America/New_York"}
+	if !s.InWindow(saturdayNight) {
+		t.Errorf("%v should be in the window of %v", saturdayNight, s)
+	} else if s.InWindow(saturdayNight.Add(time.Hour)) {
+		t.Errorf("%v should not be in the window of %v", saturdayNight.Add(time.Hour), s)
+	}
+
+	s = &MaintenanceSchedule{Windows: []string{"Sat 22:00-23:00"}, Timezone: "UTC"}
+	if s.InWindow(saturdayNight) {
+		t.Errorf("%v should not be in the window of %v", saturdayNight, s)
+	} else if !s.InWindow(saturdayNight.Add(-5 * time.Hour)) {
+		t.Errorf("%v should be in the window of %v", saturdayNight.Add(-5*time.Hour), s)
+	}
+
+	s.OverrideUntil = uint64(saturdayNight.Add(time.Minute).Unix())
+	if !s.InWindow(saturdayNight) {
+		t.Errorf("%v should be in the window opened until %v", saturdayNight, s.OverrideUntil)
+	} else if s.InWindow(saturdayNight.Add(2 * time.Minute)) {
+		t.Errorf("%v should not be in the window opened until %v", saturdayNight.Add(2*time.Minute), s.OverrideUntil)
+	}
+}
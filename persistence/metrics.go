@@ -0,0 +1,57 @@
+package persistence
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/metrics"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// The duration of the database transactions, by the function of this package that made them.
+var dbTransactionDuration = metrics.NewHistogramVec("anax_db_transaction_duration_seconds",
+	"The duration of the bolt database transactions, by operation (read or write) and function.",
+	metrics.DefaultDurationBuckets, "op", "function")
+
+// Runs fn in a read-only transaction, as db.View does, and records how long it took.
+func timedView(db *bolt.DB, fn func(*bolt.Tx) error) error {
+	if !metrics.Enabled() {
+		return db.View(fn)
+	}
+	start := time.Now()
+	err := db.View(fn)
+	dbTransactionDuration.ObserveSince(start, "read", callerName())
+	return err
+}
+
+// Runs fn in a read-write transaction, as db.Update does, and records how long it took.
+func timedUpdate(db *bolt.DB, fn func(*bolt.Tx) error) error {
+	if !metrics.Enabled() {
+		return db.Update(fn)
+	}
+	start := time.Now()
+	err := db.Update(fn)
+	dbTransactionDuration.ObserveSince(start, "write", callerName())
+	return err
+}
+
+// Returns the name of the function that called timedView or timedUpdate, without the package path, e.g.
+// FindExchangeDevice or (*ExchangeDevice).SetPattern.
+func callerName() string {
+	pc, _, _, ok := runtime.Caller(2)
+	if !ok {
+		return "unknown"
+	}
+	f := runtime.FuncForPC(pc)
+	if f == nil {
+		return "unknown"
+	}
+	name := f.Name()
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, "."); i != -1 {
+		name = name[i+1:]
+	}
+	return name
+}
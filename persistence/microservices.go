@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/boltdb/bolt"
 	"github.com/golang/glog"
+	"github.com/open-horizon/anax/containermessage"
 	"github.com/open-horizon/anax/cutil"
 	"github.com/satori/go.uuid"
 	"strconv"
@@ -100,6 +101,16 @@ type MicroserviceDefinition struct {
 	UpgradeNewMsId               string               `json:"upgrade_new_ms_id"`
 	MetadataHash                 []byte               `json:"metadata_hash"` // the hash of the whole exchange.MicroserviceDefinition
 
+	// the user input layer that set each variable of the service when it was configured, see policy.MergeUserInputLayers
+	VariableSources map[string]string `json:"variable_sources,omitempty"`
+
+	// how the service came to exist, autoconfig, user or import, and the pattern the autoconfig configured it from.
+	// Empty for the services configured before it was recorded.
+	Origin        string `json:"origin,omitempty"`
+	OriginPattern string `json:"origin_pattern,omitempty"`
+
+	// incremented each time the record is updated, for the ETag of the API
+	Revision uint64 `json:"revision"`
 }
 
 func (w MicroserviceDefinition) String() string {
@@ -138,13 +149,17 @@ func (w MicroserviceDefinition) String() string {
 		"UngradeFailureReason: %v, "+
 		"UngradeFailureDescription: %v, "+
 		"UpgradeNewMsId: %v, "+
-		"MetadataHash: %v",
+		"MetadataHash: %v, "+
+		"VariableSources: %v, "+
+		"Origin: %v, "+
+		"OriginPattern: %v, "+
+		"Revision: %v",
 		w.Id, w.Owner, w.Label, w.Description, w.SpecRef, w.Org, w.Version, w.Arch, w.Sharable, w.DownloadURL,
 		w.MatchHardware, w.UserInputs, w.Workloads, w.Public, w.RequiredServices,
 		w.Deployment, w.DeploymentSignature, w.ClusterDeployment, w.ClusterDeploymentSignature, w.LastUpdated,
 		w.Archived, w.Name, w.RequestedArch, w.UpgradeVersionRange, w.AutoUpgrade, w.ActiveUpgrade,
 		w.UpgradeStartTime, w.UpgradeMsUnregisteredTime, w.UpgradeAgreementsClearedTime, w.UpgradeExecutionStartTime, w.UpgradeMsReregisteredTime,
-		w.UpgradeFailedTime, w.UngradeFailureReason, w.UngradeFailureDescription, w.UpgradeNewMsId, w.MetadataHash)
+		w.UpgradeFailedTime, w.UngradeFailureReason, w.UngradeFailureDescription, w.UpgradeNewMsId, w.MetadataHash, w.VariableSources, w.Origin, w.OriginPattern, w.Revision)
 }
 
 func (w MicroserviceDefinition) ShortString() string {
@@ -221,7 +236,7 @@ func (m *MicroserviceDefinition) HasRequiredServices() bool {
 
 // save the microservice record. update if it already exists in the db
 func SaveOrUpdateMicroserviceDef(db *bolt.DB, msdef *MicroserviceDefinition) error {
-	writeErr := db.Update(func(tx *bolt.Tx) error {
+	writeErr := timedUpdate(db, func(tx *bolt.Tx) error {
 		if bucket, err := tx.CreateBucketIfNotExists([]byte(MICROSERVICE_DEFINITIONS)); err != nil {
 			return err
 		} else if nextKey, err := bucket.NextSequence(); err != nil {
@@ -229,6 +244,7 @@ func SaveOrUpdateMicroserviceDef(db *bolt.DB, msdef *MicroserviceDefinition) err
 		} else {
 			strKey := strconv.FormatUint(nextKey, 10)
 			msdef.Id = strKey
+			msdef.Revision = 1
 
 			glog.V(5).Infof("saving service definition %v to db", *msdef)
 
@@ -254,7 +270,7 @@ func FindMicroserviceDefWithKey(db *bolt.DB, key string) (*MicroserviceDefinitio
 	pms = nil
 
 	// fetch microservice definitions
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 
 		if b := tx.Bucket([]byte(MICROSERVICE_DEFINITIONS)); b != nil {
 			v := b.Get([]byte(key))
@@ -301,20 +317,20 @@ func ArchivedMSFilter() MSFilter {
 // filter on the url + version + org
 func UrlOrgVersionMSFilter(spec_url string, org string, version string) MSFilter {
 	return func(e MicroserviceDefinition) bool {
-		return (e.SpecRef == spec_url && e.Org == org && e.Version == version)
+		return (cutil.SameSpecURL(e.SpecRef, spec_url) && e.Org == org && e.Version == version)
 	}
 }
 
 // filter on the url + + org
 func UrlOrgMSFilter(spec_url string, org string) MSFilter {
 	return func(e MicroserviceDefinition) bool {
-		return (e.SpecRef == spec_url && e.Org == org)
+		return (cutil.SameSpecURL(e.SpecRef, spec_url) && e.Org == org)
 	}
 }
 
 // filter for all the microservice defs for the given url
 func UrlMSFilter(spec_url string) MSFilter {
-	return func(e MicroserviceDefinition) bool { return (e.SpecRef == spec_url) }
+	return func(e MicroserviceDefinition) bool { return cutil.SameSpecURL(e.SpecRef, spec_url) }
 }
 
 // find the microservice instance from the db
@@ -322,7 +338,7 @@ func FindMicroserviceDefs(db *bolt.DB, filters []MSFilter) ([]MicroserviceDefini
 	ms_defs := make([]MicroserviceDefinition, 0)
 
 	// fetch contracts
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 
 		if b := tx.Bucket([]byte(MICROSERVICE_DEFINITIONS)); b != nil {
 			b.ForEach(func(k, v []byte) error {
@@ -447,7 +463,7 @@ func microserviceDefStateUpdate(db *bolt.DB, key string, fn func(MicroserviceDef
 
 // does whole-member replacements of values that are legal to change
 func persistUpdatedMicroserviceDef(db *bolt.DB, key string, update *MicroserviceDefinition) error {
-	return db.Update(func(tx *bolt.Tx) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
 		if b, err := tx.CreateBucketIfNotExists([]byte(MICROSERVICE_DEFINITIONS)); err != nil {
 			return err
 		} else {
@@ -500,6 +516,8 @@ func persistUpdatedMicroserviceDef(db *bolt.DB, key string, update *Microservice
 					mod.UpgradeVersionRange = update.UpgradeVersionRange
 				}
 
+				mod.Revision++
+
 				if serialized, err := json.Marshal(mod); err != nil {
 					return fmt.Errorf("Failed to serialize contract record: %v. Error: %v", mod, err)
 				} else if err := b.Put([]byte(key), serialized); err != nil {
@@ -518,26 +536,34 @@ func persistUpdatedMicroserviceDef(db *bolt.DB, key string, update *Microservice
 //
 
 type MicroserviceInstance struct {
-	SpecRef              string                         `json:"ref_url"`
-	Org                  string                         `json:"organization"`
-	Version              string                         `json:"version"`
-	Arch                 string                         `json:"arch"`
-	InstanceId           string                         `json:"instance_id"`
-	Archived             bool                           `json:"archived"`
-	InstanceCreationTime uint64                         `json:"instance_creation_time"`
-	ExecutionStartTime   uint64                         `json:"execution_start_time"`
-	ExecutionFailureCode uint                           `json:"execution_failure_code"`
-	ExecutionFailureDesc string                         `json:"execution_failure_desc"`
-	CleanupStartTime     uint64                         `json:"cleanup_start_time"`
-	AssociatedAgreements []string                       `json:"associated_agreements"`
-	MicroserviceDefId    string                         `json:"microservicedef_id"`
-	ParentPath           [][]ServiceInstancePathElement `json:"service_instance_path"` // Set when instance is created
-	AgreementLess        bool                           `json:"agreement_less"`        // Set when the service instance was started because it is an agreement-less service (as defined in the pattern)
-	MaxRetries           uint                           `json:"max_retries"`           // maximum retries allowed
-	MaxRetryDuration     uint                           `json:"max_retry_duration"`    // The number of seconds in which the specified number of retries must occur in order for next retry cycle.
-	CurrentRetryCount    uint                           `json:"current_retry_count"`
-	RetryStartTime       uint64                         `json:"retry_start_time"`
-	EnvVars              map[string]string              `json:"env_vars"`
+	SpecRef              string                                 `json:"ref_url"`
+	Org                  string                                 `json:"organization"`
+	Version              string                                 `json:"version"`
+	Arch                 string                                 `json:"arch"`
+	InstanceId           string                                 `json:"instance_id"`
+	Archived             bool                                   `json:"archived"`
+	InstanceCreationTime uint64                                 `json:"instance_creation_time"`
+	ExecutionStartTime   uint64                                 `json:"execution_start_time"`
+	ExecutionFailureCode uint                                   `json:"execution_failure_code"`
+	ExecutionFailureDesc string                                 `json:"execution_failure_desc"`
+	CleanupStartTime     uint64                                 `json:"cleanup_start_time"`
+	AssociatedAgreements []string                               `json:"associated_agreements"`
+	MicroserviceDefId    string                                 `json:"microservicedef_id"`
+	ParentPath           [][]ServiceInstancePathElement         `json:"service_instance_path"` // Set when instance is created
+	AgreementLess        bool                                   `json:"agreement_less"`        // Set when the service instance was started because it is an agreement-less service (as defined in the pattern)
+	MaxRetries           uint                                   `json:"max_retries"`           // maximum retries allowed
+	MaxRetryDuration     uint                                   `json:"max_retry_duration"`    // The number of seconds in which the specified number of retries must occur in order for next retry cycle.
+	CurrentRetryCount    uint                                   `json:"current_retry_count"`
+	RetryStartTime       uint64                                 `json:"retry_start_time"`
+	EnvVars              map[string]string                      `json:"env_vars"`
+	ImageDigests         map[string]string                      `json:"image_digests,omitempty"`    // The image digest that each container of the service was pinned to, keyed by container name.
+	RestartPolicy        string                                 `json:"restart_policy,omitempty"`   // The restart policy that was applied when the service last failed.
+	RetryBackoffS        uint                                   `json:"retry_backoff_s"`            // The number of seconds waited before the pending or last restart.
+	NextRetryTime        uint64                                 `json:"next_retry_time"`            // The time of the pending restart, zero if no restart is pending.
+	Mounts               map[string][]string                    `json:"mounts,omitempty"`           // The host paths bound into each container of the service, after the host access allow list was applied.
+	CPUPinning           map[string]containermessage.CPUPinning `json:"cpu_pinning,omitempty"`      // The cpus each pinned container of the service is pinned to.
+	ContextEnvVars       map[string]string                      `json:"context_env_vars,omitempty"` // The node context env vars that were injected into the service containers.
+	PublishedPorts       map[string][]string                    `json:"published_ports,omitempty"`  // The host ports each container of the service publishes, e.g. 0.0.0.0:8080->80/tcp.
 }
 
 func (w MicroserviceInstance) String() string {
@@ -560,11 +586,20 @@ func (w MicroserviceInstance) String() string {
 		"MaxRetryDuration: %v, "+
 		"CurrentRetryCount: %v, "+
 		"RetryStartTime: %v, "+
-		"EnvVars: %v",
+		"EnvVars: %v, "+
+		"ImageDigests: %v, "+
+		"RestartPolicy: %v, "+
+		"RetryBackoffS: %v, "+
+		"NextRetryTime: %v, "+
+		"Mounts: %v, "+
+		"CPUPinning: %v, "+
+		"ContextEnvVars: %v, "+
+		"PublishedPorts: %v",
 		w.SpecRef, w.Org, w.Version, w.Arch, w.InstanceId, w.Archived, w.InstanceCreationTime,
 		w.ExecutionStartTime, w.ExecutionFailureCode, w.ExecutionFailureDesc,
 		w.CleanupStartTime, w.AssociatedAgreements, w.MicroserviceDefId, w.ParentPath, w.AgreementLess,
-		w.MaxRetries, w.MaxRetryDuration, w.CurrentRetryCount, w.RetryStartTime, w.EnvVars)
+		w.MaxRetries, w.MaxRetryDuration, w.CurrentRetryCount, w.RetryStartTime, w.EnvVars, w.ImageDigests,
+		w.RestartPolicy, w.RetryBackoffS, w.NextRetryTime, w.Mounts, w.CPUPinning, w.ContextEnvVars, w.PublishedPorts)
 }
 
 // create a unique name for a microservice def
@@ -623,8 +658,9 @@ func (m *MicroserviceInstance) GetDirectParents() []ServiceInstancePathElement {
 	return parents
 }
 
-// create a new microservice instance and save it to db.
-func NewMicroserviceInstance(db *bolt.DB, ref_url string, org string, version string, msdef_id string, dependencyPath []ServiceInstancePathElement) (*MicroserviceInstance, error) {
+// create a new microservice instance and save it to db. The instance has the architecture of its service definition,
+// which is not the node's when it is one of the AdditionalArchs of the config, the node's architecture when arch is empty.
+func NewMicroserviceInstance(db *bolt.DB, ref_url string, org string, version string, arch string, msdef_id string, dependencyPath []ServiceInstancePathElement) (*MicroserviceInstance, error) {
 
 	if ref_url == "" || org == "" || version == "" {
 		return nil, errors.New("Microservice ref url id, org or version is empty, cannot persist")
@@ -641,11 +677,15 @@ func NewMicroserviceInstance(db *bolt.DB, ref_url string, org string, version st
 		return nil, fmt.Errorf("Not expecting any records with Org %v, SpecRef %v, version %v and instance id %v, found %v", org, ref_url, version, instance_id, ms_instance)
 	}
 
+	if arch == "" {
+		arch = cutil.ArchString()
+	}
+
 	new_inst := &MicroserviceInstance{
 		SpecRef:              ref_url,
 		Org:                  org,
 		Version:              version,
-		Arch:                 cutil.ArchString(),
+		Arch:                 cutil.CanonicalArch(arch),
 		InstanceId:           instance_id,
 		Archived:             false,
 		InstanceCreationTime: uint64(time.Now().Unix()),
@@ -692,7 +732,7 @@ func FindMicroserviceInstance(db *bolt.DB, url string, org string, version strin
 	pms = nil
 
 	// fetch microservice instances
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 
 		if b := tx.Bucket([]byte(MICROSERVICE_INSTANCES)); b != nil {
 			b.ForEach(func(k, v []byte) error {
@@ -701,7 +741,7 @@ func FindMicroserviceInstance(db *bolt.DB, url string, org string, version strin
 
 				if err := json.Unmarshal(v, &ms); err != nil {
 					glog.Errorf("Unable to deserialize service_instance db record: %v", v)
-				} else if ms.SpecRef == url && ms.Version == version && ms.InstanceId == instance_id {
+				} else if cutil.SameSpecURL(ms.SpecRef, url) && ms.Version == version && ms.InstanceId == instance_id {
 					// ms.Org == "" is for ms instances created by older versions
 					if ms.Org == "" || ms.Org == org {
 						pms = &ms
@@ -728,7 +768,7 @@ func FindMicroserviceInstanceWithKey(db *bolt.DB, key string) (*MicroserviceInst
 	pms = nil
 
 	// fetch microservice instances
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 
 		if b := tx.Bucket([]byte(MICROSERVICE_INSTANCES)); b != nil {
 			v := b.Get([]byte(key))
@@ -765,7 +805,7 @@ func AllMIFilter() MIFilter {
 // filter for all the microservice instances for the given url and org and version
 func AllInstancesMIFilter(spec_url string, org string, version string) MIFilter {
 	return func(e MicroserviceInstance) bool {
-		if e.SpecRef == spec_url && e.Version == version {
+		if cutil.SameSpecURL(e.SpecRef, spec_url) && e.Version == version {
 			// e.Org == "" is for ms instances created by older versions
 			if e.Org == "" || e.Org == org {
 				return true
@@ -788,7 +828,7 @@ func FindMicroserviceInstances(db *bolt.DB, filters []MIFilter) ([]MicroserviceI
 	ms_instances := make([]MicroserviceInstance, 0)
 
 	// fetch contracts
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 
 		if b := tx.Bucket([]byte(MICROSERVICE_INSTANCES)); b != nil {
 			b.ForEach(func(k, v []byte) error {
@@ -881,6 +921,41 @@ func UpdateMSInstanceEnvVars(db *bolt.DB, key string, env_vars map[string]string
 	})
 }
 
+func UpdateMSInstanceImageDigests(db *bolt.DB, key string, digests map[string]string) (*MicroserviceInstance, error) {
+	return microserviceInstanceStateUpdate(db, key, func(c MicroserviceInstance) *MicroserviceInstance {
+		c.ImageDigests = digests
+		return &c
+	})
+}
+
+func UpdateMSInstanceMounts(db *bolt.DB, key string, mounts map[string][]string) (*MicroserviceInstance, error) {
+	return microserviceInstanceStateUpdate(db, key, func(c MicroserviceInstance) *MicroserviceInstance {
+		c.Mounts = mounts
+		return &c
+	})
+}
+
+func UpdateMSInstanceCPUPinning(db *bolt.DB, key string, pinning map[string]containermessage.CPUPinning) (*MicroserviceInstance, error) {
+	return microserviceInstanceStateUpdate(db, key, func(c MicroserviceInstance) *MicroserviceInstance {
+		c.CPUPinning = pinning
+		return &c
+	})
+}
+
+func UpdateMSInstanceContextEnvVars(db *bolt.DB, key string, envvars map[string]string) (*MicroserviceInstance, error) {
+	return microserviceInstanceStateUpdate(db, key, func(c MicroserviceInstance) *MicroserviceInstance {
+		c.ContextEnvVars = envvars
+		return &c
+	})
+}
+
+func UpdateMSInstancePublishedPorts(db *bolt.DB, key string, ports map[string][]string) (*MicroserviceInstance, error) {
+	return microserviceInstanceStateUpdate(db, key, func(c MicroserviceInstance) *MicroserviceInstance {
+		c.PublishedPorts = ports
+		return &c
+	})
+}
+
 func MicroserviceInstanceCleanupStarted(db *bolt.DB, key string) (*MicroserviceInstance, error) {
 	return microserviceInstanceStateUpdate(db, key, func(c MicroserviceInstance) *MicroserviceInstance {
 		c.CleanupStartTime = uint64(time.Now().Unix())
@@ -961,11 +1036,24 @@ func UpdateMSInstanceRetryState(db *bolt.DB, key string, started bool, max_retri
 			c.MaxRetries = 0
 			c.MaxRetryDuration = 0
 			c.CurrentRetryCount = 1 // the original execution is counted as the first one.
+			c.RetryBackoffS = 0
+			c.NextRetryTime = 0
 		}
 		return &c
 	})
 }
 
+// This function is called when a restart of the failed service is scheduled, and with a zero next_retry_time when the
+// scheduled restart begins.
+func UpdateMSInstanceRestartState(db *bolt.DB, key string, restart_policy string, backoff uint, next_retry_time uint64) (*MicroserviceInstance, error) {
+	return microserviceInstanceStateUpdate(db, key, func(c MicroserviceInstance) *MicroserviceInstance {
+		c.RestartPolicy = restart_policy
+		c.RetryBackoffS = backoff
+		c.NextRetryTime = next_retry_time
+		return &c
+	})
+}
+
 func UpdateMSInstanceCurrentRetryCount(db *bolt.DB, key string, current_retry uint) (*MicroserviceInstance, error) {
 	return microserviceInstanceStateUpdate(db, key, func(c MicroserviceInstance) *MicroserviceInstance {
 		c.CurrentRetryCount = current_retry
@@ -999,7 +1087,7 @@ func microserviceInstanceStateUpdate(db *bolt.DB, key string, fn func(Microservi
 
 // does whole-member replacements of values that are legal to change
 func persistUpdatedMicroserviceInstance(db *bolt.DB, key string, update *MicroserviceInstance) error {
-	return db.Update(func(tx *bolt.Tx) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
 		if b, err := tx.CreateBucketIfNotExists([]byte(MICROSERVICE_INSTANCES)); err != nil {
 			return err
 		} else {
@@ -1030,7 +1118,16 @@ func persistUpdatedMicroserviceInstance(db *bolt.DB, key string, update *Microse
 				mod.MaxRetries = update.MaxRetries
 				mod.MaxRetryDuration = update.MaxRetryDuration
 				mod.CurrentRetryCount = update.CurrentRetryCount
+				mod.RestartPolicy = update.RestartPolicy
+				mod.RetryBackoffS = update.RetryBackoffS
+				mod.NextRetryTime = update.NextRetryTime
 				mod.EnvVars = update.EnvVars
+				mod.Mounts = update.Mounts
+				mod.CPUPinning = update.CPUPinning
+				mod.ContextEnvVars = update.ContextEnvVars
+				if len(mod.ImageDigests) == 0 { // 1 transition from empty to non-empty
+					mod.ImageDigests = update.ImageDigests
+				}
 
 				if len(mod.ParentPath) != len(update.ParentPath) {
 					mod.ParentPath = update.ParentPath
@@ -1085,7 +1182,7 @@ func DeleteMicroserviceInstance(db *bolt.DB, key string) (*MicroserviceInstance,
 		} else if ms == nil {
 			return nil, nil
 		} else {
-			return ms, db.Update(func(tx *bolt.Tx) error {
+			return ms, timedUpdate(db, func(tx *bolt.Tx) error {
 
 				if b, err := tx.CreateBucketIfNotExists([]byte(MICROSERVICE_INSTANCES)); err != nil {
 					return err
@@ -1132,7 +1229,7 @@ func NewServiceInstancePathElement(url string, org string, version string) *Serv
 }
 
 func (s *ServiceInstancePathElement) IsSame(other *ServiceInstancePathElement) bool {
-	return (s.URL == other.URL) && (s.Org == other.Org) && (s.Version == other.Version)
+	return cutil.SameSpecURL(s.URL, other.URL) && (s.Org == other.Org) && (s.Version == other.Version)
 }
 
 // create an instance
@@ -1146,7 +1243,7 @@ func createInstanceId() (string, error) {
 
 // save the given microservice instance into the db
 func saveMicroserviceInstance(db *bolt.DB, new_inst *MicroserviceInstance) (*MicroserviceInstance, error) {
-	return new_inst, db.Update(func(tx *bolt.Tx) error {
+	return new_inst, timedUpdate(db, func(tx *bolt.Tx) error {
 		if b, err := tx.CreateBucketIfNotExists([]byte(MICROSERVICE_INSTANCES)); err != nil {
 			return err
 		} else if bytes, err := json.Marshal(new_inst); err != nil {
@@ -4,6 +4,7 @@ package persistence
 
 import (
 	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/cutil"
 	"io/ioutil"
 	"os"
 	"path"
@@ -36,13 +37,36 @@ func Test_ServiceInstancePath_HasDirectParent_simple(t *testing.T) {
 
 	depPath := []ServiceInstancePathElement{*parent, *child}
 	// Create the test microservice instance to represent the child
-	if msi, err := NewMicroserviceInstance(db, childURL, childOrg, childVersion, "1234", depPath); err != nil {
+	if msi, err := NewMicroserviceInstance(db, childURL, childOrg, childVersion, "", "1234", depPath); err != nil {
 		t.Errorf("Error creating instance: %v", err)
 	} else if !msi.HasDirectParent(parent) {
 		t.Errorf("Child %v has direct parent: %v", child, depPath)
 	}
 }
 
+// The instance has the architecture of its service, the node's when it is not given.
+func Test_NewMicroserviceInstance_arch(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Errorf("Error setting up UT DB: %v", err)
+	}
+
+	defer cleanTestDir(dir)
+
+	if msi, err := NewMicroserviceInstance(db, "url1", "myorg", "1.0.0", "armhf", "1234", []ServiceInstancePathElement{}); err != nil {
+		t.Errorf("Error creating instance: %v", err)
+	} else if msi.Arch != "arm" {
+		t.Errorf("the instance should have the canonical arch of its service, has %v", msi.Arch)
+	}
+
+	if msi, err := NewMicroserviceInstance(db, "url2", "myorg", "1.0.0", "", "1234", []ServiceInstancePathElement{}); err != nil {
+		t.Errorf("Error creating instance: %v", err)
+	} else if msi.Arch != cutil.ArchString() {
+		t.Errorf("the instance should have the arch of the node, has %v", msi.Arch)
+	}
+}
+
 // Parent is not in child's dependency path
 func Test_ServiceInstancePath_HasDirectParent_fail1(t *testing.T) {
 
@@ -70,7 +94,7 @@ func Test_ServiceInstancePath_HasDirectParent_fail1(t *testing.T) {
 
 	depPath := []ServiceInstancePathElement{*parent, *child}
 	// Create the test microservice instance to represent the child
-	if msi, err := NewMicroserviceInstance(db, childURL, childOrg, childVersion, "1234", depPath); err != nil {
+	if msi, err := NewMicroserviceInstance(db, childURL, childOrg, childVersion, "", "1234", depPath); err != nil {
 		t.Errorf("Error creating instance: %v", err)
 	} else if msi.HasDirectParent(notParent) {
 		t.Errorf("Child %v does not have direct parent: %v", child, depPath)
@@ -106,7 +130,7 @@ func Test_ServiceInstancePath_HasDirectParent_fail2(t *testing.T) {
 
 	depPath := []ServiceInstancePathElement{*parent, *child, *child2}
 	// Create the test microservice instance to represent the child
-	if msi, err := NewMicroserviceInstance(db, child2URL, child2Org, child2Version, "1234", depPath); err != nil {
+	if msi, err := NewMicroserviceInstance(db, child2URL, child2Org, child2Version, "", "1234", depPath); err != nil {
 		t.Errorf("Error creating instance: %v", err)
 	} else if msi.HasDirectParent(parent) {
 		t.Errorf("Child %v does not have direct parent: %v", child2, depPath)
@@ -146,7 +170,7 @@ func Test_ServiceInstancePath_HasDirectParent_fail3(t *testing.T) {
 	dp2 := []ServiceInstancePathElement{*parent, *child2}
 
 	// Create the test microservice instance to represent the child
-	if msi, err := NewMicroserviceInstance(db, child2URL, child2Org, child2Version, "1234", depPath); err != nil {
+	if msi, err := NewMicroserviceInstance(db, child2URL, child2Org, child2Version, "", "1234", depPath); err != nil {
 		t.Errorf("Error creating instance: %v", err)
 	} else if _, err := UpdateMSInstanceAddDependencyPath(db, msi.GetKey(), &dp2); err != nil {
 		t.Errorf("Error updating instance: %v", err)
@@ -186,7 +210,7 @@ func Test_ServiceInstancePath_HasDirectParent_second(t *testing.T) {
 	dp2 := []ServiceInstancePathElement{*parent, *child2}
 
 	// Create the test microservice instance to represent the child
-	if msi, err := NewMicroserviceInstance(db, child2URL, child2Org, child2Version, "1234", depPath); err != nil {
+	if msi, err := NewMicroserviceInstance(db, child2URL, child2Org, child2Version, "", "1234", depPath); err != nil {
 		t.Errorf("Error creating instance: %v", err)
 	} else if newmsi, err := UpdateMSInstanceAddDependencyPath(db, msi.GetKey(), &dp2); err != nil {
 		t.Errorf("Error updating instance: %v", err)
@@ -267,7 +291,7 @@ func Test_UpdateMSInstanceAddDependencyPath(t *testing.T) {
 	dep2 := []ServiceInstancePathElement{*parent, *child}
 
 	// Create the test microservice instance to represent the child
-	if msi, err := NewMicroserviceInstance(db, "child2UR", "childorg2", "2.0.0", "1234", dep1); err != nil {
+	if msi, err := NewMicroserviceInstance(db, "child2UR", "childorg2", "2.0.0", "", "1234", dep1); err != nil {
 		t.Errorf("Error creating instance: %v", err)
 	} else if newmsi, err := UpdateMSInstanceAddDependencyPath(db, msi.GetKey(), &dep2); err != nil {
 		t.Errorf("Error updating instance: %v", err)
@@ -306,7 +330,7 @@ func Test_UpdateMSInstanceRemoveDependencyPath(t *testing.T) {
 	dep4 := []ServiceInstancePathElement{*parent3, *child, *child2}
 
 	// Create the test microservice instance to represent the child
-	if msi, err := NewMicroserviceInstance(db, "child2UR", "childorg2", "2.0.0", "1234", dep1); err != nil {
+	if msi, err := NewMicroserviceInstance(db, "child2UR", "childorg2", "2.0.0", "", "1234", dep1); err != nil {
 		t.Errorf("Error creating instance: %v", err)
 	} else if _, err := UpdateMSInstanceAddDependencyPath(db, msi.GetKey(), &dep2); err != nil {
 		t.Errorf("Error updating instance: %v", err)
@@ -350,7 +374,7 @@ func Test_UpdateMSInstanceRemoveDependencyPath2(t *testing.T) {
 	dep4 := []ServiceInstancePathElement{*parent3, *child, *child2}
 
 	// Create the test microservice instance to represent the child
-	if msi, err := NewMicroserviceInstance(db, "child2UR", "childorg2", "2.0.0", "1234", dep1); err != nil {
+	if msi, err := NewMicroserviceInstance(db, "child2UR", "childorg2", "2.0.0", "", "1234", dep1); err != nil {
 		t.Errorf("Error creating instance: %v", err)
 	} else if _, err := UpdateMSInstanceAddDependencyPath(db, msi.GetKey(), &dep2); err != nil {
 		t.Errorf("Error updating instance: %v", err)
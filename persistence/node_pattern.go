@@ -16,7 +16,7 @@ func FindSavedNodeExchPattern(db *bolt.DB) (string, error) {
 
 	pattern_name := ""
 
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 		if b := tx.Bucket([]byte(NODE_EXCH_PATTERN)); b != nil {
 			return b.ForEach(func(k, v []byte) error {
 				pattern_name = string(v)
@@ -37,7 +37,7 @@ func FindSavedNodeExchPattern(db *bolt.DB) (string, error) {
 // There is only 1 object in the bucket so we can use the bucket name as the object key.
 func SaveNodeExchPattern(db *bolt.DB, nodePatternName string) error {
 
-	writeErr := db.Update(func(tx *bolt.Tx) error {
+	writeErr := timedUpdate(db, func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte(NODE_EXCH_PATTERN))
 		if err != nil {
 			return err
@@ -59,7 +59,7 @@ func DeleteNodeExchPattern(db *bolt.DB) error {
 		return nil
 	} else {
 
-		return db.Update(func(tx *bolt.Tx) error {
+		return timedUpdate(db, func(tx *bolt.Tx) error {
 
 			if b, err := tx.CreateBucketIfNotExists([]byte(NODE_EXCH_PATTERN)); err != nil {
 				return err
@@ -17,7 +17,7 @@ func FindNodePolicy(db *bolt.DB) (*externalpolicy.ExternalPolicy, error) {
 
 	policy := make([]externalpolicy.ExternalPolicy, 0)
 
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 		if b := tx.Bucket([]byte(NODE_POLICY)); b != nil {
 			return b.ForEach(func(k, v []byte) error {
 				var pol externalpolicy.ExternalPolicy
@@ -50,7 +50,7 @@ func FindNodePolicy(db *bolt.DB) (*externalpolicy.ExternalPolicy, error) {
 // There is only 1 object in the bucket so we can use the bucket name as the object key.
 func SaveNodePolicy(db *bolt.DB, nodePolicy *externalpolicy.ExternalPolicy) error {
 
-	writeErr := db.Update(func(tx *bolt.Tx) error {
+	writeErr := timedUpdate(db, func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte(NODE_POLICY))
 		if err != nil {
 			return err
@@ -75,7 +75,7 @@ func DeleteNodePolicy(db *bolt.DB) error {
 		return nil
 	} else {
 
-		return db.Update(func(tx *bolt.Tx) error {
+		return timedUpdate(db, func(tx *bolt.Tx) error {
 
 			if b, err := tx.CreateBucketIfNotExists([]byte(NODE_POLICY)); err != nil {
 				return err
@@ -93,7 +93,7 @@ func GetNodePolicyLastUpdated_Exch(db *bolt.DB) (string, error) {
 
 	lastUpdated := ""
 
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 		if b := tx.Bucket([]byte(EXCHANGE_NP_LAST_UPDATED)); b != nil {
 			return b.ForEach(func(k, v []byte) error {
 				lastUpdated = string(v)
@@ -114,7 +114,7 @@ func GetNodePolicyLastUpdated_Exch(db *bolt.DB) (string, error) {
 // save the exchange node policy lastUpdated string.
 func SaveNodePolicyLastUpdated_Exch(db *bolt.DB, lastUpdated string) error {
 
-	writeErr := db.Update(func(tx *bolt.Tx) error {
+	writeErr := timedUpdate(db, func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte(EXCHANGE_NP_LAST_UPDATED))
 		if err != nil {
 			return err
@@ -135,7 +135,7 @@ func DeleteNodePolicyLastUpdated_Exch(db *bolt.DB) error {
 	} else if lastUpdated == "" {
 		return nil
 	} else {
-		return db.Update(func(tx *bolt.Tx) error {
+		return timedUpdate(db, func(tx *bolt.Tx) error {
 
 			if b, err := tx.CreateBucketIfNotExists([]byte(EXCHANGE_NP_LAST_UPDATED)); err != nil {
 				return err
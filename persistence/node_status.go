@@ -29,7 +29,7 @@ type ContainerStatus struct {
 func FindNodeStatus(db *bolt.DB) ([]WorkloadStatus, error) {
 	var nodeStatus []WorkloadStatus
 
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 		if b := tx.Bucket([]byte(NODE_STATUS)); b != nil {
 			return b.ForEach(func(k, v []byte) error {
 
@@ -52,7 +52,7 @@ func FindNodeStatus(db *bolt.DB) ([]WorkloadStatus, error) {
 
 // SaveNodeStatus saves the provided node status to the local db
 func SaveNodeStatus(db *bolt.DB, status []WorkloadStatus) error {
-	writeErr := db.Update(func(tx *bolt.Tx) error {
+	writeErr := timedUpdate(db, func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte(NODE_STATUS))
 		if err != nil {
 			return err
@@ -75,7 +75,7 @@ func DeleteNodeStatus(db *bolt.DB) error {
 	} else if len(seList) == 0 {
 		return nil
 	} else {
-		return db.Update(func(tx *bolt.Tx) error {
+		return timedUpdate(db, func(tx *bolt.Tx) error {
 
 			if b, err := tx.CreateBucketIfNotExists([]byte(NODE_STATUS)); err != nil {
 				return err
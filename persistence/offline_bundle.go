@@ -0,0 +1,228 @@
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+)
+
+// The bucket names in the bolt DB.
+const OFFLINE_BUNDLE = "offline_bundle"
+const OFFLINE_DEFINITIONS = "offline_definitions"
+
+// The states of the offline bundle installation.
+const OFFLINE_BUNDLE_STATE_INSTALLED = "installed" // the bundle is installed, only the registration of the node is left
+const OFFLINE_BUNDLE_STATE_FAILED = "failed"       // the bundle could not be installed, it is tried again when anax restarts
+
+// The kinds of definitions that an offline bundle holds.
+const OFFLINE_DEFINITION_SERVICE = "service"
+const OFFLINE_DEFINITION_PATTERN = "pattern"
+
+// The outcome of the installation of the offline bundle of the node.
+type OfflineBundleStatus struct {
+	Path        string   `json:"path"`
+	Digest      string   `json:"digest"` // The sha256 of the bundle manifest, the bundle is installed again when it changes.
+	State       string   `json:"state"`
+	InstallTime uint64   `json:"install_time"`
+	Images      []string `json:"images,omitempty"`   // The image files that were loaded into the container runtime.
+	Services    []string `json:"services,omitempty"` // The org/url/version/arch of the service definitions.
+	Patterns    []string `json:"patterns,omitempty"` // The org/name of the patterns.
+	UserInput   bool     `json:"user_input"`         // Whether the node user input was set from the bundle.
+	Error       string   `json:"error,omitempty"`
+}
+
+func (s OfflineBundleStatus) String() string {
+	return fmt.Sprintf("Path: %v, Digest: %v, State: %v, InstallTime: %v, Images: %v, Services: %v, Patterns: %v, UserInput: %v, Error: %v",
+		s.Path, s.Digest, s.State, s.InstallTime, s.Images, s.Services, s.Patterns, s.UserInput, s.Error)
+}
+
+// Retrieve the offline bundle status from the database, nil if no bundle was installed.
+func FindOfflineBundleStatus(db *bolt.DB) (*OfflineBundleStatus, error) {
+	var status *OfflineBundleStatus
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(OFFLINE_BUNDLE)); b != nil {
+			if v := b.Get([]byte(OFFLINE_BUNDLE)); v != nil {
+				var s OfflineBundleStatus
+				if err := json.Unmarshal(v, &s); err != nil {
+					return fmt.Errorf("Unable to deserialize offline bundle status record: %v", v)
+				}
+				status = &s
+			}
+		}
+		return nil // end transaction
+	})
+
+	return status, readErr
+}
+
+// There is only 1 object in the bucket so we can use the bucket name as the object key.
+func SaveOfflineBundleStatus(db *bolt.DB, status *OfflineBundleStatus) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(OFFLINE_BUNDLE)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(status); err != nil {
+			return fmt.Errorf("Failed to serialize offline bundle status: %v. Error: %v", status, err)
+		} else {
+			return b.Put([]byte(OFFLINE_BUNDLE), serial)
+		}
+	})
+}
+
+// A service definition or pattern from the offline bundle, in the format that hzn publishes them with, so that they
+// can be resolved without the exchange.
+type OfflineDefinition struct {
+	Kind       string          `json:"kind"`
+	Key        string          `json:"key"` // org/url/version/arch of a service, org/name of a pattern
+	Definition json.RawMessage `json:"definition"`
+}
+
+func (d OfflineDefinition) String() string {
+	return fmt.Sprintf("Kind: %v, Key: %v", d.Kind, d.Key)
+}
+
+// save the OfflineDefinition record into db, it replaces the definition of the same kind and key.
+func SaveOfflineDefinition(db *bolt.DB, def *OfflineDefinition) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if bucket, err := tx.CreateBucketIfNotExists([]byte(OFFLINE_DEFINITIONS)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(*def); err != nil {
+			return fmt.Errorf("Failed to serialize the offline definition object: %v. Error: %v", *def, err)
+		} else {
+			return bucket.Put([]byte(def.Kind+"/"+def.Key), serial)
+		}
+	})
+}
+
+// find the offline definitions of the given kind, all of them when the kind is empty.
+func FindOfflineDefinitions(db *bolt.DB, kind string) ([]OfflineDefinition, error) {
+	defs := make([]OfflineDefinition, 0)
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+
+		if b := tx.Bucket([]byte(OFFLINE_DEFINITIONS)); b != nil {
+			b.ForEach(func(k, v []byte) error {
+
+				var d OfflineDefinition
+
+				if err := json.Unmarshal(v, &d); err != nil {
+					glog.Errorf("Unable to deserialize OfflineDefinition db record: %v. Error: %v", v, err)
+				} else if kind == "" || d.Kind == kind {
+					defs = append(defs, d)
+				}
+				return nil
+			})
+		}
+
+		return nil // end the transaction
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	} else {
+		return defs, nil
+	}
+}
+
+// The bucket name in the bolt DB.
+const OFFLINE_CONFIGSTATE = "offline_configstate"
+
+// The definitions of the offline definitions directory that configured the node when it could not reach the exchange,
+// so that they are compared with the ones of the exchange once it can. The definitions are kept as they were read, by
+// org/name for a pattern and org/id for a service.
+type OfflineConfigstate struct {
+	Dir           string                     `json:"dir"`
+	Pattern       string                     `json:"pattern"`
+	ConfigureTime uint64                     `json:"configure_time"`
+	Patterns      map[string]json.RawMessage `json:"patterns"`
+	Services      map[string]json.RawMessage `json:"services"`
+	ReconcileTime uint64                     `json:"reconcile_time,omitempty"` // when they were compared with the exchange
+	Drift         []string                   `json:"drift,omitempty"`          // the keys of the ones that differ from the exchange
+}
+
+func (s OfflineConfigstate) String() string {
+	return fmt.Sprintf("Dir: %v, Pattern: %v, ConfigureTime: %v, Patterns: %v, Services: %v, ReconcileTime: %v, Drift: %v",
+		s.Dir, s.Pattern, s.ConfigureTime, len(s.Patterns), len(s.Services), s.ReconcileTime, s.Drift)
+}
+
+// Retrieve the offline configstate record from the database, nil if the node was not configured offline.
+func FindOfflineConfigstate(db *bolt.DB) (*OfflineConfigstate, error) {
+	var state *OfflineConfigstate
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(OFFLINE_CONFIGSTATE)); b != nil {
+			if v := b.Get([]byte(OFFLINE_CONFIGSTATE)); v != nil {
+				var s OfflineConfigstate
+				if err := json.Unmarshal(v, &s); err != nil {
+					return fmt.Errorf("Unable to deserialize offline configstate record: %v", v)
+				}
+				state = &s
+			}
+		}
+		return nil // end transaction
+	})
+
+	return state, readErr
+}
+
+// There is only 1 object in the bucket so we can use the bucket name as the object key.
+func SaveOfflineConfigstate(db *bolt.DB, state *OfflineConfigstate) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(OFFLINE_CONFIGSTATE)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(state); err != nil {
+			return fmt.Errorf("Failed to serialize offline configstate: %v. Error: %v", state, err)
+		} else {
+			return b.Put([]byte(OFFLINE_CONFIGSTATE), serial)
+		}
+	})
+}
+
+// The bucket name in the bolt DB.
+const OFFLINE_REGISTRATION = "offline_registration"
+
+// The registration of a node that could not reach the exchange, so that the node is set up with the exchange once it
+// can: its arch, node policy and user input are then sent to the exchange.
+type OfflineRegistration struct {
+	Dir          string `json:"dir"`
+	RegisterTime uint64 `json:"register_time"`
+	SetupTime    uint64 `json:"setup_time,omitempty"` // when the node was set up with the exchange
+}
+
+func (r OfflineRegistration) String() string {
+	return fmt.Sprintf("Dir: %v, RegisterTime: %v, SetupTime: %v", r.Dir, r.RegisterTime, r.SetupTime)
+}
+
+// Retrieve the offline registration record from the database, nil if the node was not registered offline.
+func FindOfflineRegistration(db *bolt.DB) (*OfflineRegistration, error) {
+	var reg *OfflineRegistration
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(OFFLINE_REGISTRATION)); b != nil {
+			if v := b.Get([]byte(OFFLINE_REGISTRATION)); v != nil {
+				var r OfflineRegistration
+				if err := json.Unmarshal(v, &r); err != nil {
+					return fmt.Errorf("Unable to deserialize offline registration record: %v", v)
+				}
+				reg = &r
+			}
+		}
+		return nil // end transaction
+	})
+
+	return reg, readErr
+}
+
+// There is only 1 object in the bucket so we can use the bucket name as the object key.
+func SaveOfflineRegistration(db *bolt.DB, reg *OfflineRegistration) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(OFFLINE_REGISTRATION)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(reg); err != nil {
+			return fmt.Errorf("Failed to serialize offline registration: %v. Error: %v", reg, err)
+		} else {
+			return b.Put([]byte(OFFLINE_REGISTRATION), serial)
+		}
+	})
+}
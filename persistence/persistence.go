@@ -84,6 +84,12 @@ type EstablishedAgreement struct {
 	BlockchainOrg                   string                   `json:"blockchain_org,omitempty"`        // the org of the blockchain instance
 	RunningWorkload                 WorkloadInfo             `json:"workload_to_run,omitempty"`       // For display purposes, a copy of the workload info that this agreement is managing. It should be the same info that is buried inside the proposal.
 	AgreementTimeout                uint64                   `json:"agreement_timeout"`
+	NetworkSubnet                   string                   `json:"network_subnet,omitempty"`      // The subnet of the docker network created for this agreement's containers.
+	NetworkSubnetIPv6               string                   `json:"network_subnet_ipv6,omitempty"` // The IPv6 subnet of the network, when IPv6 is enabled on it.
+	ImageDigests                    map[string]string        `json:"image_digests,omitempty"`       // The image digest that each container in the deployment was pinned to, keyed by container (service) name.
+	ContextEnvVars                  map[string]string        `json:"context_env_vars,omitempty"`    // The node context env vars that were injected into the workload containers.
+	PublishedPorts                  map[string][]string      `json:"published_ports,omitempty"`     // The host ports each workload container publishes, e.g. 0.0.0.0:8080->80/tcp, keyed by container (service) name.
+	Mounts                          map[string][]string      `json:"mounts,omitempty"`              // The host paths bound into each workload container, after the host access allow list was applied.
 }
 
 func (c EstablishedAgreement) String() string {
@@ -116,13 +122,19 @@ func (c EstablishedAgreement) String() string {
 		"BlockchainName: %v, "+
 		"BlockchainOrg: %v, "+
 		"RunningWorkload: %v"+
-		"AgreementTimeout: %v",
+		"AgreementTimeout: %v, "+
+		"NetworkSubnet: %v, "+
+		"NetworkSubnetIPv6: %v, "+
+		"ImageDigests: %v, "+
+		"ContextEnvVars: %v, "+
+		"PublishedPorts: %v, "+
+		"Mounts: %v",
 		c.Name, c.DependentServices, c.Archived, c.CurrentAgreementId, c.ConsumerId, c.CounterPartyAddress, ServiceConfigNames(&c.CurrentDeployment),
 		"********", c.ProposalSig,
 		c.AgreementCreationTime, c.AgreementExecutionStartTime, c.AgreementAcceptedTime, c.AgreementBCUpdateAckTime, c.AgreementFinalizedTime,
 		c.AgreementDataReceivedTime, c.AgreementTerminatedTime, c.AgreementForceTerminatedTime, c.TerminatedReason, c.TerminatedDescription,
 		c.AgreementProtocol, c.ProtocolVersion, c.AgreementProtocolTerminatedTime, c.WorkloadTerminatedTime,
-		c.MeteringNotificationMsg, c.BlockchainType, c.BlockchainName, c.BlockchainOrg, c.RunningWorkload, c.AgreementTimeout)
+		c.MeteringNotificationMsg, c.BlockchainType, c.BlockchainName, c.BlockchainOrg, c.RunningWorkload, c.AgreementTimeout, c.NetworkSubnet, c.NetworkSubnetIPv6, c.ImageDigests, c.ContextEnvVars, c.PublishedPorts, c.Mounts)
 
 }
 
@@ -175,7 +187,7 @@ func NewEstablishedAgreement(db *bolt.DB, name string, agreementId string, consu
 		AgreementTimeout:                agreementTimeout,
 	}
 
-	return newAg, db.Update(func(tx *bolt.Tx) error {
+	return newAg, timedUpdate(db, func(tx *bolt.Tx) error {
 
 		if b, err := tx.CreateBucketIfNotExists([]byte(E_AGREEMENTS + "-" + protocol)); err != nil {
 			return err
@@ -290,6 +302,46 @@ func AgreementDeploymentStarted(db *bolt.DB, dbAgreementId string, protocol stri
 	})
 }
 
+// record the IPv4 and IPv6 subnets of the docker network that was created for the agreement
+func AgreementNetworkSubnetUpdate(db *bolt.DB, dbAgreementId string, protocol string, subnet string, subnetIPv6 string) (*EstablishedAgreement, error) {
+	return agreementStateUpdate(db, dbAgreementId, protocol, func(c EstablishedAgreement) *EstablishedAgreement {
+		c.NetworkSubnet = subnet
+		c.NetworkSubnetIPv6 = subnetIPv6
+		return &c
+	})
+}
+
+// record the image digests that the agreement's containers were pinned to
+func AgreementImageDigestsUpdate(db *bolt.DB, dbAgreementId string, protocol string, digests map[string]string) (*EstablishedAgreement, error) {
+	return agreementStateUpdate(db, dbAgreementId, protocol, func(c EstablishedAgreement) *EstablishedAgreement {
+		c.ImageDigests = digests
+		return &c
+	})
+}
+
+func AgreementContextEnvVarsUpdate(db *bolt.DB, dbAgreementId string, protocol string, envvars map[string]string) (*EstablishedAgreement, error) {
+	return agreementStateUpdate(db, dbAgreementId, protocol, func(c EstablishedAgreement) *EstablishedAgreement {
+		c.ContextEnvVars = envvars
+		return &c
+	})
+}
+
+// record the host paths that are bound into the agreement's containers
+func AgreementMountsUpdate(db *bolt.DB, dbAgreementId string, protocol string, mounts map[string][]string) (*EstablishedAgreement, error) {
+	return agreementStateUpdate(db, dbAgreementId, protocol, func(c EstablishedAgreement) *EstablishedAgreement {
+		c.Mounts = mounts
+		return &c
+	})
+}
+
+// record the host ports that the agreement's containers publish
+func AgreementPublishedPortsUpdate(db *bolt.DB, dbAgreementId string, protocol string, ports map[string][]string) (*EstablishedAgreement, error) {
+	return agreementStateUpdate(db, dbAgreementId, protocol, func(c EstablishedAgreement) *EstablishedAgreement {
+		c.PublishedPorts = ports
+		return &c
+	})
+}
+
 // set agreement state to terminated
 func AgreementStateTerminated(db *bolt.DB, dbAgreementId string, reason uint64, reasonString string, protocol string) (*EstablishedAgreement, error) {
 	return agreementStateUpdate(db, dbAgreementId, protocol, func(c EstablishedAgreement) *EstablishedAgreement {
@@ -363,7 +415,7 @@ func DeleteEstablishedAgreement(db *bolt.DB, agreementId string, protocol string
 			return fmt.Errorf("Expecting 1 records with id: %v, found %v", agreementId, agreements)
 		} else {
 
-			return db.Update(func(tx *bolt.Tx) error {
+			return timedUpdate(db, func(tx *bolt.Tx) error {
 
 				if b, err := tx.CreateBucketIfNotExists([]byte(E_AGREEMENTS + "-" + protocol)); err != nil {
 					return err
@@ -397,7 +449,7 @@ func agreementStateUpdate(db *bolt.DB, dbAgreementId string, protocol string, fn
 
 // does whole-member replacements of values that are legal to change during the course of a contract's life
 func persistUpdatedAgreement(db *bolt.DB, dbAgreementId string, protocol string, update *EstablishedAgreement) error {
-	return db.Update(func(tx *bolt.Tx) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
 		if b, err := tx.CreateBucketIfNotExists([]byte(E_AGREEMENTS + "-" + protocol)); err != nil {
 			return err
 		} else {
@@ -474,6 +526,21 @@ func persistUpdatedAgreement(db *bolt.DB, dbAgreementId string, protocol string,
 				if mod.ProposalSig == "" { // 1 transition from empty to non-empty
 					mod.ProposalSig = update.ProposalSig
 				}
+				if mod.NetworkSubnet == "" { // 1 transition from empty to non-empty
+					mod.NetworkSubnet = update.NetworkSubnet
+				}
+				if len(mod.ImageDigests) == 0 { // 1 transition from empty to non-empty
+					mod.ImageDigests = update.ImageDigests
+				}
+				if len(mod.ContextEnvVars) == 0 { // 1 transition from empty to non-empty
+					mod.ContextEnvVars = update.ContextEnvVars
+				}
+				if len(update.PublishedPorts) != 0 { // only save non-empty values, the ports change when the containers are recreated
+					mod.PublishedPorts = update.PublishedPorts
+				}
+				if len(update.Mounts) != 0 { // only save non-empty values, the allow list can change when the containers are recreated
+					mod.Mounts = update.Mounts
+				}
 
 				if serialized, err := json.Marshal(mod); err != nil {
 					return fmt.Errorf("Failed to serialize contract record: %v. Error: %v", mod, err)
@@ -508,7 +575,7 @@ func FindEstablishedAgreements(db *bolt.DB, protocol string, filters []EAFilter)
 	agreements := make([]EstablishedAgreement, 0)
 
 	// fetch contracts
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 
 		if b := tx.Bucket([]byte(E_AGREEMENTS + "-" + protocol)); b != nil {
 			b.ForEach(func(k, v []byte) error {
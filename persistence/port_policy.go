@@ -0,0 +1,107 @@
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/containermessage"
+	"net"
+)
+
+// The bucket name in the bolt DB.
+const PORT_POLICY = "port_policy"
+
+// The host ports that deployment configs are allowed to publish. Only the ports it allows are published, without a
+// port policy none are.
+type PortPolicy struct {
+	Ranges     []string `json:"ranges"`     // Host port ranges, e.g. 8000-8999, 8080/tcp or 5000-5010/udp. Empty means no fixed host ports.
+	Interfaces []string `json:"interfaces"` // The host addresses that ports can be published on, 0.0.0.0 for all the interfaces. Empty means any address.
+	Ephemeral  bool     `json:"ephemeral"`  // Whether ephemeral host ports, chosen by docker, are allowed.
+}
+
+func (p PortPolicy) String() string {
+	return fmt.Sprintf("Ranges: %v, Interfaces: %v, Ephemeral: %v", p.Ranges, p.Interfaces, p.Ephemeral)
+}
+
+// Verify that the ranges and interfaces can be used.
+func (p PortPolicy) Validate() error {
+	for _, r := range p.Ranges {
+		if _, _, _, err := containermessage.ParsePortRange(r); err != nil {
+			return err
+		}
+	}
+	for _, iface := range p.Interfaces {
+		if net.ParseIP(iface) == nil {
+			return fmt.Errorf("interface %v must be an IP address of the host, or 0.0.0.0 for all the interfaces", iface)
+		}
+	}
+	return nil
+}
+
+// Verify that every host port that the services of the deployment publish is allowed by the port policy.
+func (p PortPolicy) CheckDeployment(dd *containermessage.DeploymentDescription) error {
+	return dd.CheckPorts(p.Ranges, p.Interfaces, p.Ephemeral)
+}
+
+// The port policy of a node that has not set one, it allows no host ports.
+func DenyAllPortPolicy() *PortPolicy {
+	return &PortPolicy{
+		Ranges:     []string{},
+		Interfaces: []string{},
+		Ephemeral:  false,
+	}
+}
+
+// Retrieve the port policy that applies to the node, the deny all policy if it has not been set.
+func FindEffectivePortPolicy(db *bolt.DB) (*PortPolicy, error) {
+	if policy, err := FindPortPolicy(db); err != nil {
+		return nil, err
+	} else if policy == nil {
+		return DenyAllPortPolicy(), nil
+	} else {
+		return policy, nil
+	}
+}
+
+// Retrieve the port policy from the database, nil if it has not been set.
+func FindPortPolicy(db *bolt.DB) (*PortPolicy, error) {
+	var policy *PortPolicy
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(PORT_POLICY)); b != nil {
+			if v := b.Get([]byte(PORT_POLICY)); v != nil {
+				var pp PortPolicy
+				if err := json.Unmarshal(v, &pp); err != nil {
+					return fmt.Errorf("Unable to deserialize port policy record: %v", v)
+				}
+				policy = &pp
+			}
+		}
+		return nil // end transaction
+	})
+
+	return policy, readErr
+}
+
+// There is only 1 object in the bucket so we can use the bucket name as the object key.
+func SavePortPolicy(db *bolt.DB, policy *PortPolicy) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(PORT_POLICY)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(policy); err != nil {
+			return fmt.Errorf("Failed to serialize port policy: %v. Error: %v", policy, err)
+		} else {
+			return b.Put([]byte(PORT_POLICY), serial)
+		}
+	})
+}
+
+// Remove the port policy from the local database, no host ports are published again.
+func DeletePortPolicy(db *bolt.DB) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(PORT_POLICY)); b != nil {
+			return b.Delete([]byte(PORT_POLICY))
+		}
+		return nil
+	})
+}
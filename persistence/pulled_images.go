@@ -0,0 +1,88 @@
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"time"
+)
+
+// pulled image table name
+const PULLED_IMAGES = "pulled_images"
+
+// A container image that anax pulled for a service, keyed by the image name that the containers use.
+type PulledImage struct {
+	Image      string `json:"image"`      // The image name, repo:tag or repo@digest.
+	Repository string `json:"repository"` // The image name without the tag and digest, the versions of a service image share it.
+	ImageID    string `json:"image_id"`
+	PullTime   uint64 `json:"pull_time"` // The last time the image was fetched for a service.
+}
+
+func (w PulledImage) String() string {
+	return fmt.Sprintf("Image: %v, "+
+		"Repository: %v, "+
+		"ImageID: %v, "+
+		"PullTime: %v",
+		w.Image, w.Repository, w.ImageID, w.PullTime)
+}
+
+// save the pulled image into the db, refreshing its pull time if it was pulled before.
+func SavePulledImage(db *bolt.DB, image string, repository string, imageID string) error {
+	pi := PulledImage{
+		Image:      image,
+		Repository: repository,
+		ImageID:    imageID,
+		PullTime:   uint64(time.Now().Unix()),
+	}
+
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if bucket, err := tx.CreateBucketIfNotExists([]byte(PULLED_IMAGES)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(pi); err != nil {
+			return fmt.Errorf("Failed to serialize the pulled image object: %v. Error: %v", pi, err)
+		} else {
+			return bucket.Put([]byte(image), serial)
+		}
+	})
+}
+
+// delete the pulled image record from the db.
+func DeletePulledImage(db *bolt.DB, image string) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(PULLED_IMAGES)); b != nil {
+			return b.Delete([]byte(image))
+		}
+		return nil
+	})
+}
+
+// find all the pulled image records in the db.
+func FindPulledImages(db *bolt.DB) ([]PulledImage, error) {
+	pis := make([]PulledImage, 0)
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+
+		if b := tx.Bucket([]byte(PULLED_IMAGES)); b != nil {
+			b.ForEach(func(k, v []byte) error {
+
+				var pi PulledImage
+
+				if err := json.Unmarshal(v, &pi); err != nil {
+					glog.Errorf("Unable to deserialize PulledImage db record: %v. Error: %v", v, err)
+				} else {
+					pis = append(pis, pi)
+				}
+				return nil
+			})
+		}
+
+		return nil // end the transaction
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	} else {
+		return pis, nil
+	}
+}
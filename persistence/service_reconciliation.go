@@ -0,0 +1,61 @@
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// The bucket name in the bolt DB.
+const SERVICE_RECONCILIATION = "service_reconciliation"
+
+// The last comparison of the services registered on the node with the ones its pattern requires, see the
+// Edge.ServiceReconcileIntervalS of the config. The services are the top-level services of the pattern and their
+// dependencies.
+type ServiceReconciliation struct {
+	Timestamp     uint64       `json:"timestamp"`
+	Pattern       string       `json:"pattern,omitempty"`              // org/name of the pattern
+	AutoReconcile bool         `json:"auto_reconcile"`                 // the missing services that need no user input were created
+	Missing       ServiceSpecs `json:"missing_services"`               // required by the pattern, not registered
+	Superfluous   ServiceSpecs `json:"superfluous_services"`           // registered, not required by the pattern
+	Created       ServiceSpecs `json:"created_services"`               // the missing services that were created
+	NotCreated    ServiceSpecs `json:"not_created_services,omitempty"` // the missing services that need user input, or failed to be created
+	Error         string       `json:"error,omitempty"`                // why the services could not be compared
+}
+
+func (s ServiceReconciliation) String() string {
+	return fmt.Sprintf("Timestamp: %v, Pattern: %v, AutoReconcile: %v, Missing: %v, Superfluous: %v, Created: %v, NotCreated: %v, Error: %v", s.Timestamp, s.Pattern, s.AutoReconcile, s.Missing, s.Superfluous, s.Created, s.NotCreated, s.Error)
+}
+
+// Retrieve the last reconciliation of the node's services from the database, nil if there is none.
+func FindServiceReconciliation(db *bolt.DB) (*ServiceReconciliation, error) {
+	var reconciliation *ServiceReconciliation
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(SERVICE_RECONCILIATION)); b != nil {
+			if v := b.Get([]byte(SERVICE_RECONCILIATION)); v != nil {
+				var sr ServiceReconciliation
+				if err := json.Unmarshal(v, &sr); err != nil {
+					return fmt.Errorf("Unable to deserialize service reconciliation record: %v", v)
+				}
+				reconciliation = &sr
+			}
+		}
+		return nil // end transaction
+	})
+
+	return reconciliation, readErr
+}
+
+// There is only 1 object in the bucket so we can use the bucket name as the object key.
+func SaveServiceReconciliation(db *bolt.DB, reconciliation *ServiceReconciliation) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(SERVICE_RECONCILIATION)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(reconciliation); err != nil {
+			return fmt.Errorf("Failed to serialize service reconciliation: %v. Error: %v", reconciliation, err)
+		} else {
+			return b.Put([]byte(SERVICE_RECONCILIATION), serial)
+		}
+	})
+}
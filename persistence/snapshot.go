@@ -0,0 +1,111 @@
+package persistence
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// A copy of some of the buckets of the database, taken so that they can be put back as they were, e.g. when a change
+// that is made through several calls, each in its own transaction, fails part way.
+type BucketSnapshot struct {
+	buckets map[string]*bucketCopy // nil for the buckets that did not exist
+}
+
+// The keys, nested buckets and sequence of a bucket, copied out of the transaction that read them.
+type bucketCopy struct {
+	sequence uint64
+	values   map[string][]byte
+	nested   map[string]*bucketCopy
+}
+
+// Copy the named buckets in a single read transaction.
+func SnapshotBuckets(db *bolt.DB, names ...string) (*BucketSnapshot, error) {
+	snap := &BucketSnapshot{buckets: make(map[string]*bucketCopy, len(names))}
+
+	err := timedView(db, func(tx *bolt.Tx) error {
+		for _, name := range names {
+			if b := tx.Bucket([]byte(name)); b != nil {
+				c, err := copyOutBucket(b)
+				if err != nil {
+					return err
+				}
+				snap.buckets[name] = c
+			} else {
+				snap.buckets[name] = nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to snapshot the buckets %v, error %v", names, err)
+	}
+	return snap, nil
+}
+
+// Put the buckets of the snapshot back as they were when it was taken, in a single transaction. A bucket that did not
+// exist then is removed.
+func (s *BucketSnapshot) Restore(db *bolt.DB) error {
+	err := timedUpdate(db, func(tx *bolt.Tx) error {
+		for name, c := range s.buckets {
+			if tx.Bucket([]byte(name)) != nil {
+				if err := tx.DeleteBucket([]byte(name)); err != nil {
+					return err
+				}
+			}
+			if c == nil {
+				continue
+			}
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			if err := copyInBucket(c, b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to restore the snapshot of the buckets, error %v", err)
+	}
+	return nil
+}
+
+// The values returned by bolt are only valid in their transaction, they are copied.
+func copyOutBucket(b *bolt.Bucket) (*bucketCopy, error) {
+	c := &bucketCopy{sequence: b.Sequence(), values: map[string][]byte{}, nested: map[string]*bucketCopy{}}
+	err := b.ForEach(func(k, v []byte) error {
+		if v != nil {
+			c.values[string(k)] = append([]byte(nil), v...)
+			return nil
+		}
+		nested, err := copyOutBucket(b.Bucket(k))
+		if err != nil {
+			return err
+		}
+		c.nested[string(k)] = nested
+		return nil
+	})
+	return c, err
+}
+
+func copyInBucket(c *bucketCopy, b *bolt.Bucket) error {
+	if err := b.SetSequence(c.sequence); err != nil {
+		return err
+	}
+	for k, v := range c.values {
+		if err := b.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	for k, nc := range c.nested {
+		nested, err := b.CreateBucket([]byte(k))
+		if err != nil {
+			return err
+		}
+		if err := copyInBucket(nc, nested); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// +build unit
+
+package persistence
+
+import (
+	"github.com/boltdb/bolt"
+	"testing"
+)
+
+func Test_SnapshotBuckets_restore(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanTestDir(dir)
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("kept"))
+		if err != nil {
+			return err
+		}
+		nested, err := b.CreateBucket([]byte("nested"))
+		if err != nil {
+			return err
+		}
+		if err := nested.Put([]byte("inner"), []byte("1")); err != nil {
+			return err
+		} else if err := b.Put([]byte("key"), []byte("before")); err != nil {
+			return err
+		}
+		return b.SetSequence(7)
+	}); err != nil {
+		t.Fatalf("failed to fill the database, error %v", err)
+	}
+
+	snap, err := SnapshotBuckets(db, "kept", "added")
+	if err != nil {
+		t.Fatalf("failed to snapshot the buckets, error %v", err)
+	}
+
+	// change the bucket, and create the one that did not exist
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("kept"))
+		if err := b.Put([]byte("key"), []byte("after")); err != nil {
+			return err
+		} else if err := b.Put([]byte("other"), []byte("x")); err != nil {
+			return err
+		} else if err := b.DeleteBucket([]byte("nested")); err != nil {
+			return err
+		} else if _, err := b.NextSequence(); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket([]byte("added"))
+		return err
+	}); err != nil {
+		t.Fatalf("failed to change the database, error %v", err)
+	}
+
+	if err := snap.Restore(db); err != nil {
+		t.Fatalf("failed to restore the snapshot, error %v", err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("kept"))
+		if b == nil {
+			t.Fatalf("the bucket should be restored")
+		} else if v := b.Get([]byte("key")); string(v) != "before" {
+			t.Errorf("key should be restored to before, is %v", string(v))
+		} else if v := b.Get([]byte("other")); v != nil {
+			t.Errorf("the key added after the snapshot should be removed, is %v", string(v))
+		} else if nested := b.Bucket([]byte("nested")); nested == nil || string(nested.Get([]byte("inner"))) != "1" {
+			t.Errorf("the nested bucket should be restored")
+		} else if b.Sequence() != 7 {
+			t.Errorf("the sequence should be restored to 7, is %v", b.Sequence())
+		}
+		if tx.Bucket([]byte("added")) != nil {
+			t.Errorf("the bucket created after the snapshot should be removed")
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to read the database, error %v", err)
+	}
+}
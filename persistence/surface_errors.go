@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/boltdb/bolt"
 	"github.com/golang/glog"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/i18n"
 	"time"
 )
@@ -18,14 +19,14 @@ type SurfaceError struct {
 	Event_code string       `json:"event_code"`
 	Hidden     bool         `json:"hidden"`
 	Workload   WorkloadInfo `json:"workload"`
-	Timestamp  string       `json:"timestamp"`
+	Timestamp  string       `json:"timestamp"` // RFC3339 in UTC
 }
 
 // FindSurfaceErrors returns the surface errors currently in the local db
 func FindSurfaceErrors(db *bolt.DB) ([]SurfaceError, error) {
 	var surfaceErrors []SurfaceError
 
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 		if b := tx.Bucket([]byte(NODE_SURFACEERR)); b != nil {
 			return b.ForEach(func(k, v []byte) error {
 
@@ -48,7 +49,7 @@ func FindSurfaceErrors(db *bolt.DB) ([]SurfaceError, error) {
 
 // SaveSurfaceErrors saves the provided list of surface errors to the local db
 func SaveSurfaceErrors(db *bolt.DB, surfaceErrors []SurfaceError) error {
-	writeErr := db.Update(func(tx *bolt.Tx) error {
+	writeErr := timedUpdate(db, func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte(NODE_SURFACEERR))
 		if err != nil {
 			return err
@@ -71,7 +72,7 @@ func DeleteSurfaceErrors(db *bolt.DB) error {
 	} else if len(seList) == 0 {
 		return nil
 	} else {
-		return db.Update(func(tx *bolt.Tx) error {
+		return timedUpdate(db, func(tx *bolt.Tx) error {
 
 			if b, err := tx.CreateBucketIfNotExists([]byte(NODE_SURFACEERR)); err != nil {
 				return err
@@ -160,12 +161,12 @@ func MatchWorkload(error1 EventLog, error2 EventLog) bool {
 		return false
 	}
 
-	return (source1Workload.URL == source2Workload.URL && source1Workload.Org == source2Workload.Org)
+	return (cutil.SameSpecURL(source1Workload.URL, source2Workload.URL) && source1Workload.Org == source2Workload.Org)
 }
 
 // NewSurfaceError returns a surface error from the eventlog parameter
 func NewSurfaceError(eventLog EventLog) SurfaceError {
-	timestamp := time.Unix((int64)(eventLog.Timestamp), 0).String()
+	timestamp := time.Unix((int64)(eventLog.Timestamp), 0).UTC().Format(time.RFC3339)
 	newErr := SurfaceError{Record_id: eventLog.Id, Message: fmt.Sprintf("%s: %v", eventLog.MessageMeta.MessageKey, eventLog.MessageMeta.MessageArgs), Event_code: eventLog.EventCode, Hidden: false, Workload: GetWorkloadInfo(eventLog), Timestamp: timestamp}
 	if eventLog.MessageMeta != nil && eventLog.MessageMeta.MessageKey != "" {
 		newErr.Message = i18n.GetMessagePrinter().Sprintf(eventLog.MessageMeta.MessageKey, eventLog.MessageMeta.MessageArgs...)
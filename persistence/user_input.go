@@ -16,7 +16,7 @@ func FindNodeUserInput(db *bolt.DB) ([]policy.UserInput, error) {
 
 	var userInput []policy.UserInput
 
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 		if b := tx.Bucket([]byte(NODE_USERINPUT)); b != nil {
 			return b.ForEach(func(k, v []byte) error {
 
@@ -41,7 +41,7 @@ func FindNodeUserInput(db *bolt.DB) ([]policy.UserInput, error) {
 // There is only 1 object in the bucket so we can use the bucket name as the object key.
 func SaveNodeUserInput(db *bolt.DB, userInput []policy.UserInput) error {
 
-	writeErr := db.Update(func(tx *bolt.Tx) error {
+	writeErr := timedUpdate(db, func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte(NODE_USERINPUT))
 		if err != nil {
 			return err
@@ -66,7 +66,7 @@ func DeleteNodeUserInput(db *bolt.DB) error {
 		return nil
 	} else {
 
-		return db.Update(func(tx *bolt.Tx) error {
+		return timedUpdate(db, func(tx *bolt.Tx) error {
 
 			if b, err := tx.CreateBucketIfNotExists([]byte(NODE_USERINPUT)); err != nil {
 				return err
@@ -119,7 +119,7 @@ func GetNodeUserInputHash_Exch(db *bolt.DB) ([]byte, error) {
 
 	userInputHash := []byte{}
 
-	readErr := db.View(func(tx *bolt.Tx) error {
+	readErr := timedView(db, func(tx *bolt.Tx) error {
 		if b := tx.Bucket([]byte(EXCHANGE_NODE_USERINPUT_HASH)); b != nil {
 			return b.ForEach(func(k, v []byte) error {
 				userInputHash = v
@@ -140,7 +140,7 @@ func GetNodeUserInputHash_Exch(db *bolt.DB) ([]byte, error) {
 // save the exchange node user input hash.
 func SaveNodeUserInputHash_Exch(db *bolt.DB, userInputHash []byte) error {
 
-	writeErr := db.Update(func(tx *bolt.Tx) error {
+	writeErr := timedUpdate(db, func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte(EXCHANGE_NODE_USERINPUT_HASH))
 		if err != nil {
 			return err
@@ -161,7 +161,7 @@ func DeleteNodeUserInputHash_Exch(db *bolt.DB) error {
 	} else if userInputHash == nil || len(userInputHash) == 0 {
 		return nil
 	} else {
-		return db.Update(func(tx *bolt.Tx) error {
+		return timedUpdate(db, func(tx *bolt.Tx) error {
 
 			if b, err := tx.CreateBucketIfNotExists([]byte(EXCHANGE_NODE_USERINPUT_HASH)); err != nil {
 				return err
@@ -0,0 +1,145 @@
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+)
+
+// workload canary table name
+const WORKLOAD_CANARY = "workload_canary"
+
+// The states of the canary rollout of a workload version.
+const (
+	CANARY_STATE_SOAKING     = "soaking"     // the canaries run the new version, the other agreements wait
+	CANARY_STATE_PROMOTED    = "promoted"    // the canaries stayed healthy, all the agreements can move to the version
+	CANARY_STATE_ROLLED_BACK = "rolled_back" // a canary failed, the node rejects the version
+)
+
+// The canary rollout of a new version of a workload on this node, keyed by the workload org, url and version.
+type WorkloadCanary struct {
+	Org          string   `json:"org"`
+	URL          string   `json:"url"`
+	Version      string   `json:"version"`
+	State        string   `json:"state"`
+	Affected     int      `json:"affected"` // The number of agreements for other versions of the workload when the rollout started.
+	Quota        int      `json:"quota"`    // The number of agreements that can move to the version while it is soaking.
+	Canaries     []string `json:"canaries"` // The ids of the agreements that moved to the version while it was soaking.
+	StartTime    uint64   `json:"start_time"`
+	DecisionTime uint64   `json:"decision_time,omitempty"` // The time the version was promoted or rolled back.
+	Reason       string   `json:"reason,omitempty"`        // Why the version was rolled back.
+}
+
+func NewWorkloadCanary(org string, url string, version string, affected int, quota int, startTime uint64) *WorkloadCanary {
+	return &WorkloadCanary{
+		Org:       org,
+		URL:       url,
+		Version:   version,
+		State:     CANARY_STATE_SOAKING,
+		Affected:  affected,
+		Quota:     quota,
+		Canaries:  []string{},
+		StartTime: startTime,
+	}
+}
+
+func (w WorkloadCanary) String() string {
+	return fmt.Sprintf("Org: %v, "+
+		"URL: %v, "+
+		"Version: %v, "+
+		"State: %v, "+
+		"Affected: %v, "+
+		"Quota: %v, "+
+		"Canaries: %v, "+
+		"StartTime: %v, "+
+		"DecisionTime: %v, "+
+		"Reason: %v",
+		w.Org, w.URL, w.Version, w.State, w.Affected, w.Quota, w.Canaries, w.StartTime, w.DecisionTime, w.Reason)
+}
+
+func (w WorkloadCanary) IsCanary(agreementId string) bool {
+	for _, id := range w.Canaries {
+		if id == agreementId {
+			return true
+		}
+	}
+	return false
+}
+
+func workloadCanaryKey(org string, url string, version string) string {
+	return fmt.Sprintf("%v/%v/%v", org, url, version)
+}
+
+// save the WorkloadCanary record into db.
+func SaveWorkloadCanary(db *bolt.DB, canary *WorkloadCanary) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if bucket, err := tx.CreateBucketIfNotExists([]byte(WORKLOAD_CANARY)); err != nil {
+			return err
+		} else if serial, err := json.Marshal(*canary); err != nil {
+			return fmt.Errorf("Failed to serialize the workload canary object: %v. Error: %v", *canary, err)
+		} else {
+			return bucket.Put([]byte(workloadCanaryKey(canary.Org, canary.URL, canary.Version)), serial)
+		}
+	})
+}
+
+// delete the WorkloadCanary record of the given workload version from the db.
+func DeleteWorkloadCanary(db *bolt.DB, org string, url string, version string) error {
+	return timedUpdate(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(WORKLOAD_CANARY)); b != nil {
+			return b.Delete([]byte(workloadCanaryKey(org, url, version)))
+		}
+		return nil
+	})
+}
+
+// find the canary rollout of the given workload version, nil if there is none.
+func FindWorkloadCanary(db *bolt.DB, org string, url string, version string) (*WorkloadCanary, error) {
+	var canary *WorkloadCanary
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(WORKLOAD_CANARY)); b != nil {
+			if v := b.Get([]byte(workloadCanaryKey(org, url, version))); v != nil {
+				var wc WorkloadCanary
+				if err := json.Unmarshal(v, &wc); err != nil {
+					return fmt.Errorf("Unable to deserialize WorkloadCanary db record: %v. Error: %v", v, err)
+				}
+				canary = &wc
+			}
+		}
+		return nil
+	})
+
+	return canary, readErr
+}
+
+// find all the workload canary records in the db.
+func FindWorkloadCanaries(db *bolt.DB) ([]WorkloadCanary, error) {
+	wcs := make([]WorkloadCanary, 0)
+
+	readErr := timedView(db, func(tx *bolt.Tx) error {
+
+		if b := tx.Bucket([]byte(WORKLOAD_CANARY)); b != nil {
+			b.ForEach(func(k, v []byte) error {
+
+				var wc WorkloadCanary
+
+				if err := json.Unmarshal(v, &wc); err != nil {
+					glog.Errorf("Unable to deserialize WorkloadCanary db record: %v. Error: %v", v, err)
+				} else {
+					wcs = append(wcs, wc)
+				}
+				return nil
+			})
+		}
+
+		return nil // end the transaction
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	} else {
+		return wcs, nil
+	}
+}
@@ -41,7 +41,7 @@ type APISpecification struct {
 }
 
 func (a APISpecification) IsSame(compare APISpecification, checkVersion bool) bool {
-	if a.SpecRef != compare.SpecRef || a.Org != compare.Org || a.ExclusiveAccess != compare.ExclusiveAccess || a.Arch != compare.Arch {
+	if !cutil.SameSpecURL(a.SpecRef, compare.SpecRef) || a.Org != compare.Org || a.ExclusiveAccess != compare.ExclusiveAccess || a.Arch != compare.Arch {
 		return false
 	} else if checkVersion {
 		return a.Version == compare.Version
@@ -69,10 +69,11 @@ func (self *APISpecList) Concatenate(new_list *APISpecList) {
 	}
 }
 
-// This function adds an API spec to the list. Return an error if there are duplicates.
+// This function adds an API spec to the list. Return an error if there are duplicates. The specs of the same service for
+// different hardware architectures are not duplicates.
 func (self *APISpecList) Add_API_Spec(new_ele *APISpecification) error {
 	for _, ele := range *self {
-		if ele.SpecRef == new_ele.SpecRef && ele.Org == new_ele.Org {
+		if cutil.SameSpecURL(ele.SpecRef, new_ele.SpecRef) && ele.Org == new_ele.Org && cutil.ArchEquivalent(ele.Arch, new_ele.Arch) {
 			return errors.New(fmt.Sprintf("APISpecList %v already has the element being added: %v", *self, *new_ele))
 		}
 	}
@@ -83,7 +84,7 @@ func (self *APISpecList) Add_API_Spec(new_ele *APISpecification) error {
 // This function return true if an api spec list contains the input spec ref url
 func (self APISpecList) ContainsSpecRef(url string, org string, version string) bool {
 	for _, ele := range self {
-		if ele.SpecRef == url && ele.Version == version && ele.Org == org {
+		if cutil.SameSpecURL(ele.SpecRef, url) && ele.Version == version && ele.Org == org {
 			return true
 		}
 	}
@@ -107,7 +108,7 @@ func (self APISpecList) Supports(required APISpecList) error {
 	for _, sub_ele := range self {
 		found := false
 		for _, req_ele := range required {
-			if sub_ele.SpecRef == req_ele.SpecRef && sub_ele.Org == req_ele.Org && sub_ele.Arch == req_ele.Arch {
+			if cutil.SameSpecURL(sub_ele.SpecRef, req_ele.SpecRef) && sub_ele.Org == req_ele.Org && sub_ele.Arch == req_ele.Arch {
 				if req_ver, err := semanticversion.Version_Expression_Factory(req_ele.Version); err != nil {
 					continue
 				} else if ok, err := req_ver.Is_within_range(sub_ele.Version); err != nil {
@@ -184,7 +185,7 @@ func (self *APISpecList) GetCommonVersionRanges() (*APISpecList, error) {
 	for _, apiSpec := range *self {
 		found := false
 		for i, newApiSpec := range *new_list {
-			if newApiSpec.SpecRef == apiSpec.SpecRef && newApiSpec.Org == apiSpec.Org && newApiSpec.Arch == apiSpec.Arch {
+			if cutil.SameSpecURL(newApiSpec.SpecRef, apiSpec.SpecRef) && newApiSpec.Org == apiSpec.Org && newApiSpec.Arch == apiSpec.Arch {
 				found = true
 
 				// get the intersection of the two version ranges
@@ -224,3 +225,122 @@ func (self *APISpecList) GetCommonVersionRanges() (*APISpecList, error) {
 
 	return new_list1, nil
 }
+
+// A version range that a workload requires of one of its dependencies, see ApplyRequirements.
+type DependencyRequirement struct {
+	SpecRef    string // the url of the dependency
+	Org        string
+	Arch       string
+	Version    string // the version range that is required, e.g. [1.0.0,1.0.0] for exactly 1.0.0
+	RequiredBy string // the workload that requires the dependency, e.g. myorg/mywl 1.0.0
+}
+
+func (r DependencyRequirement) String() string {
+	return fmt.Sprintf("%v requires %v version %v", r.RequiredBy, cutil.FormOrgSpecUrl(r.SpecRef, r.Org), r.Version)
+}
+
+// Narrow the common version range of each dependency in the list, as returned by GetCommonVersionRanges, to the version
+// ranges that the workloads require of it. The common range of a shared singleton starts at the highest version that
+// was resolved for it, so a workload that requires an exact lower version would get a version that it does not support.
+// The dependency is given the range that all the workloads require instead. An error naming two of the workloads is
+// returned when their requirements of a dependency do not intersect.
+func (self *APISpecList) ApplyRequirements(reqs []DependencyRequirement) error {
+
+	for i, apiSpec := range *self {
+
+		// The requirements of this dependency, as canonical ranges.
+		matching := make([]DependencyRequirement, 0, 2)
+		for _, req := range reqs {
+			if cutil.SameSpecURL(req.SpecRef, apiSpec.SpecRef) && req.Org == apiSpec.Org && (req.Arch == "" || cutil.ArchEquivalent(req.Arch, apiSpec.Arch)) {
+				if vr, err := semanticversion.CanonicalVersionRange(req.Version); err != nil {
+					return fmt.Errorf("Failed to convert the version string %v required by %v to version range. %v", req.Version, req.RequiredBy, err)
+				} else {
+					req.Version = vr
+					matching = append(matching, req)
+				}
+			}
+		}
+		if len(matching) == 0 {
+			continue
+		}
+
+		// Version ranges intersect as a whole when each pair of them does, so the first pair that does not is named.
+		required := matching[0].Version
+		for j, req := range matching {
+			for _, other := range matching[:j] {
+				if _, ok, err := semanticversion.IntersectVersionRanges(req.Version, other.Version); err != nil {
+					return fmt.Errorf("Error creating version range for %v/%v, %v and %v. %v", apiSpec.Org, apiSpec.SpecRef, req.Version, other.Version, err)
+				} else if !ok {
+					return fmt.Errorf("Incompatible requirements of service %v: %v, and %v.", cutil.FormOrgSpecUrl(apiSpec.SpecRef, apiSpec.Org), other, req)
+				}
+			}
+			if j != 0 {
+				if intersection, _, err := semanticversion.IntersectVersionRanges(required, req.Version); err != nil {
+					return fmt.Errorf("Error creating version range for %v/%v, %v and %v. %v", apiSpec.Org, apiSpec.SpecRef, required, req.Version, err)
+				} else {
+					required = intersection
+				}
+			}
+		}
+
+		// Keep the common range where the requirements allow it, otherwise use the required range.
+		if intersection, ok, err := semanticversion.IntersectVersionRanges(apiSpec.Version, required); err != nil {
+			return fmt.Errorf("Error creating version range for %v/%v, %v and %v. %v", apiSpec.Org, apiSpec.SpecRef, apiSpec.Version, required, err)
+		} else if ok {
+			(*self)[i].Version = intersection
+		} else {
+			(*self)[i].Version = required
+		}
+	}
+
+	return nil
+}
+
+// The differences between an old and a new list of services, e.g. the services of the old and the new pattern of a node.
+type APISpecDiff struct {
+	Add     APISpecList `json:"add"`     // the services that are only in the new list
+	Keep    APISpecList `json:"keep"`    // the services that are in both lists, as they are in the new list
+	Remove  APISpecList `json:"remove"`  // the services that are only in the old list
+	Changed APISpecList `json:"changed"` // the services of Keep whose version or sharing is not the same in both lists
+}
+
+func (d APISpecDiff) String() string {
+	return fmt.Sprintf("Add: %v, Keep: %v, Remove: %v, Changed: %v", d.Add.AsStringArray(), d.Keep.AsStringArray(), d.Remove.AsStringArray(), d.Changed.AsStringArray())
+}
+
+// Returns the service of the list with the given URL and org, for a hardware architecture equivalent to arch, nil if
+// there is none.
+func (self APISpecList) findService(url string, org string, arch string) *APISpecification {
+	for i, ele := range self {
+		if cutil.SameSpecURL(ele.SpecRef, url) && ele.Org == org && cutil.ArchEquivalent(ele.Arch, arch) {
+			return &self[i]
+		}
+	}
+	return nil
+}
+
+// Compare an old and a new list of services. A service is in both lists when it has the same URL and org, for an
+// equivalent hardware architecture, whatever its version. So a service whose version changes is kept, and it is also in
+// Changed, rather than removed and added again.
+func DiffAPISpecLists(oldList APISpecList, newList APISpecList) *APISpecDiff {
+	diff := &APISpecDiff{Add: APISpecList{}, Keep: APISpecList{}, Remove: APISpecList{}, Changed: APISpecList{}}
+
+	for _, newEle := range newList {
+		if oldEle := oldList.findService(newEle.SpecRef, newEle.Org, newEle.Arch); oldEle == nil {
+			diff.Add = append(diff.Add, newEle)
+		} else {
+			diff.Keep = append(diff.Keep, newEle)
+			if oldEle.Version != newEle.Version || oldEle.ExclusiveAccess != newEle.ExclusiveAccess {
+				diff.Changed = append(diff.Changed, newEle)
+			}
+		}
+	}
+
+	for _, oldEle := range oldList {
+		if newList.findService(oldEle.SpecRef, oldEle.Org, oldEle.Arch) == nil {
+			diff.Remove = append(diff.Remove, oldEle)
+		}
+	}
+
+	return diff
+}
@@ -3,6 +3,7 @@
 package policy
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -124,6 +125,17 @@ func Test_APISpecification_same(t *testing.T) {
 		}
 	}
 
+	asString1 = `[{"specRef": "http://mycompany.com/dm/gps","organization":"myorg","version":"1.0.0","exclusiveAccess":false,"arch":"arm"}]`
+	asString2 = `[{"specRef": "HTTP://MyCompany.com:80/dm/gps/","organization":"myorg","version":"1.0.0","exclusiveAccess":false,"arch":"arm"}]`
+
+	if as1 = create_APISpecification(asString1, t); as1 != nil {
+		if as2 = create_APISpecification(asString2, t); as2 != nil {
+			if !as1.IsSame(*as2, true) {
+				t.Errorf("Error: %v and %v are the same spec URL.", as1, as2)
+			}
+		}
+	}
+
 }
 
 // Some sameness tests - API spec lists which are NOT the same
@@ -460,3 +472,158 @@ func Test_APISpecification_GetCommonVersionRanges_success3(t *testing.T) {
 		}
 	}
 }
+
+// The common range of a shared singleton is the highest resolved version, a workload that requires an exact lower
+// version in a range that the other workload also accepts gets that version.
+func Test_APISpecification_ApplyRequirements_exact_in_range(t *testing.T) {
+	prod := `[{"specRef":"http://mycompany.com/dm/gps","organization":"myorg","version":"1.0.0","exclusiveAccess":false,"arch":"amd64"},
+	          {"specRef":"http://mycompany.com/dm/gps","organization":"myorg","version":"2.0.0","exclusiveAccess":false,"arch":"amd64"},
+	          {"specRef":"http://mycompany.com/dm/network","organization":"myorg","version":"1.5.0","exclusiveAccess":false,"arch":"amd64"}]`
+	apiSpecList := create_APISpecification(prod, t)
+	if apiSpecList == nil {
+		return
+	}
+	common, err := apiSpecList.GetCommonVersionRanges()
+	if err != nil {
+		t.Fatalf("Error: got error but should not be. %v\n", err)
+	}
+
+	reqs := []DependencyRequirement{
+		{SpecRef: "http://mycompany.com/dm/gps", Org: "myorg", Arch: "amd64", Version: "[1.0.0,1.0.0]", RequiredBy: "myorg/wl1 1.0.0"},
+		{SpecRef: "http://mycompany.com/dm/gps", Org: "myorg", Arch: "x86_64", Version: "[1.0.0,3.0.0)", RequiredBy: "myorg/wl2 1.0.0"},
+		{SpecRef: "http://mycompany.com/dm/network", Org: "myorg", Arch: "amd64", Version: "1.0.0", RequiredBy: "myorg/wl2 1.0.0"},
+	}
+	if err := common.ApplyRequirements(reqs); err != nil {
+		t.Fatalf("Error: got error but should not be. %v\n", err)
+	}
+
+	for _, as := range *common {
+		if as.SpecRef == "http://mycompany.com/dm/gps" && as.Version != "[1.0.0,1.0.0]" {
+			t.Errorf("Error: should have version range [1.0.0,1.0.0], but is %v\n", as)
+		} else if as.SpecRef == "http://mycompany.com/dm/network" && as.Version != "[1.5.0,INFINITY)" {
+			t.Errorf("Error: should have version range [1.5.0,INFINITY), but is %v\n", as)
+		}
+	}
+}
+
+// A workload that requires an exact version below the range of another workload cannot share the singleton with it.
+func Test_APISpecification_ApplyRequirements_exact_out_of_range(t *testing.T) {
+	prod := `[{"specRef":"http://mycompany.com/dm/gps","organization":"myorg","version":"[1.0.0,INFINITY)","exclusiveAccess":false,"arch":"amd64"}]`
+	apiSpecList := create_APISpecification(prod, t)
+	if apiSpecList == nil {
+		return
+	}
+
+	reqs := []DependencyRequirement{
+		{SpecRef: "http://mycompany.com/dm/gps", Org: "myorg", Arch: "amd64", Version: "[2.0.0,3.0.0)", RequiredBy: "myorg/wl2 1.0.0"},
+		{SpecRef: "http://mycompany.com/dm/gps", Org: "myorg", Arch: "amd64", Version: "[1.0.0,1.0.0]", RequiredBy: "myorg/wl1 1.0.0"},
+	}
+	err := apiSpecList.ApplyRequirements(reqs)
+	if err == nil {
+		t.Fatalf("Error: should have returned an error, the list is %v\n", *apiSpecList)
+	} else if !strings.Contains(err.Error(), "myorg/wl1 1.0.0 requires") || !strings.Contains(err.Error(), "myorg/wl2 1.0.0 requires") {
+		t.Errorf("Error: should name both workloads, but is %v\n", err)
+	}
+}
+
+// Version ranges that overlap narrow the common range to the overlap, ranges that do not overlap are an error.
+func Test_APISpecification_ApplyRequirements_ranges(t *testing.T) {
+	prod := `[{"specRef":"http://mycompany.com/dm/gps","organization":"myorg","version":"[1.2.0,INFINITY)","exclusiveAccess":false,"arch":"amd64"},
+	          {"specRef":"http://mycompany.com/dm/gps","organization":"myorg1","version":"[1.2.0,INFINITY)","exclusiveAccess":false,"arch":"amd64"}]`
+	apiSpecList := create_APISpecification(prod, t)
+	if apiSpecList == nil {
+		return
+	}
+
+	overlap := []DependencyRequirement{
+		{SpecRef: "http://mycompany.com/dm/gps", Org: "myorg", Version: "[1.0.0,2.0.0)", RequiredBy: "myorg/wl1 1.0.0"},
+		{SpecRef: "http://mycompany.com/dm/gps", Org: "myorg", Version: "[1.5.0,3.0.0)", RequiredBy: "myorg/wl2 1.0.0"},
+	}
+	if err := apiSpecList.ApplyRequirements(overlap); err != nil {
+		t.Fatalf("Error: got error but should not be. %v\n", err)
+	} else if (*apiSpecList)[0].Version != "[1.5.0,2.0.0)" {
+		t.Errorf("Error: should have version range [1.5.0,2.0.0), but is %v\n", (*apiSpecList)[0])
+	} else if (*apiSpecList)[1].Version != "[1.2.0,INFINITY)" {
+		t.Errorf("Error: the service of another org should not change, but is %v\n", (*apiSpecList)[1])
+	}
+
+	noOverlap := []DependencyRequirement{
+		{SpecRef: "http://mycompany.com/dm/gps", Org: "myorg1", Version: "[1.0.0,2.0.0)", RequiredBy: "myorg/wl1 1.0.0"},
+		{SpecRef: "http://mycompany.com/dm/gps", Org: "myorg1", Version: "[2.0.0,3.0.0)", RequiredBy: "myorg/wl2 1.0.0"},
+	}
+	if err := apiSpecList.ApplyRequirements(noOverlap); err == nil {
+		t.Errorf("Error: should have returned an error, the list is %v\n", *apiSpecList)
+	}
+}
+
+// The services of 2 patterns, a shared singleton that both need at different versions is kept and changed.
+func Test_DiffAPISpecLists_shared_singleton_versions(t *testing.T) {
+	oldString := `[{"specRef":"http://mycompany.com/dm/gps","organization":"myorg","version":"[1.0.0,2.0.0)","exclusiveAccess":false,"arch":"amd64"},
+				{"specRef":"http://mycompany.com/dm/cpu","organization":"myorg","version":"[1.0.0,INFINITY)","exclusiveAccess":false,"arch":"amd64"},
+				{"specRef":"http://mycompany.com/dm/net","organization":"myorg","version":"1.0.0","exclusiveAccess":true,"arch":"amd64"}]`
+	newString := `[{"specRef":"http://mycompany.com/dm/gps","organization":"myorg","version":"[2.0.0,3.0.0)","exclusiveAccess":false,"arch":"amd64"},
+				{"specRef":"http://mycompany.com/dm/cpu/","organization":"myorg","version":"[1.0.0,INFINITY)","exclusiveAccess":false,"arch":"x86_64"},
+				{"specRef":"http://mycompany.com/dm/temp","organization":"myorg","version":"1.0.0","exclusiveAccess":true,"arch":"amd64"}]`
+
+	oldList := create_APISpecification(oldString, t)
+	newList := create_APISpecification(newString, t)
+	if oldList == nil || newList == nil {
+		return
+	}
+
+	diff := DiffAPISpecLists(*oldList, *newList)
+
+	if len(diff.Keep) != 2 {
+		t.Errorf("gps and cpu should be kept, the diff is %v", diff)
+	} else if diff.Keep[0].SpecRef != "http://mycompany.com/dm/gps" || diff.Keep[0].Version != "[2.0.0,3.0.0)" {
+		t.Errorf("gps should be kept with the version of the new list, is %v", diff.Keep[0])
+	}
+
+	if len(diff.Changed) != 1 || diff.Changed[0].SpecRef != "http://mycompany.com/dm/gps" {
+		t.Errorf("only gps should be changed, cpu is at the same version for an equivalent arch, the diff is %v", diff)
+	}
+
+	if len(diff.Add) != 1 || diff.Add[0].SpecRef != "http://mycompany.com/dm/temp" {
+		t.Errorf("temp should be added, the diff is %v", diff)
+	}
+
+	if len(diff.Remove) != 1 || diff.Remove[0].SpecRef != "http://mycompany.com/dm/net" {
+		t.Errorf("net should be removed, the diff is %v", diff)
+	}
+}
+
+// A service that changes from shared to exclusive is changed, the same service for another arch is a different one.
+func Test_DiffAPISpecLists_sharing_and_arch(t *testing.T) {
+	oldString := `[{"specRef":"http://mycompany.com/dm/gps","organization":"myorg","version":"1.0.0","exclusiveAccess":false,"arch":"amd64"},
+				{"specRef":"http://mycompany.com/dm/cpu","organization":"myorg","version":"1.0.0","exclusiveAccess":false,"arch":"arm"}]`
+	newString := `[{"specRef":"http://mycompany.com/dm/gps","organization":"myorg","version":"1.0.0","exclusiveAccess":true,"arch":"amd64"},
+				{"specRef":"http://mycompany.com/dm/cpu","organization":"myorg","version":"1.0.0","exclusiveAccess":false,"arch":"amd64"}]`
+
+	oldList := create_APISpecification(oldString, t)
+	newList := create_APISpecification(newString, t)
+	if oldList == nil || newList == nil {
+		return
+	}
+
+	diff := DiffAPISpecLists(*oldList, *newList)
+
+	if len(diff.Keep) != 1 || len(diff.Changed) != 1 || diff.Changed[0].SpecRef != "http://mycompany.com/dm/gps" {
+		t.Errorf("gps should be kept and changed, the diff is %v", diff)
+	} else if len(diff.Add) != 1 || diff.Add[0].Arch != "amd64" {
+		t.Errorf("cpu for amd64 should be added, the diff is %v", diff)
+	} else if len(diff.Remove) != 1 || diff.Remove[0].Arch != "arm" {
+		t.Errorf("cpu for arm should be removed, the diff is %v", diff)
+	}
+
+	// no change at all
+	diff = DiffAPISpecLists(*oldList, *oldList)
+	if len(diff.Keep) != 2 || len(diff.Changed) != 0 || len(diff.Add) != 0 || len(diff.Remove) != 0 {
+		t.Errorf("all the services should be kept unchanged, the diff is %v", diff)
+	}
+
+	// from no services
+	diff = DiffAPISpecLists(APISpecList{}, *newList)
+	if len(diff.Add) != 2 || len(diff.Keep) != 0 || len(diff.Remove) != 0 {
+		t.Errorf("all the services should be added, the diff is %v", diff)
+	}
+}
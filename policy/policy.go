@@ -19,8 +19,7 @@ func GeneratePolicy(sensorUrl string, sensorOrg string, sensorName string, senso
 	glog.V(5).Infof("Generating policy for %v/%v", sensorOrg, sensorUrl)
 
 	// Generate a policy file name
-	a_tmp := strings.Split(sensorUrl, "/")
-	fileName := fmt.Sprintf("%v_%v", sensorOrg, a_tmp[len(a_tmp)-1])
+	fileName := servicePolicyName(sensorUrl, sensorOrg)
 
 	p := Policy_Factory("Policy for " + fileName)
 	p.Add_API_Spec(APISpecification_Factory(sensorUrl, sensorOrg, sensorVersion, arch))
@@ -51,6 +50,17 @@ func GeneratePolicy(sensorUrl string, sensorOrg string, sensorName string, senso
 	}
 }
 
+// The name of the policy that GeneratePolicy generates for the given service, which is also its file name.
+func servicePolicyName(serviceUrl string, serviceOrg string) string {
+	a_tmp := strings.Split(serviceUrl, "/")
+	return fmt.Sprintf("%v_%v", serviceOrg, a_tmp[len(a_tmp)-1])
+}
+
+// Returns the full name of the policy file that GeneratePolicy writes for the given service.
+func ServicePolicyFileName(filePath string, deviceOrg string, serviceUrl string, serviceOrg string) string {
+	return policyFileName(filePath, deviceOrg, servicePolicyName(serviceUrl, serviceOrg))
+}
+
 func RetrieveAllProperties(policy *Policy) (*externalpolicy.PropertyList, error) {
 	pl := new(externalpolicy.PropertyList)
 
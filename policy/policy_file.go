@@ -621,13 +621,15 @@ func (p *Policy) RequiresKnownBC(protocol string) (string, string, string) {
 }
 
 // convert the arch in the specref into GOARCH if it is a synonym of a GOARCH.
-// the synonyms are defined in the configuration file
+// the synonyms defined in the configuration file take precedence over the ones known to cutil.
 func (p *Policy) ConvertSpecRefArchToGOARCH(arch_synonymns config.ArchSynonyms) {
 	if p.APISpecs != nil {
 		for i := 0; i < len(p.APISpecs); i++ {
 			api_spec := &p.APISpecs[i]
 			if api_spec.Arch != "" && arch_synonymns.GetCanonicalArch(api_spec.Arch) != "" {
 				api_spec.Arch = arch_synonymns.GetCanonicalArch(api_spec.Arch)
+			} else if api_spec.Arch != "" {
+				api_spec.Arch = cutil.CanonicalArch(api_spec.Arch)
 			}
 		}
 	}
@@ -699,6 +701,35 @@ func DeletePolicyFilesForPattern(policyPath string, org string, pattern string)
 	return nil
 }
 
+// This function deletes the policy files of the node's org that are for the given service.
+func DeletePolicyFilesForService(policyPath string, org string, serviceUrl string, serviceOrg string) error {
+
+	orgPath := policyPath + "/" + org + "/"
+
+	if _, err := os.Stat(orgPath); os.IsNotExist(err) {
+		glog.Infof("The directory %v does not exist, do nothing.", orgPath)
+		return nil
+	}
+
+	files, err := getPolicyFiles(orgPath)
+	if err != nil {
+		return fmt.Errorf("Unable to get list of policy files in %v, error: %v", orgPath, err)
+	}
+
+	// For each policy, if its first API spec is the service, delete it.
+	for _, fileInfo := range files {
+		if policy, err := ReadPolicyFile(orgPath+fileInfo.Name(), config.NewArchSynonyms()); err != nil {
+			return fmt.Errorf("Failed to read file %v, error: %v", orgPath+fileInfo.Name(), err)
+		} else if len(policy.APISpecs) != 0 && cutil.SameSpecURL(policy.APISpecs[0].SpecRef, serviceUrl) && policy.APISpecs[0].Org == serviceOrg {
+			if err := DeletePolicyFile(orgPath + fileInfo.Name()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 // This function deletes all the policy files for the given org.
 // If patternBasedOnly is false, it deletes all policy file under the path.
 // If patternBasedOnly is true, it only deletes the policy files that are pattern based.
@@ -838,11 +869,16 @@ func (c *Contents) ConflictsWithAlreadyTracked(org string, pol *Policy) string {
 	return ""
 }
 
+// The full name of the policy file of the given name in the org based hierarchy.
+func policyFileName(filepath string, org string, name string) string {
+	return fmt.Sprintf("%v%v/%v.policy", filepath, org, name)
+}
+
 func CreatePolicyFile(filepath string, org string, name string, p *Policy) (string, error) {
 
 	// Store the policy on the filesystem in an org based hierarchy
 	fullFilePath := fmt.Sprintf("%v%v/", filepath, org)
-	fullFileName := fmt.Sprintf("%v%v.policy", fullFilePath, name)
+	fullFileName := policyFileName(filepath, org, name)
 	if err := os.MkdirAll(fullFilePath, 0764); err != nil {
 		return "", errors.New(fmt.Sprintf("Error writing policy file, cannot create file path %v", fullFilePath))
 	} else if err := WritePolicyFile(p, fullFileName); err != nil {
@@ -75,6 +75,16 @@ func Test_ConvertSpecRefArchToGOARCH(t *testing.T) {
 	}
 }
 
+// the synonyms known to cutil are converted without synonyms in the configuration file.
+func Test_ConvertSpecRefArchToGOARCH_builtin(t *testing.T) {
+
+	if pf, err := ReadPolicyFile("./test/pftest/test2.policy", map[string]string{}); err != nil {
+		t.Error(err)
+	} else if api_spec := pf.APISpecs[0]; api_spec.Arch != "amd64" {
+		t.Errorf("Failed to convert the arch x86_64 in the spec ref to its canonical synonym amd64. %v", api_spec)
+	}
+}
+
 func Test_getPolicyFiles(t *testing.T) {
 
 	if files, err := getPolicyFiles("./test/pftest/"); err != nil {
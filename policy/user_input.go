@@ -285,6 +285,91 @@ func MergeUserInputArrays(ui1, ui2 []UserInput, deepMerge bool) []UserInput {
 	return userInput
 }
 
+// A named set of user inputs that is merged with other sets by MergeUserInputLayers, e.g. the pattern or the node
+// user input. A set with a higher precedence overrides a set with a lower precedence.
+type UserInputLayer struct {
+	Name       string
+	Precedence int
+	UserInput  []UserInput
+}
+
+// The user input of a service merged from the user input layers, along with the name of the layer that set
+// each variable and the variables that layers of equal precedence disagree on.
+type MergedUserInput struct {
+	UserInput UserInput
+	Sources   map[string]string
+	Conflicts []cutil.MergeConflict
+}
+
+// Returns true if the version range of the user input contains the given version of the service. A user input without
+// a version range applies to all the versions, and an empty version matches any user input. A user input with a version
+// range that cannot be parsed applies to no version.
+func userInputAppliesToVersion(ui *UserInput, svcVersion string) bool {
+	if svcVersion == "" || ui.ServiceVersionRange == "" {
+		return true
+	}
+	if vExp, err := semanticversion.Version_Expression_Factory(ui.ServiceVersionRange); err != nil {
+		return false
+	} else if inRange, err := vExp.Is_within_range(svcVersion); err != nil {
+		return false
+	} else {
+		return inRange
+	}
+}
+
+// Merge the user input of the given service from the layers. Every user input in a layer that is for the service
+// is merged separately, so two user inputs for the same service in the same layer are reported as conflicts if they
+// set a variable to different values. Variables with object values are merged field by field, see cutil.MergeLayers.
+// Only the user inputs whose version range contains svcVersion are merged, all of them if it is empty.
+// Returns nil if none of the layers has user input for the service.
+func MergeUserInputLayers(svcName, svcOrg, svcVersion, svcArch string, layers []UserInputLayer) *MergedUserInput {
+
+	var merged *MergedUserInput
+	headerPrecedence := 0
+	mergeLayers := make([]cutil.MergeLayer, 0, len(layers))
+	names := make([]string, 0, 10)
+	seen := make(map[string]bool)
+	for _, layer := range layers {
+		for _, ui := range layer.UserInput {
+			if ui.ServiceOrgid != svcOrg || !cutil.SameSpecURL(ui.ServiceUrl, svcName) {
+				continue
+			} else if !(ui.ServiceArch == svcArch || ui.ServiceArch == "" || svcArch == "") {
+				continue
+			} else if !userInputAppliesToVersion(&ui, svcVersion) {
+				continue
+			}
+
+			// the service attributes of the merged user input are taken from the user input with the highest precedence
+			if merged == nil || layer.Precedence >= headerPrecedence {
+				headerPrecedence = layer.Precedence
+				merged = &MergedUserInput{UserInput: UserInput{ServiceOrgid: ui.ServiceOrgid, ServiceUrl: ui.ServiceUrl, ServiceArch: ui.ServiceArch, ServiceVersionRange: ui.ServiceVersionRange}}
+			}
+
+			// keep the variables in the order they are first seen
+			for _, input := range ui.Inputs {
+				if !seen[input.Name] {
+					seen[input.Name] = true
+					names = append(names, input.Name)
+				}
+			}
+			mergeLayers = append(mergeLayers, cutil.MergeLayer{Name: layer.Name, Precedence: layer.Precedence, Values: ui.GetInputMap()})
+		}
+	}
+
+	if merged == nil {
+		return nil
+	}
+
+	result := cutil.MergeLayers(mergeLayers)
+	merged.UserInput.Inputs = make([]Input, 0, len(names))
+	for _, name := range names {
+		merged.UserInput.Inputs = append(merged.UserInput.Inputs, Input{Name: name, Value: result.Values[name]})
+	}
+	merged.Sources = result.Provenance
+	merged.Conflicts = result.Conflicts
+	return merged
+}
+
 // Get the user input that fits this given service spec
 // if arch is an empty string, it means any arch.
 // if service version is an empty string, it means any version is be ok.
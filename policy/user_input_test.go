@@ -189,6 +189,89 @@ func Test_MergeUserInputArrays(t *testing.T) {
 
 }
 
+func Test_MergeUserInputLayers(t *testing.T) {
+	patternUserInput := UserInput{
+		ServiceOrgid:        "mycomp",
+		ServiceUrl:          "cpu",
+		ServiceArch:         "",
+		ServiceVersionRange: "",
+		Inputs:              []Input{Input{Name: "var1", Value: "pat1"}, Input{Name: "var2", Value: map[string]interface{}{"a": 1.0, "b": 2.0}}},
+	}
+
+	nodeUserInput := UserInput{
+		ServiceOrgid:        "mycomp",
+		ServiceUrl:          "cpu/",
+		ServiceArch:         "amd64",
+		ServiceVersionRange: "[1.0.0,INFINITY)",
+		Inputs:              []Input{Input{Name: "var2", Value: map[string]interface{}{"b": 3.0}}, Input{Name: "var3", Value: []interface{}{"x"}}},
+	}
+
+	otherUserInput := UserInput{
+		ServiceOrgid: "mycomp",
+		ServiceUrl:   "gps",
+		Inputs:       []Input{Input{Name: "var1", Value: "gps1"}},
+	}
+
+	layers := []UserInputLayer{
+		{Name: "node", Precedence: 2, UserInput: []UserInput{nodeUserInput, otherUserInput}},
+		{Name: "pattern", Precedence: 1, UserInput: []UserInput{patternUserInput}},
+	}
+
+	merged := MergeUserInputLayers("cpu", "mycomp", "1.2.0", "amd64", layers)
+	expectedInputs := []Input{Input{Name: "var2", Value: map[string]interface{}{"a": 1.0, "b": 3.0}}, Input{Name: "var3", Value: []interface{}{"x"}}, Input{Name: "var1", Value: "pat1"}}
+	expectedSources := map[string]string{"var1": "pattern", "var2.a": "pattern", "var2.b": "node", "var3": "node"}
+
+	if merged == nil {
+		t.Errorf("The merged user input should not be nil.")
+	} else if !reflect.DeepEqual(merged.UserInput.Inputs, expectedInputs) {
+		t.Errorf("The inputs should be %v, but got %v.", expectedInputs, merged.UserInput.Inputs)
+	} else if merged.UserInput.ServiceVersionRange != "[1.0.0,INFINITY)" || merged.UserInput.ServiceArch != "amd64" {
+		t.Errorf("The service attributes should come from the node user input, but got %v.", merged.UserInput)
+	} else if !reflect.DeepEqual(merged.Sources, expectedSources) {
+		t.Errorf("The sources should be %v, but got %v.", expectedSources, merged.Sources)
+	} else if len(merged.Conflicts) != 0 {
+		t.Errorf("There should be no conflicts, but got %v.", merged.Conflicts)
+	}
+
+	// two node user inputs for the same service disagree
+	duplicateUserInput := UserInput{
+		ServiceOrgid: "mycomp",
+		ServiceUrl:   "cpu",
+		Inputs:       []Input{Input{Name: "var3", Value: []interface{}{"y"}}},
+	}
+	layers[0].UserInput = append(layers[0].UserInput, duplicateUserInput)
+
+	merged = MergeUserInputLayers("cpu", "mycomp", "1.2.0", "amd64", layers)
+	if merged == nil {
+		t.Errorf("The merged user input should not be nil.")
+	} else if len(merged.Conflicts) != 1 || merged.Conflicts[0].Key != "var3" {
+		t.Errorf("There should be a conflict on var3, but got %v.", merged.Conflicts)
+	} else if v, _ := merged.UserInput.GetInputValue("var3"); !reflect.DeepEqual(v, []interface{}{"y"}) {
+		t.Errorf("The later user input should win, but got %v.", v)
+	}
+
+	if merged := MergeUserInputLayers("cpu", "mycomp", "1.2.0", "arm", []UserInputLayer{{Name: "node", Precedence: 2, UserInput: []UserInput{nodeUserInput}}}); merged != nil {
+		t.Errorf("There should be no user input for a different arch, but got %v.", merged)
+	}
+
+	// the node user input is for versions 1.0.0 and up, so only the pattern defaults apply to an older version
+	merged = MergeUserInputLayers("cpu", "mycomp", "0.9.0", "amd64", []UserInputLayer{
+		{Name: "node", Precedence: 2, UserInput: []UserInput{nodeUserInput}},
+		{Name: "pattern", Precedence: 1, UserInput: []UserInput{patternUserInput}},
+	})
+	expectedSources = map[string]string{"var1": "pattern", "var2.a": "pattern", "var2.b": "pattern"}
+	if merged == nil {
+		t.Errorf("The merged user input should not be nil.")
+	} else if !reflect.DeepEqual(merged.Sources, expectedSources) {
+		t.Errorf("The sources should be %v, but got %v.", expectedSources, merged.Sources)
+	}
+
+	// an empty version matches the user input of any version range
+	if merged := MergeUserInputLayers("cpu", "mycomp", "", "amd64", []UserInputLayer{{Name: "node", Precedence: 2, UserInput: []UserInput{nodeUserInput}}}); merged == nil {
+		t.Errorf("The user input should apply to any version.")
+	}
+}
+
 func Test_FindUserInput(t *testing.T) {
 	svcUserInput1 := UserInput{
 		ServiceOrgid:        "mycomp1",
@@ -196,6 +196,10 @@ func (c *BasicProtocolHandler) GetTerminationCode(reason string) uint {
 		return basicprotocol.CANCEL_NODE_USERINPUT_CHANGED
 	case TERM_REASON_NODE_PATTERN_CHANGED:
 		return basicprotocol.CANCEL_NODE_PATTERN_CHANGED
+	case TERM_REASON_HEALTH_CHECK_FAILURE:
+		return basicprotocol.CANCEL_HEALTH_CHECK_FAILURE
+	case TERM_REASON_CANARY_ROLLBACK:
+		return basicprotocol.CANCEL_CANARY_ROLLBACK
 	default:
 		return 999
 	}
@@ -0,0 +1,121 @@
+// +build unit
+
+package producer
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+const (
+	canaryOrg = "myorg"
+	canaryURL = "https://mydomain.com/services/netspeed"
+)
+
+func canaryTestHandler(t *testing.T, canary config.CanaryConfig) (*BaseProducerProtocolHandler, func()) {
+	dir, err := ioutil.TempDir("", "utdb-")
+	if err != nil {
+		t.Fatalf("unable to create the db dir, error %v", err)
+	}
+	db, err := bolt.Open(path.Join(dir, "anax-int.db"), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unable to open the db, error %v", err)
+	}
+
+	cfg := &config.HorizonConfig{Edge: config.Config{Canary: canary}}
+	return &BaseProducerProtocolHandler{name: "test", db: db, config: cfg}, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func newCanaryTestAgreement(t *testing.T, db *bolt.DB, agreementId string, version string) {
+	wi := &persistence.WorkloadInfo{URL: canaryURL, Org: canaryOrg, Version: version}
+	if _, err := persistence.NewEstablishedAgreement(db, "ag", agreementId, "agbot", "proposal", policy.BasicProtocol, 1, persistence.ServiceSpecs{}, "", "", "", "", "", wi, 0); err != nil {
+		t.Fatalf("unable to save agreement %v, error %v", agreementId, err)
+	}
+}
+
+// The first agreement for a new version is its canary once it is accepted. The next ones are rejected while the node
+// runs the old version, and accepted when it no longer does.
+func Test_WorkloadCanary_admission(t *testing.T) {
+
+	w, cleanup := canaryTestHandler(t, config.CanaryConfig{Count: 1})
+	defer cleanup()
+
+	newCanaryTestAgreement(t, w.db, "ag-old", "1.0.0")
+
+	if err := w.CheckWorkloadCanary("ag-new1", canaryOrg, canaryURL, "2.0.0"); err != nil {
+		t.Fatalf("the first agreement for the version should be accepted, error %v", err)
+	} else if canary, _ := persistence.FindWorkloadCanary(w.db, canaryOrg, canaryURL, "2.0.0"); canary != nil {
+		t.Errorf("the check should not start the rollout, it is %v", canary)
+	}
+
+	w.AdmitWorkloadCanary("ag-new1", canaryOrg, canaryURL, "2.0.0")
+	canary, err := persistence.FindWorkloadCanary(w.db, canaryOrg, canaryURL, "2.0.0")
+	if err != nil || canary == nil {
+		t.Fatalf("the rollout should have started, error %v", err)
+	} else if canary.State != persistence.CANARY_STATE_SOAKING || canary.Quota != 1 || canary.Affected != 1 || !canary.IsCanary("ag-new1") {
+		t.Errorf("wrong rollout %v", canary)
+	}
+
+	// the quota is used and the old version still runs
+	if err := w.CheckWorkloadCanary("ag-new2", canaryOrg, canaryURL, "2.0.0"); err == nil {
+		t.Errorf("the agreement should be rejected while the version is soaking")
+	} else if err := w.CheckWorkloadCanary("ag-new1", canaryOrg, canaryURL, "2.0.0"); err != nil {
+		t.Errorf("the canary should still be accepted, error %v", err)
+	}
+
+	// the old version no longer runs, the node would be left without the workload
+	if _, err := persistence.AgreementStateTerminated(w.db, "ag-old", 1, "cancelled", policy.BasicProtocol); err != nil {
+		t.Fatalf("unable to terminate the agreement, error %v", err)
+	}
+	if err := w.CheckWorkloadCanary("ag-new2", canaryOrg, canaryURL, "2.0.0"); err != nil {
+		t.Errorf("the agreement should be accepted when the node runs no other version, error %v", err)
+	}
+	w.AdmitWorkloadCanary("ag-new2", canaryOrg, canaryURL, "2.0.0")
+	if canary, _ := persistence.FindWorkloadCanary(w.db, canaryOrg, canaryURL, "2.0.0"); len(canary.Canaries) != 2 {
+		t.Errorf("the agreement should be one more canary, the canaries are %v", canary.Canaries)
+	}
+
+	// a version that was rolled back is rejected, a promoted one is accepted
+	canary.State = persistence.CANARY_STATE_ROLLED_BACK
+	persistence.SaveWorkloadCanary(w.db, canary)
+	if err := w.CheckWorkloadCanary("ag-new1", canaryOrg, canaryURL, "2.0.0"); err == nil {
+		t.Errorf("a version that was rolled back should be rejected")
+	}
+	canary.State = persistence.CANARY_STATE_PROMOTED
+	persistence.SaveWorkloadCanary(w.db, canary)
+	if err := w.CheckWorkloadCanary("ag-new3", canaryOrg, canaryURL, "2.0.0"); err != nil {
+		t.Errorf("a promoted version should be accepted, error %v", err)
+	}
+}
+
+// A new workload and a node without the canary rollout have no canaries.
+func Test_WorkloadCanary_no_rollout(t *testing.T) {
+
+	w, cleanup := canaryTestHandler(t, config.CanaryConfig{Percent: 50})
+	defer cleanup()
+
+	w.AdmitWorkloadCanary("ag-new1", canaryOrg, canaryURL, "2.0.0")
+	if canary, _ := persistence.FindWorkloadCanary(w.db, canaryOrg, canaryURL, "2.0.0"); canary != nil {
+		t.Errorf("a workload that the node does not run should have no canaries, it has %v", canary)
+	}
+
+	off, cleanupOff := canaryTestHandler(t, config.CanaryConfig{})
+	defer cleanupOff()
+
+	newCanaryTestAgreement(t, off.db, "ag-old", "1.0.0")
+	off.AdmitWorkloadCanary("ag-new1", canaryOrg, canaryURL, "2.0.0")
+	if canary, _ := persistence.FindWorkloadCanary(off.db, canaryOrg, canaryURL, "2.0.0"); canary != nil {
+		t.Errorf("a node without the canary rollout should have no canaries, it has %v", canary)
+	}
+}
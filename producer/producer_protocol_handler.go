@@ -9,6 +9,8 @@ import (
 	"github.com/open-horizon/anax/abstractprotocol"
 	"github.com/open-horizon/anax/api"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/containermessage"
+	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/eventlog"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
@@ -17,6 +19,7 @@ import (
 	"github.com/open-horizon/anax/policy"
 	"github.com/open-horizon/anax/worker"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -26,6 +29,8 @@ const (
 	EL_PROD_NODE_REJECTED_PROPOSAL_MSG = "Node received Proposal message using agreement %v for service %v/%v from the agbot %v."
 	EL_PROD_NODE_REJECTED_PROPOSAL     = "Node rejected the proposal for service %v/%v."
 	EL_PROD_ERR_HANDLE_PROPOSAL        = "Error handling proposal for service %v/%v. Error: %v"
+	EL_PROD_START_WORKLOAD_CANARY      = "Start the canary rollout of version %v of service %v/%v on %v of the %v agreements for other versions."
+	EL_PROD_WORKLOAD_CANARY_ADMITTED   = "Agreement %v is canary %v of %v for version %v of service %v/%v."
 )
 
 // This is does nothing useful at run time.
@@ -42,6 +47,30 @@ func MarkI18nMessages() {
 	msgPrinter.Sprintf(EL_PROD_NODE_REJECTED_PROPOSAL_MSG)
 	msgPrinter.Sprintf(EL_PROD_NODE_REJECTED_PROPOSAL)
 	msgPrinter.Sprintf(EL_PROD_ERR_HANDLE_PROPOSAL)
+	msgPrinter.Sprintf(EL_PROD_START_WORKLOAD_CANARY)
+	msgPrinter.Sprintf(EL_PROD_WORKLOAD_CANARY_ADMITTED)
+}
+
+// The reason that new proposals are rejected, e.g. while the agent is being updated. Empty when they are accepted.
+var proposalsPaused string
+var proposalsPausedLock sync.Mutex
+
+// Reject new proposals until ResumeProposals is called. The agreements that were made are not affected.
+func PauseProposals(reason string) {
+	proposalsPausedLock.Lock()
+	defer proposalsPausedLock.Unlock()
+	proposalsPaused = reason
+}
+
+func ResumeProposals() {
+	PauseProposals("")
+}
+
+// Returns the reason that new proposals are rejected, empty if they are not.
+func ProposalsPaused() string {
+	proposalsPausedLock.Lock()
+	defer proposalsPausedLock.Unlock()
+	return proposalsPaused
 }
 
 func CreateProducerPH(name string, cfg *config.HorizonConfig, db *bolt.DB, pm *policy.PolicyManager, ec exchange.ExchangeContext) ProducerProtocolHandler {
@@ -222,7 +251,11 @@ func (w *BaseProducerProtocolHandler) HandleProposal(ph abstractprotocol.Protoco
 
 		err_log_event := ""
 
-		if err := w.saveSigningKeys(tcPolicy); err != nil {
+		if reason := ProposalsPaused(); reason != "" {
+			glog.Warningf(BPPHlogString(w.Name(), fmt.Sprintf("not accepting new agreements, ignoring proposal: %v", reason)))
+			err_log_event = fmt.Sprintf("Node is not accepting new agreements: %v", reason)
+			handled = true
+		} else if err := w.saveSigningKeys(tcPolicy); err != nil {
 			glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("received error handling signing keys from the exchange: %v", err)))
 			err_log_event = fmt.Sprintf("Received error handling signing keys from the exchange: %v", err)
 			handled = true
@@ -258,6 +291,22 @@ func (w *BaseProducerProtocolHandler) HandleProposal(ph abstractprotocol.Protoco
 			glog.Errorf(BPPHlogString(w.Name(), "pattern name matching failed, ignoring proposal"))
 			err_log_event = "Pattern name matching failed, ignoring proposal"
 			handled = true
+		} else if err := w.CheckWorkloadHostAccess(tcPolicy); err != nil {
+			glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("device and host path check failed, ignoring proposal: %v", err)))
+			err_log_event = fmt.Sprintf("Node cannot provide the devices or host paths required by the workload: %v", err)
+			handled = true
+		} else if err := w.CheckWorkloadPorts(tcPolicy); err != nil {
+			glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("port policy check failed, ignoring proposal: %v", err)))
+			err_log_event = fmt.Sprintf("Node does not allow the ports published by the workload: %v", err)
+			handled = true
+		} else if err := w.CheckWorkloadKubeScope(tcPolicy); err != nil {
+			glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("kubernetes scope check failed, ignoring proposal: %v", err)))
+			err_log_event = fmt.Sprintf("Node cannot run the cluster workload within its Kubernetes scope: %v", err)
+			handled = true
+		} else if err := w.CheckWorkloadCPUPinning(tcPolicy); err != nil {
+			glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("cpu pinning check failed, ignoring proposal: %v", err)))
+			err_log_event = fmt.Sprintf("Node cannot provide the cpu pinning required by the workload: %v", err)
+			handled = true
 		} else if ag, found, err := w.FindAgreementWithSameWorkload(ph, tcPolicy.Header.Name); err != nil {
 			glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("error finding agreement with TsAndCs name '%v', error %v", tcPolicy.Header.Name, err)))
 			err_log_event = fmt.Sprintf("Error finding agreement with TsAndCs (Terms And Conditions) name '%v', error %v", tcPolicy.Header.Name, err)
@@ -275,6 +324,14 @@ func (w *BaseProducerProtocolHandler) HandleProposal(ph abstractprotocol.Protoco
 				proposal.ConsumerId(),
 				proposal.Protocol())
 			handled = true
+		} else if err := cutil.CheckDiskSpace(w.config); err != nil {
+			glog.Warningf(BPPHlogString(w.Name(), fmt.Sprintf("disk space check failed, ignoring proposal: %v", err)))
+			err_log_event = fmt.Sprintf("Node does not have enough disk space for a new agreement: %v", err)
+			handled = true
+		} else if err := w.CheckWorkloadCanary(proposal.AgreementId(), worg, wls, wversion); err != nil {
+			glog.Warningf(BPPHlogString(w.Name(), fmt.Sprintf("canary rollout check failed, ignoring proposal: %v", err)))
+			err_log_event = fmt.Sprintf("Node is not accepting the workload version yet: %v", err)
+			handled = true
 		} else if messageTarget, err := exchange.CreateMessageTarget(exchangeMsg.AgbotId, nil, exchangeMsg.AgbotPubKey, ""); err != nil {
 			glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("error creating message target: %v", err)))
 			err_log_event = fmt.Sprintf("Error creating message target: %v", err)
@@ -303,6 +360,8 @@ func (w *BaseProducerProtocolHandler) HandleProposal(ph abstractprotocol.Protoco
 						ConvertToServiceSpecs(tcPolicy.APISpecs),
 						proposal.ConsumerId(),
 						proposal.Protocol())
+				} else {
+					w.AdmitWorkloadCanary(proposal.AgreementId(), worg, wls, wversion)
 				}
 				return handled, r, tcPolicy
 			}
@@ -324,10 +383,307 @@ func (w *BaseProducerProtocolHandler) HandleProposal(ph abstractprotocol.Protoco
 	return handled, nil, nil
 }
 
+// Verify that the host devices and host paths requested by the workload's deployment config are allowed by the node's
+// host access allow list, that the devices are present on this node, and that the container runtimes it selects are
+// allowed by the node configuration. Deployment configs that are not native docker
+// deployments (e.g. cluster deployments) are not checked.
+func (w *BaseProducerProtocolHandler) CheckWorkloadHostAccess(pol *policy.Policy) error {
+	live := w.config.LiveEdge()
+	allowList, err := persistence.GetHostAccessAllowList(w.db, live.DeviceAllowList, live.HostPathAllowList)
+	if err != nil {
+		return fmt.Errorf("unable to read the host access allow list, %v", err)
+	}
+
+	for _, wl := range pol.Workloads {
+		if wl.Deployment == "" {
+			continue
+		}
+		dd, err := containermessage.GetNativeDeployment(wl.Deployment)
+		if err != nil {
+			glog.V(5).Infof(BPPHlogString(w.Name(), fmt.Sprintf("skipping device check for workload %v/%v, %v", wl.Org, wl.WorkloadURL, err)))
+			continue
+		}
+		if err := dd.CheckDevices(allowList.Devices); err != nil {
+			return fmt.Errorf("workload %v/%v %v, %v", wl.Org, wl.WorkloadURL, wl.Version, err)
+		} else if err := dd.CheckBinds(allowList.HostPaths); err != nil {
+			return fmt.Errorf("workload %v/%v %v, %v", wl.Org, wl.WorkloadURL, wl.Version, err)
+		} else if err := dd.CheckRuntime(live.RuntimeAllowList); err != nil {
+			return fmt.Errorf("workload %v/%v %v, %v", wl.Org, wl.WorkloadURL, wl.Version, err)
+		}
+	}
+	return nil
+}
+
+// Verify that the host ports published by the workload's deployment config are allowed by the node's port policy.
+// Without a port policy no host port can be published. Deployment configs that are not native docker deployments are
+// not checked.
+func (w *BaseProducerProtocolHandler) CheckWorkloadPorts(pol *policy.Policy) error {
+	portPolicy, err := persistence.FindEffectivePortPolicy(w.db)
+	if err != nil {
+		return fmt.Errorf("unable to read the port policy, %v", err)
+	}
+
+	for _, wl := range pol.Workloads {
+		if wl.Deployment == "" {
+			continue
+		}
+		dd, err := containermessage.GetNativeDeployment(wl.Deployment)
+		if err != nil {
+			continue
+		}
+		if err := portPolicy.CheckDeployment(dd); err != nil {
+			return fmt.Errorf("workload %v/%v %v, %v", wl.Org, wl.WorkloadURL, wl.Version, err)
+		}
+	}
+	return nil
+}
+
+// Verify that the cluster workload of the workload's cluster deployment config is deployed to a namespace that the
+// node's Kubernetes scope allows, and that its resource limits are within the quota. Operators are not checked, the
+// namespaces and resources they use are up to them.
+func (w *BaseProducerProtocolHandler) CheckWorkloadKubeScope(pol *policy.Policy) error {
+	scope := &w.config.Edge.KubeScope
+
+	for _, wl := range pol.Workloads {
+		if wl.ClusterDeployment == "" {
+			continue
+		}
+		kd, err := persistence.GetKubeDeployment(wl.ClusterDeployment)
+		if err != nil || !kd.IsWorkload() {
+			continue
+		}
+
+		if namespace := kd.Workload.Namespace; namespace != "" && !scope.AllowsNamespace(namespace) {
+			return fmt.Errorf("workload %v/%v %v, namespace %v is not one of the namespaces %v", wl.Org, wl.WorkloadURL, wl.Version, namespace, scope.Namespaces)
+		}
+		// a service without a limit could use all of the cluster, it cannot be within the quota
+		unboundedCPUs, unboundedMemory := kd.Workload.UnboundedServices(scope.DefaultLimits.CPUs, scope.DefaultLimits.MemoryMb)
+		if scope.Quota.CPUs != 0 && len(unboundedCPUs) != 0 {
+			return fmt.Errorf("workload %v/%v %v, services %v have no cpu limit and there is no default limit, they cannot be within the quota of %v cpus", wl.Org, wl.WorkloadURL, wl.Version, unboundedCPUs, scope.Quota.CPUs)
+		} else if scope.Quota.MemoryMb != 0 && len(unboundedMemory) != 0 {
+			return fmt.Errorf("workload %v/%v %v, services %v have no memory limit and there is no default limit, they cannot be within the quota of %v MB", wl.Org, wl.WorkloadURL, wl.Version, unboundedMemory, scope.Quota.MemoryMb)
+		}
+		cpus, memoryMb := kd.Workload.ResourceLimits(scope.DefaultLimits.CPUs, scope.DefaultLimits.MemoryMb)
+		if exceeded := scope.ExceedsQuota(cpus, memoryMb); exceeded != "" {
+			return fmt.Errorf("workload %v/%v %v, %v", wl.Org, wl.WorkloadURL, wl.Version, exceeded)
+		}
+	}
+	return nil
+}
+
+// Verify that the cpus the workload's deployment config pins its containers to are online and allowed by the node
+// configuration, and that they are not pinned by another agreement or service. An agreement for the same workload is
+// being replaced, so its cpus are not considered. Deployment configs that are not native docker deployments are not
+// checked.
+func (w *BaseProducerProtocolHandler) CheckWorkloadCPUPinning(pol *policy.Policy) error {
+	for _, wl := range pol.Workloads {
+		if wl.Deployment == "" {
+			continue
+		}
+		dd, err := containermessage.GetNativeDeployment(wl.Deployment)
+		if err != nil {
+			continue
+		}
+
+		pinned := false
+		for _, service := range dd.Services {
+			pinned = pinned || service.CPUSet != "" || service.CPURealtimeRuntime != 0
+		}
+		if !pinned {
+			continue
+		}
+
+		live := w.config.LiveEdge()
+		online, err := containermessage.OnlineCPUs("")
+		if err != nil {
+			return err
+		} else if err := dd.CheckCPUPinning(online, live.CPUSetAllowList, live.MaxCPURealtimeRuntime); err != nil {
+			return fmt.Errorf("workload %v/%v %v, %v", wl.Org, wl.WorkloadURL, wl.Version, err)
+		}
+
+		inUse, err := w.pinnedCPUSets(wl.WorkloadURL, wl.Org)
+		if err != nil {
+			return err
+		}
+		for serviceName, service := range dd.Services {
+			for owner, cpuset := range inUse {
+				if overlap := containermessage.CPUSetOverlap(service.CPUSet, cpuset); len(overlap) != 0 {
+					return fmt.Errorf("workload %v/%v %v, service %v: cpus %v of cpuset %v are already pinned by %v", wl.Org, wl.WorkloadURL, wl.Version, serviceName, overlap, service.CPUSet, owner)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Serializes the changes to the workload canaries, so that no more canaries are admitted than the quota allows.
+var canaryLock sync.Mutex
+
+// The governance worker holds the lock while it decides on a canary rollout, so that no canary is admitted meanwhile.
+func LockWorkloadCanaries() {
+	canaryLock.Lock()
+}
+
+func UnlockWorkloadCanaries() {
+	canaryLock.Unlock()
+}
+
+// Verify that an agreement can move to the workload version, according to the canary rollout settings of the node.
+// While a new version is soaking, only its canaries move to it. Another agreement is rejected while the node still
+// runs the workload in an agreement for another version, so that the workload keeps running during the soak. When the
+// node no longer runs any version of the workload, e.g. because the agbot cancelled its agreements before proposing
+// the new version, the agreement is accepted as one more canary: rejecting it would leave the node without the
+// workload. Versions whose canaries failed are rejected. The check changes nothing, the canary is admitted by
+// AdmitWorkloadCanary once the proposal is accepted.
+func (w *BaseProducerProtocolHandler) CheckWorkloadCanary(agreementId string, org string, url string, version string) error {
+	if live := w.config.LiveEdge(); !live.Canary.Enabled() || url == "" {
+		return nil
+	}
+
+	canaryLock.Lock()
+	defer canaryLock.Unlock()
+
+	canary, err := persistence.FindWorkloadCanary(w.db, org, url, version)
+	if err != nil {
+		return fmt.Errorf("unable to read the canary rollout of version %v of %v/%v, %v", version, org, url, err)
+	} else if canary == nil {
+		// the first agreement for the version is its first canary
+		return nil
+	}
+
+	switch canary.State {
+	case persistence.CANARY_STATE_PROMOTED:
+		return nil
+	case persistence.CANARY_STATE_ROLLED_BACK:
+		return fmt.Errorf("version %v of %v/%v was rolled back on this node, %v", version, org, url, canary.Reason)
+	}
+
+	if canary.IsCanary(agreementId) || len(canary.Canaries) < canary.Quota {
+		return nil
+	} else if running, err := w.runningAgreementsForOtherVersions(org, url, version); err != nil {
+		return err
+	} else if running != 0 {
+		return fmt.Errorf("version %v of %v/%v is soaking on %v canary agreements, the node runs %v agreements for other versions meanwhile", version, org, url, len(canary.Canaries), running)
+	}
+	return nil
+}
+
+// Record the agreement of an accepted proposal as a canary of the workload version, when the version is soaking. The
+// canary rollout of the version starts with its first agreement, when the node has agreements for other versions of
+// the workload. A workload that the node does not run yet has nothing to protect and has no canaries.
+func (w *BaseProducerProtocolHandler) AdmitWorkloadCanary(agreementId string, org string, url string, version string) {
+	live := w.config.LiveEdge()
+	if !live.Canary.Enabled() || url == "" {
+		return
+	}
+
+	canaryLock.Lock()
+	defer canaryLock.Unlock()
+
+	canary, err := persistence.FindWorkloadCanary(w.db, org, url, version)
+	if err != nil {
+		glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("unable to read the canary rollout of version %v of %v/%v, %v", version, org, url, err)))
+		return
+	} else if canary == nil {
+		affected, err := w.agreementsForOtherVersions(org, url, version)
+		if err != nil {
+			glog.Errorf(BPPHlogString(w.Name(), err.Error()))
+			return
+		} else if affected == 0 {
+			return
+		}
+		canary = persistence.NewWorkloadCanary(org, url, version, affected, live.Canary.Canaries(affected), uint64(time.Now().Unix()))
+		glog.V(3).Infof(BPPHlogString(w.Name(), fmt.Sprintf("starting the canary rollout %v", canary)))
+		w.logCanaryEvent(persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_PROD_START_WORKLOAD_CANARY, version, org, url, canary.Quota, affected), persistence.EC_START_WORKLOAD_CANARY)
+	}
+
+	if canary.State != persistence.CANARY_STATE_SOAKING || canary.IsCanary(agreementId) {
+		return
+	}
+
+	canary.Canaries = append(canary.Canaries, agreementId)
+	if err := persistence.SaveWorkloadCanary(w.db, canary); err != nil {
+		glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("unable to save the canary rollout of version %v of %v/%v, %v", version, org, url, err)))
+		return
+	}
+	w.logCanaryEvent(persistence.SEVERITY_INFO, persistence.NewMessageMeta(EL_PROD_WORKLOAD_CANARY_ADMITTED, agreementId, len(canary.Canaries), canary.Quota, version, org, url), persistence.EC_WORKLOAD_CANARY_ADMITTED)
+}
+
+// Returns the number of active agreements for other versions of the workload.
+func (w *BaseProducerProtocolHandler) agreementsForOtherVersions(org string, url string, version string) (int, error) {
+	return w.countAgreementsForOtherVersions(org, url, version, false)
+}
+
+// Returns the number of agreements for other versions of the workload that are not terminated.
+func (w *BaseProducerProtocolHandler) runningAgreementsForOtherVersions(org string, url string, version string) (int, error) {
+	return w.countAgreementsForOtherVersions(org, url, version, true)
+}
+
+func (w *BaseProducerProtocolHandler) countAgreementsForOtherVersions(org string, url string, version string, running bool) (int, error) {
+	ags, err := persistence.FindEstablishedAgreementsAllProtocols(w.db, policy.AllAgreementProtocols(), []persistence.EAFilter{persistence.UnarchivedEAFilter()})
+	if err != nil {
+		return 0, fmt.Errorf("unable to retrieve agreements from database, error %v", err)
+	}
+	n := 0
+	for _, ag := range ags {
+		if running && ag.AgreementTerminatedTime != 0 {
+			continue
+		} else if ag.RunningWorkload.Org == org && cutil.SameSpecURL(ag.RunningWorkload.URL, url) && ag.RunningWorkload.Version != version {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (w *BaseProducerProtocolHandler) logCanaryEvent(severity string, meta *persistence.MessageMeta, code string) {
+	if dev, err := persistence.FindExchangeDevice(w.db); err != nil || dev == nil {
+		glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("unable to log the canary rollout event, the node cannot be read: %v", err)))
+	} else {
+		eventlog.LogNodeEvent(w.db, severity, meta, code, dev.Id, dev.Org, dev.Pattern, dev.Config.State)
+	}
+}
+
+// Returns the cpusets that the containers of the active agreements and service instances are pinned to, keyed by
+// container or service name. The agreements for the given workload are skipped.
+func (w *BaseProducerProtocolHandler) pinnedCPUSets(workloadURL string, org string) (map[string]string, error) {
+	inUse := make(map[string]string)
+
+	ags, err := persistence.FindEstablishedAgreementsAllProtocols(w.db, policy.AllAgreementProtocols(), []persistence.EAFilter{persistence.UnarchivedEAFilter()})
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve agreements from database, error %v", err)
+	}
+	for _, ag := range ags {
+		if ag.AgreementTerminatedTime != 0 || (cutil.SameSpecURL(ag.RunningWorkload.URL, workloadURL) && ag.RunningWorkload.Org == org) {
+			continue
+		}
+		for name, sc := range ag.CurrentDeployment {
+			if sc.HostConfig.CPUSetCPUs != "" {
+				inUse[fmt.Sprintf("agreement %v container %v", ag.CurrentAgreementId, name)] = sc.HostConfig.CPUSetCPUs
+			}
+		}
+	}
+
+	msinsts, err := persistence.FindMicroserviceInstances(w.db, []persistence.MIFilter{persistence.UnarchivedMIFilter()})
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve service instances from database, error %v", err)
+	}
+	for _, msinst := range msinsts {
+		if msinst.CleanupStartTime != 0 {
+			continue
+		}
+		for name, cp := range msinst.CPUPinning {
+			if cp.CPUSet != "" {
+				inUse[fmt.Sprintf("service %v/%v container %v", msinst.Org, msinst.SpecRef, name)] = cp.CPUSet
+			}
+		}
+	}
+	return inUse, nil
+}
+
 // This function gets the pattern and workload's signing keys and save them to anax
 func (w *BaseProducerProtocolHandler) saveSigningKeys(pol *policy.Policy) error {
 	// do nothing if the config does not allow using the certs from the org on the exchange
-	if !w.config.Edge.TrustCertUpdatesFromOrg {
+	if !w.config.LiveEdge().TrustCertUpdatesFromOrg {
 		return nil
 	}
 
@@ -599,6 +955,8 @@ const TERM_REASON_NODE_SHUTDOWN = "NodeShutdown"
 const TERM_REASON_SERVICE_SUSPENDED = "ServiceSuspended"
 const TERM_REASON_NODE_USERINPUT_CHANGED = "NodeUserInputChanged"
 const TERM_REASON_NODE_PATTERN_CHANGED = "NodePatternChanged"
+const TERM_REASON_HEALTH_CHECK_FAILURE = "HealthCheckFailure"
+const TERM_REASON_CANARY_ROLLBACK = "CanaryRollback"
 
 // ==============================================================================================================
 type ExchangeMessageCommand struct {
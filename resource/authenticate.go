@@ -7,6 +7,7 @@ import (
 	"github.com/open-horizon/edge-sync-service/core/security"
 	"net/http"
 	"strings"
+	"sync"
 )
 
 // FSSAuthenticate is the plugin for authenticating FSS (ESS) API calls from a service to anax.
@@ -17,6 +18,14 @@ type FSSAuthenticate struct {
 	nodeID    string
 	nodeToken string
 	AuthMgr   *AuthenticationManager
+	lock      sync.Mutex // the node token is rotated while the ESS uses it
+}
+
+// Use the new token of the node to access the CSS, e.g. after it was rotated.
+func (auth *FSSAuthenticate) setNodeToken(token string) {
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+	auth.nodeToken = token
 }
 
 // Start initializes the HorizonAuthenticate plugin.
@@ -83,8 +92,11 @@ func (auth *FSSAuthenticate) KeyandSecretForURL(url string) (string, string) {
 
 	if strings.HasPrefix(url, common.HTTPCSSURL) {
 		id := common.Configuration.OrgID + "/" + common.Configuration.DestinationType + "/" + common.Configuration.DestinationID
-		glog.V(6).Infof(essALS(fmt.Sprintf("returning credentials %v %v", id, auth.nodeToken)))
-		return id, auth.nodeToken
+		auth.lock.Lock()
+		token := auth.nodeToken
+		auth.lock.Unlock()
+		glog.V(6).Infof(essALS(fmt.Sprintf("returning credentials %v %v", id, token)))
+		return id, token
 	}
 
 	return "", ""
@@ -2,6 +2,7 @@ package resource
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -141,6 +142,23 @@ func (a *AuthenticationManager) Authenticate(authId string, appSecret string) (b
 	return false, "", nil
 }
 
+// Verify that the input credentials are the ones created for key, e.g. the ones of the containers of an agreement.
+// Returns false when there are none for key.
+func (a *AuthenticationManager) AuthenticateKey(key string, authId string, appSecret string) (bool, error) {
+	authFileName := path.Join(a.GetCredentialPath(key), config.HZN_FSS_AUTH_FILE)
+	if credBytes, err := ioutil.ReadFile(authFileName); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.New(fmt.Sprintf("unable to read auth file %v, error: %v", authFileName, err))
+	} else {
+		authObj := new(AuthenticationCredential)
+		if err := json.Unmarshal(credBytes, authObj); err != nil {
+			return false, errors.New(fmt.Sprintf("unable to demarshal auth file %v, error: %v", authFileName, err))
+		}
+		return authObj.Id == authId && subtle.ConstantTimeCompare([]byte(authObj.Token), []byte(appSecret)) == 1, nil
+	}
+}
+
 // Remove a container authentication credential from the Agent's host file system.
 func (a *AuthenticationManager) RemoveCredential(key string) error {
 	if err := os.RemoveAll(a.GetCredentialPath(key)); err != nil {
@@ -42,3 +42,22 @@ func NewNodeUnconfigCommand(msg *events.NodeShutdownCompleteMessage) *NodeUnconf
 		msg: msg,
 	}
 }
+
+// This worker command is used to tell the worker that the token of the node was rotated.
+type NodeTokenCommand struct {
+	msg *events.NodeTokenMessage
+}
+
+func (n NodeTokenCommand) String() string {
+	return n.ShortString()
+}
+
+func (n NodeTokenCommand) ShortString() string {
+	return fmt.Sprintf("NodeToken Command, Org: %v, DeviceId: %v", n.msg.Org(), n.msg.DeviceId())
+}
+
+func NewNodeTokenCommand(msg *events.NodeTokenMessage) *NodeTokenCommand {
+	return &NodeTokenCommand{
+		msg: msg,
+	}
+}
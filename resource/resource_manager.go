@@ -24,6 +24,7 @@ type ResourceManager struct {
 	pattern string
 	id      string
 	token   string
+	auth    *FSSAuthenticate // the authenticator of the embedded ESS, once it is started
 }
 
 func NewResourceManager(cfg *config.HorizonConfig, org string, pattern string, id string, token string) *ResourceManager {
@@ -50,6 +51,14 @@ func (r *ResourceManager) NodeConfigUpdate(org string, pattern string, id string
 	r.token = token
 }
 
+// Change the token of the node after it was rotated, the embedded ESS uses it from now on to access the CSS.
+func (r *ResourceManager) NodeTokenUpdate(token string) {
+	r.token = token
+	if r.auth != nil {
+		r.auth.setNodeToken(token)
+	}
+}
+
 func (r ResourceManager) String() string {
 	return fmt.Sprintf("ResourceManager: Org %v"+
 		", Pattern: %v"+
@@ -58,7 +67,7 @@ func (r ResourceManager) String() string {
 		r.org, r.pattern, r.id, r.token)
 }
 
-func (r ResourceManager) StartFileSyncService(am *AuthenticationManager) error {
+func (r *ResourceManager) StartFileSyncService(am *AuthenticationManager) error {
 
 	// Generate a self signed certificate to be used for TLS between a service and the embedded ESS API.
 	// The SSL private key is stored in a different location from the certificate so that the services
@@ -149,7 +158,8 @@ func (r ResourceManager) StartFileSyncService(am *AuthenticationManager) error {
 	censorAndDumpConfig()
 
 	// Set the authenticator that we're going to use.
-	security.SetAuthentication(&FSSAuthenticate{nodeOrg: r.org, nodeID: r.id, nodeToken: r.token, AuthMgr: am})
+	r.auth = &FSSAuthenticate{nodeOrg: r.org, nodeID: r.id, nodeToken: r.token, AuthMgr: am}
+	security.SetAuthentication(r.auth)
 
 	// Start the embedded ESS.
 	if err := base.Start("", true); err != nil {
@@ -77,6 +77,11 @@ func (w *ResourceWorker) NewEvent(incoming events.Message) {
 		w.EC = worker.NewExchangeContext(fmt.Sprintf("%v/%v", msg.Org(), msg.DeviceId()), msg.Token(), w.Config.Edge.ExchangeURL, w.Config.GetCSSURL(), w.Config.Collaborators.HTTPClientFactory)
 		w.Commands <- NewNodeConfigCommand(msg)
 
+	case *events.NodeTokenMessage:
+		msg, _ := incoming.(*events.NodeTokenMessage)
+		w.EC = worker.NewExchangeContext(fmt.Sprintf("%v/%v", msg.Org(), msg.DeviceId()), msg.Token(), w.Config.Edge.ExchangeURL, w.Config.GetCSSURL(), w.Config.Collaborators.HTTPClientFactory)
+		w.Commands <- NewNodeTokenCommand(msg)
+
 	case *events.NodeShutdownCompleteMessage:
 		msg, _ := incoming.(*events.NodeShutdownCompleteMessage)
 		switch msg.Event().Id {
@@ -101,6 +106,10 @@ func (w *ResourceWorker) CommandHandler(command worker.Command) bool {
 			glog.Errorf(reslog(fmt.Sprintf("Error handling node config command: %v", err)))
 		}
 
+	case *NodeTokenCommand:
+		cmd, _ := command.(*NodeTokenCommand)
+		w.rm.NodeTokenUpdate(cmd.msg.Token())
+
 	case *NodeUnconfigCommand:
 		cmd, _ := command.(*NodeUnconfigCommand)
 		if err := w.handleNodeUnconfigCommand(cmd); err != nil {
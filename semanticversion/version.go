@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/i18n"
-	"strconv"
 	"strings"
 )
 
@@ -20,7 +19,9 @@ import (
 // '(' following version is excluded from the range
 // '[' following version is included in the range
 //
-// <version> is a string of x or x.y or x.y.z
+// <version> is a string of x or x.y or x.y.z or x.y.z.w, optionally followed by build metadata
+// such as +git.abc. The build metadata is ignored when versions are compared, and a missing
+// segment is 0, so 1.2 and 1.2.0.0 are the same version.
 //
 // <right-spec> if specified is one of:
 // ')' previous version is excluded from the range
@@ -42,6 +43,11 @@ const rightInc = "]"
 const INF = "INFINITY"
 const versionSeperator = ","
 const numberSeperator = "."
+const buildSeperator = "+"
+const maxSegments = 4
+
+// Returned when two version ranges have no version in common.
+var ErrNoIntersection = errors.New("No intersection found.")
 
 type Version_Expression struct {
 	full_expression string
@@ -183,52 +189,38 @@ func (self *Version_Expression) Is_within_range(expr string) (bool, error) {
 		return false, errors.New(errorString)
 	}
 
-	normalizedExpr := normalize(expr)
+	cs, _ := CompareVersions(expr, self.start)
+	ce, _ := CompareVersions(expr, self.end)
 
 	// Exit early in the easy cases
-	if (normalizedExpr == self.start && self.start_inclusive) || (normalizedExpr == self.end && self.end_inclusive) {
+	if (cs == 0 && self.start_inclusive) || (ce == 0 && self.end_inclusive) {
 		return true, nil
-	} else if (normalizedExpr == self.start && !self.start_inclusive) || (normalizedExpr == self.end && !self.end_inclusive) {
+	} else if cs == 0 || ce == 0 {
 		return false, nil
 	}
 
-	// Compare the start version to see if the input is in this object's range
-	exprNums := strings.Split(normalizedExpr, numberSeperator)
-	startNums := strings.Split(self.start, numberSeperator)
-	for idx, startVal := range startNums {
-		startInt, _ := strconv.Atoi(startVal)
-		exprInt, _ := strconv.Atoi(exprNums[idx])
-		if startInt == exprInt {
-			continue
-		} else if startInt < exprInt {
-			break
-		} else {
-			return false, nil
-		}
-	}
+	// The input is in this object's range when it is above the start and below the end. An end
+	// range of "INFINITY" is above every version.
+	return cs > 0 && ce < 0, nil
+}
 
-	// Compare the end version to see if the input is in this object's range. An end range of
-	// "INFINITY" will always be in range.
-	if self.end == INF {
-		return true, nil
-	}
+// Return true if the version is in this object's range. Unlike Is_within_range, a range with the same start and end
+// only contains that version when both ends are inclusive.
+func (self *Version_Expression) contains(version string) bool {
+	cs, _ := CompareVersions(version, self.start)
+	ce, _ := CompareVersions(version, self.end)
+	aboveStart := cs > 0 || (cs == 0 && self.start_inclusive)
+	belowEnd := ce < 0 || (ce == 0 && self.end_inclusive)
+	return aboveStart && belowEnd
+}
 
-	endNums := strings.Split(self.end, numberSeperator)
-	for idx, endVal := range endNums {
-		endInt, _ := strconv.Atoi(endVal)
-		exprInt, _ := strconv.Atoi(exprNums[idx])
-		if endInt == exprInt {
-			continue
-		} else if endInt > exprInt {
-			return true, nil
-		} else {
-			return false, nil
-		}
+// Return true if no version is in this object's range.
+func (self *Version_Expression) isEmpty() bool {
+	if self.end == INF {
+		return false
 	}
-
-	// Should never get here
-	errorString := fmt.Sprintf("Version_Expression: Unable to compare versions %v %v.", expr, self)
-	return false, errors.New(errorString)
+	c, _ := CompareVersions(self.start, self.end)
+	return c > 0 || (c == 0 && (!self.start_inclusive || !self.end_inclusive))
 }
 
 // make this version equals to the intersection of self and the given version
@@ -264,10 +256,10 @@ func (self *Version_Expression) IntersectsWith(other *Version_Expression) error
 			return err
 		} else if c == 0 {
 			if !self.start_inclusive && !self.end_inclusive {
-				return fmt.Errorf("No intersection found.")
+				return ErrNoIntersection
 			}
 		} else if c == 1 {
-			return fmt.Errorf("No intersection found.")
+			return ErrNoIntersection
 		}
 	}
 
@@ -276,6 +268,16 @@ func (self *Version_Expression) IntersectsWith(other *Version_Expression) error
 	return nil
 }
 
+// Return the intersection of this version range and the other one as a new version range, neither one is changed.
+// Returns ErrNoIntersection if the ranges have no version in common.
+func (self *Version_Expression) Intersection(other *Version_Expression) (*Version_Expression, error) {
+	intersection := *self
+	if err := intersection.IntersectsWith(other); err != nil {
+		return nil, err
+	}
+	return &intersection, nil
+}
+
 // change the ceiling of this version range.
 func (self *Version_Expression) ChangeCeiling(ceiling_version string, inclusive bool) error {
 
@@ -358,28 +360,54 @@ func IsVersionString(expr string) bool {
 		return true
 	}
 
+	return versionSegments(expr) != nil
+}
+
+// Return the numeric segments of the input version string, without its build metadata. Returns nil if the
+// input is not a valid version string.
+func versionSegments(expr string) []string {
+
+	if i := strings.Index(expr, buildSeperator); i != -1 {
+		if !isBuildMetadata(expr[i+1:]) {
+			return nil
+		}
+		expr = expr[:i]
+	}
+
 	nums := strings.Split(expr, numberSeperator)
-	if len(nums) == 0 || len(nums) > 3 {
-		return false
-	} else {
-		for _, val := range nums {
-			if val == "" {
-				return false
-			} else if len(val) > 1 { // not allow the leadng 0s.
-				if s := strings.TrimLeft(val, "0"); s != val {
-					return false
-				}
-			}
+	if len(nums) > maxSegments {
+		return nil
+	}
+
+	for _, val := range nums {
+		if val == "" {
+			return nil
+		} else if len(val) > 1 && val[0] == '0' { // not allow the leadng 0s.
+			return nil
+		}
 
-			for _, val2 := range val {
-				if !strings.Contains("0123456789", string(val2)) {
-					return false
-				}
+		for _, val2 := range val {
+			if val2 < '0' || val2 > '9' {
+				return nil
 			}
+		}
+	}
+	return nums
+}
 
+// Return true if the input is valid build metadata, dot separated identifiers of letters, digits and hyphens.
+func isBuildMetadata(meta string) bool {
+	for _, ident := range strings.Split(meta, numberSeperator) {
+		if ident == "" {
+			return false
+		}
+		for _, c := range ident {
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-') {
+				return false
+			}
 		}
-		return true
 	}
+	return true
 }
 
 // Return true if the input version string is a full version expression
@@ -411,18 +439,21 @@ func IsVersionExpression(expr string) bool {
 	return true
 }
 
-// Return a normalized version string containing all 3 version numbers. The input version string is ASSUMED to
-// be a valid version string. For example, an input version string of 1 will be returned as 1.0.0
+// Return a normalized version string containing at least 3 version numbers and no build metadata. The input
+// version string is ASSUMED to be a valid version string. For example, an input version string of 1 will be returned
+// as 1.0.0, and 1.2.3.0+git.abc as 1.2.3
 func normalize(expr string) string {
 	if expr == INF {
 		return expr
 	}
-	result := expr
-	nums := strings.Split(expr, numberSeperator)
-	if len(nums) < 3 {
-		result += strings.Repeat(".0", 3-len(nums))
+	nums := versionSegments(expr)
+	if len(nums) == maxSegments && nums[maxSegments-1] == "0" {
+		nums = nums[:maxSegments-1]
 	}
-	return result
+	for len(nums) < 3 {
+		nums = append(nums, "0")
+	}
+	return strings.Join(nums, numberSeperator)
 }
 
 // Return 1 if the input version v1 is higher than v2
@@ -449,28 +480,74 @@ func CompareVersions(v1 string, v2 string) (int, error) {
 		return -1, nil
 	}
 
-	// make each has 3 fields
-	v1n := normalize(v1)
-	v2n := normalize(v2)
-
-	// convert each field into integer and then compare
-	v1s := strings.Split(v1n, numberSeperator)
-	v2s := strings.Split(v2n, numberSeperator)
+	// compare each field, a missing field is 0
+	v1s := versionSegments(v1)
+	v2s := versionSegments(v2)
 
-	for i := 0; i < 3; i++ {
-		if v1s[i] == v2s[i] {
-			continue
+	for i := 0; i < maxSegments; i++ {
+		n1, n2 := "0", "0"
+		if i < len(v1s) {
+			n1 = v1s[i]
+		}
+		if i < len(v2s) {
+			n2 = v2s[i]
 		}
 
-		n1, _ := strconv.Atoi(v1s[i])
-		n2, _ := strconv.Atoi(v2s[i])
-
-		if n1 < n2 {
-			return -1, nil
-		} else if n1 > n2 {
+		// the numbers have no leading 0s, so the longer one is higher. This also works for numbers too big for an int.
+		if len(n1) != len(n2) {
+			if len(n1) < len(n2) {
+				return -1, nil
+			}
 			return 1, nil
+		} else if c := strings.Compare(n1, n2); c != 0 {
+			return c, nil
 		}
 	}
 
 	return 0, nil
 }
+
+// Return the canonical form of the input version or version range, e.g. [1.2.0,INFINITY) for 1.2. Version ranges
+// that contain the same versions have the same canonical form.
+func CanonicalVersionRange(expr string) (string, error) {
+	ve, err := Version_Expression_Factory(expr)
+	if err != nil {
+		return "", err
+	}
+	return ve.Get_expression(), nil
+}
+
+// Return the canonical form of the intersection of the two version ranges, and true. When the ranges have no version
+// in common, the intersection is empty and false is returned. An error is returned if either input is not a version
+// or version range.
+func IntersectVersionRanges(r1 string, r2 string) (string, bool, error) {
+	ve1, err := Version_Expression_Factory(r1)
+	if err != nil {
+		return "", false, err
+	}
+	ve2, err := Version_Expression_Factory(r2)
+	if err != nil {
+		return "", false, err
+	}
+
+	// IntersectsWith keeps a range with the same start and end when one of its ends is inclusive, it has no version.
+	if intersection, err := ve1.Intersection(ve2); err == ErrNoIntersection || (err == nil && intersection.isEmpty()) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	} else {
+		return intersection.Get_expression(), true, nil
+	}
+}
+
+// Return true if the version is in the version range. A single version as the range means that version or any higher
+// one. A range with the same start and end only contains that version when both ends are inclusive.
+func VersionInRange(version string, r string) (bool, error) {
+	ve, err := Version_Expression_Factory(r)
+	if err != nil {
+		return false, err
+	} else if !IsVersionString(version) {
+		return false, fmt.Errorf("Version_Expression: %v is not a valid version string.", version)
+	}
+	return ve.contains(version), nil
+}
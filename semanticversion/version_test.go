@@ -5,6 +5,9 @@ package semanticversion
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -33,7 +36,7 @@ func TestConstructor(t *testing.T) {
 		t.Errorf("Factory did not return nil but should have, it returned %v Error: %v \n", c, err)
 	} else if c, err := Version_Expression_Factory("1a.2.3"); c != nil {
 		t.Errorf("Factory did not return nil but should have, it returned %v Error: %v \n", c, err)
-	} else if c, err := Version_Expression_Factory("1.2.3.4"); c != nil {
+	} else if c, err := Version_Expression_Factory("1.2.3.4.5"); c != nil {
 		t.Errorf("Factory did not return nil but should have, it returned %v Error: %v \n", c, err)
 	} else if c, err := Version_Expression_Factory("1..2..3..4"); c != nil {
 		t.Errorf("Factory did not return nil but should have, it returned %v Error: %v \n", c, err)
@@ -57,7 +60,7 @@ func TestConstructor(t *testing.T) {
 		t.Errorf("Factory did not return nil but should have, it returned %v Error: %v \n", c, err)
 	} else if c, err := Version_Expression_Factory("[1.2,3.4a)"); c != nil {
 		t.Errorf("Factory did not return nil but should have, it returned %v Error: %v \n", c, err)
-	} else if c, err := Version_Expression_Factory("[1.2.3.4,3.4)"); c != nil {
+	} else if c, err := Version_Expression_Factory("[1.2.3.4.5,3.4)"); c != nil {
 		t.Errorf("Factory did not return nil but should have, it returned %v Error: %v \n", c, err)
 	} else if c, err := Version_Expression_Factory("(1.2,3..4]"); c != nil {
 		t.Errorf("Factory did not return nil but should have, it returned %v Error: %v \n", c, err)
@@ -320,14 +323,14 @@ func TestVersionExpressionFailure(t *testing.T) {
 
 // This test tests if the version string is a valide string.
 func TestIsVersionString(t *testing.T) {
-	v_good := []string{"1.0", "1.2", "1.234.567", "3.0.0", "234"}
+	v_good := []string{"1.0", "1.2", "1.234.567", "3.0.0", "234", "1.2.3.4", "1.2.3+git.abc", "1.2.3.4+build-7"}
 	for _, v := range v_good {
 		if !IsVersionString(v) {
 			t.Errorf("Version string %v is valid, however the IsVersionString function returned false.\n", v)
 		}
 	}
 
-	v_bad := []string{"1.0.0.1", "1.2.3a", "[1.2, 1.3]", "1.2.3-abc", "1.2.03"}
+	v_bad := []string{"1.0.0.0.1", "1.2.3+", "1.2.3+git..abc", "1.2.3+git_abc", "+git", "1.2.3a", "[1.2, 1.3]", "1.2.3-abc", "1.2.03"}
 	for _, v := range v_bad {
 		if IsVersionString(v) {
 			t.Errorf("Version string %v is invalid, however the IsVersionString function returned true.\n", v)
@@ -521,3 +524,246 @@ func TestCompareVersions(t *testing.T) {
 	c, err = CompareVersions(v1, v2)
 	assert.NotNil(t, err, fmt.Sprintf("Should get error, but did not. \n"))
 }
+
+// This series of tests verifies four part versions and build metadata in versions and ranges.
+func TestFourPartVersions(t *testing.T) {
+	tests := []struct {
+		v1 string
+		v2 string
+		c  int
+	}{
+		{"1.2.3.4", "1.2.3.5", -1},
+		{"1.2.3.10", "1.2.3.9", 1},
+		{"1.2.3.0", "1.2.3", 0},
+		{"1.2.3.1", "1.2.3", 1},
+		{"1.2.3+git.abc", "1.2.3", 0},
+		{"1.2.3+git.abc", "1.2.3+git.def", 0},
+		{"1.2.3.4+b1", "1.2.4", -1},
+		{"1.2.3.4", "INFINITY", -1},
+		{"99999999999999999999.0", "9.0", 1},
+	}
+	for _, test := range tests {
+		if c, err := CompareVersions(test.v1, test.v2); err != nil {
+			t.Errorf("unexpected error comparing %v and %v: %v", test.v1, test.v2, err)
+		} else if c != test.c {
+			t.Errorf("comparing %v and %v returned %v, expected %v", test.v1, test.v2, c, test.c)
+		}
+	}
+
+	assert.Equal(t, "1.2.3", normalize("1.2.3.0+git.abc"))
+	assert.Equal(t, "1.2.3.4", normalize("1.2.3.4"))
+	assert.Equal(t, "1.0.0", normalize("1+b"))
+
+	ve, err := Version_Expression_Factory("[1.2.3.4+git.abc,1.2.4.1)")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if ve.Get_expression() != "[1.2.3.4,1.2.4.1)" {
+		t.Errorf("wrong expression %v", ve.Get_expression())
+	}
+
+	ranges := map[string]bool{"1.2.3": false, "1.2.3.4": true, "1.2.3.4+other": true, "1.2.3.9": true, "1.2.4": true, "1.2.4.0": true, "1.2.4.1": false, "1.2.4.1+b": false}
+	for v, expected := range ranges {
+		if in, err := ve.Is_within_range(v); err != nil {
+			t.Errorf("unexpected error for %v: %v", v, err)
+		} else if in != expected {
+			t.Errorf("%v in range %v should be %v", v, ve.Get_expression(), expected)
+		}
+	}
+
+	if !IsVersionExpression("(1.2.3.4,2.0.0+b]") {
+		t.Errorf("(1.2.3.4,2.0.0+b] is a version expression")
+	}
+}
+
+// A reference comparison of versions of up to 4 small numbers, after dropping the build metadata.
+func referenceCompare(v1 string, v2 string) int {
+	parse := func(v string) [4]int {
+		n := [4]int{}
+		for i, s := range strings.Split(strings.SplitN(v, "+", 2)[0], ".") {
+			n[i], _ = strconv.Atoi(s)
+		}
+		return n
+	}
+	n1, n2 := parse(v1), parse(v2)
+	for i := range n1 {
+		if n1[i] < n2[i] {
+			return -1
+		} else if n1[i] > n2[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+func randomVersion(r *rand.Rand) string {
+	nums := make([]string, 1+r.Intn(4))
+	for i := range nums {
+		nums[i] = strconv.Itoa(r.Intn(12))
+	}
+	v := strings.Join(nums, ".")
+	if r.Intn(3) == 0 {
+		v += "+b" + strconv.Itoa(r.Intn(5))
+	}
+	return v
+}
+
+// Compares random versions against the reference comparison, and checks that the ordering is total and that the
+// range checks agree with it.
+func TestCompareVersionsProperties(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 5000; i++ {
+		v1, v2, v3 := randomVersion(r), randomVersion(r), randomVersion(r)
+
+		c12, err := CompareVersions(v1, v2)
+		if err != nil {
+			t.Fatalf("unexpected error comparing %v and %v: %v", v1, v2, err)
+		}
+		if expected := referenceCompare(v1, v2); c12 != expected {
+			t.Errorf("comparing %v and %v returned %v, expected %v", v1, v2, c12, expected)
+		}
+		if c21, _ := CompareVersions(v2, v1); c21 != -c12 {
+			t.Errorf("comparing %v and %v is not antisymmetric", v1, v2)
+		}
+		c23, _ := CompareVersions(v2, v3)
+		c13, _ := CompareVersions(v1, v3)
+		if c12 <= 0 && c23 <= 0 && c13 > 0 {
+			t.Errorf("comparing %v, %v and %v is not transitive", v1, v2, v3)
+		}
+
+		// a three part version compares the same with its four part form
+		if c, _ := CompareVersions(normalize(v1), v1); c != 0 {
+			t.Errorf("%v and its normalized form %v are not equal", v1, normalize(v1))
+		}
+
+		// v3 is in [lower,higher) when lower <= v3 < higher
+		lower, higher := v1, v2
+		if c12 > 0 {
+			lower, higher = v2, v1
+		}
+		ve, err := Version_Expression_Factory("[" + lower + "," + higher + ")")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		cl, _ := CompareVersions(v3, lower)
+		ch, _ := CompareVersions(v3, higher)
+		expected := cl >= 0 && ch < 0
+		if c12 == 0 {
+			// the start is inclusive for an empty range
+			expected = cl == 0
+		}
+		if in, err := ve.Is_within_range(v3); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if in != expected {
+			t.Errorf("%v in range %v returned %v, expected %v", v3, ve.Get_expression(), in, expected)
+		}
+	}
+}
+
+// All the version ranges with ends at 1, 2 and 3 or unbounded, with every combination of open and closed ends.
+func allTestRanges() []string {
+	ends := []string{"1", "2", "3"}
+	ranges := make([]string, 0)
+	for _, start := range ends {
+		for _, left := range []string{leftInc, leftEx} {
+			ranges = append(ranges, left+start+versionSeperator+INF+rightEx)
+			for _, end := range ends {
+				for _, right := range []string{rightInc, rightEx} {
+					ranges = append(ranges, left+start+versionSeperator+end+right)
+				}
+			}
+		}
+	}
+	return ranges
+}
+
+// The versions that are checked against the ranges, there is one between each pair of range ends.
+var testRangeVersions = []string{"0.5", "1", "1.5", "2", "2.5", "3", "3.5", "100"}
+
+// This test compares the intersection of every pair of ranges with the versions that are in both ranges.
+func TestIntersectVersionRanges(t *testing.T) {
+	ranges := allTestRanges()
+	for _, r1 := range ranges {
+		for _, r2 := range ranges {
+			intersection, ok, err := IntersectVersionRanges(r1, r2)
+			if err != nil {
+				t.Fatalf("unexpected error intersecting %v and %v: %v", r1, r2, err)
+			}
+
+			if reverse, rok, _ := IntersectVersionRanges(r2, r1); reverse != intersection || rok != ok {
+				t.Errorf("intersecting %v and %v is not commutative, %v and %v", r1, r2, intersection, reverse)
+			}
+
+			empty := true
+			for _, v := range testRangeVersions {
+				in1, _ := VersionInRange(v, r1)
+				in2, _ := VersionInRange(v, r2)
+				if in1 && in2 {
+					empty = false
+				}
+				if ok {
+					if in, _ := VersionInRange(v, intersection); in != (in1 && in2) {
+						t.Errorf("%v in the intersection %v of %v and %v is %v, in the ranges it is %v and %v", v, intersection, r1, r2, in, in1, in2)
+					}
+				}
+			}
+
+			if ok == empty {
+				t.Errorf("intersection %v of %v and %v returned %v, expected it to be empty %v", intersection, r1, r2, ok, empty)
+			} else if !ok && intersection != "" {
+				t.Errorf("empty intersection of %v and %v returned %v", r1, r2, intersection)
+			}
+		}
+	}
+}
+
+func TestIntersectVersionRangesCanonical(t *testing.T) {
+	tests := []struct {
+		r1           string
+		r2           string
+		intersection string
+		ok           bool
+	}{
+		{"1.0", "2", "[2.0.0,INFINITY)", true},
+		{"[1,3)", "(2,4]", "(2.0.0,3.0.0)", true},
+		{"[1,2]", "[2,3]", "[2.0.0,2.0.0]", true},
+		{"[1,2)", "[2,3]", "", false},
+		{"[1,2]", "(2,3]", "", false},
+		{"[3,4]", "[1,2]", "", false},
+		{"[1.2.3.4,2)", "1.2.3.5", "[1.2.3.5,2.0.0)", true},
+	}
+	for _, test := range tests {
+		if intersection, ok, err := IntersectVersionRanges(test.r1, test.r2); err != nil {
+			t.Errorf("unexpected error intersecting %v and %v: %v", test.r1, test.r2, err)
+		} else if intersection != test.intersection || ok != test.ok {
+			t.Errorf("intersecting %v and %v returned %v %v, expected %v %v", test.r1, test.r2, intersection, ok, test.intersection, test.ok)
+		}
+	}
+
+	if _, _, err := IntersectVersionRanges("[1,2)", "1.x"); err == nil {
+		t.Errorf("expected an error for an invalid range")
+	}
+
+	// the inputs are not changed
+	ve1, _ := Version_Expression_Factory("[1,3)")
+	ve2, _ := Version_Expression_Factory("(2,4]")
+	if _, err := ve1.Intersection(ve2); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if ve1.Get_expression() != "[1.0.0,3.0.0)" || ve2.Get_expression() != "(2.0.0,4.0.0]" {
+		t.Errorf("the ranges were changed to %v and %v", ve1, ve2)
+	}
+
+	// Is_within_range keeps its meaning for a range with the same start and end, VersionInRange does not.
+	for _, r := range []string{"[2,2)", "(2,2]"} {
+		ve, _ := Version_Expression_Factory(r)
+		if in, err := ve.Is_within_range("2"); err != nil || !in {
+			t.Errorf("Is_within_range should find 2 in %v, got %v, error %v", r, in, err)
+		} else if in, err := VersionInRange("2", r); err != nil || in {
+			t.Errorf("VersionInRange should not find 2 in %v, got %v, error %v", r, in, err)
+		}
+	}
+
+	if c, err := CanonicalVersionRange("1.2"); err != nil || c != "[1.2.0,INFINITY)" {
+		t.Errorf("wrong canonical range %v, error %v", c, err)
+	}
+}
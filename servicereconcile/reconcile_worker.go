@@ -0,0 +1,134 @@
+package servicereconcile
+
+import (
+	"context"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/api"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/worker"
+)
+
+// How often the worker checks whether the reconciliation was turned on, while Edge.ServiceReconcileIntervalS is 0.
+const DISABLED_CHECK_INTERVAL_S = 60
+
+// How much longer than Edge.ServiceReconcileIntervalS the interval gets at most while the exchange cannot be reached.
+const MAX_BACKOFF_FACTOR = 8
+
+// The worker that compares the services registered on the configured node with the ones its pattern requires, every
+// Edge.ServiceReconcileIntervalS of the config, see api.ReconcileServices. The interval is doubled each time the
+// exchange cannot be reached, up to MAX_BACKOFF_FACTOR times the configured one, and set back once it can.
+type ServiceReconcileWorker struct {
+	worker.BaseWorker // embedded field
+	db                *bolt.DB
+}
+
+func NewServiceReconcileWorker(name string, cfg *config.HorizonConfig, db *bolt.DB) *ServiceReconcileWorker {
+
+	var ec *worker.BaseExchangeContext
+	if dev, _ := persistence.FindExchangeDevice(db); dev != nil {
+		ec = worker.NewExchangeContext(fmt.Sprintf("%v/%v", dev.Org, dev.Id), dev.Token, cfg.Edge.ExchangeURL, cfg.GetCSSURL(), cfg.Collaborators.HTTPClientFactory)
+	}
+
+	w := &ServiceReconcileWorker{
+		BaseWorker: worker.NewBaseWorker(name, cfg, ec),
+		db:         db,
+	}
+
+	glog.Info(srlog(fmt.Sprintf("Starting Service Reconcile worker")))
+	w.Start(w, nextInterval(cfg.LiveEdge().ServiceReconcileIntervalS, 0, false))
+	return w
+}
+
+func (w *ServiceReconcileWorker) Messages() chan events.Message {
+	return w.BaseWorker.Manager.Messages
+}
+
+// Handle events that are propogated to this worker from the internal event bus.
+func (w *ServiceReconcileWorker) NewEvent(incoming events.Message) {
+
+	switch incoming.(type) {
+	case *events.EdgeRegisteredExchangeMessage:
+		msg, _ := incoming.(*events.EdgeRegisteredExchangeMessage)
+		w.EC = worker.NewExchangeContext(fmt.Sprintf("%v/%v", msg.Org(), msg.DeviceId()), msg.Token(), w.Config.Edge.ExchangeURL, w.Config.GetCSSURL(), w.Config.Collaborators.HTTPClientFactory)
+
+	case *events.NodeTokenMessage:
+		msg, _ := incoming.(*events.NodeTokenMessage)
+		w.EC = worker.NewExchangeContext(fmt.Sprintf("%v/%v", msg.Org(), msg.DeviceId()), msg.Token(), w.Config.Edge.ExchangeURL, w.Config.GetCSSURL(), w.Config.Collaborators.HTTPClientFactory)
+
+	case *events.NodeShutdownCompleteMessage:
+		msg, _ := incoming.(*events.NodeShutdownCompleteMessage)
+		switch msg.Event().Id {
+		case events.UNCONFIGURE_COMPLETE:
+			w.Commands <- worker.NewTerminateCommand("shutdown")
+		}
+
+	default: //nothing
+
+	}
+
+	return
+}
+
+// There are no commands, the comparison runs from the NoWorkHandler.
+func (w *ServiceReconcileWorker) CommandHandler(command worker.Command) bool {
+	return false
+}
+
+// Compare the services of the node with the ones of its pattern, and publish the policies of the services it created.
+func (w *ServiceReconcileWorker) NoWorkHandler() {
+	interval := w.Config.LiveEdge().ServiceReconcileIntervalS
+	if interval == 0 || w.GetExchangeToken() == "" {
+		w.SetNoWorkInterval(nextInterval(interval, 0, false))
+		return
+	}
+
+	ctx := api.WithRequestID(context.Background(), "service-reconcile")
+	_, msgs, err := api.ReconcileServices(ctx,
+		exchange.GetHTTPExchangePatternHandler(w),
+		exchange.GetHTTPServiceDefResolverHandler(w),
+		exchange.GetHTTPServiceHandler(w),
+		exchange.GetHTTPDeviceHandler(w),
+		exchange.GetHTTPPatchDeviceHandler(w),
+		w.db, w.Config)
+
+	unreachable := err != nil && api.ErrorReason(err) == api.ERR_EXCHANGE_UNREACHABLE
+	if unreachable {
+		glog.V(3).Infof(srlog(fmt.Sprintf("the exchange cannot be reached, the services will be compared later, %v", err)))
+	} else if err != nil {
+		glog.Errorf(srlog(fmt.Sprintf("unable to compare the services of the node with the ones of its pattern, error %v", err)))
+	}
+	w.SetNoWorkInterval(nextInterval(interval, w.GetNoWorkInterval(), unreachable))
+
+	for _, msg := range msgs {
+		w.Messages() <- msg
+	}
+}
+
+// Returns the number of seconds until the next comparison, given the configured interval and the current one. The
+// current one is doubled while the exchange cannot be reached, up to MAX_BACKOFF_FACTOR times the configured one.
+func nextInterval(configured uint64, current int, unreachable bool) int {
+	if configured == 0 {
+		return DISABLED_CHECK_INTERVAL_S
+	} else if !unreachable {
+		return int(configured)
+	}
+
+	max := int(configured) * MAX_BACKOFF_FACTOR
+	if next := current * 2; next < int(configured)*2 {
+		return int(configured) * 2
+	} else if next > max {
+		return max
+	} else {
+		return next
+	}
+}
+
+// Utility logging function
+var srlog = func(v interface{}) string {
+	return fmt.Sprintf("Service Reconcile Worker: %v", v)
+}
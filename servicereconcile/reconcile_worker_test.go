@@ -0,0 +1,32 @@
+// +build unit
+
+package servicereconcile
+
+import (
+	"testing"
+)
+
+func Test_nextInterval(t *testing.T) {
+
+	for _, tc := range []struct {
+		configured  uint64
+		current     int
+		unreachable bool
+		expected    int
+	}{
+		{0, 600, false, DISABLED_CHECK_INTERVAL_S},
+		{0, 600, true, DISABLED_CHECK_INTERVAL_S},
+		{600, 600, false, 600},
+		{600, 4800, false, 600},
+		{600, 600, true, 1200},
+		{600, 1200, true, 2400},
+		{600, 2400, true, 4800},
+		{600, 4800, true, 4800},
+		{600, DISABLED_CHECK_INTERVAL_S, true, 1200},
+		{300, 4800, true, 2400},
+	} {
+		if got := nextInterval(tc.configured, tc.current, tc.unreachable); got != tc.expected {
+			t.Errorf("nextInterval(%v, %v, %v) should be %v, got %v", tc.configured, tc.current, tc.unreachable, tc.expected, got)
+		}
+	}
+}
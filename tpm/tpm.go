@@ -0,0 +1,255 @@
+// Package tpm binds the key material of the node to its TPM 2.0. The key that encrypts the secrets in the local
+// database is sealed by the TPM, so that a copy of the database cannot be read on another machine, and the TPM
+// signs attestation quotes of its PCRs for verifiers of the node.
+//
+// The keys are primary keys created from the owner hierarchy with fixed templates. The TPM derives the same key from
+// the same template until it is cleared, so nothing but the sealed data has to be stored.
+package tpm
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/persistence"
+	"io"
+	"sync"
+)
+
+// The states of the TPM integration.
+const STATE_DISABLED = "disabled" // the node does not use a TPM
+const STATE_ACTIVE = "active"     // the credential key is sealed by the TPM
+const STATE_RECOVERY = "recovery" // the credential key is sealed but the TPM cannot unseal it, only the API runs
+
+// The largest nonce that a quote can include.
+const MAX_NONCE_SIZE = 64
+
+// The key that the data is sealed under.
+var srkTemplate = tpm2.Public{
+	Type:       tpm2.AlgRSA,
+	NameAlg:    tpm2.AlgSHA256,
+	Attributes: tpm2.FlagFixedTPM | tpm2.FlagFixedParent | tpm2.FlagSensitiveDataOrigin | tpm2.FlagUserWithAuth | tpm2.FlagRestricted | tpm2.FlagDecrypt | tpm2.FlagNoDA,
+	RSAParameters: &tpm2.RSAParams{
+		Symmetric: &tpm2.SymScheme{Alg: tpm2.AlgAES, KeyBits: 128, Mode: tpm2.AlgCFB},
+		KeyBits:   2048,
+	},
+}
+
+// The key that signs the quotes, it can only sign data that the TPM produced.
+var akTemplate = tpm2.Public{
+	Type:       tpm2.AlgRSA,
+	NameAlg:    tpm2.AlgSHA256,
+	Attributes: tpm2.FlagFixedTPM | tpm2.FlagFixedParent | tpm2.FlagSensitiveDataOrigin | tpm2.FlagUserWithAuth | tpm2.FlagRestricted | tpm2.FlagSign | tpm2.FlagNoDA,
+	RSAParameters: &tpm2.RSAParams{
+		Sign:    &tpm2.SigScheme{Alg: tpm2.AlgRSASSA, Hash: tpm2.AlgSHA256},
+		KeyBits: 2048,
+	},
+}
+
+// A TPM 2.0 device. The TPM runs one command at a time.
+type TPM struct {
+	device string
+	rw     io.ReadWriteCloser
+	lock   sync.Mutex
+}
+
+// Open the TPM device, e.g. /dev/tpmrm0.
+func Open(device string) (*TPM, error) {
+	rw, err := tpm2.OpenTPM(device)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open TPM %v, %v", device, err)
+	}
+	return &TPM{device: device, rw: rw}, nil
+}
+
+func (t *TPM) Close() error {
+	return t.rw.Close()
+}
+
+// Returns random bytes from the TPM's generator.
+func (t *TPM) GenerateKey(size int) ([]byte, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	key := make([]byte, 0, size)
+	for len(key) < size {
+		// the TPM can return fewer bytes than requested
+		b, err := tpm2.GetRandom(t.rw, uint16(size-len(key)))
+		if err != nil {
+			return nil, fmt.Errorf("unable to get random bytes from the TPM, %v", err)
+		} else if len(b) == 0 {
+			return nil, errors.New("the TPM returned no random bytes")
+		}
+		key = append(key, b...)
+	}
+	return key, nil
+}
+
+// The sealed data, only the TPM that sealed it can load and unseal it.
+type sealedData struct {
+	Public  []byte `json:"public"`
+	Private []byte `json:"private"`
+}
+
+// Seal the data under the storage root key of the TPM.
+func (t *TPM) Seal(data []byte) ([]byte, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	srk, _, err := tpm2.CreatePrimary(t.rw, tpm2.HandleOwner, tpm2.PCRSelection{}, "", "", srkTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the storage root key, %v", err)
+	}
+	defer t.flush(srk)
+
+	private, public, err := tpm2.Seal(t.rw, srk, "", "", nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to seal, %v", err)
+	}
+	return json.Marshal(sealedData{Public: public, Private: private})
+}
+
+// Unseal data sealed by Seal.
+func (t *TPM) Unseal(sealed []byte) ([]byte, error) {
+	var sd sealedData
+	if err := json.Unmarshal(sealed, &sd); err != nil {
+		return nil, fmt.Errorf("unable to read the sealed data, %v", err)
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	srk, _, err := tpm2.CreatePrimary(t.rw, tpm2.HandleOwner, tpm2.PCRSelection{}, "", "", srkTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the storage root key, %v", err)
+	}
+	defer t.flush(srk)
+
+	item, _, err := tpm2.Load(t.rw, srk, "", sd.Public, sd.Private)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the sealed data, it was sealed by another TPM or the TPM was cleared, %v", err)
+	}
+	defer t.flush(item)
+
+	data, err := tpm2.Unseal(t.rw, item, "")
+	if err != nil {
+		return nil, fmt.Errorf("unable to unseal, %v", err)
+	}
+	return data, nil
+}
+
+// A quote of PCR values signed by the attestation key of the TPM. The attestation is the TPMS_ATTEST structure that
+// was signed, it includes the nonce and the digest of the PCR values.
+type Quote struct {
+	Attestation    []byte         `json:"attestation"`
+	Signature      []byte         `json:"signature"`       // RSASSA-PKCS1-v1_5 with SHA-256
+	PCRs           map[int][]byte `json:"pcrs"`            // the quoted PCR values of the SHA-256 bank
+	AttestationKey string         `json:"attestation_key"` // PEM encoded public key
+}
+
+// Returns a quote of the PCRs that includes the nonce, which the verifier chooses to make sure the quote is new.
+func (t *TPM) Quote(nonce []byte, pcrs []int) (*Quote, error) {
+	if len(nonce) == 0 || len(nonce) > MAX_NONCE_SIZE {
+		return nil, fmt.Errorf("the nonce must be 1 to %v bytes", MAX_NONCE_SIZE)
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	ak, pub, err := tpm2.CreatePrimary(t.rw, tpm2.HandleOwner, tpm2.PCRSelection{}, "", "", akTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the attestation key, %v", err)
+	}
+	defer t.flush(ak)
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal the attestation key, %v", err)
+	}
+
+	sel := tpm2.PCRSelection{Hash: tpm2.AlgSHA256, PCRs: pcrs}
+	attestation, sig, err := tpm2.Quote(t.rw, ak, "", "", nonce, sel, tpm2.AlgNull)
+	if err != nil {
+		return nil, fmt.Errorf("unable to quote PCRs %v, %v", pcrs, err)
+	} else if sig.RSA == nil {
+		return nil, fmt.Errorf("unexpected quote signature algorithm %v", sig.Alg)
+	}
+
+	values, err := tpm2.ReadPCRs(t.rw, sel)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read PCRs %v, %v", pcrs, err)
+	}
+
+	return &Quote{
+		Attestation:    attestation,
+		Signature:      sig.RSA.Signature,
+		PCRs:           values,
+		AttestationKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	}, nil
+}
+
+func (t *TPM) flush(handle tpmutil.Handle) {
+	if err := tpm2.FlushContext(t.rw, handle); err != nil {
+		glog.Warningf("Unable to flush TPM handle %v, error %v", handle, err)
+	}
+}
+
+// The state of the TPM integration, reported by the node API.
+type Status struct {
+	State  string `json:"state"`
+	Device string `json:"device,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+var status = &Status{State: STATE_DISABLED}
+var device *TPM
+
+// Returns the state of the TPM integration.
+func GetStatus() Status {
+	return *status
+}
+
+// Returns the TPM that the node uses, nil when it does not use one.
+func GetTPM() *TPM {
+	return device
+}
+
+// Seal the credential key of the database with the configured TPM. It is called once when anax starts. A node that
+// has no TPM configured and no sealed key works as before. When the key is sealed but cannot be unsealed, because the
+// TPM cannot be opened, was cleared or was replaced, the state is recovery and anax only runs the API, instead of
+// failing and being restarted over and over. Anax leaves recovery mode when it is restarted with a working TPM.
+func Initialize(cfg *config.TPMConfig, db *bolt.DB) Status {
+	sealed := persistence.CredentialKeySealed(db)
+
+	if cfg.Device == "" {
+		if sealed {
+			status = &Status{State: STATE_RECOVERY, Error: "the credential key is sealed by a TPM, but Edge.TPM.Device is not set"}
+		}
+	} else if t, err := Open(cfg.Device); err != nil && !sealed {
+		// nothing is sealed yet, the node works as one without a TPM until the TPM can be opened
+		glog.Warningf("Unable to use the TPM, the credential key is not sealed: %v", err)
+	} else if err != nil {
+		status = &Status{State: STATE_RECOVERY, Device: cfg.Device, Error: err.Error()}
+	} else {
+		persistence.SetCredentialKeySealer(t)
+		device = t
+		if err := persistence.CheckCredentialKey(db); err != nil {
+			status = &Status{State: STATE_RECOVERY, Device: cfg.Device, Error: err.Error()}
+		} else {
+			status = &Status{State: STATE_ACTIVE, Device: cfg.Device}
+		}
+	}
+
+	if status.State == STATE_RECOVERY {
+		glog.Errorf("The TPM cannot unseal the credential key, anax is running in recovery mode: %v", status.Error)
+	} else {
+		glog.V(3).Infof("TPM state %v", status.State)
+	}
+	return *status
+}
@@ -0,0 +1,329 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The prefix of a service variable value that refers to a secret in Vault. The reference is the path of the secret
+// and the key of the value in the secret, e.g. vault:secret/data/edge/siteA#apiKey.
+const REFERENCE_PREFIX = "vault:"
+
+// A reference to a value of a secret in Vault.
+type Reference struct {
+	Path string
+	Key  string
+}
+
+func (r Reference) String() string {
+	return fmt.Sprintf("%v%v#%v", REFERENCE_PREFIX, r.Path, r.Key)
+}
+
+// Returns true if the value is meant to be a Vault reference, whether or not it is a valid one.
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, REFERENCE_PREFIX)
+}
+
+func ParseReference(value string) (*Reference, error) {
+	if !IsReference(value) {
+		return nil, fmt.Errorf("%v is not a Vault reference, it must start with %v", value, REFERENCE_PREFIX)
+	}
+
+	ref := strings.TrimPrefix(value, REFERENCE_PREFIX)
+	i := strings.LastIndex(ref, "#")
+	if i == -1 {
+		return nil, fmt.Errorf("%v has no key, it must be %v<path>#<key>", value, REFERENCE_PREFIX)
+	}
+
+	path, key := strings.Trim(ref[:i], "/"), ref[i+1:]
+	if path == "" || key == "" {
+		return nil, fmt.Errorf("%v must have a path and a key, e.g. %vsecret/data/edge#apiKey", value, REFERENCE_PREFIX)
+	}
+	return &Reference{Path: path, Key: key}, nil
+}
+
+// Returns the names of the env vars whose values are Vault references, sorted.
+func ReferencingEnvvars(env map[string]string) []string {
+	names := make([]string, 0)
+	for name, value := range env {
+		if IsReference(value) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// A client of the Vault HTTP API. It logs in with the configured auth method and keeps the token, renewing it when
+// half of its TTL has passed and logging in again when it can no longer be renewed. It is safe for concurrent use.
+type Client struct {
+	config     config.VaultConfig
+	address    string
+	httpClient *http.Client
+	now        func() time.Time
+
+	lock      sync.Mutex
+	token     string
+	ttl       time.Duration // 0 means the token does not expire
+	renewable bool
+	obtained  time.Time // when the token was obtained or last renewed
+}
+
+func NewClient(cfg config.VaultConfig) (*Client, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("the Vault address is not configured")
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if cfg.CACertFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the Vault CA certificate file %v, %v", cfg.CACertFile, err)
+		} else if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("the Vault CA certificate file %v has no PEM certificate", cfg.CACertFile)
+		}
+	}
+
+	return &Client{
+		config:  cfg,
+		address: strings.TrimRight(cfg.Address, "/"),
+		httpClient: &http.Client{
+			Timeout:   cfg.GetTimeout(),
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		now: time.Now,
+	}, nil
+}
+
+// The parts of a Vault response that the client uses.
+type vaultResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Auth   *vaultAuth             `json:"auth"`
+	Errors []string               `json:"errors"`
+}
+
+type vaultAuth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int64  `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+// Returns the value that the reference refers to. A value that is not a string is returned as JSON. A token that is
+// rejected is replaced once by logging in again, e.g. when it was revoked.
+func (c *Client) Read(ref Reference) (string, error) {
+	token, err := c.getToken()
+	if err != nil {
+		return "", err
+	}
+
+	value, status, err := c.read(token, ref)
+	if status == http.StatusForbidden {
+		glog.Warningf("Vault rejected the token while reading %v, logging in again", ref)
+		c.discardToken(token)
+		if token, err = c.getToken(); err != nil {
+			return "", err
+		}
+		value, _, err = c.read(token, ref)
+	}
+	return value, err
+}
+
+// Returns a copy of the env vars in which the Vault references are replaced by the values they refer to. The errors
+// name the env var and the reference, never a secret.
+func (c *Client) ResolveEnv(env map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(env))
+	for name, value := range env {
+		resolved[name] = value
+	}
+
+	for _, name := range ReferencingEnvvars(env) {
+		ref, err := ParseReference(env[name])
+		if err != nil {
+			return nil, fmt.Errorf("env var %v: %v", name, err)
+		}
+		secret, err := c.Read(*ref)
+		if err != nil {
+			return nil, fmt.Errorf("env var %v: unable to read %v from Vault, %v", name, ref, err)
+		}
+		resolved[name] = secret
+	}
+	return resolved, nil
+}
+
+func (c *Client) read(token string, ref Reference) (string, int, error) {
+	resp, status, err := c.invoke(http.MethodGet, "/v1/"+ref.Path, token, nil)
+	if err != nil {
+		return "", status, err
+	}
+
+	// a KV version 2 secret has its values in data.data, a KV version 1 secret in data.
+	values := resp.Data
+	if inner, ok := values["data"].(map[string]interface{}); ok {
+		if _, isV2 := values["metadata"]; isV2 {
+			values = inner
+		}
+	}
+
+	value, ok := values[ref.Key]
+	if !ok || value == nil {
+		return "", status, fmt.Errorf("the secret %v has no key %v", ref.Path, ref.Key)
+	} else if s, isString := value.(string); isString {
+		return s, status, nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", status, fmt.Errorf("unable to encode the value of key %v of the secret %v, %v", ref.Key, ref.Path, err)
+	}
+	return string(b), status, nil
+}
+
+// Returns a usable token. The token is renewed when half of its TTL has passed, and a new one is obtained when it
+// expired or could not be renewed.
+func (c *Client) getToken() (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.token != "" && c.ttl != 0 {
+		if age := c.now().Sub(c.obtained); age >= c.ttl {
+			glog.V(3).Infof("Vault token expired, logging in again")
+			c.token = ""
+		} else if c.renewable && age >= c.ttl/2 {
+			if err := c.renew(); err != nil {
+				glog.Warningf("Unable to renew the Vault token, logging in again. %v", err)
+				c.token = ""
+			}
+		}
+	}
+
+	if c.token == "" {
+		if err := c.login(); err != nil {
+			return "", err
+		}
+	}
+	return c.token, nil
+}
+
+// Forget the token if it is still the current one, so that the next request logs in again.
+func (c *Client) discardToken(token string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.token == token {
+		c.token = ""
+	}
+}
+
+// Obtain a token with the configured auth method. The caller holds the lock.
+func (c *Client) login() error {
+	switch c.config.GetAuthMethod() {
+	case config.VAULT_AUTH_TOKEN:
+		// the token is configured, look it up to learn its TTL
+		resp, _, err := c.invoke(http.MethodGet, "/v1/auth/token/lookup-self", c.config.Token, nil)
+		if err != nil {
+			return fmt.Errorf("unable to look up the configured Vault token, %v", err)
+		}
+		ttl, _ := resp.Data["ttl"].(float64)
+		renewable, _ := resp.Data["renewable"].(bool)
+		c.setToken(c.config.Token, int64(ttl), renewable)
+
+	case config.VAULT_AUTH_APPROLE:
+		body := map[string]string{"role_id": c.config.RoleId, "secret_id": c.config.SecretId}
+		resp, _, err := c.invoke(http.MethodPost, fmt.Sprintf("/v1/auth/%v/login", c.config.GetAuthPath()), "", body)
+		if err != nil {
+			return fmt.Errorf("unable to log in to Vault with role id %v, %v", c.config.RoleId, err)
+		} else if resp.Auth == nil || resp.Auth.ClientToken == "" {
+			return fmt.Errorf("unable to log in to Vault with role id %v, the response has no token", c.config.RoleId)
+		}
+		c.setToken(resp.Auth.ClientToken, resp.Auth.LeaseDuration, resp.Auth.Renewable)
+
+	default:
+		return fmt.Errorf("the Vault auth method %v is not supported", c.config.AuthMethod)
+	}
+
+	glog.V(3).Infof("Logged in to Vault with the %v auth method, the token TTL is %v", c.config.GetAuthMethod(), c.ttl)
+	return nil
+}
+
+// Renew the current token. The caller holds the lock.
+func (c *Client) renew() error {
+	resp, _, err := c.invoke(http.MethodPost, "/v1/auth/token/renew-self", c.token, map[string]string{})
+	if err != nil {
+		return err
+	} else if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return errors.New("the renewal response has no token")
+	}
+	c.setToken(resp.Auth.ClientToken, resp.Auth.LeaseDuration, resp.Auth.Renewable)
+	glog.V(3).Infof("Renewed the Vault token, the token TTL is %v", c.ttl)
+	return nil
+}
+
+func (c *Client) setToken(token string, ttlS int64, renewable bool) {
+	c.token = token
+	c.ttl = time.Duration(ttlS) * time.Second
+	c.renewable = renewable
+	c.obtained = c.now()
+}
+
+// Invoke the Vault API. Returns the decoded response and the HTTP status, an error if the status is not 2xx.
+func (c *Client) invoke(method string, path string, token string, body interface{}) (*vaultResponse, int, error) {
+	var reqBody *bytes.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, 0, err
+		}
+		reqBody = bytes.NewReader(b)
+	} else {
+		reqBody = bytes.NewReader([]byte{})
+	}
+
+	req, err := http.NewRequest(method, c.address+path, reqBody)
+	if err != nil {
+		return nil, 0, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if c.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.config.Namespace)
+	}
+
+	httpResp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer httpResp.Body.Close()
+
+	content, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, httpResp.StatusCode, err
+	}
+
+	resp := new(vaultResponse)
+	if len(content) != 0 {
+		if err := json.Unmarshal(content, resp); err != nil {
+			return nil, httpResp.StatusCode, fmt.Errorf("unable to decode the Vault response to %v %v, HTTP status %v, %v", method, path, httpResp.StatusCode, err)
+		}
+	}
+
+	if httpResp.StatusCode == http.StatusNotFound {
+		return nil, httpResp.StatusCode, fmt.Errorf("%v not found in Vault", strings.TrimPrefix(path, "/v1/"))
+	} else if httpResp.StatusCode < 200 || httpResp.StatusCode > 299 {
+		return nil, httpResp.StatusCode, fmt.Errorf("Vault returned HTTP status %v for %v %v: %v", httpResp.StatusCode, method, path, strings.Join(resp.Errors, ", "))
+	}
+	return resp, httpResp.StatusCode, nil
+}
@@ -0,0 +1,203 @@
+// +build unit
+
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/anax/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ParseReference(t *testing.T) {
+
+	tests := []struct {
+		value string
+		path  string
+		key   string
+		ok    bool
+	}{
+		{"vault:secret/data/edge/siteA#apiKey", "secret/data/edge/siteA", "apiKey", true},
+		{"vault:/kv/edge/#password", "kv/edge", "password", true},
+		{"vault:secret/data/a#b#c", "secret/data/a#b", "c", true},
+		{"vault:secret/data/edge", "", "", false},
+		{"vault:#apiKey", "", "", false},
+		{"vault:secret/data/edge#", "", "", false},
+		{"secret/data/edge#apiKey", "", "", false},
+	}
+
+	for _, test := range tests {
+		ref, err := ParseReference(test.value)
+		if test.ok && (err != nil || ref.Path != test.path || ref.Key != test.key) {
+			t.Errorf("%v: expected path %v and key %v, got %v and error %v", test.value, test.path, test.key, ref, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%v: expected an error, got %v", test.value, ref)
+		}
+	}
+}
+
+// A fake Vault server that hands out numbered tokens with the approle auth method and counts the calls.
+type fakeVault struct {
+	secrets  map[string]interface{}
+	tokens   map[string]bool
+	issued   int
+	logins   int
+	renewals int
+}
+
+func (f *fakeVault) issue() string {
+	f.issued++
+	token := fmt.Sprintf("token%v", f.issued)
+	f.tokens[token] = true
+	return token
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reply := func(status int, body interface{}) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+
+	switch r.URL.Path {
+	case "/v1/auth/approle/login":
+		f.logins++
+		reply(http.StatusOK, map[string]interface{}{"auth": map[string]interface{}{"client_token": f.issue(), "lease_duration": 60, "renewable": true}})
+		return
+	}
+
+	if !f.tokens[r.Header.Get("X-Vault-Token")] {
+		reply(http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v1/auth/token/renew-self":
+		f.renewals++
+		reply(http.StatusOK, map[string]interface{}{"auth": map[string]interface{}{"client_token": r.Header.Get("X-Vault-Token"), "lease_duration": 60, "renewable": true}})
+	default:
+		if secret, ok := f.secrets[strings.TrimPrefix(r.URL.Path, "/v1/")]; ok {
+			reply(http.StatusOK, map[string]interface{}{"data": secret})
+		} else {
+			reply(http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+		}
+	}
+}
+
+func newFakeVault() (*fakeVault, *httptest.Server, *Client, *time.Time) {
+	fake := &fakeVault{
+		secrets: map[string]interface{}{
+			"secret/data/edge/siteA": map[string]interface{}{
+				"data":     map[string]interface{}{"apiKey": "s3cr3t", "limits": map[string]interface{}{"max": 5}},
+				"metadata": map[string]interface{}{"version": 2},
+			},
+			"kv/edge": map[string]interface{}{"password": "pa55"},
+		},
+		tokens: map[string]bool{},
+	}
+	server := httptest.NewServer(fake)
+
+	client, _ := NewClient(config.VaultConfig{Address: server.URL, AuthMethod: config.VAULT_AUTH_APPROLE, RoleId: "edge", SecretId: "id"})
+	now := time.Now()
+	client.now = func() time.Time { return now }
+	return fake, server, client, &now
+}
+
+func Test_ResolveEnv(t *testing.T) {
+
+	fake, server, client, _ := newFakeVault()
+	defer server.Close()
+
+	env := map[string]string{
+		"API_KEY":  "vault:secret/data/edge/siteA#apiKey",
+		"LIMITS":   "vault:secret/data/edge/siteA#limits",
+		"PASSWORD": "vault:kv/edge#password",
+		"PLAIN":    "value",
+	}
+
+	resolved, err := client.ResolveEnv(env)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	expected := map[string]string{"API_KEY": "s3cr3t", "LIMITS": `{"max":5}`, "PASSWORD": "pa55", "PLAIN": "value"}
+	for name, value := range expected {
+		if resolved[name] != value {
+			t.Errorf("%v: expected %v, got %v", name, value, resolved[name])
+		}
+	}
+	if env["API_KEY"] != "vault:secret/data/edge/siteA#apiKey" {
+		t.Errorf("the input env was modified: %v", env)
+	}
+	if fake.logins != 1 {
+		t.Errorf("expected the token to be reused, got %v logins", fake.logins)
+	}
+}
+
+func Test_ResolveEnv_errors(t *testing.T) {
+
+	_, server, client, _ := newFakeVault()
+	defer server.Close()
+
+	for _, ref := range []string{"vault:secret/data/edge/siteA#missing", "vault:secret/data/edge/siteB#apiKey", "vault:secret/data/edge/siteA"} {
+		_, err := client.ResolveEnv(map[string]string{"API_KEY": ref, "OTHER": "vault:secret/data/edge/siteA#apiKey"})
+		if err == nil {
+			t.Errorf("%v: expected an error", ref)
+		} else if !strings.Contains(err.Error(), "API_KEY") {
+			t.Errorf("%v: expected the error to name the env var, got %v", ref, err)
+		} else if strings.Contains(err.Error(), "s3cr3t") {
+			t.Errorf("%v: the error shows the secret: %v", ref, err)
+		}
+	}
+}
+
+func Test_getToken_renewal(t *testing.T) {
+
+	fake, server, client, now := newFakeVault()
+	defer server.Close()
+	ref := Reference{Path: "kv/edge", Key: "password"}
+
+	if _, err := client.Read(ref); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// before half of the TTL the token is used as it is
+	*now = now.Add(20 * time.Second)
+	client.Read(ref)
+	if fake.logins != 1 || fake.renewals != 0 {
+		t.Errorf("expected 1 login and no renewal, got %v and %v", fake.logins, fake.renewals)
+	}
+
+	// after half of the TTL it is renewed
+	*now = now.Add(20 * time.Second)
+	client.Read(ref)
+	if fake.logins != 1 || fake.renewals != 1 {
+		t.Errorf("expected 1 login and 1 renewal, got %v and %v", fake.logins, fake.renewals)
+	}
+
+	// once it has expired there is a new login
+	*now = now.Add(2 * time.Minute)
+	client.Read(ref)
+	if fake.logins != 2 || fake.renewals != 1 {
+		t.Errorf("expected 2 logins and 1 renewal, got %v and %v", fake.logins, fake.renewals)
+	}
+}
+
+func Test_Read_revoked_token(t *testing.T) {
+
+	fake, server, client, _ := newFakeVault()
+	defer server.Close()
+	ref := Reference{Path: "kv/edge", Key: "password"}
+
+	client.Read(ref)
+	fake.tokens = map[string]bool{}
+
+	if value, err := client.Read(ref); err != nil || value != "pa55" {
+		t.Errorf("expected a new login and the value, got %v and error %v", value, err)
+	} else if fake.logins != 2 {
+		t.Errorf("expected 2 logins, got %v", fake.logins)
+	}
+}